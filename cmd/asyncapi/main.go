@@ -0,0 +1,33 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"payment-gateway/internal/consts"
+	"payment-gateway/internal/kafka"
+)
+
+// Command asyncapi writes the AsyncAPI specification for emitted Kafka events.
+// It is used by `make asyncapi` to regenerate docs/asyncapi.json.
+func main() {
+	output := flag.String("o", "", "Output file (defaults to stdout)")
+	broker := flag.String("broker", "localhost:9092", "Kafka broker URL to list in the servers section")
+	flag.Parse()
+
+	spec, err := kafka.AsyncAPIJSON(*broker, consts.APIVersion)
+	if err != nil {
+		log.Fatalf("Failed to generate AsyncAPI specification: %v", err)
+	}
+	spec = append(spec, '\n')
+
+	if *output == "" {
+		fmt.Print(string(spec))
+		return
+	}
+
+	if err := os.WriteFile(*output, spec, 0o644); err != nil {
+		log.Fatalf("Failed to write %s: %v", *output, err)
+	}
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"payment-gateway/internal/kafka"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// Command dlqreplay republishes messages consumers gave up on from the dead-letter topic to the
+// topics they were consumed from, or lists them with -dry-run. It connects to the brokers
+// configured in the environment like the service, and stops once no message arrived for -idle.
+func main() {
+	group := flag.String("group", "payment-gateway-dlq-replay", "Consumer group tracking which dead letters were replayed")
+	limit := flag.Int("limit", 0, "Dead letters to replay before stopping, 0 for all")
+	idle := flag.Duration("idle", 10*time.Second, "How long to wait for another dead letter before stopping")
+	dryRun := flag.Bool("dry-run", false, "List dead letters without replaying them")
+	flag.Parse()
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	config, err := kafka.ConfigFromEnv()
+	if err != nil {
+		log.Fatalf("Invalid Kafka configuration: %v", err)
+	}
+	producer, err := kafka.NewProducer(config)
+	if err != nil {
+		log.Fatalf("Failed to create Kafka producer: %v", err)
+	}
+	defer producer.Close()
+
+	options := kafka.ReplayOptions{Limit: *limit, Idle: *idle, DryRun: *dryRun}
+	n, err := kafka.ReplayDeadLetters(ctx, producer, *group, options, func(d kafka.DeadLetter) {
+		fmt.Printf("%s offset %d: transaction %s from %s/%d offset %d, failed %s in group %s by %s after %d attempts: %s\n",
+			kafka.TopicTransactionsDLQ, d.DLQOffset, d.TransactionID, d.Topic, d.Partition, d.Offset,
+			d.FailedAt.Format(time.RFC3339), d.Group, strings.Join(d.Handlers, ", "), d.Attempts, d.Error)
+	})
+	if err != nil {
+		log.Fatalf("Replay stopped after %d dead letters: %v", n, err)
+	}
+
+	if *dryRun {
+		log.Printf("Listed %d dead letters", n)
+		return
+	}
+	log.Printf("Replayed %d dead letters", n)
+}
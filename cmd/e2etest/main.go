@@ -0,0 +1,269 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"payment-gateway/db"
+	"payment-gateway/internal/api"
+	"payment-gateway/internal/consts"
+	"payment-gateway/internal/events"
+	"payment-gateway/internal/gateway"
+	"payment-gateway/internal/geo"
+	"payment-gateway/internal/kafka"
+	"payment-gateway/internal/models"
+	"payment-gateway/internal/services"
+	"payment-gateway/internal/utils"
+	"payment-gateway/internal/warehouse"
+	"regexp"
+	"sync"
+	"time"
+)
+
+// Command e2etest boots the API on the mock database with mock gateways that always accept,
+// then runs scripted scenarios against it over HTTP. It exits non-zero when a scenario fails, so
+// it doubles as a smoke test in CI:
+//
+//	go run ./cmd/e2etest
+//	go run ./cmd/e2etest -run refund -v
+func main() {
+	pattern := flag.String("run", "", "Run only scenarios whose name matches this regular expression")
+	verbose := flag.Bool("v", false, "Show the server's logs")
+	flag.Parse()
+
+	filter, err := regexp.Compile(*pattern)
+	if err != nil {
+		log.Fatalf("Invalid -run pattern: %v", err)
+	}
+
+	// Results are reported on stdout; the server and mock gateways print there too, so their
+	// output is dropped unless asked for
+	results := os.Stdout
+	if !*verbose {
+		log.SetOutput(io.Discard)
+		if devNull, err := os.OpenFile(os.DevNull, os.O_WRONLY, 0); err == nil {
+			os.Stdout = devNull
+		}
+	}
+
+	// Events recorded in the outbox are never published, so no broker is needed
+	os.Setenv("MOCK_KAFKA", "true")
+
+	h, err := newHarness()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to start the server: %v\n", err)
+		os.Exit(1)
+	}
+	defer h.Close()
+
+	failed := 0
+	for _, sc := range scenarios {
+		if !filter.MatchString(sc.name) {
+			continue
+		}
+
+		start := time.Now()
+		err := sc.run(h)
+		elapsed := time.Since(start).Round(time.Millisecond)
+		if err != nil {
+			failed++
+			fmt.Fprintf(results, "FAIL %s (%s): %v\n", sc.name, elapsed, err)
+			continue
+		}
+		fmt.Fprintf(results, "ok   %s (%s)\n", sc.name, elapsed)
+	}
+
+	if failed > 0 {
+		fmt.Fprintf(results, "%d scenario(s) failed\n", failed)
+		h.Close()
+		os.Exit(1)
+	}
+}
+
+// harness is the API under test, reachable over HTTP, with the dependencies scenarios control
+// directly: the database, the gateway selector and the in-process event bus
+type harness struct {
+	server   *httptest.Server
+	db       *db.MockDB
+	selector *gateway.Selector
+	apiKey   string
+
+	mu       sync.Mutex
+	observed []events.TransactionEvent
+	stop     func()
+}
+
+// newHarness serves the API the way cmd/main.go wires it with -mock-db, leaving out background
+// schedules so scenarios alone decide what happens
+func newHarness() (*harness, error) {
+	mockDB := db.NewMockDB()
+
+	// Mock gateways that are always available and answer at once
+	selector := gateway.NewSelector(mockDB)
+	selector.RegisterProvider(gateway.NewMockProvider(1, "PayPal", "application/json", 1, 0))
+	selector.RegisterProvider(gateway.NewMockProvider(2, "Stripe", "application/json", 1, 0))
+	selector.RegisterProvider(gateway.NewMockProvider(3, "Adyen", "application/xml", 1, 0))
+
+	transactions := services.NewTransactionService(mockDB, selector)
+
+	reconciliationDir, err := os.MkdirTemp("", "e2etest-reconciliation")
+	if err != nil {
+		return nil, err
+	}
+	reconciliationStore, err := warehouse.NewDirStore(reconciliationDir)
+	if err != nil {
+		return nil, err
+	}
+
+	readiness := utils.NewReadiness(consts.DependencyDatabase, consts.DependencyKafka)
+	readiness.SetReady(consts.DependencyDatabase, true)
+	readiness.SetReady(consts.DependencyKafka, true)
+
+	retention := services.NewRetentionService(mockDB, transactions.Operations(), services.DefaultRetentionPolicy())
+	oauth := services.NewOAuthService(mockDB, services.OAuthConfig{Issuer: "e2etest", TokenTTL: time.Hour, SigningKey: utils.DeriveKey("e2etest-oauth")})
+	screening := services.NewScreeningService(mockDB, nil)
+	users := services.NewUserService(mockDB)
+	users.SetScreening(screening)
+	transactions.SetScreening(screening)
+	metrics := services.NewRealtimeMetrics(transactions.Events())
+	anomalies := services.NewAnomalyDetector(selector)
+	transactions.SetGatewayObserver(anomalies)
+	reconciliation := services.NewReconciliationService(mockDB, reconciliationStore, transactions)
+	// Scenarios act as the merchant of the mock database's users
+	apiKeys := services.NewAPIKeyService(mockDB)
+	key, err := apiKeys.Create(context.Background(), 1, models.APIKeyRequest{Name: "e2etest", Scope: consts.APIKeyScopeFull})
+	if err != nil {
+		return nil, err
+	}
+	producer, err := kafka.NewProducer(kafka.DefaultConfig())
+	if err != nil {
+		return nil, err
+	}
+
+	router := api.SetupRouter(api.Services{
+		Transactions:       transactions,
+		Gateways:           selector,
+		Readiness:          readiness,
+		Retention:          retention,
+		WebhookSecrets:     services.NewWebhookSecretService(mockDB),
+		ClientCertificates: services.NewClientCertificateService(mockDB),
+		SigningKeys:        services.NewSigningKeyService(mockDB),
+		APIKeys:            apiKeys,
+		OAuth:              oauth,
+		Users:              users,
+		Screening:          screening,
+		Metrics:            metrics,
+		Anomalies:          anomalies,
+		Maintenance:        services.NewMaintenanceService(mockDB, selector),
+		RoutingRules:       services.NewRoutingRuleService(mockDB, selector),
+		MerchantWebhooks:   services.NewMerchantWebhookService(mockDB),
+		Payouts:            services.NewPayoutService(mockDB, transactions),
+		Reconciliation:     reconciliation,
+		Producer:           producer,
+	}, &geo.IPLocator{}, utils.SecurityConfig{AllowedOrigins: []string{"*"}}, utils.RequestLogConfig{})
+
+	h := &harness{server: httptest.NewServer(router), db: mockDB, selector: selector, apiKey: key.Key}
+
+	// Watch the in-process event bus the way the realtime metrics do
+	ch, cancel := transactions.Events().Subscribe(events.Filter{}, 1024)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for evt := range ch {
+			h.mu.Lock()
+			h.observed = append(h.observed, evt)
+			h.mu.Unlock()
+		}
+	}()
+	h.stop = func() {
+		cancel()
+		<-done
+		os.RemoveAll(reconciliationDir)
+	}
+
+	return h, nil
+}
+
+// Close stops the server and the event subscription; closing more than once is harmless
+func (h *harness) Close() {
+	if h.stop == nil {
+		return
+	}
+	h.server.Close()
+	h.stop()
+	h.stop = nil
+}
+
+// do sends a JSON request to the API with the merchant's API key and decodes a JSON response into
+// out, if given. The response's status code is returned whatever it is.
+func (h *harness) do(method, path string, body, out interface{}) (int, error) {
+	var reader io.Reader
+	if body != nil {
+		payload, err := json.Marshal(body)
+		if err != nil {
+			return 0, err
+		}
+		reader = bytes.NewReader(payload)
+	}
+
+	req, err := http.NewRequest(method, h.server.URL+path, reader)
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "application/json")
+	req.Header.Set(consts.APIKeyHeader, h.apiKey)
+
+	resp, err := h.server.Client().Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return resp.StatusCode, err
+	}
+	if out != nil && resp.StatusCode < 300 {
+		if err := json.Unmarshal(data, out); err != nil {
+			return resp.StatusCode, fmt.Errorf("failed to decode %s %s response %s: %w", method, path, data, err)
+		}
+	}
+	return resp.StatusCode, nil
+}
+
+// expect sends a request like do and fails unless it is answered with the wanted status code
+func (h *harness) expect(want int, method, path string, body, out interface{}) error {
+	status, err := h.do(method, path, body, out)
+	if err != nil {
+		return err
+	}
+	if status != want {
+		return fmt.Errorf("%s %s: expected status %d, got %d", method, path, want, status)
+	}
+	return nil
+}
+
+// waitForEvent waits for the event bus to announce a transaction reaching a status
+func (h *harness) waitForEvent(txID int, eventType, status string) error {
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) {
+		h.mu.Lock()
+		for _, evt := range h.observed {
+			if evt.Transaction.ID == txID && evt.Type == eventType && evt.Transaction.Status == status {
+				h.mu.Unlock()
+				return nil
+			}
+		}
+		h.mu.Unlock()
+		time.Sleep(10 * time.Millisecond)
+	}
+	return fmt.Errorf("no %s event with status %s was published for transaction %d", eventType, status, txID)
+}
@@ -0,0 +1,192 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"payment-gateway/internal/consts"
+	"payment-gateway/internal/events"
+	"payment-gateway/internal/models"
+	"strconv"
+)
+
+// scenario is a scripted walk through the API that returns an error describing what went wrong
+type scenario struct {
+	name string
+	run  func(h *harness) error
+}
+
+// scenarios run in order against the same server; each creates the transactions it needs
+var scenarios = []scenario{
+	{name: "deposit happy path", run: depositHappyPath},
+	{name: "gateway failover", run: gatewayFailover},
+	{name: "callback replay", run: callbackReplay},
+	{name: "refund", run: refund},
+}
+
+// User 1 of the mock database is in the US, where PayPal is tried before Stripe and Adyen
+const (
+	scenarioUserID       = 1
+	primaryGatewayID     = "1"
+	secondaryGatewayID   = 2
+	scenarioCurrency     = "USD"
+	scenarioDepositValue = 25.00
+)
+
+// depositHappyPath deposits, completes the deposit by callback and reads it back
+func depositHappyPath(h *harness) error {
+	deposit, err := h.deposit(scenarioDepositValue)
+	if err != nil {
+		return err
+	}
+	if deposit.Status != consts.Processing || deposit.ReferenceID == "" || deposit.GatewayReference == "" {
+		return fmt.Errorf("expected a processing deposit with references, got %+v", deposit)
+	}
+	if err := h.waitForEvent(deposit.TransactionID, events.TransactionCreated, consts.Pending); err != nil {
+		return err
+	}
+
+	if err := h.complete(deposit); err != nil {
+		return err
+	}
+	if err := h.waitForEvent(deposit.TransactionID, events.TransactionStatusChanged, consts.Completed); err != nil {
+		return err
+	}
+
+	var history []models.TransactionEvent
+	if err := h.expect(http.StatusOK, http.MethodGet, transactionPath(deposit.TransactionID, "/events"), nil, &history); err != nil {
+		return err
+	}
+	if len(history) == 0 || history[len(history)-1].Status != consts.Completed {
+		return fmt.Errorf("expected the event history to end with the completion, got %+v", history)
+	}
+	return nil
+}
+
+// gatewayFailover deposits while the primary gateway is marked down and expects the next
+// gateway in priority order to take the deposit
+func gatewayFailover(h *harness) error {
+	h.selector.MarkGatewayDown(primaryGatewayID)
+	defer h.selector.MarkGatewayUp(primaryGatewayID)
+
+	deposit, err := h.deposit(scenarioDepositValue)
+	if err != nil {
+		return err
+	}
+
+	tx, err := h.db.GetTransactionByID(deposit.TransactionID)
+	if err != nil {
+		return fmt.Errorf("failed to load transaction %d: %w", deposit.TransactionID, err)
+	}
+	if tx.GatewayID != secondaryGatewayID {
+		return fmt.Errorf("expected gateway %d to take the deposit, got gateway %d", secondaryGatewayID, tx.GatewayID)
+	}
+
+	return h.complete(deposit)
+}
+
+// callbackReplay delivers a deposit's completion twice, as gateways retrying callbacks do, then
+// a stale processing callback, which must not move the deposit back
+func callbackReplay(h *harness) error {
+	deposit, err := h.deposit(scenarioDepositValue)
+	if err != nil {
+		return err
+	}
+
+	for i := 0; i < 2; i++ {
+		if err := h.complete(deposit); err != nil {
+			return fmt.Errorf("delivery %d: %w", i+1, err)
+		}
+	}
+
+	gatewayID, err := h.gatewayOf(deposit.TransactionID)
+	if err != nil {
+		return err
+	}
+	stale := models.CallbackData{TransactionID: deposit.TransactionID, Status: consts.Processing, GatewayReference: deposit.GatewayReference}
+	if err := h.expect(http.StatusConflict, http.MethodPost, consts.CallbackRoute+"/"+gatewayID, stale, nil); err != nil {
+		return err
+	}
+
+	return h.expectStatus(deposit.TransactionID, consts.Completed)
+}
+
+// refund refunds a completed deposit in full and expects a second refund to be refused
+func refund(h *harness) error {
+	deposit, err := h.deposit(scenarioDepositValue)
+	if err != nil {
+		return err
+	}
+	if err := h.complete(deposit); err != nil {
+		return err
+	}
+
+	var refunded models.Refund
+	request := models.RefundRequest{TransactionID: deposit.TransactionID, Reason: "e2e test"}
+	if err := h.expect(http.StatusOK, http.MethodPost, consts.RefundRoute, request, &refunded); err != nil {
+		return err
+	}
+	if refunded.Status != consts.Completed || refunded.Amount != scenarioDepositValue || refunded.RefundableAmount != 0 {
+		return fmt.Errorf("expected a completed refund of %.2f, got %+v", scenarioDepositValue, refunded)
+	}
+
+	var fetched models.Refund
+	if err := h.expect(http.StatusOK, http.MethodGet, consts.RefundRoute+"/"+strconv.Itoa(refunded.ID), nil, &fetched); err != nil {
+		return err
+	}
+	if fetched.ID != refunded.ID || fetched.Status != consts.Completed {
+		return fmt.Errorf("expected refund %d to read back completed, got %+v", refunded.ID, fetched)
+	}
+
+	return h.expect(http.StatusConflict, http.MethodPost, consts.RefundRoute, request, nil)
+}
+
+// deposit submits a deposit of the scenario user
+func (h *harness) deposit(amount float64) (*models.TransactionResponse, error) {
+	var response models.TransactionResponse
+	request := models.TransactionRequest{UserID: scenarioUserID, Amount: amount, Currency: scenarioCurrency}
+	if err := h.expect(http.StatusOK, http.MethodPost, consts.DepositRoute, request, &response); err != nil {
+		return nil, err
+	}
+	return &response, nil
+}
+
+// complete reports a deposit completed through its gateway's callback endpoint and checks the
+// deposit reads back completed
+func (h *harness) complete(deposit *models.TransactionResponse) error {
+	gatewayID, err := h.gatewayOf(deposit.TransactionID)
+	if err != nil {
+		return err
+	}
+
+	callback := models.CallbackData{TransactionID: deposit.TransactionID, Status: consts.Completed, GatewayReference: deposit.GatewayReference}
+	if err := h.expect(http.StatusOK, http.MethodPost, consts.CallbackRoute+"/"+gatewayID, callback, nil); err != nil {
+		return err
+	}
+	return h.expectStatus(deposit.TransactionID, consts.Completed)
+}
+
+// expectStatus reads a transaction of the scenario user through the API and checks its status
+func (h *harness) expectStatus(txID int, status string) error {
+	var tx models.TransactionResponse
+	if err := h.expect(http.StatusOK, http.MethodGet, transactionPath(txID, ""), nil, &tx); err != nil {
+		return err
+	}
+	if tx.Status != status {
+		return fmt.Errorf("expected transaction %d to be %s, got %s", txID, status, tx.Status)
+	}
+	return nil
+}
+
+// gatewayOf returns the ID of the gateway a transaction was sent to
+func (h *harness) gatewayOf(txID int) (string, error) {
+	tx, err := h.db.GetTransactionByID(txID)
+	if err != nil {
+		return "", fmt.Errorf("failed to load transaction %d: %w", txID, err)
+	}
+	return strconv.Itoa(tx.GatewayID), nil
+}
+
+// transactionPath is the path of a transaction of the scenario user, or of one of its resources
+func transactionPath(txID int, resource string) string {
+	return fmt.Sprintf("%s/%d%s?user_id=%d", consts.TransactionsRoute, txID, resource, scenarioUserID)
+}
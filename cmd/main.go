@@ -1,17 +1,40 @@
 package main
 
 import (
+	"context"
+	"crypto/x509"
+	"encoding/hex"
+	"errors"
 	"flag"
 	"fmt"
 	"log"
 	"net/http"
 	"os"
+	"os/signal"
 	"payment-gateway/db"
 	"payment-gateway/internal/api"
+	"payment-gateway/internal/auth"
+	"payment-gateway/internal/codec"
+	"payment-gateway/internal/consts"
 	"payment-gateway/internal/gateway"
+	"payment-gateway/internal/geo"
+	"payment-gateway/internal/httpserver"
 	"payment-gateway/internal/kafka"
+	"payment-gateway/internal/models"
+	"payment-gateway/internal/money"
+	"payment-gateway/internal/paytoken"
+	"payment-gateway/internal/reference"
+	"payment-gateway/internal/scheduler"
+	"payment-gateway/internal/screening"
 	"payment-gateway/internal/services"
+	"payment-gateway/internal/utils"
+	"payment-gateway/internal/warehouse"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
 	"time"
+	_ "time/tzdata" // payout schedules need zone data missing from minimal images
 )
 
 func main() {
@@ -25,12 +48,30 @@ func main() {
 		*useMockDB = true
 	}
 
+	productionDeployment := getEnvOrDefault("ENV", "development") == deploymentProduction
+	security := loadSecurityConfig()
+
 	var dbInterface db.DBInterface
 
+	// Track dependency readiness; traffic should only be routed once everything is confirmed
+	readiness := utils.NewReadiness(consts.DependencyDatabase, consts.DependencyKafka)
+
+	// Create the Kafka producer; it connects once the first event is published
+	kafkaConfig, err := kafka.ConfigFromEnv()
+	if err != nil {
+		log.Fatalf("Invalid Kafka configuration: %v", err)
+	}
+	producer, err := kafka.NewProducer(kafkaConfig)
+	if err != nil {
+		log.Fatalf("Failed to create Kafka producer: %v", err)
+	}
+
 	// Initialize database
 	if *useMockDB {
 		log.Println("Using mock database for testing")
 		dbInterface = db.NewMockDB()
+		readiness.SetReady(consts.DependencyDatabase, true)
+		readiness.SetReady(consts.DependencyKafka, true)
 	} else {
 		// Initialize PostgreSQL database
 		dbUser := getEnvOrDefault("DB_USER", "postgres")
@@ -43,12 +84,22 @@ func main() {
 
 		dbURL := "postgres://" + dbUser + ":" + dbPassword + "@" + dbHost + ":" + dbPort + "/" + dbName + "?sslmode=disable"
 
-		log.Println("Connecting to PostgreSQL database...")
-		postgresDB, err := db.NewPostgresDB(dbURL)
+		// Open the pool without connecting; connectivity is confirmed in the background
+		postgresDB, err := db.OpenPostgresDB(dbURL)
 		if err != nil {
-			log.Fatalf("Failed to connect to database: %v", err)
+			log.Fatalf("Failed to open database: %v", err)
 		}
 		dbInterface = postgresDB
+
+		// Shard by merchant when additional shard databases are configured
+		if shardDSNs := os.Getenv("DB_SHARD_DSNS"); shardDSNs != "" {
+			dbInterface, err = openShardedDB(postgresDB, strings.Split(shardDSNs, ","))
+			if err != nil {
+				log.Fatalf("Failed to open database shards: %v", err)
+			}
+		}
+
+		go waitForDependencies(readiness, dbInterface, producer, loadStartupConfig())
 	}
 
 	// Set up clean shutdown
@@ -58,60 +109,1117 @@ func main() {
 			log.Printf("Error closing database connection: %v", err)
 		}
 
-		// Close Kafka connection
-		if kafka.IsInitialized() {
-			if err := kafka.Close(); err != nil {
-				log.Printf("Error closing Kafka connection: %v", err)
-			}
+		// Flush and close the Kafka producer
+		if err := producer.Close(); err != nil {
+			log.Printf("Error closing Kafka connection: %v", err)
 		}
 	}()
 
+	// Background jobs are registered as their services are set up and started with the server
+	jobs := scheduler.New()
+
 	// Initialize gateway selector
 	gatewaySelector := gateway.NewSelector(dbInterface)
 
-	// Register payment gateway providers
-	registerPaymentGateways(gatewaySelector)
+	// Client certificates for gateways requiring mutual TLS. HTTP providers of such gateways take
+	// their TLS configuration from it and close idle connections when a certificate is rotated:
+	//	config.TLS = clientCertificates.TLSConfig(gatewayID)
+	//	clientCertificates.OnRotate(gatewayID, client.CloseIdleConnections)
+	clientCertificates := services.NewClientCertificateService(dbInterface)
+	if err := clientCertificates.Refresh(context.Background()); err != nil {
+		log.Printf("Failed to load client certificates: %v", err)
+	}
+	jobs.Register("client-certificate-refresh", consts.ClientCertificateRefreshInterval, clientCertificates.Refresh)
+
+	// Keys requests to open-banking style gateways are signed with. Their providers sign client
+	// assertions with signingKeys.SignJWT and request bodies with signingKeys.SignDetached.
+	signingKeys := services.NewSigningKeyService(dbInterface)
+	if err := signingKeys.Refresh(context.Background()); err != nil {
+		log.Printf("Failed to load signing keys: %v", err)
+	}
+	jobs.Register("signing-key-refresh", consts.SigningKeyRefreshInterval, signingKeys.Refresh)
+
+	// Register payment gateway providers; live credentials are only allowed in production deployments
+	registerPaymentGateways(gatewaySelector, productionDeployment)
+
+	// Production deployments refuse to start with settings that are only safe in development
+	if productionDeployment {
+		var mockGateways []string
+		for _, provider := range gatewaySelector.Providers() {
+			if gateway.IsMock(provider) {
+				mockGateways = append(mockGateways, fmt.Sprintf("%s (%s)", provider.ID(), provider.Name()))
+			}
+		}
+		if err := security.CheckProduction(*useMockDB, mockGateways); err != nil {
+			log.Fatalf("Refusing to start in production:\n%v", err)
+		}
+	}
 
 	// Initialize transaction service
 	transactionService := services.NewTransactionService(dbInterface, gatewaySelector)
+	transactionService.SetLiveTransactions(productionDeployment)
+
+	// Configure the country signals used to infer where a transaction originates
+	locator, binTable := loadGeoConfig()
+	transactionService.SetBINTable(binTable)
+
+	// Generate the references transactions are known by to merchants and gateways
+	references, err := reference.NewGenerator(getEnvOrDefault("REFERENCE_PREFIX", consts.DefaultReferencePrefix))
+	if err != nil {
+		log.Fatalf("Invalid REFERENCE_PREFIX: %v", err)
+	}
+	transactionService.SetReferenceGenerator(references)
+
+	// Round amounts to their currency's minor unit, half up unless configured otherwise per currency
+	rounder, err := money.ParseRounder(os.Getenv("ROUNDING_MODE"), os.Getenv("CURRENCY_ROUNDING_MODES"))
+	if err != nil {
+		log.Fatalf("Invalid ROUNDING_MODE or CURRENCY_ROUNDING_MODES: %v", err)
+	}
+	transactionService.SetRounder(rounder)
+
+	// Decrypt Apple Pay and Google Pay tokens for gateways taking cards rather than tokens, with the
+	// merchant certificates managed through the admin API
+	merchantCertificates := transactionService.MerchantCertificates()
+	merchantCertificates.SetDecrypter(loadWalletTokenDecrypter())
+	if err := merchantCertificates.Refresh(context.Background()); err != nil {
+		log.Printf("Failed to load merchant certificates: %v", err)
+	}
+	jobs.Register("merchant-certificate-refresh", consts.MerchantCertificateRefreshInterval, merchantCertificates.Refresh)
+
+	// Banks send customers back here after they authorised an open banking deposit; consents left
+	// unauthorised past their expiry fail their deposit
+	transactionService.SetOpenBankingRedirectURI(getEnvOrDefault("OPEN_BANKING_REDIRECT_URI", "http://localhost:"+*port+consts.OpenBankingCallbackRoute))
+	jobs.Register("consent-expiry", consts.ConsentExpiryInterval, countJob("Expired %d payment consents", transactionService.ExpireConsents))
+
+	// Deposits at gateways with a payment window, such as unpaid PIX charges, fail once it closed
+	jobs.Register("payment-expiry", consts.PaymentExpiryInterval, countJob("Expired %d unpaid deposits", transactionService.ExpirePayments))
+
+	// Authorized deposits are voided once their gateway released the amount held
+	jobs.Register("authorization-expiry", consts.AuthorizationExpiryInterval, countJob("Voided %d expired authorizations", transactionService.ExpireAuthorizations))
+
+	// Deposits held for fraud review become spendable once their hold's deadline passed unreviewed
+	jobs.Register("hold-release", consts.HoldReleaseInterval, countJob("Released %d expired holds", transactionService.ReleaseExpiredHolds))
+
+	// Responses replayed to retried deposits and withdrawals are forgotten once they expire
+	jobs.Register("idempotency-key-purge", consts.IdempotencyKeyPurgeInterval, countJob("Purged %d expired idempotency keys", transactionService.PurgeExpiredIdempotencyKeys))
+
+	// Banks send users back here after they let a bank payout gateway read their accounts
+	transactionService.SetBankAccountRedirectURI(getEnvOrDefault("BANK_ACCOUNT_REDIRECT_URI", "http://localhost:"+*port+consts.BankAccountCallbackRoute))
+
+	// Wallet transfers are held to per-transfer, daily and new-recipient limits
+	transactionService.SetTransferLimits(services.TransferLimits{
+		MaxAmount:          getEnvFloat("TRANSFER_MAX_AMOUNT", consts.DefaultTransferMaxAmount),
+		DailyAmount:        getEnvFloat("TRANSFER_DAILY_AMOUNT", consts.DefaultTransferDailyAmount),
+		DailyCount:         getEnvInt("TRANSFER_DAILY_COUNT", consts.DefaultTransferDailyCount),
+		NewRecipientAmount: getEnvFloat("TRANSFER_NEW_RECIPIENT_AMOUNT", consts.DefaultTransferNewRecipientAmount),
+	})
+
+	// Top up wallets under their auto-reload rules, capped in amount and per day, and disable rules
+	// that keep failing
+	transactionService.SetAutoReloadLimits(services.AutoReloadLimits{
+		MaxAmount:   getEnvFloat("AUTO_RELOAD_MAX_AMOUNT", consts.DefaultAutoReloadMaxAmount),
+		DailyCount:  getEnvInt("AUTO_RELOAD_DAILY_COUNT", consts.DefaultAutoReloadDailyCount),
+		MaxFailures: getEnvInt("AUTO_RELOAD_MAX_FAILURES", consts.DefaultAutoReloadMaxFailures),
+	})
+	jobs.Register("auto-reload", getEnvDuration("AUTO_RELOAD_INTERVAL", consts.AutoReloadInterval), countJob("Submitted %d auto-reloads", transactionService.RunAutoReloads))
+
+	// Lookups of transactions in flight reuse the status their gateway last reported for a few seconds
+	transactionService.SetStatusQueryCacheTTL(getEnvDuration("STATUS_QUERY_CACHE_TTL", consts.DefaultStatusQueryCacheTTL))
+
+	// Transactions in flight at gateways whose callbacks can't be relied on, such as UPI PSPs, are
+	// polled for their status
+	jobs.Register("status-polling", consts.StatusPollInterval, countJob("Settled %d transactions by polling their gateways", transactionService.PollTransactionStatuses))
+
+	// Transactions left pending or processing unchanged past their TTL are failed, after asking
+	// their gateway for the outcome where it can be asked
+	transactionService.SetStaleTransactionPolicy(services.StaleTransactionPolicy{
+		TTL:          getEnvDuration("STALE_TRANSACTION_TTL", consts.DefaultStaleTransactionTTL),
+		QueryGateway: os.Getenv("STALE_TRANSACTION_QUERY_GATEWAY") != "false",
+	})
+	jobs.Register("stale-transaction-expiry", consts.StaleTransactionInterval, countJob("Expired %d stale transactions", transactionService.ExpireStaleTransactions))
+
+	// AML reports identify the reporting entity by its registration with the financial intelligence unit
+	transactionService.SetAMLReportingEntityID(os.Getenv("AML_REPORTING_ENTITY_ID"))
+
+	// Periodically purge expired long-running operations
+	jobs.Register("operation-cleanup", consts.OperationCleanupInterval, func(context.Context) error {
+		deleted, err := transactionService.Operations().PurgeExpired()
+		if deleted > 0 {
+			log.Printf("Purged %d expired operations", deleted)
+		}
+		return err
+	})
+
+	// Move aged and soft-deleted transactions out of the hot table on a schedule
+	retentionService := services.NewRetentionService(dbInterface, transactionService.Operations(), loadRetentionPolicy())
+	jobs.Register("archival", consts.ArchivalInterval, func(ctx context.Context) error {
+		_, err := retentionService.StartArchival(ctx)
+		return err
+	})
+
+	// Index searchable fields of rows written before blind indexes were maintained
+	if indexer, ok := dbInterface.(db.BlindIndexer); ok {
+		go backfillBlindIndexes(indexer, loadStartupConfig())
+	}
+
+	// Keep monthly partitions created ahead of time when the database supports them
+	if partitioner, ok := dbInterface.(db.Partitioner); ok {
+		partitionService := services.NewPartitionService(partitioner, db.PartitionedTables, consts.PartitionMonthsAhead)
+		if err := partitionService.EnsurePartitions(); err != nil {
+			log.Printf("Partition maintenance failed: %v", err)
+		}
+		jobs.Register("partition-maintenance", consts.PartitionMaintenanceInterval, func(context.Context) error {
+			return partitionService.EnsurePartitions()
+		})
+	}
+
+	// Deliver recorded outbox messages to Kafka and merchant webhooks. Kafka events are kept
+	// until the broker accepts them, however long it is down.
+	kafkaDispatcher := services.NewOutboxDispatcher(dbInterface, consts.OutboxKafka, services.NewKafkaSink(producer))
+	kafkaDispatcher.SetMaxAttempts(0)
+	jobs.Register("outbox-kafka", consts.OutboxDispatchInterval, func(ctx context.Context) error {
+		_, err := kafkaDispatcher.DispatchPending(ctx)
+		return err
+	})
+
+	// Consume the transaction topics when a consumer group is configured
+	var consumer *kafka.Consumer
+	if group := os.Getenv("KAFKA_CONSUMER_GROUP"); group != "" && !kafka.Mocked() {
+		consumer = kafka.NewConsumer(kafka.DefaultConsumerConfig(producer, group))
+		consumer.Handle("log", kafka.LogHandler)
+	}
+
+	webhookDispatcher := services.NewOutboxDispatcher(dbInterface, consts.OutboxMerchantWebhook, services.NewMerchantWebhookSink(dbInterface))
+	jobs.Register("outbox-merchant-webhooks", consts.OutboxDispatchInterval, func(ctx context.Context) error {
+		_, err := webhookDispatcher.DispatchPending(ctx)
+		return err
+	})
+
+	// Export completed transactions to the data warehouse when a directory is configured
+	if dir := os.Getenv("WAREHOUSE_DIR"); dir != "" {
+		store, err := warehouse.NewDirStore(dir)
+		if err != nil {
+			log.Fatalf("Invalid WAREHOUSE_DIR: %v", err)
+		}
+		transactionService.SetWarehouseExport(true)
+		exporter := services.NewWarehouseExporter(dbInterface, store)
+		jobs.Register("warehouse-export", getEnvDuration("WAREHOUSE_EXPORT_INTERVAL", consts.WarehouseExportInterval), func(ctx context.Context) error {
+			_, err := exporter.ExportPending(ctx)
+			return err
+		})
+	}
+
+	// Verify gateway callbacks against their active webhook secrets
+	webhookSecrets := services.NewWebhookSecretService(dbInterface)
+
+	// Authenticate merchants with the API keys they manage themselves
+	apiKeys := services.NewAPIKeyService(dbInterface)
+
+	// Issue OAuth2 client-credentials tokens and accept those of a configured identity provider
+	oauth := services.NewOAuthService(dbInterface, loadOAuthConfig())
+
+	// Manage users, screening new users and large withdrawals' beneficiaries against sanctions lists
+	screeningService := services.NewScreeningService(dbInterface, loadSanctionsScreener())
+	transactionService.SetScreening(screeningService)
+	users := services.NewUserService(dbInterface)
+	users.SetScreening(screeningService)
+
+	// Count recent outcomes per gateway and country for live dashboards
+	metrics := services.NewRealtimeMetrics(transactionService.Events())
+	stopMetrics := metrics.Start()
+	defer stopMetrics()
 
-	// Set up HTTP router
-	router := api.SetupRouter(transactionService, gatewaySelector)
+	// Alert on gateway failure-rate and latency spikes, downgrading gateways configured for it
+	anomalies := services.NewAnomalyDetector(gatewaySelector)
+	anomalySettings, err := services.ParseAnomalySettings(os.Getenv("ANOMALY_GATEWAY_SETTINGS"))
+	if err != nil {
+		log.Fatalf("Invalid ANOMALY_GATEWAY_SETTINGS: %v", err)
+	}
+	for gatewayID, settings := range anomalySettings {
+		anomalies.SetGatewaySettings(gatewayID, settings)
+	}
+	if url := os.Getenv("ANOMALY_ALERT_WEBHOOK_URL"); url != "" {
+		anomalies.SetAlertSink(services.NewWebhookAlertSink(url))
+	}
+	transactionService.SetGatewayObserver(anomalies)
+	jobs.Register("anomaly-detection", getEnvDuration("ANOMALY_INTERVAL", consts.AnomalyInterval), func(ctx context.Context) error {
+		anomalies.Evaluate(ctx)
+		return nil
+	})
+
+	// Skip gateways during their scheduled maintenance windows
+	maintenance := services.NewMaintenanceService(dbInterface, gatewaySelector)
+	if err := maintenance.Refresh(context.Background()); err != nil {
+		log.Printf("Warning: %v", err)
+	}
+	jobs.Register("maintenance-refresh", consts.MaintenanceRefreshInterval, maintenance.Refresh)
+
+	// Report declines with the recovery hints configured in the mapping table
+	recoveryHints := transactionService.RecoveryHints()
+	if err := recoveryHints.Refresh(context.Background()); err != nil {
+		log.Printf("Warning: %v", err)
+	}
+	jobs.Register("recovery-hint-refresh", consts.RecoveryHintRefreshInterval, recoveryHints.Refresh)
+
+	// Let merchants prefer or exclude gateways for their transactions
+	routingRules := services.NewRoutingRuleService(dbInterface, gatewaySelector)
+	merchantWebhooks := services.NewMerchantWebhookService(dbInterface)
+
+	// Pay out withdrawals of merchants with a payout schedule once their payout is due
+	payouts := services.NewPayoutService(dbInterface, transactionService)
+	jobs.Register("payouts", getEnvDuration("PAYOUT_INTERVAL", consts.PayoutInterval), countJob("Submitted %d scheduled withdrawals for payout", payouts.RunDue))
+
+	// Write merchants' daily reconciliation files once each day has settled and announce them by webhook
+	reconciliationStore, err := warehouse.NewDirStore(getEnvOrDefault("RECONCILIATION_DIR", "reconciliation"))
+	if err != nil {
+		log.Fatalf("Invalid RECONCILIATION_DIR: %v", err)
+	}
+	reconciliation := services.NewReconciliationService(dbInterface, reconciliationStore, transactionService)
+	reconciliation.SetBaseURL(getEnvOrDefault("PUBLIC_API_URL", "http://localhost:"+*port))
+	jobs.Register("reconciliation", getEnvDuration("RECONCILIATION_INTERVAL", consts.ReconciliationInterval), countJob("Generated %d reconciliation files", reconciliation.RunDue))
 
-	// Configure HTTP server
-	server := &http.Server{
-		Addr:         ":" + *port,
-		Handler:      router,
-		ReadTimeout:  15 * time.Second,
-		WriteTimeout: 15 * time.Second,
+	// Set up the HTTP servers, one per listener, each routing its share of the API through its own
+	// middleware chain
+	handler := api.NewHandler(api.Services{
+		Transactions:       transactionService,
+		Gateways:           gatewaySelector,
+		Readiness:          readiness,
+		Retention:          retentionService,
+		WebhookSecrets:     webhookSecrets,
+		ClientCertificates: clientCertificates,
+		SigningKeys:        signingKeys,
+		APIKeys:            apiKeys,
+		OAuth:              oauth,
+		Users:              users,
+		Screening:          screeningService,
+		Metrics:            metrics,
+		Anomalies:          anomalies,
+		Maintenance:        maintenance,
+		RoutingRules:       routingRules,
+		MerchantWebhooks:   merchantWebhooks,
+		Payouts:            payouts,
+		Reconciliation:     reconciliation,
+		Producer:           producer,
+	})
+	requestLog := loadRequestLogConfig()
+	listeners := loadListeners(loadServerConfig(*port))
+	servers := make([]*httpserver.Server, len(listeners))
+	for i, l := range listeners {
+		router := api.NewRouter(handler, l.scope, api.AdminMiddleware(security), api.Middleware(l.scope, locator, security, requestLog)...)
+		servers[i] = httpserver.New(l.config, router)
+	}
 
-		IdleTimeout: 60 * time.Second,
+	// Start the background jobs, the Kafka consumer and the server
+	jobs.Start()
+	consumerCtx, stopConsumer := context.WithCancel(context.Background())
+	consumerDone := make(chan struct{})
+	go func() {
+		defer close(consumerDone)
+		if consumer == nil {
+			return
+		}
+		if err := consumer.Run(consumerCtx); err != nil {
+			log.Printf("Kafka consumer stopped: %v", err)
+		}
+	}()
+	for i, server := range servers {
+		go func() {
+			log.Printf("Serving the %s on %s...", listeners[i].name, listeners[i].config.Addr)
+			if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+				log.Fatalf("Server on %s failed to start: %v", listeners[i].config.Addr, err)
+			}
+		}()
 	}
 
-	// Start the server
-	log.Printf("Server starting on port %s...", *port)
-	if err := server.ListenAndServe(); err != nil {
-		log.Fatalf("Server failed to start: %v", err)
+	// On SIGINT or SIGTERM, finish the requests and job runs in progress before closing connections
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	log.Printf("Received %s, shutting down", <-signals)
+
+	ctx, cancel := context.WithTimeout(context.Background(), consts.ShutdownTimeout)
+	defer cancel()
+	for i, server := range servers {
+		if err := server.Shutdown(ctx); err != nil {
+			log.Printf("Failed to shut down the server on %s: %v", listeners[i].config.Addr, err)
+		}
 	}
+	if err := jobs.Stop(ctx); err != nil {
+		log.Printf("Failed to stop background jobs: %v", err)
+	}
+
+	// Stop consuming, leaving a message still being retried uncommitted, then leave the consumer group
+	stopConsumer()
+	select {
+	case <-consumerDone:
+	case <-ctx.Done():
+		log.Printf("Kafka consumer still running: %v", ctx.Err())
+	}
+	if consumer != nil {
+		if err := consumer.Close(); err != nil {
+			log.Printf("Failed to close the Kafka consumer: %v", err)
+		}
+	}
+}
+
+// countJob adapts a job returning how many items it handled, logging the count when it handled any
+func countJob(format string, run func(ctx context.Context) (int, error)) scheduler.Func {
+	return func(ctx context.Context) error {
+		n, err := run(ctx)
+		if n > 0 {
+			log.Printf(format, n)
+		}
+		return err
+	}
+}
+
+// openShardedDB combines the primary database with the given shard DSNs into a
+// database sharded by merchant; the primary is shard 0
+func openShardedDB(primary *db.PostgresDB, dsns []string) (*db.ShardedDB, error) {
+	shards := []db.DBInterface{primary}
+
+	for _, dsn := range dsns {
+		dsn = strings.TrimSpace(dsn)
+		if dsn == "" {
+			continue
+		}
+
+		shard, err := db.OpenPostgresDB(dsn)
+		if err != nil {
+			return nil, err
+		}
+		shards = append(shards, shard)
+	}
+
+	log.Printf("Sharding data across %d databases", len(shards))
+	return db.NewShardedDB(shards, db.ModuloResolver{Shards: len(shards)})
 }
 
-// registerPaymentGateways registers all available payment gateway providers
-func registerPaymentGateways(selector *gateway.Selector) {
+// registerPaymentGateways registers all available payment gateway providers. Gateways that only
+// have a mock, PayPal and open banking, are left out of production deployments.
+func registerPaymentGateways(selector *gateway.Selector, productionDeployment bool) {
 	// Register PayPal provider
-	paypal := gateway.NewMockProvider(1, "PayPal", "application/json", 0.95, 500*time.Millisecond)
-	selector.RegisterProvider(paypal)
+	if !productionDeployment {
+		paypal := gateway.NewMockProvider(1, "PayPal", "application/json", 0.95, 500*time.Millisecond)
+		configureEnvironments(paypal, productionDeployment)
+		selector.RegisterProvider(paypal)
+	}
 
-	// Register Stripe provider
-	stripe := gateway.NewMockProvider(2, "Stripe", "application/json", 0.98, 300*time.Millisecond)
+	// Register Stripe provider, calling Stripe's API once its sandbox or live API key is configured
+	var stripe configurableProvider = gateway.NewMockProvider(2, "Stripe", "application/json", 0.98, 300*time.Millisecond)
+	if hasAPIKey(stripe.ID()) {
+		stripe = gateway.NewStripeProvider(2, "Stripe", loadStripeConfig())
+	}
+	configureEnvironments(stripe, productionDeployment)
 	selector.RegisterProvider(stripe)
 
-	// Register Adyen provider
-	adyen := gateway.NewMockProvider(3, "Adyen", "application/xml", 0.90, 800*time.Millisecond)
+	// Register Adyen provider, calling Adyen's APIs once its sandbox or live API key is configured
+	var adyen configurableProvider = gateway.NewMockProvider(3, "Adyen", "application/xml", 0.90, 800*time.Millisecond)
+	if hasAPIKey(adyen.ID()) {
+		adyen = gateway.NewAdyenProvider(3, "Adyen", loadAdyenConfig())
+	}
+	configureEnvironments(adyen, productionDeployment)
 	selector.RegisterProvider(adyen)
 
+	// Register the card switch when one is configured
+	if config, ok := loadISO8583Config(); ok {
+		cardSwitch := gateway.NewISO8583Provider(getEnvInt("ISO8583_GATEWAY_ID", 4), getEnvOrDefault("ISO8583_GATEWAY_NAME", "Card Switch"), config)
+		selector.RegisterProvider(cardSwitch)
+	}
+
+	// Register M-Pesa when a short code is configured; its Daraja credentials are the gateway's
+	// API keys, "<consumer key>:<consumer secret>"
+	if config, ok := loadMpesaConfig(); ok {
+		mpesa := gateway.NewMpesaProvider(getEnvInt("MPESA_GATEWAY_ID", 6), getEnvOrDefault("MPESA_GATEWAY_NAME", "M-Pesa"), config)
+		configureEnvironments(mpesa, productionDeployment)
+		selector.RegisterProvider(mpesa)
+	}
+
+	// Register Coinbase Commerce when its webhook secrets are configured; its API keys are the
+	// gateway's API keys
+	if config, ok := loadCoinbaseCommerceConfig(); ok {
+		coinbase := gateway.NewCoinbaseCommerceProvider(getEnvInt("COINBASE_COMMERCE_GATEWAY_ID", 7), getEnvOrDefault("COINBASE_COMMERCE_GATEWAY_NAME", "Coinbase Commerce"), config)
+		configureEnvironments(coinbase, productionDeployment)
+		selector.RegisterProvider(coinbase)
+	}
+
+	// Register TrueLayer when merchant accounts are configured; its app's credentials are the
+	// gateway's API keys, "<client ID>:<client secret>". Withdrawals reach it only when they name a
+	// bank account linked through it.
+	if config, ok := loadTrueLayerConfig(); ok {
+		truelayer := gateway.NewTrueLayerProvider(getEnvInt("TRUELAYER_GATEWAY_ID", 8), getEnvOrDefault("TRUELAYER_GATEWAY_NAME", "TrueLayer"), config)
+		configureEnvironments(truelayer, productionDeployment)
+		selector.RegisterProvider(truelayer)
+	}
+
+	// Register the SEPA provider when the merchant's creditor identity is configured; the bank's
+	// API key is the gateway's API key. Transactions reach it only when they name a mandate.
+	if config, ok := loadSEPAConfig(); ok {
+		sepa := gateway.NewSEPAProvider(getEnvInt("SEPA_GATEWAY_ID", 9), getEnvOrDefault("SEPA_GATEWAY_NAME", "SEPA"), config)
+		configureEnvironments(sepa, productionDeployment)
+		selector.RegisterProvider(sepa)
+	}
+
+	// Register PIX when the merchant's PIX key is configured; the PSP's client credentials are the
+	// gateway's API keys, "<client ID>:<client secret>"
+	if config, ok := loadPixConfig(); ok {
+		pix := gateway.NewPixProvider(getEnvInt("PIX_GATEWAY_ID", 10), getEnvOrDefault("PIX_GATEWAY_NAME", "PIX"), config)
+		configureEnvironments(pix, productionDeployment)
+		selector.RegisterProvider(pix)
+	}
+
+	// Register UPI when the merchant's VPA is configured; the PSP's API key is the gateway's API key
+	if config, ok := loadUPIConfig(); ok {
+		upi := gateway.NewUPIProvider(getEnvInt("UPI_GATEWAY_ID", 11), getEnvOrDefault("UPI_GATEWAY_NAME", "UPI"), config)
+		configureEnvironments(upi, productionDeployment)
+		selector.RegisterProvider(upi)
+	}
+
+	// Register the open banking provider; deposits reach it only when they name a bank from its
+	// directory and the gateway exists in the database
+	if !productionDeployment {
+		openBanking := gateway.NewMockOpenBankingProvider(getEnvInt("OPEN_BANKING_GATEWAY_ID", 5), getEnvOrDefault("OPEN_BANKING_GATEWAY_NAME", "Open Banking"), []models.Bank{
+			{ID: "sandbox-gb", Name: "Sandbox Bank UK", CountryCode: "GB", Currencies: []string{"GBP"}},
+			{ID: "sandbox-de", Name: "Sandbox Bank Deutschland", CountryCode: "DE", Currencies: []string{"EUR"}},
+		})
+		configureEnvironments(openBanking, productionDeployment)
+		selector.RegisterProvider(openBanking)
+	}
+
 	log.Println("Payment gateway providers registered successfully")
 }
 
+// hasAPIKey reports whether a sandbox or live API key is configured for a gateway
+func hasAPIKey(gatewayID string) bool {
+	prefix := "GATEWAY_" + gatewayID + "_"
+	return os.Getenv(prefix+"SANDBOX_API_KEY") != "" || os.Getenv(prefix+"LIVE_API_KEY") != ""
+}
+
+// configurableProvider is a provider whose sandbox and production environments are configurable
+type configurableProvider interface {
+	gateway.Provider
+	gateway.EnvironmentProvider
+}
+
+// configureEnvironments applies a provider's GATEWAY_<ID>_SANDBOX_URL, GATEWAY_<ID>_SANDBOX_API_KEY,
+// GATEWAY_<ID>_LIVE_URL and GATEWAY_<ID>_LIVE_API_KEY settings, refusing to start when live
+// credentials are configured outside a production deployment
+func configureEnvironments(provider configurableProvider, productionDeployment bool) {
+	prefix := "GATEWAY_" + provider.ID() + "_"
+
+	environments := gateway.Environments{
+		Sandbox: gateway.Environment{
+			Name:    gateway.EnvironmentSandbox,
+			BaseURL: os.Getenv(prefix + "SANDBOX_URL"),
+			APIKey:  os.Getenv(prefix + "SANDBOX_API_KEY"),
+		},
+	}
+	if liveURL, liveKey := os.Getenv(prefix+"LIVE_URL"), os.Getenv(prefix+"LIVE_API_KEY"); liveURL != "" || liveKey != "" {
+		environments.Production = &gateway.Environment{Name: gateway.EnvironmentProduction, BaseURL: liveURL, APIKey: liveKey}
+	}
+
+	if err := environments.Check(productionDeployment); err != nil {
+		log.Fatalf("Invalid environments of gateway %s: %v", provider.ID(), err)
+	}
+	provider.SetEnvironments(environments)
+}
+
+// listener is an address the API is served on and the routes served there
+type listener struct {
+	name   string
+	config httpserver.Config
+	scope  api.Scope
+}
+
+// startupConfig controls how long startup waits for dependencies to become reachable
+type startupConfig struct {
+	maxAttempts    int
+	initialBackoff time.Duration
+	maxBackoff     time.Duration
+	checkTimeout   time.Duration
+}
+
+// deploymentProduction is the ENV of production deployments
+const deploymentProduction = "production"
+
+// loadSecurityConfig reads from the environment the allowed CORS origins, the admin tokens and
+// the networks admin requests may come from. Any origin is allowed by default.
+func loadSecurityConfig() utils.SecurityConfig {
+	config := utils.SecurityConfig{AdminToken: os.Getenv("ADMIN_TOKEN")}
+	for _, origin := range strings.Split(getEnvOrDefault("CORS_ALLOWED_ORIGINS", "*"), ",") {
+		if origin = strings.TrimSpace(origin); origin != "" {
+			config.AllowedOrigins = append(config.AllowedOrigins, origin)
+		}
+	}
+
+	var err error
+	if config.AdminTokens, err = utils.ParseAdminTokens(os.Getenv("ADMIN_TOKENS")); err != nil {
+		log.Fatalf("Invalid ADMIN_TOKENS: %v", err)
+	}
+	if config.AdminNetworks, err = utils.ParseNetworks(os.Getenv("ADMIN_ALLOWED_NETWORKS")); err != nil {
+		log.Fatalf("Invalid ADMIN_ALLOWED_NETWORKS: %v", err)
+	}
+
+	if !config.AdminAuthEnabled() {
+		log.Println("Neither ADMIN_TOKEN nor ADMIN_TOKENS is set; admin endpoints are not authenticated")
+	}
+	return config
+}
+
+// loadRequestLogConfig reads request and response logging settings from the environment.
+// Logging is off unless REQUEST_LOGGING is true, and then covers every request by default.
+func loadRequestLogConfig() utils.RequestLogConfig {
+	config := utils.RequestLogConfig{
+		Enabled:      os.Getenv("REQUEST_LOGGING") == "true",
+		SampleRate:   1,
+		MaxBodyBytes: getEnvInt("REQUEST_LOG_MAX_BODY_BYTES", consts.RequestLogMaxBodyBytes),
+	}
+
+	if rate := os.Getenv("REQUEST_LOG_SAMPLE_RATE"); rate != "" {
+		sampleRate, err := strconv.ParseFloat(rate, 64)
+		if err != nil || sampleRate < 0 || sampleRate > 1 {
+			log.Fatalf("Invalid REQUEST_LOG_SAMPLE_RATE: must be between 0 and 1")
+		}
+		config.SampleRate = sampleRate
+	}
+
+	if config.Enabled {
+		log.Printf("Logging %g%% of requests and responses, masked", config.SampleRate*100)
+	}
+	return config
+}
+
+// loadServerConfig reads the HTTP server's protocols, connection limits and keep-alive settings
+// from the environment. HTTP/2 is served over TLS when TLS_CERT_FILE and TLS_KEY_FILE are set, and
+// without TLS to clients using it with prior knowledge when H2C_ENABLED is true.
+func loadServerConfig(port string) httpserver.Config {
+	config := httpserver.DefaultConfig(":" + port)
+	config.HTTP2 = os.Getenv("HTTP2_ENABLED") != "false"
+	config.H2C = os.Getenv("H2C_ENABLED") == "true"
+	config.TLSCertFile = os.Getenv("TLS_CERT_FILE")
+	config.TLSKeyFile = os.Getenv("TLS_KEY_FILE")
+	config.IdleTimeout = getEnvDuration("HTTP_IDLE_TIMEOUT", config.IdleTimeout)
+	config.MaxIdleConns = getEnvInt("HTTP_MAX_IDLE_CONNS", 0)
+	config.MaxConcurrentStreams = getEnvInt("HTTP2_MAX_CONCURRENT_STREAMS", 0)
+	config.TCPKeepAlive = os.Getenv("TCP_KEEPALIVE") != "false"
+	config.TCPKeepAliveIdle = getEnvDuration("TCP_KEEPALIVE_IDLE", config.TCPKeepAliveIdle)
+	config.TCPKeepAliveInterval = getEnvDuration("TCP_KEEPALIVE_INTERVAL", config.TCPKeepAliveInterval)
+	config.TCPKeepAliveCount = getEnvInt("TCP_KEEPALIVE_COUNT", config.TCPKeepAliveCount)
+
+	if (config.TLSCertFile == "") != (config.TLSKeyFile == "") {
+		log.Fatalf("Invalid TLS configuration: TLS_CERT_FILE and TLS_KEY_FILE must be set together")
+	}
+	if config.HTTP2 && !config.TLS() && !config.H2C {
+		log.Printf("HTTP/2 needs TLS_CERT_FILE and TLS_KEY_FILE, or H2C_ENABLED for HTTP/2 without TLS; serving HTTP/1.1 only")
+	}
+	return config
+}
+
+// loadListeners reads the listeners the API is served on from the environment. The API is served on
+// the port, and on the Unix socket API_SOCKET for a sidecar proxy. Setting ADMIN_ADDR, a TCP
+// address or unix:/path, serves the admin endpoints there only. Unix sockets are served without
+// TLS, which the proxy in front of them terminates.
+func loadListeners(config httpserver.Config) []listener {
+	scope := api.ScopeAll
+	adminAddr := os.Getenv("ADMIN_ADDR")
+	if adminAddr != "" {
+		scope = api.ScopePublic
+	}
+
+	listeners := []listener{{name: "API", config: config, scope: scope}}
+	if path := os.Getenv("API_SOCKET"); path != "" {
+		listeners = append(listeners, listener{name: "API", config: socketConfig(config, "unix:"+path), scope: scope})
+	}
+	if adminAddr != "" {
+		admin := config
+		admin.Addr = adminAddr
+		if _, ok := admin.SocketPath(); ok {
+			admin = socketConfig(config, adminAddr)
+		}
+		listeners = append(listeners, listener{name: "admin endpoints", config: admin, scope: api.ScopeAdmin})
+	}
+
+	for _, l := range listeners[1:] {
+		if l.config.Addr == config.Addr {
+			log.Fatalf("Invalid listener configuration: %s is already the API port", l.config.Addr)
+		}
+	}
+	return listeners
+}
+
+// socketConfig returns the configuration of a Unix socket listener at addr, without TLS
+func socketConfig(config httpserver.Config, addr string) httpserver.Config {
+	config.Addr = addr
+	config.TLSCertFile, config.TLSKeyFile = "", ""
+	return config
+}
+
+// loadStartupConfig reads dependency wait settings from the environment
+func loadStartupConfig() startupConfig {
+	return startupConfig{
+		maxAttempts:    getEnvInt("STARTUP_MAX_ATTEMPTS", 10),
+		initialBackoff: getEnvDuration("STARTUP_INITIAL_BACKOFF", time.Second),
+		maxBackoff:     getEnvDuration("STARTUP_MAX_BACKOFF", 30*time.Second),
+		checkTimeout:   getEnvDuration("STARTUP_CHECK_TIMEOUT", 5*time.Second),
+	}
+}
+
+// waitForDependencies retries database and Kafka connectivity with bounded backoff,
+// marking each dependency ready once confirmed. The process exits if a dependency
+// is still unreachable after the configured number of attempts.
+func waitForDependencies(readiness *utils.Readiness, dbInterface db.DBInterface, producer *kafka.Producer, cfg startupConfig) {
+	var wg sync.WaitGroup
+
+	wait := func(name string, check func() error) {
+		defer wg.Done()
+
+		log.Printf("Waiting for %s (up to %d attempts)...", name, cfg.maxAttempts)
+		if err := utils.RetryOperationWithBackoff(check, cfg.maxAttempts, cfg.initialBackoff, cfg.maxBackoff); err != nil {
+			log.Fatalf("Dependency %s is unavailable: %v", name, err)
+		}
+
+		readiness.SetReady(name, true)
+		log.Printf("Dependency %s is ready", name)
+	}
+
+	wg.Add(2)
+	go wait(consts.DependencyDatabase, dbInterface.Ping)
+	go wait(consts.DependencyKafka, func() error {
+		if kafka.Mocked() {
+			return nil
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), cfg.checkTimeout)
+		defer cancel()
+		return producer.Ping(ctx)
+	})
+	wg.Wait()
+
+	log.Println("All dependencies are ready")
+}
+
+// backfillBlindIndexes indexes existing rows once the database is reachable
+func backfillBlindIndexes(indexer db.BlindIndexer, cfg startupConfig) {
+	var updated int64
+	err := utils.RetryOperationWithBackoff(func() error {
+		var err error
+		updated, err = indexer.BackfillBlindIndexes()
+		return err
+	}, cfg.maxAttempts, cfg.initialBackoff, cfg.maxBackoff)
+
+	if err != nil {
+		log.Printf("Failed to backfill blind indexes: %v", err)
+		return
+	}
+	if updated > 0 {
+		log.Printf("Backfilled blind indexes for %d rows", updated)
+	}
+}
+
+// loadRetentionPolicy reads the transaction retention policy from the environment
+func loadRetentionPolicy() models.RetentionPolicy {
+	policy := services.DefaultRetentionPolicy()
+	policy.ArchiveAfterDays = getEnvInt("RETENTION_ARCHIVE_AFTER_DAYS", policy.ArchiveAfterDays)
+	policy.BatchSize = getEnvInt("RETENTION_BATCH_SIZE", policy.BatchSize)
+	policy.PurgePII = os.Getenv("RETENTION_PURGE_PII") != "false"
+	return policy
+}
+
+// loadGeoConfig reads the GeoIP and card BIN country sources from the environment
+func loadGeoConfig() (*geo.IPLocator, geo.BINTable) {
+	networks, err := geo.ParseNetworks(os.Getenv("GEOIP_NETWORKS"))
+	if err != nil {
+		log.Fatalf("Invalid GEOIP_NETWORKS: %v", err)
+	}
+
+	binTable, err := geo.ParseBINTable(os.Getenv("CARD_BIN_COUNTRIES"))
+	if err != nil {
+		log.Fatalf("Invalid CARD_BIN_COUNTRIES: %v", err)
+	}
+
+	locator := &geo.IPLocator{
+		CountryHeader: os.Getenv("GEOIP_COUNTRY_HEADER"),
+		Networks:      networks,
+	}
+	return locator, binTable
+}
+
+// loadSanctionsScreener reads the sanctions lists names are screened against: a local CSV dataset
+// or an external API. It returns nil, disabling screening, when neither is configured.
+func loadSanctionsScreener() screening.Screener {
+	if url := os.Getenv("SANCTIONS_API_URL"); url != "" {
+		minScore := 0.85
+		if value := os.Getenv("SANCTIONS_API_MIN_SCORE"); value != "" {
+			var err error
+			if minScore, err = strconv.ParseFloat(value, 64); err != nil || minScore < 0 || minScore > 1 {
+				log.Fatalf("Invalid SANCTIONS_API_MIN_SCORE: %s", value)
+			}
+		}
+		return screening.NewHTTP(url, os.Getenv("SANCTIONS_API_KEY"), minScore)
+	}
+
+	path := os.Getenv("SANCTIONS_LIST_FILE")
+	if path == "" {
+		log.Printf("WARNING: neither SANCTIONS_LIST_FILE nor SANCTIONS_API_URL is set; names are not screened against sanctions lists")
+		return nil
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		log.Fatalf("Failed to open SANCTIONS_LIST_FILE: %v", err)
+	}
+	defer file.Close()
+
+	list, err := screening.LoadList(file)
+	if err != nil {
+		log.Fatalf("Invalid SANCTIONS_LIST_FILE: %v", err)
+	}
+	log.Printf("Loaded %d sanctions list entries from %s", list.Len(), path)
+	return list
+}
+
+// loadISO8583Config reads the card switch connection and field settings from the environment,
+// reporting false when no switch is configured
+func loadISO8583Config() (gateway.ISO8583Config, bool) {
+	address := os.Getenv("ISO8583_SWITCH_ADDRESS")
+	if address == "" {
+		return gateway.ISO8583Config{}, false
+	}
+
+	fields, err := codec.ParseFieldSpecs(os.Getenv("ISO8583_FIELD_SPECS"))
+	if err != nil {
+		log.Fatalf("Invalid ISO8583_FIELD_SPECS: %v", err)
+	}
+
+	staticFields, err := codec.ParseFieldValues(os.Getenv("ISO8583_STATIC_FIELDS"))
+	if err != nil {
+		log.Fatalf("Invalid ISO8583_STATIC_FIELDS: %v", err)
+	}
+
+	return gateway.ISO8583Config{
+		Address:      address,
+		Timeout:      getEnvDuration("ISO8583_TIMEOUT", 30*time.Second),
+		AcquirerID:   os.Getenv("ISO8583_ACQUIRER_ID"),
+		TerminalID:   os.Getenv("ISO8583_TERMINAL_ID"),
+		MerchantID:   os.Getenv("ISO8583_MERCHANT_ID"),
+		Fields:       fields,
+		StaticFields: staticFields,
+
+		CryptogramField: getEnvInt("ISO8583_CRYPTOGRAM_FIELD", gateway.DefaultISO8583CryptogramField),
+	}, true
+}
+
+// loadStripeConfig reads the Stripe provider's webhook and request settings from the environment.
+// Webhook signing secrets are required, as Stripe reports the outcome of payments only in events.
+func loadStripeConfig() gateway.StripeConfig {
+	config := gateway.StripeConfig{
+		WebhookTolerance: getEnvDuration("STRIPE_WEBHOOK_TOLERANCE", gateway.DefaultStripeWebhookTolerance),
+		Timeout:          getEnvDuration("STRIPE_TIMEOUT", 30*time.Second),
+	}
+	for _, secret := range strings.Split(os.Getenv("STRIPE_WEBHOOK_SECRETS"), ",") {
+		if secret = strings.TrimSpace(secret); secret != "" {
+			config.WebhookSecrets = append(config.WebhookSecrets, secret)
+		}
+	}
+	for _, method := range strings.Split(os.Getenv("STRIPE_PAYMENT_METHOD_TYPES"), ",") {
+		if method = strings.TrimSpace(method); method != "" {
+			config.PaymentMethodTypes = append(config.PaymentMethodTypes, method)
+		}
+	}
+
+	if len(config.WebhookSecrets) == 0 {
+		log.Fatalf("STRIPE_WEBHOOK_SECRETS must be set when the Stripe gateway calls Stripe's API")
+	}
+	return config
+}
+
+// loadAdyenConfig reads the Adyen provider's account, webhook and payment settings from the
+// environment. HMAC keys are required, as Adyen reports the outcome of payments in notifications.
+func loadAdyenConfig() gateway.AdyenConfig {
+	config := gateway.AdyenConfig{
+		MerchantAccount:   os.Getenv("ADYEN_MERCHANT_ACCOUNT"),
+		LiveURLPrefix:     os.Getenv("ADYEN_LIVE_URL_PREFIX"),
+		PaymentMethodType: os.Getenv("ADYEN_PAYMENT_METHOD_TYPE"),
+		ReturnURL:         os.Getenv("ADYEN_RETURN_URL"),
+		Timeout:           getEnvDuration("ADYEN_TIMEOUT", 30*time.Second),
+	}
+	for _, key := range strings.Split(os.Getenv("ADYEN_HMAC_KEYS"), ",") {
+		if key = strings.TrimSpace(key); key != "" {
+			if _, err := hex.DecodeString(key); err != nil {
+				log.Fatalf("Invalid ADYEN_HMAC_KEYS: keys must be hex-encoded")
+			}
+			config.HMACKeys = append(config.HMACKeys, key)
+		}
+	}
+	for _, wallet := range strings.Split(os.Getenv("ADYEN_WALLET_TOKENS"), ",") {
+		switch wallet = strings.TrimSpace(wallet); wallet {
+		case "":
+		case consts.WalletApplePay, consts.WalletGooglePay:
+			config.WalletTokens = append(config.WalletTokens, wallet)
+		default:
+			log.Fatalf("Invalid ADYEN_WALLET_TOKENS: unknown wallet %q", wallet)
+		}
+	}
+
+	if config.MerchantAccount == "" || config.PaymentMethodType == "" || len(config.HMACKeys) == 0 {
+		log.Fatalf("ADYEN_MERCHANT_ACCOUNT, ADYEN_PAYMENT_METHOD_TYPE and ADYEN_HMAC_KEYS must be set when the Adyen gateway calls Adyen's APIs")
+	}
+	return config
+}
+
+// loadWalletTokenDecrypter reads the roots of trust wallet payment tokens are verified against from
+// the environment: Apple's root CA certificates and Google's published root signing keys, with the
+// recipient ID Google Pay tokens are signed for. Tokens of a wallet without them can't be decrypted.
+func loadWalletTokenDecrypter() *paytoken.Decrypter {
+	decrypter := &paytoken.Decrypter{GoogleRecipientID: os.Getenv("GOOGLE_PAY_RECIPIENT_ID")}
+
+	if path := os.Getenv("APPLE_PAY_ROOT_CA_FILE"); path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			log.Fatalf("Failed to read APPLE_PAY_ROOT_CA_FILE: %v", err)
+		}
+		decrypter.AppleRoots = x509.NewCertPool()
+		if !decrypter.AppleRoots.AppendCertsFromPEM(data) {
+			log.Fatalf("Invalid APPLE_PAY_ROOT_CA_FILE: no PEM certificates")
+		}
+	}
+
+	if path := os.Getenv("GOOGLE_PAY_ROOT_KEYS_FILE"); path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			log.Fatalf("Failed to read GOOGLE_PAY_ROOT_KEYS_FILE: %v", err)
+		}
+		if decrypter.GoogleRootKeys, err = paytoken.ParseGoogleRootKeys(data, time.Now()); err != nil {
+			log.Fatalf("Invalid GOOGLE_PAY_ROOT_KEYS_FILE: %v", err)
+		}
+		if decrypter.GoogleRecipientID == "" {
+			log.Fatalf("GOOGLE_PAY_RECIPIENT_ID must be set when GOOGLE_PAY_ROOT_KEYS_FILE is")
+		}
+	}
+
+	return decrypter
+}
+
+// loadMpesaConfig reads the M-Pesa provider's short code, STK Push and B2C settings from the
+// environment. ok is false when no short code is configured.
+func loadMpesaConfig() (config gateway.MpesaConfig, ok bool) {
+	config = gateway.MpesaConfig{
+		ShortCode:          os.Getenv("MPESA_SHORT_CODE"),
+		PassKey:            os.Getenv("MPESA_PASS_KEY"),
+		TransactionType:    os.Getenv("MPESA_TRANSACTION_TYPE"),
+		InitiatorName:      os.Getenv("MPESA_INITIATOR_NAME"),
+		SecurityCredential: os.Getenv("MPESA_SECURITY_CREDENTIAL"),
+		CommandID:          os.Getenv("MPESA_COMMAND_ID"),
+		CallbackURL:        os.Getenv("MPESA_CALLBACK_URL"),
+		Timeout:            getEnvDuration("MPESA_TIMEOUT", 30*time.Second),
+	}
+	if config.ShortCode == "" {
+		return config, false
+	}
+
+	if config.PassKey == "" || config.CallbackURL == "" {
+		log.Fatalf("MPESA_PASS_KEY and MPESA_CALLBACK_URL must be set with MPESA_SHORT_CODE")
+	}
+	if config.InitiatorName == "" || config.SecurityCredential == "" {
+		log.Println("MPESA_INITIATOR_NAME or MPESA_SECURITY_CREDENTIAL is not set; M-Pesa withdrawals will be refused")
+	}
+	return config, true
+}
+
+// loadCoinbaseCommerceConfig reads the Coinbase Commerce provider's webhook and redirect settings
+// from the environment. ok is false when no webhook secret is configured, as charges are only
+// settled by webhook events.
+func loadCoinbaseCommerceConfig() (config gateway.CoinbaseCommerceConfig, ok bool) {
+	config = gateway.CoinbaseCommerceConfig{
+		RedirectURL: os.Getenv("COINBASE_COMMERCE_REDIRECT_URL"),
+		CancelURL:   os.Getenv("COINBASE_COMMERCE_CANCEL_URL"),
+		Timeout:     getEnvDuration("COINBASE_COMMERCE_TIMEOUT", 30*time.Second),
+	}
+	for _, secret := range strings.Split(os.Getenv("COINBASE_COMMERCE_WEBHOOK_SECRETS"), ",") {
+		if secret = strings.TrimSpace(secret); secret != "" {
+			config.WebhookSecrets = append(config.WebhookSecrets, secret)
+		}
+	}
+	return config, len(config.WebhookSecrets) > 0
+}
+
+// loadTrueLayerConfig reads the TrueLayer provider's merchant accounts, as TRUELAYER_MERCHANT_ACCOUNTS
+// "GBP=<id>,EUR=<id>", and its request signing key from the environment. ok is false when no
+// merchant account is configured, as payouts are paid from one.
+func loadTrueLayerConfig() (config gateway.TrueLayerConfig, ok bool) {
+	config = gateway.TrueLayerConfig{
+		MerchantAccountIDs: make(map[string]string),
+		SigningKeyID:       os.Getenv("TRUELAYER_SIGNING_KEY_ID"),
+		Providers:          os.Getenv("TRUELAYER_PROVIDERS"),
+		Timeout:            getEnvDuration("TRUELAYER_TIMEOUT", 30*time.Second),
+	}
+	for _, pair := range strings.Split(os.Getenv("TRUELAYER_MERCHANT_ACCOUNTS"), ",") {
+		currency, id, found := strings.Cut(strings.TrimSpace(pair), "=")
+		if found && currency != "" && id != "" {
+			config.MerchantAccountIDs[strings.ToUpper(currency)] = id
+		}
+	}
+	if len(config.MerchantAccountIDs) == 0 {
+		return config, false
+	}
+
+	keyFile := os.Getenv("TRUELAYER_SIGNING_KEY_FILE")
+	if keyFile == "" || config.SigningKeyID == "" {
+		log.Println("TRUELAYER_SIGNING_KEY_FILE or TRUELAYER_SIGNING_KEY_ID is not set; TrueLayer payouts will be refused")
+		return config, true
+	}
+	data, err := os.ReadFile(keyFile)
+	if err != nil {
+		log.Fatalf("Failed to read TRUELAYER_SIGNING_KEY_FILE: %v", err)
+	}
+	if config.SigningKey, err = gateway.ParseTrueLayerSigningKey(data); err != nil {
+		log.Fatalf("Invalid TRUELAYER_SIGNING_KEY_FILE: %v", err)
+	}
+	return config, true
+}
+
+// loadSEPAConfig reads the SEPA provider's creditor identity, account and webhook secrets from the
+// environment. ok is false when no creditor identifier or IBAN is configured, as every debit and
+// credit transfer names them.
+func loadSEPAConfig() (config gateway.SEPAConfig, ok bool) {
+	config = gateway.SEPAConfig{
+		CreditorID:   os.Getenv("SEPA_CREDITOR_ID"),
+		CreditorName: os.Getenv("SEPA_CREDITOR_NAME"),
+		IBAN:         os.Getenv("SEPA_IBAN"),
+		BIC:          os.Getenv("SEPA_BIC"),
+		Timeout:      getEnvDuration("SEPA_TIMEOUT", 30*time.Second),
+	}
+	for _, secret := range strings.Split(os.Getenv("SEPA_WEBHOOK_SECRETS"), ",") {
+		if secret = strings.TrimSpace(secret); secret != "" {
+			config.WebhookSecrets = append(config.WebhookSecrets, secret)
+		}
+	}
+	if config.CreditorID == "" || config.IBAN == "" {
+		return config, false
+	}
+	if len(config.WebhookSecrets) == 0 {
+		log.Println("SEPA_WEBHOOK_SECRETS is not set; SEPA notifications will be refused")
+	}
+	return config, true
+}
+
+// loadPixConfig reads the PIX provider's key, merchant identity, charge expiry and webhook secrets
+// from the environment. ok is false when no PIX key is configured, as every charge is paid to it.
+func loadPixConfig() (config gateway.PixConfig, ok bool) {
+	config = gateway.PixConfig{
+		Key:          os.Getenv("PIX_KEY"),
+		MerchantName: os.Getenv("PIX_MERCHANT_NAME"),
+		MerchantCity: os.Getenv("PIX_MERCHANT_CITY"),
+		Expiry:       getEnvDuration("PIX_EXPIRY", consts.DefaultPixExpiry),
+		Timeout:      getEnvDuration("PIX_TIMEOUT", 30*time.Second),
+	}
+	for _, secret := range strings.Split(os.Getenv("PIX_WEBHOOK_SECRETS"), ",") {
+		if secret = strings.TrimSpace(secret); secret != "" {
+			config.WebhookSecrets = append(config.WebhookSecrets, secret)
+		}
+	}
+	if config.Key == "" {
+		return config, false
+	}
+	if len(config.WebhookSecrets) == 0 {
+		log.Println("PIX_WEBHOOK_SECRETS is not set; PIX payment notifications will be refused")
+	}
+	return config, true
+}
+
+// loadUPIConfig reads the UPI provider's merchant VPA and identity, payment window, poll delay and
+// webhook secrets from the environment. ok is false when no merchant VPA is configured, as every
+// payment is made to it.
+func loadUPIConfig() (config gateway.UPIConfig, ok bool) {
+	config = gateway.UPIConfig{
+		MerchantVPA:     os.Getenv("UPI_MERCHANT_VPA"),
+		MerchantName:    os.Getenv("UPI_MERCHANT_NAME"),
+		MerchantCode:    os.Getenv("UPI_MERCHANT_CODE"),
+		Expiry:          getEnvDuration("UPI_EXPIRY", consts.DefaultUPIExpiry),
+		StatusPollDelay: getEnvDuration("UPI_STATUS_POLL_DELAY", consts.DefaultUPIStatusPollDelay),
+		Timeout:         getEnvDuration("UPI_TIMEOUT", 30*time.Second),
+	}
+	for _, secret := range strings.Split(os.Getenv("UPI_WEBHOOK_SECRETS"), ",") {
+		if secret = strings.TrimSpace(secret); secret != "" {
+			config.WebhookSecrets = append(config.WebhookSecrets, secret)
+		}
+	}
+	if config.MerchantVPA == "" {
+		return config, false
+	}
+	if len(config.WebhookSecrets) == 0 {
+		log.Println("UPI_WEBHOOK_SECRETS is not set; UPI callbacks will be refused and deposits settled by polling only")
+	}
+	return config, true
+}
+
+// loadOAuthConfig reads the token endpoint settings and the trusted identity provider from the
+// environment
+func loadOAuthConfig() services.OAuthConfig {
+	config := services.OAuthConfig{
+		Issuer:   getEnvOrDefault("OAUTH_ISSUER", "payment-gateway"),
+		TokenTTL: getEnvDuration("OAUTH_TOKEN_TTL", consts.OAuthTokenTTL),
+	}
+
+	if keyHex := os.Getenv("OAUTH_SIGNING_KEY"); keyHex != "" {
+		key, err := hex.DecodeString(keyHex)
+		if err != nil || len(key) < 32 {
+			log.Fatalf("Invalid OAUTH_SIGNING_KEY: must be at least 32 hex-encoded bytes")
+		}
+		config.SigningKey = key
+	} else {
+		config.SigningKey = utils.DeriveKey("oauth-signing")
+	}
+
+	if spec := os.Getenv("OAUTH_SCOPE_ROLES"); spec != "" {
+		scopeRoles, err := auth.ParseScopeRoles(spec)
+		if err != nil {
+			log.Fatalf("Invalid OAUTH_SCOPE_ROLES: %v", err)
+		}
+		config.ScopeRoles = scopeRoles
+	}
+
+	if jwksURL := os.Getenv("OAUTH_JWKS_URL"); jwksURL != "" {
+		issuer := os.Getenv("OAUTH_JWKS_ISSUER")
+		if issuer == "" {
+			log.Fatalf("OAUTH_JWKS_ISSUER is required with OAUTH_JWKS_URL")
+		}
+		config.ExternalIssuers = append(config.ExternalIssuers, auth.Issuer{
+			Name:          issuer,
+			Audience:      os.Getenv("OAUTH_AUDIENCE"),
+			JWKS:          auth.NewJWKS(jwksURL),
+			MerchantClaim: os.Getenv("OAUTH_MERCHANT_CLAIM"),
+		})
+	}
+
+	return config
+}
+
+// getEnvInt returns an integer environment variable or a default value
+func getEnvInt(key string, defaultValue int) int {
+	value, err := strconv.Atoi(os.Getenv(key))
+	if err != nil || value <= 0 {
+		return defaultValue
+	}
+	return value
+}
+
+// getEnvFloat returns a decimal environment variable (e.g. "2500.50") or a default value
+func getEnvFloat(key string, defaultValue float64) float64 {
+	value, err := strconv.ParseFloat(os.Getenv(key), 64)
+	if err != nil || value <= 0 {
+		return defaultValue
+	}
+	return value
+}
+
+// getEnvDuration returns a duration environment variable (e.g. "2s") or a default value
+func getEnvDuration(key string, defaultValue time.Duration) time.Duration {
+	value, err := time.ParseDuration(os.Getenv(key))
+	if err != nil || value <= 0 {
+		return defaultValue
+	}
+	return value
+}
+
 // getEnvOrDefault returns the value of an environment variable or a default value
 func getEnvOrDefault(key, defaultValue string) string {
 	value := os.Getenv(key)
@@ -0,0 +1,88 @@
+package db
+
+import (
+	"fmt"
+	"payment-gateway/internal/utils"
+)
+
+// blindIndexBatchSize is the number of rows re-indexed per database round trip
+const blindIndexBatchSize = 500
+
+// blindIndexedColumns lists the columns that have a blind index kept alongside them
+var blindIndexedColumns = []struct {
+	table, column, hashColumn string
+}{
+	{"users", "email", "email_hash"},
+	{"transactions", "reference_id", "reference_hash"},
+	{"transactions", "gateway_reference", "gateway_reference_hash"},
+}
+
+// BlindIndexer is implemented by databases that store blind indexes of searchable fields
+// alongside the values themselves
+type BlindIndexer interface {
+	// BackfillBlindIndexes computes blind indexes for rows that don't have one or whose index
+	// was computed with a key that is no longer current, returning the number of rows updated
+	BackfillBlindIndexes() (int64, error)
+}
+
+// BackfillBlindIndexes indexes rows written before blind indexes were maintained and re-indexes
+// rows hashed with a rotated-out key, so that key can be removed once this completes
+func (p *PostgresDB) BackfillBlindIndexes() (int64, error) {
+	var total int64
+
+	for _, c := range blindIndexedColumns {
+		for {
+			updated, err := p.reindexBatch(c.table, c.column, c.hashColumn)
+			total += updated
+			if err != nil {
+				return total, err
+			}
+			if updated < blindIndexBatchSize {
+				break
+			}
+		}
+	}
+
+	return total, nil
+}
+
+// reindexBatch recomputes the blind index of up to blindIndexBatchSize stale rows of a table
+func (p *PostgresDB) reindexBatch(table, column, hashColumn string) (int64, error) {
+	query := fmt.Sprintf(`
+		SELECT id, %[2]s FROM %[1]s
+		WHERE %[2]s IS NOT NULL AND (%[3]s IS NULL OR %[3]s NOT LIKE $1 || '%%')
+		LIMIT $2
+	`, table, column, hashColumn)
+
+	rows, err := p.db.Query(query, utils.CurrentBlindIndexPrefix(), blindIndexBatchSize)
+	if err != nil {
+		return 0, fmt.Errorf("failed to fetch %s without a current blind index: %w", table, err)
+	}
+
+	hashes := make(map[int]string)
+	for rows.Next() {
+		var id int
+		var value string
+		if err := rows.Scan(&id, &value); err != nil {
+			rows.Close()
+			return 0, fmt.Errorf("failed to scan %s: %w", table, err)
+		}
+		hashes[id] = utils.BlindIndex(value)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, fmt.Errorf("error iterating %s: %w", table, err)
+	}
+
+	update := fmt.Sprintf(`UPDATE %s SET %s = $1 WHERE id = $2`, table, hashColumn)
+
+	var updated int64
+	for id, hash := range hashes {
+		if _, err := p.db.Exec(update, hash, id); err != nil {
+			return updated, fmt.Errorf("failed to update blind index of %s %d: %w", table, id, err)
+		}
+		updated++
+	}
+
+	return updated, nil
+}
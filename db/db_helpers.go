@@ -2,11 +2,14 @@ package db
 
 import (
 	"database/sql"
+	"encoding/json"
 	"fmt"
+	"payment-gateway/internal/consts"
 	"payment-gateway/internal/models"
+	"payment-gateway/internal/utils"
 	"time"
 
-	_ "github.com/lib/pq"
+	"github.com/lib/pq"
 )
 
 // PostgresDB implements DBInterface using PostgreSQL
@@ -16,6 +19,23 @@ type PostgresDB struct {
 
 // NewPostgresDB creates a new PostgreSQL database connection
 func NewPostgresDB(dataSourceName string) (*PostgresDB, error) {
+	postgresDB, err := OpenPostgresDB(dataSourceName)
+	if err != nil {
+		return nil, err
+	}
+
+	// Validate connection
+	if err := postgresDB.Ping(); err != nil {
+		postgresDB.Close()
+		return nil, fmt.Errorf("failed to ping database: %w", err)
+	}
+
+	return postgresDB, nil
+}
+
+// OpenPostgresDB prepares a PostgreSQL connection pool without validating connectivity,
+// so callers can wait for the database to become reachable
+func OpenPostgresDB(dataSourceName string) (*PostgresDB, error) {
 	db, err := sql.Open("postgres", dataSourceName)
 	if err != nil {
 		return nil, fmt.Errorf("failed to open database connection: %w", err)
@@ -26,23 +46,21 @@ func NewPostgresDB(dataSourceName string) (*PostgresDB, error) {
 	db.SetMaxIdleConns(5)
 	db.SetConnMaxLifetime(5 * time.Minute)
 
-	// Validate connection
-	if err := db.Ping(); err != nil {
-		return nil, fmt.Errorf("failed to ping database: %w", err)
-	}
-
 	return &PostgresDB{db: db}, nil
 }
 
 // GetUserByID fetches a user by ID
 func (p *PostgresDB) GetUserByID(userID int) (*models.User, error) {
 	query := `
-		SELECT id, username, email, country_id, created_at, updated_at 
+		SELECT id, username, email, country_id, merchant_id, phone, phone_e164, postal_code, postal_code_normalized,
+			   full_name, screening_status, created_at, updated_at
 		FROM users 
 		WHERE id = $1
 	`
 
 	var user models.User
+	var merchantID sql.NullInt64
+	var phone, phoneE164, postalCode, postalCodeNormalized, fullName, screeningStatus sql.NullString
 	var updatedAt sql.NullTime
 
 	err := p.db.QueryRow(query, userID).Scan(
@@ -50,6 +68,13 @@ func (p *PostgresDB) GetUserByID(userID int) (*models.User, error) {
 		&user.Username,
 		&user.Email,
 		&user.CountryID,
+		&merchantID,
+		&phone,
+		&phoneE164,
+		&postalCode,
+		&postalCodeNormalized,
+		&fullName,
+		&screeningStatus,
 		&user.CreatedAt,
 		&updatedAt,
 	)
@@ -61,6 +86,15 @@ func (p *PostgresDB) GetUserByID(userID int) (*models.User, error) {
 		return nil, fmt.Errorf("failed to fetch user: %w", err)
 	}
 
+	if merchantID.Valid {
+		user.MerchantID = int(merchantID.Int64)
+	}
+	user.Phone = phone.String
+	user.PhoneE164 = phoneE164.String
+	user.PostalCode = postalCode.String
+	user.PostalCodeNormalized = postalCodeNormalized.String
+	user.FullName = fullName.String
+	user.ScreeningStatus = screeningStatus.String
 	if updatedAt.Valid {
 		user.UpdatedAt = updatedAt.Time
 	}
@@ -68,203 +102,5357 @@ func (p *PostgresDB) GetUserByID(userID int) (*models.User, error) {
 	return &user, nil
 }
 
-// GetSupportedGatewaysByCountry fetches gateways supported for a country
-func (p *PostgresDB) GetSupportedGatewaysByCountry(countryID int) ([]models.Gateway, error) {
+// CreateUser stores a new user with the blind index of their email
+func (p *PostgresDB) CreateUser(user models.User) (int, error) {
 	query := `
-		SELECT g.id, g.name, g.data_format_supported, g.created_at, g.updated_at
-		FROM gateways g
-		JOIN gateway_countries gc ON g.id = gc.gateway_id
-		WHERE gc.country_id = $1
-		ORDER BY gc.priority
+		INSERT INTO users (username, email, email_hash, country_id, merchant_id, full_name, screening_status, created_at, updated_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $8)
+		RETURNING id
 	`
 
-	rows, err := p.db.Query(query, countryID)
+	var id int
+	err := p.db.QueryRow(query,
+		user.Username,
+		user.Email,
+		utils.BlindIndex(user.Email),
+		user.CountryID,
+		sql.NullInt64{Int64: int64(user.MerchantID), Valid: user.MerchantID != 0},
+		sql.NullString{String: user.FullName, Valid: user.FullName != ""},
+		sql.NullString{String: user.ScreeningStatus, Valid: user.ScreeningStatus != ""},
+		user.CreatedAt,
+	).Scan(&id)
 	if err != nil {
-		return nil, fmt.Errorf("failed to fetch gateways: %w", err)
+		return 0, fmt.Errorf("failed to create user: %w", err)
 	}
-	defer rows.Close()
 
-	var gateways []models.Gateway
-	for rows.Next() {
-		var gateway models.Gateway
-		var updatedAt sql.NullTime
+	return id, nil
+}
 
-		if err := rows.Scan(
-			&gateway.ID,
-			&gateway.Name,
-			&gateway.DataFormatSupported,
-			&gateway.CreatedAt,
-			&updatedAt,
-		); err != nil {
-			return nil, fmt.Errorf("failed to scan gateway: %w", err)
-		}
+// UpdateUserScreeningStatus sets a user's sanctions screening status
+func (p *PostgresDB) UpdateUserScreeningStatus(userID int, status string) error {
+	query := `
+		UPDATE users
+		SET screening_status = $1, updated_at = CURRENT_TIMESTAMP
+		WHERE id = $2
+	`
 
-		if updatedAt.Valid {
-			gateway.UpdatedAt = updatedAt.Time
+	result, err := p.db.Exec(query, status, userID)
+	if err != nil {
+		return fmt.Errorf("failed to update user screening status: %w", err)
+	}
+
+	if rows, err := result.RowsAffected(); err == nil && rows == 0 {
+		return fmt.Errorf("user not found: %w", sql.ErrNoRows)
+	}
+
+	return nil
+}
+
+// UpdateUserContact updates a user's contact details
+func (p *PostgresDB) UpdateUserContact(userID int, contact models.UserContact) error {
+	query := `
+		UPDATE users
+		SET phone = $1, phone_e164 = $2, postal_code = $3, postal_code_normalized = $4, updated_at = CURRENT_TIMESTAMP
+		WHERE id = $5
+	`
+
+	result, err := p.db.Exec(
+		query,
+		sql.NullString{String: contact.Phone, Valid: contact.Phone != ""},
+		sql.NullString{String: contact.PhoneE164, Valid: contact.PhoneE164 != ""},
+		sql.NullString{String: contact.PostalCode, Valid: contact.PostalCode != ""},
+		sql.NullString{String: contact.PostalCodeNormalized, Valid: contact.PostalCodeNormalized != ""},
+		userID,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to update user contact: %w", err)
+	}
+
+	if rows, err := result.RowsAffected(); err == nil && rows == 0 {
+		return fmt.Errorf("user not found: %w", sql.ErrNoRows)
+	}
+
+	return nil
+}
+
+// GetMerchantByID fetches a merchant by ID
+func (p *PostgresDB) GetMerchantByID(merchantID int) (*models.Merchant, error) {
+	query := `
+		SELECT id, name, allowed_redirect_domains, webhook_url, webhook_secret, livemode, created_at, updated_at
+		FROM merchants
+		WHERE id = $1
+	`
+
+	var merchant models.Merchant
+	var webhookURL, webhookSecret sql.NullString
+	var updatedAt sql.NullTime
+
+	err := p.db.QueryRow(query, merchantID).Scan(
+		&merchant.ID,
+		&merchant.Name,
+		pq.Array(&merchant.AllowedRedirectDomains),
+		&webhookURL,
+		&webhookSecret,
+		&merchant.Livemode,
+		&merchant.CreatedAt,
+		&updatedAt,
+	)
+
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, fmt.Errorf("merchant not found: %w", err)
 		}
+		return nil, fmt.Errorf("failed to fetch merchant: %w", err)
+	}
 
-		gateways = append(gateways, gateway)
+	merchant.WebhookURL = webhookURL.String
+	merchant.WebhookSecret = webhookSecret.String
+	if updatedAt.Valid {
+		merchant.UpdatedAt = updatedAt.Time
 	}
 
-	if err := rows.Err(); err != nil {
-		return nil, fmt.Errorf("error iterating gateways: %w", err)
+	return &merchant, nil
+}
+
+// SetMerchantWebhookSecret replaces the encrypted secret a merchant's webhooks are signed with
+func (p *PostgresDB) SetMerchantWebhookSecret(merchantID int, secret string) error {
+	query := `
+		UPDATE merchants
+		SET webhook_secret = $1, updated_at = CURRENT_TIMESTAMP
+		WHERE id = $2
+	`
+
+	result, err := p.db.Exec(query, secret, merchantID)
+	if err != nil {
+		return fmt.Errorf("failed to update merchant webhook secret: %w", err)
+	}
+	if rows, err := result.RowsAffected(); err == nil && rows == 0 {
+		return fmt.Errorf("merchant not found: %w", sql.ErrNoRows)
 	}
 
-	return gateways, nil
+	return nil
 }
 
-// GetGatewaysByPriority fetches gateways with their priorities for a country
-func (p *PostgresDB) GetGatewaysByPriority(countryID int) ([]models.GatewayPriority, error) {
+// SetMerchantLivemode switches a merchant between gateways' sandbox and production environments
+func (p *PostgresDB) SetMerchantLivemode(merchantID int, livemode bool) error {
 	query := `
-		SELECT g.id, g.name, g.data_format_supported, gc.priority 
-		FROM gateways g
-		JOIN gateway_countries gc ON g.id = gc.gateway_id
-		WHERE gc.country_id = $1
-		ORDER BY gc.priority
+		UPDATE merchants
+		SET livemode = $1, updated_at = CURRENT_TIMESTAMP
+		WHERE id = $2
 	`
 
-	rows, err := p.db.Query(query, countryID)
+	result, err := p.db.Exec(query, livemode, merchantID)
 	if err != nil {
-		return nil, fmt.Errorf("failed to fetch gateway priorities: %w", err)
+		return fmt.Errorf("failed to update merchant livemode: %w", err)
+	}
+	if rows, err := result.RowsAffected(); err == nil && rows == 0 {
+		return fmt.Errorf("merchant not found: %w", sql.ErrNoRows)
+	}
+
+	return nil
+}
+
+// GetRoutingRules fetches a merchant's routing rules in position order
+func (p *PostgresDB) GetRoutingRules(merchantID int) ([]models.RoutingRule, error) {
+	query := `
+		SELECT position, field, operator, value, action, gateway_id
+		FROM merchant_routing_rules
+		WHERE merchant_id = $1
+		ORDER BY position
+	`
+
+	rows, err := p.db.Query(query, merchantID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch routing rules: %w", err)
 	}
 	defer rows.Close()
 
-	var gateways []models.GatewayPriority
+	var rules []models.RoutingRule
 	for rows.Next() {
-		var gw models.GatewayPriority
-		if err := rows.Scan(
-			&gw.GatewayID,
-			&gw.Name,
-			&gw.Format,
-			&gw.Priority,
-		); err != nil {
-			return nil, fmt.Errorf("failed to scan gateway priority: %w", err)
+		var rule models.RoutingRule
+		if err := rows.Scan(&rule.Position, &rule.Field, &rule.Operator, &rule.Value, &rule.Action, &rule.GatewayID); err != nil {
+			return nil, fmt.Errorf("failed to scan routing rule: %w", err)
 		}
-		gateways = append(gateways, gw)
+		rules = append(rules, rule)
 	}
 
 	if err := rows.Err(); err != nil {
-		return nil, fmt.Errorf("error iterating gateway priorities: %w", err)
+		return nil, fmt.Errorf("error iterating routing rules: %w", err)
 	}
 
-	return gateways, nil
+	return rules, nil
 }
 
-// CreateTransaction creates a new transaction record
-func (p *PostgresDB) CreateTransaction(transaction models.Transaction) (int, error) {
-	query := `
-		INSERT INTO transactions (
-			amount, currency, type, status, user_id, gateway_id, country_id, created_at
-		) VALUES ($1, $2, $3, $4, $5, $6, $7, $8) 
-		RETURNING id
-	`
+// ReplaceRoutingRules replaces all of a merchant's routing rules in a single transaction
+func (p *PostgresDB) ReplaceRoutingRules(merchantID int, rules []models.RoutingRule) error {
+	tx, err := p.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin routing rules transaction: %w", err)
+	}
+	defer tx.Rollback()
 
-	var id int
-	err := p.db.QueryRow(
-		query,
-		transaction.Amount,
-		transaction.Currency,
-		transaction.Type,
-		transaction.Status,
-		transaction.UserID,
-		transaction.GatewayID,
-		transaction.CountryID,
-		transaction.CreatedAt,
-	).Scan(&id)
+	if _, err := tx.Exec(`DELETE FROM merchant_routing_rules WHERE merchant_id = $1`, merchantID); err != nil {
+		return fmt.Errorf("failed to delete routing rules: %w", err)
+	}
 
-	if err != nil {
-		return 0, fmt.Errorf("failed to create transaction: %w", err)
+	query := `
+		INSERT INTO merchant_routing_rules (merchant_id, position, field, operator, value, action, gateway_id)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+	`
+	for _, rule := range rules {
+		if _, err := tx.Exec(query, merchantID, rule.Position, rule.Field, rule.Operator, rule.Value, rule.Action, rule.GatewayID); err != nil {
+			return fmt.Errorf("failed to create routing rule: %w", err)
+		}
 	}
 
-	return id, nil
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit routing rules: %w", err)
+	}
+	return nil
 }
 
-// GetTransactionByID fetches a transaction by ID
-func (p *PostgresDB) GetTransactionByID(transactionID int) (*models.Transaction, error) {
+// GetPayoutSchedule fetches a merchant's payout schedule
+func (p *PostgresDB) GetPayoutSchedule(merchantID int) (*models.PayoutSchedule, error) {
 	query := `
-		SELECT id, amount, currency, type, status, user_id, gateway_id, country_id, 
-			   reference_id, error_message, created_at, updated_at
-		FROM transactions
-		WHERE id = $1
+		SELECT merchant_id, frequency, weekday, payout_time, cutoff_time, updated_at
+		FROM payout_schedules
+		WHERE merchant_id = $1
 	`
 
-	var tx models.Transaction
-	var referenceID, errorMessage sql.NullString
-	var updatedAt sql.NullTime
+	var schedule models.PayoutSchedule
+	var weekday sql.NullString
 
-	err := p.db.QueryRow(query, transactionID).Scan(
-		&tx.ID,
-		&tx.Amount,
-		&tx.Currency,
-		&tx.Type,
-		&tx.Status,
-		&tx.UserID,
-		&tx.GatewayID,
-		&tx.CountryID,
-		&referenceID,
-		&errorMessage,
-		&tx.CreatedAt,
-		&updatedAt,
+	err := p.db.QueryRow(query, merchantID).Scan(
+		&schedule.MerchantID,
+		&schedule.Frequency,
+		&weekday,
+		&schedule.PayoutTime,
+		&schedule.CutoffTime,
+		&schedule.UpdatedAt,
 	)
 
 	if err != nil {
 		if err == sql.ErrNoRows {
-			return nil, fmt.Errorf("transaction not found: %w", err)
+			return nil, fmt.Errorf("payout schedule not found: %w", err)
 		}
-		return nil, fmt.Errorf("failed to fetch transaction: %w", err)
+		return nil, fmt.Errorf("failed to fetch payout schedule: %w", err)
 	}
 
-	if referenceID.Valid {
-		tx.ReferenceID = referenceID.String
+	schedule.Weekday = weekday.String
+	return &schedule, nil
+}
+
+// SavePayoutSchedule creates or replaces a merchant's payout schedule
+func (p *PostgresDB) SavePayoutSchedule(schedule models.PayoutSchedule) error {
+	query := `
+		INSERT INTO payout_schedules (merchant_id, frequency, weekday, payout_time, cutoff_time, updated_at)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		ON CONFLICT (merchant_id) DO UPDATE
+		SET frequency = EXCLUDED.frequency, weekday = EXCLUDED.weekday, payout_time = EXCLUDED.payout_time,
+			cutoff_time = EXCLUDED.cutoff_time, updated_at = EXCLUDED.updated_at
+	`
+
+	_, err := p.db.Exec(
+		query,
+		schedule.MerchantID,
+		schedule.Frequency,
+		sql.NullString{String: schedule.Weekday, Valid: schedule.Weekday != ""},
+		schedule.PayoutTime,
+		schedule.CutoffTime,
+		schedule.UpdatedAt,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to save payout schedule: %w", err)
 	}
-	if errorMessage.Valid {
-		tx.ErrorMessage = errorMessage.String
+
+	return nil
+}
+
+// DeletePayoutSchedule removes a merchant's payout schedule
+func (p *PostgresDB) DeletePayoutSchedule(merchantID int) error {
+	result, err := p.db.Exec(`DELETE FROM payout_schedules WHERE merchant_id = $1`, merchantID)
+	if err != nil {
+		return fmt.Errorf("failed to delete payout schedule: %w", err)
 	}
-	if updatedAt.Valid {
-		tx.UpdatedAt = updatedAt.Time
+	if rows, err := result.RowsAffected(); err == nil && rows == 0 {
+		return fmt.Errorf("payout schedule not found: %w", sql.ErrNoRows)
 	}
 
-	return &tx, nil
+	return nil
+}
+
+// GetMerchantIDs fetches the IDs of every merchant in ascending order
+func (p *PostgresDB) GetMerchantIDs() ([]int, error) {
+	rows, err := p.db.Query(`SELECT id FROM merchants ORDER BY id`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch merchants: %w", err)
+	}
+	defer rows.Close()
+
+	var ids []int
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			return nil, fmt.Errorf("failed to scan merchant: %w", err)
+		}
+		ids = append(ids, id)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating merchants: %w", err)
+	}
+
+	return ids, nil
 }
 
-// UpdateTransactionStatus updates a transaction's status
-func (p *PostgresDB) UpdateTransactionStatus(txID int, status, errorMsg string) error {
+// GetMerchantFees fetches a merchant's fees by currency
+func (p *PostgresDB) GetMerchantFees(merchantID int) ([]models.MerchantFee, error) {
 	query := `
-		UPDATE transactions
-		SET status = $1, error_message = $2, updated_at = CURRENT_TIMESTAMP
-		WHERE id = $3
+		SELECT currency, percent, fixed, updated_at
+		FROM merchant_fees
+		WHERE merchant_id = $1
+		ORDER BY currency
 	`
 
-	_, err := p.db.Exec(query, status, errorMsg, txID)
+	rows, err := p.db.Query(query, merchantID)
 	if err != nil {
-		return fmt.Errorf("failed to update transaction status: %w", err)
+		return nil, fmt.Errorf("failed to fetch merchant fees: %w", err)
+	}
+	defer rows.Close()
+
+	var fees []models.MerchantFee
+	for rows.Next() {
+		var fee models.MerchantFee
+		if err := rows.Scan(&fee.Currency, &fee.Percent, &fee.Fixed, &fee.UpdatedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan merchant fee: %w", err)
+		}
+		fees = append(fees, fee)
 	}
 
-	return nil
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating merchant fees: %w", err)
+	}
+
+	return fees, nil
 }
 
-// UpdateTransactionReference updates a transaction's reference ID
-func (p *PostgresDB) UpdateTransactionReference(txID int, referenceID string) error {
+// ReplaceMerchantFees replaces all of a merchant's fees in a single transaction
+func (p *PostgresDB) ReplaceMerchantFees(merchantID int, fees []models.MerchantFee) error {
+	tx, err := p.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin merchant fees transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`DELETE FROM merchant_fees WHERE merchant_id = $1`, merchantID); err != nil {
+		return fmt.Errorf("failed to delete merchant fees: %w", err)
+	}
+
 	query := `
-		UPDATE transactions
-		SET reference_id = $1, updated_at = CURRENT_TIMESTAMP
-		WHERE id = $2
+		INSERT INTO merchant_fees (merchant_id, currency, percent, fixed, updated_at)
+		VALUES ($1, $2, $3, $4, $5)
 	`
-
-	_, err := p.db.Exec(query, referenceID, txID)
-	if err != nil {
-		return fmt.Errorf("failed to update transaction reference: %w", err)
+	for _, fee := range fees {
+		if _, err := tx.Exec(query, merchantID, fee.Currency, fee.Percent, fee.Fixed, fee.UpdatedAt); err != nil {
+			return fmt.Errorf("failed to create merchant fee: %w", err)
+		}
 	}
 
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit merchant fees: %w", err)
+	}
 	return nil
 }
 
-// Ping checks the database connection
-func (p *PostgresDB) Ping() error {
-	return p.db.Ping()
+// GetCountryByID fetches a country by ID
+func (p *PostgresDB) GetCountryByID(countryID int) (*models.Country, error) {
+	return p.getCountry(`SELECT id, name, code, currency, timezone FROM countries WHERE id = $1`, countryID)
 }
 
-// Close closes the database connection
-func (p *PostgresDB) Close() error {
-	return p.db.Close()
+// GetCountryByCode fetches a country by its ISO 3166-1 alpha-2 code
+func (p *PostgresDB) GetCountryByCode(code string) (*models.Country, error) {
+	return p.getCountry(`SELECT id, name, code, currency, timezone FROM countries WHERE code = $1`, code)
+}
+
+// getCountry fetches a single country using the given query
+func (p *PostgresDB) getCountry(query string, arg interface{}) (*models.Country, error) {
+	var country models.Country
+
+	err := p.db.QueryRow(query, arg).Scan(
+		&country.ID,
+		&country.Name,
+		&country.Code,
+		&country.Currency,
+		&country.Timezone,
+	)
+
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, fmt.Errorf("country not found: %w", err)
+		}
+		return nil, fmt.Errorf("failed to fetch country: %w", err)
+	}
+
+	return &country, nil
+}
+
+// GetBankHolidays fetches a country's bank holidays between two dates (YYYY-MM-DD), inclusive
+func (p *PostgresDB) GetBankHolidays(countryID int, from, to string) ([]models.BankHoliday, error) {
+	query := `
+		SELECT country_id, holiday_date, name
+		FROM bank_holidays
+		WHERE country_id = $1 AND holiday_date BETWEEN $2 AND $3
+		ORDER BY holiday_date
+	`
+
+	rows, err := p.db.Query(query, countryID, from, to)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch bank holidays: %w", err)
+	}
+	defer rows.Close()
+
+	var holidays []models.BankHoliday
+	for rows.Next() {
+		var holiday models.BankHoliday
+		var date time.Time
+		if err := rows.Scan(&holiday.CountryID, &date, &holiday.Name); err != nil {
+			return nil, fmt.Errorf("failed to scan bank holiday: %w", err)
+		}
+		holiday.Date = date.Format("2006-01-02")
+		holidays = append(holidays, holiday)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating bank holidays: %w", err)
+	}
+
+	return holidays, nil
+}
+
+// CreateBankHoliday adds a bank holiday to a country's calendar, renaming it if the day is already one
+func (p *PostgresDB) CreateBankHoliday(holiday models.BankHoliday) error {
+	query := `
+		INSERT INTO bank_holidays (country_id, holiday_date, name)
+		VALUES ($1, $2, $3)
+		ON CONFLICT (country_id, holiday_date) DO UPDATE SET name = EXCLUDED.name
+	`
+
+	if _, err := p.db.Exec(query, holiday.CountryID, holiday.Date, holiday.Name); err != nil {
+		return fmt.Errorf("failed to create bank holiday: %w", err)
+	}
+
+	return nil
+}
+
+// DeleteBankHoliday removes a bank holiday from a country's calendar
+func (p *PostgresDB) DeleteBankHoliday(countryID int, date string) error {
+	result, err := p.db.Exec(`DELETE FROM bank_holidays WHERE country_id = $1 AND holiday_date = $2`, countryID, date)
+	if err != nil {
+		return fmt.Errorf("failed to delete bank holiday: %w", err)
+	}
+	if rows, err := result.RowsAffected(); err == nil && rows == 0 {
+		return fmt.Errorf("bank holiday not found: %w", sql.ErrNoRows)
+	}
+
+	return nil
+}
+
+// GetComplianceFields fetches the compliance fields of a country in order
+func (p *PostgresDB) GetComplianceFields(countryID int) ([]models.ComplianceField, error) {
+	query := `
+		SELECT name, label, pattern, transaction_types, description
+		FROM country_compliance_fields
+		WHERE country_id = $1
+		ORDER BY position
+	`
+
+	rows, err := p.db.Query(query, countryID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch compliance fields: %w", err)
+	}
+	defer rows.Close()
+
+	var fields []models.ComplianceField
+	for rows.Next() {
+		var field models.ComplianceField
+		var pattern, description sql.NullString
+		if err := rows.Scan(&field.Name, &field.Label, &pattern, pq.Array(&field.Types), &description); err != nil {
+			return nil, fmt.Errorf("failed to scan compliance field: %w", err)
+		}
+		field.Pattern = pattern.String
+		field.Description = description.String
+		fields = append(fields, field)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating compliance fields: %w", err)
+	}
+
+	return fields, nil
+}
+
+// ReplaceComplianceFields replaces all of a country's compliance fields in a single transaction
+func (p *PostgresDB) ReplaceComplianceFields(countryID int, fields []models.ComplianceField) error {
+	tx, err := p.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin compliance fields transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`DELETE FROM country_compliance_fields WHERE country_id = $1`, countryID); err != nil {
+		return fmt.Errorf("failed to delete compliance fields: %w", err)
+	}
+
+	query := `
+		INSERT INTO country_compliance_fields (country_id, position, name, label, pattern, transaction_types, description)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+	`
+	for i, field := range fields {
+		if _, err := tx.Exec(query, countryID, i+1, field.Name, field.Label,
+			sql.NullString{String: field.Pattern, Valid: field.Pattern != ""},
+			pq.Array(field.Types),
+			sql.NullString{String: field.Description, Valid: field.Description != ""},
+		); err != nil {
+			return fmt.Errorf("failed to create compliance field: %w", err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit compliance fields: %w", err)
+	}
+	return nil
+}
+
+// GetAMLThresholds fetches the AML thresholds of a country by currency
+func (p *PostgresDB) GetAMLThresholds(countryID int) ([]models.AMLThreshold, error) {
+	query := `
+		SELECT country_id, currency, amount, updated_at
+		FROM aml_thresholds
+		WHERE country_id = $1
+		ORDER BY currency
+	`
+
+	rows, err := p.db.Query(query, countryID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch AML thresholds: %w", err)
+	}
+	defer rows.Close()
+
+	var thresholds []models.AMLThreshold
+	for rows.Next() {
+		var threshold models.AMLThreshold
+		if err := rows.Scan(&threshold.CountryID, &threshold.Currency, &threshold.Amount, &threshold.UpdatedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan AML threshold: %w", err)
+		}
+		thresholds = append(thresholds, threshold)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating AML thresholds: %w", err)
+	}
+
+	return thresholds, nil
+}
+
+// GetAMLThreshold fetches the AML threshold of a country in a currency, returning sql.ErrNoRows
+// when there is none
+func (p *PostgresDB) GetAMLThreshold(countryID int, currency string) (*models.AMLThreshold, error) {
+	query := `
+		SELECT country_id, currency, amount, updated_at
+		FROM aml_thresholds
+		WHERE country_id = $1 AND currency = $2
+	`
+
+	var threshold models.AMLThreshold
+	err := p.db.QueryRow(query, countryID, currency).Scan(&threshold.CountryID, &threshold.Currency, &threshold.Amount, &threshold.UpdatedAt)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, fmt.Errorf("AML threshold not found: %w", err)
+		}
+		return nil, fmt.Errorf("failed to fetch AML threshold: %w", err)
+	}
+
+	return &threshold, nil
+}
+
+// SetAMLThreshold creates or replaces the AML threshold of a country in a currency
+func (p *PostgresDB) SetAMLThreshold(threshold models.AMLThreshold) error {
+	query := `
+		INSERT INTO aml_thresholds (country_id, currency, amount, updated_at)
+		VALUES ($1, $2, $3, $4)
+		ON CONFLICT (country_id, currency) DO UPDATE SET amount = EXCLUDED.amount, updated_at = EXCLUDED.updated_at
+	`
+
+	if _, err := p.db.Exec(query, threshold.CountryID, threshold.Currency, threshold.Amount, threshold.UpdatedAt); err != nil {
+		return fmt.Errorf("failed to save AML threshold: %w", err)
+	}
+
+	return nil
+}
+
+// DeleteAMLThreshold removes the AML threshold of a country in a currency
+func (p *PostgresDB) DeleteAMLThreshold(countryID int, currency string) error {
+	result, err := p.db.Exec(`DELETE FROM aml_thresholds WHERE country_id = $1 AND currency = $2`, countryID, currency)
+	if err != nil {
+		return fmt.Errorf("failed to delete AML threshold: %w", err)
+	}
+	if rows, err := result.RowsAffected(); err == nil && rows == 0 {
+		return fmt.Errorf("AML threshold not found: %w", sql.ErrNoRows)
+	}
+
+	return nil
+}
+
+// GetDeclineRecoveryHints fetches the configured recovery hint of each decline code
+func (p *PostgresDB) GetDeclineRecoveryHints() ([]models.DeclineRecoveryHint, error) {
+	rows, err := p.db.Query(`SELECT decline_code, recovery_hint, updated_at FROM decline_recovery_hints ORDER BY decline_code`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch decline recovery hints: %w", err)
+	}
+	defer rows.Close()
+
+	var hints []models.DeclineRecoveryHint
+	for rows.Next() {
+		var hint models.DeclineRecoveryHint
+		var updatedAt sql.NullTime
+		if err := rows.Scan(&hint.DeclineCode, &hint.RecoveryHint, &updatedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan decline recovery hint: %w", err)
+		}
+		hint.UpdatedAt = updatedAt.Time
+		hints = append(hints, hint)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating decline recovery hints: %w", err)
+	}
+
+	return hints, nil
+}
+
+// SaveDeclineRecoveryHint sets the recovery hint of a decline code
+func (p *PostgresDB) SaveDeclineRecoveryHint(hint models.DeclineRecoveryHint) error {
+	query := `
+		INSERT INTO decline_recovery_hints (decline_code, recovery_hint, updated_at)
+		VALUES ($1, $2, $3)
+		ON CONFLICT (decline_code) DO UPDATE SET recovery_hint = EXCLUDED.recovery_hint, updated_at = EXCLUDED.updated_at
+	`
+
+	if _, err := p.db.Exec(query, hint.DeclineCode, hint.RecoveryHint, hint.UpdatedAt); err != nil {
+		return fmt.Errorf("failed to save decline recovery hint: %w", err)
+	}
+
+	return nil
+}
+
+// GetSupportedGatewaysByCountry fetches gateways supported for a country
+func (p *PostgresDB) GetSupportedGatewaysByCountry(countryID int) ([]models.Gateway, error) {
+	query := `
+		SELECT g.id, g.name, g.data_format_supported, g.created_at, g.updated_at
+		FROM gateways g
+		JOIN gateway_countries gc ON g.id = gc.gateway_id
+		WHERE gc.country_id = $1
+		ORDER BY gc.priority
+	`
+
+	rows, err := p.db.Query(query, countryID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch gateways: %w", err)
+	}
+	defer rows.Close()
+
+	var gateways []models.Gateway
+	for rows.Next() {
+		var gateway models.Gateway
+		var updatedAt sql.NullTime
+
+		if err := rows.Scan(
+			&gateway.ID,
+			&gateway.Name,
+			&gateway.DataFormatSupported,
+			&gateway.CreatedAt,
+			&updatedAt,
+		); err != nil {
+			return nil, fmt.Errorf("failed to scan gateway: %w", err)
+		}
+
+		if updatedAt.Valid {
+			gateway.UpdatedAt = updatedAt.Time
+		}
+
+		gateways = append(gateways, gateway)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating gateways: %w", err)
+	}
+
+	return gateways, nil
+}
+
+// GetGatewaysByPriority fetches gateways with their priorities for a country
+func (p *PostgresDB) GetGatewaysByPriority(countryID int) ([]models.GatewayPriority, error) {
+	query := `
+		SELECT g.id, g.name, g.data_format_supported, gc.priority 
+		FROM gateways g
+		JOIN gateway_countries gc ON g.id = gc.gateway_id
+		WHERE gc.country_id = $1
+		ORDER BY gc.priority
+	`
+
+	rows, err := p.db.Query(query, countryID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch gateway priorities: %w", err)
+	}
+	defer rows.Close()
+
+	var gateways []models.GatewayPriority
+	for rows.Next() {
+		var gw models.GatewayPriority
+		if err := rows.Scan(
+			&gw.GatewayID,
+			&gw.Name,
+			&gw.Format,
+			&gw.Priority,
+		); err != nil {
+			return nil, fmt.Errorf("failed to scan gateway priority: %w", err)
+		}
+		gateways = append(gateways, gw)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating gateway priorities: %w", err)
+	}
+
+	return gateways, nil
+}
+
+// CreateWebhookSecret stores a webhook secret for a gateway
+func (p *PostgresDB) CreateWebhookSecret(secret models.WebhookSecret) (int, error) {
+	query := `
+		INSERT INTO webhook_secrets (gateway_id, secret, hint, status, created_at)
+		VALUES ($1, $2, $3, $4, $5)
+		RETURNING id
+	`
+
+	var id int
+	err := p.db.QueryRow(query, secret.GatewayID, secret.Secret, secret.Hint, secret.Status, secret.CreatedAt).Scan(&id)
+	if err != nil {
+		return 0, fmt.Errorf("failed to create webhook secret: %w", err)
+	}
+
+	return id, nil
+}
+
+// GetWebhookSecretsByGateway fetches a gateway's webhook secrets, oldest first
+func (p *PostgresDB) GetWebhookSecretsByGateway(gatewayID int) ([]models.WebhookSecret, error) {
+	query := `
+		SELECT id, gateway_id, secret, hint, status, created_at, retired_at
+		FROM webhook_secrets
+		WHERE gateway_id = $1
+		ORDER BY id
+	`
+
+	rows, err := p.db.Query(query, gatewayID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch webhook secrets: %w", err)
+	}
+	defer rows.Close()
+
+	var secrets []models.WebhookSecret
+	for rows.Next() {
+		var secret models.WebhookSecret
+		var retiredAt sql.NullTime
+
+		if err := rows.Scan(
+			&secret.ID,
+			&secret.GatewayID,
+			&secret.Secret,
+			&secret.Hint,
+			&secret.Status,
+			&secret.CreatedAt,
+			&retiredAt,
+		); err != nil {
+			return nil, fmt.Errorf("failed to scan webhook secret: %w", err)
+		}
+
+		if retiredAt.Valid {
+			secret.RetiredAt = retiredAt.Time
+		}
+
+		secrets = append(secrets, secret)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating webhook secrets: %w", err)
+	}
+
+	return secrets, nil
+}
+
+// RetireWebhookSecret marks an active webhook secret of a gateway as retired
+func (p *PostgresDB) RetireWebhookSecret(gatewayID, secretID int) error {
+	query := `
+		UPDATE webhook_secrets
+		SET status = $1, retired_at = CURRENT_TIMESTAMP
+		WHERE id = $2 AND gateway_id = $3 AND status = $4
+	`
+
+	result, err := p.db.Exec(query, consts.WebhookSecretRetired, secretID, gatewayID, consts.WebhookSecretActive)
+	if err != nil {
+		return fmt.Errorf("failed to retire webhook secret: %w", err)
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to retire webhook secret: %w", err)
+	}
+	if rows == 0 {
+		return sql.ErrNoRows
+	}
+
+	return nil
+}
+
+// CreateClientCertificate stores a client certificate for a gateway
+func (p *PostgresDB) CreateClientCertificate(certificate models.ClientCertificate) (int, error) {
+	query := `
+		INSERT INTO gateway_client_certificates (gateway_id, certificate, private_key, subject, fingerprint, not_after, status, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+		RETURNING id
+	`
+
+	var id int
+	err := p.db.QueryRow(query,
+		certificate.GatewayID,
+		certificate.Certificate,
+		certificate.PrivateKey,
+		certificate.Subject,
+		certificate.Fingerprint,
+		certificate.NotAfter,
+		certificate.Status,
+		certificate.CreatedAt,
+	).Scan(&id)
+	if err != nil {
+		return 0, fmt.Errorf("failed to create client certificate: %w", err)
+	}
+
+	return id, nil
+}
+
+// GetClientCertificates fetches a gateway's client certificates, or every gateway's when
+// gatewayID is 0, oldest first
+func (p *PostgresDB) GetClientCertificates(gatewayID int) ([]models.ClientCertificate, error) {
+	query := `
+		SELECT id, gateway_id, certificate, private_key, subject, fingerprint, not_after, status, created_at, retired_at
+		FROM gateway_client_certificates
+		WHERE $1 = 0 OR gateway_id = $1
+		ORDER BY id
+	`
+
+	rows, err := p.db.Query(query, gatewayID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch client certificates: %w", err)
+	}
+	defer rows.Close()
+
+	var certificates []models.ClientCertificate
+	for rows.Next() {
+		var certificate models.ClientCertificate
+		var retiredAt sql.NullTime
+
+		if err := rows.Scan(
+			&certificate.ID,
+			&certificate.GatewayID,
+			&certificate.Certificate,
+			&certificate.PrivateKey,
+			&certificate.Subject,
+			&certificate.Fingerprint,
+			&certificate.NotAfter,
+			&certificate.Status,
+			&certificate.CreatedAt,
+			&retiredAt,
+		); err != nil {
+			return nil, fmt.Errorf("failed to scan client certificate: %w", err)
+		}
+
+		if retiredAt.Valid {
+			certificate.RetiredAt = retiredAt.Time
+		}
+
+		certificates = append(certificates, certificate)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating client certificates: %w", err)
+	}
+
+	return certificates, nil
+}
+
+// RetireClientCertificate marks an active client certificate of a gateway as retired
+func (p *PostgresDB) RetireClientCertificate(gatewayID, certificateID int) error {
+	query := `
+		UPDATE gateway_client_certificates
+		SET status = $1, retired_at = CURRENT_TIMESTAMP
+		WHERE id = $2 AND gateway_id = $3 AND status = $4
+	`
+
+	result, err := p.db.Exec(query, consts.ClientCertificateRetired, certificateID, gatewayID, consts.ClientCertificateActive)
+	if err != nil {
+		return fmt.Errorf("failed to retire client certificate: %w", err)
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to retire client certificate: %w", err)
+	}
+	if rows == 0 {
+		return sql.ErrNoRows
+	}
+
+	return nil
+}
+
+// CreateMerchantCertificate stores a merchant certificate for a wallet
+func (p *PostgresDB) CreateMerchantCertificate(certificate models.MerchantCertificate) (int, error) {
+	query := `
+		INSERT INTO wallet_merchant_certificates (wallet, certificate, public_key, private_key, public_key_hash, merchant_identifier, not_after, status, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+		RETURNING id
+	`
+
+	var notAfter sql.NullTime
+	if !certificate.NotAfter.IsZero() {
+		notAfter = sql.NullTime{Time: certificate.NotAfter, Valid: true}
+	}
+
+	var id int
+	err := p.db.QueryRow(query,
+		certificate.Wallet,
+		certificate.Certificate,
+		certificate.PublicKey,
+		certificate.PrivateKey,
+		certificate.PublicKeyHash,
+		certificate.MerchantIdentifier,
+		notAfter,
+		certificate.Status,
+		certificate.CreatedAt,
+	).Scan(&id)
+	if err != nil {
+		return 0, fmt.Errorf("failed to create merchant certificate: %w", err)
+	}
+
+	return id, nil
+}
+
+// GetMerchantCertificates fetches a wallet's merchant certificates, or every wallet's when wallet
+// is empty, oldest first
+func (p *PostgresDB) GetMerchantCertificates(wallet string) ([]models.MerchantCertificate, error) {
+	query := `
+		SELECT id, wallet, certificate, public_key, private_key, public_key_hash, merchant_identifier, not_after, status, created_at, retired_at
+		FROM wallet_merchant_certificates
+		WHERE $1 = '' OR wallet = $1
+		ORDER BY id
+	`
+
+	rows, err := p.db.Query(query, wallet)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch merchant certificates: %w", err)
+	}
+	defer rows.Close()
+
+	var certificates []models.MerchantCertificate
+	for rows.Next() {
+		var certificate models.MerchantCertificate
+		var notAfter, retiredAt sql.NullTime
+
+		if err := rows.Scan(
+			&certificate.ID,
+			&certificate.Wallet,
+			&certificate.Certificate,
+			&certificate.PublicKey,
+			&certificate.PrivateKey,
+			&certificate.PublicKeyHash,
+			&certificate.MerchantIdentifier,
+			&notAfter,
+			&certificate.Status,
+			&certificate.CreatedAt,
+			&retiredAt,
+		); err != nil {
+			return nil, fmt.Errorf("failed to scan merchant certificate: %w", err)
+		}
+
+		if notAfter.Valid {
+			certificate.NotAfter = notAfter.Time
+		}
+		if retiredAt.Valid {
+			certificate.RetiredAt = retiredAt.Time
+		}
+
+		certificates = append(certificates, certificate)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating merchant certificates: %w", err)
+	}
+
+	return certificates, nil
+}
+
+// RetireMerchantCertificate marks an active merchant certificate as retired
+func (p *PostgresDB) RetireMerchantCertificate(certificateID int) error {
+	query := `
+		UPDATE wallet_merchant_certificates
+		SET status = $1, retired_at = CURRENT_TIMESTAMP
+		WHERE id = $2 AND status = $3
+	`
+
+	result, err := p.db.Exec(query, consts.MerchantCertificateRetired, certificateID, consts.MerchantCertificateActive)
+	if err != nil {
+		return fmt.Errorf("failed to retire merchant certificate: %w", err)
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to retire merchant certificate: %w", err)
+	}
+	if rows == 0 {
+		return sql.ErrNoRows
+	}
+
+	return nil
+}
+
+// CreateSigningKey stores a signing key for a gateway
+func (p *PostgresDB) CreateSigningKey(key models.SigningKey) (int, error) {
+	query := `
+		INSERT INTO gateway_signing_keys (gateway_id, key_id, algorithm, public_key, private_key, status, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+		RETURNING id
+	`
+
+	var id int
+	err := p.db.QueryRow(query,
+		key.GatewayID,
+		key.KeyID,
+		key.Algorithm,
+		key.PublicKey,
+		key.PrivateKey,
+		key.Status,
+		key.CreatedAt,
+	).Scan(&id)
+	if err != nil {
+		return 0, fmt.Errorf("failed to create signing key: %w", err)
+	}
+
+	return id, nil
+}
+
+// GetSigningKeys fetches a gateway's signing keys, or every gateway's when gatewayID is 0,
+// oldest first
+func (p *PostgresDB) GetSigningKeys(gatewayID int) ([]models.SigningKey, error) {
+	query := `
+		SELECT id, gateway_id, key_id, algorithm, public_key, private_key, status, created_at, retired_at
+		FROM gateway_signing_keys
+		WHERE $1 = 0 OR gateway_id = $1
+		ORDER BY id
+	`
+
+	rows, err := p.db.Query(query, gatewayID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch signing keys: %w", err)
+	}
+	defer rows.Close()
+
+	var keys []models.SigningKey
+	for rows.Next() {
+		var key models.SigningKey
+		var retiredAt sql.NullTime
+
+		if err := rows.Scan(
+			&key.ID,
+			&key.GatewayID,
+			&key.KeyID,
+			&key.Algorithm,
+			&key.PublicKey,
+			&key.PrivateKey,
+			&key.Status,
+			&key.CreatedAt,
+			&retiredAt,
+		); err != nil {
+			return nil, fmt.Errorf("failed to scan signing key: %w", err)
+		}
+
+		if retiredAt.Valid {
+			key.RetiredAt = retiredAt.Time
+		}
+
+		keys = append(keys, key)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating signing keys: %w", err)
+	}
+
+	return keys, nil
+}
+
+// RetireSigningKey marks an active signing key of a gateway as retired
+func (p *PostgresDB) RetireSigningKey(gatewayID, keyID int) error {
+	query := `
+		UPDATE gateway_signing_keys
+		SET status = $1, retired_at = CURRENT_TIMESTAMP
+		WHERE id = $2 AND gateway_id = $3 AND status = $4
+	`
+
+	result, err := p.db.Exec(query, consts.SigningKeyRetired, keyID, gatewayID, consts.SigningKeyActive)
+	if err != nil {
+		return fmt.Errorf("failed to retire signing key: %w", err)
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to retire signing key: %w", err)
+	}
+	if rows == 0 {
+		return sql.ErrNoRows
+	}
+
+	return nil
+}
+
+// CreateMaintenanceWindow stores a maintenance window for a gateway
+func (p *PostgresDB) CreateMaintenanceWindow(window models.MaintenanceWindow) (int, error) {
+	query := `
+		INSERT INTO gateway_maintenance_windows (gateway_id, starts_at, ends_at, reason, created_at)
+		VALUES ($1, $2, $3, $4, $5)
+		RETURNING id
+	`
+
+	var id int
+	err := p.db.QueryRow(query, window.GatewayID, window.StartsAt, window.EndsAt, window.Reason, window.CreatedAt).Scan(&id)
+	if err != nil {
+		return 0, fmt.Errorf("failed to create maintenance window: %w", err)
+	}
+
+	return id, nil
+}
+
+// GetMaintenanceWindows fetches the windows that are not cancelled and end after the given
+// time, by start time; a zero gateway ID matches every gateway
+func (p *PostgresDB) GetMaintenanceWindows(gatewayID int, endingAfter time.Time) ([]models.MaintenanceWindow, error) {
+	query := `
+		SELECT id, gateway_id, starts_at, ends_at, reason, created_at
+		FROM gateway_maintenance_windows
+		WHERE ($1 = 0 OR gateway_id = $1) AND cancelled_at IS NULL AND ends_at > $2
+		ORDER BY starts_at, id
+	`
+
+	rows, err := p.db.Query(query, gatewayID, endingAfter)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch maintenance windows: %w", err)
+	}
+	defer rows.Close()
+
+	var windows []models.MaintenanceWindow
+	for rows.Next() {
+		var window models.MaintenanceWindow
+		if err := rows.Scan(
+			&window.ID,
+			&window.GatewayID,
+			&window.StartsAt,
+			&window.EndsAt,
+			&window.Reason,
+			&window.CreatedAt,
+		); err != nil {
+			return nil, fmt.Errorf("failed to scan maintenance window: %w", err)
+		}
+
+		windows = append(windows, window)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating maintenance windows: %w", err)
+	}
+
+	return windows, nil
+}
+
+// CancelMaintenanceWindow marks a gateway's maintenance window as cancelled
+func (p *PostgresDB) CancelMaintenanceWindow(gatewayID, windowID int) error {
+	query := `
+		UPDATE gateway_maintenance_windows
+		SET cancelled_at = CURRENT_TIMESTAMP
+		WHERE id = $1 AND gateway_id = $2 AND cancelled_at IS NULL
+	`
+
+	result, err := p.db.Exec(query, windowID, gatewayID)
+	if err != nil {
+		return fmt.Errorf("failed to cancel maintenance window: %w", err)
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to cancel maintenance window: %w", err)
+	}
+	if rows == 0 {
+		return sql.ErrNoRows
+	}
+
+	return nil
+}
+
+// CreateRoutingDecision records how a gateway was selected for a transaction
+func (p *PostgresDB) CreateRoutingDecision(decision models.RoutingDecision) (int, error) {
+	query := `
+		INSERT INTO routing_decisions (
+			transaction_id, country_id, type, selected_gateway_id, preferred_gateway_id,
+			excluded_gateway_ids, override, reason, created_at
+		) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+		RETURNING id
+	`
+
+	var id int
+	err := p.db.QueryRow(
+		query,
+		decision.TransactionID,
+		decision.CountryID,
+		decision.Type,
+		decision.SelectedGatewayID,
+		sql.NullInt64{Int64: int64(decision.PreferredGatewayID), Valid: decision.PreferredGatewayID != 0},
+		pq.Array(decision.ExcludedGatewayIDs),
+		decision.Override,
+		decision.Reason,
+		decision.CreatedAt,
+	).Scan(&id)
+
+	if err != nil {
+		return 0, fmt.Errorf("failed to create routing decision: %w", err)
+	}
+
+	return id, nil
+}
+
+// GetRoutingDecisionsByTransaction fetches the routing audit trail for a transaction
+func (p *PostgresDB) GetRoutingDecisionsByTransaction(txID int) ([]models.RoutingDecision, error) {
+	query := `
+		SELECT id, transaction_id, country_id, type, selected_gateway_id, preferred_gateway_id,
+			   excluded_gateway_ids, override, reason, created_at
+		FROM routing_decisions
+		WHERE transaction_id = $1
+		ORDER BY created_at
+	`
+
+	rows, err := p.db.Query(query, txID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch routing decisions: %w", err)
+	}
+	defer rows.Close()
+
+	var decisions []models.RoutingDecision
+	for rows.Next() {
+		var decision models.RoutingDecision
+		var preferred sql.NullInt64
+		var excluded pq.Int64Array
+
+		if err := rows.Scan(
+			&decision.ID,
+			&decision.TransactionID,
+			&decision.CountryID,
+			&decision.Type,
+			&decision.SelectedGatewayID,
+			&preferred,
+			&excluded,
+			&decision.Override,
+			&decision.Reason,
+			&decision.CreatedAt,
+		); err != nil {
+			return nil, fmt.Errorf("failed to scan routing decision: %w", err)
+		}
+
+		if preferred.Valid {
+			decision.PreferredGatewayID = int(preferred.Int64)
+		}
+		for _, id := range excluded {
+			decision.ExcludedGatewayIDs = append(decision.ExcludedGatewayIDs, int(id))
+		}
+
+		decisions = append(decisions, decision)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating routing decisions: %w", err)
+	}
+
+	return decisions, nil
+}
+
+// CreateTransactionEvent appends an entry to a transaction's audit trail
+func (p *PostgresDB) CreateTransactionEvent(event models.TransactionEvent) (int, error) {
+	query := `
+		INSERT INTO transaction_events (
+			transaction_id, type, status, message, decline_code, gateway_reference, created_at
+		) VALUES ($1, $2, $3, $4, $5, $6, $7)
+		RETURNING id
+	`
+
+	var id int
+	err := p.db.QueryRow(
+		query,
+		event.TransactionID,
+		event.Type,
+		event.Status,
+		sql.NullString{String: event.Message, Valid: event.Message != ""},
+		sql.NullString{String: event.DeclineCode, Valid: event.DeclineCode != ""},
+		sql.NullString{String: event.GatewayReference, Valid: event.GatewayReference != ""},
+		event.CreatedAt,
+	).Scan(&id)
+
+	if err != nil {
+		return 0, fmt.Errorf("failed to create transaction event: %w", err)
+	}
+
+	return id, nil
+}
+
+// GetTransactionEvents fetches a transaction's audit trail, oldest first
+func (p *PostgresDB) GetTransactionEvents(txID int) ([]models.TransactionEvent, error) {
+	query := `
+		SELECT id, transaction_id, type, status, message, decline_code, gateway_reference, created_at
+		FROM transaction_events
+		WHERE transaction_id = $1
+		ORDER BY id
+	`
+
+	rows, err := p.db.Query(query, txID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch transaction events: %w", err)
+	}
+	defer rows.Close()
+
+	var events []models.TransactionEvent
+	for rows.Next() {
+		var event models.TransactionEvent
+		var message, declineCode, gatewayReference sql.NullString
+
+		if err := rows.Scan(
+			&event.ID,
+			&event.TransactionID,
+			&event.Type,
+			&event.Status,
+			&message,
+			&declineCode,
+			&gatewayReference,
+			&event.CreatedAt,
+		); err != nil {
+			return nil, fmt.Errorf("failed to scan transaction event: %w", err)
+		}
+
+		event.Message = message.String
+		event.DeclineCode = declineCode.String
+		event.GatewayReference = gatewayReference.String
+		events = append(events, event)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating transaction events: %w", err)
+	}
+
+	return events, nil
+}
+
+// rowQuerier runs a query returning one row, on the database or within a database transaction
+type rowQuerier interface {
+	QueryRow(query string, args ...interface{}) *sql.Row
+}
+
+// CreateTransaction creates a new transaction record
+func (p *PostgresDB) CreateTransaction(transaction models.Transaction) (int, error) {
+	return insertTransaction(p.db, transaction)
+}
+
+// CreateWithdrawal creates a withdrawal while its user's wallet balance covers it, returning
+// ErrInsufficientBalance otherwise. The check holds the user row that transfers and refunds lock,
+// so the same funds cannot be withdrawn twice, or withdrawn and transferred, at once.
+func (p *PostgresDB) CreateWithdrawal(transaction models.Transaction) (int, error) {
+	tx, err := p.db.Begin()
+	if err != nil {
+		return 0, fmt.Errorf("failed to begin withdrawal: %w", err)
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`SELECT id FROM users WHERE id = $1 FOR UPDATE`, transaction.UserID); err != nil {
+		return 0, fmt.Errorf("failed to lock user: %w", err)
+	}
+	var covered bool
+	query := `SELECT COALESCE(SUM(amount), 0) >= $9 FROM (` + walletLedgerQuery + `) ledger`
+	args := append(walletLedgerArgs(transaction.UserID, transaction.Currency, transaction.Livemode), transaction.Amount)
+	if err := tx.QueryRow(query, args...).Scan(&covered); err != nil {
+		return 0, fmt.Errorf("failed to check wallet balance: %w", err)
+	}
+	if !covered {
+		return 0, ErrInsufficientBalance
+	}
+
+	id, err := insertTransaction(tx, transaction)
+	if err != nil {
+		return 0, err
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("failed to commit withdrawal: %w", err)
+	}
+	return id, nil
+}
+
+// insertTransaction inserts a transaction record, returning its ID
+func insertTransaction(q rowQuerier, transaction models.Transaction) (int, error) {
+	query := `
+		INSERT INTO transactions (
+			amount, currency, type, status, user_id, gateway_id, country_id, beneficiary, phone_number, phone_e164,
+			return_url, cancel_url, reference_id, reference_hash, retry_of_id, country_source, risk_flags, scheduled_for,
+			expected_settlement_date, card_bin, sca_exemption, livemode, compliance_fields, created_at, bank_account_id, mandate_id,
+			incremental_authorization, capture_of_id
+		) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $17, $18, $19, $20, $21, $22, $23, $24, $25, $26, $27, $28) 
+		RETURNING id
+	`
+
+	complianceFields, err := encryptComplianceFields(transaction.ComplianceFields)
+	if err != nil {
+		return 0, err
+	}
+
+	var id int
+	err = q.QueryRow(
+		query,
+		transaction.Amount,
+		transaction.Currency,
+		transaction.Type,
+		transaction.Status,
+		transaction.UserID,
+		transaction.GatewayID,
+		transaction.CountryID,
+		sql.NullString{String: transaction.Beneficiary, Valid: transaction.Beneficiary != ""},
+		sql.NullString{String: transaction.PhoneNumber, Valid: transaction.PhoneNumber != ""},
+		sql.NullString{String: transaction.PhoneE164, Valid: transaction.PhoneE164 != ""},
+		sql.NullString{String: transaction.ReturnURL, Valid: transaction.ReturnURL != ""},
+		sql.NullString{String: transaction.CancelURL, Valid: transaction.CancelURL != ""},
+		sql.NullString{String: transaction.ReferenceID, Valid: transaction.ReferenceID != ""},
+		sql.NullString{String: utils.BlindIndex(transaction.ReferenceID), Valid: transaction.ReferenceID != ""},
+		sql.NullInt64{Int64: int64(transaction.RetryOfID), Valid: transaction.RetryOfID > 0},
+		sql.NullString{String: transaction.CountrySource, Valid: transaction.CountrySource != ""},
+		pq.Array(transaction.RiskFlags),
+		sql.NullTime{Time: transaction.ScheduledFor, Valid: !transaction.ScheduledFor.IsZero()},
+		sql.NullString{String: transaction.ExpectedSettlementDate, Valid: transaction.ExpectedSettlementDate != ""},
+		sql.NullString{String: transaction.CardBIN, Valid: transaction.CardBIN != ""},
+		sql.NullString{String: transaction.SCAExemption, Valid: transaction.SCAExemption != ""},
+		transaction.Livemode,
+		sql.NullString{String: complianceFields, Valid: complianceFields != ""},
+		transaction.CreatedAt,
+		sql.NullInt64{Int64: int64(transaction.BankAccountID), Valid: transaction.BankAccountID > 0},
+		sql.NullInt64{Int64: int64(transaction.MandateID), Valid: transaction.MandateID > 0},
+		transaction.IncrementalAuthorization,
+		sql.NullInt64{Int64: int64(transaction.CaptureOfID), Valid: transaction.CaptureOfID > 0},
+	).Scan(&id)
+
+	if err != nil {
+		return 0, fmt.Errorf("failed to create transaction: %w", err)
+	}
+
+	return id, nil
+}
+
+// GetTransactionByID fetches a transaction by ID
+func (p *PostgresDB) GetTransactionByID(transactionID int) (*models.Transaction, error) {
+	query := `
+		SELECT id, amount, currency, type, status, user_id, gateway_id, country_id, 
+			   beneficiary, phone_number, phone_e164, return_url, cancel_url, reference_id, gateway_reference, redirect_url,
+			   gateway_idempotency_key, error_message, decline_code, retry_of_id, country_source, risk_flags, scheduled_for,
+			   expected_settlement_date, card_bin, sca_exemption, sca_exemption_outcome, livemode, compliance_fields, created_at, updated_at,
+			   crypto_amount, crypto_currency, crypto_network, crypto_transaction_hash, crypto_confirmations,
+			   crypto_required_confirmations, crypto_status, bank_account_id, mandate_id, authorized_amount, authorization_expires_at,
+			   incremental_authorization, captured_amount, capture_of_id
+		FROM transactions
+		WHERE id = $1 AND deleted_at IS NULL
+	`
+
+	var tx models.Transaction
+	var beneficiary, phoneNumber, phoneE164, returnURL, cancelURL, referenceID, gatewayReference, redirectURL, idempotencyKey, errorMessage, declineCode, countrySource, cardBIN, scaExemption, scaOutcome, complianceFields sql.NullString
+	var retryOfID sql.NullInt64
+	var scheduledFor, settlementDate, updatedAt sql.NullTime
+	var cryptoAmount, cryptoCurrency, cryptoNetwork, cryptoHash, cryptoStatus sql.NullString
+	var cryptoConfirmations, cryptoRequired, bankAccountID, mandateID sql.NullInt64
+	var authorizedAmount, capturedAmount sql.NullFloat64
+	var authorizationExpiresAt sql.NullTime
+	var captureOfID sql.NullInt64
+
+	err := p.db.QueryRow(query, transactionID).Scan(
+		&tx.ID,
+		&tx.Amount,
+		&tx.Currency,
+		&tx.Type,
+		&tx.Status,
+		&tx.UserID,
+		&tx.GatewayID,
+		&tx.CountryID,
+		&beneficiary,
+		&phoneNumber,
+		&phoneE164,
+		&returnURL,
+		&cancelURL,
+		&referenceID,
+		&gatewayReference,
+		&redirectURL,
+		&idempotencyKey,
+		&errorMessage,
+		&declineCode,
+		&retryOfID,
+		&countrySource,
+		pq.Array(&tx.RiskFlags),
+		&scheduledFor,
+		&settlementDate,
+		&cardBIN,
+		&scaExemption,
+		&scaOutcome,
+		&tx.Livemode,
+		&complianceFields,
+		&tx.CreatedAt,
+		&updatedAt,
+		&cryptoAmount,
+		&cryptoCurrency,
+		&cryptoNetwork,
+		&cryptoHash,
+		&cryptoConfirmations,
+		&cryptoRequired,
+		&cryptoStatus,
+		&bankAccountID,
+		&mandateID,
+		&authorizedAmount,
+		&authorizationExpiresAt,
+		&tx.IncrementalAuthorization,
+		&capturedAmount,
+		&captureOfID,
+	)
+
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, fmt.Errorf("transaction not found: %w", err)
+		}
+		return nil, fmt.Errorf("failed to fetch transaction: %w", err)
+	}
+
+	if beneficiary.Valid {
+		tx.Beneficiary = beneficiary.String
+	}
+	tx.PhoneNumber = phoneNumber.String
+	tx.PhoneE164 = phoneE164.String
+	if returnURL.Valid {
+		tx.ReturnURL = returnURL.String
+	}
+	if cancelURL.Valid {
+		tx.CancelURL = cancelURL.String
+	}
+	if referenceID.Valid {
+		tx.ReferenceID = referenceID.String
+	}
+	tx.GatewayReference = gatewayReference.String
+	tx.RedirectURL = redirectURL.String
+	if idempotencyKey.Valid {
+		tx.GatewayIdempotencyKey = idempotencyKey.String
+	}
+	if errorMessage.Valid {
+		tx.ErrorMessage = errorMessage.String
+	}
+	if declineCode.Valid {
+		tx.DeclineCode = declineCode.String
+	}
+	if retryOfID.Valid {
+		tx.RetryOfID = int(retryOfID.Int64)
+	}
+	if countrySource.Valid {
+		tx.CountrySource = countrySource.String
+	}
+	if scheduledFor.Valid {
+		tx.ScheduledFor = scheduledFor.Time
+	}
+	if settlementDate.Valid {
+		tx.ExpectedSettlementDate = settlementDate.Time.Format("2006-01-02")
+	}
+	tx.CardBIN = cardBIN.String
+	tx.SCAExemption = scaExemption.String
+	tx.SCAExemptionOutcome = scaOutcome.String
+	if updatedAt.Valid {
+		tx.UpdatedAt = updatedAt.Time
+	}
+	if tx.ComplianceFields, err = decryptComplianceFields(complianceFields.String); err != nil {
+		return nil, err
+	}
+	if cryptoStatus.Valid {
+		tx.Crypto = &models.CryptoPayment{
+			Amount:                cryptoAmount.String,
+			Currency:              cryptoCurrency.String,
+			Network:               cryptoNetwork.String,
+			TransactionHash:       cryptoHash.String,
+			Confirmations:         int(cryptoConfirmations.Int64),
+			RequiredConfirmations: int(cryptoRequired.Int64),
+			Status:                cryptoStatus.String,
+		}
+	}
+	tx.BankAccountID = int(bankAccountID.Int64)
+	tx.MandateID = int(mandateID.Int64)
+	tx.AuthorizedAmount = authorizedAmount.Float64
+	tx.AuthorizationExpiresAt = authorizationExpiresAt.Time
+	tx.CapturedAmount = capturedAmount.Float64
+	tx.CaptureOfID = int(captureOfID.Int64)
+
+	return &tx, nil
+}
+
+// encryptComplianceFields encodes a transaction's compliance field values for storage, or returns
+// "" when there are none
+func encryptComplianceFields(values map[string]string) (string, error) {
+	if len(values) == 0 {
+		return "", nil
+	}
+
+	encoded, err := json.Marshal(values)
+	if err != nil {
+		return "", fmt.Errorf("failed to encode compliance fields: %w", err)
+	}
+	encrypted, err := utils.EncryptString(string(encoded))
+	if err != nil {
+		return "", fmt.Errorf("failed to encrypt compliance fields: %w", err)
+	}
+	return encrypted, nil
+}
+
+// decryptComplianceFields decodes stored compliance field values
+func decryptComplianceFields(stored string) (map[string]string, error) {
+	if stored == "" {
+		return nil, nil
+	}
+
+	decrypted, err := utils.DecryptString(stored)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt compliance fields: %w", err)
+	}
+	var values map[string]string
+	if err := json.Unmarshal([]byte(decrypted), &values); err != nil {
+		return nil, fmt.Errorf("failed to decode compliance fields: %w", err)
+	}
+	return values, nil
+}
+
+// SearchTransactions returns the newest transactions matching any of the search criteria
+func (p *PostgresDB) SearchTransactions(search models.TransactionSearch) ([]models.TransactionSearchResult, error) {
+	query := `
+		SELECT t.id, t.type, t.status, t.amount, t.currency, t.user_id, u.email, t.gateway_id, t.country_id,
+			   t.reference_id, t.gateway_reference, t.decline_code, t.created_at
+		FROM transactions t
+		JOIN users u ON u.id = t.user_id
+		WHERE t.deleted_at IS NULL AND (
+			($1 > 0 AND t.id = $1) OR
+			($2 > 0 AND t.amount = $2) OR
+			u.email_hash = ANY($3) OR
+			t.reference_hash = ANY($4) OR
+			t.gateway_reference_hash = ANY($4) OR
+			($5 <> '' AND t.gateway_idempotency_key = $5)
+		)
+		ORDER BY t.created_at DESC
+		LIMIT $6
+	`
+
+	rows, err := p.db.Query(query, search.TransactionID, search.Amount, pq.Array(search.EmailHashes),
+		pq.Array(search.ReferenceHashes), search.IdempotencyKey, search.Limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to search transactions: %w", err)
+	}
+	defer rows.Close()
+
+	var results []models.TransactionSearchResult
+	for rows.Next() {
+		var result models.TransactionSearchResult
+		var referenceID, gatewayReference, declineCode sql.NullString
+
+		if err := rows.Scan(
+			&result.ID,
+			&result.Type,
+			&result.Status,
+			&result.Amount,
+			&result.Currency,
+			&result.UserID,
+			&result.UserEmail,
+			&result.GatewayID,
+			&result.CountryID,
+			&referenceID,
+			&gatewayReference,
+			&declineCode,
+			&result.CreatedAt,
+		); err != nil {
+			return nil, fmt.Errorf("failed to scan transaction: %w", err)
+		}
+
+		result.ReferenceID = referenceID.String
+		result.GatewayReference = gatewayReference.String
+		result.DeclineCode = declineCode.String
+		results = append(results, result)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating transactions: %w", err)
+	}
+
+	return results, nil
+}
+
+// GetDeclineCounts counts a merchant's completed and failed transactions created from from until
+// to, grouped by day, gateway, country, BIN range and decline code
+func (p *PostgresDB) GetDeclineCounts(merchantID int, tag string, from, to time.Time) ([]models.DeclineCount, error) {
+	query := `
+		SELECT to_char(t.created_at, 'YYYY-MM-DD'), t.gateway_id, t.country_id,
+			   COALESCE(LEFT(t.card_bin, $4), ''), COALESCE(t.decline_code, ''), COUNT(*)
+		FROM transactions t
+		JOIN users u ON u.id = t.user_id
+		WHERE u.merchant_id = $1 AND t.created_at >= $2 AND t.created_at < $3
+		  AND t.status IN ($5, $6) AND t.deleted_at IS NULL
+		  AND ($7 = '' OR EXISTS (SELECT 1 FROM transaction_tags g WHERE g.transaction_id = t.id AND g.tag = $7))
+		GROUP BY 1, 2, 3, 4, 5
+	`
+
+	rows, err := p.db.Query(query, merchantID, from, to, consts.DeclineReportBINLength, consts.Completed, consts.Failed, tag)
+	if err != nil {
+		return nil, fmt.Errorf("failed to count declines: %w", err)
+	}
+	defer rows.Close()
+
+	var counts []models.DeclineCount
+	for rows.Next() {
+		var count models.DeclineCount
+		if err := rows.Scan(&count.Day, &count.GatewayID, &count.CountryID, &count.BINRange, &count.DeclineCode, &count.Count); err != nil {
+			return nil, fmt.Errorf("failed to scan decline count: %w", err)
+		}
+		counts = append(counts, count)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating decline counts: %w", err)
+	}
+
+	return counts, nil
+}
+
+// GetMerchantTransactions fetches the transactions of a merchant's users created from from until
+// to, in ID order, with the columns reconciliation files report
+func (p *PostgresDB) GetMerchantTransactions(merchantID int, from, to time.Time) ([]models.Transaction, error) {
+	query := `
+		SELECT t.id, t.amount, t.currency, t.type, t.status, t.user_id, t.gateway_id, t.country_id,
+			   t.reference_id, t.gateway_reference, t.decline_code, t.livemode, t.created_at, t.updated_at
+		FROM transactions t
+		JOIN users u ON u.id = t.user_id
+		WHERE u.merchant_id = $1 AND t.created_at >= $2 AND t.created_at < $3 AND t.deleted_at IS NULL
+		ORDER BY t.id
+	`
+
+	rows, err := p.db.Query(query, merchantID, from, to)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch merchant transactions: %w", err)
+	}
+	defer rows.Close()
+
+	var transactions []models.Transaction
+	for rows.Next() {
+		var tx models.Transaction
+		var referenceID, gatewayReference, declineCode sql.NullString
+		var updatedAt sql.NullTime
+		if err := rows.Scan(&tx.ID, &tx.Amount, &tx.Currency, &tx.Type, &tx.Status, &tx.UserID, &tx.GatewayID, &tx.CountryID,
+			&referenceID, &gatewayReference, &declineCode, &tx.Livemode, &tx.CreatedAt, &updatedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan transaction: %w", err)
+		}
+		tx.ReferenceID = referenceID.String
+		tx.GatewayReference = gatewayReference.String
+		tx.DeclineCode = declineCode.String
+		tx.UpdatedAt = updatedAt.Time
+		transactions = append(transactions, tx)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating transactions: %w", err)
+	}
+
+	return transactions, nil
+}
+
+// SaveReconciliationFile creates or replaces a reconciliation file
+func (p *PostgresDB) SaveReconciliationFile(file models.ReconciliationFile) error {
+	query := `
+		INSERT INTO reconciliation_files (id, merchant_id, report_date, version, row_count, size_bytes, checksum, storage_key, generated_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+		ON CONFLICT (id) DO UPDATE SET
+			version = EXCLUDED.version,
+			row_count = EXCLUDED.row_count,
+			size_bytes = EXCLUDED.size_bytes,
+			checksum = EXCLUDED.checksum,
+			storage_key = EXCLUDED.storage_key,
+			generated_at = EXCLUDED.generated_at
+	`
+
+	_, err := p.db.Exec(query, file.ID, file.MerchantID, file.Date, file.Version, file.Rows, file.Size, file.Checksum, file.StorageKey, file.GeneratedAt)
+	if err != nil {
+		return fmt.Errorf("failed to save reconciliation file: %w", err)
+	}
+	return nil
+}
+
+// GetReconciliationFile fetches one of a merchant's reconciliation files
+func (p *PostgresDB) GetReconciliationFile(merchantID int, fileID string) (*models.ReconciliationFile, error) {
+	query := `
+		SELECT id, merchant_id, to_char(report_date, 'YYYY-MM-DD'), version, row_count, size_bytes, checksum, storage_key, generated_at
+		FROM reconciliation_files
+		WHERE merchant_id = $1 AND id = $2
+	`
+
+	var file models.ReconciliationFile
+	err := p.db.QueryRow(query, merchantID, fileID).Scan(&file.ID, &file.MerchantID, &file.Date, &file.Version, &file.Rows, &file.Size, &file.Checksum, &file.StorageKey, &file.GeneratedAt)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, fmt.Errorf("reconciliation file not found: %w", err)
+		}
+		return nil, fmt.Errorf("failed to get reconciliation file: %w", err)
+	}
+
+	return &file, nil
+}
+
+// ListReconciliationFiles fetches a merchant's reconciliation files for the days from from to to,
+// newest first
+func (p *PostgresDB) ListReconciliationFiles(merchantID int, from, to string) ([]models.ReconciliationFile, error) {
+	query := `
+		SELECT id, merchant_id, to_char(report_date, 'YYYY-MM-DD'), version, row_count, size_bytes, checksum, storage_key, generated_at
+		FROM reconciliation_files
+		WHERE merchant_id = $1 AND report_date >= $2 AND report_date <= $3
+		ORDER BY report_date DESC
+	`
+
+	rows, err := p.db.Query(query, merchantID, from, to)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list reconciliation files: %w", err)
+	}
+	defer rows.Close()
+
+	var files []models.ReconciliationFile
+	for rows.Next() {
+		var file models.ReconciliationFile
+		if err := rows.Scan(&file.ID, &file.MerchantID, &file.Date, &file.Version, &file.Rows, &file.Size, &file.Checksum, &file.StorageKey, &file.GeneratedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan reconciliation file: %w", err)
+		}
+		files = append(files, file)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating reconciliation files: %w", err)
+	}
+
+	return files, nil
+}
+
+// GetTransactionIDByGatewayReference finds a gateway's transaction by the reference the gateway
+// assigned it, for callbacks that don't carry the transaction ID
+func (p *PostgresDB) GetTransactionIDByGatewayReference(gatewayID int, gatewayReference string) (int, error) {
+	query := `
+		SELECT id
+		FROM transactions
+		WHERE gateway_id = $1 AND gateway_reference_hash = ANY($2) AND gateway_reference = $3 AND deleted_at IS NULL
+		ORDER BY id DESC
+		LIMIT 1
+	`
+
+	var txID int
+	err := p.db.QueryRow(query, gatewayID, pq.Array(utils.BlindIndexCandidates(gatewayReference)), gatewayReference).Scan(&txID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return 0, fmt.Errorf("transaction not found: %w", err)
+		}
+		return 0, fmt.Errorf("failed to find transaction by gateway reference: %w", err)
+	}
+
+	return txID, nil
+}
+
+// UpdateTransactionStatus moves a transaction from one status to another, returning
+// sql.ErrNoRows when it is no longer in the status it was read in. Two writers racing on the same
+// transaction can't both move it.
+func (p *PostgresDB) UpdateTransactionStatus(txID int, from, to, errorMsg string) error {
+	query := `
+		UPDATE transactions
+		SET status = $1, error_message = $2, updated_at = CURRENT_TIMESTAMP
+		WHERE id = $3 AND status = $4
+	`
+
+	result, err := p.db.Exec(query, to, errorMsg, txID, from)
+	if err != nil {
+		return fmt.Errorf("failed to update transaction status: %w", err)
+	}
+	if n, err := result.RowsAffected(); err != nil {
+		return fmt.Errorf("failed to update transaction status: %w", err)
+	} else if n == 0 {
+		return sql.ErrNoRows
+	}
+
+	return nil
+}
+
+// UpdateTransactionGatewayReference records the provider's reference and hosted payment page,
+// leaving either unchanged when empty
+func (p *PostgresDB) UpdateTransactionGatewayReference(txID int, gatewayReference, redirectURL string) error {
+	query := `
+		UPDATE transactions
+		SET gateway_reference = COALESCE($1, gateway_reference),
+			gateway_reference_hash = COALESCE($2, gateway_reference_hash),
+			redirect_url = COALESCE($3, redirect_url),
+			updated_at = CURRENT_TIMESTAMP
+		WHERE id = $4
+	`
+
+	_, err := p.db.Exec(
+		query,
+		sql.NullString{String: gatewayReference, Valid: gatewayReference != ""},
+		sql.NullString{String: utils.BlindIndex(gatewayReference), Valid: gatewayReference != ""},
+		sql.NullString{String: redirectURL, Valid: redirectURL != ""},
+		txID,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to update transaction gateway reference: %w", err)
+	}
+
+	return nil
+}
+
+// UpdateTransactionGateway moves a transaction to another gateway, before it is submitted
+func (p *PostgresDB) UpdateTransactionGateway(txID, gatewayID int) error {
+	query := `
+		UPDATE transactions
+		SET gateway_id = $1, updated_at = CURRENT_TIMESTAMP
+		WHERE id = $2
+	`
+
+	_, err := p.db.Exec(query, gatewayID, txID)
+	if err != nil {
+		return fmt.Errorf("failed to update transaction gateway: %w", err)
+	}
+
+	return nil
+}
+
+// UpdateTransactionSCAExemptionOutcome records the outcome of the SCA exemption requested for a transaction
+func (p *PostgresDB) UpdateTransactionSCAExemptionOutcome(txID int, outcome string) error {
+	query := `
+		UPDATE transactions
+		SET sca_exemption_outcome = $1, updated_at = CURRENT_TIMESTAMP
+		WHERE id = $2
+	`
+
+	_, err := p.db.Exec(query, outcome, txID)
+	if err != nil {
+		return fmt.Errorf("failed to update transaction SCA exemption outcome: %w", err)
+	}
+
+	return nil
+}
+
+// UpdateTransactionCryptoPayment records the payment of a crypto deposit
+func (p *PostgresDB) UpdateTransactionCryptoPayment(txID int, payment models.CryptoPayment) error {
+	query := `
+		UPDATE transactions
+		SET crypto_amount = $1, crypto_currency = $2, crypto_network = $3, crypto_transaction_hash = $4,
+		    crypto_confirmations = $5, crypto_required_confirmations = $6, crypto_status = $7, updated_at = CURRENT_TIMESTAMP
+		WHERE id = $8
+	`
+
+	_, err := p.db.Exec(query, payment.Amount, payment.Currency, payment.Network, payment.TransactionHash,
+		payment.Confirmations, payment.RequiredConfirmations, payment.Status, txID)
+	if err != nil {
+		return fmt.Errorf("failed to update transaction crypto payment: %w", err)
+	}
+
+	return nil
+}
+
+// ExpireUnpaidDeposits fails up to limit deposits of a gateway still pending or processing that
+// were created before the given time, returning their IDs. Rows are locked and their status
+// checked in the same statement, so a deposit completed by a concurrent callback is never failed.
+func (p *PostgresDB) ExpireUnpaidDeposits(gatewayID int, createdBefore time.Time, errorMsg, declineCode string, limit int) ([]int, error) {
+	query := `
+		UPDATE transactions
+		SET status = $1, error_message = $2, decline_code = $3, updated_at = CURRENT_TIMESTAMP
+		WHERE id IN (
+			SELECT id FROM transactions
+			WHERE gateway_id = $4 AND type = $5 AND status IN ($6, $7) AND created_at < $8 AND deleted_at IS NULL
+			ORDER BY created_at, id
+			LIMIT $9
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING id
+	`
+
+	rows, err := p.db.Query(query, consts.Failed, errorMsg, declineCode, gatewayID, consts.Deposit, consts.Pending, consts.Processing, createdBefore, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to expire unpaid deposits: %w", err)
+	}
+	defer rows.Close()
+
+	var ids []int
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			return nil, fmt.Errorf("failed to scan transaction ID: %w", err)
+		}
+		ids = append(ids, id)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating expired deposits: %w", err)
+	}
+
+	return ids, nil
+}
+
+// GetInFlightTransactionIDs returns, in ID order, the IDs of up to limit transactions of a gateway
+// still pending or processing with a gateway reference that were created in the given window and
+// have an ID above afterID
+func (p *PostgresDB) GetInFlightTransactionIDs(gatewayID int, createdAfter, createdBefore time.Time, afterID, limit int) ([]int, error) {
+	query := `
+		SELECT id FROM transactions
+		WHERE gateway_id = $1 AND status IN ($2, $3) AND created_at > $4 AND created_at < $5 AND id > $6
+			AND gateway_reference IS NOT NULL AND deleted_at IS NULL
+		ORDER BY id
+		LIMIT $7
+	`
+
+	rows, err := p.db.Query(query, gatewayID, consts.Pending, consts.Processing, createdAfter, createdBefore, afterID, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get in-flight transactions: %w", err)
+	}
+	defer rows.Close()
+
+	var ids []int
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			return nil, fmt.Errorf("failed to scan transaction ID: %w", err)
+		}
+		ids = append(ids, id)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating in-flight transactions: %w", err)
+	}
+
+	return ids, nil
+}
+
+// GetStaleTransactionIDs returns the IDs of up to limit transactions still pending or processing
+// that last changed before the given time, least recently changed first. Transactions never
+// updated since they were created last changed when they were created.
+func (p *PostgresDB) GetStaleTransactionIDs(changedBefore time.Time, limit int) ([]int, error) {
+	query := `
+		SELECT id FROM transactions
+		WHERE status IN ($1, $2) AND COALESCE(updated_at, created_at) < $3 AND deleted_at IS NULL
+		ORDER BY COALESCE(updated_at, created_at), id
+		LIMIT $4
+	`
+
+	rows, err := p.db.Query(query, consts.Pending, consts.Processing, changedBefore, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get stale transactions: %w", err)
+	}
+	defer rows.Close()
+
+	var ids []int
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			return nil, fmt.Errorf("failed to scan transaction ID: %w", err)
+		}
+		ids = append(ids, id)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating stale transactions: %w", err)
+	}
+
+	return ids, nil
+}
+
+// ExpireStaleTransaction fails a transaction still pending or processing that last changed before
+// the given time, returning sql.ErrNoRows when it settled or changed meanwhile. The status is
+// checked in the same statement, so a transaction completed by a concurrent callback is never failed.
+func (p *PostgresDB) ExpireStaleTransaction(txID int, changedBefore time.Time, errorMsg, declineCode string) error {
+	query := `
+		UPDATE transactions
+		SET status = $1, error_message = $2, decline_code = $3, updated_at = CURRENT_TIMESTAMP
+		WHERE id = $4 AND status IN ($5, $6) AND COALESCE(updated_at, created_at) < $7 AND deleted_at IS NULL
+	`
+
+	result, err := p.db.Exec(query, consts.Failed, errorMsg, declineCode, txID, consts.Pending, consts.Processing, changedBefore)
+	if err != nil {
+		return fmt.Errorf("failed to expire stale transaction: %w", err)
+	}
+	if n, err := result.RowsAffected(); err != nil {
+		return fmt.Errorf("failed to expire stale transaction: %w", err)
+	} else if n == 0 {
+		return sql.ErrNoRows
+	}
+
+	return nil
+}
+
+// ClaimDueScheduledTransactions moves up to limit scheduled transactions whose payout is due
+// before the given time to pending, returning their IDs. Claimed rows are locked so concurrent
+// runs on other instances claim different transactions.
+func (p *PostgresDB) ClaimDueScheduledTransactions(before time.Time, limit int) ([]int, error) {
+	query := `
+		UPDATE transactions
+		SET status = $1, updated_at = CURRENT_TIMESTAMP
+		WHERE id IN (
+			SELECT id FROM transactions
+			WHERE status = $2 AND scheduled_for <= $3 AND deleted_at IS NULL
+			ORDER BY scheduled_for, id
+			LIMIT $4
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING id
+	`
+
+	rows, err := p.db.Query(query, consts.Pending, consts.Scheduled, before, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to claim scheduled transactions: %w", err)
+	}
+	defer rows.Close()
+
+	var ids []int
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			return nil, fmt.Errorf("failed to scan transaction ID: %w", err)
+		}
+		ids = append(ids, id)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating scheduled transactions: %w", err)
+	}
+
+	return ids, nil
+}
+
+// RescheduleTransaction returns a claimed withdrawal to scheduled, waiting for a later payout. It
+// returns sql.ErrNoRows when the withdrawal is no longer pending.
+func (p *PostgresDB) RescheduleTransaction(txID int, scheduledFor time.Time, expectedSettlementDate string) error {
+	query := `
+		UPDATE transactions
+		SET status = $1, scheduled_for = $2, expected_settlement_date = $3, updated_at = CURRENT_TIMESTAMP
+		WHERE id = $4 AND status = $5
+	`
+
+	result, err := p.db.Exec(query, consts.Scheduled, scheduledFor, sql.NullString{String: expectedSettlementDate, Valid: expectedSettlementDate != ""}, txID, consts.Pending)
+	if err != nil {
+		return fmt.Errorf("failed to reschedule transaction: %w", err)
+	}
+	if n, err := result.RowsAffected(); err != nil {
+		return fmt.Errorf("failed to reschedule transaction: %w", err)
+	} else if n == 0 {
+		return sql.ErrNoRows
+	}
+
+	return nil
+}
+
+// AuthorizeTransaction records a pending or processing deposit as authorized for its amount
+// until expiresAt. It returns sql.ErrNoRows when the deposit is in any other status, so a late
+// report of the authorization never reopens a deposit already captured or voided.
+func (p *PostgresDB) AuthorizeTransaction(txID int, expiresAt time.Time) error {
+	query := `
+		UPDATE transactions
+		SET status = $1, authorized_amount = amount, authorization_expires_at = $2, updated_at = CURRENT_TIMESTAMP
+		WHERE id = $3 AND status IN ($4, $5) AND deleted_at IS NULL
+	`
+
+	result, err := p.db.Exec(query, consts.Authorized, expiresAt, txID, consts.Pending, consts.Processing)
+	if err != nil {
+		return fmt.Errorf("failed to authorize transaction: %w", err)
+	}
+	if n, err := result.RowsAffected(); err != nil {
+		return fmt.Errorf("failed to authorize transaction: %w", err)
+	} else if n == 0 {
+		return sql.ErrNoRows
+	}
+
+	return nil
+}
+
+// ClaimAuthorizedTransaction moves an authorized deposit to processing while it is captured or
+// voided, returning sql.ErrNoRows when it isn't authorized. Only one capture, void or expiry
+// claims an authorization.
+func (p *PostgresDB) ClaimAuthorizedTransaction(txID int) error {
+	query := `
+		UPDATE transactions
+		SET status = $1, updated_at = CURRENT_TIMESTAMP
+		WHERE id = $2 AND status = $3 AND deleted_at IS NULL
+	`
+
+	result, err := p.db.Exec(query, consts.Processing, txID, consts.Authorized)
+	if err != nil {
+		return fmt.Errorf("failed to claim authorized transaction: %w", err)
+	}
+	if n, err := result.RowsAffected(); err != nil {
+		return fmt.Errorf("failed to claim authorized transaction: %w", err)
+	} else if n == 0 {
+		return sql.ErrNoRows
+	}
+
+	return nil
+}
+
+// CaptureTransaction records the final capture of a claimed authorization, the amount captured by
+// all its captures and its status. It returns sql.ErrNoRows when the deposit isn't claimed.
+func (p *PostgresDB) CaptureTransaction(txID int, amount, capturedAmount float64, status string) error {
+	query := `
+		UPDATE transactions
+		SET amount = $1, captured_amount = $2, status = $3, error_message = '', updated_at = CURRENT_TIMESTAMP
+		WHERE id = $4 AND status = $5 AND deleted_at IS NULL
+	`
+
+	result, err := p.db.Exec(query, amount, capturedAmount, status, txID, consts.Processing)
+	if err != nil {
+		return fmt.Errorf("failed to capture transaction: %w", err)
+	}
+	if n, err := result.RowsAffected(); err != nil {
+		return fmt.Errorf("failed to capture transaction: %w", err)
+	} else if n == 0 {
+		return sql.ErrNoRows
+	}
+
+	return nil
+}
+
+// RecordPartialCapture records the amount captured so far of a claimed authorization and returns it
+// to authorized for further captures. It returns sql.ErrNoRows when the deposit isn't claimed.
+func (p *PostgresDB) RecordPartialCapture(txID int, capturedAmount float64) error {
+	query := `
+		UPDATE transactions
+		SET captured_amount = $1, status = $2, error_message = '', updated_at = CURRENT_TIMESTAMP
+		WHERE id = $3 AND status = $4 AND deleted_at IS NULL
+	`
+
+	result, err := p.db.Exec(query, capturedAmount, consts.Authorized, txID, consts.Processing)
+	if err != nil {
+		return fmt.Errorf("failed to record partial capture: %w", err)
+	}
+	if n, err := result.RowsAffected(); err != nil {
+		return fmt.Errorf("failed to record partial capture: %w", err)
+	} else if n == 0 {
+		return sql.ErrNoRows
+	}
+
+	return nil
+}
+
+// IncrementAuthorization records the new amount held for a claimed authorization and returns it to
+// authorized. It returns sql.ErrNoRows when the deposit isn't claimed.
+func (p *PostgresDB) IncrementAuthorization(txID int, amount float64) error {
+	query := `
+		UPDATE transactions
+		SET amount = $1, authorized_amount = $1, status = $2, error_message = '', updated_at = CURRENT_TIMESTAMP
+		WHERE id = $3 AND status = $4 AND deleted_at IS NULL
+	`
+
+	result, err := p.db.Exec(query, amount, consts.Authorized, txID, consts.Processing)
+	if err != nil {
+		return fmt.Errorf("failed to increment authorization: %w", err)
+	}
+	if n, err := result.RowsAffected(); err != nil {
+		return fmt.Errorf("failed to increment authorization: %w", err)
+	} else if n == 0 {
+		return sql.ErrNoRows
+	}
+
+	return nil
+}
+
+// ExpireAuthorizations voids up to limit authorized deposits whose authorization expired before
+// the given time, returning their IDs. Rows are locked and their status checked in the same
+// statement, so a deposit being captured is never voided.
+func (p *PostgresDB) ExpireAuthorizations(before time.Time, errorMsg string, limit int) ([]int, error) {
+	query := `
+		UPDATE transactions
+		SET status = $1, error_message = $2, updated_at = CURRENT_TIMESTAMP
+		WHERE id IN (
+			SELECT id FROM transactions
+			WHERE status = $3 AND authorization_expires_at < $4 AND deleted_at IS NULL
+			ORDER BY authorization_expires_at, id
+			LIMIT $5
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING id
+	`
+
+	rows, err := p.db.Query(query, consts.Voided, errorMsg, consts.Authorized, before, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to expire authorizations: %w", err)
+	}
+	defer rows.Close()
+
+	var ids []int
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			return nil, fmt.Errorf("failed to scan transaction ID: %w", err)
+		}
+		ids = append(ids, id)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating expired authorizations: %w", err)
+	}
+
+	return ids, nil
+}
+
+// UpdateTransactionIdempotencyKey records the idempotency key used for gateway calls
+func (p *PostgresDB) UpdateTransactionIdempotencyKey(txID int, key string) error {
+	query := `
+		UPDATE transactions
+		SET gateway_idempotency_key = $1, updated_at = CURRENT_TIMESTAMP
+		WHERE id = $2
+	`
+
+	_, err := p.db.Exec(query, key, txID)
+	if err != nil {
+		return fmt.Errorf("failed to update transaction idempotency key: %w", err)
+	}
+
+	return nil
+}
+
+// UpdateTransactionDeclineCode records the normalized reason a provider declined a transaction
+func (p *PostgresDB) UpdateTransactionDeclineCode(txID int, declineCode string) error {
+	query := `
+		UPDATE transactions
+		SET decline_code = $1, updated_at = CURRENT_TIMESTAMP
+		WHERE id = $2
+	`
+
+	_, err := p.db.Exec(query, declineCode, txID)
+	if err != nil {
+		return fmt.Errorf("failed to update transaction decline code: %w", err)
+	}
+
+	return nil
+}
+
+// SoftDeleteTransaction marks a transaction as deleted so it is hidden from lookups
+// and archived on the next retention run
+func (p *PostgresDB) SoftDeleteTransaction(txID int) error {
+	query := `
+		UPDATE transactions
+		SET deleted_at = CURRENT_TIMESTAMP, updated_at = CURRENT_TIMESTAMP
+		WHERE id = $1 AND deleted_at IS NULL
+	`
+
+	result, err := p.db.Exec(query, txID)
+	if err != nil {
+		return fmt.Errorf("failed to soft delete transaction: %w", err)
+	}
+
+	if rows, err := result.RowsAffected(); err == nil && rows == 0 {
+		return fmt.Errorf("transaction not found: %w", sql.ErrNoRows)
+	}
+
+	return nil
+}
+
+// ArchiveTransactions moves up to limit soft-deleted transactions, and completed, failed or voided
+// transactions created before the cutoff, into transactions_archive together with their
+// routing decisions. When purgePII is set the beneficiary, phone number and redirect URLs are not copied.
+func (p *PostgresDB) ArchiveTransactions(cutoff time.Time, purgePII bool, limit int) (int64, error) {
+	tx, err := p.db.Begin()
+	if err != nil {
+		return 0, fmt.Errorf("failed to begin archival: %w", err)
+	}
+	defer tx.Rollback()
+
+	// Lock the candidate rows; SKIP LOCKED lets concurrent runs on other instances take different rows
+	rows, err := tx.Query(`
+		SELECT id FROM transactions
+		WHERE deleted_at IS NOT NULL
+		   OR (status IN ($1, $2, $5) AND created_at < $3)
+		ORDER BY id
+		LIMIT $4
+		FOR UPDATE SKIP LOCKED
+	`, consts.Completed, consts.Failed, cutoff, limit, consts.Voided)
+	if err != nil {
+		return 0, fmt.Errorf("failed to select transactions for archival: %w", err)
+	}
+
+	var ids []int64
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return 0, fmt.Errorf("failed to scan transaction ID: %w", err)
+		}
+		ids = append(ids, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, fmt.Errorf("error iterating transactions for archival: %w", err)
+	}
+
+	if len(ids) == 0 {
+		return 0, nil
+	}
+
+	_, err = tx.Exec(`
+		INSERT INTO transactions_archive (
+			id, amount, currency, type, status, beneficiary, phone_number, phone_e164, return_url, cancel_url, redirect_url,
+			reference_id, gateway_reference, gateway_idempotency_key, error_message, decline_code, retry_of_id, country_source, risk_flags,
+			livemode, created_at, updated_at, deleted_at, gateway_id, country_id, user_id, routing_decisions, pii_purged, archived_at
+		)
+		SELECT t.id, t.amount, t.currency, t.type, t.status,
+			   CASE WHEN $2 THEN NULL ELSE t.beneficiary END,
+			   CASE WHEN $2 THEN NULL ELSE t.phone_number END,
+			   CASE WHEN $2 THEN NULL ELSE t.phone_e164 END,
+			   CASE WHEN $2 THEN NULL ELSE t.return_url END,
+			   CASE WHEN $2 THEN NULL ELSE t.cancel_url END,
+			   CASE WHEN $2 THEN NULL ELSE t.redirect_url END,
+			   t.reference_id, t.gateway_reference, t.gateway_idempotency_key, t.error_message, t.decline_code, t.retry_of_id, t.country_source,
+			   t.risk_flags, t.livemode, t.created_at, t.updated_at,
+			   t.deleted_at, t.gateway_id, t.country_id, t.user_id,
+			   COALESCE((SELECT jsonb_agg(to_jsonb(r) ORDER BY r.id) FROM routing_decisions r WHERE r.transaction_id = t.id), '[]'),
+			   $2, CURRENT_TIMESTAMP
+		FROM transactions t
+		WHERE t.id = ANY($1)
+		ON CONFLICT (id) DO NOTHING
+	`, pq.Int64Array(ids), purgePII)
+	if err != nil {
+		return 0, fmt.Errorf("failed to copy transactions to archive: %w", err)
+	}
+
+	if _, err := tx.Exec(`DELETE FROM routing_decisions WHERE transaction_id = ANY($1)`, pq.Int64Array(ids)); err != nil {
+		return 0, fmt.Errorf("failed to delete archived routing decisions: %w", err)
+	}
+
+	result, err := tx.Exec(`DELETE FROM transactions WHERE id = ANY($1)`, pq.Int64Array(ids))
+	if err != nil {
+		return 0, fmt.Errorf("failed to delete archived transactions: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("failed to commit archival: %w", err)
+	}
+
+	return result.RowsAffected()
+}
+
+// GetArchivalStats returns the sizes of the hot and archive transaction tables
+func (p *PostgresDB) GetArchivalStats() (*models.ArchivalStats, error) {
+	var stats models.ArchivalStats
+
+	err := p.db.QueryRow(`SELECT COUNT(*), COUNT(deleted_at) FROM transactions`).Scan(
+		&stats.HotTransactions,
+		&stats.SoftDeletedTransactions,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to count transactions: %w", err)
+	}
+
+	var lastArchivedAt sql.NullTime
+	err = p.db.QueryRow(`SELECT COUNT(*), MAX(archived_at) FROM transactions_archive`).Scan(
+		&stats.ArchivedTransactions,
+		&lastArchivedAt,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to count archived transactions: %w", err)
+	}
+
+	if lastArchivedAt.Valid {
+		stats.LastArchivedAt = lastArchivedAt.Time
+	}
+
+	return &stats, nil
+}
+
+// CreateAPIKey stores a merchant API key
+func (p *PostgresDB) CreateAPIKey(key models.APIKey) (int, error) {
+	query := `
+		INSERT INTO api_keys (merchant_id, name, key_prefix, key_hash, scope, status, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+		RETURNING id
+	`
+
+	var id int
+	err := p.db.QueryRow(query, key.MerchantID, key.Name, key.Prefix, key.KeyHash, key.Scope, key.Status, key.CreatedAt).Scan(&id)
+	if err != nil {
+		return 0, fmt.Errorf("failed to create API key: %w", err)
+	}
+
+	return id, nil
+}
+
+// apiKeyColumns lists the api_keys columns read by scanAPIKey
+const apiKeyColumns = `id, merchant_id, name, key_prefix, key_hash, scope, status, created_at, last_used_at, expires_at, revoked_at`
+
+// scanAPIKey scans a row selected with apiKeyColumns
+func scanAPIKey(row interface{ Scan(...interface{}) error }) (*models.APIKey, error) {
+	var key models.APIKey
+	var lastUsedAt, expiresAt, revokedAt sql.NullTime
+
+	if err := row.Scan(
+		&key.ID,
+		&key.MerchantID,
+		&key.Name,
+		&key.Prefix,
+		&key.KeyHash,
+		&key.Scope,
+		&key.Status,
+		&key.CreatedAt,
+		&lastUsedAt,
+		&expiresAt,
+		&revokedAt,
+	); err != nil {
+		return nil, err
+	}
+
+	if lastUsedAt.Valid {
+		key.LastUsedAt = lastUsedAt.Time
+	}
+	if expiresAt.Valid {
+		key.ExpiresAt = expiresAt.Time
+	}
+	if revokedAt.Valid {
+		key.RevokedAt = revokedAt.Time
+	}
+
+	return &key, nil
+}
+
+// GetAPIKeyByPrefix fetches an API key by its public prefix
+func (p *PostgresDB) GetAPIKeyByPrefix(prefix string) (*models.APIKey, error) {
+	query := `SELECT ` + apiKeyColumns + ` FROM api_keys WHERE key_prefix = $1`
+
+	key, err := scanAPIKey(p.db.QueryRow(query, prefix))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, fmt.Errorf("API key not found: %w", err)
+		}
+		return nil, fmt.Errorf("failed to fetch API key: %w", err)
+	}
+
+	return key, nil
+}
+
+// GetAPIKeysByMerchant fetches a merchant's API keys, oldest first
+func (p *PostgresDB) GetAPIKeysByMerchant(merchantID int) ([]models.APIKey, error) {
+	query := `SELECT ` + apiKeyColumns + ` FROM api_keys WHERE merchant_id = $1 ORDER BY id`
+
+	rows, err := p.db.Query(query, merchantID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch API keys: %w", err)
+	}
+	defer rows.Close()
+
+	var keys []models.APIKey
+	for rows.Next() {
+		key, err := scanAPIKey(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan API key: %w", err)
+		}
+		keys = append(keys, *key)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating API keys: %w", err)
+	}
+
+	return keys, nil
+}
+
+// RevokeAPIKey revokes an active API key of a merchant
+func (p *PostgresDB) RevokeAPIKey(merchantID, keyID int) error {
+	query := `
+		UPDATE api_keys
+		SET status = $1, revoked_at = $2
+		WHERE id = $3 AND merchant_id = $4 AND status = $5
+	`
+
+	result, err := p.db.Exec(query, consts.APIKeyRevoked, time.Now(), keyID, merchantID, consts.APIKeyActive)
+	if err != nil {
+		return fmt.Errorf("failed to revoke API key: %w", err)
+	}
+	if rows, err := result.RowsAffected(); err == nil && rows == 0 {
+		return sql.ErrNoRows
+	}
+
+	return nil
+}
+
+// ExpireAPIKey schedules an active API key of a merchant to stop working
+func (p *PostgresDB) ExpireAPIKey(merchantID, keyID int, expiresAt time.Time) error {
+	query := `
+		UPDATE api_keys
+		SET expires_at = $1
+		WHERE id = $2 AND merchant_id = $3 AND status = $4
+	`
+
+	result, err := p.db.Exec(query, expiresAt, keyID, merchantID, consts.APIKeyActive)
+	if err != nil {
+		return fmt.Errorf("failed to expire API key: %w", err)
+	}
+	if rows, err := result.RowsAffected(); err == nil && rows == 0 {
+		return sql.ErrNoRows
+	}
+
+	return nil
+}
+
+// TouchAPIKey records when an API key was last used
+func (p *PostgresDB) TouchAPIKey(keyID int, usedAt time.Time) error {
+	if _, err := p.db.Exec(`UPDATE api_keys SET last_used_at = $1 WHERE id = $2`, usedAt, keyID); err != nil {
+		return fmt.Errorf("failed to update API key usage: %w", err)
+	}
+	return nil
+}
+
+// CreateOAuthClient stores an OAuth2 client
+func (p *PostgresDB) CreateOAuthClient(client models.OAuthClient) (int, error) {
+	query := `
+		INSERT INTO oauth_clients (client_id, merchant_id, name, secret_hash, scopes, status, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+		RETURNING id
+	`
+
+	var id int
+	err := p.db.QueryRow(query, client.ClientID, client.MerchantID, client.Name, client.SecretHash,
+		pq.Array(client.Scopes), client.Status, client.CreatedAt).Scan(&id)
+	if err != nil {
+		return 0, fmt.Errorf("failed to create OAuth client: %w", err)
+	}
+
+	return id, nil
+}
+
+// GetOAuthClientByClientID fetches an OAuth2 client by its client ID
+func (p *PostgresDB) GetOAuthClientByClientID(clientID string) (*models.OAuthClient, error) {
+	query := `
+		SELECT id, client_id, merchant_id, name, secret_hash, scopes, status, created_at
+		FROM oauth_clients
+		WHERE client_id = $1
+	`
+
+	var client models.OAuthClient
+	err := p.db.QueryRow(query, clientID).Scan(
+		&client.ID,
+		&client.ClientID,
+		&client.MerchantID,
+		&client.Name,
+		&client.SecretHash,
+		pq.Array(&client.Scopes),
+		&client.Status,
+		&client.CreatedAt,
+	)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, fmt.Errorf("OAuth client not found: %w", err)
+		}
+		return nil, fmt.Errorf("failed to fetch OAuth client: %w", err)
+	}
+
+	return &client, nil
+}
+
+// CreateCallbackRecord stores a callback as received
+func (p *PostgresDB) CreateCallbackRecord(record models.CallbackRecord) (int, error) {
+	headers, err := json.Marshal(record.Headers)
+	if err != nil {
+		return 0, fmt.Errorf("failed to marshal callback headers: %w", err)
+	}
+
+	query := `
+		INSERT INTO callback_records (gateway_id, headers, body, status, created_at)
+		VALUES ($1, $2, $3, $4, $5)
+		RETURNING id
+	`
+
+	var id int
+	err = p.db.QueryRow(query, record.GatewayID, headers, []byte(record.Body), record.Status, record.CreatedAt).Scan(&id)
+	if err != nil {
+		return 0, fmt.Errorf("failed to create callback record: %w", err)
+	}
+
+	return id, nil
+}
+
+// GetCallbackRecordByID fetches a stored callback
+func (p *PostgresDB) GetCallbackRecordByID(recordID int) (*models.CallbackRecord, error) {
+	query := `
+		SELECT id, gateway_id, headers, body, status, error, transaction_id, attempts, created_at, processed_at
+		FROM callback_records
+		WHERE id = $1
+	`
+
+	var record models.CallbackRecord
+	var headers, body []byte
+	var errorMsg sql.NullString
+	var transactionID sql.NullInt64
+	var processedAt sql.NullTime
+
+	err := p.db.QueryRow(query, recordID).Scan(
+		&record.ID,
+		&record.GatewayID,
+		&headers,
+		&body,
+		&record.Status,
+		&errorMsg,
+		&transactionID,
+		&record.Attempts,
+		&record.CreatedAt,
+		&processedAt,
+	)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, fmt.Errorf("callback record not found: %w", err)
+		}
+		return nil, fmt.Errorf("failed to fetch callback record: %w", err)
+	}
+
+	if err := json.Unmarshal(headers, &record.Headers); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal callback headers: %w", err)
+	}
+	record.Body = string(body)
+	record.Error = errorMsg.String
+	record.TransactionID = int(transactionID.Int64)
+	if processedAt.Valid {
+		record.ProcessedAt = processedAt.Time
+	}
+
+	return &record, nil
+}
+
+// UpdateCallbackRecord records the outcome of parsing and processing a stored callback
+func (p *PostgresDB) UpdateCallbackRecord(recordID int, status, errorMsg string, transactionID int) error {
+	var txID sql.NullInt64
+	if transactionID > 0 {
+		txID = sql.NullInt64{Int64: int64(transactionID), Valid: true}
+	}
+
+	query := `
+		UPDATE callback_records
+		SET status = $1, error = NULLIF($2, ''), transaction_id = COALESCE($3, transaction_id),
+		    attempts = attempts + 1, processed_at = $4
+		WHERE id = $5
+	`
+
+	result, err := p.db.Exec(query, status, errorMsg, txID, time.Now(), recordID)
+	if err != nil {
+		return fmt.Errorf("failed to update callback record: %w", err)
+	}
+	if rows, err := result.RowsAffected(); err == nil && rows == 0 {
+		return fmt.Errorf("callback record not found: %w", sql.ErrNoRows)
+	}
+
+	return nil
+}
+
+// CreateOutboxMessages records outbox messages in a single transaction. Messages whose
+// dedup token was already recorded for the same destination are skipped.
+func (p *PostgresDB) CreateOutboxMessages(messages []models.OutboxMessage) error {
+	if len(messages) == 0 {
+		return nil
+	}
+
+	tx, err := p.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin outbox transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	query := `
+		INSERT INTO outbox_messages (
+			destination, event_type, transaction_id, merchant_id, dedup_token,
+			content_type, payload, status, next_attempt_at, created_at, endpoint_id
+		) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)
+		ON CONFLICT (destination, endpoint_id, dedup_token) DO NOTHING
+	`
+
+	now := time.Now()
+	for _, msg := range messages {
+		var merchantID sql.NullInt64
+		if msg.MerchantID > 0 {
+			merchantID = sql.NullInt64{Int64: int64(msg.MerchantID), Valid: true}
+		}
+		if _, err := tx.Exec(query,
+			msg.Destination,
+			msg.EventType,
+			msg.TransactionID,
+			merchantID,
+			msg.DedupToken,
+			msg.ContentType,
+			[]byte(msg.Payload),
+			consts.OutboxPending,
+			now,
+			now,
+			msg.EndpointID,
+		); err != nil {
+			return fmt.Errorf("failed to create outbox message: %w", err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit outbox messages: %w", err)
+	}
+	return nil
+}
+
+// GetPendingOutboxMessages returns pending messages for a destination that are due for delivery, oldest first
+func (p *PostgresDB) GetPendingOutboxMessages(destination string, now time.Time, limit int) ([]models.OutboxMessage, error) {
+	query := `
+		SELECT id, destination, event_type, transaction_id, merchant_id, dedup_token,
+		       content_type, payload, status, attempts, last_error, next_attempt_at, created_at, endpoint_id
+		FROM outbox_messages
+		WHERE destination = $1 AND status = $2 AND next_attempt_at <= $3
+		ORDER BY id
+		LIMIT $4
+	`
+
+	rows, err := p.db.Query(query, destination, consts.OutboxPending, now, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch outbox messages: %w", err)
+	}
+	defer rows.Close()
+
+	return scanOutboxMessages(rows)
+}
+
+// GetOutboxMessage returns an outbox message by ID
+func (p *PostgresDB) GetOutboxMessage(messageID int) (*models.OutboxMessage, error) {
+	query := `
+		SELECT id, destination, event_type, transaction_id, merchant_id, dedup_token,
+		       content_type, payload, status, attempts, last_error, next_attempt_at, created_at, endpoint_id
+		FROM outbox_messages
+		WHERE id = $1
+	`
+
+	rows, err := p.db.Query(query, messageID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch outbox message: %w", err)
+	}
+	defer rows.Close()
+
+	messages, err := scanOutboxMessages(rows)
+	if err != nil {
+		return nil, err
+	}
+	if len(messages) == 0 {
+		return nil, sql.ErrNoRows
+	}
+	return &messages[0], nil
+}
+
+// ListOutboxMessages returns up to limit messages with the given status, oldest first. An empty
+// destination matches every destination.
+func (p *PostgresDB) ListOutboxMessages(destination, status string, limit int) ([]models.OutboxMessage, error) {
+	query := `
+		SELECT id, destination, event_type, transaction_id, merchant_id, dedup_token,
+		       content_type, payload, status, attempts, last_error, next_attempt_at, created_at, endpoint_id
+		FROM outbox_messages
+		WHERE ($1 = '' OR destination = $1) AND status = $2
+		ORDER BY id
+		LIMIT $3
+	`
+
+	rows, err := p.db.Query(query, destination, status, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list outbox messages: %w", err)
+	}
+	defer rows.Close()
+
+	return scanOutboxMessages(rows)
+}
+
+// scanOutboxMessages reads outbox messages selected with the columns used by the queries above
+func scanOutboxMessages(rows *sql.Rows) ([]models.OutboxMessage, error) {
+	var messages []models.OutboxMessage
+	for rows.Next() {
+		var msg models.OutboxMessage
+		var merchantID sql.NullInt64
+		var lastError sql.NullString
+		var payload []byte
+
+		if err := rows.Scan(
+			&msg.ID,
+			&msg.Destination,
+			&msg.EventType,
+			&msg.TransactionID,
+			&merchantID,
+			&msg.DedupToken,
+			&msg.ContentType,
+			&payload,
+			&msg.Status,
+			&msg.Attempts,
+			&lastError,
+			&msg.NextAttemptAt,
+			&msg.CreatedAt,
+			&msg.EndpointID,
+		); err != nil {
+			return nil, fmt.Errorf("failed to scan outbox message: %w", err)
+		}
+
+		msg.MerchantID = int(merchantID.Int64)
+		msg.LastError = lastError.String
+		msg.Payload = payload
+		messages = append(messages, msg)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating outbox messages: %w", err)
+	}
+
+	return messages, nil
+}
+
+// MarkOutboxMessageDelivered marks an outbox message as delivered
+func (p *PostgresDB) MarkOutboxMessageDelivered(messageID int) error {
+	query := `
+		UPDATE outbox_messages
+		SET status = $1, attempts = attempts + 1, delivered_at = $2, last_error = NULL
+		WHERE id = $3
+	`
+
+	if _, err := p.db.Exec(query, consts.OutboxDelivered, time.Now(), messageID); err != nil {
+		return fmt.Errorf("failed to mark outbox message delivered: %w", err)
+	}
+	return nil
+}
+
+// MarkOutboxMessageRetry records a failed delivery attempt, scheduling the next one or marking the message failed
+func (p *PostgresDB) MarkOutboxMessageRetry(messageID int, errorMsg string, nextAttemptAt time.Time, failed bool) error {
+	status := consts.OutboxPending
+	if failed {
+		status = consts.OutboxFailed
+	}
+
+	query := `
+		UPDATE outbox_messages
+		SET status = $1, attempts = attempts + 1, last_error = $2, next_attempt_at = $3
+		WHERE id = $4
+	`
+
+	if _, err := p.db.Exec(query, status, errorMsg, nextAttemptAt, messageID); err != nil {
+		return fmt.Errorf("failed to update outbox message: %w", err)
+	}
+	return nil
+}
+
+// RequeueOutboxMessage makes a pending or failed outbox message due at nextAttemptAt with its
+// attempt count reset. Returns sql.ErrNoRows if there is no such message in either status.
+func (p *PostgresDB) RequeueOutboxMessage(messageID int, nextAttemptAt time.Time) error {
+	query := `
+		UPDATE outbox_messages
+		SET status = $1, attempts = 0, next_attempt_at = $2
+		WHERE id = $3 AND status IN ($1, $4)
+	`
+
+	return p.execOutboxUpdate(query, consts.OutboxPending, nextAttemptAt, messageID, consts.OutboxFailed)
+}
+
+// DiscardOutboxMessage stops delivery of a pending or failed outbox message. Returns
+// sql.ErrNoRows if there is no such message in either status.
+func (p *PostgresDB) DiscardOutboxMessage(messageID int) error {
+	query := `
+		UPDATE outbox_messages
+		SET status = $1
+		WHERE id = $2 AND status IN ($3, $4)
+	`
+
+	return p.execOutboxUpdate(query, consts.OutboxDiscarded, messageID, consts.OutboxPending, consts.OutboxFailed)
+}
+
+// execOutboxUpdate runs an update of a single outbox message, returning sql.ErrNoRows when no row matched
+func (p *PostgresDB) execOutboxUpdate(query string, args ...interface{}) error {
+	result, err := p.db.Exec(query, args...)
+	if err != nil {
+		return fmt.Errorf("failed to update outbox message: %w", err)
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to update outbox message: %w", err)
+	}
+	if rows == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// CreateDispute stores a new dispute
+func (p *PostgresDB) CreateDispute(dispute models.Dispute) (int, error) {
+	query := `
+		INSERT INTO disputes (transaction_id, user_id, description, status, created_at, updated_at)
+		VALUES ($1, $2, $3, $4, $5, $5)
+		RETURNING id
+	`
+
+	var id int
+	err := p.db.QueryRow(query,
+		dispute.TransactionID,
+		dispute.UserID,
+		sql.NullString{String: dispute.Description, Valid: dispute.Description != ""},
+		dispute.Status,
+		dispute.CreatedAt,
+	).Scan(&id)
+	if err != nil {
+		return 0, fmt.Errorf("failed to create dispute: %w", err)
+	}
+
+	return id, nil
+}
+
+// GetDisputeByID fetches a dispute
+func (p *PostgresDB) GetDisputeByID(disputeID int) (*models.Dispute, error) {
+	return p.getDispute("id", disputeID)
+}
+
+// GetDisputeByTransaction fetches the dispute of a transaction
+func (p *PostgresDB) GetDisputeByTransaction(txID int) (*models.Dispute, error) {
+	return p.getDispute("transaction_id", txID)
+}
+
+// getDispute fetches the dispute whose column equals value, returning sql.ErrNoRows when there is none
+func (p *PostgresDB) getDispute(column string, value int) (*models.Dispute, error) {
+	query := `
+		SELECT id, transaction_id, user_id, description, status, resolution, created_at, updated_at
+		FROM disputes
+		WHERE ` + column + ` = $1
+	`
+
+	rows, err := p.db.Query(query, value)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch dispute: %w", err)
+	}
+	defer rows.Close()
+
+	disputes, err := scanDisputes(rows)
+	if err != nil {
+		return nil, err
+	}
+	if len(disputes) == 0 {
+		return nil, sql.ErrNoRows
+	}
+	return &disputes[0], nil
+}
+
+// ListDisputes returns up to limit disputes with the given status, oldest first
+func (p *PostgresDB) ListDisputes(status string, limit int) ([]models.Dispute, error) {
+	query := `
+		SELECT id, transaction_id, user_id, description, status, resolution, created_at, updated_at
+		FROM disputes
+		WHERE status = $1
+		ORDER BY created_at, id
+		LIMIT $2
+	`
+
+	rows, err := p.db.Query(query, status, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list disputes: %w", err)
+	}
+	defer rows.Close()
+
+	return scanDisputes(rows)
+}
+
+// scanDisputes reads disputes selected with the columns used by the queries above
+func scanDisputes(rows *sql.Rows) ([]models.Dispute, error) {
+	var disputes []models.Dispute
+	for rows.Next() {
+		var dispute models.Dispute
+		var description, resolution sql.NullString
+
+		if err := rows.Scan(
+			&dispute.ID,
+			&dispute.TransactionID,
+			&dispute.UserID,
+			&description,
+			&dispute.Status,
+			&resolution,
+			&dispute.CreatedAt,
+			&dispute.UpdatedAt,
+		); err != nil {
+			return nil, fmt.Errorf("failed to scan dispute: %w", err)
+		}
+
+		dispute.Description = description.String
+		dispute.Resolution = resolution.String
+		disputes = append(disputes, dispute)
+	}
+
+	return disputes, rows.Err()
+}
+
+// UpdateDisputeStatus moves an open or in-review dispute to a new status, returning
+// sql.ErrNoRows when no such dispute exists or it is already closed
+func (p *PostgresDB) UpdateDisputeStatus(disputeID int, status, resolution string, updatedAt time.Time) error {
+	query := `
+		UPDATE disputes
+		SET status = $1, resolution = $2, updated_at = $3
+		WHERE id = $4 AND status IN ($5, $6)
+	`
+
+	result, err := p.db.Exec(query, status, sql.NullString{String: resolution, Valid: resolution != ""}, updatedAt, disputeID, consts.DisputeOpen, consts.DisputeInReview)
+	if err != nil {
+		return fmt.Errorf("failed to update dispute: %w", err)
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to update dispute: %w", err)
+	}
+	if rows == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// CreateTransactionHold places a hold on a deposit, returning sql.ErrNoRows when the deposit is
+// already held
+func (p *PostgresDB) CreateTransactionHold(hold models.TransactionHold) (int, error) {
+	query := `
+		INSERT INTO transaction_holds (transaction_id, user_id, amount, currency, livemode, reason, status, release_at, created_at, updated_at)
+		SELECT $1, $2, $3, $4, $5, $6, $7, $8, $9, $9
+		WHERE NOT EXISTS (SELECT 1 FROM transaction_holds WHERE transaction_id = $1 AND status = $7)
+		ON CONFLICT DO NOTHING
+		RETURNING id
+	`
+
+	var id int
+	err := p.db.QueryRow(query,
+		hold.TransactionID,
+		hold.UserID,
+		hold.Amount,
+		hold.Currency,
+		hold.Livemode,
+		hold.Reason,
+		hold.Status,
+		hold.ReleaseAt,
+		hold.CreatedAt,
+	).Scan(&id)
+	if err == sql.ErrNoRows {
+		return 0, err
+	}
+	if err != nil {
+		return 0, fmt.Errorf("failed to create transaction hold: %w", err)
+	}
+
+	return id, nil
+}
+
+// GetTransactionHold fetches the latest hold placed on a transaction, returning sql.ErrNoRows when
+// there is none
+func (p *PostgresDB) GetTransactionHold(txID int) (*models.TransactionHold, error) {
+	query := `
+		SELECT ` + transactionHoldColumns + `
+		FROM transaction_holds
+		WHERE transaction_id = $1
+		ORDER BY created_at DESC, id DESC
+		LIMIT 1
+	`
+
+	rows, err := p.db.Query(query, txID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch transaction hold: %w", err)
+	}
+	defer rows.Close()
+
+	holds, err := scanTransactionHolds(rows)
+	if err != nil {
+		return nil, err
+	}
+	if len(holds) == 0 {
+		return nil, sql.ErrNoRows
+	}
+	return &holds[0], nil
+}
+
+// ListTransactionHolds returns up to limit transaction holds with the given status, oldest first
+func (p *PostgresDB) ListTransactionHolds(status string, limit int) ([]models.TransactionHold, error) {
+	query := `
+		SELECT ` + transactionHoldColumns + `
+		FROM transaction_holds
+		WHERE status = $1
+		ORDER BY created_at, id
+		LIMIT $2
+	`
+
+	rows, err := p.db.Query(query, status, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list transaction holds: %w", err)
+	}
+	defer rows.Close()
+
+	return scanTransactionHolds(rows)
+}
+
+// transactionHoldColumns are the columns scanTransactionHolds reads
+const transactionHoldColumns = `id, transaction_id, user_id, amount, currency, livemode, reason, status, release_at,
+			   release_note, released_at, created_at, updated_at`
+
+// scanTransactionHolds reads transaction holds selected with transactionHoldColumns
+func scanTransactionHolds(rows *sql.Rows) ([]models.TransactionHold, error) {
+	var holds []models.TransactionHold
+	for rows.Next() {
+		var hold models.TransactionHold
+		var note sql.NullString
+		var releasedAt sql.NullTime
+
+		if err := rows.Scan(
+			&hold.ID,
+			&hold.TransactionID,
+			&hold.UserID,
+			&hold.Amount,
+			&hold.Currency,
+			&hold.Livemode,
+			&hold.Reason,
+			&hold.Status,
+			&hold.ReleaseAt,
+			&note,
+			&releasedAt,
+			&hold.CreatedAt,
+			&hold.UpdatedAt,
+		); err != nil {
+			return nil, fmt.Errorf("failed to scan transaction hold: %w", err)
+		}
+
+		hold.ReleaseNote = note.String
+		hold.ReleasedAt = releasedAt.Time
+		holds = append(holds, hold)
+	}
+	return holds, rows.Err()
+}
+
+// ReleaseTransactionHold releases a transaction's active hold, returning sql.ErrNoRows when it has
+// none
+func (p *PostgresDB) ReleaseTransactionHold(txID int, note string, releasedAt time.Time) error {
+	query := `
+		UPDATE transaction_holds
+		SET status = $1, release_note = $2, released_at = $3, updated_at = $3
+		WHERE transaction_id = $4 AND status = $5
+	`
+
+	result, err := p.db.Exec(query, consts.HoldReleased, sql.NullString{String: note, Valid: note != ""}, releasedAt, txID, consts.HoldActive)
+	if err != nil {
+		return fmt.Errorf("failed to release transaction hold: %w", err)
+	}
+	return holdUpdated(result)
+}
+
+// ExtendTransactionHold moves the deadline of a transaction's active hold, returning sql.ErrNoRows
+// when it has none
+func (p *PostgresDB) ExtendTransactionHold(txID int, releaseAt, updatedAt time.Time) error {
+	query := `
+		UPDATE transaction_holds
+		SET release_at = $1, updated_at = $2
+		WHERE transaction_id = $3 AND status = $4
+	`
+
+	result, err := p.db.Exec(query, releaseAt, updatedAt, txID, consts.HoldActive)
+	if err != nil {
+		return fmt.Errorf("failed to extend transaction hold: %w", err)
+	}
+	return holdUpdated(result)
+}
+
+// holdUpdated returns sql.ErrNoRows when an update of a transaction hold matched no active hold
+func holdUpdated(result sql.Result) error {
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to update transaction hold: %w", err)
+	}
+	if rows == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// ExpireTransactionHolds releases up to limit active holds whose deadline is before the given
+// time, returning the IDs of the transactions they held
+func (p *PostgresDB) ExpireTransactionHolds(before time.Time, limit int) ([]int, error) {
+	query := `
+		UPDATE transaction_holds
+		SET status = $1, released_at = $2, updated_at = $2
+		WHERE id IN (
+			SELECT id FROM transaction_holds
+			WHERE status = $3 AND release_at <= $2
+			ORDER BY release_at
+			LIMIT $4
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING transaction_id
+	`
+
+	rows, err := p.db.Query(query, consts.HoldExpired, before, consts.HoldActive, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to expire transaction holds: %w", err)
+	}
+	defer rows.Close()
+
+	var ids []int
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			return ids, fmt.Errorf("failed to scan expired transaction hold: %w", err)
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+// GetHeldAmount sums the active holds on a user's deposits in a currency and mode
+func (p *PostgresDB) GetHeldAmount(userID int, currency string, livemode bool) (float64, error) {
+	query := `
+		SELECT COALESCE(SUM(amount), 0)
+		FROM transaction_holds
+		WHERE user_id = $1 AND currency = $2 AND livemode = $3 AND status = $4
+	`
+
+	var held float64
+	if err := p.db.QueryRow(query, userID, currency, livemode, consts.HoldActive).Scan(&held); err != nil {
+		return 0, fmt.Errorf("failed to get held amount: %w", err)
+	}
+	return held, nil
+}
+
+// CreateIdempotencyKey claims an idempotency key for a request in progress, returning sql.ErrNoRows
+// when the key was already claimed
+func (p *PostgresDB) CreateIdempotencyKey(key models.IdempotencyKey) error {
+	query := `
+		INSERT INTO idempotency_keys (caller, route, key, request_hash, created_at, expires_at)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		ON CONFLICT (caller, route, key) DO NOTHING
+	`
+
+	result, err := p.db.Exec(query, key.Caller, key.Route, key.Key, key.RequestHash, key.CreatedAt, key.ExpiresAt)
+	if err != nil {
+		return fmt.Errorf("failed to create idempotency key: %w", err)
+	}
+	if n, err := result.RowsAffected(); err != nil {
+		return fmt.Errorf("failed to create idempotency key: %w", err)
+	} else if n == 0 {
+		return sql.ErrNoRows
+	}
+
+	return nil
+}
+
+// GetIdempotencyKey fetches an idempotency key and the response recorded for it, returning
+// sql.ErrNoRows when it doesn't exist
+func (p *PostgresDB) GetIdempotencyKey(caller, route, key string) (*models.IdempotencyKey, error) {
+	query := `
+		SELECT caller, route, key, request_hash, status_code, content_type, response_body, created_at, completed_at, expires_at
+		FROM idempotency_keys
+		WHERE caller = $1 AND route = $2 AND key = $3
+	`
+
+	var record models.IdempotencyKey
+	var statusCode sql.NullInt64
+	var contentType sql.NullString
+	var completedAt sql.NullTime
+	err := p.db.QueryRow(query, caller, route, key).Scan(
+		&record.Caller,
+		&record.Route,
+		&record.Key,
+		&record.RequestHash,
+		&statusCode,
+		&contentType,
+		&record.ResponseBody,
+		&record.CreatedAt,
+		&completedAt,
+		&record.ExpiresAt,
+	)
+	if err == sql.ErrNoRows {
+		return nil, err
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get idempotency key: %w", err)
+	}
+
+	record.StatusCode = int(statusCode.Int64)
+	record.ContentType = contentType.String
+	record.CompletedAt = completedAt.Time
+	return &record, nil
+}
+
+// CompleteIdempotencyKey records the response to an idempotency key's request, returning
+// sql.ErrNoRows when the key is no longer claimed
+func (p *PostgresDB) CompleteIdempotencyKey(caller, route, key string, statusCode int, contentType string, body []byte, completedAt time.Time) error {
+	query := `
+		UPDATE idempotency_keys
+		SET status_code = $1, content_type = $2, response_body = $3, completed_at = $4
+		WHERE caller = $5 AND route = $6 AND key = $7 AND completed_at IS NULL
+	`
+
+	result, err := p.db.Exec(query, statusCode, contentType, body, completedAt, caller, route, key)
+	if err != nil {
+		return fmt.Errorf("failed to complete idempotency key: %w", err)
+	}
+	if n, err := result.RowsAffected(); err != nil {
+		return fmt.Errorf("failed to complete idempotency key: %w", err)
+	} else if n == 0 {
+		return sql.ErrNoRows
+	}
+
+	return nil
+}
+
+// DeleteIdempotencyKey deletes an idempotency key claimed at createdAt, leaving it alone when it
+// was claimed again since
+func (p *PostgresDB) DeleteIdempotencyKey(caller, route, key string, createdAt time.Time) error {
+	query := `DELETE FROM idempotency_keys WHERE caller = $1 AND route = $2 AND key = $3 AND created_at = $4`
+
+	if _, err := p.db.Exec(query, caller, route, key, createdAt); err != nil {
+		return fmt.Errorf("failed to delete idempotency key: %w", err)
+	}
+
+	return nil
+}
+
+// PurgeIdempotencyKeys deletes up to limit idempotency keys that expired before, returning how many
+// were deleted
+func (p *PostgresDB) PurgeIdempotencyKeys(before time.Time, limit int) (int, error) {
+	query := `
+		DELETE FROM idempotency_keys
+		WHERE (caller, route, key) IN (
+			SELECT caller, route, key FROM idempotency_keys
+			WHERE expires_at < $1
+			LIMIT $2
+		)
+	`
+
+	result, err := p.db.Exec(query, before, limit)
+	if err != nil {
+		return 0, fmt.Errorf("failed to purge idempotency keys: %w", err)
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("failed to purge idempotency keys: %w", err)
+	}
+
+	return int(n), nil
+}
+
+// CreateAMLCase stores a new AML case, with its travel rule details encrypted
+func (p *PostgresDB) CreateAMLCase(amlCase models.AMLCase) (int, error) {
+	travelRule, err := encryptTravelRule(amlCase.TravelRule)
+	if err != nil {
+		return 0, err
+	}
+
+	query := `
+		INSERT INTO aml_cases (transaction_id, reference_id, user_id, country_id, type, amount, currency, threshold, travel_rule, status, created_at, updated_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $11)
+		RETURNING id
+	`
+
+	var id int
+	err = p.db.QueryRow(query,
+		amlCase.TransactionID,
+		sql.NullString{String: amlCase.ReferenceID, Valid: amlCase.ReferenceID != ""},
+		amlCase.UserID,
+		amlCase.CountryID,
+		amlCase.Type,
+		amlCase.Amount,
+		amlCase.Currency,
+		amlCase.Threshold,
+		travelRule,
+		amlCase.Status,
+		amlCase.CreatedAt,
+	).Scan(&id)
+	if err != nil {
+		return 0, fmt.Errorf("failed to create AML case: %w", err)
+	}
+
+	return id, nil
+}
+
+// GetAMLCaseByID fetches an AML case, returning sql.ErrNoRows when there is none
+func (p *PostgresDB) GetAMLCaseByID(caseID int) (*models.AMLCase, error) {
+	query := `
+		SELECT id, transaction_id, reference_id, user_id, country_id, type, amount, currency, threshold, travel_rule, status, note, created_at, updated_at
+		FROM aml_cases
+		WHERE id = $1
+	`
+
+	rows, err := p.db.Query(query, caseID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch AML case: %w", err)
+	}
+	defer rows.Close()
+
+	cases, err := scanAMLCases(rows)
+	if err != nil {
+		return nil, err
+	}
+	if len(cases) == 0 {
+		return nil, sql.ErrNoRows
+	}
+	return &cases[0], nil
+}
+
+// ListAMLCases returns up to limit AML cases with the given status, oldest first
+func (p *PostgresDB) ListAMLCases(status string, limit int) ([]models.AMLCase, error) {
+	query := `
+		SELECT id, transaction_id, reference_id, user_id, country_id, type, amount, currency, threshold, travel_rule, status, note, created_at, updated_at
+		FROM aml_cases
+		WHERE status = $1
+		ORDER BY created_at, id
+		LIMIT $2
+	`
+
+	rows, err := p.db.Query(query, status, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list AML cases: %w", err)
+	}
+	defer rows.Close()
+
+	return scanAMLCases(rows)
+}
+
+// scanAMLCases reads AML cases selected with the columns used by the queries above
+func scanAMLCases(rows *sql.Rows) ([]models.AMLCase, error) {
+	var cases []models.AMLCase
+	for rows.Next() {
+		var amlCase models.AMLCase
+		var referenceID, note sql.NullString
+		var travelRule string
+
+		if err := rows.Scan(
+			&amlCase.ID,
+			&amlCase.TransactionID,
+			&referenceID,
+			&amlCase.UserID,
+			&amlCase.CountryID,
+			&amlCase.Type,
+			&amlCase.Amount,
+			&amlCase.Currency,
+			&amlCase.Threshold,
+			&travelRule,
+			&amlCase.Status,
+			&note,
+			&amlCase.CreatedAt,
+			&amlCase.UpdatedAt,
+		); err != nil {
+			return nil, fmt.Errorf("failed to scan AML case: %w", err)
+		}
+
+		amlCase.ReferenceID = referenceID.String
+		amlCase.Note = note.String
+		var err error
+		if amlCase.TravelRule, err = decryptTravelRule(travelRule); err != nil {
+			return nil, err
+		}
+		cases = append(cases, amlCase)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating AML cases: %w", err)
+	}
+
+	return cases, nil
+}
+
+// UpdateAMLCaseStatus moves an open or in-review AML case to a new status, returning
+// sql.ErrNoRows when no such case exists or it is already closed
+func (p *PostgresDB) UpdateAMLCaseStatus(caseID int, status, note string, updatedAt time.Time) error {
+	query := `
+		UPDATE aml_cases
+		SET status = $1, note = $2, updated_at = $3
+		WHERE id = $4 AND status IN ($5, $6)
+	`
+
+	result, err := p.db.Exec(query, status, sql.NullString{String: note, Valid: note != ""}, updatedAt, caseID, consts.AMLCaseOpen, consts.AMLCaseInReview)
+	if err != nil {
+		return fmt.Errorf("failed to update AML case: %w", err)
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to update AML case: %w", err)
+	}
+	if rows == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// screeningCaseColumns are the columns scanScreeningCases reads
+const screeningCaseColumns = `id, user_id, trigger, name, amount, currency, matches, status, reviewer, note, created_at, updated_at`
+
+// CreateScreeningCase stores a new screening case, with the screened name encrypted
+func (p *PostgresDB) CreateScreeningCase(screeningCase models.ScreeningCase) (int, error) {
+	name, err := utils.EncryptString(screeningCase.Name)
+	if err != nil {
+		return 0, fmt.Errorf("failed to encrypt screened name: %w", err)
+	}
+	matches, err := json.Marshal(screeningCase.Matches)
+	if err != nil {
+		return 0, fmt.Errorf("failed to encode screening matches: %w", err)
+	}
+
+	query := `
+		INSERT INTO screening_cases (user_id, trigger, name, amount, currency, matches, status, created_at, updated_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $8)
+		RETURNING id
+	`
+
+	var id int
+	err = p.db.QueryRow(query,
+		screeningCase.UserID,
+		screeningCase.Trigger,
+		name,
+		sql.NullFloat64{Float64: screeningCase.Amount, Valid: screeningCase.Currency != ""},
+		sql.NullString{String: screeningCase.Currency, Valid: screeningCase.Currency != ""},
+		matches,
+		screeningCase.Status,
+		screeningCase.CreatedAt,
+	).Scan(&id)
+	if err != nil {
+		return 0, fmt.Errorf("failed to create screening case: %w", err)
+	}
+
+	return id, nil
+}
+
+// GetScreeningCaseByID fetches a screening case, returning sql.ErrNoRows when there is none
+func (p *PostgresDB) GetScreeningCaseByID(caseID int) (*models.ScreeningCase, error) {
+	rows, err := p.db.Query(`SELECT `+screeningCaseColumns+` FROM screening_cases WHERE id = $1`, caseID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch screening case: %w", err)
+	}
+	defer rows.Close()
+
+	cases, err := scanScreeningCases(rows)
+	if err != nil {
+		return nil, err
+	}
+	if len(cases) == 0 {
+		return nil, sql.ErrNoRows
+	}
+	return &cases[0], nil
+}
+
+// ListScreeningCases returns up to limit screening cases with the given status, oldest first
+func (p *PostgresDB) ListScreeningCases(status string, limit int) ([]models.ScreeningCase, error) {
+	query := `SELECT ` + screeningCaseColumns + ` FROM screening_cases WHERE status = $1 ORDER BY created_at, id LIMIT $2`
+
+	rows, err := p.db.Query(query, status, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list screening cases: %w", err)
+	}
+	defer rows.Close()
+
+	return scanScreeningCases(rows)
+}
+
+// ListUserScreeningCases returns a user's screening cases, newest first
+func (p *PostgresDB) ListUserScreeningCases(userID int) ([]models.ScreeningCase, error) {
+	query := `SELECT ` + screeningCaseColumns + ` FROM screening_cases WHERE user_id = $1 ORDER BY created_at DESC, id DESC`
+
+	rows, err := p.db.Query(query, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list user screening cases: %w", err)
+	}
+	defer rows.Close()
+
+	return scanScreeningCases(rows)
+}
+
+// scanScreeningCases reads screening cases selected with screeningCaseColumns
+func scanScreeningCases(rows *sql.Rows) ([]models.ScreeningCase, error) {
+	var cases []models.ScreeningCase
+	for rows.Next() {
+		var screeningCase models.ScreeningCase
+		var name string
+		var matches []byte
+		var amount sql.NullFloat64
+		var currency, reviewer, note sql.NullString
+
+		if err := rows.Scan(
+			&screeningCase.ID,
+			&screeningCase.UserID,
+			&screeningCase.Trigger,
+			&name,
+			&amount,
+			&currency,
+			&matches,
+			&screeningCase.Status,
+			&reviewer,
+			&note,
+			&screeningCase.CreatedAt,
+			&screeningCase.UpdatedAt,
+		); err != nil {
+			return nil, fmt.Errorf("failed to scan screening case: %w", err)
+		}
+
+		var err error
+		if screeningCase.Name, err = utils.DecryptString(name); err != nil {
+			return nil, fmt.Errorf("failed to decrypt screened name: %w", err)
+		}
+		if err := json.Unmarshal(matches, &screeningCase.Matches); err != nil {
+			return nil, fmt.Errorf("failed to decode screening matches: %w", err)
+		}
+		screeningCase.Amount = amount.Float64
+		screeningCase.Currency = currency.String
+		screeningCase.Reviewer = reviewer.String
+		screeningCase.Note = note.String
+		cases = append(cases, screeningCase)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating screening cases: %w", err)
+	}
+
+	return cases, nil
+}
+
+// DecideScreeningCase closes an open screening case, returning sql.ErrNoRows when no such case
+// exists or it is already closed
+func (p *PostgresDB) DecideScreeningCase(caseID int, status, reviewer, note string, updatedAt time.Time) error {
+	query := `
+		UPDATE screening_cases
+		SET status = $1, reviewer = $2, note = $3, updated_at = $4
+		WHERE id = $5 AND status = $6
+	`
+
+	result, err := p.db.Exec(query, status, reviewer, sql.NullString{String: note, Valid: note != ""}, updatedAt, caseID, consts.ScreeningCaseOpen)
+	if err != nil {
+		return fmt.Errorf("failed to update screening case: %w", err)
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to update screening case: %w", err)
+	}
+	if rows == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// CreateScreeningEvent appends an entry to a user's screening audit trail
+func (p *PostgresDB) CreateScreeningEvent(event models.ScreeningEvent) error {
+	query := `
+		INSERT INTO screening_events (user_id, case_id, action, actor, detail, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6)
+	`
+
+	_, err := p.db.Exec(query,
+		event.UserID,
+		sql.NullInt64{Int64: int64(event.CaseID), Valid: event.CaseID != 0},
+		event.Action,
+		event.Actor,
+		sql.NullString{String: event.Detail, Valid: event.Detail != ""},
+		event.CreatedAt,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to create screening event: %w", err)
+	}
+	return nil
+}
+
+// ListScreeningEvents returns a user's screening audit trail, oldest first
+func (p *PostgresDB) ListScreeningEvents(userID int) ([]models.ScreeningEvent, error) {
+	query := `
+		SELECT id, user_id, case_id, action, actor, detail, created_at
+		FROM screening_events
+		WHERE user_id = $1
+		ORDER BY id
+	`
+
+	rows, err := p.db.Query(query, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list screening events: %w", err)
+	}
+	defer rows.Close()
+
+	var events []models.ScreeningEvent
+	for rows.Next() {
+		var event models.ScreeningEvent
+		var caseID sql.NullInt64
+		var detail sql.NullString
+
+		if err := rows.Scan(&event.ID, &event.UserID, &caseID, &event.Action, &event.Actor, &detail, &event.CreatedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan screening event: %w", err)
+		}
+		event.CaseID = int(caseID.Int64)
+		event.Detail = detail.String
+		events = append(events, event)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating screening events: %w", err)
+	}
+
+	return events, nil
+}
+
+// encryptTravelRule encodes an AML case's originator and beneficiary details for storage
+func encryptTravelRule(info models.TravelRuleInfo) (string, error) {
+	encoded, err := json.Marshal(info)
+	if err != nil {
+		return "", fmt.Errorf("failed to encode travel rule details: %w", err)
+	}
+	encrypted, err := utils.EncryptString(string(encoded))
+	if err != nil {
+		return "", fmt.Errorf("failed to encrypt travel rule details: %w", err)
+	}
+	return encrypted, nil
+}
+
+// decryptTravelRule decodes stored originator and beneficiary details
+func decryptTravelRule(stored string) (models.TravelRuleInfo, error) {
+	var info models.TravelRuleInfo
+	decrypted, err := utils.DecryptString(stored)
+	if err != nil {
+		return info, fmt.Errorf("failed to decrypt travel rule details: %w", err)
+	}
+	if err := json.Unmarshal([]byte(decrypted), &info); err != nil {
+		return info, fmt.Errorf("failed to decode travel rule details: %w", err)
+	}
+	return info, nil
+}
+
+// CreatePaymentConsent stores a new payment consent
+func (p *PostgresDB) CreatePaymentConsent(consent models.PaymentConsent) (int, error) {
+	query := `
+		INSERT INTO payment_consents (transaction_id, gateway_id, bank_id, external_id, status, state_hash, authorisation_url, expires_at, created_at, updated_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $9)
+		RETURNING id
+	`
+
+	var id int
+	err := p.db.QueryRow(query,
+		consent.TransactionID,
+		consent.GatewayID,
+		consent.BankID,
+		consent.ExternalID,
+		consent.Status,
+		consent.StateHash,
+		sql.NullString{String: consent.AuthorisationURL, Valid: consent.AuthorisationURL != ""},
+		consent.ExpiresAt,
+		consent.CreatedAt,
+	).Scan(&id)
+	if err != nil {
+		return 0, fmt.Errorf("failed to create payment consent: %w", err)
+	}
+
+	return id, nil
+}
+
+// GetPaymentConsentByState fetches the payment consent whose state hashes to stateHash
+func (p *PostgresDB) GetPaymentConsentByState(stateHash string) (*models.PaymentConsent, error) {
+	return p.getPaymentConsent("state_hash", stateHash)
+}
+
+// GetPaymentConsentByTransaction fetches the payment consent of a transaction
+func (p *PostgresDB) GetPaymentConsentByTransaction(txID int) (*models.PaymentConsent, error) {
+	return p.getPaymentConsent("transaction_id", txID)
+}
+
+// getPaymentConsent fetches the payment consent whose column equals value, returning
+// sql.ErrNoRows when there is none
+func (p *PostgresDB) getPaymentConsent(column string, value interface{}) (*models.PaymentConsent, error) {
+	query := `
+		SELECT id, transaction_id, gateway_id, bank_id, external_id, status, state_hash, authorisation_url, expires_at, created_at, updated_at
+		FROM payment_consents
+		WHERE ` + column + ` = $1
+	`
+
+	rows, err := p.db.Query(query, value)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch payment consent: %w", err)
+	}
+	defer rows.Close()
+
+	consents, err := scanPaymentConsents(rows)
+	if err != nil {
+		return nil, err
+	}
+	if len(consents) == 0 {
+		return nil, sql.ErrNoRows
+	}
+	return &consents[0], nil
+}
+
+// ListExpiredPaymentConsents returns up to limit consents still awaiting authorisation after they
+// expired, earliest expiry first
+func (p *PostgresDB) ListExpiredPaymentConsents(now time.Time, limit int) ([]models.PaymentConsent, error) {
+	query := `
+		SELECT id, transaction_id, gateway_id, bank_id, external_id, status, state_hash, authorisation_url, expires_at, created_at, updated_at
+		FROM payment_consents
+		WHERE status = $1 AND expires_at < $2
+		ORDER BY expires_at, id
+		LIMIT $3
+	`
+
+	rows, err := p.db.Query(query, consts.ConsentAwaitingAuthorisation, now, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list expired payment consents: %w", err)
+	}
+	defer rows.Close()
+
+	return scanPaymentConsents(rows)
+}
+
+// scanPaymentConsents reads payment consents selected with the columns used by the queries above
+func scanPaymentConsents(rows *sql.Rows) ([]models.PaymentConsent, error) {
+	var consents []models.PaymentConsent
+	for rows.Next() {
+		var consent models.PaymentConsent
+		var authorisationURL sql.NullString
+
+		if err := rows.Scan(
+			&consent.ID,
+			&consent.TransactionID,
+			&consent.GatewayID,
+			&consent.BankID,
+			&consent.ExternalID,
+			&consent.Status,
+			&consent.StateHash,
+			&authorisationURL,
+			&consent.ExpiresAt,
+			&consent.CreatedAt,
+			&consent.UpdatedAt,
+		); err != nil {
+			return nil, fmt.Errorf("failed to scan payment consent: %w", err)
+		}
+
+		consent.AuthorisationURL = authorisationURL.String
+		consents = append(consents, consent)
+	}
+
+	return consents, rows.Err()
+}
+
+// UpdatePaymentConsentStatus moves a payment consent awaiting authorisation to a new status,
+// returning sql.ErrNoRows when no such consent exists or it was already used, rejected or expired
+func (p *PostgresDB) UpdatePaymentConsentStatus(consentID int, status string, updatedAt time.Time) error {
+	query := `
+		UPDATE payment_consents
+		SET status = $1, updated_at = $2
+		WHERE id = $3 AND status = $4
+	`
+
+	result, err := p.db.Exec(query, status, updatedAt, consentID, consts.ConsentAwaitingAuthorisation)
+	if err != nil {
+		return fmt.Errorf("failed to update payment consent: %w", err)
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to update payment consent: %w", err)
+	}
+	if rows == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// CreateBankAccountConsent stores a new bank account consent
+func (p *PostgresDB) CreateBankAccountConsent(consent models.BankAccountConsent) (int, error) {
+	query := `
+		INSERT INTO bank_account_consents (user_id, gateway_id, status, state_hash, authorisation_url, livemode, expires_at, created_at, updated_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $8)
+		RETURNING id
+	`
+
+	var id int
+	err := p.db.QueryRow(query,
+		consent.UserID,
+		consent.GatewayID,
+		consent.Status,
+		consent.StateHash,
+		sql.NullString{String: consent.AuthorisationURL, Valid: consent.AuthorisationURL != ""},
+		consent.Livemode,
+		consent.ExpiresAt,
+		consent.CreatedAt,
+	).Scan(&id)
+	if err != nil {
+		return 0, fmt.Errorf("failed to create bank account consent: %w", err)
+	}
+
+	return id, nil
+}
+
+// GetBankAccountConsentByState fetches the bank account consent whose state hashes to stateHash,
+// returning sql.ErrNoRows when there is none
+func (p *PostgresDB) GetBankAccountConsentByState(stateHash string) (*models.BankAccountConsent, error) {
+	query := `
+		SELECT id, user_id, gateway_id, status, state_hash, authorisation_url, access_token, refresh_token,
+			   token_expires_at, livemode, expires_at, created_at, updated_at
+		FROM bank_account_consents
+		WHERE state_hash = $1
+	`
+
+	var consent models.BankAccountConsent
+	var authorisationURL, accessToken, refreshToken sql.NullString
+	var tokenExpiresAt sql.NullTime
+
+	err := p.db.QueryRow(query, stateHash).Scan(
+		&consent.ID,
+		&consent.UserID,
+		&consent.GatewayID,
+		&consent.Status,
+		&consent.StateHash,
+		&authorisationURL,
+		&accessToken,
+		&refreshToken,
+		&tokenExpiresAt,
+		&consent.Livemode,
+		&consent.ExpiresAt,
+		&consent.CreatedAt,
+		&consent.UpdatedAt,
+	)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, err
+		}
+		return nil, fmt.Errorf("failed to fetch bank account consent: %w", err)
+	}
+
+	consent.AuthorisationURL = authorisationURL.String
+	consent.TokenExpiresAt = tokenExpiresAt.Time
+	if consent.AccessToken, err = decryptNullString(accessToken); err != nil {
+		return nil, fmt.Errorf("failed to decrypt access token: %w", err)
+	}
+	if consent.RefreshToken, err = decryptNullString(refreshToken); err != nil {
+		return nil, fmt.Errorf("failed to decrypt refresh token: %w", err)
+	}
+	return &consent, nil
+}
+
+// UpdateBankAccountConsentStatus moves a bank account consent awaiting authorisation to a new
+// status, returning sql.ErrNoRows when no such consent exists or it was already authorised,
+// rejected or expired
+func (p *PostgresDB) UpdateBankAccountConsentStatus(consentID int, status string, updatedAt time.Time) error {
+	query := `
+		UPDATE bank_account_consents
+		SET status = $1, updated_at = $2
+		WHERE id = $3 AND status = $4
+	`
+
+	result, err := p.db.Exec(query, status, updatedAt, consentID, consts.ConsentAwaitingAuthorisation)
+	if err != nil {
+		return fmt.Errorf("failed to update bank account consent: %w", err)
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to update bank account consent: %w", err)
+	}
+	if rows == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// StoreBankAccountConsentTokens stores the tokens an authorised bank account consent granted,
+// encrypted
+func (p *PostgresDB) StoreBankAccountConsentTokens(consentID int, accessToken, refreshToken string, expiresAt time.Time) error {
+	encryptedAccess, err := encryptNullString(accessToken)
+	if err != nil {
+		return fmt.Errorf("failed to encrypt access token: %w", err)
+	}
+	encryptedRefresh, err := encryptNullString(refreshToken)
+	if err != nil {
+		return fmt.Errorf("failed to encrypt refresh token: %w", err)
+	}
+
+	query := `
+		UPDATE bank_account_consents
+		SET access_token = $1, refresh_token = $2, token_expires_at = $3, updated_at = CURRENT_TIMESTAMP
+		WHERE id = $4
+	`
+
+	result, err := p.db.Exec(query, encryptedAccess, encryptedRefresh, sql.NullTime{Time: expiresAt, Valid: !expiresAt.IsZero()}, consentID)
+	if err != nil {
+		return fmt.Errorf("failed to store bank account consent tokens: %w", err)
+	}
+	if rows, err := result.RowsAffected(); err == nil && rows == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// SaveBankAccount stores a linked bank account with its identifiers encrypted. An account the user
+// linked before through the same gateway is updated and made active again. It returns the
+// account's ID.
+func (p *PostgresDB) SaveBankAccount(account models.BankAccount) (int, error) {
+	sortCode, err := encryptNullString(account.SortCode)
+	if err != nil {
+		return 0, fmt.Errorf("failed to encrypt sort code: %w", err)
+	}
+	accountNumber, err := encryptNullString(account.AccountNumber)
+	if err != nil {
+		return 0, fmt.Errorf("failed to encrypt account number: %w", err)
+	}
+	iban, err := encryptNullString(account.IBAN)
+	if err != nil {
+		return 0, fmt.Errorf("failed to encrypt IBAN: %w", err)
+	}
+
+	query := `
+		INSERT INTO linked_bank_accounts (user_id, gateway_id, consent_id, external_id, bank_name, holder_name, currency,
+			sort_code, account_number, iban, last4, status, created_at, updated_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $13)
+		ON CONFLICT (user_id, gateway_id, external_id) DO UPDATE
+		SET consent_id = EXCLUDED.consent_id, bank_name = EXCLUDED.bank_name, holder_name = EXCLUDED.holder_name,
+			currency = EXCLUDED.currency, sort_code = EXCLUDED.sort_code, account_number = EXCLUDED.account_number,
+			iban = EXCLUDED.iban, last4 = EXCLUDED.last4, status = EXCLUDED.status, updated_at = EXCLUDED.updated_at
+		RETURNING id
+	`
+
+	var id int
+	err = p.db.QueryRow(query,
+		account.UserID,
+		account.GatewayID,
+		account.ConsentID,
+		account.ExternalID,
+		sql.NullString{String: account.BankName, Valid: account.BankName != ""},
+		account.HolderName,
+		account.Currency,
+		sortCode,
+		accountNumber,
+		iban,
+		account.Last4,
+		account.Status,
+		account.CreatedAt,
+	).Scan(&id)
+	if err != nil {
+		return 0, fmt.Errorf("failed to save bank account: %w", err)
+	}
+
+	return id, nil
+}
+
+// GetBankAccountByID fetches a linked bank account with its identifiers decrypted, returning
+// sql.ErrNoRows when there is none
+func (p *PostgresDB) GetBankAccountByID(accountID int) (*models.BankAccount, error) {
+	accounts, err := p.queryBankAccounts("id", accountID)
+	if err != nil {
+		return nil, err
+	}
+	if len(accounts) == 0 {
+		return nil, sql.ErrNoRows
+	}
+	return &accounts[0], nil
+}
+
+// ListBankAccounts returns the bank accounts a user linked, active or revoked, oldest first
+func (p *PostgresDB) ListBankAccounts(userID int) ([]models.BankAccount, error) {
+	return p.queryBankAccounts("user_id", userID)
+}
+
+// queryBankAccounts fetches the linked bank accounts whose column equals value
+func (p *PostgresDB) queryBankAccounts(column string, value interface{}) ([]models.BankAccount, error) {
+	query := `
+		SELECT id, user_id, gateway_id, consent_id, external_id, bank_name, holder_name, currency,
+			   sort_code, account_number, iban, last4, status, created_at, updated_at
+		FROM linked_bank_accounts
+		WHERE ` + column + ` = $1
+		ORDER BY id
+	`
+
+	rows, err := p.db.Query(query, value)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch bank accounts: %w", err)
+	}
+	defer rows.Close()
+
+	var accounts []models.BankAccount
+	for rows.Next() {
+		var account models.BankAccount
+		var bankName, sortCode, accountNumber, iban sql.NullString
+
+		if err := rows.Scan(
+			&account.ID,
+			&account.UserID,
+			&account.GatewayID,
+			&account.ConsentID,
+			&account.ExternalID,
+			&bankName,
+			&account.HolderName,
+			&account.Currency,
+			&sortCode,
+			&accountNumber,
+			&iban,
+			&account.Last4,
+			&account.Status,
+			&account.CreatedAt,
+			&account.UpdatedAt,
+		); err != nil {
+			return nil, fmt.Errorf("failed to scan bank account: %w", err)
+		}
+
+		account.BankName = bankName.String
+		if account.SortCode, err = decryptNullString(sortCode); err != nil {
+			return nil, fmt.Errorf("failed to decrypt sort code: %w", err)
+		}
+		if account.AccountNumber, err = decryptNullString(accountNumber); err != nil {
+			return nil, fmt.Errorf("failed to decrypt account number: %w", err)
+		}
+		if account.IBAN, err = decryptNullString(iban); err != nil {
+			return nil, fmt.Errorf("failed to decrypt IBAN: %w", err)
+		}
+		accounts = append(accounts, account)
+	}
+
+	return accounts, rows.Err()
+}
+
+// RevokeBankAccount marks an active linked bank account revoked, returning sql.ErrNoRows when no
+// such account exists or it was already revoked
+func (p *PostgresDB) RevokeBankAccount(accountID int, updatedAt time.Time) error {
+	query := `
+		UPDATE linked_bank_accounts
+		SET status = $1, updated_at = $2
+		WHERE id = $3 AND status = $4
+	`
+
+	result, err := p.db.Exec(query, consts.BankAccountRevoked, updatedAt, accountID, consts.BankAccountActive)
+	if err != nil {
+		return fmt.Errorf("failed to revoke bank account: %w", err)
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to revoke bank account: %w", err)
+	}
+	if rows == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// CreateMandate stores a SEPA mandate with its IBAN encrypted and returns its ID
+func (p *PostgresDB) CreateMandate(mandate models.Mandate) (int, error) {
+	iban, err := utils.EncryptString(mandate.IBAN)
+	if err != nil {
+		return 0, fmt.Errorf("failed to encrypt IBAN: %w", err)
+	}
+
+	query := `
+		INSERT INTO sepa_mandates (user_id, gateway_id, reference, debtor_name, iban, bic, last4, sequence_type,
+			status, signed_at, created_at, updated_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $11)
+		RETURNING id
+	`
+
+	var id int
+	err = p.db.QueryRow(query,
+		mandate.UserID,
+		mandate.GatewayID,
+		mandate.Reference,
+		mandate.DebtorName,
+		iban,
+		sql.NullString{String: mandate.BIC, Valid: mandate.BIC != ""},
+		mandate.Last4,
+		mandate.SequenceType,
+		mandate.Status,
+		mandate.SignedAt,
+		mandate.CreatedAt,
+	).Scan(&id)
+	if err != nil {
+		return 0, fmt.Errorf("failed to create mandate: %w", err)
+	}
+
+	return id, nil
+}
+
+// GetMandateByID fetches a SEPA mandate with its IBAN decrypted, returning sql.ErrNoRows when
+// there is none
+func (p *PostgresDB) GetMandateByID(mandateID int) (*models.Mandate, error) {
+	mandates, err := p.queryMandates("id", mandateID)
+	if err != nil {
+		return nil, err
+	}
+	if len(mandates) == 0 {
+		return nil, sql.ErrNoRows
+	}
+	return &mandates[0], nil
+}
+
+// ListMandates returns a user's SEPA mandates, active or revoked, oldest first
+func (p *PostgresDB) ListMandates(userID int) ([]models.Mandate, error) {
+	return p.queryMandates("user_id", userID)
+}
+
+// queryMandates fetches the SEPA mandates whose column equals value
+func (p *PostgresDB) queryMandates(column string, value interface{}) ([]models.Mandate, error) {
+	query := `
+		SELECT id, user_id, gateway_id, reference, debtor_name, iban, bic, last4, sequence_type, status,
+			   revocation_reason, signed_at, created_at, updated_at
+		FROM sepa_mandates
+		WHERE ` + column + ` = $1
+		ORDER BY id
+	`
+
+	rows, err := p.db.Query(query, value)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch mandates: %w", err)
+	}
+	defer rows.Close()
+
+	var mandates []models.Mandate
+	for rows.Next() {
+		var mandate models.Mandate
+		var iban string
+		var bic, revocationReason sql.NullString
+
+		if err := rows.Scan(
+			&mandate.ID,
+			&mandate.UserID,
+			&mandate.GatewayID,
+			&mandate.Reference,
+			&mandate.DebtorName,
+			&iban,
+			&bic,
+			&mandate.Last4,
+			&mandate.SequenceType,
+			&mandate.Status,
+			&revocationReason,
+			&mandate.SignedAt,
+			&mandate.CreatedAt,
+			&mandate.UpdatedAt,
+		); err != nil {
+			return nil, fmt.Errorf("failed to scan mandate: %w", err)
+		}
+
+		if mandate.IBAN, err = utils.DecryptString(iban); err != nil {
+			return nil, fmt.Errorf("failed to decrypt IBAN: %w", err)
+		}
+		mandate.BIC = bic.String
+		mandate.RevocationReason = revocationReason.String
+		mandates = append(mandates, mandate)
+	}
+
+	return mandates, rows.Err()
+}
+
+// UpdateMandateSequenceType sets the sequence type of a SEPA mandate's next debit
+func (p *PostgresDB) UpdateMandateSequenceType(mandateID int, sequenceType string) error {
+	query := `
+		UPDATE sepa_mandates
+		SET sequence_type = $1, updated_at = CURRENT_TIMESTAMP
+		WHERE id = $2
+	`
+
+	result, err := p.db.Exec(query, sequenceType, mandateID)
+	if err != nil {
+		return fmt.Errorf("failed to update mandate: %w", err)
+	}
+	if rows, err := result.RowsAffected(); err == nil && rows == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// RevokeMandate marks an active SEPA mandate revoked with the reason code of the return that
+// ended it, if any, returning sql.ErrNoRows when no such mandate exists or it was already revoked
+func (p *PostgresDB) RevokeMandate(mandateID int, reason string, updatedAt time.Time) error {
+	query := `
+		UPDATE sepa_mandates
+		SET status = $1, revocation_reason = $2, updated_at = $3
+		WHERE id = $4 AND status = $5
+	`
+
+	result, err := p.db.Exec(query, consts.MandateRevoked, sql.NullString{String: reason, Valid: reason != ""}, updatedAt, mandateID, consts.MandateActive)
+	if err != nil {
+		return fmt.Errorf("failed to revoke mandate: %w", err)
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to revoke mandate: %w", err)
+	}
+	if rows == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// CreateAutoReloadRule stores an auto-reload rule and returns its ID
+func (p *PostgresDB) CreateAutoReloadRule(rule models.AutoReloadRule) (int, error) {
+	query := `
+		INSERT INTO auto_reload_rules (user_id, currency, threshold, amount, mandate_id, status, created_at, updated_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $7)
+		RETURNING id
+	`
+
+	var id int
+	err := p.db.QueryRow(query,
+		rule.UserID,
+		rule.Currency,
+		rule.Threshold,
+		rule.Amount,
+		rule.MandateID,
+		rule.Status,
+		rule.CreatedAt,
+	).Scan(&id)
+	if err != nil {
+		return 0, fmt.Errorf("failed to create auto-reload rule: %w", err)
+	}
+
+	return id, nil
+}
+
+// GetAutoReloadRuleByID fetches an auto-reload rule, returning sql.ErrNoRows when there is none
+func (p *PostgresDB) GetAutoReloadRuleByID(ruleID int) (*models.AutoReloadRule, error) {
+	rows, err := p.db.Query(`SELECT `+autoReloadRuleColumns+` FROM auto_reload_rules WHERE id = $1`, ruleID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch auto-reload rule: %w", err)
+	}
+	rules, err := scanAutoReloadRules(rows)
+	if err != nil {
+		return nil, err
+	}
+	if len(rules) == 0 {
+		return nil, sql.ErrNoRows
+	}
+	return &rules[0], nil
+}
+
+// ListAutoReloadRules returns a user's auto-reload rules, oldest first
+func (p *PostgresDB) ListAutoReloadRules(userID int) ([]models.AutoReloadRule, error) {
+	rows, err := p.db.Query(`SELECT `+autoReloadRuleColumns+` FROM auto_reload_rules WHERE user_id = $1 ORDER BY id`, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch auto-reload rules: %w", err)
+	}
+	return scanAutoReloadRules(rows)
+}
+
+// ClaimAutoReloadRules claims up to limit active auto-reload rules not claimed since claimedBefore,
+// marking them claimed at now. Claimed rows are locked so concurrent runs on other instances claim
+// different rules.
+func (p *PostgresDB) ClaimAutoReloadRules(now, claimedBefore time.Time, limit int) ([]models.AutoReloadRule, error) {
+	query := `
+		UPDATE auto_reload_rules
+		SET claimed_at = $1
+		WHERE id IN (
+			SELECT id FROM auto_reload_rules
+			WHERE status = $2 AND (claimed_at IS NULL OR claimed_at < $3)
+			ORDER BY claimed_at NULLS FIRST, id
+			LIMIT $4
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING ` + autoReloadRuleColumns
+
+	rows, err := p.db.Query(query, now, consts.AutoReloadActive, claimedBefore, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to claim auto-reload rules: %w", err)
+	}
+	return scanAutoReloadRules(rows)
+}
+
+// UpdateAutoReloadRule saves an auto-reload rule's status and reload state. A cancelled rule stays
+// cancelled, so a run that claimed it before the user cancelled it cannot revive it.
+func (p *PostgresDB) UpdateAutoReloadRule(rule models.AutoReloadRule) error {
+	query := `
+		UPDATE auto_reload_rules
+		SET status = CASE WHEN status = $11 THEN status ELSE $1 END, disabled_reason = $2, consecutive_failures = $3, last_transaction_id = $4,
+			last_reload_status = $5, last_reload_at = $6, window_start = $7, window_reloads = $8, updated_at = $9
+		WHERE id = $10
+	`
+
+	result, err := p.db.Exec(query,
+		rule.Status,
+		sql.NullString{String: rule.DisabledReason, Valid: rule.DisabledReason != ""},
+		rule.ConsecutiveFailures,
+		sql.NullInt64{Int64: int64(rule.LastTransactionID), Valid: rule.LastTransactionID != 0},
+		sql.NullString{String: rule.LastReloadStatus, Valid: rule.LastReloadStatus != ""},
+		sql.NullTime{Time: rule.LastReloadAt, Valid: !rule.LastReloadAt.IsZero()},
+		sql.NullTime{Time: rule.WindowStart, Valid: !rule.WindowStart.IsZero()},
+		rule.WindowReloads,
+		rule.UpdatedAt,
+		rule.ID,
+		consts.AutoReloadCancelled,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to update auto-reload rule: %w", err)
+	}
+	if rows, err := result.RowsAffected(); err == nil && rows == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// autoReloadRuleColumns are the columns scanAutoReloadRules reads, in order
+const autoReloadRuleColumns = `id, user_id, currency, threshold, amount, mandate_id, status, disabled_reason,
+	consecutive_failures, last_transaction_id, last_reload_status, last_reload_at, window_start, window_reloads,
+	created_at, updated_at`
+
+// scanAutoReloadRules reads and closes rows of autoReloadRuleColumns
+func scanAutoReloadRules(rows *sql.Rows) ([]models.AutoReloadRule, error) {
+	defer rows.Close()
+
+	var rules []models.AutoReloadRule
+	for rows.Next() {
+		var rule models.AutoReloadRule
+		var disabledReason, lastReloadStatus sql.NullString
+		var lastTransactionID sql.NullInt64
+		var lastReloadAt, windowStart sql.NullTime
+
+		if err := rows.Scan(
+			&rule.ID,
+			&rule.UserID,
+			&rule.Currency,
+			&rule.Threshold,
+			&rule.Amount,
+			&rule.MandateID,
+			&rule.Status,
+			&disabledReason,
+			&rule.ConsecutiveFailures,
+			&lastTransactionID,
+			&lastReloadStatus,
+			&lastReloadAt,
+			&windowStart,
+			&rule.WindowReloads,
+			&rule.CreatedAt,
+			&rule.UpdatedAt,
+		); err != nil {
+			return nil, fmt.Errorf("failed to scan auto-reload rule: %w", err)
+		}
+
+		rule.DisabledReason = disabledReason.String
+		rule.LastTransactionID = int(lastTransactionID.Int64)
+		rule.LastReloadStatus = lastReloadStatus.String
+		rule.LastReloadAt = lastReloadAt.Time
+		rule.WindowStart = windowStart.Time
+		rules = append(rules, rule)
+	}
+
+	return rules, rows.Err()
+}
+
+// walletLedgerQuery selects the entries of a user's wallet in a currency and mode ($1 to $3):
+// completed deposits and transfers received add to it, while withdrawals and refunds that have not
+// failed, transfers sent and active holds on deposits take from it. Archived transactions still count.
+const walletLedgerQuery = `
+	SELECT CASE WHEN type = $4 THEN amount ELSE -amount END AS amount
+	FROM transactions
+	WHERE user_id = $1 AND currency = $2 AND livemode = $3
+	  AND ((type = $4 AND status = $5) OR (type = $6 AND status <> $7))
+	UNION ALL
+	SELECT CASE WHEN type = $4 THEN amount ELSE -amount END
+	FROM transactions_archive
+	WHERE user_id = $1 AND currency = $2 AND livemode = $3
+	  AND ((type = $4 AND status = $5) OR (type = $6 AND status <> $7))
+	UNION ALL
+	SELECT amount FROM transfers WHERE to_user_id = $1 AND currency = $2 AND livemode = $3 AND status = $5
+	UNION ALL
+	SELECT -amount FROM transfers WHERE from_user_id = $1 AND currency = $2 AND livemode = $3 AND status = $5
+	UNION ALL
+	SELECT -amount FROM transaction_holds WHERE user_id = $1 AND currency = $2 AND livemode = $3 AND status = $8
+	UNION ALL
+	SELECT -r.amount FROM refunds r JOIN transactions t ON t.id = r.transaction_id
+	WHERE t.user_id = $1 AND r.currency = $2 AND t.livemode = $3 AND r.status <> $7
+	UNION ALL
+	SELECT -r.amount FROM refunds r JOIN transactions_archive t ON t.id = r.transaction_id
+	WHERE t.user_id = $1 AND r.currency = $2 AND t.livemode = $3 AND r.status <> $7
+`
+
+// walletLedgerArgs returns the parameters of walletLedgerQuery
+func walletLedgerArgs(userID int, currency string, livemode bool) []interface{} {
+	return []interface{}{userID, currency, livemode, consts.Deposit, consts.Completed, consts.Withdrawal, consts.Failed, consts.HoldActive}
+}
+
+// transferStatsQuery counts the transfers in a status ($6) a user sent in a currency and mode since
+// a time, and those ever sent to a recipient
+const transferStatsQuery = `
+	SELECT COUNT(*) FILTER (WHERE created_at >= $5),
+		   COALESCE(SUM(amount) FILTER (WHERE created_at >= $5), 0),
+		   COUNT(*) FILTER (WHERE to_user_id = $2)
+	FROM transfers
+	WHERE from_user_id = $1 AND currency = $3 AND livemode = $4 AND status = $6
+`
+
+// GetWalletBalance sums a user's wallet ledger in a currency and mode
+func (p *PostgresDB) GetWalletBalance(userID int, currency string, livemode bool) (float64, error) {
+	var balance float64
+	query := `SELECT COALESCE(SUM(amount), 0) FROM (` + walletLedgerQuery + `) ledger`
+	if err := p.db.QueryRow(query, walletLedgerArgs(userID, currency, livemode)...).Scan(&balance); err != nil {
+		return 0, fmt.Errorf("failed to get wallet balance: %w", err)
+	}
+	return balance, nil
+}
+
+// CreateTransfer records a transfer in the wallet ledger and returns it as recorded. A completed
+// transfer is first decided by check given the completed transfers its sender sent since a time,
+// and is only recorded completed while their balance covers its amount; otherwise it is recorded
+// declined with insufficient_funds. Transfers from the same user are serialized on their user
+// row, so two cannot spend the same funds or both fit under the same limit.
+func (p *PostgresDB) CreateTransfer(transfer models.Transfer, since time.Time, check TransferCheck) (*models.Transfer, error) {
+	tx, err := p.db.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transfer: %w", err)
+	}
+	defer tx.Rollback()
+
+	if transfer.Status == consts.Completed {
+		if _, err := tx.Exec(`SELECT id FROM users WHERE id = $1 FOR UPDATE`, transfer.FromUserID); err != nil {
+			return nil, fmt.Errorf("failed to lock sender: %w", err)
+		}
+
+		var stats models.TransferStats
+		err := tx.QueryRow(transferStatsQuery, transfer.FromUserID, transfer.ToUserID, transfer.Currency, transfer.Livemode, since, consts.Completed).Scan(
+			&stats.Count,
+			&stats.Amount,
+			&stats.ToRecipient,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get transfer stats: %w", err)
+		}
+		transfer = check(transfer, stats)
+	}
+
+	if transfer.Status == consts.Completed {
+		var covered bool
+		query := `SELECT COALESCE(SUM(amount), 0) >= $9 FROM (` + walletLedgerQuery + `) ledger`
+		args := append(walletLedgerArgs(transfer.FromUserID, transfer.Currency, transfer.Livemode), transfer.Amount)
+		if err := tx.QueryRow(query, args...).Scan(&covered); err != nil {
+			return nil, fmt.Errorf("failed to check wallet balance: %w", err)
+		}
+		if !covered {
+			transfer.Status = consts.Failed
+			transfer.DeclineCode = consts.DeclineInsufficientFunds
+		}
+	}
+
+	err = tx.QueryRow(`
+		INSERT INTO transfers (
+			from_user_id, to_user_id, merchant_id, amount, currency, description, status, decline_code, risk_flags, livemode, created_at
+		) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)
+		RETURNING id
+	`,
+		transfer.FromUserID,
+		transfer.ToUserID,
+		transfer.MerchantID,
+		transfer.Amount,
+		transfer.Currency,
+		sql.NullString{String: transfer.Description, Valid: transfer.Description != ""},
+		transfer.Status,
+		sql.NullString{String: transfer.DeclineCode, Valid: transfer.DeclineCode != ""},
+		pq.Array(transfer.RiskFlags),
+		transfer.Livemode,
+		transfer.CreatedAt,
+	).Scan(&transfer.ID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create transfer: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit transfer: %w", err)
+	}
+	return &transfer, nil
+}
+
+// GetTransfer fetches a transfer the user sent or received
+func (p *PostgresDB) GetTransfer(transferID, userID int) (*models.Transfer, error) {
+	query := `
+		SELECT id, from_user_id, to_user_id, merchant_id, amount, currency, description, status, decline_code,
+			   risk_flags, livemode, created_at
+		FROM transfers
+		WHERE id = $1 AND (from_user_id = $2 OR to_user_id = $2)
+	`
+
+	var transfer models.Transfer
+	var description, declineCode sql.NullString
+	err := p.db.QueryRow(query, transferID, userID).Scan(
+		&transfer.ID,
+		&transfer.FromUserID,
+		&transfer.ToUserID,
+		&transfer.MerchantID,
+		&transfer.Amount,
+		&transfer.Currency,
+		&description,
+		&transfer.Status,
+		&declineCode,
+		pq.Array(&transfer.RiskFlags),
+		&transfer.Livemode,
+		&transfer.CreatedAt,
+	)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, err
+		}
+		return nil, fmt.Errorf("failed to get transfer: %w", err)
+	}
+	transfer.Description = description.String
+	transfer.DeclineCode = declineCode.String
+
+	return &transfer, nil
+}
+
+// encryptNullString encrypts a value for storage, or returns NULL for ""
+func encryptNullString(value string) (sql.NullString, error) {
+	if value == "" {
+		return sql.NullString{}, nil
+	}
+	encrypted, err := utils.EncryptString(value)
+	if err != nil {
+		return sql.NullString{}, err
+	}
+	return sql.NullString{String: encrypted, Valid: true}, nil
+}
+
+// decryptNullString decrypts a value stored with encryptNullString
+func decryptNullString(stored sql.NullString) (string, error) {
+	if !stored.Valid || stored.String == "" {
+		return "", nil
+	}
+	return utils.DecryptString(stored.String)
+}
+
+// CreateBatch creates a new batch record with its item results
+func (p *PostgresDB) CreateBatch(batch models.Batch) (int, error) {
+	items, err := json.Marshal(batch.Items)
+	if err != nil {
+		return 0, fmt.Errorf("failed to marshal batch items: %w", err)
+	}
+
+	query := `
+		INSERT INTO batches (
+			type, status, total, succeeded, failed, items, operation_id, created_at
+		) VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+		RETURNING id
+	`
+
+	var id int
+	err = p.db.QueryRow(
+		query,
+		batch.Type,
+		batch.Status,
+		batch.Total,
+		batch.Succeeded,
+		batch.Failed,
+		items,
+		sql.NullString{String: batch.OperationID, Valid: batch.OperationID != ""},
+		batch.CreatedAt,
+	).Scan(&id)
+
+	if err != nil {
+		return 0, fmt.Errorf("failed to create batch: %w", err)
+	}
+
+	return id, nil
+}
+
+// GetBatchByID fetches a batch by ID
+func (p *PostgresDB) GetBatchByID(batchID int) (*models.Batch, error) {
+	query := `
+		SELECT id, type, status, total, succeeded, failed, items, operation_id, created_at, updated_at
+		FROM batches
+		WHERE id = $1
+	`
+
+	var batch models.Batch
+	var items []byte
+	var operationID sql.NullString
+	var updatedAt sql.NullTime
+
+	err := p.db.QueryRow(query, batchID).Scan(
+		&batch.ID,
+		&batch.Type,
+		&batch.Status,
+		&batch.Total,
+		&batch.Succeeded,
+		&batch.Failed,
+		&items,
+		&operationID,
+		&batch.CreatedAt,
+		&updatedAt,
+	)
+
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, fmt.Errorf("batch not found: %w", err)
+		}
+		return nil, fmt.Errorf("failed to fetch batch: %w", err)
+	}
+
+	if err := json.Unmarshal(items, &batch.Items); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal batch items: %w", err)
+	}
+	if operationID.Valid {
+		batch.OperationID = operationID.String
+	}
+	if updatedAt.Valid {
+		batch.UpdatedAt = updatedAt.Time
+	}
+
+	return &batch, nil
+}
+
+// UpdateBatch updates a batch's status, counters and item results
+func (p *PostgresDB) UpdateBatch(batch models.Batch) error {
+	items, err := json.Marshal(batch.Items)
+	if err != nil {
+		return fmt.Errorf("failed to marshal batch items: %w", err)
+	}
+
+	query := `
+		UPDATE batches
+		SET status = $1, total = $2, succeeded = $3, failed = $4, items = $5, updated_at = CURRENT_TIMESTAMP
+		WHERE id = $6
+	`
+
+	_, err = p.db.Exec(query, batch.Status, batch.Total, batch.Succeeded, batch.Failed, items, batch.ID)
+	if err != nil {
+		return fmt.Errorf("failed to update batch: %w", err)
+	}
+
+	return nil
+}
+
+// CreateOperation creates a new long-running operation record
+func (p *PostgresDB) CreateOperation(op models.Operation) error {
+	query := `
+		INSERT INTO operations (
+			id, type, status, progress, processed, total, result, error_message, created_at, expires_at
+		) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)
+	`
+
+	_, err := p.db.Exec(
+		query,
+		op.ID,
+		op.Type,
+		op.Status,
+		op.Progress,
+		op.Processed,
+		op.Total,
+		nullableJSON(op.Result),
+		op.Error,
+		op.CreatedAt,
+		op.ExpiresAt,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to create operation: %w", err)
+	}
+
+	return nil
+}
+
+// GetOperationByID fetches an operation by ID
+func (p *PostgresDB) GetOperationByID(operationID string) (*models.Operation, error) {
+	query := `
+		SELECT id, type, status, progress, processed, total, result, error_message,
+			   created_at, updated_at, expires_at
+		FROM operations
+		WHERE id = $1
+	`
+
+	return scanOperation(p.db.QueryRow(query, operationID))
+}
+
+// GetLatestOperationByType fetches the most recently created operation of the given type
+func (p *PostgresDB) GetLatestOperationByType(opType string) (*models.Operation, error) {
+	query := `
+		SELECT id, type, status, progress, processed, total, result, error_message,
+			   created_at, updated_at, expires_at
+		FROM operations
+		WHERE type = $1
+		ORDER BY created_at DESC
+		LIMIT 1
+	`
+
+	return scanOperation(p.db.QueryRow(query, opType))
+}
+
+// scanOperation reads a single operation row
+func scanOperation(row *sql.Row) (*models.Operation, error) {
+	var op models.Operation
+	var result []byte
+	var errorMessage sql.NullString
+	var updatedAt sql.NullTime
+
+	err := row.Scan(
+		&op.ID,
+		&op.Type,
+		&op.Status,
+		&op.Progress,
+		&op.Processed,
+		&op.Total,
+		&result,
+		&errorMessage,
+		&op.CreatedAt,
+		&updatedAt,
+		&op.ExpiresAt,
+	)
+
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, fmt.Errorf("operation not found: %w", err)
+		}
+		return nil, fmt.Errorf("failed to fetch operation: %w", err)
+	}
+
+	if len(result) > 0 {
+		op.Result = result
+	}
+	if errorMessage.Valid {
+		op.Error = errorMessage.String
+	}
+	if updatedAt.Valid {
+		op.UpdatedAt = updatedAt.Time
+	}
+
+	return &op, nil
+}
+
+// UpdateOperation updates an operation's status, progress and result
+func (p *PostgresDB) UpdateOperation(op models.Operation) error {
+	query := `
+		UPDATE operations
+		SET status = $1, progress = $2, processed = $3, total = $4, result = $5,
+			error_message = $6, updated_at = CURRENT_TIMESTAMP
+		WHERE id = $7
+	`
+
+	_, err := p.db.Exec(query, op.Status, op.Progress, op.Processed, op.Total, nullableJSON(op.Result), op.Error, op.ID)
+	if err != nil {
+		return fmt.Errorf("failed to update operation: %w", err)
+	}
+
+	return nil
+}
+
+// DeleteExpiredOperations removes operations that expired before the given time
+func (p *PostgresDB) DeleteExpiredOperations(before time.Time) (int64, error) {
+	result, err := p.db.Exec(`DELETE FROM operations WHERE expires_at < $1`, before)
+	if err != nil {
+		return 0, fmt.Errorf("failed to delete expired operations: %w", err)
+	}
+
+	return result.RowsAffected()
+}
+
+// Ping checks the database connection
+func (p *PostgresDB) Ping() error {
+	return p.db.Ping()
+}
+
+// Close closes the database connection
+func (p *PostgresDB) Close() error {
+	return p.db.Close()
+}
+
+// nullableJSON converts an empty JSON payload into a SQL NULL
+func nullableJSON(data json.RawMessage) interface{} {
+	if len(data) == 0 {
+		return nil
+	}
+	return []byte(data)
+}
+
+// CreateRefund records a refund of a deposit. It is only recorded while the deposit's amount
+// covers it together with the deposit's refunds that haven't failed, returning sql.ErrNoRows
+// otherwise; refunds of the same deposit are serialized on its row so two cannot refund the same
+// amount. As the refund takes its amount back out of the user's wallet, it is also only recorded
+// while their balance covers it, returning ErrInsufficientBalance otherwise; this check holds the
+// user row that transfers lock, so the funds cannot be refunded and transferred at once.
+func (p *PostgresDB) CreateRefund(refund models.Refund) (int, error) {
+	tx, err := p.db.Begin()
+	if err != nil {
+		return 0, fmt.Errorf("failed to begin refund: %w", err)
+	}
+	defer tx.Rollback()
+
+	var userID int
+	var livemode bool
+	err = tx.QueryRow(`SELECT user_id, livemode FROM transactions WHERE id = $1 FOR UPDATE`, refund.TransactionID).Scan(&userID, &livemode)
+	if err != nil {
+		return 0, fmt.Errorf("failed to lock transaction: %w", err)
+	}
+
+	var covered bool
+	err = tx.QueryRow(`
+		SELECT (SELECT amount FROM transactions WHERE id = $1) >= COALESCE(SUM(amount), 0) + $2
+		FROM refunds
+		WHERE transaction_id = $1 AND status <> $3
+	`, refund.TransactionID, refund.Amount, consts.Failed).Scan(&covered)
+	if err != nil {
+		return 0, fmt.Errorf("failed to check refundable amount: %w", err)
+	}
+	if !covered {
+		return 0, sql.ErrNoRows
+	}
+
+	if _, err := tx.Exec(`SELECT id FROM users WHERE id = $1 FOR UPDATE`, userID); err != nil {
+		return 0, fmt.Errorf("failed to lock user: %w", err)
+	}
+	query := `SELECT COALESCE(SUM(amount), 0) >= $9 FROM (` + walletLedgerQuery + `) ledger`
+	args := append(walletLedgerArgs(userID, refund.Currency, livemode), refund.Amount)
+	if err := tx.QueryRow(query, args...).Scan(&covered); err != nil {
+		return 0, fmt.Errorf("failed to check wallet balance: %w", err)
+	}
+	if !covered {
+		return 0, ErrInsufficientBalance
+	}
+
+	var id int
+	err = tx.QueryRow(`
+		INSERT INTO refunds (transaction_id, amount, currency, status, reason, created_at, updated_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $6)
+		RETURNING id
+	`,
+		refund.TransactionID,
+		refund.Amount,
+		refund.Currency,
+		refund.Status,
+		sql.NullString{String: refund.Reason, Valid: refund.Reason != ""},
+		refund.CreatedAt,
+	).Scan(&id)
+	if err != nil {
+		return 0, fmt.Errorf("failed to create refund: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("failed to commit refund: %w", err)
+	}
+	return id, nil
+}
+
+// UpdateRefund records a refund's status, gateway reference and decline, returning
+// sql.ErrNoRows when no such refund exists
+func (p *PostgresDB) UpdateRefund(refund models.Refund) error {
+	result, err := p.db.Exec(`
+		UPDATE refunds
+		SET status = $2, gateway_reference = $3, decline_code = $4, error_message = $5, updated_at = $6
+		WHERE id = $1
+	`,
+		refund.ID,
+		refund.Status,
+		sql.NullString{String: refund.GatewayReference, Valid: refund.GatewayReference != ""},
+		sql.NullString{String: refund.DeclineCode, Valid: refund.DeclineCode != ""},
+		sql.NullString{String: refund.ErrorMessage, Valid: refund.ErrorMessage != ""},
+		refund.UpdatedAt,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to update refund: %w", err)
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return fmt.Errorf("refund not found: %w", sql.ErrNoRows)
+	}
+	return nil
+}
+
+// GetRefund fetches a refund
+func (p *PostgresDB) GetRefund(refundID int) (*models.Refund, error) {
+	refunds, err := p.queryRefunds(`WHERE id = $1`, refundID)
+	if err != nil {
+		return nil, err
+	}
+	if len(refunds) == 0 {
+		return nil, fmt.Errorf("refund not found: %w", sql.ErrNoRows)
+	}
+	return &refunds[0], nil
+}
+
+// GetTransactionRefunds fetches the refunds of a transaction, oldest first
+func (p *PostgresDB) GetTransactionRefunds(txID int) ([]models.Refund, error) {
+	return p.queryRefunds(`WHERE transaction_id = $1 ORDER BY id`, txID)
+}
+
+// queryRefunds fetches the refunds a WHERE clause selects
+func (p *PostgresDB) queryRefunds(where string, args ...interface{}) ([]models.Refund, error) {
+	rows, err := p.db.Query(`
+		SELECT id, transaction_id, amount, currency, status, reason, gateway_reference, decline_code, error_message, created_at, updated_at
+		FROM refunds
+		`+where, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch refunds: %w", err)
+	}
+	defer rows.Close()
+
+	var refunds []models.Refund
+	for rows.Next() {
+		var refund models.Refund
+		var reason, gatewayReference, declineCode, errorMessage sql.NullString
+
+		if err := rows.Scan(
+			&refund.ID,
+			&refund.TransactionID,
+			&refund.Amount,
+			&refund.Currency,
+			&refund.Status,
+			&reason,
+			&gatewayReference,
+			&declineCode,
+			&errorMessage,
+			&refund.CreatedAt,
+			&refund.UpdatedAt,
+		); err != nil {
+			return nil, fmt.Errorf("failed to scan refund: %w", err)
+		}
+
+		refund.Reason = reason.String
+		refund.GatewayReference = gatewayReference.String
+		refund.DeclineCode = declineCode.String
+		refund.ErrorMessage = errorMessage.String
+		refunds = append(refunds, refund)
+	}
+
+	return refunds, rows.Err()
+}
+
+// AddTransactionTags attaches tags to a transaction, ignoring those it already carries
+func (p *PostgresDB) AddTransactionTags(txID int, tags []string, createdAt time.Time) error {
+	_, err := p.db.Exec(`
+		INSERT INTO transaction_tags (transaction_id, tag, created_at)
+		SELECT $1, tag, $3 FROM unnest($2::text[]) AS tag
+		ON CONFLICT (transaction_id, tag) DO NOTHING
+	`, txID, pq.Array(tags), createdAt)
+	if err != nil {
+		return fmt.Errorf("failed to tag transaction: %w", err)
+	}
+	return nil
+}
+
+// RemoveTransactionTag removes a tag from a transaction, returning sql.ErrNoRows when it doesn't
+// carry the tag
+func (p *PostgresDB) RemoveTransactionTag(txID int, tag string) error {
+	result, err := p.db.Exec(`DELETE FROM transaction_tags WHERE transaction_id = $1 AND tag = $2`, txID, tag)
+	if err != nil {
+		return fmt.Errorf("failed to remove transaction tag: %w", err)
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// GetTransactionTags fetches a transaction's tags in alphabetical order
+func (p *PostgresDB) GetTransactionTags(txID int) ([]string, error) {
+	rows, err := p.db.Query(`SELECT tag FROM transaction_tags WHERE transaction_id = $1 ORDER BY tag`, txID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch transaction tags: %w", err)
+	}
+	defer rows.Close()
+
+	tags := []string{}
+	for rows.Next() {
+		var tag string
+		if err := rows.Scan(&tag); err != nil {
+			return nil, fmt.Errorf("failed to scan transaction tag: %w", err)
+		}
+		tags = append(tags, tag)
+	}
+	return tags, rows.Err()
+}
+
+// ListTransactions returns the transactions a filter selects with their tags, newest first
+func (p *PostgresDB) ListTransactions(filter models.TransactionFilter) ([]models.TransactionSummary, error) {
+	from := sql.NullTime{Time: filter.From, Valid: !filter.From.IsZero()}
+	to := sql.NullTime{Time: filter.To, Valid: !filter.To.IsZero()}
+
+	query := `
+		SELECT t.id, t.type, t.status, t.amount, t.currency, t.user_id, t.gateway_id, t.country_id,
+			   t.reference_id, t.decline_code, t.livemode, t.created_at,
+			   ARRAY(SELECT g.tag FROM transaction_tags g WHERE g.transaction_id = t.id ORDER BY g.tag)
+		FROM transactions t
+		JOIN users u ON u.id = t.user_id
+		WHERE t.deleted_at IS NULL
+		  AND ($1 = 0 OR u.merchant_id = $1)
+		  AND ($2 = '' OR t.status = $2)
+		  AND ($3 = '' OR t.type = $3)
+		  AND ($4::timestamp IS NULL OR t.created_at >= $4)
+		  AND ($5::timestamp IS NULL OR t.created_at < $5)
+		  AND ($6 = 0 OR t.id < $6)
+		  AND (SELECT COUNT(*) FROM transaction_tags g WHERE g.transaction_id = t.id AND g.tag = ANY($7)) = cardinality($7::text[])
+		ORDER BY t.id DESC
+		LIMIT $8
+	`
+
+	rows, err := p.db.Query(query, filter.MerchantID, filter.Status, filter.Type, from, to, filter.BeforeID,
+		pq.Array(filter.Tags), filter.Limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list transactions: %w", err)
+	}
+	defer rows.Close()
+
+	var transactions []models.TransactionSummary
+	for rows.Next() {
+		var tx models.TransactionSummary
+		var referenceID, declineCode sql.NullString
+
+		if err := rows.Scan(
+			&tx.ID,
+			&tx.Type,
+			&tx.Status,
+			&tx.Amount,
+			&tx.Currency,
+			&tx.UserID,
+			&tx.GatewayID,
+			&tx.CountryID,
+			&referenceID,
+			&declineCode,
+			&tx.Livemode,
+			&tx.CreatedAt,
+			pq.Array(&tx.Tags),
+		); err != nil {
+			return nil, fmt.Errorf("failed to scan transaction: %w", err)
+		}
+
+		tx.ReferenceID = referenceID.String
+		tx.DeclineCode = declineCode.String
+		transactions = append(transactions, tx)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating transactions: %w", err)
+	}
+
+	return transactions, nil
+}
+
+// CreateWebhookEndpoint records a merchant's webhook endpoint and returns its ID
+func (p *PostgresDB) CreateWebhookEndpoint(endpoint models.WebhookEndpoint) (int, error) {
+	query := `
+		INSERT INTO webhook_endpoints (merchant_id, url, event_types, payload_version, description, created_at, updated_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+		RETURNING id
+	`
+
+	var id int
+	err := p.db.QueryRow(query,
+		endpoint.MerchantID,
+		endpoint.URL,
+		pq.Array(endpoint.EventTypes),
+		endpoint.PayloadVersion,
+		sql.NullString{String: endpoint.Description, Valid: endpoint.Description != ""},
+		endpoint.CreatedAt,
+		endpoint.UpdatedAt,
+	).Scan(&id)
+	if err != nil {
+		return 0, fmt.Errorf("failed to create webhook endpoint: %w", err)
+	}
+
+	return id, nil
+}
+
+// webhookEndpointColumns are the columns scanned by scanWebhookEndpoint
+const webhookEndpointColumns = `id, merchant_id, url, event_types, payload_version, description, created_at, updated_at`
+
+// GetWebhookEndpoints fetches a merchant's webhook endpoints, oldest first
+func (p *PostgresDB) GetWebhookEndpoints(merchantID int) ([]models.WebhookEndpoint, error) {
+	query := `SELECT ` + webhookEndpointColumns + ` FROM webhook_endpoints WHERE merchant_id = $1 ORDER BY id`
+
+	rows, err := p.db.Query(query, merchantID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch webhook endpoints: %w", err)
+	}
+	defer rows.Close()
+
+	var endpoints []models.WebhookEndpoint
+	for rows.Next() {
+		endpoint, err := scanWebhookEndpoint(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan webhook endpoint: %w", err)
+		}
+		endpoints = append(endpoints, *endpoint)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating webhook endpoints: %w", err)
+	}
+
+	return endpoints, nil
+}
+
+// GetWebhookEndpoint fetches one of a merchant's webhook endpoints
+func (p *PostgresDB) GetWebhookEndpoint(merchantID, endpointID int) (*models.WebhookEndpoint, error) {
+	query := `SELECT ` + webhookEndpointColumns + ` FROM webhook_endpoints WHERE id = $1 AND merchant_id = $2`
+
+	endpoint, err := scanWebhookEndpoint(p.db.QueryRow(query, endpointID, merchantID))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, err
+		}
+		return nil, fmt.Errorf("failed to fetch webhook endpoint: %w", err)
+	}
+	return endpoint, nil
+}
+
+// UpdateWebhookEndpoint replaces the URL, event types, payload version and description of a
+// merchant's webhook endpoint, returning sql.ErrNoRows when the merchant has no such endpoint
+func (p *PostgresDB) UpdateWebhookEndpoint(endpoint models.WebhookEndpoint) error {
+	query := `
+		UPDATE webhook_endpoints
+		SET url = $1, event_types = $2, payload_version = $3, description = $4, updated_at = $5
+		WHERE id = $6 AND merchant_id = $7
+	`
+
+	result, err := p.db.Exec(query,
+		endpoint.URL,
+		pq.Array(endpoint.EventTypes),
+		endpoint.PayloadVersion,
+		sql.NullString{String: endpoint.Description, Valid: endpoint.Description != ""},
+		endpoint.UpdatedAt,
+		endpoint.ID,
+		endpoint.MerchantID,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to update webhook endpoint: %w", err)
+	}
+	if n, err := result.RowsAffected(); err != nil {
+		return fmt.Errorf("failed to update webhook endpoint: %w", err)
+	} else if n == 0 {
+		return sql.ErrNoRows
+	}
+
+	return nil
+}
+
+// DeleteWebhookEndpoint deletes one of a merchant's webhook endpoints, returning sql.ErrNoRows
+// when the merchant has no such endpoint
+func (p *PostgresDB) DeleteWebhookEndpoint(merchantID, endpointID int) error {
+	result, err := p.db.Exec(`DELETE FROM webhook_endpoints WHERE id = $1 AND merchant_id = $2`, endpointID, merchantID)
+	if err != nil {
+		return fmt.Errorf("failed to delete webhook endpoint: %w", err)
+	}
+	if n, err := result.RowsAffected(); err != nil {
+		return fmt.Errorf("failed to delete webhook endpoint: %w", err)
+	} else if n == 0 {
+		return sql.ErrNoRows
+	}
+
+	return nil
+}
+
+// scanWebhookEndpoint reads a webhook endpoint selected with webhookEndpointColumns
+func scanWebhookEndpoint(row interface{ Scan(...interface{}) error }) (*models.WebhookEndpoint, error) {
+	var endpoint models.WebhookEndpoint
+	var description sql.NullString
+
+	if err := row.Scan(
+		&endpoint.ID,
+		&endpoint.MerchantID,
+		&endpoint.URL,
+		pq.Array(&endpoint.EventTypes),
+		&endpoint.PayloadVersion,
+		&description,
+		&endpoint.CreatedAt,
+		&endpoint.UpdatedAt,
+	); err != nil {
+		return nil, err
+	}
+
+	endpoint.Description = description.String
+	return &endpoint, nil
 }
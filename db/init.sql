@@ -6,6 +6,7 @@ CREATE TABLE IF NOT EXISTS countries (
                                          name VARCHAR(255) NOT NULL UNIQUE,
     code CHAR(2) NOT NULL UNIQUE,
     currency CHAR(3) NOT NULL DEFAULT 'USD',
+    timezone VARCHAR(64) NOT NULL DEFAULT 'UTC', -- IANA zone payout schedules are local to
     created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
     updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
     );
@@ -27,27 +28,558 @@ CREATE TABLE IF NOT EXISTS gateway_countries (
     FOREIGN KEY (country_id) REFERENCES countries(id)
     );
 
+-- Fields transactions in a country must carry for compliance, e.g. the payer's CPF in Brazil
+CREATE TABLE IF NOT EXISTS country_compliance_fields (
+                                                         country_id INT NOT NULL,
+                                                         position INT NOT NULL,
+                                                         name VARCHAR(50) NOT NULL,
+    label VARCHAR(100) NOT NULL,
+    pattern VARCHAR(255), -- regular expression the whole value must match
+    transaction_types TEXT[] NOT NULL DEFAULT '{}', -- deposit and/or withdrawal; all types when empty
+    description TEXT,
+    PRIMARY KEY (country_id, name),
+    FOREIGN KEY (country_id) REFERENCES countries(id)
+    );
+
+-- Amounts from which transactions in a country must carry travel rule details and open an AML case
+CREATE TABLE IF NOT EXISTS aml_thresholds (
+                                              country_id INT NOT NULL,
+                                              currency VARCHAR(3) NOT NULL,
+    amount DECIMAL(15, 2) NOT NULL,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (country_id, currency),
+    FOREIGN KEY (country_id) REFERENCES countries(id)
+    );
+
+-- Bank holidays per country; weekends are never banking days
+CREATE TABLE IF NOT EXISTS bank_holidays (
+                                             country_id INT NOT NULL,
+                                             holiday_date DATE NOT NULL,
+                                             name VARCHAR(100) NOT NULL,
+    PRIMARY KEY (country_id, holiday_date),
+    FOREIGN KEY (country_id) REFERENCES countries(id)
+    );
+
+-- What customers should do after each normalized decline code, returned as recovery_hint
+CREATE TABLE IF NOT EXISTS decline_recovery_hints (
+                                                      decline_code VARCHAR(50) PRIMARY KEY,
+                                                      recovery_hint VARCHAR(20) NOT NULL,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+    );
+
+-- Transactions users flagged as unrecognized, awaiting review. One dispute per transaction.
+CREATE TABLE IF NOT EXISTS disputes (
+                                        id SERIAL PRIMARY KEY,
+                                        transaction_id INT NOT NULL UNIQUE,
+                                        user_id INT NOT NULL,
+                                        description TEXT,
+                                        status VARCHAR(20) NOT NULL DEFAULT 'open',
+    resolution TEXT,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+    );
+
+CREATE INDEX IF NOT EXISTS idx_disputes_status ON disputes (status, created_at);
+
+-- Refunds of completed deposits, partial or full. A deposit's refunds that haven't failed never
+-- add up to more than its amount.
+CREATE TABLE IF NOT EXISTS refunds (
+                                       id SERIAL PRIMARY KEY,
+                                       transaction_id INT NOT NULL,
+                                       amount DECIMAL(13, 3) NOT NULL,
+                                       currency VARCHAR(3) NOT NULL,
+                                       status VARCHAR(20) NOT NULL DEFAULT 'pending',
+    reason TEXT,
+    gateway_reference VARCHAR(255),
+    decline_code VARCHAR(50),
+    error_message TEXT,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+    );
+
+CREATE INDEX IF NOT EXISTS idx_refunds_transaction ON refunds (transaction_id);
+
+-- Searchable tags merchants and admins attach to transactions, e.g. campaign:blackfriday, in lower case
+CREATE TABLE IF NOT EXISTS transaction_tags (
+                                                transaction_id INT NOT NULL,
+                                                tag VARCHAR(64) NOT NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (transaction_id, tag)
+    );
+
+CREATE INDEX IF NOT EXISTS idx_transaction_tags_tag ON transaction_tags (tag, transaction_id);
+
+-- Holds keeping completed deposits out of their user's spendable wallet balance while the fraud
+-- pipeline has them reviewed. A deposit has at most one active hold.
+CREATE TABLE IF NOT EXISTS transaction_holds (
+                                                 id SERIAL PRIMARY KEY,
+                                                 transaction_id INT NOT NULL,
+                                                 user_id INT NOT NULL,
+                                                 amount DECIMAL(13, 3) NOT NULL,
+    currency VARCHAR(3) NOT NULL,
+    livemode BOOLEAN NOT NULL DEFAULT FALSE,
+    reason VARCHAR(255) NOT NULL,
+    status VARCHAR(20) NOT NULL DEFAULT 'active',
+    release_at TIMESTAMP NOT NULL,
+    release_note TEXT,
+    released_at TIMESTAMP,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+    );
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_transaction_holds_active ON transaction_holds (transaction_id) WHERE status = 'active';
+CREATE INDEX IF NOT EXISTS idx_transaction_holds_user ON transaction_holds (user_id, currency) WHERE status = 'active';
+CREATE INDEX IF NOT EXISTS idx_transaction_holds_status ON transaction_holds (status, release_at);
+
+-- Responses to deposits and withdrawals sent with an Idempotency-Key header, replayed to retries
+-- until they expire. Responses are empty while the first request is in progress.
+CREATE TABLE IF NOT EXISTS idempotency_keys (
+    caller VARCHAR(100) NOT NULL DEFAULT '', -- whom the key belongs to: merchant:<id>, or body:<sha256> without credentials
+    route VARCHAR(64) NOT NULL,
+    key VARCHAR(255) NOT NULL,
+    request_hash VARCHAR(64) NOT NULL,
+    status_code INT,
+    content_type VARCHAR(100),
+    response_body BYTEA,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    completed_at TIMESTAMP,
+    expires_at TIMESTAMP NOT NULL,
+    PRIMARY KEY (caller, route, key)
+    );
+
+CREATE INDEX IF NOT EXISTS idx_idempotency_keys_expires_at ON idempotency_keys (expires_at);
+
+-- Transactions that reached their country's AML threshold, awaiting review and reporting
+CREATE TABLE IF NOT EXISTS aml_cases (
+                                         id SERIAL PRIMARY KEY,
+                                         transaction_id INT NOT NULL UNIQUE,
+                                         reference_id VARCHAR(32),
+    user_id INT NOT NULL,
+    country_id INT NOT NULL,
+    type VARCHAR(20) NOT NULL,
+    amount DECIMAL(15, 2) NOT NULL,
+    currency VARCHAR(3) NOT NULL,
+    threshold DECIMAL(15, 2) NOT NULL,
+    travel_rule TEXT NOT NULL, -- encrypted JSON of the originator and beneficiary details
+    status VARCHAR(20) NOT NULL DEFAULT 'open',
+    note TEXT,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+    );
+
+CREATE INDEX IF NOT EXISTS idx_aml_cases_status ON aml_cases (status, created_at);
+
+-- Users and withdrawal beneficiaries whose names matched a sanctions list, held for review
+CREATE TABLE IF NOT EXISTS screening_cases (
+                                               id SERIAL PRIMARY KEY,
+                                               user_id INT NOT NULL,
+                                               trigger VARCHAR(20) NOT NULL,
+    name TEXT NOT NULL, -- encrypted screened name
+    amount DECIMAL(15, 2),
+    currency VARCHAR(3),
+    matches JSONB NOT NULL DEFAULT '[]',
+    status VARCHAR(20) NOT NULL DEFAULT 'open',
+    reviewer VARCHAR(100),
+    note TEXT,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+    );
+
+CREATE INDEX IF NOT EXISTS idx_screening_cases_status ON screening_cases (status, created_at);
+CREATE INDEX IF NOT EXISTS idx_screening_cases_user ON screening_cases (user_id, created_at);
+
+-- Append-only audit trail of each user's sanctions screenings and the decisions on them
+CREATE TABLE IF NOT EXISTS screening_events (
+                                                id SERIAL PRIMARY KEY,
+                                                user_id INT NOT NULL,
+                                                case_id INT,
+                                                action VARCHAR(20) NOT NULL,
+    actor VARCHAR(100) NOT NULL,
+    detail TEXT,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+    );
+
+CREATE INDEX IF NOT EXISTS idx_screening_events_user ON screening_events (user_id, id);
+
+-- Customers' consents to open banking deposits, authorised at their bank
+CREATE TABLE IF NOT EXISTS payment_consents (
+                                               id SERIAL PRIMARY KEY,
+                                               transaction_id INT NOT NULL UNIQUE,
+                                               gateway_id INT NOT NULL,
+                                               bank_id VARCHAR(64) NOT NULL,
+    external_id VARCHAR(255) NOT NULL,
+    status VARCHAR(30) NOT NULL DEFAULT 'awaiting_authorisation',
+    state_hash CHAR(64) NOT NULL UNIQUE,
+    authorisation_url TEXT,
+    expires_at TIMESTAMP NOT NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (gateway_id) REFERENCES gateways(id)
+    );
+
+CREATE INDEX IF NOT EXISTS idx_payment_consents_expiry ON payment_consents (status, expires_at);
+
+-- Consents of users letting an open banking gateway read their bank accounts, to link the accounts
+-- withdrawals are paid out to. The tokens they grant are stored encrypted.
+CREATE TABLE IF NOT EXISTS bank_account_consents (
+                                                    id SERIAL PRIMARY KEY,
+                                                    user_id INT NOT NULL,
+                                                    gateway_id INT NOT NULL,
+                                                    status VARCHAR(30) NOT NULL DEFAULT 'awaiting_authorisation',
+    state_hash CHAR(64) NOT NULL UNIQUE,
+    authorisation_url TEXT,
+    access_token TEXT, -- encrypted
+    refresh_token TEXT, -- encrypted
+    token_expires_at TIMESTAMP,
+    livemode BOOLEAN NOT NULL DEFAULT false,
+    expires_at TIMESTAMP NOT NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (gateway_id) REFERENCES gateways(id)
+    );
+
+-- Bank accounts users linked through an open banking gateway; account identifiers are encrypted
+CREATE TABLE IF NOT EXISTS linked_bank_accounts (
+                                                   id SERIAL PRIMARY KEY,
+                                                   user_id INT NOT NULL,
+                                                   gateway_id INT NOT NULL,
+                                                   consent_id INT NOT NULL,
+                                                   external_id VARCHAR(255) NOT NULL, -- the account's ID at the gateway
+    bank_name VARCHAR(255),
+    holder_name VARCHAR(255) NOT NULL,
+    currency VARCHAR(3) NOT NULL,
+    sort_code TEXT, -- encrypted
+    account_number TEXT, -- encrypted
+    iban TEXT, -- encrypted
+    last4 VARCHAR(4) NOT NULL,
+    status VARCHAR(20) NOT NULL DEFAULT 'active',
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE (user_id, gateway_id, external_id),
+    FOREIGN KEY (gateway_id) REFERENCES gateways(id),
+    FOREIGN KEY (consent_id) REFERENCES bank_account_consents(id)
+    );
+
+-- SEPA Direct Debit mandates users signed; IBANs are encrypted
+CREATE TABLE IF NOT EXISTS sepa_mandates (
+                                             id SERIAL PRIMARY KEY,
+                                             user_id INT NOT NULL,
+                                             gateway_id INT NOT NULL,
+                                             reference VARCHAR(35) NOT NULL UNIQUE, -- unique mandate reference (UMR)
+    debtor_name VARCHAR(70) NOT NULL,
+    iban TEXT NOT NULL, -- encrypted
+    bic VARCHAR(11),
+    last4 VARCHAR(4) NOT NULL,
+    sequence_type VARCHAR(4) NOT NULL DEFAULT 'FRST', -- FRST until a first debit was submitted, RCUR after
+    status VARCHAR(20) NOT NULL DEFAULT 'active',
+    revocation_reason VARCHAR(4), -- SEPA reason code of the return that revoked the mandate
+    signed_at TIMESTAMP NOT NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (gateway_id) REFERENCES gateways(id)
+    );
+
+CREATE INDEX IF NOT EXISTS idx_sepa_mandates_user ON sepa_mandates(user_id);
+
+-- Rules topping up users' wallets by deposits under one of their SEPA mandates whenever the balance drops
+-- below a threshold. The scheduler claims active rules with claimed_at, so instances check different rules.
+CREATE TABLE IF NOT EXISTS auto_reload_rules (
+                                                 id SERIAL PRIMARY KEY,
+                                                 user_id INT NOT NULL,
+                                                 currency VARCHAR(3) NOT NULL,
+    threshold DECIMAL(13, 3) NOT NULL,
+    amount DECIMAL(13, 3) NOT NULL,
+    mandate_id INT NOT NULL,
+    status VARCHAR(20) NOT NULL DEFAULT 'active', -- active, disabled or cancelled
+    disabled_reason TEXT,
+    consecutive_failures INT NOT NULL DEFAULT 0,
+    last_transaction_id INT, -- the last reload's deposit
+    last_reload_status VARCHAR(20),
+    last_reload_at TIMESTAMP,
+    window_start TIMESTAMP, -- first reload of the current daily count window
+    window_reloads INT NOT NULL DEFAULT 0,
+    claimed_at TIMESTAMP,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (mandate_id) REFERENCES sepa_mandates(id)
+    );
+
+CREATE INDEX IF NOT EXISTS idx_auto_reload_rules_user ON auto_reload_rules(user_id);
+CREATE INDEX IF NOT EXISTS idx_auto_reload_rules_active ON auto_reload_rules(claimed_at) WHERE status = 'active';
+
+-- Secrets gateways sign callbacks with. Several may be active during rotation; secrets are stored encrypted.
+CREATE TABLE IF NOT EXISTS webhook_secrets (
+                                               id SERIAL PRIMARY KEY,
+                                               gateway_id INT NOT NULL,
+                                               secret TEXT NOT NULL,
+                                               hint VARCHAR(8) NOT NULL,
+    status VARCHAR(20) NOT NULL DEFAULT 'active',
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    retired_at TIMESTAMP,
+    FOREIGN KEY (gateway_id) REFERENCES gateways(id)
+    );
+
+CREATE INDEX IF NOT EXISTS idx_webhook_secrets_gateway_id ON webhook_secrets (gateway_id);
+
+-- TLS client certificates gateways' connections authenticate with (mTLS); private keys are encrypted
+CREATE TABLE IF NOT EXISTS gateway_client_certificates (
+                                                          id SERIAL PRIMARY KEY,
+                                                          gateway_id INT NOT NULL,
+                                                          certificate TEXT NOT NULL,
+                                                          private_key TEXT NOT NULL,
+                                                          subject VARCHAR(255) NOT NULL DEFAULT '',
+    fingerprint VARCHAR(64) NOT NULL,
+    not_after TIMESTAMP NOT NULL,
+    status VARCHAR(20) NOT NULL DEFAULT 'active',
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    retired_at TIMESTAMP,
+    FOREIGN KEY (gateway_id) REFERENCES gateways(id)
+    );
+
+CREATE INDEX IF NOT EXISTS idx_gateway_client_certificates_gateway_id ON gateway_client_certificates (gateway_id);
+
+-- Private keys requests to gateways are signed with (JWS); private keys are encrypted
+CREATE TABLE IF NOT EXISTS gateway_signing_keys (
+                                                   id SERIAL PRIMARY KEY,
+                                                   gateway_id INT NOT NULL,
+                                                   key_id VARCHAR(255) NOT NULL,
+    algorithm VARCHAR(10) NOT NULL,
+    public_key TEXT NOT NULL,
+    private_key TEXT NOT NULL,
+    status VARCHAR(20) NOT NULL DEFAULT 'active',
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    retired_at TIMESTAMP,
+    FOREIGN KEY (gateway_id) REFERENCES gateways(id),
+    UNIQUE (gateway_id, key_id)
+    );
+
+-- Key pairs Apple Pay and Google Pay encrypt payment tokens to; private keys are encrypted
+CREATE TABLE IF NOT EXISTS wallet_merchant_certificates (
+                                                           id SERIAL PRIMARY KEY,
+                                                           wallet VARCHAR(20) NOT NULL,
+                                                           certificate TEXT NOT NULL DEFAULT '',
+                                                           public_key TEXT NOT NULL,
+                                                           private_key TEXT NOT NULL,
+                                                           public_key_hash VARCHAR(64) NOT NULL,
+    merchant_identifier VARCHAR(64) NOT NULL DEFAULT '',
+    not_after TIMESTAMP,
+    status VARCHAR(20) NOT NULL DEFAULT 'active',
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    retired_at TIMESTAMP
+    );
+
+CREATE INDEX IF NOT EXISTS idx_wallet_merchant_certificates_wallet ON wallet_merchant_certificates (wallet);
+
+-- Periods during which a gateway is taken out of routing, e.g. for planned gateway maintenance
+CREATE TABLE IF NOT EXISTS gateway_maintenance_windows (
+                                                          id SERIAL PRIMARY KEY,
+                                                          gateway_id INT NOT NULL,
+                                                          starts_at TIMESTAMP NOT NULL,
+                                                          ends_at TIMESTAMP NOT NULL,
+    reason VARCHAR(255) NOT NULL DEFAULT '',
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    cancelled_at TIMESTAMP,
+    FOREIGN KEY (gateway_id) REFERENCES gateways(id),
+    CHECK (ends_at > starts_at)
+    );
+
+CREATE INDEX IF NOT EXISTS idx_gateway_maintenance_windows_ends_at ON gateway_maintenance_windows (ends_at);
+
+-- Gateway callbacks as received, kept so failed parses can be investigated and reparsed
+CREATE TABLE IF NOT EXISTS callback_records (
+                                                id SERIAL PRIMARY KEY,
+                                                gateway_id INT NOT NULL,
+                                                headers JSONB NOT NULL DEFAULT '{}',
+                                                body BYTEA NOT NULL,
+                                                status VARCHAR(20) NOT NULL DEFAULT 'received',
+    error TEXT,
+    transaction_id INT,
+    attempts INT NOT NULL DEFAULT 0,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    processed_at TIMESTAMP
+    );
+
+CREATE INDEX IF NOT EXISTS idx_callback_records_status ON callback_records (status, created_at);
+
+CREATE TABLE IF NOT EXISTS merchants (
+                                         id SERIAL PRIMARY KEY,
+                                         name VARCHAR(255) NOT NULL UNIQUE,
+    allowed_redirect_domains TEXT[] NOT NULL DEFAULT '{}',
+    webhook_url TEXT,
+    webhook_secret TEXT, -- signs webhook deliveries; encrypted
+    livemode BOOLEAN NOT NULL DEFAULT false, -- transactions go to gateways' production environments
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+    );
+
+-- Additional URLs merchants receive webhooks at, each filtered to the event types listed; an
+-- endpoint without types receives every event. Each is pinned to the payload version it receives.
+CREATE TABLE IF NOT EXISTS webhook_endpoints (
+                                                 id SERIAL PRIMARY KEY,
+                                                 merchant_id INT NOT NULL,
+                                                 url TEXT NOT NULL,
+                                                 event_types TEXT[] NOT NULL DEFAULT '{}',
+    payload_version VARCHAR(10) NOT NULL DEFAULT 'v1',
+    description VARCHAR(255),
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (merchant_id) REFERENCES merchants(id)
+    );
+
+CREATE INDEX IF NOT EXISTS idx_webhook_endpoints_merchant_id ON webhook_endpoints (merchant_id);
+
+-- Merchant payout schedules; withdrawals of merchants with one wait for the next payout. Times are
+-- local to each withdrawal's country.
+CREATE TABLE IF NOT EXISTS payout_schedules (
+                                                merchant_id INT PRIMARY KEY,
+                                                frequency VARCHAR(10) NOT NULL, -- daily or weekly
+    weekday VARCHAR(10),
+    payout_time CHAR(5) NOT NULL, -- HH:MM
+    cutoff_time CHAR(5) NOT NULL,
+    updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (merchant_id) REFERENCES merchants(id)
+    );
+
+-- Merchant fees per currency, charged on completed transactions and reported in reconciliation
+-- files. Currencies without a row are free.
+CREATE TABLE IF NOT EXISTS merchant_fees (
+                                             merchant_id INT NOT NULL,
+                                             currency VARCHAR(3) NOT NULL,
+    percent DECIMAL(7, 4) NOT NULL DEFAULT 0,
+    fixed DECIMAL(15, 3) NOT NULL DEFAULT 0,
+    updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (merchant_id, currency),
+    FOREIGN KEY (merchant_id) REFERENCES merchants(id)
+    );
+
+-- Merchants' daily reconciliation files; their content is kept in the reconciliation store under
+-- storage_key. IDs are stable, and regenerating a file bumps its version.
+CREATE TABLE IF NOT EXISTS reconciliation_files (
+                                                    id VARCHAR(64) PRIMARY KEY,
+                                                    merchant_id INT NOT NULL,
+                                                    report_date DATE NOT NULL,
+    version INT NOT NULL DEFAULT 1,
+    row_count INT NOT NULL,
+    size_bytes INT NOT NULL,
+    checksum VARCHAR(64) NOT NULL,
+    storage_key VARCHAR(255) NOT NULL,
+    generated_at TIMESTAMP NOT NULL,
+    UNIQUE (merchant_id, report_date),
+    FOREIGN KEY (merchant_id) REFERENCES merchants(id)
+    );
+
+-- Merchant routing rules, evaluated in position order. Rules live on their merchant's shard.
+CREATE TABLE IF NOT EXISTS merchant_routing_rules (
+                                                      merchant_id INT NOT NULL,
+                                                      position INT NOT NULL,
+                                                      field VARCHAR(20) NOT NULL,
+    operator VARCHAR(10) NOT NULL,
+    value VARCHAR(255) NOT NULL,
+    action VARCHAR(20) NOT NULL,
+    gateway_id INT NOT NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (merchant_id, position),
+    FOREIGN KEY (merchant_id) REFERENCES merchants(id)
+    );
+
+-- Merchant API keys; only a SHA-256 hash of each key is stored. Keys live on shard 0 in
+-- sharded deployments, so merchant_id has no foreign key.
+CREATE TABLE IF NOT EXISTS api_keys (
+                                        id SERIAL PRIMARY KEY,
+                                        merchant_id INT NOT NULL,
+                                        name VARCHAR(255) NOT NULL,
+    key_prefix VARCHAR(32) NOT NULL UNIQUE,
+    key_hash VARCHAR(64) NOT NULL,
+    scope VARCHAR(20) NOT NULL,
+    status VARCHAR(20) NOT NULL DEFAULT 'active',
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    last_used_at TIMESTAMP,
+    expires_at TIMESTAMP,
+    revoked_at TIMESTAMP
+    );
+
+CREATE INDEX IF NOT EXISTS idx_api_keys_merchant_id ON api_keys (merchant_id);
+
+-- Merchant OAuth2 client-credentials clients; only a SHA-256 hash of each secret is stored
+CREATE TABLE IF NOT EXISTS oauth_clients (
+                                             id SERIAL PRIMARY KEY,
+                                             client_id VARCHAR(64) NOT NULL UNIQUE,
+    merchant_id INT NOT NULL,
+    name VARCHAR(255) NOT NULL,
+    secret_hash VARCHAR(64) NOT NULL,
+    scopes TEXT[] NOT NULL DEFAULT '{}',
+    status VARCHAR(20) NOT NULL DEFAULT 'active',
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+    );
+
 CREATE TABLE IF NOT EXISTS users (
                                      id SERIAL PRIMARY KEY,
                                      username VARCHAR(255) NOT NULL UNIQUE,
     email VARCHAR(255) NOT NULL UNIQUE,
+    email_hash VARCHAR(80), -- versioned blind index of the email, maintained by the application
     password VARCHAR(255) NOT NULL DEFAULT 'password',
     country_id INT,
+    merchant_id INT,
+    phone VARCHAR(32), -- as entered
+    phone_e164 VARCHAR(16), -- phone normalized for the user's country, maintained by the application
+    postal_code VARCHAR(20), -- as entered
+    postal_code_normalized VARCHAR(12),
+    full_name VARCHAR(140), -- legal name, screened against sanctions lists
+    screening_status VARCHAR(20), -- clear, pending_review or blocked; NULL for users never screened
     created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
     updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
-    FOREIGN KEY (country_id) REFERENCES countries(id)
+    FOREIGN KEY (country_id) REFERENCES countries(id),
+    FOREIGN KEY (merchant_id) REFERENCES merchants(id)
     );
 
+CREATE INDEX IF NOT EXISTS idx_users_email_hash ON users (email_hash);
+
 CREATE TABLE IF NOT EXISTS transactions (
                                             id SERIAL PRIMARY KEY,
-                                            amount DECIMAL(10, 2) NOT NULL,
+                                            amount DECIMAL(13, 3) NOT NULL,
     currency VARCHAR(3) NOT NULL DEFAULT 'USD',
     type VARCHAR(50) NOT NULL,
     status VARCHAR(50) NOT NULL,
-    reference_id VARCHAR(255),
+    beneficiary VARCHAR(255),
+    phone_number VARCHAR(32), -- mobile-money wallet number as entered
+    phone_e164 VARCHAR(16),
+    return_url TEXT,
+    cancel_url TEXT,
+    redirect_url TEXT, -- hosted payment page returned by the gateway
+    reference_id VARCHAR(255), -- our reference, generated when the transaction is created
+    reference_hash VARCHAR(80), -- versioned blind index of reference_id, maintained by the application
+    gateway_reference VARCHAR(255), -- the gateway's own reference
+    gateway_reference_hash VARCHAR(80), -- versioned blind index of gateway_reference
+    gateway_idempotency_key VARCHAR(64),
     error_message TEXT,
+    decline_code VARCHAR(50),
+    card_bin VARCHAR(8), -- first 6-8 digits of the card, for decline analytics
+    sca_exemption VARCHAR(20), -- SCA exemption requested for a card deposit
+    sca_exemption_outcome VARCHAR(20),
+    livemode BOOLEAN NOT NULL DEFAULT false, -- sent to the gateway's production environment
+    retry_of_id INT,
+    country_source VARCHAR(20),
+    risk_flags TEXT[] NOT NULL DEFAULT '{}',
+    scheduled_for TIMESTAMP, -- payout a scheduled withdrawal waits for
+    expected_settlement_date DATE, -- banking day a withdrawal is expected to reach the beneficiary
+    compliance_fields TEXT, -- encrypted JSON of the country's compliance field values; never archived
+    crypto_amount VARCHAR(40), -- payment of a crypto deposit, as the decimal string the gateway reports
+    crypto_currency VARCHAR(10),
+    crypto_network VARCHAR(30),
+    crypto_transaction_hash VARCHAR(100),
+    crypto_confirmations INT,
+    crypto_required_confirmations INT,
+    crypto_status VARCHAR(20), -- detected, paid, underpaid, overpaid or delayed
+    bank_account_id INT, -- linked bank account a withdrawal is paid out to
+    mandate_id INT, -- SEPA mandate a deposit is debited under or a withdrawal is paid out to
+    authorized_amount DECIMAL(13, 3), -- amount held for a deposit authorized to be captured later
+    authorization_expires_at TIMESTAMP, -- when the gateway releases the amount held unless it is captured
+    incremental_authorization BOOLEAN NOT NULL DEFAULT FALSE, -- whether the amount held can be raised while authorized
+    captured_amount DECIMAL(13, 3), -- amount captured so far of an authorization captured in parts
+    capture_of_id INT, -- authorization a partial capture was taken from
     created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
     updated_at TIMESTAMP,
+    deleted_at TIMESTAMP,
     gateway_id INT NOT NULL,
     country_id INT NOT NULL,
     user_id INT NOT NULL,
@@ -56,16 +588,169 @@ CREATE TABLE IF NOT EXISTS transactions (
     FOREIGN KEY (user_id) REFERENCES users(id)
     );
 
+CREATE INDEX IF NOT EXISTS idx_transactions_created_at ON transactions (created_at);
+CREATE INDEX IF NOT EXISTS idx_transactions_reference_hash ON transactions (reference_hash);
+CREATE INDEX IF NOT EXISTS idx_transactions_gateway_reference_hash ON transactions (gateway_reference_hash);
+CREATE INDEX IF NOT EXISTS idx_transactions_scheduled_for ON transactions (scheduled_for) WHERE status = 'scheduled';
+CREATE INDEX IF NOT EXISTS idx_transactions_unpaid ON transactions (gateway_id, created_at) WHERE status IN ('pending', 'processing');
+CREATE INDEX IF NOT EXISTS idx_transactions_in_flight_changed ON transactions ((COALESCE(updated_at, created_at))) WHERE status IN ('pending', 'processing');
+CREATE INDEX IF NOT EXISTS idx_transactions_authorization_expires_at ON transactions (authorization_expires_at) WHERE status = 'authorized';
+CREATE INDEX IF NOT EXISTS idx_transactions_capture_of_id ON transactions (capture_of_id) WHERE capture_of_id IS NOT NULL;
+
+-- Aged and soft-deleted transactions are moved here by the retention job to keep the hot table small.
+-- Routing decisions are embedded as JSON; PII columns are NULL when pii_purged is set.
+CREATE TABLE IF NOT EXISTS transactions_archive (
+                                                    id INT PRIMARY KEY,
+                                                    amount DECIMAL(13, 3) NOT NULL,
+    currency VARCHAR(3) NOT NULL,
+    type VARCHAR(50) NOT NULL,
+    status VARCHAR(50) NOT NULL,
+    beneficiary VARCHAR(255),
+    phone_number VARCHAR(32), -- mobile-money wallet number as entered
+    phone_e164 VARCHAR(16),
+    return_url TEXT,
+    cancel_url TEXT,
+    redirect_url TEXT,
+    reference_id VARCHAR(255),
+    gateway_reference VARCHAR(255),
+    gateway_idempotency_key VARCHAR(64),
+    error_message TEXT,
+    decline_code VARCHAR(50),
+    retry_of_id INT,
+    country_source VARCHAR(20),
+    risk_flags TEXT[] NOT NULL DEFAULT '{}',
+    livemode BOOLEAN NOT NULL DEFAULT false, -- still counts towards the user's live or sandbox wallet balance
+    created_at TIMESTAMP,
+    updated_at TIMESTAMP,
+    deleted_at TIMESTAMP,
+    gateway_id INT NOT NULL,
+    country_id INT NOT NULL,
+    user_id INT NOT NULL,
+    routing_decisions JSONB NOT NULL DEFAULT '[]',
+    pii_purged BOOLEAN NOT NULL DEFAULT FALSE,
+    archived_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+    );
+
+CREATE INDEX IF NOT EXISTS idx_transactions_archive_archived_at ON transactions_archive (archived_at);
+
+-- Wallet ledger: transfers between users of the same merchant, which never reach a gateway.
+-- Declined transfers are kept with their decline code and move nothing.
+CREATE TABLE IF NOT EXISTS transfers (
+                                         id SERIAL PRIMARY KEY,
+                                         from_user_id INT NOT NULL,
+                                         to_user_id INT NOT NULL,
+                                         merchant_id INT NOT NULL,
+                                         amount DECIMAL(13, 3) NOT NULL,
+    currency VARCHAR(3) NOT NULL,
+    description VARCHAR(140),
+    status VARCHAR(20) NOT NULL, -- completed or failed
+    decline_code VARCHAR(50),
+    risk_flags TEXT[] NOT NULL DEFAULT '{}',
+    livemode BOOLEAN NOT NULL DEFAULT false,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (from_user_id) REFERENCES users(id),
+    FOREIGN KEY (to_user_id) REFERENCES users(id),
+    FOREIGN KEY (merchant_id) REFERENCES merchants(id)
+    );
+
+CREATE INDEX IF NOT EXISTS idx_transfers_from_user ON transfers (from_user_id, currency, created_at);
+CREATE INDEX IF NOT EXISTS idx_transfers_to_user ON transfers (to_user_id, currency);
+
+CREATE TABLE IF NOT EXISTS routing_decisions (
+                                                 id SERIAL PRIMARY KEY,
+                                                 transaction_id INT NOT NULL,
+                                                 country_id INT NOT NULL,
+    type VARCHAR(50) NOT NULL,
+    selected_gateway_id INT NOT NULL,
+    preferred_gateway_id INT,
+    excluded_gateway_ids INT[] NOT NULL DEFAULT '{}',
+    override BOOLEAN NOT NULL DEFAULT FALSE,
+    reason TEXT,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (transaction_id) REFERENCES transactions(id)
+    );
+
+CREATE INDEX IF NOT EXISTS idx_routing_decisions_transaction_id ON routing_decisions (transaction_id);
+
+-- Audit trail of each transaction's creation and status changes. Entries outlive archival of
+-- their transaction, so they carry no foreign key.
+CREATE TABLE IF NOT EXISTS transaction_events (
+                                                  id SERIAL PRIMARY KEY,
+                                                  transaction_id INT NOT NULL,
+                                                  type VARCHAR(100) NOT NULL,
+    status VARCHAR(50) NOT NULL,
+    message TEXT,
+    decline_code VARCHAR(50),
+    gateway_reference VARCHAR(255),
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+    );
+
+CREATE INDEX IF NOT EXISTS idx_transaction_events_transaction_id ON transaction_events (transaction_id, id);
+
+-- External side effects recorded alongside state changes and delivered by dispatchers.
+-- The unique dedup token per destination keeps the same event from being queued twice.
+CREATE TABLE IF NOT EXISTS outbox_messages (
+                                               id SERIAL PRIMARY KEY,
+                                               destination VARCHAR(50) NOT NULL,
+    event_type VARCHAR(100) NOT NULL,
+    transaction_id INT NOT NULL,
+    merchant_id INT,
+    endpoint_id INT NOT NULL DEFAULT 0, -- merchant webhook endpoint; 0 for the merchant's webhook_url
+    dedup_token VARCHAR(100) NOT NULL,
+    content_type VARCHAR(100) NOT NULL,
+    payload BYTEA NOT NULL,
+    status VARCHAR(20) NOT NULL DEFAULT 'pending',
+    attempts INT NOT NULL DEFAULT 0,
+    last_error TEXT,
+    next_attempt_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    delivered_at TIMESTAMP,
+    UNIQUE (destination, endpoint_id, dedup_token)
+    );
+
+CREATE INDEX IF NOT EXISTS idx_outbox_messages_pending ON outbox_messages (destination, next_attempt_at) WHERE status = 'pending';
+
+CREATE TABLE IF NOT EXISTS batches (
+                                       id SERIAL PRIMARY KEY,
+                                       type VARCHAR(50) NOT NULL,
+    status VARCHAR(50) NOT NULL,
+    total INT NOT NULL DEFAULT 0,
+    succeeded INT NOT NULL DEFAULT 0,
+    failed INT NOT NULL DEFAULT 0,
+    items JSONB NOT NULL DEFAULT '[]',
+    operation_id VARCHAR(64),
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP
+    );
+
+CREATE TABLE IF NOT EXISTS operations (
+                                          id VARCHAR(64) PRIMARY KEY,
+                                          type VARCHAR(50) NOT NULL,
+    status VARCHAR(50) NOT NULL,
+    progress INT NOT NULL DEFAULT 0,
+    processed INT NOT NULL DEFAULT 0,
+    total INT NOT NULL DEFAULT 0,
+    result JSONB,
+    error_message TEXT,
+    decline_code VARCHAR(50),
+    retry_of_id INT,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP,
+    expires_at TIMESTAMP NOT NULL
+    );
+
+CREATE INDEX IF NOT EXISTS idx_operations_expires_at ON operations (expires_at);
+
 -- Insert sample data only if tables are empty
 DO $$
 BEGIN
     -- Insert countries
     IF NOT EXISTS (SELECT 1 FROM countries LIMIT 1) THEN
-        INSERT INTO countries (name, code, currency) VALUES
-        ('United States', 'US', 'USD'),
-        ('United Kingdom', 'GB', 'GBP'),
-        ('Germany', 'DE', 'EUR'),
-        ('Japan', 'JP', 'JPY');
+        INSERT INTO countries (name, code, currency, timezone) VALUES
+        ('United States', 'US', 'USD', 'America/New_York'),
+        ('United Kingdom', 'GB', 'GBP', 'Europe/London'),
+        ('Germany', 'DE', 'EUR', 'Europe/Berlin'),
+        ('Japan', 'JP', 'JPY', 'Asia/Tokyo');
 END IF;
 
     -- Insert gateways
@@ -102,12 +787,96 @@ INSERT INTO gateway_countries (gateway_id, country_id, priority) VALUES
                                                                      (2, 4, 2); -- Stripe secondary for Japan
 END IF;
 
+    -- Insert bank holidays falling on weekdays
+    IF NOT EXISTS (SELECT 1 FROM bank_holidays LIMIT 1) THEN
+        INSERT INTO bank_holidays (country_id, holiday_date, name) VALUES
+        (1, '2026-01-01', 'New Year''s Day'),
+        (1, '2026-01-19', 'Martin Luther King Jr. Day'),
+        (1, '2026-02-16', 'Washington''s Birthday'),
+        (1, '2026-05-25', 'Memorial Day'),
+        (1, '2026-06-19', 'Juneteenth'),
+        (1, '2026-07-03', 'Independence Day (observed)'),
+        (1, '2026-09-07', 'Labor Day'),
+        (1, '2026-10-12', 'Columbus Day'),
+        (1, '2026-11-11', 'Veterans Day'),
+        (1, '2026-11-26', 'Thanksgiving Day'),
+        (1, '2026-12-25', 'Christmas Day'),
+        (2, '2026-01-01', 'New Year''s Day'),
+        (2, '2026-04-03', 'Good Friday'),
+        (2, '2026-04-06', 'Easter Monday'),
+        (2, '2026-05-04', 'Early May bank holiday'),
+        (2, '2026-05-25', 'Spring bank holiday'),
+        (2, '2026-08-31', 'Summer bank holiday'),
+        (2, '2026-12-25', 'Christmas Day'),
+        (2, '2026-12-28', 'Boxing Day (substitute day)'),
+        (3, '2026-01-01', 'Neujahr'),
+        (3, '2026-04-03', 'Karfreitag'),
+        (3, '2026-04-06', 'Ostermontag'),
+        (3, '2026-05-01', 'Tag der Arbeit'),
+        (3, '2026-05-14', 'Christi Himmelfahrt'),
+        (3, '2026-05-25', 'Pfingstmontag'),
+        (3, '2026-12-24', 'Heiligabend'),
+        (3, '2026-12-25', 'Erster Weihnachtstag'),
+        (3, '2026-12-31', 'Silvester'),
+        (4, '2026-01-01', 'New Year''s Day'),
+        (4, '2026-01-02', 'Bank holiday'),
+        (4, '2026-01-12', 'Coming of Age Day'),
+        (4, '2026-02-11', 'National Foundation Day'),
+        (4, '2026-02-23', 'Emperor''s Birthday'),
+        (4, '2026-03-20', 'Vernal Equinox Day'),
+        (4, '2026-04-29', 'Showa Day'),
+        (4, '2026-05-04', 'Greenery Day'),
+        (4, '2026-05-05', 'Children''s Day'),
+        (4, '2026-05-06', 'Constitution Memorial Day (observed)'),
+        (4, '2026-07-20', 'Marine Day'),
+        (4, '2026-08-11', 'Mountain Day'),
+        (4, '2026-09-21', 'Respect for the Aged Day'),
+        (4, '2026-09-22', 'Citizens'' Holiday'),
+        (4, '2026-09-23', 'Autumnal Equinox Day'),
+        (4, '2026-10-12', 'Sports Day'),
+        (4, '2026-11-03', 'Culture Day'),
+        (4, '2026-11-23', 'Labour Thanksgiving Day'),
+        (4, '2026-12-31', 'Bank holiday');
+END IF;
+
+    -- Insert decline recovery hints
+    IF NOT EXISTS (SELECT 1 FROM decline_recovery_hints LIMIT 1) THEN
+        INSERT INTO decline_recovery_hints (decline_code, recovery_hint) VALUES
+        ('insufficient_funds', 'use_other_method'),
+        ('do_not_honor', 'contact_bank'),
+        ('expired_card', 'use_other_method'),
+        ('fraud_suspected', 'do_not_retry'),
+        ('invalid_account', 'use_other_method'),
+        ('limit_exceeded', 'contact_bank'),
+        ('issuer_unavailable', 'try_again'),
+        ('timeout', 'try_again'),
+        ('processing_error', 'try_again'),
+        ('consent_rejected', 'use_other_method'),
+        ('consent_expired', 'try_again'),
+        ('mandate_invalid', 'use_other_method'),
+        ('debit_refunded', 'do_not_retry'),
+        ('unknown', 'use_other_method');
+END IF;
+
+    -- Insert AML thresholds: the US travel rule and the EU funds transfer regulation
+    IF NOT EXISTS (SELECT 1 FROM aml_thresholds LIMIT 1) THEN
+        INSERT INTO aml_thresholds (country_id, currency, amount) VALUES
+        (1, 'USD', 3000.00),
+        (3, 'EUR', 1000.00);
+END IF;
+
+    -- Insert merchants
+    IF NOT EXISTS (SELECT 1 FROM merchants LIMIT 1) THEN
+        INSERT INTO merchants (name, allowed_redirect_domains) VALUES
+        ('Demo Merchant', ARRAY['example.com', 'localhost']);
+END IF;
+
     -- Insert sample users
     IF NOT EXISTS (SELECT 1 FROM users LIMIT 1) THEN
-        INSERT INTO users (username, email, country_id) VALUES
-        ('user1', 'user1@example.com', 1), -- US user
-        ('user2', 'user2@example.com', 2), -- UK user
-        ('user3', 'user3@example.com', 3), -- German user
-        ('user4', 'user4@example.com', 4); -- Japanese user
+        INSERT INTO users (username, email, country_id, merchant_id) VALUES
+        ('user1', 'user1@example.com', 1, 1), -- US user
+        ('user2', 'user2@example.com', 2, 1), -- UK user
+        ('user3', 'user3@example.com', 3, 1), -- German user
+        ('user4', 'user4@example.com', 4, 1); -- Japanese user
 END IF;
 END $$;
\ No newline at end of file
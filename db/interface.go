@@ -1,23 +1,262 @@
 package db
 
 import (
+	"errors"
 	"payment-gateway/internal/models"
+	"time"
 )
 
+// ErrInsufficientBalance is returned when a user's wallet balance does not cover an amount taken
+// out of it
+var ErrInsufficientBalance = errors.New("wallet balance does not cover the amount")
+
+// TransferCheck decides a completed transfer given the completed transfers its sender already
+// sent, returning it as it should be recorded: declined when it breaks their limits, and flagged
+// when it looks risky
+type TransferCheck func(transfer models.Transfer, stats models.TransferStats) models.Transfer
+
 // DBInterface defines the database operations needed by the services
 type DBInterface interface {
 	// User operations
 	GetUserByID(userID int) (*models.User, error)
+	CreateUser(user models.User) (int, error)
+	UpdateUserContact(userID int, contact models.UserContact) error
+	UpdateUserScreeningStatus(userID int, status string) error
+
+	// Merchant operations
+	GetMerchantByID(merchantID int) (*models.Merchant, error)
+	SetMerchantWebhookSecret(merchantID int, secret string) error
+	SetMerchantLivemode(merchantID int, livemode bool) error
+	GetRoutingRules(merchantID int) ([]models.RoutingRule, error)
+	ReplaceRoutingRules(merchantID int, rules []models.RoutingRule) error
+	GetPayoutSchedule(merchantID int) (*models.PayoutSchedule, error)
+	SavePayoutSchedule(schedule models.PayoutSchedule) error
+	DeletePayoutSchedule(merchantID int) error
+	GetMerchantIDs() ([]int, error)
+	GetMerchantFees(merchantID int) ([]models.MerchantFee, error)
+	ReplaceMerchantFees(merchantID int, fees []models.MerchantFee) error
+
+	// Country operations
+	GetCountryByID(countryID int) (*models.Country, error)
+	GetCountryByCode(code string) (*models.Country, error)
+	GetBankHolidays(countryID int, from, to string) ([]models.BankHoliday, error)
+	CreateBankHoliday(holiday models.BankHoliday) error
+	DeleteBankHoliday(countryID int, date string) error
+
+	// Compliance field operations
+	GetComplianceFields(countryID int) ([]models.ComplianceField, error)
+	ReplaceComplianceFields(countryID int, fields []models.ComplianceField) error
+	GetAMLThresholds(countryID int) ([]models.AMLThreshold, error)
+	GetAMLThreshold(countryID int, currency string) (*models.AMLThreshold, error)
+	SetAMLThreshold(threshold models.AMLThreshold) error
+	DeleteAMLThreshold(countryID int, currency string) error
+
+	// Decline recovery hint operations
+	GetDeclineRecoveryHints() ([]models.DeclineRecoveryHint, error)
+	SaveDeclineRecoveryHint(hint models.DeclineRecoveryHint) error
 
 	// Gateway operations
 	GetSupportedGatewaysByCountry(countryID int) ([]models.Gateway, error)
 	GetGatewaysByPriority(countryID int) ([]models.GatewayPriority, error)
 
+	// Webhook secret operations
+	CreateWebhookSecret(secret models.WebhookSecret) (int, error)
+	GetWebhookSecretsByGateway(gatewayID int) ([]models.WebhookSecret, error)
+	RetireWebhookSecret(gatewayID, secretID int) error
+	CreateClientCertificate(certificate models.ClientCertificate) (int, error)
+	GetClientCertificates(gatewayID int) ([]models.ClientCertificate, error)
+	RetireClientCertificate(gatewayID, certificateID int) error
+	CreateSigningKey(key models.SigningKey) (int, error)
+	GetSigningKeys(gatewayID int) ([]models.SigningKey, error)
+	RetireSigningKey(gatewayID, keyID int) error
+
+	// Wallet merchant certificate operations
+	CreateMerchantCertificate(certificate models.MerchantCertificate) (int, error)
+	GetMerchantCertificates(wallet string) ([]models.MerchantCertificate, error)
+	RetireMerchantCertificate(certificateID int) error
+
+	// Gateway maintenance window operations
+	CreateMaintenanceWindow(window models.MaintenanceWindow) (int, error)
+	GetMaintenanceWindows(gatewayID int, endingAfter time.Time) ([]models.MaintenanceWindow, error)
+	CancelMaintenanceWindow(gatewayID, windowID int) error
+
+	// Routing audit trail
+	CreateRoutingDecision(decision models.RoutingDecision) (int, error)
+	GetRoutingDecisionsByTransaction(txID int) ([]models.RoutingDecision, error)
+
+	// Transaction audit trail
+	CreateTransactionEvent(event models.TransactionEvent) (int, error)
+	GetTransactionEvents(txID int) ([]models.TransactionEvent, error)
+
 	// Transaction operations
 	CreateTransaction(transaction models.Transaction) (int, error)
+	CreateWithdrawal(transaction models.Transaction) (int, error)
 	GetTransactionByID(transactionID int) (*models.Transaction, error)
-	UpdateTransactionStatus(txID int, status, errorMsg string) error
-	UpdateTransactionReference(txID int, referenceID string) error
+	GetTransactionIDByGatewayReference(gatewayID int, gatewayReference string) (int, error)
+	UpdateTransactionStatus(txID int, from, to, errorMsg string) error
+	UpdateTransactionGatewayReference(txID int, gatewayReference, redirectURL string) error
+	UpdateTransactionIdempotencyKey(txID int, key string) error
+	UpdateTransactionDeclineCode(txID int, declineCode string) error
+	UpdateTransactionGateway(txID, gatewayID int) error
+	UpdateTransactionSCAExemptionOutcome(txID int, outcome string) error
+	UpdateTransactionCryptoPayment(txID int, payment models.CryptoPayment) error
+	ClaimDueScheduledTransactions(before time.Time, limit int) ([]int, error)
+	ExpireUnpaidDeposits(gatewayID int, createdBefore time.Time, errorMsg, declineCode string, limit int) ([]int, error)
+	GetInFlightTransactionIDs(gatewayID int, createdAfter, createdBefore time.Time, afterID, limit int) ([]int, error)
+	GetStaleTransactionIDs(changedBefore time.Time, limit int) ([]int, error)
+	ExpireStaleTransaction(txID int, changedBefore time.Time, errorMsg, declineCode string) error
+	RescheduleTransaction(txID int, scheduledFor time.Time, expectedSettlementDate string) error
+	AuthorizeTransaction(txID int, expiresAt time.Time) error
+	ClaimAuthorizedTransaction(txID int) error
+	CaptureTransaction(txID int, amount, capturedAmount float64, status string) error
+	RecordPartialCapture(txID int, capturedAmount float64) error
+	IncrementAuthorization(txID int, amount float64) error
+	ExpireAuthorizations(before time.Time, errorMsg string, limit int) ([]int, error)
+	SearchTransactions(search models.TransactionSearch) ([]models.TransactionSearchResult, error)
+	GetDeclineCounts(merchantID int, tag string, from, to time.Time) ([]models.DeclineCount, error)
+	GetMerchantTransactions(merchantID int, from, to time.Time) ([]models.Transaction, error)
+
+	// Reconciliation file operations
+	SaveReconciliationFile(file models.ReconciliationFile) error
+	GetReconciliationFile(merchantID int, fileID string) (*models.ReconciliationFile, error)
+	ListReconciliationFiles(merchantID int, from, to string) ([]models.ReconciliationFile, error)
+
+	// Retention operations
+	SoftDeleteTransaction(txID int) error
+	ArchiveTransactions(cutoff time.Time, purgePII bool, limit int) (int64, error)
+	GetArchivalStats() (*models.ArchivalStats, error)
+
+	// API key operations
+	CreateAPIKey(key models.APIKey) (int, error)
+	GetAPIKeyByPrefix(prefix string) (*models.APIKey, error)
+	GetAPIKeysByMerchant(merchantID int) ([]models.APIKey, error)
+	RevokeAPIKey(merchantID, keyID int) error
+	ExpireAPIKey(merchantID, keyID int, expiresAt time.Time) error
+	TouchAPIKey(keyID int, usedAt time.Time) error
+
+	// OAuth2 client operations
+	CreateOAuthClient(client models.OAuthClient) (int, error)
+	GetOAuthClientByClientID(clientID string) (*models.OAuthClient, error)
+
+	// Callback record operations
+	CreateCallbackRecord(record models.CallbackRecord) (int, error)
+	GetCallbackRecordByID(recordID int) (*models.CallbackRecord, error)
+	UpdateCallbackRecord(recordID int, status, errorMsg string, transactionID int) error
+
+	// Dispute operations
+	CreateDispute(dispute models.Dispute) (int, error)
+	GetDisputeByID(disputeID int) (*models.Dispute, error)
+	GetDisputeByTransaction(txID int) (*models.Dispute, error)
+	ListDisputes(status string, limit int) ([]models.Dispute, error)
+	UpdateDisputeStatus(disputeID int, status, resolution string, updatedAt time.Time) error
+
+	// Transaction hold operations
+	CreateTransactionHold(hold models.TransactionHold) (int, error)
+	GetTransactionHold(txID int) (*models.TransactionHold, error)
+	ListTransactionHolds(status string, limit int) ([]models.TransactionHold, error)
+	ReleaseTransactionHold(txID int, note string, releasedAt time.Time) error
+	ExtendTransactionHold(txID int, releaseAt, updatedAt time.Time) error
+	ExpireTransactionHolds(before time.Time, limit int) ([]int, error)
+	GetHeldAmount(userID int, currency string, livemode bool) (float64, error)
+
+	// Idempotency key operations
+	CreateIdempotencyKey(key models.IdempotencyKey) error
+	GetIdempotencyKey(caller, route, key string) (*models.IdempotencyKey, error)
+	CompleteIdempotencyKey(caller, route, key string, statusCode int, contentType string, body []byte, completedAt time.Time) error
+	DeleteIdempotencyKey(caller, route, key string, createdAt time.Time) error
+	PurgeIdempotencyKeys(before time.Time, limit int) (int, error)
+
+	// Webhook endpoint operations
+	CreateWebhookEndpoint(endpoint models.WebhookEndpoint) (int, error)
+	GetWebhookEndpoints(merchantID int) ([]models.WebhookEndpoint, error)
+	GetWebhookEndpoint(merchantID, endpointID int) (*models.WebhookEndpoint, error)
+	UpdateWebhookEndpoint(endpoint models.WebhookEndpoint) error
+	DeleteWebhookEndpoint(merchantID, endpointID int) error
+
+	// Refund operations
+	CreateRefund(refund models.Refund) (int, error)
+	UpdateRefund(refund models.Refund) error
+	GetRefund(refundID int) (*models.Refund, error)
+	GetTransactionRefunds(txID int) ([]models.Refund, error)
+
+	// Transaction tags
+	AddTransactionTags(txID int, tags []string, createdAt time.Time) error
+	RemoveTransactionTag(txID int, tag string) error
+	GetTransactionTags(txID int) ([]string, error)
+	ListTransactions(filter models.TransactionFilter) ([]models.TransactionSummary, error)
+
+	// AML case operations
+	CreateAMLCase(amlCase models.AMLCase) (int, error)
+	GetAMLCaseByID(caseID int) (*models.AMLCase, error)
+	ListAMLCases(status string, limit int) ([]models.AMLCase, error)
+	UpdateAMLCaseStatus(caseID int, status, note string, updatedAt time.Time) error
+
+	// Sanctions screening operations
+	CreateScreeningCase(screeningCase models.ScreeningCase) (int, error)
+	GetScreeningCaseByID(caseID int) (*models.ScreeningCase, error)
+	ListScreeningCases(status string, limit int) ([]models.ScreeningCase, error)
+	ListUserScreeningCases(userID int) ([]models.ScreeningCase, error)
+	DecideScreeningCase(caseID int, status, reviewer, note string, updatedAt time.Time) error
+	CreateScreeningEvent(event models.ScreeningEvent) error
+	ListScreeningEvents(userID int) ([]models.ScreeningEvent, error)
+
+	// Payment consent operations
+	CreatePaymentConsent(consent models.PaymentConsent) (int, error)
+	GetPaymentConsentByState(stateHash string) (*models.PaymentConsent, error)
+	GetPaymentConsentByTransaction(txID int) (*models.PaymentConsent, error)
+	ListExpiredPaymentConsents(now time.Time, limit int) ([]models.PaymentConsent, error)
+	UpdatePaymentConsentStatus(consentID int, status string, updatedAt time.Time) error
+
+	// Bank account linking operations
+	CreateBankAccountConsent(consent models.BankAccountConsent) (int, error)
+	GetBankAccountConsentByState(stateHash string) (*models.BankAccountConsent, error)
+	UpdateBankAccountConsentStatus(consentID int, status string, updatedAt time.Time) error
+	StoreBankAccountConsentTokens(consentID int, accessToken, refreshToken string, expiresAt time.Time) error
+	SaveBankAccount(account models.BankAccount) (int, error)
+	GetBankAccountByID(accountID int) (*models.BankAccount, error)
+	ListBankAccounts(userID int) ([]models.BankAccount, error)
+	RevokeBankAccount(accountID int, updatedAt time.Time) error
+
+	// SEPA mandate operations
+	CreateMandate(mandate models.Mandate) (int, error)
+	GetMandateByID(mandateID int) (*models.Mandate, error)
+	ListMandates(userID int) ([]models.Mandate, error)
+	UpdateMandateSequenceType(mandateID int, sequenceType string) error
+	RevokeMandate(mandateID int, reason string, updatedAt time.Time) error
+
+	// Auto-reload rule operations
+	CreateAutoReloadRule(rule models.AutoReloadRule) (int, error)
+	GetAutoReloadRuleByID(ruleID int) (*models.AutoReloadRule, error)
+	ListAutoReloadRules(userID int) ([]models.AutoReloadRule, error)
+	ClaimAutoReloadRules(now, claimedBefore time.Time, limit int) ([]models.AutoReloadRule, error)
+	UpdateAutoReloadRule(rule models.AutoReloadRule) error
+
+	// Wallet ledger operations
+	GetWalletBalance(userID int, currency string, livemode bool) (float64, error)
+	CreateTransfer(transfer models.Transfer, since time.Time, check TransferCheck) (*models.Transfer, error)
+	GetTransfer(transferID, userID int) (*models.Transfer, error)
+
+	// Outbox operations
+	CreateOutboxMessages(messages []models.OutboxMessage) error
+	GetPendingOutboxMessages(destination string, now time.Time, limit int) ([]models.OutboxMessage, error)
+	MarkOutboxMessageDelivered(messageID int) error
+	MarkOutboxMessageRetry(messageID int, errorMsg string, nextAttemptAt time.Time, failed bool) error
+	GetOutboxMessage(messageID int) (*models.OutboxMessage, error)
+	ListOutboxMessages(destination, status string, limit int) ([]models.OutboxMessage, error)
+	RequeueOutboxMessage(messageID int, nextAttemptAt time.Time) error
+	DiscardOutboxMessage(messageID int) error
+
+	// Batch operations
+	CreateBatch(batch models.Batch) (int, error)
+	GetBatchByID(batchID int) (*models.Batch, error)
+	UpdateBatch(batch models.Batch) error
+
+	// Long-running operation tracking
+	CreateOperation(op models.Operation) error
+	GetOperationByID(operationID string) (*models.Operation, error)
+	GetLatestOperationByType(opType string) (*models.Operation, error)
+	UpdateOperation(op models.Operation) error
+	DeleteExpiredOperations(before time.Time) (int64, error)
 
 	// Health check
 	Ping() error
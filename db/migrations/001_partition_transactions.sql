@@ -0,0 +1,136 @@
+-- Converts transactions and routing_decisions into tables partitioned by month on created_at.
+--
+-- Intended for high-volume deployments; run once against a database created from init.sql:
+--   psql "$DATABASE_URL" -f db/migrations/001_partition_transactions.sql
+--
+-- PostgreSQL requires the partition key in every unique constraint of a partitioned table, so the
+-- primary keys become (id, created_at) and the routing_decisions -> transactions foreign key is
+-- replaced by an index. Monthly partitions ahead of the current month are created by the
+-- service's partition maintenance job; a default partition catches anything outside them.
+
+BEGIN;
+
+ALTER TABLE routing_decisions DROP CONSTRAINT IF EXISTS routing_decisions_transaction_id_fkey;
+
+-- transactions
+
+ALTER TABLE transactions RENAME TO transactions_unpartitioned;
+DROP INDEX IF EXISTS idx_transactions_created_at;
+
+CREATE TABLE transactions (
+    id INT NOT NULL DEFAULT nextval('transactions_id_seq'),
+    amount DECIMAL(13, 3) NOT NULL,
+    currency VARCHAR(3) NOT NULL DEFAULT 'USD',
+    type VARCHAR(50) NOT NULL,
+    status VARCHAR(50) NOT NULL,
+    beneficiary VARCHAR(255),
+    phone_number VARCHAR(32),
+    phone_e164 VARCHAR(16),
+    return_url TEXT,
+    cancel_url TEXT,
+    redirect_url TEXT,
+    reference_id VARCHAR(255),
+    reference_hash VARCHAR(80),
+    gateway_reference VARCHAR(255),
+    gateway_reference_hash VARCHAR(80),
+    gateway_idempotency_key VARCHAR(64),
+    error_message TEXT,
+    decline_code VARCHAR(50),
+    card_bin VARCHAR(8),
+    sca_exemption VARCHAR(20),
+    sca_exemption_outcome VARCHAR(20),
+    livemode BOOLEAN NOT NULL DEFAULT false,
+    retry_of_id INT,
+    country_source VARCHAR(20),
+    risk_flags TEXT[] NOT NULL DEFAULT '{}',
+    scheduled_for TIMESTAMP,
+    expected_settlement_date DATE,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP,
+    deleted_at TIMESTAMP,
+    gateway_id INT NOT NULL,
+    country_id INT NOT NULL,
+    user_id INT NOT NULL,
+    PRIMARY KEY (id, created_at),
+    FOREIGN KEY (gateway_id) REFERENCES gateways(id),
+    FOREIGN KEY (country_id) REFERENCES countries(id),
+    FOREIGN KEY (user_id) REFERENCES users(id)
+) PARTITION BY RANGE (created_at);
+
+CREATE TABLE transactions_default PARTITION OF transactions DEFAULT;
+
+-- routing_decisions
+
+ALTER TABLE routing_decisions RENAME TO routing_decisions_unpartitioned;
+DROP INDEX IF EXISTS idx_routing_decisions_transaction_id;
+
+CREATE TABLE routing_decisions (
+    id INT NOT NULL DEFAULT nextval('routing_decisions_id_seq'),
+    transaction_id INT NOT NULL,
+    country_id INT NOT NULL,
+    type VARCHAR(50) NOT NULL,
+    selected_gateway_id INT NOT NULL,
+    preferred_gateway_id INT,
+    excluded_gateway_ids INT[] NOT NULL DEFAULT '{}',
+    override BOOLEAN NOT NULL DEFAULT FALSE,
+    reason TEXT,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (id, created_at)
+) PARTITION BY RANGE (created_at);
+
+CREATE TABLE routing_decisions_default PARTITION OF routing_decisions DEFAULT;
+
+-- Create monthly partitions covering existing rows so they don't all land in the default partition
+
+DO $$
+DECLARE
+    tbl TEXT;
+    month DATE;
+    last_month DATE;
+BEGIN
+    FOREACH tbl IN ARRAY ARRAY['transactions', 'routing_decisions'] LOOP
+        EXECUTE format('SELECT date_trunc(''month'', COALESCE(MIN(created_at), CURRENT_TIMESTAMP))::date FROM %I', tbl || '_unpartitioned')
+            INTO month;
+        last_month := date_trunc('month', CURRENT_TIMESTAMP)::date;
+
+        WHILE month <= last_month LOOP
+            EXECUTE format(
+                'CREATE TABLE IF NOT EXISTS %I PARTITION OF %I FOR VALUES FROM (%L) TO (%L)',
+                tbl || '_p' || to_char(month, 'YYYYMM'), tbl, month, (month + INTERVAL '1 month')::date
+            );
+            month := (month + INTERVAL '1 month')::date;
+        END LOOP;
+    END LOOP;
+END $$;
+
+INSERT INTO transactions (
+    id, amount, currency, type, status, beneficiary, phone_number, phone_e164, return_url, cancel_url, redirect_url, reference_id, reference_hash, gateway_reference, gateway_reference_hash,
+    gateway_idempotency_key, error_message, decline_code, card_bin, sca_exemption, sca_exemption_outcome, livemode, retry_of_id, country_source, risk_flags, scheduled_for, expected_settlement_date, created_at, updated_at, deleted_at,
+    gateway_id, country_id, user_id
+)
+SELECT id, amount, currency, type, status, beneficiary, phone_number, phone_e164, return_url, cancel_url, redirect_url, reference_id, reference_hash, gateway_reference, gateway_reference_hash,
+       gateway_idempotency_key, error_message, decline_code, card_bin, sca_exemption, sca_exemption_outcome, livemode, retry_of_id, country_source, risk_flags, scheduled_for, expected_settlement_date, COALESCE(created_at, CURRENT_TIMESTAMP), updated_at, deleted_at,
+       gateway_id, country_id, user_id
+FROM transactions_unpartitioned;
+
+INSERT INTO routing_decisions (
+    id, transaction_id, country_id, type, selected_gateway_id, preferred_gateway_id,
+    excluded_gateway_ids, override, reason, created_at
+)
+SELECT id, transaction_id, country_id, type, selected_gateway_id, preferred_gateway_id,
+       excluded_gateway_ids, override, reason, COALESCE(created_at, CURRENT_TIMESTAMP)
+FROM routing_decisions_unpartitioned;
+
+-- Keep the ID sequences when the old tables are dropped
+ALTER SEQUENCE transactions_id_seq OWNED BY transactions.id;
+ALTER SEQUENCE routing_decisions_id_seq OWNED BY routing_decisions.id;
+
+DROP TABLE routing_decisions_unpartitioned;
+DROP TABLE transactions_unpartitioned;
+
+-- Indexes on a partitioned table are created on every partition, including future ones
+CREATE INDEX IF NOT EXISTS idx_transactions_id ON transactions (id);
+CREATE INDEX IF NOT EXISTS idx_transactions_created_at ON transactions (created_at);
+CREATE INDEX IF NOT EXISTS idx_routing_decisions_transaction_id ON routing_decisions (transaction_id);
+
+COMMIT;
@@ -0,0 +1,15 @@
+-- Configures a shard's ID sequences so every ID it issues satisfies id % shard_count = shard_index.
+--
+-- Run once per shard after init.sql and before any merchants, users or transactions are created
+-- (remove the sample merchants and users init.sql seeds), e.g. for the second of four shards:
+--   psql "$SHARD_DATABASE_URL" -v shard_count=4 -v shard_index=1 -f db/migrations/002_shard_sequences.sql
+--
+-- The service routes users and transactions to a shard by ID (see db.ModuloResolver), so users must be
+-- created on their merchant's shard and merchants on shard merchant_id % shard_count. Countries and
+-- gateways are reference data and must be kept identical on every shard.
+
+SELECT CASE WHEN :shard_index = 0 THEN :shard_count ELSE :shard_index END AS shard_start \gset
+
+ALTER SEQUENCE merchants_id_seq INCREMENT BY :shard_count RESTART WITH :shard_start;
+ALTER SEQUENCE users_id_seq INCREMENT BY :shard_count RESTART WITH :shard_start;
+ALTER SEQUENCE transactions_id_seq INCREMENT BY :shard_count RESTART WITH :shard_start;
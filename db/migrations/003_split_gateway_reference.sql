@@ -0,0 +1,38 @@
+-- Splits the gateway's hosted payment page and its own reference out of reference_id.
+--
+-- Before transaction references were generated by the service, reference_id held the redirect URL
+-- returned by the gateway, e.g. https://stripe.example.com/payment/Stripe-123-1700000000?return_url=...
+-- Run once against databases created before gateway_reference and redirect_url existed:
+--   psql "$DATABASE_URL" -f db/migrations/003_split_gateway_reference.sql
+--
+-- The URL moves to redirect_url and the last segment of its path, the gateway's reference, to
+-- gateway_reference. reference_id and reference_hash are cleared for those rows. The service
+-- computes gateway_reference_hash for the backfilled rows when it next starts.
+-- Safe to run more than once.
+
+BEGIN;
+
+ALTER TABLE transactions ADD COLUMN IF NOT EXISTS redirect_url TEXT;
+ALTER TABLE transactions ADD COLUMN IF NOT EXISTS gateway_reference VARCHAR(255);
+ALTER TABLE transactions ADD COLUMN IF NOT EXISTS gateway_reference_hash VARCHAR(80);
+CREATE INDEX IF NOT EXISTS idx_transactions_gateway_reference_hash ON transactions (gateway_reference_hash);
+
+ALTER TABLE transactions_archive ADD COLUMN IF NOT EXISTS redirect_url TEXT;
+ALTER TABLE transactions_archive ADD COLUMN IF NOT EXISTS gateway_reference VARCHAR(255);
+
+UPDATE transactions
+SET redirect_url = reference_id,
+    gateway_reference = NULLIF(regexp_replace(split_part(split_part(reference_id, '?', 1), '#', 1), '^.*/', ''), ''),
+    gateway_reference_hash = NULL,
+    reference_id = NULL,
+    reference_hash = NULL
+WHERE reference_id ~* '^https?://';
+
+-- Archived rows have no blind indexes; their URLs are NULL when PII was purged
+UPDATE transactions_archive
+SET redirect_url = CASE WHEN pii_purged THEN NULL ELSE reference_id END,
+    gateway_reference = NULLIF(regexp_replace(split_part(split_part(reference_id, '?', 1), '#', 1), '^.*/', ''), ''),
+    reference_id = NULL
+WHERE reference_id ~* '^https?://';
+
+COMMIT;
@@ -0,0 +1,17 @@
+-- Widens transaction amounts to three decimals, the minor unit of currencies such as KWD and BHD.
+--
+-- Amounts are rounded to their currency's minor unit before they are stored; with two decimals
+-- the database rounded three-decimal amounts a second time, so the stored amount differed from
+-- the one sent to the gateway. Run once against databases created before amounts had three
+-- decimals:
+--   psql "$DATABASE_URL" -f db/migrations/004_amount_scale.sql
+--
+-- Existing amounts are unchanged. On a partitioned transactions table the change applies to every
+-- partition. Safe to run more than once.
+
+BEGIN;
+
+ALTER TABLE transactions ALTER COLUMN amount TYPE DECIMAL(13, 3);
+ALTER TABLE transactions_archive ALTER COLUMN amount TYPE DECIMAL(13, 3);
+
+COMMIT;
@@ -0,0 +1,20 @@
+-- Adds the on-chain payment of crypto deposits to transactions: the amount and currency paid, the
+-- network and transaction hash, confirmations, and whether the charge was paid, underpaid or
+-- overpaid. Run once against databases created before crypto gateways were supported:
+--   psql "$DATABASE_URL" -f db/migrations/005_crypto_payments.sql
+--
+-- The columns are NULL for transactions of other gateways. On a partitioned transactions table
+-- they are added to every partition. Safe to run more than once.
+
+BEGIN;
+
+ALTER TABLE transactions
+    ADD COLUMN IF NOT EXISTS crypto_amount VARCHAR(40),
+    ADD COLUMN IF NOT EXISTS crypto_currency VARCHAR(10),
+    ADD COLUMN IF NOT EXISTS crypto_network VARCHAR(30),
+    ADD COLUMN IF NOT EXISTS crypto_transaction_hash VARCHAR(100),
+    ADD COLUMN IF NOT EXISTS crypto_confirmations INT,
+    ADD COLUMN IF NOT EXISTS crypto_required_confirmations INT,
+    ADD COLUMN IF NOT EXISTS crypto_status VARCHAR(20);
+
+COMMIT;
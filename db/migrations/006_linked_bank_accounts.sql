@@ -0,0 +1,51 @@
+-- Adds bank account linking: the consents users give an open banking gateway to read their bank
+-- accounts, with the tokens they grant, the accounts linked with them, and the linked account a
+-- withdrawal is paid out to. Run once against databases created before bank payouts were supported:
+--   psql "$DATABASE_URL" -f db/migrations/006_linked_bank_accounts.sql
+--
+-- Tokens and account identifiers are encrypted by the application. On a partitioned transactions
+-- table bank_account_id is added to every partition. Safe to run more than once.
+
+BEGIN;
+
+CREATE TABLE IF NOT EXISTS bank_account_consents (
+    id SERIAL PRIMARY KEY,
+    user_id INT NOT NULL,
+    gateway_id INT NOT NULL,
+    status VARCHAR(30) NOT NULL DEFAULT 'awaiting_authorisation',
+    state_hash CHAR(64) NOT NULL UNIQUE,
+    authorisation_url TEXT,
+    access_token TEXT,
+    refresh_token TEXT,
+    token_expires_at TIMESTAMP,
+    livemode BOOLEAN NOT NULL DEFAULT false,
+    expires_at TIMESTAMP NOT NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (gateway_id) REFERENCES gateways(id)
+);
+
+CREATE TABLE IF NOT EXISTS linked_bank_accounts (
+    id SERIAL PRIMARY KEY,
+    user_id INT NOT NULL,
+    gateway_id INT NOT NULL,
+    consent_id INT NOT NULL,
+    external_id VARCHAR(255) NOT NULL,
+    bank_name VARCHAR(255),
+    holder_name VARCHAR(255) NOT NULL,
+    currency VARCHAR(3) NOT NULL,
+    sort_code TEXT,
+    account_number TEXT,
+    iban TEXT,
+    last4 VARCHAR(4) NOT NULL,
+    status VARCHAR(20) NOT NULL DEFAULT 'active',
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE (user_id, gateway_id, external_id),
+    FOREIGN KEY (gateway_id) REFERENCES gateways(id),
+    FOREIGN KEY (consent_id) REFERENCES bank_account_consents(id)
+);
+
+ALTER TABLE transactions ADD COLUMN IF NOT EXISTS bank_account_id INT;
+
+COMMIT;
@@ -0,0 +1,34 @@
+-- Adds the wallet ledger: transfers between users of the same merchant, and the livemode of
+-- archived transactions, which still count towards a user's live or sandbox wallet balance. Run
+-- once against databases created before wallet transfers were supported:
+--   psql "$DATABASE_URL" -f db/migrations/007_wallet_transfers.sql
+--
+-- Transactions archived before this migration count towards sandbox balances. On a sharded
+-- deployment run it against every shard. Safe to run more than once.
+
+BEGIN;
+
+ALTER TABLE transactions_archive ADD COLUMN IF NOT EXISTS livemode BOOLEAN NOT NULL DEFAULT false;
+
+CREATE TABLE IF NOT EXISTS transfers (
+    id SERIAL PRIMARY KEY,
+    from_user_id INT NOT NULL,
+    to_user_id INT NOT NULL,
+    merchant_id INT NOT NULL,
+    amount DECIMAL(13, 3) NOT NULL,
+    currency VARCHAR(3) NOT NULL,
+    description VARCHAR(140),
+    status VARCHAR(20) NOT NULL,
+    decline_code VARCHAR(50),
+    risk_flags TEXT[] NOT NULL DEFAULT '{}',
+    livemode BOOLEAN NOT NULL DEFAULT false,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (from_user_id) REFERENCES users(id),
+    FOREIGN KEY (to_user_id) REFERENCES users(id),
+    FOREIGN KEY (merchant_id) REFERENCES merchants(id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_transfers_from_user ON transfers (from_user_id, currency, created_at);
+CREATE INDEX IF NOT EXISTS idx_transfers_to_user ON transfers (to_user_id, currency);
+
+COMMIT;
@@ -0,0 +1,38 @@
+-- Adds SEPA Direct Debit mandates: the mandates users signed for deposits to be debited from their
+-- bank account, the mandate a transaction is debited under or paid out to, and the recovery hints
+-- of SEPA decline codes. Run once against databases created before SEPA payments were supported:
+--   psql "$DATABASE_URL" -f db/migrations/008_sepa_mandates.sql
+--
+-- IBANs are encrypted by the application. On a partitioned transactions table mandate_id is added
+-- to every partition. Safe to run more than once.
+
+BEGIN;
+
+CREATE TABLE IF NOT EXISTS sepa_mandates (
+    id SERIAL PRIMARY KEY,
+    user_id INT NOT NULL,
+    gateway_id INT NOT NULL,
+    reference VARCHAR(35) NOT NULL UNIQUE,
+    debtor_name VARCHAR(70) NOT NULL,
+    iban TEXT NOT NULL,
+    bic VARCHAR(11),
+    last4 VARCHAR(4) NOT NULL,
+    sequence_type VARCHAR(4) NOT NULL DEFAULT 'FRST',
+    status VARCHAR(20) NOT NULL DEFAULT 'active',
+    revocation_reason VARCHAR(4),
+    signed_at TIMESTAMP NOT NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (gateway_id) REFERENCES gateways(id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_sepa_mandates_user ON sepa_mandates(user_id);
+
+ALTER TABLE transactions ADD COLUMN IF NOT EXISTS mandate_id INT;
+
+INSERT INTO decline_recovery_hints (decline_code, recovery_hint) VALUES
+    ('mandate_invalid', 'use_other_method'),
+    ('debit_refunded', 'do_not_retry')
+ON CONFLICT (decline_code) DO NOTHING;
+
+COMMIT;
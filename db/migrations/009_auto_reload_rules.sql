@@ -0,0 +1,34 @@
+-- Adds auto-reload rules, which top up users' wallets by deposits under one of their SEPA mandates
+-- whenever the balance drops below a threshold. Run once against databases created before
+-- auto-reload was supported, after 008_sepa_mandates.sql:
+--   psql "$DATABASE_URL" -f db/migrations/009_auto_reload_rules.sql
+--
+-- Safe to run more than once.
+
+BEGIN;
+
+CREATE TABLE IF NOT EXISTS auto_reload_rules (
+    id SERIAL PRIMARY KEY,
+    user_id INT NOT NULL,
+    currency VARCHAR(3) NOT NULL,
+    threshold DECIMAL(13, 3) NOT NULL,
+    amount DECIMAL(13, 3) NOT NULL,
+    mandate_id INT NOT NULL,
+    status VARCHAR(20) NOT NULL DEFAULT 'active',
+    disabled_reason TEXT,
+    consecutive_failures INT NOT NULL DEFAULT 0,
+    last_transaction_id INT,
+    last_reload_status VARCHAR(20),
+    last_reload_at TIMESTAMP,
+    window_start TIMESTAMP,
+    window_reloads INT NOT NULL DEFAULT 0,
+    claimed_at TIMESTAMP,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (mandate_id) REFERENCES sepa_mandates(id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_auto_reload_rules_user ON auto_reload_rules(user_id);
+CREATE INDEX IF NOT EXISTS idx_auto_reload_rules_active ON auto_reload_rules(claimed_at) WHERE status = 'active';
+
+COMMIT;
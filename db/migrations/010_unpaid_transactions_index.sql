@@ -0,0 +1,9 @@
+-- Indexes transactions still pending or processing by gateway and creation time, which the payment
+-- expiry scheduler scans for deposits whose payment window closed, such as unpaid PIX charges. Run
+-- once against databases created before PIX was supported:
+--   psql "$DATABASE_URL" -f db/migrations/010_unpaid_transactions_index.sql
+--
+-- On a partitioned transactions table the index is created on every partition. Safe to run more
+-- than once.
+
+CREATE INDEX IF NOT EXISTS idx_transactions_unpaid ON transactions (gateway_id, created_at) WHERE status IN ('pending', 'processing');
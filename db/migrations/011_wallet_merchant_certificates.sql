@@ -0,0 +1,26 @@
+-- Adds the key pairs Apple Pay and Google Pay encrypt payment tokens to, with which deposits paid
+-- by a wallet token are decrypted for gateways that don't take the token itself. Run once against
+-- databases created before wallet tokens were supported:
+--   psql "$DATABASE_URL" -f db/migrations/011_wallet_merchant_certificates.sql
+--
+-- Safe to run more than once.
+
+BEGIN;
+
+CREATE TABLE IF NOT EXISTS wallet_merchant_certificates (
+    id SERIAL PRIMARY KEY,
+    wallet VARCHAR(20) NOT NULL,
+    certificate TEXT NOT NULL DEFAULT '',
+    public_key TEXT NOT NULL,
+    private_key TEXT NOT NULL,
+    public_key_hash VARCHAR(64) NOT NULL,
+    merchant_identifier VARCHAR(64) NOT NULL DEFAULT '',
+    not_after TIMESTAMP,
+    status VARCHAR(20) NOT NULL DEFAULT 'active',
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    retired_at TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_wallet_merchant_certificates_wallet ON wallet_merchant_certificates (wallet);
+
+COMMIT;
@@ -0,0 +1,33 @@
+-- Adds merchant fees and the daily reconciliation files reporting every transaction with its fee
+-- and net amount. Run once against databases created before reconciliation files were supported:
+--   psql "$DATABASE_URL" -f db/migrations/012_reconciliation_files.sql
+--
+-- Safe to run more than once.
+
+BEGIN;
+
+CREATE TABLE IF NOT EXISTS merchant_fees (
+    merchant_id INT NOT NULL,
+    currency VARCHAR(3) NOT NULL,
+    percent DECIMAL(7, 4) NOT NULL DEFAULT 0,
+    fixed DECIMAL(15, 3) NOT NULL DEFAULT 0,
+    updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (merchant_id, currency),
+    FOREIGN KEY (merchant_id) REFERENCES merchants(id)
+);
+
+CREATE TABLE IF NOT EXISTS reconciliation_files (
+    id VARCHAR(64) PRIMARY KEY,
+    merchant_id INT NOT NULL,
+    report_date DATE NOT NULL,
+    version INT NOT NULL DEFAULT 1,
+    row_count INT NOT NULL,
+    size_bytes INT NOT NULL,
+    checksum VARCHAR(64) NOT NULL,
+    storage_key VARCHAR(255) NOT NULL,
+    generated_at TIMESTAMP NOT NULL,
+    UNIQUE (merchant_id, report_date),
+    FOREIGN KEY (merchant_id) REFERENCES merchants(id)
+);
+
+COMMIT;
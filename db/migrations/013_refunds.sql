@@ -0,0 +1,25 @@
+-- Adds refunds of completed deposits, linked to the deposit they refund. Run once against
+-- databases created before refunds were supported:
+--   psql "$DATABASE_URL" -f db/migrations/013_refunds.sql
+--
+-- Safe to run more than once.
+
+BEGIN;
+
+CREATE TABLE IF NOT EXISTS refunds (
+    id SERIAL PRIMARY KEY,
+    transaction_id INT NOT NULL,
+    amount DECIMAL(13, 3) NOT NULL,
+    currency VARCHAR(3) NOT NULL,
+    status VARCHAR(20) NOT NULL DEFAULT 'pending',
+    reason TEXT,
+    gateway_reference VARCHAR(255),
+    decline_code VARCHAR(50),
+    error_message TEXT,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_refunds_transaction ON refunds (transaction_id);
+
+COMMIT;
@@ -0,0 +1,18 @@
+-- Adds the searchable tags merchants and admins attach to transactions. Run once against
+-- databases created before transaction tags were supported:
+--   psql "$DATABASE_URL" -f db/migrations/014_transaction_tags.sql
+--
+-- Safe to run more than once.
+
+BEGIN;
+
+CREATE TABLE IF NOT EXISTS transaction_tags (
+    transaction_id INT NOT NULL,
+    tag VARCHAR(64) NOT NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (transaction_id, tag)
+);
+
+CREATE INDEX IF NOT EXISTS idx_transaction_tags_tag ON transaction_tags (tag, transaction_id);
+
+COMMIT;
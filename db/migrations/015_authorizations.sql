@@ -0,0 +1,16 @@
+-- Adds the amount held for deposits authorized to be captured later and when the hold lapses,
+-- with the index the authorization expiry scheduler scans. Run once against databases created
+-- before authorizations were supported:
+--   psql "$DATABASE_URL" -f db/migrations/015_authorizations.sql
+--
+-- On a partitioned transactions table the columns and index are added to every partition. Safe
+-- to run more than once.
+
+BEGIN;
+
+ALTER TABLE transactions ADD COLUMN IF NOT EXISTS authorized_amount DECIMAL(13, 3);
+ALTER TABLE transactions ADD COLUMN IF NOT EXISTS authorization_expires_at TIMESTAMP;
+
+CREATE INDEX IF NOT EXISTS idx_transactions_authorization_expires_at ON transactions (authorization_expires_at) WHERE status = 'authorized';
+
+COMMIT;
@@ -0,0 +1,30 @@
+-- Adds holds keeping completed deposits out of their user's spendable wallet balance while they
+-- are reviewed for fraud. Run once against databases created before transaction holds were
+-- supported:
+--   psql "$DATABASE_URL" -f db/migrations/016_transaction_holds.sql
+--
+-- Safe to run more than once.
+
+BEGIN;
+
+CREATE TABLE IF NOT EXISTS transaction_holds (
+    id SERIAL PRIMARY KEY,
+    transaction_id INT NOT NULL,
+    user_id INT NOT NULL,
+    amount DECIMAL(13, 3) NOT NULL,
+    currency VARCHAR(3) NOT NULL,
+    livemode BOOLEAN NOT NULL DEFAULT FALSE,
+    reason VARCHAR(255) NOT NULL,
+    status VARCHAR(20) NOT NULL DEFAULT 'active',
+    release_at TIMESTAMP NOT NULL,
+    release_note TEXT,
+    released_at TIMESTAMP,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_transaction_holds_active ON transaction_holds (transaction_id) WHERE status = 'active';
+CREATE INDEX IF NOT EXISTS idx_transaction_holds_user ON transaction_holds (user_id, currency) WHERE status = 'active';
+CREATE INDEX IF NOT EXISTS idx_transaction_holds_status ON transaction_holds (status, release_at);
+
+COMMIT;
@@ -0,0 +1,12 @@
+-- Adds whether an authorized deposit's amount held can be raised while it is authorized. Run once
+-- against databases created before incremental authorizations were supported:
+--   psql "$DATABASE_URL" -f db/migrations/017_incremental_authorizations.sql
+--
+-- On a partitioned transactions table the column is added to every partition. Safe to run more
+-- than once.
+
+BEGIN;
+
+ALTER TABLE transactions ADD COLUMN IF NOT EXISTS incremental_authorization BOOLEAN NOT NULL DEFAULT FALSE;
+
+COMMIT;
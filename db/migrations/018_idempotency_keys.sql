@@ -0,0 +1,25 @@
+-- Adds the responses to deposits and withdrawals sent with an Idempotency-Key header, replayed to
+-- retries of the request. Run once against databases created before idempotency keys were
+-- supported:
+--   psql "$DATABASE_URL" -f db/migrations/018_idempotency_keys.sql
+--
+-- Safe to run more than once.
+
+BEGIN;
+
+CREATE TABLE IF NOT EXISTS idempotency_keys (
+    route VARCHAR(64) NOT NULL,
+    key VARCHAR(255) NOT NULL,
+    request_hash VARCHAR(64) NOT NULL,
+    status_code INT,
+    content_type VARCHAR(100),
+    response_body BYTEA,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    completed_at TIMESTAMP,
+    expires_at TIMESTAMP NOT NULL,
+    PRIMARY KEY (route, key)
+);
+
+CREATE INDEX IF NOT EXISTS idx_idempotency_keys_expires_at ON idempotency_keys (expires_at);
+
+COMMIT;
@@ -0,0 +1,16 @@
+-- Adds the amount captured so far of authorizations captured in parts, and the link from each
+-- partial capture to its authorization. Run once against databases created before multi-capture
+-- was supported:
+--   psql "$DATABASE_URL" -f db/migrations/019_multi_capture.sql
+--
+-- On a partitioned transactions table the columns and index are added to every partition. Safe
+-- to run more than once.
+
+BEGIN;
+
+ALTER TABLE transactions ADD COLUMN IF NOT EXISTS captured_amount DECIMAL(13, 3);
+ALTER TABLE transactions ADD COLUMN IF NOT EXISTS capture_of_id INT;
+
+CREATE INDEX IF NOT EXISTS idx_transactions_capture_of_id ON transactions (capture_of_id) WHERE capture_of_id IS NOT NULL;
+
+COMMIT;
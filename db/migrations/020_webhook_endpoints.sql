@@ -0,0 +1,29 @@
+-- Adds the webhook endpoints merchants configure, each receiving the event types it lists, and
+-- delivers outbox messages per endpoint. Run once against databases created before webhook
+-- endpoints were supported:
+--   psql "$DATABASE_URL" -f db/migrations/020_webhook_endpoints.sql
+--
+-- Safe to run more than once.
+
+BEGIN;
+
+CREATE TABLE IF NOT EXISTS webhook_endpoints (
+    id SERIAL PRIMARY KEY,
+    merchant_id INT NOT NULL,
+    url TEXT NOT NULL,
+    event_types TEXT[] NOT NULL DEFAULT '{}',
+    description VARCHAR(255),
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (merchant_id) REFERENCES merchants(id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_webhook_endpoints_merchant_id ON webhook_endpoints (merchant_id);
+
+-- The same event is delivered once to each endpoint, so messages are unique per endpoint
+ALTER TABLE outbox_messages ADD COLUMN IF NOT EXISTS endpoint_id INT NOT NULL DEFAULT 0;
+ALTER TABLE outbox_messages DROP CONSTRAINT IF EXISTS outbox_messages_destination_dedup_token_key;
+CREATE UNIQUE INDEX IF NOT EXISTS outbox_messages_destination_endpoint_id_dedup_token_key
+    ON outbox_messages (destination, endpoint_id, dedup_token);
+
+COMMIT;
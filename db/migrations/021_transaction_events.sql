@@ -0,0 +1,24 @@
+-- Adds the audit trail recording each transaction's creation and status changes. Run once against
+-- databases created before the transaction event history was supported:
+--   psql "$DATABASE_URL" -f db/migrations/021_transaction_events.sql
+--
+-- Transactions changed before this migration only have the entries recorded afterwards.
+--
+-- Safe to run more than once.
+
+BEGIN;
+
+CREATE TABLE IF NOT EXISTS transaction_events (
+    id SERIAL PRIMARY KEY,
+    transaction_id INT NOT NULL,
+    type VARCHAR(100) NOT NULL,
+    status VARCHAR(50) NOT NULL,
+    message TEXT,
+    decline_code VARCHAR(50),
+    gateway_reference VARCHAR(255),
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_transaction_events_transaction_id ON transaction_events (transaction_id, id);
+
+COMMIT;
@@ -0,0 +1,13 @@
+-- Pins each merchant webhook endpoint to the payload version it receives. Run once against
+-- databases created before webhook payload versions were supported:
+--   psql "$DATABASE_URL" -f db/migrations/022_webhook_payload_versions.sql
+--
+-- Existing endpoints are pinned to v1, the shape they have been receiving.
+--
+-- Safe to run more than once.
+
+BEGIN;
+
+ALTER TABLE webhook_endpoints ADD COLUMN IF NOT EXISTS payload_version VARCHAR(10) NOT NULL DEFAULT 'v1';
+
+COMMIT;
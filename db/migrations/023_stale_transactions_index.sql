@@ -0,0 +1,9 @@
+-- Indexes transactions still pending or processing by when they last changed, which the stale
+-- transaction reaper scans for transactions whose gateway never reported an outcome. Run once
+-- against databases created before stale transactions were expired:
+--   psql "$DATABASE_URL" -f db/migrations/023_stale_transactions_index.sql
+--
+-- On a partitioned transactions table the index is created on every partition. Safe to run more
+-- than once.
+
+CREATE INDEX IF NOT EXISTS idx_transactions_in_flight_changed ON transactions ((COALESCE(updated_at, created_at))) WHERE status IN ('pending', 'processing');
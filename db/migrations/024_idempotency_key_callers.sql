@@ -0,0 +1,15 @@
+-- Scopes idempotency keys to their caller as well as their route, so callers sending the same key
+-- never see each other's responses. Run once against databases created before keys were scoped:
+--   psql "$DATABASE_URL" -f db/migrations/024_idempotency_key_callers.sql
+--
+-- Keys recorded before keep an empty caller, which no request matches, and are purged when they
+-- expire. Safe to run more than once.
+
+BEGIN;
+
+ALTER TABLE idempotency_keys ADD COLUMN IF NOT EXISTS caller VARCHAR(100) NOT NULL DEFAULT '';
+ALTER TABLE idempotency_keys ALTER COLUMN caller TYPE VARCHAR(100);
+ALTER TABLE idempotency_keys DROP CONSTRAINT IF EXISTS idempotency_keys_pkey;
+ALTER TABLE idempotency_keys ADD PRIMARY KEY (caller, route, key);
+
+COMMIT;
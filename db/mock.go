@@ -3,7 +3,11 @@ package db
 import (
 	"database/sql"
 	"errors"
+	"math"
+	"payment-gateway/internal/consts"
 	"payment-gateway/internal/models"
+	"payment-gateway/internal/utils"
+	"sort"
 	"sync"
 	"time"
 )
@@ -11,10 +15,52 @@ import (
 // MockDB implements DBInterface for testing
 type MockDB struct {
 	users             map[int]*models.User
+	merchants         map[int]*models.Merchant
+	countries         map[int]*models.Country
 	gateways          map[int]*models.Gateway
 	gatewaysByCountry map[int][]models.GatewayPriority
 	transactions      map[int]*models.Transaction
+	archived          map[int]*models.Transaction
+	lastArchivedAt    time.Time
+	batches           map[int]*models.Batch
+	operations        map[string]*models.Operation
+	routingDecisions  []models.RoutingDecision
+	transactionEvents []models.TransactionEvent
+	routingRules      map[int][]models.RoutingRule
+	payoutSchedules   map[int]*models.PayoutSchedule
+	merchantFees      map[int][]models.MerchantFee
+	reconFiles        map[string]models.ReconciliationFile
+	bankHolidays      map[int]map[string]string
+	complianceFields  map[int][]models.ComplianceField
+	amlThresholds     map[int]map[string]models.AMLThreshold
+	recoveryHints     map[string]models.DeclineRecoveryHint
+	webhookSecrets    []models.WebhookSecret
+	webhookEndpoints  []models.WebhookEndpoint
+	clientCerts       []models.ClientCertificate
+	signingKeys       []models.SigningKey
+	merchantCerts     []models.MerchantCertificate
+	maintenance       []models.MaintenanceWindow
+	outbox            []models.OutboxMessage
+	callbacks         []models.CallbackRecord
+	disputes          []models.Dispute
+	holds             []models.TransactionHold
+	idempotencyKeys   map[string]*models.IdempotencyKey // by caller, route and key
+	refunds           []models.Refund
+	transactionTags   map[int][]string
+	amlCases          []models.AMLCase
+	screeningCases    []models.ScreeningCase
+	screeningEvents   []models.ScreeningEvent
+	consents          []models.PaymentConsent
+	accountConsents   []models.BankAccountConsent
+	bankAccounts      []models.BankAccount
+	mandates          []models.Mandate
+	autoReloadRules   []models.AutoReloadRule
+	autoReloadClaims  map[int]time.Time // when each auto-reload rule was last claimed
+	transfers         []models.Transfer
+	apiKeys           []models.APIKey
+	oauthClients      []models.OAuthClient
 	nextTxID          int
+	nextBatchID       int
 	mu                sync.RWMutex
 }
 
@@ -22,10 +68,26 @@ type MockDB struct {
 func NewMockDB() *MockDB {
 	db := &MockDB{
 		users:             make(map[int]*models.User),
+		merchants:         make(map[int]*models.Merchant),
+		countries:         make(map[int]*models.Country),
 		gateways:          make(map[int]*models.Gateway),
+		routingRules:      make(map[int][]models.RoutingRule),
+		payoutSchedules:   make(map[int]*models.PayoutSchedule),
+		merchantFees:      make(map[int][]models.MerchantFee),
+		reconFiles:        make(map[string]models.ReconciliationFile),
+		bankHolidays:      make(map[int]map[string]string),
+		complianceFields:  make(map[int][]models.ComplianceField),
+		amlThresholds:     make(map[int]map[string]models.AMLThreshold),
+		recoveryHints:     make(map[string]models.DeclineRecoveryHint),
 		gatewaysByCountry: make(map[int][]models.GatewayPriority),
 		transactions:      make(map[int]*models.Transaction),
+		archived:          make(map[int]*models.Transaction),
+		batches:           make(map[int]*models.Batch),
+		operations:        make(map[string]*models.Operation),
+		autoReloadClaims:  make(map[int]time.Time),
+		idempotencyKeys:   make(map[string]*models.IdempotencyKey),
 		nextTxID:          1,
+		nextBatchID:       1,
 	}
 
 	// Initialize with sample data
@@ -36,29 +98,50 @@ func NewMockDB() *MockDB {
 
 // seedSampleData initializes the mock DB with test data
 func (m *MockDB) seedSampleData() {
+	// Add sample merchants
+	m.merchants[1] = &models.Merchant{
+		ID:                     1,
+		Name:                   "Demo Merchant",
+		AllowedRedirectDomains: []string{"example.com", "localhost"},
+		CreatedAt:              time.Now(),
+	}
+
+	// Add sample countries
+	m.countries[1] = &models.Country{ID: 1, Name: "United States", Code: "US", Currency: "USD", Timezone: "America/New_York"}
+	m.countries[2] = &models.Country{ID: 2, Name: "United Kingdom", Code: "GB", Currency: "GBP", Timezone: "Europe/London"}
+	m.countries[3] = &models.Country{ID: 3, Name: "Germany", Code: "DE", Currency: "EUR", Timezone: "Europe/Berlin"}
+	m.countries[4] = &models.Country{ID: 4, Name: "Japan", Code: "JP", Currency: "JPY", Timezone: "Asia/Tokyo"}
+
+	// Add sample bank holidays
+	m.bankHolidays[1] = map[string]string{"2026-11-26": "Thanksgiving Day", "2026-12-25": "Christmas Day"}
+	m.bankHolidays[2] = map[string]string{"2026-12-25": "Christmas Day", "2026-12-28": "Boxing Day (substitute day)"}
+
 	// Add sample users
 	m.users[1] = &models.User{
-		ID:        1,
-		Username:  "user1",
-		Email:     "user1@example.com",
-		CountryID: 1, // US
-		CreatedAt: time.Now(),
+		ID:         1,
+		Username:   "user1",
+		Email:      "user1@example.com",
+		CountryID:  1, // US
+		MerchantID: 1,
+		CreatedAt:  time.Now(),
 	}
 
 	m.users[2] = &models.User{
-		ID:        2,
-		Username:  "user2",
-		Email:     "user2@example.com",
-		CountryID: 2, // UK
-		CreatedAt: time.Now(),
+		ID:         2,
+		Username:   "user2",
+		Email:      "user2@example.com",
+		CountryID:  2, // UK
+		MerchantID: 1,
+		CreatedAt:  time.Now(),
 	}
 
 	m.users[3] = &models.User{
-		ID:        3,
-		Username:  "user3",
-		Email:     "user3@example.com",
-		CountryID: 3, // Germany
-		CreatedAt: time.Now(),
+		ID:         3,
+		Username:   "user3",
+		Email:      "user3@example.com",
+		CountryID:  3, // Germany
+		MerchantID: 1,
+		CreatedAt:  time.Now(),
 	}
 
 	// Add sample gateways
@@ -121,6 +204,369 @@ func (m *MockDB) GetUserByID(userID int) (*models.User, error) {
 	return &userCopy, nil
 }
 
+// CreateUser stores a new user, rejecting a taken username or email
+func (m *MockDB) CreateUser(user models.User) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	id := 1
+	for existingID, existing := range m.users {
+		if existing.Username == user.Username || existing.Email == user.Email {
+			return 0, errors.New("username or email already taken")
+		}
+		if existingID >= id {
+			id = existingID + 1
+		}
+	}
+
+	user.ID = id
+	user.UpdatedAt = user.CreatedAt
+	m.users[id] = &user
+
+	return id, nil
+}
+
+// UpdateUserScreeningStatus sets a user's sanctions screening status
+func (m *MockDB) UpdateUserScreeningStatus(userID int, status string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	user, exists := m.users[userID]
+	if !exists {
+		return sql.ErrNoRows
+	}
+
+	user.ScreeningStatus = status
+	user.UpdatedAt = time.Now()
+
+	return nil
+}
+
+// UpdateUserContact updates a user's contact details
+func (m *MockDB) UpdateUserContact(userID int, contact models.UserContact) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	user, exists := m.users[userID]
+	if !exists {
+		return sql.ErrNoRows
+	}
+
+	user.UserContact = contact
+	user.UpdatedAt = time.Now()
+
+	return nil
+}
+
+// GetMerchantByID gets a merchant by ID from the mock database
+func (m *MockDB) GetMerchantByID(merchantID int) (*models.Merchant, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	merchant, exists := m.merchants[merchantID]
+	if !exists {
+		return nil, sql.ErrNoRows
+	}
+
+	// Return a copy to prevent mutation
+	merchantCopy := *merchant
+	merchantCopy.AllowedRedirectDomains = append([]string(nil), merchant.AllowedRedirectDomains...)
+	return &merchantCopy, nil
+}
+
+// SetMerchantWebhookSecret replaces the encrypted secret a merchant's webhooks are signed with
+func (m *MockDB) SetMerchantWebhookSecret(merchantID int, secret string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	merchant, exists := m.merchants[merchantID]
+	if !exists {
+		return sql.ErrNoRows
+	}
+
+	merchant.WebhookSecret = secret
+	merchant.UpdatedAt = time.Now()
+	return nil
+}
+
+// SetMerchantLivemode switches a merchant between gateways' sandbox and production environments
+func (m *MockDB) SetMerchantLivemode(merchantID int, livemode bool) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	merchant, exists := m.merchants[merchantID]
+	if !exists {
+		return sql.ErrNoRows
+	}
+
+	merchant.Livemode = livemode
+	merchant.UpdatedAt = time.Now()
+	return nil
+}
+
+// GetRoutingRules fetches a merchant's routing rules in position order
+func (m *MockDB) GetRoutingRules(merchantID int) ([]models.RoutingRule, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	return append([]models.RoutingRule(nil), m.routingRules[merchantID]...), nil
+}
+
+// ReplaceRoutingRules replaces all of a merchant's routing rules
+func (m *MockDB) ReplaceRoutingRules(merchantID int, rules []models.RoutingRule) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, exists := m.merchants[merchantID]; !exists {
+		return sql.ErrNoRows
+	}
+
+	m.routingRules[merchantID] = append([]models.RoutingRule(nil), rules...)
+	return nil
+}
+
+// GetPayoutSchedule fetches a merchant's payout schedule
+func (m *MockDB) GetPayoutSchedule(merchantID int) (*models.PayoutSchedule, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	schedule, exists := m.payoutSchedules[merchantID]
+	if !exists {
+		return nil, sql.ErrNoRows
+	}
+
+	scheduleCopy := *schedule
+	return &scheduleCopy, nil
+}
+
+// SavePayoutSchedule creates or replaces a merchant's payout schedule
+func (m *MockDB) SavePayoutSchedule(schedule models.PayoutSchedule) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, exists := m.merchants[schedule.MerchantID]; !exists {
+		return sql.ErrNoRows
+	}
+
+	m.payoutSchedules[schedule.MerchantID] = &schedule
+	return nil
+}
+
+// GetMerchantIDs returns the IDs of every merchant in ascending order
+func (m *MockDB) GetMerchantIDs() ([]int, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	ids := make([]int, 0, len(m.merchants))
+	for id := range m.merchants {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+	return ids, nil
+}
+
+// GetMerchantFees fetches a merchant's fees by currency
+func (m *MockDB) GetMerchantFees(merchantID int) ([]models.MerchantFee, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	return append([]models.MerchantFee(nil), m.merchantFees[merchantID]...), nil
+}
+
+// ReplaceMerchantFees replaces all of a merchant's fees
+func (m *MockDB) ReplaceMerchantFees(merchantID int, fees []models.MerchantFee) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, exists := m.merchants[merchantID]; !exists {
+		return sql.ErrNoRows
+	}
+
+	sorted := append([]models.MerchantFee(nil), fees...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Currency < sorted[j].Currency })
+	m.merchantFees[merchantID] = sorted
+	return nil
+}
+
+// DeletePayoutSchedule removes a merchant's payout schedule
+func (m *MockDB) DeletePayoutSchedule(merchantID int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, exists := m.payoutSchedules[merchantID]; !exists {
+		return sql.ErrNoRows
+	}
+
+	delete(m.payoutSchedules, merchantID)
+	return nil
+}
+
+// GetCountryByID gets a country by ID
+func (m *MockDB) GetCountryByID(countryID int) (*models.Country, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	country, exists := m.countries[countryID]
+	if !exists {
+		return nil, sql.ErrNoRows
+	}
+
+	countryCopy := *country
+	return &countryCopy, nil
+}
+
+// GetCountryByCode gets a country by its ISO 3166-1 alpha-2 code
+func (m *MockDB) GetCountryByCode(code string) (*models.Country, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	for _, country := range m.countries {
+		if country.Code == code {
+			countryCopy := *country
+			return &countryCopy, nil
+		}
+	}
+
+	return nil, sql.ErrNoRows
+}
+
+// GetBankHolidays fetches a country's bank holidays between two dates, inclusive
+func (m *MockDB) GetBankHolidays(countryID int, from, to string) ([]models.BankHoliday, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var holidays []models.BankHoliday
+	for date, name := range m.bankHolidays[countryID] {
+		if date >= from && date <= to {
+			holidays = append(holidays, models.BankHoliday{CountryID: countryID, Date: date, Name: name})
+		}
+	}
+	sort.Slice(holidays, func(i, j int) bool { return holidays[i].Date < holidays[j].Date })
+	return holidays, nil
+}
+
+// CreateBankHoliday adds a bank holiday to a country's calendar
+func (m *MockDB) CreateBankHoliday(holiday models.BankHoliday) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, exists := m.countries[holiday.CountryID]; !exists {
+		return sql.ErrNoRows
+	}
+	if m.bankHolidays[holiday.CountryID] == nil {
+		m.bankHolidays[holiday.CountryID] = make(map[string]string)
+	}
+	m.bankHolidays[holiday.CountryID][holiday.Date] = holiday.Name
+	return nil
+}
+
+// DeleteBankHoliday removes a bank holiday from a country's calendar
+func (m *MockDB) DeleteBankHoliday(countryID int, date string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, exists := m.bankHolidays[countryID][date]; !exists {
+		return sql.ErrNoRows
+	}
+	delete(m.bankHolidays[countryID], date)
+	return nil
+}
+
+// GetComplianceFields gets the compliance fields of a country in order
+func (m *MockDB) GetComplianceFields(countryID int) ([]models.ComplianceField, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	return append([]models.ComplianceField(nil), m.complianceFields[countryID]...), nil
+}
+
+// ReplaceComplianceFields replaces all of a country's compliance fields
+func (m *MockDB) ReplaceComplianceFields(countryID int, fields []models.ComplianceField) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, exists := m.countries[countryID]; !exists {
+		return sql.ErrNoRows
+	}
+
+	m.complianceFields[countryID] = append([]models.ComplianceField(nil), fields...)
+	return nil
+}
+
+// GetAMLThresholds gets the AML thresholds of a country by currency
+func (m *MockDB) GetAMLThresholds(countryID int) ([]models.AMLThreshold, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var thresholds []models.AMLThreshold
+	for _, threshold := range m.amlThresholds[countryID] {
+		thresholds = append(thresholds, threshold)
+	}
+	sort.Slice(thresholds, func(i, j int) bool { return thresholds[i].Currency < thresholds[j].Currency })
+	return thresholds, nil
+}
+
+// GetAMLThreshold gets the AML threshold of a country in a currency
+func (m *MockDB) GetAMLThreshold(countryID int, currency string) (*models.AMLThreshold, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	threshold, exists := m.amlThresholds[countryID][currency]
+	if !exists {
+		return nil, sql.ErrNoRows
+	}
+	return &threshold, nil
+}
+
+// SetAMLThreshold creates or replaces the AML threshold of a country in a currency
+func (m *MockDB) SetAMLThreshold(threshold models.AMLThreshold) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, exists := m.countries[threshold.CountryID]; !exists {
+		return sql.ErrNoRows
+	}
+	if m.amlThresholds[threshold.CountryID] == nil {
+		m.amlThresholds[threshold.CountryID] = make(map[string]models.AMLThreshold)
+	}
+	m.amlThresholds[threshold.CountryID][threshold.Currency] = threshold
+	return nil
+}
+
+// DeleteAMLThreshold deletes the AML threshold of a country in a currency
+func (m *MockDB) DeleteAMLThreshold(countryID int, currency string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, exists := m.amlThresholds[countryID][currency]; !exists {
+		return sql.ErrNoRows
+	}
+	delete(m.amlThresholds[countryID], currency)
+	return nil
+}
+
+// GetDeclineRecoveryHints gets the configured recovery hint of each decline code
+func (m *MockDB) GetDeclineRecoveryHints() ([]models.DeclineRecoveryHint, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var hints []models.DeclineRecoveryHint
+	for _, hint := range m.recoveryHints {
+		hints = append(hints, hint)
+	}
+	sort.Slice(hints, func(i, j int) bool { return hints[i].DeclineCode < hints[j].DeclineCode })
+	return hints, nil
+}
+
+// SaveDeclineRecoveryHint sets the recovery hint of a decline code
+func (m *MockDB) SaveDeclineRecoveryHint(hint models.DeclineRecoveryHint) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.recoveryHints[hint.DeclineCode] = hint
+	return nil
+}
+
 // GetSupportedGatewaysByCountry gets gateways supported for a country
 func (m *MockDB) GetSupportedGatewaysByCountry(countryID int) ([]models.Gateway, error) {
 	m.mu.RLock()
@@ -159,78 +605,2761 @@ func (m *MockDB) GetGatewaysByPriority(countryID int) ([]models.GatewayPriority,
 	return result, nil
 }
 
-// CreateTransaction creates a new transaction record
-func (m *MockDB) CreateTransaction(transaction models.Transaction) (int, error) {
+// CreateWebhookSecret stores a webhook secret for a gateway
+func (m *MockDB) CreateWebhookSecret(secret models.WebhookSecret) (int, error) {
 	m.mu.Lock()
 	defer m.mu.Unlock()
 
-	id := m.nextTxID
-	m.nextTxID++
-
-	transaction.ID = id
-	if transaction.CreatedAt.IsZero() {
-		transaction.CreatedAt = time.Now()
+	secret.ID = len(m.webhookSecrets) + 1
+	if secret.CreatedAt.IsZero() {
+		secret.CreatedAt = time.Now()
 	}
 
-	m.transactions[id] = &transaction
+	m.webhookSecrets = append(m.webhookSecrets, secret)
 
-	return id, nil
+	return secret.ID, nil
 }
 
-// GetTransactionByID gets a transaction by ID
-func (m *MockDB) GetTransactionByID(transactionID int) (*models.Transaction, error) {
+// GetWebhookSecretsByGateway fetches a gateway's webhook secrets, oldest first
+func (m *MockDB) GetWebhookSecretsByGateway(gatewayID int) ([]models.WebhookSecret, error) {
 	m.mu.RLock()
 	defer m.mu.RUnlock()
 
-	tx, exists := m.transactions[transactionID]
-	if !exists {
-		return nil, sql.ErrNoRows
+	var secrets []models.WebhookSecret
+	for _, secret := range m.webhookSecrets {
+		if secret.GatewayID == gatewayID {
+			secrets = append(secrets, secret)
+		}
 	}
 
-	// Return a copy to prevent mutation
-	txCopy := *tx
-	return &txCopy, nil
+	return secrets, nil
 }
 
-// UpdateTransactionStatus updates a transaction's status
-func (m *MockDB) UpdateTransactionStatus(txID int, status, errorMsg string) error {
+// RetireWebhookSecret marks an active webhook secret of a gateway as retired
+func (m *MockDB) RetireWebhookSecret(gatewayID, secretID int) error {
 	m.mu.Lock()
 	defer m.mu.Unlock()
 
-	tx, exists := m.transactions[txID]
-	if !exists {
-		return errors.New("transaction not found")
+	for i := range m.webhookSecrets {
+		secret := &m.webhookSecrets[i]
+		if secret.ID == secretID && secret.GatewayID == gatewayID && secret.Status == consts.WebhookSecretActive {
+			secret.Status = consts.WebhookSecretRetired
+			secret.RetiredAt = time.Now()
+			return nil
+		}
 	}
 
-	tx.Status = status
-	tx.ErrorMessage = errorMsg
-	tx.UpdatedAt = time.Now()
-
-	return nil
+	return sql.ErrNoRows
 }
 
-// UpdateTransactionReference updates a transaction's reference ID
-func (m *MockDB) UpdateTransactionReference(txID int, referenceID string) error {
+// CreateClientCertificate stores a client certificate for a gateway
+func (m *MockDB) CreateClientCertificate(certificate models.ClientCertificate) (int, error) {
 	m.mu.Lock()
 	defer m.mu.Unlock()
 
-	tx, exists := m.transactions[txID]
-	if !exists {
-		return errors.New("transaction not found")
+	certificate.ID = len(m.clientCerts) + 1
+	if certificate.CreatedAt.IsZero() {
+		certificate.CreatedAt = time.Now()
 	}
 
-	tx.ReferenceID = referenceID
-	tx.UpdatedAt = time.Now()
+	m.clientCerts = append(m.clientCerts, certificate)
 
-	return nil
+	return certificate.ID, nil
 }
 
-// Ping checks the database connection (always returns nil for mock)
-func (m *MockDB) Ping() error {
-	return nil
-}
+// GetClientCertificates fetches a gateway's client certificates, or every gateway's when
+// gatewayID is 0, oldest first
+func (m *MockDB) GetClientCertificates(gatewayID int) ([]models.ClientCertificate, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
 
-// Close closes the database connection (no-op for mock)
-func (m *MockDB) Close() error {
+	var certificates []models.ClientCertificate
+	for _, certificate := range m.clientCerts {
+		if gatewayID == 0 || certificate.GatewayID == gatewayID {
+			certificates = append(certificates, certificate)
+		}
+	}
+
+	return certificates, nil
+}
+
+// RetireClientCertificate marks an active client certificate of a gateway as retired
+func (m *MockDB) RetireClientCertificate(gatewayID, certificateID int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for i := range m.clientCerts {
+		certificate := &m.clientCerts[i]
+		if certificate.ID == certificateID && certificate.GatewayID == gatewayID && certificate.Status == consts.ClientCertificateActive {
+			certificate.Status = consts.ClientCertificateRetired
+			certificate.RetiredAt = time.Now()
+			return nil
+		}
+	}
+
+	return sql.ErrNoRows
+}
+
+// CreateMerchantCertificate stores a merchant certificate for a wallet
+func (m *MockDB) CreateMerchantCertificate(certificate models.MerchantCertificate) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	certificate.ID = len(m.merchantCerts) + 1
+	if certificate.CreatedAt.IsZero() {
+		certificate.CreatedAt = time.Now()
+	}
+
+	m.merchantCerts = append(m.merchantCerts, certificate)
+
+	return certificate.ID, nil
+}
+
+// GetMerchantCertificates fetches a wallet's merchant certificates, or every wallet's when wallet
+// is empty, oldest first
+func (m *MockDB) GetMerchantCertificates(wallet string) ([]models.MerchantCertificate, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var certificates []models.MerchantCertificate
+	for _, certificate := range m.merchantCerts {
+		if wallet == "" || certificate.Wallet == wallet {
+			certificates = append(certificates, certificate)
+		}
+	}
+
+	return certificates, nil
+}
+
+// RetireMerchantCertificate marks an active merchant certificate as retired
+func (m *MockDB) RetireMerchantCertificate(certificateID int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for i := range m.merchantCerts {
+		certificate := &m.merchantCerts[i]
+		if certificate.ID == certificateID && certificate.Status == consts.MerchantCertificateActive {
+			certificate.Status = consts.MerchantCertificateRetired
+			certificate.RetiredAt = time.Now()
+			return nil
+		}
+	}
+
+	return sql.ErrNoRows
+}
+
+// CreateSigningKey stores a signing key for a gateway
+func (m *MockDB) CreateSigningKey(key models.SigningKey) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, existing := range m.signingKeys {
+		if existing.GatewayID == key.GatewayID && existing.KeyID == key.KeyID {
+			return 0, errors.New("duplicate signing key ID")
+		}
+	}
+
+	key.ID = len(m.signingKeys) + 1
+	if key.CreatedAt.IsZero() {
+		key.CreatedAt = time.Now()
+	}
+
+	m.signingKeys = append(m.signingKeys, key)
+
+	return key.ID, nil
+}
+
+// GetSigningKeys fetches a gateway's signing keys, or every gateway's when gatewayID is 0,
+// oldest first
+func (m *MockDB) GetSigningKeys(gatewayID int) ([]models.SigningKey, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var keys []models.SigningKey
+	for _, key := range m.signingKeys {
+		if gatewayID == 0 || key.GatewayID == gatewayID {
+			keys = append(keys, key)
+		}
+	}
+
+	return keys, nil
+}
+
+// RetireSigningKey marks an active signing key of a gateway as retired
+func (m *MockDB) RetireSigningKey(gatewayID, keyID int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for i := range m.signingKeys {
+		key := &m.signingKeys[i]
+		if key.ID == keyID && key.GatewayID == gatewayID && key.Status == consts.SigningKeyActive {
+			key.Status = consts.SigningKeyRetired
+			key.RetiredAt = time.Now()
+			return nil
+		}
+	}
+
+	return sql.ErrNoRows
+}
+
+// CreateMaintenanceWindow stores a maintenance window for a gateway
+func (m *MockDB) CreateMaintenanceWindow(window models.MaintenanceWindow) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	window.ID = len(m.maintenance) + 1
+	if window.CreatedAt.IsZero() {
+		window.CreatedAt = time.Now()
+	}
+
+	m.maintenance = append(m.maintenance, window)
+
+	return window.ID, nil
+}
+
+// GetMaintenanceWindows fetches the windows that are not cancelled and end after the given
+// time, by start time; a zero gateway ID matches every gateway
+func (m *MockDB) GetMaintenanceWindows(gatewayID int, endingAfter time.Time) ([]models.MaintenanceWindow, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var windows []models.MaintenanceWindow
+	for _, window := range m.maintenance {
+		if (gatewayID == 0 || window.GatewayID == gatewayID) && window.CancelledAt.IsZero() && window.EndsAt.After(endingAfter) {
+			windows = append(windows, window)
+		}
+	}
+
+	sort.SliceStable(windows, func(i, j int) bool { return windows[i].StartsAt.Before(windows[j].StartsAt) })
+	return windows, nil
+}
+
+// CancelMaintenanceWindow marks a gateway's maintenance window as cancelled
+func (m *MockDB) CancelMaintenanceWindow(gatewayID, windowID int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for i := range m.maintenance {
+		window := &m.maintenance[i]
+		if window.ID == windowID && window.GatewayID == gatewayID && window.CancelledAt.IsZero() {
+			window.CancelledAt = time.Now()
+			return nil
+		}
+	}
+
+	return sql.ErrNoRows
+}
+
+// CreateRoutingDecision records how a gateway was selected for a transaction
+func (m *MockDB) CreateRoutingDecision(decision models.RoutingDecision) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	decision.ID = len(m.routingDecisions) + 1
+	if decision.CreatedAt.IsZero() {
+		decision.CreatedAt = time.Now()
+	}
+	decision.ExcludedGatewayIDs = append([]int(nil), decision.ExcludedGatewayIDs...)
+
+	m.routingDecisions = append(m.routingDecisions, decision)
+
+	return decision.ID, nil
+}
+
+// GetRoutingDecisionsByTransaction fetches the routing audit trail for a transaction
+func (m *MockDB) GetRoutingDecisionsByTransaction(txID int) ([]models.RoutingDecision, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var decisions []models.RoutingDecision
+	for _, decision := range m.routingDecisions {
+		if decision.TransactionID == txID {
+			decisions = append(decisions, decision)
+		}
+	}
+
+	return decisions, nil
+}
+
+// CreateTransactionEvent appends an entry to a transaction's audit trail
+func (m *MockDB) CreateTransactionEvent(event models.TransactionEvent) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	event.ID = len(m.transactionEvents) + 1
+	if event.CreatedAt.IsZero() {
+		event.CreatedAt = time.Now()
+	}
+
+	m.transactionEvents = append(m.transactionEvents, event)
+
+	return event.ID, nil
+}
+
+// GetTransactionEvents fetches a transaction's audit trail, oldest first
+func (m *MockDB) GetTransactionEvents(txID int) ([]models.TransactionEvent, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var events []models.TransactionEvent
+	for _, event := range m.transactionEvents {
+		if event.TransactionID == txID {
+			events = append(events, event)
+		}
+	}
+
+	return events, nil
+}
+
+// CreateTransaction creates a new transaction record
+func (m *MockDB) CreateTransaction(transaction models.Transaction) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.insertTransaction(transaction), nil
+}
+
+// CreateWithdrawal creates a withdrawal while its user's wallet balance covers it, returning
+// ErrInsufficientBalance otherwise
+func (m *MockDB) CreateWithdrawal(transaction models.Transaction) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	// Amounts are compared in thousandths, the precision they are stored with
+	if math.Round(m.walletBalance(transaction.UserID, transaction.Currency, transaction.Livemode)*1000) < math.Round(transaction.Amount*1000) {
+		return 0, ErrInsufficientBalance
+	}
+
+	return m.insertTransaction(transaction), nil
+}
+
+// insertTransaction stores a new transaction and returns its ID. Callers hold m.mu.
+func (m *MockDB) insertTransaction(transaction models.Transaction) int {
+	id := m.nextTxID
+	m.nextTxID++
+
+	transaction.ID = id
+	if transaction.CreatedAt.IsZero() {
+		transaction.CreatedAt = time.Now()
+	}
+
+	m.transactions[id] = &transaction
+	return id
+}
+
+// GetTransactionByID gets a transaction by ID
+func (m *MockDB) GetTransactionByID(transactionID int) (*models.Transaction, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	tx, exists := m.transactions[transactionID]
+	if !exists || !tx.DeletedAt.IsZero() {
+		return nil, sql.ErrNoRows
+	}
+
+	// Return a copy to prevent mutation
+	txCopy := *tx
+	return &txCopy, nil
+}
+
+// GetTransactionIDByGatewayReference finds the newest transaction of a gateway with a gateway reference
+func (m *MockDB) GetTransactionIDByGatewayReference(gatewayID int, gatewayReference string) (int, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	txID := 0
+	for id, tx := range m.transactions {
+		if tx.GatewayID == gatewayID && tx.GatewayReference == gatewayReference && tx.DeletedAt.IsZero() && id > txID {
+			txID = id
+		}
+	}
+	if txID == 0 {
+		return 0, sql.ErrNoRows
+	}
+
+	return txID, nil
+}
+
+// SearchTransactions returns the newest transactions matching any of the search criteria
+func (m *MockDB) SearchTransactions(search models.TransactionSearch) ([]models.TransactionSearchResult, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var results []models.TransactionSearchResult
+	for _, tx := range m.transactions {
+		if !tx.DeletedAt.IsZero() {
+			continue
+		}
+
+		var email string
+		if user, exists := m.users[tx.UserID]; exists {
+			email = user.Email
+		}
+
+		matches := (search.TransactionID > 0 && tx.ID == search.TransactionID) ||
+			(search.Amount > 0 && tx.Amount == search.Amount) ||
+			(email != "" && containsHash(search.EmailHashes, email)) ||
+			(tx.ReferenceID != "" && containsHash(search.ReferenceHashes, tx.ReferenceID)) ||
+			(tx.GatewayReference != "" && containsHash(search.ReferenceHashes, tx.GatewayReference)) ||
+			(search.IdempotencyKey != "" && tx.GatewayIdempotencyKey == search.IdempotencyKey)
+		if !matches {
+			continue
+		}
+
+		results = append(results, models.TransactionSearchResult{
+			ID:               tx.ID,
+			Type:             tx.Type,
+			Status:           tx.Status,
+			Amount:           tx.Amount,
+			Currency:         tx.Currency,
+			UserID:           tx.UserID,
+			UserEmail:        email,
+			GatewayID:        tx.GatewayID,
+			CountryID:        tx.CountryID,
+			ReferenceID:      tx.ReferenceID,
+			GatewayReference: tx.GatewayReference,
+			DeclineCode:      tx.DeclineCode,
+			CreatedAt:        tx.CreatedAt,
+		})
+	}
+
+	sort.Slice(results, func(i, j int) bool { return results[i].CreatedAt.After(results[j].CreatedAt) })
+	if search.Limit > 0 && len(results) > search.Limit {
+		results = results[:search.Limit]
+	}
+	return results, nil
+}
+
+// containsHash reports whether the blind index of value is one of hashes
+func containsHash(hashes []string, value string) bool {
+	hash := utils.BlindIndex(value)
+	for _, candidate := range hashes {
+		if candidate == hash {
+			return true
+		}
+	}
+	return false
+}
+
+// UpdateTransactionStatus moves a transaction from one status to another, returning
+// sql.ErrNoRows when it is no longer in the status it was read in
+func (m *MockDB) UpdateTransactionStatus(txID int, from, to, errorMsg string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	tx, exists := m.transactions[txID]
+	if !exists || tx.Status != from {
+		return sql.ErrNoRows
+	}
+
+	tx.Status = to
+	tx.ErrorMessage = errorMsg
+	tx.UpdatedAt = time.Now()
+
+	return nil
+}
+
+// UpdateTransactionGatewayReference records the provider's reference and hosted payment page,
+// leaving either unchanged when empty
+func (m *MockDB) UpdateTransactionGatewayReference(txID int, gatewayReference, redirectURL string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	tx, exists := m.transactions[txID]
+	if !exists {
+		return errors.New("transaction not found")
+	}
+
+	if gatewayReference != "" {
+		tx.GatewayReference = gatewayReference
+	}
+	if redirectURL != "" {
+		tx.RedirectURL = redirectURL
+	}
+	tx.UpdatedAt = time.Now()
+
+	return nil
+}
+
+// UpdateTransactionIdempotencyKey records the idempotency key used for gateway calls
+func (m *MockDB) UpdateTransactionIdempotencyKey(txID int, key string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	tx, exists := m.transactions[txID]
+	if !exists {
+		return errors.New("transaction not found")
+	}
+
+	tx.GatewayIdempotencyKey = key
+	tx.UpdatedAt = time.Now()
+
+	return nil
+}
+
+// GetDeclineCounts counts a merchant's completed and failed transactions created from from until
+// to, grouped by day, gateway, country, BIN range and decline code
+func (m *MockDB) GetDeclineCounts(merchantID int, tag string, from, to time.Time) ([]models.DeclineCount, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	type key struct {
+		day, binRange, declineCode string
+		gatewayID, countryID       int
+	}
+	grouped := make(map[key]int)
+	for _, tx := range m.transactions {
+		user, exists := m.users[tx.UserID]
+		if !exists || user.MerchantID != merchantID || !tx.DeletedAt.IsZero() {
+			continue
+		}
+		if tx.Status != consts.Completed && tx.Status != consts.Failed {
+			continue
+		}
+		if tx.CreatedAt.Before(from) || !tx.CreatedAt.Before(to) {
+			continue
+		}
+		if tag != "" && !m.hasTags(tx.ID, []string{tag}) {
+			continue
+		}
+
+		binRange := tx.CardBIN
+		if len(binRange) > consts.DeclineReportBINLength {
+			binRange = binRange[:consts.DeclineReportBINLength]
+		}
+		grouped[key{tx.CreatedAt.UTC().Format("2006-01-02"), binRange, tx.DeclineCode, tx.GatewayID, tx.CountryID}]++
+	}
+
+	var counts []models.DeclineCount
+	for k, count := range grouped {
+		counts = append(counts, models.DeclineCount{
+			Day:         k.day,
+			GatewayID:   k.gatewayID,
+			CountryID:   k.countryID,
+			BINRange:    k.binRange,
+			DeclineCode: k.declineCode,
+			Count:       count,
+		})
+	}
+	return counts, nil
+}
+
+// GetMerchantTransactions fetches the transactions of a merchant's users created from from until
+// to, in ID order
+func (m *MockDB) GetMerchantTransactions(merchantID int, from, to time.Time) ([]models.Transaction, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var transactions []models.Transaction
+	for _, tx := range m.transactions {
+		user, exists := m.users[tx.UserID]
+		if !exists || user.MerchantID != merchantID || !tx.DeletedAt.IsZero() {
+			continue
+		}
+		if tx.CreatedAt.Before(from) || !tx.CreatedAt.Before(to) {
+			continue
+		}
+		transactions = append(transactions, *tx)
+	}
+
+	sort.Slice(transactions, func(i, j int) bool { return transactions[i].ID < transactions[j].ID })
+	return transactions, nil
+}
+
+// SaveReconciliationFile creates or replaces a reconciliation file
+func (m *MockDB) SaveReconciliationFile(file models.ReconciliationFile) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, exists := m.merchants[file.MerchantID]; !exists {
+		return sql.ErrNoRows
+	}
+
+	m.reconFiles[file.ID] = file
+	return nil
+}
+
+// GetReconciliationFile fetches one of a merchant's reconciliation files
+func (m *MockDB) GetReconciliationFile(merchantID int, fileID string) (*models.ReconciliationFile, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	file, exists := m.reconFiles[fileID]
+	if !exists || file.MerchantID != merchantID {
+		return nil, sql.ErrNoRows
+	}
+	return &file, nil
+}
+
+// ListReconciliationFiles fetches a merchant's reconciliation files for the days from from to to,
+// newest first
+func (m *MockDB) ListReconciliationFiles(merchantID int, from, to string) ([]models.ReconciliationFile, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var files []models.ReconciliationFile
+	for _, file := range m.reconFiles {
+		if file.MerchantID == merchantID && file.Date >= from && file.Date <= to {
+			files = append(files, file)
+		}
+	}
+
+	sort.Slice(files, func(i, j int) bool { return files[i].Date > files[j].Date })
+	return files, nil
+}
+
+// UpdateTransactionDeclineCode records the normalized reason a provider declined a transaction
+func (m *MockDB) UpdateTransactionDeclineCode(txID int, declineCode string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	tx, exists := m.transactions[txID]
+	if !exists {
+		return errors.New("transaction not found")
+	}
+
+	tx.DeclineCode = declineCode
+	tx.UpdatedAt = time.Now()
+
+	return nil
+}
+
+// UpdateTransactionGateway moves a transaction to another gateway
+func (m *MockDB) UpdateTransactionGateway(txID, gatewayID int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	tx, exists := m.transactions[txID]
+	if !exists {
+		return errors.New("transaction not found")
+	}
+
+	tx.GatewayID = gatewayID
+	tx.UpdatedAt = time.Now()
+
+	return nil
+}
+
+// UpdateTransactionSCAExemptionOutcome records the outcome of a transaction's SCA exemption
+func (m *MockDB) UpdateTransactionSCAExemptionOutcome(txID int, outcome string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	tx, exists := m.transactions[txID]
+	if !exists {
+		return errors.New("transaction not found")
+	}
+
+	tx.SCAExemptionOutcome = outcome
+	tx.UpdatedAt = time.Now()
+
+	return nil
+}
+
+// UpdateTransactionCryptoPayment records the payment of a crypto deposit
+func (m *MockDB) UpdateTransactionCryptoPayment(txID int, payment models.CryptoPayment) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	tx, exists := m.transactions[txID]
+	if !exists {
+		return errors.New("transaction not found")
+	}
+
+	tx.Crypto = &payment
+	tx.UpdatedAt = time.Now()
+
+	return nil
+}
+
+// GetInFlightTransactionIDs returns, in ID order, the IDs of up to limit transactions of a gateway
+// still pending or processing with a gateway reference that were created in the given window and
+// have an ID above afterID
+func (m *MockDB) GetInFlightTransactionIDs(gatewayID int, createdAfter, createdBefore time.Time, afterID, limit int) ([]int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var ids []int
+	for _, tx := range m.transactions {
+		if tx.GatewayID == gatewayID && (tx.Status == consts.Pending || tx.Status == consts.Processing) && tx.GatewayReference != "" &&
+			tx.DeletedAt.IsZero() && tx.CreatedAt.After(createdAfter) && tx.CreatedAt.Before(createdBefore) && tx.ID > afterID {
+			ids = append(ids, tx.ID)
+		}
+	}
+	sort.Ints(ids)
+	if len(ids) > limit {
+		ids = ids[:limit]
+	}
+	return ids, nil
+}
+
+// GetStaleTransactionIDs returns the IDs of up to limit transactions still pending or processing
+// that last changed before the given time, least recently changed first
+func (m *MockDB) GetStaleTransactionIDs(changedBefore time.Time, limit int) ([]int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var stale []*models.Transaction
+	for _, tx := range m.transactions {
+		if (tx.Status == consts.Pending || tx.Status == consts.Processing) && tx.DeletedAt.IsZero() && lastChanged(tx).Before(changedBefore) {
+			stale = append(stale, tx)
+		}
+	}
+	sort.Slice(stale, func(i, j int) bool {
+		if !lastChanged(stale[i]).Equal(lastChanged(stale[j])) {
+			return lastChanged(stale[i]).Before(lastChanged(stale[j]))
+		}
+		return stale[i].ID < stale[j].ID
+	})
+	if len(stale) > limit {
+		stale = stale[:limit]
+	}
+
+	ids := make([]int, 0, len(stale))
+	for _, tx := range stale {
+		ids = append(ids, tx.ID)
+	}
+	return ids, nil
+}
+
+// ExpireStaleTransaction fails a transaction still pending or processing that last changed before
+// the given time, returning sql.ErrNoRows when it settled or changed meanwhile
+func (m *MockDB) ExpireStaleTransaction(txID int, changedBefore time.Time, errorMsg, declineCode string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	tx, exists := m.transactions[txID]
+	if !exists || (tx.Status != consts.Pending && tx.Status != consts.Processing) || !tx.DeletedAt.IsZero() || !lastChanged(tx).Before(changedBefore) {
+		return sql.ErrNoRows
+	}
+
+	tx.Status = consts.Failed
+	tx.ErrorMessage = errorMsg
+	tx.DeclineCode = declineCode
+	tx.UpdatedAt = time.Now()
+
+	return nil
+}
+
+// lastChanged is when a transaction was last updated, or created if it never was
+func lastChanged(tx *models.Transaction) time.Time {
+	if tx.UpdatedAt.IsZero() {
+		return tx.CreatedAt
+	}
+	return tx.UpdatedAt
+}
+
+// ExpireUnpaidDeposits fails up to limit deposits of a gateway still pending or processing that
+// were created before the given time, oldest first
+func (m *MockDB) ExpireUnpaidDeposits(gatewayID int, createdBefore time.Time, errorMsg, declineCode string, limit int) ([]int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var unpaid []*models.Transaction
+	for _, tx := range m.transactions {
+		if tx.GatewayID == gatewayID && tx.Type == consts.Deposit && (tx.Status == consts.Pending || tx.Status == consts.Processing) &&
+			tx.DeletedAt.IsZero() && tx.CreatedAt.Before(createdBefore) {
+			unpaid = append(unpaid, tx)
+		}
+	}
+	sort.Slice(unpaid, func(i, j int) bool {
+		if !unpaid[i].CreatedAt.Equal(unpaid[j].CreatedAt) {
+			return unpaid[i].CreatedAt.Before(unpaid[j].CreatedAt)
+		}
+		return unpaid[i].ID < unpaid[j].ID
+	})
+	if len(unpaid) > limit {
+		unpaid = unpaid[:limit]
+	}
+
+	ids := make([]int, 0, len(unpaid))
+	for _, tx := range unpaid {
+		tx.Status = consts.Failed
+		tx.ErrorMessage = errorMsg
+		tx.DeclineCode = declineCode
+		tx.UpdatedAt = time.Now()
+		ids = append(ids, tx.ID)
+	}
+	return ids, nil
+}
+
+// ClaimDueScheduledTransactions moves up to limit scheduled transactions due before the given
+// time to pending, earliest payout first
+func (m *MockDB) ClaimDueScheduledTransactions(before time.Time, limit int) ([]int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var due []*models.Transaction
+	for _, tx := range m.transactions {
+		if tx.Status == consts.Scheduled && tx.DeletedAt.IsZero() && !tx.ScheduledFor.After(before) {
+			due = append(due, tx)
+		}
+	}
+	sort.Slice(due, func(i, j int) bool {
+		if !due[i].ScheduledFor.Equal(due[j].ScheduledFor) {
+			return due[i].ScheduledFor.Before(due[j].ScheduledFor)
+		}
+		return due[i].ID < due[j].ID
+	})
+	if len(due) > limit {
+		due = due[:limit]
+	}
+
+	ids := make([]int, 0, len(due))
+	for _, tx := range due {
+		tx.Status = consts.Pending
+		tx.UpdatedAt = time.Now()
+		ids = append(ids, tx.ID)
+	}
+	return ids, nil
+}
+
+// RescheduleTransaction returns a claimed withdrawal to scheduled, waiting for a later payout,
+// returning sql.ErrNoRows when it is no longer pending
+func (m *MockDB) RescheduleTransaction(txID int, scheduledFor time.Time, expectedSettlementDate string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	tx, exists := m.transactions[txID]
+	if !exists || tx.Status != consts.Pending {
+		return sql.ErrNoRows
+	}
+
+	tx.Status = consts.Scheduled
+	tx.ScheduledFor = scheduledFor
+	tx.ExpectedSettlementDate = expectedSettlementDate
+	tx.UpdatedAt = time.Now()
+
+	return nil
+}
+
+// AuthorizeTransaction records a pending or processing deposit as authorized for its amount
+// until expiresAt, returning sql.ErrNoRows when it is in any other status
+func (m *MockDB) AuthorizeTransaction(txID int, expiresAt time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	tx, exists := m.transactions[txID]
+	if !exists || (tx.Status != consts.Pending && tx.Status != consts.Processing) || !tx.DeletedAt.IsZero() {
+		return sql.ErrNoRows
+	}
+
+	tx.Status = consts.Authorized
+	tx.AuthorizedAmount = tx.Amount
+	tx.AuthorizationExpiresAt = expiresAt
+	tx.UpdatedAt = time.Now()
+
+	return nil
+}
+
+// ClaimAuthorizedTransaction moves an authorized deposit to processing, returning sql.ErrNoRows
+// when it isn't authorized
+func (m *MockDB) ClaimAuthorizedTransaction(txID int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	tx, exists := m.transactions[txID]
+	if !exists || tx.Status != consts.Authorized || !tx.DeletedAt.IsZero() {
+		return sql.ErrNoRows
+	}
+
+	tx.Status = consts.Processing
+	tx.UpdatedAt = time.Now()
+
+	return nil
+}
+
+// CaptureTransaction records the final capture of a claimed authorization, the amount captured by
+// all its captures and its status, returning sql.ErrNoRows when it isn't claimed
+func (m *MockDB) CaptureTransaction(txID int, amount, capturedAmount float64, status string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	tx, exists := m.transactions[txID]
+	if !exists || tx.Status != consts.Processing || !tx.DeletedAt.IsZero() {
+		return sql.ErrNoRows
+	}
+
+	tx.Amount = amount
+	tx.CapturedAmount = capturedAmount
+	tx.Status = status
+	tx.ErrorMessage = ""
+	tx.UpdatedAt = time.Now()
+
+	return nil
+}
+
+// RecordPartialCapture records the amount captured so far of a claimed authorization and returns it
+// to authorized, returning sql.ErrNoRows when it isn't claimed
+func (m *MockDB) RecordPartialCapture(txID int, capturedAmount float64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	tx, exists := m.transactions[txID]
+	if !exists || tx.Status != consts.Processing || !tx.DeletedAt.IsZero() {
+		return sql.ErrNoRows
+	}
+
+	tx.CapturedAmount = capturedAmount
+	tx.Status = consts.Authorized
+	tx.ErrorMessage = ""
+	tx.UpdatedAt = time.Now()
+
+	return nil
+}
+
+// IncrementAuthorization records the new amount held for a claimed authorization and returns it to
+// authorized, returning sql.ErrNoRows when it isn't claimed
+func (m *MockDB) IncrementAuthorization(txID int, amount float64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	tx, exists := m.transactions[txID]
+	if !exists || tx.Status != consts.Processing || !tx.DeletedAt.IsZero() {
+		return sql.ErrNoRows
+	}
+
+	tx.Amount = amount
+	tx.AuthorizedAmount = amount
+	tx.Status = consts.Authorized
+	tx.ErrorMessage = ""
+	tx.UpdatedAt = time.Now()
+
+	return nil
+}
+
+// ExpireAuthorizations voids up to limit authorized deposits whose authorization expired before
+// the given time, earliest expiry first
+func (m *MockDB) ExpireAuthorizations(before time.Time, errorMsg string, limit int) ([]int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var expired []*models.Transaction
+	for _, tx := range m.transactions {
+		if tx.Status == consts.Authorized && tx.DeletedAt.IsZero() && tx.AuthorizationExpiresAt.Before(before) {
+			expired = append(expired, tx)
+		}
+	}
+	sort.Slice(expired, func(i, j int) bool {
+		if !expired[i].AuthorizationExpiresAt.Equal(expired[j].AuthorizationExpiresAt) {
+			return expired[i].AuthorizationExpiresAt.Before(expired[j].AuthorizationExpiresAt)
+		}
+		return expired[i].ID < expired[j].ID
+	})
+	if len(expired) > limit {
+		expired = expired[:limit]
+	}
+
+	ids := make([]int, 0, len(expired))
+	for _, tx := range expired {
+		tx.Status = consts.Voided
+		tx.ErrorMessage = errorMsg
+		tx.UpdatedAt = time.Now()
+		ids = append(ids, tx.ID)
+	}
+	return ids, nil
+}
+
+// SoftDeleteTransaction marks a transaction as deleted
+func (m *MockDB) SoftDeleteTransaction(txID int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	tx, exists := m.transactions[txID]
+	if !exists || !tx.DeletedAt.IsZero() {
+		return errors.New("transaction not found")
+	}
+
+	tx.DeletedAt = time.Now()
+	tx.UpdatedAt = tx.DeletedAt
+
+	return nil
+}
+
+// ArchiveTransactions moves soft-deleted and aged terminal transactions into the archive
+func (m *MockDB) ArchiveTransactions(cutoff time.Time, purgePII bool, limit int) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var archived int64
+	for id, tx := range m.transactions {
+		if archived >= int64(limit) {
+			break
+		}
+
+		aged := (tx.Status == consts.Completed || tx.Status == consts.Failed || tx.Status == consts.Voided) && tx.CreatedAt.Before(cutoff)
+		if tx.DeletedAt.IsZero() && !aged {
+			continue
+		}
+
+		tx.ComplianceFields = nil
+		if purgePII {
+			tx.Beneficiary = ""
+			tx.PhoneNumber = ""
+			tx.PhoneE164 = ""
+			tx.RedirectURL = ""
+			tx.ReturnURL = ""
+			tx.CancelURL = ""
+		}
+
+		m.archived[id] = tx
+		delete(m.transactions, id)
+		archived++
+	}
+
+	if archived > 0 {
+		m.lastArchivedAt = time.Now()
+	}
+
+	return archived, nil
+}
+
+// GetArchivalStats returns the sizes of the hot and archive transaction tables
+func (m *MockDB) GetArchivalStats() (*models.ArchivalStats, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	stats := models.ArchivalStats{
+		HotTransactions:      int64(len(m.transactions)),
+		ArchivedTransactions: int64(len(m.archived)),
+		LastArchivedAt:       m.lastArchivedAt,
+	}
+	for _, tx := range m.transactions {
+		if !tx.DeletedAt.IsZero() {
+			stats.SoftDeletedTransactions++
+		}
+	}
+
+	return &stats, nil
+}
+
+// CreateAPIKey stores a merchant API key
+func (m *MockDB) CreateAPIKey(key models.APIKey) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, existing := range m.apiKeys {
+		if existing.Prefix == key.Prefix {
+			return 0, errors.New("duplicate API key prefix")
+		}
+	}
+
+	key.ID = len(m.apiKeys) + 1
+	if key.CreatedAt.IsZero() {
+		key.CreatedAt = time.Now()
+	}
+
+	m.apiKeys = append(m.apiKeys, key)
+
+	return key.ID, nil
+}
+
+// GetAPIKeyByPrefix fetches an API key by its public prefix
+func (m *MockDB) GetAPIKeyByPrefix(prefix string) (*models.APIKey, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	for _, key := range m.apiKeys {
+		if key.Prefix == prefix {
+			return &key, nil
+		}
+	}
+
+	return nil, sql.ErrNoRows
+}
+
+// GetAPIKeysByMerchant fetches a merchant's API keys, oldest first
+func (m *MockDB) GetAPIKeysByMerchant(merchantID int) ([]models.APIKey, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var keys []models.APIKey
+	for _, key := range m.apiKeys {
+		if key.MerchantID == merchantID {
+			keys = append(keys, key)
+		}
+	}
+
+	return keys, nil
+}
+
+// RevokeAPIKey revokes an active API key of a merchant
+func (m *MockDB) RevokeAPIKey(merchantID, keyID int) error {
+	return m.updateActiveAPIKey(merchantID, keyID, func(key *models.APIKey) {
+		key.Status = consts.APIKeyRevoked
+		key.RevokedAt = time.Now()
+	})
+}
+
+// ExpireAPIKey schedules an active API key of a merchant to stop working
+func (m *MockDB) ExpireAPIKey(merchantID, keyID int, expiresAt time.Time) error {
+	return m.updateActiveAPIKey(merchantID, keyID, func(key *models.APIKey) {
+		key.ExpiresAt = expiresAt
+	})
+}
+
+// TouchAPIKey records when an API key was last used
+func (m *MockDB) TouchAPIKey(keyID int, usedAt time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if keyID < 1 || keyID > len(m.apiKeys) {
+		return sql.ErrNoRows
+	}
+	m.apiKeys[keyID-1].LastUsedAt = usedAt
+
+	return nil
+}
+
+// updateActiveAPIKey applies update to an active API key of a merchant
+func (m *MockDB) updateActiveAPIKey(merchantID, keyID int, update func(*models.APIKey)) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for i := range m.apiKeys {
+		key := &m.apiKeys[i]
+		if key.ID == keyID && key.MerchantID == merchantID && key.Status == consts.APIKeyActive {
+			update(key)
+			return nil
+		}
+	}
+
+	return sql.ErrNoRows
+}
+
+// CreateOAuthClient stores an OAuth2 client
+func (m *MockDB) CreateOAuthClient(client models.OAuthClient) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, existing := range m.oauthClients {
+		if existing.ClientID == client.ClientID {
+			return 0, errors.New("duplicate OAuth client ID")
+		}
+	}
+
+	client.ID = len(m.oauthClients) + 1
+	if client.CreatedAt.IsZero() {
+		client.CreatedAt = time.Now()
+	}
+	client.Scopes = append([]string(nil), client.Scopes...)
+
+	m.oauthClients = append(m.oauthClients, client)
+
+	return client.ID, nil
+}
+
+// GetOAuthClientByClientID fetches an OAuth2 client by its client ID
+func (m *MockDB) GetOAuthClientByClientID(clientID string) (*models.OAuthClient, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	for _, client := range m.oauthClients {
+		if client.ClientID == clientID {
+			client.Scopes = append([]string(nil), client.Scopes...)
+			return &client, nil
+		}
+	}
+
+	return nil, sql.ErrNoRows
+}
+
+// CreateCallbackRecord stores a callback as received
+func (m *MockDB) CreateCallbackRecord(record models.CallbackRecord) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	record.ID = len(m.callbacks) + 1
+	if record.CreatedAt.IsZero() {
+		record.CreatedAt = time.Now()
+	}
+
+	m.callbacks = append(m.callbacks, record)
+
+	return record.ID, nil
+}
+
+// GetCallbackRecordByID fetches a stored callback
+func (m *MockDB) GetCallbackRecordByID(recordID int) (*models.CallbackRecord, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	if recordID < 1 || recordID > len(m.callbacks) {
+		return nil, sql.ErrNoRows
+	}
+
+	record := m.callbacks[recordID-1]
+	return &record, nil
+}
+
+// UpdateCallbackRecord records the outcome of parsing and processing a stored callback
+func (m *MockDB) UpdateCallbackRecord(recordID int, status, errorMsg string, transactionID int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if recordID < 1 || recordID > len(m.callbacks) {
+		return sql.ErrNoRows
+	}
+
+	record := &m.callbacks[recordID-1]
+	record.Status = status
+	record.Error = errorMsg
+	if transactionID > 0 {
+		record.TransactionID = transactionID
+	}
+	record.Attempts++
+	record.ProcessedAt = time.Now()
+
+	return nil
+}
+
+// CreateOutboxMessages records outbox messages, skipping dedup tokens already recorded for the same destination
+func (m *MockDB) CreateOutboxMessages(messages []models.OutboxMessage) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now()
+	for _, msg := range messages {
+		duplicate := false
+		for _, existing := range m.outbox {
+			if existing.Destination == msg.Destination && existing.EndpointID == msg.EndpointID && existing.DedupToken == msg.DedupToken {
+				duplicate = true
+				break
+			}
+		}
+		if duplicate {
+			continue
+		}
+
+		msg.ID = len(m.outbox) + 1
+		msg.Status = consts.OutboxPending
+		msg.NextAttemptAt = now
+		msg.CreatedAt = now
+		m.outbox = append(m.outbox, msg)
+	}
+
+	return nil
+}
+
+// GetPendingOutboxMessages returns pending messages for a destination that are due for delivery
+func (m *MockDB) GetPendingOutboxMessages(destination string, now time.Time, limit int) ([]models.OutboxMessage, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var messages []models.OutboxMessage
+	for _, msg := range m.outbox {
+		if len(messages) >= limit {
+			break
+		}
+		if msg.Destination == destination && msg.Status == consts.OutboxPending && !msg.NextAttemptAt.After(now) {
+			messages = append(messages, msg)
+		}
+	}
+
+	return messages, nil
+}
+
+// MarkOutboxMessageDelivered marks an outbox message as delivered
+func (m *MockDB) MarkOutboxMessageDelivered(messageID int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if messageID < 1 || messageID > len(m.outbox) {
+		return sql.ErrNoRows
+	}
+
+	msg := &m.outbox[messageID-1]
+	msg.Status = consts.OutboxDelivered
+	msg.Attempts++
+	msg.LastError = ""
+	msg.DeliveredAt = time.Now()
+
+	return nil
+}
+
+// MarkOutboxMessageRetry records a failed delivery attempt
+func (m *MockDB) MarkOutboxMessageRetry(messageID int, errorMsg string, nextAttemptAt time.Time, failed bool) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if messageID < 1 || messageID > len(m.outbox) {
+		return sql.ErrNoRows
+	}
+
+	msg := &m.outbox[messageID-1]
+	msg.Attempts++
+	msg.LastError = errorMsg
+	msg.NextAttemptAt = nextAttemptAt
+	if failed {
+		msg.Status = consts.OutboxFailed
+	}
+
+	return nil
+}
+
+// GetOutboxMessage returns an outbox message by ID
+func (m *MockDB) GetOutboxMessage(messageID int) (*models.OutboxMessage, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	if messageID < 1 || messageID > len(m.outbox) {
+		return nil, sql.ErrNoRows
+	}
+
+	msg := m.outbox[messageID-1]
+	return &msg, nil
+}
+
+// ListOutboxMessages returns up to limit messages with the given status; an empty destination matches all
+func (m *MockDB) ListOutboxMessages(destination, status string, limit int) ([]models.OutboxMessage, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var messages []models.OutboxMessage
+	for _, msg := range m.outbox {
+		if len(messages) >= limit {
+			break
+		}
+		if (destination == "" || msg.Destination == destination) && msg.Status == status {
+			messages = append(messages, msg)
+		}
+	}
+
+	return messages, nil
+}
+
+// RequeueOutboxMessage makes a pending or failed outbox message due again with its attempts reset
+func (m *MockDB) RequeueOutboxMessage(messageID int, nextAttemptAt time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	msg, ok := m.undeliveredOutboxMessage(messageID)
+	if !ok {
+		return sql.ErrNoRows
+	}
+
+	msg.Status = consts.OutboxPending
+	msg.Attempts = 0
+	msg.NextAttemptAt = nextAttemptAt
+
+	return nil
+}
+
+// DiscardOutboxMessage stops delivery of a pending or failed outbox message
+func (m *MockDB) DiscardOutboxMessage(messageID int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	msg, ok := m.undeliveredOutboxMessage(messageID)
+	if !ok {
+		return sql.ErrNoRows
+	}
+
+	msg.Status = consts.OutboxDiscarded
+
+	return nil
+}
+
+// undeliveredOutboxMessage returns a pending or failed outbox message; the caller must hold m.mu
+func (m *MockDB) undeliveredOutboxMessage(messageID int) (*models.OutboxMessage, bool) {
+	if messageID < 1 || messageID > len(m.outbox) {
+		return nil, false
+	}
+
+	msg := &m.outbox[messageID-1]
+	if msg.Status != consts.OutboxPending && msg.Status != consts.OutboxFailed {
+		return nil, false
+	}
+	return msg, true
+}
+
+// CreateIdempotencyKey claims an idempotency key for a request in progress, returning sql.ErrNoRows
+// when the key was already claimed
+func (m *MockDB) CreateIdempotencyKey(key models.IdempotencyKey) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	id := key.Caller + " " + key.Route + " " + key.Key
+	if _, exists := m.idempotencyKeys[id]; exists {
+		return sql.ErrNoRows
+	}
+	m.idempotencyKeys[id] = &key
+
+	return nil
+}
+
+// GetIdempotencyKey fetches an idempotency key and the response recorded for it
+func (m *MockDB) GetIdempotencyKey(caller, route, key string) (*models.IdempotencyKey, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	record, exists := m.idempotencyKeys[caller+" "+route+" "+key]
+	if !exists {
+		return nil, sql.ErrNoRows
+	}
+	found := *record
+	return &found, nil
+}
+
+// CompleteIdempotencyKey records the response to an idempotency key's request, returning
+// sql.ErrNoRows when the key is no longer claimed
+func (m *MockDB) CompleteIdempotencyKey(caller, route, key string, statusCode int, contentType string, body []byte, completedAt time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	record, exists := m.idempotencyKeys[caller+" "+route+" "+key]
+	if !exists || !record.CompletedAt.IsZero() {
+		return sql.ErrNoRows
+	}
+	record.StatusCode = statusCode
+	record.ContentType = contentType
+	record.ResponseBody = append([]byte(nil), body...)
+	record.CompletedAt = completedAt
+
+	return nil
+}
+
+// DeleteIdempotencyKey deletes an idempotency key claimed at createdAt
+func (m *MockDB) DeleteIdempotencyKey(caller, route, key string, createdAt time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	id := caller + " " + route + " " + key
+	if record, exists := m.idempotencyKeys[id]; exists && record.CreatedAt.Equal(createdAt) {
+		delete(m.idempotencyKeys, id)
+	}
+
+	return nil
+}
+
+// PurgeIdempotencyKeys deletes up to limit idempotency keys that expired before
+func (m *MockDB) PurgeIdempotencyKeys(before time.Time, limit int) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	purged := 0
+	for id, record := range m.idempotencyKeys {
+		if purged == limit {
+			break
+		}
+		if record.ExpiresAt.Before(before) {
+			delete(m.idempotencyKeys, id)
+			purged++
+		}
+	}
+
+	return purged, nil
+}
+
+// CreateAMLCase stores an AML case, rejecting a second case of the same transaction
+func (m *MockDB) CreateAMLCase(amlCase models.AMLCase) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, existing := range m.amlCases {
+		if existing.TransactionID == amlCase.TransactionID {
+			return 0, errors.New("transaction already has an AML case")
+		}
+	}
+
+	amlCase.ID = len(m.amlCases) + 1
+	amlCase.UpdatedAt = amlCase.CreatedAt
+	m.amlCases = append(m.amlCases, amlCase)
+
+	return amlCase.ID, nil
+}
+
+// GetAMLCaseByID fetches an AML case
+func (m *MockDB) GetAMLCaseByID(caseID int) (*models.AMLCase, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	if caseID < 1 || caseID > len(m.amlCases) {
+		return nil, sql.ErrNoRows
+	}
+
+	amlCase := m.amlCases[caseID-1]
+	return &amlCase, nil
+}
+
+// ListAMLCases returns up to limit AML cases with the given status, oldest first
+func (m *MockDB) ListAMLCases(status string, limit int) ([]models.AMLCase, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var cases []models.AMLCase
+	for _, amlCase := range m.amlCases {
+		if len(cases) >= limit {
+			break
+		}
+		if amlCase.Status == status {
+			cases = append(cases, amlCase)
+		}
+	}
+
+	return cases, nil
+}
+
+// UpdateAMLCaseStatus moves an open or in-review AML case to a new status
+func (m *MockDB) UpdateAMLCaseStatus(caseID int, status, note string, updatedAt time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if caseID < 1 || caseID > len(m.amlCases) {
+		return sql.ErrNoRows
+	}
+
+	amlCase := &m.amlCases[caseID-1]
+	if amlCase.Status != consts.AMLCaseOpen && amlCase.Status != consts.AMLCaseInReview {
+		return sql.ErrNoRows
+	}
+
+	amlCase.Status = status
+	amlCase.Note = note
+	amlCase.UpdatedAt = updatedAt
+
+	return nil
+}
+
+// CreateScreeningCase stores a screening case
+func (m *MockDB) CreateScreeningCase(screeningCase models.ScreeningCase) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	screeningCase.ID = len(m.screeningCases) + 1
+	screeningCase.UpdatedAt = screeningCase.CreatedAt
+	m.screeningCases = append(m.screeningCases, screeningCase)
+
+	return screeningCase.ID, nil
+}
+
+// GetScreeningCaseByID fetches a screening case
+func (m *MockDB) GetScreeningCaseByID(caseID int) (*models.ScreeningCase, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	if caseID < 1 || caseID > len(m.screeningCases) {
+		return nil, sql.ErrNoRows
+	}
+
+	screeningCase := m.screeningCases[caseID-1]
+	return &screeningCase, nil
+}
+
+// ListScreeningCases returns up to limit screening cases with the given status, oldest first
+func (m *MockDB) ListScreeningCases(status string, limit int) ([]models.ScreeningCase, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var cases []models.ScreeningCase
+	for _, screeningCase := range m.screeningCases {
+		if len(cases) >= limit {
+			break
+		}
+		if screeningCase.Status == status {
+			cases = append(cases, screeningCase)
+		}
+	}
+
+	return cases, nil
+}
+
+// ListUserScreeningCases returns a user's screening cases, newest first
+func (m *MockDB) ListUserScreeningCases(userID int) ([]models.ScreeningCase, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var cases []models.ScreeningCase
+	for i := len(m.screeningCases) - 1; i >= 0; i-- {
+		if m.screeningCases[i].UserID == userID {
+			cases = append(cases, m.screeningCases[i])
+		}
+	}
+
+	return cases, nil
+}
+
+// DecideScreeningCase closes an open screening case
+func (m *MockDB) DecideScreeningCase(caseID int, status, reviewer, note string, updatedAt time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if caseID < 1 || caseID > len(m.screeningCases) {
+		return sql.ErrNoRows
+	}
+
+	screeningCase := &m.screeningCases[caseID-1]
+	if screeningCase.Status != consts.ScreeningCaseOpen {
+		return sql.ErrNoRows
+	}
+
+	screeningCase.Status = status
+	screeningCase.Reviewer = reviewer
+	screeningCase.Note = note
+	screeningCase.UpdatedAt = updatedAt
+
+	return nil
+}
+
+// CreateScreeningEvent appends an entry to a user's screening audit trail
+func (m *MockDB) CreateScreeningEvent(event models.ScreeningEvent) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	event.ID = len(m.screeningEvents) + 1
+	m.screeningEvents = append(m.screeningEvents, event)
+
+	return nil
+}
+
+// ListScreeningEvents returns a user's screening audit trail, oldest first
+func (m *MockDB) ListScreeningEvents(userID int) ([]models.ScreeningEvent, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var events []models.ScreeningEvent
+	for _, event := range m.screeningEvents {
+		if event.UserID == userID {
+			events = append(events, event)
+		}
+	}
+
+	return events, nil
+}
+
+// CreateDispute stores a dispute, rejecting a second dispute of the same transaction
+func (m *MockDB) CreateDispute(dispute models.Dispute) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, existing := range m.disputes {
+		if existing.TransactionID == dispute.TransactionID {
+			return 0, errors.New("transaction already disputed")
+		}
+	}
+
+	dispute.ID = len(m.disputes) + 1
+	dispute.UpdatedAt = dispute.CreatedAt
+	m.disputes = append(m.disputes, dispute)
+
+	return dispute.ID, nil
+}
+
+// GetDisputeByID fetches a dispute
+func (m *MockDB) GetDisputeByID(disputeID int) (*models.Dispute, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	if disputeID < 1 || disputeID > len(m.disputes) {
+		return nil, sql.ErrNoRows
+	}
+
+	dispute := m.disputes[disputeID-1]
+	return &dispute, nil
+}
+
+// GetDisputeByTransaction fetches the dispute of a transaction
+func (m *MockDB) GetDisputeByTransaction(txID int) (*models.Dispute, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	for _, dispute := range m.disputes {
+		if dispute.TransactionID == txID {
+			return &dispute, nil
+		}
+	}
+	return nil, sql.ErrNoRows
+}
+
+// ListDisputes returns up to limit disputes with the given status, oldest first
+func (m *MockDB) ListDisputes(status string, limit int) ([]models.Dispute, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var disputes []models.Dispute
+	for _, dispute := range m.disputes {
+		if len(disputes) >= limit {
+			break
+		}
+		if dispute.Status == status {
+			disputes = append(disputes, dispute)
+		}
+	}
+
+	return disputes, nil
+}
+
+// UpdateDisputeStatus moves an open or in-review dispute to a new status
+func (m *MockDB) UpdateDisputeStatus(disputeID int, status, resolution string, updatedAt time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if disputeID < 1 || disputeID > len(m.disputes) {
+		return sql.ErrNoRows
+	}
+
+	dispute := &m.disputes[disputeID-1]
+	if dispute.Status != consts.DisputeOpen && dispute.Status != consts.DisputeInReview {
+		return sql.ErrNoRows
+	}
+
+	dispute.Status = status
+	dispute.Resolution = resolution
+	dispute.UpdatedAt = updatedAt
+
+	return nil
+}
+
+// CreateTransactionHold places a hold on a deposit, returning sql.ErrNoRows when the deposit is
+// already held
+func (m *MockDB) CreateTransactionHold(hold models.TransactionHold) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, existing := range m.holds {
+		if existing.TransactionID == hold.TransactionID && existing.Status == consts.HoldActive {
+			return 0, sql.ErrNoRows
+		}
+	}
+
+	hold.ID = len(m.holds) + 1
+	hold.UpdatedAt = hold.CreatedAt
+	m.holds = append(m.holds, hold)
+
+	return hold.ID, nil
+}
+
+// GetTransactionHold fetches the latest hold placed on a transaction
+func (m *MockDB) GetTransactionHold(txID int) (*models.TransactionHold, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	for i := len(m.holds) - 1; i >= 0; i-- {
+		if m.holds[i].TransactionID == txID {
+			hold := m.holds[i]
+			return &hold, nil
+		}
+	}
+	return nil, sql.ErrNoRows
+}
+
+// ListTransactionHolds returns up to limit transaction holds with the given status, oldest first
+func (m *MockDB) ListTransactionHolds(status string, limit int) ([]models.TransactionHold, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var holds []models.TransactionHold
+	for _, hold := range m.holds {
+		if len(holds) >= limit {
+			break
+		}
+		if hold.Status == status {
+			holds = append(holds, hold)
+		}
+	}
+
+	return holds, nil
+}
+
+// ReleaseTransactionHold releases a transaction's active hold
+func (m *MockDB) ReleaseTransactionHold(txID int, note string, releasedAt time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	hold, err := m.activeHold(txID)
+	if err != nil {
+		return err
+	}
+	hold.Status = consts.HoldReleased
+	hold.ReleaseNote = note
+	hold.ReleasedAt = releasedAt
+	hold.UpdatedAt = releasedAt
+
+	return nil
+}
+
+// ExtendTransactionHold moves the deadline of a transaction's active hold
+func (m *MockDB) ExtendTransactionHold(txID int, releaseAt, updatedAt time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	hold, err := m.activeHold(txID)
+	if err != nil {
+		return err
+	}
+	hold.ReleaseAt = releaseAt
+	hold.UpdatedAt = updatedAt
+
+	return nil
+}
+
+// activeHold returns a transaction's active hold, or sql.ErrNoRows. Callers hold m.mu.
+func (m *MockDB) activeHold(txID int) (*models.TransactionHold, error) {
+	for i := range m.holds {
+		if m.holds[i].TransactionID == txID && m.holds[i].Status == consts.HoldActive {
+			return &m.holds[i], nil
+		}
+	}
+	return nil, sql.ErrNoRows
+}
+
+// ExpireTransactionHolds releases up to limit active holds whose deadline is before the given
+// time, returning the IDs of the transactions they held
+func (m *MockDB) ExpireTransactionHolds(before time.Time, limit int) ([]int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var ids []int
+	for i := range m.holds {
+		hold := &m.holds[i]
+		if len(ids) >= limit {
+			break
+		}
+		if hold.Status != consts.HoldActive || hold.ReleaseAt.After(before) {
+			continue
+		}
+		hold.Status = consts.HoldExpired
+		hold.ReleasedAt = before
+		hold.UpdatedAt = before
+		ids = append(ids, hold.TransactionID)
+	}
+
+	return ids, nil
+}
+
+// GetHeldAmount sums the active holds on a user's deposits in a currency and mode
+func (m *MockDB) GetHeldAmount(userID int, currency string, livemode bool) (float64, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	return m.heldAmount(userID, currency, livemode), nil
+}
+
+// heldAmount sums a user's active holds. Callers hold m.mu.
+func (m *MockDB) heldAmount(userID int, currency string, livemode bool) float64 {
+	held := 0.0
+	for _, hold := range m.holds {
+		if hold.UserID == userID && hold.Currency == currency && hold.Livemode == livemode && hold.Status == consts.HoldActive {
+			held += hold.Amount
+		}
+	}
+	return held
+}
+
+// CreatePaymentConsent stores a payment consent, rejecting a second consent of the same transaction
+func (m *MockDB) CreatePaymentConsent(consent models.PaymentConsent) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, existing := range m.consents {
+		if existing.TransactionID == consent.TransactionID || existing.StateHash == consent.StateHash {
+			return 0, errors.New("duplicate payment consent")
+		}
+	}
+
+	consent.ID = len(m.consents) + 1
+	consent.UpdatedAt = consent.CreatedAt
+	m.consents = append(m.consents, consent)
+
+	return consent.ID, nil
+}
+
+// GetPaymentConsentByState fetches the payment consent whose state hashes to stateHash
+func (m *MockDB) GetPaymentConsentByState(stateHash string) (*models.PaymentConsent, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	for _, consent := range m.consents {
+		if consent.StateHash == stateHash {
+			return &consent, nil
+		}
+	}
+	return nil, sql.ErrNoRows
+}
+
+// GetPaymentConsentByTransaction fetches the payment consent of a transaction
+func (m *MockDB) GetPaymentConsentByTransaction(txID int) (*models.PaymentConsent, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	for _, consent := range m.consents {
+		if consent.TransactionID == txID {
+			return &consent, nil
+		}
+	}
+	return nil, sql.ErrNoRows
+}
+
+// ListExpiredPaymentConsents returns up to limit consents still awaiting authorisation after they expired
+func (m *MockDB) ListExpiredPaymentConsents(now time.Time, limit int) ([]models.PaymentConsent, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var consents []models.PaymentConsent
+	for _, consent := range m.consents {
+		if len(consents) >= limit {
+			break
+		}
+		if consent.Status == consts.ConsentAwaitingAuthorisation && consent.ExpiresAt.Before(now) {
+			consents = append(consents, consent)
+		}
+	}
+
+	return consents, nil
+}
+
+// UpdatePaymentConsentStatus moves a payment consent awaiting authorisation to a new status
+func (m *MockDB) UpdatePaymentConsentStatus(consentID int, status string, updatedAt time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if consentID < 1 || consentID > len(m.consents) {
+		return sql.ErrNoRows
+	}
+
+	consent := &m.consents[consentID-1]
+	if consent.Status != consts.ConsentAwaitingAuthorisation {
+		return sql.ErrNoRows
+	}
+
+	consent.Status = status
+	consent.UpdatedAt = updatedAt
+
+	return nil
+}
+
+// CreateBankAccountConsent stores a bank account consent, rejecting a second consent with the same state
+func (m *MockDB) CreateBankAccountConsent(consent models.BankAccountConsent) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, existing := range m.accountConsents {
+		if existing.StateHash == consent.StateHash {
+			return 0, errors.New("duplicate bank account consent")
+		}
+	}
+
+	consent.ID = len(m.accountConsents) + 1
+	consent.UpdatedAt = consent.CreatedAt
+	m.accountConsents = append(m.accountConsents, consent)
+
+	return consent.ID, nil
+}
+
+// GetBankAccountConsentByState fetches the bank account consent whose state hashes to stateHash
+func (m *MockDB) GetBankAccountConsentByState(stateHash string) (*models.BankAccountConsent, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	for _, consent := range m.accountConsents {
+		if consent.StateHash == stateHash {
+			return &consent, nil
+		}
+	}
+	return nil, sql.ErrNoRows
+}
+
+// UpdateBankAccountConsentStatus moves a bank account consent awaiting authorisation to a new status
+func (m *MockDB) UpdateBankAccountConsentStatus(consentID int, status string, updatedAt time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if consentID < 1 || consentID > len(m.accountConsents) {
+		return sql.ErrNoRows
+	}
+
+	consent := &m.accountConsents[consentID-1]
+	if consent.Status != consts.ConsentAwaitingAuthorisation {
+		return sql.ErrNoRows
+	}
+
+	consent.Status = status
+	consent.UpdatedAt = updatedAt
+
+	return nil
+}
+
+// StoreBankAccountConsentTokens stores the tokens an authorised bank account consent granted
+func (m *MockDB) StoreBankAccountConsentTokens(consentID int, accessToken, refreshToken string, expiresAt time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if consentID < 1 || consentID > len(m.accountConsents) {
+		return sql.ErrNoRows
+	}
+
+	consent := &m.accountConsents[consentID-1]
+	consent.AccessToken = accessToken
+	consent.RefreshToken = refreshToken
+	consent.TokenExpiresAt = expiresAt
+
+	return nil
+}
+
+// SaveBankAccount stores a linked bank account, updating and reactivating an account the user
+// linked before through the same gateway
+func (m *MockDB) SaveBankAccount(account models.BankAccount) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	account.UpdatedAt = account.CreatedAt
+	for i, existing := range m.bankAccounts {
+		if existing.UserID == account.UserID && existing.GatewayID == account.GatewayID && existing.ExternalID == account.ExternalID {
+			account.ID = existing.ID
+			account.CreatedAt = existing.CreatedAt
+			m.bankAccounts[i] = account
+			return account.ID, nil
+		}
+	}
+
+	account.ID = len(m.bankAccounts) + 1
+	m.bankAccounts = append(m.bankAccounts, account)
+
+	return account.ID, nil
+}
+
+// GetBankAccountByID fetches a linked bank account
+func (m *MockDB) GetBankAccountByID(accountID int) (*models.BankAccount, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	if accountID < 1 || accountID > len(m.bankAccounts) {
+		return nil, sql.ErrNoRows
+	}
+	account := m.bankAccounts[accountID-1]
+	return &account, nil
+}
+
+// ListBankAccounts returns the bank accounts a user linked, oldest first
+func (m *MockDB) ListBankAccounts(userID int) ([]models.BankAccount, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var accounts []models.BankAccount
+	for _, account := range m.bankAccounts {
+		if account.UserID == userID {
+			accounts = append(accounts, account)
+		}
+	}
+	return accounts, nil
+}
+
+// RevokeBankAccount marks an active linked bank account revoked
+func (m *MockDB) RevokeBankAccount(accountID int, updatedAt time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if accountID < 1 || accountID > len(m.bankAccounts) {
+		return sql.ErrNoRows
+	}
+
+	account := &m.bankAccounts[accountID-1]
+	if account.Status != consts.BankAccountActive {
+		return sql.ErrNoRows
+	}
+
+	account.Status = consts.BankAccountRevoked
+	account.UpdatedAt = updatedAt
+
+	return nil
+}
+
+// CreateMandate stores a SEPA mandate
+func (m *MockDB) CreateMandate(mandate models.Mandate) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, existing := range m.mandates {
+		if existing.Reference == mandate.Reference {
+			return 0, errors.New("duplicate mandate reference")
+		}
+	}
+
+	mandate.ID = len(m.mandates) + 1
+	mandate.UpdatedAt = mandate.CreatedAt
+	m.mandates = append(m.mandates, mandate)
+
+	return mandate.ID, nil
+}
+
+// GetMandateByID fetches a SEPA mandate
+func (m *MockDB) GetMandateByID(mandateID int) (*models.Mandate, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	if mandateID < 1 || mandateID > len(m.mandates) {
+		return nil, sql.ErrNoRows
+	}
+	mandate := m.mandates[mandateID-1]
+	return &mandate, nil
+}
+
+// ListMandates returns a user's SEPA mandates, oldest first
+func (m *MockDB) ListMandates(userID int) ([]models.Mandate, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var mandates []models.Mandate
+	for _, mandate := range m.mandates {
+		if mandate.UserID == userID {
+			mandates = append(mandates, mandate)
+		}
+	}
+	return mandates, nil
+}
+
+// UpdateMandateSequenceType sets the sequence type of a SEPA mandate's next debit
+func (m *MockDB) UpdateMandateSequenceType(mandateID int, sequenceType string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if mandateID < 1 || mandateID > len(m.mandates) {
+		return sql.ErrNoRows
+	}
+
+	mandate := &m.mandates[mandateID-1]
+	mandate.SequenceType = sequenceType
+	mandate.UpdatedAt = time.Now()
+
+	return nil
+}
+
+// RevokeMandate marks an active SEPA mandate revoked
+func (m *MockDB) RevokeMandate(mandateID int, reason string, updatedAt time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if mandateID < 1 || mandateID > len(m.mandates) {
+		return sql.ErrNoRows
+	}
+
+	mandate := &m.mandates[mandateID-1]
+	if mandate.Status != consts.MandateActive {
+		return sql.ErrNoRows
+	}
+
+	mandate.Status = consts.MandateRevoked
+	mandate.RevocationReason = reason
+	mandate.UpdatedAt = updatedAt
+
+	return nil
+}
+
+// CreateAutoReloadRule stores an auto-reload rule
+func (m *MockDB) CreateAutoReloadRule(rule models.AutoReloadRule) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	rule.ID = len(m.autoReloadRules) + 1
+	rule.UpdatedAt = rule.CreatedAt
+	m.autoReloadRules = append(m.autoReloadRules, rule)
+
+	return rule.ID, nil
+}
+
+// GetAutoReloadRuleByID fetches an auto-reload rule
+func (m *MockDB) GetAutoReloadRuleByID(ruleID int) (*models.AutoReloadRule, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	if ruleID < 1 || ruleID > len(m.autoReloadRules) {
+		return nil, sql.ErrNoRows
+	}
+	rule := m.autoReloadRules[ruleID-1]
+	return &rule, nil
+}
+
+// ListAutoReloadRules returns a user's auto-reload rules, oldest first
+func (m *MockDB) ListAutoReloadRules(userID int) ([]models.AutoReloadRule, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var rules []models.AutoReloadRule
+	for _, rule := range m.autoReloadRules {
+		if rule.UserID == userID {
+			rules = append(rules, rule)
+		}
+	}
+	return rules, nil
+}
+
+// ClaimAutoReloadRules claims up to limit active auto-reload rules not claimed since claimedBefore
+func (m *MockDB) ClaimAutoReloadRules(now, claimedBefore time.Time, limit int) ([]models.AutoReloadRule, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var rules []models.AutoReloadRule
+	for i := range m.autoReloadRules {
+		rule := &m.autoReloadRules[i]
+		if len(rules) == limit {
+			break
+		}
+		if rule.Status != consts.AutoReloadActive || (!m.autoReloadClaims[rule.ID].IsZero() && !m.autoReloadClaims[rule.ID].Before(claimedBefore)) {
+			continue
+		}
+		m.autoReloadClaims[rule.ID] = now
+		rules = append(rules, *rule)
+	}
+	return rules, nil
+}
+
+// UpdateAutoReloadRule saves an auto-reload rule's status and reload state; a cancelled rule
+// stays cancelled
+func (m *MockDB) UpdateAutoReloadRule(rule models.AutoReloadRule) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if rule.ID < 1 || rule.ID > len(m.autoReloadRules) {
+		return sql.ErrNoRows
+	}
+
+	stored := &m.autoReloadRules[rule.ID-1]
+	if stored.Status != consts.AutoReloadCancelled {
+		stored.Status = rule.Status
+	}
+	stored.DisabledReason = rule.DisabledReason
+	stored.ConsecutiveFailures = rule.ConsecutiveFailures
+	stored.LastTransactionID = rule.LastTransactionID
+	stored.LastReloadStatus = rule.LastReloadStatus
+	stored.LastReloadAt = rule.LastReloadAt
+	stored.WindowStart = rule.WindowStart
+	stored.WindowReloads = rule.WindowReloads
+	stored.UpdatedAt = rule.UpdatedAt
+
+	return nil
+}
+
+// GetWalletBalance sums a user's wallet ledger in a currency and mode
+func (m *MockDB) GetWalletBalance(userID int, currency string, livemode bool) (float64, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	return m.walletBalance(userID, currency, livemode), nil
+}
+
+// walletBalance adds a user's completed deposits and transfers received, archived or not, and
+// takes away their withdrawals and refunds that have not failed, transfers sent and active holds.
+// Callers hold m.mu.
+func (m *MockDB) walletBalance(userID int, currency string, livemode bool) float64 {
+	balance := 0.0
+	for _, transactions := range []map[int]*models.Transaction{m.transactions, m.archived} {
+		for _, tx := range transactions {
+			if tx.UserID != userID || tx.Currency != currency || tx.Livemode != livemode {
+				continue
+			}
+			switch {
+			case tx.Type == consts.Deposit && tx.Status == consts.Completed:
+				balance += tx.Amount
+			case tx.Type == consts.Withdrawal && tx.Status != consts.Failed:
+				balance -= tx.Amount
+			}
+		}
+	}
+	for _, transfer := range m.transfers {
+		if transfer.Status != consts.Completed || transfer.Currency != currency || transfer.Livemode != livemode {
+			continue
+		}
+		if transfer.ToUserID == userID {
+			balance += transfer.Amount
+		}
+		if transfer.FromUserID == userID {
+			balance -= transfer.Amount
+		}
+	}
+	balance -= m.heldAmount(userID, currency, livemode)
+	for _, refund := range m.refunds {
+		if refund.Status == consts.Failed || refund.Currency != currency {
+			continue
+		}
+		tx, exists := m.transactions[refund.TransactionID]
+		if !exists {
+			tx, exists = m.archived[refund.TransactionID]
+		}
+		if exists && tx.UserID == userID && tx.Livemode == livemode {
+			balance -= refund.Amount
+		}
+	}
+	// Amounts are stored to 3 decimal places
+	return math.Round(balance*1000) / 1000
+}
+
+// CreateTransfer records a transfer and returns it as recorded. A completed transfer is first
+// decided by check given the completed transfers its sender sent since a time, and is declined
+// with insufficient_funds when the sender's balance does not cover it.
+func (m *MockDB) CreateTransfer(transfer models.Transfer, since time.Time, check TransferCheck) (*models.Transfer, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if transfer.Status == consts.Completed {
+		transfer = check(transfer, m.transferStats(transfer.FromUserID, transfer.ToUserID, transfer.Currency, transfer.Livemode, since))
+	}
+	if transfer.Status == consts.Completed && m.walletBalance(transfer.FromUserID, transfer.Currency, transfer.Livemode) < transfer.Amount {
+		transfer.Status = consts.Failed
+		transfer.DeclineCode = consts.DeclineInsufficientFunds
+	}
+
+	transfer.ID = len(m.transfers) + 1
+	m.transfers = append(m.transfers, transfer)
+
+	return &transfer, nil
+}
+
+// GetTransfer fetches a transfer the user sent or received
+func (m *MockDB) GetTransfer(transferID, userID int) (*models.Transfer, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	if transferID < 1 || transferID > len(m.transfers) {
+		return nil, sql.ErrNoRows
+	}
+	transfer := m.transfers[transferID-1]
+	if transfer.FromUserID != userID && transfer.ToUserID != userID {
+		return nil, sql.ErrNoRows
+	}
+	return &transfer, nil
+}
+
+// transferStats counts the completed transfers a user sent in a currency and mode since a time,
+// and those ever sent to a recipient. Callers hold m.mu.
+func (m *MockDB) transferStats(fromUserID, toUserID int, currency string, livemode bool, since time.Time) models.TransferStats {
+	var stats models.TransferStats
+	for _, transfer := range m.transfers {
+		if transfer.FromUserID != fromUserID || transfer.Currency != currency || transfer.Livemode != livemode || transfer.Status != consts.Completed {
+			continue
+		}
+		if !transfer.CreatedAt.Before(since) {
+			stats.Count++
+			stats.Amount += transfer.Amount
+		}
+		if transfer.ToUserID == toUserID {
+			stats.ToRecipient++
+		}
+	}
+	return stats
+}
+
+// CreateBatch creates a new batch record
+func (m *MockDB) CreateBatch(batch models.Batch) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	id := m.nextBatchID
+	m.nextBatchID++
+
+	batch.ID = id
+	if batch.CreatedAt.IsZero() {
+		batch.CreatedAt = time.Now()
+	}
+	batch.Items = append([]models.BatchItemResult(nil), batch.Items...)
+
+	m.batches[id] = &batch
+
+	return id, nil
+}
+
+// GetBatchByID gets a batch by ID
+func (m *MockDB) GetBatchByID(batchID int) (*models.Batch, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	batch, exists := m.batches[batchID]
+	if !exists {
+		return nil, sql.ErrNoRows
+	}
+
+	// Return a copy to prevent mutation
+	batchCopy := *batch
+	batchCopy.Items = append([]models.BatchItemResult(nil), batch.Items...)
+	return &batchCopy, nil
+}
+
+// UpdateBatch updates a batch's status, counters and item results
+func (m *MockDB) UpdateBatch(batch models.Batch) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	existing, exists := m.batches[batch.ID]
+	if !exists {
+		return errors.New("batch not found")
+	}
+
+	existing.Status = batch.Status
+	existing.Total = batch.Total
+	existing.Succeeded = batch.Succeeded
+	existing.Failed = batch.Failed
+	existing.Items = append([]models.BatchItemResult(nil), batch.Items...)
+	existing.UpdatedAt = time.Now()
+
+	return nil
+}
+
+// CreateOperation creates a new long-running operation record
+func (m *MockDB) CreateOperation(op models.Operation) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, exists := m.operations[op.ID]; exists {
+		return errors.New("operation already exists")
+	}
+
+	m.operations[op.ID] = &op
+
+	return nil
+}
+
+// GetOperationByID gets an operation by ID
+func (m *MockDB) GetOperationByID(operationID string) (*models.Operation, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	op, exists := m.operations[operationID]
+	if !exists {
+		return nil, sql.ErrNoRows
+	}
+
+	// Return a copy to prevent mutation
+	opCopy := *op
+	return &opCopy, nil
+}
+
+// GetLatestOperationByType gets the most recently created operation of the given type
+func (m *MockDB) GetLatestOperationByType(opType string) (*models.Operation, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var latest *models.Operation
+	for _, op := range m.operations {
+		if op.Type == opType && (latest == nil || op.CreatedAt.After(latest.CreatedAt)) {
+			latest = op
+		}
+	}
+	if latest == nil {
+		return nil, sql.ErrNoRows
+	}
+
+	// Return a copy to prevent mutation
+	opCopy := *latest
+	return &opCopy, nil
+}
+
+// UpdateOperation updates an operation's status, progress and result
+func (m *MockDB) UpdateOperation(op models.Operation) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	existing, exists := m.operations[op.ID]
+	if !exists {
+		return errors.New("operation not found")
+	}
+
+	existing.Status = op.Status
+	existing.Progress = op.Progress
+	existing.Processed = op.Processed
+	existing.Total = op.Total
+	existing.Result = op.Result
+	existing.Error = op.Error
+	existing.UpdatedAt = time.Now()
+
+	return nil
+}
+
+// DeleteExpiredOperations removes operations that expired before the given time
+func (m *MockDB) DeleteExpiredOperations(before time.Time) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var deleted int64
+	for id, op := range m.operations {
+		if op.ExpiresAt.Before(before) {
+			delete(m.operations, id)
+			deleted++
+		}
+	}
+
+	return deleted, nil
+}
+
+// Ping checks the database connection (always returns nil for mock)
+func (m *MockDB) Ping() error {
+	return nil
+}
+
+// Close closes the database connection (no-op for mock)
+func (m *MockDB) Close() error {
+	return nil
+}
+
+// CreateRefund records a refund of a deposit while the deposit's amount covers it together with
+// its refunds that haven't failed, returning sql.ErrNoRows otherwise, and while the user's wallet
+// balance covers it, returning ErrInsufficientBalance otherwise
+func (m *MockDB) CreateRefund(refund models.Refund) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	tx, exists := m.transactions[refund.TransactionID]
+	if !exists {
+		return 0, sql.ErrNoRows
+	}
+
+	// Amounts are compared in thousandths, the precision they are stored with
+	thousandths := func(amount float64) int64 { return int64(math.Round(amount * 1000)) }
+	refunded := thousandths(refund.Amount)
+	for _, existing := range m.refunds {
+		if existing.TransactionID == refund.TransactionID && existing.Status != consts.Failed {
+			refunded += thousandths(existing.Amount)
+		}
+	}
+	if refunded > thousandths(tx.Amount) {
+		return 0, sql.ErrNoRows
+	}
+	if thousandths(m.walletBalance(tx.UserID, refund.Currency, tx.Livemode)) < thousandths(refund.Amount) {
+		return 0, ErrInsufficientBalance
+	}
+
+	refund.ID = len(m.refunds) + 1
+	refund.UpdatedAt = refund.CreatedAt
+	m.refunds = append(m.refunds, refund)
+
+	return refund.ID, nil
+}
+
+// UpdateRefund records a refund's status, gateway reference and decline
+func (m *MockDB) UpdateRefund(refund models.Refund) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if refund.ID < 1 || refund.ID > len(m.refunds) {
+		return sql.ErrNoRows
+	}
+
+	stored := &m.refunds[refund.ID-1]
+	stored.Status = refund.Status
+	stored.GatewayReference = refund.GatewayReference
+	stored.DeclineCode = refund.DeclineCode
+	stored.ErrorMessage = refund.ErrorMessage
+	stored.UpdatedAt = refund.UpdatedAt
+	return nil
+}
+
+// GetRefund fetches a refund
+func (m *MockDB) GetRefund(refundID int) (*models.Refund, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	if refundID < 1 || refundID > len(m.refunds) {
+		return nil, sql.ErrNoRows
+	}
+
+	refund := m.refunds[refundID-1]
+	return &refund, nil
+}
+
+// GetTransactionRefunds fetches the refunds of a transaction, oldest first
+func (m *MockDB) GetTransactionRefunds(txID int) ([]models.Refund, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var refunds []models.Refund
+	for _, refund := range m.refunds {
+		if refund.TransactionID == txID {
+			refunds = append(refunds, refund)
+		}
+	}
+	return refunds, nil
+}
+
+// AddTransactionTags attaches tags to a transaction, ignoring those it already carries
+func (m *MockDB) AddTransactionTags(txID int, tags []string, createdAt time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, exists := m.transactions[txID]; !exists {
+		return sql.ErrNoRows
+	}
+	if m.transactionTags == nil {
+		m.transactionTags = make(map[int][]string)
+	}
+
+	for _, tag := range tags {
+		if !m.hasTags(txID, []string{tag}) {
+			m.transactionTags[txID] = append(m.transactionTags[txID], tag)
+		}
+	}
+	sort.Strings(m.transactionTags[txID])
+	return nil
+}
+
+// RemoveTransactionTag removes a tag from a transaction, returning sql.ErrNoRows when it doesn't
+// carry the tag
+func (m *MockDB) RemoveTransactionTag(txID int, tag string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	tags := m.transactionTags[txID]
+	for i, existing := range tags {
+		if existing == tag {
+			m.transactionTags[txID] = append(tags[:i:i], tags[i+1:]...)
+			return nil
+		}
+	}
+	return sql.ErrNoRows
+}
+
+// GetTransactionTags fetches a transaction's tags in alphabetical order
+func (m *MockDB) GetTransactionTags(txID int) ([]string, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	return append([]string{}, m.transactionTags[txID]...), nil
+}
+
+// ListTransactions returns the transactions a filter selects with their tags, newest first
+func (m *MockDB) ListTransactions(filter models.TransactionFilter) ([]models.TransactionSummary, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var transactions []models.TransactionSummary
+	for _, tx := range m.transactions {
+		if !tx.DeletedAt.IsZero() || (filter.BeforeID > 0 && tx.ID >= filter.BeforeID) {
+			continue
+		}
+		if filter.MerchantID > 0 {
+			user, exists := m.users[tx.UserID]
+			if !exists || user.MerchantID != filter.MerchantID {
+				continue
+			}
+		}
+		if (filter.Status != "" && tx.Status != filter.Status) || (filter.Type != "" && tx.Type != filter.Type) {
+			continue
+		}
+		if (!filter.From.IsZero() && tx.CreatedAt.Before(filter.From)) || (!filter.To.IsZero() && !tx.CreatedAt.Before(filter.To)) {
+			continue
+		}
+		if !m.hasTags(tx.ID, filter.Tags) {
+			continue
+		}
+
+		transactions = append(transactions, models.TransactionSummary{
+			ID:          tx.ID,
+			Type:        tx.Type,
+			Status:      tx.Status,
+			Amount:      tx.Amount,
+			Currency:    tx.Currency,
+			UserID:      tx.UserID,
+			GatewayID:   tx.GatewayID,
+			CountryID:   tx.CountryID,
+			ReferenceID: tx.ReferenceID,
+			DeclineCode: tx.DeclineCode,
+			Livemode:    tx.Livemode,
+			Tags:        append([]string{}, m.transactionTags[tx.ID]...),
+			CreatedAt:   tx.CreatedAt,
+		})
+	}
+
+	sort.Slice(transactions, func(i, j int) bool { return transactions[i].ID > transactions[j].ID })
+	if filter.Limit > 0 && len(transactions) > filter.Limit {
+		transactions = transactions[:filter.Limit]
+	}
+	return transactions, nil
+}
+
+// hasTags reports whether a transaction carries every tag listed. Callers hold m.mu.
+func (m *MockDB) hasTags(txID int, tags []string) bool {
+	for _, tag := range tags {
+		found := false
+		for _, existing := range m.transactionTags[txID] {
+			if existing == tag {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// CreateWebhookEndpoint records a merchant's webhook endpoint and returns its ID
+func (m *MockDB) CreateWebhookEndpoint(endpoint models.WebhookEndpoint) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	endpoint.ID = len(m.webhookEndpoints) + 1
+	endpoint.EventTypes = append([]string{}, endpoint.EventTypes...)
+	m.webhookEndpoints = append(m.webhookEndpoints, endpoint)
+	return endpoint.ID, nil
+}
+
+// GetWebhookEndpoints fetches a merchant's webhook endpoints, oldest first
+func (m *MockDB) GetWebhookEndpoints(merchantID int) ([]models.WebhookEndpoint, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var endpoints []models.WebhookEndpoint
+	for _, endpoint := range m.webhookEndpoints {
+		if endpoint.MerchantID == merchantID && endpoint.ID > 0 {
+			endpoint.EventTypes = append([]string{}, endpoint.EventTypes...)
+			endpoints = append(endpoints, endpoint)
+		}
+	}
+	return endpoints, nil
+}
+
+// GetWebhookEndpoint fetches one of a merchant's webhook endpoints
+func (m *MockDB) GetWebhookEndpoint(merchantID, endpointID int) (*models.WebhookEndpoint, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	i := m.webhookEndpointIndex(merchantID, endpointID)
+	if i < 0 {
+		return nil, sql.ErrNoRows
+	}
+	endpoint := m.webhookEndpoints[i]
+	endpoint.EventTypes = append([]string{}, endpoint.EventTypes...)
+	return &endpoint, nil
+}
+
+// UpdateWebhookEndpoint replaces the URL, event types, payload version and description of a
+// merchant's webhook endpoint, returning sql.ErrNoRows when the merchant has no such endpoint
+func (m *MockDB) UpdateWebhookEndpoint(endpoint models.WebhookEndpoint) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	i := m.webhookEndpointIndex(endpoint.MerchantID, endpoint.ID)
+	if i < 0 {
+		return sql.ErrNoRows
+	}
+	existing := &m.webhookEndpoints[i]
+	existing.URL = endpoint.URL
+	existing.EventTypes = append([]string{}, endpoint.EventTypes...)
+	existing.PayloadVersion = endpoint.PayloadVersion
+	existing.Description = endpoint.Description
+	existing.UpdatedAt = endpoint.UpdatedAt
+	return nil
+}
+
+// DeleteWebhookEndpoint deletes one of a merchant's webhook endpoints, returning sql.ErrNoRows
+// when the merchant has no such endpoint
+func (m *MockDB) DeleteWebhookEndpoint(merchantID, endpointID int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	i := m.webhookEndpointIndex(merchantID, endpointID)
+	if i < 0 {
+		return sql.ErrNoRows
+	}
+	// Deleted endpoints keep their slot so IDs aren't reused
+	m.webhookEndpoints[i] = models.WebhookEndpoint{}
 	return nil
 }
+
+// webhookEndpointIndex returns the index of a merchant's webhook endpoint, or -1. Callers hold m.mu.
+func (m *MockDB) webhookEndpointIndex(merchantID, endpointID int) int {
+	for i, endpoint := range m.webhookEndpoints {
+		if endpoint.ID == endpointID && endpoint.MerchantID == merchantID && endpointID > 0 {
+			return i
+		}
+	}
+	return -1
+}
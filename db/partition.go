@@ -0,0 +1,80 @@
+package db
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// PartitionedTables are the tables partitioned by month on created_at once
+// migrations/001_partition_transactions.sql has been applied
+var PartitionedTables = []string{"transactions", "routing_decisions"}
+
+// Partitioner is implemented by databases that support time-based table partitioning
+type Partitioner interface {
+	// EnsureMonthlyPartitions creates any missing monthly partitions of table for the given
+	// number of months starting at from's month, returning the names of partitions created.
+	// Tables that are not partitioned are left untouched.
+	EnsureMonthlyPartitions(table string, from time.Time, months int) ([]string, error)
+}
+
+// MonthlyPartitionName returns the name of the partition of table holding rows created in month
+func MonthlyPartitionName(table string, month time.Time) string {
+	return fmt.Sprintf("%s_p%s", table, month.Format("200601"))
+}
+
+// StartOfMonth truncates t to midnight UTC on the first day of its month
+func StartOfMonth(t time.Time) time.Time {
+	t = t.UTC()
+	return time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, time.UTC)
+}
+
+// EnsureMonthlyPartitions creates any missing monthly partitions of a partitioned table
+func (p *PostgresDB) EnsureMonthlyPartitions(table string, from time.Time, months int) ([]string, error) {
+	var partitioned bool
+	err := p.db.QueryRow(`
+		SELECT EXISTS (
+			SELECT 1 FROM pg_partitioned_table pt
+			JOIN pg_class c ON c.oid = pt.partrelid
+			WHERE c.relname = $1
+		)
+	`, table).Scan(&partitioned)
+	if err != nil {
+		return nil, fmt.Errorf("failed to check partitioning of %s: %w", table, err)
+	}
+
+	if !partitioned {
+		return nil, nil
+	}
+
+	var created []string
+	month := StartOfMonth(from)
+	for i := 0; i < months; i++ {
+		name := MonthlyPartitionName(table, month)
+		next := month.AddDate(0, 1, 0)
+
+		var exists bool
+		if err := p.db.QueryRow(`SELECT to_regclass($1) IS NOT NULL`, name).Scan(&exists); err != nil {
+			return created, fmt.Errorf("failed to look up partition %s: %w", name, err)
+		}
+
+		if !exists {
+			query := fmt.Sprintf(
+				`CREATE TABLE IF NOT EXISTS %s PARTITION OF %s FOR VALUES FROM ('%s') TO ('%s')`,
+				pq.QuoteIdentifier(name),
+				pq.QuoteIdentifier(table),
+				month.Format("2006-01-02"),
+				next.Format("2006-01-02"),
+			)
+			if _, err := p.db.Exec(query); err != nil {
+				return created, fmt.Errorf("failed to create partition %s: %w", name, err)
+			}
+			created = append(created, name)
+		}
+
+		month = next
+	}
+
+	return created, nil
+}
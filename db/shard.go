@@ -0,0 +1,1349 @@
+package db
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"payment-gateway/internal/models"
+	"sort"
+	"sync"
+	"time"
+)
+
+// ShardResolver maps merchants and entity IDs to shard indexes
+type ShardResolver interface {
+	ShardForMerchant(merchantID int) int
+	ShardForID(id int) int
+}
+
+// ModuloResolver places merchants and IDs on shard id % Shards. Each shard's ID sequences must be
+// configured to only issue IDs for that shard (see migrations/002_shard_sequences.sql), and users
+// must be provisioned on their merchant's shard, so that any user or transaction ID identifies the
+// shard holding it without a lookup.
+type ModuloResolver struct {
+	Shards int
+}
+
+// ShardForMerchant returns the shard holding a merchant's data
+func (r ModuloResolver) ShardForMerchant(merchantID int) int {
+	return r.ShardForID(merchantID)
+}
+
+// ShardForID returns the shard holding the entity with the given ID
+func (r ModuloResolver) ShardForID(id int) int {
+	if r.Shards <= 1 || id <= 0 {
+		return 0
+	}
+	return id % r.Shards
+}
+
+// ShardedDB implements DBInterface over several databases, one per shard of merchants.
+//
+// User, transaction and routing decision operations are routed to a single shard by ID.
+// Reference data (countries, gateways) is replicated on every shard and read from the first;
+// coordination data that isn't owned by one merchant (batches, operations, outbox) lives on the first
+// shard. Cross-shard admin queries fan out to every shard and merge the results.
+type ShardedDB struct {
+	shards   []DBInterface
+	resolver ShardResolver
+}
+
+// NewShardedDB creates a sharded database over the given shards
+func NewShardedDB(shards []DBInterface, resolver ShardResolver) (*ShardedDB, error) {
+	if len(shards) == 0 {
+		return nil, errors.New("at least one shard is required")
+	}
+
+	return &ShardedDB{shards: shards, resolver: resolver}, nil
+}
+
+// Shards returns the number of shards
+func (s *ShardedDB) Shards() int {
+	return len(s.shards)
+}
+
+// ForEachShard runs fn concurrently against every shard, returning the first error
+func (s *ShardedDB) ForEachShard(fn func(index int, shard DBInterface) error) error {
+	var wg sync.WaitGroup
+	errs := make([]error, len(s.shards))
+
+	for i, shard := range s.shards {
+		wg.Add(1)
+		go func(i int, shard DBInterface) {
+			defer wg.Done()
+			if err := fn(i, shard); err != nil {
+				errs[i] = fmt.Errorf("shard %d: %w", i, err)
+			}
+		}(i, shard)
+	}
+	wg.Wait()
+
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// primary returns the shard holding reference and coordination data
+func (s *ShardedDB) primary() DBInterface {
+	return s.shards[0]
+}
+
+// byID returns the shard holding the entity with the given ID
+func (s *ShardedDB) byID(id int) DBInterface {
+	return s.shards[s.index(s.resolver.ShardForID(id))]
+}
+
+// byMerchant returns the shard holding a merchant's data
+func (s *ShardedDB) byMerchant(merchantID int) DBInterface {
+	return s.shards[s.index(s.resolver.ShardForMerchant(merchantID))]
+}
+
+// index guards against resolvers returning an index outside the configured shards
+func (s *ShardedDB) index(shard int) int {
+	if shard < 0 || shard >= len(s.shards) {
+		return 0
+	}
+	return shard
+}
+
+// GetUserByID fetches a user from the shard of their ID
+func (s *ShardedDB) GetUserByID(userID int) (*models.User, error) {
+	return s.byID(userID).GetUserByID(userID)
+}
+
+// CreateUser provisions a user on their merchant's shard, whose ID sequence only issues IDs
+// resolving back to it
+func (s *ShardedDB) CreateUser(user models.User) (int, error) {
+	return s.byMerchant(user.MerchantID).CreateUser(user)
+}
+
+// UpdateUserScreeningStatus updates a user's screening status on the shard of their ID
+func (s *ShardedDB) UpdateUserScreeningStatus(userID int, status string) error {
+	return s.byID(userID).UpdateUserScreeningStatus(userID, status)
+}
+
+// UpdateUserContact updates a user's contact details on the shard of their ID
+func (s *ShardedDB) UpdateUserContact(userID int, contact models.UserContact) error {
+	return s.byID(userID).UpdateUserContact(userID, contact)
+}
+
+// GetMerchantByID fetches a merchant from its shard
+func (s *ShardedDB) GetMerchantByID(merchantID int) (*models.Merchant, error) {
+	return s.byMerchant(merchantID).GetMerchantByID(merchantID)
+}
+
+// SetMerchantWebhookSecret updates the merchant on its shard
+func (s *ShardedDB) SetMerchantWebhookSecret(merchantID int, secret string) error {
+	return s.byMerchant(merchantID).SetMerchantWebhookSecret(merchantID, secret)
+}
+
+// SetMerchantLivemode updates the merchant on its shard
+func (s *ShardedDB) SetMerchantLivemode(merchantID int, livemode bool) error {
+	return s.byMerchant(merchantID).SetMerchantLivemode(merchantID, livemode)
+}
+
+// GetRoutingRules fetches a merchant's routing rules from the merchant's shard
+func (s *ShardedDB) GetRoutingRules(merchantID int) ([]models.RoutingRule, error) {
+	return s.byMerchant(merchantID).GetRoutingRules(merchantID)
+}
+
+// ReplaceRoutingRules stores a merchant's routing rules on the merchant's shard
+func (s *ShardedDB) ReplaceRoutingRules(merchantID int, rules []models.RoutingRule) error {
+	return s.byMerchant(merchantID).ReplaceRoutingRules(merchantID, rules)
+}
+
+// GetPayoutSchedule reads from the merchant's shard
+func (s *ShardedDB) GetPayoutSchedule(merchantID int) (*models.PayoutSchedule, error) {
+	return s.byMerchant(merchantID).GetPayoutSchedule(merchantID)
+}
+
+// SavePayoutSchedule writes to the merchant's shard
+func (s *ShardedDB) SavePayoutSchedule(schedule models.PayoutSchedule) error {
+	return s.byMerchant(schedule.MerchantID).SavePayoutSchedule(schedule)
+}
+
+// DeletePayoutSchedule deletes from the merchant's shard
+func (s *ShardedDB) DeletePayoutSchedule(merchantID int) error {
+	return s.byMerchant(merchantID).DeletePayoutSchedule(merchantID)
+}
+
+// GetMerchantIDs collects the IDs of the merchants on every shard, in ascending order
+func (s *ShardedDB) GetMerchantIDs() ([]int, error) {
+	var mu sync.Mutex
+	seen := make(map[int]bool)
+
+	err := s.ForEachShard(func(_ int, shard DBInterface) error {
+		ids, err := shard.GetMerchantIDs()
+		if err != nil {
+			return err
+		}
+
+		mu.Lock()
+		defer mu.Unlock()
+		for _, id := range ids {
+			seen[id] = true
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	ids := make([]int, 0, len(seen))
+	for id := range seen {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+	return ids, nil
+}
+
+// GetMerchantFees reads a merchant's fees from its shard
+func (s *ShardedDB) GetMerchantFees(merchantID int) ([]models.MerchantFee, error) {
+	return s.byMerchant(merchantID).GetMerchantFees(merchantID)
+}
+
+// ReplaceMerchantFees replaces a merchant's fees on its shard
+func (s *ShardedDB) ReplaceMerchantFees(merchantID int, fees []models.MerchantFee) error {
+	return s.byMerchant(merchantID).ReplaceMerchantFees(merchantID, fees)
+}
+
+// GetCountryByID reads replicated country data from the primary shard
+func (s *ShardedDB) GetCountryByID(countryID int) (*models.Country, error) {
+	return s.primary().GetCountryByID(countryID)
+}
+
+// GetCountryByCode reads replicated country data from the primary shard
+func (s *ShardedDB) GetCountryByCode(code string) (*models.Country, error) {
+	return s.primary().GetCountryByCode(code)
+}
+
+// GetBankHolidays reads replicated calendar data from the primary shard
+func (s *ShardedDB) GetBankHolidays(countryID int, from, to string) ([]models.BankHoliday, error) {
+	return s.primary().GetBankHolidays(countryID, from, to)
+}
+
+// CreateBankHoliday writes calendar data to the primary shard
+func (s *ShardedDB) CreateBankHoliday(holiday models.BankHoliday) error {
+	return s.primary().CreateBankHoliday(holiday)
+}
+
+// GetDeclineRecoveryHints reads the recovery hint mapping from the primary shard
+func (s *ShardedDB) GetDeclineRecoveryHints() ([]models.DeclineRecoveryHint, error) {
+	return s.primary().GetDeclineRecoveryHints()
+}
+
+// SaveDeclineRecoveryHint writes the recovery hint mapping to the primary shard
+func (s *ShardedDB) SaveDeclineRecoveryHint(hint models.DeclineRecoveryHint) error {
+	return s.primary().SaveDeclineRecoveryHint(hint)
+}
+
+// DeleteBankHoliday deletes calendar data from the primary shard
+func (s *ShardedDB) DeleteBankHoliday(countryID int, date string) error {
+	return s.primary().DeleteBankHoliday(countryID, date)
+}
+
+// GetComplianceFields reads replicated country configuration from the primary shard
+func (s *ShardedDB) GetComplianceFields(countryID int) ([]models.ComplianceField, error) {
+	return s.primary().GetComplianceFields(countryID)
+}
+
+// ReplaceComplianceFields writes country configuration to the primary shard
+func (s *ShardedDB) ReplaceComplianceFields(countryID int, fields []models.ComplianceField) error {
+	return s.primary().ReplaceComplianceFields(countryID, fields)
+}
+
+// GetAMLThresholds reads replicated country configuration from the primary shard
+func (s *ShardedDB) GetAMLThresholds(countryID int) ([]models.AMLThreshold, error) {
+	return s.primary().GetAMLThresholds(countryID)
+}
+
+// GetAMLThreshold reads replicated country configuration from the primary shard
+func (s *ShardedDB) GetAMLThreshold(countryID int, currency string) (*models.AMLThreshold, error) {
+	return s.primary().GetAMLThreshold(countryID, currency)
+}
+
+// SetAMLThreshold writes country configuration to the primary shard
+func (s *ShardedDB) SetAMLThreshold(threshold models.AMLThreshold) error {
+	return s.primary().SetAMLThreshold(threshold)
+}
+
+// DeleteAMLThreshold writes country configuration to the primary shard
+func (s *ShardedDB) DeleteAMLThreshold(countryID int, currency string) error {
+	return s.primary().DeleteAMLThreshold(countryID, currency)
+}
+
+// GetSupportedGatewaysByCountry reads replicated gateway configuration from the primary shard
+func (s *ShardedDB) GetSupportedGatewaysByCountry(countryID int) ([]models.Gateway, error) {
+	return s.primary().GetSupportedGatewaysByCountry(countryID)
+}
+
+// GetGatewaysByPriority reads replicated gateway configuration from the primary shard
+func (s *ShardedDB) GetGatewaysByPriority(countryID int) ([]models.GatewayPriority, error) {
+	return s.primary().GetGatewaysByPriority(countryID)
+}
+
+// CreateWebhookSecret stores replicated gateway configuration on the primary shard
+func (s *ShardedDB) CreateWebhookSecret(secret models.WebhookSecret) (int, error) {
+	return s.primary().CreateWebhookSecret(secret)
+}
+
+// GetWebhookSecretsByGateway reads replicated gateway configuration from the primary shard
+func (s *ShardedDB) GetWebhookSecretsByGateway(gatewayID int) ([]models.WebhookSecret, error) {
+	return s.primary().GetWebhookSecretsByGateway(gatewayID)
+}
+
+// RetireWebhookSecret updates replicated gateway configuration on the primary shard
+func (s *ShardedDB) RetireWebhookSecret(gatewayID, secretID int) error {
+	return s.primary().RetireWebhookSecret(gatewayID, secretID)
+}
+
+// CreateClientCertificate stores replicated gateway configuration on the primary shard
+func (s *ShardedDB) CreateClientCertificate(certificate models.ClientCertificate) (int, error) {
+	return s.primary().CreateClientCertificate(certificate)
+}
+
+// GetClientCertificates reads replicated gateway configuration from the primary shard
+func (s *ShardedDB) GetClientCertificates(gatewayID int) ([]models.ClientCertificate, error) {
+	return s.primary().GetClientCertificates(gatewayID)
+}
+
+// RetireClientCertificate updates replicated gateway configuration on the primary shard
+func (s *ShardedDB) RetireClientCertificate(gatewayID, certificateID int) error {
+	return s.primary().RetireClientCertificate(gatewayID, certificateID)
+}
+
+// CreateSigningKey stores replicated gateway configuration on the primary shard
+func (s *ShardedDB) CreateSigningKey(key models.SigningKey) (int, error) {
+	return s.primary().CreateSigningKey(key)
+}
+
+// GetSigningKeys reads replicated gateway configuration from the primary shard
+func (s *ShardedDB) GetSigningKeys(gatewayID int) ([]models.SigningKey, error) {
+	return s.primary().GetSigningKeys(gatewayID)
+}
+
+// RetireSigningKey updates replicated gateway configuration on the primary shard
+func (s *ShardedDB) RetireSigningKey(gatewayID, keyID int) error {
+	return s.primary().RetireSigningKey(gatewayID, keyID)
+}
+
+// CreateMerchantCertificate stores replicated wallet configuration on the primary shard
+func (s *ShardedDB) CreateMerchantCertificate(certificate models.MerchantCertificate) (int, error) {
+	return s.primary().CreateMerchantCertificate(certificate)
+}
+
+// GetMerchantCertificates reads replicated wallet configuration from the primary shard
+func (s *ShardedDB) GetMerchantCertificates(wallet string) ([]models.MerchantCertificate, error) {
+	return s.primary().GetMerchantCertificates(wallet)
+}
+
+// RetireMerchantCertificate updates replicated wallet configuration on the primary shard
+func (s *ShardedDB) RetireMerchantCertificate(certificateID int) error {
+	return s.primary().RetireMerchantCertificate(certificateID)
+}
+
+// CreateMaintenanceWindow stores replicated gateway configuration on the primary shard
+func (s *ShardedDB) CreateMaintenanceWindow(window models.MaintenanceWindow) (int, error) {
+	return s.primary().CreateMaintenanceWindow(window)
+}
+
+// GetMaintenanceWindows reads replicated gateway configuration from the primary shard
+func (s *ShardedDB) GetMaintenanceWindows(gatewayID int, endingAfter time.Time) ([]models.MaintenanceWindow, error) {
+	return s.primary().GetMaintenanceWindows(gatewayID, endingAfter)
+}
+
+// CancelMaintenanceWindow updates replicated gateway configuration on the primary shard
+func (s *ShardedDB) CancelMaintenanceWindow(gatewayID, windowID int) error {
+	return s.primary().CancelMaintenanceWindow(gatewayID, windowID)
+}
+
+// CreateRoutingDecision stores a routing decision alongside its transaction
+func (s *ShardedDB) CreateRoutingDecision(decision models.RoutingDecision) (int, error) {
+	return s.byID(decision.TransactionID).CreateRoutingDecision(decision)
+}
+
+// GetRoutingDecisionsByTransaction fetches routing decisions from the transaction's shard
+func (s *ShardedDB) GetRoutingDecisionsByTransaction(txID int) ([]models.RoutingDecision, error) {
+	return s.byID(txID).GetRoutingDecisionsByTransaction(txID)
+}
+
+// CreateTransactionEvent stores an audit trail entry alongside its transaction
+func (s *ShardedDB) CreateTransactionEvent(event models.TransactionEvent) (int, error) {
+	return s.byID(event.TransactionID).CreateTransactionEvent(event)
+}
+
+// GetTransactionEvents fetches a transaction's audit trail from its shard
+func (s *ShardedDB) GetTransactionEvents(txID int) ([]models.TransactionEvent, error) {
+	return s.byID(txID).GetTransactionEvents(txID)
+}
+
+// CreateTransaction creates a transaction on its user's shard
+func (s *ShardedDB) CreateTransaction(transaction models.Transaction) (int, error) {
+	return s.create(transaction, DBInterface.CreateTransaction)
+}
+
+// CreateWithdrawal creates a withdrawal on its user's shard, which holds their wallet ledger
+func (s *ShardedDB) CreateWithdrawal(transaction models.Transaction) (int, error) {
+	return s.create(transaction, DBInterface.CreateWithdrawal)
+}
+
+// create creates a transaction on its user's shard with a create method of the shard
+func (s *ShardedDB) create(transaction models.Transaction, create func(DBInterface, models.Transaction) (int, error)) (int, error) {
+	shard := s.index(s.resolver.ShardForID(transaction.UserID))
+
+	id, err := create(s.shards[shard], transaction)
+	if err != nil {
+		return 0, err
+	}
+
+	// Later lookups route by ID, so an ID from a misconfigured sequence would be unreachable
+	if resolved := s.index(s.resolver.ShardForID(id)); resolved != shard {
+		return 0, fmt.Errorf("transaction %d was created on shard %d but resolves to shard %d; check the shard's ID sequences", id, shard, resolved)
+	}
+
+	return id, nil
+}
+
+// GetTransactionByID fetches a transaction from its shard
+func (s *ShardedDB) GetTransactionByID(transactionID int) (*models.Transaction, error) {
+	return s.byID(transactionID).GetTransactionByID(transactionID)
+}
+
+// GetTransactionIDByGatewayReference searches every shard, as callbacks identify transactions only
+// by the gateway's reference
+func (s *ShardedDB) GetTransactionIDByGatewayReference(gatewayID int, gatewayReference string) (int, error) {
+	var mu sync.Mutex
+	txID := 0
+
+	err := s.ForEachShard(func(_ int, shard DBInterface) error {
+		id, err := shard.GetTransactionIDByGatewayReference(gatewayID, gatewayReference)
+		if err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				return nil
+			}
+			return err
+		}
+
+		mu.Lock()
+		if id > txID {
+			txID = id
+		}
+		mu.Unlock()
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	if txID == 0 {
+		return 0, sql.ErrNoRows
+	}
+	return txID, nil
+}
+
+// UpdateTransactionStatus moves a transaction from one status to another on its shard
+func (s *ShardedDB) UpdateTransactionStatus(txID int, from, to, errorMsg string) error {
+	return s.byID(txID).UpdateTransactionStatus(txID, from, to, errorMsg)
+}
+
+// UpdateTransactionGatewayReference records a transaction's gateway reference on its shard
+func (s *ShardedDB) UpdateTransactionGatewayReference(txID int, gatewayReference, redirectURL string) error {
+	return s.byID(txID).UpdateTransactionGatewayReference(txID, gatewayReference, redirectURL)
+}
+
+// UpdateTransactionIdempotencyKey records a transaction's idempotency key on its shard
+func (s *ShardedDB) UpdateTransactionIdempotencyKey(txID int, key string) error {
+	return s.byID(txID).UpdateTransactionIdempotencyKey(txID, key)
+}
+
+// UpdateTransactionDeclineCode records a transaction's decline code on its shard
+func (s *ShardedDB) UpdateTransactionDeclineCode(txID int, declineCode string) error {
+	return s.byID(txID).UpdateTransactionDeclineCode(txID, declineCode)
+}
+
+// UpdateTransactionGateway moves a transaction to another gateway on its shard
+func (s *ShardedDB) UpdateTransactionGateway(txID, gatewayID int) error {
+	return s.byID(txID).UpdateTransactionGateway(txID, gatewayID)
+}
+
+// UpdateTransactionSCAExemptionOutcome records a transaction's SCA exemption outcome on its shard
+func (s *ShardedDB) UpdateTransactionSCAExemptionOutcome(txID int, outcome string) error {
+	return s.byID(txID).UpdateTransactionSCAExemptionOutcome(txID, outcome)
+}
+
+// UpdateTransactionCryptoPayment records the payment of a crypto deposit on its shard
+func (s *ShardedDB) UpdateTransactionCryptoPayment(txID int, payment models.CryptoPayment) error {
+	return s.byID(txID).UpdateTransactionCryptoPayment(txID, payment)
+}
+
+// RescheduleTransaction reschedules a transaction on its shard
+func (s *ShardedDB) RescheduleTransaction(txID int, scheduledFor time.Time, expectedSettlementDate string) error {
+	return s.byID(txID).RescheduleTransaction(txID, scheduledFor, expectedSettlementDate)
+}
+
+// AuthorizeTransaction authorizes a deposit on its shard
+func (s *ShardedDB) AuthorizeTransaction(txID int, expiresAt time.Time) error {
+	return s.byID(txID).AuthorizeTransaction(txID, expiresAt)
+}
+
+// ClaimAuthorizedTransaction claims an authorized deposit on its shard
+func (s *ShardedDB) ClaimAuthorizedTransaction(txID int) error {
+	return s.byID(txID).ClaimAuthorizedTransaction(txID)
+}
+
+// CaptureTransaction records a capture on the deposit's shard
+func (s *ShardedDB) CaptureTransaction(txID int, amount, capturedAmount float64, status string) error {
+	return s.byID(txID).CaptureTransaction(txID, amount, capturedAmount, status)
+}
+
+// RecordPartialCapture records a partial capture on the authorization's shard
+func (s *ShardedDB) RecordPartialCapture(txID int, capturedAmount float64) error {
+	return s.byID(txID).RecordPartialCapture(txID, capturedAmount)
+}
+
+// IncrementAuthorization records an incremented authorization on the deposit's shard
+func (s *ShardedDB) IncrementAuthorization(txID int, amount float64) error {
+	return s.byID(txID).IncrementAuthorization(txID, amount)
+}
+
+// ExpireAuthorizations voids expired authorizations on every shard, up to limit per shard
+func (s *ShardedDB) ExpireAuthorizations(before time.Time, errorMsg string, limit int) ([]int, error) {
+	var mu sync.Mutex
+	var ids []int
+
+	err := s.ForEachShard(func(_ int, shard DBInterface) error {
+		expired, err := shard.ExpireAuthorizations(before, errorMsg, limit)
+
+		mu.Lock()
+		ids = append(ids, expired...)
+		mu.Unlock()
+
+		return err
+	})
+
+	return ids, err
+}
+
+// ExpireUnpaidDeposits expires a gateway's unpaid deposits on every shard, up to limit per shard
+func (s *ShardedDB) ExpireUnpaidDeposits(gatewayID int, createdBefore time.Time, errorMsg, declineCode string, limit int) ([]int, error) {
+	var mu sync.Mutex
+	var ids []int
+
+	err := s.ForEachShard(func(_ int, shard DBInterface) error {
+		expired, err := shard.ExpireUnpaidDeposits(gatewayID, createdBefore, errorMsg, declineCode, limit)
+
+		mu.Lock()
+		ids = append(ids, expired...)
+		mu.Unlock()
+
+		return err
+	})
+
+	return ids, err
+}
+
+// GetInFlightTransactionIDs returns the first limit IDs of a gateway's in-flight transactions
+// across every shard
+func (s *ShardedDB) GetInFlightTransactionIDs(gatewayID int, createdAfter, createdBefore time.Time, afterID, limit int) ([]int, error) {
+	var mu sync.Mutex
+	var ids []int
+
+	err := s.ForEachShard(func(_ int, shard DBInterface) error {
+		inFlight, err := shard.GetInFlightTransactionIDs(gatewayID, createdAfter, createdBefore, afterID, limit)
+
+		mu.Lock()
+		ids = append(ids, inFlight...)
+		mu.Unlock()
+
+		return err
+	})
+
+	sort.Ints(ids)
+	if len(ids) > limit {
+		ids = ids[:limit]
+	}
+	return ids, err
+}
+
+// GetStaleTransactionIDs returns stale transactions of every shard, up to limit per shard
+func (s *ShardedDB) GetStaleTransactionIDs(changedBefore time.Time, limit int) ([]int, error) {
+	var mu sync.Mutex
+	var ids []int
+
+	err := s.ForEachShard(func(_ int, shard DBInterface) error {
+		stale, err := shard.GetStaleTransactionIDs(changedBefore, limit)
+
+		mu.Lock()
+		ids = append(ids, stale...)
+		mu.Unlock()
+
+		return err
+	})
+
+	return ids, err
+}
+
+// ExpireStaleTransaction expires a stale transaction on its shard
+func (s *ShardedDB) ExpireStaleTransaction(txID int, changedBefore time.Time, errorMsg, declineCode string) error {
+	return s.byID(txID).ExpireStaleTransaction(txID, changedBefore, errorMsg, declineCode)
+}
+
+// ClaimDueScheduledTransactions claims due scheduled transactions on every shard, up to limit per shard
+func (s *ShardedDB) ClaimDueScheduledTransactions(before time.Time, limit int) ([]int, error) {
+	var mu sync.Mutex
+	var ids []int
+
+	err := s.ForEachShard(func(_ int, shard DBInterface) error {
+		claimed, err := shard.ClaimDueScheduledTransactions(before, limit)
+
+		mu.Lock()
+		ids = append(ids, claimed...)
+		mu.Unlock()
+
+		return err
+	})
+
+	return ids, err
+}
+
+// SearchTransactions searches every shard, returning the newest matches across all of them
+func (s *ShardedDB) SearchTransactions(search models.TransactionSearch) ([]models.TransactionSearchResult, error) {
+	var mu sync.Mutex
+	var results []models.TransactionSearchResult
+
+	err := s.ForEachShard(func(_ int, shard DBInterface) error {
+		matches, err := shard.SearchTransactions(search)
+		if err != nil {
+			return err
+		}
+
+		mu.Lock()
+		results = append(results, matches...)
+		mu.Unlock()
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Slice(results, func(i, j int) bool { return results[i].CreatedAt.After(results[j].CreatedAt) })
+	if search.Limit > 0 && len(results) > search.Limit {
+		results = results[:search.Limit]
+	}
+	return results, nil
+}
+
+// GetDeclineCounts counts declines on the merchant's shard, which holds its users' transactions
+func (s *ShardedDB) GetDeclineCounts(merchantID int, tag string, from, to time.Time) ([]models.DeclineCount, error) {
+	return s.byMerchant(merchantID).GetDeclineCounts(merchantID, tag, from, to)
+}
+
+// GetMerchantTransactions reads the merchant's shard, which holds its users' transactions
+func (s *ShardedDB) GetMerchantTransactions(merchantID int, from, to time.Time) ([]models.Transaction, error) {
+	return s.byMerchant(merchantID).GetMerchantTransactions(merchantID, from, to)
+}
+
+// SaveReconciliationFile records a reconciliation file on its merchant's shard
+func (s *ShardedDB) SaveReconciliationFile(file models.ReconciliationFile) error {
+	return s.byMerchant(file.MerchantID).SaveReconciliationFile(file)
+}
+
+// GetReconciliationFile reads a reconciliation file from its merchant's shard
+func (s *ShardedDB) GetReconciliationFile(merchantID int, fileID string) (*models.ReconciliationFile, error) {
+	return s.byMerchant(merchantID).GetReconciliationFile(merchantID, fileID)
+}
+
+// ListReconciliationFiles lists a merchant's reconciliation files from its shard
+func (s *ShardedDB) ListReconciliationFiles(merchantID int, from, to string) ([]models.ReconciliationFile, error) {
+	return s.byMerchant(merchantID).ListReconciliationFiles(merchantID, from, to)
+}
+
+// BackfillBlindIndexes backfills blind indexes on every shard that maintains them
+func (s *ShardedDB) BackfillBlindIndexes() (int64, error) {
+	var mu sync.Mutex
+	var total int64
+
+	err := s.ForEachShard(func(_ int, shard DBInterface) error {
+		indexer, ok := shard.(BlindIndexer)
+		if !ok {
+			return nil
+		}
+
+		updated, err := indexer.BackfillBlindIndexes()
+
+		mu.Lock()
+		total += updated
+		mu.Unlock()
+
+		return err
+	})
+
+	return total, err
+}
+
+// SoftDeleteTransaction soft-deletes a transaction on its shard
+func (s *ShardedDB) SoftDeleteTransaction(txID int) error {
+	return s.byID(txID).SoftDeleteTransaction(txID)
+}
+
+// ArchiveTransactions archives eligible transactions on every shard, up to limit per shard
+func (s *ShardedDB) ArchiveTransactions(cutoff time.Time, purgePII bool, limit int) (int64, error) {
+	var mu sync.Mutex
+	var total int64
+
+	err := s.ForEachShard(func(_ int, shard DBInterface) error {
+		archived, err := shard.ArchiveTransactions(cutoff, purgePII, limit)
+
+		mu.Lock()
+		total += archived
+		mu.Unlock()
+
+		return err
+	})
+
+	return total, err
+}
+
+// GetArchivalStats sums table sizes across every shard
+func (s *ShardedDB) GetArchivalStats() (*models.ArchivalStats, error) {
+	var mu sync.Mutex
+	var total models.ArchivalStats
+
+	err := s.ForEachShard(func(_ int, shard DBInterface) error {
+		stats, err := shard.GetArchivalStats()
+		if err != nil {
+			return err
+		}
+
+		mu.Lock()
+		defer mu.Unlock()
+
+		total.HotTransactions += stats.HotTransactions
+		total.SoftDeletedTransactions += stats.SoftDeletedTransactions
+		total.ArchivedTransactions += stats.ArchivedTransactions
+		if stats.LastArchivedAt.After(total.LastArchivedAt) {
+			total.LastArchivedAt = stats.LastArchivedAt
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &total, nil
+}
+
+// CreateAPIKey stores an API key on the primary shard, since keys are looked up by prefix
+// before the merchant is known
+func (s *ShardedDB) CreateAPIKey(key models.APIKey) (int, error) {
+	return s.primary().CreateAPIKey(key)
+}
+
+// GetAPIKeyByPrefix reads an API key from the primary shard
+func (s *ShardedDB) GetAPIKeyByPrefix(prefix string) (*models.APIKey, error) {
+	return s.primary().GetAPIKeyByPrefix(prefix)
+}
+
+// GetAPIKeysByMerchant reads a merchant's API keys from the primary shard
+func (s *ShardedDB) GetAPIKeysByMerchant(merchantID int) ([]models.APIKey, error) {
+	return s.primary().GetAPIKeysByMerchant(merchantID)
+}
+
+// RevokeAPIKey updates an API key on the primary shard
+func (s *ShardedDB) RevokeAPIKey(merchantID, keyID int) error {
+	return s.primary().RevokeAPIKey(merchantID, keyID)
+}
+
+// ExpireAPIKey updates an API key on the primary shard
+func (s *ShardedDB) ExpireAPIKey(merchantID, keyID int, expiresAt time.Time) error {
+	return s.primary().ExpireAPIKey(merchantID, keyID, expiresAt)
+}
+
+// TouchAPIKey updates an API key on the primary shard
+func (s *ShardedDB) TouchAPIKey(keyID int, usedAt time.Time) error {
+	return s.primary().TouchAPIKey(keyID, usedAt)
+}
+
+// CreateOAuthClient stores an OAuth2 client on the primary shard, since clients are looked up
+// by client ID before the merchant is known
+func (s *ShardedDB) CreateOAuthClient(client models.OAuthClient) (int, error) {
+	return s.primary().CreateOAuthClient(client)
+}
+
+// GetOAuthClientByClientID reads an OAuth2 client from the primary shard
+func (s *ShardedDB) GetOAuthClientByClientID(clientID string) (*models.OAuthClient, error) {
+	return s.primary().GetOAuthClientByClientID(clientID)
+}
+
+// CreateCallbackRecord stores a received callback on the primary shard, since the
+// owning transaction is unknown until the callback is parsed
+func (s *ShardedDB) CreateCallbackRecord(record models.CallbackRecord) (int, error) {
+	return s.primary().CreateCallbackRecord(record)
+}
+
+// GetCallbackRecordByID reads a received callback from the primary shard
+func (s *ShardedDB) GetCallbackRecordByID(recordID int) (*models.CallbackRecord, error) {
+	return s.primary().GetCallbackRecordByID(recordID)
+}
+
+// UpdateCallbackRecord updates a received callback on the primary shard
+func (s *ShardedDB) UpdateCallbackRecord(recordID int, status, errorMsg string, transactionID int) error {
+	return s.primary().UpdateCallbackRecord(recordID, status, errorMsg, transactionID)
+}
+
+// CreateDispute stores a dispute on the primary shard, which holds the single review queue
+// across merchants
+func (s *ShardedDB) CreateDispute(dispute models.Dispute) (int, error) {
+	return s.primary().CreateDispute(dispute)
+}
+
+// GetDisputeByID reads a dispute from the primary shard
+func (s *ShardedDB) GetDisputeByID(disputeID int) (*models.Dispute, error) {
+	return s.primary().GetDisputeByID(disputeID)
+}
+
+// GetDisputeByTransaction reads a transaction's dispute from the primary shard
+func (s *ShardedDB) GetDisputeByTransaction(txID int) (*models.Dispute, error) {
+	return s.primary().GetDisputeByTransaction(txID)
+}
+
+// ListDisputes lists disputes on the primary shard
+func (s *ShardedDB) ListDisputes(status string, limit int) ([]models.Dispute, error) {
+	return s.primary().ListDisputes(status, limit)
+}
+
+// UpdateDisputeStatus updates a dispute on the primary shard
+func (s *ShardedDB) UpdateDisputeStatus(disputeID int, status, resolution string, updatedAt time.Time) error {
+	return s.primary().UpdateDisputeStatus(disputeID, status, resolution, updatedAt)
+}
+
+// CreateTransactionHold places a hold on the shard of its transaction, whose wallet ledger it
+// takes from
+func (s *ShardedDB) CreateTransactionHold(hold models.TransactionHold) (int, error) {
+	return s.byID(hold.TransactionID).CreateTransactionHold(hold)
+}
+
+// GetTransactionHold reads a transaction's latest hold from its shard
+func (s *ShardedDB) GetTransactionHold(txID int) (*models.TransactionHold, error) {
+	return s.byID(txID).GetTransactionHold(txID)
+}
+
+// ListTransactionHolds merges the holds with the given status on every shard, oldest first
+func (s *ShardedDB) ListTransactionHolds(status string, limit int) ([]models.TransactionHold, error) {
+	var mu sync.Mutex
+	var holds []models.TransactionHold
+
+	err := s.ForEachShard(func(_ int, shard DBInterface) error {
+		found, err := shard.ListTransactionHolds(status, limit)
+		if err != nil {
+			return err
+		}
+		mu.Lock()
+		holds = append(holds, found...)
+		mu.Unlock()
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Slice(holds, func(i, j int) bool {
+		if !holds[i].CreatedAt.Equal(holds[j].CreatedAt) {
+			return holds[i].CreatedAt.Before(holds[j].CreatedAt)
+		}
+		return holds[i].TransactionID < holds[j].TransactionID
+	})
+	if len(holds) > limit {
+		holds = holds[:limit]
+	}
+	return holds, nil
+}
+
+// ReleaseTransactionHold releases a transaction's active hold on its shard
+func (s *ShardedDB) ReleaseTransactionHold(txID int, note string, releasedAt time.Time) error {
+	return s.byID(txID).ReleaseTransactionHold(txID, note, releasedAt)
+}
+
+// ExtendTransactionHold extends a transaction's active hold on its shard
+func (s *ShardedDB) ExtendTransactionHold(txID int, releaseAt, updatedAt time.Time) error {
+	return s.byID(txID).ExtendTransactionHold(txID, releaseAt, updatedAt)
+}
+
+// ExpireTransactionHolds releases holds past their deadline on every shard, up to limit per shard
+func (s *ShardedDB) ExpireTransactionHolds(before time.Time, limit int) ([]int, error) {
+	var mu sync.Mutex
+	var ids []int
+
+	err := s.ForEachShard(func(_ int, shard DBInterface) error {
+		expired, err := shard.ExpireTransactionHolds(before, limit)
+
+		mu.Lock()
+		ids = append(ids, expired...)
+		mu.Unlock()
+
+		return err
+	})
+
+	return ids, err
+}
+
+// GetHeldAmount sums a user's active holds on the shard of their ID, which holds their transactions
+func (s *ShardedDB) GetHeldAmount(userID int, currency string, livemode bool) (float64, error) {
+	return s.byID(userID).GetHeldAmount(userID, currency, livemode)
+}
+
+// CreateIdempotencyKey claims an idempotency key on the primary shard, which holds every key: the
+// request a key was sent with isn't known to belong to a merchant or user before it runs
+func (s *ShardedDB) CreateIdempotencyKey(key models.IdempotencyKey) error {
+	return s.primary().CreateIdempotencyKey(key)
+}
+
+// GetIdempotencyKey reads an idempotency key from the primary shard
+func (s *ShardedDB) GetIdempotencyKey(caller, route, key string) (*models.IdempotencyKey, error) {
+	return s.primary().GetIdempotencyKey(caller, route, key)
+}
+
+// CompleteIdempotencyKey records the response to an idempotency key's request on the primary shard
+func (s *ShardedDB) CompleteIdempotencyKey(caller, route, key string, statusCode int, contentType string, body []byte, completedAt time.Time) error {
+	return s.primary().CompleteIdempotencyKey(caller, route, key, statusCode, contentType, body, completedAt)
+}
+
+// DeleteIdempotencyKey deletes an idempotency key from the primary shard
+func (s *ShardedDB) DeleteIdempotencyKey(caller, route, key string, createdAt time.Time) error {
+	return s.primary().DeleteIdempotencyKey(caller, route, key, createdAt)
+}
+
+// PurgeIdempotencyKeys deletes expired idempotency keys from the primary shard
+func (s *ShardedDB) PurgeIdempotencyKeys(before time.Time, limit int) (int, error) {
+	return s.primary().PurgeIdempotencyKeys(before, limit)
+}
+
+// CreateAMLCase stores an AML case on the primary shard, which holds the single review queue
+// compliance officers report from
+func (s *ShardedDB) CreateAMLCase(amlCase models.AMLCase) (int, error) {
+	return s.primary().CreateAMLCase(amlCase)
+}
+
+// GetAMLCaseByID reads an AML case from the primary shard
+func (s *ShardedDB) GetAMLCaseByID(caseID int) (*models.AMLCase, error) {
+	return s.primary().GetAMLCaseByID(caseID)
+}
+
+// ListAMLCases lists AML cases on the primary shard
+func (s *ShardedDB) ListAMLCases(status string, limit int) ([]models.AMLCase, error) {
+	return s.primary().ListAMLCases(status, limit)
+}
+
+// UpdateAMLCaseStatus updates an AML case on the primary shard
+func (s *ShardedDB) UpdateAMLCaseStatus(caseID int, status, note string, updatedAt time.Time) error {
+	return s.primary().UpdateAMLCaseStatus(caseID, status, note, updatedAt)
+}
+
+// CreateScreeningCase stores a screening case on the primary shard, which holds the single review
+// queue and audit trail of sanctions screening
+func (s *ShardedDB) CreateScreeningCase(screeningCase models.ScreeningCase) (int, error) {
+	return s.primary().CreateScreeningCase(screeningCase)
+}
+
+// GetScreeningCaseByID reads a screening case from the primary shard
+func (s *ShardedDB) GetScreeningCaseByID(caseID int) (*models.ScreeningCase, error) {
+	return s.primary().GetScreeningCaseByID(caseID)
+}
+
+// ListScreeningCases lists screening cases on the primary shard
+func (s *ShardedDB) ListScreeningCases(status string, limit int) ([]models.ScreeningCase, error) {
+	return s.primary().ListScreeningCases(status, limit)
+}
+
+// ListUserScreeningCases lists a user's screening cases on the primary shard
+func (s *ShardedDB) ListUserScreeningCases(userID int) ([]models.ScreeningCase, error) {
+	return s.primary().ListUserScreeningCases(userID)
+}
+
+// DecideScreeningCase updates a screening case on the primary shard
+func (s *ShardedDB) DecideScreeningCase(caseID int, status, reviewer, note string, updatedAt time.Time) error {
+	return s.primary().DecideScreeningCase(caseID, status, reviewer, note, updatedAt)
+}
+
+// CreateScreeningEvent appends to the screening audit trail on the primary shard
+func (s *ShardedDB) CreateScreeningEvent(event models.ScreeningEvent) error {
+	return s.primary().CreateScreeningEvent(event)
+}
+
+// ListScreeningEvents reads a user's screening audit trail from the primary shard
+func (s *ShardedDB) ListScreeningEvents(userID int) ([]models.ScreeningEvent, error) {
+	return s.primary().ListScreeningEvents(userID)
+}
+
+// CreatePaymentConsent stores a payment consent on the primary shard, where the bank's redirect
+// looks it up by state without knowing its merchant
+func (s *ShardedDB) CreatePaymentConsent(consent models.PaymentConsent) (int, error) {
+	return s.primary().CreatePaymentConsent(consent)
+}
+
+// GetPaymentConsentByState reads a payment consent from the primary shard
+func (s *ShardedDB) GetPaymentConsentByState(stateHash string) (*models.PaymentConsent, error) {
+	return s.primary().GetPaymentConsentByState(stateHash)
+}
+
+// GetPaymentConsentByTransaction reads a transaction's payment consent from the primary shard
+func (s *ShardedDB) GetPaymentConsentByTransaction(txID int) (*models.PaymentConsent, error) {
+	return s.primary().GetPaymentConsentByTransaction(txID)
+}
+
+// ListExpiredPaymentConsents lists expired payment consents on the primary shard
+func (s *ShardedDB) ListExpiredPaymentConsents(now time.Time, limit int) ([]models.PaymentConsent, error) {
+	return s.primary().ListExpiredPaymentConsents(now, limit)
+}
+
+// UpdatePaymentConsentStatus updates a payment consent on the primary shard
+func (s *ShardedDB) UpdatePaymentConsentStatus(consentID int, status string, updatedAt time.Time) error {
+	return s.primary().UpdatePaymentConsentStatus(consentID, status, updatedAt)
+}
+
+// CreateBankAccountConsent stores a bank account consent on the primary shard, where the bank's
+// redirect looks it up by state without knowing its user
+func (s *ShardedDB) CreateBankAccountConsent(consent models.BankAccountConsent) (int, error) {
+	return s.primary().CreateBankAccountConsent(consent)
+}
+
+// GetBankAccountConsentByState reads a bank account consent from the primary shard
+func (s *ShardedDB) GetBankAccountConsentByState(stateHash string) (*models.BankAccountConsent, error) {
+	return s.primary().GetBankAccountConsentByState(stateHash)
+}
+
+// UpdateBankAccountConsentStatus updates a bank account consent on the primary shard
+func (s *ShardedDB) UpdateBankAccountConsentStatus(consentID int, status string, updatedAt time.Time) error {
+	return s.primary().UpdateBankAccountConsentStatus(consentID, status, updatedAt)
+}
+
+// StoreBankAccountConsentTokens stores a bank account consent's tokens on the primary shard
+func (s *ShardedDB) StoreBankAccountConsentTokens(consentID int, accessToken, refreshToken string, expiresAt time.Time) error {
+	return s.primary().StoreBankAccountConsentTokens(consentID, accessToken, refreshToken, expiresAt)
+}
+
+// SaveBankAccount stores a linked bank account on the primary shard with its consent, so
+// withdrawals on any shard can look it up by ID
+func (s *ShardedDB) SaveBankAccount(account models.BankAccount) (int, error) {
+	return s.primary().SaveBankAccount(account)
+}
+
+// GetBankAccountByID reads a linked bank account from the primary shard
+func (s *ShardedDB) GetBankAccountByID(accountID int) (*models.BankAccount, error) {
+	return s.primary().GetBankAccountByID(accountID)
+}
+
+// ListBankAccounts lists a user's linked bank accounts on the primary shard
+func (s *ShardedDB) ListBankAccounts(userID int) ([]models.BankAccount, error) {
+	return s.primary().ListBankAccounts(userID)
+}
+
+// RevokeBankAccount revokes a linked bank account on the primary shard
+func (s *ShardedDB) RevokeBankAccount(accountID int, updatedAt time.Time) error {
+	return s.primary().RevokeBankAccount(accountID, updatedAt)
+}
+
+// GetWalletBalance sums a user's wallet ledger on the shard of their ID, which holds their
+// transactions and transfers
+func (s *ShardedDB) GetWalletBalance(userID int, currency string, livemode bool) (float64, error) {
+	return s.byID(userID).GetWalletBalance(userID, currency, livemode)
+}
+
+// CreateTransfer records a transfer on its merchant's shard, which holds both users and their
+// transfers
+func (s *ShardedDB) CreateTransfer(transfer models.Transfer, since time.Time, check TransferCheck) (*models.Transfer, error) {
+	return s.byMerchant(transfer.MerchantID).CreateTransfer(transfer, since, check)
+}
+
+// GetTransfer fetches a transfer from the shard of the user who sent or received it
+func (s *ShardedDB) GetTransfer(transferID, userID int) (*models.Transfer, error) {
+	return s.byID(userID).GetTransfer(transferID, userID)
+}
+
+// CreateMandate stores a SEPA mandate on the primary shard, so transactions on any shard can look
+// it up by ID
+func (s *ShardedDB) CreateMandate(mandate models.Mandate) (int, error) {
+	return s.primary().CreateMandate(mandate)
+}
+
+// GetMandateByID reads a SEPA mandate from the primary shard
+func (s *ShardedDB) GetMandateByID(mandateID int) (*models.Mandate, error) {
+	return s.primary().GetMandateByID(mandateID)
+}
+
+// ListMandates lists a user's SEPA mandates on the primary shard
+func (s *ShardedDB) ListMandates(userID int) ([]models.Mandate, error) {
+	return s.primary().ListMandates(userID)
+}
+
+// UpdateMandateSequenceType updates a SEPA mandate's sequence type on the primary shard
+func (s *ShardedDB) UpdateMandateSequenceType(mandateID int, sequenceType string) error {
+	return s.primary().UpdateMandateSequenceType(mandateID, sequenceType)
+}
+
+// RevokeMandate revokes a SEPA mandate on the primary shard
+func (s *ShardedDB) RevokeMandate(mandateID int, reason string, updatedAt time.Time) error {
+	return s.primary().RevokeMandate(mandateID, reason, updatedAt)
+}
+
+// CreateAutoReloadRule stores an auto-reload rule on the primary shard, next to the mandate it
+// deposits under, so the scheduler claims every merchant's rules from one place
+func (s *ShardedDB) CreateAutoReloadRule(rule models.AutoReloadRule) (int, error) {
+	return s.primary().CreateAutoReloadRule(rule)
+}
+
+// GetAutoReloadRuleByID reads an auto-reload rule from the primary shard
+func (s *ShardedDB) GetAutoReloadRuleByID(ruleID int) (*models.AutoReloadRule, error) {
+	return s.primary().GetAutoReloadRuleByID(ruleID)
+}
+
+// ListAutoReloadRules lists a user's auto-reload rules on the primary shard
+func (s *ShardedDB) ListAutoReloadRules(userID int) ([]models.AutoReloadRule, error) {
+	return s.primary().ListAutoReloadRules(userID)
+}
+
+// ClaimAutoReloadRules claims active auto-reload rules on the primary shard
+func (s *ShardedDB) ClaimAutoReloadRules(now, claimedBefore time.Time, limit int) ([]models.AutoReloadRule, error) {
+	return s.primary().ClaimAutoReloadRules(now, claimedBefore, limit)
+}
+
+// UpdateAutoReloadRule saves an auto-reload rule's state on the primary shard
+func (s *ShardedDB) UpdateAutoReloadRule(rule models.AutoReloadRule) error {
+	return s.primary().UpdateAutoReloadRule(rule)
+}
+
+// CreateOutboxMessages records outbox messages on the primary shard
+func (s *ShardedDB) CreateOutboxMessages(messages []models.OutboxMessage) error {
+	return s.primary().CreateOutboxMessages(messages)
+}
+
+// GetPendingOutboxMessages fetches due outbox messages from the primary shard
+func (s *ShardedDB) GetPendingOutboxMessages(destination string, now time.Time, limit int) ([]models.OutboxMessage, error) {
+	return s.primary().GetPendingOutboxMessages(destination, now, limit)
+}
+
+// MarkOutboxMessageDelivered updates an outbox message on the primary shard
+func (s *ShardedDB) MarkOutboxMessageDelivered(messageID int) error {
+	return s.primary().MarkOutboxMessageDelivered(messageID)
+}
+
+// MarkOutboxMessageRetry updates an outbox message on the primary shard
+func (s *ShardedDB) MarkOutboxMessageRetry(messageID int, errorMsg string, nextAttemptAt time.Time, failed bool) error {
+	return s.primary().MarkOutboxMessageRetry(messageID, errorMsg, nextAttemptAt, failed)
+}
+
+// GetOutboxMessage fetches an outbox message from the primary shard
+func (s *ShardedDB) GetOutboxMessage(messageID int) (*models.OutboxMessage, error) {
+	return s.primary().GetOutboxMessage(messageID)
+}
+
+// ListOutboxMessages lists outbox messages on the primary shard
+func (s *ShardedDB) ListOutboxMessages(destination, status string, limit int) ([]models.OutboxMessage, error) {
+	return s.primary().ListOutboxMessages(destination, status, limit)
+}
+
+// RequeueOutboxMessage updates an outbox message on the primary shard
+func (s *ShardedDB) RequeueOutboxMessage(messageID int, nextAttemptAt time.Time) error {
+	return s.primary().RequeueOutboxMessage(messageID, nextAttemptAt)
+}
+
+// DiscardOutboxMessage updates an outbox message on the primary shard
+func (s *ShardedDB) DiscardOutboxMessage(messageID int) error {
+	return s.primary().DiscardOutboxMessage(messageID)
+}
+
+// CreateBatch creates a batch on the primary shard
+func (s *ShardedDB) CreateBatch(batch models.Batch) (int, error) {
+	return s.primary().CreateBatch(batch)
+}
+
+// GetBatchByID fetches a batch from the primary shard
+func (s *ShardedDB) GetBatchByID(batchID int) (*models.Batch, error) {
+	return s.primary().GetBatchByID(batchID)
+}
+
+// UpdateBatch updates a batch on the primary shard
+func (s *ShardedDB) UpdateBatch(batch models.Batch) error {
+	return s.primary().UpdateBatch(batch)
+}
+
+// CreateOperation creates an operation on the primary shard
+func (s *ShardedDB) CreateOperation(op models.Operation) error {
+	return s.primary().CreateOperation(op)
+}
+
+// GetOperationByID fetches an operation from the primary shard
+func (s *ShardedDB) GetOperationByID(operationID string) (*models.Operation, error) {
+	return s.primary().GetOperationByID(operationID)
+}
+
+// GetLatestOperationByType fetches the latest operation of a type from the primary shard
+func (s *ShardedDB) GetLatestOperationByType(opType string) (*models.Operation, error) {
+	return s.primary().GetLatestOperationByType(opType)
+}
+
+// UpdateOperation updates an operation on the primary shard
+func (s *ShardedDB) UpdateOperation(op models.Operation) error {
+	return s.primary().UpdateOperation(op)
+}
+
+// DeleteExpiredOperations removes expired operations from the primary shard
+func (s *ShardedDB) DeleteExpiredOperations(before time.Time) (int64, error) {
+	return s.primary().DeleteExpiredOperations(before)
+}
+
+// EnsureMonthlyPartitions creates missing partitions on every shard that supports partitioning
+func (s *ShardedDB) EnsureMonthlyPartitions(table string, from time.Time, months int) ([]string, error) {
+	var mu sync.Mutex
+	var created []string
+
+	err := s.ForEachShard(func(index int, shard DBInterface) error {
+		partitioner, ok := shard.(Partitioner)
+		if !ok {
+			return nil
+		}
+
+		names, err := partitioner.EnsureMonthlyPartitions(table, from, months)
+
+		mu.Lock()
+		for _, name := range names {
+			created = append(created, fmt.Sprintf("%s (shard %d)", name, index))
+		}
+		mu.Unlock()
+
+		return err
+	})
+
+	return created, err
+}
+
+// Ping checks every shard's connection
+func (s *ShardedDB) Ping() error {
+	return s.ForEachShard(func(_ int, shard DBInterface) error {
+		return shard.Ping()
+	})
+}
+
+// Close closes every shard's connection
+func (s *ShardedDB) Close() error {
+	return s.ForEachShard(func(_ int, shard DBInterface) error {
+		return shard.Close()
+	})
+}
+
+// CreateRefund records a refund on the shard of the transaction it refunds, where its
+// refundable amount is checked
+func (s *ShardedDB) CreateRefund(refund models.Refund) (int, error) {
+	return s.byID(refund.TransactionID).CreateRefund(refund)
+}
+
+// UpdateRefund updates a refund on the shard of its transaction
+func (s *ShardedDB) UpdateRefund(refund models.Refund) error {
+	return s.byID(refund.TransactionID).UpdateRefund(refund)
+}
+
+// GetRefund searches every shard, as refund IDs don't identify their transaction's shard
+func (s *ShardedDB) GetRefund(refundID int) (*models.Refund, error) {
+	var mu sync.Mutex
+	var found *models.Refund
+
+	err := s.ForEachShard(func(_ int, shard DBInterface) error {
+		refund, err := shard.GetRefund(refundID)
+		if err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				return nil
+			}
+			return err
+		}
+		mu.Lock()
+		found = refund
+		mu.Unlock()
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if found == nil {
+		return nil, fmt.Errorf("refund not found: %w", sql.ErrNoRows)
+	}
+	return found, nil
+}
+
+// GetTransactionRefunds fetches a transaction's refunds from its shard
+func (s *ShardedDB) GetTransactionRefunds(txID int) ([]models.Refund, error) {
+	return s.byID(txID).GetTransactionRefunds(txID)
+}
+
+// AddTransactionTags tags a transaction on its shard
+func (s *ShardedDB) AddTransactionTags(txID int, tags []string, createdAt time.Time) error {
+	return s.byID(txID).AddTransactionTags(txID, tags, createdAt)
+}
+
+// RemoveTransactionTag removes a tag from a transaction on its shard
+func (s *ShardedDB) RemoveTransactionTag(txID int, tag string) error {
+	return s.byID(txID).RemoveTransactionTag(txID, tag)
+}
+
+// GetTransactionTags fetches a transaction's tags from its shard
+func (s *ShardedDB) GetTransactionTags(txID int) ([]string, error) {
+	return s.byID(txID).GetTransactionTags(txID)
+}
+
+// ListTransactions reads a merchant's shard, which holds its users' transactions, or every shard
+// when the filter names no merchant
+func (s *ShardedDB) ListTransactions(filter models.TransactionFilter) ([]models.TransactionSummary, error) {
+	if filter.MerchantID > 0 {
+		return s.byMerchant(filter.MerchantID).ListTransactions(filter)
+	}
+
+	var mu sync.Mutex
+	var transactions []models.TransactionSummary
+
+	err := s.ForEachShard(func(_ int, shard DBInterface) error {
+		matches, err := shard.ListTransactions(filter)
+		if err != nil {
+			return err
+		}
+
+		mu.Lock()
+		transactions = append(transactions, matches...)
+		mu.Unlock()
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Slice(transactions, func(i, j int) bool { return transactions[i].ID > transactions[j].ID })
+	if filter.Limit > 0 && len(transactions) > filter.Limit {
+		transactions = transactions[:filter.Limit]
+	}
+	return transactions, nil
+}
+
+// CreateWebhookEndpoint records a webhook endpoint on its merchant's shard
+func (s *ShardedDB) CreateWebhookEndpoint(endpoint models.WebhookEndpoint) (int, error) {
+	return s.byMerchant(endpoint.MerchantID).CreateWebhookEndpoint(endpoint)
+}
+
+// GetWebhookEndpoints fetches a merchant's webhook endpoints from the merchant's shard
+func (s *ShardedDB) GetWebhookEndpoints(merchantID int) ([]models.WebhookEndpoint, error) {
+	return s.byMerchant(merchantID).GetWebhookEndpoints(merchantID)
+}
+
+// GetWebhookEndpoint fetches a webhook endpoint from its merchant's shard
+func (s *ShardedDB) GetWebhookEndpoint(merchantID, endpointID int) (*models.WebhookEndpoint, error) {
+	return s.byMerchant(merchantID).GetWebhookEndpoint(merchantID, endpointID)
+}
+
+// UpdateWebhookEndpoint updates a webhook endpoint on its merchant's shard
+func (s *ShardedDB) UpdateWebhookEndpoint(endpoint models.WebhookEndpoint) error {
+	return s.byMerchant(endpoint.MerchantID).UpdateWebhookEndpoint(endpoint)
+}
+
+// DeleteWebhookEndpoint deletes a webhook endpoint from its merchant's shard
+func (s *ShardedDB) DeleteWebhookEndpoint(merchantID, endpointID int) error {
+	return s.byMerchant(merchantID).DeleteWebhookEndpoint(merchantID, endpointID)
+}
@@ -0,0 +1,83 @@
+package db
+
+import (
+	"payment-gateway/internal/consts"
+	"payment-gateway/internal/models"
+	"testing"
+	"time"
+)
+
+// TestModuloResolver tests that IDs map to shards by modulo and invalid IDs to the primary
+func TestModuloResolver(t *testing.T) {
+	resolver := ModuloResolver{Shards: 3}
+
+	tests := map[int]int{1: 1, 2: 2, 3: 0, 7: 1, 0: 0, -4: 0}
+	for id, expected := range tests {
+		if shard := resolver.ShardForID(id); shard != expected {
+			t.Errorf("Expected ID %d on shard %d, got %d", id, expected, shard)
+		}
+	}
+}
+
+// TestShardedDBRoutesTransactionsByUser tests that transactions are created and read on their user's shard
+func TestShardedDBRoutesTransactionsByUser(t *testing.T) {
+	primary, secondary := NewMockDB(), NewMockDB()
+	sharded, err := NewShardedDB([]DBInterface{primary, secondary}, ModuloResolver{Shards: 2})
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+
+	// User 1 resolves to shard 1, whose first transaction ID also resolves to shard 1
+	txID, err := sharded.CreateTransaction(models.Transaction{UserID: 1, Status: consts.Pending})
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+
+	if _, err := secondary.GetTransactionByID(txID); err != nil {
+		t.Errorf("Expected transaction on shard 1, got: %v", err)
+	}
+	if _, err := primary.GetTransactionByID(txID); err == nil {
+		t.Error("Expected no transaction on shard 0")
+	}
+
+	if err := sharded.UpdateTransactionStatus(txID, consts.Pending, consts.Completed, ""); err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	if tx, err := sharded.GetTransactionByID(txID); err != nil || tx.Status != consts.Completed {
+		t.Errorf("Expected completed transaction, got %+v (err: %v)", tx, err)
+	}
+}
+
+// TestShardedDBRejectsMisconfiguredSequences tests that IDs resolving to another shard are reported
+func TestShardedDBRejectsMisconfiguredSequences(t *testing.T) {
+	sharded, _ := NewShardedDB([]DBInterface{NewMockDB(), NewMockDB()}, ModuloResolver{Shards: 2})
+
+	// User 2 resolves to shard 0, but the mock's first transaction ID resolves to shard 1
+	if _, err := sharded.CreateTransaction(models.Transaction{UserID: 2}); err == nil {
+		t.Error("Expected error for transaction ID resolving to another shard")
+	}
+}
+
+// TestShardedDBFanOut tests that cross-shard admin queries merge results from every shard
+func TestShardedDBFanOut(t *testing.T) {
+	primary, secondary := NewMockDB(), NewMockDB()
+	sharded, _ := NewShardedDB([]DBInterface{primary, secondary}, ModuloResolver{Shards: 2})
+
+	old := time.Now().AddDate(0, -6, 0)
+	primary.CreateTransaction(models.Transaction{Status: consts.Completed, CreatedAt: old})
+	secondary.CreateTransaction(models.Transaction{Status: consts.Failed, CreatedAt: old})
+	secondary.CreateTransaction(models.Transaction{Status: consts.Pending})
+
+	archived, err := sharded.ArchiveTransactions(time.Now().AddDate(0, -1, 0), true, 10)
+	if err != nil || archived != 2 {
+		t.Errorf("Expected 2 transactions archived, got %d (err: %v)", archived, err)
+	}
+
+	stats, err := sharded.GetArchivalStats()
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	if stats.HotTransactions != 1 || stats.ArchivedTransactions != 2 {
+		t.Errorf("Unexpected merged stats: %+v", stats)
+	}
+}
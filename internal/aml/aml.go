@@ -0,0 +1,175 @@
+// Package aml renders AML cases as report files to file with a financial intelligence unit.
+// Reports are generated on request and never stored; the cases they list stay in the review
+// queue until a compliance officer marks them reported.
+//
+// Two formats are supported:
+//
+//	csv    one row per case, for regulators accepting spreadsheets
+//	goaml  XML in the report layout of UNODC's goAML, used by many FIUs
+package aml
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"payment-gateway/internal/consts"
+	"payment-gateway/internal/models"
+	"strconv"
+	"time"
+)
+
+var ErrUnknownFormat = errors.New("unknown AML report format")
+
+// goAMLTimeFormat is goAML's date-time format, without a zone
+const goAMLTimeFormat = "2006-01-02T15:04:05"
+
+// Columns are the CSV columns written for each case
+var Columns = []string{
+	"case_id", "transaction_id", "reference_id", "type", "transaction_date", "country_code", "amount", "currency",
+	"threshold", "originator_name", "originator_account", "originator_address", "beneficiary_name",
+	"beneficiary_account", "status",
+}
+
+// Report is a set of AML cases filed together by a reporting entity
+type Report struct {
+	ReportingEntityID string // the entity's registration with the FIU
+	GeneratedAt       time.Time
+	Cases             []models.AMLCase
+}
+
+// Render encodes a report in a format, returning the file and its content type
+func Render(format string, report Report) ([]byte, string, error) {
+	switch format {
+	case consts.AMLReportCSV:
+		data, err := renderCSV(report)
+		return data, "text/csv", err
+	case consts.AMLReportGoAML:
+		data, err := renderGoAML(report)
+		return data, "application/xml", err
+	default:
+		return nil, "", fmt.Errorf("%w: %q", ErrUnknownFormat, format)
+	}
+}
+
+// FileName returns the name of a report file generated at a time
+func FileName(format string, generatedAt time.Time) string {
+	extension := "csv"
+	if format == consts.AMLReportGoAML {
+		extension = "xml"
+	}
+	return fmt.Sprintf("aml-report-%s.%s", generatedAt.UTC().Format("20060102T150405Z"), extension)
+}
+
+// renderCSV writes a header row and one row per case
+func renderCSV(report Report) ([]byte, error) {
+	var buf bytes.Buffer
+	writer := csv.NewWriter(&buf)
+	if err := writer.Write(Columns); err != nil {
+		return nil, err
+	}
+
+	for _, c := range report.Cases {
+		row := []string{
+			strconv.Itoa(c.ID),
+			strconv.Itoa(c.TransactionID),
+			c.ReferenceID,
+			c.Type,
+			c.CreatedAt.UTC().Format(time.RFC3339),
+			c.CountryCode,
+			formatAmount(c.Amount),
+			c.Currency,
+			formatAmount(c.Threshold),
+			c.TravelRule.OriginatorName,
+			c.TravelRule.OriginatorAccount,
+			c.TravelRule.OriginatorAddress,
+			c.TravelRule.BeneficiaryName,
+			c.TravelRule.BeneficiaryAccount,
+			c.Status,
+		}
+		if err := writer.Write(row); err != nil {
+			return nil, err
+		}
+	}
+
+	writer.Flush()
+	return buf.Bytes(), writer.Error()
+}
+
+// goAMLReport is the root element of a goAML report
+type goAMLReport struct {
+	XMLName        xml.Name           `xml:"report"`
+	RentityID      string             `xml:"rentity_id"`
+	SubmissionCode string             `xml:"submission_code"`
+	ReportCode     string             `xml:"report_code"`
+	SubmissionDate string             `xml:"submission_date"`
+	Transactions   []goAMLTransaction `xml:"transaction"`
+}
+
+// goAMLTransaction is a reported transaction between two accounts
+type goAMLTransaction struct {
+	TransactionNumber      string       `xml:"transactionnumber"`
+	InternalRefNumber      string       `xml:"internal_ref_number,omitempty"`
+	TransactionLocation    string       `xml:"transaction_location"`
+	TransactionDescription string       `xml:"transaction_description"`
+	DateTransaction        string       `xml:"date_transaction"`
+	AmountLocal            string       `xml:"amount_local"`
+	CurrencyCode           string       `xml:"currency_code"`
+	From                   goAMLAccount `xml:"t_from>from_account"`
+	FromCountry            string       `xml:"t_from>from_country"`
+	To                     goAMLAccount `xml:"t_to>to_account"`
+	ToCountry              string       `xml:"t_to>to_country"`
+}
+
+// goAMLAccount is the account funds move from or to, and its holder
+type goAMLAccount struct {
+	AccountName string `xml:"account_name"`
+	Account     string `xml:"account"`
+	Address     string `xml:"address,omitempty"`
+}
+
+// renderGoAML writes the cases as the transactions of one electronic (E) submission of a
+// currency transaction report (CTR)
+func renderGoAML(report Report) ([]byte, error) {
+	doc := goAMLReport{
+		RentityID:      report.ReportingEntityID,
+		SubmissionCode: "E",
+		ReportCode:     "CTR",
+		SubmissionDate: report.GeneratedAt.UTC().Format(goAMLTimeFormat),
+	}
+
+	for _, c := range report.Cases {
+		doc.Transactions = append(doc.Transactions, goAMLTransaction{
+			TransactionNumber:      strconv.Itoa(c.TransactionID),
+			InternalRefNumber:      c.ReferenceID,
+			TransactionLocation:    c.CountryCode,
+			TransactionDescription: fmt.Sprintf("%s reaching the %s %s threshold", c.Type, formatAmount(c.Threshold), c.Currency),
+			DateTransaction:        c.CreatedAt.UTC().Format(goAMLTimeFormat),
+			AmountLocal:            formatAmount(c.Amount),
+			CurrencyCode:           c.Currency,
+			From: goAMLAccount{
+				AccountName: c.TravelRule.OriginatorName,
+				Account:     c.TravelRule.OriginatorAccount,
+				Address:     c.TravelRule.OriginatorAddress,
+			},
+			FromCountry: c.CountryCode,
+			To: goAMLAccount{
+				AccountName: c.TravelRule.BeneficiaryName,
+				Account:     c.TravelRule.BeneficiaryAccount,
+			},
+			ToCountry: c.CountryCode,
+		})
+	}
+
+	data, err := xml.MarshalIndent(doc, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte(xml.Header), data...), nil
+}
+
+// formatAmount renders an amount with two decimals
+func formatAmount(amount float64) string {
+	return strconv.FormatFloat(amount, 'f', 2, 64)
+}
@@ -0,0 +1,47 @@
+package aml
+
+import (
+	"encoding/csv"
+	"errors"
+	"payment-gateway/internal/consts"
+	"payment-gateway/internal/models"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRenderCSV(t *testing.T) {
+	report := Report{
+		ReportingEntityID: "FIU-42",
+		GeneratedAt:       time.Date(2026, 10, 17, 12, 0, 0, 0, time.UTC),
+		Cases: []models.AMLCase{{
+			ID: 1, TransactionID: 7, ReferenceID: "ref-7", Type: consts.Withdrawal, CountryCode: "US", Amount: 5000, Currency: "USD",
+			Threshold: 3000, TravelRule: models.TravelRuleInfo{OriginatorName: "Doe, Jane", BeneficiaryName: "Acme"}, Status: consts.AMLCaseInReview,
+		}},
+	}
+
+	data, contentType, err := Render(consts.AMLReportCSV, report)
+	if err != nil || contentType != "text/csv" {
+		t.Fatalf("Render failed: %s, %v", contentType, err)
+	}
+
+	rows, err := csv.NewReader(strings.NewReader(string(data))).ReadAll()
+	if err != nil || len(rows) != 2 {
+		t.Fatalf("Expected a header and one row, got %v, %v", rows, err)
+	}
+	if strings.Join(rows[0], ",") != strings.Join(Columns, ",") {
+		t.Errorf("Unexpected header: %v", rows[0])
+	}
+	if row := rows[1]; row[1] != "7" || row[6] != "5000.00" || row[8] != "3000.00" || row[9] != "Doe, Jane" {
+		t.Errorf("Unexpected row: %v", row)
+	}
+}
+
+func TestRenderUnknownFormat(t *testing.T) {
+	if _, _, err := Render("pdf", Report{}); !errors.Is(err, ErrUnknownFormat) {
+		t.Errorf("Expected ErrUnknownFormat, got: %v", err)
+	}
+	if name := FileName(consts.AMLReportGoAML, time.Date(2026, 10, 17, 12, 0, 0, 0, time.UTC)); name != "aml-report-20261017T120000Z.xml" {
+		t.Errorf("Unexpected file name %s", name)
+	}
+}
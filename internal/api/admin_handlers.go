@@ -0,0 +1,1643 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"payment-gateway/internal/aml"
+	"payment-gateway/internal/consts"
+	"payment-gateway/internal/geo"
+	"payment-gateway/internal/httpclient"
+	"payment-gateway/internal/httpserver"
+	"payment-gateway/internal/models"
+	"payment-gateway/internal/services"
+	"payment-gateway/internal/utils"
+	"payment-gateway/internal/validation"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// ArchivalStatusHandler reports the retention policy, table sizes and the latest archival run
+// @Summary Get archival status
+// @Description Report the retention policy, hot and archive table sizes and the most recent archival run
+// @Tags admin
+// @Produce json,xml
+// @Success 200 {object} models.ArchivalStatus
+// @Failure 500 {object} models.APIResponse
+// @Router /admin/archival [get]
+func (h *Handler) ArchivalStatusHandler(w http.ResponseWriter, r *http.Request) {
+	status, err := h.retentionService.Status(r.Context())
+	if err != nil {
+		utils.SendErrorResponse(w, r, http.StatusInternalServerError, fmt.Sprintf("Failed to get archival status: %v", err))
+		return
+	}
+
+	utils.SendResponse(w, r, http.StatusOK, status)
+}
+
+// StartArchivalHandler starts an archival run outside of the regular schedule
+// @Summary Start an archival run
+// @Description Move aged and soft-deleted transactions to the archive; poll the returned operation for progress
+// @Tags admin
+// @Produce json,xml
+// @Success 202 {object} models.Operation
+// @Failure 409 {object} models.APIResponse
+// @Failure 500 {object} models.APIResponse
+// @Router /admin/archival [post]
+func (h *Handler) StartArchivalHandler(w http.ResponseWriter, r *http.Request) {
+	op, err := h.retentionService.StartArchival(r.Context())
+	if err != nil {
+		if errors.Is(err, services.ErrArchivalInProgress) {
+			utils.SendErrorResponse(w, r, http.StatusConflict, err.Error())
+			return
+		}
+		utils.SendErrorResponse(w, r, http.StatusInternalServerError, fmt.Sprintf("Failed to start archival: %v", err))
+		return
+	}
+
+	utils.SendResponse(w, r, http.StatusAccepted, op)
+}
+
+// DeleteTransactionHandler soft-deletes a completed or failed transaction
+// @Summary Soft delete a transaction
+// @Description Hide a completed or failed transaction from lookups; it is moved to the archive on the next run
+// @Tags admin
+// @Produce json,xml
+// @Param transaction_id path int true "Transaction ID"
+// @Success 200 {object} map[string]string
+// @Failure 400 {object} models.APIResponse
+// @Failure 404 {object} models.APIResponse
+// @Failure 409 {object} models.APIResponse
+// @Router /admin/transactions/{transaction_id} [delete]
+func (h *Handler) DeleteTransactionHandler(w http.ResponseWriter, r *http.Request) {
+	txID, err := strconv.Atoi(mux.Vars(r)["transaction_id"])
+	if err != nil || txID <= 0 {
+		utils.SendErrorResponse(w, r, http.StatusBadRequest, "Invalid transaction ID")
+		return
+	}
+
+	if err := h.retentionService.SoftDelete(r.Context(), txID); err != nil {
+		switch {
+		case errors.Is(err, services.ErrTransactionNotFound):
+			utils.SendErrorResponse(w, r, http.StatusNotFound, fmt.Sprintf("Transaction not found: %d", txID))
+		case errors.Is(err, services.ErrTransactionNotDeletable):
+			utils.SendErrorResponse(w, r, http.StatusConflict, err.Error())
+		default:
+			utils.SendErrorResponse(w, r, http.StatusInternalServerError, err.Error())
+		}
+		return
+	}
+
+	utils.SendResponse(w, r, http.StatusOK, map[string]string{"status": "deleted"})
+}
+
+// UpdateUserContactHandler replaces a user's phone number and postal code
+// @Summary Update a user's contact details
+// @Description Check the phone number and postal code against the user's country and store them as entered together with their normalized forms
+// @Tags admin
+// @Accept json,xml
+// @Produce json,xml
+// @Param user_id path int true "User ID"
+// @Param contact body models.UserContactRequest true "Contact details"
+// @Success 200 {object} models.User
+// @Failure 400 {object} models.APIResponse
+// @Failure 404 {object} models.APIResponse
+// @Failure 500 {object} models.APIResponse
+// @Router /admin/users/{user_id}/contact [put]
+func (h *Handler) UpdateUserContactHandler(w http.ResponseWriter, r *http.Request) {
+	userID, err := strconv.Atoi(mux.Vars(r)["user_id"])
+	if err != nil || userID <= 0 {
+		utils.SendErrorResponse(w, r, http.StatusBadRequest, "Invalid user ID")
+		return
+	}
+
+	var request models.UserContactRequest
+	if err := utils.DecodeRequest(r, &request); err != nil {
+		utils.SendDecodeError(w, r, err)
+		return
+	}
+	if err := validation.Struct(request); err != nil {
+		utils.SendValidationError(w, r, err)
+		return
+	}
+
+	user, err := h.users.UpdateContact(r.Context(), userID, request)
+	if err != nil {
+		switch {
+		case errors.Is(err, services.ErrUserNotFound):
+			utils.SendErrorResponse(w, r, http.StatusNotFound, fmt.Sprintf("User not found: %d", userID))
+		case errors.Is(err, geo.ErrInvalidPhoneNumber), errors.Is(err, geo.ErrInvalidPostalCode):
+			utils.SendErrorResponse(w, r, http.StatusBadRequest, err.Error())
+		default:
+			utils.SendErrorResponse(w, r, http.StatusInternalServerError, fmt.Sprintf("Failed to update user contact: %v", err))
+		}
+		return
+	}
+
+	utils.SendResponse(w, r, http.StatusOK, user)
+}
+
+// CreateUserHandler registers a user
+// @Summary Create a user
+// @Description Register a user in a country, optionally for a merchant. When sanctions screening is enabled the full name is screened first; a match creates the user with screening_status pending_review and opens a screening case, and their transactions are refused until it is cleared.
+// @Tags admin
+// @Accept json,xml
+// @Produce json,xml
+// @Param user body models.UserCreateRequest true "User"
+// @Success 201 {object} models.User
+// @Failure 400 {object} models.APIResponse
+// @Failure 404 {object} models.APIResponse
+// @Failure 500 {object} models.APIResponse
+// @Failure 503 {object} models.APIResponse
+// @Router /admin/users [post]
+func (h *Handler) CreateUserHandler(w http.ResponseWriter, r *http.Request) {
+	var request models.UserCreateRequest
+	if err := utils.DecodeRequest(r, &request); err != nil {
+		utils.SendDecodeError(w, r, err)
+		return
+	}
+	if err := validation.Struct(request); err != nil {
+		utils.SendValidationError(w, r, err)
+		return
+	}
+
+	user, err := h.users.Create(r.Context(), request)
+	if err != nil {
+		switch {
+		case errors.Is(err, services.ErrUnsupportedCountry):
+			utils.SendErrorResponse(w, r, http.StatusBadRequest, err.Error())
+		case errors.Is(err, services.ErrMerchantNotFound):
+			utils.SendErrorResponse(w, r, http.StatusNotFound, fmt.Sprintf("Merchant not found: %d", request.MerchantID))
+		case errors.Is(err, services.ErrScreeningUnavailable):
+			utils.SendErrorResponse(w, r, http.StatusServiceUnavailable, err.Error())
+		default:
+			utils.SendErrorResponse(w, r, http.StatusInternalServerError, fmt.Sprintf("Failed to create user: %v", err))
+		}
+		return
+	}
+
+	utils.SendResponse(w, r, http.StatusCreated, user)
+}
+
+// ListScreeningEventsHandler returns a user's sanctions screening audit trail
+// @Summary List a user's screening events
+// @Description The audit trail of a user's sanctions screenings, matches and the reviewers' decisions on them, oldest first
+// @Tags admin
+// @Produce json,xml
+// @Param user_id path int true "User ID"
+// @Success 200 {array} models.ScreeningEvent
+// @Failure 400 {object} models.APIResponse
+// @Failure 404 {object} models.APIResponse
+// @Failure 500 {object} models.APIResponse
+// @Router /admin/users/{user_id}/screening-events [get]
+func (h *Handler) ListScreeningEventsHandler(w http.ResponseWriter, r *http.Request) {
+	userID, err := strconv.Atoi(mux.Vars(r)["user_id"])
+	if err != nil || userID <= 0 {
+		utils.SendErrorResponse(w, r, http.StatusBadRequest, "Invalid user ID")
+		return
+	}
+
+	events, err := h.screening.Events(r.Context(), userID)
+	if err != nil {
+		if errors.Is(err, services.ErrUserNotFound) {
+			utils.SendErrorResponse(w, r, http.StatusNotFound, fmt.Sprintf("User not found: %d", userID))
+			return
+		}
+		utils.SendErrorResponse(w, r, http.StatusInternalServerError, fmt.Sprintf("Failed to list screening events: %v", err))
+		return
+	}
+
+	utils.SendResponse(w, r, http.StatusOK, events)
+}
+
+// SearchTransactionsHandler finds transactions for support agents without exposing bulk PII
+// @Summary Search transactions
+// @Description Search by transaction ID, amount, user email or gateway reference. Emails are matched by blind index and results are redacted.
+// @Tags admin
+// @Produce json,xml
+// @Param q query string true "Transaction ID, amount, email or gateway reference"
+// @Success 200 {array} models.TransactionSearchResult
+// @Failure 400 {object} models.APIResponse
+// @Failure 500 {object} models.APIResponse
+// @Router /admin/search [get]
+func (h *Handler) SearchTransactionsHandler(w http.ResponseWriter, r *http.Request) {
+	results, err := h.transactionService.SearchTransactions(r.Context(), r.URL.Query().Get("q"))
+	if err != nil {
+		if errors.Is(err, services.ErrInvalidSearchQuery) {
+			utils.SendErrorResponse(w, r, http.StatusBadRequest, err.Error())
+			return
+		}
+		utils.SendErrorResponse(w, r, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	if results == nil {
+		results = []models.TransactionSearchResult{}
+	}
+	utils.SendResponse(w, r, http.StatusOK, results)
+}
+
+// RealtimeMetricsHandler reports recent outcomes per gateway and country for live dashboards
+// @Summary Get realtime gateway metrics
+// @Description Rolling 1m, 5m and 1h counts and success rates of completed and failed transactions per gateway and country, kept in memory by this instance
+// @Tags admin
+// @Produce json,xml
+// @Success 200 {object} models.RealtimeMetrics
+// @Router /admin/metrics/realtime [get]
+func (h *Handler) RealtimeMetricsHandler(w http.ResponseWriter, r *http.Request) {
+	utils.SendResponse(w, r, http.StatusOK, h.metrics.Snapshot())
+}
+
+// HTTPClientStatsHandler reports the requests providers' HTTP clients have sent per host
+// @Summary Get provider HTTP client metrics
+// @Description Attempts, retries, failures, timeouts, reused connections and latency per provider and host since startup, kept in memory by this instance
+// @Tags admin
+// @Produce json,xml
+// @Success 200 {array} models.HTTPClientStats
+// @Router /admin/metrics/http-clients [get]
+func (h *Handler) HTTPClientStatsHandler(w http.ResponseWriter, r *http.Request) {
+	utils.SendResponse(w, r, http.StatusOK, httpclient.Stats())
+}
+
+// HTTPServerStatsHandler reports the connections the API's HTTP server has accepted and their reuse
+// @Summary Get HTTP server connection metrics
+// @Description Protocols served, open and idle connections, connections opened and closed by the idle limit, and requests served on reused connections since startup, kept in memory by this instance
+// @Tags admin
+// @Produce json,xml
+// @Success 200 {array} models.HTTPServerStats
+// @Router /admin/metrics/http-server [get]
+func (h *Handler) HTTPServerStatsHandler(w http.ResponseWriter, r *http.Request) {
+	utils.SendResponse(w, r, http.StatusOK, httpserver.Stats())
+}
+
+// AnomalyStatusHandler reports failure-rate and latency anomaly detection per gateway
+// @Summary Get gateway anomaly status
+// @Description Each gateway's detection threshold, baseline failure rate and latency and downgrade state, with the most recent alerts
+// @Tags admin
+// @Produce json,xml
+// @Success 200 {object} models.AnomalyStatus
+// @Router /admin/anomalies [get]
+func (h *Handler) AnomalyStatusHandler(w http.ResponseWriter, r *http.Request) {
+	utils.SendResponse(w, r, http.StatusOK, h.anomalies.Status())
+}
+
+// ListWebhookSecretsHandler lists a gateway's webhook secrets without their values
+// @Summary List webhook secrets
+// @Description List the active and retired secrets a gateway's callbacks are verified with
+// @Tags admin
+// @Produce json,xml
+// @Param gateway_id path string true "Gateway ID"
+// @Success 200 {array} models.WebhookSecret
+// @Failure 404 {object} models.APIResponse
+// @Failure 500 {object} models.APIResponse
+// @Router /admin/gateways/{gateway_id}/webhook-secrets [get]
+func (h *Handler) ListWebhookSecretsHandler(w http.ResponseWriter, r *http.Request) {
+	gatewayID, ok := h.adminGatewayID(w, r)
+	if !ok {
+		return
+	}
+
+	secrets, err := h.webhookSecrets.List(r.Context(), gatewayID)
+	if err != nil {
+		utils.SendErrorResponse(w, r, http.StatusInternalServerError, fmt.Sprintf("Failed to list webhook secrets: %v", err))
+		return
+	}
+
+	utils.SendResponse(w, r, http.StatusOK, secrets)
+}
+
+// AddWebhookSecretHandler adds an active webhook secret to a gateway
+// @Summary Add a webhook secret
+// @Description Add a secret alongside the existing ones so callbacks signed with either verify during rotation. A secret is generated when none is given and returned only in this response.
+// @Tags admin
+// @Accept json,xml
+// @Produce json,xml
+// @Param gateway_id path string true "Gateway ID"
+// @Param secret body models.WebhookSecretRequest false "Secret to add"
+// @Success 201 {object} models.WebhookSecret
+// @Failure 400 {object} models.APIResponse
+// @Failure 404 {object} models.APIResponse
+// @Failure 500 {object} models.APIResponse
+// @Router /admin/gateways/{gateway_id}/webhook-secrets [post]
+func (h *Handler) AddWebhookSecretHandler(w http.ResponseWriter, r *http.Request) {
+	gatewayID, ok := h.adminGatewayID(w, r)
+	if !ok {
+		return
+	}
+
+	var request models.WebhookSecretRequest
+	if r.ContentLength != 0 {
+		if err := utils.DecodeRequest(r, &request); err != nil {
+			utils.SendDecodeError(w, r, err)
+			return
+		}
+	}
+	if err := validation.Struct(request); err != nil {
+		utils.SendValidationError(w, r, err)
+		return
+	}
+
+	secret, err := h.webhookSecrets.Add(r.Context(), gatewayID, request.Secret)
+	if err != nil {
+		if errors.Is(err, services.ErrInvalidWebhookSecret) {
+			utils.SendErrorResponse(w, r, http.StatusBadRequest, err.Error())
+			return
+		}
+		utils.SendErrorResponse(w, r, http.StatusInternalServerError, fmt.Sprintf("Failed to add webhook secret: %v", err))
+		return
+	}
+
+	utils.SendResponse(w, r, http.StatusCreated, secret)
+}
+
+// RetireWebhookSecretHandler stops accepting callbacks signed with a webhook secret
+// @Summary Retire a webhook secret
+// @Description Retire a secret once the gateway signs with its replacement; the last active secret cannot be retired
+// @Tags admin
+// @Produce json,xml
+// @Param gateway_id path string true "Gateway ID"
+// @Param secret_id path int true "Webhook secret ID"
+// @Success 200 {object} map[string]string
+// @Failure 400 {object} models.APIResponse
+// @Failure 404 {object} models.APIResponse
+// @Failure 409 {object} models.APIResponse
+// @Router /admin/gateways/{gateway_id}/webhook-secrets/{secret_id} [delete]
+func (h *Handler) RetireWebhookSecretHandler(w http.ResponseWriter, r *http.Request) {
+	gatewayID, ok := h.adminGatewayID(w, r)
+	if !ok {
+		return
+	}
+
+	secretID, err := strconv.Atoi(mux.Vars(r)["secret_id"])
+	if err != nil || secretID <= 0 {
+		utils.SendErrorResponse(w, r, http.StatusBadRequest, "Invalid webhook secret ID")
+		return
+	}
+
+	if err := h.webhookSecrets.Retire(r.Context(), gatewayID, secretID); err != nil {
+		switch {
+		case errors.Is(err, services.ErrWebhookSecretNotFound):
+			utils.SendErrorResponse(w, r, http.StatusNotFound, fmt.Sprintf("Webhook secret not found: %d", secretID))
+		case errors.Is(err, services.ErrLastWebhookSecret):
+			utils.SendErrorResponse(w, r, http.StatusConflict, err.Error())
+		default:
+			utils.SendErrorResponse(w, r, http.StatusInternalServerError, err.Error())
+		}
+		return
+	}
+
+	utils.SendResponse(w, r, http.StatusOK, map[string]string{"status": "retired"})
+}
+
+// ListClientCertificatesHandler lists a gateway's client certificates without their private keys
+// @Summary List client certificates
+// @Description List the active and retired TLS client certificates a gateway's connections authenticate with
+// @Tags admin
+// @Produce json,xml
+// @Param gateway_id path string true "Gateway ID"
+// @Success 200 {array} models.ClientCertificate
+// @Failure 404 {object} models.APIResponse
+// @Failure 500 {object} models.APIResponse
+// @Router /admin/gateways/{gateway_id}/client-certificates [get]
+func (h *Handler) ListClientCertificatesHandler(w http.ResponseWriter, r *http.Request) {
+	gatewayID, ok := h.adminGatewayID(w, r)
+	if !ok {
+		return
+	}
+
+	certificates, err := h.clientCertificates.List(r.Context(), gatewayID)
+	if err != nil {
+		utils.SendErrorResponse(w, r, http.StatusInternalServerError, fmt.Sprintf("Failed to list client certificates: %v", err))
+		return
+	}
+
+	if certificates == nil {
+		certificates = []models.ClientCertificate{}
+	}
+	utils.SendResponse(w, r, http.StatusOK, certificates)
+}
+
+// AddClientCertificateHandler adds an active TLS client certificate to a gateway
+// @Summary Add a client certificate
+// @Description Add a PEM certificate and private key for gateways requiring mutual TLS. It is presented alongside the existing certificates, newest first, until they are retired. The private key is stored encrypted and never returned.
+// @Tags admin
+// @Accept json,xml
+// @Produce json,xml
+// @Param gateway_id path string true "Gateway ID"
+// @Param certificate body models.ClientCertificateRequest true "Certificate and private key"
+// @Success 201 {object} models.ClientCertificate
+// @Failure 400 {object} models.APIResponse
+// @Failure 404 {object} models.APIResponse
+// @Failure 500 {object} models.APIResponse
+// @Router /admin/gateways/{gateway_id}/client-certificates [post]
+func (h *Handler) AddClientCertificateHandler(w http.ResponseWriter, r *http.Request) {
+	gatewayID, ok := h.adminGatewayID(w, r)
+	if !ok {
+		return
+	}
+
+	var request models.ClientCertificateRequest
+	if err := utils.DecodeRequest(r, &request); err != nil {
+		utils.SendDecodeError(w, r, err)
+		return
+	}
+	if err := validation.Struct(request); err != nil {
+		utils.SendValidationError(w, r, err)
+		return
+	}
+
+	certificate, err := h.clientCertificates.Add(r.Context(), gatewayID, request)
+	if err != nil {
+		if errors.Is(err, services.ErrInvalidClientCertificate) {
+			utils.SendErrorResponse(w, r, http.StatusBadRequest, err.Error())
+			return
+		}
+		utils.SendErrorResponse(w, r, http.StatusInternalServerError, fmt.Sprintf("Failed to add client certificate: %v", err))
+		return
+	}
+
+	utils.SendResponse(w, r, http.StatusCreated, certificate)
+}
+
+// RetireClientCertificateHandler stops presenting a client certificate
+// @Summary Retire a client certificate
+// @Description Retire a certificate once the gateway accepts its replacement; the last active certificate cannot be retired
+// @Tags admin
+// @Produce json,xml
+// @Param gateway_id path string true "Gateway ID"
+// @Param certificate_id path int true "Client certificate ID"
+// @Success 200 {object} map[string]string
+// @Failure 400 {object} models.APIResponse
+// @Failure 404 {object} models.APIResponse
+// @Failure 409 {object} models.APIResponse
+// @Router /admin/gateways/{gateway_id}/client-certificates/{certificate_id} [delete]
+func (h *Handler) RetireClientCertificateHandler(w http.ResponseWriter, r *http.Request) {
+	gatewayID, ok := h.adminGatewayID(w, r)
+	if !ok {
+		return
+	}
+
+	certificateID, err := strconv.Atoi(mux.Vars(r)["certificate_id"])
+	if err != nil || certificateID <= 0 {
+		utils.SendErrorResponse(w, r, http.StatusBadRequest, "Invalid client certificate ID")
+		return
+	}
+
+	if err := h.clientCertificates.Retire(r.Context(), gatewayID, certificateID); err != nil {
+		switch {
+		case errors.Is(err, services.ErrClientCertificateNotFound):
+			utils.SendErrorResponse(w, r, http.StatusNotFound, fmt.Sprintf("Client certificate not found: %d", certificateID))
+		case errors.Is(err, services.ErrLastClientCertificate):
+			utils.SendErrorResponse(w, r, http.StatusConflict, err.Error())
+		default:
+			utils.SendErrorResponse(w, r, http.StatusInternalServerError, err.Error())
+		}
+		return
+	}
+
+	utils.SendResponse(w, r, http.StatusOK, map[string]string{"status": "retired"})
+}
+
+// ListMerchantCertificatesHandler lists the wallets' merchant certificates without their private keys
+// @Summary List merchant certificates
+// @Description List the active and retired key pairs Apple Pay and Google Pay encrypt payment tokens to
+// @Tags admin
+// @Produce json,xml
+// @Param wallet query string false "Only this wallet's certificates: apple_pay or google_pay"
+// @Success 200 {array} models.MerchantCertificate
+// @Failure 400 {object} models.APIResponse
+// @Failure 500 {object} models.APIResponse
+// @Router /admin/merchant-certificates [get]
+func (h *Handler) ListMerchantCertificatesHandler(w http.ResponseWriter, r *http.Request) {
+	wallet := r.URL.Query().Get("wallet")
+	if wallet != "" && wallet != consts.WalletApplePay && wallet != consts.WalletGooglePay {
+		utils.SendErrorResponse(w, r, http.StatusBadRequest, fmt.Sprintf("Invalid wallet: %s", wallet))
+		return
+	}
+
+	certificates, err := h.transactionService.MerchantCertificates().List(r.Context(), wallet)
+	if err != nil {
+		utils.SendErrorResponse(w, r, http.StatusInternalServerError, fmt.Sprintf("Failed to list merchant certificates: %v", err))
+		return
+	}
+
+	if certificates == nil {
+		certificates = []models.MerchantCertificate{}
+	}
+	utils.SendResponse(w, r, http.StatusOK, certificates)
+}
+
+// AddMerchantCertificateHandler adds an active key pair wallet payment tokens are decrypted with
+// @Summary Add a merchant certificate
+// @Description Add a P-256 PEM private key that Apple Pay or Google Pay encrypts payment tokens to. Apple Pay keys come with the payment processing certificate Apple issued for them;
+// @Description the public key returned for Google Pay keys is the one to register with Google. Tokens are decrypted with it alongside the existing keys until they are retired.
+// @Description The private key is stored encrypted and never returned.
+// @Tags admin
+// @Accept json,xml
+// @Produce json,xml
+// @Param certificate body models.MerchantCertificateRequest true "Wallet, certificate and private key"
+// @Success 201 {object} models.MerchantCertificate
+// @Failure 400 {object} models.APIResponse
+// @Failure 500 {object} models.APIResponse
+// @Router /admin/merchant-certificates [post]
+func (h *Handler) AddMerchantCertificateHandler(w http.ResponseWriter, r *http.Request) {
+	var request models.MerchantCertificateRequest
+	if err := utils.DecodeRequest(r, &request); err != nil {
+		utils.SendDecodeError(w, r, err)
+		return
+	}
+	if err := validation.Struct(request); err != nil {
+		utils.SendValidationError(w, r, err)
+		return
+	}
+
+	certificate, err := h.transactionService.MerchantCertificates().Add(r.Context(), request)
+	if err != nil {
+		if errors.Is(err, services.ErrInvalidMerchantCertificate) {
+			utils.SendErrorResponse(w, r, http.StatusBadRequest, err.Error())
+			return
+		}
+		utils.SendErrorResponse(w, r, http.StatusInternalServerError, fmt.Sprintf("Failed to add merchant certificate: %v", err))
+		return
+	}
+
+	utils.SendResponse(w, r, http.StatusCreated, certificate)
+}
+
+// RetireMerchantCertificateHandler stops decrypting wallet payment tokens with a merchant certificate
+// @Summary Retire a merchant certificate
+// @Description Retire a key pair once the wallet encrypts tokens to its replacement
+// @Tags admin
+// @Produce json,xml
+// @Param certificate_id path int true "Merchant certificate ID"
+// @Success 200 {object} map[string]string
+// @Failure 400 {object} models.APIResponse
+// @Failure 404 {object} models.APIResponse
+// @Failure 500 {object} models.APIResponse
+// @Router /admin/merchant-certificates/{certificate_id} [delete]
+func (h *Handler) RetireMerchantCertificateHandler(w http.ResponseWriter, r *http.Request) {
+	certificateID, err := strconv.Atoi(mux.Vars(r)["certificate_id"])
+	if err != nil || certificateID <= 0 {
+		utils.SendErrorResponse(w, r, http.StatusBadRequest, "Invalid merchant certificate ID")
+		return
+	}
+
+	if err := h.transactionService.MerchantCertificates().Retire(r.Context(), certificateID); err != nil {
+		if errors.Is(err, services.ErrMerchantCertificateNotFound) {
+			utils.SendErrorResponse(w, r, http.StatusNotFound, fmt.Sprintf("Merchant certificate not found: %d", certificateID))
+			return
+		}
+		utils.SendErrorResponse(w, r, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	utils.SendResponse(w, r, http.StatusOK, map[string]string{"status": "retired"})
+}
+
+// ListSigningKeysHandler lists a gateway's signing keys without their private keys
+// @Summary List signing keys
+// @Description List the active and retired keys requests to a gateway are signed with
+// @Tags admin
+// @Produce json,xml
+// @Param gateway_id path string true "Gateway ID"
+// @Success 200 {array} models.SigningKey
+// @Failure 404 {object} models.APIResponse
+// @Failure 500 {object} models.APIResponse
+// @Router /admin/gateways/{gateway_id}/signing-keys [get]
+func (h *Handler) ListSigningKeysHandler(w http.ResponseWriter, r *http.Request) {
+	gatewayID, ok := h.adminGatewayID(w, r)
+	if !ok {
+		return
+	}
+
+	keys, err := h.signingKeys.List(r.Context(), gatewayID)
+	if err != nil {
+		utils.SendErrorResponse(w, r, http.StatusInternalServerError, fmt.Sprintf("Failed to list signing keys: %v", err))
+		return
+	}
+
+	if keys == nil {
+		keys = []models.SigningKey{}
+	}
+	utils.SendResponse(w, r, http.StatusOK, keys)
+}
+
+// AddSigningKeyHandler adds the signing key requests to a gateway are signed with from now on
+// @Summary Add a signing key
+// @Description Add a PEM RSA or P-256 private key to sign JWTs and JWS request signatures for the gateway with. It is used from now on and published at /.well-known/jwks.json under its kid, which defaults to the key's thumbprint. The private key is stored encrypted and never returned.
+// @Tags admin
+// @Accept json,xml
+// @Produce json,xml
+// @Param gateway_id path string true "Gateway ID"
+// @Param key body models.SigningKeyRequest true "Private key and key ID"
+// @Success 201 {object} models.SigningKey
+// @Failure 400 {object} models.APIResponse
+// @Failure 404 {object} models.APIResponse
+// @Failure 500 {object} models.APIResponse
+// @Router /admin/gateways/{gateway_id}/signing-keys [post]
+func (h *Handler) AddSigningKeyHandler(w http.ResponseWriter, r *http.Request) {
+	gatewayID, ok := h.adminGatewayID(w, r)
+	if !ok {
+		return
+	}
+
+	var request models.SigningKeyRequest
+	if err := utils.DecodeRequest(r, &request); err != nil {
+		utils.SendDecodeError(w, r, err)
+		return
+	}
+	if err := validation.Struct(request); err != nil {
+		utils.SendValidationError(w, r, err)
+		return
+	}
+
+	key, err := h.signingKeys.Add(r.Context(), gatewayID, request)
+	if err != nil {
+		if errors.Is(err, services.ErrInvalidSigningKey) {
+			utils.SendErrorResponse(w, r, http.StatusBadRequest, err.Error())
+			return
+		}
+		utils.SendErrorResponse(w, r, http.StatusInternalServerError, fmt.Sprintf("Failed to add signing key: %v", err))
+		return
+	}
+
+	utils.SendResponse(w, r, http.StatusCreated, key)
+}
+
+// RetireSigningKeyHandler stops signing with a key and unpublishes it
+// @Summary Retire a signing key
+// @Description Retire a key once the gateway verifies signatures of its replacement; the last active key cannot be retired
+// @Tags admin
+// @Produce json,xml
+// @Param gateway_id path string true "Gateway ID"
+// @Param key_id path int true "Signing key ID"
+// @Success 200 {object} map[string]string
+// @Failure 400 {object} models.APIResponse
+// @Failure 404 {object} models.APIResponse
+// @Failure 409 {object} models.APIResponse
+// @Router /admin/gateways/{gateway_id}/signing-keys/{key_id} [delete]
+func (h *Handler) RetireSigningKeyHandler(w http.ResponseWriter, r *http.Request) {
+	gatewayID, ok := h.adminGatewayID(w, r)
+	if !ok {
+		return
+	}
+
+	keyID, err := strconv.Atoi(mux.Vars(r)["key_id"])
+	if err != nil || keyID <= 0 {
+		utils.SendErrorResponse(w, r, http.StatusBadRequest, "Invalid signing key ID")
+		return
+	}
+
+	if err := h.signingKeys.Retire(r.Context(), gatewayID, keyID); err != nil {
+		switch {
+		case errors.Is(err, services.ErrSigningKeyNotFound):
+			utils.SendErrorResponse(w, r, http.StatusNotFound, fmt.Sprintf("Signing key not found: %d", keyID))
+		case errors.Is(err, services.ErrLastSigningKey):
+			utils.SendErrorResponse(w, r, http.StatusConflict, err.Error())
+		default:
+			utils.SendErrorResponse(w, r, http.StatusInternalServerError, err.Error())
+		}
+		return
+	}
+
+	utils.SendResponse(w, r, http.StatusOK, map[string]string{"status": "retired"})
+}
+
+// ListMaintenanceWindowsHandler lists a gateway's current and upcoming maintenance windows
+// @Summary List maintenance windows
+// @Description List the maintenance windows of a gateway that are in progress or scheduled
+// @Tags admin
+// @Produce json,xml
+// @Param gateway_id path string true "Gateway ID"
+// @Success 200 {array} models.MaintenanceWindow
+// @Failure 404 {object} models.APIResponse
+// @Failure 500 {object} models.APIResponse
+// @Router /admin/gateways/{gateway_id}/maintenance [get]
+func (h *Handler) ListMaintenanceWindowsHandler(w http.ResponseWriter, r *http.Request) {
+	gatewayID, ok := h.adminGatewayID(w, r)
+	if !ok {
+		return
+	}
+
+	windows, err := h.maintenance.List(r.Context(), gatewayID)
+	if err != nil {
+		utils.SendErrorResponse(w, r, http.StatusInternalServerError, fmt.Sprintf("Failed to list maintenance windows: %v", err))
+		return
+	}
+
+	utils.SendResponse(w, r, http.StatusOK, windows)
+}
+
+// ScheduleMaintenanceWindowHandler schedules a maintenance window for a gateway
+// @Summary Schedule a maintenance window
+// @Description Take a gateway out of routing between starts_at and ends_at. The gateway is skipped without being called, so its circuit breaker counts no failures, and returns to routing when the window ends.
+// @Tags admin
+// @Accept json,xml
+// @Produce json,xml
+// @Param gateway_id path string true "Gateway ID"
+// @Param window body models.MaintenanceWindowRequest true "Maintenance window"
+// @Success 201 {object} models.MaintenanceWindow
+// @Failure 400 {object} models.APIResponse
+// @Failure 404 {object} models.APIResponse
+// @Failure 500 {object} models.APIResponse
+// @Router /admin/gateways/{gateway_id}/maintenance [post]
+func (h *Handler) ScheduleMaintenanceWindowHandler(w http.ResponseWriter, r *http.Request) {
+	gatewayID, ok := h.adminGatewayID(w, r)
+	if !ok {
+		return
+	}
+
+	var request models.MaintenanceWindowRequest
+	if err := utils.DecodeRequest(r, &request); err != nil {
+		utils.SendDecodeError(w, r, err)
+		return
+	}
+	if err := validation.Struct(request); err != nil {
+		utils.SendValidationError(w, r, err)
+		return
+	}
+
+	window, err := h.maintenance.Schedule(r.Context(), gatewayID, request)
+	if err != nil {
+		if errors.Is(err, services.ErrInvalidMaintenanceWindow) {
+			utils.SendErrorResponse(w, r, http.StatusBadRequest, err.Error())
+			return
+		}
+		utils.SendErrorResponse(w, r, http.StatusInternalServerError, fmt.Sprintf("Failed to schedule maintenance window: %v", err))
+		return
+	}
+
+	utils.SendResponse(w, r, http.StatusCreated, window)
+}
+
+// CancelMaintenanceWindowHandler cancels a gateway maintenance window
+// @Summary Cancel a maintenance window
+// @Description Cancel a scheduled maintenance window, or end one in progress early and return the gateway to routing
+// @Tags admin
+// @Produce json,xml
+// @Param gateway_id path string true "Gateway ID"
+// @Param window_id path int true "Maintenance window ID"
+// @Success 200 {object} map[string]string
+// @Failure 400 {object} models.APIResponse
+// @Failure 404 {object} models.APIResponse
+// @Failure 500 {object} models.APIResponse
+// @Router /admin/gateways/{gateway_id}/maintenance/{window_id} [delete]
+func (h *Handler) CancelMaintenanceWindowHandler(w http.ResponseWriter, r *http.Request) {
+	gatewayID, ok := h.adminGatewayID(w, r)
+	if !ok {
+		return
+	}
+
+	windowID, err := strconv.Atoi(mux.Vars(r)["window_id"])
+	if err != nil || windowID <= 0 {
+		utils.SendErrorResponse(w, r, http.StatusBadRequest, "Invalid maintenance window ID")
+		return
+	}
+
+	if err := h.maintenance.Cancel(r.Context(), gatewayID, windowID); err != nil {
+		if errors.Is(err, services.ErrMaintenanceWindowNotFound) {
+			utils.SendErrorResponse(w, r, http.StatusNotFound, fmt.Sprintf("Maintenance window not found: %d", windowID))
+			return
+		}
+		utils.SendErrorResponse(w, r, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	utils.SendResponse(w, r, http.StatusOK, map[string]string{"status": "cancelled"})
+}
+
+// ListBankHolidaysHandler lists a country's bank holidays
+// @Summary List bank holidays
+// @Description List the bank holidays of a country in a year, the current one by default. Weekends are never banking days and are not listed.
+// @Tags admin
+// @Produce json,xml
+// @Param country_code path string true "ISO 3166-1 alpha-2 country code"
+// @Param year query int false "Year"
+// @Success 200 {array} models.BankHoliday
+// @Failure 400 {object} models.APIResponse
+// @Failure 500 {object} models.APIResponse
+// @Router /admin/countries/{country_code}/holidays [get]
+func (h *Handler) ListBankHolidaysHandler(w http.ResponseWriter, r *http.Request) {
+	year := time.Now().Year()
+	if value := r.URL.Query().Get("year"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed < 1 || parsed > 9999 {
+			utils.SendErrorResponse(w, r, http.StatusBadRequest, "Invalid year")
+			return
+		}
+		year = parsed
+	}
+
+	holidays, err := h.transactionService.Calendar().Holidays(r.Context(), mux.Vars(r)["country_code"], year)
+	if err != nil {
+		if errors.Is(err, services.ErrUnsupportedCountry) {
+			utils.SendErrorResponse(w, r, http.StatusBadRequest, err.Error())
+			return
+		}
+		utils.SendErrorResponse(w, r, http.StatusInternalServerError, fmt.Sprintf("Failed to list bank holidays: %v", err))
+		return
+	}
+
+	utils.SendResponse(w, r, http.StatusOK, holidays)
+}
+
+// AddBankHolidayHandler adds a bank holiday to a country's calendar
+// @Summary Add a bank holiday
+// @Description Mark a day as a bank holiday in a country, renaming it if it already is one. Scheduled payouts falling on the day are deferred to the next banking day, and settlement dates skip it.
+// @Tags admin
+// @Accept json,xml
+// @Produce json,xml
+// @Param country_code path string true "ISO 3166-1 alpha-2 country code"
+// @Param holiday body models.BankHolidayRequest true "Bank holiday"
+// @Success 201 {object} models.BankHoliday
+// @Failure 400 {object} models.APIResponse
+// @Failure 500 {object} models.APIResponse
+// @Router /admin/countries/{country_code}/holidays [post]
+func (h *Handler) AddBankHolidayHandler(w http.ResponseWriter, r *http.Request) {
+	var request models.BankHolidayRequest
+	if err := utils.DecodeRequest(r, &request); err != nil {
+		utils.SendDecodeError(w, r, err)
+		return
+	}
+	if err := validation.Struct(request); err != nil {
+		utils.SendValidationError(w, r, err)
+		return
+	}
+
+	holiday, err := h.transactionService.Calendar().AddHoliday(r.Context(), mux.Vars(r)["country_code"], request)
+	if err != nil {
+		if errors.Is(err, services.ErrInvalidBankHoliday) || errors.Is(err, services.ErrUnsupportedCountry) {
+			utils.SendErrorResponse(w, r, http.StatusBadRequest, err.Error())
+			return
+		}
+		utils.SendErrorResponse(w, r, http.StatusInternalServerError, fmt.Sprintf("Failed to add bank holiday: %v", err))
+		return
+	}
+
+	utils.SendResponse(w, r, http.StatusCreated, holiday)
+}
+
+// RemoveBankHolidayHandler removes a bank holiday from a country's calendar
+// @Summary Remove a bank holiday
+// @Description Make a day a banking day again in a country
+// @Tags admin
+// @Produce json,xml
+// @Param country_code path string true "ISO 3166-1 alpha-2 country code"
+// @Param date path string true "Holiday date, YYYY-MM-DD"
+// @Success 200 {object} map[string]string
+// @Failure 400 {object} models.APIResponse
+// @Failure 404 {object} models.APIResponse
+// @Failure 500 {object} models.APIResponse
+// @Router /admin/countries/{country_code}/holidays/{date} [delete]
+func (h *Handler) RemoveBankHolidayHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+
+	if err := h.transactionService.Calendar().RemoveHoliday(r.Context(), vars["country_code"], vars["date"]); err != nil {
+		switch {
+		case errors.Is(err, services.ErrInvalidBankHoliday), errors.Is(err, services.ErrUnsupportedCountry):
+			utils.SendErrorResponse(w, r, http.StatusBadRequest, err.Error())
+		case errors.Is(err, services.ErrBankHolidayNotFound):
+			utils.SendErrorResponse(w, r, http.StatusNotFound, fmt.Sprintf("Bank holiday not found: %s", vars["date"]))
+		default:
+			utils.SendErrorResponse(w, r, http.StatusInternalServerError, fmt.Sprintf("Failed to remove bank holiday: %v", err))
+		}
+		return
+	}
+
+	utils.SendResponse(w, r, http.StatusOK, map[string]string{"status": "deleted"})
+}
+
+// ListComplianceFieldsHandler lists the compliance fields transactions in a country must carry
+// @Summary List compliance fields
+// @Description List the extra fields, such as a CPF in Brazil, that transactions in a country must carry in compliance_fields
+// @Tags admin
+// @Produce json,xml
+// @Param country_code path string true "ISO 3166-1 alpha-2 country code"
+// @Success 200 {array} models.ComplianceField
+// @Failure 400 {object} models.APIResponse
+// @Failure 500 {object} models.APIResponse
+// @Router /admin/countries/{country_code}/compliance-fields [get]
+func (h *Handler) ListComplianceFieldsHandler(w http.ResponseWriter, r *http.Request) {
+	fields, err := h.transactionService.ComplianceFields(r.Context(), mux.Vars(r)["country_code"])
+	if err != nil {
+		if errors.Is(err, services.ErrUnsupportedCountry) {
+			utils.SendErrorResponse(w, r, http.StatusBadRequest, err.Error())
+			return
+		}
+		utils.SendErrorResponse(w, r, http.StatusInternalServerError, fmt.Sprintf("Failed to list compliance fields: %v", err))
+		return
+	}
+
+	utils.SendResponse(w, r, http.StatusOK, fields)
+}
+
+// SetComplianceFieldsHandler replaces the compliance fields of a country
+// @Summary Set compliance fields
+// @Description Replace the extra fields transactions in a country must carry. A field applies to the listed transaction types, or all of them, and its value must match the pattern if one is set. An empty list requires none. Transactions already created are unaffected.
+// @Tags admin
+// @Accept json,xml
+// @Produce json,xml
+// @Param country_code path string true "ISO 3166-1 alpha-2 country code"
+// @Param fields body models.ComplianceFieldsRequest true "Compliance fields"
+// @Success 200 {array} models.ComplianceField
+// @Failure 400 {object} models.APIResponse
+// @Failure 500 {object} models.APIResponse
+// @Router /admin/countries/{country_code}/compliance-fields [put]
+func (h *Handler) SetComplianceFieldsHandler(w http.ResponseWriter, r *http.Request) {
+	var request models.ComplianceFieldsRequest
+	if err := utils.DecodeRequest(r, &request); err != nil {
+		utils.SendDecodeError(w, r, err)
+		return
+	}
+	if err := validation.Struct(request); err != nil {
+		utils.SendValidationError(w, r, err)
+		return
+	}
+
+	fields, err := h.transactionService.SetComplianceFields(r.Context(), mux.Vars(r)["country_code"], request.Fields)
+	if err != nil {
+		if errors.Is(err, services.ErrInvalidComplianceFields) || errors.Is(err, services.ErrUnsupportedCountry) {
+			utils.SendErrorResponse(w, r, http.StatusBadRequest, err.Error())
+			return
+		}
+		utils.SendErrorResponse(w, r, http.StatusInternalServerError, fmt.Sprintf("Failed to set compliance fields: %v", err))
+		return
+	}
+
+	utils.SendResponse(w, r, http.StatusOK, fields)
+}
+
+// ListAMLThresholdsHandler lists the AML thresholds of a country
+// @Summary List AML thresholds
+// @Description List the amounts, per currency, from which transactions in a country must carry travel_rule details and are queued as AML cases
+// @Tags admin
+// @Produce json,xml
+// @Param country_code path string true "ISO 3166-1 alpha-2 country code"
+// @Success 200 {array} models.AMLThreshold
+// @Failure 400 {object} models.APIResponse
+// @Failure 500 {object} models.APIResponse
+// @Router /admin/countries/{country_code}/aml-thresholds [get]
+func (h *Handler) ListAMLThresholdsHandler(w http.ResponseWriter, r *http.Request) {
+	thresholds, err := h.transactionService.AMLThresholds(r.Context(), mux.Vars(r)["country_code"])
+	if err != nil {
+		if errors.Is(err, services.ErrUnsupportedCountry) {
+			utils.SendErrorResponse(w, r, http.StatusBadRequest, err.Error())
+			return
+		}
+		utils.SendErrorResponse(w, r, http.StatusInternalServerError, fmt.Sprintf("Failed to list AML thresholds: %v", err))
+		return
+	}
+
+	utils.SendResponse(w, r, http.StatusOK, thresholds)
+}
+
+// SetAMLThresholdHandler sets the AML threshold of a country in a currency
+// @Summary Set an AML threshold
+// @Description Set the amount from which transactions in a country and currency are flagged. Flagged transactions must carry travel_rule details and open an AML case in the review queue. Transactions already created are unaffected.
+// @Tags admin
+// @Accept json,xml
+// @Produce json,xml
+// @Param country_code path string true "ISO 3166-1 alpha-2 country code"
+// @Param currency path string true "ISO 4217 currency code"
+// @Param threshold body models.AMLThresholdRequest true "AML threshold"
+// @Success 200 {object} models.AMLThreshold
+// @Failure 400 {object} models.APIResponse
+// @Failure 500 {object} models.APIResponse
+// @Router /admin/countries/{country_code}/aml-thresholds/{currency} [put]
+func (h *Handler) SetAMLThresholdHandler(w http.ResponseWriter, r *http.Request) {
+	var request models.AMLThresholdRequest
+	if err := utils.DecodeRequest(r, &request); err != nil {
+		utils.SendDecodeError(w, r, err)
+		return
+	}
+	if err := validation.Struct(request); err != nil {
+		utils.SendValidationError(w, r, err)
+		return
+	}
+
+	vars := mux.Vars(r)
+	threshold, err := h.transactionService.SetAMLThreshold(r.Context(), vars["country_code"], vars["currency"], request)
+	if err != nil {
+		if errors.Is(err, services.ErrInvalidAMLThreshold) || errors.Is(err, services.ErrUnsupportedCountry) {
+			utils.SendErrorResponse(w, r, http.StatusBadRequest, err.Error())
+			return
+		}
+		utils.SendErrorResponse(w, r, http.StatusInternalServerError, fmt.Sprintf("Failed to set AML threshold: %v", err))
+		return
+	}
+
+	utils.SendResponse(w, r, http.StatusOK, threshold)
+}
+
+// DeleteAMLThresholdHandler removes the AML threshold of a country in a currency
+// @Summary Delete an AML threshold
+// @Description Stop flagging transactions in a country and currency. Open AML cases stay in the review queue.
+// @Tags admin
+// @Produce json,xml
+// @Param country_code path string true "ISO 3166-1 alpha-2 country code"
+// @Param currency path string true "ISO 4217 currency code"
+// @Success 200 {object} map[string]string
+// @Failure 400 {object} models.APIResponse
+// @Failure 404 {object} models.APIResponse
+// @Failure 500 {object} models.APIResponse
+// @Router /admin/countries/{country_code}/aml-thresholds/{currency} [delete]
+func (h *Handler) DeleteAMLThresholdHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+
+	if err := h.transactionService.DeleteAMLThreshold(r.Context(), vars["country_code"], vars["currency"]); err != nil {
+		switch {
+		case errors.Is(err, services.ErrUnsupportedCountry):
+			utils.SendErrorResponse(w, r, http.StatusBadRequest, err.Error())
+		case errors.Is(err, services.ErrAMLThresholdNotFound):
+			utils.SendErrorResponse(w, r, http.StatusNotFound, fmt.Sprintf("AML threshold not found: %s", vars["currency"]))
+		default:
+			utils.SendErrorResponse(w, r, http.StatusInternalServerError, fmt.Sprintf("Failed to delete AML threshold: %v", err))
+		}
+		return
+	}
+
+	utils.SendResponse(w, r, http.StatusOK, map[string]string{"status": "deleted"})
+}
+
+// ListAMLCasesHandler lists the AML review queue
+// @Summary List AML cases
+// @Description List AML cases with a status, oldest first. Cases open when a transaction reaches its country's AML threshold and move through in_review to reported or dismissed.
+// @Tags admin
+// @Produce json,xml
+// @Param status query string false "open (default), in_review, reported or dismissed"
+// @Success 200 {array} models.AMLCase
+// @Failure 400 {object} models.APIResponse
+// @Failure 500 {object} models.APIResponse
+// @Router /admin/aml-cases [get]
+func (h *Handler) ListAMLCasesHandler(w http.ResponseWriter, r *http.Request) {
+	cases, err := h.transactionService.ListAMLCases(r.Context(), r.URL.Query().Get("status"))
+	if err != nil {
+		if errors.Is(err, services.ErrInvalidAMLCaseQueue) {
+			utils.SendErrorResponse(w, r, http.StatusBadRequest, err.Error())
+			return
+		}
+		utils.SendErrorResponse(w, r, http.StatusInternalServerError, fmt.Sprintf("Failed to list AML cases: %v", err))
+		return
+	}
+
+	utils.SendResponse(w, r, http.StatusOK, cases)
+}
+
+// ExportAMLReportHandler downloads AML cases as a report file for the financial intelligence unit
+// @Summary Export an AML report
+// @Description Download the AML cases with a status, in_review by default, as a report file: csv, or goaml for XML in the goAML report layout. Exporting does not change the cases; mark them reported once filed.
+// @Tags admin
+// @Produce text/csv,application/xml
+// @Param format query string true "csv or goaml"
+// @Param status query string false "in_review (default), open, reported or dismissed"
+// @Success 200 {file} file
+// @Failure 400 {object} models.APIResponse
+// @Failure 500 {object} models.APIResponse
+// @Router /admin/aml-cases/export [get]
+func (h *Handler) ExportAMLReportHandler(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	data, contentType, fileName, err := h.transactionService.AMLReport(r.Context(), query.Get("format"), query.Get("status"))
+	if err != nil {
+		if errors.Is(err, aml.ErrUnknownFormat) || errors.Is(err, services.ErrInvalidAMLCaseQueue) {
+			utils.SendErrorResponse(w, r, http.StatusBadRequest, err.Error())
+			return
+		}
+		utils.SendErrorResponse(w, r, http.StatusInternalServerError, fmt.Sprintf("Failed to export AML report: %v", err))
+		return
+	}
+
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", fileName))
+	w.WriteHeader(http.StatusOK)
+	w.Write(data)
+}
+
+// GetAMLCaseHandler returns an AML case
+// @Summary Get an AML case
+// @Description Get an AML case with the travel rule details of its transaction
+// @Tags admin
+// @Produce json,xml
+// @Param case_id path int true "AML case ID"
+// @Success 200 {object} models.AMLCase
+// @Failure 400 {object} models.APIResponse
+// @Failure 404 {object} models.APIResponse
+// @Failure 500 {object} models.APIResponse
+// @Router /admin/aml-cases/{case_id} [get]
+func (h *Handler) GetAMLCaseHandler(w http.ResponseWriter, r *http.Request) {
+	caseID, err := strconv.Atoi(mux.Vars(r)["case_id"])
+	if err != nil || caseID <= 0 {
+		utils.SendErrorResponse(w, r, http.StatusBadRequest, "Invalid AML case ID")
+		return
+	}
+
+	amlCase, err := h.transactionService.GetAMLCase(r.Context(), caseID)
+	if err != nil {
+		if errors.Is(err, services.ErrAMLCaseNotFound) {
+			utils.SendErrorResponse(w, r, http.StatusNotFound, err.Error())
+			return
+		}
+		utils.SendErrorResponse(w, r, http.StatusInternalServerError, fmt.Sprintf("Failed to get AML case: %v", err))
+		return
+	}
+
+	utils.SendResponse(w, r, http.StatusOK, amlCase)
+}
+
+// UpdateAMLCaseHandler moves an AML case through review
+// @Summary Update an AML case
+// @Description Move an AML case to in_review, reported once filed with the financial intelligence unit, or dismissed, with an optional note. Reported and dismissed cases are closed.
+// @Tags admin
+// @Accept json,xml
+// @Produce json,xml
+// @Param case_id path int true "AML case ID"
+// @Param update body models.AMLCaseUpdateRequest true "Status and note"
+// @Success 200 {object} models.AMLCase
+// @Failure 400 {object} models.APIResponse
+// @Failure 404 {object} models.APIResponse
+// @Failure 409 {object} models.APIResponse
+// @Failure 500 {object} models.APIResponse
+// @Router /admin/aml-cases/{case_id} [put]
+func (h *Handler) UpdateAMLCaseHandler(w http.ResponseWriter, r *http.Request) {
+	caseID, err := strconv.Atoi(mux.Vars(r)["case_id"])
+	if err != nil || caseID <= 0 {
+		utils.SendErrorResponse(w, r, http.StatusBadRequest, "Invalid AML case ID")
+		return
+	}
+
+	var request models.AMLCaseUpdateRequest
+	if err := utils.DecodeRequest(r, &request); err != nil {
+		utils.SendDecodeError(w, r, err)
+		return
+	}
+	if err := validation.Struct(request); err != nil {
+		utils.SendValidationError(w, r, err)
+		return
+	}
+
+	amlCase, err := h.transactionService.UpdateAMLCase(r.Context(), caseID, request)
+	if err != nil {
+		switch {
+		case errors.Is(err, services.ErrAMLCaseNotFound):
+			utils.SendErrorResponse(w, r, http.StatusNotFound, err.Error())
+		case errors.Is(err, services.ErrAMLCaseClosed):
+			utils.SendErrorResponse(w, r, http.StatusConflict, err.Error())
+		default:
+			utils.SendErrorResponse(w, r, http.StatusInternalServerError, fmt.Sprintf("Failed to update AML case: %v", err))
+		}
+		return
+	}
+
+	utils.SendResponse(w, r, http.StatusOK, amlCase)
+}
+
+// ListScreeningCasesHandler lists the sanctions screening review queue
+// @Summary List screening cases
+// @Description List screening cases with a status, oldest first. Cases open when a new user's name or a large withdrawal's beneficiary matches a sanctions list; they are cleared as false positives or confirmed.
+// @Tags admin
+// @Produce json,xml
+// @Param status query string false "open (default), cleared or confirmed"
+// @Success 200 {array} models.ScreeningCase
+// @Failure 400 {object} models.APIResponse
+// @Failure 500 {object} models.APIResponse
+// @Router /admin/screening-cases [get]
+func (h *Handler) ListScreeningCasesHandler(w http.ResponseWriter, r *http.Request) {
+	cases, err := h.screening.ListCases(r.Context(), r.URL.Query().Get("status"))
+	if err != nil {
+		if errors.Is(err, services.ErrInvalidScreeningCaseQueue) {
+			utils.SendErrorResponse(w, r, http.StatusBadRequest, err.Error())
+			return
+		}
+		utils.SendErrorResponse(w, r, http.StatusInternalServerError, fmt.Sprintf("Failed to list screening cases: %v", err))
+		return
+	}
+
+	utils.SendResponse(w, r, http.StatusOK, cases)
+}
+
+// GetScreeningCaseHandler returns a screening case
+// @Summary Get a screening case
+// @Description Get a screening case with the screened name and the sanctions list entries it matched
+// @Tags admin
+// @Produce json,xml
+// @Param case_id path int true "Screening case ID"
+// @Success 200 {object} models.ScreeningCase
+// @Failure 400 {object} models.APIResponse
+// @Failure 404 {object} models.APIResponse
+// @Failure 500 {object} models.APIResponse
+// @Router /admin/screening-cases/{case_id} [get]
+func (h *Handler) GetScreeningCaseHandler(w http.ResponseWriter, r *http.Request) {
+	caseID, err := strconv.Atoi(mux.Vars(r)["case_id"])
+	if err != nil || caseID <= 0 {
+		utils.SendErrorResponse(w, r, http.StatusBadRequest, "Invalid screening case ID")
+		return
+	}
+
+	screeningCase, err := h.screening.GetCase(r.Context(), caseID)
+	if err != nil {
+		if errors.Is(err, services.ErrScreeningCaseNotFound) {
+			utils.SendErrorResponse(w, r, http.StatusNotFound, err.Error())
+			return
+		}
+		utils.SendErrorResponse(w, r, http.StatusInternalServerError, fmt.Sprintf("Failed to get screening case: %v", err))
+		return
+	}
+
+	utils.SendResponse(w, r, http.StatusOK, screeningCase)
+}
+
+// DecideScreeningCaseHandler closes a screening case
+// @Summary Decide a screening case
+// @Description Clear an open screening case as a false positive or confirm the match, naming the reviewer for the audit trail. Clearing a new user's case lets them transact and confirming it blocks them; a cleared withdrawal beneficiary is not screened again for the user.
+// @Tags admin
+// @Accept json,xml
+// @Produce json,xml
+// @Param case_id path int true "Screening case ID"
+// @Param decision body models.ScreeningCaseDecisionRequest true "Decision, reviewer and note"
+// @Success 200 {object} models.ScreeningCase
+// @Failure 400 {object} models.APIResponse
+// @Failure 404 {object} models.APIResponse
+// @Failure 409 {object} models.APIResponse
+// @Failure 500 {object} models.APIResponse
+// @Router /admin/screening-cases/{case_id} [put]
+func (h *Handler) DecideScreeningCaseHandler(w http.ResponseWriter, r *http.Request) {
+	caseID, err := strconv.Atoi(mux.Vars(r)["case_id"])
+	if err != nil || caseID <= 0 {
+		utils.SendErrorResponse(w, r, http.StatusBadRequest, "Invalid screening case ID")
+		return
+	}
+
+	var request models.ScreeningCaseDecisionRequest
+	if err := utils.DecodeRequest(r, &request); err != nil {
+		utils.SendDecodeError(w, r, err)
+		return
+	}
+	if err := validation.Struct(request); err != nil {
+		utils.SendValidationError(w, r, err)
+		return
+	}
+
+	screeningCase, err := h.screening.DecideCase(r.Context(), caseID, request)
+	if err != nil {
+		switch {
+		case errors.Is(err, services.ErrScreeningCaseNotFound):
+			utils.SendErrorResponse(w, r, http.StatusNotFound, err.Error())
+		case errors.Is(err, services.ErrScreeningCaseClosed):
+			utils.SendErrorResponse(w, r, http.StatusConflict, err.Error())
+		default:
+			utils.SendErrorResponse(w, r, http.StatusInternalServerError, fmt.Sprintf("Failed to decide screening case: %v", err))
+		}
+		return
+	}
+
+	utils.SendResponse(w, r, http.StatusOK, screeningCase)
+}
+
+// ListDeclineRecoveryHintsHandler lists the recovery hint of every normalized decline code
+// @Summary List decline recovery hints
+// @Description List what customers are told to do after each normalized decline code: try_again, use_other_method, contact_bank or do_not_retry. Declined transactions carry the hint as recovery_hint in responses and webhooks.
+// @Tags admin
+// @Produce json,xml
+// @Success 200 {array} models.DeclineRecoveryHint
+// @Failure 500 {object} models.APIResponse
+// @Router /admin/decline-codes [get]
+func (h *Handler) ListDeclineRecoveryHintsHandler(w http.ResponseWriter, r *http.Request) {
+	hints, err := h.transactionService.RecoveryHints().List(r.Context())
+	if err != nil {
+		utils.SendErrorResponse(w, r, http.StatusInternalServerError, fmt.Sprintf("Failed to list recovery hints: %v", err))
+		return
+	}
+
+	utils.SendResponse(w, r, http.StatusOK, hints)
+}
+
+// SetDeclineRecoveryHintHandler changes the recovery hint of a decline code
+// @Summary Set a decline recovery hint
+// @Description Change what customers are told to do after a decline code. Other instances apply the change on their next refresh, within a minute.
+// @Tags admin
+// @Accept json,xml
+// @Produce json,xml
+// @Param decline_code path string true "Normalized decline code"
+// @Param hint body models.DeclineRecoveryHintRequest true "Recovery hint"
+// @Success 200 {object} models.DeclineRecoveryHint
+// @Failure 400 {object} models.APIResponse
+// @Failure 404 {object} models.APIResponse
+// @Failure 500 {object} models.APIResponse
+// @Router /admin/decline-codes/{decline_code} [put]
+func (h *Handler) SetDeclineRecoveryHintHandler(w http.ResponseWriter, r *http.Request) {
+	var request models.DeclineRecoveryHintRequest
+	if err := utils.DecodeRequest(r, &request); err != nil {
+		utils.SendDecodeError(w, r, err)
+		return
+	}
+	if err := validation.Struct(request); err != nil {
+		utils.SendValidationError(w, r, err)
+		return
+	}
+
+	declineCode := mux.Vars(r)["decline_code"]
+	hint, err := h.transactionService.RecoveryHints().Set(r.Context(), declineCode, request)
+	if err != nil {
+		if errors.Is(err, services.ErrUnknownDeclineCode) {
+			utils.SendErrorResponse(w, r, http.StatusNotFound, fmt.Sprintf("Unknown decline code: %s", declineCode))
+			return
+		}
+		utils.SendErrorResponse(w, r, http.StatusInternalServerError, fmt.Sprintf("Failed to set recovery hint: %v", err))
+		return
+	}
+
+	utils.SendResponse(w, r, http.StatusOK, hint)
+}
+
+// ListDisputesHandler lists the dispute review queue
+// @Summary List disputes
+// @Description List disputed transactions with a status, oldest first
+// @Tags admin
+// @Produce json,xml
+// @Param status query string false "open (default), in_review, resolved or rejected"
+// @Success 200 {array} models.Dispute
+// @Failure 400 {object} models.APIResponse
+// @Failure 500 {object} models.APIResponse
+// @Router /admin/disputes [get]
+func (h *Handler) ListDisputesHandler(w http.ResponseWriter, r *http.Request) {
+	disputes, err := h.transactionService.ListDisputes(r.Context(), r.URL.Query().Get("status"))
+	if err != nil {
+		if errors.Is(err, services.ErrInvalidDisputeQueue) {
+			utils.SendErrorResponse(w, r, http.StatusBadRequest, err.Error())
+			return
+		}
+		utils.SendErrorResponse(w, r, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	if disputes == nil {
+		disputes = []models.Dispute{}
+	}
+	utils.SendResponse(w, r, http.StatusOK, disputes)
+}
+
+// UpdateDisputeHandler moves a dispute through review
+// @Summary Update a dispute
+// @Description Mark a dispute in review, or close it as resolved or rejected with a resolution shown to the user. Closed disputes cannot change.
+// @Tags admin
+// @Accept json,xml
+// @Produce json,xml
+// @Param dispute_id path int true "Dispute ID"
+// @Param update body models.DisputeUpdateRequest true "New status"
+// @Success 200 {object} models.Dispute
+// @Failure 400 {object} models.APIResponse
+// @Failure 404 {object} models.APIResponse
+// @Failure 409 {object} models.APIResponse
+// @Failure 500 {object} models.APIResponse
+// @Router /admin/disputes/{dispute_id} [put]
+func (h *Handler) UpdateDisputeHandler(w http.ResponseWriter, r *http.Request) {
+	disputeID, err := strconv.Atoi(mux.Vars(r)["dispute_id"])
+	if err != nil || disputeID <= 0 {
+		utils.SendErrorResponse(w, r, http.StatusBadRequest, "Invalid dispute ID")
+		return
+	}
+
+	var request models.DisputeUpdateRequest
+	if err := utils.DecodeRequest(r, &request); err != nil {
+		utils.SendDecodeError(w, r, err)
+		return
+	}
+	if err := validation.Struct(request); err != nil {
+		utils.SendValidationError(w, r, err)
+		return
+	}
+
+	dispute, err := h.transactionService.UpdateDispute(r.Context(), disputeID, request)
+	if err != nil {
+		switch {
+		case errors.Is(err, services.ErrDisputeNotFound):
+			utils.SendErrorResponse(w, r, http.StatusNotFound, fmt.Sprintf("Dispute not found: %d", disputeID))
+		case errors.Is(err, services.ErrDisputeClosed):
+			utils.SendErrorResponse(w, r, http.StatusConflict, err.Error())
+		default:
+			utils.SendErrorResponse(w, r, http.StatusInternalServerError, err.Error())
+		}
+		return
+	}
+
+	utils.SendResponse(w, r, http.StatusOK, dispute)
+}
+
+// AdminSimulateRoutingHandler shows how a hypothetical transaction would be routed
+// @Summary Simulate routing with a decision trace
+// @Description Report the gateway a transaction would be routed to right now and every check made along the way: candidate gateways in priority order, downgrades, matching merchant rules, and why each gateway was skipped or selected. Nothing is created and no gateway is called. Set merchant_id to apply that merchant's saved rules, or pass draft rules to try a change before saving it.
+// @Tags admin
+// @Accept json,xml
+// @Produce json,xml
+// @Param transaction body models.RoutingSimulationRequest true "Transaction to simulate"
+// @Success 200 {object} models.RoutingSimulation
+// @Failure 400 {object} models.APIResponse
+// @Failure 404 {object} models.APIResponse
+// @Failure 500 {object} models.APIResponse
+// @Router /admin/routing/simulate [post]
+func (h *Handler) AdminSimulateRoutingHandler(w http.ResponseWriter, r *http.Request) {
+	var request models.RoutingSimulationRequest
+	if err := utils.DecodeRequest(r, &request); err != nil {
+		utils.SendDecodeError(w, r, err)
+		return
+	}
+	if err := validation.Struct(request); err != nil {
+		utils.SendValidationError(w, r, err)
+		return
+	}
+
+	simulation, err := h.routingRules.Simulate(r.Context(), request)
+	if err != nil {
+		switch {
+		case errors.Is(err, services.ErrInvalidRoutingRule), errors.Is(err, services.ErrUnsupportedCountry):
+			utils.SendErrorResponse(w, r, http.StatusBadRequest, err.Error())
+		case errors.Is(err, services.ErrMerchantNotFound):
+			utils.SendErrorResponse(w, r, http.StatusNotFound, err.Error())
+		default:
+			utils.SendErrorResponse(w, r, http.StatusInternalServerError, fmt.Sprintf("Failed to simulate routing: %v", err))
+		}
+		return
+	}
+
+	utils.SendResponse(w, r, http.StatusOK, simulation)
+}
+
+// GetCallbackHandler returns a stored gateway callback for investigation
+// @Summary Get a stored callback
+// @Description Return a gateway callback as received, with sensitive headers masked, and the outcome of processing it
+// @Tags admin
+// @Produce json,xml
+// @Param callback_id path int true "Callback ID"
+// @Success 200 {object} models.CallbackRecord
+// @Failure 400 {object} models.APIResponse
+// @Failure 404 {object} models.APIResponse
+// @Failure 500 {object} models.APIResponse
+// @Router /admin/callbacks/{callback_id} [get]
+func (h *Handler) GetCallbackHandler(w http.ResponseWriter, r *http.Request) {
+	callbackID, ok := adminCallbackID(w, r)
+	if !ok {
+		return
+	}
+
+	record, err := h.transactionService.GetCallback(r.Context(), callbackID)
+	if err != nil {
+		if errors.Is(err, services.ErrCallbackNotFound) {
+			utils.SendErrorResponse(w, r, http.StatusNotFound, fmt.Sprintf("Callback not found: %d", callbackID))
+			return
+		}
+		utils.SendErrorResponse(w, r, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	utils.SendResponse(w, r, http.StatusOK, record)
+}
+
+// ReparseCallbackHandler processes a stored callback again after a provider fix
+// @Summary Reparse a stored callback
+// @Description Parse and apply a callback that previously failed to parse or process; the returned record reports the new outcome
+// @Tags admin
+// @Produce json,xml
+// @Param callback_id path int true "Callback ID"
+// @Success 200 {object} models.CallbackRecord
+// @Failure 400 {object} models.APIResponse
+// @Failure 404 {object} models.APIResponse
+// @Failure 409 {object} models.APIResponse
+// @Failure 500 {object} models.APIResponse
+// @Router /admin/callbacks/{callback_id}/reparse [post]
+func (h *Handler) ReparseCallbackHandler(w http.ResponseWriter, r *http.Request) {
+	callbackID, ok := adminCallbackID(w, r)
+	if !ok {
+		return
+	}
+
+	record, err := h.transactionService.ReparseCallback(r.Context(), callbackID)
+	if err != nil {
+		switch {
+		case errors.Is(err, services.ErrCallbackNotFound):
+			utils.SendErrorResponse(w, r, http.StatusNotFound, fmt.Sprintf("Callback not found: %d", callbackID))
+		case errors.Is(err, services.ErrCallbackNotReparseable):
+			utils.SendErrorResponse(w, r, http.StatusConflict, err.Error())
+		default:
+			utils.SendErrorResponse(w, r, http.StatusInternalServerError, err.Error())
+		}
+		return
+	}
+
+	utils.SendResponse(w, r, http.StatusOK, record)
+}
+
+// ListOutboxMessagesHandler lists recorded events by delivery status
+// @Summary List outbox messages
+// @Description List Kafka events and merchant webhooks by delivery status, oldest first. Kafka events are retried until delivered, so pending Kafka messages with many attempts indicate an outage.
+// @Tags admin
+// @Produce json,xml
+// @Param destination query string false "kafka or merchant_webhook; all destinations when omitted"
+// @Param status query string false "pending (default), delivered, failed or discarded"
+// @Success 200 {array} models.OutboxMessage
+// @Failure 400 {object} models.APIResponse
+// @Failure 500 {object} models.APIResponse
+// @Router /admin/outbox [get]
+func (h *Handler) ListOutboxMessagesHandler(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+
+	messages, err := h.transactionService.ListOutboxMessages(r.Context(), query.Get("destination"), query.Get("status"))
+	if err != nil {
+		if errors.Is(err, services.ErrInvalidOutboxFilter) {
+			utils.SendErrorResponse(w, r, http.StatusBadRequest, err.Error())
+			return
+		}
+		utils.SendErrorResponse(w, r, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	if messages == nil {
+		messages = []models.OutboxMessage{}
+	}
+	utils.SendResponse(w, r, http.StatusOK, messages)
+}
+
+// RetryOutboxMessageHandler schedules a pending or failed outbox message for immediate delivery
+// @Summary Retry an outbox message
+// @Description Make a pending or failed message due now with its attempt count reset
+// @Tags admin
+// @Produce json,xml
+// @Param message_id path int true "Outbox message ID"
+// @Success 200 {object} models.OutboxMessage
+// @Failure 400 {object} models.APIResponse
+// @Failure 404 {object} models.APIResponse
+// @Failure 409 {object} models.APIResponse
+// @Failure 500 {object} models.APIResponse
+// @Router /admin/outbox/{message_id}/retry [post]
+func (h *Handler) RetryOutboxMessageHandler(w http.ResponseWriter, r *http.Request) {
+	h.updateOutboxMessage(w, r, h.transactionService.RetryOutboxMessage)
+}
+
+// DiscardOutboxMessageHandler stops delivery of a pending or failed outbox message
+// @Summary Discard an outbox message
+// @Description Stop delivering a pending or failed message, for example one its destination will never accept
+// @Tags admin
+// @Produce json,xml
+// @Param message_id path int true "Outbox message ID"
+// @Success 200 {object} models.OutboxMessage
+// @Failure 400 {object} models.APIResponse
+// @Failure 404 {object} models.APIResponse
+// @Failure 409 {object} models.APIResponse
+// @Failure 500 {object} models.APIResponse
+// @Router /admin/outbox/{message_id}/discard [post]
+func (h *Handler) DiscardOutboxMessageHandler(w http.ResponseWriter, r *http.Request) {
+	h.updateOutboxMessage(w, r, h.transactionService.DiscardOutboxMessage)
+}
+
+// updateOutboxMessage applies an admin action to the outbox message in the path and responds with the result
+func (h *Handler) updateOutboxMessage(w http.ResponseWriter, r *http.Request, update func(context.Context, int) (*models.OutboxMessage, error)) {
+	messageID, err := strconv.Atoi(mux.Vars(r)["message_id"])
+	if err != nil || messageID <= 0 {
+		utils.SendErrorResponse(w, r, http.StatusBadRequest, "Invalid outbox message ID")
+		return
+	}
+
+	msg, err := update(r.Context(), messageID)
+	if err != nil {
+		switch {
+		case errors.Is(err, services.ErrOutboxMessageNotFound):
+			utils.SendErrorResponse(w, r, http.StatusNotFound, fmt.Sprintf("Outbox message not found: %d", messageID))
+		case errors.Is(err, services.ErrOutboxMessageDelivered):
+			utils.SendErrorResponse(w, r, http.StatusConflict, err.Error())
+		default:
+			utils.SendErrorResponse(w, r, http.StatusInternalServerError, err.Error())
+		}
+		return
+	}
+
+	utils.SendResponse(w, r, http.StatusOK, msg)
+}
+
+// adminCallbackID parses the callback ID path parameter, responding with 400 when it is invalid
+func adminCallbackID(w http.ResponseWriter, r *http.Request) (int, bool) {
+	callbackID, err := strconv.Atoi(mux.Vars(r)["callback_id"])
+	if err != nil || callbackID <= 0 {
+		utils.SendErrorResponse(w, r, http.StatusBadRequest, "Invalid callback ID")
+		return 0, false
+	}
+	return callbackID, true
+}
+
+// adminGatewayID reads the gateway_id path parameter, responding with 404 for unknown gateways
+func (h *Handler) adminGatewayID(w http.ResponseWriter, r *http.Request) (int, bool) {
+	gatewayID := mux.Vars(r)["gateway_id"]
+
+	if _, err := h.gatewaySelector.GetProviderByID(gatewayID); err != nil {
+		utils.SendErrorResponse(w, r, http.StatusNotFound, fmt.Sprintf("Gateway not found: %s", gatewayID))
+		return 0, false
+	}
+
+	id, err := strconv.Atoi(gatewayID)
+	if err != nil {
+		utils.SendErrorResponse(w, r, http.StatusNotFound, fmt.Sprintf("Gateway not found: %s", gatewayID))
+		return 0, false
+	}
+
+	return id, true
+}
@@ -0,0 +1,181 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"payment-gateway/internal/models"
+	"payment-gateway/internal/services"
+	"payment-gateway/internal/utils"
+	"payment-gateway/internal/validation"
+)
+
+// AuthorizeHandler authorizes a deposit to capture later
+// @Summary Authorize a deposit
+// @Description Authorize a card deposit: the gateway holds the amount on the customer's card until the deposit is captured or voided, or the authorization expires at authorization_expires_at.
+// @Description Only gateways that can hold amounts are selected. Gateways waiting for the customer to confirm the payment leave the deposit processing until they report it authorized.
+// @Tags transactions
+// @Accept json,xml
+// @Produce json,xml
+// @Param transaction body models.TransactionRequest true "Deposit request"
+// @Success 200 {object} models.TransactionResponse
+// @Failure 400 {object} models.APIResponse
+// @Failure 500 {object} models.APIResponse
+// @Router /authorize [post]
+func (h *Handler) AuthorizeHandler(w http.ResponseWriter, r *http.Request) {
+	var request models.TransactionRequest
+	if err := utils.DecodeRequest(r, &request); err != nil {
+		utils.SendDecodeError(w, r, err)
+		return
+	}
+	if err := services.ValidateTransactionRequest(request); err != nil {
+		utils.SendValidationError(w, r, err)
+		return
+	}
+
+	response, err := h.transactionService.ProcessAuthorization(r.Context(), request)
+
+	var fieldErrs validation.Errors
+	if errors.As(err, &fieldErrs) {
+		utils.SendValidationError(w, r, err)
+		return
+	}
+	if err != nil {
+		utils.SendErrorResponse(w, r, errorStatus(err), fmt.Sprintf("Failed to authorize deposit: %v", err))
+		return
+	}
+
+	utils.SendResponse(w, r, http.StatusOK, response)
+}
+
+// CaptureHandler captures an authorized deposit in full or in part
+// @Summary Capture an authorized deposit
+// @Description Capture part or all of what remains authorized of a deposit, all of it when no amount is given. A final capture, the default, releases the rest and the deposit's amount becomes the amount it captured.
+// @Description With final_capture false the rest stays authorized and the capture is returned as a deposit of its own, linked to the authorization by capture_of_transaction_id.
+// @Description Declined captures are returned with status failed and a decline_code. When the gateway can't be reached the deposit stays authorized and the capture can be retried.
+// @Tags transactions
+// @Accept json,xml
+// @Produce json,xml
+// @Param capture body models.CaptureRequest true "Capture"
+// @Success 200 {object} models.TransactionResponse
+// @Failure 400 {object} models.APIResponse
+// @Failure 404 {object} models.APIResponse
+// @Failure 409 {object} models.APIResponse
+// @Failure 422 {object} models.APIResponse
+// @Failure 500 {object} models.APIResponse
+// @Router /capture [post]
+func (h *Handler) CaptureHandler(w http.ResponseWriter, r *http.Request) {
+	var request models.CaptureRequest
+	if err := utils.DecodeRequest(r, &request); err != nil {
+		utils.SendDecodeError(w, r, err)
+		return
+	}
+	if err := validation.Struct(request); err != nil {
+		utils.SendValidationError(w, r, err)
+		return
+	}
+
+	response, err := h.transactionService.Capture(r.Context(), request)
+
+	var fieldErrs validation.Errors
+	if errors.As(err, &fieldErrs) {
+		utils.SendValidationError(w, r, err)
+		return
+	}
+	if err != nil {
+		sendAuthorizationError(w, r, request.TransactionID, "capture", err)
+		return
+	}
+
+	utils.SendResponse(w, r, http.StatusOK, response)
+}
+
+// VoidHandler releases the amount held for an authorized deposit
+// @Summary Void an authorized deposit
+// @Description Release the amount held for an authorized deposit, which becomes voided. When the gateway can't be reached the deposit stays authorized and the void can be retried.
+// @Tags transactions
+// @Accept json,xml
+// @Produce json,xml
+// @Param void body models.VoidRequest true "Void"
+// @Success 200 {object} models.TransactionResponse
+// @Failure 400 {object} models.APIResponse
+// @Failure 404 {object} models.APIResponse
+// @Failure 409 {object} models.APIResponse
+// @Failure 422 {object} models.APIResponse
+// @Failure 500 {object} models.APIResponse
+// @Router /void [post]
+func (h *Handler) VoidHandler(w http.ResponseWriter, r *http.Request) {
+	var request models.VoidRequest
+	if err := utils.DecodeRequest(r, &request); err != nil {
+		utils.SendDecodeError(w, r, err)
+		return
+	}
+	if err := validation.Struct(request); err != nil {
+		utils.SendValidationError(w, r, err)
+		return
+	}
+
+	response, err := h.transactionService.Void(r.Context(), request)
+	if err != nil {
+		sendAuthorizationError(w, r, request.TransactionID, "void", err)
+		return
+	}
+
+	utils.SendResponse(w, r, http.StatusOK, response)
+}
+
+// IncrementAuthorizationHandler raises the amount held for an authorized deposit
+// @Summary Increment an authorization
+// @Description Raise the amount held for an authorized deposit by amount, as when a hotel stay or car rental runs longer. The deposit must have been authorized with incremental_authorization at a gateway that supports it; its amount becomes the new authorized_amount.
+// @Description Declined increments are returned with status authorized, a decline_code and the authorized_amount unchanged: the gateway keeps holding the amount already authorized.
+// @Tags transactions
+// @Accept json,xml
+// @Produce json,xml
+// @Param increment body models.IncrementAuthorizationRequest true "Increment"
+// @Success 200 {object} models.TransactionResponse
+// @Failure 400 {object} models.APIResponse
+// @Failure 404 {object} models.APIResponse
+// @Failure 409 {object} models.APIResponse
+// @Failure 422 {object} models.APIResponse
+// @Failure 500 {object} models.APIResponse
+// @Router /increment-authorization [post]
+func (h *Handler) IncrementAuthorizationHandler(w http.ResponseWriter, r *http.Request) {
+	var request models.IncrementAuthorizationRequest
+	if err := utils.DecodeRequest(r, &request); err != nil {
+		utils.SendDecodeError(w, r, err)
+		return
+	}
+	if err := validation.Struct(request); err != nil {
+		utils.SendValidationError(w, r, err)
+		return
+	}
+
+	response, err := h.transactionService.IncrementAuthorization(r.Context(), request)
+
+	var fieldErrs validation.Errors
+	if errors.As(err, &fieldErrs) {
+		utils.SendValidationError(w, r, err)
+		return
+	}
+	if err != nil {
+		sendAuthorizationError(w, r, request.TransactionID, "increment the authorization of", err)
+		return
+	}
+
+	utils.SendResponse(w, r, http.StatusOK, response)
+}
+
+// sendAuthorizationError answers a failed capture, void or increment, the action
+func sendAuthorizationError(w http.ResponseWriter, r *http.Request, txID int, action string, err error) {
+	switch {
+	case errors.Is(err, services.ErrTransactionNotFound):
+		utils.SendErrorResponse(w, r, http.StatusNotFound, fmt.Sprintf("Transaction not found: %d", txID))
+	case errors.Is(err, services.ErrNotAuthorized), errors.Is(err, services.ErrCaptureExceedsAuthorization),
+		errors.Is(err, services.ErrIncrementNotRequested):
+		utils.SendErrorResponse(w, r, http.StatusConflict, err.Error())
+	case errors.Is(err, services.ErrAuthorizationUnsupported):
+		utils.SendErrorResponse(w, r, http.StatusUnprocessableEntity, err.Error())
+	default:
+		utils.SendErrorResponse(w, r, errorStatus(err), fmt.Sprintf("Failed to %s deposit: %v", action, err))
+	}
+}
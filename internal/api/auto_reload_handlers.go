@@ -0,0 +1,192 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"payment-gateway/internal/auth"
+	"payment-gateway/internal/models"
+	"payment-gateway/internal/services"
+	"payment-gateway/internal/utils"
+	"payment-gateway/internal/validation"
+	"strconv"
+
+	"github.com/gorilla/mux"
+)
+
+// CreateAutoReloadRuleHandler records a rule topping up the wallet of one of the calling merchant's users automatically
+// @Summary Create an auto-reload rule
+// @Description Top up the wallet of one of the calling merchant's users automatically: whenever its balance in the currency drops below the threshold, the scheduler deposits the amount under the user's SEPA mandate.
+// @Description Reloads are capped per day and in amount, and a rule is disabled after repeated failures or when its mandate is revoked.
+// @Description Merchants receive an auto_reload.failed webhook for each failed reload and an auto_reload.disabled webhook when a rule is disabled.
+// @Tags wallets
+// @Accept json,xml
+// @Produce json,xml
+// @Security BearerAuth
+// @Param rule body models.AutoReloadRuleRequest true "Auto-reload rule"
+// @Success 201 {object} models.AutoReloadRule
+// @Failure 400 {object} models.APIResponse
+// @Failure 401 {object} models.APIResponse
+// @Failure 403 {object} models.APIResponse
+// @Failure 404 {object} models.APIResponse
+// @Failure 500 {object} models.APIResponse
+// @Router /auto-reload-rules [post]
+func (h *Handler) CreateAutoReloadRuleHandler(w http.ResponseWriter, r *http.Request) {
+	caller, _ := auth.FromContext(r.Context())
+
+	var request models.AutoReloadRuleRequest
+	if err := utils.DecodeRequest(r, &request); err != nil {
+		utils.SendDecodeError(w, r, err)
+		return
+	}
+	if err := validation.Struct(request); err != nil {
+		utils.SendValidationError(w, r, err)
+		return
+	}
+
+	rule, err := h.transactionService.CreateAutoReloadRule(r.Context(), caller.MerchantID, request)
+
+	var fieldErrs validation.Errors
+	if errors.As(err, &fieldErrs) {
+		utils.SendValidationError(w, r, err)
+		return
+	}
+	if err != nil {
+		switch {
+		case errors.Is(err, services.ErrUserNotFound):
+			utils.SendErrorResponse(w, r, http.StatusNotFound, fmt.Sprintf("User not found: %d", request.UserID))
+		case errors.Is(err, services.ErrMandateNotFound):
+			utils.SendErrorResponse(w, r, http.StatusNotFound, fmt.Sprintf("Mandate not found: %d", request.MandateID))
+		case errors.Is(err, services.ErrInvalidAutoReloadRule):
+			utils.SendErrorResponse(w, r, http.StatusBadRequest, err.Error())
+		default:
+			utils.SendErrorResponse(w, r, errorStatus(err), err.Error())
+		}
+		return
+	}
+
+	utils.SendResponse(w, r, http.StatusCreated, rule)
+}
+
+// ListAutoReloadRulesHandler returns the auto-reload rules of one of the calling merchant's users
+// @Summary List auto-reload rules
+// @Description List the auto-reload rules of one of the calling merchant's users, active, disabled or cancelled, with the outcome of their last reload.
+// @Tags wallets
+// @Produce json,xml
+// @Security BearerAuth
+// @Param user_id query int true "User whose wallet the rules top up"
+// @Success 200 {array} models.AutoReloadRule
+// @Failure 400 {object} models.APIResponse
+// @Failure 401 {object} models.APIResponse
+// @Failure 404 {object} models.APIResponse
+// @Failure 500 {object} models.APIResponse
+// @Router /auto-reload-rules [get]
+func (h *Handler) ListAutoReloadRulesHandler(w http.ResponseWriter, r *http.Request) {
+	caller, _ := auth.FromContext(r.Context())
+
+	userID, err := strconv.Atoi(r.URL.Query().Get("user_id"))
+	if err != nil || userID <= 0 {
+		utils.SendErrorResponse(w, r, http.StatusBadRequest, "Invalid user ID")
+		return
+	}
+
+	rules, err := h.transactionService.ListAutoReloadRules(r.Context(), caller.MerchantID, userID)
+	if err != nil {
+		if errors.Is(err, services.ErrUserNotFound) {
+			utils.SendErrorResponse(w, r, http.StatusNotFound, fmt.Sprintf("User not found: %d", userID))
+			return
+		}
+		utils.SendErrorResponse(w, r, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	utils.SendResponse(w, r, http.StatusOK, rules)
+}
+
+// CancelAutoReloadRuleHandler stops an auto-reload rule
+// @Summary Cancel an auto-reload rule
+// @Description Cancel an auto-reload rule of one of the calling merchant's users for good. A reload already submitted is not cancelled.
+// @Tags wallets
+// @Produce json,xml
+// @Security BearerAuth
+// @Param rule_id path int true "Auto-reload rule ID"
+// @Param user_id query int true "User whose wallet the rule tops up"
+// @Success 200 {object} map[string]string
+// @Failure 400 {object} models.APIResponse
+// @Failure 401 {object} models.APIResponse
+// @Failure 403 {object} models.APIResponse
+// @Failure 404 {object} models.APIResponse
+// @Failure 500 {object} models.APIResponse
+// @Router /auto-reload-rules/{rule_id} [delete]
+func (h *Handler) CancelAutoReloadRuleHandler(w http.ResponseWriter, r *http.Request) {
+	caller, _ := auth.FromContext(r.Context())
+	ruleID, userID, ok := autoReloadRuleParams(w, r)
+	if !ok {
+		return
+	}
+
+	if err := h.transactionService.CancelAutoReloadRule(r.Context(), caller.MerchantID, ruleID, userID); err != nil {
+		if errors.Is(err, services.ErrAutoReloadRuleNotFound) {
+			utils.SendErrorResponse(w, r, http.StatusNotFound, fmt.Sprintf("Auto-reload rule not found: %d", ruleID))
+			return
+		}
+		utils.SendErrorResponse(w, r, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	utils.SendResponse(w, r, http.StatusOK, map[string]string{"status": "cancelled"})
+}
+
+// EnableAutoReloadRuleHandler re-enables a disabled auto-reload rule
+// @Summary Re-enable an auto-reload rule
+// @Description Re-enable an auto-reload rule of one of the calling merchant's users disabled after repeated failures, clearing its failure count. Its mandate must still be active.
+// @Tags wallets
+// @Produce json,xml
+// @Security BearerAuth
+// @Param rule_id path int true "Auto-reload rule ID"
+// @Param user_id query int true "User whose wallet the rule tops up"
+// @Success 200 {object} models.AutoReloadRule
+// @Failure 400 {object} models.APIResponse
+// @Failure 401 {object} models.APIResponse
+// @Failure 403 {object} models.APIResponse
+// @Failure 404 {object} models.APIResponse
+// @Failure 500 {object} models.APIResponse
+// @Router /auto-reload-rules/{rule_id}/enable [post]
+func (h *Handler) EnableAutoReloadRuleHandler(w http.ResponseWriter, r *http.Request) {
+	caller, _ := auth.FromContext(r.Context())
+	ruleID, userID, ok := autoReloadRuleParams(w, r)
+	if !ok {
+		return
+	}
+
+	rule, err := h.transactionService.EnableAutoReloadRule(r.Context(), caller.MerchantID, ruleID, userID)
+	if err != nil {
+		switch {
+		case errors.Is(err, services.ErrAutoReloadRuleNotFound):
+			utils.SendErrorResponse(w, r, http.StatusNotFound, fmt.Sprintf("Auto-reload rule not found: %d", ruleID))
+		case errors.Is(err, services.ErrMandateNotFound):
+			utils.SendErrorResponse(w, r, http.StatusBadRequest, "The rule's mandate is no longer active")
+		default:
+			utils.SendErrorResponse(w, r, errorStatus(err), err.Error())
+		}
+		return
+	}
+
+	utils.SendResponse(w, r, http.StatusOK, rule)
+}
+
+// autoReloadRuleParams parses the rule ID path parameter and user ID query parameter, answering
+// 400 when either is invalid
+func autoReloadRuleParams(w http.ResponseWriter, r *http.Request) (ruleID, userID int, ok bool) {
+	ruleID, err := strconv.Atoi(mux.Vars(r)["rule_id"])
+	if err != nil || ruleID <= 0 {
+		utils.SendErrorResponse(w, r, http.StatusBadRequest, "Invalid auto-reload rule ID")
+		return 0, 0, false
+	}
+	userID, err = strconv.Atoi(r.URL.Query().Get("user_id"))
+	if err != nil || userID <= 0 {
+		utils.SendErrorResponse(w, r, http.StatusBadRequest, "Invalid user ID")
+		return 0, 0, false
+	}
+	return ruleID, userID, true
+}
@@ -0,0 +1,835 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"payment-gateway/db"
+	"payment-gateway/internal/consts"
+	"payment-gateway/internal/gateway"
+	"payment-gateway/internal/geo"
+	"payment-gateway/internal/kafka"
+	"payment-gateway/internal/models"
+	"payment-gateway/internal/services"
+	"payment-gateway/internal/utils"
+	"payment-gateway/internal/warehouse"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// update rewrites the golden files with the responses received, for intentional changes to the API:
+//
+//	go test ./internal/api -run TestGoldenResponses -update
+var update = flag.Bool("update", false, "rewrite the golden files in testdata/golden with the responses received")
+
+// goldenAdminToken is the admin token the router under test requires on admin routes
+const goldenAdminToken = "golden-admin-token"
+
+// goldenCase is a request whose response must match testdata/golden/<name>.golden. Every case runs
+// on a server and database of its own, after its setup requests, so a case's response depends only
+// on what it sets up. ${step.field} in a path, body or header is replaced with a field of a setup
+// request's JSON response, and ${in.duration} with the time that far from now.
+type goldenCase struct {
+	name        string
+	method      string
+	path        string
+	body        string
+	contentType string // of the body; JSON by default
+	accept      string // JSON by default
+	headers     map[string]string
+	anonymous   bool         // sent without the admin token
+	until       string       // resend until the response contains this, for work finished in the background
+	setup       []goldenCase // requests run first on the case's server, whose responses aren't compared
+	omit        []string     // response fields left out of the golden file
+}
+
+// after returns the case run after the setup requests steps
+func (tc goldenCase) after(steps ...goldenCase) goldenCase {
+	tc.setup = steps
+	return tc
+}
+
+// merchantKey authenticates merchant API cases with the API key created by createAPIKey
+var merchantKey = map[string]string{consts.APIKeyHeader: "${admin_api_key_create.key}"}
+
+// Requests cases set up state with, each a case of its own too
+var (
+	createDeposit             = goldenCase{name: "deposit", method: "POST", path: "/deposit", body: `{"user_id":1,"amount":25,"currency":"USD"}`}
+	completeDeposit           = goldenCase{name: "callback_completed", method: "POST", path: "/callback/1", body: `{"transaction_id":${deposit.transaction_id},"status":"completed","gateway_reference":"${deposit.gateway_reference}"}`}
+	malformedCallback         = goldenCase{name: "callback_malformed", method: "POST", path: "/callback/1", body: `not json`}
+	createWithdrawal          = goldenCase{name: "withdraw", method: "POST", path: "/withdraw", body: `{"user_id":1,"amount":5,"currency":"USD"}`}
+	createIdempotentDeposit   = goldenCase{name: "deposit_idempotent", method: "POST", path: "/deposit", body: `{"user_id":2,"amount":30,"currency":"GBP"}`, headers: map[string]string{"Idempotency-Key": "golden-1"}}
+	submitDispute             = goldenCase{name: "dispute_submit", method: "POST", path: "/transactions/${deposit.transaction_id}/dispute", body: `{"user_id":1,"description":"I do not recognize this deposit"}`}
+	createRefund              = goldenCase{name: "refund", method: "POST", path: "/refund", body: `{"transaction_id":${deposit.transaction_id},"amount":15,"reason":"damaged"}`, headers: merchantKey}
+	authorizeDeposit          = goldenCase{name: "authorize", method: "POST", path: "/authorize", body: `{"user_id":1,"amount":50,"currency":"USD","incremental_authorization":true}`}
+	voidAuthorization         = goldenCase{name: "void", method: "POST", path: "/void", body: `{"transaction_id":${authorize.transaction_id}}`}
+	createBatch               = goldenCase{name: "batch_deposit", method: "POST", path: "/deposits/batch", body: `{"transactions":[{"user_id":1,"amount":5,"currency":"USD"}]}`}
+	createBulkWithdrawal      = goldenCase{name: "bulk_withdraw", method: "POST", path: "/withdrawals/batch", contentType: "text/csv", body: "user_id,amount,currency,beneficiary\n1,1.00,USD,GB29NWBK60161331926819\n2,abc,GBP,GB29NWBK60161331926819\n"}
+	addTags                   = goldenCase{name: "admin_transaction_tags_add", method: "POST", path: "/admin/transactions/${deposit.transaction_id}/tags", body: `{"tags":["campaign:launch","VIP"]}`}
+	holdDeposit               = goldenCase{name: "admin_hold", method: "POST", path: "/admin/transactions/${deposit.transaction_id}/hold", body: `{"reason":"manual review"}`}
+	releaseHold               = goldenCase{name: "admin_hold_release", method: "POST", path: "/admin/transactions/${deposit.transaction_id}/hold/release", body: `{"note":"looks fine"}`}
+	addWebhookSecret          = goldenCase{name: "admin_webhook_secret_add", method: "POST", path: "/admin/gateways/3/webhook-secrets", body: `{"secret":"0123456789abcdef0123"}`}
+	addGeneratedWebhookSecret = goldenCase{name: "admin_webhook_secret_add_generated", method: "POST", path: "/admin/gateways/3/webhook-secrets"}
+	scheduleMaintenance       = goldenCase{name: "admin_maintenance_schedule", method: "POST", path: "/admin/gateways/2/maintenance", body: `{"starts_at":"2099-01-01T00:00:00Z","ends_at":"2099-01-01T02:00:00Z","reason":"upgrade"}`}
+	addHoliday                = goldenCase{name: "admin_holiday_add", method: "POST", path: "/admin/countries/US/holidays", body: `{"date":"2099-07-04","name":"Independence Day"}`}
+	setComplianceFields       = goldenCase{name: "admin_compliance_fields_set", method: "PUT", path: "/admin/countries/DE/compliance-fields", body: `{"fields":[{"name":"tax_id","label":"Tax ID","pattern":"^[0-9]{11}$","types":["withdrawal"]}]}`}
+	setAMLThreshold           = goldenCase{name: "admin_aml_threshold_set", method: "PUT", path: "/admin/countries/GB/aml-thresholds/GBP", body: `{"amount":10000}`}
+	createAPIKey              = goldenCase{name: "admin_api_key_create", method: "POST", path: "/admin/merchants/1/api-keys", body: `{"name":"golden","scope":"full"}`}
+	createOAuthClient         = goldenCase{name: "admin_oauth_client_create", method: "POST", path: "/admin/merchants/1/oauth-clients", body: `{"name":"golden","scopes":["payments:read","payments:write"]}`}
+	replaceFees               = goldenCase{name: "admin_merchant_fees_replace", method: "PUT", path: "/admin/merchants/1/fees", body: `{"fees":[{"transaction_type":"deposit","percentage":1.5,"fixed":0.3,"currency":"USD"}]}`}
+	createUser                = goldenCase{name: "admin_user_create", method: "POST", path: "/admin/users", body: `{"username":"golden","email":"golden@example.com","full_name":"Golden Tester","country_code":"GB","merchant_id":1}`}
+	createReadOnlyKey         = goldenCase{name: "merchant_api_key_create", method: "POST", path: "/merchant/api-keys", body: `{"name":"reporting","scope":"read_only"}`, headers: merchantKey}
+	createTransfer            = goldenCase{name: "transfer", method: "POST", path: "/transfers", body: `{"from_user_id":1,"to_user_id":2,"amount":3,"currency":"USD","description":"lunch"}`, headers: merchantKey}
+	replaceRoutingRules       = goldenCase{name: "merchant_routing_rules_replace", method: "PUT", path: "/merchant/routing-rules", body: `{"rules":[{"field":"amount","operator":"gte","value":"100","action":"prefer","gateway_id":2}]}`, headers: merchantKey}
+	savePayoutSchedule        = goldenCase{name: "merchant_payout_schedule_save", method: "PUT", path: "/merchant/payout-schedule", body: `{"frequency":"weekly","weekday":"friday","payout_time":"17:00","cutoff_time":"15:00"}`, headers: merchantKey}
+	generateReconciliation    = goldenCase{name: "merchant_reconciliation_generate", method: "POST", path: "/merchant/reports/reconciliation", body: `{"date":"2026-01-01","format":"csv"}`, headers: merchantKey}
+	addMerchantTags           = goldenCase{name: "merchant_transaction_tags_add", method: "POST", path: "/merchant/transactions/${deposit.transaction_id}/tags", body: `{"tags":["order:1001"]}`, headers: merchantKey}
+	rollWebhookSecret         = goldenCase{name: "merchant_webhook_secret_roll", method: "POST", path: "/merchant/webhook-secret", headers: merchantKey}
+	createWebhookEndpoint     = goldenCase{name: "merchant_webhook_endpoint_create", method: "POST", path: "/merchant/webhook-endpoints", body: `{"url":"https://example.com/hooks","event_types":["transaction.status_changed"],"payload_version":"v2"}`, headers: merchantKey}
+)
+
+// goldenCases exercise every route at least once, with its main error cases
+var goldenCases = []goldenCase{
+	// Health and documentation
+	{name: "health", method: "GET", path: "/health"},
+	{name: "ready", method: "GET", path: "/ready"},
+	{name: "asyncapi", method: "GET", path: "/docs/asyncapi.json"},
+	{name: "jwks", method: "GET", path: "/.well-known/jwks.json"},
+	{name: "payment_methods", method: "GET", path: "/payment-methods?country=US&currency=USD"},
+	{name: "payment_methods_xml", method: "GET", path: "/payment-methods?country=US&currency=USD", accept: "application/xml"},
+
+	// Deposits and withdrawals
+	createDeposit,
+	{name: "deposit_xml", method: "POST", path: "/deposit", contentType: "application/xml", accept: "application/xml",
+		body: `<TransactionRequest><UserID>1</UserID><Amount>10</Amount><Currency>USD</Currency></TransactionRequest>`},
+	{name: "deposit_invalid", method: "POST", path: "/deposit", body: `{"user_id":1,"amount":-5,"currency":"USDX"}`},
+	{name: "deposit_invalid_xml", method: "POST", path: "/deposit", body: `{"user_id":1,"amount":-5,"currency":"USDX"}`, accept: "application/xml"},
+	{name: "deposit_malformed", method: "POST", path: "/deposit", body: `{"user_id":`},
+	{name: "deposit_unknown_user", method: "POST", path: "/deposit", body: `{"user_id":999,"amount":25,"currency":"USD"}`},
+	{name: "deposit_unsupported_content_type", method: "POST", path: "/deposit", contentType: "text/plain", body: `user_id=1`},
+	completeDeposit.after(createDeposit),
+	{name: "callback_replayed", method: "POST", path: "/callback/1", body: `{"transaction_id":${deposit.transaction_id},"status":"completed","gateway_reference":"${deposit.gateway_reference}"}`, setup: []goldenCase{createDeposit, completeDeposit}},
+	{name: "callback_illegal_transition", method: "POST", path: "/callback/1", body: `{"transaction_id":${deposit.transaction_id},"status":"processing","gateway_reference":"${deposit.gateway_reference}"}`, setup: []goldenCase{createDeposit, completeDeposit}},
+	{name: "callback_unknown_gateway", method: "POST", path: "/callback/99", body: `{}`},
+	malformedCallback,
+	createWithdrawal.after(createDeposit, completeDeposit),
+	{name: "withdraw_insufficient_balance", method: "POST", path: "/withdraw", body: `{"user_id":1,"amount":5,"currency":"USD"}`},
+	{name: "withdraw_invalid", method: "POST", path: "/withdraw", body: `{"user_id":0,"amount":0,"currency":""}`},
+	createIdempotentDeposit,
+	{name: "deposit_idempotent_replayed", method: "POST", path: "/deposit", body: `{"user_id":2,"amount":30,"currency":"GBP"}`, headers: map[string]string{"Idempotency-Key": "golden-1"}, setup: []goldenCase{createIdempotentDeposit}},
+	{name: "deposit_idempotency_key_reused", method: "POST", path: "/deposit", body: `{"user_id":2,"amount":31,"currency":"GBP"}`, headers: map[string]string{"Idempotency-Key": "golden-1"}, setup: []goldenCase{createIdempotentDeposit}},
+	{name: "deposit_idempotent_too_large", method: "POST", path: "/deposit", body: `{"user_id":2,"amount":30,"currency":"GBP","description":"` + strings.Repeat("x", consts.MaxRequestBodySize) + `"}`, headers: map[string]string{"Idempotency-Key": "golden-2"}},
+
+	// Transactions
+	{name: "transaction", method: "GET", path: "/transactions/${deposit.transaction_id}?user_id=1", setup: []goldenCase{createDeposit}},
+	{name: "transaction_xml", method: "GET", path: "/transactions/${deposit.transaction_id}?user_id=1", accept: "application/xml", setup: []goldenCase{createDeposit}},
+	{name: "transaction_not_found", method: "GET", path: "/transactions/999?user_id=1"},
+	{name: "transaction_invalid_id", method: "GET", path: "/transactions/abc?user_id=1"},
+	{name: "transaction_events", method: "GET", path: "/transactions/${deposit.transaction_id}/events?user_id=1", setup: []goldenCase{createDeposit, completeDeposit}},
+	{name: "transaction_events_not_found", method: "GET", path: "/transactions/999/events?user_id=1"},
+	submitDispute.after(createDeposit, completeDeposit),
+	{name: "dispute_submit_twice", method: "POST", path: "/transactions/${deposit.transaction_id}/dispute", body: `{"user_id":1}`, setup: []goldenCase{createDeposit, completeDeposit, submitDispute}},
+	{name: "dispute", method: "GET", path: "/transactions/${deposit.transaction_id}/dispute?user_id=1", setup: []goldenCase{createDeposit, completeDeposit, submitDispute}},
+	{name: "dispute_not_found", method: "GET", path: "/transactions/999/dispute?user_id=1"},
+	{name: "payment_consent_not_found", method: "GET", path: "/transactions/${deposit.transaction_id}/consent?user_id=1", setup: []goldenCase{createDeposit}},
+
+	// Refunds
+	createRefund.after(createAPIKey, createDeposit, completeDeposit),
+	{name: "refund_unauthenticated", method: "POST", path: "/refund", body: `{"transaction_id":${deposit.transaction_id}}`, anonymous: true, setup: []goldenCase{createDeposit, completeDeposit}},
+	{name: "refund_not_found", method: "POST", path: "/refund", body: `{"transaction_id":999}`, headers: merchantKey, setup: []goldenCase{createAPIKey}},
+	{name: "refund_exceeding", method: "POST", path: "/refund", body: `{"transaction_id":${deposit.transaction_id},"amount":100}`, headers: merchantKey, setup: []goldenCase{createAPIKey, createDeposit, completeDeposit}},
+	{name: "refund_not_completed", method: "POST", path: "/refund", body: `{"transaction_id":${deposit.transaction_id}}`, headers: merchantKey, setup: []goldenCase{createAPIKey, createDeposit}},
+	{name: "refund_invalid", method: "POST", path: "/refund", body: `{"transaction_id":0}`, headers: merchantKey, setup: []goldenCase{createAPIKey}},
+	{name: "refund_get", method: "GET", path: "/refund/${refund.id}", headers: merchantKey, setup: []goldenCase{createAPIKey, createDeposit, completeDeposit, createRefund}},
+	{name: "refund_get_unauthenticated", method: "GET", path: "/refund/${refund.id}", anonymous: true, setup: []goldenCase{createAPIKey, createDeposit, completeDeposit, createRefund}},
+	{name: "refund_get_not_found", method: "GET", path: "/refund/999", headers: merchantKey, setup: []goldenCase{createAPIKey}},
+
+	// Authorize and capture
+	authorizeDeposit,
+	{name: "increment_authorization", method: "POST", path: "/increment-authorization", body: `{"transaction_id":${authorize.transaction_id},"amount":5}`, setup: []goldenCase{authorizeDeposit}},
+	{name: "capture", method: "POST", path: "/capture", body: `{"transaction_id":${authorize.transaction_id},"amount":20}`, setup: []goldenCase{authorizeDeposit}},
+	{name: "capture_not_found", method: "POST", path: "/capture", body: `{"transaction_id":999}`},
+	voidAuthorization.after(authorizeDeposit),
+	{name: "void_twice", method: "POST", path: "/void", body: `{"transaction_id":${authorize.transaction_id}}`, setup: []goldenCase{authorizeDeposit, voidAuthorization}},
+	{name: "void_invalid", method: "POST", path: "/void", body: `{}`},
+
+	// Batches and operations
+	createBatch,
+	{name: "batch_deposit_empty", method: "POST", path: "/deposits/batch", body: `{"transactions":[]}`},
+	{name: "batch_deposit_get", method: "GET", path: "/deposits/batch/${batch_deposit.id}", setup: []goldenCase{createBatch}},
+	{name: "batch_deposit_not_found", method: "GET", path: "/deposits/batch/999"},
+	createBulkWithdrawal.after(createDeposit, completeDeposit),
+	{name: "bulk_withdraw_get", method: "GET", path: "/withdrawals/batch/${bulk_withdraw.id}", until: `"succeeded":1`, setup: []goldenCase{createDeposit, completeDeposit, createBulkWithdrawal}},
+	{name: "operation", method: "GET", path: "/operations/${bulk_withdraw.operation_id}", until: `"status":"succeeded"`, setup: []goldenCase{createDeposit, completeDeposit, createBulkWithdrawal}},
+	{name: "operation_not_found", method: "GET", path: "/operations/op_unknown"},
+
+	// Open banking, bank accounts and mandates
+	{name: "open_banking_banks", method: "GET", path: "/open-banking/banks?country=GB"},
+	{name: "open_banking_callback_invalid", method: "GET", path: "/open-banking/callback"},
+	{name: "bank_account_link_no_gateway", method: "POST", path: "/bank-accounts/link", body: `{"user_id":2}`},
+	{name: "bank_account_link_invalid", method: "POST", path: "/bank-accounts/link", body: `{"user_id":0}`},
+	{name: "bank_account_callback_invalid", method: "GET", path: "/bank-accounts/callback"},
+	{name: "bank_accounts", method: "GET", path: "/bank-accounts?user_id=2"},
+	{name: "bank_account_revoke_not_found", method: "DELETE", path: "/bank-accounts/999?user_id=2"},
+	{name: "mandate_create_unauthenticated", method: "POST", path: "/mandates", body: `{"user_id":3,"debtor_name":"Max Mustermann","iban":"DE89370400440532013000"}`, anonymous: true},
+	{name: "mandate_create_no_gateway", method: "POST", path: "/mandates", body: `{"user_id":3,"debtor_name":"Max Mustermann","iban":"DE89370400440532013000"}`, headers: merchantKey, setup: []goldenCase{createAPIKey}},
+	{name: "mandate_create_invalid", method: "POST", path: "/mandates", body: `{"user_id":3,"debtor_name":"","iban":"DE00"}`, headers: merchantKey, setup: []goldenCase{createAPIKey}},
+	{name: "mandates", method: "GET", path: "/mandates?user_id=3", headers: merchantKey, setup: []goldenCase{createAPIKey}},
+	{name: "mandates_unauthenticated", method: "GET", path: "/mandates?user_id=3", anonymous: true},
+	{name: "mandates_user_not_found", method: "GET", path: "/mandates?user_id=999", headers: merchantKey, setup: []goldenCase{createAPIKey}},
+	{name: "mandate_revoke_unauthenticated", method: "DELETE", path: "/mandates/999?user_id=3", anonymous: true},
+	{name: "mandate_revoke_not_found", method: "DELETE", path: "/mandates/999?user_id=3", headers: merchantKey, setup: []goldenCase{createAPIKey}},
+	{name: "upi_vpa_validate_no_gateway", method: "POST", path: "/upi/vpa/validate", body: `{"user_id":1,"vpa":"someone@upi"}`},
+
+	// Transfers, wallets and auto-reload
+	{name: "auto_reload_rule_create_unauthenticated", method: "POST", path: "/auto-reload-rules", body: `{"user_id":3,"currency":"EUR","threshold":10,"amount":50,"mandate_id":999}`, anonymous: true},
+	{name: "auto_reload_rule_create_unknown_mandate", method: "POST", path: "/auto-reload-rules", body: `{"user_id":3,"currency":"EUR","threshold":10,"amount":50,"mandate_id":999}`, headers: merchantKey, setup: []goldenCase{createAPIKey}},
+	{name: "auto_reload_rules", method: "GET", path: "/auto-reload-rules?user_id=3", headers: merchantKey, setup: []goldenCase{createAPIKey}},
+	{name: "auto_reload_rules_unauthenticated", method: "GET", path: "/auto-reload-rules?user_id=3", anonymous: true},
+	{name: "auto_reload_rules_user_not_found", method: "GET", path: "/auto-reload-rules?user_id=999", headers: merchantKey, setup: []goldenCase{createAPIKey}},
+	{name: "auto_reload_rule_cancel_unauthenticated", method: "DELETE", path: "/auto-reload-rules/999?user_id=3", anonymous: true},
+	{name: "auto_reload_rule_cancel_not_found", method: "DELETE", path: "/auto-reload-rules/999?user_id=3", headers: merchantKey, setup: []goldenCase{createAPIKey}},
+	{name: "auto_reload_rule_enable_unauthenticated", method: "POST", path: "/auto-reload-rules/999/enable?user_id=3", anonymous: true},
+	{name: "auto_reload_rule_enable_not_found", method: "POST", path: "/auto-reload-rules/999/enable?user_id=3", headers: merchantKey, setup: []goldenCase{createAPIKey}},
+
+	// Admin: authentication
+	{name: "admin_unauthorized", method: "GET", path: "/admin/transactions", anonymous: true},
+	{name: "admin_unauthorized_xml", method: "GET", path: "/admin/transactions", anonymous: true, accept: "application/xml"},
+
+	// Admin: transactions, tags and holds
+	{name: "admin_transactions", method: "GET", path: "/admin/transactions?limit=2", setup: []goldenCase{createDeposit, completeDeposit, createWithdrawal}},
+	{name: "admin_search", method: "GET", path: "/admin/search?q=${deposit.reference_id}", setup: []goldenCase{createDeposit}},
+	addTags.after(createDeposit),
+	{name: "admin_transaction_tags_invalid", method: "POST", path: "/admin/transactions/${deposit.transaction_id}/tags", body: `{"tags":["not a tag!"]}`, setup: []goldenCase{createDeposit}},
+	{name: "admin_transaction_tags", method: "GET", path: "/admin/transactions/${deposit.transaction_id}/tags", setup: []goldenCase{createDeposit, addTags}},
+	{name: "admin_transaction_tag_remove", method: "DELETE", path: "/admin/transactions/${deposit.transaction_id}/tags/vip", setup: []goldenCase{createDeposit, addTags}},
+	{name: "admin_hold_withdrawal", method: "POST", path: "/admin/transactions/${withdraw.transaction_id}/hold", body: `{"reason":"manual review"}`, setup: []goldenCase{createDeposit, completeDeposit, createWithdrawal}},
+	holdDeposit.after(createDeposit, completeDeposit),
+	{name: "admin_hold_get", method: "GET", path: "/admin/transactions/${deposit.transaction_id}/hold", setup: []goldenCase{createDeposit, completeDeposit, holdDeposit}},
+	{name: "admin_hold_extend", method: "POST", path: "/admin/transactions/${deposit.transaction_id}/hold/extend", body: `{"release_at":"${in.600h}"}`, setup: []goldenCase{createDeposit, completeDeposit, holdDeposit}},
+	{name: "admin_hold_extend_too_far", method: "POST", path: "/admin/transactions/${deposit.transaction_id}/hold/extend", body: `{"release_at":"2099-01-01T00:00:00Z"}`, setup: []goldenCase{createDeposit, completeDeposit, holdDeposit}},
+	{name: "admin_holds", method: "GET", path: "/admin/holds", setup: []goldenCase{createDeposit, completeDeposit, holdDeposit}},
+	releaseHold.after(createDeposit, completeDeposit, holdDeposit),
+	{name: "admin_hold_release_twice", method: "POST", path: "/admin/transactions/${deposit.transaction_id}/hold/release", body: `{}`, setup: []goldenCase{createDeposit, completeDeposit, holdDeposit, releaseHold}},
+	{name: "admin_transaction_delete_not_found", method: "DELETE", path: "/admin/transactions/999"},
+
+	// Admin: archival, metrics and anomalies
+	{name: "admin_archival", method: "GET", path: "/admin/archival", setup: []goldenCase{createDeposit, completeDeposit}},
+	{name: "admin_archival_start", method: "POST", path: "/admin/archival"},
+	{name: "admin_metrics_realtime", method: "GET", path: "/admin/metrics/realtime", setup: []goldenCase{createDeposit, completeDeposit}},
+	{name: "admin_metrics_http_clients", method: "GET", path: "/admin/metrics/http-clients"},
+	{name: "admin_metrics_http_server", method: "GET", path: "/admin/metrics/http-server"},
+	{name: "admin_anomalies", method: "GET", path: "/admin/anomalies", setup: []goldenCase{createDeposit}},
+
+	// Admin: gateway credentials and maintenance
+	addWebhookSecret,
+	{name: "admin_webhook_secret_add_invalid", method: "POST", path: "/admin/gateways/3/webhook-secrets", body: `{"secret":"short"}`},
+	addGeneratedWebhookSecret,
+	{name: "admin_webhook_secrets", method: "GET", path: "/admin/gateways/3/webhook-secrets", setup: []goldenCase{addWebhookSecret, addGeneratedWebhookSecret}},
+	{name: "admin_webhook_secret_retire", method: "DELETE", path: "/admin/gateways/3/webhook-secrets/${admin_webhook_secret_add.id}", setup: []goldenCase{addWebhookSecret, addGeneratedWebhookSecret}},
+	{name: "admin_webhook_secret_retire_last", method: "DELETE", path: "/admin/gateways/3/webhook-secrets/${admin_webhook_secret_add_generated.id}", setup: []goldenCase{addGeneratedWebhookSecret}},
+	{name: "admin_client_certificate_add_invalid", method: "POST", path: "/admin/gateways/1/client-certificates", body: `{"certificate":"not a certificate","private_key":"not a key"}`},
+	{name: "admin_client_certificates", method: "GET", path: "/admin/gateways/1/client-certificates"},
+	{name: "admin_client_certificate_retire_not_found", method: "DELETE", path: "/admin/gateways/1/client-certificates/999"},
+	{name: "admin_signing_key_add_invalid", method: "POST", path: "/admin/gateways/1/signing-keys", body: `{"private_key":"not a key"}`},
+	{name: "admin_signing_keys", method: "GET", path: "/admin/gateways/1/signing-keys"},
+	{name: "admin_signing_key_retire_not_found", method: "DELETE", path: "/admin/gateways/1/signing-keys/999"},
+	scheduleMaintenance,
+	{name: "admin_maintenance_schedule_invalid", method: "POST", path: "/admin/gateways/2/maintenance", body: `{"starts_at":"2099-01-01T02:00:00Z","ends_at":"2099-01-01T00:00:00Z"}`},
+	{name: "admin_maintenance", method: "GET", path: "/admin/gateways/2/maintenance", setup: []goldenCase{scheduleMaintenance}},
+	{name: "admin_maintenance_cancel", method: "DELETE", path: "/admin/gateways/2/maintenance/${admin_maintenance_schedule.id}", setup: []goldenCase{scheduleMaintenance}},
+	{name: "admin_merchant_certificate_add_invalid", method: "POST", path: "/admin/merchant-certificates", body: `{"wallet":"google_pay","private_key":"not a key"}`},
+	{name: "admin_merchant_certificates", method: "GET", path: "/admin/merchant-certificates"},
+	{name: "admin_merchant_certificate_retire_not_found", method: "DELETE", path: "/admin/merchant-certificates/999"},
+	{name: "admin_routing_simulate", method: "POST", path: "/admin/routing/simulate", body: `{"merchant_id":1,"type":"deposit","country_code":"US","amount":25,"currency":"USD"}`},
+
+	// Admin: countries
+	{name: "admin_holidays", method: "GET", path: "/admin/countries/US/holidays"},
+	addHoliday,
+	{name: "admin_holiday_remove", method: "DELETE", path: "/admin/countries/US/holidays/2099-07-04", setup: []goldenCase{addHoliday}},
+	{name: "admin_holidays_unknown_country", method: "GET", path: "/admin/countries/ZZ/holidays"},
+	setComplianceFields,
+	{name: "admin_compliance_fields", method: "GET", path: "/admin/countries/DE/compliance-fields", setup: []goldenCase{setComplianceFields}},
+	setAMLThreshold,
+	{name: "admin_aml_threshold_set_invalid", method: "PUT", path: "/admin/countries/GB/aml-thresholds/GBP", body: `{"amount":-1}`},
+	{name: "admin_aml_thresholds", method: "GET", path: "/admin/countries/GB/aml-thresholds", setup: []goldenCase{setAMLThreshold}},
+	{name: "admin_aml_threshold_delete", method: "DELETE", path: "/admin/countries/GB/aml-thresholds/GBP", setup: []goldenCase{setAMLThreshold}},
+
+	// Admin: review queues
+	{name: "admin_aml_cases", method: "GET", path: "/admin/aml-cases"},
+	{name: "admin_aml_export", method: "GET", path: "/admin/aml-cases/export?format=csv"},
+	{name: "admin_aml_case_not_found", method: "GET", path: "/admin/aml-cases/999"},
+	{name: "admin_aml_case_update_not_found", method: "PUT", path: "/admin/aml-cases/999", body: `{"status":"dismissed"}`},
+	{name: "admin_screening_cases", method: "GET", path: "/admin/screening-cases"},
+	{name: "admin_screening_case_not_found", method: "GET", path: "/admin/screening-cases/999"},
+	{name: "admin_screening_case_decide_invalid", method: "PUT", path: "/admin/screening-cases/999", body: `{"status":"ignored"}`},
+	{name: "admin_decline_codes", method: "GET", path: "/admin/decline-codes"},
+	{name: "admin_decline_code_set", method: "PUT", path: "/admin/decline-codes/insufficient_funds", body: `{"recovery_hint":"use_other_method"}`},
+	{name: "admin_decline_code_set_invalid", method: "PUT", path: "/admin/decline-codes/insufficient_funds", body: `{"recovery_hint":"pray"}`},
+	{name: "admin_disputes", method: "GET", path: "/admin/disputes", setup: []goldenCase{createDeposit, completeDeposit, submitDispute}},
+	{name: "admin_dispute_update", method: "PUT", path: "/admin/disputes/${dispute_submit.id}", body: `{"status":"resolved","resolution":"Charge confirmed by the user"}`, setup: []goldenCase{createDeposit, completeDeposit, submitDispute}},
+	{name: "admin_dispute_update_not_found", method: "PUT", path: "/admin/disputes/999", body: `{"status":"in_review"}`},
+
+	// Admin: callbacks and outbox
+	{name: "admin_callback", method: "GET", path: "/admin/callbacks/1", setup: []goldenCase{createDeposit, completeDeposit}},
+	{name: "admin_callback_not_found", method: "GET", path: "/admin/callbacks/999"},
+	{name: "admin_callback_reparse", method: "POST", path: "/admin/callbacks/1/reparse", setup: []goldenCase{malformedCallback}},
+	{name: "admin_callback_reparse_processed", method: "POST", path: "/admin/callbacks/1/reparse", setup: []goldenCase{createDeposit, completeDeposit}},
+	{name: "admin_outbox", method: "GET", path: "/admin/outbox?limit=2", setup: []goldenCase{createDeposit, completeDeposit},
+		omit: []string{"id", "transaction_id", "dedup_token", "created_at", "delivered_at", "next_attempt_at", "occurred_at", "updated_at"}},
+	{name: "admin_outbox_retry_not_found", method: "POST", path: "/admin/outbox/999/retry"},
+	{name: "admin_outbox_discard_not_found", method: "POST", path: "/admin/outbox/999/discard"},
+
+	// Admin: merchants and users
+	createAPIKey,
+	{name: "admin_api_key_create_unknown_merchant", method: "POST", path: "/admin/merchants/999/api-keys", body: `{"name":"golden"}`},
+	createOAuthClient,
+	{name: "admin_livemode", method: "PUT", path: "/admin/merchants/1/livemode", body: `{"livemode":false}`},
+	{name: "admin_livemode_unavailable", method: "PUT", path: "/admin/merchants/1/livemode", body: `{"livemode":true}`},
+	replaceFees,
+	{name: "admin_merchant_fees", method: "GET", path: "/admin/merchants/1/fees", setup: []goldenCase{replaceFees}},
+	createUser,
+	{name: "admin_user_create_invalid", method: "POST", path: "/admin/users", body: `{"username":"","email":"not-an-email","country_code":"XX"}`},
+	{name: "admin_user_contact", method: "PUT", path: "/admin/users/${admin_user_create.id}/contact", body: `{"phone":"07911 123456","postal_code":"sw1a 1aa"}`, setup: []goldenCase{createUser}},
+	{name: "admin_user_screening_events", method: "GET", path: "/admin/users/${admin_user_create.id}/screening-events", setup: []goldenCase{createUser}},
+
+	// OAuth2
+	{name: "oauth_token", method: "POST", path: "/oauth/token", contentType: "application/x-www-form-urlencoded",
+		body: `grant_type=client_credentials&client_id=${admin_oauth_client_create.client_id}&client_secret=${admin_oauth_client_create.client_secret}`, setup: []goldenCase{createOAuthClient}},
+	{name: "oauth_token_invalid_client", method: "POST", path: "/oauth/token", contentType: "application/x-www-form-urlencoded",
+		body: `grant_type=client_credentials&client_id=unknown&client_secret=wrong`},
+	{name: "oauth_token_unsupported_grant", method: "POST", path: "/oauth/token", contentType: "application/x-www-form-urlencoded", body: `grant_type=password`},
+
+	// Merchant API: authentication
+	{name: "merchant_unauthenticated", method: "GET", path: "/merchant/api-keys", anonymous: true},
+	{name: "merchant_invalid_key", method: "GET", path: "/merchant/api-keys", headers: map[string]string{consts.APIKeyHeader: "pgk_invalid"}},
+
+	// Merchant API: keys
+	{name: "merchant_api_keys", method: "GET", path: "/merchant/api-keys", headers: merchantKey, setup: []goldenCase{createAPIKey}},
+	createReadOnlyKey.after(createAPIKey),
+	{name: "merchant_api_key_create_invalid", method: "POST", path: "/merchant/api-keys", body: `{"name":"reporting","scope":"everything"}`, headers: merchantKey, setup: []goldenCase{createAPIKey}},
+	{name: "merchant_read_only_forbidden", method: "POST", path: "/merchant/api-keys", body: `{}`, headers: map[string]string{consts.APIKeyHeader: "${merchant_api_key_create.key}"}, setup: []goldenCase{createAPIKey, createReadOnlyKey}},
+	{name: "merchant_api_key_roll", method: "POST", path: "/merchant/api-keys/${merchant_api_key_create.id}/roll", headers: merchantKey, setup: []goldenCase{createAPIKey, createReadOnlyKey}},
+	{name: "merchant_api_key_revoke", method: "DELETE", path: "/merchant/api-keys/${merchant_api_key_create.id}", headers: merchantKey, setup: []goldenCase{createAPIKey, createReadOnlyKey}},
+	{name: "merchant_api_key_revoke_not_found", method: "DELETE", path: "/merchant/api-keys/999", headers: merchantKey, setup: []goldenCase{createAPIKey}},
+
+	// Merchant API: transfers and wallets
+	{name: "transfer_unauthenticated", method: "POST", path: "/transfers", body: `{"from_user_id":1,"to_user_id":2,"amount":3,"currency":"USD"}`, anonymous: true},
+	createTransfer.after(createAPIKey, createDeposit, completeDeposit),
+	{name: "transfer_invalid", method: "POST", path: "/transfers", body: `{"from_user_id":1,"to_user_id":1,"amount":0,"currency":"USD"}`, headers: merchantKey, setup: []goldenCase{createAPIKey}},
+	{name: "transfer_get", method: "GET", path: "/transfers/${transfer.id}?user_id=1", headers: merchantKey, setup: []goldenCase{createAPIKey, createDeposit, completeDeposit, createTransfer}},
+	{name: "transfer_get_unauthenticated", method: "GET", path: "/transfers/${transfer.id}?user_id=1", anonymous: true, setup: []goldenCase{createAPIKey, createDeposit, completeDeposit, createTransfer}},
+	{name: "transfer_not_found", method: "GET", path: "/transfers/999?user_id=1", headers: merchantKey, setup: []goldenCase{createAPIKey}},
+	{name: "wallet_balance", method: "GET", path: "/wallets/1/balance?currency=USD", headers: merchantKey, setup: []goldenCase{createAPIKey, createDeposit, completeDeposit, createTransfer}},
+	{name: "wallet_balance_xml", method: "GET", path: "/wallets/1/balance?currency=USD", accept: "application/xml", headers: merchantKey, setup: []goldenCase{createAPIKey, createDeposit, completeDeposit, createTransfer}},
+	{name: "wallet_balance_unauthenticated", method: "GET", path: "/wallets/1/balance?currency=USD", anonymous: true},
+	{name: "wallet_balance_user_not_found", method: "GET", path: "/wallets/999/balance?currency=USD", headers: merchantKey, setup: []goldenCase{createAPIKey}},
+
+	// Merchant API: routing rules
+	replaceRoutingRules.after(createAPIKey),
+	{name: "merchant_routing_rules_replace_invalid", method: "PUT", path: "/merchant/routing-rules", body: `{"rules":[{"field":"colour","operator":"eq","value":"red","action":"prefer","gateway_id":2}]}`, headers: merchantKey, setup: []goldenCase{createAPIKey}},
+	{name: "merchant_routing_rules", method: "GET", path: "/merchant/routing-rules", headers: merchantKey, setup: []goldenCase{createAPIKey, replaceRoutingRules}},
+	{name: "merchant_routing_simulate", method: "POST", path: "/merchant/routing-rules/simulate", body: `{"type":"deposit","country_code":"US","amount":150,"currency":"USD"}`, headers: merchantKey, setup: []goldenCase{createAPIKey, replaceRoutingRules}},
+
+	// Merchant API: payout schedule
+	{name: "merchant_payout_schedule_save_invalid", method: "PUT", path: "/merchant/payout-schedule", body: `{"frequency":"hourly","payout_time":"5pm","cutoff_time":"15:00"}`, headers: merchantKey, setup: []goldenCase{createAPIKey}},
+	savePayoutSchedule.after(createAPIKey),
+	{name: "merchant_payout_schedule", method: "GET", path: "/merchant/payout-schedule", headers: merchantKey, setup: []goldenCase{createAPIKey, savePayoutSchedule}},
+	{name: "merchant_payout_schedule_delete", method: "DELETE", path: "/merchant/payout-schedule", headers: merchantKey, setup: []goldenCase{createAPIKey, savePayoutSchedule}},
+
+	// Merchant API: reports
+	generateReconciliation.after(createAPIKey),
+	{name: "merchant_reconciliation_files", method: "GET", path: "/merchant/reports/reconciliation", headers: merchantKey, setup: []goldenCase{createAPIKey, generateReconciliation}},
+	{name: "merchant_reconciliation_file", method: "GET", path: "/merchant/reports/reconciliation/${merchant_reconciliation_generate.id}", headers: merchantKey, setup: []goldenCase{createAPIKey, generateReconciliation}},
+	{name: "merchant_reconciliation_download", method: "GET", path: "/merchant/reports/reconciliation/${merchant_reconciliation_generate.id}/download", headers: merchantKey, setup: []goldenCase{createAPIKey, generateReconciliation}},
+	{name: "merchant_reconciliation_file_not_found", method: "GET", path: "/merchant/reports/reconciliation/unknown", headers: merchantKey, setup: []goldenCase{createAPIKey}},
+	{name: "merchant_decline_report", method: "GET", path: "/merchant/reports/declines", headers: merchantKey, setup: []goldenCase{createAPIKey, createDeposit, completeDeposit},
+		omit: []string{"from", "to", "start"}},
+
+	// Merchant API: transactions and tags
+	{name: "merchant_transactions", method: "GET", path: "/merchant/transactions?tags=campaign:launch", headers: merchantKey, setup: []goldenCase{createAPIKey, createDeposit, addTags}},
+	addMerchantTags.after(createAPIKey, createDeposit),
+	{name: "merchant_transaction_tags", method: "GET", path: "/merchant/transactions/${deposit.transaction_id}/tags", headers: merchantKey, setup: []goldenCase{createAPIKey, createDeposit, addMerchantTags}},
+	{name: "merchant_transaction_tag_remove", method: "DELETE", path: "/merchant/transactions/${deposit.transaction_id}/tags/order:1001", headers: merchantKey, setup: []goldenCase{createAPIKey, createDeposit, addMerchantTags}},
+	{name: "merchant_transaction_tags_not_found", method: "GET", path: "/merchant/transactions/999/tags", headers: merchantKey, setup: []goldenCase{createAPIKey}},
+
+	// Merchant API: webhooks
+	rollWebhookSecret.after(createAPIKey),
+	createWebhookEndpoint.after(createAPIKey),
+	{name: "merchant_webhook_endpoint_create_invalid", method: "POST", path: "/merchant/webhook-endpoints", body: `{"url":"","payload_version":"v9"}`, headers: merchantKey, setup: []goldenCase{createAPIKey}},
+	{name: "merchant_webhook_endpoints", method: "GET", path: "/merchant/webhook-endpoints", headers: merchantKey, setup: []goldenCase{createAPIKey, createWebhookEndpoint}},
+	{name: "merchant_webhook_endpoint_update", method: "PUT", path: "/merchant/webhook-endpoints/${merchant_webhook_endpoint_create.id}", body: `{"url":"https://example.com/hooks/v2","payload_version":"v1"}`, headers: merchantKey, setup: []goldenCase{createAPIKey, createWebhookEndpoint}},
+	{name: "merchant_webhook_endpoint_delete", method: "DELETE", path: "/merchant/webhook-endpoints/${merchant_webhook_endpoint_create.id}", headers: merchantKey, setup: []goldenCase{createAPIKey, createWebhookEndpoint}},
+	{name: "merchant_webhook_endpoint_delete_not_found", method: "DELETE", path: "/merchant/webhook-endpoints/999", headers: merchantKey, setup: []goldenCase{createAPIKey}},
+	{name: "webhook_verify", method: "POST", path: "/webhooks/verify", body: `{}`, headers: merchantKey, setup: []goldenCase{createAPIKey, rollWebhookSecret}},
+}
+
+// TestGoldenResponses replays goldenCases against the router and compares each response with its
+// golden file, so changes to the shape of responses are caught. Values that differ between runs,
+// such as timestamps, generated references and secrets, are replaced with placeholders first.
+func TestGoldenResponses(t *testing.T) {
+	covered := make(map[string]bool)
+	dir := filepath.Join("testdata", "golden")
+	for _, tc := range goldenCases {
+		t.Run(tc.name, func(t *testing.T) {
+			router := newGoldenRouter(t)
+			server := httptest.NewServer(router)
+			defer server.Close()
+
+			responses := make(map[string]map[string]interface{})
+			for _, step := range tc.setup {
+				_, body, err := step.send(server.Client(), server.URL, responses)
+				if err != nil {
+					t.Fatalf("setup %s: %v", step.name, err)
+				}
+				var decoded map[string]interface{}
+				if json.Unmarshal(body, &decoded) == nil {
+					responses[step.name] = decoded
+				}
+			}
+
+			req, err := tc.request(server.URL, responses)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var match mux.RouteMatch
+			if router.Match(req, &match) && match.Route != nil {
+				template, _ := match.Route.GetPathTemplate()
+				covered[req.Method+" "+template] = true
+			}
+
+			resp, body, err := tc.send(server.Client(), server.URL, responses)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			got := formatGolden(resp, body, tc.omit)
+			path := filepath.Join(dir, tc.name+".golden")
+			if *update {
+				if err := os.MkdirAll(dir, 0755); err != nil {
+					t.Fatal(err)
+				}
+				if err := os.WriteFile(path, got, 0644); err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+
+			want, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatalf("%v (run with -update to create it)", err)
+			}
+			if !bytes.Equal(got, want) {
+				t.Errorf("response differs from %s (run with -update if the change is intended)\ngot:\n%s\nwant:\n%s", path, got, want)
+			}
+		})
+	}
+
+	// Every route needs at least one case
+	router := newGoldenRouter(t)
+	router.Walk(func(route *mux.Route, _ *mux.Router, _ []*mux.Route) error {
+		template, err := route.GetPathTemplate()
+		if err != nil {
+			return nil
+		}
+		methods, err := route.GetMethods()
+		if err != nil {
+			return nil
+		}
+		for _, method := range methods {
+			if !covered[method+" "+template] {
+				t.Errorf("No golden case for %s %s", method, template)
+			}
+		}
+		return nil
+	})
+
+	// Golden files of removed cases are stale
+	if !*update {
+		files, _ := filepath.Glob(filepath.Join(dir, "*.golden"))
+		names := make(map[string]bool, len(goldenCases))
+		for _, tc := range goldenCases {
+			names[tc.name] = true
+		}
+		for _, file := range files {
+			if !names[strings.TrimSuffix(filepath.Base(file), ".golden")] {
+				t.Errorf("Golden file %s has no case", file)
+			}
+		}
+	}
+}
+
+// TestRouterScopes tests that the public and admin routers split the routes between them, both
+// serving the health checks, and that the admin router requires the admin token
+func TestRouterScopes(t *testing.T) {
+	handler := newGoldenHandler(t)
+	public := NewRouter(handler, ScopePublic, AdminMiddleware(goldenSecurity), Middleware(ScopePublic, &geo.IPLocator{}, goldenSecurity, utils.RequestLogConfig{})...)
+	admin := NewRouter(handler, ScopeAdmin, AdminMiddleware(goldenSecurity), Middleware(ScopeAdmin, &geo.IPLocator{}, goldenSecurity, utils.RequestLogConfig{})...)
+
+	routes := func(router *mux.Router) map[string]bool {
+		templates := map[string]bool{}
+		router.Walk(func(route *mux.Route, _ *mux.Router, _ []*mux.Route) error {
+			template, err := route.GetPathTemplate()
+			methods, _ := route.GetMethods()
+			if err == nil {
+				templates[strings.Join(methods, ",")+" "+template] = true
+			}
+			return nil
+		})
+		return templates
+	}
+	publicRoutes, adminRoutes := routes(public), routes(admin)
+	for route := range routes(NewRouter(handler, ScopeAll, nil)) {
+		_, path, _ := strings.Cut(route, " ")
+		isAdmin := strings.HasPrefix(path, consts.AdminRoutePrefix)
+		health := path == consts.HealthRoute || path == consts.ReadyRoute
+		if publicRoutes[route] != (health || !isAdmin) || adminRoutes[route] != (health || isAdmin) {
+			t.Errorf("%s: served by the public router %t, by the admin router %t", route, publicRoutes[route], adminRoutes[route])
+		}
+	}
+
+	tests := []struct {
+		router *mux.Router
+		name   string
+		path   string
+		token  string
+		want   int
+	}{
+		{public, "public", consts.HealthRoute, "", http.StatusOK},
+		{public, "public", consts.JWKSRoute, "", http.StatusOK},
+		{public, "public", consts.AdminMetricsRoute + "/http-server", goldenAdminToken, http.StatusNotFound},
+		{admin, "admin", consts.ReadyRoute, "", http.StatusOK},
+		{admin, "admin", consts.JWKSRoute, "", http.StatusNotFound},
+		{admin, "admin", consts.AdminMetricsRoute + "/http-server", "", http.StatusUnauthorized},
+		{admin, "admin", consts.AdminMetricsRoute + "/http-server", goldenAdminToken, http.StatusOK},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(http.MethodGet, tt.path, nil)
+		if tt.token != "" {
+			req.Header.Set("Authorization", "Bearer "+tt.token)
+		}
+		rec := httptest.NewRecorder()
+		tt.router.ServeHTTP(rec, req)
+		if rec.Code != tt.want {
+			t.Errorf("GET %s on the %s router: expected %d, got %d", tt.path, tt.name, tt.want, rec.Code)
+		}
+	}
+}
+
+// goldenSecurity is the security configuration of the routers under test
+var goldenSecurity = utils.SecurityConfig{AllowedOrigins: []string{"*"}, AdminToken: goldenAdminToken}
+
+// newGoldenRouter serves the whole API on the mock database with mock gateways that always accept
+// at once
+func newGoldenRouter(t *testing.T) *mux.Router {
+	return NewRouter(newGoldenHandler(t), ScopeAll, AdminMiddleware(goldenSecurity), Middleware(ScopeAll, &geo.IPLocator{}, goldenSecurity, utils.RequestLogConfig{})...)
+}
+
+// newGoldenHandler creates the handler of the routers under test
+func newGoldenHandler(t *testing.T) *Handler {
+	t.Setenv("MOCK_KAFKA", "true")
+
+	mockDB := db.NewMockDB()
+	selector := gateway.NewSelector(mockDB)
+	selector.RegisterProvider(gateway.NewMockProvider(1, "PayPal", "application/json", 1, 0))
+	selector.RegisterProvider(gateway.NewMockProvider(2, "Stripe", "application/json", 1, 0))
+	selector.RegisterProvider(gateway.NewMockProvider(3, "Adyen", "application/xml", 1, 0))
+	selector.RegisterProvider(gateway.NewMockOpenBankingProvider(5, "Open Banking", []models.Bank{
+		{ID: "sandbox-gb", Name: "Sandbox Bank UK", CountryCode: "GB", Currencies: []string{"GBP"}},
+	}))
+
+	transactions := services.NewTransactionService(mockDB, selector)
+	reconciliationStore, err := warehouse.NewDirStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	producer, err := kafka.NewProducer(kafka.DefaultConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	readiness := utils.NewReadiness(consts.DependencyDatabase, consts.DependencyKafka)
+	readiness.SetReady(consts.DependencyDatabase, true)
+	readiness.SetReady(consts.DependencyKafka, true)
+
+	oauth := services.NewOAuthService(mockDB, services.OAuthConfig{Issuer: "golden", TokenTTL: time.Hour, SigningKey: utils.DeriveKey("golden-oauth")})
+	screening := services.NewScreeningService(mockDB, nil)
+	users := services.NewUserService(mockDB)
+	users.SetScreening(screening)
+	transactions.SetScreening(screening)
+	anomalies := services.NewAnomalyDetector(selector)
+	transactions.SetGatewayObserver(anomalies)
+
+	return NewHandler(Services{
+		Transactions:       transactions,
+		Gateways:           selector,
+		Readiness:          readiness,
+		Retention:          services.NewRetentionService(mockDB, transactions.Operations(), services.DefaultRetentionPolicy()),
+		WebhookSecrets:     services.NewWebhookSecretService(mockDB),
+		ClientCertificates: services.NewClientCertificateService(mockDB),
+		SigningKeys:        services.NewSigningKeyService(mockDB),
+		APIKeys:            services.NewAPIKeyService(mockDB),
+		OAuth:              oauth,
+		Users:              users,
+		Screening:          screening,
+		Metrics:            services.NewRealtimeMetrics(transactions.Events()),
+		Anomalies:          anomalies,
+		Maintenance:        services.NewMaintenanceService(mockDB, selector),
+		RoutingRules:       services.NewRoutingRuleService(mockDB, selector),
+		MerchantWebhooks:   services.NewMerchantWebhookService(mockDB),
+		Payouts:            services.NewPayoutService(mockDB, transactions),
+		Reconciliation:     services.NewReconciliationService(mockDB, reconciliationStore, transactions),
+		Producer:           producer,
+	})
+}
+
+// send sends the case's request, resending it until the response contains tc.until for up to
+// a second
+func (tc goldenCase) send(client *http.Client, baseURL string, responses map[string]map[string]interface{}) (*http.Response, []byte, error) {
+	deadline := time.Now().Add(time.Second)
+	for {
+		req, err := tc.request(baseURL, responses)
+		if err != nil {
+			return nil, nil, err
+		}
+		resp, err := client.Do(req)
+		if err != nil {
+			return nil, nil, err
+		}
+		body, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			return nil, nil, err
+		}
+
+		if tc.until == "" || bytes.Contains(body, []byte(tc.until)) || time.Now().After(deadline) {
+			return resp, body, nil
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// placeholder matches ${case.field} references to earlier responses and ${in.duration} times
+var placeholder = regexp.MustCompile(`\$\{(\w+)\.(\w+)\}`)
+
+// request builds the case's request, filling in references to earlier responses
+func (tc goldenCase) request(baseURL string, responses map[string]map[string]interface{}) (*http.Request, error) {
+	var missing error
+	expand := func(s string) string {
+		return placeholder.ReplaceAllStringFunc(s, func(ref string) string {
+			parts := placeholder.FindStringSubmatch(ref)
+			if parts[1] == "in" {
+				d, err := time.ParseDuration(parts[2])
+				if err != nil {
+					missing = fmt.Errorf("%s: %w", ref, err)
+				}
+				return time.Now().Add(d).UTC().Format(time.RFC3339)
+			}
+			value, ok := responses[parts[1]][parts[2]]
+			if !ok {
+				missing = fmt.Errorf("%s is not in an earlier response", ref)
+				return ""
+			}
+			if f, ok := value.(float64); ok {
+				return fmt.Sprint(int64(f))
+			}
+			return fmt.Sprint(value)
+		})
+	}
+
+	path, body := expand(tc.path), expand(tc.body)
+	headers := make(map[string]string, len(tc.headers))
+	for name, value := range tc.headers {
+		headers[name] = expand(value)
+	}
+	if missing != nil {
+		return nil, missing
+	}
+
+	req, err := http.NewRequest(tc.method, baseURL+path, strings.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	if tc.contentType != "" {
+		req.Header.Set("Content-Type", tc.contentType)
+	} else {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if tc.accept != "" {
+		req.Header.Set("Accept", tc.accept)
+	} else {
+		req.Header.Set("Accept", "application/json")
+	}
+	if !tc.anonymous {
+		req.Header.Set("Authorization", "Bearer "+goldenAdminToken)
+	}
+	for name, value := range headers {
+		req.Header.Set(name, value)
+	}
+	return req, nil
+}
+
+// volatileFields hold values that differ between runs, by JSON name and by the Go field name XML
+// responses use
+var volatileFields = map[string]bool{
+	"access_token":             true,
+	"body":                     true, // callbacks as received, quoting gateway references
+	"client_id":                true,
+	"client_secret":            true,
+	"expected_settlement_date": true,
+	"gateway_reference":        true,
+	"hint":                     true,
+	"key":                      true,
+	"latency_ms":               true,
+	"prefix":                   true,
+	"redirect_url":             true,
+	"reference_id":             true,
+	"secret":                   true,
+	"Idempotency-Key":          true, // headers of signed webhook samples
+	"X-Webhook-Signature":      true,
+
+	"ClientID":               true,
+	"ExpectedSettlementDate": true,
+	"GatewayReference":       true,
+	"Hint":                   true,
+	"Key":                    true,
+	"Prefix":                 true,
+	"RedirectURL":            true,
+	"ReferenceID":            true,
+	"Secret":                 true,
+}
+
+// timestamp matches RFC 3339 timestamps
+var timestamp = regexp.MustCompile(`\d{4}-\d{2}-\d{2}T\d{2}:\d{2}:\d{2}(\.\d+)?(Z|[+-]\d{2}:\d{2})`)
+
+// formatGolden renders a response as its status, content type and normalized body, leaving the
+// omitted fields out of JSON bodies
+func formatGolden(resp *http.Response, body []byte, omit []string) []byte {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "%d %s\nContent-Type: %s\n\n", resp.StatusCode, http.StatusText(resp.StatusCode), resp.Header.Get("Content-Type"))
+
+	var decoded interface{}
+	decoder := json.NewDecoder(bytes.NewReader(body))
+	decoder.UseNumber()
+	if strings.HasPrefix(resp.Header.Get("Content-Type"), "application/json") && decoder.Decode(&decoded) == nil {
+		encoder := json.NewEncoder(&buf)
+		encoder.SetEscapeHTML(false)
+		encoder.SetIndent("", "  ")
+		encoder.Encode(normalizeJSON(omitFields(decoded, omit)))
+	} else if indented, err := indentXML(body); strings.Contains(resp.Header.Get("Content-Type"), "xml") && err == nil {
+		buf.Write(normalizeText(indented))
+	} else {
+		buf.Write(normalizeText(body))
+	}
+	if buf.Len() > 0 && buf.Bytes()[buf.Len()-1] != '\n' {
+		buf.WriteByte('\n')
+	}
+	return buf.Bytes()
+}
+
+// omitFields deletes the named fields from a decoded JSON document, at any depth
+func omitFields(v interface{}, omit []string) interface{} {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		for _, name := range omit {
+			delete(v, name)
+		}
+		for _, value := range v {
+			omitFields(value, omit)
+		}
+	case []interface{}:
+		for _, value := range v {
+			omitFields(value, omit)
+		}
+	}
+	return v
+}
+
+// normalizeJSON replaces volatile values in a decoded JSON document with placeholders
+func normalizeJSON(v interface{}) interface{} {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		for key, value := range v {
+			if volatileFields[key] {
+				if s, ok := value.(string); !ok || s != "" {
+					v[key] = "<" + key + ">"
+				}
+				continue
+			}
+			v[key] = normalizeJSON(value)
+		}
+	case []interface{}:
+		for i := range v {
+			v[i] = normalizeJSON(v[i])
+		}
+	case string:
+		return normalizeString(v)
+	}
+	return v
+}
+
+// indentXML re-encodes an XML document indented, one element per line, so golden file diffs point at
+// the elements that changed
+func indentXML(body []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	decoder := xml.NewDecoder(bytes.NewReader(body))
+	encoder := xml.NewEncoder(&buf)
+	encoder.Indent("", "  ")
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if err := encoder.EncodeToken(xml.CopyToken(token)); err != nil {
+			return nil, err
+		}
+	}
+	if err := encoder.Flush(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// xmlElement matches an XML element with text content
+var xmlElement = regexp.MustCompile(`<(\w+)>([^<]*)</(\w+)>`)
+
+// normalizeText replaces volatile values in XML, CSV and other text bodies with placeholders
+func normalizeText(body []byte) []byte {
+	body = xmlElement.ReplaceAllFunc(body, func(element []byte) []byte {
+		parts := xmlElement.FindSubmatch(element)
+		if name := string(parts[1]); volatileFields[name] && len(parts[2]) > 0 {
+			return []byte(fmt.Sprintf("<%s>&lt;%s&gt;</%s>", name, name, name))
+		}
+		return element
+	})
+	return []byte(normalizeString(string(body)))
+}
+
+// operationID matches generated long-running operation IDs
+var operationID = regexp.MustCompile(`op_[0-9a-f]{32}`)
+
+// normalizeString replaces timestamps, other than the zero time unset timestamps marshal to, and
+// generated IDs with placeholders
+func normalizeString(s string) string {
+	s = timestamp.ReplaceAllStringFunc(s, func(ts string) string {
+		if strings.HasPrefix(ts, "0001-01-01T00:00:00") {
+			return ts
+		}
+		return "<timestamp>"
+	})
+	return operationID.ReplaceAllString(s, "<operation_id>")
+}
@@ -1,12 +1,22 @@
 package api
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"net/http"
+	"payment-gateway/internal/consts"
 	"payment-gateway/internal/gateway"
+	"payment-gateway/internal/geo"
+	"payment-gateway/internal/kafka"
 	"payment-gateway/internal/models"
 	"payment-gateway/internal/services"
 	"payment-gateway/internal/utils"
+	"payment-gateway/internal/validation"
+	"strconv"
+	"strings"
 
 	"github.com/gorilla/mux"
 )
@@ -15,13 +25,71 @@ import (
 type Handler struct {
 	transactionService *services.TransactionService
 	gatewaySelector    gateway.SelectorInterface
+	readiness          *utils.Readiness
+	retentionService   *services.RetentionService
+	webhookSecrets     *services.WebhookSecretService
+	clientCertificates *services.ClientCertificateService
+	signingKeys        *services.SigningKeyService
+	apiKeys            *services.APIKeyService
+	oauth              *services.OAuthService
+	users              *services.UserService
+	screening          *services.ScreeningService
+	metrics            *services.RealtimeMetrics
+	anomalies          *services.AnomalyDetector
+	maintenance        *services.MaintenanceService
+	routingRules       *services.RoutingRuleService
+	merchantWebhooks   *services.MerchantWebhookService
+	payouts            *services.PayoutService
+	reconciliation     *services.ReconciliationService
+	producer           *kafka.Producer
+}
+
+// Services holds the services the API handlers depend on. Named fields keep services of the same
+// type from being passed in the wrong place.
+type Services struct {
+	Transactions       *services.TransactionService
+	Gateways           gateway.SelectorInterface
+	Readiness          *utils.Readiness
+	Retention          *services.RetentionService
+	WebhookSecrets     *services.WebhookSecretService
+	ClientCertificates *services.ClientCertificateService
+	SigningKeys        *services.SigningKeyService
+	APIKeys            *services.APIKeyService
+	OAuth              *services.OAuthService
+	Users              *services.UserService
+	Screening          *services.ScreeningService
+	Metrics            *services.RealtimeMetrics
+	Anomalies          *services.AnomalyDetector
+	Maintenance        *services.MaintenanceService
+	RoutingRules       *services.RoutingRuleService
+	MerchantWebhooks   *services.MerchantWebhookService
+	Payouts            *services.PayoutService
+	Reconciliation     *services.ReconciliationService
+	Producer           *kafka.Producer
 }
 
 // NewHandler creates a new handler instance
-func NewHandler(transactionService *services.TransactionService, gatewaySelector gateway.SelectorInterface) *Handler {
+func NewHandler(deps Services) *Handler {
 	return &Handler{
-		transactionService: transactionService,
-		gatewaySelector:    gatewaySelector,
+		transactionService: deps.Transactions,
+		gatewaySelector:    deps.Gateways,
+		readiness:          deps.Readiness,
+		retentionService:   deps.Retention,
+		webhookSecrets:     deps.WebhookSecrets,
+		clientCertificates: deps.ClientCertificates,
+		signingKeys:        deps.SigningKeys,
+		apiKeys:            deps.APIKeys,
+		oauth:              deps.OAuth,
+		users:              deps.Users,
+		screening:          deps.Screening,
+		metrics:            deps.Metrics,
+		anomalies:          deps.Anomalies,
+		maintenance:        deps.Maintenance,
+		routingRules:       deps.RoutingRules,
+		merchantWebhooks:   deps.MerchantWebhooks,
+		payouts:            deps.Payouts,
+		reconciliation:     deps.Reconciliation,
+		producer:           deps.Producer,
 	}
 }
 
@@ -32,8 +100,10 @@ func NewHandler(transactionService *services.TransactionService, gatewaySelector
 // @Accept json,xml
 // @Produce json,xml
 // @Param transaction body models.TransactionRequest true "Deposit request"
+// @Param Idempotency-Key header string false "Unique key of the payment; repeated requests get the original response"
 // @Success 200 {object} models.TransactionResponse
 // @Failure 400 {object} models.APIResponse
+// @Failure 409 {object} models.APIResponse
 // @Failure 500 {object} models.APIResponse
 // @Router /deposit [post]
 func (h *Handler) DepositHandler(w http.ResponseWriter, r *http.Request) {
@@ -41,18 +111,12 @@ func (h *Handler) DepositHandler(w http.ResponseWriter, r *http.Request) {
 
 	// Parse request based on content type
 	if err := utils.DecodeRequest(r, &request); err != nil {
-		utils.SendErrorResponse(w, r, http.StatusBadRequest, fmt.Sprintf("Invalid request: %v", err))
+		utils.SendDecodeError(w, r, err)
 		return
 	}
 
-	// Basic validation
-	if request.Amount <= 0 {
-		utils.SendErrorResponse(w, r, http.StatusBadRequest, "Amount must be greater than zero")
-		return
-	}
-
-	if request.UserID <= 0 {
-		utils.SendErrorResponse(w, r, http.StatusBadRequest, "Invalid user ID")
+	if err := services.ValidateTransactionRequest(request); err != nil {
+		utils.SendValidationError(w, r, err)
 		return
 	}
 
@@ -60,8 +124,13 @@ func (h *Handler) DepositHandler(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
 	response, err := h.transactionService.ProcessDeposit(ctx, request)
 
+	var fieldErrs validation.Errors
+	if errors.As(err, &fieldErrs) {
+		utils.SendValidationError(w, r, err)
+		return
+	}
 	if err != nil {
-		utils.SendErrorResponse(w, r, http.StatusInternalServerError, fmt.Sprintf("Failed to process deposit: %v", err))
+		utils.SendErrorResponse(w, r, errorStatus(err), fmt.Sprintf("Failed to process deposit: %v", err))
 		return
 	}
 
@@ -72,12 +141,15 @@ func (h *Handler) DepositHandler(w http.ResponseWriter, r *http.Request) {
 // WithdrawalHandler handles withdrawal requests
 // @Summary Process a withdrawal transaction
 // @Description Process a withdrawal by selecting an appropriate payment gateway based on user's country
+// @Description Withdrawals the user's wallet balance doesn't cover are rejected with 409.
 // @Tags transactions
 // @Accept json,xml
 // @Produce json,xml
 // @Param transaction body models.TransactionRequest true "Withdrawal request"
+// @Param Idempotency-Key header string false "Unique key of the payment; repeated requests get the original response"
 // @Success 200 {object} models.TransactionResponse
 // @Failure 400 {object} models.APIResponse
+// @Failure 409 {object} models.APIResponse
 // @Failure 500 {object} models.APIResponse
 // @Router /withdrawal [post]
 func (h *Handler) WithdrawalHandler(w http.ResponseWriter, r *http.Request) {
@@ -85,18 +157,12 @@ func (h *Handler) WithdrawalHandler(w http.ResponseWriter, r *http.Request) {
 
 	// Parse request based on content type
 	if err := utils.DecodeRequest(r, &request); err != nil {
-		utils.SendErrorResponse(w, r, http.StatusBadRequest, fmt.Sprintf("Invalid request: %v", err))
-		return
-	}
-
-	// Basic validation
-	if request.Amount <= 0 {
-		utils.SendErrorResponse(w, r, http.StatusBadRequest, "Amount must be greater than zero")
+		utils.SendDecodeError(w, r, err)
 		return
 	}
 
-	if request.UserID <= 0 {
-		utils.SendErrorResponse(w, r, http.StatusBadRequest, "Invalid user ID")
+	if err := services.ValidateTransactionRequest(request); err != nil {
+		utils.SendValidationError(w, r, err)
 		return
 	}
 
@@ -104,8 +170,13 @@ func (h *Handler) WithdrawalHandler(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
 	response, err := h.transactionService.ProcessWithdrawal(ctx, request)
 
+	var fieldErrs validation.Errors
+	if errors.As(err, &fieldErrs) {
+		utils.SendValidationError(w, r, err)
+		return
+	}
 	if err != nil {
-		utils.SendErrorResponse(w, r, http.StatusInternalServerError, fmt.Sprintf("Failed to process withdrawal: %v", err))
+		utils.SendErrorResponse(w, r, errorStatus(err), fmt.Sprintf("Failed to process withdrawal: %v", err))
 		return
 	}
 
@@ -113,41 +184,406 @@ func (h *Handler) WithdrawalHandler(w http.ResponseWriter, r *http.Request) {
 	utils.SendResponse(w, r, http.StatusOK, response)
 }
 
+// BatchDepositHandler handles batch deposit requests
+// @Summary Process a batch of deposit transactions
+// @Description Validate and process multiple deposits concurrently, returning per-item results
+// @Tags transactions
+// @Accept json,xml
+// @Produce json,xml
+// @Param batch body models.BatchDepositRequest true "Batch deposit request"
+// @Success 200 {object} models.Batch
+// @Failure 400 {object} models.APIResponse
+// @Failure 500 {object} models.APIResponse
+// @Router /deposits/batch [post]
+func (h *Handler) BatchDepositHandler(w http.ResponseWriter, r *http.Request) {
+	var request models.BatchDepositRequest
+
+	// Parse request based on content type
+	if err := utils.DecodeRequest(r, &request); err != nil {
+		utils.SendDecodeError(w, r, err)
+		return
+	}
+
+	// Items are validated individually so valid ones are still processed
+	if err := validation.Struct(request); err != nil {
+		utils.SendValidationError(w, r, err)
+		return
+	}
+
+	if len(request.Transactions) > consts.MaxBatchSize {
+		utils.SendErrorResponse(w, r, http.StatusBadRequest, fmt.Sprintf("Batch exceeds maximum size of %d transactions", consts.MaxBatchSize))
+		return
+	}
+
+	// Process batch
+	ctx := r.Context()
+	batch, err := h.transactionService.ProcessDepositBatch(ctx, request.Transactions)
+
+	if err != nil {
+		utils.SendErrorResponse(w, r, http.StatusInternalServerError, fmt.Sprintf("Failed to process batch: %v", err))
+		return
+	}
+
+	// Send response
+	utils.SendResponse(w, r, http.StatusOK, batch)
+}
+
+// BulkWithdrawalHandler handles bulk withdrawal CSV uploads
+// @Summary Upload a CSV of withdrawals
+// @Description Accept a CSV file (user_id, amount, currency, beneficiary) that is parsed, validated and processed asynchronously
+// @Tags transactions
+// @Accept multipart/form-data,text/csv
+// @Produce json,xml
+// @Param file formData file true "Withdrawal CSV file"
+// @Success 202 {object} models.Batch
+// @Failure 400 {object} models.APIResponse
+// @Failure 500 {object} models.APIResponse
+// @Router /withdrawals/batch [post]
+func (h *Handler) BulkWithdrawalHandler(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, consts.MaxCSVUploadSize)
+
+	var reader io.Reader = r.Body
+
+	// Accept either a multipart form upload or a raw CSV body
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "multipart/form-data") {
+		file, _, err := r.FormFile("file")
+		if err != nil {
+			utils.SendErrorResponse(w, r, http.StatusBadRequest, fmt.Sprintf("Invalid upload: %v", err))
+			return
+		}
+		defer file.Close()
+		reader = file
+	}
+
+	data, err := io.ReadAll(reader)
+	if err != nil {
+		utils.SendErrorResponse(w, r, http.StatusBadRequest, fmt.Sprintf("Failed to read upload: %v", err))
+		return
+	}
+
+	batch, err := h.transactionService.SubmitWithdrawalCSV(data)
+	if err != nil {
+		utils.SendErrorResponse(w, r, http.StatusBadRequest, fmt.Sprintf("Failed to submit withdrawals: %v", err))
+		return
+	}
+
+	utils.SendResponse(w, r, http.StatusAccepted, batch)
+}
+
+// GetBatchHandler returns the current status of a batch
+// @Summary Get batch status
+// @Description Poll the status of a previously submitted batch and its transactions
+// @Tags transactions
+// @Produce json,xml
+// @Param batch_id path int true "Batch ID"
+// @Param If-None-Match header string false "ETag of the representation the client holds"
+// @Success 200 {object} models.Batch
+// @Header 200 {string} ETag "Entity tag of the representation"
+// @Success 304 "The representation the client holds is current"
+// @Failure 400 {object} models.APIResponse
+// @Failure 404 {object} models.APIResponse
+// @Router /deposits/batch/{batch_id} [get]
+// @Router /withdrawals/batch/{batch_id} [get]
+func (h *Handler) GetBatchHandler(w http.ResponseWriter, r *http.Request) {
+	batchID, err := strconv.Atoi(mux.Vars(r)["batch_id"])
+	if err != nil || batchID <= 0 {
+		utils.SendErrorResponse(w, r, http.StatusBadRequest, "Invalid batch ID")
+		return
+	}
+
+	batch, err := h.transactionService.GetBatch(r.Context(), batchID)
+	if err != nil {
+		utils.SendErrorResponse(w, r, http.StatusNotFound, fmt.Sprintf("Batch not found: %v", err))
+		return
+	}
+
+	utils.SendCacheableResponse(w, r, batch)
+}
+
+// GetOperationHandler returns the progress and result of a long-running operation
+// @Summary Get operation status
+// @Description Poll the progress and result of an asynchronous operation such as a batch upload
+// @Tags operations
+// @Produce json,xml
+// @Param operation_id path string true "Operation ID"
+// @Param If-None-Match header string false "ETag of the representation the client holds"
+// @Success 200 {object} models.Operation
+// @Header 200 {string} ETag "Entity tag of the representation"
+// @Success 304 "The representation the client holds is current"
+// @Failure 404 {object} models.APIResponse
+// @Router /operations/{operation_id} [get]
+func (h *Handler) GetOperationHandler(w http.ResponseWriter, r *http.Request) {
+	operationID := mux.Vars(r)["operation_id"]
+
+	op, err := h.transactionService.Operations().Get(r.Context(), operationID)
+	if err != nil {
+		utils.SendErrorResponse(w, r, http.StatusNotFound, fmt.Sprintf("Operation not found: %s", operationID))
+		return
+	}
+
+	utils.SendCacheableResponse(w, r, op)
+}
+
+// GetTransactionHandler returns the status of a user's transaction
+// @Summary Get transaction status
+// @Description Return the status of a user's transaction. A transaction still pending or processing at a gateway
+// @Description that reports statuses is refreshed from the gateway first; the gateway's answer is reused for a few
+// @Description seconds, so polling more often doesn't reach the gateway more often.
+// @Tags transactions
+// @Produce json,xml
+// @Param transaction_id path int true "Transaction ID"
+// @Param user_id query int true "User who made the transaction"
+// @Param If-None-Match header string false "ETag of the representation the client holds"
+// @Success 200 {object} models.TransactionResponse
+// @Header 200 {string} ETag "Entity tag of the representation"
+// @Success 304 "The representation the client holds is current"
+// @Failure 400 {object} models.APIResponse
+// @Failure 404 {object} models.APIResponse
+// @Failure 500 {object} models.APIResponse
+// @Router /transactions/{transaction_id} [get]
+func (h *Handler) GetTransactionHandler(w http.ResponseWriter, r *http.Request) {
+	txID, ok := transactionID(w, r)
+	if !ok {
+		return
+	}
+
+	userID, err := strconv.Atoi(r.URL.Query().Get("user_id"))
+	if err != nil || userID <= 0 {
+		utils.SendErrorResponse(w, r, http.StatusBadRequest, "Invalid user ID")
+		return
+	}
+
+	tx, err := h.transactionService.GetTransaction(r.Context(), txID, userID)
+	if err != nil {
+		if errors.Is(err, services.ErrTransactionNotFound) {
+			utils.SendErrorResponse(w, r, http.StatusNotFound, fmt.Sprintf("Transaction not found: %d", txID))
+			return
+		}
+		utils.SendErrorResponse(w, r, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	// Customers yet to pay can still be sent to the gateway's payment page
+	response := h.transactionResponse(tx)
+	if tx.Status == consts.Pending || tx.Status == consts.Processing {
+		response.RedirectURL = tx.RedirectURL
+	}
+	utils.SendCacheableResponse(w, r, response)
+}
+
+// transactionResponse describes a stored transaction like the response to its submission
+func (h *Handler) transactionResponse(tx *models.Transaction) models.TransactionResponse {
+	return models.TransactionResponse{
+		Status:           tx.Status,
+		TransactionID:    tx.ID,
+		ReferenceID:      tx.ReferenceID,
+		Message:          tx.ErrorMessage,
+		GatewayReference: tx.GatewayReference,
+		DeclineCode:      tx.DeclineCode,
+		RecoveryHint:     h.transactionService.RecoveryHints().Hint(tx.DeclineCode),
+	}
+}
+
+// SubmitDisputeHandler lets a user flag one of their transactions as unrecognized
+// @Summary Dispute a transaction
+// @Description Flag a completed or processing transaction as unrecognized. The dispute is queued for review before any formal chargeback; poll its status with GET.
+// @Tags transactions
+// @Accept json,xml
+// @Produce json,xml
+// @Param transaction_id path int true "Transaction ID"
+// @Param dispute body models.DisputeRequest true "Dispute"
+// @Success 201 {object} models.Dispute
+// @Failure 400 {object} models.APIResponse
+// @Failure 404 {object} models.APIResponse
+// @Failure 409 {object} models.APIResponse
+// @Failure 500 {object} models.APIResponse
+// @Router /transactions/{transaction_id}/dispute [post]
+func (h *Handler) SubmitDisputeHandler(w http.ResponseWriter, r *http.Request) {
+	txID, ok := transactionID(w, r)
+	if !ok {
+		return
+	}
+
+	var request models.DisputeRequest
+	if err := utils.DecodeRequest(r, &request); err != nil {
+		utils.SendDecodeError(w, r, err)
+		return
+	}
+	if err := validation.Struct(request); err != nil {
+		utils.SendValidationError(w, r, err)
+		return
+	}
+
+	dispute, err := h.transactionService.SubmitDispute(r.Context(), txID, request)
+	if err != nil {
+		h.sendDisputeError(w, r, txID, err)
+		return
+	}
+
+	utils.SendResponse(w, r, http.StatusCreated, dispute)
+}
+
+// GetDisputeHandler returns the status of a user's dispute of a transaction
+// @Summary Get dispute status
+// @Description Return the review status of the dispute a user opened for their transaction
+// @Tags transactions
+// @Produce json,xml
+// @Param transaction_id path int true "Transaction ID"
+// @Param user_id query int true "User who opened the dispute"
+// @Param If-None-Match header string false "ETag of the representation the client holds"
+// @Success 200 {object} models.Dispute
+// @Header 200 {string} ETag "Entity tag of the representation"
+// @Success 304 "The representation the client holds is current"
+// @Failure 400 {object} models.APIResponse
+// @Failure 404 {object} models.APIResponse
+// @Failure 500 {object} models.APIResponse
+// @Router /transactions/{transaction_id}/dispute [get]
+func (h *Handler) GetDisputeHandler(w http.ResponseWriter, r *http.Request) {
+	txID, ok := transactionID(w, r)
+	if !ok {
+		return
+	}
+
+	userID, err := strconv.Atoi(r.URL.Query().Get("user_id"))
+	if err != nil || userID <= 0 {
+		utils.SendErrorResponse(w, r, http.StatusBadRequest, "Invalid user ID")
+		return
+	}
+
+	dispute, err := h.transactionService.GetDispute(r.Context(), txID, userID)
+	if err != nil {
+		h.sendDisputeError(w, r, txID, err)
+		return
+	}
+
+	utils.SendCacheableResponse(w, r, dispute)
+}
+
+// sendDisputeError responds to a failed dispute request with the status matching the error
+func (h *Handler) sendDisputeError(w http.ResponseWriter, r *http.Request, txID int, err error) {
+	switch {
+	case errors.Is(err, services.ErrTransactionNotFound):
+		utils.SendErrorResponse(w, r, http.StatusNotFound, fmt.Sprintf("Transaction not found: %d", txID))
+	case errors.Is(err, services.ErrDisputeNotFound):
+		utils.SendErrorResponse(w, r, http.StatusNotFound, fmt.Sprintf("Transaction %d is not disputed", txID))
+	case errors.Is(err, services.ErrDisputeExists), errors.Is(err, services.ErrTransactionNotFinal):
+		utils.SendErrorResponse(w, r, http.StatusConflict, err.Error())
+	default:
+		utils.SendErrorResponse(w, r, http.StatusInternalServerError, err.Error())
+	}
+}
+
+// transactionID parses the transaction ID path parameter, responding with 400 when it is invalid
+func transactionID(w http.ResponseWriter, r *http.Request) (int, bool) {
+	txID, err := strconv.Atoi(mux.Vars(r)["transaction_id"])
+	if err != nil || txID <= 0 {
+		utils.SendErrorResponse(w, r, http.StatusBadRequest, "Invalid transaction ID")
+		return 0, false
+	}
+	return txID, true
+}
+
+// ListPaymentMethodsHandler returns the payment options for a checkout
+// @Summary List payment methods
+// @Description List the payment options customers have for a deposit in a country and currency: cards and wallets
+// @Description per gateway and banks per open banking gateway. Only gateways that support the country and currency
+// @Description and could take the deposit now, i.e. healthy and not in maintenance, are listed, in routing priority order.
+// @Tags transactions
+// @Produce json,xml
+// @Param country query string true "ISO 3166-1 alpha-2 country code"
+// @Param currency query string false "ISO 4217 currency code; the country's currency when omitted"
+// @Success 200 {object} models.PaymentMethods
+// @Failure 400 {object} models.APIResponse
+// @Failure 500 {object} models.APIResponse
+// @Router /payment-methods [get]
+func (h *Handler) ListPaymentMethodsHandler(w http.ResponseWriter, r *http.Request) {
+	country := r.URL.Query().Get("country")
+	if !geo.IsValidCountryCode(country) {
+		utils.SendErrorResponse(w, r, http.StatusBadRequest, "Invalid country code")
+		return
+	}
+	currency := strings.ToUpper(r.URL.Query().Get("currency"))
+	if currency != "" && !validation.IsCurrency(currency) {
+		utils.SendErrorResponse(w, r, http.StatusBadRequest, "Invalid currency code")
+		return
+	}
+
+	methods, err := h.transactionService.ListPaymentMethods(r.Context(), geo.NormalizeCountryCode(country), currency)
+	if err != nil {
+		utils.SendErrorResponse(w, r, errorStatus(err), err.Error())
+		return
+	}
+
+	utils.SendResponse(w, r, http.StatusOK, methods)
+}
+
 // CallbackHandler handles callbacks from payment gateways
 // @Summary Process a callback from a payment gateway
-// @Description Receive and process callbacks from payment gateways to update transaction status
+// @Description Receive and process callbacks from payment gateways to update transaction status.
+// @Description Callbacks must be signed with one of the gateway's active webhook secrets once any is configured.
+// @Description Every callback is stored as received, with sensitive headers masked, before it is parsed.
 // @Tags callbacks
 // @Accept json,xml
 // @Produce json
 // @Param gateway_id path string true "Gateway ID"
+// @Param X-Webhook-Signature header string false "HMAC-SHA256 of the body as sha256=<hex>"
 // @Param callback body models.CallbackData true "Callback data"
 // @Success 200 {object} map[string]string
 // @Failure 400 {object} models.APIResponse
+// @Failure 401 {object} models.APIResponse
+// @Failure 409 {object} models.APIResponse
 // @Failure 500 {object} models.APIResponse
 // @Router /callback/{gateway_id} [post]
 func (h *Handler) CallbackHandler(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	gatewayID := vars["gateway_id"]
 
-	// Get the provider by ID
-	provider, err := h.gatewaySelector.GetProviderByID(gatewayID)
-	if err != nil {
+	// Make sure the gateway exists
+	if _, err := h.gatewaySelector.GetProviderByID(gatewayID); err != nil {
 		utils.SendErrorResponse(w, r, http.StatusBadRequest, fmt.Sprintf("Invalid gateway: %v", err))
 		return
 	}
 
-	// Parse callback data
-	callbackData, err := provider.ParseCallback(r)
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, consts.MaxCallbackBodySize))
 	if err != nil {
-		utils.SendErrorResponse(w, r, http.StatusBadRequest, fmt.Sprintf("Failed to parse callback: %v", err))
+		utils.SendErrorResponse(w, r, http.StatusBadRequest, fmt.Sprintf("Failed to read callback: %v", err))
 		return
 	}
 
-	// Process callback
+	// Store the callback as received so failures can be investigated and reparsed
 	ctx := r.Context()
-	err = h.transactionService.HandleCallback(ctx, callbackData)
-
+	gatewayNum, _ := strconv.Atoi(gatewayID)
+	record, err := h.transactionService.RecordCallback(ctx, gatewayNum, r.Header, body)
 	if err != nil {
+		utils.SendErrorResponse(w, r, http.StatusInternalServerError, fmt.Sprintf("Failed to store callback: %v", err))
+		return
+	}
+
+	// Verify the signature before trusting the payload
+	if err := h.webhookSecrets.Verify(ctx, gatewayNum, body, r.Header.Get(consts.WebhookSignatureHeader)); err != nil {
+		if errors.Is(err, services.ErrInvalidWebhookSignature) {
+			h.transactionService.RejectCallback(ctx, record, err)
+			utils.SendErrorResponse(w, r, http.StatusUnauthorized, err.Error())
+			return
+		}
+		utils.SendErrorResponse(w, r, http.StatusInternalServerError, fmt.Sprintf("Failed to verify callback: %v", err))
+		return
+	}
+
+	// Parse and process the callback
+	if err := h.transactionService.ProcessCallback(ctx, record); err != nil {
+		if errors.Is(err, services.ErrInvalidWebhookSignature) {
+			utils.SendErrorResponse(w, r, http.StatusUnauthorized, err.Error())
+			return
+		}
+		if errors.Is(err, services.ErrInvalidCallback) {
+			utils.SendErrorResponse(w, r, http.StatusBadRequest, fmt.Sprintf("Failed to parse callback: %v", err))
+			return
+		}
+		if errors.Is(err, services.ErrIllegalStatusTransition) {
+			utils.SendErrorResponse(w, r, http.StatusConflict, err.Error())
+			return
+		}
 		utils.SendErrorResponse(w, r, http.StatusInternalServerError, fmt.Sprintf("Failed to process callback: %v", err))
 		return
 	}
@@ -158,22 +594,130 @@ func (h *Handler) CallbackHandler(w http.ResponseWriter, r *http.Request) {
 
 // HealthCheckHandler handles health check requests
 // @Summary API health check
-// @Description Check the health of the API and its dependencies
+// @Description Check the health of the API and its dependencies in parallel, each within a timeout, reporting each dependency's status and latency and warning of gateway maintenance windows in progress or starting within 24 hours
 // @Tags system
 // @Produce json
-// @Success 200 {object} map[string]string
-// @Failure 500 {object} models.APIResponse
+// @Success 200 {object} map[string]interface{}
+// @Failure 503 {object} map[string]interface{}
 // @Router /health [get]
 func (h *Handler) HealthCheckHandler(w http.ResponseWriter, r *http.Request) {
-	// Check database connection
-	if err := h.transactionService.Ping(); err != nil {
-		utils.SendErrorResponse(w, r, http.StatusInternalServerError, "Database connection failed")
-		return
+	checks := []utils.HealthCheck{
+		{
+			Name:     consts.DependencyDatabase,
+			Timeout:  consts.HealthCheckTimeout,
+			Critical: true,
+			Check: func(context.Context) error {
+				return h.transactionService.Ping()
+			},
+		},
+		{
+			// Undelivered events wait in the outbox, so the service only degrades without Kafka
+			Name:    consts.DependencyKafka,
+			Timeout: consts.HealthCheckTimeout,
+			Check: func(ctx context.Context) error {
+				if kafka.Mocked() {
+					return nil
+				}
+				return h.producer.Ping(ctx)
+			},
+		},
+	}
+
+	results := utils.RunHealthChecks(r.Context(), checks)
+	status := utils.HealthStatus(checks, results)
+
+	statusCode := http.StatusOK
+	if status == "unhealthy" {
+		statusCode = http.StatusServiceUnavailable
 	}
 
-	// All checks passed
-	utils.SendResponse(w, r, http.StatusOK, map[string]string{
-		"status":  "healthy",
-		"version": "1.0.0",
+	utils.SendResponse(w, r, statusCode, map[string]interface{}{
+		"status":       status,
+		"version":      consts.APIVersion,
+		"dependencies": results,
+		"maintenance":  h.maintenance.Upcoming(),
 	})
 }
+
+// ReadinessHandler reports whether the service's dependencies are confirmed available
+// @Summary API readiness check
+// @Description Report readiness of each dependency; returns 503 until all are confirmed during startup
+// @Tags system
+// @Produce json
+// @Success 200 {object} map[string]interface{}
+// @Failure 503 {object} map[string]interface{}
+// @Router /ready [get]
+func (h *Handler) ReadinessHandler(w http.ResponseWriter, r *http.Request) {
+	statusCode := http.StatusOK
+	status := "ready"
+	if !h.readiness.IsReady() {
+		statusCode = http.StatusServiceUnavailable
+		status = "not_ready"
+	}
+
+	utils.SendResponse(w, r, statusCode, map[string]interface{}{
+		"status":       status,
+		"dependencies": h.readiness.Status(),
+	})
+}
+
+// AsyncAPIHandler serves the AsyncAPI specification for the Kafka events the service emits
+// @Summary AsyncAPI specification
+// @Description Describes the Kafka topics, event types, headers and payload schemas published by the service
+// @Tags system
+// @Produce json
+// @Success 200 {object} map[string]interface{}
+// @Failure 500 {object} models.APIResponse
+// @Router /docs/asyncapi.json [get]
+func (h *Handler) AsyncAPIHandler(w http.ResponseWriter, r *http.Request) {
+	spec, err := kafka.AsyncAPIJSON(strings.Join(h.producer.Brokers(), ","), consts.APIVersion)
+	if err != nil {
+		utils.SendErrorResponse(w, r, http.StatusInternalServerError, "Failed to generate AsyncAPI specification")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(spec)
+}
+
+// JWKSHandler publishes the public keys of the gateways' active signing keys
+// @Summary Signing keys
+// @Description JSON Web Key Set of the keys requests to gateways are signed with, for gateways to verify JWTs and JWS request signatures by their kid
+// @Tags system
+// @Produce json
+// @Success 200 {object} auth.JSONWebKeySet
+// @Router /.well-known/jwks.json [get]
+func (h *Handler) JWKSHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "public, max-age=300")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(h.signingKeys.KeySet())
+}
+
+// errorStatus maps service errors caused by invalid client input to 400, livemode transactions
+// outside production and sanctions screening holds to 403, unavailable screening to 503, and
+// everything else to 500
+func errorStatus(err error) int {
+	if errors.Is(err, services.ErrLivemodeUnavailable) || errors.Is(err, services.ErrScreeningHold) ||
+		errors.Is(err, services.ErrSanctionsMatch) {
+		return http.StatusForbidden
+	}
+	if errors.Is(err, services.ErrScreeningUnavailable) {
+		return http.StatusServiceUnavailable
+	}
+	if errors.Is(err, services.ErrInvalidRedirectURL) || errors.Is(err, gateway.ErrInvalidGatewayOverride) ||
+		errors.Is(err, services.ErrUnsupportedCountry) || errors.Is(err, geo.ErrInvalidPhoneNumber) ||
+		errors.Is(err, services.ErrInvalidSCAExemption) || errors.Is(err, services.ErrInvalidBankPayment) ||
+		errors.Is(err, services.ErrBankNotFound) || errors.Is(err, services.ErrInvalidBankPayout) ||
+		errors.Is(err, services.ErrBankAccountNotFound) || errors.Is(err, services.ErrInvalidMandate) ||
+		errors.Is(err, services.ErrMandateNotFound) || errors.Is(err, services.ErrInvalidUPIPayment) ||
+		errors.Is(err, services.ErrUPIGatewayNotFound) || errors.Is(err, services.ErrInvalidWalletToken) ||
+		errors.Is(err, services.ErrInvalidAuthorization) || errors.Is(err, services.ErrAuthorizationUnsupported) {
+		return http.StatusBadRequest
+	}
+	if errors.Is(err, services.ErrInsufficientBalance) {
+		return http.StatusConflict
+	}
+	return http.StatusInternalServerError
+}
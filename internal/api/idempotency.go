@@ -0,0 +1,95 @@
+package api
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"payment-gateway/internal/auth"
+	"payment-gateway/internal/consts"
+	"payment-gateway/internal/services"
+	"payment-gateway/internal/utils"
+	"strconv"
+)
+
+// idempotent replays the original response to requests repeating the Idempotency-Key header of an
+// earlier request from the same caller, instead of running them again. Requests without the header
+// run as usual.
+func (h *Handler) idempotent(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key := r.Header.Get(consts.IdempotencyKeyHeader)
+		if key == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, consts.MaxRequestBodySize))
+		if err != nil {
+			utils.SendDecodeError(w, r, err)
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+
+		claim, err := h.transactionService.BeginIdempotentRequest(r.Context(), idempotencyCaller(r, body), r.URL.Path, key, body)
+		if err != nil {
+			switch {
+			case errors.Is(err, services.ErrInvalidIdempotencyKey):
+				utils.SendErrorResponse(w, r, http.StatusBadRequest, err.Error())
+			case errors.Is(err, services.ErrIdempotencyKeyInUse):
+				utils.SendErrorResponse(w, r, http.StatusConflict, err.Error())
+			case errors.Is(err, services.ErrIdempotencyKeyReused):
+				utils.SendErrorResponse(w, r, http.StatusUnprocessableEntity, err.Error())
+			default:
+				utils.SendErrorResponse(w, r, http.StatusInternalServerError, err.Error())
+			}
+			return
+		}
+
+		if !claim.CompletedAt.IsZero() {
+			w.Header().Set("Content-Type", claim.ContentType)
+			w.Header().Set(consts.IdempotentReplayedHeader, strconv.FormatBool(true))
+			w.WriteHeader(claim.StatusCode)
+			w.Write(claim.ResponseBody)
+			return
+		}
+
+		recorder := &idempotentResponse{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(recorder, r)
+
+		if err := h.transactionService.CompleteIdempotentRequest(r.Context(), claim, recorder.status, w.Header().Get("Content-Type"), recorder.body.Bytes()); err != nil {
+			log.Printf("Failed to record the response to idempotency key %q: %v", key, err)
+		}
+	})
+}
+
+// idempotencyCaller names whom a request's idempotency key belongs to: the authenticated merchant,
+// or for requests sent without credentials, such as deposits and withdrawals, the request body
+// itself, so only a request repeating the body byte for byte can replay its response
+func idempotencyCaller(r *http.Request, body []byte) string {
+	if caller, ok := auth.FromContext(r.Context()); ok {
+		return fmt.Sprintf("merchant:%d", caller.MerchantID)
+	}
+	hash := sha256.Sum256(body)
+	return "body:" + hex.EncodeToString(hash[:])
+}
+
+// idempotentResponse records the status and body written to a response
+type idempotentResponse struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (r *idempotentResponse) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *idempotentResponse) Write(data []byte) (int, error) {
+	r.body.Write(data)
+	return r.ResponseWriter.Write(data)
+}
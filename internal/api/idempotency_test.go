@@ -0,0 +1,45 @@
+package api
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"payment-gateway/internal/consts"
+	"strings"
+	"testing"
+)
+
+// countingReader counts the bytes read from it
+type countingReader struct {
+	io.Reader
+	read int
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.Reader.Read(p)
+	c.read += n
+	return n, err
+}
+
+// TestIdempotentBodyLimit tests that a keyed request over the body size limit is refused with 413
+// before its handler runs, without the body being read past the limit
+func TestIdempotentBodyLimit(t *testing.T) {
+	handler := newGoldenHandler(t)
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Error("Expected the handler not to run")
+	})
+
+	body := &countingReader{Reader: strings.NewReader(strings.Repeat("x", 4*consts.MaxRequestBodySize))}
+	req := httptest.NewRequest(http.MethodPost, consts.DepositRoute, body)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(consts.IdempotencyKeyHeader, "too-large")
+	rec := httptest.NewRecorder()
+	handler.idempotent(next).ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("Expected 413, got %d: %s", rec.Code, rec.Body)
+	}
+	if body.read > 2*consts.MaxRequestBodySize {
+		t.Errorf("Expected reading to stop at the limit, read %d bytes", body.read)
+	}
+}
@@ -0,0 +1,139 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"payment-gateway/internal/auth"
+	"payment-gateway/internal/models"
+	"payment-gateway/internal/services"
+	"payment-gateway/internal/utils"
+	"payment-gateway/internal/validation"
+	"strconv"
+
+	"github.com/gorilla/mux"
+)
+
+// CreateMandateHandler records a SEPA Direct Debit mandate one of the calling merchant's users signed
+// @Summary Record a mandate
+// @Description Record a SEPA Direct Debit mandate one of the calling merchant's users signed, authorising a direct debit gateway to debit their account.
+// @Description Deposits naming it as mandate_id are collected from the account by direct debit, and withdrawals naming it are paid
+// @Description out to the account by SEPA credit transfer. The debtor must be the user, and only the last four digits of the IBAN are returned.
+// @Tags sepa
+// @Accept json,xml
+// @Produce json,xml
+// @Security BearerAuth
+// @Param mandate body models.MandateRequest true "Mandate"
+// @Success 201 {object} models.Mandate
+// @Failure 400 {object} models.APIResponse
+// @Failure 401 {object} models.APIResponse
+// @Failure 403 {object} models.APIResponse
+// @Failure 404 {object} models.APIResponse
+// @Failure 500 {object} models.APIResponse
+// @Router /mandates [post]
+func (h *Handler) CreateMandateHandler(w http.ResponseWriter, r *http.Request) {
+	caller, _ := auth.FromContext(r.Context())
+
+	var request models.MandateRequest
+	if err := utils.DecodeRequest(r, &request); err != nil {
+		utils.SendDecodeError(w, r, err)
+		return
+	}
+	if err := validation.Struct(request); err != nil {
+		utils.SendValidationError(w, r, err)
+		return
+	}
+
+	mandate, err := h.transactionService.CreateMandate(r.Context(), caller.MerchantID, request)
+	if err != nil {
+		switch {
+		case errors.Is(err, services.ErrUserNotFound):
+			utils.SendErrorResponse(w, r, http.StatusNotFound, fmt.Sprintf("User not found: %d", request.UserID))
+		case errors.Is(err, services.ErrDirectDebitGatewayNotFound):
+			utils.SendErrorResponse(w, r, http.StatusNotFound, "No gateway takes direct debit mandates")
+		case errors.Is(err, services.ErrInvalidMandate), errors.Is(err, services.ErrMandateDebtorMismatch):
+			utils.SendErrorResponse(w, r, http.StatusBadRequest, err.Error())
+		default:
+			utils.SendErrorResponse(w, r, errorStatus(err), err.Error())
+		}
+		return
+	}
+
+	utils.SendResponse(w, r, http.StatusCreated, mandate)
+}
+
+// ListMandatesHandler returns the SEPA mandates one of the calling merchant's users signed
+// @Summary List mandates
+// @Description List the SEPA Direct Debit mandates one of the calling merchant's users signed, active or revoked. Only the last four digits of IBANs are returned.
+// @Tags sepa
+// @Produce json,xml
+// @Security BearerAuth
+// @Param user_id query int true "User who signed the mandates"
+// @Success 200 {array} models.Mandate
+// @Failure 400 {object} models.APIResponse
+// @Failure 401 {object} models.APIResponse
+// @Failure 404 {object} models.APIResponse
+// @Failure 500 {object} models.APIResponse
+// @Router /mandates [get]
+func (h *Handler) ListMandatesHandler(w http.ResponseWriter, r *http.Request) {
+	caller, _ := auth.FromContext(r.Context())
+
+	userID, err := strconv.Atoi(r.URL.Query().Get("user_id"))
+	if err != nil || userID <= 0 {
+		utils.SendErrorResponse(w, r, http.StatusBadRequest, "Invalid user ID")
+		return
+	}
+
+	mandates, err := h.transactionService.ListMandates(r.Context(), caller.MerchantID, userID)
+	if err != nil {
+		if errors.Is(err, services.ErrUserNotFound) {
+			utils.SendErrorResponse(w, r, http.StatusNotFound, fmt.Sprintf("User not found: %d", userID))
+			return
+		}
+		utils.SendErrorResponse(w, r, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	utils.SendResponse(w, r, http.StatusOK, mandates)
+}
+
+// RevokeMandateHandler stops debiting under a SEPA mandate
+// @Summary Revoke a mandate
+// @Description Revoke a SEPA Direct Debit mandate of one of the calling merchant's users. Deposits can no longer be debited under it nor withdrawals paid out to its account, including scheduled ones.
+// @Tags sepa
+// @Produce json,xml
+// @Security BearerAuth
+// @Param mandate_id path int true "Mandate ID"
+// @Param user_id query int true "User who signed the mandate"
+// @Success 200 {object} map[string]string
+// @Failure 400 {object} models.APIResponse
+// @Failure 401 {object} models.APIResponse
+// @Failure 403 {object} models.APIResponse
+// @Failure 404 {object} models.APIResponse
+// @Failure 500 {object} models.APIResponse
+// @Router /mandates/{mandate_id} [delete]
+func (h *Handler) RevokeMandateHandler(w http.ResponseWriter, r *http.Request) {
+	caller, _ := auth.FromContext(r.Context())
+
+	mandateID, err := strconv.Atoi(mux.Vars(r)["mandate_id"])
+	if err != nil || mandateID <= 0 {
+		utils.SendErrorResponse(w, r, http.StatusBadRequest, "Invalid mandate ID")
+		return
+	}
+	userID, err := strconv.Atoi(r.URL.Query().Get("user_id"))
+	if err != nil || userID <= 0 {
+		utils.SendErrorResponse(w, r, http.StatusBadRequest, "Invalid user ID")
+		return
+	}
+
+	if err := h.transactionService.RevokeMandate(r.Context(), caller.MerchantID, mandateID, userID); err != nil {
+		if errors.Is(err, services.ErrMandateNotFound) {
+			utils.SendErrorResponse(w, r, http.StatusNotFound, fmt.Sprintf("Mandate not found: %d", mandateID))
+			return
+		}
+		utils.SendErrorResponse(w, r, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	utils.SendResponse(w, r, http.StatusOK, map[string]string{"status": "revoked"})
+}
@@ -0,0 +1,971 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"payment-gateway/internal/auth"
+	"payment-gateway/internal/consts"
+	"payment-gateway/internal/models"
+	"payment-gateway/internal/services"
+	"payment-gateway/internal/utils"
+	"payment-gateway/internal/validation"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/mux"
+)
+
+// ListAPIKeysHandler lists the calling merchant's API keys
+// @Summary List API keys
+// @Description List the merchant's API keys with their scope, status and last use; secrets are never returned
+// @Tags merchant
+// @Produce json,xml
+// @Security BearerAuth
+// @Success 200 {array} models.APIKey
+// @Failure 401 {object} models.APIResponse
+// @Failure 500 {object} models.APIResponse
+// @Router /merchant/api-keys [get]
+func (h *Handler) ListAPIKeysHandler(w http.ResponseWriter, r *http.Request) {
+	caller, _ := auth.FromContext(r.Context())
+
+	keys, err := h.apiKeys.List(r.Context(), caller.MerchantID)
+	if err != nil {
+		utils.SendErrorResponse(w, r, http.StatusInternalServerError, fmt.Sprintf("Failed to list API keys: %v", err))
+		return
+	}
+
+	utils.SendResponse(w, r, http.StatusOK, keys)
+}
+
+// CreateAPIKeyHandler issues a new API key for the calling merchant
+// @Summary Create an API key
+// @Description Issue a read_only or full API key. The key is returned only in this response.
+// @Tags merchant
+// @Accept json,xml
+// @Produce json,xml
+// @Security BearerAuth
+// @Param key body models.APIKeyRequest true "API key to create"
+// @Success 201 {object} models.APIKey
+// @Failure 400 {object} models.APIResponse
+// @Failure 401 {object} models.APIResponse
+// @Failure 403 {object} models.APIResponse
+// @Failure 500 {object} models.APIResponse
+// @Router /merchant/api-keys [post]
+func (h *Handler) CreateAPIKeyHandler(w http.ResponseWriter, r *http.Request) {
+	caller, _ := auth.FromContext(r.Context())
+	h.createAPIKey(w, r, caller.MerchantID)
+}
+
+// RollAPIKeyHandler replaces one of the calling merchant's API keys
+// @Summary Roll an API key
+// @Description Issue a replacement with the same name and scope. The old key keeps working for 24 hours so clients can switch over.
+// @Tags merchant
+// @Produce json,xml
+// @Security BearerAuth
+// @Param key_id path int true "API key ID"
+// @Success 201 {object} models.APIKey
+// @Failure 400 {object} models.APIResponse
+// @Failure 401 {object} models.APIResponse
+// @Failure 403 {object} models.APIResponse
+// @Failure 404 {object} models.APIResponse
+// @Failure 500 {object} models.APIResponse
+// @Router /merchant/api-keys/{key_id}/roll [post]
+func (h *Handler) RollAPIKeyHandler(w http.ResponseWriter, r *http.Request) {
+	caller, _ := auth.FromContext(r.Context())
+	keyID, ok := apiKeyID(w, r)
+	if !ok {
+		return
+	}
+
+	key, err := h.apiKeys.Roll(r.Context(), caller.MerchantID, keyID)
+	if err != nil {
+		if errors.Is(err, services.ErrAPIKeyNotFound) {
+			utils.SendErrorResponse(w, r, http.StatusNotFound, fmt.Sprintf("API key not found: %d", keyID))
+			return
+		}
+		utils.SendErrorResponse(w, r, http.StatusInternalServerError, fmt.Sprintf("Failed to roll API key: %v", err))
+		return
+	}
+
+	utils.SendResponse(w, r, http.StatusCreated, key)
+}
+
+// RevokeAPIKeyHandler revokes one of the calling merchant's API keys
+// @Summary Revoke an API key
+// @Description Stop accepting an API key immediately, including the key used for this request
+// @Tags merchant
+// @Produce json,xml
+// @Security BearerAuth
+// @Param key_id path int true "API key ID"
+// @Success 200 {object} map[string]string
+// @Failure 400 {object} models.APIResponse
+// @Failure 401 {object} models.APIResponse
+// @Failure 403 {object} models.APIResponse
+// @Failure 404 {object} models.APIResponse
+// @Failure 500 {object} models.APIResponse
+// @Router /merchant/api-keys/{key_id} [delete]
+func (h *Handler) RevokeAPIKeyHandler(w http.ResponseWriter, r *http.Request) {
+	caller, _ := auth.FromContext(r.Context())
+	keyID, ok := apiKeyID(w, r)
+	if !ok {
+		return
+	}
+
+	if err := h.apiKeys.Revoke(r.Context(), caller.MerchantID, keyID); err != nil {
+		if errors.Is(err, services.ErrAPIKeyNotFound) {
+			utils.SendErrorResponse(w, r, http.StatusNotFound, fmt.Sprintf("API key not found: %d", keyID))
+			return
+		}
+		utils.SendErrorResponse(w, r, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	utils.SendResponse(w, r, http.StatusOK, map[string]string{"status": consts.APIKeyRevoked})
+}
+
+// ListRoutingRulesHandler lists the calling merchant's routing rules
+// @Summary List routing rules
+// @Description List the merchant's routing rules in evaluation order
+// @Tags merchant
+// @Produce json,xml
+// @Security BearerAuth
+// @Success 200 {array} models.RoutingRule
+// @Failure 401 {object} models.APIResponse
+// @Failure 500 {object} models.APIResponse
+// @Router /merchant/routing-rules [get]
+func (h *Handler) ListRoutingRulesHandler(w http.ResponseWriter, r *http.Request) {
+	caller, _ := auth.FromContext(r.Context())
+
+	rules, err := h.routingRules.List(r.Context(), caller.MerchantID)
+	if err != nil {
+		utils.SendErrorResponse(w, r, http.StatusInternalServerError, fmt.Sprintf("Failed to list routing rules: %v", err))
+		return
+	}
+
+	utils.SendResponse(w, r, http.StatusOK, rules)
+}
+
+// ReplaceRoutingRulesHandler replaces the calling merchant's routing rules
+// @Summary Replace routing rules
+// @Description Save rules that prefer or exclude gateways for the merchant's transactions, evaluated in the order given after platform constraints. An empty list removes all rules.
+// @Tags merchant
+// @Accept json,xml
+// @Produce json,xml
+// @Security BearerAuth
+// @Param rules body models.RoutingRulesRequest true "Routing rules"
+// @Success 200 {array} models.RoutingRule
+// @Failure 400 {object} models.APIResponse
+// @Failure 401 {object} models.APIResponse
+// @Failure 403 {object} models.APIResponse
+// @Failure 404 {object} models.APIResponse
+// @Failure 500 {object} models.APIResponse
+// @Router /merchant/routing-rules [put]
+func (h *Handler) ReplaceRoutingRulesHandler(w http.ResponseWriter, r *http.Request) {
+	caller, _ := auth.FromContext(r.Context())
+
+	var request models.RoutingRulesRequest
+	if err := utils.DecodeRequest(r, &request); err != nil {
+		utils.SendDecodeError(w, r, err)
+		return
+	}
+	if err := validation.Struct(request); err != nil {
+		utils.SendValidationError(w, r, err)
+		return
+	}
+
+	rules, err := h.routingRules.Replace(r.Context(), caller.MerchantID, request.Rules)
+	if err != nil {
+		switch {
+		case errors.Is(err, services.ErrInvalidRoutingRule):
+			utils.SendErrorResponse(w, r, http.StatusBadRequest, err.Error())
+		case errors.Is(err, services.ErrMerchantNotFound):
+			utils.SendErrorResponse(w, r, http.StatusNotFound, fmt.Sprintf("Merchant not found: %d", caller.MerchantID))
+		default:
+			utils.SendErrorResponse(w, r, http.StatusInternalServerError, fmt.Sprintf("Failed to save routing rules: %v", err))
+		}
+		return
+	}
+
+	utils.SendResponse(w, r, http.StatusOK, rules)
+}
+
+// SimulateRoutingHandler shows how a transaction would be routed for the calling merchant
+// @Summary Simulate routing
+// @Description Report which rules match a transaction, the gateway it would be routed to right now and the checks that led there, without creating it. Draft rules in the request are used instead of the saved ones; merchant_id is ignored.
+// @Tags merchant
+// @Accept json,xml
+// @Produce json,xml
+// @Security BearerAuth
+// @Param transaction body models.RoutingSimulationRequest true "Transaction to simulate"
+// @Success 200 {object} models.RoutingSimulation
+// @Failure 400 {object} models.APIResponse
+// @Failure 401 {object} models.APIResponse
+// @Failure 403 {object} models.APIResponse
+// @Failure 500 {object} models.APIResponse
+// @Router /merchant/routing-rules/simulate [post]
+func (h *Handler) SimulateRoutingHandler(w http.ResponseWriter, r *http.Request) {
+	caller, _ := auth.FromContext(r.Context())
+
+	var request models.RoutingSimulationRequest
+	if err := utils.DecodeRequest(r, &request); err != nil {
+		utils.SendDecodeError(w, r, err)
+		return
+	}
+	if err := validation.Struct(request); err != nil {
+		utils.SendValidationError(w, r, err)
+		return
+	}
+
+	request.MerchantID = caller.MerchantID
+	simulation, err := h.routingRules.Simulate(r.Context(), request)
+	if err != nil {
+		if errors.Is(err, services.ErrInvalidRoutingRule) || errors.Is(err, services.ErrUnsupportedCountry) {
+			utils.SendErrorResponse(w, r, http.StatusBadRequest, err.Error())
+			return
+		}
+		utils.SendErrorResponse(w, r, http.StatusInternalServerError, fmt.Sprintf("Failed to simulate routing: %v", err))
+		return
+	}
+
+	utils.SendResponse(w, r, http.StatusOK, simulation)
+}
+
+// GetPayoutScheduleHandler returns the calling merchant's payout schedule
+// @Summary Get the payout schedule
+// @Description Return the schedule the merchant's withdrawals are paid out on
+// @Tags merchant
+// @Produce json,xml
+// @Security BearerAuth
+// @Success 200 {object} models.PayoutSchedule
+// @Failure 401 {object} models.APIResponse
+// @Failure 404 {object} models.APIResponse
+// @Failure 500 {object} models.APIResponse
+// @Router /merchant/payout-schedule [get]
+func (h *Handler) GetPayoutScheduleHandler(w http.ResponseWriter, r *http.Request) {
+	caller, _ := auth.FromContext(r.Context())
+
+	schedule, err := h.payouts.Get(r.Context(), caller.MerchantID)
+	if err != nil {
+		if errors.Is(err, services.ErrPayoutScheduleNotFound) {
+			utils.SendErrorResponse(w, r, http.StatusNotFound, "Withdrawals are paid out immediately; no payout schedule is set")
+			return
+		}
+		utils.SendErrorResponse(w, r, http.StatusInternalServerError, fmt.Sprintf("Failed to get payout schedule: %v", err))
+		return
+	}
+
+	utils.SendResponse(w, r, http.StatusOK, schedule)
+}
+
+// SavePayoutScheduleHandler sets the calling merchant's payout schedule
+// @Summary Set the payout schedule
+// @Description Pay out the merchant's withdrawals daily or weekly at payout_time instead of immediately. Withdrawals requested after cutoff_time wait for the following payout. Times are HH:MM local to each withdrawal's country.
+// @Tags merchant
+// @Accept json,xml
+// @Produce json,xml
+// @Security BearerAuth
+// @Param schedule body models.PayoutScheduleRequest true "Payout schedule"
+// @Success 200 {object} models.PayoutSchedule
+// @Failure 400 {object} models.APIResponse
+// @Failure 401 {object} models.APIResponse
+// @Failure 403 {object} models.APIResponse
+// @Failure 404 {object} models.APIResponse
+// @Failure 500 {object} models.APIResponse
+// @Router /merchant/payout-schedule [put]
+func (h *Handler) SavePayoutScheduleHandler(w http.ResponseWriter, r *http.Request) {
+	caller, _ := auth.FromContext(r.Context())
+
+	var request models.PayoutScheduleRequest
+	if err := utils.DecodeRequest(r, &request); err != nil {
+		utils.SendDecodeError(w, r, err)
+		return
+	}
+	if err := validation.Struct(request); err != nil {
+		utils.SendValidationError(w, r, err)
+		return
+	}
+
+	schedule, err := h.payouts.Save(r.Context(), caller.MerchantID, request)
+	if err != nil {
+		switch {
+		case errors.Is(err, services.ErrInvalidPayoutSchedule):
+			utils.SendErrorResponse(w, r, http.StatusBadRequest, err.Error())
+		case errors.Is(err, services.ErrMerchantNotFound):
+			utils.SendErrorResponse(w, r, http.StatusNotFound, fmt.Sprintf("Merchant not found: %d", caller.MerchantID))
+		default:
+			utils.SendErrorResponse(w, r, http.StatusInternalServerError, fmt.Sprintf("Failed to save payout schedule: %v", err))
+		}
+		return
+	}
+
+	utils.SendResponse(w, r, http.StatusOK, schedule)
+}
+
+// DeletePayoutScheduleHandler removes the calling merchant's payout schedule
+// @Summary Remove the payout schedule
+// @Description Pay out the merchant's withdrawals immediately again. Withdrawals already scheduled are still paid out at their payout time.
+// @Tags merchant
+// @Produce json,xml
+// @Security BearerAuth
+// @Success 200 {object} models.APIResponse
+// @Failure 401 {object} models.APIResponse
+// @Failure 403 {object} models.APIResponse
+// @Failure 404 {object} models.APIResponse
+// @Failure 500 {object} models.APIResponse
+// @Router /merchant/payout-schedule [delete]
+func (h *Handler) DeletePayoutScheduleHandler(w http.ResponseWriter, r *http.Request) {
+	caller, _ := auth.FromContext(r.Context())
+
+	if err := h.payouts.Delete(r.Context(), caller.MerchantID); err != nil {
+		if errors.Is(err, services.ErrPayoutScheduleNotFound) {
+			utils.SendErrorResponse(w, r, http.StatusNotFound, "No payout schedule is set")
+			return
+		}
+		utils.SendErrorResponse(w, r, http.StatusInternalServerError, fmt.Sprintf("Failed to remove payout schedule: %v", err))
+		return
+	}
+
+	utils.SendResponse(w, r, http.StatusOK, map[string]string{"status": "deleted"})
+}
+
+// DeclineReportHandler reports the calling merchant's declines over time
+// @Summary Get the decline report
+// @Description Break the merchant's declines down by day, week or month and by gateway, country and card BIN range (first six digits), with counts per normalized decline code. Attempts are completed and failed transactions. Dates are UTC and inclusive; the last 30 days are reported by default.
+// @Tags merchant
+// @Produce json,xml
+// @Security BearerAuth
+// @Param from query string false "First day, YYYY-MM-DD"
+// @Param to query string false "Last day, YYYY-MM-DD"
+// @Param interval query string false "day, week or month" default(day)
+// @Param tag query string false "Only transactions carrying the tag, e.g. campaign:blackfriday"
+// @Success 200 {object} models.DeclineReport
+// @Failure 400 {object} models.APIResponse
+// @Failure 401 {object} models.APIResponse
+// @Failure 500 {object} models.APIResponse
+// @Router /merchant/reports/declines [get]
+func (h *Handler) DeclineReportHandler(w http.ResponseWriter, r *http.Request) {
+	caller, _ := auth.FromContext(r.Context())
+
+	query := models.DeclineReportQuery{
+		From:     r.URL.Query().Get("from"),
+		To:       r.URL.Query().Get("to"),
+		Interval: r.URL.Query().Get("interval"),
+		Tag:      r.URL.Query().Get("tag"),
+	}
+
+	report, err := h.transactionService.DeclineReport(r.Context(), caller.MerchantID, query)
+	if err != nil {
+		if errors.Is(err, services.ErrInvalidDeclineReport) {
+			utils.SendErrorResponse(w, r, http.StatusBadRequest, err.Error())
+			return
+		}
+		utils.SendErrorResponse(w, r, http.StatusInternalServerError, fmt.Sprintf("Failed to build decline report: %v", err))
+		return
+	}
+
+	utils.SendResponse(w, r, http.StatusOK, report)
+}
+
+// ListReconciliationFilesHandler lists the calling merchant's reconciliation files
+// @Summary List reconciliation files
+// @Description List the merchant's daily reconciliation files, newest first. Dates are UTC and inclusive; the last 30 days are listed by default and at most 366 at once.
+// @Tags merchant
+// @Produce json,xml
+// @Security BearerAuth
+// @Param from query string false "First day, YYYY-MM-DD"
+// @Param to query string false "Last day, YYYY-MM-DD"
+// @Success 200 {array} models.ReconciliationFile
+// @Failure 400 {object} models.APIResponse
+// @Failure 401 {object} models.APIResponse
+// @Failure 500 {object} models.APIResponse
+// @Router /merchant/reports/reconciliation [get]
+func (h *Handler) ListReconciliationFilesHandler(w http.ResponseWriter, r *http.Request) {
+	caller, _ := auth.FromContext(r.Context())
+
+	query := models.ReconciliationQuery{
+		From: r.URL.Query().Get("from"),
+		To:   r.URL.Query().Get("to"),
+	}
+
+	files, err := h.reconciliation.List(r.Context(), caller.MerchantID, query)
+	if err != nil {
+		if errors.Is(err, services.ErrInvalidReconciliation) {
+			utils.SendErrorResponse(w, r, http.StatusBadRequest, err.Error())
+			return
+		}
+		utils.SendErrorResponse(w, r, http.StatusInternalServerError, fmt.Sprintf("Failed to list reconciliation files: %v", err))
+		return
+	}
+
+	utils.SendResponse(w, r, http.StatusOK, files)
+}
+
+// GenerateReconciliationFileHandler generates the calling merchant's reconciliation file for a day
+// @Summary Generate a reconciliation file
+// @Description Generate the merchant's reconciliation file for a UTC day that has ended, for example to pick up statuses that changed since it was generated. The file keeps its ID, its version is bumped and a reconciliation.file_ready webhook is sent. Fees are taken from the merchant's current fee schedule.
+// @Tags merchant
+// @Accept json,xml
+// @Produce json,xml
+// @Security BearerAuth
+// @Param request body models.ReconciliationRequest true "Day to reconcile"
+// @Success 201 {object} models.ReconciliationFile
+// @Failure 400 {object} models.APIResponse
+// @Failure 401 {object} models.APIResponse
+// @Failure 403 {object} models.APIResponse
+// @Failure 404 {object} models.APIResponse
+// @Failure 500 {object} models.APIResponse
+// @Router /merchant/reports/reconciliation [post]
+func (h *Handler) GenerateReconciliationFileHandler(w http.ResponseWriter, r *http.Request) {
+	caller, _ := auth.FromContext(r.Context())
+
+	var request models.ReconciliationRequest
+	if err := utils.DecodeRequest(r, &request); err != nil {
+		utils.SendDecodeError(w, r, err)
+		return
+	}
+	if err := validation.Struct(request); err != nil {
+		utils.SendValidationError(w, r, err)
+		return
+	}
+
+	file, err := h.reconciliation.Generate(r.Context(), caller.MerchantID, request.Date)
+	if err != nil {
+		switch {
+		case errors.Is(err, services.ErrInvalidReconciliation):
+			utils.SendErrorResponse(w, r, http.StatusBadRequest, err.Error())
+		case errors.Is(err, services.ErrMerchantNotFound):
+			utils.SendErrorResponse(w, r, http.StatusNotFound, fmt.Sprintf("Merchant not found: %d", caller.MerchantID))
+		default:
+			utils.SendErrorResponse(w, r, http.StatusInternalServerError, fmt.Sprintf("Failed to generate reconciliation file: %v", err))
+		}
+		return
+	}
+
+	utils.SendResponse(w, r, http.StatusCreated, file)
+}
+
+// GetReconciliationFileHandler returns one of the calling merchant's reconciliation files
+// @Summary Get a reconciliation file
+// @Description Get a reconciliation file's version, row count and SHA-256 checksum, with the link its CSV content is downloaded from
+// @Tags merchant
+// @Produce json,xml
+// @Security BearerAuth
+// @Param file_id path string true "Reconciliation file ID"
+// @Success 200 {object} models.ReconciliationFile
+// @Failure 401 {object} models.APIResponse
+// @Failure 404 {object} models.APIResponse
+// @Failure 500 {object} models.APIResponse
+// @Router /merchant/reports/reconciliation/{file_id} [get]
+func (h *Handler) GetReconciliationFileHandler(w http.ResponseWriter, r *http.Request) {
+	caller, _ := auth.FromContext(r.Context())
+
+	file, err := h.reconciliation.Get(r.Context(), caller.MerchantID, mux.Vars(r)["file_id"])
+	if err != nil {
+		if errors.Is(err, services.ErrReconciliationFileNotFound) {
+			utils.SendErrorResponse(w, r, http.StatusNotFound, err.Error())
+			return
+		}
+		utils.SendErrorResponse(w, r, http.StatusInternalServerError, fmt.Sprintf("Failed to get reconciliation file: %v", err))
+		return
+	}
+
+	utils.SendResponse(w, r, http.StatusOK, file)
+}
+
+// DownloadReconciliationFileHandler downloads one of the calling merchant's reconciliation files
+// @Summary Download a reconciliation file
+// @Description Download a reconciliation file as CSV with a header line: transaction_id, reference_id, gateway_reference, type, status, amount, currency, fee, net_amount, gateway_id, decline_code, livemode, created_at and updated_at. Fees and net amounts are set for completed transactions only; withdrawals' net amounts are negative.
+// @Tags merchant
+// @Produce text/csv
+// @Security BearerAuth
+// @Param file_id path string true "Reconciliation file ID"
+// @Success 200 {file} file
+// @Header 200 {string} ETag "SHA-256 checksum of the content"
+// @Failure 401 {object} models.APIResponse
+// @Failure 404 {object} models.APIResponse
+// @Failure 500 {object} models.APIResponse
+// @Router /merchant/reports/reconciliation/{file_id}/download [get]
+func (h *Handler) DownloadReconciliationFileHandler(w http.ResponseWriter, r *http.Request) {
+	caller, _ := auth.FromContext(r.Context())
+
+	file, data, err := h.reconciliation.Content(r.Context(), caller.MerchantID, mux.Vars(r)["file_id"])
+	if err != nil {
+		if errors.Is(err, services.ErrReconciliationFileNotFound) {
+			utils.SendErrorResponse(w, r, http.StatusNotFound, err.Error())
+			return
+		}
+		utils.SendErrorResponse(w, r, http.StatusInternalServerError, fmt.Sprintf("Failed to download reconciliation file: %v", err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/csv")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", file.ID+".csv"))
+	w.Header().Set("ETag", strconv.Quote(file.Checksum))
+	w.WriteHeader(http.StatusOK)
+	w.Write(data)
+}
+
+// RollWebhookSecretHandler creates a new webhook signing secret for the calling merchant
+// @Summary Roll the webhook signing secret
+// @Description Generate a secret to sign the merchant's webhooks with, replacing any previous one. Deliveries carry X-Webhook-Signature from the next attempt. The secret is returned only in this response.
+// @Tags merchant
+// @Produce json,xml
+// @Security BearerAuth
+// @Success 201 {object} models.MerchantWebhookSecret
+// @Failure 401 {object} models.APIResponse
+// @Failure 403 {object} models.APIResponse
+// @Failure 404 {object} models.APIResponse
+// @Failure 500 {object} models.APIResponse
+// @Router /merchant/webhook-secret [post]
+func (h *Handler) RollWebhookSecretHandler(w http.ResponseWriter, r *http.Request) {
+	caller, _ := auth.FromContext(r.Context())
+
+	secret, err := h.merchantWebhooks.RollSecret(r.Context(), caller.MerchantID)
+	if err != nil {
+		if errors.Is(err, services.ErrMerchantNotFound) {
+			utils.SendErrorResponse(w, r, http.StatusNotFound, fmt.Sprintf("Merchant not found: %d", caller.MerchantID))
+			return
+		}
+		utils.SendErrorResponse(w, r, http.StatusInternalServerError, fmt.Sprintf("Failed to roll webhook secret: %v", err))
+		return
+	}
+
+	utils.SendResponse(w, r, http.StatusCreated, secret)
+}
+
+// ListWebhookEndpointsHandler lists the calling merchant's webhook endpoints
+// @Summary List webhook endpoints
+// @Description List the URLs the merchant receives webhooks at besides its webhook_url, with the event types each receives
+// @Tags merchant
+// @Produce json,xml
+// @Security BearerAuth
+// @Success 200 {array} models.WebhookEndpoint
+// @Failure 401 {object} models.APIResponse
+// @Failure 500 {object} models.APIResponse
+// @Router /merchant/webhook-endpoints [get]
+func (h *Handler) ListWebhookEndpointsHandler(w http.ResponseWriter, r *http.Request) {
+	caller, _ := auth.FromContext(r.Context())
+
+	endpoints, err := h.merchantWebhooks.ListEndpoints(r.Context(), caller.MerchantID)
+	if err != nil {
+		utils.SendErrorResponse(w, r, http.StatusInternalServerError, fmt.Sprintf("Failed to list webhook endpoints: %v", err))
+		return
+	}
+
+	utils.SendResponse(w, r, http.StatusOK, endpoints)
+}
+
+// CreateWebhookEndpointHandler adds a webhook endpoint for the calling merchant
+// @Summary Add a webhook endpoint
+// @Description Receive webhooks at another URL, only those of the event types listed: exact types such as transaction.completed, prefixes such as transfer.*, or * for all. An endpoint without event types receives every event. Deliveries are signed with the merchant's webhook secret.
+// @Description The endpoint is pinned to payload_version, v1 (legacy shape) or v2 (event envelope), defaulting to the latest.
+// @Tags merchant
+// @Accept json,xml
+// @Produce json,xml
+// @Security BearerAuth
+// @Param endpoint body models.WebhookEndpointRequest true "Webhook endpoint"
+// @Success 201 {object} models.WebhookEndpoint
+// @Failure 400 {object} models.APIResponse
+// @Failure 401 {object} models.APIResponse
+// @Failure 403 {object} models.APIResponse
+// @Failure 404 {object} models.APIResponse
+// @Failure 500 {object} models.APIResponse
+// @Router /merchant/webhook-endpoints [post]
+func (h *Handler) CreateWebhookEndpointHandler(w http.ResponseWriter, r *http.Request) {
+	caller, _ := auth.FromContext(r.Context())
+
+	var request models.WebhookEndpointRequest
+	if err := utils.DecodeRequest(r, &request); err != nil {
+		utils.SendDecodeError(w, r, err)
+		return
+	}
+	if err := validation.Struct(request); err != nil {
+		utils.SendValidationError(w, r, err)
+		return
+	}
+
+	endpoint, err := h.merchantWebhooks.CreateEndpoint(r.Context(), caller.MerchantID, request)
+	if err != nil {
+		switch {
+		case errors.Is(err, services.ErrInvalidWebhookEndpoint):
+			utils.SendErrorResponse(w, r, http.StatusBadRequest, err.Error())
+		case errors.Is(err, services.ErrMerchantNotFound):
+			utils.SendErrorResponse(w, r, http.StatusNotFound, fmt.Sprintf("Merchant not found: %d", caller.MerchantID))
+		default:
+			utils.SendErrorResponse(w, r, http.StatusInternalServerError, fmt.Sprintf("Failed to add webhook endpoint: %v", err))
+		}
+		return
+	}
+
+	utils.SendResponse(w, r, http.StatusCreated, endpoint)
+}
+
+// UpdateWebhookEndpointHandler replaces one of the calling merchant's webhook endpoints
+// @Summary Update a webhook endpoint
+// @Description Replace the URL, event types and description of a webhook endpoint, and its payload version when one is given. Events already recorded for it are delivered to the new URL in the new version.
+// @Tags merchant
+// @Accept json,xml
+// @Produce json,xml
+// @Security BearerAuth
+// @Param endpoint_id path int true "Webhook endpoint ID"
+// @Param endpoint body models.WebhookEndpointRequest true "Webhook endpoint"
+// @Success 200 {object} models.WebhookEndpoint
+// @Failure 400 {object} models.APIResponse
+// @Failure 401 {object} models.APIResponse
+// @Failure 403 {object} models.APIResponse
+// @Failure 404 {object} models.APIResponse
+// @Failure 500 {object} models.APIResponse
+// @Router /merchant/webhook-endpoints/{endpoint_id} [put]
+func (h *Handler) UpdateWebhookEndpointHandler(w http.ResponseWriter, r *http.Request) {
+	caller, _ := auth.FromContext(r.Context())
+	endpointID, ok := webhookEndpointID(w, r)
+	if !ok {
+		return
+	}
+
+	var request models.WebhookEndpointRequest
+	if err := utils.DecodeRequest(r, &request); err != nil {
+		utils.SendDecodeError(w, r, err)
+		return
+	}
+	if err := validation.Struct(request); err != nil {
+		utils.SendValidationError(w, r, err)
+		return
+	}
+
+	endpoint, err := h.merchantWebhooks.UpdateEndpoint(r.Context(), caller.MerchantID, endpointID, request)
+	if err != nil {
+		switch {
+		case errors.Is(err, services.ErrInvalidWebhookEndpoint):
+			utils.SendErrorResponse(w, r, http.StatusBadRequest, err.Error())
+		case errors.Is(err, services.ErrWebhookEndpointNotFound):
+			utils.SendErrorResponse(w, r, http.StatusNotFound, fmt.Sprintf("Webhook endpoint not found: %d", endpointID))
+		default:
+			utils.SendErrorResponse(w, r, http.StatusInternalServerError, fmt.Sprintf("Failed to update webhook endpoint: %v", err))
+		}
+		return
+	}
+
+	utils.SendResponse(w, r, http.StatusOK, endpoint)
+}
+
+// DeleteWebhookEndpointHandler deletes one of the calling merchant's webhook endpoints
+// @Summary Delete a webhook endpoint
+// @Description Stop delivering webhooks to an endpoint, including events still awaiting delivery to it
+// @Tags merchant
+// @Produce json,xml
+// @Security BearerAuth
+// @Param endpoint_id path int true "Webhook endpoint ID"
+// @Success 200 {object} map[string]string
+// @Failure 400 {object} models.APIResponse
+// @Failure 401 {object} models.APIResponse
+// @Failure 403 {object} models.APIResponse
+// @Failure 404 {object} models.APIResponse
+// @Failure 500 {object} models.APIResponse
+// @Router /merchant/webhook-endpoints/{endpoint_id} [delete]
+func (h *Handler) DeleteWebhookEndpointHandler(w http.ResponseWriter, r *http.Request) {
+	caller, _ := auth.FromContext(r.Context())
+	endpointID, ok := webhookEndpointID(w, r)
+	if !ok {
+		return
+	}
+
+	if err := h.merchantWebhooks.DeleteEndpoint(r.Context(), caller.MerchantID, endpointID); err != nil {
+		if errors.Is(err, services.ErrWebhookEndpointNotFound) {
+			utils.SendErrorResponse(w, r, http.StatusNotFound, fmt.Sprintf("Webhook endpoint not found: %d", endpointID))
+			return
+		}
+		utils.SendErrorResponse(w, r, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	utils.SendResponse(w, r, http.StatusOK, map[string]string{"status": "deleted"})
+}
+
+// VerifyWebhookHandler helps the calling merchant test its webhook signature verification
+// @Summary Test webhook signature verification
+// @Description Return sample webhook deliveries signed with the merchant's secret, including ones a verifier must reject. With a payload and signature, also report whether the signature the merchant computed matches.
+// @Description Samples are in payload_version, v1 by default.
+// @Tags merchant
+// @Accept json,xml
+// @Produce json,xml
+// @Security BearerAuth
+// @Param check body models.WebhookVerifyRequest false "Payload and signature to check"
+// @Success 200 {object} models.WebhookVerification
+// @Failure 400 {object} models.APIResponse
+// @Failure 401 {object} models.APIResponse
+// @Failure 403 {object} models.APIResponse
+// @Failure 409 {object} models.APIResponse
+// @Failure 500 {object} models.APIResponse
+// @Router /webhooks/verify [post]
+func (h *Handler) VerifyWebhookHandler(w http.ResponseWriter, r *http.Request) {
+	caller, _ := auth.FromContext(r.Context())
+
+	var request models.WebhookVerifyRequest
+	if r.ContentLength != 0 {
+		if err := utils.DecodeRequest(r, &request); err != nil {
+			utils.SendDecodeError(w, r, err)
+			return
+		}
+	}
+	if err := validation.Struct(request); err != nil {
+		utils.SendValidationError(w, r, err)
+		return
+	}
+
+	verification, err := h.merchantWebhooks.Verify(r.Context(), caller.MerchantID, request)
+	if err != nil {
+		switch {
+		case errors.Is(err, services.ErrInvalidWebhookCheck):
+			utils.SendErrorResponse(w, r, http.StatusBadRequest, err.Error())
+		case errors.Is(err, services.ErrMerchantNotFound):
+			utils.SendErrorResponse(w, r, http.StatusNotFound, fmt.Sprintf("Merchant not found: %d", caller.MerchantID))
+		case errors.Is(err, services.ErrNoMerchantWebhookSecret):
+			utils.SendErrorResponse(w, r, http.StatusConflict, err.Error())
+		default:
+			utils.SendErrorResponse(w, r, http.StatusInternalServerError, fmt.Sprintf("Failed to build webhook samples: %v", err))
+		}
+		return
+	}
+
+	utils.SendResponse(w, r, http.StatusOK, verification)
+}
+
+// AdminCreateAPIKeyHandler issues an API key for a merchant, e.g. its first key
+// @Summary Create a merchant API key
+// @Description Issue an API key on behalf of a merchant. The key is returned only in this response.
+// @Tags admin
+// @Accept json,xml
+// @Produce json,xml
+// @Param merchant_id path int true "Merchant ID"
+// @Param key body models.APIKeyRequest true "API key to create"
+// @Success 201 {object} models.APIKey
+// @Failure 400 {object} models.APIResponse
+// @Failure 404 {object} models.APIResponse
+// @Failure 500 {object} models.APIResponse
+// @Router /admin/merchants/{merchant_id}/api-keys [post]
+func (h *Handler) AdminCreateAPIKeyHandler(w http.ResponseWriter, r *http.Request) {
+	merchantID, err := strconv.Atoi(mux.Vars(r)["merchant_id"])
+	if err != nil || merchantID <= 0 {
+		utils.SendErrorResponse(w, r, http.StatusBadRequest, "Invalid merchant ID")
+		return
+	}
+
+	h.createAPIKey(w, r, merchantID)
+}
+
+// SetMerchantLivemodeHandler switches a merchant between gateways' sandbox and production environments
+// @Summary Set merchant livemode
+// @Description Send the merchant's new transactions to gateways' production environments (livemode true) or their sandboxes. Livemode can only be enabled in production deployments.
+// @Tags admin
+// @Accept json,xml
+// @Produce json,xml
+// @Param merchant_id path int true "Merchant ID"
+// @Param livemode body models.MerchantLivemodeRequest true "Livemode"
+// @Success 200 {object} models.Merchant
+// @Failure 400 {object} models.APIResponse
+// @Failure 403 {object} models.APIResponse
+// @Failure 404 {object} models.APIResponse
+// @Failure 500 {object} models.APIResponse
+// @Router /admin/merchants/{merchant_id}/livemode [put]
+func (h *Handler) SetMerchantLivemodeHandler(w http.ResponseWriter, r *http.Request) {
+	merchantID, err := strconv.Atoi(mux.Vars(r)["merchant_id"])
+	if err != nil || merchantID <= 0 {
+		utils.SendErrorResponse(w, r, http.StatusBadRequest, "Invalid merchant ID")
+		return
+	}
+
+	var request models.MerchantLivemodeRequest
+	if err := utils.DecodeRequest(r, &request); err != nil {
+		utils.SendDecodeError(w, r, err)
+		return
+	}
+
+	merchant, err := h.transactionService.SetMerchantLivemode(r.Context(), merchantID, request)
+	if err != nil {
+		switch {
+		case errors.Is(err, services.ErrLivemodeUnavailable):
+			utils.SendErrorResponse(w, r, http.StatusForbidden, err.Error())
+		case errors.Is(err, services.ErrMerchantNotFound):
+			utils.SendErrorResponse(w, r, http.StatusNotFound, fmt.Sprintf("Merchant not found: %d", merchantID))
+		default:
+			utils.SendErrorResponse(w, r, http.StatusInternalServerError, fmt.Sprintf("Failed to set livemode: %v", err))
+		}
+		return
+	}
+
+	utils.SendResponse(w, r, http.StatusOK, merchant)
+}
+
+// ListMerchantFeesHandler lists a merchant's fees
+// @Summary List merchant fees
+// @Description List the fees charged on a merchant's completed transactions by currency, as shown in its reconciliation files
+// @Tags admin
+// @Produce json,xml
+// @Param merchant_id path int true "Merchant ID"
+// @Success 200 {array} models.MerchantFee
+// @Failure 400 {object} models.APIResponse
+// @Failure 404 {object} models.APIResponse
+// @Failure 500 {object} models.APIResponse
+// @Router /admin/merchants/{merchant_id}/fees [get]
+func (h *Handler) ListMerchantFeesHandler(w http.ResponseWriter, r *http.Request) {
+	merchantID, err := strconv.Atoi(mux.Vars(r)["merchant_id"])
+	if err != nil || merchantID <= 0 {
+		utils.SendErrorResponse(w, r, http.StatusBadRequest, "Invalid merchant ID")
+		return
+	}
+
+	fees, err := h.reconciliation.Fees(r.Context(), merchantID)
+	if err != nil {
+		if errors.Is(err, services.ErrMerchantNotFound) {
+			utils.SendErrorResponse(w, r, http.StatusNotFound, fmt.Sprintf("Merchant not found: %d", merchantID))
+			return
+		}
+		utils.SendErrorResponse(w, r, http.StatusInternalServerError, fmt.Sprintf("Failed to list merchant fees: %v", err))
+		return
+	}
+
+	utils.SendResponse(w, r, http.StatusOK, fees)
+}
+
+// ReplaceMerchantFeesHandler replaces a merchant's fees
+// @Summary Replace merchant fees
+// @Description Set the fee charged on a merchant's completed transactions in each currency: a percentage of the amount plus a fixed amount, rounded like amounts. Currencies not listed are free. Fees apply to reconciliation files generated afterwards, including regenerated ones.
+// @Tags admin
+// @Accept json,xml
+// @Produce json,xml
+// @Param merchant_id path int true "Merchant ID"
+// @Param fees body models.MerchantFeesRequest true "Fees by currency"
+// @Success 200 {array} models.MerchantFee
+// @Failure 400 {object} models.APIResponse
+// @Failure 404 {object} models.APIResponse
+// @Failure 500 {object} models.APIResponse
+// @Router /admin/merchants/{merchant_id}/fees [put]
+func (h *Handler) ReplaceMerchantFeesHandler(w http.ResponseWriter, r *http.Request) {
+	merchantID, err := strconv.Atoi(mux.Vars(r)["merchant_id"])
+	if err != nil || merchantID <= 0 {
+		utils.SendErrorResponse(w, r, http.StatusBadRequest, "Invalid merchant ID")
+		return
+	}
+
+	var request models.MerchantFeesRequest
+	if err := utils.DecodeRequest(r, &request); err != nil {
+		utils.SendDecodeError(w, r, err)
+		return
+	}
+	if err := validation.Struct(request); err != nil {
+		utils.SendValidationError(w, r, err)
+		return
+	}
+
+	fees, err := h.reconciliation.ReplaceFees(r.Context(), merchantID, request.Fees)
+	if err != nil {
+		switch {
+		case errors.Is(err, services.ErrInvalidMerchantFees):
+			utils.SendErrorResponse(w, r, http.StatusBadRequest, err.Error())
+		case errors.Is(err, services.ErrMerchantNotFound):
+			utils.SendErrorResponse(w, r, http.StatusNotFound, fmt.Sprintf("Merchant not found: %d", merchantID))
+		default:
+			utils.SendErrorResponse(w, r, http.StatusInternalServerError, fmt.Sprintf("Failed to save merchant fees: %v", err))
+		}
+		return
+	}
+
+	utils.SendResponse(w, r, http.StatusOK, fees)
+}
+
+// createAPIKey decodes an API key request and issues the key for a merchant
+func (h *Handler) createAPIKey(w http.ResponseWriter, r *http.Request, merchantID int) {
+	var request models.APIKeyRequest
+	if r.ContentLength != 0 {
+		if err := utils.DecodeRequest(r, &request); err != nil {
+			utils.SendDecodeError(w, r, err)
+			return
+		}
+	}
+	if err := validation.Struct(request); err != nil {
+		utils.SendValidationError(w, r, err)
+		return
+	}
+
+	key, err := h.apiKeys.Create(r.Context(), merchantID, request)
+	if err != nil {
+		switch {
+		case errors.Is(err, services.ErrInvalidAPIKeyScope):
+			utils.SendErrorResponse(w, r, http.StatusBadRequest, err.Error())
+		case errors.Is(err, services.ErrMerchantNotFound):
+			utils.SendErrorResponse(w, r, http.StatusNotFound, fmt.Sprintf("Merchant not found: %d", merchantID))
+		default:
+			utils.SendErrorResponse(w, r, http.StatusInternalServerError, fmt.Sprintf("Failed to create API key: %v", err))
+		}
+		return
+	}
+
+	utils.SendResponse(w, r, http.StatusCreated, key)
+}
+
+// authenticate identifies the merchant behind a request from an API key or an OAuth2 access
+// token, sent as a bearer token (API keys may also be sent in the X-Api-Key header), and checks
+// that its roles allow the request: viewers may only make GET requests.
+func (h *Handler) authenticate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		credential := r.Header.Get(consts.APIKeyHeader)
+		if header := r.Header.Get("Authorization"); credential == "" && len(header) > 7 && strings.EqualFold(header[:7], "Bearer ") {
+			credential = strings.TrimSpace(header[7:])
+		}
+		if credential == "" {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			utils.SendErrorResponse(w, r, http.StatusUnauthorized, "API key or access token required")
+			return
+		}
+
+		var principal *auth.Principal
+		var err error
+		if strings.HasPrefix(credential, consts.APIKeyPrefix) {
+			var key *models.APIKey
+			if key, err = h.apiKeys.Authenticate(r.Context(), credential); err == nil {
+				principal = auth.APIKeyPrincipal(key)
+			}
+		} else {
+			principal, err = h.oauth.Authenticate(r.Context(), credential)
+		}
+		if err != nil {
+			if errors.Is(err, services.ErrInvalidAPIKey) || errors.Is(err, auth.ErrInvalidToken) {
+				w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+				utils.SendErrorResponse(w, r, http.StatusUnauthorized, err.Error())
+				return
+			}
+			utils.SendErrorResponse(w, r, http.StatusInternalServerError, err.Error())
+			return
+		}
+
+		if role := auth.RequiredRole(r.Method); !principal.HasRole(role) {
+			utils.SendErrorResponse(w, r, http.StatusForbidden, fmt.Sprintf("The %s role is required", role))
+			return
+		}
+
+		next.ServeHTTP(w, r.WithContext(auth.NewContext(r.Context(), principal)))
+	})
+}
+
+// apiKeyID parses the API key ID path parameter, responding with 400 when it is invalid
+func apiKeyID(w http.ResponseWriter, r *http.Request) (int, bool) {
+	keyID, err := strconv.Atoi(mux.Vars(r)["key_id"])
+	if err != nil || keyID <= 0 {
+		utils.SendErrorResponse(w, r, http.StatusBadRequest, "Invalid API key ID")
+		return 0, false
+	}
+	return keyID, true
+}
+
+// webhookEndpointID parses the webhook endpoint ID path parameter, responding with 400 when it is
+// invalid
+func webhookEndpointID(w http.ResponseWriter, r *http.Request) (int, bool) {
+	endpointID, err := strconv.Atoi(mux.Vars(r)["endpoint_id"])
+	if err != nil || endpointID <= 0 {
+		utils.SendErrorResponse(w, r, http.StatusBadRequest, "Invalid webhook endpoint ID")
+		return 0, false
+	}
+	return endpointID, true
+}
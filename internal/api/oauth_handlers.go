@@ -0,0 +1,130 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"payment-gateway/internal/models"
+	"payment-gateway/internal/services"
+	"payment-gateway/internal/utils"
+	"payment-gateway/internal/validation"
+	"strconv"
+
+	"github.com/gorilla/mux"
+)
+
+// TokenHandler issues OAuth2 access tokens for the client-credentials grant
+// @Summary Issue an access token
+// @Description Exchange OAuth2 client credentials, sent with HTTP Basic authentication or in the form, for a bearer access token
+// @Tags oauth
+// @Accept x-www-form-urlencoded,json
+// @Produce json
+// @Param request body models.TokenRequest true "Token request"
+// @Success 200 {object} models.TokenResponse
+// @Failure 400 {object} models.OAuthError
+// @Failure 401 {object} models.OAuthError
+// @Router /oauth/token [post]
+func (h *Handler) TokenHandler(w http.ResponseWriter, r *http.Request) {
+	var request models.TokenRequest
+	if err := utils.DecodeRequest(r, &request); err != nil {
+		status := http.StatusBadRequest
+		if utils.IsBodyTooLarge(err) {
+			status = http.StatusRequestEntityTooLarge
+		}
+		sendOAuthError(w, status, "invalid_request", err.Error())
+		return
+	}
+	if err := validation.Struct(request); err != nil {
+		sendOAuthError(w, http.StatusBadRequest, "invalid_request", err.Error())
+		return
+	}
+
+	// Clients should authenticate with HTTP Basic; credentials in the body are also accepted
+	if clientID, secret, ok := r.BasicAuth(); ok {
+		request.ClientID, _ = url.QueryUnescape(clientID)
+		request.ClientSecret, _ = url.QueryUnescape(secret)
+	}
+
+	token, err := h.oauth.IssueToken(r.Context(), request)
+	if err != nil {
+		switch {
+		case errors.Is(err, services.ErrUnsupportedGrantType):
+			sendOAuthError(w, http.StatusBadRequest, "unsupported_grant_type", err.Error())
+		case errors.Is(err, services.ErrInvalidOAuthClient):
+			w.Header().Set("WWW-Authenticate", `Basic realm="oauth"`)
+			sendOAuthError(w, http.StatusUnauthorized, "invalid_client", err.Error())
+		case errors.Is(err, services.ErrInvalidOAuthScope):
+			sendOAuthError(w, http.StatusBadRequest, "invalid_scope", err.Error())
+		default:
+			sendOAuthError(w, http.StatusInternalServerError, "server_error", "Failed to issue access token")
+		}
+		return
+	}
+
+	sendOAuthResponse(w, http.StatusOK, token)
+}
+
+// CreateOAuthClientHandler registers an OAuth2 client for a merchant
+// @Summary Create a merchant OAuth2 client
+// @Description Register a client-credentials client with the given scopes. The client secret is returned only in this response.
+// @Tags admin
+// @Accept json,xml
+// @Produce json,xml
+// @Param merchant_id path int true "Merchant ID"
+// @Param client body models.OAuthClientRequest true "Client to create"
+// @Success 201 {object} models.OAuthClient
+// @Failure 400 {object} models.APIResponse
+// @Failure 404 {object} models.APIResponse
+// @Failure 500 {object} models.APIResponse
+// @Router /admin/merchants/{merchant_id}/oauth-clients [post]
+func (h *Handler) CreateOAuthClientHandler(w http.ResponseWriter, r *http.Request) {
+	merchantID, err := strconv.Atoi(mux.Vars(r)["merchant_id"])
+	if err != nil || merchantID <= 0 {
+		utils.SendErrorResponse(w, r, http.StatusBadRequest, "Invalid merchant ID")
+		return
+	}
+
+	var request models.OAuthClientRequest
+	if r.ContentLength != 0 {
+		if err := utils.DecodeRequest(r, &request); err != nil {
+			utils.SendDecodeError(w, r, err)
+			return
+		}
+	}
+	if err := validation.Struct(request); err != nil {
+		utils.SendValidationError(w, r, err)
+		return
+	}
+
+	client, err := h.oauth.CreateClient(r.Context(), merchantID, request)
+	if err != nil {
+		switch {
+		case errors.Is(err, services.ErrInvalidOAuthScope):
+			utils.SendErrorResponse(w, r, http.StatusBadRequest, err.Error())
+		case errors.Is(err, services.ErrMerchantNotFound):
+			utils.SendErrorResponse(w, r, http.StatusNotFound, fmt.Sprintf("Merchant not found: %d", merchantID))
+		default:
+			utils.SendErrorResponse(w, r, http.StatusInternalServerError, fmt.Sprintf("Failed to create OAuth client: %v", err))
+		}
+		return
+	}
+
+	utils.SendResponse(w, r, http.StatusCreated, client)
+}
+
+// sendOAuthResponse writes a token endpoint response, which is always JSON and never cached
+func sendOAuthResponse(w http.ResponseWriter, statusCode int, data interface{}) {
+	body, _ := json.Marshal(data)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(statusCode)
+	w.Write(body)
+}
+
+// sendOAuthError writes an OAuth2 error response
+func sendOAuthError(w http.ResponseWriter, statusCode int, code, description string) {
+	sendOAuthResponse(w, statusCode, models.OAuthError{Error: code, Description: description})
+}
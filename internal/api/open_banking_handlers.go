@@ -0,0 +1,273 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"payment-gateway/internal/consts"
+	"payment-gateway/internal/geo"
+	"payment-gateway/internal/models"
+	"payment-gateway/internal/services"
+	"payment-gateway/internal/utils"
+	"payment-gateway/internal/validation"
+	"strconv"
+
+	"github.com/gorilla/mux"
+)
+
+// ListBanksHandler returns the bank directory
+// @Summary List banks
+// @Description List the banks customers can pay deposits from by open banking payment initiation.
+// @Description Pass a bank's id as bank_id in a deposit request to pay from it.
+// @Tags open-banking
+// @Produce json,xml
+// @Param country query string false "ISO 3166-1 alpha-2 country code; all countries when omitted"
+// @Success 200 {array} models.Bank
+// @Failure 400 {object} models.APIResponse
+// @Failure 500 {object} models.APIResponse
+// @Router /open-banking/banks [get]
+func (h *Handler) ListBanksHandler(w http.ResponseWriter, r *http.Request) {
+	country := r.URL.Query().Get("country")
+	if country != "" && !geo.IsValidCountryCode(country) {
+		utils.SendErrorResponse(w, r, http.StatusBadRequest, "Invalid country code")
+		return
+	}
+
+	banks, err := h.transactionService.ListBanks(r.Context(), geo.NormalizeCountryCode(country))
+	if err != nil {
+		utils.SendErrorResponse(w, r, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	utils.SendResponse(w, r, http.StatusOK, banks)
+}
+
+// ConsentCallbackHandler handles the redirect from the customer's bank after a payment consent
+// @Summary Complete an open banking deposit
+// @Description The redirect URI banks send customers back to once they authorised or rejected a payment consent.
+// @Description An authorisation code executes the payment; an error, or a consent that expired, fails the deposit.
+// @Description The customer is then redirected to the deposit's return_url, or its cancel_url when it failed;
+// @Description deposits without them are returned as JSON.
+// @Tags open-banking
+// @Produce json,xml
+// @Param state query string true "State the consent was created with"
+// @Param code query string false "Authorisation code, when the customer authorised the payment"
+// @Param error query string false "Error, when the customer rejected the payment"
+// @Success 200 {object} models.TransactionResponse
+// @Success 303 "Redirect to the deposit's return or cancel URL"
+// @Failure 400 {object} models.APIResponse
+// @Failure 404 {object} models.APIResponse
+// @Failure 409 {object} models.APIResponse
+// @Failure 500 {object} models.APIResponse
+// @Router /open-banking/callback [get]
+func (h *Handler) ConsentCallbackHandler(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	tx, err := h.transactionService.HandleConsentCallback(r.Context(), query.Get("state"), query.Get("code"), query.Get("error"))
+	if err != nil {
+		switch {
+		case errors.Is(err, services.ErrInvalidConsentCallback):
+			utils.SendErrorResponse(w, r, http.StatusBadRequest, err.Error())
+		case errors.Is(err, services.ErrConsentNotFound):
+			utils.SendErrorResponse(w, r, http.StatusNotFound, "Payment consent not found")
+		case errors.Is(err, services.ErrConsentUsed):
+			utils.SendErrorResponse(w, r, http.StatusConflict, err.Error())
+		default:
+			utils.SendErrorResponse(w, r, http.StatusInternalServerError, err.Error())
+		}
+		return
+	}
+
+	redirect := tx.ReturnURL
+	if tx.Status == consts.Failed && tx.CancelURL != "" {
+		redirect = tx.CancelURL
+	}
+	if redirect != "" {
+		http.Redirect(w, r, redirect, http.StatusSeeOther)
+		return
+	}
+
+	utils.SendResponse(w, r, http.StatusOK, h.transactionResponse(tx))
+}
+
+// GetPaymentConsentHandler returns the payment consent of an open banking deposit
+// @Summary Get payment consent
+// @Description Return the consent of a user's open banking deposit: the bank, its authorisation URL and whether it was authorised
+// @Tags open-banking
+// @Produce json,xml
+// @Param transaction_id path int true "Transaction ID"
+// @Param user_id query int true "User who made the deposit"
+// @Param If-None-Match header string false "ETag of the representation the client holds"
+// @Success 200 {object} models.PaymentConsent
+// @Header 200 {string} ETag "Entity tag of the representation"
+// @Success 304 "The representation the client holds is current"
+// @Failure 400 {object} models.APIResponse
+// @Failure 404 {object} models.APIResponse
+// @Failure 500 {object} models.APIResponse
+// @Router /transactions/{transaction_id}/consent [get]
+func (h *Handler) GetPaymentConsentHandler(w http.ResponseWriter, r *http.Request) {
+	txID, ok := transactionID(w, r)
+	if !ok {
+		return
+	}
+
+	userID, err := strconv.Atoi(r.URL.Query().Get("user_id"))
+	if err != nil || userID <= 0 {
+		utils.SendErrorResponse(w, r, http.StatusBadRequest, "Invalid user ID")
+		return
+	}
+
+	consent, err := h.transactionService.GetPaymentConsent(r.Context(), txID, userID)
+	if err != nil {
+		switch {
+		case errors.Is(err, services.ErrTransactionNotFound):
+			utils.SendErrorResponse(w, r, http.StatusNotFound, fmt.Sprintf("Transaction not found: %d", txID))
+		case errors.Is(err, services.ErrConsentNotFound):
+			utils.SendErrorResponse(w, r, http.StatusNotFound, fmt.Sprintf("Transaction %d is not an open banking deposit", txID))
+		default:
+			utils.SendErrorResponse(w, r, http.StatusInternalServerError, err.Error())
+		}
+		return
+	}
+
+	utils.SendCacheableResponse(w, r, consent)
+}
+
+// CreateBankAccountLinkHandler starts linking a user's bank accounts for withdrawals
+// @Summary Link bank accounts
+// @Description Create a consent for an open banking gateway to read a user's bank accounts. Send the user to its
+// @Description authorisation_url; once they authorise it at their bank, the accounts held in their name are linked
+// @Description and withdrawals can name one as bank_account_id to be paid out to it.
+// @Tags open-banking
+// @Accept json,xml
+// @Produce json,xml
+// @Param link body models.BankAccountLinkRequest true "User and, when several gateways pay out to bank accounts, the gateway"
+// @Success 201 {object} models.BankAccountConsent
+// @Failure 400 {object} models.APIResponse
+// @Failure 404 {object} models.APIResponse
+// @Failure 500 {object} models.APIResponse
+// @Router /bank-accounts/link [post]
+func (h *Handler) CreateBankAccountLinkHandler(w http.ResponseWriter, r *http.Request) {
+	var request models.BankAccountLinkRequest
+	if err := utils.DecodeRequest(r, &request); err != nil {
+		utils.SendDecodeError(w, r, err)
+		return
+	}
+	if err := validation.Struct(request); err != nil {
+		utils.SendValidationError(w, r, err)
+		return
+	}
+
+	consent, err := h.transactionService.CreateBankAccountLink(r.Context(), request.UserID, request.GatewayID)
+	if err != nil {
+		switch {
+		case errors.Is(err, services.ErrUserNotFound):
+			utils.SendErrorResponse(w, r, http.StatusNotFound, fmt.Sprintf("User not found: %d", request.UserID))
+		case errors.Is(err, services.ErrBankPayoutGatewayNotFound):
+			utils.SendErrorResponse(w, r, http.StatusNotFound, "No gateway pays out to bank accounts")
+		case errors.Is(err, services.ErrInvalidBankPayout):
+			utils.SendErrorResponse(w, r, http.StatusBadRequest, err.Error())
+		default:
+			utils.SendErrorResponse(w, r, errorStatus(err), err.Error())
+		}
+		return
+	}
+
+	utils.SendResponse(w, r, http.StatusCreated, consent)
+}
+
+// BankAccountCallbackHandler handles the redirect from the user's bank after linking accounts
+// @Summary Complete linking bank accounts
+// @Description The redirect URI banks send users back to once they authorised or rejected reading their accounts.
+// @Description An authorisation code links the accounts held in the user's name and returns them.
+// @Tags open-banking
+// @Produce json,xml
+// @Param state query string true "State the consent was created with"
+// @Param code query string false "Authorisation code, when the user authorised reading their accounts"
+// @Param error query string false "Error, when the user rejected it"
+// @Success 200 {array} models.BankAccount
+// @Failure 400 {object} models.APIResponse
+// @Failure 404 {object} models.APIResponse
+// @Failure 409 {object} models.APIResponse
+// @Failure 500 {object} models.APIResponse
+// @Router /bank-accounts/callback [get]
+func (h *Handler) BankAccountCallbackHandler(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	accounts, err := h.transactionService.HandleBankAccountCallback(r.Context(), query.Get("state"), query.Get("code"), query.Get("error"))
+	if err != nil {
+		switch {
+		case errors.Is(err, services.ErrInvalidConsentCallback):
+			utils.SendErrorResponse(w, r, http.StatusBadRequest, err.Error())
+		case errors.Is(err, services.ErrBankAccountConsentNotFound):
+			utils.SendErrorResponse(w, r, http.StatusNotFound, "Bank account consent not found")
+		case errors.Is(err, services.ErrConsentUsed), errors.Is(err, services.ErrBankAccountHolderMismatch):
+			utils.SendErrorResponse(w, r, http.StatusConflict, err.Error())
+		default:
+			utils.SendErrorResponse(w, r, http.StatusInternalServerError, err.Error())
+		}
+		return
+	}
+
+	utils.SendResponse(w, r, http.StatusOK, accounts)
+}
+
+// ListBankAccountsHandler returns the bank accounts a user linked
+// @Summary List bank accounts
+// @Description List the bank accounts a user linked for withdrawals, active or revoked. Only the last four digits of account numbers are returned.
+// @Tags open-banking
+// @Produce json,xml
+// @Param user_id query int true "User who linked the accounts"
+// @Success 200 {array} models.BankAccount
+// @Failure 400 {object} models.APIResponse
+// @Failure 500 {object} models.APIResponse
+// @Router /bank-accounts [get]
+func (h *Handler) ListBankAccountsHandler(w http.ResponseWriter, r *http.Request) {
+	userID, err := strconv.Atoi(r.URL.Query().Get("user_id"))
+	if err != nil || userID <= 0 {
+		utils.SendErrorResponse(w, r, http.StatusBadRequest, "Invalid user ID")
+		return
+	}
+
+	accounts, err := h.transactionService.ListBankAccounts(r.Context(), userID)
+	if err != nil {
+		utils.SendErrorResponse(w, r, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	utils.SendResponse(w, r, http.StatusOK, accounts)
+}
+
+// RevokeBankAccountHandler stops paying withdrawals out to a linked bank account
+// @Summary Revoke a bank account
+// @Description Revoke a user's linked bank account. Withdrawals can no longer be paid out to it, including scheduled ones.
+// @Tags open-banking
+// @Produce json,xml
+// @Param account_id path int true "Bank account ID"
+// @Param user_id query int true "User who linked the account"
+// @Success 200 {object} map[string]string
+// @Failure 400 {object} models.APIResponse
+// @Failure 404 {object} models.APIResponse
+// @Failure 500 {object} models.APIResponse
+// @Router /bank-accounts/{account_id} [delete]
+func (h *Handler) RevokeBankAccountHandler(w http.ResponseWriter, r *http.Request) {
+	accountID, err := strconv.Atoi(mux.Vars(r)["account_id"])
+	if err != nil || accountID <= 0 {
+		utils.SendErrorResponse(w, r, http.StatusBadRequest, "Invalid bank account ID")
+		return
+	}
+	userID, err := strconv.Atoi(r.URL.Query().Get("user_id"))
+	if err != nil || userID <= 0 {
+		utils.SendErrorResponse(w, r, http.StatusBadRequest, "Invalid user ID")
+		return
+	}
+
+	if err := h.transactionService.RevokeBankAccount(r.Context(), accountID, userID); err != nil {
+		if errors.Is(err, services.ErrBankAccountNotFound) {
+			utils.SendErrorResponse(w, r, http.StatusNotFound, fmt.Sprintf("Bank account not found: %d", accountID))
+			return
+		}
+		utils.SendErrorResponse(w, r, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	utils.SendResponse(w, r, http.StatusOK, map[string]string{"status": "revoked"})
+}
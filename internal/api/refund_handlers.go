@@ -0,0 +1,107 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"payment-gateway/internal/auth"
+	"payment-gateway/internal/models"
+	"payment-gateway/internal/services"
+	"payment-gateway/internal/utils"
+	"payment-gateway/internal/validation"
+	"strconv"
+
+	"github.com/gorilla/mux"
+)
+
+// RefundHandler refunds one of the calling merchant's completed deposits in full or in part
+// @Summary Refund a deposit
+// @Description Refund one of the calling merchant's completed deposits through the gateway that took it. Without an amount, what remains refundable is refunded.
+// @Description Partial refunds are accepted until the deposit's refunds that haven't failed add up to its amount. Declined refunds are returned with status failed and a decline_code, and their amount is refundable again.
+// @Description Gateways that accept refunds asynchronously leave them processing.
+// @Tags transactions
+// @Accept json,xml
+// @Produce json,xml
+// @Security BearerAuth
+// @Param refund body models.RefundRequest true "Refund"
+// @Success 200 {object} models.Refund
+// @Failure 400 {object} models.APIResponse
+// @Failure 401 {object} models.APIResponse
+// @Failure 403 {object} models.APIResponse
+// @Failure 404 {object} models.APIResponse
+// @Failure 409 {object} models.APIResponse
+// @Failure 422 {object} models.APIResponse
+// @Failure 500 {object} models.APIResponse
+// @Router /refund [post]
+func (h *Handler) RefundHandler(w http.ResponseWriter, r *http.Request) {
+	caller, _ := auth.FromContext(r.Context())
+
+	var request models.RefundRequest
+	if err := utils.DecodeRequest(r, &request); err != nil {
+		utils.SendDecodeError(w, r, err)
+		return
+	}
+	if err := validation.Struct(request); err != nil {
+		utils.SendValidationError(w, r, err)
+		return
+	}
+
+	refund, err := h.transactionService.ProcessRefund(r.Context(), caller.MerchantID, request)
+
+	var fieldErrs validation.Errors
+	if errors.As(err, &fieldErrs) {
+		utils.SendValidationError(w, r, err)
+		return
+	}
+	if err != nil {
+		switch {
+		case errors.Is(err, services.ErrTransactionNotFound):
+			utils.SendErrorResponse(w, r, http.StatusNotFound, fmt.Sprintf("Transaction not found: %d", request.TransactionID))
+		case errors.Is(err, services.ErrRefundNotAllowed), errors.Is(err, services.ErrRefundExceedsAmount),
+			errors.Is(err, services.ErrRefundExceedsBalance):
+			utils.SendErrorResponse(w, r, http.StatusConflict, err.Error())
+		case errors.Is(err, services.ErrRefundUnsupported):
+			utils.SendErrorResponse(w, r, http.StatusUnprocessableEntity, err.Error())
+		default:
+			utils.SendErrorResponse(w, r, errorStatus(err), fmt.Sprintf("Failed to process refund: %v", err))
+		}
+		return
+	}
+
+	utils.SendResponse(w, r, http.StatusOK, refund)
+}
+
+// GetRefundHandler returns a refund of one of the calling merchant's deposits
+// @Summary Get a refund
+// @Description Return a refund of one of the calling merchant's deposits with the amount of the deposit that remains refundable
+// @Tags transactions
+// @Produce json,xml
+// @Security BearerAuth
+// @Param refund_id path int true "Refund ID"
+// @Success 200 {object} models.Refund
+// @Failure 400 {object} models.APIResponse
+// @Failure 401 {object} models.APIResponse
+// @Failure 404 {object} models.APIResponse
+// @Failure 500 {object} models.APIResponse
+// @Router /refund/{refund_id} [get]
+func (h *Handler) GetRefundHandler(w http.ResponseWriter, r *http.Request) {
+	caller, _ := auth.FromContext(r.Context())
+
+	refundID, err := strconv.Atoi(mux.Vars(r)["refund_id"])
+	if err != nil || refundID <= 0 {
+		utils.SendErrorResponse(w, r, http.StatusBadRequest, "Invalid refund ID")
+		return
+	}
+
+	refund, err := h.transactionService.GetRefund(r.Context(), caller.MerchantID, refundID)
+	if err != nil {
+		if errors.Is(err, services.ErrRefundNotFound) {
+			utils.SendErrorResponse(w, r, http.StatusNotFound, fmt.Sprintf("Refund not found: %d", refundID))
+			return
+		}
+		utils.SendErrorResponse(w, r, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	utils.SendResponse(w, r, http.StatusOK, refund)
+}
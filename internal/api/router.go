@@ -2,33 +2,272 @@ package api
 
 import (
 	"github.com/gorilla/mux"
+	"net/http"
 	"payment-gateway/internal/consts"
-	"payment-gateway/internal/gateway"
-	"payment-gateway/internal/services"
+	"payment-gateway/internal/geo"
 	"payment-gateway/internal/utils"
+	"strings"
 )
 
-// SetupRouter sets up the HTTP router
-func SetupRouter(transactionService *services.TransactionService, gatewaySelector *gateway.Selector) *mux.Router {
+// Scope selects the routes a router serves, so the public API and the admin endpoints can be
+// served on separate listeners
+type Scope int
+
+const (
+	ScopeAll    Scope = iota // every route, when one listener serves the whole API
+	ScopePublic              // every route but the admin endpoints
+	ScopeAdmin               // the admin endpoints and the health checks
+)
+
+// SetupRouter sets up the HTTP router serving every route
+func SetupRouter(deps Services, locator *geo.IPLocator, security utils.SecurityConfig, requestLog utils.RequestLogConfig) *mux.Router {
+	return NewRouter(NewHandler(deps), ScopeAll, AdminMiddleware(security), Middleware(ScopeAll, locator, security, requestLog)...)
+}
+
+// Middleware returns the middleware chain of a router serving scope: requests are logged and
+// CORS headers sent on every listener, and the client's country signals are attached to public
+// API requests
+func Middleware(scope Scope, locator *geo.IPLocator, security utils.SecurityConfig, requestLog utils.RequestLogConfig) []mux.MiddlewareFunc {
+	chain := []mux.MiddlewareFunc{
+		utils.LoggingMiddleware,
+		utils.RequestLogMiddleware(requestLog),
+		utils.CorsMiddleware(security),
+	}
+	if scope != ScopeAdmin {
+		chain = append(chain, locator.Middleware)
+	}
+	return chain
+}
+
+// AdminMiddleware returns the middleware chain of the admin endpoints, run after the router's:
+// every request is audited, then refused unless it comes from the admin networks with an admin
+// token
+func AdminMiddleware(security utils.SecurityConfig) []mux.MiddlewareFunc {
+	return []mux.MiddlewareFunc{
+		utils.AdminAuditMiddleware(consts.AdminRoutePrefix),
+		utils.AdminAuthMiddleware(security, consts.AdminRoutePrefix),
+	}
+}
+
+// NewRouter sets up a router serving the routes of scope through a middleware chain, the admin
+// endpoints on a subrouter adding the admin middleware. Routers for different listeners share
+// the handler, and so its services.
+func NewRouter(handler *Handler, scope Scope, admin []mux.MiddlewareFunc, middleware ...mux.MiddlewareFunc) *mux.Router {
 	router := mux.NewRouter()
+	router.Use(middleware...)
+
+	// Health check endpoint
+	router.HandleFunc(consts.HealthRoute, handler.HealthCheckHandler).Methods("GET")
+	router.HandleFunc(consts.ReadyRoute, handler.ReadinessHandler).Methods("GET")
+
+	if scope != ScopePublic {
+		adminRouter := router.MatcherFunc(func(r *http.Request, _ *mux.RouteMatch) bool {
+			return strings.HasPrefix(r.URL.Path, consts.AdminRoutePrefix)
+		}).Subrouter()
+		adminRouter.Use(admin...)
+		registerAdminRoutes(adminRouter, handler)
+	}
+	if scope != ScopeAdmin {
+		registerPublicRoutes(router, handler)
+	}
+	return router
+}
+
+// registerPublicRoutes registers the routes of payments, merchants and gateway callbacks
+func registerPublicRoutes(router *mux.Router, handler *Handler) {
+	// Retries sending a deposit's or withdrawal's Idempotency-Key get its original response.
+	router.Handle(consts.DepositRoute, handler.idempotent(http.HandlerFunc(handler.DepositHandler))).Methods("POST")
+	router.Handle(consts.WithdrawRoute, handler.idempotent(http.HandlerFunc(handler.WithdrawalHandler))).Methods("POST")
+
+	// Refunds of completed deposits through the gateway that took them, made by the deposits' merchant
+	router.Handle(consts.RefundRoute, handler.authenticate(http.HandlerFunc(handler.RefundHandler))).Methods("POST")
+	router.Handle(consts.RefundRoute+"/{refund_id}", handler.authenticate(http.HandlerFunc(handler.GetRefundHandler))).Methods("GET")
+
+	// Deposits authorized now and captured or voided later
+	router.HandleFunc(consts.AuthorizeRoute, handler.AuthorizeHandler).Methods("POST")
+	router.HandleFunc(consts.CaptureRoute, handler.CaptureHandler).Methods("POST")
+	router.HandleFunc(consts.VoidRoute, handler.VoidHandler).Methods("POST")
+	router.HandleFunc(consts.IncrementAuthorizationRoute, handler.IncrementAuthorizationHandler).Methods("POST")
+
+	// Batch endpoints
+	router.HandleFunc(consts.BatchDepositRoute, handler.BatchDepositHandler).Methods("POST")
+	router.HandleFunc(consts.BatchDepositRoute+"/{batch_id}", handler.GetBatchHandler).Methods("GET")
+	router.HandleFunc(consts.BulkWithdrawRoute, handler.BulkWithdrawalHandler).Methods("POST")
+	router.HandleFunc(consts.BulkWithdrawRoute+"/{batch_id}", handler.GetBatchHandler).Methods("GET")
+
+	// Long-running operations
+	router.HandleFunc(consts.OperationsRoute+"/{operation_id}", handler.GetOperationHandler).Methods("GET")
 
-	// Create handler with dependencies
-	handler := NewHandler(transactionService, gatewaySelector)
+	// Transaction status, refreshed from gateways that report it
+	router.HandleFunc(consts.TransactionsRoute+"/{transaction_id}", handler.GetTransactionHandler).Methods("GET")
 
-	// Set up middleware
-	router.Use(utils.LoggingMiddleware)
-	router.Use(utils.CorsMiddleware)
+	// Audit trail of a transaction's creation and status changes
+	router.HandleFunc(consts.TransactionsRoute+"/{transaction_id}/events", handler.GetTransactionEventsHandler).Methods("GET")
 
-	// Set up routes
-	router.HandleFunc(consts.DepositRoute, handler.DepositHandler).Methods("POST")
-	router.HandleFunc(consts.WithdrawRoute, handler.WithdrawalHandler).Methods("POST")
+	// Disputes users open for transactions they do not recognize
+	router.HandleFunc(consts.TransactionsRoute+"/{transaction_id}/dispute", handler.SubmitDisputeHandler).Methods("POST")
+	router.HandleFunc(consts.TransactionsRoute+"/{transaction_id}/dispute", handler.GetDisputeHandler).Methods("GET")
+
+	// Payment options checkouts can offer
+	router.HandleFunc(consts.PaymentMethodsRoute, handler.ListPaymentMethodsHandler).Methods("GET")
+
+	// Open banking deposits: the bank directory, the redirect back from the bank and the consent
+	router.HandleFunc(consts.OpenBankingBanksRoute, handler.ListBanksHandler).Methods("GET")
+	router.HandleFunc(consts.OpenBankingCallbackRoute, handler.ConsentCallbackHandler).Methods("GET")
+	router.HandleFunc(consts.TransactionsRoute+"/{transaction_id}/consent", handler.GetPaymentConsentHandler).Methods("GET")
+
+	// Bank accounts withdrawals are paid out to: linking them at the bank, the redirect back and revoking them
+	router.HandleFunc(consts.BankAccountLinkRoute, handler.CreateBankAccountLinkHandler).Methods("POST")
+	router.HandleFunc(consts.BankAccountCallbackRoute, handler.BankAccountCallbackHandler).Methods("GET")
+	router.HandleFunc(consts.BankAccountsRoute, handler.ListBankAccountsHandler).Methods("GET")
+	router.HandleFunc(consts.BankAccountsRoute+"/{account_id}", handler.RevokeBankAccountHandler).Methods("DELETE")
+
+	// SEPA Direct Debit mandates deposits are debited under and withdrawals paid out to, managed by
+	// the users' merchant
+	router.Handle(consts.MandatesRoute, handler.authenticate(http.HandlerFunc(handler.CreateMandateHandler))).Methods("POST")
+	router.Handle(consts.MandatesRoute, handler.authenticate(http.HandlerFunc(handler.ListMandatesHandler))).Methods("GET")
+	router.Handle(consts.MandatesRoute+"/{mandate_id}", handler.authenticate(http.HandlerFunc(handler.RevokeMandateHandler))).Methods("DELETE")
+
+	// UPI virtual payment addresses, checked before a collect request is sent to them
+	router.HandleFunc(consts.UPIVPAValidateRoute, handler.ValidateVPAHandler).Methods("POST")
+
+	// Transfers between users' wallets, recorded in the wallet ledger only, and wallet balances, both
+	// made and read by the users' merchant
+	router.Handle(consts.TransfersRoute, handler.authenticate(http.HandlerFunc(handler.TransferHandler))).Methods("POST")
+	router.Handle(consts.TransfersRoute+"/{transfer_id}", handler.authenticate(http.HandlerFunc(handler.GetTransferHandler))).Methods("GET")
+	router.Handle(consts.WalletsRoute+"/{user_id}/balance", handler.authenticate(http.HandlerFunc(handler.WalletBalanceHandler))).Methods("GET")
+
+	// Rules topping up wallets under a SEPA mandate when their balance runs low, managed by the
+	// users' merchant
+	router.Handle(consts.AutoReloadRulesRoute, handler.authenticate(http.HandlerFunc(handler.CreateAutoReloadRuleHandler))).Methods("POST")
+	router.Handle(consts.AutoReloadRulesRoute, handler.authenticate(http.HandlerFunc(handler.ListAutoReloadRulesHandler))).Methods("GET")
+	router.Handle(consts.AutoReloadRulesRoute+"/{rule_id}", handler.authenticate(http.HandlerFunc(handler.CancelAutoReloadRuleHandler))).Methods("DELETE")
+	router.Handle(consts.AutoReloadRulesRoute+"/{rule_id}/enable", handler.authenticate(http.HandlerFunc(handler.EnableAutoReloadRuleHandler))).Methods("POST")
 
 	// Callback endpoint for each gateway
 	// The gateway_id parameter will be used to identify which gateway sent the callback
 	router.HandleFunc(consts.CallbackRoute+"/{gateway_id}", handler.CallbackHandler).Methods("POST")
 
-	// Health check endpoint
-	router.HandleFunc(consts.HealthRoute, handler.HealthCheckHandler).Methods("GET")
+	// OAuth2 client-credentials token endpoint
+	router.HandleFunc(consts.OAuthTokenRoute, handler.TokenHandler).Methods("POST")
 
-	return router
+	// Merchant self-service endpoints, authenticated with an API key or OAuth2 access token
+	merchant := router.PathPrefix(consts.MerchantAPIKeysRoute).Subrouter()
+	merchant.Use(handler.authenticate)
+	merchant.HandleFunc("", handler.ListAPIKeysHandler).Methods("GET")
+	merchant.HandleFunc("", handler.CreateAPIKeyHandler).Methods("POST")
+	merchant.HandleFunc("/{key_id}/roll", handler.RollAPIKeyHandler).Methods("POST")
+	merchant.HandleFunc("/{key_id}", handler.RevokeAPIKeyHandler).Methods("DELETE")
+
+	rules := router.PathPrefix(consts.MerchantRoutingRulesRoute).Subrouter()
+	rules.Use(handler.authenticate)
+	rules.HandleFunc("", handler.ListRoutingRulesHandler).Methods("GET")
+	rules.HandleFunc("", handler.ReplaceRoutingRulesHandler).Methods("PUT")
+	rules.HandleFunc("/simulate", handler.SimulateRoutingHandler).Methods("POST")
+
+	payoutSchedule := router.PathPrefix(consts.MerchantPayoutScheduleRoute).Subrouter()
+	payoutSchedule.Use(handler.authenticate)
+	payoutSchedule.HandleFunc("", handler.GetPayoutScheduleHandler).Methods("GET")
+	payoutSchedule.HandleFunc("", handler.SavePayoutScheduleHandler).Methods("PUT")
+	payoutSchedule.HandleFunc("", handler.DeletePayoutScheduleHandler).Methods("DELETE")
+
+	reports := router.PathPrefix(consts.MerchantReconciliationRoute).Subrouter()
+	reports.Use(handler.authenticate)
+	reports.HandleFunc("", handler.ListReconciliationFilesHandler).Methods("GET")
+	reports.HandleFunc("", handler.GenerateReconciliationFileHandler).Methods("POST")
+	reports.HandleFunc("/{file_id}", handler.GetReconciliationFileHandler).Methods("GET")
+	reports.HandleFunc("/{file_id}/download", handler.DownloadReconciliationFileHandler).Methods("GET")
+
+	transactions := router.PathPrefix(consts.MerchantTransactionsRoute).Subrouter()
+	transactions.Use(handler.authenticate)
+	transactions.HandleFunc("", handler.ListMerchantTransactionsHandler).Methods("GET")
+	transactions.HandleFunc("/{transaction_id}/tags", handler.GetTransactionTagsHandler).Methods("GET")
+	transactions.HandleFunc("/{transaction_id}/tags", handler.AddTransactionTagsHandler).Methods("POST")
+	transactions.HandleFunc("/{transaction_id}/tags/{tag}", handler.RemoveTransactionTagHandler).Methods("DELETE")
+
+	router.Handle(consts.MerchantReportsRoute+"/declines", handler.authenticate(http.HandlerFunc(handler.DeclineReportHandler))).Methods("GET")
+	router.Handle(consts.MerchantWebhookSecretRoute, handler.authenticate(http.HandlerFunc(handler.RollWebhookSecretHandler))).Methods("POST")
+
+	webhookEndpoints := router.PathPrefix(consts.MerchantWebhookEndpointsRoute).Subrouter()
+	webhookEndpoints.Use(handler.authenticate)
+	webhookEndpoints.HandleFunc("", handler.ListWebhookEndpointsHandler).Methods("GET")
+	webhookEndpoints.HandleFunc("", handler.CreateWebhookEndpointHandler).Methods("POST")
+	webhookEndpoints.HandleFunc("/{endpoint_id}", handler.UpdateWebhookEndpointHandler).Methods("PUT")
+	webhookEndpoints.HandleFunc("/{endpoint_id}", handler.DeleteWebhookEndpointHandler).Methods("DELETE")
+	router.Handle(consts.WebhookVerifyRoute, handler.authenticate(http.HandlerFunc(handler.VerifyWebhookHandler))).Methods("POST")
+
+	// Public keys gateways verify our request signatures with
+	router.HandleFunc(consts.JWKSRoute, handler.JWKSHandler).Methods("GET")
+
+	// Event documentation
+	router.HandleFunc(consts.AsyncAPIRoute, handler.AsyncAPIHandler).Methods("GET")
+}
+
+// registerAdminRoutes registers the admin endpoints
+func registerAdminRoutes(router *mux.Router, handler *Handler) {
+	router.HandleFunc(consts.AdminArchivalRoute, handler.ArchivalStatusHandler).Methods("GET")
+	router.HandleFunc(consts.AdminArchivalRoute, handler.StartArchivalHandler).Methods("POST")
+	router.HandleFunc(consts.AdminTransactionsRoute, handler.AdminListTransactionsHandler).Methods("GET")
+	router.HandleFunc(consts.AdminTransactionsRoute+"/{transaction_id}", handler.DeleteTransactionHandler).Methods("DELETE")
+	router.HandleFunc(consts.AdminTransactionsRoute+"/{transaction_id}/tags", handler.AdminGetTransactionTagsHandler).Methods("GET")
+	router.HandleFunc(consts.AdminTransactionsRoute+"/{transaction_id}/tags", handler.AdminAddTransactionTagsHandler).Methods("POST")
+	router.HandleFunc(consts.AdminTransactionsRoute+"/{transaction_id}/tags/{tag}", handler.AdminRemoveTransactionTagHandler).Methods("DELETE")
+	router.HandleFunc(consts.AdminTransactionsRoute+"/{transaction_id}/hold", handler.GetTransactionHoldHandler).Methods("GET")
+	router.HandleFunc(consts.AdminTransactionsRoute+"/{transaction_id}/hold", handler.HoldTransactionHandler).Methods("POST")
+	router.HandleFunc(consts.AdminTransactionsRoute+"/{transaction_id}/hold/release", handler.ReleaseTransactionHoldHandler).Methods("POST")
+	router.HandleFunc(consts.AdminTransactionsRoute+"/{transaction_id}/hold/extend", handler.ExtendTransactionHoldHandler).Methods("POST")
+	router.HandleFunc(consts.AdminHoldsRoute, handler.ListTransactionHoldsHandler).Methods("GET")
+	router.HandleFunc(consts.AdminSearchRoute, handler.SearchTransactionsHandler).Methods("GET")
+	router.HandleFunc(consts.AdminMetricsRoute+"/realtime", handler.RealtimeMetricsHandler).Methods("GET")
+	router.HandleFunc(consts.AdminMetricsRoute+"/http-clients", handler.HTTPClientStatsHandler).Methods("GET")
+	router.HandleFunc(consts.AdminMetricsRoute+"/http-server", handler.HTTPServerStatsHandler).Methods("GET")
+	router.HandleFunc(consts.AdminAnomaliesRoute, handler.AnomalyStatusHandler).Methods("GET")
+	router.HandleFunc(consts.AdminGatewaysRoute+"/{gateway_id}/webhook-secrets", handler.ListWebhookSecretsHandler).Methods("GET")
+	router.HandleFunc(consts.AdminGatewaysRoute+"/{gateway_id}/webhook-secrets", handler.AddWebhookSecretHandler).Methods("POST")
+	router.HandleFunc(consts.AdminGatewaysRoute+"/{gateway_id}/webhook-secrets/{secret_id}", handler.RetireWebhookSecretHandler).Methods("DELETE")
+	router.HandleFunc(consts.AdminGatewaysRoute+"/{gateway_id}/client-certificates", handler.ListClientCertificatesHandler).Methods("GET")
+	router.HandleFunc(consts.AdminGatewaysRoute+"/{gateway_id}/client-certificates", handler.AddClientCertificateHandler).Methods("POST")
+	router.HandleFunc(consts.AdminGatewaysRoute+"/{gateway_id}/client-certificates/{certificate_id}", handler.RetireClientCertificateHandler).Methods("DELETE")
+	router.HandleFunc(consts.AdminMerchantCertificatesRoute, handler.ListMerchantCertificatesHandler).Methods("GET")
+	router.HandleFunc(consts.AdminMerchantCertificatesRoute, handler.AddMerchantCertificateHandler).Methods("POST")
+	router.HandleFunc(consts.AdminMerchantCertificatesRoute+"/{certificate_id}", handler.RetireMerchantCertificateHandler).Methods("DELETE")
+	router.HandleFunc(consts.AdminGatewaysRoute+"/{gateway_id}/signing-keys", handler.ListSigningKeysHandler).Methods("GET")
+	router.HandleFunc(consts.AdminGatewaysRoute+"/{gateway_id}/signing-keys", handler.AddSigningKeyHandler).Methods("POST")
+	router.HandleFunc(consts.AdminGatewaysRoute+"/{gateway_id}/signing-keys/{key_id}", handler.RetireSigningKeyHandler).Methods("DELETE")
+	router.HandleFunc(consts.AdminGatewaysRoute+"/{gateway_id}/maintenance", handler.ListMaintenanceWindowsHandler).Methods("GET")
+	router.HandleFunc(consts.AdminGatewaysRoute+"/{gateway_id}/maintenance", handler.ScheduleMaintenanceWindowHandler).Methods("POST")
+	router.HandleFunc(consts.AdminGatewaysRoute+"/{gateway_id}/maintenance/{window_id}", handler.CancelMaintenanceWindowHandler).Methods("DELETE")
+	router.HandleFunc(consts.AdminRoutingRoute+"/simulate", handler.AdminSimulateRoutingHandler).Methods("POST")
+	router.HandleFunc(consts.AdminCountriesRoute+"/{country_code}/holidays", handler.ListBankHolidaysHandler).Methods("GET")
+	router.HandleFunc(consts.AdminCountriesRoute+"/{country_code}/holidays", handler.AddBankHolidayHandler).Methods("POST")
+	router.HandleFunc(consts.AdminCountriesRoute+"/{country_code}/holidays/{date}", handler.RemoveBankHolidayHandler).Methods("DELETE")
+	router.HandleFunc(consts.AdminCountriesRoute+"/{country_code}/compliance-fields", handler.ListComplianceFieldsHandler).Methods("GET")
+	router.HandleFunc(consts.AdminCountriesRoute+"/{country_code}/compliance-fields", handler.SetComplianceFieldsHandler).Methods("PUT")
+	router.HandleFunc(consts.AdminCountriesRoute+"/{country_code}/aml-thresholds", handler.ListAMLThresholdsHandler).Methods("GET")
+	router.HandleFunc(consts.AdminCountriesRoute+"/{country_code}/aml-thresholds/{currency}", handler.SetAMLThresholdHandler).Methods("PUT")
+	router.HandleFunc(consts.AdminCountriesRoute+"/{country_code}/aml-thresholds/{currency}", handler.DeleteAMLThresholdHandler).Methods("DELETE")
+	router.HandleFunc(consts.AdminAMLCasesRoute, handler.ListAMLCasesHandler).Methods("GET")
+	router.HandleFunc(consts.AdminAMLCasesRoute+"/export", handler.ExportAMLReportHandler).Methods("GET")
+	router.HandleFunc(consts.AdminAMLCasesRoute+"/{case_id}", handler.GetAMLCaseHandler).Methods("GET")
+	router.HandleFunc(consts.AdminAMLCasesRoute+"/{case_id}", handler.UpdateAMLCaseHandler).Methods("PUT")
+	router.HandleFunc(consts.AdminScreeningRoute, handler.ListScreeningCasesHandler).Methods("GET")
+	router.HandleFunc(consts.AdminScreeningRoute+"/{case_id}", handler.GetScreeningCaseHandler).Methods("GET")
+	router.HandleFunc(consts.AdminScreeningRoute+"/{case_id}", handler.DecideScreeningCaseHandler).Methods("PUT")
+	router.HandleFunc(consts.AdminDeclineCodesRoute, handler.ListDeclineRecoveryHintsHandler).Methods("GET")
+	router.HandleFunc(consts.AdminDeclineCodesRoute+"/{decline_code}", handler.SetDeclineRecoveryHintHandler).Methods("PUT")
+	router.HandleFunc(consts.AdminDisputesRoute, handler.ListDisputesHandler).Methods("GET")
+	router.HandleFunc(consts.AdminDisputesRoute+"/{dispute_id}", handler.UpdateDisputeHandler).Methods("PUT")
+	router.HandleFunc(consts.AdminCallbacksRoute+"/{callback_id}", handler.GetCallbackHandler).Methods("GET")
+	router.HandleFunc(consts.AdminCallbacksRoute+"/{callback_id}/reparse", handler.ReparseCallbackHandler).Methods("POST")
+	router.HandleFunc(consts.AdminOutboxRoute, handler.ListOutboxMessagesHandler).Methods("GET")
+	router.HandleFunc(consts.AdminOutboxRoute+"/{message_id}/retry", handler.RetryOutboxMessageHandler).Methods("POST")
+	router.HandleFunc(consts.AdminOutboxRoute+"/{message_id}/discard", handler.DiscardOutboxMessageHandler).Methods("POST")
+	router.HandleFunc(consts.AdminMerchantsRoute+"/{merchant_id}/api-keys", handler.AdminCreateAPIKeyHandler).Methods("POST")
+	router.HandleFunc(consts.AdminMerchantsRoute+"/{merchant_id}/oauth-clients", handler.CreateOAuthClientHandler).Methods("POST")
+	router.HandleFunc(consts.AdminMerchantsRoute+"/{merchant_id}/livemode", handler.SetMerchantLivemodeHandler).Methods("PUT")
+	router.HandleFunc(consts.AdminMerchantsRoute+"/{merchant_id}/fees", handler.ListMerchantFeesHandler).Methods("GET")
+	router.HandleFunc(consts.AdminMerchantsRoute+"/{merchant_id}/fees", handler.ReplaceMerchantFeesHandler).Methods("PUT")
+	router.HandleFunc(consts.AdminUsersRoute, handler.CreateUserHandler).Methods("POST")
+	router.HandleFunc(consts.AdminUsersRoute+"/{user_id}/contact", handler.UpdateUserContactHandler).Methods("PUT")
+	router.HandleFunc(consts.AdminUsersRoute+"/{user_id}/screening-events", handler.ListScreeningEventsHandler).Methods("GET")
 }
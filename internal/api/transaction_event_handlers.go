@@ -0,0 +1,49 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"payment-gateway/internal/services"
+	"payment-gateway/internal/utils"
+	"strconv"
+)
+
+// GetTransactionEventsHandler returns the audit trail of a user's transaction
+// @Summary Get transaction events
+// @Description Return the history of a user's transaction, oldest first: its creation and each status change with
+// @Description the message, decline code and gateway reference it carried. Entries are appended as the changes
+// @Description happen and never modified, for support investigations and chargeback evidence.
+// @Tags transactions
+// @Produce json,xml
+// @Param transaction_id path int true "Transaction ID"
+// @Param user_id query int true "User who made the transaction"
+// @Success 200 {array} models.TransactionEvent
+// @Failure 400 {object} models.APIResponse
+// @Failure 404 {object} models.APIResponse
+// @Failure 500 {object} models.APIResponse
+// @Router /transactions/{transaction_id}/events [get]
+func (h *Handler) GetTransactionEventsHandler(w http.ResponseWriter, r *http.Request) {
+	txID, ok := transactionID(w, r)
+	if !ok {
+		return
+	}
+
+	userID, err := strconv.Atoi(r.URL.Query().Get("user_id"))
+	if err != nil || userID <= 0 {
+		utils.SendErrorResponse(w, r, http.StatusBadRequest, "Invalid user ID")
+		return
+	}
+
+	history, err := h.transactionService.GetTransactionEvents(r.Context(), txID, userID)
+	if err != nil {
+		if errors.Is(err, services.ErrTransactionNotFound) {
+			utils.SendErrorResponse(w, r, http.StatusNotFound, fmt.Sprintf("Transaction not found: %d", txID))
+			return
+		}
+		utils.SendErrorResponse(w, r, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	utils.SendResponse(w, r, http.StatusOK, history)
+}
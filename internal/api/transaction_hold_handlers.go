@@ -0,0 +1,199 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"payment-gateway/internal/models"
+	"payment-gateway/internal/services"
+	"payment-gateway/internal/utils"
+	"payment-gateway/internal/validation"
+)
+
+// HoldTransactionHandler holds a completed deposit for fraud review
+// @Summary Hold a deposit for review
+// @Description Place a hold on a completed deposit, keeping its amount out of the user's spendable wallet balance until a reviewer releases it or release_at passes, 72 hours from now by default and at most 30 days. A deposit has at most one active hold.
+// @Tags admin
+// @Accept json,xml
+// @Produce json,xml
+// @Param transaction_id path int true "Transaction ID"
+// @Param hold body models.TransactionHoldRequest true "Hold"
+// @Success 201 {object} models.TransactionHold
+// @Failure 400 {object} models.APIResponse
+// @Failure 404 {object} models.APIResponse
+// @Failure 409 {object} models.APIResponse
+// @Failure 500 {object} models.APIResponse
+// @Router /admin/transactions/{transaction_id}/hold [post]
+func (h *Handler) HoldTransactionHandler(w http.ResponseWriter, r *http.Request) {
+	txID, ok := transactionID(w, r)
+	if !ok {
+		return
+	}
+
+	var request models.TransactionHoldRequest
+	if err := utils.DecodeRequest(r, &request); err != nil {
+		utils.SendDecodeError(w, r, err)
+		return
+	}
+	if err := validation.Struct(request); err != nil {
+		utils.SendValidationError(w, r, err)
+		return
+	}
+
+	hold, err := h.transactionService.HoldTransaction(r.Context(), txID, request)
+	if err != nil {
+		sendTransactionHoldError(w, r, txID, err)
+		return
+	}
+
+	utils.SendResponse(w, r, http.StatusCreated, hold)
+}
+
+// GetTransactionHoldHandler returns a transaction's latest hold
+// @Summary Get a transaction's hold
+// @Description Return the latest hold placed on a transaction, active or not
+// @Tags admin
+// @Produce json,xml
+// @Param transaction_id path int true "Transaction ID"
+// @Success 200 {object} models.TransactionHold
+// @Failure 400 {object} models.APIResponse
+// @Failure 404 {object} models.APIResponse
+// @Failure 500 {object} models.APIResponse
+// @Router /admin/transactions/{transaction_id}/hold [get]
+func (h *Handler) GetTransactionHoldHandler(w http.ResponseWriter, r *http.Request) {
+	txID, ok := transactionID(w, r)
+	if !ok {
+		return
+	}
+
+	hold, err := h.transactionService.GetTransactionHold(r.Context(), txID)
+	if err != nil {
+		sendTransactionHoldError(w, r, txID, err)
+		return
+	}
+
+	utils.SendResponse(w, r, http.StatusOK, hold)
+}
+
+// ReleaseTransactionHoldHandler releases a transaction's hold after review
+// @Summary Release a hold
+// @Description Release a transaction's active hold after review, making the deposit's amount spendable again
+// @Tags admin
+// @Accept json,xml
+// @Produce json,xml
+// @Param transaction_id path int true "Transaction ID"
+// @Param release body models.TransactionHoldReleaseRequest false "Reviewer's note"
+// @Success 200 {object} models.TransactionHold
+// @Failure 400 {object} models.APIResponse
+// @Failure 404 {object} models.APIResponse
+// @Failure 409 {object} models.APIResponse
+// @Failure 500 {object} models.APIResponse
+// @Router /admin/transactions/{transaction_id}/hold/release [post]
+func (h *Handler) ReleaseTransactionHoldHandler(w http.ResponseWriter, r *http.Request) {
+	txID, ok := transactionID(w, r)
+	if !ok {
+		return
+	}
+
+	var request models.TransactionHoldReleaseRequest
+	if r.ContentLength != 0 {
+		if err := utils.DecodeRequest(r, &request); err != nil {
+			utils.SendDecodeError(w, r, err)
+			return
+		}
+	}
+	if err := validation.Struct(request); err != nil {
+		utils.SendValidationError(w, r, err)
+		return
+	}
+
+	hold, err := h.transactionService.ReleaseTransactionHold(r.Context(), txID, request)
+	if err != nil {
+		sendTransactionHoldError(w, r, txID, err)
+		return
+	}
+
+	utils.SendResponse(w, r, http.StatusOK, hold)
+}
+
+// ExtendTransactionHoldHandler moves a transaction's hold deadline later
+// @Summary Extend a hold
+// @Description Move the deadline of a transaction's active hold later, to at most 30 days after it was placed
+// @Tags admin
+// @Accept json,xml
+// @Produce json,xml
+// @Param transaction_id path int true "Transaction ID"
+// @Param extend body models.TransactionHoldExtendRequest true "New deadline"
+// @Success 200 {object} models.TransactionHold
+// @Failure 400 {object} models.APIResponse
+// @Failure 404 {object} models.APIResponse
+// @Failure 409 {object} models.APIResponse
+// @Failure 500 {object} models.APIResponse
+// @Router /admin/transactions/{transaction_id}/hold/extend [post]
+func (h *Handler) ExtendTransactionHoldHandler(w http.ResponseWriter, r *http.Request) {
+	txID, ok := transactionID(w, r)
+	if !ok {
+		return
+	}
+
+	var request models.TransactionHoldExtendRequest
+	if err := utils.DecodeRequest(r, &request); err != nil {
+		utils.SendDecodeError(w, r, err)
+		return
+	}
+	if err := validation.Struct(request); err != nil {
+		utils.SendValidationError(w, r, err)
+		return
+	}
+
+	hold, err := h.transactionService.ExtendTransactionHold(r.Context(), txID, request)
+	if err != nil {
+		sendTransactionHoldError(w, r, txID, err)
+		return
+	}
+
+	utils.SendResponse(w, r, http.StatusOK, hold)
+}
+
+// ListTransactionHoldsHandler lists the hold review queue
+// @Summary List transaction holds
+// @Description List transaction holds with a status, oldest first
+// @Tags admin
+// @Produce json,xml
+// @Param status query string false "active (default), released or expired"
+// @Success 200 {array} models.TransactionHold
+// @Failure 400 {object} models.APIResponse
+// @Failure 500 {object} models.APIResponse
+// @Router /admin/holds [get]
+func (h *Handler) ListTransactionHoldsHandler(w http.ResponseWriter, r *http.Request) {
+	holds, err := h.transactionService.ListTransactionHolds(r.Context(), r.URL.Query().Get("status"))
+	if err != nil {
+		if errors.Is(err, services.ErrInvalidHoldQueue) {
+			utils.SendErrorResponse(w, r, http.StatusBadRequest, err.Error())
+			return
+		}
+		utils.SendErrorResponse(w, r, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	if holds == nil {
+		holds = []models.TransactionHold{}
+	}
+	utils.SendResponse(w, r, http.StatusOK, holds)
+}
+
+// sendTransactionHoldError answers a failed transaction hold request
+func sendTransactionHoldError(w http.ResponseWriter, r *http.Request, txID int, err error) {
+	switch {
+	case errors.Is(err, services.ErrTransactionNotFound):
+		utils.SendErrorResponse(w, r, http.StatusNotFound, fmt.Sprintf("Transaction not found: %d", txID))
+	case errors.Is(err, services.ErrHoldNotFound):
+		utils.SendErrorResponse(w, r, http.StatusNotFound, fmt.Sprintf("Transaction %d was never held", txID))
+	case errors.Is(err, services.ErrInvalidHold):
+		utils.SendErrorResponse(w, r, http.StatusBadRequest, err.Error())
+	case errors.Is(err, services.ErrNotHoldable), errors.Is(err, services.ErrTransactionHeld), errors.Is(err, services.ErrHoldClosed):
+		utils.SendErrorResponse(w, r, http.StatusConflict, err.Error())
+	default:
+		utils.SendErrorResponse(w, r, http.StatusInternalServerError, err.Error())
+	}
+}
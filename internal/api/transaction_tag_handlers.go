@@ -0,0 +1,285 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"payment-gateway/internal/auth"
+	"payment-gateway/internal/models"
+	"payment-gateway/internal/services"
+	"payment-gateway/internal/utils"
+	"payment-gateway/internal/validation"
+	"strconv"
+
+	"github.com/gorilla/mux"
+)
+
+// ListMerchantTransactionsHandler lists the calling merchant's transactions with their tags
+// @Summary List transactions
+// @Description List the merchant's transactions with their tags, newest first. Transactions carrying every tag given match. Dates are UTC and inclusive.
+// @Description Continue a list by passing the last ID of a page as before_id.
+// @Tags merchant
+// @Produce json,xml
+// @Security BearerAuth
+// @Param tag query []string false "Tag transactions must carry, e.g. campaign:blackfriday; may be repeated" collectionFormat(multi)
+// @Param status query string false "pending, scheduled, processing, authorized, completed, failed or voided"
+// @Param type query string false "deposit or withdrawal"
+// @Param from query string false "First day, YYYY-MM-DD"
+// @Param to query string false "Last day, YYYY-MM-DD"
+// @Param before_id query int false "List transactions with lower IDs"
+// @Param limit query int false "Page size, at most 200" default(50)
+// @Success 200 {array} models.TransactionSummary
+// @Failure 400 {object} models.APIResponse
+// @Failure 401 {object} models.APIResponse
+// @Failure 500 {object} models.APIResponse
+// @Router /merchant/transactions [get]
+func (h *Handler) ListMerchantTransactionsHandler(w http.ResponseWriter, r *http.Request) {
+	caller, _ := auth.FromContext(r.Context())
+	h.listTransactions(w, r, caller.MerchantID)
+}
+
+// GetTransactionTagsHandler returns the tags of one of the calling merchant's transactions
+// @Summary Get transaction tags
+// @Description Return the tags of one of the merchant's transactions, in alphabetical order
+// @Tags merchant
+// @Produce json,xml
+// @Security BearerAuth
+// @Param transaction_id path int true "Transaction ID"
+// @Success 200 {object} models.TransactionTags
+// @Failure 400 {object} models.APIResponse
+// @Failure 401 {object} models.APIResponse
+// @Failure 404 {object} models.APIResponse
+// @Failure 500 {object} models.APIResponse
+// @Router /merchant/transactions/{transaction_id}/tags [get]
+func (h *Handler) GetTransactionTagsHandler(w http.ResponseWriter, r *http.Request) {
+	caller, _ := auth.FromContext(r.Context())
+	h.getTransactionTags(w, r, caller.MerchantID)
+}
+
+// AddTransactionTagsHandler tags one of the calling merchant's transactions
+// @Summary Tag a transaction
+// @Description Attach tags to one of the merchant's transactions, keeping those it already carries. A tag is a key and a value separated by a colon, e.g. campaign:blackfriday, or a single label; tags are stored in lower case and a transaction carries at most 20.
+// @Tags merchant
+// @Accept json,xml
+// @Produce json,xml
+// @Security BearerAuth
+// @Param transaction_id path int true "Transaction ID"
+// @Param tags body models.TransactionTagsRequest true "Tags"
+// @Success 200 {object} models.TransactionTags
+// @Failure 400 {object} models.APIResponse
+// @Failure 401 {object} models.APIResponse
+// @Failure 403 {object} models.APIResponse
+// @Failure 404 {object} models.APIResponse
+// @Failure 500 {object} models.APIResponse
+// @Router /merchant/transactions/{transaction_id}/tags [post]
+func (h *Handler) AddTransactionTagsHandler(w http.ResponseWriter, r *http.Request) {
+	caller, _ := auth.FromContext(r.Context())
+	h.addTransactionTags(w, r, caller.MerchantID)
+}
+
+// RemoveTransactionTagHandler removes a tag from one of the calling merchant's transactions
+// @Summary Remove a transaction tag
+// @Description Remove a tag from one of the merchant's transactions
+// @Tags merchant
+// @Produce json,xml
+// @Security BearerAuth
+// @Param transaction_id path int true "Transaction ID"
+// @Param tag path string true "Tag"
+// @Success 200 {object} models.TransactionTags
+// @Failure 400 {object} models.APIResponse
+// @Failure 401 {object} models.APIResponse
+// @Failure 403 {object} models.APIResponse
+// @Failure 404 {object} models.APIResponse
+// @Failure 500 {object} models.APIResponse
+// @Router /merchant/transactions/{transaction_id}/tags/{tag} [delete]
+func (h *Handler) RemoveTransactionTagHandler(w http.ResponseWriter, r *http.Request) {
+	caller, _ := auth.FromContext(r.Context())
+	h.removeTransactionTag(w, r, caller.MerchantID)
+}
+
+// AdminListTransactionsHandler lists transactions of every merchant, or one, with their tags
+// @Summary List transactions
+// @Description List transactions with their tags, newest first. Transactions carrying every tag given match. Dates are UTC and inclusive.
+// @Description Continue a list by passing the last ID of a page as before_id.
+// @Tags admin
+// @Produce json,xml
+// @Param merchant_id query int false "Only the merchant's transactions"
+// @Param tag query []string false "Tag transactions must carry, e.g. campaign:blackfriday; may be repeated" collectionFormat(multi)
+// @Param status query string false "pending, scheduled, processing, authorized, completed, failed or voided"
+// @Param type query string false "deposit or withdrawal"
+// @Param from query string false "First day, YYYY-MM-DD"
+// @Param to query string false "Last day, YYYY-MM-DD"
+// @Param before_id query int false "List transactions with lower IDs"
+// @Param limit query int false "Page size, at most 200" default(50)
+// @Success 200 {array} models.TransactionSummary
+// @Failure 400 {object} models.APIResponse
+// @Failure 500 {object} models.APIResponse
+// @Router /admin/transactions [get]
+func (h *Handler) AdminListTransactionsHandler(w http.ResponseWriter, r *http.Request) {
+	merchantID := 0
+	if value := r.URL.Query().Get("merchant_id"); value != "" {
+		var err error
+		if merchantID, err = strconv.Atoi(value); err != nil || merchantID <= 0 {
+			utils.SendErrorResponse(w, r, http.StatusBadRequest, "Invalid merchant ID")
+			return
+		}
+	}
+	h.listTransactions(w, r, merchantID)
+}
+
+// AdminGetTransactionTagsHandler returns the tags of any transaction
+// @Summary Get transaction tags
+// @Description Return a transaction's tags, in alphabetical order
+// @Tags admin
+// @Produce json,xml
+// @Param transaction_id path int true "Transaction ID"
+// @Success 200 {object} models.TransactionTags
+// @Failure 400 {object} models.APIResponse
+// @Failure 404 {object} models.APIResponse
+// @Failure 500 {object} models.APIResponse
+// @Router /admin/transactions/{transaction_id}/tags [get]
+func (h *Handler) AdminGetTransactionTagsHandler(w http.ResponseWriter, r *http.Request) {
+	h.getTransactionTags(w, r, 0)
+}
+
+// AdminAddTransactionTagsHandler tags any transaction
+// @Summary Tag a transaction
+// @Description Attach tags to a transaction, keeping those it already carries. Tags are stored in lower case and a transaction carries at most 20.
+// @Tags admin
+// @Accept json,xml
+// @Produce json,xml
+// @Param transaction_id path int true "Transaction ID"
+// @Param tags body models.TransactionTagsRequest true "Tags"
+// @Success 200 {object} models.TransactionTags
+// @Failure 400 {object} models.APIResponse
+// @Failure 404 {object} models.APIResponse
+// @Failure 500 {object} models.APIResponse
+// @Router /admin/transactions/{transaction_id}/tags [post]
+func (h *Handler) AdminAddTransactionTagsHandler(w http.ResponseWriter, r *http.Request) {
+	h.addTransactionTags(w, r, 0)
+}
+
+// AdminRemoveTransactionTagHandler removes a tag from any transaction
+// @Summary Remove a transaction tag
+// @Description Remove a tag from a transaction
+// @Tags admin
+// @Produce json,xml
+// @Param transaction_id path int true "Transaction ID"
+// @Param tag path string true "Tag"
+// @Success 200 {object} models.TransactionTags
+// @Failure 400 {object} models.APIResponse
+// @Failure 404 {object} models.APIResponse
+// @Failure 500 {object} models.APIResponse
+// @Router /admin/transactions/{transaction_id}/tags/{tag} [delete]
+func (h *Handler) AdminRemoveTransactionTagHandler(w http.ResponseWriter, r *http.Request) {
+	h.removeTransactionTag(w, r, 0)
+}
+
+// listTransactions lists a merchant's transactions, or every merchant's for a merchantID of 0
+func (h *Handler) listTransactions(w http.ResponseWriter, r *http.Request, merchantID int) {
+	values := r.URL.Query()
+	query := models.TransactionListQuery{
+		Tags:   values["tag"],
+		Status: values.Get("status"),
+		Type:   values.Get("type"),
+		From:   values.Get("from"),
+		To:     values.Get("to"),
+	}
+	for name, target := range map[string]*int{"before_id": &query.BeforeID, "limit": &query.Limit} {
+		if value := values.Get(name); value != "" {
+			n, err := strconv.Atoi(value)
+			if err != nil {
+				utils.SendErrorResponse(w, r, http.StatusBadRequest, fmt.Sprintf("Invalid %s", name))
+				return
+			}
+			*target = n
+		}
+	}
+
+	transactions, err := h.transactionService.ListTransactions(r.Context(), merchantID, query)
+	if err != nil {
+		if errors.Is(err, services.ErrInvalidTransactionList) {
+			utils.SendErrorResponse(w, r, http.StatusBadRequest, err.Error())
+			return
+		}
+		utils.SendErrorResponse(w, r, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	if transactions == nil {
+		transactions = []models.TransactionSummary{}
+	}
+	utils.SendResponse(w, r, http.StatusOK, transactions)
+}
+
+// getTransactionTags returns the tags of a transaction of a merchant, or of any for a merchantID of 0
+func (h *Handler) getTransactionTags(w http.ResponseWriter, r *http.Request, merchantID int) {
+	txID, ok := transactionID(w, r)
+	if !ok {
+		return
+	}
+
+	tags, err := h.transactionService.GetTransactionTags(r.Context(), txID, merchantID)
+	if err != nil {
+		sendTransactionTagsError(w, r, txID, err)
+		return
+	}
+
+	utils.SendResponse(w, r, http.StatusOK, tags)
+}
+
+// addTransactionTags tags a transaction of a merchant, or any for a merchantID of 0
+func (h *Handler) addTransactionTags(w http.ResponseWriter, r *http.Request, merchantID int) {
+	txID, ok := transactionID(w, r)
+	if !ok {
+		return
+	}
+
+	var request models.TransactionTagsRequest
+	if err := utils.DecodeRequest(r, &request); err != nil {
+		utils.SendDecodeError(w, r, err)
+		return
+	}
+	if err := validation.Struct(request); err != nil {
+		utils.SendValidationError(w, r, err)
+		return
+	}
+
+	tags, err := h.transactionService.AddTransactionTags(r.Context(), txID, merchantID, request)
+	if err != nil {
+		sendTransactionTagsError(w, r, txID, err)
+		return
+	}
+
+	utils.SendResponse(w, r, http.StatusOK, tags)
+}
+
+// removeTransactionTag removes a tag from a transaction of a merchant, or any for a merchantID of 0
+func (h *Handler) removeTransactionTag(w http.ResponseWriter, r *http.Request, merchantID int) {
+	txID, ok := transactionID(w, r)
+	if !ok {
+		return
+	}
+
+	tags, err := h.transactionService.RemoveTransactionTag(r.Context(), txID, merchantID, mux.Vars(r)["tag"])
+	if err != nil {
+		sendTransactionTagsError(w, r, txID, err)
+		return
+	}
+
+	utils.SendResponse(w, r, http.StatusOK, tags)
+}
+
+// sendTransactionTagsError answers a failed transaction tag request
+func sendTransactionTagsError(w http.ResponseWriter, r *http.Request, txID int, err error) {
+	switch {
+	case errors.Is(err, services.ErrTransactionNotFound):
+		utils.SendErrorResponse(w, r, http.StatusNotFound, fmt.Sprintf("Transaction not found: %d", txID))
+	case errors.Is(err, services.ErrTransactionTagNotFound):
+		utils.SendErrorResponse(w, r, http.StatusNotFound, err.Error())
+	case errors.Is(err, services.ErrInvalidTransactionTags):
+		utils.SendErrorResponse(w, r, http.StatusBadRequest, err.Error())
+	default:
+		utils.SendErrorResponse(w, r, http.StatusInternalServerError, err.Error())
+	}
+}
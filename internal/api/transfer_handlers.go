@@ -0,0 +1,153 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"payment-gateway/internal/auth"
+	"payment-gateway/internal/models"
+	"payment-gateway/internal/services"
+	"payment-gateway/internal/utils"
+	"payment-gateway/internal/validation"
+	"strconv"
+
+	"github.com/gorilla/mux"
+)
+
+// TransferHandler moves funds between two of the calling merchant's users' wallets
+// @Summary Transfer between wallets
+// @Description Move funds from one of the calling merchant's users' wallets to another's. The transfer never reaches a gateway: it is recorded in the wallet ledger only.
+// @Description It completes when the sender's balance covers it and it is within their limits. Declined transfers are returned with status failed and a decline_code.
+// @Description Merchants receive a transfer.completed or transfer.failed webhook.
+// @Tags wallets
+// @Accept json,xml
+// @Produce json,xml
+// @Security BearerAuth
+// @Param transfer body models.TransferRequest true "Transfer"
+// @Success 200 {object} models.Transfer
+// @Failure 400 {object} models.APIResponse
+// @Failure 401 {object} models.APIResponse
+// @Failure 403 {object} models.APIResponse
+// @Failure 404 {object} models.APIResponse
+// @Failure 500 {object} models.APIResponse
+// @Router /transfers [post]
+func (h *Handler) TransferHandler(w http.ResponseWriter, r *http.Request) {
+	caller, _ := auth.FromContext(r.Context())
+
+	var request models.TransferRequest
+	if err := utils.DecodeRequest(r, &request); err != nil {
+		utils.SendDecodeError(w, r, err)
+		return
+	}
+	if err := validation.Struct(request); err != nil {
+		utils.SendValidationError(w, r, err)
+		return
+	}
+
+	transfer, err := h.transactionService.Transfer(r.Context(), caller.MerchantID, request)
+
+	var fieldErrs validation.Errors
+	if errors.As(err, &fieldErrs) {
+		utils.SendValidationError(w, r, err)
+		return
+	}
+	if err != nil {
+		switch {
+		case errors.Is(err, services.ErrUserNotFound):
+			utils.SendErrorResponse(w, r, http.StatusNotFound, fmt.Sprintf("User not found: %d", request.FromUserID))
+		case errors.Is(err, services.ErrTransferRecipientNotFound):
+			utils.SendErrorResponse(w, r, http.StatusNotFound, fmt.Sprintf("Recipient not found: %d", request.ToUserID))
+		case errors.Is(err, services.ErrInvalidTransfer):
+			utils.SendErrorResponse(w, r, http.StatusBadRequest, err.Error())
+		default:
+			utils.SendErrorResponse(w, r, errorStatus(err), fmt.Sprintf("Failed to process transfer: %v", err))
+		}
+		return
+	}
+
+	utils.SendResponse(w, r, http.StatusOK, transfer)
+}
+
+// GetTransferHandler returns a transfer one of the calling merchant's users sent or received
+// @Summary Get a transfer
+// @Description Return a wallet transfer one of the calling merchant's users sent or received
+// @Tags wallets
+// @Produce json,xml
+// @Security BearerAuth
+// @Param transfer_id path int true "Transfer ID"
+// @Param user_id query int true "User who sent or received the transfer"
+// @Success 200 {object} models.Transfer
+// @Failure 400 {object} models.APIResponse
+// @Failure 401 {object} models.APIResponse
+// @Failure 404 {object} models.APIResponse
+// @Failure 500 {object} models.APIResponse
+// @Router /transfers/{transfer_id} [get]
+func (h *Handler) GetTransferHandler(w http.ResponseWriter, r *http.Request) {
+	caller, _ := auth.FromContext(r.Context())
+
+	transferID, err := strconv.Atoi(mux.Vars(r)["transfer_id"])
+	if err != nil || transferID <= 0 {
+		utils.SendErrorResponse(w, r, http.StatusBadRequest, "Invalid transfer ID")
+		return
+	}
+	userID, err := strconv.Atoi(r.URL.Query().Get("user_id"))
+	if err != nil || userID <= 0 {
+		utils.SendErrorResponse(w, r, http.StatusBadRequest, "Invalid user ID")
+		return
+	}
+
+	transfer, err := h.transactionService.GetTransfer(r.Context(), caller.MerchantID, transferID, userID)
+	if err != nil {
+		if errors.Is(err, services.ErrTransferNotFound) {
+			utils.SendErrorResponse(w, r, http.StatusNotFound, fmt.Sprintf("Transfer not found: %d", transferID))
+			return
+		}
+		utils.SendErrorResponse(w, r, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	utils.SendResponse(w, r, http.StatusOK, transfer)
+}
+
+// WalletBalanceHandler returns the wallet balance of one of the calling merchant's users in a currency
+// @Summary Get wallet balance
+// @Description Return the spendable wallet balance of one of the calling merchant's users in a currency: completed deposits and transfers received, less withdrawals that have not failed, transfers sent and deposits held for review, which are reported as held.
+// @Description The balance is live or sandbox as the user's merchant currently is.
+// @Tags wallets
+// @Produce json,xml
+// @Security BearerAuth
+// @Param user_id path int true "User ID"
+// @Param currency query string true "ISO 4217 currency code"
+// @Success 200 {object} models.WalletBalance
+// @Failure 400 {object} models.APIResponse
+// @Failure 401 {object} models.APIResponse
+// @Failure 403 {object} models.APIResponse
+// @Failure 404 {object} models.APIResponse
+// @Failure 500 {object} models.APIResponse
+// @Router /wallets/{user_id}/balance [get]
+func (h *Handler) WalletBalanceHandler(w http.ResponseWriter, r *http.Request) {
+	caller, _ := auth.FromContext(r.Context())
+
+	userID, err := strconv.Atoi(mux.Vars(r)["user_id"])
+	if err != nil || userID <= 0 {
+		utils.SendErrorResponse(w, r, http.StatusBadRequest, "Invalid user ID")
+		return
+	}
+	currency := r.URL.Query().Get("currency")
+	if !validation.IsCurrency(currency) {
+		utils.SendErrorResponse(w, r, http.StatusBadRequest, "Invalid currency")
+		return
+	}
+
+	balance, err := h.transactionService.GetWalletBalance(r.Context(), caller.MerchantID, userID, currency)
+	if err != nil {
+		if errors.Is(err, services.ErrUserNotFound) {
+			utils.SendErrorResponse(w, r, http.StatusNotFound, fmt.Sprintf("User not found: %d", userID))
+			return
+		}
+		utils.SendErrorResponse(w, r, errorStatus(err), err.Error())
+		return
+	}
+
+	utils.SendResponse(w, r, http.StatusOK, balance)
+}
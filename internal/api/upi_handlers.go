@@ -0,0 +1,55 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"payment-gateway/internal/models"
+	"payment-gateway/internal/services"
+	"payment-gateway/internal/utils"
+	"payment-gateway/internal/validation"
+)
+
+// ValidateVPAHandler asks a UPI gateway whether a virtual payment address exists
+// @Summary Validate a VPA
+// @Description Ask a UPI gateway whether a virtual payment address exists and the name of its holder, for the customer to confirm
+// @Description before a deposit naming it as vpa sends it a collect request. The gateway is asked in the environment of the user's merchant.
+// @Description An address that doesn't exist is reported with valid false.
+// @Tags upi
+// @Accept json,xml
+// @Produce json,xml
+// @Param request body models.VPAValidationRequest true "VPA"
+// @Success 200 {object} models.VPAValidation
+// @Failure 400 {object} models.APIResponse
+// @Failure 403 {object} models.APIResponse
+// @Failure 404 {object} models.APIResponse
+// @Failure 500 {object} models.APIResponse
+// @Router /upi/vpa/validate [post]
+func (h *Handler) ValidateVPAHandler(w http.ResponseWriter, r *http.Request) {
+	var request models.VPAValidationRequest
+	if err := utils.DecodeRequest(r, &request); err != nil {
+		utils.SendDecodeError(w, r, err)
+		return
+	}
+	if err := validation.Struct(request); err != nil {
+		utils.SendValidationError(w, r, err)
+		return
+	}
+
+	result, err := h.transactionService.ValidateVPA(r.Context(), request)
+	if err != nil {
+		switch {
+		case errors.Is(err, services.ErrUserNotFound):
+			utils.SendErrorResponse(w, r, http.StatusNotFound, fmt.Sprintf("User not found: %d", request.UserID))
+		case errors.Is(err, services.ErrUPIGatewayNotFound):
+			utils.SendErrorResponse(w, r, http.StatusNotFound, "No gateway takes UPI payments")
+		case errors.Is(err, services.ErrInvalidUPIPayment):
+			utils.SendErrorResponse(w, r, http.StatusBadRequest, err.Error())
+		default:
+			utils.SendErrorResponse(w, r, errorStatus(err), err.Error())
+		}
+		return
+	}
+
+	utils.SendResponse(w, r, http.StatusOK, result)
+}
@@ -0,0 +1,128 @@
+// Package auth identifies the merchant behind an API request and the roles it was granted,
+// whether it authenticated with an API key or an OAuth2 access token.
+package auth
+
+import (
+	"context"
+	"fmt"
+	"payment-gateway/internal/consts"
+	"payment-gateway/internal/models"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Authentication methods reported in Principal.Method
+const (
+	MethodAPIKey = "api_key"
+	MethodOAuth2 = "oauth2"
+)
+
+// Principal is an authenticated merchant caller
+type Principal struct {
+	MerchantID int
+	Subject    string // API key prefix or OAuth2 client ID
+	Method     string
+	Roles      []string
+	ExpiresAt  time.Time // zero for API keys without an expiry
+}
+
+// HasRole reports whether the caller was granted a role
+func (p *Principal) HasRole(role string) bool {
+	for _, r := range p.Roles {
+		if r == role {
+			return true
+		}
+	}
+	return false
+}
+
+// APIKeyPrincipal returns the caller authenticated by an API key
+func APIKeyPrincipal(key *models.APIKey) *Principal {
+	return &Principal{
+		MerchantID: key.MerchantID,
+		Subject:    key.Prefix,
+		Method:     MethodAPIKey,
+		Roles:      APIKeyRoles.Roles(key.Scope),
+		ExpiresAt:  key.ExpiresAt,
+	}
+}
+
+// RequiredRole returns the role needed to make a request with the given HTTP method
+func RequiredRole(method string) string {
+	if method == "GET" || method == "HEAD" {
+		return consts.RoleViewer
+	}
+	return consts.RoleOperator
+}
+
+type contextKey struct{}
+
+// NewContext returns a context carrying an authenticated caller
+func NewContext(ctx context.Context, principal *Principal) context.Context {
+	return context.WithValue(ctx, contextKey{}, principal)
+}
+
+// FromContext returns the authenticated caller of a request, if any
+func FromContext(ctx context.Context) (*Principal, bool) {
+	principal, ok := ctx.Value(contextKey{}).(*Principal)
+	return principal, ok
+}
+
+// ScopeRoles maps OAuth2 scopes to the roles they grant
+type ScopeRoles map[string][]string
+
+// DefaultScopeRoles grants viewers read access and operators write access
+var DefaultScopeRoles = ScopeRoles{
+	consts.OAuthScopePaymentsRead:  {consts.RoleViewer},
+	consts.OAuthScopePaymentsWrite: {consts.RoleViewer, consts.RoleOperator},
+}
+
+// APIKeyRoles maps API key scopes to the roles they grant
+var APIKeyRoles = ScopeRoles{
+	consts.APIKeyScopeReadOnly: {consts.RoleViewer},
+	consts.APIKeyScopeFull:     {consts.RoleViewer, consts.RoleOperator},
+}
+
+// Roles returns the distinct roles granted by a set of scopes, ignoring unknown scopes
+func (m ScopeRoles) Roles(scopes ...string) []string {
+	seen := make(map[string]bool)
+	var roles []string
+	for _, scope := range scopes {
+		for _, role := range m[scope] {
+			if !seen[role] {
+				seen[role] = true
+				roles = append(roles, role)
+			}
+		}
+	}
+	sort.Strings(roles)
+	return roles
+}
+
+// ParseScopeRoles parses a comma-separated list of SCOPE=ROLE pairs, e.g.
+// "payments:read=viewer,payments:write=viewer,payments:write=operator". A scope may be listed
+// once per role it grants.
+func ParseScopeRoles(spec string) (ScopeRoles, error) {
+	roles := ScopeRoles{}
+
+	for _, entry := range strings.Split(spec, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		scope, role, ok := strings.Cut(entry, "=")
+		scope, role = strings.TrimSpace(scope), strings.TrimSpace(role)
+		if !ok || scope == "" || role == "" {
+			return nil, fmt.Errorf("invalid entry %q: expected SCOPE=ROLE", entry)
+		}
+		if role != consts.RoleViewer && role != consts.RoleOperator {
+			return nil, fmt.Errorf("unknown role %q", role)
+		}
+
+		roles[scope] = append(roles[scope], role)
+	}
+
+	return roles, nil
+}
@@ -0,0 +1,287 @@
+package auth
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"payment-gateway/internal/consts"
+	"reflect"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+var testHMACKey = []byte("0123456789abcdef0123456789abcdef")
+
+// validClaims returns the claims of an unexpired token for merchant 7
+func validClaims(issuer string) map[string]interface{} {
+	return map[string]interface{}{
+		"iss":         issuer,
+		"sub":         "pgc_client",
+		"merchant_id": 7,
+		"scope":       "payments:read",
+		"exp":         time.Now().Add(time.Hour).Unix(),
+	}
+}
+
+// signRS256 creates a JWT signed with an RSA key
+func signRS256(t *testing.T, key *rsa.PrivateKey, kid string, claims map[string]interface{}) string {
+	header, _ := json.Marshal(tokenHeader{Alg: AlgRS256, Kid: kid, Typ: "JWT"})
+	payload, _ := json.Marshal(claims)
+	signingInput := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+
+	digest := sha256.Sum256([]byte(signingInput))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:])
+	if err != nil {
+		t.Fatalf("Failed to sign token: %v", err)
+	}
+	return signingInput + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+// TestVerifyHS256 tests that tokens from our own issuer verify and map scopes to roles
+func TestVerifyHS256(t *testing.T) {
+	verifier := NewVerifier(DefaultScopeRoles, Issuer{Name: "payment-gateway", HMACKey: testHMACKey})
+	ctx := context.Background()
+
+	token, err := SignHS256(testHMACKey, validClaims("payment-gateway"))
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+
+	principal, err := verifier.Verify(ctx, token)
+	if err != nil {
+		t.Fatalf("Expected token to verify, got: %v", err)
+	}
+	if principal.MerchantID != 7 || principal.Subject != "pgc_client" || principal.Method != MethodOAuth2 {
+		t.Errorf("Unexpected principal: %+v", principal)
+	}
+	if !principal.HasRole(consts.RoleViewer) || principal.HasRole(consts.RoleOperator) {
+		t.Errorf("Expected payments:read to grant only the viewer role, got %v", principal.Roles)
+	}
+
+	tests := []struct {
+		name   string
+		key    []byte
+		claims func(map[string]interface{})
+	}{
+		{"wrong key", []byte("another-key-0123456789abcdef0123"), func(map[string]interface{}) {}},
+		{"expired", testHMACKey, func(c map[string]interface{}) { c["exp"] = time.Now().Add(-time.Hour).Unix() }},
+		{"no expiry", testHMACKey, func(c map[string]interface{}) { delete(c, "exp") }},
+		{"untrusted issuer", testHMACKey, func(c map[string]interface{}) { c["iss"] = "someone-else" }},
+		{"no merchant", testHMACKey, func(c map[string]interface{}) { delete(c, "merchant_id") }},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			claims := validClaims("payment-gateway")
+			tt.claims(claims)
+			token, _ := SignHS256(tt.key, claims)
+
+			if _, err := verifier.Verify(ctx, token); !errors.Is(err, ErrInvalidToken) {
+				t.Errorf("Expected ErrInvalidToken, got: %v", err)
+			}
+		})
+	}
+}
+
+// TestVerifyJWKS tests that tokens from an external identity provider verify against its
+// published keys, that rotated keys are picked up and that HS256 tokens are refused
+func TestVerifyJWKS(t *testing.T) {
+	oldKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	newKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+
+	var published atomic.Value
+	published.Store(map[string]*rsa.PrivateKey{"old": oldKey})
+	var fetches int32
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&fetches, 1)
+		var keys []map[string]string
+		for kid, key := range published.Load().(map[string]*rsa.PrivateKey) {
+			keys = append(keys, map[string]string{
+				"kty": "RSA",
+				"kid": kid,
+				"use": "sig",
+				"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+				"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+			})
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"keys": keys})
+	}))
+	defer server.Close()
+
+	jwks := NewJWKS(server.URL)
+	verifier := NewVerifier(DefaultScopeRoles, Issuer{
+		Name:          "https://idp.example.com",
+		Audience:      "payments-api",
+		JWKS:          jwks,
+		MerchantClaim: "https://example.com/merchant",
+	})
+	ctx := context.Background()
+
+	claims := validClaims("https://idp.example.com")
+	delete(claims, "merchant_id")
+	claims["https://example.com/merchant"] = "7"
+	claims["aud"] = []string{"payments-api"}
+	claims["scope"] = "payments:write"
+
+	principal, err := verifier.Verify(ctx, signRS256(t, oldKey, "old", claims))
+	if err != nil {
+		t.Fatalf("Expected token to verify, got: %v", err)
+	}
+	if principal.MerchantID != 7 || !principal.HasRole(consts.RoleOperator) {
+		t.Errorf("Unexpected principal: %+v", principal)
+	}
+
+	// A key rotated in after the last fetch is found by refetching, once the minimum
+	// refresh interval has passed
+	published.Store(map[string]*rsa.PrivateKey{"old": oldKey, "new": newKey})
+	jwks.fetchedAt = time.Now().Add(-consts.JWKSMinRefreshInterval)
+	if _, err := verifier.Verify(ctx, signRS256(t, newKey, "new", claims)); err != nil {
+		t.Errorf("Expected token signed with rotated key to verify, got: %v", err)
+	}
+	if got := atomic.LoadInt32(&fetches); got != 2 {
+		t.Errorf("Expected 2 JWKS fetches, got %d", got)
+	}
+
+	claims["aud"] = "another-api"
+	if _, err := verifier.Verify(ctx, signRS256(t, oldKey, "old", claims)); !errors.Is(err, ErrInvalidToken) {
+		t.Errorf("Expected token for another audience to be rejected, got: %v", err)
+	}
+
+	// HS256 tokens are refused from issuers verified by JWKS, so public keys can't be used as secrets
+	claims["aud"] = "payments-api"
+	hsToken, _ := SignHS256(testHMACKey, claims)
+	if _, err := verifier.Verify(ctx, hsToken); !errors.Is(err, ErrInvalidToken) {
+		t.Errorf("Expected HS256 token to be rejected, got: %v", err)
+	}
+}
+
+// TestTokenCache tests that cached tokens are returned until they expire
+func TestTokenCache(t *testing.T) {
+	cache := NewTokenCache(2)
+
+	cache.Put("a", &Principal{MerchantID: 1, ExpiresAt: time.Now().Add(time.Hour)})
+	cache.Put("b", &Principal{MerchantID: 2, ExpiresAt: time.Now().Add(-time.Second)})
+
+	if principal, ok := cache.Get("a"); !ok || principal.MerchantID != 1 {
+		t.Errorf("Expected cached principal for merchant 1, got %+v", principal)
+	}
+	if _, ok := cache.Get("b"); ok {
+		t.Error("Expected expired token to miss")
+	}
+
+	// Adding beyond capacity evicts so the cache stays bounded
+	cache.Put("c", &Principal{MerchantID: 3, ExpiresAt: time.Now().Add(time.Hour)})
+	cache.Put("d", &Principal{MerchantID: 4, ExpiresAt: time.Now().Add(time.Hour)})
+	if len(cache.entries) > 2 {
+		t.Errorf("Expected at most 2 cached tokens, got %d", len(cache.entries))
+	}
+	if _, ok := cache.Get("d"); !ok {
+		t.Error("Expected the newest token to be cached")
+	}
+}
+
+// TestParseScopeRoles tests parsing of the scope to role mapping
+func TestParseScopeRoles(t *testing.T) {
+	roles, err := ParseScopeRoles("reports=viewer, payments=viewer,payments=operator")
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+
+	want := ScopeRoles{"reports": {"viewer"}, "payments": {"viewer", "operator"}}
+	if !reflect.DeepEqual(roles, want) {
+		t.Errorf("Expected %v, got %v", want, roles)
+	}
+	if got := roles.Roles("reports", "payments", "unknown"); !reflect.DeepEqual(got, []string{"operator", "viewer"}) {
+		t.Errorf("Expected operator and viewer, got %v", got)
+	}
+
+	for _, spec := range []string{"payments", "payments=admin", "=viewer"} {
+		if _, err := ParseScopeRoles(spec); err == nil {
+			t.Errorf("Expected error for %q", spec)
+		}
+	}
+}
+
+// TestSignPublishedKeys tests that JWTs and detached JWS signed with RSA and P-256 keys verify
+// against the keys' published JSON Web Keys
+func TestSignPublishedKeys(t *testing.T) {
+	rsaKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	ecKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	ecDER, _ := x509.MarshalECPrivateKey(ecKey)
+
+	ecSigner, err := ParsePrivateKey(pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: ecDER}))
+	if err != nil {
+		t.Fatalf("Expected the EC key to parse, got: %v", err)
+	}
+	weakKey, _ := rsa.GenerateKey(rand.Reader, 1024)
+	if _, err := ParsePrivateKey(pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(weakKey)})); !errors.Is(err, ErrUnsupportedKey) {
+		t.Errorf("Expected a 1024-bit RSA key to be refused, got: %v", err)
+	}
+
+	var set JSONWebKeySet
+	for kid, key := range map[string]crypto.PublicKey{"rsa-1": rsaKey.Public(), "ec-1": ecSigner.Public()} {
+		jwk, err := NewJSONWebKey(kid, key)
+		if err != nil {
+			t.Fatalf("Expected a JSON Web Key, got: %v", err)
+		}
+		set.Keys = append(set.Keys, jwk)
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(set)
+	}))
+	defer server.Close()
+
+	verifier := NewVerifier(DefaultScopeRoles, Issuer{Name: "https://bank.example.com", JWKS: NewJWKS(server.URL)})
+	ctx := context.Background()
+
+	for kid, signer := range map[string]crypto.Signer{"rsa-1": rsaKey, "ec-1": ecSigner} {
+		token, err := Sign(signer, kid, validClaims("https://bank.example.com"))
+		if err != nil {
+			t.Fatalf("Expected no error signing with %s, got: %v", kid, err)
+		}
+		if _, err := verifier.Verify(ctx, token); err != nil {
+			t.Errorf("Expected the token signed with %s to verify, got: %v", kid, err)
+		}
+
+		body := []byte(`{"amount":"10.00"}`)
+		signature, err := SignDetached(signer, kid, body, map[string]interface{}{"typ": "JOSE"})
+		if err != nil {
+			t.Fatalf("Expected no error signing with %s, got: %v", kid, err)
+		}
+		parts := strings.Split(signature, ".")
+		if len(parts) != 3 || parts[1] != "" {
+			t.Fatalf("Expected a detached JWS, got %q", signature)
+		}
+
+		parsed, err := parseToken(parts[0] + "." + base64.RawURLEncoding.EncodeToString(body) + "." + parts[2])
+		if err != nil {
+			t.Fatalf("Expected the attached JWS to parse, got: %v", err)
+		}
+		key, _ := NewJWKS(server.URL).Key(ctx, parsed.header.Kid)
+		if err := verifySignature(parsed, key); err != nil {
+			t.Errorf("Expected the signature of the body with %s to verify, got: %v", kid, err)
+		}
+	}
+
+	first, _ := Thumbprint(ecSigner.Public())
+	second, _ := Thumbprint(ecKey.Public())
+	other, _ := Thumbprint(rsaKey.Public())
+	if first != second || first == other || len(first) != 43 {
+		t.Errorf("Expected a stable SHA-256 thumbprint per key, got %q, %q and %q", first, second, other)
+	}
+}
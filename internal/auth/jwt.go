@@ -0,0 +1,230 @@
+package auth
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/hmac"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Supported JWT signing algorithms
+const (
+	AlgHS256 = "HS256"
+	AlgRS256 = "RS256"
+	AlgES256 = "ES256"
+)
+
+// ErrInvalidToken is returned for access tokens that are malformed, badly signed, expired or
+// issued by an untrusted issuer
+var ErrInvalidToken = errors.New("invalid access token")
+
+// tokenHeader is the JOSE header of a JWT
+type tokenHeader struct {
+	Alg string `json:"alg"`
+	Kid string `json:"kid,omitempty"`
+	Typ string `json:"typ,omitempty"`
+}
+
+// Claims are the claims of a verified access token
+type Claims struct {
+	Issuer    string
+	Subject   string
+	Audience  []string
+	ExpiresAt time.Time
+	NotBefore time.Time
+	IssuedAt  time.Time
+	Scopes    []string
+
+	// Raw holds every claim, including those not mapped above
+	Raw map[string]interface{}
+}
+
+// String returns the claim as a string, converting numbers
+func (c *Claims) String(name string) string {
+	switch v := c.Raw[name].(type) {
+	case string:
+		return v
+	case json.Number:
+		return v.String()
+	}
+	return ""
+}
+
+// SignHS256 creates a JWT signed with HMAC-SHA256
+func SignHS256(key []byte, claims map[string]interface{}) (string, error) {
+	header, err := json.Marshal(tokenHeader{Alg: AlgHS256, Typ: "JWT"})
+	if err != nil {
+		return "", err
+	}
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+
+	signingInput := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(signingInput))
+
+	return signingInput + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil)), nil
+}
+
+// parsedToken is a JWT split into its parts, not yet verified
+type parsedToken struct {
+	header       tokenHeader
+	claims       *Claims
+	signingInput string
+	signature    []byte
+}
+
+// parseToken decodes a compact JWT without verifying it
+func parseToken(token string) (*parsedToken, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, fmt.Errorf("%w: expected three segments", ErrInvalidToken)
+	}
+
+	var header tokenHeader
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return nil, fmt.Errorf("%w: header: %v", ErrInvalidToken, err)
+	}
+
+	raw := make(map[string]interface{})
+	if err := decodeSegment(parts[1], &raw); err != nil {
+		return nil, fmt.Errorf("%w: claims: %v", ErrInvalidToken, err)
+	}
+
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, fmt.Errorf("%w: signature: %v", ErrInvalidToken, err)
+	}
+
+	claims, err := mapClaims(raw)
+	if err != nil {
+		return nil, err
+	}
+
+	return &parsedToken{
+		header:       header,
+		claims:       claims,
+		signingInput: parts[0] + "." + parts[1],
+		signature:    signature,
+	}, nil
+}
+
+// decodeSegment decodes a base64url JSON segment, keeping numbers exact
+func decodeSegment(segment string, v interface{}) error {
+	data, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return err
+	}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	return decoder.Decode(v)
+}
+
+// mapClaims extracts the registered claims and scopes. Scopes are read from the space-separated
+// "scope" claim or, as some identity providers send them, the "scp" claim.
+func mapClaims(raw map[string]interface{}) (*Claims, error) {
+	claims := &Claims{Raw: raw}
+	claims.Issuer, _ = raw["iss"].(string)
+	claims.Subject, _ = raw["sub"].(string)
+
+	switch aud := raw["aud"].(type) {
+	case string:
+		claims.Audience = []string{aud}
+	case []interface{}:
+		for _, a := range aud {
+			if s, ok := a.(string); ok {
+				claims.Audience = append(claims.Audience, s)
+			}
+		}
+	}
+
+	for name, dst := range map[string]*time.Time{"exp": &claims.ExpiresAt, "nbf": &claims.NotBefore, "iat": &claims.IssuedAt} {
+		value, present := raw[name]
+		if !present {
+			continue
+		}
+		number, ok := value.(json.Number)
+		if !ok {
+			return nil, fmt.Errorf("%w: %s is not a number", ErrInvalidToken, name)
+		}
+		seconds, err := strconv.ParseFloat(number.String(), 64)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %s: %v", ErrInvalidToken, name, err)
+		}
+		*dst = time.Unix(int64(seconds), 0)
+	}
+
+	switch scope := raw["scope"].(type) {
+	case string:
+		claims.Scopes = strings.Fields(scope)
+	}
+	if claims.Scopes == nil {
+		switch scp := raw["scp"].(type) {
+		case string:
+			claims.Scopes = strings.Fields(scp)
+		case []interface{}:
+			for _, s := range scp {
+				if str, ok := s.(string); ok {
+					claims.Scopes = append(claims.Scopes, str)
+				}
+			}
+		}
+	}
+
+	return claims, nil
+}
+
+// verifySignature checks a token's signature with a key matching its algorithm
+func verifySignature(token *parsedToken, key interface{}) error {
+	digest := sha256.Sum256([]byte(token.signingInput))
+
+	switch token.header.Alg {
+	case AlgHS256:
+		secret, ok := key.([]byte)
+		if !ok {
+			return fmt.Errorf("%w: no HMAC key for %s", ErrInvalidToken, token.header.Alg)
+		}
+		mac := hmac.New(sha256.New, secret)
+		mac.Write([]byte(token.signingInput))
+		if !hmac.Equal(mac.Sum(nil), token.signature) {
+			return fmt.Errorf("%w: signature mismatch", ErrInvalidToken)
+		}
+	case AlgRS256:
+		publicKey, ok := key.(*rsa.PublicKey)
+		if !ok {
+			return fmt.Errorf("%w: no RSA key for %s", ErrInvalidToken, token.header.Alg)
+		}
+		if err := rsa.VerifyPKCS1v15(publicKey, crypto.SHA256, digest[:], token.signature); err != nil {
+			return fmt.Errorf("%w: signature mismatch", ErrInvalidToken)
+		}
+	case AlgES256:
+		publicKey, ok := key.(*ecdsa.PublicKey)
+		if !ok {
+			return fmt.Errorf("%w: no EC key for %s", ErrInvalidToken, token.header.Alg)
+		}
+		if len(token.signature) != 64 {
+			return fmt.Errorf("%w: malformed ES256 signature", ErrInvalidToken)
+		}
+		r := new(big.Int).SetBytes(token.signature[:32])
+		s := new(big.Int).SetBytes(token.signature[32:])
+		if !ecdsa.Verify(publicKey, digest[:], r, s) {
+			return fmt.Errorf("%w: signature mismatch", ErrInvalidToken)
+		}
+	default:
+		return fmt.Errorf("%w: unsupported algorithm %q", ErrInvalidToken, token.header.Alg)
+	}
+
+	return nil
+}
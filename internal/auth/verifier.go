@@ -0,0 +1,184 @@
+package auth
+
+import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// DefaultMerchantClaim is the claim carrying the merchant ID of an access token
+const DefaultMerchantClaim = "merchant_id"
+
+// clockSkew tolerates small differences between our clock and the issuer's
+const clockSkew = 30 * time.Second
+
+// Issuer is a trusted issuer of access tokens. Tokens from our own token endpoint are signed
+// with HMACKey; tokens from an external identity provider are verified against its JWKS.
+type Issuer struct {
+	Name          string // expected "iss" claim
+	Audience      string // required "aud" claim, if set
+	HMACKey       []byte // verifies HS256 tokens
+	JWKS          *JWKS  // verifies RS256 and ES256 tokens
+	MerchantClaim string // claim holding the merchant ID; DefaultMerchantClaim if empty
+}
+
+// Verifier verifies OAuth2 access tokens from a set of trusted issuers and maps their scopes
+// to roles
+type Verifier struct {
+	issuers    map[string]Issuer
+	scopeRoles ScopeRoles
+}
+
+// NewVerifier creates a verifier trusting the given issuers
+func NewVerifier(scopeRoles ScopeRoles, issuers ...Issuer) *Verifier {
+	v := &Verifier{issuers: make(map[string]Issuer, len(issuers)), scopeRoles: scopeRoles}
+	for _, issuer := range issuers {
+		v.issuers[issuer.Name] = issuer
+	}
+	return v
+}
+
+// Verify checks an access token's issuer, signature, lifetime and audience and returns the
+// merchant caller it identifies
+func (v *Verifier) Verify(ctx context.Context, token string) (*Principal, error) {
+	parsed, err := parseToken(token)
+	if err != nil {
+		return nil, err
+	}
+	claims := parsed.claims
+
+	issuer, ok := v.issuers[claims.Issuer]
+	if !ok {
+		return nil, fmt.Errorf("%w: untrusted issuer %q", ErrInvalidToken, claims.Issuer)
+	}
+
+	// Each issuer only accepts the algorithms of the keys it was configured with, so an
+	// RSA public key can never be used as an HMAC secret
+	var key interface{}
+	switch parsed.header.Alg {
+	case AlgHS256:
+		if issuer.HMACKey == nil {
+			return nil, fmt.Errorf("%w: %s is not accepted from %s", ErrInvalidToken, parsed.header.Alg, issuer.Name)
+		}
+		key = issuer.HMACKey
+	case AlgRS256, AlgES256:
+		if issuer.JWKS == nil {
+			return nil, fmt.Errorf("%w: %s is not accepted from %s", ErrInvalidToken, parsed.header.Alg, issuer.Name)
+		}
+		if key, err = issuer.JWKS.Key(ctx, parsed.header.Kid); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("%w: unsupported algorithm %q", ErrInvalidToken, parsed.header.Alg)
+	}
+
+	if err := verifySignature(parsed, key); err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	if claims.ExpiresAt.IsZero() || now.After(claims.ExpiresAt.Add(clockSkew)) {
+		return nil, fmt.Errorf("%w: token has expired", ErrInvalidToken)
+	}
+	if !claims.NotBefore.IsZero() && now.Add(clockSkew).Before(claims.NotBefore) {
+		return nil, fmt.Errorf("%w: token is not valid yet", ErrInvalidToken)
+	}
+	if issuer.Audience != "" && !contains(claims.Audience, issuer.Audience) {
+		return nil, fmt.Errorf("%w: token is not intended for this service", ErrInvalidToken)
+	}
+
+	merchantClaim := issuer.MerchantClaim
+	if merchantClaim == "" {
+		merchantClaim = DefaultMerchantClaim
+	}
+	merchantID, err := strconv.Atoi(claims.String(merchantClaim))
+	if err != nil || merchantID <= 0 {
+		return nil, fmt.Errorf("%w: missing %s claim", ErrInvalidToken, merchantClaim)
+	}
+
+	subject := claims.String("client_id")
+	if subject == "" {
+		subject = claims.Subject
+	}
+
+	return &Principal{
+		MerchantID: merchantID,
+		Subject:    subject,
+		Method:     MethodOAuth2,
+		Roles:      v.scopeRoles.Roles(claims.Scopes...),
+		ExpiresAt:  claims.ExpiresAt,
+	}, nil
+}
+
+// contains reports whether values includes value
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// TokenCache remembers verified access tokens until they expire, so repeated requests with
+// the same token skip signature verification and key lookups. Tokens are keyed by their hash.
+type TokenCache struct {
+	mu         sync.Mutex
+	entries    map[[sha256.Size]byte]*Principal
+	maxEntries int
+}
+
+// NewTokenCache creates a cache holding up to maxEntries tokens
+func NewTokenCache(maxEntries int) *TokenCache {
+	return &TokenCache{
+		entries:    make(map[[sha256.Size]byte]*Principal),
+		maxEntries: maxEntries,
+	}
+}
+
+// Get returns the caller identified by a cached, unexpired token
+func (c *TokenCache) Get(token string) (*Principal, bool) {
+	key := sha256.Sum256([]byte(token))
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	principal, ok := c.entries[key]
+	if !ok {
+		return nil, false
+	}
+	if !time.Now().Before(principal.ExpiresAt) {
+		delete(c.entries, key)
+		return nil, false
+	}
+	return principal, true
+}
+
+// Put caches a verified token. When the cache is full, expired tokens are evicted first and
+// then arbitrary ones.
+func (c *TokenCache) Put(token string, principal *Principal) {
+	key := sha256.Sum256([]byte(token))
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if len(c.entries) >= c.maxEntries {
+		now := time.Now()
+		for k, p := range c.entries {
+			if !now.Before(p.ExpiresAt) {
+				delete(c.entries, k)
+			}
+		}
+		for k := range c.entries {
+			if len(c.entries) < c.maxEntries {
+				break
+			}
+			delete(c.entries, k)
+		}
+	}
+
+	c.entries[key] = principal
+}
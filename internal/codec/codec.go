@@ -0,0 +1,152 @@
+package codec
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"mime"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Codec names
+const (
+	JSONName    = "json"
+	XMLName     = "xml"
+	FormName    = "form"
+	ISO8583Name = "iso8583"
+)
+
+// ErrUnsupportedFormat is returned when no codec is registered for a content type
+var ErrUnsupportedFormat = errors.New("unsupported data format")
+
+// Codec encodes and decodes one wire format that gateways declare as their data format
+type Codec interface {
+	// Name returns the short name of the format, e.g. "json"
+	Name() string
+
+	// ContentTypes returns the media types the format is known by; the first is canonical
+	ContentTypes() []string
+
+	// Marshal encodes a value
+	Marshal(v interface{}) ([]byte, error)
+
+	// Unmarshal decodes data into the value pointed to by v
+	Unmarshal(data []byte, v interface{}) error
+}
+
+// Registry resolves content types to codecs
+type Registry struct {
+	mu            sync.RWMutex
+	byName        map[string]Codec
+	byContentType map[string]Codec
+}
+
+// NewRegistry creates a registry holding the given codecs
+func NewRegistry(codecs ...Codec) *Registry {
+	r := &Registry{
+		byName:        make(map[string]Codec),
+		byContentType: make(map[string]Codec),
+	}
+	for _, c := range codecs {
+		r.Register(c)
+	}
+	return r
+}
+
+// Register adds a codec, replacing any codec previously registered under its name or content types
+func (r *Registry) Register(c Codec) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.byName[c.Name()] = c
+	for _, contentType := range c.ContentTypes() {
+		r.byContentType[contentType] = c
+	}
+}
+
+// Lookup returns the codec for a content type. Media type parameters such as charset are ignored.
+func (r *Registry) Lookup(contentType string) (Codec, error) {
+	mediaType := contentType
+	if parsed, _, err := mime.ParseMediaType(contentType); err == nil {
+		mediaType = parsed
+	}
+	mediaType = strings.ToLower(strings.TrimSpace(mediaType))
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	if c, ok := r.byContentType[mediaType]; ok {
+		return c, nil
+	}
+	return nil, fmt.Errorf("%w: %s", ErrUnsupportedFormat, contentType)
+}
+
+// ByName returns the codec registered under a name
+func (r *Registry) ByName(name string) (Codec, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	if c, ok := r.byName[name]; ok {
+		return c, nil
+	}
+	return nil, fmt.Errorf("%w: %s", ErrUnsupportedFormat, name)
+}
+
+// Codecs returns the registered codecs ordered by name
+func (r *Registry) Codecs() []Codec {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	codecs := make([]Codec, 0, len(r.byName))
+	for _, c := range r.byName {
+		codecs = append(codecs, c)
+	}
+	sort.Slice(codecs, func(i, j int) bool { return codecs[i].Name() < codecs[j].Name() })
+	return codecs
+}
+
+// Default is the registry of the formats the service supports
+var Default = NewRegistry(JSON{}, XML{}, Form{}, ISO8583{})
+
+// Lookup returns the codec for a content type from the default registry
+func Lookup(contentType string) (Codec, error) {
+	return Default.Lookup(contentType)
+}
+
+// ByName returns the codec registered under a name in the default registry
+func ByName(name string) (Codec, error) {
+	return Default.ByName(name)
+}
+
+// JSON encodes values as JSON
+type JSON struct{}
+
+// Name returns "json"
+func (JSON) Name() string { return JSONName }
+
+// ContentTypes returns the JSON media types
+func (JSON) ContentTypes() []string { return []string{"application/json"} }
+
+// Marshal encodes v as JSON
+func (JSON) Marshal(v interface{}) ([]byte, error) { return json.Marshal(v) }
+
+// Unmarshal decodes JSON into v
+func (JSON) Unmarshal(data []byte, v interface{}) error { return json.Unmarshal(data, v) }
+
+// XML encodes values as XML, as used by SOAP gateways
+type XML struct{}
+
+// Name returns "xml"
+func (XML) Name() string { return XMLName }
+
+// ContentTypes returns the XML media types
+func (XML) ContentTypes() []string { return []string{"application/xml", "text/xml"} }
+
+// Marshal encodes v as XML
+func (XML) Marshal(v interface{}) ([]byte, error) { return xml.Marshal(v) }
+
+// Unmarshal decodes XML into v
+func (XML) Unmarshal(data []byte, v interface{}) error { return xml.Unmarshal(data, v) }
@@ -0,0 +1,60 @@
+package codec
+
+import (
+	"errors"
+	"testing"
+)
+
+// TestRegistryLookup tests that content types resolve to codecs regardless of parameters and case
+func TestRegistryLookup(t *testing.T) {
+	cases := map[string]string{
+		"application/json":                  JSONName,
+		"application/json; charset=utf-8":   JSONName,
+		"Text/XML":                          XMLName,
+		"application/x-www-form-urlencoded": FormName,
+		"application/iso8583":               ISO8583Name,
+	}
+
+	for contentType, name := range cases {
+		c, err := Lookup(contentType)
+		if err != nil {
+			t.Errorf("Expected codec for %s, got error: %v", contentType, err)
+			continue
+		}
+		if c.Name() != name {
+			t.Errorf("Expected %s for %s, got %s", name, contentType, c.Name())
+		}
+	}
+
+	if _, err := Lookup("application/octet-stream"); !errors.Is(err, ErrUnsupportedFormat) {
+		t.Errorf("Expected ErrUnsupportedFormat, got: %v", err)
+	}
+}
+
+// TestFormRoundTrip tests that structs survive form encoding using their json field names
+func TestFormRoundTrip(t *testing.T) {
+	type payload struct {
+		TransactionID int      `json:"transaction_id"`
+		Status        string   `json:"status"`
+		Amount        float64  `json:"amount"`
+		Message       string   `json:"message,omitempty"`
+		Tags          []string `json:"tags"`
+	}
+
+	in := payload{TransactionID: 42, Status: "completed", Amount: 10.5, Tags: []string{"a", "b"}}
+	data, err := Form{}.Marshal(in)
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	if string(data) != "amount=10.5&status=completed&tags=a&tags=b&transaction_id=42" {
+		t.Errorf("Unexpected form encoding: %s", data)
+	}
+
+	var out payload
+	if err := (Form{}).Unmarshal(data, &out); err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	if out.TransactionID != 42 || out.Status != "completed" || out.Amount != 10.5 || len(out.Tags) != 2 {
+		t.Errorf("Unexpected decoded payload: %+v", out)
+	}
+}
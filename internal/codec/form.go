@@ -0,0 +1,221 @@
+package codec
+
+import (
+	"fmt"
+	"net/url"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Form encodes values as URL-encoded forms, as posted by redirect-based gateways. Struct
+// fields are named by their json tags; only scalar fields and slices of scalars are encoded.
+type Form struct{}
+
+// Name returns "form"
+func (Form) Name() string { return FormName }
+
+// ContentTypes returns the form media type
+func (Form) ContentTypes() []string { return []string{"application/x-www-form-urlencoded"} }
+
+// Marshal encodes a struct, map or url.Values as a form
+func (Form) Marshal(v interface{}) ([]byte, error) {
+	values := url.Values{}
+
+	rv := reflect.Indirect(reflect.ValueOf(v))
+	switch rv.Kind() {
+	case reflect.Struct:
+		t := rv.Type()
+		for i := 0; i < t.NumField(); i++ {
+			field := t.Field(i)
+			name, omitEmpty, ok := fieldName(field, "json")
+			if !ok {
+				continue
+			}
+
+			fv := rv.Field(i)
+			if omitEmpty && fv.IsZero() {
+				continue
+			}
+			if fv.Kind() == reflect.Slice && fv.Type().Elem().Kind() != reflect.Uint8 {
+				for j := 0; j < fv.Len(); j++ {
+					if s, ok := formatScalar(fv.Index(j)); ok {
+						values.Add(name, s)
+					}
+				}
+				continue
+			}
+			if s, ok := formatScalar(fv); ok {
+				values.Set(name, s)
+			}
+		}
+	case reflect.Map:
+		if rv.Type().Key().Kind() != reflect.String {
+			return nil, fmt.Errorf("form: unsupported map key type %s", rv.Type().Key())
+		}
+		for _, key := range rv.MapKeys() {
+			mv := reflect.Indirect(rv.MapIndex(key))
+			if mv.Kind() == reflect.Interface {
+				mv = reflect.Indirect(mv.Elem())
+			}
+			if mv.Kind() == reflect.Slice {
+				for j := 0; j < mv.Len(); j++ {
+					if s, ok := formatScalar(mv.Index(j)); ok {
+						values.Add(key.String(), s)
+					}
+				}
+				continue
+			}
+			if s, ok := formatScalar(mv); ok {
+				values.Set(key.String(), s)
+			}
+		}
+	default:
+		return nil, fmt.Errorf("form: unsupported type %T", v)
+	}
+
+	return []byte(values.Encode()), nil
+}
+
+// Unmarshal decodes a form into a struct, map[string]string or url.Values
+func (Form) Unmarshal(data []byte, v interface{}) error {
+	values, err := url.ParseQuery(string(data))
+	if err != nil {
+		return fmt.Errorf("form: %w", err)
+	}
+
+	switch target := v.(type) {
+	case *url.Values:
+		*target = values
+		return nil
+	case *map[string]string:
+		if *target == nil {
+			*target = make(map[string]string, len(values))
+		}
+		for key := range values {
+			(*target)[key] = values.Get(key)
+		}
+		return nil
+	}
+
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Ptr || rv.Elem().Kind() != reflect.Struct {
+		return fmt.Errorf("form: cannot decode into %T", v)
+	}
+	rv = rv.Elem()
+	t := rv.Type()
+
+	for i := 0; i < t.NumField(); i++ {
+		name, _, ok := fieldName(t.Field(i), "json")
+		if !ok {
+			continue
+		}
+		raw, present := values[name]
+		if !present || len(raw) == 0 {
+			continue
+		}
+
+		fv := rv.Field(i)
+		if fv.Kind() == reflect.Slice && fv.Type().Elem().Kind() != reflect.Uint8 {
+			slice := reflect.MakeSlice(fv.Type(), len(raw), len(raw))
+			for j, s := range raw {
+				if err := parseScalar(slice.Index(j), s); err != nil {
+					return fmt.Errorf("form: field %s: %w", name, err)
+				}
+			}
+			fv.Set(slice)
+			continue
+		}
+		if err := parseScalar(fv, raw[0]); err != nil {
+			return fmt.Errorf("form: field %s: %w", name, err)
+		}
+	}
+
+	return nil
+}
+
+// fieldName returns the name a struct field is encoded under according to a tag, whether
+// it is omitted when empty, and false for unexported or skipped fields
+func fieldName(field reflect.StructField, tag string) (string, bool, bool) {
+	if !field.IsExported() {
+		return "", false, false
+	}
+
+	name, opts, _ := strings.Cut(field.Tag.Get(tag), ",")
+	if name == "-" {
+		return "", false, false
+	}
+	if name == "" {
+		name = field.Name
+	}
+	return name, strings.Contains(opts, "omitempty"), true
+}
+
+// formatScalar renders a scalar value as text, reporting false for non-scalar values
+func formatScalar(v reflect.Value) (string, bool) {
+	if !v.IsValid() {
+		return "", false
+	}
+	if t, ok := v.Interface().(time.Time); ok {
+		return t.Format(time.RFC3339), true
+	}
+
+	switch v.Kind() {
+	case reflect.String:
+		return v.String(), true
+	case reflect.Bool:
+		return strconv.FormatBool(v.Bool()), true
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return strconv.FormatInt(v.Int(), 10), true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return strconv.FormatUint(v.Uint(), 10), true
+	case reflect.Float32, reflect.Float64:
+		return strconv.FormatFloat(v.Float(), 'f', -1, 64), true
+	}
+	return "", false
+}
+
+// parseScalar sets a scalar value from text
+func parseScalar(v reflect.Value, s string) error {
+	if _, ok := v.Interface().(time.Time); ok {
+		t, err := time.Parse(time.RFC3339, s)
+		if err != nil {
+			return err
+		}
+		v.Set(reflect.ValueOf(t))
+		return nil
+	}
+
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString(s)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			return err
+		}
+		v.SetBool(b)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		i, err := strconv.ParseInt(s, 10, 64)
+		if err != nil {
+			return err
+		}
+		v.SetInt(i)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		u, err := strconv.ParseUint(s, 10, 64)
+		if err != nil {
+			return err
+		}
+		v.SetUint(u)
+	case reflect.Float32, reflect.Float64:
+		f, err := strconv.ParseFloat(s, 64)
+		if err != nil {
+			return err
+		}
+		v.SetFloat(f)
+	default:
+		return fmt.Errorf("unsupported field type %s", v.Type())
+	}
+	return nil
+}
@@ -0,0 +1,407 @@
+package codec
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Message is an ISO 8583 message: a message type indicator and data elements keyed by field number
+type Message struct {
+	MTI    string
+	Fields map[int]string
+}
+
+// FieldSpec describes how an ISO 8583 data element is laid out
+type FieldSpec struct {
+	Length  int  // fixed length, or maximum length for variable fields
+	Prefix  int  // digits of the length prefix: 0 for fixed, 2 for LLVAR, 3 for LLLVAR
+	Numeric bool // numeric fields are zero-padded on the left, others space-padded on the right
+}
+
+// ISO8583Fields lists the data elements understood by the ISO 8583 codec by default
+var ISO8583Fields = map[int]FieldSpec{
+	2:   {Length: 19, Prefix: 2, Numeric: true}, // primary account number
+	3:   {Length: 6, Numeric: true},             // processing code
+	4:   {Length: 12, Numeric: true},            // amount, in minor units
+	7:   {Length: 10, Numeric: true},            // transmission date and time, MMDDhhmmss
+	11:  {Length: 6, Numeric: true},             // system trace audit number
+	12:  {Length: 6, Numeric: true},             // local time, hhmmss
+	13:  {Length: 4, Numeric: true},             // local date, MMDD
+	14:  {Length: 4, Numeric: true},             // expiration date, YYMM
+	22:  {Length: 3, Numeric: true},             // point of service entry mode
+	32:  {Length: 11, Prefix: 2, Numeric: true}, // acquiring institution ID
+	37:  {Length: 12},                           // retrieval reference number
+	38:  {Length: 6},                            // authorization code
+	39:  {Length: 2},                            // response code
+	41:  {Length: 8},                            // card acceptor terminal ID
+	42:  {Length: 15},                           // card acceptor ID
+	44:  {Length: 25, Prefix: 2},                // additional response data
+	48:  {Length: 999, Prefix: 3},               // additional data, private
+	49:  {Length: 3},                            // currency code
+	70:  {Length: 3, Numeric: true},             // network management information code
+	102: {Length: 28, Prefix: 2},                // account identification
+	126: {Length: 999, Prefix: 3},               // private use, e.g. wallet payment cryptograms
+}
+
+// ParseFieldSpecs parses field layouts written in ISO 8583 notation, e.g. "18=n4,60=ans...999",
+// where ".." marks an LLVAR field and "..." an LLLVAR field
+func ParseFieldSpecs(s string) (map[int]FieldSpec, error) {
+	specs := make(map[int]FieldSpec)
+	for _, entry := range strings.Split(s, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		number, notation, ok := strings.Cut(entry, "=")
+		field, err := strconv.Atoi(strings.TrimSpace(number))
+		if !ok || err != nil || field < 2 || field > 128 {
+			return nil, fmt.Errorf("iso8583: invalid field spec %q", entry)
+		}
+		spec, err := parseFieldSpec(strings.TrimSpace(notation))
+		if err != nil {
+			return nil, fmt.Errorf("iso8583: invalid field spec %q: %w", entry, err)
+		}
+		specs[field] = spec
+	}
+	return specs, nil
+}
+
+// ParseFieldValues parses field values written as "18=5999,22=012"
+func ParseFieldValues(s string) (map[int]string, error) {
+	values := make(map[int]string)
+	for _, entry := range strings.Split(s, ",") {
+		if strings.TrimSpace(entry) == "" {
+			continue
+		}
+
+		number, value, ok := strings.Cut(entry, "=")
+		field, err := strconv.Atoi(strings.TrimSpace(number))
+		if !ok || err != nil || field < 2 || field > 128 {
+			return nil, fmt.Errorf("iso8583: invalid field value %q", entry)
+		}
+		values[field] = strings.TrimSpace(value)
+	}
+	return values, nil
+}
+
+// parseFieldSpec parses a single layout such as "n6", "an12" or "ans..99"
+func parseFieldSpec(notation string) (FieldSpec, error) {
+	kind := strings.TrimRight(notation, ".0123456789")
+	rest := notation[len(kind):]
+	switch kind {
+	case "n", "a", "an", "ans":
+	default:
+		return FieldSpec{}, fmt.Errorf("unknown type %q", kind)
+	}
+
+	spec := FieldSpec{Numeric: kind == "n"}
+	switch {
+	case strings.HasPrefix(rest, "..."):
+		spec.Prefix = 3
+	case strings.HasPrefix(rest, ".."):
+		spec.Prefix = 2
+	}
+	length, err := strconv.Atoi(strings.TrimLeft(rest, "."))
+	if err != nil || length <= 0 {
+		return FieldSpec{}, fmt.Errorf("invalid length")
+	}
+	if (spec.Prefix == 2 && length > 99) || (spec.Prefix == 3 && length > 999) {
+		return FieldSpec{}, fmt.Errorf("length exceeds its prefix")
+	}
+	spec.Length = length
+
+	return spec, nil
+}
+
+// ISO8583 encodes messages in the ASCII variant of ISO 8583: a four digit message type
+// indicator, a hex primary bitmap (followed by a secondary bitmap when fields above 64 are
+// present) and the data elements in field order. Besides Message, structs whose fields are
+// tagged `iso8583:"<field>"` (and optionally `iso8583:"mti"`) can be encoded and decoded.
+//
+// Fields overrides or extends the default layout for switches with their own field definitions.
+type ISO8583 struct {
+	Fields map[int]FieldSpec
+}
+
+// spec returns the layout of a field, preferring the codec's own definitions
+func (c ISO8583) spec(field int) (FieldSpec, bool) {
+	if spec, ok := c.Fields[field]; ok {
+		return spec, true
+	}
+	spec, ok := ISO8583Fields[field]
+	return spec, ok
+}
+
+// Name returns "iso8583"
+func (ISO8583) Name() string { return ISO8583Name }
+
+// ContentTypes returns the ISO 8583 media types
+func (ISO8583) ContentTypes() []string {
+	return []string{"application/iso8583", "application/x-iso8583"}
+}
+
+// Marshal encodes a Message or tagged struct
+func (c ISO8583) Marshal(v interface{}) ([]byte, error) {
+	msg, err := toMessage(v)
+	if err != nil {
+		return nil, err
+	}
+	return c.Encode(msg)
+}
+
+// Unmarshal decodes into a Message or tagged struct
+func (c ISO8583) Unmarshal(data []byte, v interface{}) error {
+	msg, err := c.Decode(data)
+	if err != nil {
+		return err
+	}
+
+	if target, ok := v.(*Message); ok {
+		*target = msg
+		return nil
+	}
+	return fromMessage(msg, v)
+}
+
+// EncodeISO8583 renders a message in wire format using the default field layout
+func EncodeISO8583(msg Message) ([]byte, error) {
+	return ISO8583{}.Encode(msg)
+}
+
+// DecodeISO8583 parses a message from wire format using the default field layout
+func DecodeISO8583(data []byte) (Message, error) {
+	return ISO8583{}.Decode(data)
+}
+
+// Encode renders a message in wire format
+func (c ISO8583) Encode(msg Message) ([]byte, error) {
+	if len(msg.MTI) != 4 || !isDigits(msg.MTI) {
+		return nil, fmt.Errorf("iso8583: invalid message type indicator %q", msg.MTI)
+	}
+
+	fields := make([]int, 0, len(msg.Fields))
+	for field := range msg.Fields {
+		if field < 2 || field > 128 {
+			return nil, fmt.Errorf("iso8583: invalid field number %d", field)
+		}
+		fields = append(fields, field)
+	}
+	sort.Ints(fields)
+
+	var bitmap [16]byte
+	for _, field := range fields {
+		bitmap[(field-1)/8] |= 0x80 >> uint((field-1)%8)
+	}
+	secondary := len(fields) > 0 && fields[len(fields)-1] > 64
+	if secondary {
+		bitmap[0] |= 0x80
+	}
+
+	var b strings.Builder
+	b.WriteString(msg.MTI)
+	bitmapBytes := bitmap[:8]
+	if secondary {
+		bitmapBytes = bitmap[:]
+	}
+	fmt.Fprintf(&b, "%X", bitmapBytes)
+
+	for _, field := range fields {
+		spec, ok := c.spec(field)
+		if !ok {
+			return nil, fmt.Errorf("iso8583: field %d is not supported", field)
+		}
+		value := msg.Fields[field]
+		if spec.Numeric && !isDigits(value) {
+			return nil, fmt.Errorf("iso8583: field %d must be numeric", field)
+		}
+		if len(value) > spec.Length {
+			return nil, fmt.Errorf("iso8583: field %d exceeds %d characters", field, spec.Length)
+		}
+
+		switch {
+		case spec.Prefix > 0:
+			fmt.Fprintf(&b, "%0*d%s", spec.Prefix, len(value), value)
+		case spec.Numeric:
+			b.WriteString(strings.Repeat("0", spec.Length-len(value)) + value)
+		default:
+			b.WriteString(value + strings.Repeat(" ", spec.Length-len(value)))
+		}
+	}
+
+	return []byte(b.String()), nil
+}
+
+// Decode parses a message from wire format
+func (c ISO8583) Decode(data []byte) (Message, error) {
+	s := string(data)
+	if len(s) < 20 {
+		return Message{}, fmt.Errorf("iso8583: message too short")
+	}
+
+	msg := Message{MTI: s[:4], Fields: make(map[int]string)}
+	if !isDigits(msg.MTI) {
+		return Message{}, fmt.Errorf("iso8583: invalid message type indicator %q", msg.MTI)
+	}
+
+	bitmap, err := parseBitmap(s[4:20])
+	if err != nil {
+		return Message{}, err
+	}
+	pos := 20
+	if bitmap[0]&0x80 != 0 {
+		if len(s) < 36 {
+			return Message{}, fmt.Errorf("iso8583: secondary bitmap missing")
+		}
+		secondary, err := parseBitmap(s[20:36])
+		if err != nil {
+			return Message{}, err
+		}
+		bitmap = append(bitmap, secondary...)
+		pos = 36
+	}
+
+	for field := 2; field <= len(bitmap)*8; field++ {
+		if bitmap[(field-1)/8]&(0x80>>uint((field-1)%8)) == 0 {
+			continue
+		}
+		spec, ok := c.spec(field)
+		if !ok {
+			return Message{}, fmt.Errorf("iso8583: field %d is not supported", field)
+		}
+
+		length := spec.Length
+		if spec.Prefix > 0 {
+			if pos+spec.Prefix > len(s) {
+				return Message{}, fmt.Errorf("iso8583: field %d truncated", field)
+			}
+			length, err = strconv.Atoi(s[pos : pos+spec.Prefix])
+			if err != nil || length > spec.Length {
+				return Message{}, fmt.Errorf("iso8583: field %d has an invalid length", field)
+			}
+			pos += spec.Prefix
+		}
+		if pos+length > len(s) {
+			return Message{}, fmt.Errorf("iso8583: field %d truncated", field)
+		}
+
+		value := s[pos : pos+length]
+		pos += length
+		if spec.Prefix == 0 && !spec.Numeric {
+			value = strings.TrimRight(value, " ")
+		}
+		msg.Fields[field] = value
+	}
+
+	if pos != len(s) {
+		return Message{}, fmt.Errorf("iso8583: %d unexpected trailing bytes", len(s)-pos)
+	}
+
+	return msg, nil
+}
+
+// parseBitmap decodes a 16 character hex bitmap
+func parseBitmap(s string) ([]byte, error) {
+	bitmap := make([]byte, 8)
+	for i := 0; i < 8; i++ {
+		b, err := strconv.ParseUint(s[i*2:i*2+2], 16, 8)
+		if err != nil {
+			return nil, fmt.Errorf("iso8583: invalid bitmap %q", s)
+		}
+		bitmap[i] = byte(b)
+	}
+	return bitmap, nil
+}
+
+// toMessage builds a message from a Message or tagged struct
+func toMessage(v interface{}) (Message, error) {
+	switch m := v.(type) {
+	case Message:
+		return m, nil
+	case *Message:
+		return *m, nil
+	}
+
+	rv := reflect.Indirect(reflect.ValueOf(v))
+	if rv.Kind() != reflect.Struct {
+		return Message{}, fmt.Errorf("iso8583: unsupported type %T", v)
+	}
+
+	msg := Message{Fields: make(map[int]string)}
+	t := rv.Type()
+	for i := 0; i < t.NumField(); i++ {
+		tag := t.Field(i).Tag.Get("iso8583")
+		if tag == "" || tag == "-" || !t.Field(i).IsExported() {
+			continue
+		}
+
+		fv := rv.Field(i)
+		s, ok := formatScalar(fv)
+		if !ok {
+			return Message{}, fmt.Errorf("iso8583: field %s is not a scalar", t.Field(i).Name)
+		}
+		if tag == "mti" {
+			msg.MTI = s
+			continue
+		}
+
+		field, err := strconv.Atoi(tag)
+		if err != nil {
+			return Message{}, fmt.Errorf("iso8583: invalid tag %q on field %s", tag, t.Field(i).Name)
+		}
+		if fv.IsZero() {
+			continue
+		}
+		msg.Fields[field] = s
+	}
+
+	return msg, nil
+}
+
+// fromMessage fills a tagged struct from a message
+func fromMessage(msg Message, v interface{}) error {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Ptr || rv.Elem().Kind() != reflect.Struct {
+		return fmt.Errorf("iso8583: cannot decode into %T", v)
+	}
+	rv = rv.Elem()
+	t := rv.Type()
+
+	for i := 0; i < t.NumField(); i++ {
+		tag := t.Field(i).Tag.Get("iso8583")
+		if tag == "" || tag == "-" || !t.Field(i).IsExported() {
+			continue
+		}
+
+		value := msg.MTI
+		if tag != "mti" {
+			field, err := strconv.Atoi(tag)
+			if err != nil {
+				return fmt.Errorf("iso8583: invalid tag %q on field %s", tag, t.Field(i).Name)
+			}
+			var present bool
+			if value, present = msg.Fields[field]; !present {
+				continue
+			}
+		}
+
+		if err := parseScalar(rv.Field(i), value); err != nil {
+			return fmt.Errorf("iso8583: field %s: %w", t.Field(i).Name, err)
+		}
+	}
+
+	return nil
+}
+
+// isDigits reports whether s consists of ASCII digits only
+func isDigits(s string) bool {
+	for _, r := range s {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,96 @@
+package codec
+
+import (
+	"testing"
+)
+
+// TestISO8583RoundTrip tests encoding and decoding of fixed, variable and secondary-bitmap fields
+func TestISO8583RoundTrip(t *testing.T) {
+	msg := Message{
+		MTI: "0210",
+		Fields: map[int]string{
+			2:   "4242424242424242",
+			4:   "1050",
+			37:  "REF123",
+			39:  "00",
+			102: "ACC-1",
+		},
+	}
+
+	data, err := EncodeISO8583(msg)
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+
+	// Field 102 requires the secondary bitmap, flagged by the first bit of the primary one
+	if string(data[:8]) != "0210D000" {
+		t.Errorf("Unexpected message header: %s", data[:20])
+	}
+
+	decoded, err := DecodeISO8583(data)
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	if decoded.MTI != "0210" {
+		t.Errorf("Expected MTI 0210, got %s", decoded.MTI)
+	}
+	expected := map[int]string{2: "4242424242424242", 4: "000000001050", 37: "REF123", 39: "00", 102: "ACC-1"}
+	for field, value := range expected {
+		if decoded.Fields[field] != value {
+			t.Errorf("Expected field %d to be %q, got %q", field, value, decoded.Fields[field])
+		}
+	}
+}
+
+// TestISO8583TaggedStruct tests that tagged structs are filled from data elements
+func TestISO8583TaggedStruct(t *testing.T) {
+	type response struct {
+		MTI          string `iso8583:"mti"`
+		ReferenceID  string `iso8583:"37"`
+		ResponseCode string `iso8583:"39"`
+		Trace        int    `iso8583:"11"`
+		Ignored      string
+	}
+
+	data, err := ISO8583{}.Marshal(response{MTI: "0110", ReferenceID: "ABC", ResponseCode: "51", Trace: 7})
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+
+	var out response
+	if err := (ISO8583{}).Unmarshal(data, &out); err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	if out.MTI != "0110" || out.ReferenceID != "ABC" || out.ResponseCode != "51" || out.Trace != 7 {
+		t.Errorf("Unexpected decoded response: %+v", out)
+	}
+
+	if _, err := DecodeISO8583(append(data, 'X')); err == nil {
+		t.Error("Expected error for trailing bytes, got none")
+	}
+}
+
+// TestParseFieldSpecs tests parsing of field layouts written in ISO 8583 notation
+func TestParseFieldSpecs(t *testing.T) {
+	specs, err := ParseFieldSpecs("18=n4, 60=ans...999,62=an..20")
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+
+	expected := map[int]FieldSpec{
+		18: {Length: 4, Numeric: true},
+		60: {Length: 999, Prefix: 3},
+		62: {Length: 20, Prefix: 2},
+	}
+	for field, spec := range expected {
+		if specs[field] != spec {
+			t.Errorf("Expected field %d to be %+v, got %+v", field, spec, specs[field])
+		}
+	}
+
+	for _, invalid := range []string{"1=n4", "18=x4", "18=n", "18=an..100"} {
+		if _, err := ParseFieldSpecs(invalid); err == nil {
+			t.Errorf("Expected error for %q, got none", invalid)
+		}
+	}
+}
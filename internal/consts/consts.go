@@ -1,5 +1,7 @@
 package consts
 
+import "time"
+
 const (
 	// Transaction Types
 	Deposit    = "deposit"
@@ -9,11 +11,717 @@ const (
 	Pending    = "pending"
 	Completed  = "completed"
 	Processing = "processing"
+	Failed     = "failed"
+	Scheduled  = "scheduled"  // withdrawal waiting for its merchant's next payout
+	Authorized = "authorized" // deposit whose amount the gateway holds until it is captured or voided
+	Voided     = "voided"     // authorized deposit released without being captured
+
+	// Normalized decline codes that providers map their own codes into
+	DeclineInsufficientFunds = "insufficient_funds"
+	DeclineDoNotHonor        = "do_not_honor"
+	DeclineExpiredCard       = "expired_card"
+	DeclineFraudSuspected    = "fraud_suspected"
+	DeclineInvalidAccount    = "invalid_account"
+	DeclineLimitExceeded     = "limit_exceeded"
+	DeclineIssuerUnavailable = "issuer_unavailable"
+	DeclineTimeout           = "timeout"
+	DeclineProcessingError   = "processing_error"
+	DeclineConsentRejected   = "consent_rejected" // the customer did not authorise the payment at their bank
+	DeclineConsentExpired    = "consent_expired"  // the customer did not authorise the payment in time
+	DeclineMandateInvalid    = "mandate_invalid"  // the debtor's bank holds no valid mandate for a direct debit
+	DeclineDebitRefunded     = "debit_refunded"   // the debtor had their bank refund a settled direct debit
+	DeclineUnknown           = "unknown"
+
+	// Recovery hints telling customers what to do after a decline
+	RecoveryTryAgain       = "try_again"
+	RecoveryUseOtherMethod = "use_other_method"
+	RecoveryContactBank    = "contact_bank"
+	RecoveryDoNotRetry     = "do_not_retry"
+
+	// Strong customer authentication (SCA) exemptions card deposits may request
+	SCAExemptionLowValue = "low_value"
+	SCAExemptionTRA      = "tra" // transaction risk analysis
+
+	// Outcomes of a requested SCA exemption
+	SCAExemptionGranted     = "granted"
+	SCAExemptionRejected    = "rejected"    // the deposit fell back to a 3DS challenge
+	SCAExemptionUnsupported = "unsupported" // the gateway cannot request exemptions
+
+	// Payment method types listed in the payment-methods directory
+	PaymentMethodCard   = "card"
+	PaymentMethodWallet = "wallet" // mobile-money wallets, identified by phone_number
+	PaymentMethodBank   = "bank"   // open banking payment initiation, identified by bank_id
+	PaymentMethodCrypto = "crypto" // cryptocurrency, paid on the gateway's hosted page
+	PaymentMethodSEPA   = "sepa"   // SEPA Direct Debit and credit transfer, identified by mandate_id
+	PaymentMethodPix    = "pix"    // Brazilian instant payments, paid by QR code or its copy-paste payload
+	PaymentMethodUPI    = "upi"    // Indian instant payments, collected from a vpa or paid through an intent link
+
+	// Sources a transaction's country can be resolved from, in order of precedence
+	CountrySourceExplicit = "explicit"
+	CountrySourceCardBIN  = "card_bin"
+	CountrySourceGeoIP    = "geoip"
+	CountrySourceUser     = "user"
+
+	// Risk flags raised for the fraud pipeline
+	RiskCountryMismatch = "country_mismatch"
+	RiskNewRecipient    = "new_recipient" // a wallet transfer to a user the sender never paid before
+
+	// Batch status types
+	BatchProcessing     = "processing"
+	BatchCompleted      = "completed"
+	BatchPartialFailure = "partially_failed"
+	BatchFailed         = "failed"
+
+	// Operation status types
+	OperationPending   = "pending"
+	OperationRunning   = "running"
+	OperationSucceeded = "succeeded"
+	OperationFailed    = "failed"
+
+	// Webhook secret status types
+	WebhookSecretActive  = "active"
+	WebhookSecretRetired = "retired"
+
+	// Client certificate statuses
+	ClientCertificateActive  = "active"
+	ClientCertificateRetired = "retired"
+
+	// Signing key statuses
+	SigningKeyActive  = "active"
+	SigningKeyRetired = "retired"
+
+	// API key scopes
+	APIKeyScopeReadOnly = "read_only"
+	APIKeyScopeFull     = "full"
+
+	// API key status types
+	APIKeyActive  = "active"
+	APIKeyRevoked = "revoked"
+
+	// OAuth2 scopes granted to merchant clients
+	OAuthScopePaymentsRead  = "payments:read"
+	OAuthScopePaymentsWrite = "payments:write"
+
+	// OAuth2 client status types
+	OAuthClientActive  = "active"
+	OAuthClientRevoked = "revoked"
+
+	// Roles of authenticated merchant callers. Viewers may read merchant resources;
+	// operators may also change them.
+	RoleViewer   = "viewer"
+	RoleOperator = "operator"
+
+	// Callback record status types
+	CallbackReceived    = "received"
+	CallbackProcessed   = "processed"
+	CallbackParseFailed = "parse_failed"
+	CallbackFailed      = "failed"
+	CallbackRejected    = "rejected"
+
+	// Outbox destinations
+	OutboxKafka           = "kafka"
+	OutboxMerchantWebhook = "merchant_webhook"
+	OutboxWarehouse       = "warehouse"
+
+	// Outbox message status types
+	OutboxPending   = "pending"
+	OutboxDelivered = "delivered"
+	OutboxFailed    = "failed"
+	OutboxDiscarded = "discarded" // abandoned by an admin
+
+	// Merchant webhook payload versions. Payloads are recorded in the v1 shape and transformed
+	// to the version of the endpoint they are delivered to.
+	WebhookPayloadV1     = "v1" // legacy: the event's type, object and occurred_at at the top level
+	WebhookPayloadV2     = "v2" // event envelope: id, type, api_version, created_at and the object under data
+	WebhookPayloadLatest = WebhookPayloadV2
+
+	// Dispute status types; resolved and rejected disputes are closed
+	DisputeOpen     = "open"
+	DisputeInReview = "in_review"
+	DisputeResolved = "resolved" // the user's claim was upheld
+	DisputeRejected = "rejected"
+
+	// Transaction hold status types; released and expired holds are closed
+	HoldActive   = "active"
+	HoldReleased = "released" // released by a reviewer
+	HoldExpired  = "expired"  // released automatically at its deadline
+
+	// AML case status types; reported and dismissed cases are closed
+	AMLCaseOpen      = "open"
+	AMLCaseInReview  = "in_review"
+	AMLCaseReported  = "reported" // filed with the regulator
+	AMLCaseDismissed = "dismissed"
+
+	// AML report export formats
+	AMLReportCSV   = "csv"
+	AMLReportGoAML = "goaml" // XML in the layout of UNODC's goAML, used by many financial intelligence units
+
+	// Payment status types of a crypto deposit, alongside the transaction's status
+	CryptoPaymentDetected  = "detected"  // seen on the network, waiting for confirmations
+	CryptoPaymentPaid      = "paid"      // confirmed for the charge's price
+	CryptoPaymentUnderpaid = "underpaid" // confirmed for less than the price
+	CryptoPaymentOverpaid  = "overpaid"  // confirmed for more than the price; the excess is owed back
+	CryptoPaymentDelayed   = "delayed"   // paid after the charge expired; resolved on the gateway's dashboard
+
+	// Rounding modes of amounts to their currency's minor unit
+	RoundingHalfUp   = "half_up"   // halves round away from zero
+	RoundingHalfEven = "half_even" // halves round to the even digit (banker's rounding)
+
+	// Sanctions screening status types of users
+	ScreeningClear         = "clear"
+	ScreeningPendingReview = "pending_review" // matched a sanctions list; transactions are held until reviewed
+	ScreeningBlocked       = "blocked"        // the match was confirmed
+
+	// Screening case status types; cleared and confirmed cases are closed
+	ScreeningCaseOpen      = "open"
+	ScreeningCaseCleared   = "cleared" // a false positive
+	ScreeningCaseConfirmed = "confirmed"
+
+	// What triggered a sanctions screening
+	ScreeningTriggerUserCreated = "user_created"
+	ScreeningTriggerWithdrawal  = "withdrawal"
+
+	// Screening audit trail actions, besides the case statuses a reviewer decides
+	ScreeningEventScreened = "screened"
+	ScreeningEventMatched  = "matched"
+
+	// ScreeningActorSystem is the actor of screening audit trail entries the gateway records itself
+	ScreeningActorSystem = "system"
+
+	// Payment consent statuses of open banking deposits
+	ConsentAwaitingAuthorisation = "awaiting_authorisation"
+	ConsentConsumed              = "consumed" // authorised and the payment executed
+	ConsentRejected              = "rejected"
+	ConsentExpired               = "expired"
+
+	// Statuses of bank account consents besides those of payment consents, and of linked accounts
+	ConsentAuthorised  = "authorised"
+	BankAccountActive  = "active"
+	BankAccountRevoked = "revoked"
+
+	// Statuses of SEPA Direct Debit mandates
+	MandateActive  = "active"
+	MandateRevoked = "revoked"
+
+	// SEPA Direct Debit sequence types: a mandate's first debit and those after it
+	SequenceFirst     = "FRST"
+	SequenceRecurring = "RCUR"
+
+	// How a UPI deposit is paid: approving a collect request sent to the payer's VPA in their UPI
+	// app, or opening an intent link in it
+	UPIFlowCollect = "collect"
+	UPIFlowIntent  = "intent"
+
+	// Wallets whose encrypted payment tokens deposits can be paid with
+	WalletApplePay  = "apple_pay"
+	WalletGooglePay = "google_pay"
+
+	// Merchant certificate statuses
+	MerchantCertificateActive  = "active"
+	MerchantCertificateRetired = "retired"
+
+	// Statuses of auto-reload rules: checked by the scheduler, stopped by the safety caps, or
+	// cancelled by the user
+	AutoReloadActive    = "active"
+	AutoReloadDisabled  = "disabled"
+	AutoReloadCancelled = "cancelled"
+
+	// Operation types
+	OperationWithdrawalBatch = "withdrawal_batch"
+	OperationArchival        = "transaction_archival"
 )
 
 const (
-	DepositRoute  = "/deposit"
-	WithdrawRoute = "/withdraw"
-	CallbackRoute = "/callback"
-	HealthRoute   = "/health"
+	// MaxBatchSize is the maximum number of items accepted in a single batch request
+	MaxBatchSize = 100
+
+	// BatchConcurrency is the number of batch items processed in parallel
+	BatchConcurrency = 10
+
+	// MaxCSVUploadSize is the maximum accepted size in bytes of a bulk CSV upload
+	MaxCSVUploadSize = 1 << 20
+
+	// MaxPooledBufferSize is the largest capacity in bytes of a request or response buffer kept
+	// for reuse; buffers grown past it by an unusually large body are left to the garbage collector
+	MaxPooledBufferSize = 64 << 10
+
+	// MaxRequestBodySize is the maximum accepted size in bytes of a decoded request body
+	MaxRequestBodySize = 1 << 20
+
+	// MaxCSVRows is the maximum number of data rows accepted in a bulk CSV upload
+	MaxCSVRows = 1000
+
+	// OperationTTL is how long a long-running operation remains retrievable after creation
+	OperationTTL = 24 * time.Hour
+
+	// OperationCleanupInterval is how often expired operations are purged
+	OperationCleanupInterval = time.Hour
+
+	// DefaultArchiveAfterDays is how long completed and failed transactions stay in the hot table
+	DefaultArchiveAfterDays = 90
+
+	// DefaultArchiveBatchSize is the number of transactions moved to the archive per database round trip
+	DefaultArchiveBatchSize = 500
+
+	// MaxSoftDeclineRetries is how many alternate gateways a softly declined transaction is retried on
+	MaxSoftDeclineRetries = 1
+
+	// ArchivalInterval is how often the retention job runs
+	ArchivalInterval = 24 * time.Hour
+
+	// PartitionMonthsAhead is how many months of partitions are kept created beyond the current month
+	PartitionMonthsAhead = 3
+
+	// PartitionMaintenanceInterval is how often missing partitions are created
+	PartitionMaintenanceInterval = time.Hour
+
+	// OutboxDispatchInterval is how often dispatchers poll for pending outbox messages
+	OutboxDispatchInterval = time.Second
+
+	// OutboxBatchSize is the maximum number of outbox messages a dispatcher delivers per poll
+	OutboxBatchSize = 100
+
+	// MaxOutboxAttempts is how many times delivery of an outbox message is attempted before it is marked failed.
+	// Kafka events are retried until delivered or discarded by an admin.
+	MaxOutboxAttempts = 10
+
+	// KafkaConsumerMaxAttempts is how many times the Kafka consumer runs its handlers on a message
+	// before logging and skipping it, so one bad message cannot stall its partition
+	KafkaConsumerMaxAttempts = 5
+
+	// KafkaConsumerRetryBackoff is how long the Kafka consumer waits before handling a message
+	// again after a handler failed, doubled after each failure
+	KafkaConsumerRetryBackoff = time.Second
+
+	// KafkaConsumerDedupWindow is how many recent dedup tokens the Kafka consumer remembers, to
+	// discard messages the producer delivered more than once
+	KafkaConsumerDedupWindow = 10000
+
+	// WarehouseExportInterval is how often completed transactions are exported to the warehouse
+	WarehouseExportInterval = time.Minute
+
+	// WarehouseBatchSize is the maximum number of transactions exported per warehouse file
+	WarehouseBatchSize = 1000
+
+	// MetricsEventBufferSize is how many events the realtime metrics buffer before the event bus drops them
+	MetricsEventBufferSize = 1024
+
+	// AnomalyInterval is how often gateway failure rates and latency are compared with their baselines
+	AnomalyInterval = time.Minute
+
+	// DefaultAnomalyThreshold is the z-score above a gateway's baseline that counts as a spike
+	DefaultAnomalyThreshold = 3.0
+
+	// AnomalyMinSamples is how many calls an interval needs before it is evaluated; quieter
+	// intervals are merged into the next one
+	AnomalyMinSamples = 20
+
+	// AnomalyWarmupIntervals is how many normal intervals form a baseline before spikes are reported
+	AnomalyWarmupIntervals = 5
+
+	// AnomalyBaselineWeight is the weight of each new normal interval in a gateway's baseline
+	AnomalyBaselineWeight = 0.1
+
+	// AnomalyMinBaselineFailureRate keeps a near-perfect baseline from making every failure significant
+	AnomalyMinBaselineFailureRate = 0.01
+
+	// AnomalyMinFailureRateIncrease is the smallest failure rate increase reported as a spike
+	AnomalyMinFailureRateIncrease = 0.05
+
+	// AnomalyMinLatencyRatio is how many times its baseline mean latency must be before a spike is reported
+	AnomalyMinLatencyRatio = 1.5
+
+	// AnomalyDowngradeDuration is how long a gateway is downgraded in routing after a spike
+	AnomalyDowngradeDuration = 10 * time.Minute
+
+	// MaxAnomalyAlerts is how many recent anomaly alerts are kept for admins
+	MaxAnomalyAlerts = 100
+
+	// MaintenanceRefreshInterval is how often maintenance windows are reloaded, picking up
+	// windows scheduled through other instances
+	MaintenanceRefreshInterval = time.Minute
+
+	// MaintenanceWarningPeriod is how far ahead the status endpoint warns of maintenance windows
+	MaintenanceWarningPeriod = 24 * time.Hour
+
+	// MaxMaintenanceWindow is the longest maintenance window that can be scheduled
+	MaxMaintenanceWindow = 7 * 24 * time.Hour
+
+	// DeclineReportBINLength is how many leading digits of a card BIN identify its range in decline reports
+	DeclineReportBINLength = 6
+
+	// DeclineReportDefaultDays is how many days a decline report covers when no period is given
+	DeclineReportDefaultDays = 30
+
+	// MaxDeclineReportDays is the longest period a decline report can cover
+	MaxDeclineReportDays = 366
+
+	// RecoveryHintRefreshInterval is how often decline recovery hints are reloaded, picking up
+	// changes made through other instances
+	RecoveryHintRefreshInterval = time.Minute
+
+	// ClientCertificateRefreshInterval is how often gateways' client certificates are reloaded,
+	// so certificates rotated through another instance are picked up
+	ClientCertificateRefreshInterval = time.Minute
+
+	// ClientCertificateExpiryWarning is how long before a client certificate expires that refreshes
+	// start logging a warning
+	ClientCertificateExpiryWarning = 30 * 24 * time.Hour
+
+	// MerchantCertificateRefreshInterval is how often Apple Pay and Google Pay merchant
+	// certificates are reloaded, so certificates rotated through another instance are picked up
+	MerchantCertificateRefreshInterval = time.Minute
+
+	// WalletTokenMaxAge is how long after an Apple Pay token was signed it can be paid with
+	WalletTokenMaxAge = 5 * time.Minute
+
+	// SigningKeyRefreshInterval is how often gateways' signing keys are reloaded, so keys rotated
+	// through another instance are picked up
+	SigningKeyRefreshInterval = time.Minute
+
+	// ConsentExpiryInterval is how often payment consents past their expiry are expired and their
+	// deposits failed
+	ConsentExpiryInterval = time.Minute
+
+	// ConsentExpiryBatchSize is the maximum number of payment consents expired per run
+	ConsentExpiryBatchSize = 100
+
+	// PaymentExpiryInterval is how often deposits at gateways with a payment window, such as PIX,
+	// are failed once their window closed unpaid
+	PaymentExpiryInterval = time.Minute
+
+	// PaymentExpiryBatchSize is the maximum number of deposits of a gateway expired per run
+	PaymentExpiryBatchSize = 100
+
+	// PaymentExpiryGrace is how long past its window a deposit is left unpaid before it is failed,
+	// so a payment made at the last moment is notified first
+	PaymentExpiryGrace = 2 * time.Minute
+
+	// AuthorizationExpiryInterval is how often authorized deposits whose hold lapsed are voided
+	AuthorizationExpiryInterval = time.Minute
+
+	// AuthorizationExpiryBatchSize is the maximum number of authorizations expired per run
+	AuthorizationExpiryBatchSize = 100
+
+	// DefaultAuthorizationValidity is how long gateways that don't say otherwise hold an
+	// authorized amount, as card networks do for most merchants
+	DefaultAuthorizationValidity = 7 * 24 * time.Hour
+
+	// HoldReleaseInterval is how often holds past their deadline are released
+	HoldReleaseInterval = time.Minute
+
+	// HoldReleaseBatchSize is the maximum number of holds released per run
+	HoldReleaseBatchSize = 100
+
+	// DefaultHoldDuration is how long a deposit is held for review unless the hold says otherwise
+	DefaultHoldDuration = 72 * time.Hour
+
+	// MaxHoldDuration is how long after it was placed a hold can be extended to at most
+	MaxHoldDuration = 30 * 24 * time.Hour
+
+	// IdempotencyKeyTTL is how long the response to a request sent with an idempotency key is
+	// replayed to retries of the request
+	IdempotencyKeyTTL = 24 * time.Hour
+
+	// IdempotencyKeyLockTimeout is how long a request sent with an idempotency key can run before
+	// the key is taken as abandoned, as by a crashed instance, and a retry runs the request again
+	IdempotencyKeyLockTimeout = 5 * time.Minute
+
+	// IdempotencyKeyPurgeInterval is how often expired idempotency keys are deleted
+	IdempotencyKeyPurgeInterval = time.Hour
+
+	// IdempotencyKeyPurgeBatchSize is the maximum number of idempotency keys deleted per run
+	IdempotencyKeyPurgeBatchSize = 1000
+
+	// DefaultPixExpiry is how long PIX charges can be paid for
+	DefaultPixExpiry = time.Hour
+
+	// DefaultUPIExpiry is how long UPI collect requests and intent links can be paid for
+	DefaultUPIExpiry = 15 * time.Minute
+
+	// DefaultUPIStatusPollDelay is how long after its submission a UPI deposit is first polled
+	// for its status, should its callback not have arrived
+	DefaultUPIStatusPollDelay = time.Minute
+
+	// StatusPollInterval is how often transactions in flight at gateways whose callbacks can't be
+	// relied on are polled for their status
+	StatusPollInterval = time.Minute
+
+	// StatusPollBatchSize is the maximum number of transactions of a gateway polled per run
+	StatusPollBatchSize = 100
+
+	// StatusPollMaxAge is how long after their submission transactions are polled at gateways
+	// without a payment window; those with one are polled until it closed
+	StatusPollMaxAge = 24 * time.Hour
+
+	// ShutdownTimeout is how long the server waits for requests and background job runs in progress
+	// to finish when asked to shut down
+	ShutdownTimeout = 30 * time.Second
+
+	// StaleTransactionInterval is how often transactions left pending or processing past the stale
+	// transaction TTL are expired
+	StaleTransactionInterval = 5 * time.Minute
+
+	// StaleTransactionBatchSize is the maximum number of stale transactions expired per run
+	StaleTransactionBatchSize = 100
+
+	// DefaultStaleTransactionTTL is how long a transaction can stay pending or processing without
+	// changing before it is expired, as its gateway is then taken never to report an outcome
+	DefaultStaleTransactionTTL = 72 * time.Hour
+
+	// PayoutInterval is how often due scheduled withdrawals are paid out
+	PayoutInterval = time.Minute
+
+	// PayoutBatchSize is the maximum number of scheduled withdrawals paid out per run
+	PayoutBatchSize = 100
+
+	// AutoReloadInterval is how often wallets with auto-reload rules are checked against their thresholds
+	AutoReloadInterval = time.Minute
+
+	// AutoReloadBatchSize is the maximum number of auto-reload rules checked per run
+	AutoReloadBatchSize = 100
+
+	// AutoReloadClaimTTL is how long a claimed auto-reload rule is left to the instance that claimed it
+	AutoReloadClaimTTL = 30 * time.Second
+
+	// AutoReloadRetryDelay is how long after a failed auto-reload the next is submitted
+	AutoReloadRetryDelay = time.Hour
+
+	// Default safety caps of auto-reloads: the amount of one reload, reloads per rule within
+	// AutoReloadLimitWindow, and consecutive failed reloads after which a rule is disabled
+	DefaultAutoReloadMaxAmount   = 1000.0
+	DefaultAutoReloadDailyCount  = 3
+	DefaultAutoReloadMaxFailures = 3
+
+	// AutoReloadLimitWindow is the window the daily count of auto-reloads is counted over, from a
+	// rule's first reload in it
+	AutoReloadLimitWindow = 24 * time.Hour
+
+	// ReconciliationInterval is how often merchants' reconciliation files for the previous day are
+	// generated, once ReconciliationDelay has passed since the day ended
+	ReconciliationInterval = 10 * time.Minute
+
+	// ReconciliationDelay is how long after the end of a UTC day its reconciliation files are
+	// generated, leaving time for late gateway callbacks
+	ReconciliationDelay = 2 * time.Hour
+
+	// ReconciliationDefaultDays is how many days of reconciliation files are listed when no period is given
+	ReconciliationDefaultDays = 30
+
+	// MaxReconciliationDays is the longest period reconciliation files can be listed for at once
+	MaxReconciliationDays = 366
+
+	// DefaultStatusQueryCacheTTL is how long the status a gateway reported for a transaction looked
+	// up while in flight is reused before the gateway is asked again
+	DefaultStatusQueryCacheTTL = 5 * time.Second
+
+	// StatusQueryTimeout bounds a gateway status query shared by the lookups waiting for it
+	StatusQueryTimeout = 10 * time.Second
+
+	// BankTransferSettlementDays is how many banking days after submission a withdrawal is expected to settle
+	BankTransferSettlementDays = 1
+
+	// BankingCalendarLookaheadDays is how many days of bank holidays are loaded to find the next banking day
+	BankingCalendarLookaheadDays = 31
+
+	// MaxOutboxListResults is the maximum number of outbox messages returned to an admin
+	MaxOutboxListResults = 100
+
+	// MaxDisputeListResults is the maximum number of disputes returned from the review queue
+	MaxDisputeListResults = 100
+
+	// MaxHoldListResults is the maximum number of transaction holds returned from the review queue
+	MaxHoldListResults = 100
+
+	// MaxAMLCaseListResults is the maximum number of AML cases returned from the review queue
+	MaxAMLCaseListResults = 100
+
+	// MaxAMLReportCases is the maximum number of AML cases exported in one report file
+	MaxAMLReportCases = 1000
+
+	// MaxScreeningCaseListResults is the maximum number of screening cases returned from the review queue
+	MaxScreeningCaseListResults = 100
+
+	// Default limits of the wallet transfers a user sends, in the transfer's currency
+	DefaultTransferMaxAmount          = 10000.0
+	DefaultTransferDailyAmount        = 25000.0
+	DefaultTransferDailyCount         = 20
+	DefaultTransferNewRecipientAmount = 1000.0 // first transfer to a recipient; larger ones are declined as suspected fraud
+
+	// TransferLimitWindow is the rolling window daily transfer limits are counted over
+	TransferLimitWindow = 24 * time.Hour
+
+	// MaxOutboxBackoff caps the delay between delivery attempts of an outbox message
+	MaxOutboxBackoff = 5 * time.Minute
+
+	// APIKeyRollGracePeriod is how long a rolled API key keeps working alongside its replacement
+	APIKeyRollGracePeriod = 24 * time.Hour
+
+	// APIKeyLastUsedResolution limits how often an API key's last-used time is written
+	APIKeyLastUsedResolution = time.Minute
+
+	// APIKeyPrefix starts every API key, so leaked keys are easy to recognize
+	APIKeyPrefix = "pgk_"
+
+	// OAuthClientIDPrefix starts every OAuth2 client ID
+	OAuthClientIDPrefix = "pgc_"
+
+	// DefaultReferencePrefix starts transaction references unless REFERENCE_PREFIX is set
+	DefaultReferencePrefix = "PG"
+
+	// MandateReferencePrefix starts the unique mandate references (UMR) of SEPA Direct Debit mandates
+	MandateReferencePrefix = "MNDT"
+
+	// APIKeyHeader carries an API key for clients that cannot send an Authorization header
+	APIKeyHeader = "X-Api-Key"
+
+	// IdempotencyKeyHeader carries a client's key for a deposit or withdrawal, so retries of the
+	// request get the original response instead of creating another transaction
+	IdempotencyKeyHeader = "Idempotency-Key"
+
+	// IdempotentReplayedHeader is set on responses replayed for a repeated idempotency key
+	IdempotentReplayedHeader = "Idempotent-Replayed"
+
+	// MaxIdempotencyKeyLength is the maximum length of an idempotency key
+	MaxIdempotencyKeyLength = 255
+
+	// OAuthTokenTTL is the default lifetime of access tokens issued by the token endpoint
+	OAuthTokenTTL = time.Hour
+
+	// MaxCachedTokens bounds the number of verified access tokens cached by the middleware
+	MaxCachedTokens = 10000
+
+	// JWKSRefreshInterval is how often an identity provider's signing keys are refetched
+	JWKSRefreshInterval = time.Hour
+
+	// JWKSMinRefreshInterval limits refetching signing keys when a token names an unknown key
+	JWKSMinRefreshInterval = time.Minute
+
+	// MaxSearchResults is the maximum number of transactions returned by an admin search
+	MaxSearchResults = 50
+
+	// MaxTransactionTags is the maximum number of tags a transaction carries
+	MaxTransactionTags = 20
+
+	// MaxWebhookEndpoints is the maximum number of webhook endpoints a merchant configures
+	MaxWebhookEndpoints = 16
+
+	// DefaultTransactionListLimit and MaxTransactionListLimit bound the pages of transaction lists
+	DefaultTransactionListLimit = 50
+	MaxTransactionListLimit     = 200
+
+	// MinWebhookSecretLength is the minimum length of a webhook secret supplied by an admin
+	MinWebhookSecretLength = 16
+
+	// MaxCallbackBodySize is the maximum accepted size in bytes of a gateway callback
+	MaxCallbackBodySize = 1 << 20
+
+	// WebhookSignatureHeader carries the HMAC-SHA256 signature of a callback body as "sha256=<hex>"
+	WebhookSignatureHeader = "X-Webhook-Signature"
+)
+
+// APIVersion is the version reported by the health check and published specifications
+const APIVersion = "1.0.0"
+
+// Dependencies reported by the health and readiness endpoints
+const (
+	DependencyDatabase = "database"
+	DependencyKafka    = "kafka"
+)
+
+// Health check results of a dependency
+const (
+	HealthUp      = "up"
+	HealthDown    = "down"
+	HealthTimeout = "timeout"
+)
+
+// RequestLogMaxBodyBytes is the default size above which logged request and response bodies are
+// summarized rather than written in full
+const RequestLogMaxBodyBytes = 16 << 10
+
+// HealthCheckTimeout bounds each dependency check of the health endpoint, so a wedged connection
+// cannot hold the response past the server's write timeout
+const HealthCheckTimeout = 2 * time.Second
+
+const (
+	DepositRoute        = "/deposit"
+	WithdrawRoute       = "/withdraw"
+	CallbackRoute       = "/callback"
+	HealthRoute         = "/health"
+	ReadyRoute          = "/ready"
+	BatchDepositRoute   = "/deposits/batch"
+	BulkWithdrawRoute   = "/withdrawals/batch"
+	OperationsRoute     = "/operations"
+	TransactionsRoute   = "/transactions"
+	AsyncAPIRoute       = "/docs/asyncapi.json"
+	JWKSRoute           = "/.well-known/jwks.json"
+	PaymentMethodsRoute = "/payment-methods"
+
+	// Open banking routes: the bank directory and the redirect banks send customers back to
+	OpenBankingBanksRoute    = "/open-banking/banks"
+	OpenBankingCallbackRoute = "/open-banking/callback"
+
+	// Bank account routes: linking the accounts withdrawals are paid out to and the redirect
+	// banks send users back to
+	BankAccountsRoute        = "/bank-accounts"
+	BankAccountLinkRoute     = "/bank-accounts/link"
+	BankAccountCallbackRoute = "/bank-accounts/callback"
+
+	// SEPA Direct Debit mandates deposits are debited under and withdrawals are paid out to
+	MandatesRoute = "/mandates"
+
+	// Wallet routes: transfers between users' wallets, their balances and rules topping them up
+	TransfersRoute       = "/transfers"
+	WalletsRoute         = "/wallets"
+	AutoReloadRulesRoute = "/auto-reload-rules"
+
+	// UPI virtual payment addresses customers are collected from
+	UPIVPAValidateRoute = "/upi/vpa/validate"
+
+	// Refunds of completed deposits
+	RefundRoute = "/refund"
+
+	// Deposits authorized now and captured or voided later
+	AuthorizeRoute              = "/authorize"
+	CaptureRoute                = "/capture"
+	VoidRoute                   = "/void"
+	IncrementAuthorizationRoute = "/increment-authorization"
+
+	// Admin routes, authenticated with the admin token when one is configured
+	AdminRoutePrefix       = "/admin/"
+	AdminArchivalRoute     = "/admin/archival"
+	AdminTransactionsRoute = "/admin/transactions"
+	AdminGatewaysRoute     = "/admin/gateways"
+	AdminSearchRoute       = "/admin/search"
+	AdminCallbacksRoute    = "/admin/callbacks"
+	AdminMerchantsRoute    = "/admin/merchants"
+	AdminUsersRoute        = "/admin/users"
+	AdminOutboxRoute       = "/admin/outbox"
+	AdminMetricsRoute      = "/admin/metrics"
+	AdminAnomaliesRoute    = "/admin/anomalies"
+	AdminRoutingRoute      = "/admin/routing"
+	AdminCountriesRoute    = "/admin/countries"
+	AdminDeclineCodesRoute = "/admin/decline-codes"
+	AdminDisputesRoute     = "/admin/disputes"
+	AdminHoldsRoute        = "/admin/holds"
+	AdminAMLCasesRoute     = "/admin/aml-cases"
+	AdminScreeningRoute    = "/admin/screening-cases"
+
+	// Certificates Apple Pay and Google Pay encrypt payment tokens to
+	AdminMerchantCertificatesRoute = "/admin/merchant-certificates"
+
+	// Merchant self-service routes, authenticated with an API key or OAuth2 access token
+	MerchantAPIKeysRoute          = "/merchant/api-keys"
+	MerchantRoutingRulesRoute     = "/merchant/routing-rules"
+	MerchantWebhookSecretRoute    = "/merchant/webhook-secret"
+	MerchantWebhookEndpointsRoute = "/merchant/webhook-endpoints"
+	MerchantPayoutScheduleRoute   = "/merchant/payout-schedule"
+	MerchantReportsRoute          = "/merchant/reports"
+	MerchantReconciliationRoute   = "/merchant/reports/reconciliation"
+	MerchantTransactionsRoute     = "/merchant/transactions"
+	WebhookVerifyRoute            = "/webhooks/verify"
+
+	// OAuthTokenRoute issues OAuth2 client-credentials access tokens
+	OAuthTokenRoute = "/oauth/token"
 )
@@ -0,0 +1,155 @@
+package events
+
+import (
+	"log"
+	"payment-gateway/internal/models"
+	"sync"
+	"time"
+)
+
+// Transaction lifecycle event types
+const (
+	TransactionCreated       = "transaction.created"
+	TransactionStatusChanged = "transaction.status_changed"
+)
+
+// Wallet transfer event types. Transfers are only delivered to merchant webhooks, never
+// published on the bus.
+const (
+	TransferCompleted = "transfer.completed"
+	TransferFailed    = "transfer.failed"
+)
+
+// Auto-reload event types, delivered to merchant webhooks only: a reload that failed, and a
+// rule the safety caps disabled
+const (
+	AutoReloadFailed   = "auto_reload.failed"
+	AutoReloadDisabled = "auto_reload.disabled"
+)
+
+// ReconciliationFileReady announces a merchant's generated or regenerated reconciliation file,
+// delivered to merchant webhooks only
+const ReconciliationFileReady = "reconciliation.file_ready"
+
+// DefaultBufferSize is the per-subscriber channel capacity used when none is given
+const DefaultBufferSize = 64
+
+// TransactionEvent describes a change in a transaction's lifecycle
+type TransactionEvent struct {
+	Type        string             `json:"type"`
+	Transaction models.Transaction `json:"transaction"`
+	OccurredAt  time.Time          `json:"occurred_at"`
+}
+
+// TransferEvent describes a wallet transfer that completed or was declined
+type TransferEvent struct {
+	Type       string          `json:"type"`
+	Transfer   models.Transfer `json:"transfer"`
+	OccurredAt time.Time       `json:"occurred_at"`
+}
+
+// AutoReloadEvent describes an auto-reload that failed or a rule that was disabled
+type AutoReloadEvent struct {
+	Type          string                `json:"type"`
+	Rule          models.AutoReloadRule `json:"rule"`
+	TransactionID int                   `json:"transaction_id,omitempty"` // the reload's deposit, if one was created
+	Message       string                `json:"message"`
+	OccurredAt    time.Time             `json:"occurred_at"`
+}
+
+// ReconciliationEvent describes a reconciliation file ready to download
+type ReconciliationEvent struct {
+	Type       string                    `json:"type"`
+	File       models.ReconciliationFile `json:"file"`
+	OccurredAt time.Time                 `json:"occurred_at"`
+}
+
+// Filter restricts which events a subscriber receives. Zero values match everything.
+type Filter struct {
+	Statuses []string
+}
+
+// Matches reports whether an event passes the filter
+func (f Filter) Matches(evt TransactionEvent) bool {
+	if len(f.Statuses) == 0 {
+		return true
+	}
+	for _, status := range f.Statuses {
+		if evt.Transaction.Status == status {
+			return true
+		}
+	}
+	return false
+}
+
+type subscription struct {
+	filter Filter
+	ch     chan TransactionEvent
+}
+
+// Bus is an in-process publish/subscribe hub for transaction lifecycle events. It feeds
+// consumers within the instance, such as the realtime metrics; durable delivery to Kafka and
+// merchant webhooks goes through the outbox.
+type Bus struct {
+	mu     sync.RWMutex
+	subs   map[int]*subscription
+	nextID int
+}
+
+// NewBus creates a new event bus
+func NewBus() *Bus {
+	return &Bus{subs: make(map[int]*subscription)}
+}
+
+// Publish delivers an event to every matching subscriber. Delivery never blocks the
+// publisher: events are dropped for subscribers whose buffer is full.
+func (b *Bus) Publish(evt TransactionEvent) {
+	if evt.OccurredAt.IsZero() {
+		evt.OccurredAt = time.Now()
+	}
+
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	for id, sub := range b.subs {
+		if !sub.filter.Matches(evt) {
+			continue
+		}
+
+		select {
+		case sub.ch <- evt:
+		default:
+			log.Printf("Dropping %s event for transaction %d: subscriber %d is not keeping up", evt.Type, evt.Transaction.ID, id)
+		}
+	}
+}
+
+// Subscribe registers a subscriber for events matching the filter. The returned cancel
+// function unregisters the subscriber and closes the channel.
+func (b *Bus) Subscribe(filter Filter, bufferSize int) (<-chan TransactionEvent, func()) {
+	if bufferSize <= 0 {
+		bufferSize = DefaultBufferSize
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	id := b.nextID
+	b.nextID++
+
+	sub := &subscription{filter: filter, ch: make(chan TransactionEvent, bufferSize)}
+	b.subs[id] = sub
+
+	var once sync.Once
+	cancel := func() {
+		once.Do(func() {
+			b.mu.Lock()
+			defer b.mu.Unlock()
+
+			delete(b.subs, id)
+			close(sub.ch)
+		})
+	}
+
+	return sub.ch, cancel
+}
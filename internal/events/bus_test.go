@@ -0,0 +1,50 @@
+package events
+
+import (
+	"payment-gateway/internal/models"
+	"testing"
+)
+
+// TestBusFiltersEvents tests that subscribers only receive events matching their filter
+func TestBusFiltersEvents(t *testing.T) {
+	bus := NewBus()
+
+	allEvents, cancelAll := bus.Subscribe(Filter{}, 10)
+	defer cancelAll()
+
+	completedEvents, cancelCompleted := bus.Subscribe(Filter{Statuses: []string{"completed"}}, 10)
+	defer cancelCompleted()
+
+	bus.Publish(TransactionEvent{Type: TransactionCreated, Transaction: models.Transaction{ID: 1, UserID: 1, Status: "pending"}})
+	bus.Publish(TransactionEvent{Type: TransactionStatusChanged, Transaction: models.Transaction{ID: 2, UserID: 2, Status: "completed"}})
+
+	if len(allEvents) != 2 {
+		t.Fatalf("Expected both events, got %d", len(allEvents))
+	}
+	if evt := <-allEvents; evt.Transaction.ID != 1 || evt.OccurredAt.IsZero() {
+		t.Errorf("Unexpected first event: %+v", evt)
+	}
+
+	if len(completedEvents) != 1 {
+		t.Fatalf("Expected 1 completed event, got %d", len(completedEvents))
+	}
+	if evt := <-completedEvents; evt.Transaction.ID != 2 {
+		t.Errorf("Unexpected completed event: %+v", evt)
+	}
+}
+
+// TestBusCancelClosesChannel tests that cancelling a subscription closes its channel
+func TestBusCancelClosesChannel(t *testing.T) {
+	bus := NewBus()
+
+	ch, cancel := bus.Subscribe(Filter{}, 1)
+	cancel()
+	cancel() // safe to call twice
+
+	if _, ok := <-ch; ok {
+		t.Error("Expected channel to be closed after cancel")
+	}
+
+	// Publishing after cancel must not panic
+	bus.Publish(TransactionEvent{Type: TransactionCreated})
+}
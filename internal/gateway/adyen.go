@@ -0,0 +1,508 @@
+package gateway
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"payment-gateway/internal/consts"
+	"payment-gateway/internal/httpclient"
+	"payment-gateway/internal/models"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// Adyen API settings. Live endpoints are specific to the merchant's company account and are
+// built from its live URL prefix.
+const (
+	AdyenCheckoutTestURL = "https://checkout-test.adyen.com/v71"
+	AdyenPayoutTestURL   = "https://pal-test.adyen.com/pal/servlet/Payout/v68"
+	AdyenAPIKeyHeader    = "X-API-Key"
+)
+
+// AdyenConfig configures the Adyen provider. API keys are the provider's environments; a base
+// URL, when set, serves both APIs under their live paths (/checkout/v71 and
+// /pal/servlet/Payout/v68), e.g. behind a proxy.
+type AdyenConfig struct {
+	MerchantAccount string
+
+	// LiveURLPrefix is the company account's prefix of live endpoints, e.g. "1797a841fbb37ca7-AdyenDemo".
+	// Required for livemode transactions unless the production environment has a base URL.
+	LiveURLPrefix string
+
+	// HMACKeys are the hex-encoded HMAC keys of the webhooks Adyen sends notifications to.
+	// Notifications signed with any of them are accepted, so keys can be rotated.
+	HMACKeys []string
+
+	// PaymentMethodType is the payment method deposits are made with, e.g. "ideal" or "trustly".
+	// The customer is redirected to complete the payment.
+	PaymentMethodType string
+
+	// WalletTokens are the consts.Wallet* wallets whose payment tokens deposits are paid with as
+	// they are, for merchant certificates registered with Adyen. Apple Pay and Google Pay deposits
+	// don't redirect the customer.
+	WalletTokens []string
+
+	// ReturnURL is where the customer is sent back after paying, for transactions without a return URL
+	ReturnURL string
+
+	Timeout time.Duration // per attempt; 30 seconds when zero
+}
+
+// AdyenProvider is a gateway adapter for Adyen. Deposits are made with the Checkout API and
+// withdrawals with the Payout API to payout details stored for the user, and both are settled by
+// notification webhooks. Requests carry the transaction's idempotency key, so retries never
+// create a second payment or payout. Adyen echoes the transaction ID, sent as the merchant
+// reference, in every notification.
+type AdyenProvider struct {
+	id           string
+	name         string
+	config       AdyenConfig
+	client       *httpclient.Client
+	declineCodes DeclineCodeMap
+	environments Environments
+	available    atomic.Bool
+}
+
+// NewAdyenProvider creates an Adyen provider. SetEnvironments must be called with the API keys
+// before it processes transactions.
+func NewAdyenProvider(id int, name string, config AdyenConfig) *AdyenProvider {
+	clientConfig := httpclient.DefaultConfig(name)
+	if config.Timeout > 0 {
+		clientConfig.Timeout = config.Timeout
+	}
+
+	p := &AdyenProvider{
+		id:           strconv.Itoa(id),
+		name:         name,
+		config:       config,
+		client:       httpclient.New(clientConfig),
+		declineCodes: AdyenDeclineCodes,
+	}
+	p.SetEnvironments(Environments{Sandbox: Environment{Name: EnvironmentSandbox}})
+	p.available.Store(true)
+	return p
+}
+
+// SetEnvironments configures the sandbox and production environments
+func (p *AdyenProvider) SetEnvironments(environments Environments) {
+	p.environments = environments
+}
+
+// ID returns the unique identifier of the gateway
+func (p *AdyenProvider) ID() string {
+	return p.id
+}
+
+// Name returns the name of the gateway
+func (p *AdyenProvider) Name() string {
+	return p.name
+}
+
+// DataFormat returns the data format supported by the gateway
+func (p *AdyenProvider) DataFormat() string {
+	return "application/json"
+}
+
+// IsAvailable reports whether the last request reached Adyen
+func (p *AdyenProvider) IsAvailable() bool {
+	return p.available.Load()
+}
+
+// ProcessDeposit makes a payment with the configured payment method. Payments the customer must
+// complete return the page to redirect them to; the outcome arrives as a notification.
+func (p *AdyenProvider) ProcessDeposit(ctx context.Context, transaction models.Transaction) (*models.TransactionResponse, error) {
+	returnURL := transaction.ReturnURL
+	if returnURL == "" {
+		returnURL = p.config.ReturnURL
+	}
+
+	request := adyenPaymentRequest{
+		Amount:                 p.amount(transaction),
+		MerchantAccount:        p.config.MerchantAccount,
+		Reference:              strconv.Itoa(transaction.ID),
+		MerchantOrderReference: transaction.ReferenceID,
+		PaymentMethod:          p.paymentMethod(transaction),
+		ReturnURL:              returnURL,
+		ShopperReference:       strconv.Itoa(transaction.UserID),
+	}
+
+	env, err := p.environments.Select(transaction.Livemode)
+	if err != nil {
+		return nil, err
+	}
+	checkoutURL, _, err := p.endpoints(env)
+	if err != nil {
+		return nil, err
+	}
+
+	var result adyenResult
+	if err := p.post(ctx, env, transaction, checkoutURL+"/payments", request, &result); err != nil {
+		return nil, err
+	}
+	response, err := p.response(transaction, result)
+	if err != nil {
+		return nil, err
+	}
+	if result.Action != nil {
+		response.RedirectURL = result.Action.URL
+	}
+	return response, nil
+}
+
+// AcceptsWalletToken reports whether deposits paid with a wallet's tokens are sent to Adyen as they are
+func (p *AdyenProvider) AcceptsWalletToken(wallet string) bool {
+	for _, accepted := range p.config.WalletTokens {
+		if accepted == wallet {
+			return true
+		}
+	}
+	return false
+}
+
+// paymentMethod returns the payment method of a deposit: the wallet token it is paid with, or the
+// configured payment method
+func (p *AdyenProvider) paymentMethod(transaction models.Transaction) map[string]string {
+	token := transaction.WalletToken
+	switch {
+	case token != nil && token.Type == consts.WalletApplePay:
+		// Apple Pay's paymentData is sent base64 encoded
+		return map[string]string{"type": "applepay", "applePayToken": base64.StdEncoding.EncodeToString([]byte(token.Data))}
+	case token != nil && token.Type == consts.WalletGooglePay:
+		return map[string]string{"type": "googlepay", "googlePayToken": token.Data}
+	}
+	return map[string]string{"type": p.config.PaymentMethodType}
+}
+
+// ProcessWithdrawal pays out to the transaction's beneficiary, the reference of payout details
+// stored in Adyen for the user, whose shopper reference is the user ID
+func (p *AdyenProvider) ProcessWithdrawal(ctx context.Context, transaction models.Transaction) (*models.TransactionResponse, error) {
+	request := adyenPayoutRequest{
+		Amount:                           p.amount(transaction),
+		MerchantAccount:                  p.config.MerchantAccount,
+		Reference:                        strconv.Itoa(transaction.ID),
+		ShopperReference:                 strconv.Itoa(transaction.UserID),
+		SelectedRecurringDetailReference: transaction.Beneficiary,
+	}
+	request.Recurring.Contract = "PAYOUT"
+
+	env, err := p.environments.Select(transaction.Livemode)
+	if err != nil {
+		return nil, err
+	}
+	_, payoutURL, err := p.endpoints(env)
+	if err != nil {
+		return nil, err
+	}
+
+	var result adyenResult
+	if err := p.post(ctx, env, transaction, payoutURL+"/payout", request, &result); err != nil {
+		return nil, err
+	}
+	return p.response(transaction, result)
+}
+
+// ProcessRefund requests a refund of part or all of a deposit's payment. Adyen only acknowledges
+// the request, so the refund is processing until Adyen settles it.
+func (p *AdyenProvider) ProcessRefund(ctx context.Context, refund models.Refund, transaction models.Transaction) (*models.TransactionResponse, error) {
+	if transaction.GatewayReference == "" {
+		return nil, fmt.Errorf("transaction %d has no Adyen reference", transaction.ID)
+	}
+
+	refunded := refundTransaction(refund, transaction)
+	request := adyenRefundRequest{
+		Amount:          p.amount(refunded),
+		MerchantAccount: p.config.MerchantAccount,
+		Reference:       "refund-" + strconv.Itoa(refund.ID),
+	}
+
+	env, err := p.environments.Select(transaction.Livemode)
+	if err != nil {
+		return nil, err
+	}
+	checkoutURL, _, err := p.endpoints(env)
+	if err != nil {
+		return nil, err
+	}
+
+	var result struct {
+		PSPReference string `json:"pspReference"`
+		Status       string `json:"status"`
+	}
+	if err := p.post(ctx, env, refunded, checkoutURL+"/payments/"+url.PathEscape(transaction.GatewayReference)+"/refunds", request, &result); err != nil {
+		return nil, err
+	}
+	if result.Status != "received" {
+		return nil, fmt.Errorf("unexpected Adyen refund status %q", result.Status)
+	}
+
+	return &models.TransactionResponse{
+		Status:           consts.Processing,
+		TransactionID:    transaction.ID,
+		GatewayReference: result.PSPReference,
+	}, nil
+}
+
+// ParseCallback verifies the HMAC signature of a notification and maps it to the transaction it
+// settles. Adyen sends one notification item per webhook request.
+func (p *AdyenProvider) ParseCallback(r *http.Request) (*models.CallbackData, error) {
+	var notification struct {
+		NotificationItems []struct {
+			Item adyenNotificationItem `json:"NotificationRequestItem"`
+		} `json:"notificationItems"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&notification); err != nil {
+		return nil, fmt.Errorf("invalid Adyen notification: %w", err)
+	}
+	if len(notification.NotificationItems) == 0 {
+		return nil, errors.New("adyen notification has no items")
+	}
+	item := notification.NotificationItems[0].Item
+
+	if err := p.verifySignature(item); err != nil {
+		return nil, err
+	}
+
+	callbackData := &models.CallbackData{
+		GatewayReference: item.PSPReference,
+		GatewayID:        p.id,
+		Timestamp:        item.EventDate,
+	}
+	var err error
+	if callbackData.TransactionID, err = strconv.Atoi(item.MerchantReference); err != nil {
+		return nil, fmt.Errorf("invalid transaction ID %q in Adyen notification %s", item.MerchantReference, item.PSPReference)
+	}
+
+	success := item.Success == "true"
+	switch item.EventCode {
+	case "AUTHORISATION", "PAYOUT_THIRDPARTY":
+		if success {
+			callbackData.Status = consts.Completed
+		} else {
+			callbackData.Status = consts.Failed
+			callbackData.ReasonCode = item.Reason
+			callbackData.Message = item.Reason
+		}
+	case "PAYOUT_DECLINE", "PAIDOUT_REVERSED":
+		callbackData.Status = consts.Failed
+		callbackData.ReasonCode = item.Reason
+		callbackData.Message = item.Reason
+	case "CANCELLATION", "OFFER_CLOSED", "PAYOUT_EXPIRE":
+		// Failed cancellations leave the payment as it was
+		if !success {
+			return nil, fmt.Errorf("adyen %s notification for %s was not successful", item.EventCode, item.PSPReference)
+		}
+		callbackData.Status = consts.Failed
+		callbackData.Message = strings.ToLower(item.EventCode)
+	default:
+		return nil, fmt.Errorf("unsupported Adyen event code %q", item.EventCode)
+	}
+
+	if callbackData.ReasonCode != "" {
+		callbackData.DeclineCode = p.declineCodes.Normalize(callbackData.ReasonCode)
+	}
+	return callbackData, nil
+}
+
+// response maps the resultCode of a payment or payout to a transaction response. Refusals are
+// returned as declines, and payments that were cancelled or could not be processed as errors.
+func (p *AdyenProvider) response(transaction models.Transaction, result adyenResult) (*models.TransactionResponse, error) {
+	response := &models.TransactionResponse{
+		TransactionID:    transaction.ID,
+		GatewayReference: result.PSPReference,
+	}
+
+	switch result.ResultCode {
+	case "Authorised":
+		response.Status = consts.Completed
+	case "Received", "Pending", "PresentToShopper", "RedirectShopper", "IdentifyShopper", "ChallengeShopper":
+		response.Status = consts.Processing
+	case "Refused":
+		return nil, &DeclineError{Code: p.declineCodes.Normalize(result.RefusalReason), ProviderCode: result.RefusalReason, Message: result.RefusalReason}
+	case "Cancelled":
+		return nil, fmt.Errorf("adyen cancelled payment %s", result.PSPReference)
+	case "Error":
+		return nil, fmt.Errorf("adyen could not process payment %s: %s", result.PSPReference, result.RefusalReason)
+	default:
+		return nil, fmt.Errorf("unexpected Adyen result code %q", result.ResultCode)
+	}
+	return response, nil
+}
+
+// amount returns the transaction amount in minor units, as Adyen expects
+func (p *AdyenProvider) amount(transaction models.Transaction) adyenAmount {
+	return adyenAmount{Value: minorUnits(transaction.Amount, transaction.Currency), Currency: transaction.Currency}
+}
+
+// endpoints returns the Checkout and Payout API URLs of an environment: under its base URL when
+// set, Adyen's test endpoints in sandbox, and the company account's live endpoints in production
+func (p *AdyenProvider) endpoints(env Environment) (checkoutURL, payoutURL string, err error) {
+	if env.BaseURL != "" {
+		base := strings.TrimSuffix(env.BaseURL, "/")
+		return base + "/checkout/v71", base + "/pal/servlet/Payout/v68", nil
+	}
+	if env.Name != EnvironmentProduction {
+		return AdyenCheckoutTestURL, AdyenPayoutTestURL, nil
+	}
+	if p.config.LiveURLPrefix == "" {
+		return "", "", errors.New("adyen live endpoints need a live URL prefix or base URL")
+	}
+	return "https://" + p.config.LiveURLPrefix + "-checkout-live.adyenpayments.com/checkout/v71",
+		"https://" + p.config.LiveURLPrefix + "-pal-live.adyenpayments.com/pal/servlet/Payout/v68", nil
+}
+
+// post sends a JSON request to an environment and decodes the response
+func (p *AdyenProvider) post(ctx context.Context, env Environment, transaction models.Transaction, url string, request, out interface{}) error {
+	body, err := json.Marshal(request)
+	if err != nil {
+		return fmt.Errorf("failed to encode Adyen request: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to build Adyen request: %w", err)
+	}
+	req.Header.Set(AdyenAPIKeyHeader, env.APIKey)
+	req.Header.Set("Content-Type", "application/json")
+	if transaction.GatewayIdempotencyKey != "" {
+		req.Header.Set(httpclient.IdempotencyKeyHeader, transaction.GatewayIdempotencyKey)
+	}
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		p.available.Store(false)
+		return fmt.Errorf("adyen request failed: %w", err)
+	}
+	defer resp.Body.Close()
+	p.available.Store(resp.StatusCode < http.StatusInternalServerError)
+
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("failed to read Adyen response: %w", err)
+	}
+
+	if resp.StatusCode >= http.StatusBadRequest {
+		var failure struct {
+			ErrorCode string `json:"errorCode"`
+			ErrorType string `json:"errorType"`
+			Message   string `json:"message"`
+		}
+		if err := json.Unmarshal(respBody, &failure); err != nil || failure.ErrorCode == "" {
+			return fmt.Errorf("adyen returned status %d", resp.StatusCode)
+		}
+		return fmt.Errorf("adyen returned status %d: %s %s: %s", resp.StatusCode, failure.ErrorType, failure.ErrorCode, failure.Message)
+	}
+
+	if err := json.Unmarshal(respBody, out); err != nil {
+		return fmt.Errorf("invalid Adyen response: %w", err)
+	}
+	return nil
+}
+
+// verifySignature checks a notification item's additionalData.hmacSignature: the base64
+// HMAC-SHA256, under one of the HMAC keys, of its pspReference, originalReference,
+// merchantAccountCode, merchantReference, amount value and currency, eventCode and success
+// joined by colons
+func (p *AdyenProvider) verifySignature(item adyenNotificationItem) error {
+	if len(p.config.HMACKeys) == 0 {
+		return fmt.Errorf("%w: no Adyen HMAC key is configured", ErrInvalidCallbackSignature)
+	}
+	signature, err := base64.StdEncoding.DecodeString(item.AdditionalData["hmacSignature"])
+	if err != nil || len(signature) == 0 {
+		return fmt.Errorf("%w: missing or malformed hmacSignature", ErrInvalidCallbackSignature)
+	}
+
+	payload := strings.Join([]string{
+		item.PSPReference,
+		item.OriginalReference,
+		item.MerchantAccountCode,
+		item.MerchantReference,
+		strconv.FormatInt(item.Amount.Value, 10),
+		item.Amount.Currency,
+		item.EventCode,
+		item.Success,
+	}, ":")
+
+	for _, keyHex := range p.config.HMACKeys {
+		key, err := hex.DecodeString(keyHex)
+		if err != nil {
+			continue
+		}
+		mac := hmac.New(sha256.New, key)
+		mac.Write([]byte(payload))
+		if hmac.Equal(mac.Sum(nil), signature) {
+			return nil
+		}
+	}
+	return ErrInvalidCallbackSignature
+}
+
+// adyenAmount is an amount in minor units
+type adyenAmount struct {
+	Value    int64  `json:"value"`
+	Currency string `json:"currency"`
+}
+
+// adyenPaymentRequest is a Checkout API payment
+type adyenPaymentRequest struct {
+	Amount                 adyenAmount       `json:"amount"`
+	MerchantAccount        string            `json:"merchantAccount"`
+	Reference              string            `json:"reference"`
+	MerchantOrderReference string            `json:"merchantOrderReference,omitempty"`
+	PaymentMethod          map[string]string `json:"paymentMethod"`
+	ReturnURL              string            `json:"returnUrl,omitempty"`
+	ShopperReference       string            `json:"shopperReference,omitempty"`
+}
+
+// adyenPayoutRequest is a Payout API payout to stored payout details
+type adyenPayoutRequest struct {
+	Amount                           adyenAmount `json:"amount"`
+	MerchantAccount                  string      `json:"merchantAccount"`
+	Reference                        string      `json:"reference"`
+	ShopperReference                 string      `json:"shopperReference"`
+	SelectedRecurringDetailReference string      `json:"selectedRecurringDetailReference,omitempty"`
+	Recurring                        struct {
+		Contract string `json:"contract"`
+	} `json:"recurring"`
+}
+
+// adyenRefundRequest is a Checkout API refund of a payment
+type adyenRefundRequest struct {
+	Amount          adyenAmount `json:"amount"`
+	MerchantAccount string      `json:"merchantAccount"`
+	Reference       string      `json:"reference"`
+}
+
+// adyenResult holds the fields read from payment and payout responses
+type adyenResult struct {
+	PSPReference  string `json:"pspReference"`
+	ResultCode    string `json:"resultCode"`
+	RefusalReason string `json:"refusalReason"`
+	Action        *struct {
+		URL string `json:"url"`
+	} `json:"action"`
+}
+
+// adyenNotificationItem is a notification's NotificationRequestItem
+type adyenNotificationItem struct {
+	PSPReference        string            `json:"pspReference"`
+	OriginalReference   string            `json:"originalReference"`
+	MerchantAccountCode string            `json:"merchantAccountCode"`
+	MerchantReference   string            `json:"merchantReference"`
+	Amount              adyenAmount       `json:"amount"`
+	EventCode           string            `json:"eventCode"`
+	EventDate           string            `json:"eventDate"`
+	Success             string            `json:"success"`
+	Reason              string            `json:"reason"`
+	AdditionalData      map[string]string `json:"additionalData"`
+}
@@ -0,0 +1,298 @@
+package gateway
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"payment-gateway/internal/consts"
+	"payment-gateway/internal/models"
+	"strings"
+	"testing"
+)
+
+const adyenTestHMACKey = "44782def547aaa06c910c43932b1eb0c71fc68d9d0c057550c48ec2acf6ba056"
+
+// fakeAdyen answers API requests with the given status and body, recording each request's path
+// and decoded body
+func fakeAdyen(t *testing.T, status int, body string) (*AdyenProvider, <-chan map[string]interface{}) {
+	requests := make(chan map[string]interface{}, 10)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var request map[string]interface{}
+		json.NewDecoder(r.Body).Decode(&request)
+		request["_path"] = r.URL.Path
+		request["_api_key"] = r.Header.Get(AdyenAPIKeyHeader)
+		request["_idempotency_key"] = r.Header.Get("Idempotency-Key")
+		requests <- request
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		fmt.Fprint(w, body)
+	}))
+	t.Cleanup(server.Close)
+
+	provider := NewAdyenProvider(3, "Adyen", AdyenConfig{
+		MerchantAccount:   "TestMerchant",
+		HMACKeys:          []string{hex.EncodeToString([]byte("old-key")), adyenTestHMACKey},
+		PaymentMethodType: "ideal",
+		ReturnURL:         "https://shop.example.com/done",
+	})
+	provider.SetEnvironments(Environments{Sandbox: Environment{Name: EnvironmentSandbox, BaseURL: server.URL, APIKey: "AQE_test"}})
+	return provider, requests
+}
+
+// adyenNotification returns a notification with one item, signed with the given hex HMAC key
+func adyenNotification(t *testing.T, key string, item adyenNotificationItem) []byte {
+	payload := strings.Join([]string{item.PSPReference, item.OriginalReference, item.MerchantAccountCode, item.MerchantReference,
+		fmt.Sprint(item.Amount.Value), item.Amount.Currency, item.EventCode, item.Success}, ":")
+	rawKey, _ := hex.DecodeString(key)
+	mac := hmac.New(sha256.New, rawKey)
+	mac.Write([]byte(payload))
+	item.AdditionalData = map[string]string{"hmacSignature": base64.StdEncoding.EncodeToString(mac.Sum(nil))}
+
+	body, err := json.Marshal(map[string]interface{}{
+		"live":              "false",
+		"notificationItems": []interface{}{map[string]interface{}{"NotificationRequestItem": item}},
+	})
+	if err != nil {
+		t.Fatalf("Failed to encode notification: %v", err)
+	}
+	return body
+}
+
+func TestAdyenDeposit(t *testing.T) {
+	provider, requests := fakeAdyen(t, http.StatusOK, `{"pspReference":"PSP123","resultCode":"RedirectShopper","action":{"type":"redirect","url":"https://test.adyen.com/hpp/redirect"}}`)
+
+	transaction := models.Transaction{ID: 42, UserID: 7, Amount: 12.34, Currency: "EUR", ReferenceID: "ref-42", GatewayIdempotencyKey: "idem-42"}
+	response, err := provider.ProcessDeposit(context.Background(), transaction)
+	if err != nil {
+		t.Fatalf("ProcessDeposit failed: %v", err)
+	}
+	if response.Status != consts.Processing || response.GatewayReference != "PSP123" || response.RedirectURL != "https://test.adyen.com/hpp/redirect" {
+		t.Errorf("Unexpected response: %+v", response)
+	}
+
+	req := <-requests
+	if req["_path"] != "/checkout/v71/payments" || req["_api_key"] != "AQE_test" || req["_idempotency_key"] != "idem-42" {
+		t.Errorf("Unexpected request: %v", req)
+	}
+	amount, _ := req["amount"].(map[string]interface{})
+	method, _ := req["paymentMethod"].(map[string]interface{})
+	if amount["value"] != 1234.0 || amount["currency"] != "EUR" || req["reference"] != "42" || req["merchantAccount"] != "TestMerchant" ||
+		method["type"] != "ideal" || req["returnUrl"] != "https://shop.example.com/done" {
+		t.Errorf("Unexpected payment: %v", req)
+	}
+}
+
+// TestAdyenWalletToken tests that deposits paid with a token of a wallet configured at Adyen send
+// the token as their payment method
+func TestAdyenWalletToken(t *testing.T) {
+	provider, requests := fakeAdyen(t, http.StatusOK, `{"pspReference":"PSP124","resultCode":"Authorised"}`)
+	provider.config.WalletTokens = []string{consts.WalletApplePay, consts.WalletGooglePay}
+	if !AcceptsWalletToken(provider, consts.WalletApplePay) || AcceptsWalletToken(provider, "samsung_pay") {
+		t.Error("Expected the configured wallets' tokens accepted")
+	}
+
+	tests := []struct {
+		token  models.WalletToken
+		method map[string]interface{}
+	}{
+		{models.WalletToken{Type: consts.WalletApplePay, Data: `{"version":"EC_v1"}`},
+			map[string]interface{}{"type": "applepay", "applePayToken": base64.StdEncoding.EncodeToString([]byte(`{"version":"EC_v1"}`))}},
+		{models.WalletToken{Type: consts.WalletGooglePay, Data: `{"protocolVersion":"ECv2"}`},
+			map[string]interface{}{"type": "googlepay", "googlePayToken": `{"protocolVersion":"ECv2"}`}},
+	}
+	for _, tt := range tests {
+		token := tt.token
+		response, err := provider.ProcessDeposit(context.Background(), models.Transaction{ID: 43, Amount: 5, Currency: "EUR", WalletToken: &token})
+		if err != nil || response.Status != consts.Completed {
+			t.Fatalf("Expected a completed deposit, got %+v (%v)", response, err)
+		}
+		req := <-requests
+		if method, _ := req["paymentMethod"].(map[string]interface{}); fmt.Sprint(method) != fmt.Sprint(tt.method) {
+			t.Errorf("Expected payment method %v, got %v", tt.method, method)
+		}
+	}
+}
+
+func TestAdyenResultCodes(t *testing.T) {
+	tests := []struct {
+		resultCode string
+		status     string
+		decline    string // normalized decline code of refusals
+	}{
+		{"Authorised", consts.Completed, ""},
+		{"Received", consts.Processing, ""},
+		{"Pending", consts.Processing, ""},
+		{"ChallengeShopper", consts.Processing, ""},
+		{"Refused", "", consts.DeclineInsufficientFunds},
+		{"Cancelled", "", ""},
+		{"Error", "", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.resultCode, func(t *testing.T) {
+			provider, _ := fakeAdyen(t, http.StatusOK, fmt.Sprintf(`{"pspReference":"PSP1","resultCode":%q,"refusalReason":"Not enough balance"}`, tt.resultCode))
+
+			response, err := provider.ProcessWithdrawal(context.Background(), models.Transaction{ID: 1, Amount: 10, Currency: "EUR", Beneficiary: "8415"})
+			var decline *DeclineError
+			switch {
+			case tt.status != "":
+				if err != nil || response.Status != tt.status {
+					t.Errorf("Expected %s, got %+v, %v", tt.status, response, err)
+				}
+			case tt.decline != "":
+				if !errors.As(err, &decline) || decline.Code != tt.decline || decline.ProviderCode != "Not enough balance" {
+					t.Errorf("Expected a %s decline, got %v", tt.decline, err)
+				}
+			default:
+				if err == nil || errors.As(err, &decline) {
+					t.Errorf("Expected an error, got %+v, %v", response, err)
+				}
+			}
+		})
+	}
+}
+
+func TestAdyenWithdrawal(t *testing.T) {
+	provider, requests := fakeAdyen(t, http.StatusOK, `{"pspReference":"PSP456","resultCode":"Received"}`)
+
+	response, err := provider.ProcessWithdrawal(context.Background(), models.Transaction{ID: 7, UserID: 3, Amount: 500, Currency: "JPY", Beneficiary: "8415"})
+	if err != nil {
+		t.Fatalf("ProcessWithdrawal failed: %v", err)
+	}
+	if response.Status != consts.Processing || response.GatewayReference != "PSP456" {
+		t.Errorf("Unexpected response: %+v", response)
+	}
+
+	req := <-requests
+	amount, _ := req["amount"].(map[string]interface{})
+	if req["_path"] != "/pal/servlet/Payout/v68/payout" || amount["value"] != 500.0 || req["selectedRecurringDetailReference"] != "8415" || req["shopperReference"] != "3" {
+		t.Errorf("Unexpected payout request: %v", req)
+	}
+}
+
+func TestAdyenRefund(t *testing.T) {
+	provider, requests := fakeAdyen(t, http.StatusCreated, `{"pspReference":"PSP789","status":"received"}`)
+
+	refund := models.Refund{ID: 5, Amount: 4.5, Currency: "EUR", GatewayIdempotencyKey: "idem-refund-5"}
+	response, err := provider.ProcessRefund(context.Background(), refund, models.Transaction{ID: 42, Amount: 12.34, Currency: "EUR", GatewayReference: "PSP123"})
+	if err != nil {
+		t.Fatalf("ProcessRefund failed: %v", err)
+	}
+	if response.Status != consts.Processing || response.GatewayReference != "PSP789" {
+		t.Errorf("Unexpected response: %+v", response)
+	}
+
+	req := <-requests
+	amount, _ := req["amount"].(map[string]interface{})
+	if req["_path"] != "/checkout/v71/payments/PSP123/refunds" || req["_idempotency_key"] != "idem-refund-5" || amount["value"] != 450.0 || req["reference"] != "refund-5" {
+		t.Errorf("Unexpected refund request: %v", req)
+	}
+}
+
+func TestAdyenEndpoints(t *testing.T) {
+	provider := NewAdyenProvider(3, "Adyen", AdyenConfig{})
+	if checkout, payout, err := provider.endpoints(Environment{Name: EnvironmentSandbox}); err != nil || checkout != AdyenCheckoutTestURL || payout != AdyenPayoutTestURL {
+		t.Errorf("Expected Adyen's test endpoints in sandbox, got %s, %s, %v", checkout, payout, err)
+	}
+	if _, _, err := provider.endpoints(Environment{Name: EnvironmentProduction}); err == nil {
+		t.Error("Expected live endpoints to need a live URL prefix")
+	}
+
+	provider = NewAdyenProvider(3, "Adyen", AdyenConfig{LiveURLPrefix: "abc-Company"})
+	checkout, payout, err := provider.endpoints(Environment{Name: EnvironmentProduction})
+	if err != nil || checkout != "https://abc-Company-checkout-live.adyenpayments.com/checkout/v71" ||
+		payout != "https://abc-Company-pal-live.adyenpayments.com/pal/servlet/Payout/v68" {
+		t.Errorf("Unexpected live endpoints %s, %s, %v", checkout, payout, err)
+	}
+}
+
+func TestAdyenParseCallback(t *testing.T) {
+	provider, _ := fakeAdyen(t, http.StatusOK, `{}`)
+	refused := adyenNotificationItem{
+		PSPReference:        "PSP123",
+		MerchantAccountCode: "TestMerchant",
+		MerchantReference:   "42",
+		Amount:              adyenAmount{Value: 1234, Currency: "EUR"},
+		EventCode:           "AUTHORISATION",
+		EventDate:           "2026-10-17T10:00:00+02:00",
+		Success:             "false",
+		Reason:              "Expired Card",
+	}
+
+	tampered := adyenNotification(t, adyenTestHMACKey, refused)
+	tampered = bytes.Replace(tampered, []byte(`"value":1234`), []byte(`"value":1`), 1)
+
+	tests := []struct {
+		name    string
+		body    []byte
+		wantErr bool
+	}{
+		{"valid", adyenNotification(t, adyenTestHMACKey, refused), false},
+		{"rotated key", adyenNotification(t, hex.EncodeToString([]byte("old-key")), refused), false},
+		{"wrong key", adyenNotification(t, hex.EncodeToString([]byte("other-key")), refused), true},
+		{"tampered", tampered, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data, err := provider.ParseCallback(httptest.NewRequest(http.MethodPost, "/callback/3", bytes.NewReader(tt.body)))
+			if tt.wantErr {
+				if !errors.Is(err, ErrInvalidCallbackSignature) {
+					t.Errorf("Expected an invalid signature, got %v", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ParseCallback failed: %v", err)
+			}
+			if data.TransactionID != 42 || data.Status != consts.Failed || data.GatewayReference != "PSP123" ||
+				data.ReasonCode != "Expired Card" || data.DeclineCode != consts.DeclineExpiredCard {
+				t.Errorf("Unexpected callback data: %+v", data)
+			}
+		})
+	}
+}
+
+func TestAdyenParseCallbackEventCodes(t *testing.T) {
+	provider, _ := fakeAdyen(t, http.StatusOK, `{}`)
+
+	tests := []struct {
+		eventCode string
+		success   string
+		status    string
+	}{
+		{"AUTHORISATION", "true", consts.Completed},
+		{"PAYOUT_THIRDPARTY", "true", consts.Completed},
+		{"PAYOUT_DECLINE", "true", consts.Failed},
+		{"CANCELLATION", "true", consts.Failed},
+		{"OFFER_CLOSED", "true", consts.Failed},
+		{"CANCELLATION", "false", ""},
+		{"REFUND", "true", ""},
+	}
+
+	for _, tt := range tests {
+		item := adyenNotificationItem{PSPReference: "PSP1", MerchantReference: "7", EventCode: tt.eventCode, Success: tt.success}
+		data, err := provider.ParseCallback(httptest.NewRequest(http.MethodPost, "/callback/3", bytes.NewReader(adyenNotification(t, adyenTestHMACKey, item))))
+		if tt.status == "" {
+			if err == nil {
+				t.Errorf("Expected %s (success %s) to be refused", tt.eventCode, tt.success)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Failed to parse %s: %v", tt.eventCode, err)
+			continue
+		}
+		if data.Status != tt.status || data.TransactionID != 7 {
+			t.Errorf("%s: unexpected callback data %+v", tt.eventCode, data)
+		}
+	}
+}
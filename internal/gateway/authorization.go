@@ -0,0 +1,73 @@
+package gateway
+
+import (
+	"context"
+	"payment-gateway/internal/models"
+	"time"
+)
+
+// AuthorizationProvider is implemented by providers that can hold a deposit's amount on the
+// customer's card and take it later: deposits are authorized with Authorize, then captured with
+// Capture or released with Void. Each call forwards transaction.GatewayIdempotencyKey, which is
+// derived separately for the authorization, the capture and the void.
+type AuthorizationProvider interface {
+	Provider
+
+	// Authorize holds a deposit's amount without taking it. Declines are returned as a
+	// DeclineError.
+	Authorize(ctx context.Context, transaction models.Transaction) (*models.TransactionResponse, error)
+
+	// Capture takes amount, at most the amount authorized, from an authorized deposit; the rest
+	// is released. Declines are returned as a DeclineError.
+	Capture(ctx context.Context, transaction models.Transaction, amount float64) (*models.TransactionResponse, error)
+
+	// Void releases the amount held for an authorized deposit
+	Void(ctx context.Context, transaction models.Transaction) (*models.TransactionResponse, error)
+
+	// AuthorizationValidity returns how long an authorized amount is held before the issuer
+	// releases it
+	AuthorizationValidity() time.Duration
+}
+
+// SupportsAuthorization reports whether a provider can authorize deposits to capture later
+func SupportsAuthorization(provider Provider) bool {
+	_, ok := provider.(AuthorizationProvider)
+	return ok
+}
+
+// IncrementalAuthorizationProvider is implemented by authorization providers that can raise the
+// amount held for an authorized deposit, as hotels and car rentals do when a stay or rental runs
+// longer. The deposit must ask for it when it is authorized: Authorize requests it for
+// transactions with IncrementalAuthorization set.
+type IncrementalAuthorizationProvider interface {
+	AuthorizationProvider
+
+	// IncrementAuthorization raises the amount held for an authorized deposit to amount, its new
+	// total. Declines are returned as a DeclineError and leave the authorization as it was.
+	IncrementAuthorization(ctx context.Context, transaction models.Transaction, amount float64) (*models.TransactionResponse, error)
+}
+
+// MultiCaptureProvider is implemented by authorization providers that can capture an authorized
+// deposit in several parts, as merchants shipping an order in several parcels do. Capture remains
+// the final capture, releasing what is left.
+type MultiCaptureProvider interface {
+	AuthorizationProvider
+
+	// CapturePart takes amount from an authorized deposit and keeps the rest authorized for
+	// further captures. Declines are returned as a DeclineError and leave the authorization as
+	// it was.
+	CapturePart(ctx context.Context, transaction models.Transaction, amount float64) (*models.TransactionResponse, error)
+}
+
+// SupportsMultiCapture reports whether a provider can capture authorized deposits in parts
+func SupportsMultiCapture(provider Provider) bool {
+	_, ok := provider.(MultiCaptureProvider)
+	return ok
+}
+
+// SupportsIncrementalAuthorization reports whether a provider can raise the amount held for
+// authorized deposits
+func SupportsIncrementalAuthorization(provider Provider) bool {
+	_, ok := provider.(IncrementalAuthorizationProvider)
+	return ok
+}
@@ -0,0 +1,419 @@
+package gateway
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"payment-gateway/internal/consts"
+	"payment-gateway/internal/httpclient"
+	"payment-gateway/internal/models"
+	"payment-gateway/internal/money"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// Coinbase Commerce API settings
+const (
+	CoinbaseCommerceURL     = "https://api.commerce.coinbase.com"
+	CoinbaseAPIKeyHeader    = "X-CC-Api-Key"
+	CoinbaseVersionHeader   = "X-CC-Version"
+	CoinbaseAPIVersion      = "2018-03-22"
+	CoinbaseSignatureHeader = "X-CC-Webhook-Signature"
+	coinbaseUnresolved      = "UNRESOLVED"
+)
+
+// CoinbaseCommerceConfig configures the Coinbase Commerce provider. API keys are the provider's
+// environments; Coinbase Commerce has no sandbox, so both default to its API.
+type CoinbaseCommerceConfig struct {
+	// WebhookSecrets are the shared secrets of the webhook subscriptions Coinbase Commerce sends
+	// events to. Events signed with any of them are accepted, so secrets can be rotated.
+	WebhookSecrets []string
+
+	// RedirectURL and CancelURL are where the customer is sent after paying or cancelling, for
+	// transactions without their own
+	RedirectURL string
+	CancelURL   string
+
+	Timeout time.Duration // per attempt; 30 seconds when zero
+}
+
+// CoinbaseCommerceProvider is a gateway adapter for Coinbase Commerce. Deposits create a charge
+// priced in the transaction's currency, which the customer pays in the cryptocurrency of their
+// choice on Coinbase's hosted page. Charge webhooks report the payment as it is detected on the
+// network and confirmed, including payments for less or more than the price. Coinbase Commerce
+// doesn't pay out, so withdrawals are refused.
+type CoinbaseCommerceProvider struct {
+	id           string
+	name         string
+	config       CoinbaseCommerceConfig
+	client       *httpclient.Client
+	declineCodes DeclineCodeMap
+	environments Environments
+	available    atomic.Bool
+}
+
+// NewCoinbaseCommerceProvider creates a Coinbase Commerce provider. SetEnvironments must be called
+// with the API keys before it processes transactions.
+func NewCoinbaseCommerceProvider(id int, name string, config CoinbaseCommerceConfig) *CoinbaseCommerceProvider {
+	clientConfig := httpclient.DefaultConfig(name)
+	if config.Timeout > 0 {
+		clientConfig.Timeout = config.Timeout
+	}
+
+	p := &CoinbaseCommerceProvider{
+		id:           strconv.Itoa(id),
+		name:         name,
+		config:       config,
+		client:       httpclient.New(clientConfig),
+		declineCodes: CoinbaseDeclineCodes,
+	}
+	p.SetEnvironments(Environments{Sandbox: Environment{Name: EnvironmentSandbox}})
+	p.available.Store(true)
+	return p
+}
+
+// SetEnvironments configures the sandbox and production environments; environments without a
+// base URL use the Coinbase Commerce API
+func (p *CoinbaseCommerceProvider) SetEnvironments(environments Environments) {
+	if environments.Sandbox.BaseURL == "" {
+		environments.Sandbox.BaseURL = CoinbaseCommerceURL
+	}
+	if environments.Production != nil && environments.Production.BaseURL == "" {
+		production := *environments.Production
+		production.BaseURL = CoinbaseCommerceURL
+		environments.Production = &production
+	}
+	p.environments = environments
+}
+
+// ID returns the unique identifier of the gateway
+func (p *CoinbaseCommerceProvider) ID() string {
+	return p.id
+}
+
+// Name returns the name of the gateway
+func (p *CoinbaseCommerceProvider) Name() string {
+	return p.name
+}
+
+// DataFormat returns the data format supported by the gateway
+func (p *CoinbaseCommerceProvider) DataFormat() string {
+	return "application/json"
+}
+
+// IsAvailable reports whether the last request reached Coinbase Commerce
+func (p *CoinbaseCommerceProvider) IsAvailable() bool {
+	return p.available.Load()
+}
+
+// PaymentMethods returns the payment methods Coinbase Commerce takes: cryptocurrencies
+func (p *CoinbaseCommerceProvider) PaymentMethods() []string {
+	return []string{consts.PaymentMethodCrypto}
+}
+
+// ProcessDeposit creates a charge for the transaction's amount and returns its hosted page to
+// redirect the customer to. The charge's code is the gateway reference; the payment is reported
+// by webhook events.
+func (p *CoinbaseCommerceProvider) ProcessDeposit(ctx context.Context, transaction models.Transaction) (*models.TransactionResponse, error) {
+	redirectURL := transaction.ReturnURL
+	if redirectURL == "" {
+		redirectURL = p.config.RedirectURL
+	}
+	cancelURL := transaction.CancelURL
+	if cancelURL == "" {
+		cancelURL = p.config.CancelURL
+	}
+
+	request := coinbaseChargeRequest{
+		Name:        "Deposit " + strconv.Itoa(transaction.ID),
+		Description: transaction.ReferenceID,
+		PricingType: "fixed_price",
+		LocalPrice: coinbaseMoney{
+			Amount:   strconv.FormatFloat(transaction.Amount, 'f', money.Exponent(transaction.Currency), 64),
+			Currency: transaction.Currency,
+		},
+		Metadata: map[string]string{
+			"transaction_id": strconv.Itoa(transaction.ID),
+			"reference_id":   transaction.ReferenceID,
+		},
+		RedirectURL: redirectURL,
+		CancelURL:   cancelURL,
+	}
+
+	var result struct {
+		Data coinbaseCharge `json:"data"`
+	}
+	if err := p.post(ctx, transaction, "/charges", request, &result); err != nil {
+		return nil, err
+	}
+	if result.Data.Code == "" || result.Data.HostedURL == "" {
+		return nil, errors.New("coinbase commerce returned a charge without a code or hosted URL")
+	}
+
+	return &models.TransactionResponse{
+		TransactionID:    transaction.ID,
+		Status:           consts.Processing,
+		GatewayReference: result.Data.Code,
+		RedirectURL:      result.Data.HostedURL,
+	}, nil
+}
+
+// ProcessWithdrawal refuses withdrawals, as Coinbase Commerce only takes payments
+func (p *CoinbaseCommerceProvider) ProcessWithdrawal(ctx context.Context, transaction models.Transaction) (*models.TransactionResponse, error) {
+	return nil, fmt.Errorf("%s: withdrawals are not supported", p.name)
+}
+
+// ProcessRefund refuses refunds. Crypto payments are refunded to an address the customer gives,
+// from the Coinbase Commerce dashboard.
+func (p *CoinbaseCommerceProvider) ProcessRefund(ctx context.Context, refund models.Refund, transaction models.Transaction) (*models.TransactionResponse, error) {
+	return nil, fmt.Errorf("%s: %w", p.name, ErrRefundUnsupported)
+}
+
+// ParseCallback verifies the signature of a charge webhook event and maps it to the deposit it
+// reports. Charges underpaid or overpaid are left unresolved by Coinbase Commerce: underpaid
+// deposits fail and overpaid ones complete, both with the crypto payment's status saying so.
+func (p *CoinbaseCommerceProvider) ParseCallback(r *http.Request) (*models.CallbackData, error) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read callback: %w", err)
+	}
+	if err := p.verifySignature(body, r.Header.Get(CoinbaseSignatureHeader)); err != nil {
+		return nil, err
+	}
+
+	var notification struct {
+		Event struct {
+			ID        string         `json:"id"`
+			Type      string         `json:"type"`
+			CreatedAt string         `json:"created_at"`
+			Data      coinbaseCharge `json:"data"`
+		} `json:"event"`
+	}
+	if err := json.Unmarshal(body, &notification); err != nil {
+		return nil, fmt.Errorf("invalid Coinbase Commerce event: %w", err)
+	}
+	event := notification.Event
+	charge := event.Data
+
+	callbackData := &models.CallbackData{
+		GatewayReference: charge.Code,
+		GatewayID:        p.id,
+		Timestamp:        event.CreatedAt,
+		Crypto:           charge.payment(),
+	}
+	if id := charge.Metadata["transaction_id"]; id != "" {
+		if callbackData.TransactionID, err = strconv.Atoi(id); err != nil {
+			return nil, fmt.Errorf("invalid transaction ID %q in Coinbase Commerce event %s", id, event.ID)
+		}
+	}
+
+	resolution := charge.resolution()
+	switch event.Type {
+	case "charge:created":
+		// The deposit is processing from the moment its charge is created
+		callbackData.Status = consts.Processing
+	case "charge:pending":
+		callbackData.Status = consts.Processing
+		setCryptoStatus(callbackData, consts.CryptoPaymentDetected)
+	case "charge:confirmed", "charge:resolved":
+		callbackData.Status = consts.Completed
+		if resolution == "OVERPAID" && event.Type == "charge:confirmed" {
+			setCryptoStatus(callbackData, consts.CryptoPaymentOverpaid)
+		} else {
+			setCryptoStatus(callbackData, consts.CryptoPaymentPaid)
+		}
+	case "charge:delayed":
+		callbackData.Status = consts.Processing
+		setCryptoStatus(callbackData, consts.CryptoPaymentDelayed)
+	case "charge:failed":
+		switch resolution {
+		case "OVERPAID":
+			// The price was paid; the excess is owed back to the customer
+			callbackData.Status = consts.Completed
+			setCryptoStatus(callbackData, consts.CryptoPaymentOverpaid)
+		case "UNDERPAID":
+			callbackData.Status = consts.Failed
+			setCryptoStatus(callbackData, consts.CryptoPaymentUnderpaid)
+			callbackData.ReasonCode = resolution
+			callbackData.Message = "underpaid"
+			if callbackData.Crypto != nil {
+				callbackData.Message = fmt.Sprintf("underpaid: received %s %s", callbackData.Crypto.Amount, callbackData.Crypto.Currency)
+			}
+		default:
+			// Charges expire when no payment arrives in time
+			callbackData.Status = consts.Failed
+			callbackData.ReasonCode = "EXPIRED"
+			callbackData.Message = "charge expired"
+		}
+	default:
+		return nil, fmt.Errorf("unsupported Coinbase Commerce event type %q", event.Type)
+	}
+
+	if callbackData.ReasonCode != "" {
+		callbackData.DeclineCode = p.declineCodes.Normalize(callbackData.ReasonCode)
+	}
+	return callbackData, nil
+}
+
+// setCryptoStatus sets the status of a callback's crypto payment, when it reports one
+func setCryptoStatus(data *models.CallbackData, status string) {
+	if data.Crypto != nil {
+		data.Crypto.Status = status
+	}
+}
+
+// post sends a JSON request to the transaction's environment and decodes the response
+func (p *CoinbaseCommerceProvider) post(ctx context.Context, transaction models.Transaction, path string, request, out interface{}) error {
+	env, err := p.environments.Select(transaction.Livemode)
+	if err != nil {
+		return err
+	}
+
+	body, err := json.Marshal(request)
+	if err != nil {
+		return fmt.Errorf("failed to encode Coinbase Commerce request: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, strings.TrimSuffix(env.BaseURL, "/")+path, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to build Coinbase Commerce request: %w", err)
+	}
+	req.Header.Set(CoinbaseAPIKeyHeader, env.APIKey)
+	req.Header.Set(CoinbaseVersionHeader, CoinbaseAPIVersion)
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		p.available.Store(false)
+		return fmt.Errorf("coinbase commerce request failed: %w", err)
+	}
+	defer resp.Body.Close()
+	p.available.Store(resp.StatusCode < http.StatusInternalServerError)
+
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("failed to read Coinbase Commerce response: %w", err)
+	}
+
+	if resp.StatusCode >= http.StatusBadRequest {
+		var failure struct {
+			Error struct {
+				Type    string `json:"type"`
+				Message string `json:"message"`
+			} `json:"error"`
+		}
+		if err := json.Unmarshal(respBody, &failure); err != nil || failure.Error.Type == "" {
+			return fmt.Errorf("coinbase commerce returned status %d", resp.StatusCode)
+		}
+		return fmt.Errorf("coinbase commerce returned status %d: %s: %s", resp.StatusCode, failure.Error.Type, failure.Error.Message)
+	}
+
+	if err := json.Unmarshal(respBody, out); err != nil {
+		return fmt.Errorf("invalid Coinbase Commerce response: %w", err)
+	}
+	return nil
+}
+
+// verifySignature checks the X-CC-Webhook-Signature header: the hex HMAC-SHA256 of the raw
+// body under one of the webhook secrets
+func (p *CoinbaseCommerceProvider) verifySignature(body []byte, header string) error {
+	if len(p.config.WebhookSecrets) == 0 {
+		return fmt.Errorf("%w: no Coinbase Commerce webhook secret is configured", ErrInvalidCallbackSignature)
+	}
+	signature, err := hex.DecodeString(header)
+	if err != nil || len(signature) == 0 {
+		return fmt.Errorf("%w: missing or malformed %s header", ErrInvalidCallbackSignature, CoinbaseSignatureHeader)
+	}
+
+	for _, secret := range p.config.WebhookSecrets {
+		mac := hmac.New(sha256.New, []byte(secret))
+		mac.Write(body)
+		if hmac.Equal(mac.Sum(nil), signature) {
+			return nil
+		}
+	}
+	return ErrInvalidCallbackSignature
+}
+
+// CoinbaseDeclineCodes maps the reasons Coinbase Commerce charges fail to normalized decline codes
+var CoinbaseDeclineCodes = DeclineCodeMap{
+	"EXPIRED":   consts.DeclineConsentExpired,    // no payment arrived before the charge expired
+	"UNDERPAID": consts.DeclineInsufficientFunds, // the payment was for less than the price
+}
+
+// coinbaseMoney is an amount as a decimal string
+type coinbaseMoney struct {
+	Amount   string `json:"amount"`
+	Currency string `json:"currency"`
+}
+
+// coinbaseChargeRequest creates a fixed-price charge
+type coinbaseChargeRequest struct {
+	Name        string            `json:"name"`
+	Description string            `json:"description,omitempty"`
+	PricingType string            `json:"pricing_type"`
+	LocalPrice  coinbaseMoney     `json:"local_price"`
+	Metadata    map[string]string `json:"metadata"`
+	RedirectURL string            `json:"redirect_url,omitempty"`
+	CancelURL   string            `json:"cancel_url,omitempty"`
+}
+
+// coinbaseCharge holds the fields read from charges, in API responses and webhook events
+type coinbaseCharge struct {
+	Code      string            `json:"code"`
+	HostedURL string            `json:"hosted_url"`
+	Metadata  map[string]string `json:"metadata"`
+	Payments  []struct {
+		Network       string `json:"network"`
+		TransactionID string `json:"transaction_id"`
+		Value         struct {
+			Crypto coinbaseMoney `json:"crypto"`
+		} `json:"value"`
+		Block struct {
+			Confirmations         int `json:"confirmations_accumulated"`
+			ConfirmationsRequired int `json:"confirmations_required"`
+		} `json:"block"`
+	} `json:"payments"`
+	Timeline []struct {
+		Status  string `json:"status"`
+		Context string `json:"context"`
+	} `json:"timeline"`
+}
+
+// payment returns the charge's latest payment, or nil before the customer has paid
+func (c coinbaseCharge) payment() *models.CryptoPayment {
+	if len(c.Payments) == 0 {
+		return nil
+	}
+	latest := c.Payments[len(c.Payments)-1]
+	return &models.CryptoPayment{
+		Amount:                latest.Value.Crypto.Amount,
+		Currency:              latest.Value.Crypto.Currency,
+		Network:               latest.Network,
+		TransactionHash:       latest.TransactionID,
+		Confirmations:         latest.Block.Confirmations,
+		RequiredConfirmations: latest.Block.ConfirmationsRequired,
+	}
+}
+
+// resolution returns why an unresolved charge needs resolving, e.g. UNDERPAID or OVERPAID, from
+// its latest timeline entry, or "" when it isn't unresolved
+func (c coinbaseCharge) resolution() string {
+	if len(c.Timeline) == 0 {
+		return ""
+	}
+	latest := c.Timeline[len(c.Timeline)-1]
+	if latest.Status != coinbaseUnresolved {
+		return ""
+	}
+	return latest.Context
+}
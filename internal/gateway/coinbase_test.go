@@ -0,0 +1,171 @@
+package gateway
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"payment-gateway/internal/consts"
+	"payment-gateway/internal/models"
+	"testing"
+)
+
+const coinbaseTestWebhookSecret = "cc-secret"
+
+// fakeCoinbase answers API requests with the given status and body, recording each request with
+// its body read
+func fakeCoinbase(t *testing.T, status int, body string) (*CoinbaseCommerceProvider, <-chan *http.Request) {
+	requests := make(chan *http.Request, 10)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requestBody, _ := io.ReadAll(r.Body)
+		r.Body = io.NopCloser(bytes.NewReader(requestBody))
+		requests <- r
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		fmt.Fprint(w, body)
+	}))
+	t.Cleanup(server.Close)
+
+	provider := NewCoinbaseCommerceProvider(7, "Coinbase Commerce", CoinbaseCommerceConfig{
+		WebhookSecrets: []string{"cc-old", coinbaseTestWebhookSecret},
+		RedirectURL:    "https://shop.example.com/paid",
+	})
+	provider.SetEnvironments(Environments{Sandbox: Environment{Name: EnvironmentSandbox, BaseURL: server.URL, APIKey: "cc-key"}})
+	return provider, requests
+}
+
+// signCoinbaseEvent returns the X-CC-Webhook-Signature header of a payload
+func signCoinbaseEvent(secret string, payload []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(payload)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// coinbaseEvent builds a charge webhook event for transaction 42 with one payment of 0.0015 BTC
+// and the given final timeline entry
+func coinbaseEvent(eventType, timelineStatus, timelineContext string) []byte {
+	return []byte(fmt.Sprintf(`{"id":1,"event":{"id":"evt-1","type":%q,"created_at":"2024-05-01T10:00:00Z","data":{
+		"code":"ABCD1234","metadata":{"transaction_id":"42"},
+		"payments":[{"network":"bitcoin","transaction_id":"f1e2d3","value":{"local":{"amount":"100.00","currency":"USD"},"crypto":{"amount":"0.00150000","currency":"BTC"}},
+			"block":{"height":840000,"confirmations_accumulated":1,"confirmations_required":1}}],
+		"timeline":[{"status":"NEW"},{"status":%q,"context":%q}]}}}`, eventType, timelineStatus, timelineContext))
+}
+
+func TestCoinbaseDeposit(t *testing.T) {
+	provider, requests := fakeCoinbase(t, http.StatusCreated, `{"data":{"code":"ABCD1234","hosted_url":"https://commerce.coinbase.com/charges/ABCD1234"}}`)
+
+	response, err := provider.ProcessDeposit(context.Background(), models.Transaction{ID: 42, Amount: 99.5, Currency: "USD", ReferenceID: "PG01"})
+	if err != nil {
+		t.Fatalf("ProcessDeposit failed: %v", err)
+	}
+	if response.Status != consts.Processing || response.GatewayReference != "ABCD1234" || response.RedirectURL != "https://commerce.coinbase.com/charges/ABCD1234" {
+		t.Errorf("Unexpected response: %+v", response)
+	}
+
+	req := <-requests
+	if req.URL.Path != "/charges" || req.Header.Get(CoinbaseAPIKeyHeader) != "cc-key" || req.Header.Get(CoinbaseVersionHeader) != CoinbaseAPIVersion {
+		t.Errorf("Unexpected request %s with headers %v", req.URL.Path, req.Header)
+	}
+
+	var charge coinbaseChargeRequest
+	if err := json.NewDecoder(req.Body).Decode(&charge); err != nil {
+		t.Fatalf("Invalid request body: %v", err)
+	}
+	if charge.PricingType != "fixed_price" || charge.LocalPrice != (coinbaseMoney{Amount: "99.50", Currency: "USD"}) ||
+		charge.Metadata["transaction_id"] != "42" || charge.RedirectURL != "https://shop.example.com/paid" {
+		t.Errorf("Unexpected charge: %+v", charge)
+	}
+
+	if _, err := provider.ProcessWithdrawal(context.Background(), models.Transaction{ID: 43, Amount: 10, Currency: "USD"}); err == nil {
+		t.Error("Expected withdrawals to be refused")
+	}
+}
+
+func TestCoinbaseParseCallback(t *testing.T) {
+	provider, _ := fakeCoinbase(t, http.StatusOK, `{}`)
+	payload := coinbaseEvent("charge:confirmed", "COMPLETED", "")
+
+	tests := []struct {
+		name      string
+		signature string
+		wantErr   bool
+	}{
+		{"valid", signCoinbaseEvent(coinbaseTestWebhookSecret, payload), false},
+		{"rotated secret", signCoinbaseEvent("cc-old", payload), false},
+		{"wrong secret", signCoinbaseEvent("cc-other", payload), true},
+		{"missing", "", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/callback/7", bytes.NewReader(payload))
+			req.Header.Set(CoinbaseSignatureHeader, tt.signature)
+
+			data, err := provider.ParseCallback(req)
+			if tt.wantErr {
+				if !errors.Is(err, ErrInvalidCallbackSignature) {
+					t.Errorf("Expected an invalid signature, got %v", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ParseCallback failed: %v", err)
+			}
+			want := models.CryptoPayment{Amount: "0.00150000", Currency: "BTC", Network: "bitcoin", TransactionHash: "f1e2d3",
+				Confirmations: 1, RequiredConfirmations: 1, Status: consts.CryptoPaymentPaid}
+			if data.TransactionID != 42 || data.Status != consts.Completed || data.GatewayReference != "ABCD1234" || data.Crypto == nil || *data.Crypto != want {
+				t.Errorf("Unexpected callback data: %+v, crypto %+v", data, data.Crypto)
+			}
+		})
+	}
+}
+
+func TestCoinbaseParseCallbackEventTypes(t *testing.T) {
+	provider, _ := fakeCoinbase(t, http.StatusOK, `{}`)
+
+	tests := []struct {
+		eventType       string
+		timelineStatus  string
+		timelineContext string
+		status          string
+		cryptoStatus    string
+		declineCode     string
+	}{
+		{"charge:pending", "PENDING", "", consts.Processing, consts.CryptoPaymentDetected, ""},
+		{"charge:confirmed", "UNRESOLVED", "OVERPAID", consts.Completed, consts.CryptoPaymentOverpaid, ""},
+		{"charge:failed", "UNRESOLVED", "OVERPAID", consts.Completed, consts.CryptoPaymentOverpaid, ""},
+		{"charge:failed", "UNRESOLVED", "UNDERPAID", consts.Failed, consts.CryptoPaymentUnderpaid, consts.DeclineInsufficientFunds},
+		{"charge:failed", "EXPIRED", "", consts.Failed, "", consts.DeclineConsentExpired},
+		{"charge:delayed", "UNRESOLVED", "DELAYED", consts.Processing, consts.CryptoPaymentDelayed, ""},
+		{"charge:resolved", "RESOLVED", "", consts.Completed, consts.CryptoPaymentPaid, ""},
+		{"charge:refunded", "", "", "", "", ""},
+	}
+
+	for _, tt := range tests {
+		payload := coinbaseEvent(tt.eventType, tt.timelineStatus, tt.timelineContext)
+		req := httptest.NewRequest(http.MethodPost, "/callback/7", bytes.NewReader(payload))
+		req.Header.Set(CoinbaseSignatureHeader, signCoinbaseEvent(coinbaseTestWebhookSecret, payload))
+
+		data, err := provider.ParseCallback(req)
+		if tt.status == "" {
+			if err == nil {
+				t.Errorf("Expected %s events to be unsupported", tt.eventType)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Failed to parse %s: %v", tt.eventType, err)
+			continue
+		}
+		if data.Status != tt.status || data.DeclineCode != tt.declineCode || (tt.cryptoStatus != "" && data.Crypto.Status != tt.cryptoStatus) {
+			t.Errorf("%s %s: unexpected callback data %+v, crypto %+v", tt.eventType, tt.timelineContext, data, data.Crypto)
+		}
+	}
+}
@@ -0,0 +1,130 @@
+package gateway
+
+import (
+	"fmt"
+	"payment-gateway/internal/consts"
+)
+
+// DeclineError is returned by providers when a gateway declines a transaction, as opposed to
+// failing to process it. Code is one of the normalized consts.Decline* codes; ProviderCode is
+// the gateway's own code, kept for support and reconciliation.
+type DeclineError struct {
+	Code         string
+	ProviderCode string
+	Message      string
+}
+
+func (e *DeclineError) Error() string {
+	if e.ProviderCode != "" {
+		return fmt.Sprintf("transaction declined: %s (provider code %s): %s", e.Code, e.ProviderCode, e.Message)
+	}
+	return fmt.Sprintf("transaction declined: %s: %s", e.Code, e.Message)
+}
+
+// Soft reports whether the decline is transient, so the transaction may succeed on another gateway
+func (e *DeclineError) Soft() bool {
+	return IsSoftDecline(e.Code)
+}
+
+// IsSoftDecline reports whether a normalized decline code is transient. Hard declines such as
+// insufficient funds or suspected fraud must never be retried.
+func IsSoftDecline(code string) bool {
+	switch code {
+	case consts.DeclineIssuerUnavailable, consts.DeclineTimeout, consts.DeclineProcessingError:
+		return true
+	default:
+		return false
+	}
+}
+
+// DeclineCodeMap maps a provider's own decline codes to normalized decline codes
+type DeclineCodeMap map[string]string
+
+// Normalize returns the normalized decline code for a provider code, or
+// consts.DeclineUnknown if the provider code isn't mapped
+func (m DeclineCodeMap) Normalize(providerCode string) string {
+	if code, ok := m[providerCode]; ok {
+		return code
+	}
+	return consts.DeclineUnknown
+}
+
+// ISO8583Approved is the ISO 8583 response code of an approved transaction
+const ISO8583Approved = "00"
+
+// ISO8583DeclineCodes maps ISO 8583 response codes, used by most card acquirers, to normalized decline codes
+var ISO8583DeclineCodes = DeclineCodeMap{
+	"05": consts.DeclineDoNotHonor,
+	"14": consts.DeclineInvalidAccount,
+	"51": consts.DeclineInsufficientFunds,
+	"54": consts.DeclineExpiredCard,
+	"59": consts.DeclineFraudSuspected,
+	"61": consts.DeclineLimitExceeded,
+	"68": consts.DeclineTimeout,
+	"91": consts.DeclineIssuerUnavailable,
+	"96": consts.DeclineProcessingError,
+}
+
+// StripeDeclineCodes maps Stripe's card decline codes and payout failure codes to normalized decline codes
+var StripeDeclineCodes = DeclineCodeMap{
+	"insufficient_funds":              consts.DeclineInsufficientFunds,
+	"do_not_honor":                    consts.DeclineDoNotHonor,
+	"generic_decline":                 consts.DeclineDoNotHonor,
+	"card_declined":                   consts.DeclineDoNotHonor,
+	"expired_card":                    consts.DeclineExpiredCard,
+	"fraudulent":                      consts.DeclineFraudSuspected,
+	"lost_card":                       consts.DeclineFraudSuspected,
+	"stolen_card":                     consts.DeclineFraudSuspected,
+	"pickup_card":                     consts.DeclineFraudSuspected,
+	"card_velocity_exceeded":          consts.DeclineLimitExceeded,
+	"withdrawal_count_limit_exceeded": consts.DeclineLimitExceeded,
+	"incorrect_number":                consts.DeclineInvalidAccount,
+	"invalid_account":                 consts.DeclineInvalidAccount,
+	"account_closed":                  consts.DeclineInvalidAccount,
+	"no_account":                      consts.DeclineInvalidAccount,
+	"invalid_account_number":          consts.DeclineInvalidAccount,
+	"account_frozen":                  consts.DeclineDoNotHonor,
+	"bank_account_restricted":         consts.DeclineDoNotHonor,
+	"debit_not_authorized":            consts.DeclineDoNotHonor,
+	"issuer_not_available":            consts.DeclineIssuerUnavailable,
+	"try_again_later":                 consts.DeclineIssuerUnavailable,
+	"processing_error":                consts.DeclineProcessingError,
+	"could_not_process":               consts.DeclineProcessingError,
+}
+
+// AdyenDeclineCodes maps Adyen's refusal reasons, returned in payment responses and in the reason
+// of failed notifications, to normalized decline codes
+var AdyenDeclineCodes = DeclineCodeMap{
+	"Refused":                    consts.DeclineDoNotHonor,
+	"Declined Non Generic":       consts.DeclineDoNotHonor,
+	"Referral":                   consts.DeclineDoNotHonor,
+	"Restricted Card":            consts.DeclineDoNotHonor,
+	"Transaction Not Permitted":  consts.DeclineDoNotHonor,
+	"CVC Declined":               consts.DeclineDoNotHonor,
+	"Not enough balance":         consts.DeclineInsufficientFunds,
+	"Expired Card":               consts.DeclineExpiredCard,
+	"Blocked Card":               consts.DeclineFraudSuspected,
+	"FRAUD":                      consts.DeclineFraudSuspected,
+	"FRAUD-CANCELLED":            consts.DeclineFraudSuspected,
+	"Acquirer Fraud":             consts.DeclineFraudSuspected,
+	"Issuer Suspected Fraud":     consts.DeclineFraudSuspected,
+	"Invalid Card Number":        consts.DeclineInvalidAccount,
+	"Withdrawal amount exceeded": consts.DeclineLimitExceeded,
+	"Withdrawal count exceeded":  consts.DeclineLimitExceeded,
+	"Issuer Unavailable":         consts.DeclineIssuerUnavailable,
+	"Acquirer Error":             consts.DeclineProcessingError,
+}
+
+// MpesaDeclineCodes maps the result codes of M-Pesa STK Push callbacks and B2C results to
+// normalized decline codes
+var MpesaDeclineCodes = DeclineCodeMap{
+	"1":    consts.DeclineInsufficientFunds, // balance too low for the payment
+	"1001": consts.DeclineIssuerUnavailable, // the subscriber has another transaction in progress
+	"1019": consts.DeclineConsentExpired,    // the STK Push prompt expired
+	"1025": consts.DeclineProcessingError,   // the prompt could not be sent
+	"1032": consts.DeclineConsentRejected,   // the customer cancelled the prompt
+	"1037": consts.DeclineTimeout,           // the customer's phone could not be reached
+	"2001": consts.DeclineDoNotHonor,        // wrong PIN, or invalid initiator credentials for B2C
+	"2040": consts.DeclineInvalidAccount,    // the receiving number is not registered with M-Pesa
+	"9999": consts.DeclineProcessingError,   // Safaricom system error
+}
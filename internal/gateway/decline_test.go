@@ -0,0 +1,70 @@
+package gateway
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"payment-gateway/internal/codec"
+	"payment-gateway/internal/consts"
+	"payment-gateway/internal/models"
+	"testing"
+)
+
+// TestDeclineCodeMapNormalize tests that provider codes map to normalized codes with an unknown fallback
+func TestDeclineCodeMapNormalize(t *testing.T) {
+	if code := ISO8583DeclineCodes.Normalize("51"); code != consts.DeclineInsufficientFunds {
+		t.Errorf("Expected %s, got %s", consts.DeclineInsufficientFunds, code)
+	}
+
+	if code := ISO8583DeclineCodes.Normalize("Z9"); code != consts.DeclineUnknown {
+		t.Errorf("Expected %s for unmapped code, got %s", consts.DeclineUnknown, code)
+	}
+}
+
+// TestMockProviderDecline tests that the mock provider declines test amounts with a normalized code
+func TestMockProviderDecline(t *testing.T) {
+	provider := NewMockProvider(1, "TestGateway", "application/json", 1.0, 0)
+
+	_, err := provider.ProcessDeposit(context.Background(), models.Transaction{ID: 1, Amount: 10.54})
+
+	var decline *DeclineError
+	if !errors.As(err, &decline) {
+		t.Fatalf("Expected DeclineError, got: %v", err)
+	}
+	if decline.Code != consts.DeclineExpiredCard || decline.ProviderCode != "54" {
+		t.Errorf("Unexpected decline: %+v", decline)
+	}
+
+	if _, err := provider.ProcessDeposit(context.Background(), models.Transaction{ID: 2, Amount: 10.00}); err != nil {
+		t.Errorf("Expected no decline for a regular amount, got: %v", err)
+	}
+}
+
+// TestMockProviderISO8583Callback tests that callbacks in the gateway's declared ISO 8583 format
+// are parsed without a content type and that the response code decides the status
+func TestMockProviderISO8583Callback(t *testing.T) {
+	provider := NewMockProvider(4, "CardSwitch", "application/iso8583", 1.0, 0)
+
+	body, err := codec.EncodeISO8583(codec.Message{
+		MTI:    "0210",
+		Fields: map[int]string{37: "RRN000000001", 39: "51", 48: "17"},
+	})
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+
+	req := httptest.NewRequest(http.MethodPost, "/callback/4", bytes.NewReader(body))
+	callback, err := provider.ParseCallback(req)
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+
+	if callback.TransactionID != 17 || callback.GatewayReference != "RRN000000001" {
+		t.Errorf("Unexpected callback: %+v", callback)
+	}
+	if callback.Status != consts.Failed || callback.DeclineCode != consts.DeclineInsufficientFunds {
+		t.Errorf("Expected insufficient funds decline, got status %s and code %s", callback.Status, callback.DeclineCode)
+	}
+}
@@ -0,0 +1,33 @@
+package gateway
+
+import (
+	"context"
+	"payment-gateway/internal/models"
+)
+
+// DirectDebitProvider is implemented by providers that debit deposits from a customer's bank
+// account under a mandate the customer signed, such as SEPA Direct Debit, and pay withdrawals out
+// to the mandate's account by credit transfer. Instead of ProcessDeposit and ProcessWithdrawal,
+// transactions naming a mandate are submitted with CollectDebit and CreditTransfer. Such providers
+// are only selected for transactions naming a mandate.
+type DirectDebitProvider interface {
+	Provider
+
+	// CollectDebit submits a direct debit of a deposit under a mandate. Declines are returned as a
+	// DeclineError.
+	CollectDebit(ctx context.Context, transaction models.Transaction, mandate models.Mandate) (*models.TransactionResponse, error)
+
+	// CreditTransfer pays a withdrawal out to a mandate's account. Declines are returned as a
+	// DeclineError.
+	CreditTransfer(ctx context.Context, transaction models.Transaction, mandate models.Mandate) (*models.TransactionResponse, error)
+
+	// RevokesMandate reports whether a debit returned with the gateway's reason code can never be
+	// collected under its mandate again, as when the account was closed
+	RevokesMandate(reasonCode string) bool
+}
+
+// SupportsDirectDebit reports whether a provider debits deposits under mandates
+func SupportsDirectDebit(provider Provider) bool {
+	_, ok := provider.(DirectDebitProvider)
+	return ok
+}
@@ -0,0 +1,94 @@
+package gateway
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// Gateway environments
+const (
+	EnvironmentSandbox    = "sandbox"
+	EnvironmentProduction = "production"
+)
+
+var (
+	ErrNoProductionEnvironment = errors.New("no production environment is configured")
+	ErrLiveCredentials         = errors.New("live gateway credentials are only allowed in production deployments")
+)
+
+// Environment is where a provider sends transactions and the credentials it authenticates with
+type Environment struct {
+	Name    string
+	BaseURL string
+	APIKey  string
+}
+
+// Environments are a provider's sandbox and production environments. Production is nil when no
+// live credentials are configured, so livemode transactions cannot be sent by mistake.
+type Environments struct {
+	Sandbox    Environment
+	Production *Environment
+}
+
+// EnvironmentProvider is implemented by providers whose sandbox and production environments are
+// configurable. SetEnvironments must be called before the provider processes transactions.
+type EnvironmentProvider interface {
+	SetEnvironments(environments Environments)
+}
+
+// Select returns the environment a transaction is sent to: production for livemode transactions
+// and sandbox otherwise
+func (e Environments) Select(livemode bool) (Environment, error) {
+	if !livemode {
+		return e.Sandbox, nil
+	}
+	if e.Production == nil {
+		return Environment{}, ErrNoProductionEnvironment
+	}
+	return *e.Production, nil
+}
+
+// Check validates the environments' base URLs and keeps live credentials out of non-production
+// deployments: only production deployments may configure a production environment, and sandbox
+// API keys must not look like live keys.
+func (e Environments) Check(productionDeployment bool) error {
+	if err := checkBaseURL(e.Sandbox); err != nil {
+		return err
+	}
+	if looksLive(e.Sandbox.APIKey) {
+		return fmt.Errorf("%w: the sandbox API key is a live key", ErrLiveCredentials)
+	}
+
+	if e.Production == nil {
+		return nil
+	}
+	if !productionDeployment {
+		return fmt.Errorf("%w: a production environment is configured", ErrLiveCredentials)
+	}
+	if e.Production.APIKey == "" {
+		return errors.New("the production environment has no API key")
+	}
+	return checkBaseURL(*e.Production)
+}
+
+// checkBaseURL requires an environment's base URL, when set, to be an absolute HTTPS URL
+func checkBaseURL(env Environment) error {
+	if env.BaseURL == "" {
+		return nil
+	}
+
+	u, err := url.Parse(env.BaseURL)
+	if err != nil || u.Scheme != "https" || u.Host == "" {
+		return fmt.Errorf("invalid %s base URL %q: must be an absolute HTTPS URL", env.Name, env.BaseURL)
+	}
+	return nil
+}
+
+// looksLive reports whether an API key follows the live-key naming most providers use,
+// such as "live_..." or "sk_live_..."
+func looksLive(key string) bool {
+	key = strings.ToLower(key)
+	return strings.HasPrefix(key, "live_") || strings.Contains(key, "_live_")
+}
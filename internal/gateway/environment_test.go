@@ -0,0 +1,73 @@
+package gateway
+
+import (
+	"context"
+	"errors"
+	"payment-gateway/internal/models"
+	"strings"
+	"testing"
+)
+
+// TestEnvironmentsCheck tests that live credentials are only accepted in production deployments
+func TestEnvironmentsCheck(t *testing.T) {
+	sandbox := Environment{Name: EnvironmentSandbox, BaseURL: "https://sandbox.example.com", APIKey: "sk_test_123"}
+	production := &Environment{Name: EnvironmentProduction, BaseURL: "https://api.example.com", APIKey: "sk_live_123"}
+
+	tests := []struct {
+		name         string
+		environments Environments
+		production   bool
+		liveErr      bool
+		wantErr      bool
+	}{
+		{"sandbox only", Environments{Sandbox: sandbox}, false, false, false},
+		{"production in production", Environments{Sandbox: sandbox, Production: production}, true, false, false},
+		{"production outside production", Environments{Sandbox: sandbox, Production: production}, false, true, true},
+		{"live key in sandbox", Environments{Sandbox: Environment{Name: EnvironmentSandbox, APIKey: "live_abc"}}, true, true, true},
+		{"production without key", Environments{Sandbox: sandbox, Production: &Environment{Name: EnvironmentProduction}}, true, false, true},
+		{"plain HTTP base URL", Environments{Sandbox: Environment{Name: EnvironmentSandbox, BaseURL: "http://sandbox.example.com"}}, false, false, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.environments.Check(tt.production)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Expected error %t, got: %v", tt.wantErr, err)
+			}
+			if errors.Is(err, ErrLiveCredentials) != tt.liveErr {
+				t.Errorf("Expected ErrLiveCredentials %t, got: %v", tt.liveErr, err)
+			}
+		})
+	}
+}
+
+// TestMockProviderEnvironments tests that livemode transactions use the production environment
+func TestMockProviderEnvironments(t *testing.T) {
+	provider := NewMockProvider(1, "TestGateway", "application/json", 1.0, 0)
+	ctx := context.Background()
+
+	if _, err := provider.ProcessDeposit(ctx, models.Transaction{ID: 1, Amount: 10, Livemode: true}); !errors.Is(err, ErrNoProductionEnvironment) {
+		t.Errorf("Expected ErrNoProductionEnvironment without live credentials, got: %v", err)
+	}
+
+	provider.SetEnvironments(Environments{
+		Sandbox:    Environment{Name: EnvironmentSandbox},
+		Production: &Environment{Name: EnvironmentProduction, BaseURL: "https://live.example.com", APIKey: "sk_live_123"},
+	})
+
+	sandbox, err := provider.ProcessDeposit(ctx, models.Transaction{ID: 2, Amount: 10})
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	if !strings.HasPrefix(sandbox.RedirectURL, "https://TestGateway.example.com/") {
+		t.Errorf("Expected the default sandbox URL, got %s", sandbox.RedirectURL)
+	}
+
+	live, err := provider.ProcessDeposit(ctx, models.Transaction{ID: 3, Amount: 10, Livemode: true})
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	if !strings.HasPrefix(live.RedirectURL, "https://live.example.com/payment/") {
+		t.Errorf("Expected the production URL, got %s", live.RedirectURL)
+	}
+}
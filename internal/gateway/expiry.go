@@ -0,0 +1,19 @@
+package gateway
+
+import "time"
+
+// ExpiringPaymentProvider is implemented by providers whose deposits must be paid within a
+// window, such as PIX charges. Deposits still unpaid once their window closed are failed.
+type ExpiringPaymentProvider interface {
+	// PaymentExpiry returns how long after its submission a deposit can be paid
+	PaymentExpiry() time.Duration
+}
+
+// PaymentExpiry returns how long a provider's deposits can be paid for, or zero when they don't
+// expire
+func PaymentExpiry(provider Provider) time.Duration {
+	if expiring, ok := provider.(ExpiringPaymentProvider); ok {
+		return expiring.PaymentExpiry()
+	}
+	return 0
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"net/http"
 	"payment-gateway/internal/models"
+	"time"
 )
 
 // PaymentProvider defines a common interface for all payment gateway providers
@@ -20,12 +21,37 @@ type Provider interface {
 	// IsAvailable checks if the gateway is currently available
 	IsAvailable() bool
 
-	// ProcessDeposit handles deposit transactions
+	// ProcessDeposit handles deposit transactions. Providers that support idempotent
+	// requests should forward transaction.GatewayIdempotencyKey so retries are deduplicated.
 	ProcessDeposit(ctx context.Context, transaction models.Transaction) (*models.TransactionResponse, error)
 
-	// ProcessWithdrawal handles withdrawal transactions
+	// ProcessWithdrawal handles withdrawal transactions. Providers that support idempotent
+	// requests should forward transaction.GatewayIdempotencyKey so retries are deduplicated.
 	ProcessWithdrawal(ctx context.Context, transaction models.Transaction) (*models.TransactionResponse, error)
 
+	// ProcessRefund returns all or part of a completed deposit, the transaction, to the customer.
+	// Providers forward refund.GatewayIdempotencyKey so retries are not refunded twice, and return
+	// ErrRefundUnsupported when their API can't refund payments.
+	ProcessRefund(ctx context.Context, refund models.Refund, transaction models.Transaction) (*models.TransactionResponse, error)
+
 	// ParseCallback parses callback request from the gateway
 	ParseCallback(r *http.Request) (*models.CallbackData, error)
 }
+
+// callbackReceivedAtKey is the context key of the time a callback was received
+type callbackReceivedAtKey struct{}
+
+// WithCallbackReceivedAt returns a context recording when the callback being parsed was
+// received, so providers that check signature timestamps can verify callbacks stored earlier
+func WithCallbackReceivedAt(ctx context.Context, receivedAt time.Time) context.Context {
+	return context.WithValue(ctx, callbackReceivedAtKey{}, receivedAt)
+}
+
+// CallbackReceivedAt returns when the callback being parsed was received, or the current time
+// when the context doesn't record it
+func CallbackReceivedAt(ctx context.Context) time.Time {
+	if receivedAt, ok := ctx.Value(callbackReceivedAtKey{}).(time.Time); ok && !receivedAt.IsZero() {
+		return receivedAt
+	}
+	return time.Now()
+}
@@ -6,20 +6,65 @@ import (
 	"fmt"
 	"log"
 	"payment-gateway/db"
+	"payment-gateway/internal/codec"
+	"payment-gateway/internal/models"
 	"sort"
+	"strconv"
+	"strings"
 	"sync"
+	"time"
 )
 
 var (
-	ErrNoAvailableGateway = errors.New("no available gateway found")
+	ErrNoAvailableGateway     = errors.New("no available gateway found")
+	ErrInvalidGatewayOverride = errors.New("invalid gateway override")
 )
 
+// Outcomes of the steps in a selection trace
+const (
+	TraceInfo     = "info"
+	TraceSkipped  = "skipped"
+	TraceSelected = "selected"
+)
+
+// SelectionOptions lets callers influence gateway selection. Overrides are always subject
+// to the same country support and health checks as the default priority order.
+type SelectionOptions struct {
+	PreferredGatewayID string
+	ExcludedGatewayIDs []string
+
+	// Merchant routing rules and the transaction they are evaluated against. A preferred
+	// gateway in the request takes precedence over rule preferences; exclusions add up.
+	Rules     []models.RoutingRule
+	RuleInput RuleInput
+
+	// PaymentInitiation selects among open banking providers, for deposits paid from a bank.
+	// Otherwise those providers are skipped.
+	PaymentInitiation bool
+
+	// BankPayout selects among open banking providers paying out to linked bank accounts, for
+	// withdrawals to such an account. Otherwise those providers are skipped.
+	BankPayout bool
+
+	// DirectDebit selects among providers debiting deposits under mandates and paying withdrawals
+	// out to their accounts, for transactions naming a mandate. Otherwise those providers are
+	// skipped.
+	DirectDebit bool
+}
+
+// HasOverride reports whether any routing override was requested
+func (o SelectionOptions) HasOverride() bool {
+	return o.PreferredGatewayID != "" || len(o.ExcludedGatewayIDs) > 0
+}
+
 // Selector is responsible for selecting appropriate gateways
 type Selector struct {
 	db           db.DBInterface
 	providers    map[string]Provider
 	lock         sync.RWMutex
 	healthStatus map[string]bool
+	downgraded   map[string]time.Time // gateways tried after all others until the given time
+	maintenance  map[string][]models.MaintenanceWindow
 }
 
 // NewSelector creates a new gateway selector
@@ -28,6 +73,8 @@ func NewSelector(dbInterface db.DBInterface) *Selector {
 		db:           dbInterface,
 		providers:    make(map[string]Provider),
 		healthStatus: make(map[string]bool),
+		downgraded:   make(map[string]time.Time),
+		maintenance:  make(map[string][]models.MaintenanceWindow),
 	}
 }
 
@@ -36,6 +83,11 @@ func (s *Selector) RegisterProvider(provider Provider) {
 	s.lock.Lock()
 	defer s.lock.Unlock()
 
+	// Transactions and callbacks in an unknown format cannot be routed or parsed
+	if _, err := codec.Lookup(provider.DataFormat()); err != nil {
+		log.Printf("Warning: gateway %s declares data format %q, which has no registered codec", provider.Name(), provider.DataFormat())
+	}
+
 	s.providers[provider.ID()] = provider
 	s.healthStatus[provider.ID()] = true
 	log.Printf("Registered payment gateway: %s", provider.Name())
@@ -59,6 +111,48 @@ func (s *Selector) MarkGatewayUp(gatewayID string) {
 	log.Printf("Marked gateway %s as up", gatewayID)
 }
 
+// DowngradeGateway moves a gateway behind every other gateway in the priority order until the
+// given time. Unlike MarkGatewayDown it stays usable when no other gateway is available.
+func (s *Selector) DowngradeGateway(gatewayID string, until time.Time) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.downgraded[gatewayID] = until
+	log.Printf("Downgraded gateway %s until %s", gatewayID, until.Format(time.RFC3339))
+}
+
+// isDowngraded reports whether a gateway is currently downgraded; the caller must hold s.lock
+func (s *Selector) isDowngraded(gatewayID string, now time.Time) bool {
+	until, ok := s.downgraded[gatewayID]
+	return ok && now.Before(until)
+}
+
+// SetMaintenanceWindows replaces the maintenance windows of every gateway. A gateway is skipped
+// while one of its windows is in progress, without being called or marked down, so it returns
+// to routing as soon as the window ends.
+func (s *Selector) SetMaintenanceWindows(windows []models.MaintenanceWindow) {
+	maintenance := make(map[string][]models.MaintenanceWindow)
+	for _, window := range windows {
+		id := strconv.Itoa(window.GatewayID)
+		maintenance[id] = append(maintenance[id], window)
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.maintenance = maintenance
+}
+
+// inMaintenance reports whether a gateway is in a maintenance window; the caller must hold s.lock
+func (s *Selector) inMaintenance(gatewayID string, now time.Time) bool {
+	for _, window := range s.maintenance[gatewayID] {
+		if !now.Before(window.StartsAt) && now.Before(window.EndsAt) {
+			return true
+		}
+	}
+	return false
+}
+
 // GetProviderByID returns a provider by its ID
 func (s *Selector) GetProviderByID(id string) (Provider, error) {
 	s.lock.RLock()
@@ -72,47 +166,354 @@ func (s *Selector) GetProviderByID(id string) (Provider, error) {
 	return provider, nil
 }
 
+// Providers returns the registered providers ordered by ID
+func (s *Selector) Providers() []Provider {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	providers := make([]Provider, 0, len(s.providers))
+	for _, provider := range s.providers {
+		providers = append(providers, provider)
+	}
+	sort.Slice(providers, func(i, j int) bool {
+		return atoi(providers[i].ID()) < atoi(providers[j].ID())
+	})
+	return providers
+}
+
+// AvailableProviders returns the providers of the gateways supporting a country that could take a
+// transaction now: registered, marked healthy, available and not in maintenance. They are ordered
+// by priority, with gateways downgraded after an anomaly last.
+func (s *Selector) AvailableProviders(ctx context.Context, countryID int) ([]Provider, error) {
+	gateways, err := s.db.GetGatewaysByPriority(countryID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get gateways: %w", err)
+	}
+
+	now := time.Now()
+	s.lock.RLock()
+	downgraded := make(map[int]bool)
+	for _, gw := range gateways {
+		downgraded[gw.GatewayID] = s.isDowngraded(strconv.Itoa(gw.GatewayID), now)
+	}
+	s.lock.RUnlock()
+
+	sort.SliceStable(gateways, func(i, j int) bool {
+		if downgraded[gateways[i].GatewayID] != downgraded[gateways[j].GatewayID] {
+			return !downgraded[gateways[i].GatewayID]
+		}
+		return gateways[i].Priority < gateways[j].Priority
+	})
+
+	providers := []Provider{}
+	for _, gw := range gateways {
+		providerID := strconv.Itoa(gw.GatewayID)
+		if s.gatewayInMaintenance(providerID, now) {
+			continue
+		}
+		if provider := s.usableProvider(providerID); provider != nil {
+			providers = append(providers, provider)
+		}
+	}
+	return providers, nil
+}
+
 // SelectGateway selects the appropriate gateway for a transaction based on country and transaction type
 func (s *Selector) SelectGateway(ctx context.Context, countryID int, txType string) (Provider, error) {
+	provider, _, err := s.SelectGatewayWithOptions(ctx, countryID, txType, SelectionOptions{})
+	return provider, err
+}
+
+// SelectGatewayWithOptions selects a gateway honoring the caller's preferred gateway and
+// exclusion list, and returns a routing decision describing how the choice was made
+func (s *Selector) SelectGatewayWithOptions(ctx context.Context, countryID int, txType string, opts SelectionOptions) (Provider, models.RoutingDecision, error) {
+	return s.selectGateway(ctx, countryID, txType, opts, nil)
+}
+
+// TraceGatewaySelection selects a gateway like SelectGatewayWithOptions and also returns every
+// check made along the way, for operators validating routing configuration
+func (s *Selector) TraceGatewaySelection(ctx context.Context, countryID int, txType string, opts SelectionOptions) (Provider, models.RoutingDecision, []models.RoutingTraceStep, error) {
+	trace := &selectionTrace{steps: []models.RoutingTraceStep{}}
+	provider, decision, err := s.selectGateway(ctx, countryID, txType, opts, trace)
+	return provider, decision, trace.steps, err
+}
+
+// selectGateway implements gateway selection, recording its checks in trace when it is not nil
+func (s *Selector) selectGateway(ctx context.Context, countryID int, txType string, opts SelectionOptions, trace *selectionTrace) (Provider, models.RoutingDecision, error) {
+	decision := models.RoutingDecision{
+		CountryID:          countryID,
+		Type:               txType,
+		PreferredGatewayID: atoi(opts.PreferredGatewayID),
+	}
+	for _, id := range opts.ExcludedGatewayIDs {
+		decision.ExcludedGatewayIDs = append(decision.ExcludedGatewayIDs, atoi(id))
+	}
+
+	excluded := make(map[string]bool, len(opts.ExcludedGatewayIDs))
+	for _, id := range opts.ExcludedGatewayIDs {
+		excluded[id] = true
+	}
+	if opts.PreferredGatewayID != "" && excluded[opts.PreferredGatewayID] {
+		return nil, decision, fmt.Errorf("%w: gateway %s is both preferred and excluded", ErrInvalidGatewayOverride, opts.PreferredGatewayID)
+	}
+
 	// Get gateways supported for this country with their priorities
 	gateways, err := s.db.GetGatewaysByPriority(countryID)
 	if err != nil {
-		return nil, fmt.Errorf("failed to get gateways: %w", err)
+		return nil, decision, fmt.Errorf("failed to get gateways: %w", err)
 	}
 
+	trace.add(0, 0, TraceInfo, "%d gateways support country %d", len(gateways), countryID)
 	if len(gateways) == 0 {
-		return nil, ErrNoAvailableGateway
+		return nil, decision, ErrNoAvailableGateway
 	}
 
-	// Sort gateways by priority (lower number means higher priority)
+	// Sort gateways by priority (lower number means higher priority), with downgraded gateways last
+	now := time.Now()
+	s.lock.RLock()
+	downgraded := make(map[int]bool)
+	for _, gw := range gateways {
+		id := strconv.Itoa(gw.GatewayID)
+		downgraded[gw.GatewayID] = s.isDowngraded(id, now)
+		if downgraded[gw.GatewayID] {
+			trace.add(gw.GatewayID, gw.Priority, TraceInfo, "downgraded after an anomaly until %s; tried after the other gateways", s.downgraded[id].Format(time.RFC3339))
+		}
+	}
+	s.lock.RUnlock()
+
 	sort.Slice(gateways, func(i, j int) bool {
+		if downgraded[gateways[i].GatewayID] != downgraded[gateways[j].GatewayID] {
+			return !downgraded[gateways[i].GatewayID]
+		}
 		return gateways[i].Priority < gateways[j].Priority
 	})
 
+	// Merchant rules narrow the choice further; a rule preference applies when the request
+	// names no usable preferred gateway
+	var reasons []string
+	rules := evaluateRules(opts.Rules, opts.RuleInput, excluded)
+	for _, rule := range rules.matched {
+		trace.add(rule.GatewayID, 0, TraceInfo, "merchant rule %d matched (%s %s %s): %s gateway %d", rule.Position, rule.Field, rule.Operator, rule.Value, rule.Action, rule.GatewayID)
+	}
+	preferredID := opts.PreferredGatewayID
+	preferred, honored := "preferred gateway "+preferredID, "preferred gateway honored"
+	if position, ok := rules.excluded[preferredID]; ok && preferredID != "" {
+		reasons = append(reasons, fmt.Sprintf("%s excluded by merchant rule %d", preferred, position))
+		trace.add(atoi(preferredID), 0, TraceSkipped, "%s excluded by merchant rule %d", preferred, position)
+		preferredID = ""
+	}
+	if preferredID == "" && rules.preferred != "" {
+		preferredID = rules.preferred
+		preferred = fmt.Sprintf("gateway %s preferred by merchant rule %d", preferredID, rules.preferBy)
+		honored = fmt.Sprintf("preferred by merchant rule %d", rules.preferBy)
+	}
+
+	// Honor the preferred gateway if it supports this country and is healthy
+	if preferredID != "" {
+		var supported *models.GatewayPriority
+		for i, gw := range gateways {
+			if fmt.Sprintf("%d", gw.GatewayID) == preferredID {
+				supported = &gateways[i]
+				break
+			}
+		}
+
+		if supported == nil {
+			reasons = append(reasons, fmt.Sprintf("%s does not support country %d", preferred, countryID))
+			trace.add(atoi(preferredID), 0, TraceSkipped, "%s does not support country %d", preferred, countryID)
+		} else if mismatch := s.paymentMethodMismatch(preferredID, opts); mismatch != "" {
+			reasons = append(reasons, fmt.Sprintf("%s %s", preferred, mismatch))
+			trace.add(supported.GatewayID, supported.Priority, TraceSkipped, "%s %s", preferred, mismatch)
+		} else if s.gatewayInMaintenance(preferredID, now) {
+			reasons = append(reasons, fmt.Sprintf("%s is in maintenance", preferred))
+			trace.add(supported.GatewayID, supported.Priority, TraceSkipped, "%s is in maintenance", preferred)
+		} else if provider := s.usableProvider(preferredID); provider != nil {
+			log.Printf("Selected preferred gateway: %s", provider.Name())
+			trace.add(supported.GatewayID, supported.Priority, TraceSelected, "%s is available", preferred)
+			decision.SelectedGatewayID = atoi(provider.ID())
+			decision.Override = true
+			decision.Reason = honored
+			return provider, decision, nil
+		} else {
+			reasons = append(reasons, fmt.Sprintf("%s is unavailable", preferred))
+			trace.add(supported.GatewayID, supported.Priority, TraceSkipped, "%s is unavailable", preferred)
+		}
+	}
+
 	// Try each gateway in priority order until we find an available one
+	skippedExcluded, skippedByRule, skippedMaintenance := false, false, false
 	for _, gw := range gateways {
 		providerID := fmt.Sprintf("%d", gw.GatewayID) // Convert int to string for provider lookup
 
+		if excluded[providerID] {
+			log.Printf("Gateway %s excluded by request, trying next", providerID)
+			trace.add(gw.GatewayID, gw.Priority, TraceSkipped, "excluded by request")
+			skippedExcluded = true
+			continue
+		}
+
+		if position, ok := rules.excluded[providerID]; ok {
+			log.Printf("Gateway %s excluded by merchant rule %d, trying next", providerID, position)
+			trace.add(gw.GatewayID, gw.Priority, TraceSkipped, "excluded by merchant rule %d", position)
+			skippedByRule = true
+			continue
+		}
+
 		s.lock.RLock()
 		provider, exists := s.providers[providerID]
 		isHealthy := s.healthStatus[providerID]
+		maintenance := s.inMaintenance(providerID, now)
 		s.lock.RUnlock()
 
 		if !exists {
 			log.Printf("No provider implementation found for gateway ID %s", providerID)
+			trace.add(gw.GatewayID, gw.Priority, TraceSkipped, "no provider implementation registered")
+			continue
+		}
+
+		if mismatch := paymentMethodMismatch(provider, opts); mismatch != "" {
+			log.Printf("Gateway %s %s, trying next", provider.Name(), mismatch)
+			trace.add(gw.GatewayID, gw.Priority, TraceSkipped, "%s", mismatch)
+			continue
+		}
+
+		if maintenance {
+			log.Printf("Gateway %s is in maintenance, trying next", provider.Name())
+			trace.add(gw.GatewayID, gw.Priority, TraceSkipped, "in maintenance")
+			skippedMaintenance = true
 			continue
 		}
 
 		if !isHealthy {
 			log.Printf("Gateway %s is marked as unhealthy, trying next", provider.Name())
+			trace.add(gw.GatewayID, gw.Priority, TraceSkipped, "marked down")
 			continue
 		}
 
 		if provider.IsAvailable() {
 			log.Printf("Selected gateway: %s", provider.Name())
-			return provider, nil
+			trace.add(gw.GatewayID, gw.Priority, TraceSelected, "first available gateway in priority order")
+			decision.SelectedGatewayID = gw.GatewayID
+			decision.Override = skippedExcluded || skippedByRule
+			if skippedExcluded {
+				reasons = append(reasons, "higher-priority gateways excluded by request")
+			}
+			if skippedByRule {
+				reasons = append(reasons, "higher-priority gateways excluded by merchant rules")
+			}
+			if skippedMaintenance {
+				reasons = append(reasons, "higher-priority gateways in maintenance")
+			}
+			if downgraded[gw.GatewayID] {
+				reasons = append(reasons, "gateway downgraded after an anomaly; no other gateway was available")
+			} else if downgradedAhead(gateways, downgraded, gw.Priority) {
+				reasons = append(reasons, "higher-priority gateways downgraded after an anomaly")
+			}
+			if len(reasons) == 0 {
+				reasons = append(reasons, "selected by priority order")
+			} else {
+				reasons = append(reasons, "fell back to priority order")
+			}
+			decision.Reason = strings.Join(reasons, "; ")
+			return provider, decision, nil
 		}
+
+		trace.add(gw.GatewayID, gw.Priority, TraceSkipped, "not accepting requests")
 	}
 
-	return nil, ErrNoAvailableGateway
+	return nil, decision, ErrNoAvailableGateway
+}
+
+// gatewayInMaintenance reports whether a gateway is in a maintenance window
+func (s *Selector) gatewayInMaintenance(gatewayID string, now time.Time) bool {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	return s.inMaintenance(gatewayID, now)
+}
+
+// paymentMethodMismatch explains why a registered gateway cannot take the payment method asked for
+// in opts, or returns "" when it can or is not registered
+func (s *Selector) paymentMethodMismatch(gatewayID string, opts SelectionOptions) string {
+	s.lock.RLock()
+	provider, exists := s.providers[gatewayID]
+	s.lock.RUnlock()
+
+	if !exists {
+		return ""
+	}
+	return paymentMethodMismatch(provider, opts)
+}
+
+// paymentMethodMismatch explains why a provider cannot take the payment method asked for in opts,
+// or returns "" when it can
+func paymentMethodMismatch(provider Provider, opts SelectionOptions) string {
+	switch initiates := SupportsPaymentInitiation(provider); {
+	case opts.PaymentInitiation && !initiates:
+		return "does not initiate bank payments"
+	case !opts.PaymentInitiation && initiates:
+		return "only initiates bank payments"
+	}
+
+	switch paysOut := SupportsBankPayouts(provider); {
+	case opts.BankPayout && !paysOut:
+		return "does not pay out to linked bank accounts"
+	case !opts.BankPayout && paysOut:
+		return "only pays out to linked bank accounts"
+	}
+
+	switch debits := SupportsDirectDebit(provider); {
+	case opts.DirectDebit && !debits:
+		return "does not take direct debit mandates"
+	case !opts.DirectDebit && debits:
+		return "only takes transactions under a direct debit mandate"
+	}
+	return ""
+}
+
+// usableProvider returns the provider if it is registered, marked healthy and currently available
+func (s *Selector) usableProvider(providerID string) Provider {
+	s.lock.RLock()
+	provider, exists := s.providers[providerID]
+	isHealthy := s.healthStatus[providerID]
+	s.lock.RUnlock()
+
+	if !exists || !isHealthy || !provider.IsAvailable() {
+		return nil
+	}
+	return provider
+}
+
+// downgradedAhead reports whether a downgraded gateway would otherwise have been tried before the given priority
+func downgradedAhead(gateways []models.GatewayPriority, downgraded map[int]bool, priority int) bool {
+	for _, gw := range gateways {
+		if downgraded[gw.GatewayID] && gw.Priority < priority {
+			return true
+		}
+	}
+	return false
+}
+
+// selectionTrace collects the checks made during a traced selection
+type selectionTrace struct {
+	steps []models.RoutingTraceStep
+}
+
+// add records a step; it does nothing on a nil trace, so untraced selections pay nothing
+func (t *selectionTrace) add(gatewayID, priority int, outcome, format string, args ...interface{}) {
+	if t == nil {
+		return
+	}
+	t.steps = append(t.steps, models.RoutingTraceStep{
+		GatewayID: gatewayID,
+		Priority:  priority,
+		Outcome:   outcome,
+		Detail:    fmt.Sprintf(format, args...),
+	})
+}
+
+// atoi converts a gateway ID to an int, returning 0 for non-numeric IDs
+func atoi(s string) int {
+	i, _ := strconv.Atoi(s)
+	return i
 }
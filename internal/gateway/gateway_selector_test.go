@@ -0,0 +1,315 @@
+package gateway
+
+import (
+	"context"
+	"errors"
+	"payment-gateway/db"
+	"payment-gateway/internal/models"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newTestSelector creates a selector over the mock database with always-available providers
+func newTestSelector() *Selector {
+	selector := NewSelector(db.NewMockDB())
+	selector.RegisterProvider(NewMockProvider(1, "PayPal", "application/json", 1.0, 0))
+	selector.RegisterProvider(NewMockProvider(2, "Stripe", "application/json", 1.0, 0))
+	selector.RegisterProvider(NewMockProvider(3, "Adyen", "application/xml", 1.0, 0))
+	return selector
+}
+
+// TestSelectGatewayWithOptions tests preferred gateway and exclusion handling
+func TestSelectGatewayWithOptions(t *testing.T) {
+	tests := []struct {
+		name         string
+		opts         SelectionOptions
+		markDown     string
+		downgrade    string
+		wantGateway  string
+		wantOverride bool
+	}{
+		{name: "default priority", opts: SelectionOptions{}, wantGateway: "1"},
+		{name: "preferred honored", opts: SelectionOptions{PreferredGatewayID: "3"}, wantGateway: "3", wantOverride: true},
+		{name: "preferred unhealthy falls back", opts: SelectionOptions{PreferredGatewayID: "3"}, markDown: "3", wantGateway: "1"},
+		{name: "preferred unsupported falls back", opts: SelectionOptions{PreferredGatewayID: "9"}, wantGateway: "1"},
+		{name: "exclusion skips primary", opts: SelectionOptions{ExcludedGatewayIDs: []string{"1"}}, wantGateway: "2", wantOverride: true},
+		{name: "exclusion of lower priority has no effect", opts: SelectionOptions{ExcludedGatewayIDs: []string{"3"}}, wantGateway: "1"},
+		{name: "downgraded primary tried last", opts: SelectionOptions{}, downgrade: "1", wantGateway: "2"},
+		{name: "downgraded gateway used when no other is available", opts: SelectionOptions{ExcludedGatewayIDs: []string{"2", "3"}}, downgrade: "1", wantGateway: "1", wantOverride: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			selector := newTestSelector()
+			if tt.markDown != "" {
+				selector.MarkGatewayDown(tt.markDown)
+			}
+			if tt.downgrade != "" {
+				selector.DowngradeGateway(tt.downgrade, time.Now().Add(time.Minute))
+			}
+
+			provider, decision, err := selector.SelectGatewayWithOptions(context.Background(), 1, "deposit", tt.opts)
+			if err != nil {
+				t.Fatalf("Expected no error, got: %v", err)
+			}
+
+			if provider.ID() != tt.wantGateway {
+				t.Errorf("Expected gateway %s, got %s", tt.wantGateway, provider.ID())
+			}
+
+			if decision.Override != tt.wantOverride {
+				t.Errorf("Expected override=%v, got %v (reason: %s)", tt.wantOverride, decision.Override, decision.Reason)
+			}
+
+			if decision.Reason == "" {
+				t.Error("Expected routing decision to include a reason")
+			}
+		})
+	}
+}
+
+// TestSelectGatewayMaintenance tests that gateways are skipped only while a maintenance window is in progress
+func TestSelectGatewayMaintenance(t *testing.T) {
+	now := time.Now()
+	selector := newTestSelector()
+	selector.SetMaintenanceWindows([]models.MaintenanceWindow{
+		{ID: 1, GatewayID: 1, StartsAt: now.Add(-time.Minute), EndsAt: now.Add(time.Hour)},
+		{ID: 2, GatewayID: 2, StartsAt: now.Add(time.Hour), EndsAt: now.Add(2 * time.Hour)},
+		{ID: 3, GatewayID: 3, StartsAt: now.Add(-2 * time.Hour), EndsAt: now.Add(-time.Hour)},
+	})
+
+	provider, decision, err := selector.SelectGatewayWithOptions(context.Background(), 1, "deposit", SelectionOptions{})
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	if provider.ID() != "2" {
+		t.Errorf("Expected gateway 2 while gateway 1 is in maintenance, got %s", provider.ID())
+	}
+	if !strings.Contains(decision.Reason, "maintenance") {
+		t.Errorf("Expected reason to mention maintenance, got %q", decision.Reason)
+	}
+
+	provider, decision, err = selector.SelectGatewayWithOptions(context.Background(), 1, "deposit", SelectionOptions{PreferredGatewayID: "1"})
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	if provider.ID() != "2" || decision.Override {
+		t.Errorf("Expected preferred gateway in maintenance to fall back to gateway 2, got %s (override=%v)", provider.ID(), decision.Override)
+	}
+
+	// A window that has ended no longer applies
+	provider, _, err = selector.SelectGatewayWithOptions(context.Background(), 1, "deposit", SelectionOptions{PreferredGatewayID: "3"})
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	if provider.ID() != "3" {
+		t.Errorf("Expected gateway 3 after its window ended, got %s", provider.ID())
+	}
+
+	// Unlike a downgraded gateway, one in maintenance is not used as a last resort
+	_, _, err = selector.SelectGatewayWithOptions(context.Background(), 1, "deposit", SelectionOptions{ExcludedGatewayIDs: []string{"2", "3"}})
+	if !errors.Is(err, ErrNoAvailableGateway) {
+		t.Errorf("Expected ErrNoAvailableGateway, got: %v", err)
+	}
+
+	selector.SetMaintenanceWindows(nil)
+	provider, _, err = selector.SelectGatewayWithOptions(context.Background(), 1, "deposit", SelectionOptions{})
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	if provider.ID() != "1" {
+		t.Errorf("Expected gateway 1 once its window was removed, got %s", provider.ID())
+	}
+}
+
+// TestSelectGatewayWithRules tests that merchant rules prefer and exclude gateways within platform constraints
+func TestSelectGatewayWithRules(t *testing.T) {
+	rules := []models.RoutingRule{
+		{Position: 1, Field: RuleFieldCurrency, Operator: RuleOpEq, Value: "GBP", Action: RuleActionPrefer, GatewayID: 3},
+		{Position: 2, Field: RuleFieldAmount, Operator: RuleOpGt, Value: "1000", Action: RuleActionExclude, GatewayID: 1},
+		{Position: 3, Field: RuleFieldAmount, Operator: RuleOpGt, Value: "5000", Action: RuleActionExclude, GatewayID: 3},
+	}
+
+	tests := []struct {
+		name         string
+		opts         SelectionOptions
+		input        RuleInput
+		markDown     string
+		wantGateway  string
+		wantOverride bool
+		wantReason   string
+	}{
+		{name: "no rule matches", input: RuleInput{Amount: 100, Currency: "USD"}, wantGateway: "1", wantReason: "selected by priority order"},
+		{name: "rule preference honored", input: RuleInput{Amount: 100, Currency: "GBP"}, wantGateway: "3", wantOverride: true, wantReason: "preferred by merchant rule 1"},
+		{name: "rule exclusion skips primary", input: RuleInput{Amount: 2000, Currency: "USD"}, wantGateway: "2", wantOverride: true, wantReason: "excluded by merchant rules"},
+		{name: "exclusion beats preference", input: RuleInput{Amount: 6000, Currency: "GBP"}, wantGateway: "2", wantOverride: true},
+		{name: "request preference beats rule preference", opts: SelectionOptions{PreferredGatewayID: "2"}, input: RuleInput{Amount: 100, Currency: "GBP"}, wantGateway: "2", wantOverride: true, wantReason: "preferred gateway honored"},
+		{name: "request preference excluded by rule", opts: SelectionOptions{PreferredGatewayID: "1"}, input: RuleInput{Amount: 2000, Currency: "USD"}, wantGateway: "2", wantOverride: true, wantReason: "excluded by merchant rule 2"},
+		{name: "unhealthy preferred gateway falls back", input: RuleInput{Amount: 100, Currency: "GBP"}, markDown: "3", wantGateway: "1", wantReason: "preferred by merchant rule 1 is unavailable"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			selector := newTestSelector()
+			if tt.markDown != "" {
+				selector.MarkGatewayDown(tt.markDown)
+			}
+
+			opts := tt.opts
+			opts.Rules = rules
+			opts.RuleInput = tt.input
+			provider, decision, err := selector.SelectGatewayWithOptions(context.Background(), 1, "deposit", opts)
+			if err != nil {
+				t.Fatalf("Expected no error, got: %v", err)
+			}
+
+			if provider.ID() != tt.wantGateway {
+				t.Errorf("Expected gateway %s, got %s (reason: %s)", tt.wantGateway, provider.ID(), decision.Reason)
+			}
+			if decision.Override != tt.wantOverride {
+				t.Errorf("Expected override=%v, got %v (reason: %s)", tt.wantOverride, decision.Override, decision.Reason)
+			}
+			if !strings.Contains(decision.Reason, tt.wantReason) {
+				t.Errorf("Expected reason to contain %q, got %q", tt.wantReason, decision.Reason)
+			}
+		})
+	}
+
+	// Exclusions by the request and by rules add up
+	_, _, err := newTestSelector().SelectGatewayWithOptions(context.Background(), 1, "deposit", SelectionOptions{
+		ExcludedGatewayIDs: []string{"2", "3"},
+		Rules:              rules,
+		RuleInput:          RuleInput{Amount: 2000, Currency: "USD"},
+	})
+	if !errors.Is(err, ErrNoAvailableGateway) {
+		t.Errorf("Expected ErrNoAvailableGateway, got: %v", err)
+	}
+}
+
+// TestSelectGatewayWithOptionsConflicts tests invalid and exhaustive overrides
+func TestSelectGatewayWithOptionsConflicts(t *testing.T) {
+	selector := newTestSelector()
+
+	_, _, err := selector.SelectGatewayWithOptions(context.Background(), 1, "deposit", SelectionOptions{
+		PreferredGatewayID: "2",
+		ExcludedGatewayIDs: []string{"2"},
+	})
+	if !errors.Is(err, ErrInvalidGatewayOverride) {
+		t.Errorf("Expected ErrInvalidGatewayOverride, got: %v", err)
+	}
+
+	_, _, err = selector.SelectGatewayWithOptions(context.Background(), 1, "deposit", SelectionOptions{
+		ExcludedGatewayIDs: []string{"1", "2", "3"},
+	})
+	if !errors.Is(err, ErrNoAvailableGateway) {
+		t.Errorf("Expected ErrNoAvailableGateway, got: %v", err)
+	}
+}
+
+// TestTraceGatewaySelection tests that a traced selection records why each gateway was skipped or selected
+func TestTraceGatewaySelection(t *testing.T) {
+	selector := newTestSelector()
+	selector.MarkGatewayDown("2")
+	rules := []models.RoutingRule{
+		{Position: 1, Field: RuleFieldAmount, Operator: RuleOpGt, Value: "1000", Action: RuleActionExclude, GatewayID: 1},
+	}
+
+	provider, decision, trace, err := selector.TraceGatewaySelection(context.Background(), 1, "deposit", SelectionOptions{
+		Rules:     rules,
+		RuleInput: RuleInput{Type: "deposit", Amount: 2000, Currency: "USD", Country: "US"},
+	})
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	if provider.ID() != "3" || decision.SelectedGatewayID != 3 {
+		t.Fatalf("Expected gateway 3, got %s", provider.ID())
+	}
+
+	want := []struct {
+		gatewayID int
+		outcome   string
+		detail    string
+	}{
+		{0, TraceInfo, "3 gateways support country 1"},
+		{1, TraceInfo, "merchant rule 1 matched"},
+		{1, TraceSkipped, "excluded by merchant rule 1"},
+		{2, TraceSkipped, "marked down"},
+		{3, TraceSelected, "first available gateway"},
+	}
+	if len(trace) != len(want) {
+		t.Fatalf("Expected %d trace steps, got %+v", len(want), trace)
+	}
+	for i, step := range want {
+		if trace[i].GatewayID != step.gatewayID || trace[i].Outcome != step.outcome || !strings.Contains(trace[i].Detail, step.detail) {
+			t.Errorf("Expected step %d to be %s gateway %d (%q), got %+v", i, step.outcome, step.gatewayID, step.detail, trace[i])
+		}
+	}
+
+	// Untraced selections make the same choice
+	provider, _, err = selector.SelectGatewayWithOptions(context.Background(), 1, "deposit", SelectionOptions{Rules: rules, RuleInput: RuleInput{Amount: 2000}})
+	if err != nil || provider.ID() != "3" {
+		t.Errorf("Expected gateway 3 without tracing, got %v (%v)", provider, err)
+	}
+}
+
+// TestSelectGatewayPaymentInitiation tests that open banking providers only take bank payments
+func TestSelectGatewayPaymentInitiation(t *testing.T) {
+	selector := NewSelector(db.NewMockDB())
+	selector.RegisterProvider(NewMockOpenBankingProvider(1, "Open Banking", nil))
+	selector.RegisterProvider(NewMockProvider(2, "Stripe", "application/json", 1.0, 0))
+	ctx := context.Background()
+
+	provider, _, err := selector.SelectGatewayWithOptions(ctx, 1, "deposit", SelectionOptions{})
+	if err != nil || provider.ID() != "2" {
+		t.Errorf("Expected card deposits to skip the open banking gateway, got %v, %v", provider, err)
+	}
+
+	provider, _, err = selector.SelectGatewayWithOptions(ctx, 1, "deposit", SelectionOptions{PaymentInitiation: true})
+	if err != nil || provider.ID() != "1" {
+		t.Errorf("Expected bank payments to select the open banking gateway, got %v, %v", provider, err)
+	}
+
+	_, decision, steps, err := selector.TraceGatewaySelection(ctx, 1, "deposit", SelectionOptions{PreferredGatewayID: "2", PaymentInitiation: true})
+	if err != nil || decision.SelectedGatewayID != 1 {
+		t.Fatalf("Expected a preferred card gateway to be passed over for a bank payment, got %+v, %v", decision, err)
+	}
+	if !strings.Contains(decision.Reason, "does not initiate bank payments") {
+		t.Errorf("Expected the reason to explain the skipped preference, got %q", decision.Reason)
+	}
+	var skipped bool
+	for _, step := range steps {
+		skipped = skipped || (step.GatewayID == 2 && step.Outcome == TraceSkipped)
+	}
+	if !skipped {
+		t.Errorf("Expected the card gateway skipped in the trace, got %+v", steps)
+	}
+}
+
+// TestAvailableProviders tests that only gateways that could take a transaction now are listed,
+// in priority order with downgraded gateways last
+func TestAvailableProviders(t *testing.T) {
+	selector := newTestSelector()
+	ctx := context.Background()
+	now := time.Now()
+
+	selector.MarkGatewayDown("2")
+	selector.DowngradeGateway("3", now.Add(time.Hour))
+	providers, err := selector.AvailableProviders(ctx, 3)
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	if len(providers) != 2 || providers[0].ID() != "1" || providers[1].ID() != "3" {
+		t.Errorf("Expected gateways 1 and 3, the downgraded one last, got %v", providers)
+	}
+
+	selector.SetMaintenanceWindows([]models.MaintenanceWindow{{GatewayID: 1, StartsAt: now.Add(-time.Hour), EndsAt: now.Add(time.Hour)}})
+	providers, _ = selector.AvailableProviders(ctx, 3)
+	if len(providers) != 1 || providers[0].ID() != "3" {
+		t.Errorf("Expected gateways in maintenance to be left out, got %v", providers)
+	}
+
+	if providers, _ := selector.AvailableProviders(ctx, 4); len(providers) != 0 {
+		t.Errorf("Expected no gateways for an unsupported country, got %v", providers)
+	}
+}
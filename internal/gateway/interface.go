@@ -2,6 +2,7 @@ package gateway
 
 import (
 	"context"
+	"payment-gateway/internal/models"
 )
 
 // SelectorInterface defines the interface for gateway selectors
@@ -9,6 +10,18 @@ type SelectorInterface interface {
 	// SelectGateway selects the appropriate gateway based on country and transaction type
 	SelectGateway(ctx context.Context, countryID int, txType string) (Provider, error)
 
+	// SelectGatewayWithOptions selects a gateway honoring caller overrides and reports how it was chosen
+	SelectGatewayWithOptions(ctx context.Context, countryID int, txType string, opts SelectionOptions) (Provider, models.RoutingDecision, error)
+
+	// TraceGatewaySelection selects a gateway like SelectGatewayWithOptions and returns every check made
+	TraceGatewaySelection(ctx context.Context, countryID int, txType string, opts SelectionOptions) (Provider, models.RoutingDecision, []models.RoutingTraceStep, error)
+
+	// Providers returns the registered providers ordered by ID
+	Providers() []Provider
+
+	// AvailableProviders returns the providers that could take a transaction in a country now, in priority order
+	AvailableProviders(ctx context.Context, countryID int) ([]Provider, error)
+
 	// GetProviderByID returns a provider by its ID
 	GetProviderByID(id string) (Provider, error)
 
@@ -0,0 +1,332 @@
+package gateway
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"net"
+	"net/http"
+	"payment-gateway/internal/codec"
+	"payment-gateway/internal/consts"
+	"payment-gateway/internal/models"
+	"payment-gateway/internal/money"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+// ISO 8583 message type indicators and processing codes used by the card-switch adapter
+const (
+	ISO8583FinancialRequest  = "0200"
+	ISO8583FinancialResponse = "0210"
+
+	ISO8583ProcessingPurchase = "000000"
+	ISO8583ProcessingPayout   = "260000"
+	ISO8583ProcessingRefund   = "200000"
+
+	// ISO8583EntryModeWallet is the point of service entry mode of cards decrypted from wallet
+	// payment tokens: entered by electronic commerce, without a PIN
+	ISO8583EntryModeWallet = "812"
+
+	// DefaultISO8583CryptogramField is the private field wallet payment cryptograms are sent in
+	DefaultISO8583CryptogramField = 126
+)
+
+// ISO8583Config configures the connection to a card switch and the fields sent with every request
+type ISO8583Config struct {
+	Address    string        // host:port of the switch
+	Timeout    time.Duration // per-request dial and exchange timeout
+	AcquirerID string        // field 32
+	TerminalID string        // field 41
+	MerchantID string        // field 42, the card acceptor ID
+
+	// Fields overrides or extends the codec's field layout for this switch
+	Fields map[int]codec.FieldSpec
+
+	// StaticFields are sent unchanged with every request, e.g. merchant category code
+	StaticFields map[int]string
+
+	// CurrencyCodes maps ISO 4217 alphabetic codes to the numeric codes sent in field 49
+	CurrencyCodes map[string]string
+
+	// CryptogramField is the field the cryptogram of a card decrypted from a wallet payment token
+	// is sent in; DefaultISO8583CryptogramField when zero
+	CryptogramField int
+}
+
+// DefaultISO8583CurrencyCodes are the numeric currency codes used when none are configured
+var DefaultISO8583CurrencyCodes = map[string]string{
+	"USD": "840",
+	"EUR": "978",
+	"GBP": "826",
+	"JPY": "392",
+}
+
+// minorUnits converts an amount to the currency's minor unit, e.g. cents, as card networks and
+// most gateway APIs expect
+func minorUnits(amount float64, currency string) int64 {
+	return money.MinorUnits(amount, currency)
+}
+
+// ISO8583Provider is a gateway adapter for acquirers reached through an ISO 8583 card switch
+// over TCP. Each request opens a connection, sends one length-prefixed 0200 message and waits
+// for the matching 0210 response. The retrieval reference number is derived from the
+// transaction ID, so retried requests can be recognized by the switch.
+type ISO8583Provider struct {
+	id           string
+	name         string
+	config       ISO8583Config
+	codec        codec.ISO8583
+	declineCodes DeclineCodeMap
+	stan         uint32
+	available    atomic.Bool
+}
+
+// NewISO8583Provider creates a card-switch adapter
+func NewISO8583Provider(id int, name string, config ISO8583Config) *ISO8583Provider {
+	if config.Timeout <= 0 {
+		config.Timeout = 30 * time.Second
+	}
+	if config.CurrencyCodes == nil {
+		config.CurrencyCodes = DefaultISO8583CurrencyCodes
+	}
+	if config.CryptogramField == 0 {
+		config.CryptogramField = DefaultISO8583CryptogramField
+	}
+
+	p := &ISO8583Provider{
+		id:           strconv.Itoa(id),
+		name:         name,
+		config:       config,
+		codec:        codec.ISO8583{Fields: config.Fields},
+		declineCodes: ISO8583DeclineCodes,
+	}
+	p.available.Store(true)
+	return p
+}
+
+// ID returns the unique identifier of the gateway
+func (p *ISO8583Provider) ID() string {
+	return p.id
+}
+
+// Name returns the name of the gateway
+func (p *ISO8583Provider) Name() string {
+	return p.name
+}
+
+// DataFormat returns the data format supported by the gateway
+func (p *ISO8583Provider) DataFormat() string {
+	return codec.ISO8583{}.ContentTypes()[0]
+}
+
+// IsAvailable reports whether the last exchange with the switch succeeded
+func (p *ISO8583Provider) IsAvailable() bool {
+	return p.available.Load()
+}
+
+// SupportsCurrency reports whether the switch has a numeric code for a currency
+func (p *ISO8583Provider) SupportsCurrency(currency string) bool {
+	_, ok := p.config.CurrencyCodes[currency]
+	return ok
+}
+
+// ProcessDeposit sends a purchase to the switch
+func (p *ISO8583Provider) ProcessDeposit(ctx context.Context, transaction models.Transaction) (*models.TransactionResponse, error) {
+	return p.process(ctx, transaction, ISO8583ProcessingPurchase)
+}
+
+// ProcessWithdrawal sends a payout to the switch
+func (p *ISO8583Provider) ProcessWithdrawal(ctx context.Context, transaction models.Transaction) (*models.TransactionResponse, error) {
+	return p.process(ctx, transaction, ISO8583ProcessingPayout)
+}
+
+// ProcessRefund sends a refund of part or all of a purchase to the switch. Its retrieval
+// reference number is derived from the refund ID; the private data still names the purchase.
+func (p *ISO8583Provider) ProcessRefund(ctx context.Context, refund models.Refund, transaction models.Transaction) (*models.TransactionResponse, error) {
+	request, err := p.buildRequest(refundTransaction(refund, transaction), ISO8583ProcessingRefund)
+	if err != nil {
+		return nil, err
+	}
+	request.Fields[37] = fmt.Sprintf("R%011d", refund.ID)
+
+	return p.send(ctx, request, transaction.ID)
+}
+
+// ParseCallback parses an advice sent by the switch
+func (p *ISO8583Provider) ParseCallback(r *http.Request) (*models.CallbackData, error) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read callback: %w", err)
+	}
+
+	var callbackData models.CallbackData
+	if err := p.codec.Unmarshal(body, &callbackData); err != nil {
+		return nil, err
+	}
+	applyISO8583ResponseCode(&callbackData)
+
+	if callbackData.ReasonCode != "" {
+		callbackData.DeclineCode = p.declineCodes.Normalize(callbackData.ReasonCode)
+	}
+	callbackData.GatewayID = p.id
+	callbackData.Timestamp = time.Now().Format(time.RFC3339)
+
+	return &callbackData, nil
+}
+
+// process exchanges a financial request with the switch and maps the response code
+func (p *ISO8583Provider) process(ctx context.Context, transaction models.Transaction, processingCode string) (*models.TransactionResponse, error) {
+	request, err := p.buildRequest(transaction, processingCode)
+	if err != nil {
+		return nil, err
+	}
+
+	return p.send(ctx, request, transaction.ID)
+}
+
+// send exchanges a financial request for a transaction with the switch and maps its response
+func (p *ISO8583Provider) send(ctx context.Context, request codec.Message, transactionID int) (*models.TransactionResponse, error) {
+	response, err := p.exchange(ctx, request)
+	p.available.Store(err == nil)
+	if err != nil {
+		return nil, err
+	}
+
+	if response.MTI != ISO8583FinancialResponse ||
+		response.Fields[11] != request.Fields[11] ||
+		response.Fields[37] != request.Fields[37] {
+		return nil, fmt.Errorf("unexpected response from card switch: MTI %s, trace %s", response.MTI, response.Fields[11])
+	}
+
+	responseCode := response.Fields[39]
+	if responseCode != ISO8583Approved {
+		return nil, &DeclineError{
+			Code:         p.declineCodes.Normalize(responseCode),
+			ProviderCode: responseCode,
+			Message:      "Declined by card switch",
+		}
+	}
+
+	message := "Approved"
+	if authCode := response.Fields[38]; authCode != "" {
+		message = "Approved with authorization code " + authCode
+	}
+	return &models.TransactionResponse{
+		Status:           consts.Completed,
+		TransactionID:    transactionID,
+		Message:          message,
+		GatewayReference: response.Fields[37],
+	}, nil
+}
+
+// buildRequest maps a transaction to a 0200 financial request
+func (p *ISO8583Provider) buildRequest(transaction models.Transaction, processingCode string) (codec.Message, error) {
+	currency, ok := p.config.CurrencyCodes[transaction.Currency]
+	if !ok {
+		return codec.Message{}, fmt.Errorf("currency %s is not supported by the card switch", transaction.Currency)
+	}
+
+	amount := minorUnits(transaction.Amount, transaction.Currency)
+
+	now := time.Now().UTC()
+	stan := atomic.AddUint32(&p.stan, 1) % 1000000
+
+	msg := codec.Message{MTI: ISO8583FinancialRequest, Fields: make(map[int]string)}
+	for field, value := range p.config.StaticFields {
+		msg.Fields[field] = value
+	}
+	msg.Fields[3] = processingCode
+	msg.Fields[4] = strconv.FormatInt(amount, 10)
+	msg.Fields[7] = now.Format("0102150405")
+	msg.Fields[11] = fmt.Sprintf("%06d", stan)
+	msg.Fields[12] = now.Format("150405")
+	msg.Fields[13] = now.Format("0102")
+	msg.Fields[37] = fmt.Sprintf("%012d", transaction.ID)
+	msg.Fields[48] = strconv.Itoa(transaction.ID)
+	msg.Fields[49] = currency
+	if p.config.AcquirerID != "" {
+		msg.Fields[32] = p.config.AcquirerID
+	}
+	if p.config.TerminalID != "" {
+		msg.Fields[41] = p.config.TerminalID
+	}
+	if p.config.MerchantID != "" {
+		msg.Fields[42] = p.config.MerchantID
+	}
+
+	// Deposits paid with a wallet token carry the card decrypted from it and its cryptogram
+	if card := transaction.WalletCard; card != nil {
+		msg.Fields[2] = card.Number
+		msg.Fields[14] = fmt.Sprintf("%02d%02d", card.ExpiryYear%100, card.ExpiryMonth)
+		msg.Fields[22] = ISO8583EntryModeWallet
+		if card.Cryptogram != "" {
+			msg.Fields[p.config.CryptogramField] = card.Cryptogram
+		}
+	}
+
+	return msg, nil
+}
+
+// exchange sends a message to the switch and reads its response. Messages are framed by a
+// two-byte big-endian length header.
+func (p *ISO8583Provider) exchange(ctx context.Context, request codec.Message) (codec.Message, error) {
+	payload, err := p.codec.Encode(request)
+	if err != nil {
+		return codec.Message{}, fmt.Errorf("failed to pack request: %w", err)
+	}
+	if len(payload) > math.MaxUint16 {
+		return codec.Message{}, fmt.Errorf("request of %d bytes exceeds the frame size", len(payload))
+	}
+
+	dialer := net.Dialer{Timeout: p.config.Timeout}
+	conn, err := dialer.DialContext(ctx, "tcp", p.config.Address)
+	if err != nil {
+		return codec.Message{}, fmt.Errorf("failed to connect to card switch: %w", err)
+	}
+	defer conn.Close()
+
+	deadline := time.Now().Add(p.config.Timeout)
+	if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+		deadline = d
+	}
+	conn.SetDeadline(deadline)
+
+	frame := make([]byte, 2+len(payload))
+	binary.BigEndian.PutUint16(frame, uint16(len(payload)))
+	copy(frame[2:], payload)
+	if _, err := conn.Write(frame); err != nil {
+		return codec.Message{}, fmt.Errorf("failed to send request to card switch: %w", err)
+	}
+
+	var header [2]byte
+	if _, err := io.ReadFull(conn, header[:]); err != nil {
+		return codec.Message{}, fmt.Errorf("failed to read response from card switch: %w", err)
+	}
+	body := make([]byte, binary.BigEndian.Uint16(header[:]))
+	if _, err := io.ReadFull(conn, body); err != nil {
+		return codec.Message{}, fmt.Errorf("failed to read response from card switch: %w", err)
+	}
+
+	response, err := p.codec.Decode(body)
+	if err != nil {
+		return codec.Message{}, fmt.Errorf("failed to unpack response: %w", err)
+	}
+	return response, nil
+}
+
+// applyISO8583ResponseCode derives the status of a callback that reports its outcome only
+// through an ISO 8583 response code: approved completes the transaction, anything else fails it
+func applyISO8583ResponseCode(callbackData *models.CallbackData) {
+	if callbackData.Status != "" || callbackData.ReasonCode == "" {
+		return
+	}
+
+	callbackData.Status = consts.Failed
+	if callbackData.ReasonCode == ISO8583Approved {
+		callbackData.Status = consts.Completed
+		callbackData.ReasonCode = ""
+	}
+}
@@ -0,0 +1,138 @@
+package gateway
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"payment-gateway/internal/codec"
+	"payment-gateway/internal/consts"
+	"payment-gateway/internal/models"
+	"testing"
+	"time"
+)
+
+// switchFields is the layout of the switch-specific merchant category code used in these tests
+var switchFields = map[int]codec.FieldSpec{18: {Length: 4, Numeric: true}}
+
+// fakeSwitch answers each financial request with the response code chosen by respond
+func fakeSwitch(t *testing.T, respond func(req codec.Message) string) (string, <-chan codec.Message) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	requests := make(chan codec.Message, 10)
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+
+			var header [2]byte
+			if _, err := io.ReadFull(conn, header[:]); err != nil {
+				conn.Close()
+				continue
+			}
+			body := make([]byte, binary.BigEndian.Uint16(header[:]))
+			io.ReadFull(conn, body)
+
+			req, err := codec.ISO8583{Fields: switchFields}.Decode(body)
+			if err != nil {
+				conn.Close()
+				continue
+			}
+			requests <- req
+
+			resp := codec.Message{MTI: ISO8583FinancialResponse, Fields: map[int]string{
+				11: req.Fields[11],
+				37: req.Fields[37],
+				38: "A1B2C3",
+				39: respond(req),
+			}}
+			payload, _ := codec.EncodeISO8583(resp)
+			frame := make([]byte, 2+len(payload))
+			binary.BigEndian.PutUint16(frame, uint16(len(payload)))
+			copy(frame[2:], payload)
+			conn.Write(frame)
+			conn.Close()
+		}
+	}()
+
+	return listener.Addr().String(), requests
+}
+
+// TestISO8583ProviderApproval tests that transactions are packed into 0200 requests and approvals complete them
+func TestISO8583ProviderApproval(t *testing.T) {
+	address, requests := fakeSwitch(t, func(codec.Message) string { return ISO8583Approved })
+	provider := NewISO8583Provider(4, "CardSwitch", ISO8583Config{
+		Address:      address,
+		Timeout:      time.Second,
+		TerminalID:   "TERM0001",
+		StaticFields: map[int]string{18: "5999"},
+		Fields:       switchFields,
+	})
+
+	resp, err := provider.ProcessDeposit(context.Background(), models.Transaction{ID: 42, Amount: 10.5, Currency: "USD"})
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	if resp.Status != consts.Completed || resp.TransactionID != 42 {
+		t.Errorf("Unexpected response: %+v", resp)
+	}
+
+	req := <-requests
+	expected := map[int]string{3: ISO8583ProcessingPurchase, 4: "000000001050", 18: "5999", 37: "000000000042", 41: "TERM0001", 48: "42", 49: "840"}
+	if req.MTI != ISO8583FinancialRequest {
+		t.Errorf("Expected MTI %s, got %s", ISO8583FinancialRequest, req.MTI)
+	}
+	for field, value := range expected {
+		if req.Fields[field] != value {
+			t.Errorf("Expected field %d to be %q, got %q", field, value, req.Fields[field])
+		}
+	}
+}
+
+// TestISO8583ProviderWalletCard tests that deposits paid with a wallet token send the card
+// decrypted from it with its cryptogram
+func TestISO8583ProviderWalletCard(t *testing.T) {
+	address, requests := fakeSwitch(t, func(codec.Message) string { return ISO8583Approved })
+	provider := NewISO8583Provider(4, "CardSwitch", ISO8583Config{Address: address, Timeout: time.Second})
+
+	card := &models.WalletCard{Wallet: consts.WalletApplePay, Number: "4817499999991234", ExpiryMonth: 3, ExpiryYear: 2029,
+		Cryptogram: "AgAAAAAABk4DWZ4C28yUQAAAAAA=", AuthMethod: "CRYPTOGRAM_3DS"}
+	if _, err := provider.ProcessDeposit(context.Background(), models.Transaction{ID: 43, Amount: 10, Currency: "USD", WalletCard: card}); err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+
+	req := <-requests
+	expected := map[int]string{2: "4817499999991234", 14: "2903", 22: ISO8583EntryModeWallet, 126: "AgAAAAAABk4DWZ4C28yUQAAAAAA="}
+	for field, value := range expected {
+		if req.Fields[field] != value {
+			t.Errorf("Expected field %d to be %q, got %q", field, value, req.Fields[field])
+		}
+	}
+}
+
+// TestISO8583ProviderDecline tests that response codes map to normalized decline codes
+func TestISO8583ProviderDecline(t *testing.T) {
+	address, _ := fakeSwitch(t, func(codec.Message) string { return "51" })
+	provider := NewISO8583Provider(4, "CardSwitch", ISO8583Config{Address: address, Timeout: time.Second})
+
+	_, err := provider.ProcessWithdrawal(context.Background(), models.Transaction{ID: 7, Amount: 500, Currency: "JPY"})
+
+	var decline *DeclineError
+	if !errors.As(err, &decline) {
+		t.Fatalf("Expected DeclineError, got: %v", err)
+	}
+	if decline.Code != consts.DeclineInsufficientFunds || decline.ProviderCode != "51" {
+		t.Errorf("Unexpected decline: %+v", decline)
+	}
+
+	if _, err := provider.ProcessDeposit(context.Background(), models.Transaction{ID: 8, Amount: 1, Currency: "XXX"}); err == nil {
+		t.Error("Expected error for an unmapped currency, got none")
+	}
+}
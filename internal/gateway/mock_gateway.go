@@ -4,14 +4,26 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"io"
+	"math"
 	"math/rand"
 	"net/http"
+	"net/url"
+	"payment-gateway/internal/codec"
+	"payment-gateway/internal/consts"
 	"payment-gateway/internal/models"
 	"payment-gateway/internal/utils"
 	"strconv"
+	"sync"
 	"time"
 )
 
+// Amounts up to which the mock issuer grants SCA exemptions, after the PSD2 thresholds
+const (
+	mockLowValueExemptionLimit = 30.0
+	mockTRAExemptionLimit      = 500.0
+)
+
 // MockProvider implements the Provider interface for testing
 type MockProvider struct {
 	id             string
@@ -19,6 +31,22 @@ type MockProvider struct {
 	dataFormat     string
 	successRate    float64 // 0.0 to 1.0, simulates availability
 	processingTime time.Duration
+	declineCodes   DeclineCodeMap
+	environments   Environments
+
+	// processed caches responses by idempotency key, mimicking provider-side deduplication
+	processed map[string]*models.TransactionResponse
+	mu        sync.Mutex
+}
+
+// IsMock reports whether a provider is a mock, which answers transactions itself rather than
+// sending them to a real gateway
+func IsMock(provider Provider) bool {
+	switch provider.(type) {
+	case *MockProvider, *MockOpenBankingProvider:
+		return true
+	}
+	return false
 }
 
 // NewMockProvider creates a new mock provider
@@ -29,9 +57,28 @@ func NewMockProvider(id int, name, dataFormat string, successRate float64, proce
 		dataFormat:     dataFormat,
 		successRate:    successRate,
 		processingTime: processingTime,
+		declineCodes:   ISO8583DeclineCodes,
+		environments: Environments{
+			Sandbox: Environment{Name: EnvironmentSandbox, BaseURL: mockBaseURL(name)},
+		},
+		processed: make(map[string]*models.TransactionResponse),
 	}
 }
 
+// SetEnvironments configures the sandbox and production environments; environments without a
+// base URL use the mock's own
+func (p *MockProvider) SetEnvironments(environments Environments) {
+	if environments.Sandbox.BaseURL == "" {
+		environments.Sandbox.BaseURL = mockBaseURL(p.name)
+	}
+	if environments.Production != nil && environments.Production.BaseURL == "" {
+		production := *environments.Production
+		production.BaseURL = mockBaseURL(p.name)
+		environments.Production = &production
+	}
+	p.environments = environments
+}
+
 // ID returns the unique identifier of the gateway
 func (p *MockProvider) ID() string {
 	return p.id
@@ -54,6 +101,16 @@ func (p *MockProvider) IsAvailable() bool {
 
 // ProcessDeposit handles deposit transactions
 func (p *MockProvider) ProcessDeposit(ctx context.Context, transaction models.Transaction) (*models.TransactionResponse, error) {
+	// Replay the original result for a repeated idempotency key instead of charging again
+	if cached := p.lookupIdempotent(transaction.GatewayIdempotencyKey); cached != nil {
+		return cached, nil
+	}
+
+	env, err := p.environments.Select(transaction.Livemode)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", p.name, err)
+	}
+
 	// Simulate processing time
 	time.Sleep(p.processingTime)
 
@@ -70,6 +127,15 @@ func (p *MockProvider) ProcessDeposit(ctx context.Context, transaction models.Tr
 		return nil, fmt.Errorf("deposit processing failed: gateway unavailable")
 	}
 
+	// Simulate issuer declines for test amounts
+	if err := p.simulateDecline(transaction); err != nil {
+		return nil, err
+	}
+
+	if err := p.simulateSCAExemption(transaction); err != nil {
+		return nil, err
+	}
+
 	// Generate reference ID
 	referenceID := fmt.Sprintf("%s-%d-%d", p.name, transaction.ID, time.Now().Unix())
 
@@ -80,16 +146,43 @@ func (p *MockProvider) ProcessDeposit(ctx context.Context, transaction models.Tr
 		fmt.Printf("Processing deposit with masked data: %s\n", maskedData)
 	}
 
-	return &models.TransactionResponse{
-		Status:        "processing",
-		TransactionID: transaction.ID,
-		Message:       "Transaction is being processed",
-		RedirectURL:   fmt.Sprintf("https://%s.example.com/payment/%s", p.name, referenceID),
-	}, nil
+	response := &models.TransactionResponse{
+		Status:           "processing",
+		TransactionID:    transaction.ID,
+		Message:          "Transaction is being processed",
+		GatewayReference: referenceID,
+		RedirectURL:      hostedPaymentURL(fmt.Sprintf("%s/payment/%s", env.BaseURL, referenceID), transaction),
+	}
+	// Exempted deposits are authorized without sending the customer to a 3DS challenge
+	if transaction.SCAExemption != "" {
+		response.RedirectURL = ""
+	}
+	p.storeIdempotent(transaction.GatewayIdempotencyKey, response)
+
+	return response, nil
+}
+
+// SupportsSCAExemption reports whether the mock issuer can be asked for an SCA exemption
+func (p *MockProvider) SupportsSCAExemption(exemption string) bool {
+	return exemption == consts.SCAExemptionLowValue || exemption == consts.SCAExemptionTRA
+}
+
+// PaymentMethods returns the payment methods the mock takes: cards and mobile-money wallets
+func (p *MockProvider) PaymentMethods() []string {
+	return []string{consts.PaymentMethodCard, consts.PaymentMethodWallet}
 }
 
 // ProcessWithdrawal handles withdrawal transactions
 func (p *MockProvider) ProcessWithdrawal(ctx context.Context, transaction models.Transaction) (*models.TransactionResponse, error) {
+	// Replay the original result for a repeated idempotency key instead of paying out again
+	if cached := p.lookupIdempotent(transaction.GatewayIdempotencyKey); cached != nil {
+		return cached, nil
+	}
+
+	if _, err := p.environments.Select(transaction.Livemode); err != nil {
+		return nil, fmt.Errorf("%s: %w", p.name, err)
+	}
+
 	// Simulate processing time
 	time.Sleep(p.processingTime)
 
@@ -106,6 +199,14 @@ func (p *MockProvider) ProcessWithdrawal(ctx context.Context, transaction models
 		return nil, fmt.Errorf("withdrawal processing failed: gateway unavailable")
 	}
 
+	// Simulate issuer declines for test amounts
+	if err := p.simulateDecline(transaction); err != nil {
+		return nil, err
+	}
+
+	// Generate reference ID
+	referenceID := fmt.Sprintf("%s-%d-%d", p.name, transaction.ID, time.Now().Unix())
+
 	// Mask sensitive data for secure logging
 	txData, err := json.Marshal(transaction)
 	if err == nil {
@@ -113,35 +214,216 @@ func (p *MockProvider) ProcessWithdrawal(ctx context.Context, transaction models
 		fmt.Printf("Processing withdrawal with masked data: %s\n", maskedData)
 	}
 
-	return &models.TransactionResponse{
-		Status:        "processing",
-		TransactionID: transaction.ID,
-		Message:       "Withdrawal request is being processed",
-		RedirectURL:   "",
-	}, nil
+	response := &models.TransactionResponse{
+		Status:           "processing",
+		TransactionID:    transaction.ID,
+		Message:          "Withdrawal request is being processed",
+		GatewayReference: referenceID,
+	}
+	p.storeIdempotent(transaction.GatewayIdempotencyKey, response)
+
+	return response, nil
+}
+
+// ProcessRefund refunds a deposit at once, declining it like a transaction of the same amount
+func (p *MockProvider) ProcessRefund(ctx context.Context, refund models.Refund, transaction models.Transaction) (*models.TransactionResponse, error) {
+	// Replay the original result for a repeated idempotency key instead of refunding again
+	if cached := p.lookupIdempotent(refund.GatewayIdempotencyKey); cached != nil {
+		return cached, nil
+	}
+
+	if _, err := p.environments.Select(transaction.Livemode); err != nil {
+		return nil, fmt.Errorf("%s: %w", p.name, err)
+	}
+
+	time.Sleep(p.processingTime)
+
+	if rand.Float64() >= p.successRate {
+		return nil, fmt.Errorf("refund processing failed: gateway unavailable")
+	}
+	if err := p.simulateDecline(refundTransaction(refund, transaction)); err != nil {
+		return nil, err
+	}
+
+	response := &models.TransactionResponse{
+		Status:           consts.Completed,
+		TransactionID:    transaction.ID,
+		Message:          "Refund completed",
+		GatewayReference: fmt.Sprintf("%s-refund-%d-%d", p.name, refund.ID, time.Now().Unix()),
+	}
+	p.storeIdempotent(refund.GatewayIdempotencyKey, response)
+
+	return response, nil
+}
+
+// Authorize holds a deposit's amount at once, declining it like a deposit of the same amount
+func (p *MockProvider) Authorize(ctx context.Context, transaction models.Transaction) (*models.TransactionResponse, error) {
+	// Replay the original result for a repeated idempotency key instead of authorizing again
+	if cached := p.lookupIdempotent(transaction.GatewayIdempotencyKey); cached != nil {
+		return cached, nil
+	}
+
+	if _, err := p.environments.Select(transaction.Livemode); err != nil {
+		return nil, fmt.Errorf("%s: %w", p.name, err)
+	}
+
+	time.Sleep(p.processingTime)
+
+	if rand.Float64() >= p.successRate {
+		return nil, fmt.Errorf("authorization failed: gateway unavailable")
+	}
+	if err := p.simulateDecline(transaction); err != nil {
+		return nil, err
+	}
+
+	response := &models.TransactionResponse{
+		Status:           consts.Authorized,
+		TransactionID:    transaction.ID,
+		Message:          "Amount authorized",
+		GatewayReference: fmt.Sprintf("%s-auth-%d-%d", p.name, transaction.ID, time.Now().Unix()),
+	}
+	p.storeIdempotent(transaction.GatewayIdempotencyKey, response)
+
+	return response, nil
+}
+
+// Capture takes part or all of an authorized amount at once
+func (p *MockProvider) Capture(ctx context.Context, transaction models.Transaction, amount float64) (*models.TransactionResponse, error) {
+	return p.settleAuthorization(ctx, transaction, consts.Completed, "Authorization captured")
+}
+
+// CapturePart takes part of an authorized amount at once, keeping the rest authorized. The part
+// is declined like a deposit of the same amount.
+func (p *MockProvider) CapturePart(ctx context.Context, transaction models.Transaction, amount float64) (*models.TransactionResponse, error) {
+	if cached := p.lookupIdempotent(transaction.GatewayIdempotencyKey); cached != nil {
+		return cached, nil
+	}
+	if transaction.GatewayReference == "" {
+		return nil, fmt.Errorf("transaction %d has no %s reference", transaction.ID, p.name)
+	}
+
+	time.Sleep(p.processingTime)
+
+	if rand.Float64() >= p.successRate {
+		return nil, fmt.Errorf("partial capture failed: gateway unavailable")
+	}
+	part := transaction
+	part.Amount = amount
+	if err := p.simulateDecline(part); err != nil {
+		return nil, err
+	}
+
+	response := &models.TransactionResponse{
+		Status:           consts.Completed,
+		TransactionID:    transaction.ID,
+		Message:          "Partial capture completed",
+		GatewayReference: transaction.GatewayReference,
+	}
+	p.storeIdempotent(transaction.GatewayIdempotencyKey, response)
+
+	return response, nil
+}
+
+// Void releases an authorized amount at once
+func (p *MockProvider) Void(ctx context.Context, transaction models.Transaction) (*models.TransactionResponse, error) {
+	return p.settleAuthorization(ctx, transaction, consts.Voided, "Authorization voided")
+}
+
+// IncrementAuthorization raises an authorized amount at once, declining the new total like a
+// deposit of the same amount
+func (p *MockProvider) IncrementAuthorization(ctx context.Context, transaction models.Transaction, amount float64) (*models.TransactionResponse, error) {
+	if cached := p.lookupIdempotent(transaction.GatewayIdempotencyKey); cached != nil {
+		return cached, nil
+	}
+	if transaction.GatewayReference == "" {
+		return nil, fmt.Errorf("transaction %d has no %s reference", transaction.ID, p.name)
+	}
+
+	time.Sleep(p.processingTime)
+
+	if rand.Float64() >= p.successRate {
+		return nil, fmt.Errorf("authorization increment failed: gateway unavailable")
+	}
+	incremented := transaction
+	incremented.Amount = amount
+	if err := p.simulateDecline(incremented); err != nil {
+		return nil, err
+	}
+
+	response := &models.TransactionResponse{
+		Status:           consts.Authorized,
+		TransactionID:    transaction.ID,
+		Message:          "Authorization incremented",
+		GatewayReference: transaction.GatewayReference,
+		AuthorizedAmount: amount,
+	}
+	p.storeIdempotent(transaction.GatewayIdempotencyKey, response)
+
+	return response, nil
+}
+
+// AuthorizationValidity returns how long the mock issuer holds authorized amounts
+func (p *MockProvider) AuthorizationValidity() time.Duration {
+	return consts.DefaultAuthorizationValidity
+}
+
+// settleAuthorization captures or voids an authorization, answering with status
+func (p *MockProvider) settleAuthorization(ctx context.Context, transaction models.Transaction, status, message string) (*models.TransactionResponse, error) {
+	if cached := p.lookupIdempotent(transaction.GatewayIdempotencyKey); cached != nil {
+		return cached, nil
+	}
+	if transaction.GatewayReference == "" {
+		return nil, fmt.Errorf("transaction %d has no %s reference", transaction.ID, p.name)
+	}
+
+	time.Sleep(p.processingTime)
+
+	if rand.Float64() >= p.successRate {
+		return nil, fmt.Errorf("%s failed: gateway unavailable", status)
+	}
+
+	response := &models.TransactionResponse{
+		Status:           status,
+		TransactionID:    transaction.ID,
+		Message:          message,
+		GatewayReference: transaction.GatewayReference,
+	}
+	p.storeIdempotent(transaction.GatewayIdempotencyKey, response)
+
+	return response, nil
 }
 
 // ParseCallback parses callback request from the gateway
 func (p *MockProvider) ParseCallback(r *http.Request) (*models.CallbackData, error) {
+	// Callbacks without a content type are in the gateway's declared format
 	contentType := r.Header.Get("Content-Type")
+	if contentType == "" {
+		contentType = p.dataFormat
+	}
 
-	var callbackData models.CallbackData
-	var err error
-
-	switch contentType {
-	case "application/json", "":
-		err = json.NewDecoder(r.Body).Decode(&callbackData)
-	case "application/xml", "text/xml":
-		// For simplicity, we're using a generic parser that would be replaced with a proper XML parser
-		err = fmt.Errorf("XML parsing not implemented in this mock")
-	default:
-		err = fmt.Errorf("unsupported content type: %s", contentType)
+	c, err := codec.Lookup(contentType)
+	if err != nil {
+		return nil, err
 	}
 
+	body, err := io.ReadAll(r.Body)
 	if err != nil {
+		return nil, fmt.Errorf("failed to read callback: %w", err)
+	}
+
+	var callbackData models.CallbackData
+	if err := c.Unmarshal(body, &callbackData); err != nil {
 		return nil, err
 	}
 
+	// Card switches report the outcome only through the ISO 8583 response code
+	applyISO8583ResponseCode(&callbackData)
+
+	// Map the gateway's own decline code into the normalized taxonomy
+	if callbackData.ReasonCode != "" {
+		callbackData.DeclineCode = p.declineCodes.Normalize(callbackData.ReasonCode)
+	}
+
 	// Set gateway ID if not provided in callback
 	if callbackData.GatewayID == "" {
 		callbackData.GatewayID = p.id
@@ -154,3 +436,99 @@ func (p *MockProvider) ParseCallback(r *http.Request) (*models.CallbackData, err
 
 	return &callbackData, nil
 }
+
+// simulateDecline declines transactions whose amount has cents matching an ISO 8583 decline
+// code (e.g. 10.51 for insufficient funds), mirroring the test amounts real sandboxes offer
+func (p *MockProvider) simulateDecline(transaction models.Transaction) error {
+	return simulateDecline(p.name, p.declineCodes, transaction)
+}
+
+// simulateSCAExemption rejects requested SCA exemptions for amounts above the exemption's limit
+func (p *MockProvider) simulateSCAExemption(transaction models.Transaction) error {
+	limit := 0.0
+	switch transaction.SCAExemption {
+	case "":
+		return nil
+	case consts.SCAExemptionLowValue:
+		limit = mockLowValueExemptionLimit
+	case consts.SCAExemptionTRA:
+		limit = mockTRAExemptionLimit
+	}
+
+	if transaction.Amount <= limit {
+		return nil
+	}
+	return &SCAExemptionRejectedError{
+		Exemption: transaction.SCAExemption,
+		Message:   fmt.Sprintf("%s requires authentication above %.2f", p.name, limit),
+	}
+}
+
+// lookupIdempotent returns a copy of the response previously recorded for an idempotency key
+func (p *MockProvider) lookupIdempotent(key string) *models.TransactionResponse {
+	if key == "" {
+		return nil
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if response, exists := p.processed[key]; exists {
+		responseCopy := *response
+		return &responseCopy
+	}
+	return nil
+}
+
+// storeIdempotent records the response for an idempotency key
+func (p *MockProvider) storeIdempotent(key string, response *models.TransactionResponse) {
+	if key == "" {
+		return
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	responseCopy := *response
+	p.processed[key] = &responseCopy
+}
+
+// simulateDecline declines a transaction whose amount has cents matching one of a provider's
+// decline codes
+func simulateDecline(name string, declineCodes DeclineCodeMap, transaction models.Transaction) error {
+	cents := int(math.Round(transaction.Amount*100)) % 100
+	providerCode := fmt.Sprintf("%02d", cents)
+
+	code, ok := declineCodes[providerCode]
+	if !ok {
+		return nil
+	}
+
+	return &DeclineError{
+		Code:         code,
+		ProviderCode: providerCode,
+		Message:      fmt.Sprintf("%s declined the transaction", name),
+	}
+}
+
+// mockBaseURL returns the base URL a mock provider uses when none is configured
+func mockBaseURL(name string) string {
+	return fmt.Sprintf("https://%s.example.com", name)
+}
+
+// hostedPaymentURL embeds the transaction's return and cancel URLs in a hosted-payment redirect
+func hostedPaymentURL(base string, transaction models.Transaction) string {
+	if transaction.ReturnURL == "" && transaction.CancelURL == "" {
+		return base
+	}
+
+	params := url.Values{}
+	if transaction.ReturnURL != "" {
+		params.Set("return_url", transaction.ReturnURL)
+	}
+	if transaction.CancelURL != "" {
+		params.Set("cancel_url", transaction.CancelURL)
+	}
+
+	return base + "?" + params.Encode()
+}
@@ -0,0 +1,258 @@
+package gateway
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"payment-gateway/internal/codec"
+	"payment-gateway/internal/consts"
+	"payment-gateway/internal/models"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// mockConsentTTL is how long customers have to authorise a consent at the mock bank
+const mockConsentTTL = 15 * time.Minute
+
+// MockOpenBankingProvider implements PaymentInitiationProvider for testing. It mimics a PIS
+// sandbox: consents are authorised on the bank's page, which redirects the customer back with an
+// authorisation code, and each consent's payment can be executed once. Payments settle at once,
+// as instant payment schemes do, and are declined for the same test amounts as MockProvider.
+type MockOpenBankingProvider struct {
+	id           string
+	name         string
+	banks        []models.Bank
+	declineCodes DeclineCodeMap
+	environments Environments
+
+	mu       sync.Mutex
+	consents map[string]*mockConsent // by external ID
+	now      func() time.Time
+}
+
+// mockConsent is a consent as the mock bank knows it
+type mockConsent struct {
+	transactionID int
+	bankID        string
+	amount        float64
+	expiresAt     time.Time
+	executed      bool
+}
+
+// NewMockOpenBankingProvider creates a mock open banking provider connecting to the given banks
+func NewMockOpenBankingProvider(id int, name string, banks []models.Bank) *MockOpenBankingProvider {
+	directory := make([]models.Bank, len(banks))
+	for i, bank := range banks {
+		bank.GatewayID = id
+		directory[i] = bank
+	}
+
+	p := &MockOpenBankingProvider{
+		id:           strconv.Itoa(id),
+		name:         name,
+		banks:        directory,
+		declineCodes: ISO8583DeclineCodes,
+		consents:     make(map[string]*mockConsent),
+		now:          time.Now,
+	}
+	p.SetEnvironments(Environments{Sandbox: Environment{Name: EnvironmentSandbox}})
+	return p
+}
+
+// SetEnvironments configures the sandbox and production environments; environments without a
+// base URL use the mock's own
+func (p *MockOpenBankingProvider) SetEnvironments(environments Environments) {
+	if environments.Sandbox.BaseURL == "" {
+		environments.Sandbox.BaseURL = mockBaseURL(p.slug())
+	}
+	if environments.Production != nil && environments.Production.BaseURL == "" {
+		production := *environments.Production
+		production.BaseURL = mockBaseURL(p.slug())
+		environments.Production = &production
+	}
+	p.environments = environments
+}
+
+// ID returns the unique identifier of the gateway
+func (p *MockOpenBankingProvider) ID() string {
+	return p.id
+}
+
+// Name returns the name of the gateway
+func (p *MockOpenBankingProvider) Name() string {
+	return p.name
+}
+
+// DataFormat returns the data format supported by the gateway
+func (p *MockOpenBankingProvider) DataFormat() string {
+	return "application/json"
+}
+
+// IsAvailable checks if the gateway is currently available
+func (p *MockOpenBankingProvider) IsAvailable() bool {
+	return true
+}
+
+// ProcessDeposit refuses deposits without a consent; they are made with CreateConsent and ExecutePayment
+func (p *MockOpenBankingProvider) ProcessDeposit(ctx context.Context, transaction models.Transaction) (*models.TransactionResponse, error) {
+	return nil, fmt.Errorf("%s: deposits require a payment consent authorised at the customer's bank", p.name)
+}
+
+// ProcessWithdrawal refuses withdrawals, which payment initiation cannot make
+func (p *MockOpenBankingProvider) ProcessWithdrawal(ctx context.Context, transaction models.Transaction) (*models.TransactionResponse, error) {
+	return nil, fmt.Errorf("%s: withdrawals are not supported", p.name)
+}
+
+// ProcessRefund refuses refunds. Open banking payments are pushed from the payer's bank and can
+// only be paid back by a payout.
+func (p *MockOpenBankingProvider) ProcessRefund(ctx context.Context, refund models.Refund, transaction models.Transaction) (*models.TransactionResponse, error) {
+	return nil, fmt.Errorf("%s: %w", p.name, ErrRefundUnsupported)
+}
+
+// ParseCallback parses a payment status callback from the gateway
+func (p *MockOpenBankingProvider) ParseCallback(r *http.Request) (*models.CallbackData, error) {
+	c, err := codec.Lookup(p.DataFormat())
+	if err != nil {
+		return nil, err
+	}
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read callback: %w", err)
+	}
+
+	var callbackData models.CallbackData
+	if err := c.Unmarshal(body, &callbackData); err != nil {
+		return nil, err
+	}
+	if callbackData.ReasonCode != "" {
+		callbackData.DeclineCode = p.declineCodes.Normalize(callbackData.ReasonCode)
+	}
+	if callbackData.GatewayID == "" {
+		callbackData.GatewayID = p.id
+	}
+	if callbackData.Timestamp == "" {
+		callbackData.Timestamp = p.now().Format(time.RFC3339)
+	}
+
+	return &callbackData, nil
+}
+
+// Banks returns the banks customers can pay from in a country, or in every country for ""
+func (p *MockOpenBankingProvider) Banks(ctx context.Context, countryCode string) ([]models.Bank, error) {
+	banks := []models.Bank{}
+	for _, bank := range p.banks {
+		if countryCode == "" || strings.EqualFold(bank.CountryCode, countryCode) {
+			banks = append(banks, bank)
+		}
+	}
+	return banks, nil
+}
+
+// CreateConsent creates a consent to a deposit from transaction.BankID, authorised on the mock
+// bank's page
+func (p *MockOpenBankingProvider) CreateConsent(ctx context.Context, transaction models.Transaction, redirectURI, state string) (*Consent, error) {
+	env, err := p.environments.Select(transaction.Livemode)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", p.name, err)
+	}
+
+	bank, ok := p.bank(transaction.BankID)
+	if !ok {
+		return nil, fmt.Errorf("%s: unknown bank %q", p.name, transaction.BankID)
+	}
+	if !supportsCurrency(bank, transaction.Currency) {
+		return nil, fmt.Errorf("%s: %s does not support %s payments", p.name, bank.Name, transaction.Currency)
+	}
+
+	externalID := fmt.Sprintf("%s-consent-%d-%d", p.slug(), transaction.ID, p.now().UnixNano())
+	expiresAt := p.now().Add(mockConsentTTL)
+
+	p.mu.Lock()
+	p.consents[externalID] = &mockConsent{
+		transactionID: transaction.ID,
+		bankID:        bank.ID,
+		amount:        transaction.Amount,
+		expiresAt:     expiresAt,
+	}
+	p.mu.Unlock()
+
+	params := url.Values{}
+	params.Set("consent_id", externalID)
+	params.Set("bank_id", bank.ID)
+	params.Set("redirect_uri", redirectURI)
+	params.Set("state", state)
+
+	return &Consent{
+		ExternalID:       externalID,
+		AuthorisationURL: env.BaseURL + "/authorise?" + params.Encode(),
+		ExpiresAt:        expiresAt,
+	}, nil
+}
+
+// ExecutePayment executes an authorised consent's payment. A consent can be executed once, and
+// consents authorised after they expired are declined.
+func (p *MockOpenBankingProvider) ExecutePayment(ctx context.Context, transaction models.Transaction, consent models.PaymentConsent, authorisationCode string) (*models.TransactionResponse, error) {
+	if authorisationCode == "" {
+		return nil, errors.New("authorisation code is required")
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	known, ok := p.consents[consent.ExternalID]
+	if !ok || known.transactionID != transaction.ID {
+		return nil, fmt.Errorf("%s: unknown consent %s", p.name, consent.ExternalID)
+	}
+	if known.executed {
+		return nil, fmt.Errorf("%s: consent %s was already executed", p.name, consent.ExternalID)
+	}
+	known.executed = true
+
+	if p.now().After(known.expiresAt) {
+		return nil, &DeclineError{
+			Code:    consts.DeclineConsentExpired,
+			Message: fmt.Sprintf("%s consent %s expired before it was authorised", p.name, consent.ExternalID),
+		}
+	}
+	if err := simulateDecline(p.name, p.declineCodes, models.Transaction{Amount: known.amount}); err != nil {
+		return nil, err
+	}
+
+	return &models.TransactionResponse{
+		Status:           consts.Completed,
+		TransactionID:    transaction.ID,
+		Message:          "Payment executed",
+		GatewayReference: fmt.Sprintf("%s-payment-%d-%d", p.slug(), transaction.ID, p.now().Unix()),
+	}, nil
+}
+
+// bank returns a bank of the provider's directory by ID
+func (p *MockOpenBankingProvider) bank(id string) (models.Bank, bool) {
+	for _, bank := range p.banks {
+		if bank.ID == id {
+			return bank, true
+		}
+	}
+	return models.Bank{}, false
+}
+
+// slug returns the provider's name as used in its references
+func (p *MockOpenBankingProvider) slug() string {
+	return strings.ToLower(strings.ReplaceAll(p.name, " ", "-"))
+}
+
+// supportsCurrency reports whether customers can pay from a bank in a currency
+func supportsCurrency(bank models.Bank, currency string) bool {
+	for _, supported := range bank.Currencies {
+		if strings.EqualFold(supported, currency) {
+			return true
+		}
+	}
+	return false
+}
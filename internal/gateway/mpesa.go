@@ -0,0 +1,512 @@
+package gateway
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"payment-gateway/internal/consts"
+	"payment-gateway/internal/httpclient"
+	"payment-gateway/internal/models"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// M-Pesa Daraja API settings
+const (
+	MpesaSandboxURL    = "https://sandbox.safaricom.co.ke"
+	MpesaProductionURL = "https://api.safaricom.co.ke"
+
+	// MpesaResultSuccess is the result code of completed STK Push payments and B2C payments
+	MpesaResultSuccess = 0
+
+	// mpesaTimestampFormat is the format of STK Push timestamps, in East Africa Time
+	mpesaTimestampFormat = "20060102150405"
+
+	// mpesaTokenMargin is how long before its expiry an access token is renewed
+	mpesaTokenMargin = time.Minute
+)
+
+// mpesaZone is East Africa Time, which STK Push passwords are computed in
+var mpesaZone = time.FixedZone("EAT", 3*60*60)
+
+// MpesaConfig configures the M-Pesa provider. The API key of each environment is the Daraja
+// app's "<consumer key>:<consumer secret>", exchanged for access tokens.
+type MpesaConfig struct {
+	// ShortCode is the paybill or till number deposits are paid to and withdrawals paid from
+	ShortCode string
+
+	// PassKey is the Lipa Na M-Pesa Online pass key STK Push passwords are derived from
+	PassKey string
+
+	// TransactionType of STK Push deposits: "CustomerPayBillOnline" for paybills (the default) or
+	// "CustomerBuyGoodsOnline" for tills
+	TransactionType string
+
+	// InitiatorName and SecurityCredential authorise B2C withdrawals. The credential is the
+	// initiator's password encrypted with Safaricom's public certificate, as generated on the
+	// Daraja portal.
+	InitiatorName      string
+	SecurityCredential string
+
+	// CommandID of B2C withdrawals: "BusinessPayment" (the default), "SalaryPayment" or "PromotionPayment"
+	CommandID string
+
+	// CallbackURL is the gateway's callback endpoint, /callback/<gateway ID>. STK Push results,
+	// B2C results and B2C queue timeouts are all sent to it.
+	CallbackURL string
+
+	Timeout time.Duration // per attempt; 30 seconds when zero
+}
+
+// MpesaProvider is a gateway adapter for Safaricom's M-Pesa Daraja API. Deposits are STK Push
+// requests prompting the customer to approve the payment on their phone, and withdrawals are
+// B2C payments to the customer's wallet; both identify the wallet by the transaction's phone
+// number and settle by callback. Safaricom's callbacks don't carry the transaction ID, so they
+// are matched to transactions by the gateway reference: the CheckoutRequestID of deposits and
+// the ConversationID of withdrawals.
+type MpesaProvider struct {
+	id           string
+	name         string
+	config       MpesaConfig
+	client       *httpclient.Client
+	declineCodes DeclineCodeMap
+	environments Environments
+	available    atomic.Bool
+
+	tokenMu sync.Mutex
+	tokens  map[string]mpesaToken // access tokens by environment name
+}
+
+// mpesaToken is an OAuth access token and when it expires
+type mpesaToken struct {
+	value     string
+	expiresAt time.Time
+}
+
+// NewMpesaProvider creates an M-Pesa provider. SetEnvironments must be called with the Daraja
+// app's credentials before it processes transactions.
+func NewMpesaProvider(id int, name string, config MpesaConfig) *MpesaProvider {
+	if config.TransactionType == "" {
+		config.TransactionType = "CustomerPayBillOnline"
+	}
+	if config.CommandID == "" {
+		config.CommandID = "BusinessPayment"
+	}
+
+	clientConfig := httpclient.DefaultConfig(name)
+	if config.Timeout > 0 {
+		clientConfig.Timeout = config.Timeout
+	}
+
+	p := &MpesaProvider{
+		id:           strconv.Itoa(id),
+		name:         name,
+		config:       config,
+		client:       httpclient.New(clientConfig),
+		declineCodes: MpesaDeclineCodes,
+		tokens:       make(map[string]mpesaToken),
+	}
+	p.SetEnvironments(Environments{Sandbox: Environment{Name: EnvironmentSandbox}})
+	p.available.Store(true)
+	return p
+}
+
+// SetEnvironments configures the sandbox and production environments; environments without a
+// base URL use Safaricom's sandbox and production APIs
+func (p *MpesaProvider) SetEnvironments(environments Environments) {
+	if environments.Sandbox.BaseURL == "" {
+		environments.Sandbox.BaseURL = MpesaSandboxURL
+	}
+	if environments.Production != nil && environments.Production.BaseURL == "" {
+		production := *environments.Production
+		production.BaseURL = MpesaProductionURL
+		environments.Production = &production
+	}
+	p.environments = environments
+
+	p.tokenMu.Lock()
+	p.tokens = make(map[string]mpesaToken)
+	p.tokenMu.Unlock()
+}
+
+// ID returns the unique identifier of the gateway
+func (p *MpesaProvider) ID() string {
+	return p.id
+}
+
+// Name returns the name of the gateway
+func (p *MpesaProvider) Name() string {
+	return p.name
+}
+
+// DataFormat returns the data format supported by the gateway
+func (p *MpesaProvider) DataFormat() string {
+	return "application/json"
+}
+
+// IsAvailable reports whether the last request reached Safaricom
+func (p *MpesaProvider) IsAvailable() bool {
+	return p.available.Load()
+}
+
+// PaymentMethods returns the payment methods M-Pesa takes: mobile-money wallets
+func (p *MpesaProvider) PaymentMethods() []string {
+	return []string{consts.PaymentMethodWallet}
+}
+
+// SupportsCurrency reports whether M-Pesa takes a currency; it takes Kenyan shillings only
+func (p *MpesaProvider) SupportsCurrency(currency string) bool {
+	return currency == "KES"
+}
+
+// ProcessDeposit sends an STK Push request, prompting the customer to enter their M-Pesa PIN on
+// their phone. The outcome arrives as a callback once they approve or cancel it.
+func (p *MpesaProvider) ProcessDeposit(ctx context.Context, transaction models.Transaction) (*models.TransactionResponse, error) {
+	msisdn, amount, err := p.walletAmount(transaction)
+	if err != nil {
+		return nil, err
+	}
+
+	timestamp := time.Now().In(mpesaZone).Format(mpesaTimestampFormat)
+	request := mpesaSTKPushRequest{
+		BusinessShortCode: p.config.ShortCode,
+		Password:          base64.StdEncoding.EncodeToString([]byte(p.config.ShortCode + p.config.PassKey + timestamp)),
+		Timestamp:         timestamp,
+		TransactionType:   p.config.TransactionType,
+		Amount:            amount,
+		PartyA:            msisdn,
+		PartyB:            p.config.ShortCode,
+		PhoneNumber:       msisdn,
+		CallBackURL:       p.config.CallbackURL,
+		AccountReference:  mpesaAccountReference(transaction),
+		TransactionDesc:   "Deposit " + strconv.Itoa(transaction.ID),
+	}
+
+	var result struct {
+		CheckoutRequestID   string `json:"CheckoutRequestID"`
+		ResponseCode        string `json:"ResponseCode"`
+		ResponseDescription string `json:"ResponseDescription"`
+	}
+	if err := p.post(ctx, transaction, "/mpesa/stkpush/v1/processrequest", request, &result); err != nil {
+		return nil, err
+	}
+	if result.ResponseCode != "0" {
+		return nil, fmt.Errorf("m-pesa refused the STK Push request: %s: %s", result.ResponseCode, result.ResponseDescription)
+	}
+
+	return &models.TransactionResponse{
+		Status:           consts.Processing,
+		TransactionID:    transaction.ID,
+		GatewayReference: result.CheckoutRequestID,
+		Message:          "Approve the payment on your phone",
+	}, nil
+}
+
+// ProcessWithdrawal sends a B2C payment from the short code to the customer's wallet. The
+// transaction's idempotency key is sent as the OriginatorConversationID, so Safaricom refuses a
+// retried request as a duplicate.
+func (p *MpesaProvider) ProcessWithdrawal(ctx context.Context, transaction models.Transaction) (*models.TransactionResponse, error) {
+	msisdn, amount, err := p.walletAmount(transaction)
+	if err != nil {
+		return nil, err
+	}
+
+	request := mpesaB2CRequest{
+		OriginatorConversationID: transaction.GatewayIdempotencyKey,
+		InitiatorName:            p.config.InitiatorName,
+		SecurityCredential:       p.config.SecurityCredential,
+		CommandID:                p.config.CommandID,
+		Amount:                   amount,
+		PartyA:                   p.config.ShortCode,
+		PartyB:                   msisdn,
+		Remarks:                  "Withdrawal " + strconv.Itoa(transaction.ID),
+		QueueTimeOutURL:          p.config.CallbackURL,
+		ResultURL:                p.config.CallbackURL,
+		Occasion:                 transaction.ReferenceID,
+	}
+
+	var result struct {
+		ConversationID      string `json:"ConversationID"`
+		ResponseCode        string `json:"ResponseCode"`
+		ResponseDescription string `json:"ResponseDescription"`
+	}
+	if err := p.post(ctx, transaction, "/mpesa/b2c/v3/paymentrequest", request, &result); err != nil {
+		return nil, err
+	}
+	if result.ResponseCode != "0" {
+		return nil, fmt.Errorf("m-pesa refused the B2C request: %s: %s", result.ResponseCode, result.ResponseDescription)
+	}
+
+	return &models.TransactionResponse{
+		Status:           consts.Processing,
+		TransactionID:    transaction.ID,
+		GatewayReference: result.ConversationID,
+	}, nil
+}
+
+// ProcessRefund refuses refunds. STK Push payments are refunded by a B2C payment, made as a
+// withdrawal.
+func (p *MpesaProvider) ProcessRefund(ctx context.Context, refund models.Refund, transaction models.Transaction) (*models.TransactionResponse, error) {
+	return nil, fmt.Errorf("%s: %w", p.name, ErrRefundUnsupported)
+}
+
+// ParseCallback maps an STK Push callback, a B2C result or a B2C queue timeout to the outcome of
+// the transaction. None carry the transaction ID; the gateway reference identifies it. Safaricom
+// doesn't sign callbacks, so deployments should only accept them from Safaricom's addresses.
+func (p *MpesaProvider) ParseCallback(r *http.Request) (*models.CallbackData, error) {
+	var callback struct {
+		Body *struct {
+			STKCallback mpesaSTKCallback `json:"stkCallback"`
+		} `json:"Body"`
+		Result *mpesaB2CResult `json:"Result"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&callback); err != nil {
+		return nil, fmt.Errorf("invalid M-Pesa callback: %w", err)
+	}
+
+	var callbackData *models.CallbackData
+	switch {
+	case callback.Body != nil && callback.Body.STKCallback.CheckoutRequestID != "":
+		stk := callback.Body.STKCallback
+		callbackData = p.callbackData(stk.CheckoutRequestID, stk.ResultCode, stk.ResultDesc)
+		if receipt, ok := stk.CallbackMetadata.value("MpesaReceiptNumber"); ok {
+			callbackData.Message = "M-Pesa receipt " + receipt
+		}
+	case callback.Result != nil && callback.Result.ConversationID != "":
+		result := callback.Result
+		callbackData = p.callbackData(result.ConversationID, result.ResultCode, result.ResultDesc)
+		if result.ResultCode == MpesaResultSuccess && result.TransactionID != "" {
+			callbackData.Message = "M-Pesa receipt " + result.TransactionID
+		}
+	default:
+		return nil, errors.New("m-pesa callback is neither an STK Push callback nor a B2C result")
+	}
+
+	return callbackData, nil
+}
+
+// callbackData maps the result code of a callback identified by a gateway reference
+func (p *MpesaProvider) callbackData(reference string, resultCode int, resultDesc string) *models.CallbackData {
+	callbackData := &models.CallbackData{
+		GatewayReference: reference,
+		GatewayID:        p.id,
+		Status:           consts.Completed,
+	}
+	if resultCode != MpesaResultSuccess {
+		callbackData.Status = consts.Failed
+		callbackData.ReasonCode = strconv.Itoa(resultCode)
+		callbackData.DeclineCode = p.declineCodes.Normalize(callbackData.ReasonCode)
+		callbackData.Message = resultDesc
+	}
+	return callbackData
+}
+
+// walletAmount returns the transaction's wallet as an MSISDN without the leading +, and its
+// amount in whole shillings, which is all M-Pesa takes
+func (p *MpesaProvider) walletAmount(transaction models.Transaction) (string, int64, error) {
+	if transaction.PhoneE164 == "" {
+		return "", 0, errors.New("m-pesa transactions need the customer's phone_number")
+	}
+	if transaction.Currency != "KES" {
+		return "", 0, fmt.Errorf("m-pesa takes KES only, not %s", transaction.Currency)
+	}
+	if transaction.Amount != math.Trunc(transaction.Amount) {
+		return "", 0, fmt.Errorf("m-pesa takes whole shillings only, not %.2f", transaction.Amount)
+	}
+	return strings.TrimPrefix(transaction.PhoneE164, "+"), int64(transaction.Amount), nil
+}
+
+// mpesaAccountReference returns the account reference customers see in the STK Push prompt, which
+// M-Pesa limits to 12 characters
+func mpesaAccountReference(transaction models.Transaction) string {
+	reference := transaction.ReferenceID
+	if reference == "" {
+		reference = strconv.Itoa(transaction.ID)
+	}
+	if len(reference) > 12 {
+		reference = reference[len(reference)-12:]
+	}
+	return reference
+}
+
+// post sends a JSON request to the transaction's environment with an access token and decodes
+// the response
+func (p *MpesaProvider) post(ctx context.Context, transaction models.Transaction, path string, request, out interface{}) error {
+	env, err := p.environments.Select(transaction.Livemode)
+	if err != nil {
+		return err
+	}
+	token, err := p.accessToken(ctx, env)
+	if err != nil {
+		return err
+	}
+
+	payload, err := json.Marshal(request)
+	if err != nil {
+		return fmt.Errorf("failed to encode M-Pesa request: %w", err)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, strings.TrimSuffix(env.BaseURL, "/")+path, bytes.NewReader(payload))
+	if err != nil {
+		return fmt.Errorf("failed to build M-Pesa request: %w", err)
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("Content-Type", "application/json")
+
+	body, status, err := p.do(req)
+	if err != nil {
+		return err
+	}
+	if status >= http.StatusBadRequest {
+		var failure struct {
+			ErrorCode    string `json:"errorCode"`
+			ErrorMessage string `json:"errorMessage"`
+		}
+		if err := json.Unmarshal(body, &failure); err != nil || failure.ErrorCode == "" {
+			return fmt.Errorf("m-pesa returned status %d", status)
+		}
+		return fmt.Errorf("m-pesa returned status %d: %s: %s", status, failure.ErrorCode, failure.ErrorMessage)
+	}
+
+	if err := json.Unmarshal(body, out); err != nil {
+		return fmt.Errorf("invalid M-Pesa response: %w", err)
+	}
+	return nil
+}
+
+// accessToken returns a cached access token of an environment, requesting a new one with the
+// app's consumer key and secret when it is missing or about to expire
+func (p *MpesaProvider) accessToken(ctx context.Context, env Environment) (string, error) {
+	p.tokenMu.Lock()
+	defer p.tokenMu.Unlock()
+
+	if token, ok := p.tokens[env.Name]; ok && time.Now().Before(token.expiresAt) {
+		return token.value, nil
+	}
+
+	consumerKey, consumerSecret, ok := strings.Cut(env.APIKey, ":")
+	if !ok || consumerKey == "" || consumerSecret == "" {
+		return "", fmt.Errorf("m-pesa %s API key must be \"<consumer key>:<consumer secret>\"", env.Name)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, strings.TrimSuffix(env.BaseURL, "/")+"/oauth/v1/generate?grant_type=client_credentials", nil)
+	if err != nil {
+		return "", fmt.Errorf("failed to build M-Pesa token request: %w", err)
+	}
+	req.SetBasicAuth(consumerKey, consumerSecret)
+
+	body, status, err := p.do(req)
+	if err != nil {
+		return "", err
+	}
+	var result struct {
+		AccessToken string `json:"access_token"`
+		ExpiresIn   string `json:"expires_in"` // seconds, sent as a string
+	}
+	if status != http.StatusOK || json.Unmarshal(body, &result) != nil || result.AccessToken == "" {
+		return "", fmt.Errorf("m-pesa token request returned status %d", status)
+	}
+
+	expiresIn, err := strconv.Atoi(result.ExpiresIn)
+	if err != nil || expiresIn <= 0 {
+		expiresIn = 3599
+	}
+	p.tokens[env.Name] = mpesaToken{
+		value:     result.AccessToken,
+		expiresAt: time.Now().Add(time.Duration(expiresIn)*time.Second - mpesaTokenMargin),
+	}
+	return result.AccessToken, nil
+}
+
+// do sends a request, recording whether Safaricom was reachable, and returns the response body
+// and status
+func (p *MpesaProvider) do(req *http.Request) ([]byte, int, error) {
+	resp, err := p.client.Do(req)
+	if err != nil {
+		p.available.Store(false)
+		return nil, 0, fmt.Errorf("m-pesa request failed: %w", err)
+	}
+	defer resp.Body.Close()
+	p.available.Store(resp.StatusCode < http.StatusInternalServerError)
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to read M-Pesa response: %w", err)
+	}
+	return body, resp.StatusCode, nil
+}
+
+// mpesaSTKPushRequest is a Lipa Na M-Pesa Online payment request
+type mpesaSTKPushRequest struct {
+	BusinessShortCode string `json:"BusinessShortCode"`
+	Password          string `json:"Password"`
+	Timestamp         string `json:"Timestamp"`
+	TransactionType   string `json:"TransactionType"`
+	Amount            int64  `json:"Amount"`
+	PartyA            string `json:"PartyA"`
+	PartyB            string `json:"PartyB"`
+	PhoneNumber       string `json:"PhoneNumber"`
+	CallBackURL       string `json:"CallBackURL"`
+	AccountReference  string `json:"AccountReference"`
+	TransactionDesc   string `json:"TransactionDesc"`
+}
+
+// mpesaB2CRequest is a business to customer payment request
+type mpesaB2CRequest struct {
+	OriginatorConversationID string `json:"OriginatorConversationID"`
+	InitiatorName            string `json:"InitiatorName"`
+	SecurityCredential       string `json:"SecurityCredential"`
+	CommandID                string `json:"CommandID"`
+	Amount                   int64  `json:"Amount"`
+	PartyA                   string `json:"PartyA"`
+	PartyB                   string `json:"PartyB"`
+	Remarks                  string `json:"Remarks"`
+	QueueTimeOutURL          string `json:"QueueTimeOutURL"`
+	ResultURL                string `json:"ResultURL"`
+	Occasion                 string `json:"Occasion,omitempty"`
+}
+
+// mpesaSTKCallback is the result of an STK Push payment
+type mpesaSTKCallback struct {
+	MerchantRequestID string        `json:"MerchantRequestID"`
+	CheckoutRequestID string        `json:"CheckoutRequestID"`
+	ResultCode        int           `json:"ResultCode"`
+	ResultDesc        string        `json:"ResultDesc"`
+	CallbackMetadata  mpesaMetadata `json:"CallbackMetadata"`
+}
+
+// mpesaB2CResult is the result of a B2C payment, or its queue timeout
+type mpesaB2CResult struct {
+	ResultType               int    `json:"ResultType"`
+	ResultCode               int    `json:"ResultCode"`
+	ResultDesc               string `json:"ResultDesc"`
+	OriginatorConversationID string `json:"OriginatorConversationID"`
+	ConversationID           string `json:"ConversationID"`
+	TransactionID            string `json:"TransactionID"` // the M-Pesa receipt number
+}
+
+// mpesaMetadata lists the details of a completed STK Push payment, such as its receipt number
+type mpesaMetadata struct {
+	Item []struct {
+		Name  string      `json:"Name"`
+		Value interface{} `json:"Value"`
+	} `json:"Item"`
+}
+
+// value returns a metadata item as a string
+func (m mpesaMetadata) value(name string) (string, bool) {
+	for _, item := range m.Item {
+		if item.Name == name && item.Value != nil {
+			return fmt.Sprint(item.Value), true
+		}
+	}
+	return "", false
+}
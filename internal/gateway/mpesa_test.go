@@ -0,0 +1,169 @@
+package gateway
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"payment-gateway/internal/consts"
+	"payment-gateway/internal/models"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+// fakeMpesa answers token requests and API requests with the given body, recording each API
+// request's path and decoded body. It returns the number of token requests made.
+func fakeMpesa(t *testing.T, body string) (*MpesaProvider, <-chan map[string]interface{}, *atomic.Int32) {
+	requests := make(chan map[string]interface{}, 10)
+	var tokenRequests atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if r.URL.Path == "/oauth/v1/generate" {
+			tokenRequests.Add(1)
+			if key, secret, ok := r.BasicAuth(); !ok || key != "consumer" || secret != "secret" {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			fmt.Fprint(w, `{"access_token":"token-1","expires_in":"3599"}`)
+			return
+		}
+
+		var request map[string]interface{}
+		json.NewDecoder(r.Body).Decode(&request)
+		request["_path"] = r.URL.Path
+		request["_authorization"] = r.Header.Get("Authorization")
+		requests <- request
+		fmt.Fprint(w, body)
+	}))
+	t.Cleanup(server.Close)
+
+	provider := NewMpesaProvider(6, "M-Pesa", MpesaConfig{
+		ShortCode:          "174379",
+		PassKey:            "passkey",
+		InitiatorName:      "testapi",
+		SecurityCredential: "credential",
+		CallbackURL:        "https://gateway.example.com/callback/6",
+	})
+	provider.SetEnvironments(Environments{Sandbox: Environment{Name: EnvironmentSandbox, BaseURL: server.URL, APIKey: "consumer:secret"}})
+	return provider, requests, &tokenRequests
+}
+
+func TestMpesaDeposit(t *testing.T) {
+	provider, requests, tokenRequests := fakeMpesa(t, `{"MerchantRequestID":"29115","CheckoutRequestID":"ws_CO_191220191020363925","ResponseCode":"0","ResponseDescription":"Success. Request accepted for processing"}`)
+
+	transaction := models.Transaction{ID: 42, Amount: 100, Currency: "KES", PhoneE164: "+254712345678", ReferenceID: "PG01M55R6AZRGJRE185R7HD5ARBY"}
+	for i := 0; i < 2; i++ {
+		response, err := provider.ProcessDeposit(context.Background(), transaction)
+		if err != nil {
+			t.Fatalf("ProcessDeposit failed: %v", err)
+		}
+		if response.Status != consts.Processing || response.GatewayReference != "ws_CO_191220191020363925" {
+			t.Errorf("Unexpected response: %+v", response)
+		}
+	}
+	if tokenRequests.Load() != 1 {
+		t.Errorf("Expected the access token reused, got %d token requests", tokenRequests.Load())
+	}
+
+	req := <-requests
+	if req["_path"] != "/mpesa/stkpush/v1/processrequest" || req["_authorization"] != "Bearer token-1" {
+		t.Errorf("Unexpected request: %v", req)
+	}
+	if req["Amount"] != 100.0 || req["PartyA"] != "254712345678" || req["PhoneNumber"] != "254712345678" || req["PartyB"] != "174379" ||
+		req["AccountReference"] != "185R7HD5ARBY" || req["TransactionType"] != "CustomerPayBillOnline" {
+		t.Errorf("Unexpected STK Push request: %v", req)
+	}
+	password, _ := base64.StdEncoding.DecodeString(fmt.Sprint(req["Password"]))
+	if string(password) != "174379passkey"+fmt.Sprint(req["Timestamp"]) {
+		t.Errorf("Unexpected STK Push password %q", password)
+	}
+}
+
+func TestMpesaDepositInvalid(t *testing.T) {
+	provider, _, _ := fakeMpesa(t, `{}`)
+
+	invalid := map[string]models.Transaction{
+		"no phone":   {ID: 1, Amount: 100, Currency: "KES"},
+		"currency":   {ID: 1, Amount: 100, Currency: "USD", PhoneE164: "+254712345678"},
+		"fractional": {ID: 1, Amount: 100.5, Currency: "KES", PhoneE164: "+254712345678"},
+	}
+	for name, transaction := range invalid {
+		if _, err := provider.ProcessDeposit(context.Background(), transaction); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
+
+func TestMpesaWithdrawal(t *testing.T) {
+	provider, requests, _ := fakeMpesa(t, `{"ConversationID":"AG_20191219_00005797af5d7d75f652","OriginatorConversationID":"idem-7","ResponseCode":"0","ResponseDescription":"Accept the service request successfully."}`)
+
+	response, err := provider.ProcessWithdrawal(context.Background(), models.Transaction{ID: 7, Amount: 500, Currency: "KES", PhoneE164: "+254712345678", GatewayIdempotencyKey: "idem-7"})
+	if err != nil {
+		t.Fatalf("ProcessWithdrawal failed: %v", err)
+	}
+	if response.Status != consts.Processing || response.GatewayReference != "AG_20191219_00005797af5d7d75f652" {
+		t.Errorf("Unexpected response: %+v", response)
+	}
+
+	req := <-requests
+	if req["_path"] != "/mpesa/b2c/v3/paymentrequest" || req["OriginatorConversationID"] != "idem-7" || req["PartyA"] != "174379" ||
+		req["PartyB"] != "254712345678" || req["Amount"] != 500.0 || req["CommandID"] != "BusinessPayment" ||
+		req["ResultURL"] != "https://gateway.example.com/callback/6" {
+		t.Errorf("Unexpected B2C request: %v", req)
+	}
+}
+
+func TestMpesaParseCallback(t *testing.T) {
+	provider := NewMpesaProvider(6, "M-Pesa", MpesaConfig{})
+
+	tests := []struct {
+		name      string
+		body      string
+		reference string
+		status    string
+		decline   string
+		message   string
+	}{
+		{
+			"stk completed",
+			`{"Body":{"stkCallback":{"MerchantRequestID":"29115","CheckoutRequestID":"ws_CO_1","ResultCode":0,"ResultDesc":"The service request is processed successfully.",
+				"CallbackMetadata":{"Item":[{"Name":"Amount","Value":100},{"Name":"MpesaReceiptNumber","Value":"NLJ7RT61SV"},{"Name":"PhoneNumber","Value":254712345678}]}}}}`,
+			"ws_CO_1", consts.Completed, "", "M-Pesa receipt NLJ7RT61SV",
+		},
+		{
+			"stk cancelled",
+			`{"Body":{"stkCallback":{"MerchantRequestID":"29115","CheckoutRequestID":"ws_CO_2","ResultCode":1032,"ResultDesc":"Request cancelled by user"}}}`,
+			"ws_CO_2", consts.Failed, consts.DeclineConsentRejected, "Request cancelled by user",
+		},
+		{
+			"b2c completed",
+			`{"Result":{"ResultType":0,"ResultCode":0,"ResultDesc":"The service request is processed successfully.","OriginatorConversationID":"idem-7","ConversationID":"AG_1","TransactionID":"NLJ41HAY6Q"}}`,
+			"AG_1", consts.Completed, "", "M-Pesa receipt NLJ41HAY6Q",
+		},
+		{
+			"b2c unregistered",
+			`{"Result":{"ResultType":0,"ResultCode":2040,"ResultDesc":"Credit Party customer type (Unregistered or Registered Customer) can't be supported by the service.","ConversationID":"AG_2"}}`,
+			"AG_2", consts.Failed, consts.DeclineInvalidAccount, "Credit Party customer type (Unregistered or Registered Customer) can't be supported by the service.",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data, err := provider.ParseCallback(httptest.NewRequest(http.MethodPost, "/callback/6", strings.NewReader(tt.body)))
+			if err != nil {
+				t.Fatalf("ParseCallback failed: %v", err)
+			}
+			if data.TransactionID != 0 || data.GatewayReference != tt.reference || data.Status != tt.status ||
+				data.DeclineCode != tt.decline || data.Message != tt.message || data.GatewayID != "6" {
+				t.Errorf("Unexpected callback data: %+v", data)
+			}
+		})
+	}
+
+	if _, err := provider.ParseCallback(httptest.NewRequest(http.MethodPost, "/callback/6", strings.NewReader(`{"transaction_id":1}`))); err == nil {
+		t.Error("Expected callbacks of other formats to be refused")
+	}
+}
@@ -0,0 +1,80 @@
+package gateway
+
+import (
+	"context"
+	"payment-gateway/internal/models"
+	"time"
+)
+
+// Consent is a payment consent created at the customer's bank, waiting for the customer to
+// authorise it
+type Consent struct {
+	ExternalID       string // the consent's ID at the gateway
+	AuthorisationURL string // where the customer is sent to authorise the payment at their bank
+	ExpiresAt        time.Time
+}
+
+// PaymentInitiationProvider is implemented by open banking providers that initiate deposits from
+// the customer's bank account (PIS). Instead of ProcessDeposit, a deposit creates a consent the
+// customer authorises at their bank; the bank redirects them back with an authorisation code and
+// the payment is executed with it. Such providers are only selected for deposits paying from a bank.
+type PaymentInitiationProvider interface {
+	Provider
+
+	// Banks returns the banks customers can pay from in a country, or in every country for ""
+	Banks(ctx context.Context, countryCode string) ([]models.Bank, error)
+
+	// CreateConsent creates a consent to a deposit from transaction.BankID. The bank sends the
+	// customer to redirectURI with state and an authorisation code or error.
+	CreateConsent(ctx context.Context, transaction models.Transaction, redirectURI, state string) (*Consent, error)
+
+	// ExecutePayment executes an authorised consent's payment. Declines are returned as a DeclineError.
+	ExecutePayment(ctx context.Context, transaction models.Transaction, consent models.PaymentConsent, authorisationCode string) (*models.TransactionResponse, error)
+}
+
+// SupportsPaymentInitiation reports whether a provider initiates payments from bank accounts
+func SupportsPaymentInitiation(provider Provider) bool {
+	_, ok := provider.(PaymentInitiationProvider)
+	return ok
+}
+
+// AccountLink is a request for the customer to let the gateway read their bank accounts, waiting
+// for them to authorise it at their bank
+type AccountLink struct {
+	AuthorisationURL string // where the customer authorises the gateway to read their accounts
+	ExpiresAt        time.Time
+}
+
+// AccountAccess is what a customer's authorisation of an account link grants: tokens reading
+// their accounts, and the accounts with the name their bank holds them under
+type AccountAccess struct {
+	AccessToken    string
+	RefreshToken   string
+	TokenExpiresAt time.Time
+	Accounts       []models.BankAccount
+}
+
+// BankPayoutProvider is implemented by open banking providers that pay withdrawals out to bank
+// accounts customers linked by letting the gateway read them (AIS), so payouts only go to accounts
+// the customer verifiably holds. Instead of ProcessWithdrawal, a withdrawal is paid out to a linked
+// account with PayOut. Such providers are only selected for withdrawals to a linked account.
+type BankPayoutProvider interface {
+	Provider
+
+	// CreateAccountLink creates a link the customer authorises at their bank. The bank sends the
+	// customer to redirectURI with state and an authorisation code or error.
+	CreateAccountLink(ctx context.Context, livemode bool, redirectURI, state string) (*AccountLink, error)
+
+	// LinkAccounts exchanges an account link's authorisation code for access to the customer's
+	// accounts and reads them
+	LinkAccounts(ctx context.Context, livemode bool, authorisationCode, redirectURI string) (*AccountAccess, error)
+
+	// PayOut pays a withdrawal out to a linked account. Declines are returned as a DeclineError.
+	PayOut(ctx context.Context, transaction models.Transaction, account models.BankAccount) (*models.TransactionResponse, error)
+}
+
+// SupportsBankPayouts reports whether a provider pays withdrawals out to linked bank accounts
+func SupportsBankPayouts(provider Provider) bool {
+	_, ok := provider.(BankPayoutProvider)
+	return ok
+}
@@ -0,0 +1,35 @@
+package gateway
+
+import "payment-gateway/internal/consts"
+
+// PaymentMethodProvider is implemented by providers that take payment methods other than cards,
+// such as mobile-money wallets. Providers that don't implement it take cards.
+type PaymentMethodProvider interface {
+	// PaymentMethods returns the consts.PaymentMethod* types the provider takes
+	PaymentMethods() []string
+}
+
+// CurrencyProvider is implemented by providers that only take some currencies. Providers that
+// don't implement it take any currency.
+type CurrencyProvider interface {
+	// SupportsCurrency reports whether the provider takes an ISO 4217 currency
+	SupportsCurrency(currency string) bool
+}
+
+// PaymentMethods returns the payment method types a provider takes. Payment initiation providers
+// take bank payments only.
+func PaymentMethods(provider Provider) []string {
+	if SupportsPaymentInitiation(provider) {
+		return []string{consts.PaymentMethodBank}
+	}
+	if methods, ok := provider.(PaymentMethodProvider); ok {
+		return methods.PaymentMethods()
+	}
+	return []string{consts.PaymentMethodCard}
+}
+
+// SupportsCurrency reports whether a provider takes a currency
+func SupportsCurrency(provider Provider, currency string) bool {
+	currencies, ok := provider.(CurrencyProvider)
+	return !ok || currencies.SupportsCurrency(currency)
+}
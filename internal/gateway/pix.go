@@ -0,0 +1,488 @@
+package gateway
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"payment-gateway/internal/consts"
+	"payment-gateway/internal/httpclient"
+	"payment-gateway/internal/models"
+	"payment-gateway/internal/money"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+	"unicode"
+)
+
+// PIX API settings, following the Banco Central do Brasil's API Pix that PSPs expose
+const (
+	PixSignatureHeader = "X-Signature"
+	PixCurrency        = "BRL"
+
+	// pixGUI identifies PIX merchant account information in BR Code payloads
+	pixGUI = "br.gov.bcb.pix"
+
+	// pixTokenMargin is how long before its expiry an access token is renewed
+	pixTokenMargin = time.Minute
+
+	// BR Code limits on the merchant's name and city
+	pixMerchantNameLength = 25
+	pixMerchantCityLength = 15
+)
+
+// PixConfig configures the PIX provider: the merchant's PIX key charges are paid to and the name
+// and city shown to payers. The PSP's API URL and "<client ID>:<client secret>" credentials are
+// the provider's environments.
+type PixConfig struct {
+	Key          string // PIX key charges are paid to: CNPJ, email, phone number or random key
+	MerchantName string
+	MerchantCity string
+
+	// Expiry is how long a charge can be paid for; an hour when zero
+	Expiry time.Duration
+
+	// WebhookSecrets are the shared secrets the PSP signs its payment notifications with.
+	// Notifications signed with any of them are accepted, so secrets can be rotated.
+	WebhookSecrets []string
+
+	Timeout time.Duration // per attempt; 30 seconds when zero
+}
+
+// PixProvider is a gateway adapter for a PSP's API Pix, Brazil's instant payment system. Deposits
+// create an immediate charge (cob) the customer pays from their bank's app before it expires, by
+// scanning a QR code or pasting its BR Code payload ("Pix Copia e Cola"); the payload is returned
+// in the response's pix field. The PSP notifies each payment received, which completes the
+// deposit within seconds. Charges nobody paid are not notified, so deposits still unpaid once
+// their charge expired are failed by the payment expiry scheduler. PIX payouts are not part of
+// the API Pix, so withdrawals are refused.
+type PixProvider struct {
+	id           string
+	name         string
+	config       PixConfig
+	client       *httpclient.Client
+	environments Environments
+	available    atomic.Bool
+	now          func() time.Time
+
+	tokenMu sync.Mutex
+	tokens  map[string]pixToken // access tokens by environment name
+}
+
+// pixToken is an OAuth access token and when it expires
+type pixToken struct {
+	value     string
+	expiresAt time.Time
+}
+
+// NewPixProvider creates a PIX provider. SetEnvironments must be called with the PSP's API URL
+// and credentials before it processes transactions.
+func NewPixProvider(id int, name string, config PixConfig) *PixProvider {
+	if config.Expiry <= 0 {
+		config.Expiry = consts.DefaultPixExpiry
+	}
+	config.MerchantName = pixText(config.MerchantName, pixMerchantNameLength)
+	config.MerchantCity = pixText(config.MerchantCity, pixMerchantCityLength)
+
+	clientConfig := httpclient.DefaultConfig(name)
+	if config.Timeout > 0 {
+		clientConfig.Timeout = config.Timeout
+	}
+
+	p := &PixProvider{
+		id:     strconv.Itoa(id),
+		name:   name,
+		config: config,
+		client: httpclient.New(clientConfig),
+		now:    time.Now,
+		tokens: make(map[string]pixToken),
+	}
+	p.available.Store(true)
+	return p
+}
+
+// SetEnvironments configures the sandbox and production environments of the PSP's API
+func (p *PixProvider) SetEnvironments(environments Environments) {
+	p.environments = environments
+
+	p.tokenMu.Lock()
+	p.tokens = make(map[string]pixToken)
+	p.tokenMu.Unlock()
+}
+
+// ID returns the unique identifier of the gateway
+func (p *PixProvider) ID() string {
+	return p.id
+}
+
+// Name returns the name of the gateway
+func (p *PixProvider) Name() string {
+	return p.name
+}
+
+// DataFormat returns the data format supported by the gateway
+func (p *PixProvider) DataFormat() string {
+	return "application/json"
+}
+
+// IsAvailable reports whether the last request reached the PSP
+func (p *PixProvider) IsAvailable() bool {
+	return p.available.Load()
+}
+
+// PaymentMethods returns the payment methods the provider takes: PIX instant payments
+func (p *PixProvider) PaymentMethods() []string {
+	return []string{consts.PaymentMethodPix}
+}
+
+// SupportsCurrency reports whether the provider takes a currency; PIX payments are in reais
+func (p *PixProvider) SupportsCurrency(currency string) bool {
+	return currency == PixCurrency
+}
+
+// PaymentExpiry returns how long a charge can be paid for
+func (p *PixProvider) PaymentExpiry() time.Duration {
+	return p.config.Expiry
+}
+
+// ProcessDeposit creates an immediate charge for the transaction's amount and returns the BR Code
+// payload the customer pays it with. Our reference is the charge's txid and the gateway
+// reference; the payment is reported by notification.
+func (p *PixProvider) ProcessDeposit(ctx context.Context, transaction models.Transaction) (*models.TransactionResponse, error) {
+	txid := transaction.ReferenceID
+	if !validPixTxID(txid) {
+		return nil, fmt.Errorf("%s: reference %q is not a valid PIX txid", p.name, txid)
+	}
+
+	request := pixChargeRequest{
+		Key:          p.config.Key,
+		PayerRequest: "Deposit " + strconv.Itoa(transaction.ID),
+	}
+	request.Calendar.Expiry = int(p.config.Expiry / time.Second)
+	request.Value.Original = strconv.FormatFloat(transaction.Amount, 'f', money.Exponent(PixCurrency), 64)
+
+	var charge pixCharge
+	if err := p.send(ctx, transaction, http.MethodPut, "/v2/cob/"+txid, request, &charge); err != nil {
+		return nil, err
+	}
+	if charge.Location == "" && charge.CopyPaste == "" {
+		return nil, errors.New("pix PSP returned a charge without a location or payload")
+	}
+
+	payload := charge.CopyPaste
+	if payload == "" {
+		payload = PixPayload(charge.Location, p.config.MerchantName, p.config.MerchantCity)
+	}
+	createdAt, err := time.Parse(time.RFC3339, charge.Calendar.Created)
+	if err != nil {
+		createdAt = p.now()
+	}
+	expiry := p.config.Expiry
+	if charge.Calendar.Expiry > 0 {
+		expiry = time.Duration(charge.Calendar.Expiry) * time.Second
+	}
+
+	return &models.TransactionResponse{
+		TransactionID:    transaction.ID,
+		Status:           consts.Processing,
+		GatewayReference: txid,
+		Pix: &models.PixPayment{
+			Payload:   payload,
+			ExpiresAt: createdAt.Add(expiry).UTC(),
+		},
+	}, nil
+}
+
+// ProcessWithdrawal refuses withdrawals, as the API Pix only receives payments
+func (p *PixProvider) ProcessWithdrawal(ctx context.Context, transaction models.Transaction) (*models.TransactionResponse, error) {
+	return nil, fmt.Errorf("%s: withdrawals are not supported", p.name)
+}
+
+// ProcessRefund refuses refunds. Pix refunds (devoluções) need the payment's end-to-end ID, which
+// isn't kept.
+func (p *PixProvider) ProcessRefund(ctx context.Context, refund models.Refund, transaction models.Transaction) (*models.TransactionResponse, error) {
+	return nil, fmt.Errorf("%s: %w", p.name, ErrRefundUnsupported)
+}
+
+// ParseCallback verifies the signature of a payment notification and maps it to the deposit whose
+// charge was paid, by its txid. A notification only reports payments received, so it completes
+// the deposit. PSPs may batch payments in one notification; they must be configured to send one
+// payment per notification, as each callback settles a single transaction.
+func (p *PixProvider) ParseCallback(r *http.Request) (*models.CallbackData, error) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read callback: %w", err)
+	}
+	if err := p.verifySignature(body, r.Header.Get(PixSignatureHeader)); err != nil {
+		return nil, err
+	}
+
+	var notification struct {
+		Pix []struct {
+			EndToEndID string `json:"endToEndId"`
+			TxID       string `json:"txid"`
+			Value      string `json:"valor"`
+			Time       string `json:"horario"`
+		} `json:"pix"`
+	}
+	if err := json.Unmarshal(body, &notification); err != nil {
+		return nil, fmt.Errorf("invalid PIX notification: %w", err)
+	}
+	if len(notification.Pix) != 1 {
+		return nil, fmt.Errorf("PIX notification reports %d payments, expected one", len(notification.Pix))
+	}
+	payment := notification.Pix[0]
+	if payment.TxID == "" {
+		// Payments to the key without a charge, such as static QR codes, belong to no deposit
+		return nil, fmt.Errorf("PIX payment %s has no txid", payment.EndToEndID)
+	}
+
+	return &models.CallbackData{
+		GatewayReference: payment.TxID,
+		GatewayID:        p.id,
+		Status:           consts.Completed,
+		Message:          fmt.Sprintf("paid %s BRL (%s)", payment.Value, payment.EndToEndID),
+		Timestamp:        payment.Time,
+	}, nil
+}
+
+// send sends a JSON request to the transaction's environment with an access token and decodes the
+// response
+func (p *PixProvider) send(ctx context.Context, transaction models.Transaction, method, path string, request, out interface{}) error {
+	env, err := p.environments.Select(transaction.Livemode)
+	if err != nil {
+		return err
+	}
+	token, err := p.accessToken(ctx, env)
+	if err != nil {
+		return err
+	}
+
+	payload, err := json.Marshal(request)
+	if err != nil {
+		return fmt.Errorf("failed to encode PIX request: %w", err)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, strings.TrimSuffix(env.BaseURL, "/")+path, bytes.NewReader(payload))
+	if err != nil {
+		return fmt.Errorf("failed to build PIX request: %w", err)
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("Content-Type", "application/json")
+
+	body, status, err := p.do(req)
+	if err != nil {
+		return err
+	}
+	if status >= http.StatusBadRequest {
+		// Errors are RFC 7807 problem details
+		var problem struct {
+			Title  string `json:"title"`
+			Detail string `json:"detail"`
+		}
+		if err := json.Unmarshal(body, &problem); err != nil || problem.Title == "" {
+			return fmt.Errorf("pix PSP returned status %d", status)
+		}
+		return fmt.Errorf("pix PSP returned status %d: %s: %s", status, problem.Title, problem.Detail)
+	}
+
+	if err := json.Unmarshal(body, out); err != nil {
+		return fmt.Errorf("invalid PIX response: %w", err)
+	}
+	return nil
+}
+
+// accessToken returns a cached access token of an environment, requesting a new one with the
+// client credentials when it is missing or about to expire
+func (p *PixProvider) accessToken(ctx context.Context, env Environment) (string, error) {
+	p.tokenMu.Lock()
+	defer p.tokenMu.Unlock()
+
+	if token, ok := p.tokens[env.Name]; ok && p.now().Before(token.expiresAt) {
+		return token.value, nil
+	}
+
+	clientID, clientSecret, ok := strings.Cut(env.APIKey, ":")
+	if !ok || clientID == "" || clientSecret == "" {
+		return "", fmt.Errorf("pix %s API key must be \"<client ID>:<client secret>\"", env.Name)
+	}
+
+	form := url.Values{"grant_type": {"client_credentials"}}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, strings.TrimSuffix(env.BaseURL, "/")+"/oauth/token", strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", fmt.Errorf("failed to build PIX token request: %w", err)
+	}
+	req.SetBasicAuth(clientID, clientSecret)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	body, status, err := p.do(req)
+	if err != nil {
+		return "", err
+	}
+	var result struct {
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int    `json:"expires_in"`
+	}
+	if status != http.StatusOK || json.Unmarshal(body, &result) != nil || result.AccessToken == "" {
+		return "", fmt.Errorf("pix token request returned status %d", status)
+	}
+
+	expiresIn := result.ExpiresIn
+	if expiresIn <= 0 {
+		expiresIn = 3600
+	}
+	p.tokens[env.Name] = pixToken{
+		value:     result.AccessToken,
+		expiresAt: p.now().Add(time.Duration(expiresIn)*time.Second - pixTokenMargin),
+	}
+	return result.AccessToken, nil
+}
+
+// do sends a request, recording whether the PSP was reachable, and returns the response body and
+// status
+func (p *PixProvider) do(req *http.Request) ([]byte, int, error) {
+	resp, err := p.client.Do(req)
+	if err != nil {
+		p.available.Store(false)
+		return nil, 0, fmt.Errorf("pix request failed: %w", err)
+	}
+	defer resp.Body.Close()
+	p.available.Store(resp.StatusCode < http.StatusInternalServerError)
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to read PIX response: %w", err)
+	}
+	return body, resp.StatusCode, nil
+}
+
+// verifySignature checks the X-Signature header: the hex HMAC-SHA256 of the raw body under one of
+// the webhook secrets
+func (p *PixProvider) verifySignature(body []byte, header string) error {
+	if len(p.config.WebhookSecrets) == 0 {
+		return fmt.Errorf("%w: no PIX webhook secret is configured", ErrInvalidCallbackSignature)
+	}
+	signature, err := hex.DecodeString(header)
+	if err != nil || len(signature) == 0 {
+		return fmt.Errorf("%w: missing or malformed %s header", ErrInvalidCallbackSignature, PixSignatureHeader)
+	}
+
+	for _, secret := range p.config.WebhookSecrets {
+		mac := hmac.New(sha256.New, []byte(secret))
+		mac.Write(body)
+		if hmac.Equal(mac.Sum(nil), signature) {
+			return nil
+		}
+	}
+	return ErrInvalidCallbackSignature
+}
+
+// PixPayload returns the BR Code payload of a dynamic charge: the EMV merchant-presented QR code
+// content pointing at the charge's location, which payers' banks fetch the amount and txid from.
+// It is shown as a QR code or pasted into the payer's bank app.
+func PixPayload(location, merchantName, merchantCity string) string {
+	location = strings.TrimPrefix(strings.TrimPrefix(location, "https://"), "http://")
+
+	var b strings.Builder
+	b.WriteString(emvField("00", "01")) // payload format indicator
+	b.WriteString(emvField("01", "12")) // point of initiation: dynamic, paid once
+	b.WriteString(emvField("26", emvField("00", pixGUI)+emvField("25", location)))
+	b.WriteString(emvField("52", "0000")) // merchant category code
+	b.WriteString(emvField("53", "986"))  // ISO 4217 numeric code of BRL
+	b.WriteString(emvField("58", "BR"))
+	b.WriteString(emvField("59", merchantName))
+	b.WriteString(emvField("60", merchantCity))
+	b.WriteString(emvField("62", emvField("05", "***"))) // the txid is in the location's payload
+	b.WriteString("6304")                                // CRC, over everything up to and including its own ID and length
+	return b.String() + fmt.Sprintf("%04X", crc16CCITT([]byte(b.String())))
+}
+
+// emvField encodes an EMV QR code field: its ID, two-digit length and value
+func emvField(id, value string) string {
+	return fmt.Sprintf("%s%02d%s", id, len(value), value)
+}
+
+// crc16CCITT returns the CRC-16/CCITT-FALSE checksum (polynomial 0x1021, initial value 0xFFFF)
+// BR Codes end with
+func crc16CCITT(data []byte) uint16 {
+	crc := uint16(0xFFFF)
+	for _, b := range data {
+		crc ^= uint16(b) << 8
+		for i := 0; i < 8; i++ {
+			if crc&0x8000 != 0 {
+				crc = crc<<1 ^ 0x1021
+			} else {
+				crc <<= 1
+			}
+		}
+	}
+	return crc
+}
+
+// validPixTxID reports whether a reference can be a charge's txid: 26 to 35 letters and digits
+func validPixTxID(txid string) bool {
+	if len(txid) < 26 || len(txid) > 35 {
+		return false
+	}
+	for _, c := range txid {
+		if c > unicode.MaxASCII || !(unicode.IsLetter(c) || unicode.IsDigit(c)) {
+			return false
+		}
+	}
+	return true
+}
+
+// pixAccents replaces the accented capitals of Portuguese with their base letters
+var pixAccents = strings.NewReplacer(
+	"Á", "A", "À", "A", "Â", "A", "Ã", "A", "É", "E", "Ê", "E", "Í", "I",
+	"Ó", "O", "Ô", "O", "Õ", "O", "Ú", "U", "Ü", "U", "Ç", "C",
+)
+
+// pixText upper-cases text and keeps the ASCII letters, digits and spaces BR Codes allow, cut to
+// max characters. Accents are dropped, so "São Paulo" becomes "SAO PAULO", and runs of spaces
+// collapsed.
+func pixText(text string, max int) string {
+	var b strings.Builder
+	for _, c := range pixAccents.Replace(strings.ToUpper(text)) {
+		if c <= unicode.MaxASCII && (unicode.IsLetter(c) || unicode.IsDigit(c) || c == ' ') {
+			b.WriteRune(c)
+		}
+	}
+	text = strings.Join(strings.Fields(b.String()), " ")
+	if len(text) > max {
+		text = strings.TrimSpace(text[:max])
+	}
+	return text
+}
+
+// pixChargeRequest creates an immediate charge
+type pixChargeRequest struct {
+	Calendar struct {
+		Expiry int `json:"expiracao"` // seconds
+	} `json:"calendario"`
+	Value struct {
+		Original string `json:"original"`
+	} `json:"valor"`
+	Key          string `json:"chave"`
+	PayerRequest string `json:"solicitacaoPagador,omitempty"`
+}
+
+// pixCharge holds the fields read from created charges
+type pixCharge struct {
+	TxID     string `json:"txid"`
+	Status   string `json:"status"`
+	Location string `json:"location"`
+	Calendar struct {
+		Created string `json:"criacao"`
+		Expiry  int    `json:"expiracao"`
+	} `json:"calendario"`
+	CopyPaste string `json:"pixCopiaECola"`
+}
@@ -0,0 +1,172 @@
+package gateway
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"payment-gateway/internal/consts"
+	"payment-gateway/internal/models"
+	"strings"
+	"testing"
+	"time"
+)
+
+const pixTestWebhookSecret = "pix-secret"
+
+// fakePixPSP issues access tokens and answers charge requests with the given status and body,
+// recording each charge request with its body read
+func fakePixPSP(t *testing.T, status int, body string) (*PixProvider, <-chan *http.Request) {
+	requests := make(chan *http.Request, 10)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if r.URL.Path == "/oauth/token" {
+			if id, secret, ok := r.BasicAuth(); !ok || id != "client" || secret != "secret" {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			fmt.Fprint(w, `{"access_token":"pix-token","token_type":"Bearer","expires_in":3600}`)
+			return
+		}
+		requestBody, _ := io.ReadAll(r.Body)
+		r.Body = io.NopCloser(bytes.NewReader(requestBody))
+		requests <- r
+		w.WriteHeader(status)
+		fmt.Fprint(w, body)
+	}))
+	t.Cleanup(server.Close)
+
+	provider := NewPixProvider(10, "PIX", PixConfig{
+		Key:            "12345678000195",
+		MerchantName:   "Loja Exemplo",
+		MerchantCity:   "São Paulo",
+		Expiry:         30 * time.Minute,
+		WebhookSecrets: []string{"pix-old", pixTestWebhookSecret},
+	})
+	provider.SetEnvironments(Environments{Sandbox: Environment{Name: EnvironmentSandbox, BaseURL: server.URL, APIKey: "client:secret"}})
+	return provider, requests
+}
+
+// signPixNotification returns the X-Signature header of a payload
+func signPixNotification(secret string, payload []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(payload)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func TestPixPayload(t *testing.T) {
+	if crc := crc16CCITT([]byte("123456789")); crc != 0x29B1 {
+		t.Fatalf("Expected CRC-16/CCITT-FALSE check value 29B1, got %04X", crc)
+	}
+
+	payload := PixPayload("https://pix.example.com/qr/v2/9d36b84f", "LOJA EXEMPLO", "SAO PAULO")
+	want := "000201010212" +
+		"2652" + "0014br.gov.bcb.pix" + "2530pix.example.com/qr/v2/9d36b84f" +
+		"52040000" + "5303986" + "5802BR" + "5912LOJA EXEMPLO" + "6009SAO PAULO" + "62070503***" + "6304"
+	if !strings.HasPrefix(payload, want) || len(payload) != len(want)+4 {
+		t.Fatalf("Unexpected payload %q", payload)
+	}
+	if crc := fmt.Sprintf("%04X", crc16CCITT([]byte(want))); payload[len(want):] != crc {
+		t.Errorf("Expected CRC %s, got %s", crc, payload[len(want):])
+	}
+
+	if got := pixText("São Paulo - Capital do Estado", pixMerchantCityLength); got != "SAO PAULO CAPIT" {
+		t.Errorf("Expected the city upper-cased without accents and cut to 15 characters, got %q", got)
+	}
+}
+
+func TestPixDeposit(t *testing.T) {
+	provider, requests := fakePixPSP(t, http.StatusCreated, `{"txid":"PG01HV6Z3K6X9M2Q8R4T5W7Y0ABC","status":"ATIVA",
+		"calendario":{"criacao":"2024-05-01T10:00:00Z","expiracao":1800},"location":"pix.example.com/qr/v2/9d36b84f"}`)
+
+	response, err := provider.ProcessDeposit(context.Background(), models.Transaction{ID: 42, Amount: 99.5, Currency: "BRL", ReferenceID: "PG01HV6Z3K6X9M2Q8R4T5W7Y0ABC"})
+	if err != nil {
+		t.Fatalf("ProcessDeposit failed: %v", err)
+	}
+	if response.Status != consts.Processing || response.GatewayReference != "PG01HV6Z3K6X9M2Q8R4T5W7Y0ABC" || response.Pix == nil {
+		t.Fatalf("Unexpected response: %+v", response)
+	}
+	if want := PixPayload("pix.example.com/qr/v2/9d36b84f", "LOJA EXEMPLO", "SAO PAULO"); response.Pix.Payload != want {
+		t.Errorf("Expected payload %q, got %q", want, response.Pix.Payload)
+	}
+	if want := time.Date(2024, 5, 1, 10, 30, 0, 0, time.UTC); !response.Pix.ExpiresAt.Equal(want) {
+		t.Errorf("Expected the charge to expire at %v, got %v", want, response.Pix.ExpiresAt)
+	}
+
+	req := <-requests
+	if req.Method != http.MethodPut || req.URL.Path != "/v2/cob/PG01HV6Z3K6X9M2Q8R4T5W7Y0ABC" || req.Header.Get("Authorization") != "Bearer pix-token" {
+		t.Errorf("Unexpected request %s %s with headers %v", req.Method, req.URL.Path, req.Header)
+	}
+	var charge pixChargeRequest
+	if err := json.NewDecoder(req.Body).Decode(&charge); err != nil {
+		t.Fatalf("Invalid request body: %v", err)
+	}
+	if charge.Key != "12345678000195" || charge.Value.Original != "99.50" || charge.Calendar.Expiry != 1800 {
+		t.Errorf("Unexpected charge request: %+v", charge)
+	}
+
+	// A payload returned by the PSP is passed on as is
+	provider, _ = fakePixPSP(t, http.StatusCreated, `{"txid":"PG01HV6Z3K6X9M2Q8R4T5W7Y0ABC","location":"pix.example.com/qr/v2/9d36b84f","pixCopiaECola":"00020101021226...6304ABCD"}`)
+	response, err = provider.ProcessDeposit(context.Background(), models.Transaction{ID: 42, Amount: 10, Currency: "BRL", ReferenceID: "PG01HV6Z3K6X9M2Q8R4T5W7Y0ABC"})
+	if err != nil || response.Pix.Payload != "00020101021226...6304ABCD" {
+		t.Errorf("Expected the PSP's payload, got %+v (%v)", response, err)
+	}
+
+	if _, err := provider.ProcessDeposit(context.Background(), models.Transaction{ID: 43, Amount: 10, Currency: "BRL", ReferenceID: "PG-SHORT"}); err == nil {
+		t.Error("Expected an error for a reference that is not a valid txid")
+	}
+	if _, err := provider.ProcessWithdrawal(context.Background(), models.Transaction{ID: 44, Amount: 10, Currency: "BRL"}); err == nil {
+		t.Error("Expected withdrawals to be refused")
+	}
+	if provider.SupportsCurrency("USD") || !provider.SupportsCurrency("BRL") || provider.PaymentExpiry() != 30*time.Minute {
+		t.Error("Expected BRL only and a 30 minute payment window")
+	}
+}
+
+func TestPixDepositRejected(t *testing.T) {
+	provider, _ := fakePixPSP(t, http.StatusBadRequest, `{"type":"https://pix.bcb.gov.br/api/v2/error/CobOperacaoInvalida","title":"Cobrança inválida.","status":400,"detail":"A chave não pertence ao usuário recebedor."}`)
+
+	_, err := provider.ProcessDeposit(context.Background(), models.Transaction{ID: 42, Amount: 10, Currency: "BRL", ReferenceID: "PG01HV6Z3K6X9M2Q8R4T5W7Y0ABC"})
+	if err == nil || !strings.Contains(err.Error(), "A chave não pertence ao usuário recebedor.") {
+		t.Errorf("Expected the problem detail in the error, got: %v", err)
+	}
+}
+
+func TestPixCallback(t *testing.T) {
+	provider, _ := fakePixPSP(t, http.StatusOK, `{}`)
+	parse := func(payload []byte, secret string) (*models.CallbackData, error) {
+		req := httptest.NewRequest(http.MethodPost, "/callback/10", bytes.NewReader(payload))
+		req.Header.Set(PixSignatureHeader, signPixNotification(secret, payload))
+		return provider.ParseCallback(req)
+	}
+
+	payload := []byte(`{"pix":[{"endToEndId":"E12345678202405011000abcdefghijk","txid":"PG01HV6Z3K6X9M2Q8R4T5W7Y0ABC","valor":"99.50","horario":"2024-05-01T10:05:00.000Z"}]}`)
+	data, err := parse(payload, pixTestWebhookSecret)
+	if err != nil {
+		t.Fatalf("ParseCallback failed: %v", err)
+	}
+	if data.Status != consts.Completed || data.GatewayReference != "PG01HV6Z3K6X9M2Q8R4T5W7Y0ABC" || data.GatewayID != "10" ||
+		data.Timestamp != "2024-05-01T10:05:00.000Z" {
+		t.Errorf("Unexpected callback data: %+v", data)
+	}
+
+	if _, err := parse(payload, "pix-old"); err != nil {
+		t.Errorf("Expected notifications signed with the previous secret to be accepted, got: %v", err)
+	}
+	if _, err := parse(payload, "wrong"); !errors.Is(err, ErrInvalidCallbackSignature) {
+		t.Errorf("Expected ErrInvalidCallbackSignature, got: %v", err)
+	}
+	if _, err := parse([]byte(`{"pix":[{"endToEndId":"E1","valor":"5.00"}]}`), pixTestWebhookSecret); err == nil {
+		t.Error("Expected an error for a payment without a txid")
+	}
+	if _, err := parse([]byte(`{"pix":[{"txid":"A"},{"txid":"B"}]}`), pixTestWebhookSecret); err == nil {
+		t.Error("Expected an error for a batch of payments")
+	}
+}
@@ -0,0 +1,18 @@
+package gateway
+
+import (
+	"errors"
+	"payment-gateway/internal/models"
+)
+
+// ErrRefundUnsupported is returned by ProcessRefund when the gateway's API can't refund
+// payments, so refunds must be made outside the gateway
+var ErrRefundUnsupported = errors.New("gateway does not support refunds")
+
+// refundTransaction returns the deposit a refund is for with the refund's amount and idempotency
+// key, for providers that send refunds like their own transactions
+func refundTransaction(refund models.Refund, transaction models.Transaction) models.Transaction {
+	transaction.Amount = refund.Amount
+	transaction.GatewayIdempotencyKey = refund.GatewayIdempotencyKey
+	return transaction
+}
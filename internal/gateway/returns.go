@@ -0,0 +1,15 @@
+package gateway
+
+// ReturnProvider is implemented by providers whose settled payments the other party's bank can
+// still return, such as SEPA direct debits and credit transfers. Their callbacks may fail a
+// completed transaction, carrying the return's reason code.
+type ReturnProvider interface {
+	// ReportsReturns reports whether the gateway's callbacks report returns of settled payments
+	ReportsReturns() bool
+}
+
+// ReportsReturns reports whether a provider's callbacks may fail a completed transaction
+func ReportsReturns(provider Provider) bool {
+	returns, ok := provider.(ReturnProvider)
+	return ok && returns.ReportsReturns()
+}
@@ -0,0 +1,181 @@
+package gateway
+
+import (
+	"fmt"
+	"payment-gateway/internal/consts"
+	"payment-gateway/internal/geo"
+	"payment-gateway/internal/models"
+	"payment-gateway/internal/validation"
+	"strconv"
+	"strings"
+)
+
+// Transaction attributes a routing rule can test
+const (
+	RuleFieldType     = "type"
+	RuleFieldAmount   = "amount"
+	RuleFieldCurrency = "currency"
+	RuleFieldCountry  = "country"
+)
+
+// Routing rule operators; "in" takes a comma-separated list
+const (
+	RuleOpEq  = "eq"
+	RuleOpNe  = "ne"
+	RuleOpGt  = "gt"
+	RuleOpGte = "gte"
+	RuleOpLt  = "lt"
+	RuleOpLte = "lte"
+	RuleOpIn  = "in"
+)
+
+// What a matching routing rule does with its gateway
+const (
+	RuleActionPrefer  = "prefer"
+	RuleActionExclude = "exclude"
+)
+
+// RuleInput holds the transaction attributes merchant routing rules are evaluated against
+type RuleInput struct {
+	Type     string
+	Amount   float64
+	Currency string
+	Country  string // ISO 3166-1 alpha-2
+}
+
+// ValidateRule checks that a rule's operator suits its field and that its value parses
+func ValidateRule(rule models.RoutingRule) error {
+	switch rule.Field {
+	case RuleFieldAmount:
+		switch rule.Operator {
+		case RuleOpEq, RuleOpNe, RuleOpGt, RuleOpGte, RuleOpLt, RuleOpLte:
+		default:
+			return fmt.Errorf("operator %q cannot be used with amount", rule.Operator)
+		}
+		if amount, err := strconv.ParseFloat(rule.Value, 64); err != nil || amount < 0 {
+			return fmt.Errorf("value %q is not an amount", rule.Value)
+		}
+		return nil
+	case RuleFieldType, RuleFieldCurrency, RuleFieldCountry:
+		if rule.Operator != RuleOpEq && rule.Operator != RuleOpNe && rule.Operator != RuleOpIn {
+			return fmt.Errorf("operator %q cannot be used with %s", rule.Operator, rule.Field)
+		}
+	default:
+		return fmt.Errorf("unknown field %q", rule.Field)
+	}
+
+	values := []string{rule.Value}
+	if rule.Operator == RuleOpIn {
+		values = strings.Split(rule.Value, ",")
+	}
+	for _, value := range values {
+		value = strings.TrimSpace(value)
+		var ok bool
+		switch rule.Field {
+		case RuleFieldType:
+			ok = value == consts.Deposit || value == consts.Withdrawal
+		case RuleFieldCurrency:
+			ok = validation.IsCurrency(strings.ToUpper(value))
+		case RuleFieldCountry:
+			ok = geo.IsValidCountryCode(value)
+		}
+		if !ok {
+			return fmt.Errorf("value %q is not a valid %s", value, rule.Field)
+		}
+	}
+	return nil
+}
+
+// RuleMatches reports whether a rule's condition holds for a transaction. Rules are expected
+// to have been validated; ones that do not parse never match.
+func RuleMatches(rule models.RoutingRule, in RuleInput) bool {
+	if rule.Field == RuleFieldAmount {
+		value, err := strconv.ParseFloat(rule.Value, 64)
+		if err != nil {
+			return false
+		}
+		switch rule.Operator {
+		case RuleOpEq:
+			return in.Amount == value
+		case RuleOpNe:
+			return in.Amount != value
+		case RuleOpGt:
+			return in.Amount > value
+		case RuleOpGte:
+			return in.Amount >= value
+		case RuleOpLt:
+			return in.Amount < value
+		case RuleOpLte:
+			return in.Amount <= value
+		}
+		return false
+	}
+
+	var actual string
+	switch rule.Field {
+	case RuleFieldType:
+		actual = in.Type
+	case RuleFieldCurrency:
+		actual = in.Currency
+	case RuleFieldCountry:
+		actual = in.Country
+	default:
+		return false
+	}
+
+	switch rule.Operator {
+	case RuleOpEq:
+		return strings.EqualFold(actual, strings.TrimSpace(rule.Value))
+	case RuleOpNe:
+		return !strings.EqualFold(actual, strings.TrimSpace(rule.Value))
+	case RuleOpIn:
+		for _, value := range strings.Split(rule.Value, ",") {
+			if strings.EqualFold(actual, strings.TrimSpace(value)) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// ruleRouting is the effect of the matching merchant rules on a selection
+type ruleRouting struct {
+	matched   []models.RoutingRule // rules whose condition held, in order
+	excluded  map[string]int       // gateway ID to the position of the first rule excluding it
+	preferred string               // gateway preferred by the first matching rule whose gateway is not excluded
+	preferBy  int                  // position of that rule
+}
+
+// evaluateRules applies rules in order. Exclusions by the request or any rule take precedence
+// over preferences.
+func evaluateRules(rules []models.RoutingRule, in RuleInput, requestExcluded map[string]bool) ruleRouting {
+	routing := ruleRouting{excluded: make(map[string]int)}
+
+	var prefer []models.RoutingRule
+	for _, rule := range rules {
+		if !RuleMatches(rule, in) {
+			continue
+		}
+		routing.matched = append(routing.matched, rule)
+
+		id := strconv.Itoa(rule.GatewayID)
+		switch rule.Action {
+		case RuleActionExclude:
+			if _, ok := routing.excluded[id]; !ok {
+				routing.excluded[id] = rule.Position
+			}
+		case RuleActionPrefer:
+			prefer = append(prefer, rule)
+		}
+	}
+
+	for _, rule := range prefer {
+		id := strconv.Itoa(rule.GatewayID)
+		if _, ok := routing.excluded[id]; !ok && !requestExcluded[id] {
+			routing.preferred, routing.preferBy = id, rule.Position
+			break
+		}
+	}
+
+	return routing
+}
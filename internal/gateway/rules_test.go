@@ -0,0 +1,64 @@
+package gateway
+
+import (
+	"payment-gateway/internal/models"
+	"testing"
+)
+
+// TestValidateRule tests that operators must suit their field and values must parse
+func TestValidateRule(t *testing.T) {
+	tests := []struct {
+		name  string
+		rule  models.RoutingRule
+		valid bool
+	}{
+		{"currency equals", models.RoutingRule{Field: RuleFieldCurrency, Operator: RuleOpEq, Value: "GBP"}, true},
+		{"country in list", models.RoutingRule{Field: RuleFieldCountry, Operator: RuleOpIn, Value: "GB, de"}, true},
+		{"amount greater than", models.RoutingRule{Field: RuleFieldAmount, Operator: RuleOpGt, Value: "1000"}, true},
+		{"type not equal", models.RoutingRule{Field: RuleFieldType, Operator: RuleOpNe, Value: "withdrawal"}, true},
+		{"ordering on currency", models.RoutingRule{Field: RuleFieldCurrency, Operator: RuleOpGt, Value: "GBP"}, false},
+		{"in on amount", models.RoutingRule{Field: RuleFieldAmount, Operator: RuleOpIn, Value: "1,2"}, false},
+		{"unknown currency", models.RoutingRule{Field: RuleFieldCurrency, Operator: RuleOpEq, Value: "XYZ"}, false},
+		{"invalid country in list", models.RoutingRule{Field: RuleFieldCountry, Operator: RuleOpIn, Value: "GB,GBR"}, false},
+		{"non-numeric amount", models.RoutingRule{Field: RuleFieldAmount, Operator: RuleOpLt, Value: "lots"}, false},
+		{"unknown type", models.RoutingRule{Field: RuleFieldType, Operator: RuleOpEq, Value: "refund"}, false},
+		{"unknown field", models.RoutingRule{Field: "merchant", Operator: RuleOpEq, Value: "1"}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := ValidateRule(tt.rule)
+			if tt.valid && err != nil {
+				t.Errorf("Expected rule to be valid, got: %v", err)
+			}
+			if !tt.valid && err == nil {
+				t.Error("Expected rule to be invalid")
+			}
+		})
+	}
+}
+
+// TestRuleMatches tests rule conditions against transaction attributes
+func TestRuleMatches(t *testing.T) {
+	in := RuleInput{Type: "deposit", Amount: 1500, Currency: "GBP", Country: "GB"}
+
+	tests := []struct {
+		rule  models.RoutingRule
+		match bool
+	}{
+		{models.RoutingRule{Field: RuleFieldCurrency, Operator: RuleOpEq, Value: "gbp"}, true},
+		{models.RoutingRule{Field: RuleFieldCurrency, Operator: RuleOpNe, Value: "GBP"}, false},
+		{models.RoutingRule{Field: RuleFieldCountry, Operator: RuleOpIn, Value: "DE, GB"}, true},
+		{models.RoutingRule{Field: RuleFieldCountry, Operator: RuleOpIn, Value: "DE,FR"}, false},
+		{models.RoutingRule{Field: RuleFieldAmount, Operator: RuleOpGt, Value: "1000"}, true},
+		{models.RoutingRule{Field: RuleFieldAmount, Operator: RuleOpLte, Value: "1000"}, false},
+		{models.RoutingRule{Field: RuleFieldAmount, Operator: RuleOpEq, Value: "1500.00"}, true},
+		{models.RoutingRule{Field: RuleFieldType, Operator: RuleOpEq, Value: "withdrawal"}, false},
+	}
+
+	for _, tt := range tests {
+		if got := RuleMatches(tt.rule, in); got != tt.match {
+			t.Errorf("Expected %s %s %q to match=%v, got %v", tt.rule.Field, tt.rule.Operator, tt.rule.Value, tt.match, got)
+		}
+	}
+}
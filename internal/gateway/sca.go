@@ -0,0 +1,28 @@
+package gateway
+
+import "fmt"
+
+// SCAExemptionProvider is implemented by providers that can ask the issuer to exempt a card
+// deposit from strong customer authentication (SCA). The exemption to request is passed in
+// Transaction.SCAExemption; deposits without one are authenticated with a 3DS challenge.
+type SCAExemptionProvider interface {
+	// SupportsSCAExemption reports whether the provider can request the given exemption
+	SupportsSCAExemption(exemption string) bool
+}
+
+// SupportsSCAExemption reports whether a provider can request the given exemption
+func SupportsSCAExemption(provider Provider, exemption string) bool {
+	sca, ok := provider.(SCAExemptionProvider)
+	return ok && sca.SupportsSCAExemption(exemption)
+}
+
+// SCAExemptionRejectedError is returned by providers when the issuer rejects a requested SCA
+// exemption. Nothing is charged; the deposit may be submitted again with a 3DS challenge.
+type SCAExemptionRejectedError struct {
+	Exemption string
+	Message   string
+}
+
+func (e *SCAExemptionRejectedError) Error() string {
+	return fmt.Sprintf("SCA exemption %s rejected: %s", e.Exemption, e.Message)
+}
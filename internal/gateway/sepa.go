@@ -0,0 +1,766 @@
+package gateway
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"payment-gateway/internal/consts"
+	"payment-gateway/internal/httpclient"
+	"payment-gateway/internal/models"
+	"payment-gateway/internal/money"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+	"unicode/utf8"
+)
+
+// SEPA bank API settings
+const (
+	SEPASignatureHeader    = "X-Signature"
+	SEPACurrency           = "EUR"
+	sepaDirectDebitPath    = "/direct-debits"
+	sepaCreditTransferPath = "/credit-transfers"
+	sepaPain008Namespace   = "urn:iso:std:iso:20022:tech:xsd:pain.008.001.02"
+	sepaPain001Namespace   = "urn:iso:std:iso:20022:tech:xsd:pain.001.001.03"
+	sepaNameLength         = 70
+	sepaRemittanceLength   = 140
+	sepaIDLength           = 35
+)
+
+// SEPAConfig configures the SEPA provider: the merchant's creditor identity and the account debits
+// are collected into and credit transfers are paid from. The bank's API URL and key are the
+// provider's environments.
+type SEPAConfig struct {
+	CreditorID   string // SEPA creditor identifier, e.g. DE98ZZZ09999999999
+	CreditorName string
+	IBAN         string
+	BIC          string // optional; SEPA payments are routed by IBAN
+
+	// WebhookSecrets are the shared secrets the bank signs its status notifications with.
+	// Notifications signed with any of them are accepted, so secrets can be rotated.
+	WebhookSecrets []string
+
+	Timeout time.Duration // per attempt; 30 seconds when zero
+}
+
+// SEPAProvider is a gateway adapter for a bank's SEPA payment API, which takes ISO 20022 messages.
+// Deposits are collected by SEPA Direct Debit (pain.008) under a mandate the customer signed, and
+// withdrawals are paid out to the mandate's account by SEPA credit transfer (pain.001). The bank
+// reports each payment in an XML notification: a payment status report (pain.002) as it is
+// accepted, rejected or settled, and a debit/credit notification (camt.054) as it is booked or
+// returned. R-transactions - rejects before settlement, and returns and refunds after it - fail
+// the transaction with the SEPA reason code.
+type SEPAProvider struct {
+	id           string
+	name         string
+	config       SEPAConfig
+	client       *httpclient.Client
+	declineCodes DeclineCodeMap
+	environments Environments
+	available    atomic.Bool
+	now          func() time.Time
+}
+
+// NewSEPAProvider creates a SEPA provider. SetEnvironments must be called with the bank API's
+// URL and key before it processes transactions.
+func NewSEPAProvider(id int, name string, config SEPAConfig) *SEPAProvider {
+	clientConfig := httpclient.DefaultConfig(name)
+	if config.Timeout > 0 {
+		clientConfig.Timeout = config.Timeout
+	}
+
+	p := &SEPAProvider{
+		id:           strconv.Itoa(id),
+		name:         name,
+		config:       config,
+		client:       httpclient.New(clientConfig),
+		declineCodes: SEPADeclineCodes,
+		now:          time.Now,
+	}
+	p.available.Store(true)
+	return p
+}
+
+// SetEnvironments configures the sandbox and production environments of the bank's API
+func (p *SEPAProvider) SetEnvironments(environments Environments) {
+	p.environments = environments
+}
+
+// ID returns the unique identifier of the gateway
+func (p *SEPAProvider) ID() string {
+	return p.id
+}
+
+// Name returns the name of the gateway
+func (p *SEPAProvider) Name() string {
+	return p.name
+}
+
+// DataFormat returns the data format supported by the gateway
+func (p *SEPAProvider) DataFormat() string {
+	return "application/xml"
+}
+
+// IsAvailable reports whether the last request reached the bank
+func (p *SEPAProvider) IsAvailable() bool {
+	return p.available.Load()
+}
+
+// PaymentMethods returns the payment methods the provider takes: SEPA payments under a mandate
+func (p *SEPAProvider) PaymentMethods() []string {
+	return []string{consts.PaymentMethodSEPA}
+}
+
+// SupportsCurrency reports whether the provider takes a currency; SEPA payments are in euros
+func (p *SEPAProvider) SupportsCurrency(currency string) bool {
+	return currency == SEPACurrency
+}
+
+// ReportsReturns reports that the provider's camt.054 notifications report payments the other
+// party's bank returned after they settled
+func (p *SEPAProvider) ReportsReturns() bool {
+	return true
+}
+
+// ProcessDeposit refuses deposits without a mandate; they are collected with CollectDebit
+func (p *SEPAProvider) ProcessDeposit(ctx context.Context, transaction models.Transaction) (*models.TransactionResponse, error) {
+	return nil, fmt.Errorf("%s: deposits must be debited under a mandate", p.name)
+}
+
+// ProcessWithdrawal refuses withdrawals without a mandate; they are paid with CreditTransfer
+func (p *SEPAProvider) ProcessWithdrawal(ctx context.Context, transaction models.Transaction) (*models.TransactionResponse, error) {
+	return nil, fmt.Errorf("%s: withdrawals must be paid to a mandate's account", p.name)
+}
+
+// CollectDebit submits a pain.008 direct debit of a deposit under a mandate, to be collected on the
+// next business day. The end-to-end ID, the transaction's reference, is the gateway reference the
+// bank's notifications quote.
+func (p *SEPAProvider) CollectDebit(ctx context.Context, transaction models.Transaction, mandate models.Mandate) (*models.TransactionResponse, error) {
+	if err := p.checkPayment(transaction, mandate); err != nil {
+		return nil, err
+	}
+	if p.config.CreditorID == "" {
+		return nil, fmt.Errorf("%s: no SEPA creditor identifier is configured", p.name)
+	}
+
+	sequenceType := mandate.SequenceType
+	if sequenceType == "" {
+		sequenceType = consts.SequenceRecurring
+	}
+	endToEndID := sepaEndToEndID(transaction)
+	amount := p.amount(transaction)
+	now := p.now()
+
+	document := sepaDirectDebitDocument{
+		Namespace: sepaPain008Namespace,
+		Initiation: sepaDirectDebitInitiation{
+			GroupHeader: p.groupHeader(endToEndID, amount, now),
+			PaymentInfo: sepaDirectDebitPaymentInfo{
+				ID:             endToEndID,
+				Method:         "DD",
+				NumberOfTxs:    1,
+				ControlSum:     amount,
+				PaymentType:    sepaPaymentType{ServiceLevel: "SEPA", LocalInstrument: "CORE", SequenceType: sequenceType},
+				CollectionDate: sepaBusinessDay(now.AddDate(0, 0, 1)).Format("2006-01-02"),
+				Creditor:       sepaParty{Name: sepaText(p.config.CreditorName, sepaNameLength)},
+				CreditorAcct:   sepaAccount{IBAN: p.config.IBAN},
+				CreditorAgent:  sepaAgent(p.config.BIC),
+				ChargeBearer:   "SLEV",
+				CreditorScheme: sepaCreditorScheme{ID: p.config.CreditorID, Scheme: "SEPA"},
+				Transaction: sepaDirectDebitTransaction{
+					PaymentID: sepaPaymentID{InstructionID: strconv.Itoa(transaction.ID), EndToEndID: endToEndID},
+					Amount:    sepaAmount{Currency: transaction.Currency, Value: amount},
+					Mandate: sepaMandateInfo{
+						ID:         mandate.Reference,
+						SignedDate: mandate.SignedAt.Format("2006-01-02"),
+					},
+					DebtorAgent: sepaAgent(mandate.BIC),
+					Debtor:      sepaParty{Name: sepaText(mandate.DebtorName, sepaNameLength)},
+					DebtorAcct:  sepaAccount{IBAN: mandate.IBAN},
+					Remittance:  sepaRemittance{Unstructured: sepaText(transaction.ReferenceID, sepaRemittanceLength)},
+				},
+			},
+		},
+	}
+
+	return p.submit(ctx, transaction, sepaDirectDebitPath, document, endToEndID)
+}
+
+// CreditTransfer submits a pain.001 credit transfer paying a withdrawal out to a mandate's
+// account, executed on the current business day
+func (p *SEPAProvider) CreditTransfer(ctx context.Context, transaction models.Transaction, mandate models.Mandate) (*models.TransactionResponse, error) {
+	if err := p.checkPayment(transaction, mandate); err != nil {
+		return nil, err
+	}
+
+	endToEndID := sepaEndToEndID(transaction)
+	amount := p.amount(transaction)
+	now := p.now()
+
+	document := sepaCreditTransferDocument{
+		Namespace: sepaPain001Namespace,
+		Initiation: sepaCreditTransferInitiation{
+			GroupHeader: p.groupHeader(endToEndID, amount, now),
+			PaymentInfo: sepaCreditTransferPaymentInfo{
+				ID:            endToEndID,
+				Method:        "TRF",
+				NumberOfTxs:   1,
+				ControlSum:    amount,
+				PaymentType:   sepaPaymentType{ServiceLevel: "SEPA"},
+				ExecutionDate: sepaBusinessDay(now).Format("2006-01-02"),
+				Debtor:        sepaParty{Name: sepaText(p.config.CreditorName, sepaNameLength)},
+				DebtorAcct:    sepaAccount{IBAN: p.config.IBAN},
+				DebtorAgent:   sepaAgent(p.config.BIC),
+				ChargeBearer:  "SLEV",
+				Transaction: sepaCreditTransferTransaction{
+					PaymentID:    sepaPaymentID{InstructionID: strconv.Itoa(transaction.ID), EndToEndID: endToEndID},
+					Amount:       sepaInstructedAmount{Amount: sepaAmount{Currency: transaction.Currency, Value: amount}},
+					Creditor:     sepaParty{Name: sepaText(mandate.DebtorName, sepaNameLength)},
+					CreditorAcct: sepaAccount{IBAN: mandate.IBAN},
+					Remittance:   sepaRemittance{Unstructured: sepaText(transaction.ReferenceID, sepaRemittanceLength)},
+				},
+			},
+		},
+	}
+
+	return p.submit(ctx, transaction, sepaCreditTransferPath, document, endToEndID)
+}
+
+// RevokesMandate reports whether a SEPA reason code means the mandate's account can no longer be
+// debited: it is wrong or closed, its holder died, or the debtor's bank holds no mandate
+func (p *SEPAProvider) RevokesMandate(reasonCode string) bool {
+	switch reasonCode {
+	case "AC01", "AC04", "AC13", "MD01", "MD07", "SL01":
+		return true
+	}
+	return false
+}
+
+// ProcessRefund refuses refunds. Direct debits are refunded by the debtor's bank on request, or
+// paid back by credit transfer as a withdrawal to the mandate's account.
+func (p *SEPAProvider) ProcessRefund(ctx context.Context, refund models.Refund, transaction models.Transaction) (*models.TransactionResponse, error) {
+	return nil, fmt.Errorf("%s: %w", p.name, ErrRefundUnsupported)
+}
+
+// ParseCallback verifies the signature of a bank notification and maps the payment it reports to
+// its transaction by end-to-end ID. Payment status reports (pain.002) move it to processing when
+// accepted, completed when settled and failed when rejected; debit/credit notifications (camt.054)
+// complete it when booked and fail it when it was returned or refunded, even after it completed.
+func (p *SEPAProvider) ParseCallback(r *http.Request) (*models.CallbackData, error) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read callback: %w", err)
+	}
+	if err := p.verifySignature(body, r.Header.Get(SEPASignatureHeader)); err != nil {
+		return nil, err
+	}
+
+	var document sepaNotificationDocument
+	if err := xml.Unmarshal(body, &document); err != nil {
+		return nil, fmt.Errorf("invalid SEPA notification: %w", err)
+	}
+
+	var callbackData *models.CallbackData
+	switch {
+	case document.StatusReport != nil:
+		callbackData, err = p.parseStatusReport(document.StatusReport)
+	case document.Notification != nil:
+		callbackData, err = p.parseNotification(document.Notification)
+	default:
+		return nil, errors.New("unsupported SEPA notification: expected a pain.002 status report or camt.054 notification")
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	callbackData.GatewayID = p.id
+	if callbackData.ReasonCode != "" {
+		callbackData.DeclineCode = p.declineCodes.Normalize(callbackData.ReasonCode)
+	}
+	return callbackData, nil
+}
+
+// parseStatusReport maps the one payment of a pain.002 status report
+func (p *SEPAProvider) parseStatusReport(report *sepaStatusReport) (*models.CallbackData, error) {
+	if len(report.Transactions) != 1 {
+		return nil, fmt.Errorf("SEPA status report must report one payment, got %d", len(report.Transactions))
+	}
+	payment := report.Transactions[0]
+
+	callbackData := &models.CallbackData{GatewayReference: payment.EndToEndID, Timestamp: report.CreatedAt}
+	switch payment.Status {
+	case "RCVD", "PDNG", "ACTC", "ACCP", "ACSP", "ACWC":
+		callbackData.Status = consts.Processing
+	case "ACSC":
+		callbackData.Status = consts.Completed
+	case "RJCT":
+		callbackData.Status = consts.Failed
+		callbackData.ReasonCode, callbackData.Message = payment.reason("rejected")
+	default:
+		return nil, fmt.Errorf("unsupported SEPA transaction status %q", payment.Status)
+	}
+	return callbackData, nil
+}
+
+// parseNotification maps the one payment of a camt.054 debit/credit notification. Payments with
+// return information were returned or refunded by the other party's bank.
+func (p *SEPAProvider) parseNotification(notification *sepaDebitCreditNotification) (*models.CallbackData, error) {
+	var entry sepaEntry
+	var payments []sepaEntryTransaction
+	for _, e := range notification.Entries {
+		if len(e.Transactions) > 0 {
+			entry = e
+		}
+		payments = append(payments, e.Transactions...)
+	}
+	if len(payments) != 1 {
+		return nil, fmt.Errorf("SEPA notification must report one payment, got %d", len(payments))
+	}
+	payment := payments[0]
+
+	callbackData := &models.CallbackData{GatewayReference: payment.EndToEndID, Timestamp: notification.CreatedAt}
+	switch {
+	case payment.Return != nil:
+		callbackData.Status = consts.Failed
+		callbackData.ReasonCode = payment.Return.Code
+		callbackData.Message = "returned by the bank"
+		if payment.Return.Code != "" {
+			callbackData.Message += ": " + payment.Return.Code
+		}
+		if info := strings.Join(payment.Return.AdditionalInfo, " "); info != "" {
+			callbackData.Message += ": " + info
+		}
+	case entry.Status.code() == "BOOK":
+		callbackData.Status = consts.Completed
+	case entry.Status.code() == "PDNG" || entry.Status.code() == "INFO":
+		callbackData.Status = consts.Processing
+	default:
+		return nil, fmt.Errorf("unsupported SEPA entry status %q", entry.Status.code())
+	}
+	return callbackData, nil
+}
+
+// checkPayment checks that a transaction can be paid by SEPA under a mandate
+func (p *SEPAProvider) checkPayment(transaction models.Transaction, mandate models.Mandate) error {
+	if transaction.Currency != SEPACurrency {
+		return fmt.Errorf("%s: SEPA payments are in %s, not %s", p.name, SEPACurrency, transaction.Currency)
+	}
+	if mandate.IBAN == "" || mandate.Reference == "" {
+		return fmt.Errorf("%s: mandate %d has no IBAN or reference", p.name, mandate.ID)
+	}
+	if p.config.IBAN == "" {
+		return fmt.Errorf("%s: no creditor IBAN is configured", p.name)
+	}
+	return nil
+}
+
+// amount formats a transaction's amount as ISO 20022 messages carry it
+func (p *SEPAProvider) amount(transaction models.Transaction) string {
+	return strconv.FormatFloat(transaction.Amount, 'f', money.Exponent(transaction.Currency), 64)
+}
+
+// groupHeader returns the header of a message initiating one payment
+func (p *SEPAProvider) groupHeader(messageID, amount string, now time.Time) sepaGroupHeader {
+	return sepaGroupHeader{
+		MessageID:      messageID,
+		CreatedAt:      now.UTC().Format("2006-01-02T15:04:05"),
+		NumberOfTxs:    1,
+		ControlSum:     amount,
+		InitiatingName: sepaText(p.config.CreditorName, sepaNameLength),
+	}
+}
+
+// submit sends an initiation message to the transaction's environment and reads the bank's
+// pain.002 acknowledgement. Payments the bank rejects outright are returned as a DeclineError.
+func (p *SEPAProvider) submit(ctx context.Context, transaction models.Transaction, path string, document interface{}, endToEndID string) (*models.TransactionResponse, error) {
+	env, err := p.environments.Select(transaction.Livemode)
+	if err != nil {
+		return nil, err
+	}
+	if env.BaseURL == "" {
+		return nil, fmt.Errorf("%s: no SEPA API URL is configured", p.name)
+	}
+
+	body, err := xml.Marshal(document)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode SEPA message: %w", err)
+	}
+	body = append([]byte(xml.Header), body...)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, strings.TrimSuffix(env.BaseURL, "/")+path, bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("failed to build SEPA request: %w", err)
+	}
+	req.Header.Set("Authorization", "Bearer "+env.APIKey)
+	req.Header.Set("Content-Type", "application/xml")
+	if transaction.GatewayIdempotencyKey != "" {
+		req.Header.Set("Idempotency-Key", transaction.GatewayIdempotencyKey)
+	}
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		p.available.Store(false)
+		return nil, fmt.Errorf("SEPA request failed: %w", err)
+	}
+	defer resp.Body.Close()
+	p.available.Store(resp.StatusCode < http.StatusInternalServerError)
+
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read SEPA response: %w", err)
+	}
+	if resp.StatusCode >= http.StatusBadRequest {
+		return nil, fmt.Errorf("SEPA API returned status %d", resp.StatusCode)
+	}
+
+	var acknowledgement sepaNotificationDocument
+	if err := xml.Unmarshal(respBody, &acknowledgement); err != nil || acknowledgement.StatusReport == nil {
+		return nil, fmt.Errorf("invalid SEPA acknowledgement: expected a pain.002 status report")
+	}
+	if code, message, rejected := acknowledgement.StatusReport.rejection(); rejected {
+		return nil, &DeclineError{Code: p.declineCodes.Normalize(code), ProviderCode: code, Message: message}
+	}
+
+	return &models.TransactionResponse{
+		TransactionID:    transaction.ID,
+		Status:           consts.Processing,
+		GatewayReference: endToEndID,
+	}, nil
+}
+
+// verifySignature checks the X-Signature header: the hex HMAC-SHA256 of the raw body under one of
+// the webhook secrets
+func (p *SEPAProvider) verifySignature(body []byte, header string) error {
+	if len(p.config.WebhookSecrets) == 0 {
+		return fmt.Errorf("%w: no SEPA webhook secret is configured", ErrInvalidCallbackSignature)
+	}
+	signature, err := hex.DecodeString(header)
+	if err != nil || len(signature) == 0 {
+		return fmt.Errorf("%w: missing or malformed %s header", ErrInvalidCallbackSignature, SEPASignatureHeader)
+	}
+
+	for _, secret := range p.config.WebhookSecrets {
+		mac := hmac.New(sha256.New, []byte(secret))
+		mac.Write(body)
+		if hmac.Equal(mac.Sum(nil), signature) {
+			return nil
+		}
+	}
+	return ErrInvalidCallbackSignature
+}
+
+// SEPADeclineCodes maps the ISO 20022 reason codes of SEPA rejects, returns and refunds to
+// normalized decline codes
+var SEPADeclineCodes = DeclineCodeMap{
+	"AC01": consts.DeclineInvalidAccount,    // incorrect account number
+	"AC04": consts.DeclineInvalidAccount,    // account closed
+	"AC06": consts.DeclineDoNotHonor,        // account blocked
+	"AC13": consts.DeclineInvalidAccount,    // a consumer account debited under a B2B mandate
+	"AG01": consts.DeclineDoNotHonor,        // the account does not take direct debits
+	"AG02": consts.DeclineProcessingError,   // invalid bank operation code
+	"AM04": consts.DeclineInsufficientFunds, // insufficient funds
+	"AM05": consts.DeclineProcessingError,   // duplicate payment
+	"BE05": consts.DeclineProcessingError,   // unrecognised initiating party
+	"CNOR": consts.DeclineIssuerUnavailable, // the creditor's bank is not reachable
+	"DNOR": consts.DeclineIssuerUnavailable, // the debtor's bank is not reachable
+	"FF01": consts.DeclineProcessingError,   // invalid file format
+	"FOCR": consts.DeclineProcessingError,   // returned after a recall
+	"MD01": consts.DeclineMandateInvalid,    // no mandate
+	"MD02": consts.DeclineMandateInvalid,    // mandate data missing or incorrect
+	"MD06": consts.DeclineDebitRefunded,     // refund requested by the debtor
+	"MD07": consts.DeclineInvalidAccount,    // the debtor died
+	"MS02": consts.DeclineDoNotHonor,        // refused by the debtor
+	"MS03": consts.DeclineDoNotHonor,        // no reason given
+	"RC01": consts.DeclineInvalidAccount,    // incorrect BIC
+	"RR01": consts.DeclineDoNotHonor,        // regulatory reason
+	"RR02": consts.DeclineDoNotHonor,        // regulatory reason
+	"RR03": consts.DeclineDoNotHonor,        // regulatory reason
+	"RR04": consts.DeclineDoNotHonor,        // regulatory reason
+	"SL01": consts.DeclineDoNotHonor,        // the debtor blocked debits from the creditor
+}
+
+// sepaEndToEndID returns the end-to-end ID a payment carries to the other party's bank and back
+// in every notification: the transaction's reference
+func sepaEndToEndID(transaction models.Transaction) string {
+	id := transaction.ReferenceID
+	if id == "" {
+		id = "TX" + strconv.Itoa(transaction.ID)
+	}
+	if len(id) > sepaIDLength {
+		id = id[len(id)-sepaIDLength:]
+	}
+	return id
+}
+
+// sepaBusinessDay returns the first weekday from t, when SEPA payments are executed
+func sepaBusinessDay(t time.Time) time.Time {
+	for t.Weekday() == time.Saturday || t.Weekday() == time.Sunday {
+		t = t.AddDate(0, 0, 1)
+	}
+	return t
+}
+
+// sepaText truncates text to the characters a SEPA field holds
+func sepaText(text string, max int) string {
+	if utf8.RuneCountInString(text) <= max {
+		return text
+	}
+	return string([]rune(text)[:max])
+}
+
+// sepaAgent identifies a bank by BIC, or as not provided when payments are routed by IBAN only
+func sepaAgent(bic string) sepaFinancialInstitution {
+	if bic == "" {
+		return sepaFinancialInstitution{Other: &sepaOtherID{ID: "NOTPROVIDED"}}
+	}
+	return sepaFinancialInstitution{BIC: bic}
+}
+
+// sepaGroupHeader is the header of an initiation message
+type sepaGroupHeader struct {
+	MessageID      string `xml:"MsgId"`
+	CreatedAt      string `xml:"CreDtTm"`
+	NumberOfTxs    int    `xml:"NbOfTxs"`
+	ControlSum     string `xml:"CtrlSum"`
+	InitiatingName string `xml:"InitgPty>Nm"`
+}
+
+// sepaPaymentType is the service level, scheme and sequence of the payments in a payment block
+type sepaPaymentType struct {
+	ServiceLevel    string `xml:"SvcLvl>Cd"`
+	LocalInstrument string `xml:"LclInstrm>Cd,omitempty"`
+	SequenceType    string `xml:"SeqTp,omitempty"`
+}
+
+// sepaParty is a party's name
+type sepaParty struct {
+	Name string `xml:"Nm"`
+}
+
+// sepaAccount is an account identified by IBAN
+type sepaAccount struct {
+	IBAN string `xml:"Id>IBAN"`
+}
+
+// sepaFinancialInstitution identifies a bank by BIC, or as not provided
+type sepaFinancialInstitution struct {
+	BIC   string       `xml:"FinInstnId>BIC,omitempty"`
+	Other *sepaOtherID `xml:"FinInstnId>Othr,omitempty"`
+}
+
+// sepaOtherID is an identifier other than a BIC
+type sepaOtherID struct {
+	ID string `xml:"Id"`
+}
+
+// sepaCreditorScheme is the creditor identifier debits are collected under
+type sepaCreditorScheme struct {
+	ID     string `xml:"Id>PrvtId>Othr>Id"`
+	Scheme string `xml:"Id>PrvtId>Othr>SchmeNm>Prtry"`
+}
+
+// sepaPaymentID identifies a payment to the initiating party and end to end
+type sepaPaymentID struct {
+	InstructionID string `xml:"InstrId"`
+	EndToEndID    string `xml:"EndToEndId"`
+}
+
+// sepaAmount is an amount with its currency
+type sepaAmount struct {
+	Currency string `xml:"Ccy,attr"`
+	Value    string `xml:",chardata"`
+}
+
+// sepaInstructedAmount wraps the amount of a credit transfer
+type sepaInstructedAmount struct {
+	Amount sepaAmount `xml:"InstdAmt"`
+}
+
+// sepaMandateInfo is the mandate a direct debit is collected under
+type sepaMandateInfo struct {
+	ID         string `xml:"MndtId"`
+	SignedDate string `xml:"DtOfSgntr"`
+}
+
+// sepaRemittance is the unstructured remittance information the other party's bank shows
+type sepaRemittance struct {
+	Unstructured string `xml:"Ustrd"`
+}
+
+// sepaDirectDebitDocument is a pain.008 customer direct debit initiation
+type sepaDirectDebitDocument struct {
+	XMLName    xml.Name                  `xml:"Document"`
+	Namespace  string                    `xml:"xmlns,attr"`
+	Initiation sepaDirectDebitInitiation `xml:"CstmrDrctDbtInitn"`
+}
+
+type sepaDirectDebitInitiation struct {
+	GroupHeader sepaGroupHeader            `xml:"GrpHdr"`
+	PaymentInfo sepaDirectDebitPaymentInfo `xml:"PmtInf"`
+}
+
+type sepaDirectDebitPaymentInfo struct {
+	ID             string                     `xml:"PmtInfId"`
+	Method         string                     `xml:"PmtMtd"`
+	NumberOfTxs    int                        `xml:"NbOfTxs"`
+	ControlSum     string                     `xml:"CtrlSum"`
+	PaymentType    sepaPaymentType            `xml:"PmtTpInf"`
+	CollectionDate string                     `xml:"ReqdColltnDt"`
+	Creditor       sepaParty                  `xml:"Cdtr"`
+	CreditorAcct   sepaAccount                `xml:"CdtrAcct"`
+	CreditorAgent  sepaFinancialInstitution   `xml:"CdtrAgt"`
+	ChargeBearer   string                     `xml:"ChrgBr"`
+	CreditorScheme sepaCreditorScheme         `xml:"CdtrSchmeId"`
+	Transaction    sepaDirectDebitTransaction `xml:"DrctDbtTxInf"`
+}
+
+type sepaDirectDebitTransaction struct {
+	PaymentID   sepaPaymentID            `xml:"PmtId"`
+	Amount      sepaAmount               `xml:"InstdAmt"`
+	Mandate     sepaMandateInfo          `xml:"DrctDbtTx>MndtRltdInf"`
+	DebtorAgent sepaFinancialInstitution `xml:"DbtrAgt"`
+	Debtor      sepaParty                `xml:"Dbtr"`
+	DebtorAcct  sepaAccount              `xml:"DbtrAcct"`
+	Remittance  sepaRemittance           `xml:"RmtInf"`
+}
+
+// sepaCreditTransferDocument is a pain.001 customer credit transfer initiation
+type sepaCreditTransferDocument struct {
+	XMLName    xml.Name                     `xml:"Document"`
+	Namespace  string                       `xml:"xmlns,attr"`
+	Initiation sepaCreditTransferInitiation `xml:"CstmrCdtTrfInitn"`
+}
+
+type sepaCreditTransferInitiation struct {
+	GroupHeader sepaGroupHeader               `xml:"GrpHdr"`
+	PaymentInfo sepaCreditTransferPaymentInfo `xml:"PmtInf"`
+}
+
+type sepaCreditTransferPaymentInfo struct {
+	ID            string                        `xml:"PmtInfId"`
+	Method        string                        `xml:"PmtMtd"`
+	NumberOfTxs   int                           `xml:"NbOfTxs"`
+	ControlSum    string                        `xml:"CtrlSum"`
+	PaymentType   sepaPaymentType               `xml:"PmtTpInf"`
+	ExecutionDate string                        `xml:"ReqdExctnDt"`
+	Debtor        sepaParty                     `xml:"Dbtr"`
+	DebtorAcct    sepaAccount                   `xml:"DbtrAcct"`
+	DebtorAgent   sepaFinancialInstitution      `xml:"DbtrAgt"`
+	ChargeBearer  string                        `xml:"ChrgBr"`
+	Transaction   sepaCreditTransferTransaction `xml:"CdtTrfTxInf"`
+}
+
+type sepaCreditTransferTransaction struct {
+	PaymentID    sepaPaymentID        `xml:"PmtId"`
+	Amount       sepaInstructedAmount `xml:"Amt"`
+	Creditor     sepaParty            `xml:"Cdtr"`
+	CreditorAcct sepaAccount          `xml:"CdtrAcct"`
+	Remittance   sepaRemittance       `xml:"RmtInf"`
+}
+
+// sepaNotificationDocument is a message from the bank: a pain.002 payment status report or a
+// camt.054 debit/credit notification
+type sepaNotificationDocument struct {
+	StatusReport *sepaStatusReport            `xml:"CstmrPmtStsRpt"`
+	Notification *sepaDebitCreditNotification `xml:"BkToCstmrDbtCdtNtfctn"`
+}
+
+// sepaStatusReport is a pain.002 payment status report
+type sepaStatusReport struct {
+	CreatedAt    string                  `xml:"GrpHdr>CreDtTm"`
+	GroupStatus  string                  `xml:"OrgnlGrpInfAndSts>GrpSts"`
+	GroupReasons []sepaStatusReason      `xml:"OrgnlGrpInfAndSts>StsRsnInf"`
+	Transactions []sepaTransactionStatus `xml:"OrgnlPmtInfAndSts>TxInfAndSts"`
+}
+
+// rejection returns the reason code and message of a report rejecting the message or its payment
+func (r *sepaStatusReport) rejection() (code, message string, rejected bool) {
+	if r.GroupStatus == "RJCT" {
+		status := sepaTransactionStatus{Status: r.GroupStatus, Reasons: r.GroupReasons}
+		code, message = status.reason("rejected")
+		return code, message, true
+	}
+	for _, payment := range r.Transactions {
+		if payment.Status == "RJCT" {
+			code, message = payment.reason("rejected")
+			return code, message, true
+		}
+	}
+	return "", "", false
+}
+
+// sepaStatusReason is the reason a status was given
+type sepaStatusReason struct {
+	Code           string   `xml:"Rsn>Cd"`
+	AdditionalInfo []string `xml:"AddtlInf"`
+}
+
+// sepaTransactionStatus is the status of one payment in a pain.002 report
+type sepaTransactionStatus struct {
+	EndToEndID string             `xml:"OrgnlEndToEndId"`
+	Status     string             `xml:"TxSts"`
+	Reasons    []sepaStatusReason `xml:"StsRsnInf"`
+}
+
+// reason returns the reason code of a payment's status and a message describing it
+func (s sepaTransactionStatus) reason(verb string) (code, message string) {
+	message = verb + " by the bank"
+	if len(s.Reasons) == 0 {
+		return "", message
+	}
+	code = s.Reasons[0].Code
+	if code != "" {
+		message += ": " + code
+	}
+	if info := strings.Join(s.Reasons[0].AdditionalInfo, " "); info != "" {
+		message += ": " + info
+	}
+	return code, message
+}
+
+// sepaDebitCreditNotification is a camt.054 bank-to-customer debit/credit notification
+type sepaDebitCreditNotification struct {
+	CreatedAt string      `xml:"GrpHdr>CreDtTm"`
+	Entries   []sepaEntry `xml:"Ntfctn>Ntry"`
+}
+
+// sepaEntry is an entry booked, or pending, on the creditor's account
+type sepaEntry struct {
+	Status       sepaEntryStatus        `xml:"Sts"`
+	Transactions []sepaEntryTransaction `xml:"NtryDtls>TxDtls"`
+}
+
+// sepaEntryStatus is an entry's status, a code of its own before camt.054.001.08 and a Cd element
+// since
+type sepaEntryStatus struct {
+	Text string `xml:",chardata"`
+	Code string `xml:"Cd"`
+}
+
+// code returns the entry's status code
+func (s sepaEntryStatus) code() string {
+	if s.Code != "" {
+		return s.Code
+	}
+	return strings.TrimSpace(s.Text)
+}
+
+// sepaEntryTransaction is a payment in an entry, with return information when it was returned
+type sepaEntryTransaction struct {
+	EndToEndID string          `xml:"Refs>EndToEndId"`
+	Return     *sepaReturnInfo `xml:"RtrInf"`
+}
+
+// sepaReturnInfo is why a payment was returned or refunded
+type sepaReturnInfo struct {
+	Code           string   `xml:"Rsn>Cd"`
+	AdditionalInfo []string `xml:"AddtlInf"`
+}
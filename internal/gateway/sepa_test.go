@@ -0,0 +1,244 @@
+package gateway
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"payment-gateway/internal/consts"
+	"payment-gateway/internal/models"
+	"testing"
+	"time"
+)
+
+const sepaTestWebhookSecret = "sepa-secret"
+
+// sepaAcknowledgement is a pain.002 acknowledgement of a payment with a status and reason code
+func sepaAcknowledgement(status, reason string) string {
+	return fmt.Sprintf(`<?xml version="1.0" encoding="UTF-8"?>
+<Document xmlns="urn:iso:std:iso:20022:tech:xsd:pain.002.001.03"><CstmrPmtStsRpt>
+	<GrpHdr><MsgId>ACK1</MsgId><CreDtTm>2024-05-03T10:00:00</CreDtTm></GrpHdr>
+	<OrgnlGrpInfAndSts><OrgnlMsgId>PG01</OrgnlMsgId></OrgnlGrpInfAndSts>
+	<OrgnlPmtInfAndSts><TxInfAndSts><OrgnlEndToEndId>PG01</OrgnlEndToEndId><TxSts>%s</TxSts>
+		<StsRsnInf><Rsn><Cd>%s</Cd></Rsn><AddtlInf>See the debtor</AddtlInf></StsRsnInf></TxInfAndSts></OrgnlPmtInfAndSts>
+</CstmrPmtStsRpt></Document>`, status, reason)
+}
+
+// sepaBookingNotification is a camt.054 notification of payments with end-to-end IDs, each
+// returned with a reason code when not empty
+func sepaBookingNotification(status string, payments map[string]string) string {
+	var details string
+	for endToEndID, returnCode := range payments {
+		details += fmt.Sprintf(`<TxDtls><Refs><EndToEndId>%s</EndToEndId></Refs>`, endToEndID)
+		if returnCode != "" {
+			details += fmt.Sprintf(`<RtrInf><Rsn><Cd>%s</Cd></Rsn></RtrInf>`, returnCode)
+		}
+		details += `</TxDtls>`
+	}
+	return fmt.Sprintf(`<Document xmlns="urn:iso:std:iso:20022:tech:xsd:camt.054.001.02"><BkToCstmrDbtCdtNtfctn>
+	<GrpHdr><MsgId>NTF1</MsgId><CreDtTm>2024-05-06T08:00:00</CreDtTm></GrpHdr>
+	<Ntfctn><Ntry><Sts>%s</Sts><NtryDtls>%s</NtryDtls></Ntry></Ntfctn>
+</BkToCstmrDbtCdtNtfctn></Document>`, status, details)
+}
+
+// fakeSEPABank answers payment initiations with an acknowledgement, recording each request with its
+// body read. The provider's clock is a Friday.
+func fakeSEPABank(t *testing.T, acknowledgement string) (*SEPAProvider, <-chan *http.Request) {
+	requests := make(chan *http.Request, 10)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requestBody, _ := io.ReadAll(r.Body)
+		r.Body = io.NopCloser(bytes.NewReader(requestBody))
+		requests <- r
+		w.Header().Set("Content-Type", "application/xml")
+		fmt.Fprint(w, acknowledgement)
+	}))
+	t.Cleanup(server.Close)
+
+	provider := NewSEPAProvider(9, "SEPA", SEPAConfig{
+		CreditorID:     "DE98ZZZ09999999999",
+		CreditorName:   "Example Shop GmbH",
+		IBAN:           "DE89370400440532013000",
+		BIC:            "COBADEFFXXX",
+		WebhookSecrets: []string{"sepa-old", sepaTestWebhookSecret},
+	})
+	provider.now = func() time.Time { return time.Date(2024, 5, 3, 9, 30, 0, 0, time.UTC) }
+	provider.SetEnvironments(Environments{Sandbox: Environment{Name: EnvironmentSandbox, BaseURL: server.URL, APIKey: "sepa-key"}})
+	return provider, requests
+}
+
+var sepaTestMandate = models.Mandate{
+	ID:           3,
+	Reference:    "MNDT-ABC123",
+	DebtorName:   "Erika Mustermann",
+	IBAN:         "FR1420041010050500013M02606",
+	SequenceType: consts.SequenceFirst,
+	SignedAt:     time.Date(2024, 4, 1, 0, 0, 0, 0, time.UTC),
+}
+
+func TestSEPACollectDebit(t *testing.T) {
+	provider, requests := fakeSEPABank(t, sepaAcknowledgement("ACTC", ""))
+
+	tx := models.Transaction{ID: 42, Amount: 25.5, Currency: "EUR", ReferenceID: "PG01", GatewayIdempotencyKey: "idem-42"}
+	response, err := provider.CollectDebit(context.Background(), tx, sepaTestMandate)
+	if err != nil {
+		t.Fatalf("CollectDebit failed: %v", err)
+	}
+	if response.Status != consts.Processing || response.GatewayReference != "PG01" {
+		t.Errorf("Unexpected response: %+v", response)
+	}
+
+	req := <-requests
+	if req.URL.Path != "/direct-debits" || req.Header.Get("Authorization") != "Bearer sepa-key" ||
+		req.Header.Get("Content-Type") != "application/xml" || req.Header.Get("Idempotency-Key") != "idem-42" {
+		t.Errorf("Unexpected request %s with headers %v", req.URL.Path, req.Header)
+	}
+
+	var document sepaDirectDebitDocument
+	if err := xml.NewDecoder(req.Body).Decode(&document); err != nil {
+		t.Fatalf("Invalid request body: %v", err)
+	}
+	payment := document.Initiation.PaymentInfo
+	debit := payment.Transaction
+	// Collected on the Monday after the Friday it was submitted
+	if document.Namespace != sepaPain008Namespace || payment.CollectionDate != "2024-05-06" ||
+		payment.PaymentType != (sepaPaymentType{ServiceLevel: "SEPA", LocalInstrument: "CORE", SequenceType: consts.SequenceFirst}) ||
+		payment.CreditorScheme.ID != "DE98ZZZ09999999999" || payment.CreditorAcct.IBAN != "DE89370400440532013000" {
+		t.Errorf("Unexpected payment information: %+v", payment)
+	}
+	if debit.PaymentID.EndToEndID != "PG01" || debit.Amount != (sepaAmount{Currency: "EUR", Value: "25.50"}) ||
+		debit.Mandate != (sepaMandateInfo{ID: "MNDT-ABC123", SignedDate: "2024-04-01"}) ||
+		debit.DebtorAcct.IBAN != sepaTestMandate.IBAN || debit.DebtorAgent.Other == nil || debit.DebtorAgent.Other.ID != "NOTPROVIDED" {
+		t.Errorf("Unexpected debit: %+v", debit)
+	}
+
+	if _, err := provider.ProcessDeposit(context.Background(), tx); err == nil {
+		t.Error("Expected deposits without a mandate to be refused")
+	}
+	if _, err := provider.CollectDebit(context.Background(), models.Transaction{ID: 43, Amount: 10, Currency: "USD"}, sepaTestMandate); err == nil {
+		t.Error("Expected a debit in USD to be refused")
+	}
+}
+
+func TestSEPACreditTransfer(t *testing.T) {
+	provider, requests := fakeSEPABank(t, sepaAcknowledgement("ACCP", ""))
+
+	tx := models.Transaction{ID: 44, Amount: 100, Currency: "EUR", ReferenceID: "PG02", Type: consts.Withdrawal}
+	if _, err := provider.CreditTransfer(context.Background(), tx, sepaTestMandate); err != nil {
+		t.Fatalf("CreditTransfer failed: %v", err)
+	}
+
+	req := <-requests
+	var document sepaCreditTransferDocument
+	if err := xml.NewDecoder(req.Body).Decode(&document); err != nil {
+		t.Fatalf("Invalid request body: %v", err)
+	}
+	payment := document.Initiation.PaymentInfo
+	transfer := payment.Transaction
+	if req.URL.Path != "/credit-transfers" || document.Namespace != sepaPain001Namespace || payment.ExecutionDate != "2024-05-03" ||
+		payment.DebtorAcct.IBAN != "DE89370400440532013000" || payment.DebtorAgent.BIC != "COBADEFFXXX" {
+		t.Errorf("Unexpected payment information: %+v", payment)
+	}
+	if transfer.Amount.Amount != (sepaAmount{Currency: "EUR", Value: "100.00"}) || transfer.Creditor.Name != "Erika Mustermann" ||
+		transfer.CreditorAcct.IBAN != sepaTestMandate.IBAN || transfer.PaymentID.EndToEndID != "PG02" {
+		t.Errorf("Unexpected credit transfer: %+v", transfer)
+	}
+}
+
+func TestSEPARejectedPayment(t *testing.T) {
+	provider, _ := fakeSEPABank(t, sepaAcknowledgement("RJCT", "AC04"))
+
+	_, err := provider.CollectDebit(context.Background(), models.Transaction{ID: 42, Amount: 10, Currency: "EUR", ReferenceID: "PG01"}, sepaTestMandate)
+	var declineErr *DeclineError
+	if !errors.As(err, &declineErr) {
+		t.Fatalf("Expected a DeclineError, got %v", err)
+	}
+	if declineErr.Code != consts.DeclineInvalidAccount || declineErr.ProviderCode != "AC04" || declineErr.Message != "rejected by the bank: AC04: See the debtor" {
+		t.Errorf("Unexpected decline: %+v", declineErr)
+	}
+}
+
+// signSEPANotification returns the X-Signature header of a notification
+func signSEPANotification(secret, body string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(body))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func TestSEPAParseCallback(t *testing.T) {
+	provider, _ := fakeSEPABank(t, "")
+
+	tests := []struct {
+		name        string
+		body        string
+		status      string
+		reasonCode  string
+		declineCode string
+	}{
+		{"accepted", sepaAcknowledgement("ACSP", ""), consts.Processing, "", ""},
+		{"settled", sepaAcknowledgement("ACSC", ""), consts.Completed, "", ""},
+		{"rejected", sepaAcknowledgement("RJCT", "AM04"), consts.Failed, "AM04", consts.DeclineInsufficientFunds},
+		{"booked", sepaBookingNotification("BOOK", map[string]string{"PG01": ""}), consts.Completed, "", ""},
+		{"booked since camt.054.001.08", sepaBookingNotification("<Cd>BOOK</Cd>", map[string]string{"PG01": ""}), consts.Completed, "", ""},
+		{"pending", sepaBookingNotification("PDNG", map[string]string{"PG01": ""}), consts.Processing, "", ""},
+		{"refunded", sepaBookingNotification("BOOK", map[string]string{"PG01": "MD06"}), consts.Failed, "MD06", consts.DeclineDebitRefunded},
+		{"returned for a closed account", sepaBookingNotification("BOOK", map[string]string{"PG01": "AC04"}), consts.Failed, "AC04", consts.DeclineInvalidAccount},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/callback/9", bytes.NewReader([]byte(tt.body)))
+			req.Header.Set(SEPASignatureHeader, signSEPANotification(sepaTestWebhookSecret, tt.body))
+
+			data, err := provider.ParseCallback(req)
+			if err != nil {
+				t.Fatalf("ParseCallback failed: %v", err)
+			}
+			if data.GatewayID != "9" || data.GatewayReference != "PG01" || data.TransactionID != 0 ||
+				data.Status != tt.status || data.ReasonCode != tt.reasonCode || data.DeclineCode != tt.declineCode {
+				t.Errorf("Unexpected callback data: %+v", data)
+			}
+		})
+	}
+}
+
+func TestSEPAParseCallbackRefused(t *testing.T) {
+	provider, _ := fakeSEPABank(t, "")
+	body := sepaAcknowledgement("ACSC", "")
+	twoPayments := sepaBookingNotification("BOOK", map[string]string{"PG01": "", "PG02": ""})
+
+	for _, signature := range []string{signSEPANotification("sepa-other", body), "", "not-hex"} {
+		req := httptest.NewRequest(http.MethodPost, "/callback/9", bytes.NewReader([]byte(body)))
+		req.Header.Set(SEPASignatureHeader, signature)
+		if _, err := provider.ParseCallback(req); !errors.Is(err, ErrInvalidCallbackSignature) {
+			t.Errorf("Expected an invalid signature for %q, got %v", signature, err)
+		}
+	}
+
+	req := httptest.NewRequest(http.MethodPost, "/callback/9", bytes.NewReader([]byte(body)))
+	req.Header.Set(SEPASignatureHeader, signSEPANotification("sepa-old", body))
+	if _, err := provider.ParseCallback(req); err != nil {
+		t.Errorf("Expected a rotated secret to be accepted, got %v", err)
+	}
+
+	req = httptest.NewRequest(http.MethodPost, "/callback/9", bytes.NewReader([]byte(twoPayments)))
+	req.Header.Set(SEPASignatureHeader, signSEPANotification(sepaTestWebhookSecret, twoPayments))
+	if _, err := provider.ParseCallback(req); err == nil {
+		t.Error("Expected a notification of two payments to be refused")
+	}
+}
+
+func TestSEPARevokesMandate(t *testing.T) {
+	provider := NewSEPAProvider(9, "SEPA", SEPAConfig{})
+	for code, want := range map[string]bool{"AC04": true, "MD01": true, "MD06": false, "AM04": false, "": false} {
+		if got := provider.RevokesMandate(code); got != want {
+			t.Errorf("RevokesMandate(%q) = %v, want %v", code, got, want)
+		}
+	}
+}
@@ -0,0 +1,41 @@
+package gateway
+
+import (
+	"context"
+	"payment-gateway/internal/models"
+	"time"
+)
+
+// StatusQueryProvider is implemented by providers whose API reports a transaction's current status,
+// so transactions still in flight can be refreshed when they are looked up rather than only when
+// the gateway's callback arrives
+type StatusQueryProvider interface {
+	// QueryTransactionStatus asks the gateway for the status of a transaction it was sent, returned
+	// like a callback reporting it
+	QueryTransactionStatus(ctx context.Context, transaction models.Transaction) (*models.CallbackData, error)
+}
+
+// StatusPollingProvider is implemented by providers whose callbacks can't be relied on, such as
+// UPI PSPs. Their transactions in flight are polled for their status until they settle.
+type StatusPollingProvider interface {
+	StatusQueryProvider
+
+	// StatusPollDelay returns how long after its submission a transaction is first polled,
+	// leaving the callback time to arrive
+	StatusPollDelay() time.Duration
+}
+
+// SupportsStatusQuery reports whether a provider can be asked for a transaction's status
+func SupportsStatusQuery(provider Provider) bool {
+	_, ok := provider.(StatusQueryProvider)
+	return ok
+}
+
+// StatusPollDelay returns how long after submission a provider's transactions are first polled,
+// or zero when its transactions aren't polled
+func StatusPollDelay(provider Provider) time.Duration {
+	if polling, ok := provider.(StatusPollingProvider); ok {
+		return polling.StatusPollDelay()
+	}
+	return 0
+}
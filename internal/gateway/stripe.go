@@ -0,0 +1,578 @@
+package gateway
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"payment-gateway/internal/consts"
+	"payment-gateway/internal/httpclient"
+	"payment-gateway/internal/models"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// Stripe API settings
+const (
+	StripeAPIURL          = "https://api.stripe.com"
+	StripeAPIVersion      = "2024-06-20" // pinned so API upgrades on the account don't change responses
+	StripeSignatureHeader = "Stripe-Signature"
+
+	// DefaultStripeWebhookTolerance is how old a webhook's signature timestamp may be, as in Stripe's libraries
+	DefaultStripeWebhookTolerance = 5 * time.Minute
+)
+
+// ErrInvalidCallbackSignature is returned by ParseCallback when a provider verifies callback
+// signatures itself and the signature is missing, wrong or too old
+var ErrInvalidCallbackSignature = errors.New("invalid callback signature")
+
+// StripeConfig configures the Stripe provider. API keys and base URLs are the provider's
+// environments; sandbox uses test-mode keys (sk_test_...) and production live-mode keys.
+type StripeConfig struct {
+	// WebhookSecrets are the signing secrets (whsec_...) of the webhook endpoints Stripe sends
+	// events to. Events signed with any of them are accepted, so secrets can be rolled and test
+	// and live mode endpoints can share the callback URL.
+	WebhookSecrets []string
+
+	// WebhookTolerance is how far a webhook's signature timestamp may be from the time the
+	// callback was received; DefaultStripeWebhookTolerance when zero
+	WebhookTolerance time.Duration
+
+	// PaymentMethodTypes restricts the payment methods of PaymentIntents, e.g. "card". Payment
+	// methods enabled in the Stripe dashboard are offered when empty.
+	PaymentMethodTypes []string
+
+	Timeout time.Duration // per attempt; 30 seconds when zero
+}
+
+// StripeProvider is a gateway adapter for Stripe. Deposits create a PaymentIntent the customer
+// confirms on the merchant's page with its client secret, withdrawals create payouts to a
+// connected bank account or card, and both are settled by webhook events. Requests carry the
+// transaction's idempotency key, so retries never create a second PaymentIntent or payout.
+type StripeProvider struct {
+	id           string
+	name         string
+	config       StripeConfig
+	client       *httpclient.Client
+	declineCodes DeclineCodeMap
+	environments Environments
+	available    atomic.Bool
+}
+
+// NewStripeProvider creates a Stripe provider. SetEnvironments must be called with the API keys
+// before it processes transactions.
+func NewStripeProvider(id int, name string, config StripeConfig) *StripeProvider {
+	if config.WebhookTolerance <= 0 {
+		config.WebhookTolerance = DefaultStripeWebhookTolerance
+	}
+
+	clientConfig := httpclient.DefaultConfig(name)
+	if config.Timeout > 0 {
+		clientConfig.Timeout = config.Timeout
+	}
+
+	p := &StripeProvider{
+		id:           strconv.Itoa(id),
+		name:         name,
+		config:       config,
+		client:       httpclient.New(clientConfig),
+		declineCodes: StripeDeclineCodes,
+	}
+	p.SetEnvironments(Environments{Sandbox: Environment{Name: EnvironmentSandbox}})
+	p.available.Store(true)
+	return p
+}
+
+// SetEnvironments configures the sandbox and production environments; environments without a
+// base URL use Stripe's API
+func (p *StripeProvider) SetEnvironments(environments Environments) {
+	if environments.Sandbox.BaseURL == "" {
+		environments.Sandbox.BaseURL = StripeAPIURL
+	}
+	if environments.Production != nil && environments.Production.BaseURL == "" {
+		production := *environments.Production
+		production.BaseURL = StripeAPIURL
+		environments.Production = &production
+	}
+	p.environments = environments
+}
+
+// ID returns the unique identifier of the gateway
+func (p *StripeProvider) ID() string {
+	return p.id
+}
+
+// Name returns the name of the gateway
+func (p *StripeProvider) Name() string {
+	return p.name
+}
+
+// DataFormat returns the data format supported by the gateway
+func (p *StripeProvider) DataFormat() string {
+	return "application/json"
+}
+
+// IsAvailable reports whether the last request reached Stripe
+func (p *StripeProvider) IsAvailable() bool {
+	return p.available.Load()
+}
+
+// ProcessDeposit creates a PaymentIntent. The response carries its client secret, which the
+// merchant's page confirms the payment with; the outcome arrives as a webhook event.
+func (p *StripeProvider) ProcessDeposit(ctx context.Context, transaction models.Transaction) (*models.TransactionResponse, error) {
+	return p.createPaymentIntent(ctx, transaction, p.form(transaction))
+}
+
+// Authorize creates a PaymentIntent captured manually. Once the merchant's page confirms it,
+// Stripe holds the amount and reports the deposit authorized by a webhook event. Capturing in
+// parts is requested wherever the card allows it; deposits asking for incremental authorization
+// request it from the card's issuer if it offers it.
+func (p *StripeProvider) Authorize(ctx context.Context, transaction models.Transaction) (*models.TransactionResponse, error) {
+	form := p.form(transaction)
+	form.Set("capture_method", "manual")
+	form.Set("payment_method_options[card][request_multicapture]", "if_available")
+	if transaction.IncrementalAuthorization {
+		form.Set("payment_method_options[card][request_incremental_authorization]", "if_available")
+	}
+	return p.createPaymentIntent(ctx, transaction, form)
+}
+
+// Capture captures part or all of an authorized PaymentIntent; Stripe releases the rest
+func (p *StripeProvider) Capture(ctx context.Context, transaction models.Transaction, amount float64) (*models.TransactionResponse, error) {
+	form := url.Values{}
+	form.Set("amount_to_capture", strconv.FormatInt(minorUnits(amount, transaction.Currency), 10))
+	return p.settleAuthorization(ctx, transaction, "capture", form)
+}
+
+// CapturePart captures part of an authorized PaymentIntent and keeps it open for further
+// captures. Stripe rejects it for PaymentIntents whose card doesn't allow capturing in parts.
+func (p *StripeProvider) CapturePart(ctx context.Context, transaction models.Transaction, amount float64) (*models.TransactionResponse, error) {
+	form := url.Values{}
+	form.Set("amount_to_capture", strconv.FormatInt(minorUnits(amount, transaction.Currency), 10))
+	form.Set("final_capture", "false")
+	response, err := p.settleAuthorization(ctx, transaction, "capture", form)
+	if err != nil {
+		return nil, err
+	}
+	// The PaymentIntent stays capturable for the rest; the part itself was captured
+	if response.Status == consts.Authorized {
+		response.Status = consts.Completed
+	}
+	return response, nil
+}
+
+// Void cancels an authorized PaymentIntent, releasing its amount
+func (p *StripeProvider) Void(ctx context.Context, transaction models.Transaction) (*models.TransactionResponse, error) {
+	return p.settleAuthorization(ctx, transaction, "cancel", url.Values{})
+}
+
+// IncrementAuthorization raises the amount of an authorized PaymentIntent to amount. Stripe
+// rejects it for PaymentIntents whose issuer didn't allow incremental authorization.
+func (p *StripeProvider) IncrementAuthorization(ctx context.Context, transaction models.Transaction, amount float64) (*models.TransactionResponse, error) {
+	form := url.Values{}
+	form.Set("amount", strconv.FormatInt(minorUnits(amount, transaction.Currency), 10))
+	response, err := p.settleAuthorization(ctx, transaction, "increment_authorization", form)
+	if err != nil {
+		return nil, err
+	}
+	if response.Status == consts.Authorized {
+		response.AuthorizedAmount = amount
+	}
+	return response, nil
+}
+
+// AuthorizationValidity returns how long Stripe holds an authorized card payment
+func (p *StripeProvider) AuthorizationValidity() time.Duration {
+	return consts.DefaultAuthorizationValidity
+}
+
+// settleAuthorization captures, cancels or increments an authorized PaymentIntent
+func (p *StripeProvider) settleAuthorization(ctx context.Context, transaction models.Transaction, action string, form url.Values) (*models.TransactionResponse, error) {
+	if transaction.GatewayReference == "" {
+		return nil, fmt.Errorf("transaction %d has no Stripe reference", transaction.ID)
+	}
+
+	var intent stripeObject
+	if err := p.request(ctx, transaction, http.MethodPost, "/v1/payment_intents/"+url.PathEscape(transaction.GatewayReference)+"/"+action, form, &intent); err != nil {
+		return nil, err
+	}
+
+	response := &models.TransactionResponse{
+		Status:           consts.Processing,
+		TransactionID:    transaction.ID,
+		GatewayReference: intent.ID,
+	}
+	switch intent.Status {
+	case "succeeded":
+		response.Status = consts.Completed
+	case "canceled":
+		response.Status = consts.Voided
+	case "requires_capture":
+		response.Status = consts.Authorized
+	}
+	return response, nil
+}
+
+// createPaymentIntent creates a PaymentIntent of a deposit with the payment methods configured
+func (p *StripeProvider) createPaymentIntent(ctx context.Context, transaction models.Transaction, form url.Values) (*models.TransactionResponse, error) {
+	if len(p.config.PaymentMethodTypes) == 0 {
+		form.Set("automatic_payment_methods[enabled]", "true")
+	}
+	for i, method := range p.config.PaymentMethodTypes {
+		form.Set(fmt.Sprintf("payment_method_types[%d]", i), method)
+	}
+
+	var intent stripeObject
+	if err := p.request(ctx, transaction, http.MethodPost, "/v1/payment_intents", form, &intent); err != nil {
+		return nil, err
+	}
+
+	response := &models.TransactionResponse{
+		Status:           consts.Processing,
+		TransactionID:    transaction.ID,
+		GatewayReference: intent.ID,
+		ClientSecret:     intent.ClientSecret,
+	}
+	switch intent.Status {
+	case "succeeded":
+		response.Status = consts.Completed
+	case "requires_capture":
+		response.Status = consts.Authorized
+	case "requires_action":
+		if intent.NextAction != nil && intent.NextAction.RedirectToURL != nil {
+			response.RedirectURL = intent.NextAction.RedirectToURL.URL
+		}
+	}
+	return response, nil
+}
+
+// ProcessWithdrawal creates a payout to the transaction's beneficiary, the ID of a bank account
+// or debit card attached to the Stripe account, or to the account's default one when none is set
+func (p *StripeProvider) ProcessWithdrawal(ctx context.Context, transaction models.Transaction) (*models.TransactionResponse, error) {
+	form := p.form(transaction)
+	if transaction.Beneficiary != "" {
+		form.Set("destination", transaction.Beneficiary)
+	}
+
+	var payout stripeObject
+	if err := p.request(ctx, transaction, http.MethodPost, "/v1/payouts", form, &payout); err != nil {
+		return nil, err
+	}
+
+	response := &models.TransactionResponse{
+		Status:           consts.Processing,
+		TransactionID:    transaction.ID,
+		GatewayReference: payout.ID,
+	}
+	if payout.Status == "paid" {
+		response.Status = consts.Completed
+	}
+	return response, nil
+}
+
+// ProcessRefund refunds part or all of a deposit's PaymentIntent. Card refunds usually succeed
+// at once; refunds Stripe reports pending stay processing.
+func (p *StripeProvider) ProcessRefund(ctx context.Context, refund models.Refund, transaction models.Transaction) (*models.TransactionResponse, error) {
+	if transaction.GatewayReference == "" {
+		return nil, fmt.Errorf("transaction %d has no Stripe reference", transaction.ID)
+	}
+
+	form := url.Values{}
+	form.Set("payment_intent", transaction.GatewayReference)
+	form.Set("amount", strconv.FormatInt(minorUnits(refund.Amount, refund.Currency), 10))
+	form.Set("metadata[transaction_id]", strconv.Itoa(transaction.ID))
+	form.Set("metadata[refund_id]", strconv.Itoa(refund.ID))
+
+	var object stripeObject
+	if err := p.request(ctx, refundTransaction(refund, transaction), http.MethodPost, "/v1/refunds", form, &object); err != nil {
+		return nil, err
+	}
+
+	response := &models.TransactionResponse{
+		Status:           consts.Processing,
+		TransactionID:    transaction.ID,
+		GatewayReference: object.ID,
+	}
+	switch object.Status {
+	case "succeeded":
+		response.Status = consts.Completed
+	case "failed", "canceled":
+		return nil, &DeclineError{Code: p.declineCodes.Normalize(object.FailureReason), ProviderCode: object.FailureReason, Message: "refund " + object.Status}
+	}
+	return response, nil
+}
+
+// ParseCallback verifies a webhook event's Stripe-Signature header and maps the event to the
+// transaction it settles. Signatures are checked against the time the callback was received, so
+// stored callbacks can be reparsed later.
+func (p *StripeProvider) ParseCallback(r *http.Request) (*models.CallbackData, error) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read callback: %w", err)
+	}
+	if err := p.verifySignature(body, r.Header.Get(StripeSignatureHeader), CallbackReceivedAt(r.Context())); err != nil {
+		return nil, err
+	}
+
+	var event stripeEvent
+	if err := json.Unmarshal(body, &event); err != nil {
+		return nil, fmt.Errorf("invalid Stripe event: %w", err)
+	}
+	object := event.Data.Object
+
+	callbackData := &models.CallbackData{
+		GatewayReference: object.ID,
+		GatewayID:        p.id,
+		Timestamp:        time.Unix(event.Created, 0).UTC().Format(time.RFC3339),
+	}
+	if id := object.Metadata["transaction_id"]; id != "" {
+		if callbackData.TransactionID, err = strconv.Atoi(id); err != nil {
+			return nil, fmt.Errorf("invalid transaction ID %q in Stripe event %s", id, event.ID)
+		}
+	}
+
+	switch event.Type {
+	case "payment_intent.succeeded", "payout.paid":
+		callbackData.Status = consts.Completed
+	case "payment_intent.processing":
+		callbackData.Status = consts.Processing
+	case "payment_intent.amount_capturable_updated":
+		callbackData.Status = consts.Authorized
+	case "payment_intent.payment_failed":
+		callbackData.Status = consts.Failed
+		if object.LastPaymentError != nil {
+			callbackData.ReasonCode = object.LastPaymentError.providerCode()
+			callbackData.Message = object.LastPaymentError.Message
+		}
+	case "payout.failed":
+		callbackData.Status = consts.Failed
+		callbackData.ReasonCode = object.FailureCode
+		callbackData.Message = object.FailureMessage
+	case "payment_intent.canceled", "payout.canceled":
+		callbackData.Status = consts.Failed
+		callbackData.Message = "canceled"
+		// Manually captured PaymentIntents are canceled when voided or when their authorization lapsed
+		if object.CaptureMethod == "manual" {
+			callbackData.Status = consts.Voided
+		}
+	default:
+		return nil, fmt.Errorf("unsupported Stripe event type %q", event.Type)
+	}
+
+	if callbackData.ReasonCode != "" {
+		callbackData.DeclineCode = p.declineCodes.Normalize(callbackData.ReasonCode)
+	}
+	return callbackData, nil
+}
+
+// QueryTransactionStatus retrieves the PaymentIntent of a deposit or the payout of a withdrawal
+// and reports its status. PaymentIntents waiting for the customer, including after a failed
+// attempt they may retry, are still processing.
+func (p *StripeProvider) QueryTransactionStatus(ctx context.Context, transaction models.Transaction) (*models.CallbackData, error) {
+	if transaction.GatewayReference == "" {
+		return nil, fmt.Errorf("transaction %d has no Stripe reference", transaction.ID)
+	}
+	path := "/v1/payment_intents/"
+	if transaction.Type == consts.Withdrawal {
+		path = "/v1/payouts/"
+	}
+
+	var object stripeObject
+	if err := p.request(ctx, transaction, http.MethodGet, path+url.PathEscape(transaction.GatewayReference), nil, &object); err != nil {
+		return nil, err
+	}
+
+	callbackData := &models.CallbackData{
+		TransactionID:    transaction.ID,
+		Status:           consts.Processing,
+		GatewayReference: object.ID,
+		GatewayID:        p.id,
+		Timestamp:        time.Now().UTC().Format(time.RFC3339),
+	}
+	switch object.Status {
+	case "succeeded", "paid":
+		callbackData.Status = consts.Completed
+	case "requires_capture":
+		callbackData.Status = consts.Authorized
+	case "failed":
+		callbackData.Status = consts.Failed
+		callbackData.ReasonCode = object.FailureCode
+		callbackData.Message = object.FailureMessage
+	case "canceled":
+		callbackData.Status = consts.Failed
+		callbackData.Message = "canceled"
+		if object.CaptureMethod == "manual" {
+			callbackData.Status = consts.Voided
+		}
+	}
+
+	if callbackData.ReasonCode != "" {
+		callbackData.DeclineCode = p.declineCodes.Normalize(callbackData.ReasonCode)
+	}
+	return callbackData, nil
+}
+
+// form returns the parameters PaymentIntents and payouts share
+func (p *StripeProvider) form(transaction models.Transaction) url.Values {
+	form := url.Values{}
+	form.Set("amount", strconv.FormatInt(minorUnits(transaction.Amount, transaction.Currency), 10))
+	form.Set("currency", strings.ToLower(transaction.Currency))
+	form.Set("metadata[transaction_id]", strconv.Itoa(transaction.ID))
+	if transaction.ReferenceID != "" {
+		form.Set("metadata[reference_id]", transaction.ReferenceID)
+	}
+	return form
+}
+
+// request sends a request to the transaction's environment, form-encoded when it has a form, and
+// decodes the response. Card errors are returned as declines.
+func (p *StripeProvider) request(ctx context.Context, transaction models.Transaction, method, path string, form url.Values, out interface{}) error {
+	env, err := p.environments.Select(transaction.Livemode)
+	if err != nil {
+		return err
+	}
+
+	var body io.Reader
+	if form != nil {
+		body = strings.NewReader(form.Encode())
+	}
+	req, err := http.NewRequestWithContext(ctx, method, strings.TrimSuffix(env.BaseURL, "/")+path, body)
+	if err != nil {
+		return fmt.Errorf("failed to build Stripe request: %w", err)
+	}
+	req.Header.Set("Authorization", "Bearer "+env.APIKey)
+	req.Header.Set("Stripe-Version", StripeAPIVersion)
+	if form != nil {
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	}
+	if method == http.MethodPost && transaction.GatewayIdempotencyKey != "" {
+		req.Header.Set(httpclient.IdempotencyKeyHeader, transaction.GatewayIdempotencyKey)
+	}
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		p.available.Store(false)
+		return fmt.Errorf("stripe request failed: %w", err)
+	}
+	defer resp.Body.Close()
+	p.available.Store(resp.StatusCode < http.StatusInternalServerError)
+
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("failed to read Stripe response: %w", err)
+	}
+
+	if resp.StatusCode >= http.StatusBadRequest {
+		var failure struct {
+			Error stripeError `json:"error"`
+		}
+		if err := json.Unmarshal(respBody, &failure); err != nil || failure.Error.Type == "" {
+			return fmt.Errorf("stripe returned status %d", resp.StatusCode)
+		}
+		if failure.Error.Type == "card_error" {
+			code := failure.Error.providerCode()
+			return &DeclineError{Code: p.declineCodes.Normalize(code), ProviderCode: code, Message: failure.Error.Message}
+		}
+		return fmt.Errorf("stripe returned status %d: %s: %s", resp.StatusCode, failure.Error.Type, failure.Error.Message)
+	}
+
+	if err := json.Unmarshal(respBody, out); err != nil {
+		return fmt.Errorf("invalid Stripe response: %w", err)
+	}
+	return nil
+}
+
+// verifySignature checks a "t=<unix>,v1=<hex>" Stripe-Signature header: v1 is the HMAC-SHA256 of
+// "<t>.<body>" under one of the webhook secrets, and t must be within the tolerance of receivedAt
+func (p *StripeProvider) verifySignature(body []byte, header string, receivedAt time.Time) error {
+	if len(p.config.WebhookSecrets) == 0 {
+		return fmt.Errorf("%w: no Stripe webhook secret is configured", ErrInvalidCallbackSignature)
+	}
+
+	var timestamp string
+	var signatures []string
+	for _, part := range strings.Split(header, ",") {
+		key, value, _ := strings.Cut(strings.TrimSpace(part), "=")
+		switch key {
+		case "t":
+			timestamp = value
+		case "v1":
+			signatures = append(signatures, value)
+		}
+	}
+
+	seconds, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil || len(signatures) == 0 {
+		return fmt.Errorf("%w: malformed %s header", ErrInvalidCallbackSignature, StripeSignatureHeader)
+	}
+	signedAt := time.Unix(seconds, 0)
+	if age := receivedAt.Sub(signedAt); age > p.config.WebhookTolerance || age < -p.config.WebhookTolerance {
+		return fmt.Errorf("%w: timestamp is outside the tolerance", ErrInvalidCallbackSignature)
+	}
+
+	for _, secret := range p.config.WebhookSecrets {
+		mac := hmac.New(sha256.New, []byte(secret))
+		mac.Write([]byte(timestamp + "."))
+		mac.Write(body)
+		expected := hex.EncodeToString(mac.Sum(nil))
+
+		for _, signature := range signatures {
+			if hmac.Equal([]byte(expected), []byte(signature)) {
+				return nil
+			}
+		}
+	}
+	return ErrInvalidCallbackSignature
+}
+
+// stripeObject holds the fields read from PaymentIntents, payouts and refunds
+type stripeObject struct {
+	ID               string            `json:"id"`
+	Status           string            `json:"status"`
+	ClientSecret     string            `json:"client_secret"`
+	Metadata         map[string]string `json:"metadata"`
+	LastPaymentError *stripeError      `json:"last_payment_error"`
+	FailureCode      string            `json:"failure_code"`
+	FailureMessage   string            `json:"failure_message"`
+	FailureReason    string            `json:"failure_reason"` // refunds only
+	CaptureMethod    string            `json:"capture_method"` // PaymentIntents only
+	NextAction       *struct {
+		RedirectToURL *struct {
+			URL string `json:"url"`
+		} `json:"redirect_to_url"`
+	} `json:"next_action"`
+}
+
+// stripeEvent is a webhook event
+type stripeEvent struct {
+	ID      string `json:"id"`
+	Type    string `json:"type"`
+	Created int64  `json:"created"`
+	Data    struct {
+		Object stripeObject `json:"object"`
+	} `json:"data"`
+}
+
+// stripeError is an API error or a PaymentIntent's last payment error
+type stripeError struct {
+	Type        string `json:"type"`
+	Code        string `json:"code"`
+	DeclineCode string `json:"decline_code"`
+	Message     string `json:"message"`
+}
+
+// providerCode returns the issuer's decline code when Stripe passes one on, or the error code
+func (e *stripeError) providerCode() string {
+	if e.DeclineCode != "" {
+		return e.DeclineCode
+	}
+	return e.Code
+}
@@ -0,0 +1,379 @@
+package gateway
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"payment-gateway/internal/consts"
+	"payment-gateway/internal/models"
+	"testing"
+	"time"
+)
+
+const stripeTestWebhookSecret = "whsec_test"
+
+// fakeStripe answers API requests with the given status and body, recording each request's form
+func fakeStripe(t *testing.T, status int, body string) (*StripeProvider, <-chan *http.Request) {
+	requests := make(chan *http.Request, 10)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		requests <- r
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		fmt.Fprint(w, body)
+	}))
+	t.Cleanup(server.Close)
+
+	provider := NewStripeProvider(2, "Stripe", StripeConfig{WebhookSecrets: []string{"whsec_old", stripeTestWebhookSecret}})
+	provider.SetEnvironments(Environments{Sandbox: Environment{Name: EnvironmentSandbox, BaseURL: server.URL, APIKey: "sk_test_123"}})
+	return provider, requests
+}
+
+// signStripeEvent returns the Stripe-Signature header of a payload signed at the given time
+func signStripeEvent(secret string, payload []byte, at time.Time) string {
+	timestamp := fmt.Sprint(at.Unix())
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp + "."))
+	mac.Write(payload)
+	return "t=" + timestamp + ",v1=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+func TestStripeDeposit(t *testing.T) {
+	provider, requests := fakeStripe(t, http.StatusOK, `{"id":"pi_123","status":"requires_payment_method","client_secret":"pi_123_secret_abc"}`)
+
+	transaction := models.Transaction{ID: 42, Amount: 12.34, Currency: "USD", ReferenceID: "ref-42", GatewayIdempotencyKey: "idem-42"}
+	response, err := provider.ProcessDeposit(context.Background(), transaction)
+	if err != nil {
+		t.Fatalf("ProcessDeposit failed: %v", err)
+	}
+	if response.Status != consts.Processing || response.GatewayReference != "pi_123" || response.ClientSecret != "pi_123_secret_abc" {
+		t.Errorf("Unexpected response: %+v", response)
+	}
+
+	req := <-requests
+	if req.URL.Path != "/v1/payment_intents" {
+		t.Errorf("Expected a PaymentIntent to be created, got %s", req.URL.Path)
+	}
+	if got := req.Header.Get("Authorization"); got != "Bearer sk_test_123" {
+		t.Errorf("Expected the sandbox API key, got %q", got)
+	}
+	if got := req.Header.Get("Idempotency-Key"); got != "idem-42" {
+		t.Errorf("Expected the transaction's idempotency key, got %q", got)
+	}
+	expected := url.Values{
+		"amount":                             {"1234"},
+		"currency":                           {"usd"},
+		"metadata[transaction_id]":           {"42"},
+		"metadata[reference_id]":             {"ref-42"},
+		"automatic_payment_methods[enabled]": {"true"},
+	}
+	for field, value := range expected {
+		if got := req.PostForm.Get(field); got != value[0] {
+			t.Errorf("Expected %s=%s, got %q", field, value[0], got)
+		}
+	}
+}
+
+func TestStripeDepositCardError(t *testing.T) {
+	provider, _ := fakeStripe(t, http.StatusPaymentRequired, `{"error":{"type":"card_error","code":"card_declined","decline_code":"insufficient_funds","message":"Your card has insufficient funds."}}`)
+
+	_, err := provider.ProcessDeposit(context.Background(), models.Transaction{ID: 1, Amount: 10, Currency: "EUR"})
+	var decline *DeclineError
+	if !errors.As(err, &decline) {
+		t.Fatalf("Expected a decline, got %v", err)
+	}
+	if decline.Code != consts.DeclineInsufficientFunds || decline.ProviderCode != "insufficient_funds" {
+		t.Errorf("Unexpected decline: %+v", decline)
+	}
+}
+
+func TestStripeWithdrawal(t *testing.T) {
+	provider, requests := fakeStripe(t, http.StatusOK, `{"id":"po_123","status":"pending"}`)
+
+	response, err := provider.ProcessWithdrawal(context.Background(), models.Transaction{ID: 7, Amount: 500, Currency: "JPY", Beneficiary: "ba_123"})
+	if err != nil {
+		t.Fatalf("ProcessWithdrawal failed: %v", err)
+	}
+	if response.Status != consts.Processing || response.GatewayReference != "po_123" {
+		t.Errorf("Unexpected response: %+v", response)
+	}
+
+	req := <-requests
+	if req.URL.Path != "/v1/payouts" || req.PostForm.Get("destination") != "ba_123" || req.PostForm.Get("amount") != "500" {
+		t.Errorf("Unexpected payout request %s: %v", req.URL.Path, req.PostForm)
+	}
+}
+
+func TestStripeRefund(t *testing.T) {
+	provider, requests := fakeStripe(t, http.StatusOK, `{"id":"re_123","status":"succeeded"}`)
+
+	refund := models.Refund{ID: 5, Amount: 4.5, Currency: "USD", GatewayIdempotencyKey: "idem-refund-5"}
+	response, err := provider.ProcessRefund(context.Background(), refund, models.Transaction{ID: 42, Amount: 12.34, Currency: "USD", GatewayReference: "pi_123"})
+	if err != nil {
+		t.Fatalf("ProcessRefund failed: %v", err)
+	}
+	if response.Status != consts.Completed || response.GatewayReference != "re_123" {
+		t.Errorf("Unexpected response: %+v", response)
+	}
+
+	req := <-requests
+	if req.URL.Path != "/v1/refunds" || req.PostForm.Get("payment_intent") != "pi_123" || req.PostForm.Get("amount") != "450" || req.PostForm.Get("metadata[refund_id]") != "5" {
+		t.Errorf("Unexpected refund request %s: %v", req.URL.Path, req.PostForm)
+	}
+	if got := req.Header.Get("Idempotency-Key"); got != "idem-refund-5" {
+		t.Errorf("Expected the refund's idempotency key, got %q", got)
+	}
+}
+
+func TestStripeRefundFailed(t *testing.T) {
+	provider, _ := fakeStripe(t, http.StatusOK, `{"id":"re_123","status":"failed","failure_reason":"expired_or_canceled_card"}`)
+
+	_, err := provider.ProcessRefund(context.Background(), models.Refund{ID: 5, Amount: 4.5, Currency: "USD"}, models.Transaction{ID: 42, GatewayReference: "pi_123"})
+	var decline *DeclineError
+	if !errors.As(err, &decline) || decline.ProviderCode != "expired_or_canceled_card" {
+		t.Errorf("Expected a decline, got %v", err)
+	}
+}
+
+func TestStripeAuthorize(t *testing.T) {
+	provider, requests := fakeStripe(t, http.StatusOK, `{"id":"pi_123","status":"requires_capture"}`)
+
+	response, err := provider.Authorize(context.Background(), models.Transaction{ID: 42, Amount: 12.34, Currency: "USD", GatewayIdempotencyKey: "idem-auth-42"})
+	if err != nil {
+		t.Fatalf("Authorize failed: %v", err)
+	}
+	if response.Status != consts.Authorized || response.GatewayReference != "pi_123" {
+		t.Errorf("Unexpected response: %+v", response)
+	}
+
+	req := <-requests
+	if req.URL.Path != "/v1/payment_intents" || req.PostForm.Get("capture_method") != "manual" || req.PostForm.Get("amount") != "1234" {
+		t.Errorf("Unexpected authorization request %s: %v", req.URL.Path, req.PostForm)
+	}
+	if got := req.Header.Get("Idempotency-Key"); got != "idem-auth-42" {
+		t.Errorf("Expected the authorization's idempotency key, got %q", got)
+	}
+}
+
+func TestStripeCaptureAndVoid(t *testing.T) {
+	provider, requests := fakeStripe(t, http.StatusOK, `{"id":"pi_123","status":"succeeded"}`)
+
+	transaction := models.Transaction{ID: 42, Amount: 12.34, Currency: "USD", GatewayReference: "pi_123", GatewayIdempotencyKey: "idem-capture-42"}
+	response, err := provider.Capture(context.Background(), transaction, 10)
+	if err != nil {
+		t.Fatalf("Capture failed: %v", err)
+	}
+	if response.Status != consts.Completed || response.TransactionID != 42 {
+		t.Errorf("Unexpected response: %+v", response)
+	}
+	req := <-requests
+	if req.URL.Path != "/v1/payment_intents/pi_123/capture" || req.PostForm.Get("amount_to_capture") != "1000" {
+		t.Errorf("Unexpected capture request %s: %v", req.URL.Path, req.PostForm)
+	}
+	if got := req.Header.Get("Idempotency-Key"); got != "idem-capture-42" {
+		t.Errorf("Expected the capture's idempotency key, got %q", got)
+	}
+
+	provider, requests = fakeStripe(t, http.StatusOK, `{"id":"pi_123","status":"canceled"}`)
+	response, err = provider.Void(context.Background(), transaction)
+	if err != nil {
+		t.Fatalf("Void failed: %v", err)
+	}
+	if response.Status != consts.Voided {
+		t.Errorf("Expected a voided PaymentIntent, got %+v", response)
+	}
+	if req := <-requests; req.URL.Path != "/v1/payment_intents/pi_123/cancel" {
+		t.Errorf("Expected the PaymentIntent to be canceled, got %s", req.URL.Path)
+	}
+
+	if _, err := provider.Capture(context.Background(), models.Transaction{ID: 7}, 10); err == nil {
+		t.Error("Expected an error capturing a deposit without a Stripe reference")
+	}
+}
+
+func TestStripeIncrementAuthorization(t *testing.T) {
+	provider, requests := fakeStripe(t, http.StatusOK, `{"id":"pi_123","status":"requires_capture"}`)
+
+	if _, err := provider.Authorize(context.Background(), models.Transaction{ID: 42, Amount: 12.34, Currency: "USD", IncrementalAuthorization: true}); err != nil {
+		t.Fatalf("Authorize failed: %v", err)
+	}
+	if req := <-requests; req.PostForm.Get("payment_method_options[card][request_incremental_authorization]") != "if_available" {
+		t.Errorf("Expected incremental authorization requested, got %v", req.PostForm)
+	}
+
+	transaction := models.Transaction{ID: 42, Amount: 12.34, Currency: "USD", GatewayReference: "pi_123", GatewayIdempotencyKey: "idem-increment-42"}
+	response, err := provider.IncrementAuthorization(context.Background(), transaction, 20)
+	if err != nil {
+		t.Fatalf("IncrementAuthorization failed: %v", err)
+	}
+	if response.Status != consts.Authorized || response.AuthorizedAmount != 20 {
+		t.Errorf("Unexpected response: %+v", response)
+	}
+	req := <-requests
+	if req.URL.Path != "/v1/payment_intents/pi_123/increment_authorization" || req.PostForm.Get("amount") != "2000" {
+		t.Errorf("Unexpected increment request %s: %v", req.URL.Path, req.PostForm)
+	}
+	if got := req.Header.Get("Idempotency-Key"); got != "idem-increment-42" {
+		t.Errorf("Expected the increment's idempotency key, got %q", got)
+	}
+}
+
+func TestStripeCapturePart(t *testing.T) {
+	provider, requests := fakeStripe(t, http.StatusOK, `{"id":"pi_123","status":"requires_capture"}`)
+
+	if _, err := provider.Authorize(context.Background(), models.Transaction{ID: 42, Amount: 12.34, Currency: "USD"}); err != nil {
+		t.Fatalf("Authorize failed: %v", err)
+	}
+	if req := <-requests; req.PostForm.Get("payment_method_options[card][request_multicapture]") != "if_available" {
+		t.Errorf("Expected capturing in parts requested, got %v", req.PostForm)
+	}
+
+	transaction := models.Transaction{ID: 42, Amount: 12.34, Currency: "USD", GatewayReference: "pi_123", GatewayIdempotencyKey: "idem-partial-capture-42"}
+	response, err := provider.CapturePart(context.Background(), transaction, 5)
+	if err != nil {
+		t.Fatalf("CapturePart failed: %v", err)
+	}
+	if response.Status != consts.Completed || response.GatewayReference != "pi_123" {
+		t.Errorf("Expected the part captured, got %+v", response)
+	}
+	req := <-requests
+	if req.URL.Path != "/v1/payment_intents/pi_123/capture" || req.PostForm.Get("amount_to_capture") != "500" || req.PostForm.Get("final_capture") != "false" {
+		t.Errorf("Unexpected partial capture request %s: %v", req.URL.Path, req.PostForm)
+	}
+	if got := req.Header.Get("Idempotency-Key"); got != "idem-partial-capture-42" {
+		t.Errorf("Expected the partial capture's idempotency key, got %q", got)
+	}
+}
+
+func TestStripeQueryTransactionStatus(t *testing.T) {
+	provider, requests := fakeStripe(t, http.StatusOK, `{"id":"pi_123","status":"succeeded"}`)
+
+	data, err := provider.QueryTransactionStatus(context.Background(), models.Transaction{ID: 42, Type: consts.Deposit, GatewayReference: "pi_123", GatewayIdempotencyKey: "idem-42"})
+	if err != nil {
+		t.Fatalf("QueryTransactionStatus failed: %v", err)
+	}
+	if data.Status != consts.Completed || data.TransactionID != 42 || data.GatewayReference != "pi_123" || data.GatewayID != "2" {
+		t.Errorf("Unexpected status: %+v", data)
+	}
+	req := <-requests
+	if req.Method != http.MethodGet || req.URL.Path != "/v1/payment_intents/pi_123" || req.Header.Get("Idempotency-Key") != "" {
+		t.Errorf("Unexpected request %s %s with headers %v", req.Method, req.URL.Path, req.Header)
+	}
+
+	provider, requests = fakeStripe(t, http.StatusOK, `{"id":"po_123","status":"failed","failure_code":"account_closed","failure_message":"The bank account has been closed."}`)
+	data, err = provider.QueryTransactionStatus(context.Background(), models.Transaction{ID: 7, Type: consts.Withdrawal, GatewayReference: "po_123"})
+	if err != nil {
+		t.Fatalf("QueryTransactionStatus failed: %v", err)
+	}
+	if data.Status != consts.Failed || data.ReasonCode != "account_closed" || data.Message != "The bank account has been closed." {
+		t.Errorf("Unexpected status: %+v", data)
+	}
+	if req := <-requests; req.URL.Path != "/v1/payouts/po_123" {
+		t.Errorf("Expected the payout to be retrieved, got %s", req.URL.Path)
+	}
+
+	// A PaymentIntent the customer may still pay stays processing
+	provider, _ = fakeStripe(t, http.StatusOK, `{"id":"pi_123","status":"requires_payment_method"}`)
+	if data, err := provider.QueryTransactionStatus(context.Background(), models.Transaction{ID: 42, Type: consts.Deposit, GatewayReference: "pi_123"}); err != nil || data.Status != consts.Processing {
+		t.Errorf("Expected the deposit still processing, got %+v (%v)", data, err)
+	}
+	if _, err := provider.QueryTransactionStatus(context.Background(), models.Transaction{ID: 43, Type: consts.Deposit}); err == nil {
+		t.Error("Expected an error for a transaction without a Stripe reference")
+	}
+}
+
+func TestStripeDepositWithoutLiveEnvironment(t *testing.T) {
+	provider, _ := fakeStripe(t, http.StatusOK, `{}`)
+
+	_, err := provider.ProcessDeposit(context.Background(), models.Transaction{ID: 1, Amount: 10, Currency: "USD", Livemode: true})
+	if !errors.Is(err, ErrNoProductionEnvironment) {
+		t.Errorf("Expected livemode deposits to need a production environment, got %v", err)
+	}
+}
+
+func TestStripeParseCallback(t *testing.T) {
+	provider, _ := fakeStripe(t, http.StatusOK, `{}`)
+	payload := []byte(`{"id":"evt_1","type":"payment_intent.payment_failed","created":1700000000,"data":{"object":{"id":"pi_123","metadata":{"transaction_id":"42"},"last_payment_error":{"type":"card_error","code":"card_declined","decline_code":"stolen_card","message":"Your card was declined."}}}}`)
+	now := time.Now()
+
+	tests := []struct {
+		name       string
+		signature  string
+		receivedAt time.Time
+		wantErr    bool
+	}{
+		{"valid", signStripeEvent(stripeTestWebhookSecret, payload, now), now, false},
+		{"rolled secret", signStripeEvent("whsec_old", payload, now), now, false},
+		{"reparsed later", signStripeEvent(stripeTestWebhookSecret, payload, now.Add(-time.Hour)), now.Add(-time.Hour), false},
+		{"wrong secret", signStripeEvent("whsec_other", payload, now), now, true},
+		{"too old", signStripeEvent(stripeTestWebhookSecret, payload, now.Add(-10*time.Minute)), now, true},
+		{"missing", "", now, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/callback/2", bytes.NewReader(payload))
+			req = req.WithContext(WithCallbackReceivedAt(req.Context(), tt.receivedAt))
+			req.Header.Set(StripeSignatureHeader, tt.signature)
+
+			data, err := provider.ParseCallback(req)
+			if tt.wantErr {
+				if !errors.Is(err, ErrInvalidCallbackSignature) {
+					t.Errorf("Expected an invalid signature, got %v", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ParseCallback failed: %v", err)
+			}
+			if data.TransactionID != 42 || data.Status != consts.Failed || data.GatewayReference != "pi_123" ||
+				data.ReasonCode != "stolen_card" || data.DeclineCode != consts.DeclineFraudSuspected {
+				t.Errorf("Unexpected callback data: %+v", data)
+			}
+		})
+	}
+}
+
+func TestStripeParseCallbackEventTypes(t *testing.T) {
+	provider, _ := fakeStripe(t, http.StatusOK, `{}`)
+
+	tests := []struct {
+		eventType string
+		status    string
+	}{
+		{"payment_intent.succeeded", consts.Completed},
+		{"payment_intent.processing", consts.Processing},
+		{"payment_intent.canceled", consts.Failed},
+		{"payout.paid", consts.Completed},
+		{"payout.failed", consts.Failed},
+		{"charge.refunded", ""},
+	}
+
+	for _, tt := range tests {
+		payload := []byte(fmt.Sprintf(`{"id":"evt_1","type":%q,"data":{"object":{"id":"obj_1","metadata":{"transaction_id":"7"}}}}`, tt.eventType))
+		req := httptest.NewRequest(http.MethodPost, "/callback/2", bytes.NewReader(payload))
+		req.Header.Set(StripeSignatureHeader, signStripeEvent(stripeTestWebhookSecret, payload, time.Now()))
+
+		data, err := provider.ParseCallback(req)
+		if tt.status == "" {
+			if err == nil {
+				t.Errorf("Expected %s events to be unsupported", tt.eventType)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Failed to parse %s: %v", tt.eventType, err)
+			continue
+		}
+		if data.Status != tt.status || data.TransactionID != 7 {
+			t.Errorf("%s: unexpected callback data %+v", tt.eventType, data)
+		}
+	}
+}
@@ -0,0 +1,832 @@
+package gateway
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha512"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"net/url"
+	"payment-gateway/internal/consts"
+	"payment-gateway/internal/httpclient"
+	"payment-gateway/internal/models"
+	"payment-gateway/internal/money"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// TrueLayer API settings
+const (
+	TrueLayerSandboxURL        = "https://api.truelayer-sandbox.com"
+	TrueLayerProductionURL     = "https://api.truelayer.com"
+	TrueLayerAuthSandboxURL    = "https://auth.truelayer-sandbox.com"
+	TrueLayerAuthProductionURL = "https://auth.truelayer.com"
+
+	// TrueLayerSignatureHeader carries the detached ES512 JWS payout requests and webhooks are
+	// signed with
+	TrueLayerSignatureHeader = "Tl-Signature"
+
+	// trueLayerLinkTTL is how long users have to authorise an account link at their bank
+	trueLayerLinkTTL = 15 * time.Minute
+
+	// trueLayerTokenMargin is how long before its expiry an access token is renewed
+	trueLayerTokenMargin = time.Minute
+
+	// trueLayerJWKSRefresh is how often webhook signing keys are refetched for an unknown key ID
+	trueLayerJWKSRefresh = time.Minute
+
+	// trueLayerReferenceLength is the longest payout reference banks show the beneficiary
+	trueLayerReferenceLength = 18
+)
+
+// TrueLayerWebhookJWKSURLs are the key sets TrueLayer signs webhooks with. A webhook's signature
+// names its key set; keys are only fetched from these.
+var TrueLayerWebhookJWKSURLs = []string{
+	"https://webhooks.truelayer.com/.well-known/jwks",
+	"https://webhooks.truelayer-sandbox.com/.well-known/jwks",
+}
+
+// TrueLayerConfig configures the TrueLayer provider. The API key of each environment is the
+// TrueLayer app's "<client ID>:<client secret>".
+type TrueLayerConfig struct {
+	// MerchantAccountIDs are the merchant accounts payouts are paid from, by currency. Only
+	// currencies with a merchant account are paid out.
+	MerchantAccountIDs map[string]string
+
+	// SigningKey signs payout requests; its public key is uploaded to the TrueLayer console,
+	// which assigned it SigningKeyID. TrueLayer requires a P-521 key.
+	SigningKey   *ecdsa.PrivateKey
+	SigningKeyID string
+
+	// Providers filters the banks users can link accounts at; "uk-ob-all uk-oauth-all" by default
+	Providers string
+
+	// WebhookJWKSURLs overrides the key sets webhook signatures may name
+	WebhookJWKSURLs []string
+
+	Timeout time.Duration // per attempt; 30 seconds when zero
+}
+
+// TrueLayerProvider is a gateway adapter for TrueLayer, an open banking aggregator. Users link
+// the accounts withdrawals are paid out to by letting TrueLayer read them at their bank (AIS), so
+// payouts only go to accounts in the user's own name; withdrawals are then paid out from the
+// merchant account to the linked account and settle by webhook. TrueLayer takes no deposits here.
+type TrueLayerProvider struct {
+	id           string
+	name         string
+	config       TrueLayerConfig
+	client       *httpclient.Client
+	declineCodes DeclineCodeMap
+	environments Environments
+	available    atomic.Bool
+
+	tokenMu sync.Mutex
+	tokens  map[string]trueLayerToken // payout access tokens by environment name
+
+	jwksMu sync.Mutex
+	jwks   map[string]*trueLayerKeySet // webhook signing keys by key set URL
+}
+
+// trueLayerToken is a client credentials access token and when it expires
+type trueLayerToken struct {
+	value     string
+	expiresAt time.Time
+}
+
+// trueLayerKeySet is a fetched webhook key set
+type trueLayerKeySet struct {
+	keys      map[string]*ecdsa.PublicKey // by key ID
+	fetchedAt time.Time
+}
+
+// NewTrueLayerProvider creates a TrueLayer provider. SetEnvironments must be called with the
+// TrueLayer app's credentials before it links accounts or pays out.
+func NewTrueLayerProvider(id int, name string, config TrueLayerConfig) *TrueLayerProvider {
+	if config.Providers == "" {
+		config.Providers = "uk-ob-all uk-oauth-all"
+	}
+	if len(config.WebhookJWKSURLs) == 0 {
+		config.WebhookJWKSURLs = TrueLayerWebhookJWKSURLs
+	}
+
+	clientConfig := httpclient.DefaultConfig(name)
+	if config.Timeout > 0 {
+		clientConfig.Timeout = config.Timeout
+	}
+
+	p := &TrueLayerProvider{
+		id:           strconv.Itoa(id),
+		name:         name,
+		config:       config,
+		client:       httpclient.New(clientConfig),
+		declineCodes: TrueLayerDeclineCodes,
+		tokens:       make(map[string]trueLayerToken),
+		jwks:         make(map[string]*trueLayerKeySet),
+	}
+	p.SetEnvironments(Environments{Sandbox: Environment{Name: EnvironmentSandbox}})
+	p.available.Store(true)
+	return p
+}
+
+// SetEnvironments configures the sandbox and production environments; environments without a
+// base URL use TrueLayer's sandbox and production APIs. An environment's base URL serves both its
+// API and its auth server when set.
+func (p *TrueLayerProvider) SetEnvironments(environments Environments) {
+	if environments.Sandbox.BaseURL == "" {
+		environments.Sandbox.BaseURL = TrueLayerSandboxURL
+	}
+	if environments.Production != nil && environments.Production.BaseURL == "" {
+		production := *environments.Production
+		production.BaseURL = TrueLayerProductionURL
+		environments.Production = &production
+	}
+	p.environments = environments
+
+	p.tokenMu.Lock()
+	p.tokens = make(map[string]trueLayerToken)
+	p.tokenMu.Unlock()
+}
+
+// ID returns the unique identifier of the gateway
+func (p *TrueLayerProvider) ID() string {
+	return p.id
+}
+
+// Name returns the name of the gateway
+func (p *TrueLayerProvider) Name() string {
+	return p.name
+}
+
+// DataFormat returns the data format supported by the gateway
+func (p *TrueLayerProvider) DataFormat() string {
+	return "application/json"
+}
+
+// IsAvailable reports whether the last request reached TrueLayer
+func (p *TrueLayerProvider) IsAvailable() bool {
+	return p.available.Load()
+}
+
+// PaymentMethods returns no deposit payment methods; TrueLayer only pays withdrawals out
+func (p *TrueLayerProvider) PaymentMethods() []string {
+	return []string{}
+}
+
+// SupportsCurrency reports whether payouts in a currency have a merchant account to be paid from
+func (p *TrueLayerProvider) SupportsCurrency(currency string) bool {
+	return p.config.MerchantAccountIDs[currency] != ""
+}
+
+// ProcessDeposit refuses deposits, which this provider does not take
+func (p *TrueLayerProvider) ProcessDeposit(ctx context.Context, transaction models.Transaction) (*models.TransactionResponse, error) {
+	return nil, fmt.Errorf("%s: deposits are not supported", p.name)
+}
+
+// ProcessWithdrawal refuses withdrawals without a linked account; they are paid out with PayOut
+func (p *TrueLayerProvider) ProcessWithdrawal(ctx context.Context, transaction models.Transaction) (*models.TransactionResponse, error) {
+	return nil, fmt.Errorf("%s: withdrawals must be paid out to a linked bank account", p.name)
+}
+
+// CreateAccountLink returns TrueLayer's auth link, on which the user picks their bank and lets
+// TrueLayer read their identity and accounts
+func (p *TrueLayerProvider) CreateAccountLink(ctx context.Context, livemode bool, redirectURI, state string) (*AccountLink, error) {
+	env, err := p.environments.Select(livemode)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", p.name, err)
+	}
+	clientID, _, err := trueLayerCredentials(env)
+	if err != nil {
+		return nil, err
+	}
+
+	params := url.Values{}
+	params.Set("response_type", "code")
+	params.Set("client_id", clientID)
+	params.Set("scope", "info accounts offline_access")
+	params.Set("redirect_uri", redirectURI)
+	params.Set("providers", p.config.Providers)
+	params.Set("state", state)
+
+	return &AccountLink{
+		AuthorisationURL: trueLayerAuthURL(env) + "/?" + params.Encode(),
+		ExpiresAt:        time.Now().Add(trueLayerLinkTTL),
+	}, nil
+}
+
+// LinkAccounts exchanges an authorisation code for the user's access and refresh tokens, and reads
+// the accounts they hold with the name their bank knows them by
+func (p *TrueLayerProvider) LinkAccounts(ctx context.Context, livemode bool, authorisationCode, redirectURI string) (*AccountAccess, error) {
+	if authorisationCode == "" {
+		return nil, errors.New("authorisation code is required")
+	}
+	env, err := p.environments.Select(livemode)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", p.name, err)
+	}
+
+	form := url.Values{}
+	form.Set("grant_type", "authorization_code")
+	form.Set("code", authorisationCode)
+	form.Set("redirect_uri", redirectURI)
+	token, err := p.requestToken(ctx, env, form)
+	if err != nil {
+		return nil, err
+	}
+
+	var info struct {
+		Results []struct {
+			FullName string `json:"full_name"`
+		} `json:"results"`
+	}
+	if err := p.get(ctx, env, "/data/v1/info", token.AccessToken, &info); err != nil {
+		return nil, err
+	}
+	if len(info.Results) == 0 || info.Results[0].FullName == "" {
+		return nil, errors.New("truelayer returned no account holder name")
+	}
+
+	var accounts struct {
+		Results []trueLayerAccount `json:"results"`
+	}
+	if err := p.get(ctx, env, "/data/v1/accounts", token.AccessToken, &accounts); err != nil {
+		return nil, err
+	}
+
+	access := &AccountAccess{
+		AccessToken:    token.AccessToken,
+		RefreshToken:   token.RefreshToken,
+		TokenExpiresAt: time.Now().Add(time.Duration(token.ExpiresIn) * time.Second),
+	}
+	for _, account := range accounts.Results {
+		linked := models.BankAccount{
+			ExternalID:    account.AccountID,
+			BankName:      account.Provider.DisplayName,
+			HolderName:    info.Results[0].FullName,
+			Currency:      account.Currency,
+			SortCode:      strings.ReplaceAll(account.AccountNumber.SortCode, "-", ""),
+			AccountNumber: account.AccountNumber.Number,
+			IBAN:          account.AccountNumber.IBAN,
+		}
+		// Accounts payouts cannot be addressed to are left out
+		if linked.IBAN == "" && (linked.SortCode == "" || linked.AccountNumber == "") {
+			continue
+		}
+		access.Accounts = append(access.Accounts, linked)
+	}
+	return access, nil
+}
+
+// PayOut pays a withdrawal from the merchant account of its currency to a linked account, by
+// sort code and account number when the account has them and by IBAN otherwise. The outcome
+// arrives as a payout_executed or payout_failed webhook.
+func (p *TrueLayerProvider) PayOut(ctx context.Context, transaction models.Transaction, account models.BankAccount) (*models.TransactionResponse, error) {
+	merchantAccountID := p.config.MerchantAccountIDs[transaction.Currency]
+	if merchantAccountID == "" {
+		return nil, fmt.Errorf("%s: no merchant account pays out %s", p.name, transaction.Currency)
+	}
+	if account.Currency != "" && account.Currency != transaction.Currency {
+		return nil, fmt.Errorf("%s: bank account %d holds %s, not %s", p.name, account.ID, account.Currency, transaction.Currency)
+	}
+
+	identifier := trueLayerAccountIdentifier{Type: "iban", IBAN: account.IBAN}
+	if account.SortCode != "" && account.AccountNumber != "" {
+		identifier = trueLayerAccountIdentifier{Type: "sort_code_account_number", SortCode: account.SortCode, AccountNumber: account.AccountNumber}
+	}
+
+	request := trueLayerPayoutRequest{
+		MerchantAccountID: merchantAccountID,
+		AmountInMinor:     money.MinorUnits(transaction.Amount, transaction.Currency),
+		Currency:          transaction.Currency,
+		Beneficiary: trueLayerBeneficiary{
+			Type:              "external_account",
+			AccountHolderName: account.HolderName,
+			AccountIdentifier: identifier,
+			Reference:         trueLayerReference(transaction),
+		},
+		Metadata: map[string]string{"transaction_id": strconv.Itoa(transaction.ID)},
+	}
+
+	var result struct {
+		ID string `json:"id"`
+	}
+	if err := p.postPayout(ctx, transaction, request, &result); err != nil {
+		return nil, err
+	}
+
+	return &models.TransactionResponse{
+		Status:           consts.Processing,
+		TransactionID:    transaction.ID,
+		GatewayReference: result.ID,
+		Message:          "Payout submitted",
+	}, nil
+}
+
+// ProcessRefund refuses refunds. TrueLayer takes no deposits here, so there is nothing to refund.
+func (p *TrueLayerProvider) ProcessRefund(ctx context.Context, refund models.Refund, transaction models.Transaction) (*models.TransactionResponse, error) {
+	return nil, fmt.Errorf("%s: %w", p.name, ErrRefundUnsupported)
+}
+
+// ParseCallback verifies a payout webhook's signature and maps payout_executed and payout_failed
+// to the outcome of the withdrawal
+func (p *TrueLayerProvider) ParseCallback(r *http.Request) (*models.CallbackData, error) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read TrueLayer webhook: %w", err)
+	}
+	if err := p.verifyWebhook(r.Context(), r, body); err != nil {
+		return nil, err
+	}
+
+	var event struct {
+		Type          string            `json:"type"`
+		EventID       string            `json:"event_id"`
+		PayoutID      string            `json:"payout_id"`
+		FailureReason string            `json:"failure_reason"`
+		Metadata      map[string]string `json:"metadata"`
+	}
+	if err := json.Unmarshal(body, &event); err != nil {
+		return nil, fmt.Errorf("invalid TrueLayer webhook: %w", err)
+	}
+
+	callbackData := &models.CallbackData{GatewayReference: event.PayoutID, GatewayID: p.id}
+	if id, err := strconv.Atoi(event.Metadata["transaction_id"]); err == nil {
+		callbackData.TransactionID = id
+	}
+
+	switch event.Type {
+	case "payout_executed":
+		callbackData.Status = consts.Completed
+	case "payout_failed":
+		callbackData.Status = consts.Failed
+		callbackData.ReasonCode = event.FailureReason
+		callbackData.DeclineCode = p.declineCodes.Normalize(event.FailureReason)
+		callbackData.Message = "payout failed: " + event.FailureReason
+	default:
+		return nil, fmt.Errorf("unsupported TrueLayer webhook %q", event.Type)
+	}
+	return callbackData, nil
+}
+
+// trueLayerTokenResponse is an access token granted by TrueLayer's auth server
+type trueLayerTokenResponse struct {
+	AccessToken  string `json:"access_token"`
+	RefreshToken string `json:"refresh_token"`
+	ExpiresIn    int    `json:"expires_in"`
+}
+
+// requestToken requests an access token from an environment's auth server with the app's
+// client credentials
+func (p *TrueLayerProvider) requestToken(ctx context.Context, env Environment, form url.Values) (*trueLayerTokenResponse, error) {
+	clientID, clientSecret, err := trueLayerCredentials(env)
+	if err != nil {
+		return nil, err
+	}
+	form.Set("client_id", clientID)
+	form.Set("client_secret", clientSecret)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, trueLayerAuthURL(env)+"/connect/token", strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, fmt.Errorf("failed to build TrueLayer token request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	body, status, err := p.do(req)
+	if err != nil {
+		return nil, err
+	}
+	var token trueLayerTokenResponse
+	if status != http.StatusOK || json.Unmarshal(body, &token) != nil || token.AccessToken == "" {
+		return nil, fmt.Errorf("truelayer token request returned status %d", status)
+	}
+	if token.ExpiresIn <= 0 {
+		token.ExpiresIn = 3600
+	}
+	return &token, nil
+}
+
+// payoutToken returns a cached client credentials token of an environment, requesting a new one
+// when it is missing or about to expire
+func (p *TrueLayerProvider) payoutToken(ctx context.Context, env Environment) (string, error) {
+	p.tokenMu.Lock()
+	defer p.tokenMu.Unlock()
+
+	if token, ok := p.tokens[env.Name]; ok && time.Now().Before(token.expiresAt) {
+		return token.value, nil
+	}
+
+	form := url.Values{}
+	form.Set("grant_type", "client_credentials")
+	form.Set("scope", "payments")
+	token, err := p.requestToken(ctx, env, form)
+	if err != nil {
+		return "", err
+	}
+
+	p.tokens[env.Name] = trueLayerToken{
+		value:     token.AccessToken,
+		expiresAt: time.Now().Add(time.Duration(token.ExpiresIn)*time.Second - trueLayerTokenMargin),
+	}
+	return token.AccessToken, nil
+}
+
+// get reads a Data API resource with a user's access token
+func (p *TrueLayerProvider) get(ctx context.Context, env Environment, path, accessToken string, out interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, strings.TrimSuffix(env.BaseURL, "/")+path, nil)
+	if err != nil {
+		return fmt.Errorf("failed to build TrueLayer request: %w", err)
+	}
+	req.Header.Set("Authorization", "Bearer "+accessToken)
+
+	body, status, err := p.do(req)
+	if err != nil {
+		return err
+	}
+	if status >= http.StatusBadRequest {
+		return trueLayerError(status, body)
+	}
+	if err := json.Unmarshal(body, out); err != nil {
+		return fmt.Errorf("invalid TrueLayer response: %w", err)
+	}
+	return nil
+}
+
+// postPayout sends a payout signed with the configured key. The transaction's idempotency key is
+// signed with it, so TrueLayer refuses a retried payout as a duplicate.
+func (p *TrueLayerProvider) postPayout(ctx context.Context, transaction models.Transaction, request trueLayerPayoutRequest, out interface{}) error {
+	if p.config.SigningKey == nil {
+		return fmt.Errorf("%s: no request signing key is configured", p.name)
+	}
+	env, err := p.environments.Select(transaction.Livemode)
+	if err != nil {
+		return err
+	}
+	token, err := p.payoutToken(ctx, env)
+	if err != nil {
+		return err
+	}
+
+	payload, err := json.Marshal(request)
+	if err != nil {
+		return fmt.Errorf("failed to encode TrueLayer request: %w", err)
+	}
+	const path = "/v3/payouts"
+	headers := [][2]string{{httpclient.IdempotencyKeyHeader, transaction.GatewayIdempotencyKey}}
+	signature, err := signTrueLayerRequest(p.config.SigningKey, p.config.SigningKeyID, http.MethodPost, path, headers, payload)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, strings.TrimSuffix(env.BaseURL, "/")+path, bytes.NewReader(payload))
+	if err != nil {
+		return fmt.Errorf("failed to build TrueLayer request: %w", err)
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(httpclient.IdempotencyKeyHeader, transaction.GatewayIdempotencyKey)
+	req.Header.Set(TrueLayerSignatureHeader, signature)
+
+	body, status, err := p.do(req)
+	if err != nil {
+		return err
+	}
+	if status >= http.StatusBadRequest {
+		return trueLayerError(status, body)
+	}
+	if err := json.Unmarshal(body, out); err != nil {
+		return fmt.Errorf("invalid TrueLayer response: %w", err)
+	}
+	return nil
+}
+
+// do sends a request, recording whether TrueLayer was reachable, and returns the response body
+// and status
+func (p *TrueLayerProvider) do(req *http.Request) ([]byte, int, error) {
+	resp, err := p.client.Do(req)
+	if err != nil {
+		p.available.Store(false)
+		return nil, 0, fmt.Errorf("truelayer request failed: %w", err)
+	}
+	defer resp.Body.Close()
+	p.available.Store(resp.StatusCode < http.StatusInternalServerError)
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to read TrueLayer response: %w", err)
+	}
+	return body, resp.StatusCode, nil
+}
+
+// verifyWebhook checks a webhook's Tl-Signature against the key it names in an allowed key set,
+// over the request line, the headers the signature lists and the body
+func (p *TrueLayerProvider) verifyWebhook(ctx context.Context, r *http.Request, body []byte) error {
+	header, signingInput, signature, err := parseTrueLayerSignature(r.Header.Get(TrueLayerSignatureHeader))
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidCallbackSignature, err)
+	}
+	if !p.allowedKeySet(header.JKU) {
+		return fmt.Errorf("%w: untrusted key set %q", ErrInvalidCallbackSignature, header.JKU)
+	}
+
+	key, err := p.webhookKey(ctx, header.JKU, header.Kid)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidCallbackSignature, err)
+	}
+
+	var headers [][2]string
+	if header.TLHeaders != "" {
+		for _, name := range strings.Split(header.TLHeaders, ",") {
+			headers = append(headers, [2]string{name, r.Header.Get(name)})
+		}
+	}
+	payload := trueLayerSigningPayload(r.Method, r.URL.Path, headers, body)
+	if !verifyES512(key, signingInput+"."+base64.RawURLEncoding.EncodeToString(payload), signature) {
+		return ErrInvalidCallbackSignature
+	}
+	return nil
+}
+
+// allowedKeySet reports whether webhook signatures may name a key set
+func (p *TrueLayerProvider) allowedKeySet(jku string) bool {
+	for _, allowed := range p.config.WebhookJWKSURLs {
+		if jku == allowed {
+			return true
+		}
+	}
+	return false
+}
+
+// webhookKey returns a webhook signing key of a key set, refetching the set when the key is not
+// cached and it was not fetched within trueLayerJWKSRefresh
+func (p *TrueLayerProvider) webhookKey(ctx context.Context, jku, kid string) (*ecdsa.PublicKey, error) {
+	p.jwksMu.Lock()
+	defer p.jwksMu.Unlock()
+
+	set, ok := p.jwks[jku]
+	if ok {
+		if key, ok := set.keys[kid]; ok {
+			return key, nil
+		}
+		if time.Since(set.fetchedAt) < trueLayerJWKSRefresh {
+			return nil, fmt.Errorf("unknown signing key %q", kid)
+		}
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, jku, nil)
+	if err != nil {
+		return nil, err
+	}
+	body, status, err := p.do(req)
+	if err != nil {
+		return nil, err
+	}
+	var fetched struct {
+		Keys []struct {
+			Kty string `json:"kty"`
+			Kid string `json:"kid"`
+			Crv string `json:"crv"`
+			X   string `json:"x"`
+			Y   string `json:"y"`
+		} `json:"keys"`
+	}
+	if status != http.StatusOK || json.Unmarshal(body, &fetched) != nil {
+		return nil, fmt.Errorf("failed to fetch signing keys from %s: status %d", jku, status)
+	}
+
+	set = &trueLayerKeySet{keys: make(map[string]*ecdsa.PublicKey), fetchedAt: time.Now()}
+	for _, jwk := range fetched.Keys {
+		if jwk.Kty != "EC" || jwk.Crv != "P-521" {
+			continue
+		}
+		x, errX := base64.RawURLEncoding.DecodeString(jwk.X)
+		y, errY := base64.RawURLEncoding.DecodeString(jwk.Y)
+		if errX != nil || errY != nil {
+			continue
+		}
+		key := &ecdsa.PublicKey{Curve: elliptic.P521(), X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+		if key.Curve.IsOnCurve(key.X, key.Y) {
+			set.keys[jwk.Kid] = key
+		}
+	}
+	p.jwks[jku] = set
+
+	key, ok := set.keys[kid]
+	if !ok {
+		return nil, fmt.Errorf("unknown signing key %q", kid)
+	}
+	return key, nil
+}
+
+// trueLayerSignatureHeader is the protected header of a Tl-Signature
+type trueLayerSignatureHeader struct {
+	Alg       string `json:"alg"`
+	Kid       string `json:"kid"`
+	TLVersion string `json:"tl_version"`
+	TLHeaders string `json:"tl_headers"`
+	JKU       string `json:"jku,omitempty"`
+}
+
+// signTrueLayerRequest returns the Tl-Signature of a request: a detached ES512 JWS over the
+// request line, the listed headers and the body
+func signTrueLayerRequest(key *ecdsa.PrivateKey, kid, method, path string, headers [][2]string, body []byte) (string, error) {
+	names := make([]string, len(headers))
+	for i, header := range headers {
+		names[i] = header[0]
+	}
+	return signTrueLayerJWS(key, trueLayerSignatureHeader{Alg: "ES512", Kid: kid, TLVersion: "2", TLHeaders: strings.Join(names, ",")},
+		trueLayerSigningPayload(method, path, headers, body))
+}
+
+// signTrueLayerJWS signs a payload under a protected header, leaving the payload detached
+func signTrueLayerJWS(key *ecdsa.PrivateKey, header trueLayerSignatureHeader, payload []byte) (string, error) {
+	if key.Curve != elliptic.P521() {
+		return "", errors.New("truelayer signing keys must use P-521")
+	}
+	headerJSON, err := json.Marshal(header)
+	if err != nil {
+		return "", err
+	}
+	encodedHeader := base64.RawURLEncoding.EncodeToString(headerJSON)
+	digest := sha512.Sum512([]byte(encodedHeader + "." + base64.RawURLEncoding.EncodeToString(payload)))
+
+	r, s, err := ecdsa.Sign(rand.Reader, key, digest[:])
+	if err != nil {
+		return "", fmt.Errorf("failed to sign TrueLayer request: %w", err)
+	}
+	signature := make([]byte, 132)
+	r.FillBytes(signature[:66])
+	s.FillBytes(signature[66:])
+	return encodedHeader + ".." + base64.RawURLEncoding.EncodeToString(signature), nil
+}
+
+// parseTrueLayerSignature splits a Tl-Signature into its header, the encoded header that starts
+// the signing input, and the signature
+func parseTrueLayerSignature(jws string) (trueLayerSignatureHeader, string, []byte, error) {
+	var header trueLayerSignatureHeader
+	parts := strings.Split(jws, ".")
+	if len(parts) != 3 || parts[1] != "" {
+		return header, "", nil, errors.New("missing or malformed signature")
+	}
+	headerJSON, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil || json.Unmarshal(headerJSON, &header) != nil {
+		return header, "", nil, errors.New("malformed signature header")
+	}
+	if header.Alg != "ES512" {
+		return header, "", nil, fmt.Errorf("unsupported algorithm %q", header.Alg)
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil || len(signature) != 132 {
+		return header, "", nil, errors.New("malformed signature")
+	}
+	return header, parts[0], signature, nil
+}
+
+// verifyES512 verifies an ES512 signature of a JWS signing input
+func verifyES512(key *ecdsa.PublicKey, signingInput string, signature []byte) bool {
+	digest := sha512.Sum512([]byte(signingInput))
+	r := new(big.Int).SetBytes(signature[:66])
+	s := new(big.Int).SetBytes(signature[66:])
+	return ecdsa.Verify(key, digest[:], r, s)
+}
+
+// trueLayerSigningPayload is what a Tl-Signature signs: the method and path, each listed header
+// as "<name>: <value>" and the body, separated by newlines
+func trueLayerSigningPayload(method, path string, headers [][2]string, body []byte) []byte {
+	var payload bytes.Buffer
+	payload.WriteString(method + " " + path + "\n")
+	for _, header := range headers {
+		payload.WriteString(header[0] + ": " + header[1] + "\n")
+	}
+	payload.Write(body)
+	return payload.Bytes()
+}
+
+// ParseTrueLayerSigningKey parses a PEM P-521 private key in SEC 1 or PKCS #8 form
+func ParseTrueLayerSigningKey(data []byte) (*ecdsa.PrivateKey, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("no PEM private key found")
+	}
+
+	var key interface{}
+	var err error
+	if block.Type == "EC PRIVATE KEY" {
+		key, err = x509.ParseECPrivateKey(block.Bytes)
+	} else {
+		key, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	ecKey, ok := key.(*ecdsa.PrivateKey)
+	if !ok || ecKey.Curve != elliptic.P521() {
+		return nil, errors.New("truelayer signing keys must be P-521 EC keys")
+	}
+	return ecKey, nil
+}
+
+// trueLayerCredentials splits an environment's API key into the app's client ID and secret
+func trueLayerCredentials(env Environment) (string, string, error) {
+	clientID, clientSecret, ok := strings.Cut(env.APIKey, ":")
+	if !ok || clientID == "" || clientSecret == "" {
+		return "", "", fmt.Errorf("truelayer %s API key must be \"<client ID>:<client secret>\"", env.Name)
+	}
+	return clientID, clientSecret, nil
+}
+
+// trueLayerAuthURL returns the auth server of an environment: TrueLayer's own for its APIs, and
+// the environment's base URL when it was overridden
+func trueLayerAuthURL(env Environment) string {
+	switch env.BaseURL {
+	case TrueLayerSandboxURL:
+		return TrueLayerAuthSandboxURL
+	case TrueLayerProductionURL:
+		return TrueLayerAuthProductionURL
+	}
+	return strings.TrimSuffix(env.BaseURL, "/")
+}
+
+// trueLayerReference returns the reference the beneficiary's bank shows for a payout
+func trueLayerReference(transaction models.Transaction) string {
+	reference := transaction.ReferenceID
+	if reference == "" {
+		reference = "WITHDRAWAL " + strconv.Itoa(transaction.ID)
+	}
+	if len(reference) > trueLayerReferenceLength {
+		reference = reference[len(reference)-trueLayerReferenceLength:]
+	}
+	return reference
+}
+
+// trueLayerError describes an error response, which TrueLayer sends as a problem document
+func trueLayerError(status int, body []byte) error {
+	var problem struct {
+		Title  string `json:"title"`
+		Detail string `json:"detail"`
+	}
+	if err := json.Unmarshal(body, &problem); err != nil || problem.Title == "" {
+		return fmt.Errorf("truelayer returned status %d", status)
+	}
+	return fmt.Errorf("truelayer returned status %d: %s: %s", status, problem.Title, problem.Detail)
+}
+
+// TrueLayerDeclineCodes maps the failure reasons of TrueLayer payouts to normalized decline codes
+var TrueLayerDeclineCodes = DeclineCodeMap{
+	"insufficient_funds": consts.DeclineInsufficientFunds, // the merchant account's balance is too low
+	"invalid_iban":       consts.DeclineInvalidAccount,
+	"returned":           consts.DeclineInvalidAccount, // the beneficiary's bank returned the payment
+	"blocked":            consts.DeclineFraudSuspected,
+	"scheme_error":       consts.DeclineProcessingError,
+}
+
+// trueLayerAccount is an account read from the Data API
+type trueLayerAccount struct {
+	AccountID     string `json:"account_id"`
+	Currency      string `json:"currency"`
+	AccountNumber struct {
+		IBAN     string `json:"iban"`
+		Number   string `json:"number"`
+		SortCode string `json:"sort_code"`
+	} `json:"account_number"`
+	Provider struct {
+		DisplayName string `json:"display_name"`
+	} `json:"provider"`
+}
+
+// trueLayerPayoutRequest pays out from a merchant account to an external account
+type trueLayerPayoutRequest struct {
+	MerchantAccountID string               `json:"merchant_account_id"`
+	AmountInMinor     int64                `json:"amount_in_minor"`
+	Currency          string               `json:"currency"`
+	Beneficiary       trueLayerBeneficiary `json:"beneficiary"`
+	Metadata          map[string]string    `json:"metadata,omitempty"`
+}
+
+// trueLayerBeneficiary is the external account a payout is paid to
+type trueLayerBeneficiary struct {
+	Type              string                     `json:"type"`
+	AccountHolderName string                     `json:"account_holder_name"`
+	AccountIdentifier trueLayerAccountIdentifier `json:"account_identifier"`
+	Reference         string                     `json:"reference"`
+}
+
+// trueLayerAccountIdentifier identifies an account by sort code and account number or by IBAN
+type trueLayerAccountIdentifier struct {
+	Type          string `json:"type"`
+	SortCode      string `json:"sort_code,omitempty"`
+	AccountNumber string `json:"account_number,omitempty"`
+	IBAN          string `json:"iban,omitempty"`
+}
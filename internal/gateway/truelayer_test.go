@@ -0,0 +1,219 @@
+package gateway
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"payment-gateway/internal/consts"
+	"payment-gateway/internal/models"
+	"testing"
+)
+
+// fakeTrueLayer serves TrueLayer's auth server, Data API, payouts and webhook key set, recording
+// payout requests with their body read. Webhooks are signed with the returned key.
+func fakeTrueLayer(t *testing.T) (*TrueLayerProvider, *ecdsa.PrivateKey, *ecdsa.PrivateKey, <-chan *http.Request, string) {
+	requestKey, _ := ecdsa.GenerateKey(elliptic.P521(), rand.Reader)
+	webhookKey, _ := ecdsa.GenerateKey(elliptic.P521(), rand.Reader)
+	payouts := make(chan *http.Request, 10)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/connect/token", func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		if r.Form.Get("client_id") != "tl-client" || r.Form.Get("client_secret") != "tl-secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		switch r.Form.Get("grant_type") {
+		case "authorization_code":
+			fmt.Fprint(w, `{"access_token":"user-token","refresh_token":"user-refresh","expires_in":3600}`)
+		case "client_credentials":
+			fmt.Fprint(w, `{"access_token":"payments-token","expires_in":3600}`)
+		}
+	})
+	mux.HandleFunc("/data/v1/info", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"results":[{"full_name":"MR JANE DOE"}]}`)
+	})
+	mux.HandleFunc("/data/v1/accounts", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer user-token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		fmt.Fprint(w, `{"results":[
+			{"account_id":"acc-1","currency":"GBP","account_number":{"number":"12345678","sort_code":"01-21-31"},"provider":{"display_name":"Lloyds"}},
+			{"account_id":"acc-2","currency":"EUR","account_number":{"iban":"DE89370400440532013000"},"provider":{"display_name":"N26"}},
+			{"account_id":"card-1","currency":"GBP","account_number":{}}]}`)
+	})
+	mux.HandleFunc("/v3/payouts", func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		r.Body = io.NopCloser(bytes.NewReader(body))
+		payouts <- r
+		w.WriteHeader(http.StatusAccepted)
+		fmt.Fprint(w, `{"id":"payout-1"}`)
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, `{"keys":[{"kty":"EC","kid":"wh-1","crv":"P-521","x":%q,"y":%q}]}`,
+			base64.RawURLEncoding.EncodeToString(webhookKey.X.FillBytes(make([]byte, 66))),
+			base64.RawURLEncoding.EncodeToString(webhookKey.Y.FillBytes(make([]byte, 66))))
+	})
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+
+	provider := NewTrueLayerProvider(8, "TrueLayer", TrueLayerConfig{
+		MerchantAccountIDs: map[string]string{"GBP": "ma-gbp"},
+		SigningKey:         requestKey,
+		SigningKeyID:       "req-1",
+		WebhookJWKSURLs:    []string{server.URL + "/jwks"},
+	})
+	provider.SetEnvironments(Environments{Sandbox: Environment{Name: EnvironmentSandbox, BaseURL: server.URL, APIKey: "tl-client:tl-secret"}})
+	return provider, requestKey, webhookKey, payouts, server.URL
+}
+
+// signTrueLayerWebhook returns the Tl-Signature of a webhook to path, naming a key set and key
+func signTrueLayerWebhook(t *testing.T, key *ecdsa.PrivateKey, jku, kid, path string, body []byte) string {
+	t.Helper()
+	signature, err := signTrueLayerJWS(key, trueLayerSignatureHeader{Alg: "ES512", Kid: kid, TLVersion: "2", JKU: jku},
+		trueLayerSigningPayload(http.MethodPost, path, nil, body))
+	if err != nil {
+		t.Fatalf("Failed to sign webhook: %v", err)
+	}
+	return signature
+}
+
+func TestTrueLayerLinkAccounts(t *testing.T) {
+	provider, _, _, _, _ := fakeTrueLayer(t)
+	ctx := context.Background()
+
+	link, err := provider.CreateAccountLink(ctx, false, "https://payments.example.com/bank-accounts/callback", "state-1")
+	if err != nil {
+		t.Fatalf("CreateAccountLink failed: %v", err)
+	}
+	authorisation, _ := url.Parse(link.AuthorisationURL)
+	query := authorisation.Query()
+	if query.Get("client_id") != "tl-client" || query.Get("state") != "state-1" || query.Get("scope") != "info accounts offline_access" {
+		t.Errorf("Unexpected auth link %s", link.AuthorisationURL)
+	}
+
+	access, err := provider.LinkAccounts(ctx, false, "auth-code", "https://payments.example.com/bank-accounts/callback")
+	if err != nil {
+		t.Fatalf("LinkAccounts failed: %v", err)
+	}
+	if access.AccessToken != "user-token" || access.RefreshToken != "user-refresh" || access.TokenExpiresAt.IsZero() {
+		t.Errorf("Unexpected access %+v", access)
+	}
+	want := []models.BankAccount{
+		{ExternalID: "acc-1", BankName: "Lloyds", HolderName: "MR JANE DOE", Currency: "GBP", SortCode: "012131", AccountNumber: "12345678"},
+		{ExternalID: "acc-2", BankName: "N26", HolderName: "MR JANE DOE", Currency: "EUR", IBAN: "DE89370400440532013000"},
+	}
+	if len(access.Accounts) != len(want) {
+		t.Fatalf("Expected the accounts payouts can reach, got %+v", access.Accounts)
+	}
+	for i := range want {
+		if access.Accounts[i] != want[i] {
+			t.Errorf("Account %d: expected %+v, got %+v", i, want[i], access.Accounts[i])
+		}
+	}
+}
+
+func TestTrueLayerPayOut(t *testing.T) {
+	provider, requestKey, _, payouts, _ := fakeTrueLayer(t)
+	account := models.BankAccount{ID: 3, HolderName: "Jane Doe", Currency: "GBP", SortCode: "012131", AccountNumber: "12345678"}
+	tx := models.Transaction{ID: 42, Amount: 25.5, Currency: "GBP", ReferenceID: "PG0123456789ABCDEFGH", GatewayIdempotencyKey: "idem-42"}
+
+	response, err := provider.PayOut(context.Background(), tx, account)
+	if err != nil {
+		t.Fatalf("PayOut failed: %v", err)
+	}
+	if response.Status != consts.Processing || response.GatewayReference != "payout-1" {
+		t.Errorf("Unexpected response: %+v", response)
+	}
+
+	req := <-payouts
+	if req.Header.Get("Authorization") != "Bearer payments-token" || req.Header.Get("Idempotency-Key") != "idem-42" {
+		t.Errorf("Unexpected headers %v", req.Header)
+	}
+	body, _ := io.ReadAll(req.Body)
+
+	// The signature covers the idempotency key and body, and verifies with the uploaded public key
+	header, signingInput, signature, err := parseTrueLayerSignature(req.Header.Get(TrueLayerSignatureHeader))
+	if err != nil || header.Kid != "req-1" || header.TLHeaders != "Idempotency-Key" {
+		t.Fatalf("Unexpected signature header %+v: %v", header, err)
+	}
+	payload := trueLayerSigningPayload(http.MethodPost, "/v3/payouts", [][2]string{{"Idempotency-Key", "idem-42"}}, body)
+	if !verifyES512(&requestKey.PublicKey, signingInput+"."+base64.RawURLEncoding.EncodeToString(payload), signature) {
+		t.Error("Expected the payout signature to verify")
+	}
+
+	var payout trueLayerPayoutRequest
+	if err := json.Unmarshal(body, &payout); err != nil {
+		t.Fatalf("Invalid request body: %v", err)
+	}
+	identifier := trueLayerAccountIdentifier{Type: "sort_code_account_number", SortCode: "012131", AccountNumber: "12345678"}
+	if payout.MerchantAccountID != "ma-gbp" || payout.AmountInMinor != 2550 || payout.Beneficiary.AccountIdentifier != identifier ||
+		payout.Beneficiary.AccountHolderName != "Jane Doe" || payout.Beneficiary.Reference != "0123456789ABCDEFGH" ||
+		payout.Metadata["transaction_id"] != "42" {
+		t.Errorf("Unexpected payout: %+v", payout)
+	}
+
+	// Currencies without a merchant account are not paid out
+	if _, err := provider.PayOut(context.Background(), models.Transaction{ID: 43, Amount: 10, Currency: "EUR"}, models.BankAccount{Currency: "EUR", IBAN: "DE89"}); err == nil {
+		t.Error("Expected a payout without a merchant account to be refused")
+	}
+	if _, err := provider.ProcessWithdrawal(context.Background(), tx); err == nil {
+		t.Error("Expected withdrawals without a linked account to be refused")
+	}
+}
+
+func TestTrueLayerParseCallback(t *testing.T) {
+	provider, _, webhookKey, _, serverURL := fakeTrueLayer(t)
+	otherKey, _ := ecdsa.GenerateKey(elliptic.P521(), rand.Reader)
+	jku := serverURL + "/jwks"
+
+	executed := []byte(`{"type":"payout_executed","event_id":"ev-1","payout_id":"payout-1","metadata":{"transaction_id":"42"}}`)
+	failed := []byte(`{"type":"payout_failed","event_id":"ev-2","payout_id":"payout-1","failure_reason":"insufficient_funds","metadata":{"transaction_id":"42"}}`)
+
+	tests := []struct {
+		name        string
+		body        []byte
+		signature   string
+		status      string
+		declineCode string
+	}{
+		{"executed", executed, signTrueLayerWebhook(t, webhookKey, jku, "wh-1", "/callback/8", executed), consts.Completed, ""},
+		{"failed", failed, signTrueLayerWebhook(t, webhookKey, jku, "wh-1", "/callback/8", failed), consts.Failed, consts.DeclineInsufficientFunds},
+		{"wrong key", executed, signTrueLayerWebhook(t, otherKey, jku, "wh-1", "/callback/8", executed), "", ""},
+		{"untrusted key set", executed, signTrueLayerWebhook(t, webhookKey, "https://attacker.example.com/jwks", "wh-1", "/callback/8", executed), "", ""},
+		{"other path", executed, signTrueLayerWebhook(t, webhookKey, jku, "wh-1", "/callback/7", executed), "", ""},
+		{"missing", executed, "", "", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/callback/8", bytes.NewReader(tt.body))
+			req.Header.Set(TrueLayerSignatureHeader, tt.signature)
+
+			data, err := provider.ParseCallback(req)
+			if tt.status == "" {
+				if !errors.Is(err, ErrInvalidCallbackSignature) {
+					t.Errorf("Expected an invalid signature, got %v", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ParseCallback failed: %v", err)
+			}
+			if data.TransactionID != 42 || data.GatewayReference != "payout-1" || data.Status != tt.status || data.DeclineCode != tt.declineCode {
+				t.Errorf("Unexpected callback data: %+v", data)
+			}
+		})
+	}
+}
@@ -0,0 +1,435 @@
+package gateway
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"payment-gateway/internal/consts"
+	"payment-gateway/internal/httpclient"
+	"payment-gateway/internal/models"
+	"payment-gateway/internal/money"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// UPI API settings
+const (
+	UPISignatureHeader = "X-Signature"
+	UPICurrency        = "INR"
+
+	// Outcomes the PSP reports payments with; payments are PENDING until then
+	upiStatusSuccess = "SUCCESS"
+	upiStatusFailure = "FAILURE"
+	upiStatusExpired = "EXPIRED"
+)
+
+// UPIConfig configures the UPI provider: the merchant's VPA deposits are paid to and the name and
+// merchant category code shown to payers. The PSP's API URL and API key are the provider's
+// environments.
+type UPIConfig struct {
+	MerchantVPA  string // VPA deposits are paid to
+	MerchantName string
+	MerchantCode string // merchant category code
+
+	// Expiry is how long collect requests and intent links can be paid for; 15 minutes when zero
+	Expiry time.Duration
+
+	// StatusPollDelay is how long after its submission a deposit is first polled for its status,
+	// should its callback not have arrived; a minute when zero
+	StatusPollDelay time.Duration
+
+	// WebhookSecrets are the shared secrets the PSP signs its payment callbacks with. Callbacks
+	// signed with any of them are accepted, so secrets can be rotated.
+	WebhookSecrets []string
+
+	Timeout time.Duration // per attempt; 30 seconds when zero
+}
+
+// UPIProvider is a gateway adapter for a UPI payment service provider, India's instant payment
+// system. Deposits naming the payer's VPA send a collect request the customer approves in their
+// UPI app; other deposits return an intent link (upi://pay) the customer opens in it, on mobile
+// directly or by scanning it as a QR code. Either way the deposit is processing until the PSP
+// reports its outcome. PSPs' callbacks are known to be lost or delayed, so the provider reports
+// the status of its deposits to the status poller, and deposits unpaid once their window closed
+// are failed by the payment expiry scheduler. VPAs can be validated before a collect request is
+// sent to them. UPI payouts are not supported, so withdrawals are refused.
+type UPIProvider struct {
+	id           string
+	name         string
+	config       UPIConfig
+	client       *httpclient.Client
+	environments Environments
+	available    atomic.Bool
+	declineCodes DeclineCodeMap
+	now          func() time.Time
+}
+
+// NewUPIProvider creates a UPI provider. SetEnvironments must be called with the PSP's API URL and
+// API key before it processes transactions.
+func NewUPIProvider(id int, name string, config UPIConfig) *UPIProvider {
+	if config.Expiry <= 0 {
+		config.Expiry = consts.DefaultUPIExpiry
+	}
+	if config.StatusPollDelay <= 0 {
+		config.StatusPollDelay = consts.DefaultUPIStatusPollDelay
+	}
+
+	clientConfig := httpclient.DefaultConfig(name)
+	if config.Timeout > 0 {
+		clientConfig.Timeout = config.Timeout
+	}
+
+	p := &UPIProvider{
+		id:           strconv.Itoa(id),
+		name:         name,
+		config:       config,
+		client:       httpclient.New(clientConfig),
+		declineCodes: UPIDeclineCodes,
+		now:          time.Now,
+	}
+	p.available.Store(true)
+	return p
+}
+
+// SetEnvironments configures the sandbox and production environments of the PSP's API
+func (p *UPIProvider) SetEnvironments(environments Environments) {
+	p.environments = environments
+}
+
+// ID returns the unique identifier of the gateway
+func (p *UPIProvider) ID() string {
+	return p.id
+}
+
+// Name returns the name of the gateway
+func (p *UPIProvider) Name() string {
+	return p.name
+}
+
+// DataFormat returns the data format supported by the gateway
+func (p *UPIProvider) DataFormat() string {
+	return "application/json"
+}
+
+// IsAvailable reports whether the last request reached the PSP
+func (p *UPIProvider) IsAvailable() bool {
+	return p.available.Load()
+}
+
+// PaymentMethods returns the payment methods the provider takes: UPI payments
+func (p *UPIProvider) PaymentMethods() []string {
+	return []string{consts.PaymentMethodUPI}
+}
+
+// SupportsCurrency reports whether the provider takes a currency; UPI payments are in rupees
+func (p *UPIProvider) SupportsCurrency(currency string) bool {
+	return currency == UPICurrency
+}
+
+// PaymentExpiry returns how long collect requests and intent links can be paid for
+func (p *UPIProvider) PaymentExpiry() time.Duration {
+	return p.config.Expiry
+}
+
+// StatusPollDelay returns how long after its submission a deposit is first polled for its status
+func (p *UPIProvider) StatusPollDelay() time.Duration {
+	return p.config.StatusPollDelay
+}
+
+// ProcessDeposit sends a collect request to the transaction's payer VPA, or creates an intent link
+// when it names none. Our reference identifies the payment at the PSP and is the gateway
+// reference. Collect requests the PSP refuses, as for an address that doesn't exist, are returned
+// as a DeclineError.
+func (p *UPIProvider) ProcessDeposit(ctx context.Context, transaction models.Transaction) (*models.TransactionResponse, error) {
+	if transaction.Currency != UPICurrency {
+		return nil, fmt.Errorf("%s: UPI payments are in %s, not %s", p.name, UPICurrency, transaction.Currency)
+	}
+
+	request := upiPaymentRequest{
+		Reference:     transaction.ReferenceID,
+		PayeeVPA:      p.config.MerchantVPA,
+		PayerVPA:      transaction.PayerVPA,
+		Amount:        strconv.FormatFloat(transaction.Amount, 'f', money.Exponent(UPICurrency), 64),
+		Currency:      UPICurrency,
+		Note:          "Deposit " + strconv.Itoa(transaction.ID),
+		ExpiryMinutes: int(p.config.Expiry / time.Minute),
+	}
+
+	flow, path := consts.UPIFlowCollect, "/v1/collect"
+	if transaction.PayerVPA == "" {
+		flow, path = consts.UPIFlowIntent, "/v1/intent"
+	}
+
+	var payment upiPayment
+	if err := p.send(ctx, transaction, http.MethodPost, path, request, &payment); err != nil {
+		return nil, err
+	}
+	if payment.Status == upiStatusFailure {
+		return nil, &DeclineError{Code: p.declineCodes.Normalize(payment.ErrorCode), ProviderCode: payment.ErrorCode, Message: payment.ErrorMessage}
+	}
+
+	expiresAt, err := time.Parse(time.RFC3339, payment.ExpiresAt)
+	if err != nil {
+		expiresAt = p.now().Add(p.config.Expiry)
+	}
+	upi := &models.UPIPayment{Flow: flow, ExpiresAt: expiresAt.UTC()}
+	if flow == consts.UPIFlowIntent {
+		upi.IntentURL = payment.IntentURL
+		if upi.IntentURL == "" {
+			upi.IntentURL = UPIIntentURL(p.config.MerchantVPA, p.config.MerchantName, p.config.MerchantCode, request.Reference, request.Amount, request.Note)
+		}
+	}
+
+	return &models.TransactionResponse{
+		TransactionID:    transaction.ID,
+		Status:           consts.Processing,
+		GatewayReference: transaction.ReferenceID,
+		UPI:              upi,
+	}, nil
+}
+
+// ProcessWithdrawal refuses withdrawals, as UPI payouts are not supported
+func (p *UPIProvider) ProcessWithdrawal(ctx context.Context, transaction models.Transaction) (*models.TransactionResponse, error) {
+	return nil, fmt.Errorf("%s: withdrawals are not supported", p.name)
+}
+
+// ProcessRefund refuses refunds. UPI refunds go through the PSP's dashboard or its own refund API.
+func (p *UPIProvider) ProcessRefund(ctx context.Context, refund models.Refund, transaction models.Transaction) (*models.TransactionResponse, error) {
+	return nil, fmt.Errorf("%s: %w", p.name, ErrRefundUnsupported)
+}
+
+// ParseCallback verifies the signature of a payment callback and maps the payment's status to the
+// deposit, by its reference
+func (p *UPIProvider) ParseCallback(r *http.Request) (*models.CallbackData, error) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read callback: %w", err)
+	}
+	if err := p.verifySignature(body, r.Header.Get(UPISignatureHeader)); err != nil {
+		return nil, err
+	}
+
+	var payment upiPayment
+	if err := json.Unmarshal(body, &payment); err != nil {
+		return nil, fmt.Errorf("invalid UPI callback: %w", err)
+	}
+	if payment.Reference == "" {
+		return nil, fmt.Errorf("UPI callback has no reference")
+	}
+	return p.callbackData(payment), nil
+}
+
+// QueryTransactionStatus asks the PSP for the status of a deposit's payment
+func (p *UPIProvider) QueryTransactionStatus(ctx context.Context, transaction models.Transaction) (*models.CallbackData, error) {
+	var payment upiPayment
+	if err := p.send(ctx, transaction, http.MethodGet, "/v1/payments/"+url.PathEscape(transaction.GatewayReference), nil, &payment); err != nil {
+		return nil, err
+	}
+	if payment.Reference == "" {
+		payment.Reference = transaction.GatewayReference
+	}
+	return p.callbackData(payment), nil
+}
+
+// ValidateVPA asks the PSP whether a VPA exists and whose it is
+func (p *UPIProvider) ValidateVPA(ctx context.Context, vpa string, livemode bool) (*models.VPAValidation, error) {
+	var result struct {
+		VPA   string `json:"vpa"`
+		Valid bool   `json:"valid"`
+		Name  string `json:"name"`
+	}
+	if err := p.send(ctx, models.Transaction{Livemode: livemode}, http.MethodPost, "/v1/vpa/validate", map[string]string{"vpa": vpa}, &result); err != nil {
+		return nil, err
+	}
+
+	validation := &models.VPAValidation{VPA: vpa, Valid: result.Valid}
+	if result.Valid {
+		validation.Name = result.Name
+	}
+	return validation, nil
+}
+
+// callbackData maps a payment the PSP reported to the callback of its deposit. Payments still
+// pending keep the deposit processing.
+func (p *UPIProvider) callbackData(payment upiPayment) *models.CallbackData {
+	data := &models.CallbackData{
+		GatewayReference: payment.Reference,
+		GatewayID:        p.id,
+		Status:           consts.Processing,
+		Timestamp:        payment.UpdatedAt,
+	}
+
+	switch payment.Status {
+	case upiStatusSuccess:
+		data.Status = consts.Completed
+		data.Message = fmt.Sprintf("paid %s INR from %s (RRN %s)", payment.Amount, payment.PayerVPA, payment.RRN)
+	case upiStatusFailure:
+		data.Status = consts.Failed
+		data.ReasonCode = payment.ErrorCode
+		data.DeclineCode = p.declineCodes.Normalize(payment.ErrorCode)
+		data.Message = payment.ErrorMessage
+	case upiStatusExpired:
+		data.Status = consts.Failed
+		data.DeclineCode = consts.DeclineConsentExpired
+		data.Message = "UPI payment expired before the customer paid"
+	}
+	return data
+}
+
+// send sends a JSON request to the transaction's environment and decodes the response. A nil
+// request is sent without a body. Payments the PSP refuses are answered with a FAILURE status and
+// decoded like any other.
+func (p *UPIProvider) send(ctx context.Context, transaction models.Transaction, method, path string, request, out interface{}) error {
+	env, err := p.environments.Select(transaction.Livemode)
+	if err != nil {
+		return err
+	}
+	if env.BaseURL == "" {
+		return fmt.Errorf("%s: no UPI API URL is configured", p.name)
+	}
+
+	var payload io.Reader
+	if request != nil {
+		encoded, err := json.Marshal(request)
+		if err != nil {
+			return fmt.Errorf("failed to encode UPI request: %w", err)
+		}
+		payload = bytes.NewReader(encoded)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, strings.TrimSuffix(env.BaseURL, "/")+path, payload)
+	if err != nil {
+		return fmt.Errorf("failed to build UPI request: %w", err)
+	}
+	req.Header.Set("Authorization", "Bearer "+env.APIKey)
+	if request != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if method == http.MethodPost && transaction.GatewayIdempotencyKey != "" {
+		req.Header.Set("Idempotency-Key", transaction.GatewayIdempotencyKey)
+	}
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		p.available.Store(false)
+		return fmt.Errorf("UPI request failed: %w", err)
+	}
+	defer resp.Body.Close()
+	p.available.Store(resp.StatusCode < http.StatusInternalServerError)
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("failed to read UPI response: %w", err)
+	}
+	if resp.StatusCode >= http.StatusBadRequest {
+		var apiErr struct {
+			Code    string `json:"error_code"`
+			Message string `json:"error_message"`
+		}
+		if err := json.Unmarshal(body, &apiErr); err != nil || apiErr.Code == "" {
+			return fmt.Errorf("UPI PSP returned status %d", resp.StatusCode)
+		}
+		return fmt.Errorf("UPI PSP returned status %d: %s: %s", resp.StatusCode, apiErr.Code, apiErr.Message)
+	}
+
+	if err := json.Unmarshal(body, out); err != nil {
+		return fmt.Errorf("invalid UPI response: %w", err)
+	}
+	return nil
+}
+
+// verifySignature checks the X-Signature header: the hex HMAC-SHA256 of the raw body under one of
+// the webhook secrets
+func (p *UPIProvider) verifySignature(body []byte, header string) error {
+	if len(p.config.WebhookSecrets) == 0 {
+		return fmt.Errorf("%w: no UPI webhook secret is configured", ErrInvalidCallbackSignature)
+	}
+	signature, err := hex.DecodeString(header)
+	if err != nil || len(signature) == 0 {
+		return fmt.Errorf("%w: missing or malformed %s header", ErrInvalidCallbackSignature, UPISignatureHeader)
+	}
+
+	for _, secret := range p.config.WebhookSecrets {
+		mac := hmac.New(sha256.New, []byte(secret))
+		mac.Write(body)
+		if hmac.Equal(mac.Sum(nil), signature) {
+			return nil
+		}
+	}
+	return ErrInvalidCallbackSignature
+}
+
+// UPIIntentURL returns the intent link of a payment to a payee VPA, following NPCI's UPI linking
+// specification: a upi://pay URL UPI apps open with the payee, amount and reference filled in.
+// It is opened on mobile directly or shown as a QR code.
+func UPIIntentURL(payeeVPA, payeeName, merchantCode, reference, amount, note string) string {
+	params := []struct{ key, value string }{
+		{"pa", payeeVPA},
+		{"pn", payeeName},
+		{"mc", merchantCode},
+		{"tr", reference},
+		{"tn", note},
+		{"am", amount},
+		{"cu", UPICurrency},
+	}
+
+	var b strings.Builder
+	b.WriteString("upi://pay")
+	separator := "?"
+	for _, param := range params {
+		if param.value == "" {
+			continue
+		}
+		// UPI apps show "+" literally, so spaces are percent-encoded
+		b.WriteString(separator + param.key + "=" + strings.ReplaceAll(url.QueryEscape(param.value), "+", "%20"))
+		separator = "&"
+	}
+	return b.String()
+}
+
+// UPIDeclineCodes maps the NPCI response codes PSPs report failed payments with to normalized
+// decline codes
+var UPIDeclineCodes = DeclineCodeMap{
+	"U16": consts.DeclineFraudSuspected,    // risk threshold exceeded
+	"U28": consts.DeclineIssuerUnavailable, // the payer's bank is not reachable
+	"U30": consts.DeclineDoNotHonor,        // the debit failed
+	"U69": consts.DeclineConsentExpired,    // the collect request expired
+	"Z8":  consts.DeclineLimitExceeded,     // per-transaction limit exceeded
+	"Z9":  consts.DeclineInsufficientFunds, // insufficient funds
+	"ZA":  consts.DeclineConsentRejected,   // the payer declined the collect request
+	"ZH":  consts.DeclineInvalidAccount,    // invalid VPA
+	"ZM":  consts.DeclineDoNotHonor,        // invalid UPI PIN
+}
+
+// upiPaymentRequest sends a collect request, when PayerVPA is set, or creates an intent link
+type upiPaymentRequest struct {
+	Reference     string `json:"reference"`
+	PayeeVPA      string `json:"payee_vpa"`
+	PayerVPA      string `json:"payer_vpa,omitempty"`
+	Amount        string `json:"amount"`
+	Currency      string `json:"currency"`
+	Note          string `json:"note"`
+	ExpiryMinutes int    `json:"expiry_minutes"`
+}
+
+// upiPayment holds the fields read from payments, as created, queried and reported by callbacks
+type upiPayment struct {
+	Reference    string `json:"reference"`
+	Status       string `json:"status"`
+	Amount       string `json:"amount"`
+	PayerVPA     string `json:"payer_vpa"`
+	RRN          string `json:"rrn"` // retrieval reference number the payer's bank shows
+	IntentURL    string `json:"intent_url"`
+	ExpiresAt    string `json:"expires_at"`
+	UpdatedAt    string `json:"updated_at"`
+	ErrorCode    string `json:"error_code"`
+	ErrorMessage string `json:"error_message"`
+}
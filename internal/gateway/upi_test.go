@@ -0,0 +1,211 @@
+package gateway
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"payment-gateway/internal/consts"
+	"payment-gateway/internal/models"
+	"testing"
+	"time"
+)
+
+const upiTestWebhookSecret = "upi-secret"
+
+// fakeUPIPSP answers requests with the given status and body, recording each request with its
+// body read
+func fakeUPIPSP(t *testing.T, status int, body string) (*UPIProvider, <-chan *http.Request) {
+	requests := make(chan *http.Request, 10)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer upi-key" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		requestBody, _ := io.ReadAll(r.Body)
+		r.Body = io.NopCloser(bytes.NewReader(requestBody))
+		requests <- r
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		fmt.Fprint(w, body)
+	}))
+	t.Cleanup(server.Close)
+
+	provider := NewUPIProvider(11, "UPI", UPIConfig{
+		MerchantVPA:    "shop@okbank",
+		MerchantName:   "Example Shop",
+		MerchantCode:   "5411",
+		WebhookSecrets: []string{"upi-old", upiTestWebhookSecret},
+	})
+	provider.SetEnvironments(Environments{Sandbox: Environment{Name: EnvironmentSandbox, BaseURL: server.URL, APIKey: "upi-key"}})
+	return provider, requests
+}
+
+func TestUPIIntentURL(t *testing.T) {
+	got := UPIIntentURL("shop@okbank", "Example Shop", "5411", "PG01HV6Z", "99.50", "Deposit 42")
+	want := "upi://pay?pa=shop%40okbank&pn=Example%20Shop&mc=5411&tr=PG01HV6Z&tn=Deposit%2042&am=99.50&cu=INR"
+	if got != want {
+		t.Errorf("Expected %q, got %q", want, got)
+	}
+}
+
+func TestUPICollectDeposit(t *testing.T) {
+	provider, requests := fakeUPIPSP(t, http.StatusOK, `{"reference":"PG01HV6Z","status":"PENDING","expires_at":"2024-05-01T10:15:00Z"}`)
+
+	response, err := provider.ProcessDeposit(context.Background(), models.Transaction{ID: 42, Amount: 99.5, Currency: "INR", ReferenceID: "PG01HV6Z", PayerVPA: "alice@okaxis"})
+	if err != nil {
+		t.Fatalf("ProcessDeposit failed: %v", err)
+	}
+	if response.Status != consts.Processing || response.GatewayReference != "PG01HV6Z" || response.UPI == nil {
+		t.Fatalf("Unexpected response: %+v", response)
+	}
+	if response.UPI.Flow != consts.UPIFlowCollect || response.UPI.IntentURL != "" ||
+		!response.UPI.ExpiresAt.Equal(time.Date(2024, 5, 1, 10, 15, 0, 0, time.UTC)) {
+		t.Errorf("Unexpected UPI payment: %+v", response.UPI)
+	}
+
+	req := <-requests
+	if req.Method != http.MethodPost || req.URL.Path != "/v1/collect" {
+		t.Errorf("Unexpected request %s %s", req.Method, req.URL.Path)
+	}
+	var collect upiPaymentRequest
+	if err := json.NewDecoder(req.Body).Decode(&collect); err != nil {
+		t.Fatalf("Invalid request body: %v", err)
+	}
+	if collect.PayerVPA != "alice@okaxis" || collect.PayeeVPA != "shop@okbank" || collect.Amount != "99.50" || collect.ExpiryMinutes != 15 {
+		t.Errorf("Unexpected collect request: %+v", collect)
+	}
+
+	// Collect requests the PSP refuses are declines
+	provider, _ = fakeUPIPSP(t, http.StatusOK, `{"reference":"PG01HV6Z","status":"FAILURE","error_code":"ZH","error_message":"Invalid VPA"}`)
+	_, err = provider.ProcessDeposit(context.Background(), models.Transaction{ID: 42, Amount: 10, Currency: "INR", ReferenceID: "PG01HV6Z", PayerVPA: "nobody@okaxis"})
+	var decline *DeclineError
+	if !errors.As(err, &decline) || decline.Code != consts.DeclineInvalidAccount || decline.ProviderCode != "ZH" {
+		t.Errorf("Expected an invalid_account decline, got: %v", err)
+	}
+
+	if _, err := provider.ProcessDeposit(context.Background(), models.Transaction{ID: 43, Amount: 10, Currency: "USD", ReferenceID: "PG01HV6Z"}); err == nil {
+		t.Error("Expected an error for a currency other than INR")
+	}
+	if _, err := provider.ProcessWithdrawal(context.Background(), models.Transaction{ID: 44, Amount: 10, Currency: "INR"}); err == nil {
+		t.Error("Expected withdrawals to be refused")
+	}
+}
+
+func TestUPIIntentDeposit(t *testing.T) {
+	provider, requests := fakeUPIPSP(t, http.StatusOK, `{"reference":"PG01HV6Z","status":"PENDING"}`)
+	provider.now = func() time.Time { return time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC) }
+
+	response, err := provider.ProcessDeposit(context.Background(), models.Transaction{ID: 42, Amount: 250, Currency: "INR", ReferenceID: "PG01HV6Z"})
+	if err != nil {
+		t.Fatalf("ProcessDeposit failed: %v", err)
+	}
+	want := UPIIntentURL("shop@okbank", "Example Shop", "5411", "PG01HV6Z", "250.00", "Deposit 42")
+	if response.UPI == nil || response.UPI.Flow != consts.UPIFlowIntent || response.UPI.IntentURL != want {
+		t.Fatalf("Expected the intent link %q, got %+v", want, response.UPI)
+	}
+	if !response.UPI.ExpiresAt.Equal(time.Date(2024, 5, 1, 10, 15, 0, 0, time.UTC)) {
+		t.Errorf("Expected the default 15 minute window, got %v", response.UPI.ExpiresAt)
+	}
+	if req := <-requests; req.URL.Path != "/v1/intent" {
+		t.Errorf("Expected an intent request, got %s", req.URL.Path)
+	}
+
+	// An intent link returned by the PSP is passed on as is
+	provider, _ = fakeUPIPSP(t, http.StatusOK, `{"reference":"PG01HV6Z","status":"PENDING","intent_url":"upi://pay?pa=shop@okbank&tr=PG01HV6Z"}`)
+	response, err = provider.ProcessDeposit(context.Background(), models.Transaction{ID: 42, Amount: 250, Currency: "INR", ReferenceID: "PG01HV6Z"})
+	if err != nil || response.UPI.IntentURL != "upi://pay?pa=shop@okbank&tr=PG01HV6Z" {
+		t.Errorf("Expected the PSP's intent link, got %+v (%v)", response, err)
+	}
+}
+
+func TestUPIQueryTransactionStatus(t *testing.T) {
+	tests := []struct {
+		name        string
+		body        string
+		status      string
+		declineCode string
+	}{
+		{"pending", `{"reference":"PG01HV6Z","status":"PENDING"}`, consts.Processing, ""},
+		{"paid", `{"reference":"PG01HV6Z","status":"SUCCESS","amount":"99.50","payer_vpa":"alice@okaxis","rrn":"412345678901"}`, consts.Completed, ""},
+		{"declined", `{"reference":"PG01HV6Z","status":"FAILURE","error_code":"Z9","error_message":"Insufficient funds"}`, consts.Failed, consts.DeclineInsufficientFunds},
+		{"expired", `{"reference":"PG01HV6Z","status":"EXPIRED"}`, consts.Failed, consts.DeclineConsentExpired},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			provider, requests := fakeUPIPSP(t, http.StatusOK, tt.body)
+
+			data, err := provider.QueryTransactionStatus(context.Background(), models.Transaction{ID: 42, GatewayReference: "PG01HV6Z"})
+			if err != nil {
+				t.Fatalf("QueryTransactionStatus failed: %v", err)
+			}
+			if data.Status != tt.status || data.DeclineCode != tt.declineCode || data.GatewayReference != "PG01HV6Z" {
+				t.Errorf("Unexpected callback data: %+v", data)
+			}
+			if req := <-requests; req.Method != http.MethodGet || req.URL.Path != "/v1/payments/PG01HV6Z" {
+				t.Errorf("Unexpected request %s %s", req.Method, req.URL.Path)
+			}
+		})
+	}
+
+	provider, _ := fakeUPIPSP(t, http.StatusNotFound, `{"error_code":"NOT_FOUND","error_message":"No such payment"}`)
+	if _, err := provider.QueryTransactionStatus(context.Background(), models.Transaction{ID: 42, GatewayReference: "PG01HV6Z"}); err == nil {
+		t.Error("Expected an error for an unknown payment")
+	}
+}
+
+func TestUPIValidateVPA(t *testing.T) {
+	provider, requests := fakeUPIPSP(t, http.StatusOK, `{"vpa":"alice@okaxis","valid":true,"name":"ALICE KUMAR"}`)
+
+	validation, err := provider.ValidateVPA(context.Background(), "alice@okaxis", false)
+	if err != nil {
+		t.Fatalf("ValidateVPA failed: %v", err)
+	}
+	if !validation.Valid || validation.Name != "ALICE KUMAR" || validation.VPA != "alice@okaxis" {
+		t.Errorf("Unexpected validation: %+v", validation)
+	}
+	if req := <-requests; req.URL.Path != "/v1/vpa/validate" {
+		t.Errorf("Unexpected request path %s", req.URL.Path)
+	}
+
+	provider, _ = fakeUPIPSP(t, http.StatusOK, `{"vpa":"nobody@okaxis","valid":false}`)
+	if validation, err := provider.ValidateVPA(context.Background(), "nobody@okaxis", false); err != nil || validation.Valid {
+		t.Errorf("Expected an invalid VPA, got %+v (%v)", validation, err)
+	}
+	if _, err := provider.ValidateVPA(context.Background(), "alice@okaxis", true); !errors.Is(err, ErrNoProductionEnvironment) {
+		t.Errorf("Expected ErrNoProductionEnvironment in livemode, got: %v", err)
+	}
+}
+
+func TestUPICallback(t *testing.T) {
+	provider, _ := fakeUPIPSP(t, http.StatusOK, `{}`)
+	parse := func(payload []byte, secret string) (*models.CallbackData, error) {
+		req := httptest.NewRequest(http.MethodPost, "/callback/11", bytes.NewReader(payload))
+		req.Header.Set(UPISignatureHeader, signPixNotification(secret, payload))
+		return provider.ParseCallback(req)
+	}
+
+	payload := []byte(`{"reference":"PG01HV6Z","status":"FAILURE","error_code":"ZA","error_message":"Collect request declined","updated_at":"2024-05-01T10:05:00Z"}`)
+	data, err := parse(payload, upiTestWebhookSecret)
+	if err != nil {
+		t.Fatalf("ParseCallback failed: %v", err)
+	}
+	if data.Status != consts.Failed || data.GatewayReference != "PG01HV6Z" || data.GatewayID != "11" ||
+		data.ReasonCode != "ZA" || data.DeclineCode != consts.DeclineConsentRejected || data.Timestamp != "2024-05-01T10:05:00Z" {
+		t.Errorf("Unexpected callback data: %+v", data)
+	}
+
+	if _, err := parse(payload, "upi-old"); err != nil {
+		t.Errorf("Expected callbacks signed with the previous secret to be accepted, got: %v", err)
+	}
+	if _, err := parse(payload, "wrong"); !errors.Is(err, ErrInvalidCallbackSignature) {
+		t.Errorf("Expected ErrInvalidCallbackSignature, got: %v", err)
+	}
+	if _, err := parse([]byte(`{"status":"SUCCESS"}`), upiTestWebhookSecret); err == nil {
+		t.Error("Expected an error for a payment without a reference")
+	}
+}
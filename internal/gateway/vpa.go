@@ -0,0 +1,22 @@
+package gateway
+
+import (
+	"context"
+	"payment-gateway/internal/models"
+)
+
+// VPAValidator is implemented by UPI providers that can look up a virtual payment address, so
+// customers can confirm the holder's name before a collect request is sent to it
+type VPAValidator interface {
+	Provider
+
+	// ValidateVPA asks the PSP whether a VPA exists, in the production environment when livemode
+	// is set. An address that doesn't exist is reported as invalid rather than as an error.
+	ValidateVPA(ctx context.Context, vpa string, livemode bool) (*models.VPAValidation, error)
+}
+
+// SupportsVPAValidation reports whether a provider can look up virtual payment addresses
+func SupportsVPAValidation(provider Provider) bool {
+	_, ok := provider.(VPAValidator)
+	return ok
+}
@@ -0,0 +1,33 @@
+package gateway
+
+import (
+	"payment-gateway/internal/consts"
+)
+
+// WalletTokenProvider is implemented by providers that take Apple Pay or Google Pay payment tokens
+// as they are, decrypting them with merchant certificates registered with the provider. Deposits
+// paid with a token at other providers carry the card decrypted from it.
+type WalletTokenProvider interface {
+	// AcceptsWalletToken reports whether the provider takes a consts.Wallet* wallet's tokens
+	AcceptsWalletToken(wallet string) bool
+}
+
+// AcceptsWalletToken reports whether a provider takes a wallet's payment tokens as they are
+func AcceptsWalletToken(provider Provider, wallet string) bool {
+	tokens, ok := provider.(WalletTokenProvider)
+	return ok && tokens.AcceptsWalletToken(wallet)
+}
+
+// TakesWalletToken reports whether a deposit paid with a wallet's token can be sent to a provider:
+// the provider takes the token itself, or takes cards and is sent the card decrypted from it
+func TakesWalletToken(provider Provider, wallet string) bool {
+	if AcceptsWalletToken(provider, wallet) {
+		return true
+	}
+	for _, method := range PaymentMethods(provider) {
+		if method == consts.PaymentMethodCard {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,186 @@
+package geo
+
+import (
+	"errors"
+	"regexp"
+	"strings"
+)
+
+var (
+	ErrInvalidPhoneNumber = errors.New("phone number is not valid")
+	ErrInvalidPostalCode  = errors.New("postal code is not valid")
+)
+
+// phonePlan describes a country's numbering plan
+type phonePlan struct {
+	callingCode string // ITU-T E.164 country calling code, without "+"
+	trunkPrefix string // dialled before national numbers within the country, e.g. "0"
+	minDigits   int    // length range of the national significant number
+	maxDigits   int
+}
+
+// phonePlans are the numbering plans of the countries we know; numbers from other countries
+// are checked against the general E.164 rules only
+var phonePlans = map[string]phonePlan{
+	"US": {"1", "1", 10, 10},
+	"CA": {"1", "1", 10, 10},
+	"GB": {"44", "0", 9, 10},
+	"DE": {"49", "0", 6, 13},
+	"FR": {"33", "0", 9, 9},
+	"NL": {"31", "0", 9, 9},
+	"ES": {"34", "", 9, 9},
+	"IT": {"39", "", 6, 11},
+	"JP": {"81", "0", 9, 10},
+	"AU": {"61", "0", 9, 9},
+	"IN": {"91", "0", 10, 10},
+	"PK": {"92", "0", 9, 10},
+	"AE": {"971", "0", 8, 9},
+	"SA": {"966", "0", 8, 9},
+	"BR": {"55", "0", 10, 11},
+	"MX": {"52", "", 10, 10},
+	"ZA": {"27", "0", 9, 9},
+	"NG": {"234", "0", 8, 10},
+	"KE": {"254", "0", 9, 9},
+	"GH": {"233", "0", 9, 9},
+	"UG": {"256", "0", 9, 9},
+	"TZ": {"255", "0", 9, 9},
+	"RW": {"250", "0", 9, 9},
+	"ZM": {"260", "0", 9, 9},
+	"EG": {"20", "0", 9, 10},
+}
+
+// NormalizePhoneNumber converts a phone number to E.164 form, e.g. "+447911123456". Numbers
+// may be given in international form ("+44 7911 123456" or "0044...") or, when country is
+// known, in national form ("07911 123456"). International numbers for a known country must use
+// its calling code, and the national number must have a length valid in that country.
+func NormalizePhoneNumber(raw, country string) (string, error) {
+	country = NormalizeCountryCode(country)
+
+	number := stripPhoneSeparators(raw)
+
+	plan, known := phonePlans[country]
+	switch {
+	case strings.HasPrefix(number, "+"):
+		number = number[1:]
+	case strings.HasPrefix(number, "00"):
+		number = number[2:]
+	case known:
+		// A national number; drop the trunk prefix and add the calling code
+		number = plan.callingCode + strings.TrimPrefix(number, plan.trunkPrefix)
+	default:
+		return "", ErrInvalidPhoneNumber
+	}
+
+	// E.164 numbers have at most 15 digits and never start with 0
+	if !isDigits(number) || len(number) < 8 || len(number) > 15 || number[0] == '0' {
+		return "", ErrInvalidPhoneNumber
+	}
+
+	if known {
+		national := strings.TrimPrefix(number, plan.callingCode)
+		if national == number || len(national) < plan.minDigits || len(national) > plan.maxDigits {
+			return "", ErrInvalidPhoneNumber
+		}
+	}
+
+	return "+" + number, nil
+}
+
+// IsE164 reports whether s is a phone number in E.164 form
+func IsE164(s string) bool {
+	normalized, err := NormalizePhoneNumber(s, "")
+	return err == nil && normalized == s
+}
+
+// IsPhoneNumber reports whether raw could be a phone number before its country is known: a
+// valid international number, or 6 to 15 digits in national form
+func IsPhoneNumber(raw string) bool {
+	if _, err := NormalizePhoneNumber(raw, ""); err == nil {
+		return true
+	}
+	number := stripPhoneSeparators(raw)
+	return !strings.HasPrefix(number, "+") && !strings.HasPrefix(number, "00") &&
+		len(number) >= 6 && len(number) <= 15 && isDigits(number)
+}
+
+// stripPhoneSeparators removes the punctuation people use to group the digits of phone numbers,
+// and the "(0)" trunk prefix sometimes written after the calling code, e.g. "+44 (0)20"
+func stripPhoneSeparators(raw string) string {
+	raw = strings.Replace(raw, "(0)", "", 1)
+	return strings.Map(func(r rune) rune {
+		switch r {
+		case ' ', '-', '.', '(', ')', '/':
+			return -1
+		}
+		return r
+	}, strings.TrimSpace(raw))
+}
+
+// postalFormat describes a country's postal code format
+type postalFormat struct {
+	pattern *regexp.Regexp // matches the code upper-cased with spaces and dashes removed
+	format  func(compact string) string
+}
+
+// postalFormats are the postal code formats of the countries we know; codes from other
+// countries only need to look like a postal code
+var postalFormats = map[string]postalFormat{
+	"US": {regexp.MustCompile(`^\d{5}(\d{4})?$`), func(c string) string { return splitAt(c, 5, "-") }},
+	"CA": {regexp.MustCompile(`^[ABCEGHJ-NPRSTVXY]\d[A-Z]\d[A-Z]\d$`), func(c string) string { return splitAt(c, 3, " ") }},
+	"GB": {regexp.MustCompile(`^[A-Z]{1,2}\d[A-Z\d]?\d[A-Z]{2}$`), func(c string) string { return splitAt(c, len(c)-3, " ") }},
+	"NL": {regexp.MustCompile(`^[1-9]\d{3}[A-Z]{2}$`), func(c string) string { return splitAt(c, 4, " ") }},
+	"BR": {regexp.MustCompile(`^\d{8}$`), func(c string) string { return splitAt(c, 5, "-") }},
+	"JP": {regexp.MustCompile(`^\d{7}$`), func(c string) string { return splitAt(c, 3, "-") }},
+	"DE": {regexp.MustCompile(`^\d{5}$`), nil},
+	"FR": {regexp.MustCompile(`^\d{5}$`), nil},
+	"ES": {regexp.MustCompile(`^\d{5}$`), nil},
+	"IT": {regexp.MustCompile(`^\d{5}$`), nil},
+	"MX": {regexp.MustCompile(`^\d{5}$`), nil},
+	"PK": {regexp.MustCompile(`^\d{5}$`), nil},
+	"SA": {regexp.MustCompile(`^\d{5}(\d{4})?$`), func(c string) string { return splitAt(c, 5, "-") }},
+	"IN": {regexp.MustCompile(`^[1-9]\d{5}$`), nil},
+	"NG": {regexp.MustCompile(`^\d{6}$`), nil},
+	"AU": {regexp.MustCompile(`^\d{4}$`), nil},
+	"ZA": {regexp.MustCompile(`^\d{4}$`), nil},
+	"KE": {regexp.MustCompile(`^\d{5}$`), nil},
+}
+
+// genericPostalCode matches the postal codes of countries without a known format
+var genericPostalCode = regexp.MustCompile(`^[A-Z\d][A-Z\d -]{1,8}[A-Z\d]$`)
+
+// NormalizePostalCode converts a postal code to the canonical form used in the country, e.g.
+// "sw1a1aa" to "SW1A 1AA" in GB or "123456789" to "12345-6789" in the US
+func NormalizePostalCode(raw, country string) (string, error) {
+	code := strings.ToUpper(strings.Join(strings.Fields(raw), " "))
+
+	format, known := postalFormats[NormalizeCountryCode(country)]
+	if !known {
+		if !genericPostalCode.MatchString(code) {
+			return "", ErrInvalidPostalCode
+		}
+		return code, nil
+	}
+
+	compact := strings.NewReplacer(" ", "", "-", "").Replace(code)
+	if !format.pattern.MatchString(compact) {
+		return "", ErrInvalidPostalCode
+	}
+	if format.format == nil {
+		return compact, nil
+	}
+	return format.format(compact), nil
+}
+
+// IsPostalCode reports whether raw could be a postal code before its country is known
+func IsPostalCode(raw string) bool {
+	_, err := NormalizePostalCode(raw, "")
+	return err == nil
+}
+
+// splitAt inserts sep into s at index i, unless s ends there
+func splitAt(s string, i int, sep string) string {
+	if i <= 0 || i >= len(s) {
+		return s
+	}
+	return s[:i] + sep + s[i:]
+}
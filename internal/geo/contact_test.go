@@ -0,0 +1,86 @@
+package geo
+
+import (
+	"errors"
+	"testing"
+)
+
+// TestNormalizePhoneNumber tests E.164 normalization of international and national numbers
+func TestNormalizePhoneNumber(t *testing.T) {
+	tests := []struct {
+		name    string
+		raw     string
+		country string
+		want    string
+	}{
+		{"international", "+44 7911 123456", "GB", "+447911123456"},
+		{"international access code", "0044 (0)7911-123456", "", "+447911123456"},
+		{"national with trunk prefix", "07911 123456", "gb", "+447911123456"},
+		{"US national", "(415) 555-2671", "US", "+14155552671"},
+		{"US with trunk prefix", "1-415-555-2671", "US", "+14155552671"},
+		{"mobile money", "0712 345678", "KE", "+254712345678"},
+		{"unknown country international", "+224 612 345 678", "GN", "+224612345678"},
+		{"national without country", "07911 123456", "", ""},
+		{"wrong calling code for country", "+49 1512 3456789", "GB", ""},
+		{"too short for country", "0791112", "GB", ""},
+		{"too long", "+1234567890123456", "", ""},
+		{"letters", "+44 7911 CALLME", "GB", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := NormalizePhoneNumber(tt.raw, tt.country)
+			if tt.want == "" {
+				if !errors.Is(err, ErrInvalidPhoneNumber) {
+					t.Errorf("Expected ErrInvalidPhoneNumber, got %q, %v", got, err)
+				}
+				return
+			}
+			if err != nil || got != tt.want {
+				t.Errorf("Expected %q, got %q, %v", tt.want, got, err)
+			}
+		})
+	}
+
+	if !IsE164("+447911123456") || IsE164("+44 7911 123456") {
+		t.Error("Expected only the compact international form to be E.164")
+	}
+	if !IsPhoneNumber("07911 123456") || IsPhoneNumber("12-34") {
+		t.Error("Expected national numbers of plausible length to be phone numbers")
+	}
+}
+
+// TestNormalizePostalCode tests canonical formatting of known and unknown postal code formats
+func TestNormalizePostalCode(t *testing.T) {
+	tests := []struct {
+		raw     string
+		country string
+		want    string
+	}{
+		{"sw1a1aa", "GB", "SW1A 1AA"},
+		{" EC1A  1BB ", "GB", "EC1A 1BB"},
+		{"123456789", "US", "12345-6789"},
+		{"12345", "US", "12345"},
+		{"k1a0b1", "CA", "K1A 0B1"},
+		{"1012ab", "NL", "1012 AB"},
+		{"1000001", "JP", "100-0001"},
+		{"10115", "DE", "10115"},
+		{"00100", "ZZ", "00100"},
+		{"1234", "US", ""},
+		{"D1A 0B1", "CA", ""},
+		{"ABCDEFGHIJK", "ZZ", ""},
+	}
+
+	for _, tt := range tests {
+		got, err := NormalizePostalCode(tt.raw, tt.country)
+		if tt.want == "" {
+			if !errors.Is(err, ErrInvalidPostalCode) {
+				t.Errorf("%s/%s: expected ErrInvalidPostalCode, got %q, %v", tt.raw, tt.country, got, err)
+			}
+			continue
+		}
+		if err != nil || got != tt.want {
+			t.Errorf("%s/%s: expected %q, got %q, %v", tt.raw, tt.country, tt.want, got, err)
+		}
+	}
+}
@@ -0,0 +1,185 @@
+// Package geo resolves the country a payment originates from using signals available
+// in the request: the client's IP address and the issuing country of the card BIN.
+package geo
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// ClientInfo holds the network-level country signals of the HTTP request that initiated a transaction
+type ClientInfo struct {
+	IP          string
+	CountryCode string // ISO 3166-1 alpha-2, empty if unknown
+}
+
+type clientInfoKey struct{}
+
+// NewContext returns a context carrying the client's country signals
+func NewContext(ctx context.Context, info ClientInfo) context.Context {
+	return context.WithValue(ctx, clientInfoKey{}, info)
+}
+
+// FromContext returns the client's country signals, if any
+func FromContext(ctx context.Context) (ClientInfo, bool) {
+	info, ok := ctx.Value(clientInfoKey{}).(ClientInfo)
+	return info, ok
+}
+
+// IPLocator resolves a client's country from an HTTP request
+type IPLocator struct {
+	// CountryHeader is a header set by a trusted CDN or load balancer with the client's
+	// country, e.g. CF-IPCountry. Ignored when empty.
+	CountryHeader string
+
+	// Networks maps CIDR ranges to country codes for deployments without a CDN header
+	Networks []Network
+}
+
+// Network assigns a country to an IP range
+type Network struct {
+	CIDR        *net.IPNet
+	CountryCode string
+}
+
+// ParseNetworks parses a comma-separated list of CIDR=COUNTRY pairs, e.g. "203.0.113.0/24=GB"
+func ParseNetworks(spec string) ([]Network, error) {
+	var networks []Network
+
+	for _, entry := range strings.Split(spec, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		cidr, country, ok := strings.Cut(entry, "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid network %q: expected CIDR=COUNTRY", entry)
+		}
+
+		_, ipNet, err := net.ParseCIDR(strings.TrimSpace(cidr))
+		if err != nil {
+			return nil, fmt.Errorf("invalid network %q: %w", entry, err)
+		}
+
+		networks = append(networks, Network{CIDR: ipNet, CountryCode: NormalizeCountryCode(country)})
+	}
+
+	return networks, nil
+}
+
+// Locate returns the client's IP and country for a request
+func (l *IPLocator) Locate(r *http.Request) ClientInfo {
+	info := ClientInfo{IP: ClientIP(r)}
+
+	if l.CountryHeader != "" {
+		// "XX" and "T1" are used by CDNs for unknown and Tor clients
+		if code := NormalizeCountryCode(r.Header.Get(l.CountryHeader)); code != "" && code != "XX" && code != "T1" {
+			info.CountryCode = code
+			return info
+		}
+	}
+
+	if ip := net.ParseIP(info.IP); ip != nil {
+		for _, network := range l.Networks {
+			if network.CIDR.Contains(ip) {
+				info.CountryCode = network.CountryCode
+				break
+			}
+		}
+	}
+
+	return info
+}
+
+// Middleware attaches the client's country signals to the request context
+func (l *IPLocator) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		next.ServeHTTP(w, r.WithContext(NewContext(r.Context(), l.Locate(r))))
+	})
+}
+
+// ClientIP returns the originating client IP, preferring the first X-Forwarded-For entry
+func ClientIP(r *http.Request) string {
+	if forwarded := r.Header.Get("X-Forwarded-For"); forwarded != "" {
+		first, _, _ := strings.Cut(forwarded, ",")
+		return strings.TrimSpace(first)
+	}
+
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// BINTable maps card BIN prefixes to the issuing country
+type BINTable map[string]string
+
+// ParseBINTable parses a comma-separated list of PREFIX=COUNTRY pairs, e.g. "424242=US"
+func ParseBINTable(spec string) (BINTable, error) {
+	table := BINTable{}
+
+	for _, entry := range strings.Split(spec, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		prefix, country, ok := strings.Cut(entry, "=")
+		prefix = strings.TrimSpace(prefix)
+		if !ok || prefix == "" || len(prefix) > 8 || !isDigits(prefix) {
+			return nil, fmt.Errorf("invalid BIN entry %q: expected PREFIX=COUNTRY", entry)
+		}
+
+		table[prefix] = NormalizeCountryCode(country)
+	}
+
+	return table, nil
+}
+
+// Lookup returns the issuing country of the longest matching BIN prefix
+func (t BINTable) Lookup(bin string) (string, bool) {
+	prefixes := make([]string, 0, len(t))
+	for prefix := range t {
+		prefixes = append(prefixes, prefix)
+	}
+	sort.Slice(prefixes, func(i, j int) bool { return len(prefixes[i]) > len(prefixes[j]) })
+
+	for _, prefix := range prefixes {
+		if strings.HasPrefix(bin, prefix) {
+			return t[prefix], true
+		}
+	}
+	return "", false
+}
+
+// IsValidBIN reports whether s is a 6 to 8 digit card BIN
+func IsValidBIN(s string) bool {
+	return len(s) >= 6 && len(s) <= 8 && isDigits(s)
+}
+
+// isDigits reports whether s consists only of ASCII digits
+func isDigits(s string) bool {
+	for _, c := range s {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// NormalizeCountryCode trims and upper-cases an ISO 3166-1 alpha-2 country code
+func NormalizeCountryCode(code string) string {
+	return strings.ToUpper(strings.TrimSpace(code))
+}
+
+// IsValidCountryCode reports whether code is a two-letter country code
+func IsValidCountryCode(code string) bool {
+	code = NormalizeCountryCode(code)
+	return len(code) == 2 && code[0] >= 'A' && code[0] <= 'Z' && code[1] >= 'A' && code[1] <= 'Z'
+}
@@ -0,0 +1,68 @@
+package geo
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+// TestLocate tests that the CDN header wins over configured networks
+func TestLocate(t *testing.T) {
+	networks, err := ParseNetworks("203.0.113.0/24=gb, 198.51.100.0/24=DE")
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	locator := &IPLocator{CountryHeader: "CF-IPCountry", Networks: networks}
+
+	tests := []struct {
+		name       string
+		remoteAddr string
+		forwarded  string
+		header     string
+		country    string
+	}{
+		{"remote address network", "203.0.113.9:5000", "", "", "GB"},
+		{"forwarded network", "10.0.0.1:5000", "198.51.100.4, 10.0.0.1", "", "DE"},
+		{"header wins", "203.0.113.9:5000", "", "us", "US"},
+		{"unknown header ignored", "203.0.113.9:5000", "", "XX", "GB"},
+		{"no match", "192.0.2.1:5000", "", "", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("POST", "/deposit", nil)
+			r.RemoteAddr = tt.remoteAddr
+			if tt.forwarded != "" {
+				r.Header.Set("X-Forwarded-For", tt.forwarded)
+			}
+			if tt.header != "" {
+				r.Header.Set("CF-IPCountry", tt.header)
+			}
+
+			if info := locator.Locate(r); info.CountryCode != tt.country {
+				t.Errorf("Expected country %q, got %q", tt.country, info.CountryCode)
+			}
+		})
+	}
+}
+
+// TestBINTableLookup tests that the longest matching prefix determines the issuing country
+func TestBINTableLookup(t *testing.T) {
+	table, err := ParseBINTable("4=US,424242=GB")
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+
+	if country, _ := table.Lookup("42424299"); country != "GB" {
+		t.Errorf("Expected GB, got %q", country)
+	}
+	if country, _ := table.Lookup("411111"); country != "US" {
+		t.Errorf("Expected US, got %q", country)
+	}
+	if _, ok := table.Lookup("511111"); ok {
+		t.Error("Expected no match")
+	}
+
+	if _, err := ParseBINTable("42x=US"); err == nil {
+		t.Error("Expected invalid prefix to be rejected")
+	}
+}
@@ -0,0 +1,227 @@
+// Package httpclient is the HTTP transport shared by providers that call a gateway's REST API.
+// Each Client pools connections per host with a cap on concurrent connections, retries requests
+// that are safe to repeat, records per-host metrics and propagates a W3C traceparent header, so
+// provider implementations only build requests and parse responses.
+package httpclient
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math/rand"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// IdempotencyKeyHeader marks a request that a gateway deduplicates, so it can be retried whatever its method
+const IdempotencyKeyHeader = "Idempotency-Key"
+
+// Config configures a Client
+type Config struct {
+	Name                string        // provider name, reported in metrics and logs
+	Timeout             time.Duration // per attempt, including reading the response body
+	MaxConnsPerHost     int           // concurrent connections per host, 0 for no limit
+	MaxIdleConnsPerHost int
+	IdleConnTimeout     time.Duration
+	MaxRetries          int // retries after the first attempt
+	InitialBackoff      time.Duration
+	MaxBackoff          time.Duration
+	TLS                 *tls.Config   // e.g. client certificates for gateways requiring mTLS
+	OnAttempt           func(Attempt) // called after every attempt, e.g. to export traces
+}
+
+// DefaultConfig returns the configuration providers start from
+func DefaultConfig(name string) Config {
+	return Config{
+		Name:                name,
+		Timeout:             30 * time.Second,
+		MaxConnsPerHost:     50,
+		MaxIdleConnsPerHost: 10,
+		IdleConnTimeout:     90 * time.Second,
+		MaxRetries:          2,
+		InitialBackoff:      200 * time.Millisecond,
+		MaxBackoff:          5 * time.Second,
+	}
+}
+
+// Client sends a provider's requests
+type Client struct {
+	config  Config
+	http    *http.Client
+	metrics *metrics
+}
+
+// New creates a client and registers its metrics for Stats
+func New(config Config) *Client {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.MaxConnsPerHost = config.MaxConnsPerHost
+	transport.MaxIdleConnsPerHost = config.MaxIdleConnsPerHost
+	transport.IdleConnTimeout = config.IdleConnTimeout
+	if config.TLS != nil {
+		transport.TLSClientConfig = config.TLS.Clone()
+	}
+
+	c := &Client{
+		config:  config,
+		http:    &http.Client{Transport: transport, Timeout: config.Timeout},
+		metrics: newMetrics(config.Name),
+	}
+	register(c)
+	return c
+}
+
+// Name returns the provider name the client reports metrics under
+func (c *Client) Name() string {
+	return c.config.Name
+}
+
+// CloseIdleConnections closes pooled connections, so the next requests open connections with
+// current TLS settings such as a rotated client certificate
+func (c *Client) CloseIdleConnections() {
+	c.http.CloseIdleConnections()
+}
+
+// Do sends a request, retrying transport errors and 429, 502, 503 and 504 responses with
+// exponential backoff when the request is safe to repeat: its method is idempotent or it carries
+// an Idempotency-Key, and its body can be replayed. Every attempt carries a traceparent header
+// with the trace of the request's context, or a new trace.
+func (c *Client) Do(req *http.Request) (*http.Response, error) {
+	ctx := req.Context()
+	traceID, ok := TraceIDFromContext(ctx)
+	if !ok {
+		traceID = newTraceID()
+	}
+
+	retries := 0
+	if retrySafe(req) {
+		retries = c.config.MaxRetries
+	}
+
+	backoff := c.config.InitialBackoff
+	for attempt := 1; ; attempt++ {
+		resp, err := c.attempt(req, attempt, traceID)
+		if attempt > retries || !retryable(resp, err) || ctx.Err() != nil {
+			return resp, err
+		}
+
+		wait := backoff + time.Duration(rand.Int63n(int64(backoff)/2+1))
+		if resp != nil {
+			if after, ok := retryAfter(resp); ok {
+				wait = after
+			}
+			io.Copy(io.Discard, resp.Body)
+			resp.Body.Close()
+		}
+		if wait > c.config.MaxBackoff {
+			wait = c.config.MaxBackoff
+		}
+
+		log.Printf("%s request to %s failed (attempt %d/%d, trace %s), retrying in %v: %s",
+			c.config.Name, req.URL.Host, attempt, retries+1, traceID, wait, describe(resp, err))
+		c.metrics.retried(req.URL.Host)
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, ctx.Err()
+		}
+
+		backoff *= 2
+		if backoff > c.config.MaxBackoff {
+			backoff = c.config.MaxBackoff
+		}
+	}
+}
+
+// attempt sends one attempt of a request, traced with a new span of the request's trace
+func (c *Client) attempt(req *http.Request, number int, traceID string) (*http.Response, error) {
+	trace := &Attempt{
+		Client:  c.config.Name,
+		Method:  req.Method,
+		Host:    req.URL.Host,
+		Number:  number,
+		TraceID: traceID,
+		SpanID:  newSpanID(),
+	}
+
+	attemptReq := req.Clone(withClientTrace(req.Context(), trace))
+	if number > 1 && req.GetBody != nil {
+		body, err := req.GetBody()
+		if err != nil {
+			return nil, fmt.Errorf("failed to replay request body: %w", err)
+		}
+		attemptReq.Body = body
+	}
+	attemptReq.Header.Set(TraceparentHeader, trace.traceparent())
+
+	c.metrics.started(req.URL.Host)
+	start := time.Now()
+	resp, err := c.http.Do(attemptReq)
+	trace.Duration = time.Since(start)
+	trace.Err = err
+	if resp != nil {
+		trace.StatusCode = resp.StatusCode
+	}
+
+	c.metrics.finished(trace)
+	if c.config.OnAttempt != nil {
+		c.config.OnAttempt(*trace)
+	}
+	return resp, err
+}
+
+// retrySafe reports whether repeating a request cannot apply it twice and its body can be replayed
+func retrySafe(req *http.Request) bool {
+	if req.Body != nil && req.Body != http.NoBody && req.GetBody == nil {
+		return false
+	}
+
+	switch req.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodPut, http.MethodDelete:
+		return true
+	}
+	return req.Header.Get(IdempotencyKeyHeader) != ""
+}
+
+// retryable reports whether an attempt failed in a way a later attempt may not
+func retryable(resp *http.Response, err error) bool {
+	if err != nil {
+		return !errors.Is(err, context.Canceled)
+	}
+
+	switch resp.StatusCode {
+	case http.StatusTooManyRequests, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
+
+// retryAfter returns the delay a response's Retry-After header asks for, in seconds
+func retryAfter(resp *http.Response) (time.Duration, bool) {
+	seconds, err := strconv.Atoi(resp.Header.Get("Retry-After"))
+	if err != nil || seconds < 0 {
+		return 0, false
+	}
+	return time.Duration(seconds) * time.Second, true
+}
+
+// timedOut reports whether an attempt failed because it ran out of time
+func timedOut(err error) bool {
+	var netErr net.Error
+	return errors.Is(err, context.DeadlineExceeded) || (errors.As(err, &netErr) && netErr.Timeout())
+}
+
+// describe summarizes why an attempt failed
+func describe(resp *http.Response, err error) string {
+	if err != nil {
+		return err.Error()
+	}
+	return resp.Status
+}
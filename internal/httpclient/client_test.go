@@ -0,0 +1,125 @@
+package httpclient
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// testConfig returns a configuration with short backoffs
+func testConfig(name string) Config {
+	config := DefaultConfig(name)
+	config.InitialBackoff = time.Millisecond
+	config.MaxBackoff = 10 * time.Millisecond
+	return config
+}
+
+// TestDoRetries tests that only requests safe to repeat are retried, with the same trace
+func TestDoRetries(t *testing.T) {
+	var calls int32
+	var traceparents []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		traceparents = append(traceparents, r.Header.Get(TraceparentHeader))
+		if atomic.AddInt32(&calls, 1)%3 != 0 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	client := New(testConfig("RetryTest"))
+	traceID := strings.Repeat("ab", 16)
+
+	req, _ := http.NewRequestWithContext(WithTraceID(context.Background(), traceID), http.MethodPost, server.URL+"/payments", bytes.NewReader([]byte(`{"amount":10}`)))
+	req.Header.Set(IdempotencyKeyHeader, "pgw-123")
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || calls != 3 {
+		t.Fatalf("Expected success on the third attempt, got %d after %d attempts", resp.StatusCode, calls)
+	}
+
+	spans := map[string]bool{}
+	for _, traceparent := range traceparents {
+		parts := strings.Split(traceparent, "-")
+		if len(parts) != 4 || parts[1] != traceID {
+			t.Errorf("Expected every attempt to carry trace %s, got %q", traceID, traceparent)
+			continue
+		}
+		spans[parts[2]] = true
+	}
+	if len(spans) != 3 {
+		t.Errorf("Expected a new span per attempt, got %v", traceparents)
+	}
+
+	// A POST without an idempotency key could be applied twice, so it is not retried
+	calls = 0
+	req, _ = http.NewRequest(http.MethodPost, server.URL+"/payments", bytes.NewReader([]byte(`{"amount":10}`)))
+	resp, err = client.Do(req)
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusServiceUnavailable || calls != 1 {
+		t.Errorf("Expected a single attempt, got %d after %d attempts", resp.StatusCode, calls)
+	}
+
+	stats := Stats()
+	var found bool
+	for _, s := range stats {
+		if s.Client != "RetryTest" {
+			continue
+		}
+		found = true
+		if s.Requests != 4 || s.Retries != 2 || s.Failures != 3 || s.InFlight != 0 {
+			t.Errorf("Expected 4 requests, 2 retries and 3 failures, got %+v", s)
+		}
+	}
+	if !found {
+		t.Errorf("Expected stats for the client, got %+v", stats)
+	}
+}
+
+// TestDoTimeout tests that each attempt is bounded by the timeout and the timeout is counted
+func TestDoTimeout(t *testing.T) {
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer server.Close()
+	defer close(release)
+
+	config := testConfig("TimeoutTest")
+	config.Timeout = 50 * time.Millisecond
+	config.MaxRetries = 1
+
+	var attempts []Attempt
+	config.OnAttempt = func(a Attempt) { attempts = append(attempts, a) }
+	client := New(config)
+
+	req, _ := http.NewRequest(http.MethodGet, server.URL+"/status", nil)
+	start := time.Now()
+	if _, err := client.Do(req); err == nil {
+		t.Fatal("Expected the request to time out")
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("Expected both attempts to time out within a second, took %s", elapsed)
+	}
+
+	if len(attempts) != 2 || attempts[1].Number != 2 || attempts[0].TraceID != attempts[1].TraceID {
+		t.Errorf("Expected two attempts of one trace, got %+v", attempts)
+	}
+	for _, s := range Stats() {
+		if s.Client == "TimeoutTest" && s.Timeouts != 2 {
+			t.Errorf("Expected 2 timeouts, got %+v", s)
+		}
+	}
+}
@@ -0,0 +1,140 @@
+package httpclient
+
+import (
+	"payment-gateway/internal/models"
+	"sort"
+	"sync"
+	"time"
+)
+
+// hostMetrics accumulates the attempts a client has sent to one host
+type hostMetrics struct {
+	inFlight     int64
+	requests     int64
+	retries      int64
+	failures     int64
+	timeouts     int64
+	reusedConns  int64
+	totalLatency time.Duration
+	maxLatency   time.Duration
+}
+
+// metrics accumulates a client's attempts per host
+type metrics struct {
+	client string
+
+	mu    sync.Mutex
+	hosts map[string]*hostMetrics
+}
+
+func newMetrics(client string) *metrics {
+	return &metrics{client: client, hosts: make(map[string]*hostMetrics)}
+}
+
+// host returns the metrics of a host; the caller must hold m.mu
+func (m *metrics) host(name string) *hostMetrics {
+	host, ok := m.hosts[name]
+	if !ok {
+		host = &hostMetrics{}
+		m.hosts[name] = host
+	}
+	return host
+}
+
+func (m *metrics) started(host string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.host(host).inFlight++
+}
+
+func (m *metrics) retried(host string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.host(host).retries++
+}
+
+func (m *metrics) finished(attempt *Attempt) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	host := m.host(attempt.Host)
+	host.inFlight--
+	host.requests++
+	host.totalLatency += attempt.Duration
+	if attempt.Duration > host.maxLatency {
+		host.maxLatency = attempt.Duration
+	}
+	if attempt.ConnReused {
+		host.reusedConns++
+	}
+	if attempt.Err != nil || attempt.StatusCode >= 500 {
+		host.failures++
+	}
+	if timedOut(attempt.Err) {
+		host.timeouts++
+	}
+}
+
+// snapshot returns the client's stats per host
+func (m *metrics) snapshot() []models.HTTPClientStats {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	stats := make([]models.HTTPClientStats, 0, len(m.hosts))
+	for name, host := range m.hosts {
+		s := models.HTTPClientStats{
+			Client:       m.client,
+			Host:         name,
+			InFlight:     host.inFlight,
+			Requests:     host.requests,
+			Retries:      host.retries,
+			Failures:     host.failures,
+			Timeouts:     host.timeouts,
+			ReusedConns:  host.reusedConns,
+			MaxLatencyMS: milliseconds(host.maxLatency),
+		}
+		if host.requests > 0 {
+			s.AverageLatencyMS = milliseconds(host.totalLatency / time.Duration(host.requests))
+		}
+		stats = append(stats, s)
+	}
+	return stats
+}
+
+func milliseconds(d time.Duration) float64 {
+	return float64(d.Microseconds()) / 1000
+}
+
+// registry holds every client created, for Stats
+var registry struct {
+	mu      sync.Mutex
+	clients []*Client
+}
+
+func register(c *Client) {
+	registry.mu.Lock()
+	defer registry.mu.Unlock()
+
+	registry.clients = append(registry.clients, c)
+}
+
+// Stats returns the stats of every client per host, ordered by client and host
+func Stats() []models.HTTPClientStats {
+	registry.mu.Lock()
+	clients := append([]*Client(nil), registry.clients...)
+	registry.mu.Unlock()
+
+	stats := []models.HTTPClientStats{}
+	for _, c := range clients {
+		stats = append(stats, c.metrics.snapshot()...)
+	}
+	sort.Slice(stats, func(i, j int) bool {
+		if stats[i].Client != stats[j].Client {
+			return stats[i].Client < stats[j].Client
+		}
+		return stats[i].Host < stats[j].Host
+	})
+	return stats
+}
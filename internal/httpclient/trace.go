@@ -0,0 +1,110 @@
+package httpclient
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/hex"
+	"net/http/httptrace"
+	"regexp"
+	"time"
+)
+
+// TraceparentHeader carries the W3C trace context of a request to the gateway
+const TraceparentHeader = "traceparent"
+
+var traceIDPattern = regexp.MustCompile(`^[0-9a-f]{32}$`)
+
+// Attempt traces one attempt of a request
+type Attempt struct {
+	Client     string
+	Method     string
+	Host       string
+	Number     int // 1 for the first attempt
+	TraceID    string
+	SpanID     string
+	StatusCode int   // 0 when no response was received
+	Err        error // transport error, if any
+	Duration   time.Duration
+
+	ConnReused   bool
+	DNS          time.Duration
+	Connect      time.Duration
+	TLSHandshake time.Duration
+	FirstByte    time.Duration // from sending the request to the first response byte
+}
+
+// traceparent renders the attempt's W3C traceparent header, sampled
+func (a *Attempt) traceparent() string {
+	return "00-" + a.TraceID + "-" + a.SpanID + "-01"
+}
+
+type traceIDKey struct{}
+
+// WithTraceID returns a context whose requests are traced under a 32 hex digit trace ID, so
+// every gateway call made for one transaction shares a trace. Invalid IDs are ignored.
+func WithTraceID(ctx context.Context, traceID string) context.Context {
+	if !traceIDPattern.MatchString(traceID) {
+		return ctx
+	}
+	return context.WithValue(ctx, traceIDKey{}, traceID)
+}
+
+// TraceIDFromContext returns the trace ID requests made with the context are traced under
+func TraceIDFromContext(ctx context.Context) (string, bool) {
+	traceID, ok := ctx.Value(traceIDKey{}).(string)
+	return traceID, ok
+}
+
+// withClientTrace records connection and response timings of an attempt in its trace
+func withClientTrace(ctx context.Context, attempt *Attempt) context.Context {
+	var dnsStart, connectStart, tlsStart, wroteRequest time.Time
+
+	return httptrace.WithClientTrace(ctx, &httptrace.ClientTrace{
+		GotConn: func(info httptrace.GotConnInfo) {
+			attempt.ConnReused = info.Reused
+		},
+		DNSStart: func(httptrace.DNSStartInfo) {
+			dnsStart = time.Now()
+		},
+		DNSDone: func(httptrace.DNSDoneInfo) {
+			attempt.DNS = time.Since(dnsStart)
+		},
+		ConnectStart: func(string, string) {
+			connectStart = time.Now()
+		},
+		ConnectDone: func(string, string, error) {
+			attempt.Connect = time.Since(connectStart)
+		},
+		TLSHandshakeStart: func() {
+			tlsStart = time.Now()
+		},
+		TLSHandshakeDone: func(tls.ConnectionState, error) {
+			attempt.TLSHandshake = time.Since(tlsStart)
+		},
+		WroteRequest: func(httptrace.WroteRequestInfo) {
+			wroteRequest = time.Now()
+		},
+		GotFirstResponseByte: func() {
+			if !wroteRequest.IsZero() {
+				attempt.FirstByte = time.Since(wroteRequest)
+			}
+		},
+	})
+}
+
+// newTraceID returns a random 16-byte trace ID
+func newTraceID() string {
+	return randomHex(16)
+}
+
+// newSpanID returns a random 8-byte span ID
+func newSpanID() string {
+	return randomHex(8)
+}
+
+func randomHex(n int) string {
+	b := make([]byte, n)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
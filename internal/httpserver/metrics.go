@@ -0,0 +1,135 @@
+package httpserver
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"payment-gateway/internal/models"
+	"sort"
+	"sync"
+	"sync/atomic"
+)
+
+// connKey is the context key of the requests a connection has served
+type connKey struct{}
+
+// metrics accumulates a server's connections and the requests served on them
+type metrics struct {
+	addr      string
+	protocols []string
+
+	mu          sync.Mutex
+	states      map[net.Conn]http.ConnState
+	idle        int64
+	connsOpened int64
+	idleClosed  int64
+
+	requests      atomic.Int64
+	reusedConns   atomic.Int64
+	http2Requests atomic.Int64
+}
+
+func newMetrics(addr string, protocols []string) *metrics {
+	return &metrics{addr: addr, protocols: protocols, states: make(map[net.Conn]http.ConnState)}
+}
+
+// connContext gives each connection's requests a shared count of the requests served on it
+func (m *metrics) connContext(ctx context.Context, conn net.Conn) context.Context {
+	return context.WithValue(ctx, connKey{}, new(atomic.Int64))
+}
+
+// handler counts the requests next serves, and those served on a connection used before
+func (m *metrics) handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		m.requests.Add(1)
+		if r.ProtoMajor == 2 {
+			m.http2Requests.Add(1)
+		}
+		if served, ok := r.Context().Value(connKey{}).(*atomic.Int64); ok && served.Add(1) > 1 {
+			m.reusedConns.Add(1)
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// stateChanged records a connection's new state and returns the number of idle connections
+func (m *metrics) stateChanged(conn net.Conn, state http.ConnState) int64 {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	previous, known := m.states[conn]
+	if previous == http.StateIdle && known {
+		m.idle--
+	}
+
+	switch state {
+	case http.StateNew:
+		m.connsOpened++
+		m.states[conn] = state
+	case http.StateIdle:
+		m.idle++
+		m.states[conn] = state
+	case http.StateHijacked, http.StateClosed:
+		delete(m.states, conn)
+	default:
+		m.states[conn] = state
+	}
+	return m.idle
+}
+
+// idleLimited records a connection closed because the idle limit was reached
+func (m *metrics) idleLimited() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.idleClosed++
+}
+
+// snapshot returns the server's stats
+func (m *metrics) snapshot() models.HTTPServerStats {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	s := models.HTTPServerStats{
+		Addr:            m.addr,
+		Protocols:       m.protocols,
+		OpenConns:       int64(len(m.states)),
+		IdleConns:       m.idle,
+		ConnsOpened:     m.connsOpened,
+		IdleConnsClosed: m.idleClosed,
+		Requests:        m.requests.Load(),
+		HTTP2Requests:   m.http2Requests.Load(),
+		ReusedConns:     m.reusedConns.Load(),
+	}
+	if s.ConnsOpened > 0 {
+		s.RequestsPerConn = float64(s.Requests) / float64(s.ConnsOpened)
+	}
+	return s
+}
+
+// registry holds every server created, for Stats
+var registry struct {
+	mu      sync.Mutex
+	servers []*Server
+}
+
+func register(s *Server) {
+	registry.mu.Lock()
+	defer registry.mu.Unlock()
+
+	registry.servers = append(registry.servers, s)
+}
+
+// Stats returns the stats of every server, ordered by address
+func Stats() []models.HTTPServerStats {
+	registry.mu.Lock()
+	servers := append([]*Server(nil), registry.servers...)
+	registry.mu.Unlock()
+
+	stats := []models.HTTPServerStats{}
+	for _, s := range servers {
+		stats = append(stats, s.metrics.snapshot())
+	}
+	sort.SliceStable(stats, func(i, j int) bool { return stats[i].Addr < stats[j].Addr })
+	return stats
+}
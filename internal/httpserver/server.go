@@ -0,0 +1,209 @@
+// Package httpserver is the API's HTTP server. It listens on a TCP address or a Unix domain socket,
+// chooses the protocols served, HTTP/1.1 and HTTP/2 over TLS and HTTP/2 without TLS (h2c) for
+// internal callers, tunes TCP keep-alives and idle connections, and records per-connection metrics
+// showing how well clients reuse connections.
+package httpserver
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// Config configures a Server
+type Config struct {
+	Addr       string      // host:port, or unix:/path for a Unix domain socket
+	SocketMode os.FileMode // permissions of a Unix socket, 0 for 0660 so only its owner and group connect
+
+	// Protocols: HTTP/1.1 is always served. HTTP/2 is negotiated over TLS when HTTP2 is set and a
+	// certificate is configured; H2C accepts HTTP/2 without TLS from clients that use it with prior
+	// knowledge, such as internal services behind the same network boundary.
+	HTTP2       bool
+	H2C         bool
+	TLSCertFile string
+	TLSKeyFile  string
+
+	ReadHeaderTimeout time.Duration
+	ReadTimeout       time.Duration
+	WriteTimeout      time.Duration
+	IdleTimeout       time.Duration // how long a keep-alive connection may wait for its next request
+
+	MaxIdleConns         int           // idle keep-alive connections kept open, 0 for no limit
+	MaxConcurrentStreams int           // requests in flight per HTTP/2 connection, 0 for the default of 250
+	MaxHeaderBytes       int           // 0 for the default of 1 MB
+	TCPKeepAlive         bool          // probe idle TCP connections so dead peers are detected
+	TCPKeepAliveIdle     time.Duration // idle time before the first probe
+	TCPKeepAliveInterval time.Duration // time between unanswered probes
+	TCPKeepAliveCount    int           // unanswered probes before the connection is dropped
+}
+
+// DefaultConfig returns the configuration the API is served with unless overridden
+func DefaultConfig(addr string) Config {
+	return Config{
+		Addr:                 addr,
+		HTTP2:                true,
+		ReadHeaderTimeout:    5 * time.Second,
+		ReadTimeout:          15 * time.Second,
+		WriteTimeout:         15 * time.Second,
+		IdleTimeout:          60 * time.Second,
+		TCPKeepAlive:         true,
+		TCPKeepAliveIdle:     30 * time.Second,
+		TCPKeepAliveInterval: 15 * time.Second,
+		TCPKeepAliveCount:    4,
+	}
+}
+
+// TLS reports whether the server is configured with a certificate
+func (c Config) TLS() bool {
+	return c.TLSCertFile != "" && c.TLSKeyFile != ""
+}
+
+// SocketPath returns the path of the Unix socket the server listens on, if Addr names one
+func (c Config) SocketPath() (string, bool) {
+	return strings.CutPrefix(c.Addr, "unix:")
+}
+
+// Server serves a handler with the configured protocols and records its connection metrics
+type Server struct {
+	config  Config
+	http    *http.Server
+	metrics *metrics
+}
+
+// New creates a server and registers its metrics for Stats
+func New(config Config, handler http.Handler) *Server {
+	protocols := new(http.Protocols)
+	protocols.SetHTTP1(true)
+	protocols.SetHTTP2(config.HTTP2 && config.TLS())
+	protocols.SetUnencryptedHTTP2(config.H2C)
+
+	s := &Server{config: config, metrics: newMetrics(config.Addr, protocolNames(config))}
+	s.http = &http.Server{
+		Addr:              config.Addr,
+		Handler:           s.metrics.handler(handler),
+		ReadHeaderTimeout: config.ReadHeaderTimeout,
+		ReadTimeout:       config.ReadTimeout,
+		WriteTimeout:      config.WriteTimeout,
+		IdleTimeout:       config.IdleTimeout,
+		MaxHeaderBytes:    config.MaxHeaderBytes,
+		Protocols:         protocols,
+		HTTP2:             &http.HTTP2Config{MaxConcurrentStreams: config.MaxConcurrentStreams},
+		ConnContext:       s.metrics.connContext,
+		ConnState:         s.connState,
+	}
+	register(s)
+	return s
+}
+
+// ListenAndServe listens on the configured address, with TCP keep-alives on a TCP address, and
+// serves until the server is shut down, returning http.ErrServerClosed then. A Unix socket is
+// removed once the server is shut down.
+func (s *Server) ListenAndServe() error {
+	listener, err := s.listen()
+	if err != nil {
+		return err
+	}
+	return s.Serve(listener)
+}
+
+// Serve serves connections accepted from a listener, over TLS when a certificate is configured
+func (s *Server) Serve(listener net.Listener) error {
+	if s.config.TLS() {
+		s.http.TLSConfig = &tls.Config{MinVersion: tls.VersionTLS12}
+		return s.http.ServeTLS(listener, s.config.TLSCertFile, s.config.TLSKeyFile)
+	}
+	return s.http.Serve(listener)
+}
+
+// Shutdown stops accepting connections, closes idle ones and waits for active ones to finish
+// their requests or ctx to be done
+func (s *Server) Shutdown(ctx context.Context) error {
+	return s.http.Shutdown(ctx)
+}
+
+// listen opens the TCP listener, enabling keep-alive probes on accepted connections as configured,
+// or the Unix socket listener
+func (s *Server) listen() (net.Listener, error) {
+	if path, ok := s.config.SocketPath(); ok {
+		return listenUnix(path, s.config.SocketMode)
+	}
+
+	lc := net.ListenConfig{KeepAlive: -1}
+	if s.config.TCPKeepAlive {
+		lc.KeepAliveConfig = net.KeepAliveConfig{
+			Enable:   true,
+			Idle:     s.config.TCPKeepAliveIdle,
+			Interval: s.config.TCPKeepAliveInterval,
+			Count:    s.config.TCPKeepAliveCount,
+		}
+	}
+
+	addr := s.config.Addr
+	if addr == "" {
+		addr = ":http"
+		if s.config.TLS() {
+			addr = ":https"
+		}
+	}
+	return lc.Listen(context.Background(), "tcp", addr)
+}
+
+// listenUnix listens on a Unix socket, replacing one left behind by a process that exited without
+// removing it. A socket another process still accepts connections on is left alone.
+func listenUnix(path string, mode os.FileMode) (net.Listener, error) {
+	if info, err := os.Lstat(path); err == nil {
+		if info.Mode().Type() != fs.ModeSocket {
+			return nil, fmt.Errorf("cannot listen on %s: file exists and is not a socket", path)
+		}
+		if conn, err := net.DialTimeout("unix", path, time.Second); err == nil {
+			conn.Close()
+			return nil, fmt.Errorf("cannot listen on %s: socket is in use", path)
+		}
+		if err := os.Remove(path); err != nil {
+			return nil, err
+		}
+	} else if !errors.Is(err, fs.ErrNotExist) {
+		return nil, err
+	}
+
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	if mode == 0 {
+		mode = 0o660
+	}
+	if err := os.Chmod(path, mode); err != nil {
+		listener.Close()
+		return nil, err
+	}
+	return listener, nil
+}
+
+// connState records connection state changes, closing connections going idle beyond the limit
+func (s *Server) connState(conn net.Conn, state http.ConnState) {
+	idle := s.metrics.stateChanged(conn, state)
+	if state == http.StateIdle && s.config.MaxIdleConns > 0 && idle > int64(s.config.MaxIdleConns) {
+		s.metrics.idleLimited()
+		conn.Close()
+	}
+}
+
+// protocolNames lists the protocols a configuration serves, by their ALPN names
+func protocolNames(config Config) []string {
+	names := []string{"http/1.1"}
+	if config.HTTP2 && config.TLS() {
+		names = append(names, "h2")
+	}
+	if config.H2C {
+		names = append(names, "h2c")
+	}
+	return names
+}
@@ -0,0 +1,180 @@
+package httpserver
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"payment-gateway/internal/models"
+	"strings"
+	"testing"
+	"time"
+)
+
+// startServer serves a handler answering "ok" on a local port, returning the server and its URL
+func startServer(t *testing.T, config Config) (*Server, string) {
+	t.Helper()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	config.Addr = listener.Addr().String()
+	s := New(config, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "ok")
+	}))
+
+	go s.Serve(listener)
+	t.Cleanup(func() { s.http.Close() })
+	return s, "http://" + config.Addr
+}
+
+// get sends a GET and reads its body, so the connection can be reused
+func get(t *testing.T, client *http.Client, url string) *http.Response {
+	t.Helper()
+
+	resp, err := client.Get(url)
+	if err != nil {
+		t.Fatalf("GET %s: %v", url, err)
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+	return resp
+}
+
+// waitFor polls a server's stats until cond holds, failing after a second
+func waitFor(t *testing.T, s *Server, cond func(models.HTTPServerStats) bool) {
+	t.Helper()
+
+	deadline := time.Now().Add(time.Second)
+	for !cond(s.metrics.snapshot()) {
+		if time.Now().After(deadline) {
+			t.Fatalf("Timed out waiting for stats, got %+v", s.metrics.snapshot())
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// TestServerCountsReusedConnections tests that requests sent over a kept-alive connection are
+// counted as reusing it
+func TestServerCountsReusedConnections(t *testing.T) {
+	s, url := startServer(t, DefaultConfig(""))
+	client := &http.Client{Transport: &http.Transport{}}
+
+	for i := 0; i < 3; i++ {
+		get(t, client, url)
+	}
+
+	stats := s.metrics.snapshot()
+	if stats.Requests != 3 || stats.ReusedConns != 2 || stats.ConnsOpened != 1 {
+		t.Errorf("Expected 3 requests on 1 connection, 2 reusing it, got %+v", stats)
+	}
+	if stats.RequestsPerConn != 3 {
+		t.Errorf("Expected 3 requests per connection, got %g", stats.RequestsPerConn)
+	}
+	waitFor(t, s, func(stats models.HTTPServerStats) bool { return stats.OpenConns == 1 && stats.IdleConns == 1 })
+}
+
+// TestServerH2C tests that HTTP/2 without TLS is served only when enabled
+func TestServerH2C(t *testing.T) {
+	protocols := new(http.Protocols)
+	protocols.SetUnencryptedHTTP2(true)
+	client := &http.Client{Transport: &http.Transport{Protocols: protocols}}
+
+	config := DefaultConfig("")
+	config.H2C = true
+	s, url := startServer(t, config)
+
+	for i := 0; i < 2; i++ {
+		if resp := get(t, client, url); resp.ProtoMajor != 2 {
+			t.Fatalf("Expected an HTTP/2 response, got %s", resp.Proto)
+		}
+	}
+	stats := s.metrics.snapshot()
+	if stats.HTTP2Requests != 2 || stats.ReusedConns != 1 || stats.ConnsOpened != 1 {
+		t.Errorf("Expected 2 HTTP/2 requests on 1 connection, got %+v", stats)
+	}
+	if len(stats.Protocols) != 2 || stats.Protocols[1] != "h2c" {
+		t.Errorf("Expected http/1.1 and h2c served, got %v", stats.Protocols)
+	}
+
+	_, plainURL := startServer(t, DefaultConfig(""))
+	if resp, err := client.Get(plainURL); err == nil {
+		resp.Body.Close()
+		t.Error("Expected HTTP/2 without TLS refused when h2c is disabled")
+	}
+}
+
+// TestServerIdleConnectionLimit tests that connections going idle beyond the limit are closed
+func TestServerIdleConnectionLimit(t *testing.T) {
+	config := DefaultConfig("")
+	config.MaxIdleConns = 1
+	s, url := startServer(t, config)
+
+	first := &http.Client{Transport: &http.Transport{}}
+	second := &http.Client{Transport: &http.Transport{}}
+	get(t, first, url)
+	get(t, second, url)
+
+	waitFor(t, s, func(stats models.HTTPServerStats) bool {
+		return stats.IdleConnsClosed == 1 && stats.IdleConns == 1 && stats.OpenConns == 1
+	})
+
+	// The client whose connection was closed reconnects transparently
+	get(t, first, url)
+	get(t, second, url)
+}
+
+// TestServerUnixSocket tests that a server listens on a Unix socket, replacing a stale one, and
+// removes it on shutdown, and that a socket in use is not taken over
+func TestServerUnixSocket(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "api.sock")
+
+	// A socket left behind by a process that exited without removing it
+	stale, err := net.Listen("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stale.(*net.UnixListener).SetUnlinkOnClose(false)
+	stale.Close()
+
+	s := New(Config{Addr: "unix:" + path}, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "ok")
+	}))
+	served := make(chan error, 1)
+	go func() { served <- s.ListenAndServe() }()
+
+	client := &http.Client{Transport: &http.Transport{
+		DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+			return (&net.Dialer{}).DialContext(ctx, "unix", path)
+		},
+	}}
+	waitFor(t, s, func(models.HTTPServerStats) bool {
+		resp, err := client.Get("http://gateway/")
+		if err != nil {
+			return false
+		}
+		resp.Body.Close()
+		return resp.StatusCode == http.StatusOK
+	})
+
+	if info, err := os.Stat(path); err != nil || info.Mode().Perm() != 0o660 {
+		t.Errorf("Expected the socket readable by its owner and group only, got %v: %v", info.Mode(), err)
+	}
+	if err := New(Config{Addr: "unix:" + path}, http.NotFoundHandler()).ListenAndServe(); err == nil || !strings.Contains(err.Error(), "in use") {
+		t.Errorf("Expected a socket in use refused, got %v", err)
+	}
+
+	if err := s.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-served; !errors.Is(err, http.ErrServerClosed) {
+		t.Errorf("Expected the server closed, got %v", err)
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("Expected the socket removed on shutdown, got %v", err)
+	}
+}
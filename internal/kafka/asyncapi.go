@@ -0,0 +1,199 @@
+package kafka
+
+import (
+	"encoding/json"
+	"sort"
+	"strings"
+)
+
+// AsyncAPIVersion is the version of the AsyncAPI specification generated by AsyncAPISpec
+const AsyncAPIVersion = "2.6.0"
+
+// AsyncAPISpec builds an AsyncAPI document describing every topic, event type, header and
+// payload schema this service publishes. It is generated from the same constants and models
+// the producer uses, so it cannot drift from what is actually emitted.
+func AsyncAPISpec(brokerURL, serviceVersion string) map[string]interface{} {
+	topics := make([]string, 0, len(TopicCodecs))
+	for topic := range TopicCodecs {
+		topics = append(topics, topic)
+	}
+	sort.Strings(topics)
+
+	channels := make(map[string]interface{}, len(topics))
+	for _, topic := range topics {
+		channels[topic] = map[string]interface{}{
+			"description": "Transactions processed by gateways using " + strings.Join(TopicFormats(topic), " or "),
+			"subscribe": map[string]interface{}{
+				"operationId": "consume" + camelCase(topic),
+				"summary":     "Transaction lifecycle events for " + strings.Join(TopicFormats(topic), ", ") + " gateways",
+				"bindings": map[string]interface{}{
+					"kafka": map[string]interface{}{
+						"key": map[string]interface{}{
+							"type":        "string",
+							"description": "Transaction ID",
+						},
+					},
+				},
+				"message": map[string]interface{}{
+					"$ref": "#/components/messages/TransactionSubmitted",
+				},
+			},
+		}
+	}
+
+	channels[TopicTransactionsDLQ] = map[string]interface{}{
+		"description": "Transaction events consumers gave up handling after repeated failures, until replayed to the topic they were consumed from",
+		"subscribe": map[string]interface{}{
+			"operationId": "consume" + camelCase(TopicTransactionsDLQ),
+			"summary":     "Dead-lettered transaction events",
+			"bindings": map[string]interface{}{
+				"kafka": map[string]interface{}{
+					"key": map[string]interface{}{
+						"type":        "string",
+						"description": "Transaction ID",
+					},
+				},
+			},
+			"message": map[string]interface{}{
+				"$ref": "#/components/messages/DeadLetteredTransaction",
+			},
+		},
+	}
+
+	return map[string]interface{}{
+		"asyncapi": AsyncAPIVersion,
+		"info": map[string]interface{}{
+			"title":       "Payment Gateway Events",
+			"version":     serviceVersion,
+			"description": "Kafka events emitted by the payment gateway integration service.",
+		},
+		"servers": map[string]interface{}{
+			"kafka": map[string]interface{}{
+				"url":      brokerURL,
+				"protocol": "kafka",
+			},
+		},
+		"defaultContentType": "application/json",
+		"channels":           channels,
+		"components": map[string]interface{}{
+			"messages": map[string]interface{}{
+				"TransactionSubmitted": map[string]interface{}{
+					"name":         EventTransactionSubmitted,
+					"title":        "Transaction submitted",
+					"summary":      "A gateway accepted the transaction for processing.",
+					"description":  eventEncoding,
+					"contentType":  "application/json",
+					"headers":      transactionHeaders(nil, nil),
+					"schemaFormat": avroSchemaFormat,
+					"payload":      avroPayload(),
+					"bindings":     avroBindings(),
+				},
+				"DeadLetteredTransaction": map[string]interface{}{
+					"name":         EventTransactionSubmitted,
+					"title":        "Dead-lettered transaction",
+					"summary":      "A message consumers gave up handling, as published, with where it was consumed from and why it failed.",
+					"description":  eventEncoding,
+					"contentType":  "application/json",
+					"headers":      transactionHeaders(dlqHeaders(), []string{HeaderDLQTopic, HeaderDLQPartition, HeaderDLQOffset, HeaderDLQGroup, HeaderDLQAttempts, HeaderDLQError, HeaderDLQFailedAt}),
+					"schemaFormat": avroSchemaFormat,
+					"payload":      avroPayload(),
+					"bindings":     avroBindings(),
+				},
+			},
+		},
+	}
+}
+
+// avroSchemaFormat is the AsyncAPI schema format of payloads described by an Avro schema
+const avroSchemaFormat = "application/vnd.apache.avro+json;version=1.9.0"
+
+// eventEncoding describes how transaction event payloads are encoded
+const eventEncoding = "The payload is a TransactionEvent. With a schema registry configured it is Avro in the Confluent wire format: a zero byte, the 4-byte big-endian ID of the schema registered under the <topic>-value subject, then the Avro binary. Without one it is JSON of the same fields."
+
+// avroPayload returns the current TransactionEvent schema as written
+func avroPayload() map[string]interface{} {
+	var schema map[string]interface{}
+	if err := json.Unmarshal([]byte(transactionEventSchemaText), &schema); err != nil {
+		panic(err)
+	}
+	return schema
+}
+
+// avroBindings returns the Kafka bindings of messages carrying Avro payloads with registry IDs
+func avroBindings() map[string]interface{} {
+	return map[string]interface{}{
+		"kafka": map[string]interface{}{
+			"schemaIdLocation":        "payload",
+			"schemaIdPayloadEncoding": "confluent",
+			"schemaLookupStrategy":    "TopicIdStrategy",
+			"bindingVersion":          "0.4.0",
+		},
+	}
+}
+
+// transactionHeaders returns the schema of the headers of transaction events, with extra headers
+// and required header names added
+func transactionHeaders(extra map[string]interface{}, required []string) map[string]interface{} {
+	properties := map[string]interface{}{
+		HeaderContentType: map[string]interface{}{
+			"type":        "string",
+			"description": "Data format supported by the gateway that processed the transaction",
+			"enum":        allFormats(),
+		},
+		HeaderEventType: map[string]interface{}{
+			"type": "string",
+			"enum": []string{EventTransactionSubmitted},
+		},
+		HeaderDedupToken: map[string]interface{}{
+			"type":        "string",
+			"description": "Stable token identifying the event; messages may be redelivered, so consumers should discard tokens they have already processed",
+		},
+	}
+	for name, schema := range extra {
+		properties[name] = schema
+	}
+
+	return map[string]interface{}{
+		"type":       "object",
+		"required":   append([]string{HeaderContentType, HeaderEventType, HeaderDedupToken}, required...),
+		"properties": properties,
+	}
+}
+
+// dlqHeaders returns the schemas of the headers added to dead-lettered messages
+func dlqHeaders() map[string]interface{} {
+	return map[string]interface{}{
+		HeaderDLQTopic:     map[string]interface{}{"type": "string", "description": "Topic the message was consumed from and is replayed to"},
+		HeaderDLQPartition: map[string]interface{}{"type": "string", "description": "Partition the message was consumed from, as a decimal"},
+		HeaderDLQOffset:    map[string]interface{}{"type": "string", "description": "Offset the message was consumed from, as a decimal"},
+		HeaderDLQGroup:     map[string]interface{}{"type": "string", "description": "Consumer group that failed to handle the message"},
+		HeaderDLQHandlers:  map[string]interface{}{"type": "string", "description": "Comma-separated names of the handlers that failed"},
+		HeaderDLQAttempts:  map[string]interface{}{"type": "string", "description": "Attempts made before giving up, as a decimal"},
+		HeaderDLQError:     map[string]interface{}{"type": "string", "description": "Last error of the failed handlers"},
+		HeaderDLQFailedAt:  map[string]interface{}{"type": "string", "format": "date-time"},
+	}
+}
+
+// AsyncAPIJSON renders the AsyncAPI document as indented JSON
+func AsyncAPIJSON(brokerURL, serviceVersion string) ([]byte, error) {
+	return json.MarshalIndent(AsyncAPISpec(brokerURL, serviceVersion), "", "  ")
+}
+
+// allFormats lists every data format routed to a topic
+func allFormats() []string {
+	var formats []string
+	for topic := range TopicCodecs {
+		formats = append(formats, TopicFormats(topic)...)
+	}
+	sort.Strings(formats)
+	return formats
+}
+
+// camelCase converts a dotted topic name into an identifier, e.g. transactions.json -> TransactionsJson
+func camelCase(s string) string {
+	parts := strings.FieldsFunc(s, func(r rune) bool { return r == '.' || r == '_' || r == '-' })
+	for i, p := range parts {
+		parts[i] = strings.ToUpper(p[:1]) + p[1:]
+	}
+	return strings.Join(parts, "")
+}
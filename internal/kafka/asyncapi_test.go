@@ -0,0 +1,45 @@
+package kafka
+
+import (
+	"bytes"
+	"os"
+	"payment-gateway/internal/consts"
+	"testing"
+)
+
+// TestAsyncAPISpecCoversTopics tests that every topic the producer routes to is documented
+func TestAsyncAPISpecCoversTopics(t *testing.T) {
+	spec := AsyncAPISpec("localhost:9092", consts.APIVersion)
+
+	channels, ok := spec["channels"].(map[string]interface{})
+	if !ok {
+		t.Fatal("Expected channels in AsyncAPI spec")
+	}
+
+	for _, format := range []string{"application/json", "application/xml", "text/xml"} {
+		topic, err := GetTopic(format)
+		if err != nil {
+			t.Fatalf("Expected topic for %s, got error: %v", format, err)
+		}
+		if _, exists := channels[topic]; !exists {
+			t.Errorf("Expected topic %s to be documented", topic)
+		}
+	}
+}
+
+// TestAsyncAPIDocumentUpToDate tests that the committed docs/asyncapi.json matches the generated spec
+func TestAsyncAPIDocumentUpToDate(t *testing.T) {
+	committed, err := os.ReadFile("../../docs/asyncapi.json")
+	if err != nil {
+		t.Fatalf("Failed to read docs/asyncapi.json: %v", err)
+	}
+
+	generated, err := AsyncAPIJSON("localhost:9092", consts.APIVersion)
+	if err != nil {
+		t.Fatalf("Failed to generate AsyncAPI spec: %v", err)
+	}
+
+	if !bytes.Equal(bytes.TrimSpace(committed), bytes.TrimSpace(generated)) {
+		t.Error("docs/asyncapi.json is out of date; run `make asyncapi` to regenerate it")
+	}
+}
@@ -0,0 +1,111 @@
+package kafka
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
+	"payment-gateway/internal/schemaregistry"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ConfigFromEnv reads the producer configuration from the environment:
+//   - KAFKA_BROKER_URL: comma-separated broker addresses, DefaultBroker by default
+//   - KAFKA_TLS=true, KAFKA_TLS_CA_FILE, KAFKA_TLS_CERT_FILE, KAFKA_TLS_KEY_FILE,
+//     KAFKA_TLS_SERVER_NAME: TLS to the brokers, verified against the system roots unless a CA
+//     file is given, with a client certificate when both certificate files are given
+//   - KAFKA_SASL_MECHANISM, KAFKA_SASL_USERNAME, KAFKA_SASL_PASSWORD: SASL authentication
+//   - KAFKA_BATCH_SIZE, KAFKA_BATCH_BYTES, KAFKA_BATCH_TIMEOUT: batching
+//   - SCHEMA_REGISTRY_URL, SCHEMA_REGISTRY_USERNAME, SCHEMA_REGISTRY_PASSWORD: the schema
+//     registry events are published as Avro with, JSON being published without one
+func ConfigFromEnv() (Config, error) {
+	var brokers []string
+	for _, broker := range strings.Split(os.Getenv("KAFKA_BROKER_URL"), ",") {
+		if broker = strings.TrimSpace(broker); broker != "" {
+			brokers = append(brokers, broker)
+		}
+	}
+	config := DefaultConfig(brokers...)
+
+	if os.Getenv("KAFKA_TLS") == "true" {
+		tlsConfig, err := loadTLSConfig(os.Getenv("KAFKA_TLS_CA_FILE"), os.Getenv("KAFKA_TLS_CERT_FILE"), os.Getenv("KAFKA_TLS_KEY_FILE"))
+		if err != nil {
+			return Config{}, err
+		}
+		tlsConfig.ServerName = os.Getenv("KAFKA_TLS_SERVER_NAME")
+		config.TLS = tlsConfig
+	}
+
+	config.SASL = SASLConfig{
+		Mechanism: os.Getenv("KAFKA_SASL_MECHANISM"),
+		Username:  os.Getenv("KAFKA_SASL_USERNAME"),
+		Password:  os.Getenv("KAFKA_SASL_PASSWORD"),
+	}
+
+	config.SchemaRegistry = schemaregistry.Config{
+		URL:      os.Getenv("SCHEMA_REGISTRY_URL"),
+		Username: os.Getenv("SCHEMA_REGISTRY_USERNAME"),
+		Password: os.Getenv("SCHEMA_REGISTRY_PASSWORD"),
+	}
+
+	var err error
+	if config.BatchSize, err = envInt("KAFKA_BATCH_SIZE", config.BatchSize); err != nil {
+		return Config{}, err
+	}
+	batchBytes, err := envInt("KAFKA_BATCH_BYTES", int(config.BatchBytes))
+	if err != nil {
+		return Config{}, err
+	}
+	config.BatchBytes = int64(batchBytes)
+	if value := os.Getenv("KAFKA_BATCH_TIMEOUT"); value != "" {
+		if config.BatchTimeout, err = time.ParseDuration(value); err != nil {
+			return Config{}, fmt.Errorf("invalid KAFKA_BATCH_TIMEOUT %q: %w", value, err)
+		}
+	}
+
+	return config, nil
+}
+
+// envInt reads a positive integer from the environment, defaultValue when it is unset
+func envInt(key string, defaultValue int) (int, error) {
+	value := os.Getenv(key)
+	if value == "" {
+		return defaultValue, nil
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil || n <= 0 {
+		return 0, fmt.Errorf("invalid %s %q: expected a positive integer", key, value)
+	}
+	return n, nil
+}
+
+// loadTLSConfig builds the TLS configuration of broker connections from PEM files, all optional
+func loadTLSConfig(caFile, certFile, keyFile string) (*tls.Config, error) {
+	config := &tls.Config{MinVersion: tls.VersionTLS12}
+
+	if caFile != "" {
+		pem, err := os.ReadFile(caFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read Kafka CA file: %w", err)
+		}
+		config.RootCAs = x509.NewCertPool()
+		if !config.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in Kafka CA file %s", caFile)
+		}
+	}
+
+	if certFile != "" || keyFile != "" {
+		if certFile == "" || keyFile == "" {
+			return nil, fmt.Errorf("both KAFKA_TLS_CERT_FILE and KAFKA_TLS_KEY_FILE are needed for a client certificate")
+		}
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load Kafka client certificate: %w", err)
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+
+	return config, nil
+}
@@ -0,0 +1,63 @@
+package kafka
+
+import (
+	"testing"
+	"time"
+)
+
+// TestConfigFromEnv tests that brokers, credentials and batching are read from the environment
+// and that defaults apply when they are unset
+func TestConfigFromEnv(t *testing.T) {
+	for _, key := range []string{"KAFKA_BROKER_URL", "KAFKA_TLS", "KAFKA_TLS_CA_FILE", "KAFKA_TLS_CERT_FILE", "KAFKA_TLS_KEY_FILE", "KAFKA_BATCH_TIMEOUT", "SCHEMA_REGISTRY_URL"} {
+		t.Setenv(key, "")
+	}
+
+	config, err := ConfigFromEnv()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(config.Brokers) != 1 || config.Brokers[0] != DefaultBroker || config.TLS != nil || config.BatchSize != 100 {
+		t.Errorf("Expected the default configuration, got %+v", config)
+	}
+
+	t.Setenv("KAFKA_BROKER_URL", "kafka-1:9093, kafka-2:9093")
+	t.Setenv("KAFKA_TLS", "true")
+	t.Setenv("KAFKA_TLS_SERVER_NAME", "kafka.internal")
+	t.Setenv("KAFKA_SASL_MECHANISM", SASLScramSHA512)
+	t.Setenv("KAFKA_SASL_USERNAME", "gateway")
+	t.Setenv("KAFKA_SASL_PASSWORD", "secret")
+	t.Setenv("KAFKA_BATCH_SIZE", "250")
+	t.Setenv("KAFKA_BATCH_BYTES", "2048")
+	t.Setenv("KAFKA_BATCH_TIMEOUT", "50ms")
+	t.Setenv("SCHEMA_REGISTRY_URL", "http://schema-registry:8081")
+
+	config, err = ConfigFromEnv()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(config.Brokers) != 2 || config.Brokers[1] != "kafka-2:9093" {
+		t.Errorf("Expected both brokers, got %v", config.Brokers)
+	}
+	if config.TLS == nil || config.TLS.ServerName != "kafka.internal" {
+		t.Errorf("Expected TLS verifying kafka.internal, got %+v", config.TLS)
+	}
+	if config.SASL != (SASLConfig{Mechanism: SASLScramSHA512, Username: "gateway", Password: "secret"}) {
+		t.Errorf("Expected SCRAM credentials, got %+v", config.SASL)
+	}
+	if config.BatchSize != 250 || config.BatchBytes != 2048 || config.BatchTimeout != 50*time.Millisecond {
+		t.Errorf("Expected the configured batching, got %+v", config)
+	}
+	if config.SchemaRegistry.URL != "http://schema-registry:8081" {
+		t.Errorf("Expected the schema registry, got %+v", config.SchemaRegistry)
+	}
+
+	t.Setenv("KAFKA_BATCH_SIZE", "none")
+	if _, err := ConfigFromEnv(); err == nil {
+		t.Error("Expected an invalid batch size refused")
+	}
+	t.Setenv("KAFKA_BATCH_SIZE", "")
+	t.Setenv("KAFKA_TLS_CERT_FILE", "client.pem")
+	if _, err := ConfigFromEnv(); err == nil {
+		t.Error("Expected a client certificate without its key refused")
+	}
+}
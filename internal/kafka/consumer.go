@@ -0,0 +1,321 @@
+package kafka
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"payment-gateway/internal/consts"
+	"payment-gateway/internal/schemaregistry"
+	"sync"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+)
+
+// Message is a transaction event read from a topic
+type Message struct {
+	Topic         string
+	Partition     int
+	Offset        int64
+	TransactionID string // the message key
+	ContentType   string // data format of the gateway that processed the transaction
+	EventType     string
+	DedupToken    string
+	Value         []byte
+	Time          time.Time
+
+	events *eventCodec
+}
+
+// Event decodes the transaction event the message carries, Avro or JSON whatever the topic; the
+// topic and content type only tell which gateway format the transaction was processed in. Avro
+// events published with an older version of the schema are read as the current one.
+func (m Message) Event(ctx context.Context) (TransactionEvent, error) {
+	events := m.events
+	if events == nil {
+		events = newEventCodec(nil)
+	}
+	event, err := events.decode(ctx, m.Value)
+	if err != nil {
+		return event, fmt.Errorf("failed to decode transaction %s: %w", m.TransactionID, err)
+	}
+	return event, nil
+}
+
+// HandlerFunc processes a message. Messages are delivered at least once, so handlers must be
+// idempotent.
+type HandlerFunc func(ctx context.Context, msg Message) error
+
+// MessageReader reads messages of a consumer group and commits their offsets; *kafka.Reader
+// implements it
+type MessageReader interface {
+	FetchMessage(ctx context.Context) (kafka.Message, error)
+	CommitMessages(ctx context.Context, msgs ...kafka.Message) error
+	Close() error
+}
+
+// MessageWriter publishes messages; *kafka.Writer implements it
+type MessageWriter interface {
+	WriteMessages(ctx context.Context, msgs ...kafka.Message) error
+}
+
+// ConsumerConfig configures a consumer
+type ConsumerConfig struct {
+	Brokers      []string
+	Dialer       *kafka.Dialer // connects to the brokers, with TLS and SASL as configured; nil for the default
+	GroupID      string
+	Topics       []string
+	MaxAttempts  int           // handler runs before a message is given up on; zero retries until shutdown
+	RetryBackoff time.Duration // delay before the first retry, doubled after each failure
+	DeadLetter   MessageWriter // receives messages given up on for the dead-letter topic; nil skips them
+
+	// Registry holds the schemas of Avro events, which cannot be decoded without it
+	Registry *schemaregistry.Client
+}
+
+// DefaultConsumerConfig returns the configuration of a consumer in the group reading the JSON
+// and SOAP transaction topics from the brokers a producer publishes to, with the same
+// credentials and schema registry, and moving messages it gives up on to the dead-letter topic
+// through it
+func DefaultConsumerConfig(producer *Producer, groupID string) ConsumerConfig {
+	return ConsumerConfig{
+		Brokers:      producer.Brokers(),
+		Dialer:       producer.dialer,
+		GroupID:      groupID,
+		Topics:       []string{TopicTransactionsJSON, TopicTransactionsSOAP},
+		MaxAttempts:  consts.KafkaConsumerMaxAttempts,
+		RetryBackoff: consts.KafkaConsumerRetryBackoff,
+		DeadLetter:   producer,
+		Registry:     producer.events.registry,
+	}
+}
+
+// namedHandler is a registered handler
+type namedHandler struct {
+	name   string
+	handle HandlerFunc
+}
+
+// Consumer reads transaction events as a member of a consumer group and passes each to every
+// registered handler, committing its offset once all of them succeeded. A message a handler
+// failed on is handled again, by the handlers that failed only, until they succeed or the
+// attempts run out; it is then moved to the dead-letter topic, from which ReplayDeadLetters
+// publishes it again. Messages carrying a dedup token seen recently are committed without being
+// handled again.
+type Consumer struct {
+	reader MessageReader
+	config ConsumerConfig
+	events *eventCodec
+
+	mu       sync.Mutex
+	handlers []namedHandler
+	seen     map[string]bool
+	order    []string // dedup tokens in seen, oldest first
+}
+
+// NewConsumer creates a consumer joining config.GroupID. Offsets are committed synchronously, only
+// after handling, so a consumer that stops mid-message has it redelivered.
+func NewConsumer(config ConsumerConfig) *Consumer {
+	return newConsumer(kafka.NewReader(kafka.ReaderConfig{
+		Brokers:     config.Brokers,
+		Dialer:      config.Dialer,
+		GroupID:     config.GroupID,
+		GroupTopics: config.Topics,
+		StartOffset: kafka.FirstOffset,
+	}), config)
+}
+
+// newConsumer creates a consumer reading from reader
+func newConsumer(reader MessageReader, config ConsumerConfig) *Consumer {
+	return &Consumer{reader: reader, config: config, events: newEventCodec(config.Registry), seen: make(map[string]bool)}
+}
+
+// Handle registers a handler every message is passed to, in registration order
+func (c *Consumer) Handle(name string, handler HandlerFunc) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.handlers = append(c.handlers, namedHandler{name: name, handle: handler})
+}
+
+// Run reads and handles messages until ctx is cancelled or the reader is closed, returning nil
+// then. A message being retried when ctx is cancelled is left uncommitted, and so is a message
+// that could not be moved to the dead-letter topic, for which Run returns an error.
+func (c *Consumer) Run(ctx context.Context) error {
+	log.Printf("Kafka consumer %s reading %v", c.config.GroupID, c.config.Topics)
+
+	for {
+		m, err := c.reader.FetchMessage(ctx)
+		if err != nil {
+			if ctx.Err() != nil || errors.Is(err, io.EOF) {
+				return nil
+			}
+			return fmt.Errorf("failed to fetch message: %w", err)
+		}
+
+		msg := newMessage(m)
+		msg.events = c.events
+		if c.remember(msg.DedupToken) {
+			if err := c.handle(ctx, msg); err != nil {
+				c.forget(msg.DedupToken)
+				if ctx.Err() != nil {
+					return nil
+				}
+				if err := c.giveUp(ctx, m, msg, err); err != nil {
+					if ctx.Err() != nil {
+						return nil
+					}
+					return err
+				}
+			}
+		}
+
+		if err := c.reader.CommitMessages(ctx, m); err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return fmt.Errorf("failed to commit offset %d of %s/%d: %w", m.Offset, m.Topic, m.Partition, err)
+		}
+	}
+}
+
+// Close leaves the consumer group and closes the connection to the broker
+func (c *Consumer) Close() error {
+	return c.reader.Close()
+}
+
+// handlingError reports the handlers still failing on a message once its attempts ran out
+type handlingError struct {
+	handlers []string
+	attempts int
+	err      error // last failure
+}
+
+func (e *handlingError) Error() string {
+	return fmt.Sprintf("%d attempts failed: %v", e.attempts, e.err)
+}
+
+func (e *handlingError) Unwrap() error {
+	return e.err
+}
+
+// giveUp moves a message its handlers failed on to the dead-letter topic, or skips it when no
+// dead-letter writer is configured
+func (c *Consumer) giveUp(ctx context.Context, m kafka.Message, msg Message, err error) error {
+	if c.config.DeadLetter == nil {
+		log.Printf("Skipping %s message at %s/%d offset %d for transaction %s: %v", msg.EventType, msg.Topic, msg.Partition, msg.Offset, msg.TransactionID, err)
+		return nil
+	}
+
+	var failure *handlingError
+	if !errors.As(err, &failure) {
+		failure = &handlingError{err: err}
+	}
+	if err := c.config.DeadLetter.WriteMessages(ctx, deadLetterMessage(m, c.config.GroupID, failure, time.Now())); err != nil {
+		return fmt.Errorf("failed to move offset %d of %s/%d to %s: %w", m.Offset, m.Topic, m.Partition, TopicTransactionsDLQ, err)
+	}
+	log.Printf("Moved %s message at %s/%d offset %d for transaction %s to %s: %v", msg.EventType, msg.Topic, msg.Partition, msg.Offset, msg.TransactionID, TopicTransactionsDLQ, err)
+	return nil
+}
+
+// handle passes a message to the handlers, retrying those that fail with backoff. It returns a
+// *handlingError once the attempts run out, or ctx's error when cancelled first.
+func (c *Consumer) handle(ctx context.Context, msg Message) error {
+	c.mu.Lock()
+	pending := append([]namedHandler(nil), c.handlers...)
+	c.mu.Unlock()
+
+	backoff := c.config.RetryBackoff
+	for attempt := 1; ; attempt++ {
+		var failed []namedHandler
+		var lastErr error
+		for _, h := range pending {
+			if err := h.handle(ctx, msg); err != nil {
+				log.Printf("Kafka handler %s failed on transaction %s (attempt %d): %v", h.name, msg.TransactionID, attempt, err)
+				failed = append(failed, h)
+				lastErr = fmt.Errorf("handler %s: %w", h.name, err)
+			}
+		}
+		if len(failed) == 0 {
+			return nil
+		}
+		if c.config.MaxAttempts > 0 && attempt >= c.config.MaxAttempts {
+			names := make([]string, len(failed))
+			for i, h := range failed {
+				names[i] = h.name
+			}
+			return &handlingError{handlers: names, attempts: attempt, err: lastErr}
+		}
+		pending = failed
+
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		backoff *= 2
+	}
+}
+
+// remember records a dedup token, reporting false when it was already seen. Messages without a
+// token are always handled.
+func (c *Consumer) remember(token string) bool {
+	if token == "" {
+		return true
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.seen[token] {
+		return false
+	}
+	c.seen[token] = true
+	c.order = append(c.order, token)
+	if len(c.order) > consts.KafkaConsumerDedupWindow {
+		delete(c.seen, c.order[0])
+		c.order = c.order[1:]
+	}
+	return true
+}
+
+// forget drops a dedup token of a message that was not handled, so a redelivery of it is
+func (c *Consumer) forget(token string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.seen, token)
+}
+
+// newMessage reads a Kafka message's key and headers
+func newMessage(m kafka.Message) Message {
+	msg := Message{
+		Topic:         m.Topic,
+		Partition:     m.Partition,
+		Offset:        m.Offset,
+		TransactionID: string(m.Key),
+		Value:         m.Value,
+		Time:          m.Time,
+	}
+	for _, h := range m.Headers {
+		switch h.Key {
+		case HeaderContentType:
+			msg.ContentType = string(h.Value)
+		case HeaderEventType:
+			msg.EventType = string(h.Value)
+		case HeaderDedupToken:
+			msg.DedupToken = string(h.Value)
+		}
+	}
+	return msg
+}
+
+// LogHandler logs each message, with the transaction's status when it can be decoded
+func LogHandler(ctx context.Context, msg Message) error {
+	status := "unknown"
+	if event, err := msg.Event(ctx); err == nil {
+		status = event.Status
+	}
+	log.Printf("Consumed %s for transaction %s (%s) from %s, status %s", msg.EventType, msg.TransactionID, msg.ContentType, msg.Topic, status)
+	return nil
+}
@@ -0,0 +1,215 @@
+package kafka
+
+import (
+	"context"
+	"errors"
+	"io"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+)
+
+// fakeReader serves queued messages, then blocks until closed or cancelled, and records commits
+type fakeReader struct {
+	mu        sync.Mutex
+	messages  []kafka.Message
+	committed []int64
+	closed    chan struct{}
+}
+
+func newFakeReader(messages ...kafka.Message) *fakeReader {
+	return &fakeReader{messages: messages, closed: make(chan struct{})}
+}
+
+func (r *fakeReader) FetchMessage(ctx context.Context) (kafka.Message, error) {
+	r.mu.Lock()
+	if len(r.messages) > 0 {
+		m := r.messages[0]
+		r.messages = r.messages[1:]
+		r.mu.Unlock()
+		return m, nil
+	}
+	r.mu.Unlock()
+
+	select {
+	case <-ctx.Done():
+		return kafka.Message{}, ctx.Err()
+	case <-r.closed:
+		return kafka.Message{}, io.EOF
+	}
+}
+
+func (r *fakeReader) CommitMessages(ctx context.Context, msgs ...kafka.Message) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, m := range msgs {
+		r.committed = append(r.committed, m.Offset)
+	}
+	return nil
+}
+
+func (r *fakeReader) Close() error {
+	close(r.closed)
+	return nil
+}
+
+func (r *fakeReader) commits() []int64 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]int64(nil), r.committed...)
+}
+
+// transactionMessage builds a message as the producer publishes it
+func transactionMessage(offset int64, txID, dedupToken string) kafka.Message {
+	return kafka.Message{
+		Topic:  TopicTransactionsJSON,
+		Offset: offset,
+		Key:    []byte(txID),
+		Value:  []byte(`{"transaction_id":` + txID + `,"status":"processing"}`),
+		Headers: []kafka.Header{
+			{Key: HeaderContentType, Value: []byte("application/json")},
+			{Key: HeaderEventType, Value: []byte(EventTransactionSubmitted)},
+			{Key: HeaderDedupToken, Value: []byte(dedupToken)},
+		},
+	}
+}
+
+// runUntilCommitted runs the consumer until want offsets were committed, then closes its reader
+func runUntilCommitted(t *testing.T, c *Consumer, reader *fakeReader, want int) {
+	t.Helper()
+
+	done := make(chan error, 1)
+	go func() { done <- c.Run(context.Background()) }()
+
+	deadline := time.Now().Add(2 * time.Second)
+	for len(reader.commits()) < want && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	c.Close()
+	if err := <-done; err != nil {
+		t.Fatalf("Expected Run to return nil once closed, got: %v", err)
+	}
+}
+
+// TestConsumerHandlesAndCommits tests that every handler sees each message before its offset is
+// committed, and that redelivered messages are committed without being handled again
+func TestConsumerHandlesAndCommits(t *testing.T) {
+	reader := newFakeReader(
+		transactionMessage(1, "10", "token-10"),
+		transactionMessage(2, "11", "token-11"),
+		transactionMessage(3, "10", "token-10"),
+	)
+	c := newConsumer(reader, ConsumerConfig{GroupID: "test", MaxAttempts: 1})
+
+	var mu sync.Mutex
+	var notified, reported []string
+	c.Handle("notifications", func(ctx context.Context, msg Message) error {
+		event, err := msg.Event(ctx)
+		if err != nil {
+			return err
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		notified = append(notified, msg.TransactionID+":"+event.Status)
+		return nil
+	})
+	c.Handle("reporting", func(ctx context.Context, msg Message) error {
+		mu.Lock()
+		defer mu.Unlock()
+		reported = append(reported, msg.EventType)
+		return nil
+	})
+
+	runUntilCommitted(t, c, reader, 3)
+
+	if commits := reader.commits(); len(commits) != 3 || commits[0] != 1 || commits[2] != 3 {
+		t.Errorf("Expected offsets 1 to 3 committed in order, got %v", commits)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if len(notified) != 2 || notified[0] != "10:processing" || notified[1] != "11:processing" {
+		t.Errorf("Expected each transaction notified once, got %v", notified)
+	}
+	if len(reported) != 2 || reported[0] != EventTransactionSubmitted {
+		t.Errorf("Expected each event reported once, got %v", reported)
+	}
+}
+
+// TestConsumerRetriesFailedHandlers tests that only handlers that failed are retried, and that a
+// message is skipped once its attempts run out
+func TestConsumerRetriesFailedHandlers(t *testing.T) {
+	reader := newFakeReader(transactionMessage(1, "20", "token-20"), transactionMessage(2, "21", "token-21"))
+	c := newConsumer(reader, ConsumerConfig{GroupID: "test", MaxAttempts: 3, RetryBackoff: time.Millisecond})
+
+	var mu sync.Mutex
+	calls := map[string]int{}
+	c.Handle("stable", func(ctx context.Context, msg Message) error {
+		mu.Lock()
+		defer mu.Unlock()
+		calls["stable:"+msg.TransactionID]++
+		return nil
+	})
+	c.Handle("flaky", func(ctx context.Context, msg Message) error {
+		mu.Lock()
+		defer mu.Unlock()
+		calls["flaky:"+msg.TransactionID]++
+		// Transaction 20 succeeds on the second attempt; transaction 21 always fails
+		if msg.TransactionID == "21" || calls["flaky:20"] < 2 {
+			return errors.New("unavailable")
+		}
+		return nil
+	})
+
+	runUntilCommitted(t, c, reader, 2)
+
+	mu.Lock()
+	defer mu.Unlock()
+	if calls["stable:20"] != 1 || calls["flaky:20"] != 2 {
+		t.Errorf("Expected only the failed handler retried, got %v", calls)
+	}
+	if calls["flaky:21"] != 3 {
+		t.Errorf("Expected 3 attempts before skipping, got %d", calls["flaky:21"])
+	}
+	if commits := reader.commits(); len(commits) != 2 {
+		t.Errorf("Expected both offsets committed, got %v", commits)
+	}
+}
+
+// TestConsumerShutdownLeavesMessageUncommitted tests that stopping while a message is retried
+// returns without committing it, so it is redelivered
+func TestConsumerShutdownLeavesMessageUncommitted(t *testing.T) {
+	reader := newFakeReader(transactionMessage(1, "30", "token-30"))
+	c := newConsumer(reader, ConsumerConfig{GroupID: "test", RetryBackoff: time.Hour})
+
+	failed := make(chan struct{}, 1)
+	c.Handle("down", func(ctx context.Context, msg Message) error {
+		select {
+		case failed <- struct{}{}:
+		default:
+		}
+		return errors.New("unavailable")
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- c.Run(ctx) }()
+
+	<-failed
+	cancel()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("Expected Run to return nil once cancelled, got: %v", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Expected Run to return once cancelled")
+	}
+	if commits := reader.commits(); len(commits) != 0 {
+		t.Errorf("Expected nothing committed, got %v", commits)
+	}
+	if !c.remember("token-30") {
+		t.Error("Expected the unhandled message's dedup token forgotten")
+	}
+}
@@ -0,0 +1,159 @@
+package kafka
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+)
+
+// DeadLetter is a message moved to the dead-letter topic, with why it was given up on
+type DeadLetter struct {
+	Message         // as published to the topic it was consumed from
+	DLQOffset int64 // offset in the dead-letter topic
+
+	Group    string // consumer group that failed to handle it
+	Handlers []string
+	Attempts int
+	Error    string
+	FailedAt time.Time
+}
+
+// ReplayOptions selects the dead letters ReplayDeadLetters republishes
+type ReplayOptions struct {
+	Limit  int           // messages replayed before stopping, 0 for all
+	Idle   time.Duration // how long to wait for another message before stopping
+	DryRun bool          // list the messages without republishing or committing them
+}
+
+// deadLetterMessage builds the dead-letter topic message of a message its handlers failed on:
+// the message as published, with headers recording where it was consumed from and why it failed
+func deadLetterMessage(m kafka.Message, group string, failure *handlingError, failedAt time.Time) kafka.Message {
+	headers := append([]kafka.Header(nil), m.Headers...)
+	headers = append(headers,
+		kafka.Header{Key: HeaderDLQTopic, Value: []byte(m.Topic)},
+		kafka.Header{Key: HeaderDLQPartition, Value: []byte(strconv.Itoa(m.Partition))},
+		kafka.Header{Key: HeaderDLQOffset, Value: []byte(strconv.FormatInt(m.Offset, 10))},
+		kafka.Header{Key: HeaderDLQGroup, Value: []byte(group)},
+		kafka.Header{Key: HeaderDLQHandlers, Value: []byte(strings.Join(failure.handlers, ","))},
+		kafka.Header{Key: HeaderDLQAttempts, Value: []byte(strconv.Itoa(failure.attempts))},
+		kafka.Header{Key: HeaderDLQError, Value: []byte(failure.err.Error())},
+		kafka.Header{Key: HeaderDLQFailedAt, Value: []byte(failedAt.UTC().Format(time.RFC3339Nano))},
+	)
+
+	return kafka.Message{
+		Topic:   TopicTransactionsDLQ,
+		Key:     m.Key,
+		Value:   m.Value,
+		Headers: headers,
+		Time:    failedAt,
+	}
+}
+
+// newDeadLetter reads a dead-letter topic message
+func newDeadLetter(m kafka.Message) DeadLetter {
+	d := DeadLetter{Message: newMessage(m), DLQOffset: m.Offset}
+
+	for _, h := range m.Headers {
+		value := string(h.Value)
+		switch h.Key {
+		case HeaderDLQTopic:
+			d.Topic = value
+		case HeaderDLQPartition:
+			d.Partition, _ = strconv.Atoi(value)
+		case HeaderDLQOffset:
+			d.Offset, _ = strconv.ParseInt(value, 10, 64)
+		case HeaderDLQGroup:
+			d.Group = value
+		case HeaderDLQHandlers:
+			if value != "" {
+				d.Handlers = strings.Split(value, ",")
+			}
+		case HeaderDLQAttempts:
+			d.Attempts, _ = strconv.Atoi(value)
+		case HeaderDLQError:
+			d.Error = value
+		case HeaderDLQFailedAt:
+			d.FailedAt, _ = time.Parse(time.RFC3339Nano, value)
+		}
+	}
+	return d
+}
+
+// replayMessage builds the message republishing a dead letter to the topic it was consumed from,
+// with the headers it was originally published with
+func replayMessage(m kafka.Message, topic string) kafka.Message {
+	var headers []kafka.Header
+	for _, h := range m.Headers {
+		if !strings.HasPrefix(h.Key, "dlq-") {
+			headers = append(headers, h)
+		}
+	}
+	return kafka.Message{
+		Topic:   topic,
+		Key:     m.Key,
+		Value:   m.Value,
+		Headers: headers,
+		Time:    time.Now(),
+	}
+}
+
+// ReplayDeadLetters republishes messages of the dead-letter topic to the topics they were consumed
+// from through a producer, as a member of groupID so each is replayed once. Messages keep their
+// dedup token, which consumers forgot when they gave up on them, so they are handled again.
+func ReplayDeadLetters(ctx context.Context, producer *Producer, groupID string, options ReplayOptions, report func(DeadLetter)) (int, error) {
+	reader := kafka.NewReader(kafka.ReaderConfig{
+		Brokers:     producer.Brokers(),
+		Dialer:      producer.dialer,
+		GroupID:     groupID,
+		Topic:       TopicTransactionsDLQ,
+		StartOffset: kafka.FirstOffset,
+	})
+	defer reader.Close()
+
+	return replay(ctx, reader, producer, options, report)
+}
+
+// replay republishes dead letters read from reader with writer, committing each once it has been
+// republished, until the limit is reached or no message arrives for options.Idle
+func replay(ctx context.Context, reader MessageReader, writer MessageWriter, options ReplayOptions, report func(DeadLetter)) (int, error) {
+	replayed := 0
+	for options.Limit == 0 || replayed < options.Limit {
+		fetchCtx, cancel := context.WithTimeout(ctx, options.Idle)
+		m, err := reader.FetchMessage(fetchCtx)
+		cancel()
+		if err != nil {
+			if ctx.Err() == nil && errors.Is(err, context.DeadlineExceeded) {
+				return replayed, nil
+			}
+			return replayed, fmt.Errorf("failed to fetch dead letter: %w", err)
+		}
+
+		d := newDeadLetter(m)
+		if d.Topic == "" {
+			log.Printf("Skipping dead letter at offset %d without a %s header", m.Offset, HeaderDLQTopic)
+		} else if !options.DryRun {
+			if err := writer.WriteMessages(ctx, replayMessage(m, d.Topic)); err != nil {
+				return replayed, fmt.Errorf("failed to replay dead letter at offset %d to %s: %w", m.Offset, d.Topic, err)
+			}
+		}
+
+		if !options.DryRun {
+			if err := reader.CommitMessages(ctx, m); err != nil {
+				return replayed, fmt.Errorf("failed to commit dead letter at offset %d: %w", m.Offset, err)
+			}
+		}
+		if d.Topic != "" {
+			replayed++
+			if report != nil {
+				report(d)
+			}
+		}
+	}
+	return replayed, nil
+}
@@ -0,0 +1,116 @@
+package kafka
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+)
+
+// fakeWriter records the messages written to it
+type fakeWriter struct {
+	mu       sync.Mutex
+	messages []kafka.Message
+}
+
+func (w *fakeWriter) WriteMessages(ctx context.Context, msgs ...kafka.Message) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.messages = append(w.messages, msgs...)
+	return nil
+}
+
+func (w *fakeWriter) written() []kafka.Message {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return append([]kafka.Message(nil), w.messages...)
+}
+
+// header returns the value of a message header
+func header(m kafka.Message, key string) string {
+	for _, h := range m.Headers {
+		if h.Key == key {
+			return string(h.Value)
+		}
+	}
+	return ""
+}
+
+// TestConsumerDeadLettersFailedMessages tests that a message whose attempts ran out is moved to
+// the dead-letter topic with why it failed before its offset is committed
+func TestConsumerDeadLettersFailedMessages(t *testing.T) {
+	reader := newFakeReader(transactionMessage(4, "40", "token-40"))
+	dlq := &fakeWriter{}
+	c := newConsumer(reader, ConsumerConfig{GroupID: "notifier", MaxAttempts: 2, RetryBackoff: time.Millisecond, DeadLetter: dlq})
+	c.Handle("stable", func(ctx context.Context, msg Message) error { return nil })
+	c.Handle("email", func(ctx context.Context, msg Message) error { return errors.New("smtp unavailable") })
+
+	runUntilCommitted(t, c, reader, 1)
+
+	written := dlq.written()
+	if len(written) != 1 {
+		t.Fatalf("Expected 1 dead letter, got %d", len(written))
+	}
+	m := written[0]
+	if m.Topic != TopicTransactionsDLQ || string(m.Key) != "40" || header(m, HeaderDedupToken) != "token-40" {
+		t.Errorf("Expected the message as published moved to %s, got %s %q", TopicTransactionsDLQ, m.Topic, m.Key)
+	}
+
+	d := newDeadLetter(m)
+	if d.Topic != TopicTransactionsJSON || d.Offset != 4 || d.Group != "notifier" || d.Attempts != 2 {
+		t.Errorf("Expected where and how often the message failed recorded, got %+v", d)
+	}
+	if len(d.Handlers) != 1 || d.Handlers[0] != "email" || d.Error != "handler email: smtp unavailable" {
+		t.Errorf("Expected the failed handler and its error recorded, got %v %q", d.Handlers, d.Error)
+	}
+	if d.FailedAt.IsZero() {
+		t.Error("Expected the failure time recorded")
+	}
+	if !c.remember("token-40") {
+		t.Error("Expected the dead-lettered message's dedup token forgotten, so a replay is handled")
+	}
+}
+
+// TestReplayRepublishesDeadLetters tests that dead letters are republished to the topic they were
+// consumed from without their dead-letter headers, and that a dry run changes nothing
+func TestReplayRepublishesDeadLetters(t *testing.T) {
+	failure := &handlingError{handlers: []string{"email"}, attempts: 5, err: errors.New("smtp unavailable")}
+	deadLetters := func() []kafka.Message {
+		first := deadLetterMessage(transactionMessage(1, "50", "token-50"), "notifier", failure, time.Now())
+		second := deadLetterMessage(transactionMessage(2, "51", "token-51"), "notifier", failure, time.Now())
+		first.Offset, second.Offset = 10, 11
+		return []kafka.Message{first, second}
+	}
+
+	reader := newFakeReader(deadLetters()...)
+	writer := &fakeWriter{}
+	var listed []DeadLetter
+	n, err := replay(context.Background(), reader, writer, ReplayOptions{Idle: 10 * time.Millisecond, DryRun: true}, func(d DeadLetter) {
+		listed = append(listed, d)
+	})
+	if err != nil || n != 2 || len(listed) != 2 || listed[1].TransactionID != "51" {
+		t.Fatalf("Expected both dead letters listed, got %d %v: %v", n, listed, err)
+	}
+	if len(writer.written()) != 0 || len(reader.commits()) != 0 {
+		t.Errorf("Expected a dry run to neither republish nor commit")
+	}
+
+	reader = newFakeReader(deadLetters()...)
+	n, err = replay(context.Background(), reader, writer, ReplayOptions{Limit: 1, Idle: 10 * time.Millisecond}, nil)
+	if err != nil || n != 1 {
+		t.Fatalf("Expected 1 dead letter replayed, got %d: %v", n, err)
+	}
+	written := writer.written()
+	if len(written) != 1 || written[0].Topic != TopicTransactionsJSON || string(written[0].Key) != "50" {
+		t.Fatalf("Expected the first dead letter republished to %s, got %v", TopicTransactionsJSON, written)
+	}
+	if header(written[0], HeaderDedupToken) != "token-50" || header(written[0], HeaderDLQError) != "" {
+		t.Errorf("Expected the original headers only, got %v", written[0].Headers)
+	}
+	if commits := reader.commits(); len(commits) != 1 || commits[0] != 10 {
+		t.Errorf("Expected the replayed dead letter committed, got %v", commits)
+	}
+}
@@ -0,0 +1,56 @@
+package kafka
+
+import "payment-gateway/internal/codec"
+
+// Topics the service publishes to
+const (
+	TopicTransactionsJSON    = "transactions.json"
+	TopicTransactionsSOAP    = "transactions.soap"
+	TopicTransactionsForm    = "transactions.form"
+	TopicTransactionsISO8583 = "transactions.iso8583"
+
+	// TopicTransactionsDLQ holds messages consumers gave up handling, until they are replayed
+	TopicTransactionsDLQ = "transactions.dlq"
+)
+
+// Message headers attached to every published event
+const (
+	HeaderContentType = "content-type"
+	HeaderEventType   = "event-type"
+	HeaderDedupToken  = "dedup-token"
+)
+
+// Headers added to a message moved to the dead-letter topic, alongside those it was published with
+const (
+	HeaderDLQTopic     = "dlq-topic" // topic the message was consumed from and is replayed to
+	HeaderDLQPartition = "dlq-partition"
+	HeaderDLQOffset    = "dlq-offset"
+	HeaderDLQGroup     = "dlq-group"    // consumer group that failed to handle it
+	HeaderDLQHandlers  = "dlq-handlers" // comma-separated names of the handlers that failed
+	HeaderDLQAttempts  = "dlq-attempts"
+	HeaderDLQError     = "dlq-error" // last error of the failed handlers
+	HeaderDLQFailedAt  = "dlq-failed-at"
+)
+
+// Event types carried in the event-type header
+const (
+	// EventTransactionSubmitted is emitted once a gateway has accepted a transaction for processing
+	EventTransactionSubmitted = "transaction.submitted"
+)
+
+// TopicCodecs maps each topic to the codec of the gateways whose transactions are routed to it
+var TopicCodecs = map[string]string{
+	TopicTransactionsJSON:    codec.JSONName,
+	TopicTransactionsSOAP:    codec.XMLName,
+	TopicTransactionsForm:    codec.FormName,
+	TopicTransactionsISO8583: codec.ISO8583Name,
+}
+
+// TopicFormats returns the content types routed to a topic
+func TopicFormats(topic string) []string {
+	c, err := codec.ByName(TopicCodecs[topic])
+	if err != nil {
+		return nil
+	}
+	return c.ContentTypes()
+}
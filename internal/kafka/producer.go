@@ -2,83 +2,202 @@ package kafka
 
 import (
 	"context"
+	"crypto/tls"
 	"fmt"
 	"log"
 	"os"
+	"payment-gateway/internal/codec"
+	"payment-gateway/internal/models"
+	"payment-gateway/internal/schemaregistry"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/segmentio/kafka-go"
+	"github.com/segmentio/kafka-go/sasl"
+	"github.com/segmentio/kafka-go/sasl/plain"
+	"github.com/segmentio/kafka-go/sasl/scram"
 )
 
-var writer *kafka.Writer
+// DefaultBroker is the broker used when none is configured, as in the Docker environment
+const DefaultBroker = "kafka:9092"
 
-// Initialize the Kafka writer
-func init() {
-	kafkaURL := os.Getenv("KAFKA_BROKER_URL")
-	if kafkaURL == "" {
-		kafkaURL = "kafka:9092" // Default for Docker environment
+// SASL mechanisms brokers can authenticate clients with
+const (
+	SASLPlain       = "PLAIN"
+	SASLScramSHA256 = "SCRAM-SHA-256"
+	SASLScramSHA512 = "SCRAM-SHA-512"
+)
+
+// SASLConfig holds the credentials clients authenticate to the brokers with
+type SASLConfig struct {
+	Mechanism string // SASLPlain, SASLScramSHA256 or SASLScramSHA512; empty for no authentication
+	Username  string
+	Password  string
+}
+
+// Config configures a Producer and the consumers reading from the same brokers
+type Config struct {
+	Brokers []string
+	TLS     *tls.Config // nil for plaintext connections
+	SASL    SASLConfig
+
+	// Batching: messages for a partition are sent once BatchSize messages or BatchBytes bytes are
+	// buffered, or BatchTimeout after the first of them
+	BatchSize    int
+	BatchBytes   int64
+	BatchTimeout time.Duration
+
+	// SchemaRegistry publishes events as Avro with schemas registered there; without a URL events
+	// are published as JSON
+	SchemaRegistry schemaregistry.Config
+}
+
+// DefaultConfig returns the configuration of a producer publishing to brokers, or to
+// DefaultBroker when none is given
+func DefaultConfig(brokers ...string) Config {
+	if len(brokers) == 0 {
+		brokers = []string{DefaultBroker}
+	}
+	return Config{
+		Brokers:      brokers,
+		BatchSize:    100,
+		BatchBytes:   1 << 20,
+		BatchTimeout: 10 * time.Millisecond,
 	}
+}
+
+// Producer publishes transaction events. It connects lazily, on the first message published or
+// the first Ping.
+type Producer struct {
+	config Config
+	writer *kafka.Writer
+	dialer *kafka.Dialer
+	events *eventCodec
+}
 
-	writer = &kafka.Writer{
-		Addr:                   kafka.TCP(kafkaURL),
-		Balancer:               &kafka.LeastBytes{},
-		AllowAutoTopicCreation: true,
-		BatchTimeout:           10 * time.Millisecond,
-		RequiredAcks:           kafka.RequireOne,
+// NewProducer creates a producer, failing only when the configuration is invalid
+func NewProducer(config Config) (*Producer, error) {
+	if len(config.Brokers) == 0 {
+		return nil, fmt.Errorf("no Kafka brokers configured")
 	}
+	mechanism, err := saslMechanism(config.SASL)
+	if err != nil {
+		return nil, err
+	}
+	var registry *schemaregistry.Client
+	if config.SchemaRegistry.URL != "" {
+		registry = schemaregistry.New(config.SchemaRegistry)
+	}
+
+	return &Producer{
+		config: config,
+		writer: &kafka.Writer{
+			Addr:                   kafka.TCP(config.Brokers...),
+			Balancer:               &kafka.LeastBytes{},
+			AllowAutoTopicCreation: true,
+			BatchSize:              config.BatchSize,
+			BatchBytes:             config.BatchBytes,
+			BatchTimeout:           config.BatchTimeout,
+			RequiredAcks:           kafka.RequireOne,
+			Transport:              &kafka.Transport{TLS: config.TLS, SASL: mechanism},
+		},
+		dialer: &kafka.Dialer{
+			Timeout:       10 * time.Second,
+			DualStack:     true,
+			TLS:           config.TLS,
+			SASLMechanism: mechanism,
+		},
+		events: newEventCodec(registry),
+	}, nil
+}
 
-	log.Println("Kafka writer initialized successfully.")
+// saslMechanism returns the SASL mechanism of a configuration, nil when none is configured
+func saslMechanism(config SASLConfig) (sasl.Mechanism, error) {
+	switch strings.ToUpper(config.Mechanism) {
+	case "":
+		return nil, nil
+	case SASLPlain:
+		return plain.Mechanism{Username: config.Username, Password: config.Password}, nil
+	case SASLScramSHA256:
+		return scram.Mechanism(scram.SHA256, config.Username, config.Password)
+	case SASLScramSHA512:
+		return scram.Mechanism(scram.SHA512, config.Username, config.Password)
+	}
+	return nil, fmt.Errorf("unsupported SASL mechanism %q: use %s, %s or %s", config.Mechanism, SASLPlain, SASLScramSHA256, SASLScramSHA512)
 }
 
-// IsInitialized checks if Kafka is initialized
-func IsInitialized() bool {
-	return writer != nil
+// Brokers returns the addresses of the brokers the producer publishes to
+func (p *Producer) Brokers() []string {
+	return p.config.Brokers
 }
 
-// GetTopic returns the appropriate Kafka topic based on the data format
-func GetTopic(dataFormat string) (string, error) {
-	switch dataFormat {
-	case "application/json":
-		return "transactions.json", nil
-	case "text/xml", "application/xml":
-		return "transactions.soap", nil
-	default:
-		return "", fmt.Errorf("unsupported data format: %s", dataFormat)
+// Ping checks that the first broker is reachable and accepts the producer's credentials
+func (p *Producer) Ping(ctx context.Context) error {
+	conn, err := p.dialer.DialContext(ctx, "tcp", p.config.Brokers[0])
+	if err != nil {
+		return fmt.Errorf("failed to reach Kafka broker %s: %w", p.config.Brokers[0], err)
 	}
+	return conn.Close()
+}
+
+// Mocked reports whether Kafka is mocked with MOCK_KAFKA=true, so nothing is published to a broker
+func Mocked() bool {
+	return os.Getenv("MOCK_KAFKA") == "true"
 }
 
-// PublishTransaction publishes a transaction message to the appropriate Kafka topic
-func PublishTransaction(ctx context.Context, transactionID string, message []byte, dataFormat string) error {
-	if writer == nil {
-		log.Println("Kafka writer is nil, cannot publish to Kafka.")
+// GetTopic returns the appropriate Kafka topic based on the data format
+func GetTopic(dataFormat string) (string, error) {
+	c, err := codec.Lookup(dataFormat)
+	if err != nil {
+		return "", err
+	}
 
-		// For testing environments where Kafka might not be available
-		if os.Getenv("MOCK_KAFKA") == "true" {
-			log.Printf("MOCK_KAFKA=true: Would publish transaction %s to Kafka", transactionID)
-			return nil
+	for topic, name := range TopicCodecs {
+		if name == c.Name() {
+			return topic, nil
 		}
+	}
+
+	return "", fmt.Errorf("no topic for data format: %s", dataFormat)
+}
 
-		return fmt.Errorf("Kafka writer is not initialized")
+// PublishTransaction publishes the event of a transaction to the Kafka topic of its data format.
+// Delivery is at least once, so consumers should discard messages whose dedup token they have
+// already seen.
+func (p *Producer) PublishTransaction(ctx context.Context, tx models.Transaction, dataFormat, dedupToken string) error {
+	transactionID := strconv.Itoa(tx.ID)
+
+	// For testing environments where Kafka might not be available
+	if Mocked() {
+		log.Printf("MOCK_KAFKA=true: Would publish transaction %s to Kafka", transactionID)
+		return nil
 	}
 
 	topic, err := GetTopic(dataFormat)
 	if err != nil {
 		return err
 	}
+	value, err := p.events.encode(ctx, topic, NewTransactionEvent(EventTransactionSubmitted, tx))
+	if err != nil {
+		return err
+	}
 
 	log.Printf("Publishing message to Kafka topic: %s...", topic)
 
 	kafkaMessage := kafka.Message{
 		Key:   []byte(transactionID),
-		Value: message,
+		Value: value,
 		Topic: topic,
 		Time:  time.Now(),
 		Headers: []kafka.Header{
-			{Key: "content-type", Value: []byte(dataFormat)},
+			{Key: HeaderContentType, Value: []byte(dataFormat)},
+			{Key: HeaderEventType, Value: []byte(EventTransactionSubmitted)},
+			{Key: HeaderDedupToken, Value: []byte(dedupToken)},
 		},
 	}
 
-	err = writer.WriteMessages(ctx, kafkaMessage)
+	err = p.writer.WriteMessages(ctx, kafkaMessage)
 	if err != nil {
 		log.Printf("Error publishing to Kafka: %v", err)
 		return err
@@ -88,10 +207,12 @@ func PublishTransaction(ctx context.Context, transactionID string, message []byt
 	return nil
 }
 
-// Close closes the Kafka writer
-func Close() error {
-	if writer == nil {
-		return nil
-	}
-	return writer.Close()
+// WriteMessages publishes messages to the topics they name, such as dead letters
+func (p *Producer) WriteMessages(ctx context.Context, msgs ...kafka.Message) error {
+	return p.writer.WriteMessages(ctx, msgs...)
+}
+
+// Close flushes buffered messages and closes the producer's connections
+func (p *Producer) Close() error {
+	return p.writer.Close()
 }
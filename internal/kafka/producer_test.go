@@ -0,0 +1,67 @@
+package kafka
+
+import (
+	"crypto/tls"
+	"testing"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+	"github.com/segmentio/kafka-go/sasl/plain"
+)
+
+// TestNewProducerValidatesConfig tests that a producer is created for each supported SASL
+// mechanism and refused without brokers or with an unknown mechanism
+func TestNewProducerValidatesConfig(t *testing.T) {
+	for _, mechanism := range []string{"", SASLPlain, SASLScramSHA256, "scram-sha-512"} {
+		config := DefaultConfig()
+		config.SASL = SASLConfig{Mechanism: mechanism, Username: "gateway", Password: "secret"}
+		if _, err := NewProducer(config); err != nil {
+			t.Errorf("Expected a producer with SASL mechanism %q, got %v", mechanism, err)
+		}
+	}
+
+	if _, err := NewProducer(Config{}); err == nil {
+		t.Error("Expected a producer without brokers refused")
+	}
+
+	config := DefaultConfig()
+	config.SASL.Mechanism = "GSSAPI"
+	if _, err := NewProducer(config); err == nil {
+		t.Error("Expected an unsupported SASL mechanism refused")
+	}
+}
+
+// TestNewProducerAppliesConfig tests that the writer and the dialer shared with consumers use the
+// configured brokers, batching, TLS and credentials
+func TestNewProducerAppliesConfig(t *testing.T) {
+	if brokers := DefaultConfig().Brokers; len(brokers) != 1 || brokers[0] != DefaultBroker {
+		t.Errorf("Expected %s by default, got %v", DefaultBroker, brokers)
+	}
+
+	config := DefaultConfig("kafka-1:9093", "kafka-2:9093")
+	config.TLS = &tls.Config{ServerName: "kafka"}
+	config.SASL = SASLConfig{Mechanism: SASLPlain, Username: "gateway", Password: "secret"}
+	config.BatchSize = 500
+	config.BatchTimeout = time.Second
+
+	p, err := NewProducer(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if brokers := p.Brokers(); len(brokers) != 2 || p.writer.Addr.String() != "kafka-1:9093,kafka-2:9093" {
+		t.Errorf("Expected both brokers, got %v and %s", brokers, p.writer.Addr)
+	}
+	if p.writer.BatchSize != 500 || p.writer.BatchTimeout != time.Second || p.writer.BatchBytes != 1<<20 {
+		t.Errorf("Expected the configured batching, got %d messages, %d bytes, %s",
+			p.writer.BatchSize, p.writer.BatchBytes, p.writer.BatchTimeout)
+	}
+
+	transport := p.writer.Transport.(*kafka.Transport)
+	if transport.TLS != config.TLS || p.dialer.TLS != config.TLS {
+		t.Error("Expected the writer and the dialer to use TLS")
+	}
+	mechanism, ok := p.dialer.SASLMechanism.(plain.Mechanism)
+	if !ok || mechanism.Username != "gateway" || transport.SASL != p.dialer.SASLMechanism {
+		t.Errorf("Expected the writer and the dialer to authenticate with PLAIN, got %v", p.dialer.SASLMechanism)
+	}
+}
@@ -0,0 +1,249 @@
+package kafka
+
+import (
+	"context"
+	"embed"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"path"
+	"payment-gateway/internal/models"
+	"payment-gateway/internal/schemaregistry"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hamba/avro/v2"
+)
+
+// avroMagicByte starts Avro values in the Confluent wire format, followed by the 4-byte schema ID
+const avroMagicByte = 0
+
+// schemaFiles holds the versions of the event schemas, named <schema>.v<version>.avsc
+//
+//go:embed schemas/*.avsc
+var schemaFiles embed.FS
+
+// TransactionEventSchemas are the versions of the TransactionEvent schema, oldest first. Each
+// must be backward compatible with the previous ones: consumers reading with it still decode
+// events published with any of them.
+var TransactionEventSchemas, transactionEventSchemaTexts = mustLoadSchemas("transaction_event")
+
+// TransactionEventSchema is the version of the TransactionEvent schema events are published with
+var TransactionEventSchema = TransactionEventSchemas[len(TransactionEventSchemas)-1]
+
+// transactionEventSchemaText is TransactionEventSchema as written, with its docs and defaults,
+// which the canonical form drops
+var transactionEventSchemaText = transactionEventSchemaTexts[len(transactionEventSchemaTexts)-1]
+
+// TransactionEvent is the payload of transaction events. It is the versioned contract with
+// consumers, kept apart from models.Transaction so internal fields and personal data are not
+// published and model changes do not break consumers.
+type TransactionEvent struct {
+	EventType        string    `json:"event_type" avro:"event_type"`
+	TransactionID    int64     `json:"transaction_id" avro:"transaction_id"`
+	ReferenceID      string    `json:"reference_id" avro:"reference_id"`
+	Type             string    `json:"type" avro:"type"`
+	Status           string    `json:"status" avro:"status"`
+	Amount           float64   `json:"amount" avro:"amount"`
+	Currency         string    `json:"currency" avro:"currency"`
+	UserID           int64     `json:"user_id" avro:"user_id"`
+	GatewayID        int64     `json:"gateway_id" avro:"gateway_id"`
+	CountryID        int64     `json:"country_id" avro:"country_id"`
+	GatewayReference string    `json:"gateway_reference" avro:"gateway_reference"`
+	DeclineCode      string    `json:"decline_code" avro:"decline_code"`
+	ErrorMessage     string    `json:"error_message" avro:"error_message"`
+	Livemode         bool      `json:"livemode" avro:"livemode"`
+	CreatedAt        time.Time `json:"created_at" avro:"created_at"`
+}
+
+// NewTransactionEvent builds the event of a transaction
+func NewTransactionEvent(eventType string, tx models.Transaction) TransactionEvent {
+	return TransactionEvent{
+		EventType:        eventType,
+		TransactionID:    int64(tx.ID),
+		ReferenceID:      tx.ReferenceID,
+		Type:             tx.Type,
+		Status:           tx.Status,
+		Amount:           tx.Amount,
+		Currency:         tx.Currency,
+		UserID:           int64(tx.UserID),
+		GatewayID:        int64(tx.GatewayID),
+		CountryID:        int64(tx.CountryID),
+		GatewayReference: tx.GatewayReference,
+		DeclineCode:      tx.DeclineCode,
+		ErrorMessage:     tx.ErrorMessage,
+		Livemode:         tx.Livemode,
+		CreatedAt:        tx.CreatedAt.UTC().Truncate(time.Millisecond),
+	}
+}
+
+// mustLoadSchemas parses the versions of an embedded schema, ordered by version, returning them
+// with their text
+func mustLoadSchemas(name string) ([]avro.Schema, []string) {
+	files, err := schemaFiles.ReadDir("schemas")
+	if err != nil {
+		panic(err)
+	}
+
+	versions := map[int]string{}
+	for _, file := range files {
+		version, ok := strings.CutPrefix(strings.TrimSuffix(file.Name(), ".avsc"), name+".v")
+		if !ok {
+			continue
+		}
+		n, err := strconv.Atoi(version)
+		if err != nil {
+			panic(fmt.Sprintf("schema file %s: version is not a number", file.Name()))
+		}
+		data, err := schemaFiles.ReadFile(path.Join("schemas", file.Name()))
+		if err != nil {
+			panic(err)
+		}
+		versions[n] = string(data)
+	}
+	if len(versions) == 0 {
+		panic("no versions of schema " + name)
+	}
+
+	numbers := make([]int, 0, len(versions))
+	for n := range versions {
+		numbers = append(numbers, n)
+	}
+	sort.Ints(numbers)
+	schemas := make([]avro.Schema, len(numbers))
+	texts := make([]string, len(numbers))
+	for i, n := range numbers {
+		schemas[i], texts[i] = avro.MustParse(versions[n]), versions[n]
+	}
+	return schemas, texts
+}
+
+// valueSubject returns the registry subject of a topic's message values, by the registry's
+// default topic name strategy
+func valueSubject(topic string) string {
+	return topic + "-value"
+}
+
+// eventCodec encodes and decodes transaction events: as Avro in the Confluent wire format when a
+// schema registry is configured, and as JSON of the same fields otherwise
+type eventCodec struct {
+	registry *schemaregistry.Client // nil for JSON
+
+	mu      sync.Mutex
+	ids     map[string]int      // ID of TransactionEventSchema by subject, once registered
+	readers map[int]avro.Schema // schemas resolving each writer schema to TransactionEventSchema
+}
+
+// newEventCodec creates a codec, registering schemas with registry when it is not nil
+func newEventCodec(registry *schemaregistry.Client) *eventCodec {
+	return &eventCodec{
+		registry: registry,
+		ids:      make(map[string]int),
+		readers:  make(map[int]avro.Schema),
+	}
+}
+
+// encode encodes an event published to topic
+func (c *eventCodec) encode(ctx context.Context, topic string, event TransactionEvent) ([]byte, error) {
+	if c.registry == nil {
+		return json.Marshal(event)
+	}
+
+	id, err := c.schemaID(ctx, valueSubject(topic))
+	if err != nil {
+		return nil, err
+	}
+	data, err := avro.Marshal(TransactionEventSchema, event)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode transaction event: %w", err)
+	}
+
+	value := make([]byte, 5, 5+len(data))
+	value[0] = avroMagicByte
+	binary.BigEndian.PutUint32(value[1:], uint32(id))
+	return append(value, data...), nil
+}
+
+// schemaID returns the ID of TransactionEventSchema under subject, registering it the first time
+// once the registry confirmed it is compatible with the versions registered before
+func (c *eventCodec) schemaID(ctx context.Context, subject string) (int, error) {
+	c.mu.Lock()
+	id, ok := c.ids[subject]
+	c.mu.Unlock()
+	if ok {
+		return id, nil
+	}
+
+	schema := transactionEventSchemaText
+	compatible, reasons, err := c.registry.CheckCompatibility(ctx, subject, schema)
+	if err != nil {
+		return 0, fmt.Errorf("failed to check the compatibility of the %s schema: %w", subject, err)
+	}
+	if !compatible {
+		return 0, fmt.Errorf("the %s schema is not compatible with the versions registered: %s", subject, strings.Join(reasons, "; "))
+	}
+
+	id, err = c.registry.Register(ctx, subject, schema)
+	if err != nil {
+		return 0, fmt.Errorf("failed to register the %s schema: %w", subject, err)
+	}
+
+	c.mu.Lock()
+	c.ids[subject] = id
+	c.mu.Unlock()
+	return id, nil
+}
+
+// decode decodes an event, resolving Avro values written with another version of the schema
+func (c *eventCodec) decode(ctx context.Context, value []byte) (TransactionEvent, error) {
+	var event TransactionEvent
+	if len(value) == 0 || value[0] != avroMagicByte {
+		err := json.Unmarshal(value, &event)
+		return event, err
+	}
+	if len(value) < 5 {
+		return event, fmt.Errorf("truncated Avro value of %d bytes", len(value))
+	}
+
+	reader, err := c.reader(ctx, int(binary.BigEndian.Uint32(value[1:5])))
+	if err != nil {
+		return event, err
+	}
+	err = avro.Unmarshal(reader, value[5:], &event)
+	return event, err
+}
+
+// reader returns the schema decoding values written with the schema of an ID into a
+// TransactionEvent, fetching the writer schema from the registry the first time
+func (c *eventCodec) reader(ctx context.Context, id int) (avro.Schema, error) {
+	c.mu.Lock()
+	reader, ok := c.readers[id]
+	c.mu.Unlock()
+	if ok {
+		return reader, nil
+	}
+	if c.registry == nil {
+		return nil, fmt.Errorf("an Avro value with schema %d needs a schema registry to decode", id)
+	}
+
+	text, err := c.registry.Schema(ctx, id)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch schema %d: %w", id, err)
+	}
+	writer, err := avro.Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse schema %d: %w", id, err)
+	}
+	reader, err = avro.NewSchemaCompatibility().Resolve(TransactionEventSchema, writer)
+	if err != nil {
+		return nil, fmt.Errorf("schema %d cannot be read as a TransactionEvent: %w", id, err)
+	}
+
+	c.mu.Lock()
+	c.readers[id] = reader
+	c.mu.Unlock()
+	return reader, nil
+}
@@ -0,0 +1,181 @@
+package kafka
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"payment-gateway/internal/models"
+	"payment-gateway/internal/schemaregistry"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/hamba/avro/v2"
+)
+
+// fakeRegistry is a schema registry keeping every schema registered, reporting a schema
+// compatible when it reads events of the subject's latest version
+type fakeRegistry struct {
+	mu       sync.Mutex
+	schemas  []string            // by ID - 1
+	subjects map[string][]string // versions by subject
+	checks   int
+}
+
+func newFakeRegistry(t *testing.T) (*fakeRegistry, *schemaregistry.Client) {
+	r := &fakeRegistry{subjects: map[string][]string{}}
+	server := httptest.NewServer(r)
+	t.Cleanup(server.Close)
+	return r, schemaregistry.New(schemaregistry.Config{URL: server.URL})
+}
+
+func (r *fakeRegistry) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var body struct {
+		Schema string `json:"schema"`
+	}
+	json.NewDecoder(req.Body).Decode(&body)
+
+	switch {
+	case strings.HasPrefix(req.URL.Path, "/compatibility/subjects/"):
+		r.checks++
+		subject := strings.Split(req.URL.Path, "/")[3]
+		versions := r.subjects[subject]
+		if len(versions) == 0 {
+			w.WriteHeader(http.StatusNotFound)
+			json.NewEncoder(w).Encode(map[string]interface{}{"error_code": schemaregistry.ErrorSubjectNotFound, "message": "Subject not found"})
+			return
+		}
+		err := avro.NewSchemaCompatibility().Compatible(avro.MustParse(body.Schema), avro.MustParse(versions[len(versions)-1]))
+		result := map[string]interface{}{"is_compatible": err == nil}
+		if err != nil {
+			result["messages"] = []string{err.Error()}
+		}
+		json.NewEncoder(w).Encode(result)
+	case strings.HasPrefix(req.URL.Path, "/subjects/"):
+		subject := strings.Split(req.URL.Path, "/")[2]
+		r.subjects[subject] = append(r.subjects[subject], body.Schema)
+		r.schemas = append(r.schemas, body.Schema)
+		json.NewEncoder(w).Encode(map[string]int{"id": len(r.schemas)})
+	case strings.HasPrefix(req.URL.Path, "/schemas/ids/"):
+		var id int
+		json.Unmarshal([]byte(strings.TrimPrefix(req.URL.Path, "/schemas/ids/")), &id)
+		if id < 1 || id > len(r.schemas) {
+			w.WriteHeader(http.StatusNotFound)
+			json.NewEncoder(w).Encode(map[string]interface{}{"error_code": schemaregistry.ErrorSchemaNotFound, "message": "Schema not found"})
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]string{"schema": r.schemas[id-1]})
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+// register registers a schema directly, as another producer would
+func (r *fakeRegistry) register(subject, schema string) int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.subjects[subject] = append(r.subjects[subject], schema)
+	r.schemas = append(r.schemas, schema)
+	return len(r.schemas)
+}
+
+// TestTransactionEventSchemasBackwardCompatible tests that every version of the TransactionEvent
+// schema reads events published with the versions before it, and that the current one encodes a
+// TransactionEvent
+func TestTransactionEventSchemasBackwardCompatible(t *testing.T) {
+	compatibility := avro.NewSchemaCompatibility()
+	for i, writer := range TransactionEventSchemas {
+		for j := i + 1; j < len(TransactionEventSchemas); j++ {
+			if err := compatibility.Compatible(TransactionEventSchemas[j], writer); err != nil {
+				t.Errorf("Version %d cannot read events published with version %d: %v", j+1, i+1, err)
+			}
+		}
+	}
+
+	event := NewTransactionEvent(EventTransactionSubmitted, models.Transaction{ID: 7, Amount: 12.5, Currency: "EUR", Status: "processing", CreatedAt: time.Now()})
+	data, err := avro.Marshal(TransactionEventSchema, event)
+	if err != nil {
+		t.Fatalf("Expected the event encoded with the current schema, got %v", err)
+	}
+	var decoded TransactionEvent
+	if err := avro.Unmarshal(TransactionEventSchema, data, &decoded); err != nil || decoded != event {
+		t.Errorf("Expected %+v after a round trip, got %+v: %v", event, decoded, err)
+	}
+}
+
+// TestEventCodecAvro tests that events are published as Avro with the schema registered once its
+// compatibility was confirmed, that events published with an older schema are read as the
+// current one, and that an incompatible schema is not registered
+func TestEventCodecAvro(t *testing.T) {
+	registry, client := newFakeRegistry(t)
+	codec := newEventCodec(client)
+	ctx := context.Background()
+
+	event := NewTransactionEvent(EventTransactionSubmitted, models.Transaction{ID: 8, Status: "processing", GatewayReference: "pi_8", CreatedAt: time.Now()})
+	for i := 0; i < 2; i++ {
+		value, err := codec.encode(ctx, TopicTransactionsJSON, event)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if value[0] != avroMagicByte || binary.BigEndian.Uint32(value[1:5]) != 1 {
+			t.Fatalf("Expected the Confluent wire format with schema 1, got % x", value[:5])
+		}
+		if decoded, err := newEventCodec(client).decode(ctx, value); err != nil || decoded != event {
+			t.Errorf("Expected %+v decoded, got %+v: %v", event, decoded, err)
+		}
+	}
+	if registry.checks != 1 || len(registry.subjects[TopicTransactionsJSON+"-value"]) != 1 {
+		t.Errorf("Expected the schema checked and registered once, got %d checks and %v", registry.checks, registry.subjects)
+	}
+
+	// An older producer publishing only the fields without defaults
+	older := `{"type":"record","name":"TransactionEvent","namespace":"com.paymentgateway.events","fields":[
+		{"name":"event_type","type":"string"},{"name":"transaction_id","type":"long"},{"name":"type","type":"string"},
+		{"name":"status","type":"string"},{"name":"amount","type":"double"},{"name":"currency","type":"string"},
+		{"name":"user_id","type":"long"},{"name":"gateway_id","type":"long"},
+		{"name":"created_at","type":{"type":"long","logicalType":"timestamp-millis"}}]}`
+	id := registry.register(TopicTransactionsSOAP+"-value", older)
+	data, err := avro.Marshal(avro.MustParse(older), map[string]interface{}{
+		"event_type": EventTransactionSubmitted, "transaction_id": int64(9), "type": "deposit", "status": "completed",
+		"amount": 5.0, "currency": "GBP", "user_id": int64(1), "gateway_id": int64(2), "created_at": time.UnixMilli(0).UTC(),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	value := binary.BigEndian.AppendUint32([]byte{avroMagicByte}, uint32(id))
+	decoded, err := codec.decode(ctx, append(value, data...))
+	if err != nil || decoded.TransactionID != 9 || decoded.Status != "completed" || decoded.GatewayReference != "" {
+		t.Errorf("Expected the older event read with defaults, got %+v: %v", decoded, err)
+	}
+
+	// Events of a schema without the current one's required fields cannot be read with it
+	registry.register(TopicTransactionsForm+"-value", `{"type":"record","name":"TransactionEvent","namespace":"com.paymentgateway.events","fields":[{"name":"merchant_id","type":"long"}]}`)
+	if _, err := codec.encode(ctx, TopicTransactionsForm, event); err == nil || !strings.Contains(err.Error(), "not compatible") {
+		t.Errorf("Expected an incompatible schema refused, got %v", err)
+	}
+	if len(registry.subjects[TopicTransactionsForm+"-value"]) != 1 {
+		t.Error("Expected the incompatible schema not registered")
+	}
+}
+
+// TestEventCodecJSON tests that without a schema registry events are published as JSON of the
+// TransactionEvent fields
+func TestEventCodecJSON(t *testing.T) {
+	event := NewTransactionEvent(EventTransactionSubmitted, models.Transaction{ID: 10, Beneficiary: "Jane Doe", Status: "processing"})
+	value, err := newEventCodec(nil).encode(context.Background(), TopicTransactionsJSON, event)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(value), `"transaction_id":10`) || strings.Contains(string(value), "Jane Doe") {
+		t.Errorf("Expected the event's fields only, got %s", value)
+	}
+	if decoded, err := newEventCodec(nil).decode(context.Background(), value); err != nil || decoded != event {
+		t.Errorf("Expected %+v decoded, got %+v: %v", event, decoded, err)
+	}
+}
@@ -1,15 +1,59 @@
 package models
 
-import "time"
+import (
+	"encoding/json"
+	"time"
+)
 
 // User represents a user in the system
 type User struct {
-	ID        int       `json:"id"`
-	Username  string    `json:"username"`
-	Email     string    `json:"email"`
-	CountryID int       `json:"country_id"`
-	CreatedAt time.Time `json:"created_at"`
-	UpdatedAt time.Time `json:"updated_at,omitempty"`
+	ID         int       `json:"id"`
+	Username   string    `json:"username"`
+	Email      string    `json:"email"`
+	CountryID  int       `json:"country_id"`
+	MerchantID int       `json:"merchant_id,omitempty"`
+	CreatedAt  time.Time `json:"created_at"`
+	UpdatedAt  time.Time `json:"updated_at,omitempty"`
+
+	FullName        string `json:"full_name,omitempty"`        // legal name, screened against sanctions lists
+	ScreeningStatus string `json:"screening_status,omitempty"` // clear, pending_review or blocked; empty for users never screened
+
+	UserContact
+}
+
+// UserCreateRequest registers a user
+type UserCreateRequest struct {
+	Username    string `json:"username" validate:"required,max=255"`
+	Email       string `json:"email" validate:"required,email,max=255"`
+	FullName    string `json:"full_name" validate:"required,max=140"`
+	CountryCode string `json:"country_code" validate:"required,country"`
+	MerchantID  int    `json:"merchant_id,omitempty" validate:"gte=0"`
+}
+
+// UserContact holds a user's contact details as entered and in normalized form
+type UserContact struct {
+	Phone                string `json:"phone,omitempty"`
+	PhoneE164            string `json:"phone_e164,omitempty"` // Phone in E.164 form, e.g. "+447911123456"
+	PostalCode           string `json:"postal_code,omitempty"`
+	PostalCodeNormalized string `json:"postal_code_normalized,omitempty"` // PostalCode in the country's canonical form
+}
+
+// UserContactRequest updates a user's contact details; empty fields are cleared
+type UserContactRequest struct {
+	Phone      string `json:"phone,omitempty" validate:"omitempty,phone"`
+	PostalCode string `json:"postal_code,omitempty" validate:"omitempty,postal_code"`
+}
+
+// Merchant represents a business integrating with the gateway on behalf of its users
+type Merchant struct {
+	ID                     int       `json:"id"`
+	Name                   string    `json:"name"`
+	AllowedRedirectDomains []string  `json:"allowed_redirect_domains"`
+	WebhookURL             string    `json:"webhook_url,omitempty"` // receives transaction lifecycle events
+	WebhookSecret          string    `json:"-"`                     // encrypted; signs webhook deliveries when set
+	Livemode               bool      `json:"livemode"`              // transactions go to gateways' production environments
+	CreatedAt              time.Time `json:"created_at"`
+	UpdatedAt              time.Time `json:"updated_at,omitempty"`
 }
 
 // Country represents a country
@@ -18,6 +62,262 @@ type Country struct {
 	Name     string `json:"name"`
 	Code     string `json:"code"`
 	Currency string `json:"currency"`
+	Timezone string `json:"timezone,omitempty"` // IANA zone, e.g. "Europe/London"
+}
+
+// WebhookSecret is a shared secret a gateway signs its callbacks with. A gateway can have several
+// active secrets so callbacks signed with either the old or the new one verify during rotation.
+type WebhookSecret struct {
+	ID        int       `json:"id"`
+	GatewayID int       `json:"gateway_id"`
+	Secret    string    `json:"secret,omitempty"` // plaintext, only returned when the secret is added
+	Hint      string    `json:"hint"`             // last characters of the secret, to tell secrets apart
+	Status    string    `json:"status"`           // "active" or "retired"
+	CreatedAt time.Time `json:"created_at"`
+	RetiredAt time.Time `json:"retired_at,omitempty"`
+}
+
+// ClientCertificate is a TLS client certificate a gateway's connections authenticate with (mTLS)
+type ClientCertificate struct {
+	ID          int       `json:"id"`
+	GatewayID   int       `json:"gateway_id"`
+	Certificate string    `json:"certificate"` // PEM certificate chain, leaf first
+	PrivateKey  string    `json:"-"`           // encrypted PEM private key
+	Subject     string    `json:"subject"`
+	Fingerprint string    `json:"fingerprint"` // SHA-256 of the leaf certificate, hex
+	NotAfter    time.Time `json:"not_after"`
+	Status      string    `json:"status"` // "active" or "retired"
+	CreatedAt   time.Time `json:"created_at"`
+	RetiredAt   time.Time `json:"retired_at,omitempty"`
+}
+
+// ClientCertificateRequest adds a client certificate and its private key to a gateway
+type ClientCertificateRequest struct {
+	Certificate string `json:"certificate" validate:"required"`
+	PrivateKey  string `json:"private_key" validate:"required"`
+}
+
+// MerchantCertificate is a key pair Apple Pay or Google Pay encrypts payment tokens to. Apple Pay
+// tokens name the key they were encrypted to by the hash of its public key, while Google Pay tokens
+// are decrypted with each active key in turn, so either wallet can have several keys while one is
+// rotated.
+type MerchantCertificate struct {
+	ID                 int       `json:"id"`
+	Wallet             string    `json:"wallet"`                        // "apple_pay" or "google_pay"
+	Certificate        string    `json:"certificate,omitempty"`         // PEM payment processing certificate issued by Apple; Apple Pay only
+	PublicKey          string    `json:"public_key"`                    // base64 uncompressed public key, as registered with Google Pay
+	PrivateKey         string    `json:"-"`                             // encrypted PEM private key
+	PublicKeyHash      string    `json:"public_key_hash"`               // SHA-256 of the public key, base64, as Apple Pay tokens name it
+	MerchantIdentifier string    `json:"merchant_identifier,omitempty"` // hash of the Apple Pay merchant ID, hex
+	NotAfter           time.Time `json:"not_after,omitempty"`
+	Status             string    `json:"status"` // "active" or "retired"
+	CreatedAt          time.Time `json:"created_at"`
+	RetiredAt          time.Time `json:"retired_at,omitempty"`
+}
+
+// MerchantCertificateRequest adds a merchant key pair to a wallet. Apple Pay keys come with the
+// payment processing certificate Apple issued for them.
+type MerchantCertificateRequest struct {
+	Wallet      string `json:"wallet" validate:"required,oneof=apple_pay google_pay"`
+	Certificate string `json:"certificate,omitempty"`
+	PrivateKey  string `json:"private_key" validate:"required"`
+}
+
+// WalletToken is an encrypted Apple Pay or Google Pay payment token, as the wallet returned it
+// to the merchant's page
+type WalletToken struct {
+	Type string `json:"type" validate:"required,oneof=apple_pay google_pay"`
+
+	// Data is Apple Pay's paymentData object or Google Pay's tokenizationData.token, as a JSON string
+	Data string `json:"data" validate:"required,max=16384"`
+}
+
+// WalletCard is the card a wallet payment token was decrypted to: a device or network token with
+// its one-time cryptogram, or the card itself for Google Pay's PAN_ONLY method
+type WalletCard struct {
+	Wallet      string
+	Number      string // device account number, or the card number for PAN_ONLY
+	ExpiryMonth int
+	ExpiryYear  int    // four digits
+	Cryptogram  string // base64 online payment cryptogram; empty for PAN_ONLY
+	ECI         string // electronic commerce indicator, when the wallet gave one
+	AuthMethod  string // "CRYPTOGRAM_3DS" or "PAN_ONLY"
+}
+
+// SigningKey is a private key requests to a gateway are signed with (JWS). The gateway picks
+// the public key to verify with by the key ID in the signature's "kid" header.
+type SigningKey struct {
+	ID         int       `json:"id"`
+	GatewayID  int       `json:"gateway_id"`
+	KeyID      string    `json:"kid"`
+	Algorithm  string    `json:"alg"`        // "RS256" or "ES256"
+	PublicKey  string    `json:"public_key"` // PEM public key
+	PrivateKey string    `json:"-"`          // encrypted PEM private key
+	Status     string    `json:"status"`     // "active" or "retired"
+	CreatedAt  time.Time `json:"created_at"`
+	RetiredAt  time.Time `json:"retired_at,omitempty"`
+}
+
+// SigningKeyRequest adds a signing key to a gateway; the key ID defaults to the key's thumbprint
+type SigningKeyRequest struct {
+	KeyID      string `json:"kid,omitempty" validate:"max=255"`
+	PrivateKey string `json:"private_key" validate:"required"`
+}
+
+// WebhookSecretRequest adds a webhook secret to a gateway; a secret is generated when none is given
+type WebhookSecretRequest struct {
+	Secret string `json:"secret,omitempty" validate:"omitempty,min=16"`
+}
+
+// MerchantWebhookSecret is a merchant's webhook signing secret, returned only when it is created
+type MerchantWebhookSecret struct {
+	Secret    string    `json:"secret"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// WebhookEndpoint is a URL a merchant receives webhooks at, in addition to the merchant's
+// webhook_url. Only events of the listed types are delivered to it; an endpoint without types
+// receives every event.
+type WebhookEndpoint struct {
+	ID             int       `json:"id"`
+	MerchantID     int       `json:"-"`
+	URL            string    `json:"url"`
+	EventTypes     []string  `json:"event_types"`     // event types or prefixes such as transfer.*
+	PayloadVersion string    `json:"payload_version"` // v1 or v2; the shape of the payloads delivered to it
+	Description    string    `json:"description,omitempty"`
+	CreatedAt      time.Time `json:"created_at"`
+	UpdatedAt      time.Time `json:"updated_at"`
+}
+
+// WebhookEndpointRequest creates or replaces a webhook endpoint. New endpoints without a payload
+// version receive the latest; existing ones keep the version they are pinned to.
+type WebhookEndpointRequest struct {
+	URL            string   `json:"url" validate:"required,max=2048"`
+	EventTypes     []string `json:"event_types,omitempty" validate:"max=50"`
+	PayloadVersion string   `json:"payload_version,omitempty" validate:"omitempty,oneof=v1 v2"`
+	Description    string   `json:"description,omitempty" validate:"max=255"`
+}
+
+// WebhookVerifyRequest optionally carries a payload and the signature a merchant computed for
+// it with their webhook secret, to be checked by the platform
+type WebhookVerifyRequest struct {
+	Payload        string `json:"payload,omitempty" validate:"max=65536"`
+	Signature      string `json:"signature,omitempty" validate:"max=128"`
+	PayloadVersion string `json:"payload_version,omitempty" validate:"omitempty,oneof=v1 v2"` // shape of the samples; v1 by default
+}
+
+// WebhookSample is a webhook delivery as a merchant's endpoint would receive it
+type WebhookSample struct {
+	Description string            `json:"description"`
+	Headers     map[string]string `json:"headers"`
+	Payload     string            `json:"payload"` // raw body, signed byte for byte
+	Valid       bool              `json:"valid"`   // whether a correct verifier accepts it
+}
+
+// WebhookVerification holds signed samples for testing a webhook verifier and, when a payload
+// was submitted, whether the merchant's signature of it matched
+type WebhookVerification struct {
+	SignatureValid    *bool           `json:"signature_valid,omitempty"`
+	ExpectedSignature string          `json:"expected_signature,omitempty"`
+	Samples           []WebhookSample `json:"samples"`
+}
+
+// MaintenanceWindow is a period during which a gateway is skipped by routing
+type MaintenanceWindow struct {
+	ID          int       `json:"id"`
+	GatewayID   int       `json:"gateway_id"`
+	StartsAt    time.Time `json:"starts_at"`
+	EndsAt      time.Time `json:"ends_at"`
+	Reason      string    `json:"reason,omitempty"`
+	Active      bool      `json:"active"` // whether the window is in progress
+	CreatedAt   time.Time `json:"created_at"`
+	CancelledAt time.Time `json:"cancelled_at,omitempty"`
+}
+
+// MaintenanceWindowRequest schedules a maintenance window for a gateway
+type MaintenanceWindowRequest struct {
+	StartsAt time.Time `json:"starts_at" validate:"required"`
+	EndsAt   time.Time `json:"ends_at" validate:"required"`
+	Reason   string    `json:"reason,omitempty" validate:"max=255"`
+}
+
+// APIKey is a credential a merchant authenticates API requests with. Only a hash of the key is
+// stored; the full key is returned once, when it is created.
+type APIKey struct {
+	ID         int       `json:"id"`
+	MerchantID int       `json:"merchant_id"`
+	Name       string    `json:"name"`
+	Prefix     string    `json:"prefix"`        // public part of the key, used to look it up
+	Key        string    `json:"key,omitempty"` // only returned on creation
+	KeyHash    string    `json:"-"`
+	Scope      string    `json:"scope"`  // "read_only" or "full"
+	Status     string    `json:"status"` // "active" or "revoked"
+	CreatedAt  time.Time `json:"created_at"`
+	LastUsedAt time.Time `json:"last_used_at,omitempty"`
+	ExpiresAt  time.Time `json:"expires_at,omitempty"` // set when the key was rolled
+	RevokedAt  time.Time `json:"revoked_at,omitempty"`
+}
+
+// APIKeyRequest is the body of an API key creation request
+type APIKeyRequest struct {
+	Name  string `json:"name" validate:"max=255"`
+	Scope string `json:"scope" validate:"omitempty,oneof=read_only full"`
+}
+
+// OAuthClient is a merchant's OAuth2 client-credentials client
+type OAuthClient struct {
+	ID         int       `json:"id"`
+	ClientID   string    `json:"client_id"`
+	MerchantID int       `json:"merchant_id"`
+	Name       string    `json:"name"`
+	Secret     string    `json:"client_secret,omitempty"` // only returned on creation
+	SecretHash string    `json:"-"`
+	Scopes     []string  `json:"scopes"`
+	Status     string    `json:"status"` // "active" or "revoked"
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+// OAuthClientRequest is the body of an OAuth2 client creation request
+type OAuthClientRequest struct {
+	Name   string   `json:"name" validate:"max=255"`
+	Scopes []string `json:"scopes" validate:"dive,required"`
+}
+
+// TokenRequest is an OAuth2 client-credentials token request, posted as a form
+type TokenRequest struct {
+	GrantType    string `json:"grant_type" validate:"required"`
+	ClientID     string `json:"client_id"`
+	ClientSecret string `json:"client_secret"`
+	Scope        string `json:"scope"`
+}
+
+// TokenResponse is an issued OAuth2 access token
+type TokenResponse struct {
+	AccessToken string `json:"access_token"`
+	TokenType   string `json:"token_type"`
+	ExpiresIn   int    `json:"expires_in"`
+	Scope       string `json:"scope"`
+}
+
+// OAuthError is an OAuth2 error response (RFC 6749 section 5.2)
+type OAuthError struct {
+	Error       string `json:"error"`
+	Description string `json:"error_description,omitempty"`
+}
+
+// CallbackRecord is a gateway callback as received, stored before parsing so failed callbacks
+// can be investigated and reparsed. Sensitive headers are masked.
+type CallbackRecord struct {
+	ID            int               `json:"id"`
+	GatewayID     int               `json:"gateway_id"`
+	Headers       map[string]string `json:"headers"`
+	Body          string            `json:"body"`
+	Status        string            `json:"status"` // "received", "processed", "parse_failed", "failed" or "rejected"
+	Error         string            `json:"error,omitempty"`
+	TransactionID int               `json:"transaction_id,omitempty"`
+	Attempts      int               `json:"attempts"`
+	CreatedAt     time.Time         `json:"created_at"`
+	ProcessedAt   time.Time         `json:"processed_at,omitempty"`
 }
 
 // Gateway represents a payment gateway
@@ -39,48 +339,1167 @@ type GatewayPriority struct {
 
 // Transaction represents a payment transaction
 type Transaction struct {
-	ID           int       `json:"id"`
-	Amount       float64   `json:"amount"`
-	Currency     string    `json:"currency"`
-	Type         string    `json:"type"`   // "deposit" or "withdrawal"
-	Status       string    `json:"status"` // "pending", "processing", "completed", "failed"
-	UserID       int       `json:"user_id"`
-	GatewayID    int       `json:"gateway_id"`
-	CountryID    int       `json:"country_id"`
-	ReferenceID  string    `json:"reference_id,omitempty"`
-	ErrorMessage string    `json:"error_message,omitempty"`
-	CreatedAt    time.Time `json:"created_at"`
+	ID                     int       `json:"id"`
+	Amount                 float64   `json:"amount"`
+	Currency               string    `json:"currency"`
+	Type                   string    `json:"type"`   // "deposit" or "withdrawal"
+	Status                 string    `json:"status"` // "pending", "processing", "completed", "failed"
+	UserID                 int       `json:"user_id"`
+	GatewayID              int       `json:"gateway_id"`
+	CountryID              int       `json:"country_id"`
+	Beneficiary            string    `json:"beneficiary,omitempty"`
+	PhoneNumber            string    `json:"phone_number,omitempty"` // mobile-money wallet number as entered
+	PhoneE164              string    `json:"phone_e164,omitempty"`   // PhoneNumber in E.164 form
+	ReturnURL              string    `json:"return_url,omitempty"`
+	CancelURL              string    `json:"cancel_url,omitempty"`
+	ReferenceID            string    `json:"reference_id,omitempty"`            // our reference, generated at creation
+	GatewayReference       string    `json:"gateway_reference,omitempty"`       // the provider's own reference for the transaction
+	RedirectURL            string    `json:"redirect_url,omitempty"`            // hosted payment page returned by the provider
+	GatewayIdempotencyKey  string    `json:"gateway_idempotency_key,omitempty"` // sent to providers so retries cannot double-charge
+	ErrorMessage           string    `json:"error_message,omitempty"`
+	DeclineCode            string    `json:"decline_code,omitempty"`          // normalized reason a provider declined the transaction
+	RecoveryHint           string    `json:"recovery_hint,omitempty"`         // what the customer should do after the decline; not stored
+	SCAExemption           string    `json:"sca_exemption,omitempty"`         // SCA exemption requested for a card deposit
+	SCAExemptionOutcome    string    `json:"sca_exemption_outcome,omitempty"` // granted, rejected or unsupported
+	Livemode               bool      `json:"livemode"`                        // sent to the gateway's production environment
+	CardBIN                string    `json:"card_bin,omitempty"`              // first 6-8 digits of the card, when paid by card
+	BankID                 string    `json:"-"`                               // bank an open banking deposit is paid from; kept with its consent
+	PayerVPA               string    `json:"-"`                               // UPI address a deposit's collect request is sent to; not stored
+	RetryOfID              int       `json:"retry_of_id,omitempty"`           // transaction whose soft decline this one retries
+	CountrySource          string    `json:"country_source,omitempty"`        // which signal CountryID was resolved from
+	RiskFlags              []string  `json:"risk_flags,omitempty"`
+	ScheduledFor           time.Time `json:"scheduled_for,omitempty"`            // payout a scheduled withdrawal waits for
+	ExpectedSettlementDate string    `json:"expected_settlement_date,omitempty"` // YYYY-MM-DD, withdrawals only
+	CreatedAt              time.Time `json:"created_at"`
+	UpdatedAt              time.Time `json:"updated_at,omitempty"`
+	DeletedAt              time.Time `json:"deleted_at,omitempty"` // set when soft-deleted; the row is archived on the next retention run
+
+	// Values of the country's compliance fields, passed to the provider; stored encrypted
+	ComplianceFields map[string]string `json:"-"`
+
+	// Crypto is the payment made for a crypto deposit, once the gateway has seen one
+	Crypto *CryptoPayment `json:"crypto,omitempty"`
+
+	// BankAccountID is the linked bank account a withdrawal is paid out to by open banking
+	BankAccountID int `json:"bank_account_id,omitempty"`
+
+	// MandateID is the SEPA mandate a deposit is debited under, or whose account a withdrawal is
+	// paid out to by credit transfer
+	MandateID int `json:"mandate_id,omitempty"`
+
+	// Wallet payment token a deposit is paid with: forwarded as is to gateways accepting it, or
+	// decrypted into WalletCard for the others. Neither is stored.
+	WalletToken *WalletToken `json:"-"`
+	WalletCard  *WalletCard  `json:"-"`
+
+	// Set for deposits authorized to be captured later: the amount the gateway held and when the
+	// hold lapses. Amount becomes the amount of the final capture. Incrementally authorized
+	// deposits can have the amount held raised while they are authorized.
+	AuthorizedAmount         float64   `json:"authorized_amount,omitempty"`
+	AuthorizationExpiresAt   time.Time `json:"authorization_expires_at,omitempty"`
+	IncrementalAuthorization bool      `json:"incremental_authorization,omitempty"`
+	ManualCapture            bool      `json:"-"` // sent to the gateway to authorize only; not stored
+
+	// Set for authorizations captured in parts: the amount captured so far, final capture
+	// included. Each part but the final one is a deposit of its own, linked by CaptureOfID.
+	CapturedAmount float64 `json:"captured_amount,omitempty"`
+	CaptureOfID    int     `json:"capture_of_id,omitempty"` // authorization this partial capture was taken from
+}
+
+// OutboxMessage is an external side effect of a state change, recorded before it is delivered.
+// Dispatchers deliver pending messages at least once; consumers deduplicate on DedupToken.
+type OutboxMessage struct {
+	ID            int             `json:"id"`
+	Destination   string          `json:"destination"` // "kafka", "merchant_webhook" or "warehouse"
+	EventType     string          `json:"event_type"`
+	TransactionID int             `json:"transaction_id"` // 0 for wallet transfer events, whose payload names the transfer
+	MerchantID    int             `json:"merchant_id,omitempty"`
+	EndpointID    int             `json:"endpoint_id,omitempty"` // merchant webhooks for one of the merchant's endpoints; 0 for webhook_url
+	DedupToken    string          `json:"dedup_token"`
+	ContentType   string          `json:"content_type"`
+	Payload       json.RawMessage `json:"payload"`
+	Status        string          `json:"status"` // "pending", "delivered", "failed" or "discarded"
+	Attempts      int             `json:"attempts"`
+	LastError     string          `json:"last_error,omitempty"`
+	NextAttemptAt time.Time       `json:"next_attempt_at"`
+	CreatedAt     time.Time       `json:"created_at"`
+	DeliveredAt   time.Time       `json:"delivered_at,omitempty"`
+}
+
+// TransactionSearch holds the criteria of an admin transaction search; a transaction matching
+// any of the non-zero criteria is returned
+type TransactionSearch struct {
+	TransactionID   int
+	Amount          float64
+	EmailHashes     []string // blind indexes of the user's email under every active key
+	ReferenceHashes []string // blind indexes of our or the gateway's reference under every active key
+	IdempotencyKey  string
+	Limit           int
+}
+
+// TransactionSearchResult is a redacted view of a transaction for support agents. It omits
+// beneficiaries and redirect URLs and masks the user's email.
+type TransactionSearchResult struct {
+	ID               int       `json:"id"`
+	Type             string    `json:"type"`
+	Status           string    `json:"status"`
+	Amount           float64   `json:"amount"`
+	Currency         string    `json:"currency"`
+	UserID           int       `json:"user_id"`
+	UserEmail        string    `json:"user_email"`
+	GatewayID        int       `json:"gateway_id"`
+	CountryID        int       `json:"country_id"`
+	ReferenceID      string    `json:"reference_id,omitempty"`
+	GatewayReference string    `json:"gateway_reference,omitempty"`
+	DeclineCode      string    `json:"decline_code,omitempty"`
+	CreatedAt        time.Time `json:"created_at"`
+}
+
+// RoutingDecision records how a gateway was chosen for a transaction
+type RoutingDecision struct {
+	ID                 int       `json:"id"`
+	TransactionID      int       `json:"transaction_id"`
+	CountryID          int       `json:"country_id"`
+	Type               string    `json:"type"`
+	SelectedGatewayID  int       `json:"selected_gateway_id"`
+	PreferredGatewayID int       `json:"preferred_gateway_id,omitempty"`
+	ExcludedGatewayIDs []int     `json:"excluded_gateway_ids,omitempty"`
+	Override           bool      `json:"override"` // true when the caller's preference, exclusions or routing rules changed the default choice
+	Reason             string    `json:"reason"`
+	CreatedAt          time.Time `json:"created_at"`
+}
+
+// TransactionEvent is an entry of a transaction's audit trail: its creation or a status change
+type TransactionEvent struct {
+	ID               int       `json:"id"`
+	TransactionID    int       `json:"transaction_id"`
+	Type             string    `json:"type"` // transaction.created or transaction.status_changed
+	Status           string    `json:"status"`
+	Message          string    `json:"message,omitempty"`
+	DeclineCode      string    `json:"decline_code,omitempty"`
+	GatewayReference string    `json:"gateway_reference,omitempty"`
+	CreatedAt        time.Time `json:"created_at"`
+}
+
+// DeclineRecoveryHint maps a normalized decline code to what the customer should do next
+type DeclineRecoveryHint struct {
+	DeclineCode  string    `json:"decline_code"`
+	RecoveryHint string    `json:"recovery_hint"` // try_again, use_other_method, contact_bank or do_not_retry
 	UpdatedAt    time.Time `json:"updated_at,omitempty"`
 }
 
-// TransactionRequest is the request format for transaction endpoints
-type TransactionRequest struct {
+// DeclineRecoveryHintRequest changes the recovery hint of a decline code
+type DeclineRecoveryHintRequest struct {
+	RecoveryHint string `json:"recovery_hint" validate:"required,oneof=try_again use_other_method contact_bank do_not_retry"`
+}
+
+// Dispute is a case opened when a user flags one of their transactions as unrecognized. It waits
+// in the review queue until an operator resolves or rejects it, ahead of any formal chargeback.
+type Dispute struct {
+	ID            int       `json:"id"`
+	TransactionID int       `json:"transaction_id"`
+	UserID        int       `json:"user_id"`
+	Description   string    `json:"description,omitempty"`
+	Status        string    `json:"status"`               // open, in_review, resolved or rejected
+	Resolution    string    `json:"resolution,omitempty"` // the reviewer's note, shown to the user
+	CreatedAt     time.Time `json:"created_at"`
+	UpdatedAt     time.Time `json:"updated_at"`
+}
+
+// DisputeRequest flags a transaction as unrecognized by the user who made it
+type DisputeRequest struct {
+	UserID      int    `json:"user_id" validate:"gt=0"`
+	Description string `json:"description,omitempty" validate:"max=1000"`
+}
+
+// DisputeUpdateRequest moves a dispute through review
+type DisputeUpdateRequest struct {
+	Status     string `json:"status" validate:"required,oneof=in_review resolved rejected"`
+	Resolution string `json:"resolution,omitempty" validate:"max=1000"`
+}
+
+// IdempotencyKey records a request sent with an Idempotency-Key header and the response to it, so
+// retries of the request get the same response instead of repeating it. The response is empty
+// while the first request is in progress.
+type IdempotencyKey struct {
+	Caller       string    `json:"caller"` // whom the key belongs to: keys are scoped to their caller and route
+	Key          string    `json:"key"`
+	Route        string    `json:"route"`
+	RequestHash  string    `json:"request_hash"` // SHA-256 of the request body, to detect a key reused for another request
+	StatusCode   int       `json:"status_code,omitempty"`
+	ContentType  string    `json:"content_type,omitempty"`
+	ResponseBody []byte    `json:"response_body,omitempty"`
+	CreatedAt    time.Time `json:"created_at"`
+	CompletedAt  time.Time `json:"completed_at,omitempty"`
+	ExpiresAt    time.Time `json:"expires_at"`
+}
+
+// TransactionHold keeps a completed deposit's amount out of its user's spendable wallet balance
+// while the fraud pipeline has the deposit reviewed. A reviewer releases it, or it is released
+// automatically at ReleaseAt.
+type TransactionHold struct {
+	ID            int       `json:"id"`
+	TransactionID int       `json:"transaction_id"`
+	UserID        int       `json:"user_id"`
+	Amount        float64   `json:"amount"`
+	Currency      string    `json:"currency"`
+	Livemode      bool      `json:"livemode"`
+	Reason        string    `json:"reason"`
+	Status        string    `json:"status"`                 // active, released or expired
+	ReleaseAt     time.Time `json:"release_at"`             // deadline the hold is released at unless extended
+	ReleaseNote   string    `json:"release_note,omitempty"` // the reviewer's note
+	ReleasedAt    time.Time `json:"released_at,omitempty"`
+	CreatedAt     time.Time `json:"created_at"`
+	UpdatedAt     time.Time `json:"updated_at"`
+}
+
+// TransactionHoldRequest places a hold on a completed deposit. Without release_at the hold is
+// released after consts.DefaultHoldDuration.
+type TransactionHoldRequest struct {
+	Reason    string    `json:"reason" validate:"required,max=255"`
+	ReleaseAt time.Time `json:"release_at,omitempty"`
+}
+
+// TransactionHoldReleaseRequest releases a hold after review
+type TransactionHoldReleaseRequest struct {
+	Note string `json:"note,omitempty" validate:"max=1000"`
+}
+
+// TransactionHoldExtendRequest moves a hold's deadline later
+type TransactionHoldExtendRequest struct {
+	ReleaseAt time.Time `json:"release_at" validate:"required"`
+}
+
+// Refund returns all or part of a completed deposit to the customer through the gateway that
+// took it. Refunds that are pending, processing or completed count against the deposit's amount;
+// failed ones don't.
+type Refund struct {
+	ID               int       `json:"id"`
+	TransactionID    int       `json:"transaction_id"`
+	Amount           float64   `json:"amount"`
+	Currency         string    `json:"currency"`
+	Status           string    `json:"status"` // pending, processing, completed or failed
+	Reason           string    `json:"reason,omitempty"`
+	GatewayReference string    `json:"gateway_reference,omitempty"` // the provider's own reference for the refund
+	DeclineCode      string    `json:"decline_code,omitempty"`
+	ErrorMessage     string    `json:"error_message,omitempty"`
+	CreatedAt        time.Time `json:"created_at"`
+	UpdatedAt        time.Time `json:"updated_at"`
+
+	// Sent to the provider so a retried refund is not paid twice; derived from the refund, not stored
+	GatewayIdempotencyKey string `json:"-"`
+
+	// RefundableAmount is what remains refundable of the deposit; not stored
+	RefundableAmount float64 `json:"refundable_amount"`
+}
+
+// RefundRequest refunds a completed deposit, in full when no amount is given
+type RefundRequest struct {
+	TransactionID int     `json:"transaction_id" validate:"gt=0"`
+	Amount        float64 `json:"amount,omitempty" validate:"omitempty,amount"`
+	Reason        string  `json:"reason,omitempty" validate:"max=255"`
+}
+
+// TransactionTagsRequest attaches tags to a transaction. A tag is a key and a value separated by
+// a colon, e.g. "campaign:blackfriday", or a single label such as "vip"; tags are stored in lower case.
+type TransactionTagsRequest struct {
+	Tags []string `json:"tags" validate:"required,max=20,dive,tag"`
+}
+
+// TransactionTags are the tags attached to a transaction, in alphabetical order
+type TransactionTags struct {
+	TransactionID int      `json:"transaction_id"`
+	Tags          []string `json:"tags"`
+}
+
+// TransactionListQuery filters a transaction list. Transactions match when they carry every tag
+// listed. Dates are YYYY-MM-DD in UTC and inclusive.
+type TransactionListQuery struct {
+	Tags     []string `json:"tags"`
+	Status   string   `json:"status"`
+	Type     string   `json:"type"`
+	From     string   `json:"from"`
+	To       string   `json:"to"`
+	BeforeID int      `json:"before_id"` // continue a list below the last ID of its previous page
+	Limit    int      `json:"limit"`
+}
+
+// TransactionFilter selects transactions from the store, newest first
+type TransactionFilter struct {
+	MerchantID int // 0 for every merchant's transactions
+	Tags       []string
+	Status     string
+	Type       string
+	From       time.Time // inclusive
+	To         time.Time // exclusive
+	BeforeID   int
+	Limit      int
+}
+
+// TransactionSummary is a transaction as transaction lists return it, with its tags
+type TransactionSummary struct {
+	ID          int       `json:"id"`
+	Type        string    `json:"type"`
+	Status      string    `json:"status"`
+	Amount      float64   `json:"amount"`
+	Currency    string    `json:"currency"`
+	UserID      int       `json:"user_id"`
+	GatewayID   int       `json:"gateway_id"`
+	CountryID   int       `json:"country_id"`
+	ReferenceID string    `json:"reference_id,omitempty"`
+	DeclineCode string    `json:"decline_code,omitempty"`
+	Livemode    bool      `json:"livemode"`
+	Tags        []string  `json:"tags"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+// CaptureRequest captures an authorized deposit, in full when no amount is given
+type CaptureRequest struct {
+	TransactionID int     `json:"transaction_id" validate:"gt=0"`
+	Amount        float64 `json:"amount,omitempty" validate:"omitempty,amount"`
+
+	// Whether the capture is the authorization's last, releasing what remains: true unless set
+	// false to capture the authorization in several parts
+	FinalCapture *bool `json:"final_capture,omitempty"`
+}
+
+// VoidRequest releases the amount held for an authorized deposit
+type VoidRequest struct {
+	TransactionID int `json:"transaction_id" validate:"gt=0"`
+}
+
+// IncrementAuthorizationRequest raises the amount held for an incrementally authorized deposit by
+// Amount
+type IncrementAuthorizationRequest struct {
+	TransactionID int     `json:"transaction_id" validate:"gt=0"`
+	Amount        float64 `json:"amount" validate:"amount"`
+}
+
+// Transfer moves funds between the wallets of two users of the same merchant. It never reaches a
+// gateway: it is only recorded in the wallet ledger. Declined transfers are kept with the decline
+// code and move nothing.
+type Transfer struct {
+	ID          int       `json:"id"`
+	FromUserID  int       `json:"from_user_id"`
+	ToUserID    int       `json:"to_user_id"`
+	MerchantID  int       `json:"merchant_id"`
+	Amount      float64   `json:"amount"`
+	Currency    string    `json:"currency"`
+	Description string    `json:"description,omitempty"`
+	Status      string    `json:"status"` // completed or failed
+	DeclineCode string    `json:"decline_code,omitempty"`
+	RiskFlags   []string  `json:"risk_flags,omitempty"`
+	Livemode    bool      `json:"livemode"` // moves the users' live balances rather than their sandbox ones
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+// TransferRequest moves funds from one user's wallet to another's
+type TransferRequest struct {
+	FromUserID  int     `json:"from_user_id" validate:"gt=0"`
+	ToUserID    int     `json:"to_user_id" validate:"gt=0"`
+	Amount      float64 `json:"amount" validate:"amount"`
+	Currency    string  `json:"currency" validate:"required,currency"`
+	Description string  `json:"description,omitempty" validate:"max=140"`
+}
+
+// TransferStats summarizes the completed transfers a user sent, for limits and fraud checks
+type TransferStats struct {
+	Count       int     // sent since the start of the limit window
+	Amount      float64 // sent since the start of the limit window
+	ToRecipient int     // ever sent to the recipient
+}
+
+// WalletBalance is the funds a user holds in a currency: completed deposits and transfers
+// received, less withdrawals that have not failed and transfers sent
+type WalletBalance struct {
 	UserID   int     `json:"user_id"`
-	Amount   float64 `json:"amount"`
 	Currency string  `json:"currency"`
+	Balance  float64 `json:"balance"`        // spendable, without held deposits
+	Held     float64 `json:"held,omitempty"` // of completed deposits held for review
+	Livemode bool    `json:"livemode"`
+}
+
+// Bank is a bank customers can pay from by open banking payment initiation, listed in the bank
+// directory of the gateway that connects to it
+type Bank struct {
+	ID          string   `json:"id"`
+	Name        string   `json:"name"`
+	CountryCode string   `json:"country_code"`
+	Currencies  []string `json:"currencies"`
+	GatewayID   int      `json:"gateway_id"`
+}
+
+// PaymentMethods are the payment options customers have for a deposit in a country and currency
+type PaymentMethods struct {
+	CountryCode string          `json:"country_code"`
+	Currency    string          `json:"currency"`
+	Options     []PaymentOption `json:"options"`
+
+	// Compliance fields deposits in the country must carry, whichever option is chosen
+	RequiredFields []ComplianceField `json:"required_fields"`
+}
+
+// PaymentOption is a way to pay a deposit through a gateway that can take it now. A deposit pays
+// with an option by naming its gateway as preferred_gateway_id, and its bank as bank_id.
+type PaymentOption struct {
+	Type        string `json:"type"` // card, wallet, bank or crypto
+	GatewayID   int    `json:"gateway_id"`
+	GatewayName string `json:"gateway_name"`
+	BankID      string `json:"bank_id,omitempty"`
+	BankName    string `json:"bank_name,omitempty"`
+}
+
+// PaymentConsent is the customer's consent to an open banking deposit. The customer authorises it
+// at their bank, which redirects them back with an authorisation code the payment is executed with.
+type PaymentConsent struct {
+	ID               int       `json:"id"`
+	TransactionID    int       `json:"transaction_id"`
+	GatewayID        int       `json:"gateway_id"`
+	BankID           string    `json:"bank_id"`
+	ExternalID       string    `json:"external_id"`                 // the consent's ID at the gateway
+	Status           string    `json:"status"`                      // awaiting_authorisation, consumed, rejected or expired
+	StateHash        string    `json:"-"`                           // SHA-256 of the state the bank's redirect must carry
+	AuthorisationURL string    `json:"authorisation_url,omitempty"` // where the customer authorises the payment
+	ExpiresAt        time.Time `json:"expires_at"`
+	CreatedAt        time.Time `json:"created_at"`
+	UpdatedAt        time.Time `json:"updated_at"`
+}
+
+// BankAccountConsent is a user's consent to an open banking gateway reading their bank accounts,
+// authorised at their bank to link the accounts withdrawals are paid out to. The tokens it grants
+// are stored encrypted and never returned.
+type BankAccountConsent struct {
+	ID               int       `json:"id"`
+	UserID           int       `json:"user_id"`
+	GatewayID        int       `json:"gateway_id"`
+	Status           string    `json:"status"`                      // awaiting_authorisation, authorised, rejected or expired
+	StateHash        string    `json:"-"`                           // SHA-256 of the state the bank's redirect must carry
+	AuthorisationURL string    `json:"authorisation_url,omitempty"` // where the user authorises the gateway to read their accounts
+	AccessToken      string    `json:"-"`
+	RefreshToken     string    `json:"-"`
+	TokenExpiresAt   time.Time `json:"token_expires_at,omitempty"`
+	Livemode         bool      `json:"livemode"`
+	ExpiresAt        time.Time `json:"expires_at"` // the user must authorise the consent by then
+	CreatedAt        time.Time `json:"created_at"`
+	UpdatedAt        time.Time `json:"updated_at"`
+}
+
+// BankAccount is a bank account a user linked through an open banking gateway. Withdrawals name it
+// as bank_account_id to be paid out to it. Account identifiers are stored encrypted and only their
+// last four digits are returned.
+type BankAccount struct {
+	ID            int       `json:"id"`
+	UserID        int       `json:"user_id"`
+	GatewayID     int       `json:"gateway_id"`
+	ConsentID     int       `json:"consent_id"`
+	ExternalID    string    `json:"-"` // the account's ID at the gateway
+	BankName      string    `json:"bank_name,omitempty"`
+	HolderName    string    `json:"holder_name"` // the name the bank holds the account under
+	Currency      string    `json:"currency"`
+	SortCode      string    `json:"-"`
+	AccountNumber string    `json:"-"`
+	IBAN          string    `json:"-"`
+	Last4         string    `json:"last4"`
+	Status        string    `json:"status"` // active or revoked
+	CreatedAt     time.Time `json:"created_at"`
+	UpdatedAt     time.Time `json:"updated_at"`
+}
+
+// BankAccountLinkRequest starts linking a user's bank accounts through an open banking gateway
+type BankAccountLinkRequest struct {
+	UserID    int `json:"user_id" validate:"gt=0"`
+	GatewayID int `json:"gateway_id,omitempty" validate:"gte=0"` // the only bank payout gateway when omitted
+}
+
+// Mandate is a SEPA Direct Debit mandate: a user's authorisation for deposits to be debited from
+// their bank account, quoting the mandate's reference. Withdrawals naming it are paid out to the
+// same account by SEPA credit transfer. The IBAN is stored encrypted and only its last four
+// characters are returned.
+type Mandate struct {
+	ID               int       `json:"id"`
+	UserID           int       `json:"user_id"`
+	GatewayID        int       `json:"gateway_id"`
+	Reference        string    `json:"reference"` // the unique mandate reference (UMR) quoted on every debit
+	DebtorName       string    `json:"debtor_name"`
+	IBAN             string    `json:"-"`
+	BIC              string    `json:"bic,omitempty"`
+	Last4            string    `json:"last4"`
+	SequenceType     string    `json:"sequence_type"` // FRST until a first debit was submitted, RCUR after
+	Status           string    `json:"status"`        // active or revoked
+	RevocationReason string    `json:"revocation_reason,omitempty"`
+	SignedAt         time.Time `json:"signed_at"`
+	CreatedAt        time.Time `json:"created_at"`
+	UpdatedAt        time.Time `json:"updated_at"`
+}
+
+// MandateRequest records a SEPA Direct Debit mandate the user signed
+type MandateRequest struct {
+	UserID     int       `json:"user_id" validate:"gt=0"`
+	GatewayID  int       `json:"gateway_id,omitempty" validate:"gte=0"` // the only direct debit gateway when omitted
+	DebtorName string    `json:"debtor_name" validate:"required,max=70"`
+	IBAN       string    `json:"iban" validate:"required,iban"`
+	BIC        string    `json:"bic,omitempty" validate:"omitempty,bic"`
+	SignedAt   time.Time `json:"signed_at,omitempty"` // when the user signed the mandate; now when omitted
+}
+
+// AutoReloadRule tops up a user's wallet in a currency by depositing a fixed amount under one of
+// their SEPA mandates whenever its balance drops below a threshold
+type AutoReloadRule struct {
+	ID                  int       `json:"id"`
+	UserID              int       `json:"user_id"`
+	Currency            string    `json:"currency"`
+	Threshold           float64   `json:"threshold"` // reload when the balance drops below it
+	Amount              float64   `json:"amount"`    // deposited by each reload
+	MandateID           int       `json:"mandate_id"`
+	Status              string    `json:"status"` // active, disabled or cancelled
+	DisabledReason      string    `json:"disabled_reason,omitempty"`
+	ConsecutiveFailures int       `json:"consecutive_failures"`
+	LastTransactionID   int       `json:"last_transaction_id,omitempty"` // the last reload's deposit
+	LastReloadStatus    string    `json:"last_reload_status,omitempty"`  // its status when last checked
+	LastReloadAt        time.Time `json:"last_reload_at,omitempty"`
+	WindowStart         time.Time `json:"-"` // first reload of the current consts.AutoReloadLimitWindow
+	WindowReloads       int       `json:"-"` // reloads since WindowStart
+	CreatedAt           time.Time `json:"created_at"`
+	UpdatedAt           time.Time `json:"updated_at"`
+}
+
+// AutoReloadRuleRequest creates an auto-reload rule
+type AutoReloadRuleRequest struct {
+	UserID    int     `json:"user_id" validate:"gt=0"`
+	Currency  string  `json:"currency" validate:"required,currency"`
+	Threshold float64 `json:"threshold" validate:"gte=0"`
+	Amount    float64 `json:"amount" validate:"amount"`
+	MandateID int     `json:"mandate_id" validate:"gt=0"`
+}
+
+// MerchantLivemodeRequest switches a merchant between gateways' sandbox and production environments
+type MerchantLivemodeRequest struct {
+	Livemode bool `json:"livemode"`
+}
+
+// ComplianceField is a field transactions in a country must carry for compliance, such as the
+// payer's CPF in Brazil. Requests send its value in compliance_fields under Name.
+type ComplianceField struct {
+	Name        string   `json:"name" validate:"required,max=50"`
+	Label       string   `json:"label" validate:"required,max=100"`
+	Pattern     string   `json:"pattern,omitempty" validate:"max=255"`                     // regular expression the whole value must match
+	Types       []string `json:"types,omitempty" validate:"dive,oneof=deposit withdrawal"` // transaction types requiring the field; all when empty
+	Description string   `json:"description,omitempty" validate:"max=500"`
+}
+
+// ComplianceFieldsRequest replaces the compliance fields of a country
+type ComplianceFieldsRequest struct {
+	Fields []ComplianceField `json:"fields" validate:"max=20,dive"`
+}
+
+// AMLThreshold is the amount in a currency from which transactions in a country must carry
+// travel rule details and are reported to the regulator
+type AMLThreshold struct {
+	CountryID int       `json:"country_id"`
+	Currency  string    `json:"currency"`
+	Amount    float64   `json:"amount"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// AMLThresholdRequest sets the AML threshold of a country in a currency
+type AMLThresholdRequest struct {
+	Amount float64 `json:"amount" validate:"amount"`
+}
+
+// TravelRuleInfo identifies who sends and who receives the funds of a large transaction
+type TravelRuleInfo struct {
+	OriginatorName     string `json:"originator_name" validate:"max=140"`
+	OriginatorAccount  string `json:"originator_account" validate:"max=64"` // IBAN or account number the funds come from
+	OriginatorAddress  string `json:"originator_address,omitempty" validate:"max=255"`
+	BeneficiaryName    string `json:"beneficiary_name" validate:"max=140"`
+	BeneficiaryAccount string `json:"beneficiary_account" validate:"max=64"`
+}
+
+// AMLCase is opened for a transaction that reached its country's AML threshold. It waits in the
+// review queue until a compliance officer reports it to the regulator or dismisses it.
+type AMLCase struct {
+	ID            int            `json:"id"`
+	TransactionID int            `json:"transaction_id"`
+	ReferenceID   string         `json:"reference_id,omitempty"`
+	UserID        int            `json:"user_id"`
+	CountryID     int            `json:"country_id"`
+	Type          string         `json:"type"`
+	Amount        float64        `json:"amount"`
+	Currency      string         `json:"currency"`
+	CountryCode   string         `json:"country_code,omitempty"` // not stored
+	Threshold     float64        `json:"threshold"`
+	TravelRule    TravelRuleInfo `json:"travel_rule"`
+	Status        string         `json:"status"`         // open, in_review, reported or dismissed
+	Note          string         `json:"note,omitempty"` // the reviewer's note
+	CreatedAt     time.Time      `json:"created_at"`
+	UpdatedAt     time.Time      `json:"updated_at"`
+}
+
+// AMLCaseUpdateRequest moves an AML case through review
+type AMLCaseUpdateRequest struct {
+	Status string `json:"status" validate:"required,oneof=in_review reported dismissed"`
+	Note   string `json:"note,omitempty" validate:"max=1000"`
+}
+
+// ScreeningMatch is a sanctions list entry a screened name matched
+type ScreeningMatch struct {
+	List    string  `json:"list"`     // e.g. "OFAC SDN"
+	EntryID string  `json:"entry_id"` // the entry's identifier on its list
+	Name    string  `json:"name"`
+	Score   float64 `json:"score"` // 0 to 1
+}
+
+// ScreeningCase holds a user or withdrawal whose name matched a sanctions list until a compliance
+// officer clears it as a false positive or confirms the match
+type ScreeningCase struct {
+	ID        int              `json:"id"`
+	UserID    int              `json:"user_id"`
+	Trigger   string           `json:"trigger"` // user_created or withdrawal
+	Name      string           `json:"name"`    // the screened name
+	Amount    float64          `json:"amount,omitempty"`
+	Currency  string           `json:"currency,omitempty"`
+	Matches   []ScreeningMatch `json:"matches"`
+	Status    string           `json:"status"` // open, cleared or confirmed
+	Reviewer  string           `json:"reviewer,omitempty"`
+	Note      string           `json:"note,omitempty"`
+	CreatedAt time.Time        `json:"created_at"`
+	UpdatedAt time.Time        `json:"updated_at"`
+}
+
+// ScreeningCaseDecisionRequest closes an open screening case
+type ScreeningCaseDecisionRequest struct {
+	Status   string `json:"status" validate:"required,oneof=cleared confirmed"`
+	Reviewer string `json:"reviewer" validate:"required,max=100"` // who decided, recorded in the audit trail
+	Note     string `json:"note,omitempty" validate:"max=1000"`
+}
+
+// ScreeningEvent is an entry in a user's screening audit trail
+type ScreeningEvent struct {
+	ID        int       `json:"id"`
+	UserID    int       `json:"user_id"`
+	CaseID    int       `json:"case_id,omitempty"`
+	Action    string    `json:"action"` // screened, matched, cleared or confirmed
+	Actor     string    `json:"actor"`  // "system" or the reviewer
+	Detail    string    `json:"detail,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// BankHoliday is a day banks in a country are closed besides weekends
+type BankHoliday struct {
+	CountryID int    `json:"country_id"`
+	Date      string `json:"date"` // YYYY-MM-DD
+	Name      string `json:"name"`
+}
+
+// BankHolidayRequest adds a bank holiday to a country's calendar
+type BankHolidayRequest struct {
+	Date string `json:"date" validate:"required,len=10"`
+	Name string `json:"name" validate:"required,max=100"`
+}
+
+// PayoutSchedule batches a merchant's withdrawals into scheduled payouts instead of paying each
+// out immediately. Times are local to each withdrawal's country.
+type PayoutSchedule struct {
+	MerchantID int       `json:"merchant_id"`
+	Frequency  string    `json:"frequency"`         // "daily" or "weekly"
+	Weekday    string    `json:"weekday,omitempty"` // day of weekly payouts, e.g. "friday"
+	PayoutTime string    `json:"payout_time"`       // "HH:MM"
+	CutoffTime string    `json:"cutoff_time"`       // withdrawals requested later wait for the next payout
+	UpdatedAt  time.Time `json:"updated_at"`
+}
+
+// PayoutScheduleRequest sets a merchant's payout schedule
+type PayoutScheduleRequest struct {
+	Frequency  string `json:"frequency" validate:"required,oneof=daily weekly"`
+	Weekday    string `json:"weekday,omitempty" validate:"omitempty,oneof=monday tuesday wednesday thursday friday saturday sunday"`
+	PayoutTime string `json:"payout_time" validate:"required,len=5"`
+	CutoffTime string `json:"cutoff_time" validate:"required,len=5"`
+}
+
+// MerchantFee is what a merchant is charged per completed transaction in a currency: a percentage
+// of the amount plus a fixed amount
+type MerchantFee struct {
+	Currency  string    `json:"currency" validate:"required,currency"`
+	Percent   float64   `json:"percent" validate:"gte=0,lte=100"`
+	Fixed     float64   `json:"fixed" validate:"gte=0"`
+	UpdatedAt time.Time `json:"updated_at,omitempty"`
+}
+
+// MerchantFeesRequest replaces a merchant's fees. Currencies without a fee are free.
+type MerchantFeesRequest struct {
+	Fees []MerchantFee `json:"fees" validate:"max=200,dive"`
+}
+
+// ReconciliationFile is a merchant's daily reconciliation file: every transaction of the merchant's
+// users created on a UTC day, with its status, fee and net amount as of when the file was
+// generated. Its ID is stable; regenerating the file replaces its content and bumps its version.
+type ReconciliationFile struct {
+	ID          string    `json:"id"` // "rec_<merchant>_<YYYYMMDD>"
+	MerchantID  int       `json:"merchant_id"`
+	Date        string    `json:"date"` // YYYY-MM-DD
+	Version     int       `json:"version"`
+	Rows        int       `json:"rows"`
+	Size        int       `json:"size"`     // bytes
+	Checksum    string    `json:"checksum"` // SHA-256 of the content, hex
+	StorageKey  string    `json:"-"`
+	DownloadURL string    `json:"download_url,omitempty"`
+	GeneratedAt time.Time `json:"generated_at"`
+}
+
+// ReconciliationRequest generates or regenerates a merchant's reconciliation file for a day
+type ReconciliationRequest struct {
+	Date string `json:"date" validate:"required,len=10"` // YYYY-MM-DD
+}
+
+// ReconciliationQuery selects the reconciliation files listed, by inclusive UTC dates
+type ReconciliationQuery struct {
+	From string // YYYY-MM-DD
+	To   string // YYYY-MM-DD
+}
+
+// RoutingRule is a merchant's routing preference: when the condition "field operator value"
+// holds for a transaction, the gateway is preferred or excluded. Rules are evaluated in order.
+type RoutingRule struct {
+	Position  int    `json:"position"` // evaluation order, from 1; assigned when rules are saved
+	Field     string `json:"field" validate:"required,oneof=type amount currency country"`
+	Operator  string `json:"operator" validate:"required,oneof=eq ne gt gte lt lte in"`
+	Value     string `json:"value" validate:"required,max=255"` // "in" takes a comma-separated list
+	Action    string `json:"action" validate:"required,oneof=prefer exclude"`
+	GatewayID int    `json:"gateway_id" validate:"gt=0"`
+}
+
+// RoutingRulesRequest replaces a merchant's routing rules
+type RoutingRulesRequest struct {
+	Rules []RoutingRule `json:"rules" validate:"max=50,dive"`
+}
+
+// RoutingSimulationRequest describes a transaction to show how it would be routed
+type RoutingSimulationRequest struct {
+	MerchantID         int     `json:"merchant_id,omitempty" validate:"gte=0"` // whose saved rules apply; set from the caller on merchant endpoints
+	Type               string  `json:"type" validate:"required,oneof=deposit withdrawal"`
+	Amount             float64 `json:"amount" validate:"amount"`
+	Currency           string  `json:"currency" validate:"required,currency"`
+	CountryCode        string  `json:"country_code" validate:"required,country"`
+	PreferredGatewayID int     `json:"preferred_gateway_id,omitempty" validate:"gte=0"`
+	ExcludedGatewayIDs []int   `json:"excluded_gateway_ids,omitempty" validate:"dive,gt=0"`
+
+	// Draft rules to try instead of the merchant's saved rules
+	Rules []RoutingRule `json:"rules,omitempty" validate:"max=50,dive"`
+}
+
+// RoutingSimulation reports how a transaction would be routed, without creating it
+type RoutingSimulation struct {
+	CountryID         int                `json:"country_id"`
+	MatchedRules      []int              `json:"matched_rules"` // positions of the rules whose condition held
+	SelectedGatewayID int                `json:"selected_gateway_id,omitempty"`
+	Override          bool               `json:"override"`
+	Reason            string             `json:"reason"`
+	Trace             []RoutingTraceStep `json:"trace"`
+}
+
+// RoutingTraceStep is one check made while selecting a gateway
+type RoutingTraceStep struct {
+	GatewayID int    `json:"gateway_id,omitempty"`
+	Priority  int    `json:"priority,omitempty"`
+	Outcome   string `json:"outcome"` // "info", "skipped" or "selected"
+	Detail    string `json:"detail"`
+}
+
+// TransactionRequest is the request format for transaction endpoints
+type TransactionRequest struct {
+	UserID      int     `json:"user_id" validate:"gt=0"`
+	Amount      float64 `json:"amount" validate:"amount"`
+	Currency    string  `json:"currency" validate:"required,currency"`
+	Beneficiary string  `json:"beneficiary,omitempty" validate:"max=255"`          // payout destination for withdrawals
+	PhoneNumber string  `json:"phone_number,omitempty" validate:"omitempty,phone"` // mobile-money wallet, in international or national form
+	VPA         string  `json:"vpa,omitempty" validate:"omitempty,vpa"`            // UPI virtual payment address a deposit is collected from
+	ReturnURL   string  `json:"return_url,omitempty" validate:"max=2048"`          // where hosted payment pages send the user on success
+	CancelURL   string  `json:"cancel_url,omitempty" validate:"max=2048"`          // where hosted payment pages send the user on cancellation
+
+	// Advanced routing controls
+	PreferredGatewayID int   `json:"preferred_gateway_id,omitempty" validate:"gte=0"`
+	ExcludedGatewayIDs []int `json:"excluded_gateway_ids,omitempty" validate:"dive,gt=0"`
+
+	// Country signals; the user's stored country is used when none are present
+	CountryCode string `json:"country_code,omitempty" validate:"omitempty,country"` // ISO 3166-1 alpha-2, takes precedence over inferred countries
+	CardBIN     string `json:"card_bin,omitempty" validate:"omitempty,bin"`         // first 6-8 digits of the card, used to infer the issuing country
+
+	// SCA exemption to request for a card deposit: "low_value" or "tra"
+	SCAExemption string `json:"sca_exemption,omitempty" validate:"omitempty,oneof=low_value tra"`
+
+	// Bank from the bank directory to pay a deposit from by open banking payment initiation
+	BankID string `json:"bank_id,omitempty" validate:"max=64"`
+
+	// Linked bank account to pay a withdrawal out to by open banking
+	BankAccountID int `json:"bank_account_id,omitempty" validate:"gte=0"`
+
+	// SEPA mandate to debit a deposit under, or whose account to pay a withdrawal out to
+	MandateID int `json:"mandate_id,omitempty" validate:"gte=0"`
+
+	// Values of the compliance fields the transaction country requires, by field name, e.g. {"cpf": "12345678909"}
+	ComplianceFields map[string]string `json:"compliance_fields,omitempty" validate:"max=20"`
+
+	// Originator and beneficiary details, required when the amount reaches the country's AML threshold
+	TravelRule *TravelRuleInfo `json:"travel_rule,omitempty"`
+
+	// Apple Pay or Google Pay token a deposit is paid with. It is forwarded to gateways accepting
+	// the wallet's tokens and decrypted for the others.
+	PaymentToken *WalletToken `json:"payment_token,omitempty"`
+
+	// Set by the authorize endpoint: the deposit's amount is only held, to be captured or voided later
+	ManualCapture bool `json:"-"`
+
+	// Asks for an authorization whose amount can be raised later, e.g. while a hotel stay or car
+	// rental runs longer. Authorizations only.
+	IncrementalAuthorization bool `json:"incremental_authorization,omitempty"`
 }
 
 // TransactionResponse is the response format for transaction endpoints
 type TransactionResponse struct {
 	Status        string `json:"status"`
 	TransactionID int    `json:"transaction_id"`
+	ReferenceID   string `json:"reference_id,omitempty"` // our reference for the transaction, also sent to the gateway
 	Message       string `json:"message,omitempty"`
-	RedirectURL   string `json:"redirect_url,omitempty"`
+
+	// Set by providers: their own reference and the hosted payment page, if any
+	GatewayReference string `json:"gateway_reference,omitempty"`
+	RedirectURL      string `json:"redirect_url,omitempty"`
+	ClientSecret     string `json:"client_secret,omitempty"` // confirms the payment on the merchant's page, for gateways such as Stripe
+	DeclineCode      string `json:"decline_code,omitempty"`
+	RecoveryHint     string `json:"recovery_hint,omitempty"` // try_again, use_other_method, contact_bank or do_not_retry
+
+	// Set when an SCA exemption was requested: granted, rejected (the redirect is a 3DS challenge) or unsupported
+	SCAExemptionOutcome string `json:"sca_exemption_outcome,omitempty"`
+
+	// Set when the transaction retries another that was softly declined
+	RetryOfTransactionID int `json:"retry_of_transaction_id,omitempty"`
+
+	// Set when a withdrawal waits for its merchant's next scheduled payout
+	ScheduledFor *time.Time `json:"scheduled_for,omitempty"`
+
+	// Banking day, YYYY-MM-DD, a withdrawal is expected to reach the beneficiary
+	ExpectedSettlementDate string `json:"expected_settlement_date,omitempty"`
+
+	// Set by PIX gateways: how the customer pays the deposit's charge
+	Pix *PixPayment `json:"pix,omitempty"`
+
+	// Set by UPI gateways: how the customer pays the deposit in their UPI app
+	UPI *UPIPayment `json:"upi,omitempty"`
+
+	// Set when a deposit is authorized: when the gateway releases the amount unless it is captured
+	AuthorizationExpiresAt *time.Time `json:"authorization_expires_at,omitempty"`
+
+	// Set when an authorization is incremented: the amount now held
+	AuthorizedAmount float64 `json:"authorized_amount,omitempty"`
+
+	// Set when an authorization is captured: the amount captured so far, what remains authorized
+	// and whether the capture was the last. Partial captures are transactions of their own,
+	// linked to the authorization they were taken from.
+	CapturedAmount            float64  `json:"captured_amount,omitempty"`
+	RemainingAuthorizedAmount *float64 `json:"remaining_authorized_amount,omitempty"`
+	FinalCapture              bool     `json:"final_capture,omitempty"`
+	CaptureOfTransactionID    int      `json:"capture_of_transaction_id,omitempty"`
+}
+
+// PixPayment is how a customer pays a PIX deposit: by scanning a QR code of the payload or
+// pasting it into their bank's app ("Pix Copia e Cola") before the charge expires
+type PixPayment struct {
+	Payload   string    `json:"payload"` // EMV BR Code, the QR code's content
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// UPIPayment is how a customer pays a UPI deposit before it expires: by approving the collect
+// request sent to their VPA in their UPI app, or by opening the intent link, a upi://pay URL, in
+// it, on mobile directly or by scanning it as a QR code
+type UPIPayment struct {
+	Flow      string    `json:"flow"`                 // collect or intent
+	IntentURL string    `json:"intent_url,omitempty"` // intent flow only
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// VPAValidationRequest asks a UPI gateway whether a virtual payment address exists
+type VPAValidationRequest struct {
+	UserID    int    `json:"user_id" validate:"gt=0"` // whose merchant's environment the gateway is asked in
+	VPA       string `json:"vpa" validate:"required,vpa"`
+	GatewayID int    `json:"gateway_id,omitempty" validate:"gte=0"` // required when several UPI gateways are registered
+}
+
+// VPAValidation reports whether a virtual payment address exists and the name of its holder, for
+// customers to confirm before a collect request is sent to it
+type VPAValidation struct {
+	VPA   string `json:"vpa"`
+	Valid bool   `json:"valid"`
+	Name  string `json:"name,omitempty"`
 }
 
 // CallbackData represents data received in gateway callbacks
 type CallbackData struct {
-	TransactionID int    `json:"transaction_id"`
-	Status        string `json:"status"`
-	Message       string `json:"message,omitempty"`
-	ReferenceID   string `json:"reference_id"`
-	GatewayID     string `json:"gateway_id"`
-	Timestamp     string `json:"timestamp,omitempty"`
+	TransactionID    int    `json:"transaction_id" iso8583:"48"`
+	Status           string `json:"status"`
+	Message          string `json:"message,omitempty" iso8583:"44"`
+	ReasonCode       string `json:"reason_code,omitempty" iso8583:"39"` // provider-specific decline code
+	DeclineCode      string `json:"-"`                                  // normalized from ReasonCode by the provider
+	GatewayReference string `json:"reference_id" iso8583:"37"`          // the provider's reference; gateways send it as reference_id
+	GatewayID        string `json:"gateway_id"`
+	Timestamp        string `json:"timestamp,omitempty"`
+
+	// Crypto reports the payment of a crypto deposit; set by crypto gateways only
+	Crypto *CryptoPayment `json:"crypto,omitempty"`
+}
+
+// CryptoPayment is the on-chain payment of a crypto deposit. The amount is kept as the decimal
+// string the gateway reports, as cryptocurrencies have more digits than a float64 holds.
+type CryptoPayment struct {
+	Amount                string `json:"amount"`   // e.g. "0.00153200"
+	Currency              string `json:"currency"` // e.g. "BTC"
+	Network               string `json:"network"`  // e.g. "bitcoin" or "ethereum"
+	TransactionHash       string `json:"transaction_hash,omitempty"`
+	Confirmations         int    `json:"confirmations"`
+	RequiredConfirmations int    `json:"required_confirmations,omitempty"`
+	Status                string `json:"status"` // detected, paid, underpaid, overpaid or delayed
+}
+
+// DependencyHealth is the result of checking a dependency for the health endpoint
+type DependencyHealth struct {
+	Status    string  `json:"status"` // up, down or timeout
+	LatencyMS float64 `json:"latency_ms"`
 }
 
 // APIResponse is a standard response format for all API endpoints
 type APIResponse struct {
-	StatusCode int         `json:"status_code"`
-	Message    string      `json:"message"`
-	Data       interface{} `json:"data,omitempty"`
+	StatusCode int          `json:"status_code"`
+	Message    string       `json:"message"`
+	Data       interface{}  `json:"data,omitempty"`
+	Errors     []FieldError `json:"errors,omitempty"` // set when request validation fails
+}
+
+// FieldError describes why one field of a request is invalid
+type FieldError struct {
+	Field   string `json:"field"` // json path of the field, e.g. "transactions[1].amount"
+	Rule    string `json:"rule"`
+	Param   string `json:"param,omitempty"`
+	Message string `json:"message"`
+}
+
+// BatchDepositRequest is the request format for the batch deposit endpoint
+type BatchDepositRequest struct {
+	Transactions []TransactionRequest `json:"transactions" validate:"required"` // items are validated individually
+}
+
+// BatchItemResult reports the outcome of a single item within a batch
+type BatchItemResult struct {
+	Index            int     `json:"index"`
+	Row              int     `json:"row,omitempty"` // source line number for file uploads
+	UserID           int     `json:"user_id"`
+	Amount           float64 `json:"amount"`
+	Currency         string  `json:"currency"`
+	Beneficiary      string  `json:"beneficiary,omitempty"`
+	Status           string  `json:"status"`
+	TransactionID    int     `json:"transaction_id,omitempty"`
+	ReferenceID      string  `json:"reference_id,omitempty"`
+	GatewayReference string  `json:"gateway_reference,omitempty"`
+	Message          string  `json:"message,omitempty"`
+	RedirectURL      string  `json:"redirect_url,omitempty"`
+	DeclineCode      string  `json:"decline_code,omitempty"`
+	RecoveryHint     string  `json:"recovery_hint,omitempty"`
+	Error            string  `json:"error,omitempty"`
+}
+
+// Batch groups transactions submitted together so their status can be polled
+type Batch struct {
+	ID          int               `json:"id"`
+	Type        string            `json:"type"`   // "deposit" or "withdrawal"
+	Status      string            `json:"status"` // "processing", "completed", "partially_failed", "failed"
+	Total       int               `json:"total"`
+	Succeeded   int               `json:"succeeded"`
+	Failed      int               `json:"failed"`
+	Items       []BatchItemResult `json:"items"`
+	OperationID string            `json:"operation_id,omitempty"`
+	CreatedAt   time.Time         `json:"created_at"`
+	UpdatedAt   time.Time         `json:"updated_at,omitempty"`
+}
+
+// Operation tracks the progress and result of a long-running asynchronous job
+type Operation struct {
+	ID        string          `json:"id"`
+	Type      string          `json:"type"`     // e.g. "withdrawal_batch", "export", "reconciliation"
+	Status    string          `json:"status"`   // "pending", "running", "succeeded", "failed"
+	Progress  int             `json:"progress"` // percentage complete, 0-100
+	Processed int             `json:"processed"`
+	Total     int             `json:"total"`
+	Result    json.RawMessage `json:"result,omitempty"`
+	Error     string          `json:"error,omitempty"`
+	CreatedAt time.Time       `json:"created_at"`
+	UpdatedAt time.Time       `json:"updated_at,omitempty"`
+	ExpiresAt time.Time       `json:"expires_at"`
+}
+
+// RetentionPolicy controls when transactions leave the hot table and what is kept once archived
+type RetentionPolicy struct {
+	ArchiveAfterDays int  `json:"archive_after_days"` // completed and failed transactions older than this are archived
+	PurgePII         bool `json:"purge_pii"`          // clear beneficiary and redirect URLs in the archive
+	BatchSize        int  `json:"batch_size"`         // maximum transactions moved per database round trip
+}
+
+// ArchivalStats summarizes the size of the hot and archive transaction tables
+type ArchivalStats struct {
+	HotTransactions         int64     `json:"hot_transactions"`
+	SoftDeletedTransactions int64     `json:"soft_deleted_transactions"`
+	ArchivedTransactions    int64     `json:"archived_transactions"`
+	LastArchivedAt          time.Time `json:"last_archived_at,omitempty"`
+}
+
+// ArchivalStatus reports the retention policy, table sizes and the most recent archival run
+type ArchivalStatus struct {
+	Policy  RetentionPolicy `json:"policy"`
+	Stats   ArchivalStats   `json:"stats"`
+	LastRun *Operation      `json:"last_run,omitempty"`
+}
+
+// RealtimeMetrics reports recent transaction outcomes per gateway and country
+type RealtimeMetrics struct {
+	GeneratedAt time.Time        `json:"generated_at"`
+	Gateways    []GatewayMetrics `json:"gateways"`
+}
+
+// GatewayMetrics holds the outcomes of one gateway in one country over rolling windows
+type GatewayMetrics struct {
+	GatewayID    int         `json:"gateway_id"`
+	CountryID    int         `json:"country_id"`
+	LastMinute   WindowStats `json:"1m"`
+	Last5Minutes WindowStats `json:"5m"`
+	LastHour     WindowStats `json:"1h"`
+}
+
+// WindowStats counts transactions that completed or failed within a window
+type WindowStats struct {
+	Total       int     `json:"total"`
+	Completed   int     `json:"completed"`
+	Failed      int     `json:"failed"`
+	SuccessRate float64 `json:"success_rate"` // completed / total, 0 when there were none
+}
+
+// HTTPClientStats reports the requests a provider's HTTP client has sent to one host since startup
+type HTTPClientStats struct {
+	Client           string  `json:"client"`
+	Host             string  `json:"host"`
+	InFlight         int64   `json:"in_flight"`
+	Requests         int64   `json:"requests"` // attempts, including retries
+	Retries          int64   `json:"retries"`
+	Failures         int64   `json:"failures"` // transport errors and 5xx responses
+	Timeouts         int64   `json:"timeouts"`
+	ReusedConns      int64   `json:"reused_conns"`
+	AverageLatencyMS float64 `json:"average_latency_ms"`
+	MaxLatencyMS     float64 `json:"max_latency_ms"`
+}
+
+// HTTPServerStats describes the connections an API server has accepted and how often clients
+// reused them, for capacity planning
+type HTTPServerStats struct {
+	Addr            string   `json:"addr"`
+	Protocols       []string `json:"protocols"` // served, by ALPN name: http/1.1, h2 and h2c
+	OpenConns       int64    `json:"open_conns"`
+	IdleConns       int64    `json:"idle_conns"`
+	ConnsOpened     int64    `json:"conns_opened"`
+	IdleConnsClosed int64    `json:"idle_conns_closed"` // closed on going idle beyond the idle connection limit
+	Requests        int64    `json:"requests"`
+	HTTP2Requests   int64    `json:"http2_requests"`
+	ReusedConns     int64    `json:"reused_conns"` // requests served on a connection that had served one before
+	RequestsPerConn float64  `json:"requests_per_conn"`
+}
+
+// AnomalyAlert reports an interval in which a gateway's failure rate or latency spiked above its baseline
+type AnomalyAlert struct {
+	GatewayID       string    `json:"gateway_id"`
+	Metric          string    `json:"metric"`   // "failure_rate" or "latency"
+	Observed        float64   `json:"observed"` // failure rate as a fraction, or mean latency in milliseconds
+	Baseline        float64   `json:"baseline"`
+	ZScore          float64   `json:"z_score"`
+	Samples         int       `json:"samples"`
+	DetectedAt      time.Time `json:"detected_at"`
+	DowngradedUntil time.Time `json:"downgraded_until,omitempty"` // set when the gateway was downgraded in routing
+}
+
+// GatewayAnomalyStatus describes a gateway's anomaly detection settings and baseline
+type GatewayAnomalyStatus struct {
+	GatewayID           string    `json:"gateway_id"`
+	Threshold           float64   `json:"threshold"`
+	AutoDowngrade       bool      `json:"auto_downgrade"`
+	BaselineIntervals   int       `json:"baseline_intervals"`
+	BaselineFailureRate float64   `json:"baseline_failure_rate"`
+	BaselineLatencyMs   float64   `json:"baseline_latency_ms"`
+	CurrentCalls        int       `json:"current_calls"`
+	DowngradedUntil     time.Time `json:"downgraded_until,omitempty"`
+}
+
+// AnomalyStatus reports anomaly detection per gateway and the most recent alerts
+type AnomalyStatus struct {
+	Gateways []GatewayAnomalyStatus `json:"gateways"`
+	Alerts   []AnomalyAlert         `json:"alerts"` // newest first
+}
+
+// DeclineCount counts a merchant's completed and failed transactions created on one day that
+// share a gateway, country, BIN range and decline code. DeclineCode is empty for transactions
+// that were not declined, and BINRange for transactions without a card.
+type DeclineCount struct {
+	Day         string // YYYY-MM-DD
+	GatewayID   int
+	CountryID   int
+	BINRange    string
+	DeclineCode string
+	Count       int
+}
+
+// DeclineReportQuery selects the period and granularity of a decline report
+type DeclineReportQuery struct {
+	From     string `json:"from"`     // YYYY-MM-DD, inclusive
+	To       string `json:"to"`       // YYYY-MM-DD, inclusive
+	Interval string `json:"interval"` // "day", "week" or "month"
+	Tag      string `json:"tag"`      // only transactions carrying the tag when set
+}
+
+// DeclineReport breaks a merchant's declines down by period, gateway, country and card BIN range
+type DeclineReport struct {
+	From        string          `json:"from"`
+	To          string          `json:"to"`
+	Interval    string          `json:"interval"`
+	Tag         string          `json:"tag,omitempty"`
+	Attempts    int             `json:"attempts"`
+	Declines    int             `json:"declines"`
+	DeclineRate float64         `json:"decline_rate"`
+	Periods     []DeclinePeriod `json:"periods"`
+}
+
+// DeclinePeriod holds the declines of one day, week or month of a decline report
+type DeclinePeriod struct {
+	Start       string             `json:"start"` // first day of the period, YYYY-MM-DD
+	Attempts    int                `json:"attempts"`
+	Declines    int                `json:"declines"`
+	DeclineRate float64            `json:"decline_rate"`
+	Breakdown   []DeclineBreakdown `json:"breakdown"` // most declines first
+}
+
+// DeclineBreakdown counts the attempts and declines of one gateway, country and BIN range in a period
+type DeclineBreakdown struct {
+	GatewayID    int                `json:"gateway_id"`
+	CountryCode  string             `json:"country_code"`
+	BINRange     string             `json:"bin_range,omitempty"` // first six digits of the card BIN
+	Attempts     int                `json:"attempts"`
+	Declines     int                `json:"declines"`
+	DeclineRate  float64            `json:"decline_rate"` // declines / attempts
+	DeclineCodes []DeclineCodeCount `json:"decline_codes"`
+}
+
+// DeclineCodeCount is how many declines had a normalized decline code
+type DeclineCodeCount struct {
+	DeclineCode string `json:"decline_code"`
+	Count       int    `json:"count"`
+}
+
+// ArchivalResult is the result of a completed archival run
+type ArchivalResult struct {
+	Cutoff      time.Time `json:"cutoff"`
+	Archived    int64     `json:"archived"`
+	PIIPurged   bool      `json:"pii_purged"`
+	CompletedAt time.Time `json:"completed_at"`
 }
@@ -0,0 +1,150 @@
+// Package money rounds amounts to the minor units of their currency. Amounts arrive as float64;
+// rounding works on their shortest decimal form, so 0.125 rounds as the decimal 0.125 rather than
+// the nearest binary fraction, and a rounded amount always converts to whole minor units.
+//
+// Two rounding modes are supported, configurable per currency:
+//
+//	half_up    halves round away from zero: 0.125 -> 0.13 (the default)
+//	half_even  halves round to the even digit (banker's rounding): 0.125 -> 0.12, 0.135 -> 0.14
+package money
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"payment-gateway/internal/consts"
+	"strconv"
+	"strings"
+)
+
+var ErrInvalidRoundingMode = errors.New("invalid rounding mode")
+
+// exponents lists the ISO 4217 currencies whose minor unit is not a hundredth
+var exponents = map[string]int{
+	"BIF": 0, "CLP": 0, "DJF": 0, "GNF": 0, "ISK": 0, "JPY": 0, "KMF": 0, "KRW": 0, "PYG": 0,
+	"RWF": 0, "UGX": 0, "UYI": 0, "VND": 0, "VUV": 0, "XAF": 0, "XOF": 0, "XPF": 0,
+	"BHD": 3, "IQD": 3, "JOD": 3, "KWD": 3, "LYD": 3, "OMR": 3, "TND": 3,
+	"CLF": 4, "UYW": 4,
+}
+
+// Exponent returns the number of minor-unit digits of a currency, e.g. 2 for USD and 0 for JPY
+func Exponent(currency string) int {
+	if exponent, ok := exponents[strings.ToUpper(currency)]; ok {
+		return exponent
+	}
+	return 2
+}
+
+// Rounder rounds amounts with a mode per currency
+type Rounder struct {
+	defaultMode string
+	modes       map[string]string
+}
+
+// NewRounder creates a rounder using half_up for every currency
+func NewRounder() *Rounder {
+	return &Rounder{defaultMode: consts.RoundingHalfUp, modes: map[string]string{}}
+}
+
+// ParseRounder creates a rounder from a default mode, half_up when empty, and a comma-separated
+// list of CURRENCY=MODE overrides, e.g. "EUR=half_even,JPY=half_up"
+func ParseRounder(defaultMode, spec string) (*Rounder, error) {
+	r := NewRounder()
+	if defaultMode != "" {
+		if !validMode(defaultMode) {
+			return nil, fmt.Errorf("%w: %q", ErrInvalidRoundingMode, defaultMode)
+		}
+		r.defaultMode = defaultMode
+	}
+
+	for _, entry := range strings.Split(spec, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		currency, mode, ok := strings.Cut(entry, "=")
+		currency = strings.ToUpper(strings.TrimSpace(currency))
+		mode = strings.TrimSpace(mode)
+		if !ok || len(currency) != 3 || !validMode(mode) {
+			return nil, fmt.Errorf("%w: %q, expected CURRENCY=half_up or CURRENCY=half_even", ErrInvalidRoundingMode, entry)
+		}
+		r.modes[currency] = mode
+	}
+	return r, nil
+}
+
+// validMode reports whether a rounding mode is supported
+func validMode(mode string) bool {
+	return mode == consts.RoundingHalfUp || mode == consts.RoundingHalfEven
+}
+
+// Mode returns the rounding mode of a currency
+func (r *Rounder) Mode(currency string) string {
+	if mode, ok := r.modes[strings.ToUpper(currency)]; ok {
+		return mode
+	}
+	return r.defaultMode
+}
+
+// MinorUnits rounds an amount to whole minor units of its currency, e.g. cents
+func (r *Rounder) MinorUnits(amount float64, currency string) int64 {
+	return roundScaled(amount, Exponent(currency), r.Mode(currency))
+}
+
+// Round rounds an amount to the minor unit of its currency
+func (r *Rounder) Round(amount float64, currency string) float64 {
+	return FromMinorUnits(r.MinorUnits(amount, currency), currency)
+}
+
+// MinorUnits converts an amount to whole minor units of its currency, rounding half up. Amounts
+// already rounded with a Rounder convert exactly whatever its mode.
+func MinorUnits(amount float64, currency string) int64 {
+	return roundScaled(amount, Exponent(currency), consts.RoundingHalfUp)
+}
+
+// FromMinorUnits converts whole minor units of a currency to an amount
+func FromMinorUnits(units int64, currency string) float64 {
+	return float64(units) / math.Pow10(Exponent(currency))
+}
+
+// IsRounded reports whether an amount is a whole number of its currency's minor units, so that
+// converting it to minor units and back loses nothing
+func IsRounded(amount float64, currency string) bool {
+	return FromMinorUnits(MinorUnits(amount, currency), currency) == amount
+}
+
+// roundScaled rounds the shortest decimal form of amount to exponent fraction digits, returning
+// the result scaled to an integer
+func roundScaled(amount float64, exponent int, mode string) int64 {
+	digits := strconv.FormatFloat(math.Abs(amount), 'f', -1, 64)
+	whole, fraction, _ := strings.Cut(digits, ".")
+	if len(fraction) < exponent {
+		fraction += strings.Repeat("0", exponent-len(fraction))
+	}
+	kept, rest := fraction[:exponent], fraction[exponent:]
+
+	units, _ := strconv.ParseInt(whole+kept, 10, 64)
+	if roundsUp(units, rest, mode) {
+		units++
+	}
+	if amount < 0 {
+		units = -units
+	}
+	return units
+}
+
+// roundsUp reports whether the discarded digits rest carry units to the next minor unit
+func roundsUp(units int64, rest, mode string) bool {
+	if rest == "" || rest[0] < '5' {
+		return false
+	}
+	if rest[0] > '5' || strings.TrimRight(rest[1:], "0") != "" {
+		return true
+	}
+	// Exactly half
+	if mode == consts.RoundingHalfEven {
+		return units%2 == 1
+	}
+	return true
+}
@@ -0,0 +1,81 @@
+package money
+
+import (
+	"errors"
+	"payment-gateway/internal/consts"
+	"testing"
+)
+
+func TestRound(t *testing.T) {
+	rounder, err := ParseRounder("", "EUR=half_even, kwd=half_even")
+	if err != nil {
+		t.Fatalf("ParseRounder failed: %v", err)
+	}
+
+	tests := []struct {
+		amount   float64
+		currency string
+		want     float64
+		units    int64
+	}{
+		{10.005, "USD", 10.01, 1001}, // 10.005 is just below the decimal in binary; rounding works on the decimal
+		{10.015, "USD", 10.02, 1002},
+		{10.005, "EUR", 10.00, 1000},
+		{10.015, "EUR", 10.02, 1002},
+		{10.0051, "EUR", 10.01, 1001},
+		{1.1, "USD", 1.1, 110},
+		{1500.5, "JPY", 1501, 1501},
+		{2.5, "KRW", 3, 3},
+		{1.2345, "KWD", 1.234, 1234},
+		{1.2355, "KWD", 1.236, 1236},
+		{-0.125, "USD", -0.13, -13},
+	}
+
+	for _, tt := range tests {
+		if got := rounder.Round(tt.amount, tt.currency); got != tt.want {
+			t.Errorf("Round(%v %s) = %v, want %v", tt.amount, tt.currency, got, tt.want)
+		}
+		if units := rounder.MinorUnits(tt.amount, tt.currency); units != tt.units {
+			t.Errorf("MinorUnits(%v %s) = %d, want %d", tt.amount, tt.currency, units, tt.units)
+		}
+	}
+
+	if rounder.Mode("eur") != consts.RoundingHalfEven || rounder.Mode("USD") != consts.RoundingHalfUp {
+		t.Errorf("Unexpected modes: EUR %s, USD %s", rounder.Mode("EUR"), rounder.Mode("USD"))
+	}
+	if _, err := ParseRounder("half_down", ""); !errors.Is(err, ErrInvalidRoundingMode) {
+		t.Errorf("Expected ErrInvalidRoundingMode, got: %v", err)
+	}
+	if _, err := ParseRounder("", "EUR:half_even"); !errors.Is(err, ErrInvalidRoundingMode) {
+		t.Errorf("Expected ErrInvalidRoundingMode, got: %v", err)
+	}
+}
+
+// TestNoRoundingLeakage tests that rounded amounts convert to minor units and back unchanged, and
+// that minor units add up to the sum of the rounded amounts
+func TestNoRoundingLeakage(t *testing.T) {
+	for _, currency := range []string{"USD", "JPY", "KWD"} {
+		for _, mode := range []string{consts.RoundingHalfUp, consts.RoundingHalfEven} {
+			rounder, _ := ParseRounder(mode, "")
+
+			var total float64
+			var totalUnits int64
+			for i := 1; i <= 2000; i++ {
+				amount := rounder.Round(float64(i)*0.0137, currency)
+				if !IsRounded(amount, currency) {
+					t.Fatalf("%s %s: %v is not rounded", currency, mode, amount)
+				}
+				units := MinorUnits(amount, currency)
+				if FromMinorUnits(units, currency) != amount {
+					t.Fatalf("%s %s: %v converted to %d minor units and back changed", currency, mode, amount, units)
+				}
+				total += amount
+				totalUnits += units
+			}
+
+			if MinorUnits(total, currency) != totalUnits {
+				t.Errorf("%s %s: rounded amounts total %v but their minor units %d", currency, mode, total, totalUnits)
+			}
+		}
+	}
+}
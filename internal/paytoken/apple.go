@@ -0,0 +1,311 @@
+package paytoken
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"payment-gateway/internal/consts"
+	"payment-gateway/internal/models"
+	"payment-gateway/internal/utils"
+	"strconv"
+	"time"
+)
+
+// Apple Pay token version, and the extensions identifying Apple's intermediate and leaf signing
+// certificates
+const applePayVersion = "EC_v1"
+
+var (
+	oidApplePayIntermediate = asn1.ObjectIdentifier{1, 2, 840, 113635, 100, 6, 2, 14}
+	oidApplePayLeaf         = asn1.ObjectIdentifier{1, 2, 840, 113635, 100, 6, 29}
+
+	oidSignedData    = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 2}
+	oidMessageDigest = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 4}
+	oidSigningTime   = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 5}
+)
+
+// applePayToken is the paymentData of an Apple Pay payment
+type applePayToken struct {
+	Version   string `json:"version"`
+	Data      []byte `json:"data"`
+	Signature []byte `json:"signature"` // detached CMS signature
+	Header    struct {
+		EphemeralPublicKey []byte `json:"ephemeralPublicKey"`
+		PublicKeyHash      string `json:"publicKeyHash"`
+		TransactionID      string `json:"transactionId"`
+		ApplicationData    string `json:"applicationData"`
+	} `json:"header"`
+}
+
+// applePayPayload is a decrypted Apple Pay token
+type applePayPayload struct {
+	AccountNumber   string `json:"applicationPrimaryAccountNumber"`
+	ExpirationDate  string `json:"applicationExpirationDate"` // YYMMDD
+	PaymentDataType string `json:"paymentDataType"`
+	PaymentData     struct {
+		OnlinePaymentCryptogram string `json:"onlinePaymentCryptogram"`
+		ECIIndicator            string `json:"eciIndicator"`
+	} `json:"paymentData"`
+}
+
+// CMS structures of the token's signature (RFC 5652)
+type contentInfo struct {
+	ContentType asn1.ObjectIdentifier
+	Content     asn1.RawValue `asn1:"tag:0"`
+}
+
+type signedData struct {
+	Version          int
+	DigestAlgorithms []pkix.AlgorithmIdentifier `asn1:"set"`
+	ContentInfo      asn1.RawValue
+	Certificates     asn1.RawValue `asn1:"optional,tag:0"`
+	CRLs             asn1.RawValue `asn1:"optional,tag:1"`
+	SignerInfos      []signerInfo  `asn1:"set"`
+}
+
+type signerInfo struct {
+	Version            int
+	SignerIdentifier   asn1.RawValue
+	DigestAlgorithm    pkix.AlgorithmIdentifier
+	SignedAttributes   asn1.RawValue `asn1:"optional,tag:0"`
+	SignatureAlgorithm pkix.AlgorithmIdentifier
+	Signature          []byte
+	UnsignedAttributes asn1.RawValue `asn1:"optional,tag:1"`
+}
+
+type attribute struct {
+	Type   asn1.ObjectIdentifier
+	Values asn1.RawValue `asn1:"set"`
+}
+
+// decryptApplePay verifies an EC_v1 token's signature and decrypts it with the key whose public
+// key hash its header names
+func (d *Decrypter) decryptApplePay(data []byte, keys []*utils.MerchantKey) (*models.WalletCard, error) {
+	var token applePayToken
+	if err := json.Unmarshal(data, &token); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidToken, err)
+	}
+	if token.Version != applePayVersion {
+		return nil, fmt.Errorf("%w: unsupported Apple Pay version %q", ErrInvalidToken, token.Version)
+	}
+
+	transactionID, err := hex.DecodeString(token.Header.TransactionID)
+	if err != nil {
+		return nil, fmt.Errorf("%w: invalid transactionId", ErrInvalidToken)
+	}
+	applicationData, err := hex.DecodeString(token.Header.ApplicationData)
+	if err != nil {
+		return nil, fmt.Errorf("%w: invalid applicationData", ErrInvalidToken)
+	}
+	signed := bytes.Join([][]byte{token.Header.EphemeralPublicKey, token.Data, transactionID, applicationData}, nil)
+	if err := d.verifyAppleSignature(token.Signature, signed); err != nil {
+		return nil, err
+	}
+
+	var key *utils.MerchantKey
+	for _, candidate := range keys {
+		if hash, err := candidate.PublicKeyHash(); err == nil && hash == token.Header.PublicKeyHash && candidate.MerchantIdentifier != nil {
+			key = candidate
+			break
+		}
+	}
+	if key == nil {
+		return nil, fmt.Errorf("%w: encrypted to unknown merchant key %s", ErrInvalidToken, token.Header.PublicKeyHash)
+	}
+
+	plaintext, err := decryptAppleData(key, token.Header.EphemeralPublicKey, token.Data)
+	if err != nil {
+		return nil, err
+	}
+	var payload applePayPayload
+	if err := json.Unmarshal(plaintext, &payload); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidToken, err)
+	}
+	return applePayCard(payload)
+}
+
+// verifyAppleSignature checks that a detached CMS signature over signed was made by an Apple Pay
+// signing certificate chaining to the configured roots through Apple's intermediate, within
+// consts.WalletTokenMaxAge
+func (d *Decrypter) verifyAppleSignature(signature, signed []byte) error {
+	if d.AppleRoots == nil {
+		return fmt.Errorf("%w: no Apple root certificates are configured", ErrInvalidToken)
+	}
+
+	var info contentInfo
+	if _, err := asn1.Unmarshal(signature, &info); err != nil || !info.ContentType.Equal(oidSignedData) {
+		return fmt.Errorf("%w: signature is not CMS signed data", ErrInvalidToken)
+	}
+	// Raw values keep their explicit tag, so the signed data is its contents
+	var sd signedData
+	if _, err := asn1.Unmarshal(info.Content.Bytes, &sd); err != nil {
+		return fmt.Errorf("%w: invalid signed data: %v", ErrInvalidToken, err)
+	}
+	if len(sd.SignerInfos) != 1 {
+		return fmt.Errorf("%w: expected one signer, got %d", ErrInvalidToken, len(sd.SignerInfos))
+	}
+	certificates, err := x509.ParseCertificates(sd.Certificates.Bytes)
+	if err != nil {
+		return fmt.Errorf("%w: invalid signing certificates: %v", ErrInvalidToken, err)
+	}
+
+	var leaf *x509.Certificate
+	intermediates := x509.NewCertPool()
+	for _, certificate := range certificates {
+		if hasExtension(certificate, oidApplePayLeaf) {
+			leaf = certificate
+		} else {
+			intermediates.AddCert(certificate)
+		}
+	}
+	if leaf == nil {
+		return fmt.Errorf("%w: no Apple Pay signing certificate", ErrInvalidToken)
+	}
+
+	signer := sd.SignerInfos[0]
+	digest, signingTime, err := signedAttributes(signer.SignedAttributes)
+	if err != nil {
+		return err
+	}
+	sum := sha256.Sum256(signed)
+	if !bytes.Equal(digest, sum[:]) {
+		return fmt.Errorf("%w: signature does not match the token", ErrInvalidToken)
+	}
+	now := d.now()
+	if signingTime.IsZero() || now.Sub(signingTime) > consts.WalletTokenMaxAge || signingTime.Sub(now) > consts.WalletTokenMaxAge {
+		return fmt.Errorf("%w: signed at %s, outside %s of now", ErrInvalidToken, signingTime.Format(time.RFC3339), consts.WalletTokenMaxAge)
+	}
+
+	chains, err := leaf.Verify(x509.VerifyOptions{
+		Roots:         d.AppleRoots,
+		Intermediates: intermediates,
+		CurrentTime:   signingTime,
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageAny},
+	})
+	if err != nil {
+		return fmt.Errorf("%w: untrusted signing certificate: %v", ErrInvalidToken, err)
+	}
+	trusted := false
+	for _, chain := range chains {
+		if len(chain) > 2 && hasExtension(chain[1], oidApplePayIntermediate) {
+			trusted = true
+		}
+	}
+	if !trusted {
+		return fmt.Errorf("%w: signing certificate not issued by Apple's intermediate", ErrInvalidToken)
+	}
+
+	// The signature covers the DER SET of the attributes, whose [0] tag replaces the SET tag
+	attributes := append([]byte{0x31}, signer.SignedAttributes.FullBytes[1:]...)
+	if err := leaf.CheckSignature(x509.ECDSAWithSHA256, attributes, signer.Signature); err != nil {
+		return fmt.Errorf("%w: bad signature: %v", ErrInvalidToken, err)
+	}
+	return nil
+}
+
+// signedAttributes returns the message digest and signing time of a signer's signed attributes
+func signedAttributes(raw asn1.RawValue) (digest []byte, signingTime time.Time, err error) {
+	rest := raw.Bytes
+	for len(rest) > 0 {
+		var attr attribute
+		if rest, err = asn1.Unmarshal(rest, &attr); err != nil {
+			return nil, time.Time{}, fmt.Errorf("%w: invalid signed attributes: %v", ErrInvalidToken, err)
+		}
+		switch {
+		case attr.Type.Equal(oidMessageDigest):
+			_, err = asn1.Unmarshal(attr.Values.Bytes, &digest)
+		case attr.Type.Equal(oidSigningTime):
+			_, err = asn1.Unmarshal(attr.Values.Bytes, &signingTime)
+		}
+		if err != nil {
+			return nil, time.Time{}, fmt.Errorf("%w: invalid signed attributes: %v", ErrInvalidToken, err)
+		}
+	}
+	if digest == nil {
+		return nil, time.Time{}, fmt.Errorf("%w: signature has no message digest", ErrInvalidToken)
+	}
+	return digest, signingTime, nil
+}
+
+// decryptAppleData derives the symmetric key from the ephemeral public key and the merchant key,
+// and decrypts the token's data with AES-256-GCM
+func decryptAppleData(key *utils.MerchantKey, ephemeralPublicKey, data []byte) ([]byte, error) {
+	parsed, err := x509.ParsePKIXPublicKey(ephemeralPublicKey)
+	if err != nil {
+		return nil, fmt.Errorf("%w: invalid ephemeral public key: %v", ErrInvalidToken, err)
+	}
+	ephemeral, ok := parsed.(*ecdsa.PublicKey)
+	if !ok {
+		return nil, fmt.Errorf("%w: ephemeral public key is not an EC key", ErrInvalidToken)
+	}
+	shared, err := sharedSecret(key.PrivateKey, ephemeral)
+	if err != nil {
+		return nil, err
+	}
+
+	// NIST SP 800-56A single-step KDF with SHA-256, as Apple specifies
+	kdf := sha256.New()
+	kdf.Write([]byte{0, 0, 0, 1})
+	kdf.Write(shared)
+	kdf.Write([]byte("\x0did-aes256-GCM"))
+	kdf.Write([]byte("Apple"))
+	kdf.Write(key.MerchantIdentifier)
+	symmetricKey := kdf.Sum(nil)
+
+	block, err := aes.NewCipher(symmetricKey)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := cipher.NewGCMWithNonceSize(block, 16)
+	if err != nil {
+		return nil, err
+	}
+	plaintext, err := gcm.Open(nil, make([]byte, 16), data, nil)
+	if err != nil {
+		return nil, fmt.Errorf("%w: decryption failed", ErrInvalidToken)
+	}
+	return plaintext, nil
+}
+
+// applePayCard maps a decrypted Apple Pay token to the card it carries
+func applePayCard(payload applePayPayload) (*models.WalletCard, error) {
+	if payload.PaymentDataType != "3DSecure" {
+		return nil, fmt.Errorf("%w: unsupported Apple Pay payment data type %q", ErrInvalidToken, payload.PaymentDataType)
+	}
+	if len(payload.ExpirationDate) != 6 {
+		return nil, fmt.Errorf("%w: invalid expiration date", ErrInvalidToken)
+	}
+	year, errYear := strconv.Atoi(payload.ExpirationDate[:2])
+	month, errMonth := strconv.Atoi(payload.ExpirationDate[2:4])
+	if errYear != nil || errMonth != nil || payload.AccountNumber == "" || payload.PaymentData.OnlinePaymentCryptogram == "" {
+		return nil, fmt.Errorf("%w: incomplete Apple Pay payment data", ErrInvalidToken)
+	}
+
+	return &models.WalletCard{
+		Wallet:      consts.WalletApplePay,
+		Number:      payload.AccountNumber,
+		ExpiryMonth: month,
+		ExpiryYear:  2000 + year,
+		Cryptogram:  payload.PaymentData.OnlinePaymentCryptogram,
+		ECI:         payload.PaymentData.ECIIndicator,
+		AuthMethod:  authMethodCryptogram,
+	}, nil
+}
+
+// hasExtension reports whether a certificate has an extension
+func hasExtension(certificate *x509.Certificate, id asn1.ObjectIdentifier) bool {
+	for _, extension := range certificate.Extensions {
+		if extension.Id.Equal(id) {
+			return true
+		}
+	}
+	return false
+}
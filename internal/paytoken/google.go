@@ -0,0 +1,293 @@
+package paytoken
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"payment-gateway/internal/consts"
+	"payment-gateway/internal/models"
+	"payment-gateway/internal/utils"
+	"strconv"
+	"time"
+)
+
+// Google Pay token protocol version and the sender its signatures name
+const (
+	googlePayVersion = "ECv2"
+	googlePaySender  = "Google"
+)
+
+// googlePayToken is the tokenizationData.token of a Google Pay payment
+type googlePayToken struct {
+	ProtocolVersion        string `json:"protocolVersion"`
+	Signature              []byte `json:"signature"`
+	IntermediateSigningKey struct {
+		SignedKey  string   `json:"signedKey"`
+		Signatures [][]byte `json:"signatures"`
+	} `json:"intermediateSigningKey"`
+	SignedMessage string `json:"signedMessage"`
+}
+
+// googlePaySigningKey is the signed key of an intermediate signing key, or a root signing key
+type googlePaySigningKey struct {
+	KeyValue        string `json:"keyValue"`      // base64 DER public key
+	KeyExpiration   string `json:"keyExpiration"` // milliseconds since the epoch
+	ProtocolVersion string `json:"protocolVersion,omitempty"`
+}
+
+// googlePaySignedMessage is the encrypted message of a token
+type googlePaySignedMessage struct {
+	EncryptedMessage   []byte `json:"encryptedMessage"`
+	EphemeralPublicKey []byte `json:"ephemeralPublicKey"` // uncompressed point
+	Tag                []byte `json:"tag"`
+}
+
+// googlePayPayload is a decrypted Google Pay message
+type googlePayPayload struct {
+	MessageExpiration    string `json:"messageExpiration"` // milliseconds since the epoch
+	PaymentMethod        string `json:"paymentMethod"`
+	PaymentMethodDetails struct {
+		PAN             string `json:"pan"`
+		ExpirationMonth int    `json:"expirationMonth"`
+		ExpirationYear  int    `json:"expirationYear"`
+		AuthMethod      string `json:"authMethod"`
+		Cryptogram      string `json:"cryptogram"`
+		ECIIndicator    string `json:"eciIndicator"`
+	} `json:"paymentMethodDetails"`
+}
+
+// ParseGoogleRootKeys parses Google's published root signing keys, keeping the unexpired ECv2 keys
+func ParseGoogleRootKeys(data []byte, now time.Time) ([]*ecdsa.PublicKey, error) {
+	var published struct {
+		Keys []googlePaySigningKey `json:"keys"`
+	}
+	if err := json.Unmarshal(data, &published); err != nil {
+		return nil, err
+	}
+
+	var keys []*ecdsa.PublicKey
+	for _, key := range published.Keys {
+		if key.ProtocolVersion != googlePayVersion {
+			continue
+		}
+		if key.KeyExpiration != "" && expired(key.KeyExpiration, now) {
+			continue
+		}
+		public, err := parseGoogleKey(key.KeyValue)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, public)
+	}
+	if len(keys) == 0 {
+		return nil, errors.New("no unexpired ECv2 root signing keys")
+	}
+	return keys, nil
+}
+
+// decryptGooglePay verifies an ECv2 token's intermediate signing key and signature and decrypts
+// it with the first key it was encrypted to
+func (d *Decrypter) decryptGooglePay(data []byte, keys []*utils.MerchantKey) (*models.WalletCard, error) {
+	var token googlePayToken
+	if err := json.Unmarshal(data, &token); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidToken, err)
+	}
+	if token.ProtocolVersion != googlePayVersion {
+		return nil, fmt.Errorf("%w: unsupported Google Pay protocol version %q", ErrInvalidToken, token.ProtocolVersion)
+	}
+
+	signingKey, err := d.verifyIntermediateKey(token)
+	if err != nil {
+		return nil, err
+	}
+	signed := lengthPrefixed(googlePaySender, d.GoogleRecipientID, googlePayVersion, token.SignedMessage)
+	if !verifyECDSA(signingKey, signed, token.Signature) {
+		return nil, fmt.Errorf("%w: bad signature for recipient %s", ErrInvalidToken, d.GoogleRecipientID)
+	}
+
+	var message googlePaySignedMessage
+	if err := json.Unmarshal([]byte(token.SignedMessage), &message); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidToken, err)
+	}
+	ephemeral, err := ecdh.P256().NewPublicKey(message.EphemeralPublicKey)
+	if err != nil {
+		return nil, fmt.Errorf("%w: invalid ephemeral public key: %v", ErrInvalidToken, err)
+	}
+
+	// The token doesn't name its key, so each key is tried until one's MAC matches
+	for _, key := range keys {
+		plaintext, ok := decryptGoogleMessage(key, ephemeral, message)
+		if !ok {
+			continue
+		}
+		var payload googlePayPayload
+		if err := json.Unmarshal(plaintext, &payload); err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidToken, err)
+		}
+		if expired(payload.MessageExpiration, d.now()) {
+			return nil, fmt.Errorf("%w: message expired", ErrInvalidToken)
+		}
+		return googlePayCard(payload)
+	}
+	return nil, fmt.Errorf("%w: not encrypted to any merchant key", ErrInvalidToken)
+}
+
+// verifyIntermediateKey checks that a token's intermediate signing key was signed by one of
+// Google's root keys and hasn't expired, returning it
+func (d *Decrypter) verifyIntermediateKey(token googlePayToken) (*ecdsa.PublicKey, error) {
+	if len(d.GoogleRootKeys) == 0 || d.GoogleRecipientID == "" {
+		return nil, fmt.Errorf("%w: Google Pay root keys and recipient ID are not configured", ErrInvalidToken)
+	}
+
+	signed := lengthPrefixed(googlePaySender, googlePayVersion, token.IntermediateSigningKey.SignedKey)
+	verified := false
+	for _, root := range d.GoogleRootKeys {
+		for _, signature := range token.IntermediateSigningKey.Signatures {
+			if verifyECDSA(root, signed, signature) {
+				verified = true
+			}
+		}
+	}
+	if !verified {
+		return nil, fmt.Errorf("%w: intermediate signing key not signed by Google", ErrInvalidToken)
+	}
+
+	var signedKey googlePaySigningKey
+	if err := json.Unmarshal([]byte(token.IntermediateSigningKey.SignedKey), &signedKey); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidToken, err)
+	}
+	if expired(signedKey.KeyExpiration, d.now()) {
+		return nil, fmt.Errorf("%w: intermediate signing key expired", ErrInvalidToken)
+	}
+	key, err := parseGoogleKey(signedKey.KeyValue)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidToken, err)
+	}
+	return key, nil
+}
+
+// decryptGoogleMessage derives the encryption and MAC keys from the ephemeral public key and a
+// merchant key, and decrypts the message with AES-256-CTR if its MAC matches
+func decryptGoogleMessage(key *utils.MerchantKey, ephemeral *ecdh.PublicKey, message googlePaySignedMessage) ([]byte, bool) {
+	private, err := key.PrivateKey.ECDH()
+	if err != nil {
+		return nil, false
+	}
+	shared, err := private.ECDH(ephemeral)
+	if err != nil {
+		return nil, false
+	}
+
+	derived := hkdfSHA256(append(append([]byte{}, message.EphemeralPublicKey...), shared...), make([]byte, sha256.Size), []byte(googlePaySender), 64)
+	symmetricKey, macKey := derived[:32], derived[32:]
+
+	mac := hmac.New(sha256.New, macKey)
+	mac.Write(message.EncryptedMessage)
+	if !hmac.Equal(mac.Sum(nil), message.Tag) {
+		return nil, false
+	}
+
+	block, err := aes.NewCipher(symmetricKey)
+	if err != nil {
+		return nil, false
+	}
+	plaintext := make([]byte, len(message.EncryptedMessage))
+	cipher.NewCTR(block, make([]byte, aes.BlockSize)).XORKeyStream(plaintext, message.EncryptedMessage)
+	return plaintext, true
+}
+
+// googlePayCard maps a decrypted Google Pay message to the card it carries
+func googlePayCard(payload googlePayPayload) (*models.WalletCard, error) {
+	details := payload.PaymentMethodDetails
+	if payload.PaymentMethod != "CARD" || details.PAN == "" || details.ExpirationMonth < 1 || details.ExpirationMonth > 12 {
+		return nil, fmt.Errorf("%w: incomplete Google Pay payment method", ErrInvalidToken)
+	}
+
+	switch details.AuthMethod {
+	case authMethodCryptogram:
+		if details.Cryptogram == "" {
+			return nil, fmt.Errorf("%w: CRYPTOGRAM_3DS payment method without a cryptogram", ErrInvalidToken)
+		}
+	case authMethodPANOnly:
+	default:
+		return nil, fmt.Errorf("%w: unsupported Google Pay auth method %q", ErrInvalidToken, details.AuthMethod)
+	}
+
+	return &models.WalletCard{
+		Wallet:      consts.WalletGooglePay,
+		Number:      details.PAN,
+		ExpiryMonth: details.ExpirationMonth,
+		ExpiryYear:  details.ExpirationYear,
+		Cryptogram:  details.Cryptogram,
+		ECI:         details.ECIIndicator,
+		AuthMethod:  details.AuthMethod,
+	}, nil
+}
+
+// lengthPrefixed concatenates values, each preceded by its length as 4 little-endian bytes, as
+// Google Pay signatures cover them
+func lengthPrefixed(values ...string) []byte {
+	var out []byte
+	for _, value := range values {
+		out = binary.LittleEndian.AppendUint32(out, uint32(len(value)))
+		out = append(out, value...)
+	}
+	return out
+}
+
+// hkdfSHA256 derives length bytes from a secret with HKDF-SHA256 (RFC 5869)
+func hkdfSHA256(secret, salt, info []byte, length int) []byte {
+	extract := hmac.New(sha256.New, salt)
+	extract.Write(secret)
+	prk := extract.Sum(nil)
+
+	var out, block []byte
+	for counter := byte(1); len(out) < length; counter++ {
+		expand := hmac.New(sha256.New, prk)
+		expand.Write(block)
+		expand.Write(info)
+		expand.Write([]byte{counter})
+		block = expand.Sum(nil)
+		out = append(out, block...)
+	}
+	return out[:length]
+}
+
+// verifyECDSA checks an ASN.1 ECDSA signature over the SHA-256 of data
+func verifyECDSA(key *ecdsa.PublicKey, data, signature []byte) bool {
+	digest := sha256.Sum256(data)
+	return ecdsa.VerifyASN1(key, digest[:], signature)
+}
+
+// parseGoogleKey parses a base64 DER EC public key
+func parseGoogleKey(value string) (*ecdsa.PublicKey, error) {
+	der, err := base64.StdEncoding.DecodeString(value)
+	if err != nil {
+		return nil, fmt.Errorf("invalid signing key: %w", err)
+	}
+	parsed, err := x509.ParsePKIXPublicKey(der)
+	if err != nil {
+		return nil, fmt.Errorf("invalid signing key: %w", err)
+	}
+	key, ok := parsed.(*ecdsa.PublicKey)
+	if !ok {
+		return nil, errors.New("signing key is not an EC key")
+	}
+	return key, nil
+}
+
+// expired reports whether a time in milliseconds since the epoch has passed; unparseable times
+// count as expired
+func expired(milliseconds string, now time.Time) bool {
+	ms, err := strconv.ParseInt(milliseconds, 10, 64)
+	return err != nil || now.After(time.UnixMilli(ms))
+}
@@ -0,0 +1,77 @@
+// Package paytoken verifies and decrypts Apple Pay and Google Pay payment tokens, for gateways
+// that take the card inside a token rather than the token itself.
+package paytoken
+
+import (
+	"crypto/ecdsa"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"payment-gateway/internal/consts"
+	"payment-gateway/internal/models"
+	"payment-gateway/internal/utils"
+	"time"
+)
+
+var ErrInvalidToken = errors.New("invalid payment token")
+
+// How the cardholder was authenticated: by the device, which produced a cryptogram, or not at all
+// for cards Google Pay holds without a device token
+const (
+	authMethodCryptogram = "CRYPTOGRAM_3DS"
+	authMethodPANOnly    = "PAN_ONLY"
+)
+
+// Decrypter verifies that payment tokens were signed by their wallet and decrypts them with the
+// merchant keys they were encrypted to
+type Decrypter struct {
+	// AppleRoots are the Apple root certificates Apple Pay signing certificates must chain to,
+	// such as Apple Root CA - G3
+	AppleRoots *x509.CertPool
+
+	// GoogleRootKeys are Google's ECv2 root signing keys, see ParseGoogleRootKeys
+	GoogleRootKeys []*ecdsa.PublicKey
+
+	// GoogleRecipientID is who Google Pay tokens are signed for: "merchant:" followed by the
+	// Google merchant ID
+	GoogleRecipientID string
+
+	// Now returns the current time; time.Now when nil
+	Now func() time.Time
+}
+
+// Decrypt verifies and decrypts a token of a wallet with the first of keys it was encrypted to
+func (d *Decrypter) Decrypt(token models.WalletToken, keys []*utils.MerchantKey) (*models.WalletCard, error) {
+	switch token.Type {
+	case consts.WalletApplePay:
+		return d.decryptApplePay([]byte(token.Data), keys)
+	case consts.WalletGooglePay:
+		return d.decryptGooglePay([]byte(token.Data), keys)
+	}
+	return nil, fmt.Errorf("%w: unsupported wallet %q", ErrInvalidToken, token.Type)
+}
+
+// now returns the current time
+func (d *Decrypter) now() time.Time {
+	if d.Now != nil {
+		return d.Now()
+	}
+	return time.Now()
+}
+
+// sharedSecret returns the ECDH shared secret of a merchant key and a token's ephemeral key
+func sharedSecret(private *ecdsa.PrivateKey, ephemeral *ecdsa.PublicKey) ([]byte, error) {
+	privateECDH, err := private.ECDH()
+	if err != nil {
+		return nil, err
+	}
+	ephemeralECDH, err := ephemeral.ECDH()
+	if err != nil {
+		return nil, fmt.Errorf("%w: invalid ephemeral public key: %v", ErrInvalidToken, err)
+	}
+	shared, err := privateECDH.ECDH(ephemeralECDH)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidToken, err)
+	}
+	return shared, nil
+}
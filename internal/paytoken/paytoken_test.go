@@ -0,0 +1,385 @@
+package paytoken
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"math/big"
+	"payment-gateway/internal/consts"
+	"payment-gateway/internal/models"
+	"payment-gateway/internal/utils"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// newKey generates a P-256 key
+func newKey(t *testing.T) *ecdsa.PrivateKey {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+	return key
+}
+
+// newCertificate issues a certificate for key, signed by parent or self-signed when parent is nil
+func newCertificate(t *testing.T, name string, key *ecdsa.PrivateKey, parent *x509.Certificate, parentKey *ecdsa.PrivateKey, ca bool, extension asn1.ObjectIdentifier) *x509.Certificate {
+	t.Helper()
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(time.Now().UnixNano()),
+		Subject:               pkix.Name{CommonName: name},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(24 * time.Hour),
+		IsCA:                  ca,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+	}
+	if extension != nil {
+		template.ExtraExtensions = []pkix.Extension{{Id: extension, Value: []byte{asn1.TagNull, 0}}}
+	}
+	if parent == nil {
+		parent, parentKey = template, key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, &key.PublicKey, parentKey)
+	if err != nil {
+		t.Fatalf("Failed to create certificate: %v", err)
+	}
+	certificate, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatalf("Failed to parse certificate: %v", err)
+	}
+	return certificate
+}
+
+// applePay signs and encrypts Apple Pay tokens like Apple does
+type applePay struct {
+	root, intermediate, leaf *x509.Certificate
+	leafKey                  *ecdsa.PrivateKey
+}
+
+func newApplePay(t *testing.T) *applePay {
+	rootKey, intermediateKey, leafKey := newKey(t), newKey(t), newKey(t)
+	root := newCertificate(t, "Apple Root CA - G3", rootKey, nil, nil, true, nil)
+	intermediate := newCertificate(t, "Apple Application Integration CA - G3", intermediateKey, root, rootKey, true, oidApplePayIntermediate)
+	leaf := newCertificate(t, "ecc-smp-broker-sign_UC4-PROD", leafKey, intermediate, intermediateKey, false, oidApplePayLeaf)
+	return &applePay{root: root, intermediate: intermediate, leaf: leaf, leafKey: leafKey}
+}
+
+func (a *applePay) roots() *x509.CertPool {
+	pool := x509.NewCertPool()
+	pool.AddCert(a.root)
+	return pool
+}
+
+// token encrypts payload to a merchant key and signs it at signedAt
+func (a *applePay) token(t *testing.T, merchant *utils.MerchantKey, payload string, signedAt time.Time) string {
+	t.Helper()
+	ephemeral := newKey(t)
+	ephemeralDER, _ := x509.MarshalPKIXPublicKey(&ephemeral.PublicKey)
+	shared, err := sharedSecret(ephemeral, &merchant.PrivateKey.PublicKey)
+	if err != nil {
+		t.Fatalf("Failed to derive shared secret: %v", err)
+	}
+
+	kdf := sha256.New()
+	kdf.Write([]byte{0, 0, 0, 1})
+	kdf.Write(shared)
+	kdf.Write([]byte("\x0did-aes256-GCM"))
+	kdf.Write([]byte("Apple"))
+	kdf.Write(merchant.MerchantIdentifier)
+	block, _ := aes.NewCipher(kdf.Sum(nil))
+	gcm, _ := cipher.NewGCMWithNonceSize(block, 16)
+	data := gcm.Seal(nil, make([]byte, 16), []byte(payload), nil)
+
+	transactionID := "c1caf5ae72f0039a82bad92b828363734f85bf2f9cadf193d1bad9ddcb60a795"
+	transactionIDBytes, _ := hex.DecodeString(transactionID)
+	digest := sha256.Sum256(append(append(append([]byte{}, ephemeralDER...), data...), transactionIDBytes...))
+
+	hash, _ := merchant.PublicKeyHash()
+	token := map[string]interface{}{
+		"version":   applePayVersion,
+		"data":      data,
+		"signature": a.sign(t, digest[:], signedAt),
+		"header": map[string]string{
+			"ephemeralPublicKey": base64.StdEncoding.EncodeToString(ephemeralDER),
+			"publicKeyHash":      hash,
+			"transactionId":      transactionID,
+		},
+	}
+	encoded, _ := json.Marshal(token)
+	return string(encoded)
+}
+
+// sign returns a detached CMS signature of a message digest by the leaf certificate
+func (a *applePay) sign(t *testing.T, digest []byte, signedAt time.Time) []byte {
+	t.Helper()
+	attribute := func(id asn1.ObjectIdentifier, value interface{}) []byte {
+		valueDER, err := asn1.Marshal(value)
+		if err != nil {
+			t.Fatalf("Failed to marshal attribute: %v", err)
+		}
+		der, _ := asn1.Marshal(struct {
+			Type   asn1.ObjectIdentifier
+			Values asn1.RawValue
+		}{id, asn1.RawValue{Tag: asn1.TagSet, IsCompound: true, Bytes: valueDER}})
+		return der
+	}
+	var attributes []byte
+	attributes = append(attributes, attribute(oidSigningTime, signedAt.UTC())...)
+	attributes = append(attributes, attribute(oidMessageDigest, digest)...)
+
+	signedSet, _ := asn1.Marshal(asn1.RawValue{Tag: asn1.TagSet, IsCompound: true, Bytes: attributes})
+	signedSum := sha256.Sum256(signedSet)
+	signature, err := ecdsa.SignASN1(rand.Reader, a.leafKey, signedSum[:])
+	if err != nil {
+		t.Fatalf("Failed to sign: %v", err)
+	}
+
+	sha256Algorithm := pkix.AlgorithmIdentifier{Algorithm: asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 2, 1}}
+	issuerAndSerial, _ := asn1.Marshal(struct {
+		Issuer asn1.RawValue
+		Serial *big.Int
+	}{asn1.RawValue{FullBytes: a.leaf.RawIssuer}, a.leaf.SerialNumber})
+	content, _ := asn1.Marshal(struct{ Type asn1.ObjectIdentifier }{asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 1}})
+
+	sd, err := asn1.Marshal(signedData{
+		Version:          1,
+		DigestAlgorithms: []pkix.AlgorithmIdentifier{sha256Algorithm},
+		ContentInfo:      asn1.RawValue{FullBytes: content},
+		Certificates:     asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: append(append([]byte{}, a.leaf.Raw...), a.intermediate.Raw...)},
+		SignerInfos: []signerInfo{{
+			Version:            1,
+			SignerIdentifier:   asn1.RawValue{FullBytes: issuerAndSerial},
+			DigestAlgorithm:    sha256Algorithm,
+			SignedAttributes:   asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: attributes},
+			SignatureAlgorithm: pkix.AlgorithmIdentifier{Algorithm: asn1.ObjectIdentifier{1, 2, 840, 10045, 4, 3, 2}},
+			Signature:          signature,
+		}},
+	})
+	if err != nil {
+		t.Fatalf("Failed to marshal signed data: %v", err)
+	}
+	signed, err := asn1.Marshal(contentInfo{ContentType: oidSignedData, Content: asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: sd}})
+	if err != nil {
+		t.Fatalf("Failed to marshal content info: %v", err)
+	}
+	return signed
+}
+
+// newAppleMerchantKey returns a merchant key with a payment processing certificate for a merchant ID
+func newAppleMerchantKey(t *testing.T, merchantID string) *utils.MerchantKey {
+	t.Helper()
+	key := newKey(t)
+	hash := sha256.Sum256([]byte(merchantID))
+	value, _ := asn1.Marshal(hex.EncodeToString(hash[:]))
+	template := &x509.Certificate{
+		SerialNumber:    big.NewInt(1),
+		Subject:         pkix.Name{CommonName: "Apple Pay Payment Processing:" + merchantID},
+		NotBefore:       time.Now().Add(-time.Hour),
+		NotAfter:        time.Now().Add(24 * time.Hour),
+		ExtraExtensions: []pkix.Extension{{Id: asn1.ObjectIdentifier{1, 2, 840, 113635, 100, 6, 32}, Value: value}},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("Failed to create certificate: %v", err)
+	}
+	keyDER, _ := x509.MarshalPKCS8PrivateKey(key)
+
+	merchantKey, err := utils.ParseMerchantKey(
+		string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})),
+		string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER})))
+	if err != nil {
+		t.Fatalf("Failed to parse merchant key: %v", err)
+	}
+	return merchantKey
+}
+
+const applePayload = `{"applicationPrimaryAccountNumber":"4817499999991234","applicationExpirationDate":"291231","currencyCode":"840",` +
+	`"transactionAmount":1000,"paymentDataType":"3DSecure","paymentData":{"onlinePaymentCryptogram":"AgAAAAAABk4DWZ4C28yUQAAAAAA=","eciIndicator":"7"}}`
+
+// TestDecryptApplePay tests that Apple Pay tokens signed through Apple's chain within the allowed
+// age are decrypted with the merchant key they name, and that any other token is rejected
+func TestDecryptApplePay(t *testing.T) {
+	apple := newApplePay(t)
+	merchant := newAppleMerchantKey(t, "merchant.com.example")
+	other := newAppleMerchantKey(t, "merchant.com.example")
+	now := time.Now()
+	decrypter := &Decrypter{AppleRoots: apple.roots()}
+
+	token := models.WalletToken{Type: consts.WalletApplePay, Data: apple.token(t, merchant, applePayload, now)}
+	card, err := decrypter.Decrypt(token, []*utils.MerchantKey{other, merchant})
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	want := models.WalletCard{Wallet: consts.WalletApplePay, Number: "4817499999991234", ExpiryMonth: 12, ExpiryYear: 2029,
+		Cryptogram: "AgAAAAAABk4DWZ4C28yUQAAAAAA=", ECI: "7", AuthMethod: authMethodCryptogram}
+	if *card != want {
+		t.Errorf("Expected %+v, got %+v", want, *card)
+	}
+
+	var tampered map[string]interface{}
+	json.Unmarshal([]byte(token.Data), &tampered)
+	tampered["header"].(map[string]interface{})["transactionId"] = "00"
+	tamperedData, _ := json.Marshal(tampered)
+
+	untrusted := newApplePay(t)
+	invalid := []struct {
+		name      string
+		decrypter *Decrypter
+		data      string
+		keys      []*utils.MerchantKey
+	}{
+		{"unknown merchant key", decrypter, token.Data, []*utils.MerchantKey{other}},
+		{"tampered", decrypter, string(tamperedData), []*utils.MerchantKey{merchant}},
+		{"untrusted root", &Decrypter{AppleRoots: untrusted.roots()}, token.Data, []*utils.MerchantKey{merchant}},
+		{"stale", decrypter, apple.token(t, merchant, applePayload, now.Add(-time.Hour)), []*utils.MerchantKey{merchant}},
+		{"no roots", &Decrypter{}, token.Data, []*utils.MerchantKey{merchant}},
+	}
+	for _, tt := range invalid {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := tt.decrypter.Decrypt(models.WalletToken{Type: consts.WalletApplePay, Data: tt.data}, tt.keys); !errors.Is(err, ErrInvalidToken) {
+				t.Errorf("Expected ErrInvalidToken, got: %v", err)
+			}
+		})
+	}
+}
+
+// googlePay signs and encrypts Google Pay tokens like Google does
+type googlePay struct {
+	rootKey, intermediateKey *ecdsa.PrivateKey
+}
+
+// token encrypts payload to a merchant key and signs it for a recipient
+func (g *googlePay) token(t *testing.T, merchant *utils.MerchantKey, recipientID, payload string) string {
+	t.Helper()
+	intermediateDER, _ := x509.MarshalPKIXPublicKey(&g.intermediateKey.PublicKey)
+	signedKey, _ := json.Marshal(googlePaySigningKey{
+		KeyValue:      base64.StdEncoding.EncodeToString(intermediateDER),
+		KeyExpiration: strconv.FormatInt(time.Now().Add(time.Hour).UnixMilli(), 10),
+	})
+
+	merchantPublic, _ := merchant.PrivateKey.PublicKey.ECDH()
+	ephemeral, _ := ecdh.P256().GenerateKey(rand.Reader)
+	shared, _ := ephemeral.ECDH(merchantPublic)
+	derived := hkdfSHA256(append(ephemeral.PublicKey().Bytes(), shared...), make([]byte, 32), []byte("Google"), 64)
+	block, _ := aes.NewCipher(derived[:32])
+	encrypted := make([]byte, len(payload))
+	cipher.NewCTR(block, make([]byte, aes.BlockSize)).XORKeyStream(encrypted, []byte(payload))
+	mac := hmac.New(sha256.New, derived[32:])
+	mac.Write(encrypted)
+	signedMessage, _ := json.Marshal(googlePaySignedMessage{EncryptedMessage: encrypted, EphemeralPublicKey: ephemeral.PublicKey().Bytes(), Tag: mac.Sum(nil)})
+
+	var token googlePayToken
+	token.ProtocolVersion = googlePayVersion
+	token.IntermediateSigningKey.SignedKey = string(signedKey)
+	token.IntermediateSigningKey.Signatures = [][]byte{g.signature(t, g.rootKey, lengthPrefixed("Google", "ECv2", string(signedKey)))}
+	token.SignedMessage = string(signedMessage)
+	token.Signature = g.signature(t, g.intermediateKey, lengthPrefixed("Google", recipientID, "ECv2", string(signedMessage)))
+	encoded, _ := json.Marshal(token)
+	return string(encoded)
+}
+
+func (g *googlePay) signature(t *testing.T, key *ecdsa.PrivateKey, data []byte) []byte {
+	digest := sha256.Sum256(data)
+	signature, err := ecdsa.SignASN1(rand.Reader, key, digest[:])
+	if err != nil {
+		t.Fatalf("Failed to sign: %v", err)
+	}
+	return signature
+}
+
+// googlePayload returns a decrypted Google Pay message expiring at expiresAt
+func googlePayload(authMethod, cryptogram string, expiresAt time.Time) string {
+	return `{"messageExpiration":"` + strconv.FormatInt(expiresAt.UnixMilli(), 10) + `","messageId":"AH2Ejtc","paymentMethod":"CARD",` +
+		`"paymentMethodDetails":{"pan":"4111111111111111","expirationMonth":11,"expirationYear":2030,"authMethod":"` + authMethod + `",` +
+		`"cryptogram":"` + cryptogram + `","eciIndicator":"05"}}`
+}
+
+// TestDecryptGooglePay tests that Google Pay tokens signed for the merchant through an intermediate
+// key Google signed are decrypted with the merchant key they were encrypted to, and that any other
+// token is rejected
+func TestDecryptGooglePay(t *testing.T) {
+	google := &googlePay{rootKey: newKey(t), intermediateKey: newKey(t)}
+	merchant := &utils.MerchantKey{PrivateKey: newKey(t)}
+	other := &utils.MerchantKey{PrivateKey: newKey(t)}
+	recipient := "merchant:12345678901234567890"
+	decrypter := &Decrypter{GoogleRootKeys: []*ecdsa.PublicKey{&google.rootKey.PublicKey}, GoogleRecipientID: recipient}
+	expiresAt := time.Now().Add(time.Hour)
+
+	data := google.token(t, merchant, recipient, googlePayload(authMethodCryptogram, "AgAAAAAAAIR8CQrXcIhbQAAAAAA=", expiresAt))
+	card, err := decrypter.Decrypt(models.WalletToken{Type: consts.WalletGooglePay, Data: data}, []*utils.MerchantKey{other, merchant})
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	want := models.WalletCard{Wallet: consts.WalletGooglePay, Number: "4111111111111111", ExpiryMonth: 11, ExpiryYear: 2030,
+		Cryptogram: "AgAAAAAAAIR8CQrXcIhbQAAAAAA=", ECI: "05", AuthMethod: authMethodCryptogram}
+	if *card != want {
+		t.Errorf("Expected %+v, got %+v", want, *card)
+	}
+
+	panOnly := google.token(t, merchant, recipient, googlePayload(authMethodPANOnly, "", expiresAt))
+	if card, err := decrypter.Decrypt(models.WalletToken{Type: consts.WalletGooglePay, Data: panOnly}, []*utils.MerchantKey{merchant}); err != nil || card.AuthMethod != authMethodPANOnly {
+		t.Errorf("Expected a PAN_ONLY card, got %+v (%v)", card, err)
+	}
+
+	impostor := &googlePay{rootKey: newKey(t), intermediateKey: newKey(t)}
+	invalid := []struct {
+		name string
+		data string
+		keys []*utils.MerchantKey
+	}{
+		{"other merchant key", data, []*utils.MerchantKey{other}},
+		{"other recipient", google.token(t, merchant, "merchant:1", googlePayload(authMethodCryptogram, "AgAA", expiresAt)), []*utils.MerchantKey{merchant}},
+		{"not signed by Google", impostor.token(t, merchant, recipient, googlePayload(authMethodCryptogram, "AgAA", expiresAt)), []*utils.MerchantKey{merchant}},
+		{"expired", google.token(t, merchant, recipient, googlePayload(authMethodCryptogram, "AgAA", time.Now().Add(-time.Minute))), []*utils.MerchantKey{merchant}},
+		{"missing cryptogram", google.token(t, merchant, recipient, googlePayload(authMethodCryptogram, "", expiresAt)), []*utils.MerchantKey{merchant}},
+	}
+	for _, tt := range invalid {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := decrypter.Decrypt(models.WalletToken{Type: consts.WalletGooglePay, Data: tt.data}, tt.keys); !errors.Is(err, ErrInvalidToken) {
+				t.Errorf("Expected ErrInvalidToken, got: %v", err)
+			}
+		})
+	}
+}
+
+// TestParseGoogleRootKeys tests that only unexpired ECv2 keys are kept from Google's published keys
+func TestParseGoogleRootKeys(t *testing.T) {
+	encode := func(key *ecdsa.PrivateKey) string {
+		der, _ := x509.MarshalPKIXPublicKey(&key.PublicKey)
+		return base64.StdEncoding.EncodeToString(der)
+	}
+	current, expiredKey, legacy := newKey(t), newKey(t), newKey(t)
+	now := time.Now()
+	published, _ := json.Marshal(map[string][]googlePaySigningKey{"keys": {
+		{KeyValue: encode(current), ProtocolVersion: "ECv2", KeyExpiration: strconv.FormatInt(now.Add(time.Hour).UnixMilli(), 10)},
+		{KeyValue: encode(expiredKey), ProtocolVersion: "ECv2", KeyExpiration: strconv.FormatInt(now.Add(-time.Hour).UnixMilli(), 10)},
+		{KeyValue: encode(legacy), ProtocolVersion: "ECv1"},
+	}})
+
+	keys, err := ParseGoogleRootKeys(published, now)
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	if len(keys) != 1 || !keys[0].Equal(&current.PublicKey) {
+		t.Errorf("Expected only the current ECv2 key, got %d keys", len(keys))
+	}
+
+	if _, err := ParseGoogleRootKeys([]byte(`{"keys":[]}`), now); err == nil {
+		t.Error("Expected an error without usable keys")
+	}
+}
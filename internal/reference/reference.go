@@ -0,0 +1,114 @@
+// Package reference generates the references transactions are known by to merchants and
+// gateways. A reference is a short upper-case prefix followed by a ULID, e.g.
+// "PG01HV6Z3K6X9M2Q8R4T5W7Y0ABC": 48 bits of millisecond timestamp and 80 random bits in
+// Crockford base32. References sort by creation time, can be generated on any number of
+// instances without coordination and cannot be guessed from one another. They only contain
+// digits and upper-case letters, which every gateway accepts in its merchant reference field.
+package reference
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+)
+
+// MaxPrefixLength is the longest prefix allowed; with the 26 character ULID it keeps references
+// within the 32 characters most gateways allow
+const MaxPrefixLength = 6
+
+// ulidLength is the length of an encoded ULID
+const ulidLength = 26
+
+// crockford is the Crockford base32 alphabet, which leaves out I, L, O and U
+const crockford = "0123456789ABCDEFGHJKMNPQRSTVWXYZ"
+
+var ErrInvalidPrefix = errors.New("reference prefix must be up to 6 upper-case letters or digits")
+
+// Generator generates transaction references
+type Generator struct {
+	prefix  string
+	now     func() time.Time
+	entropy io.Reader
+}
+
+// NewGenerator creates a generator for references starting with prefix
+func NewGenerator(prefix string) (*Generator, error) {
+	if len(prefix) > MaxPrefixLength {
+		return nil, ErrInvalidPrefix
+	}
+	for _, c := range prefix {
+		if (c < 'A' || c > 'Z') && (c < '0' || c > '9') {
+			return nil, ErrInvalidPrefix
+		}
+	}
+
+	return &Generator{prefix: prefix, now: time.Now, entropy: rand.Reader}, nil
+}
+
+// MustGenerator is like NewGenerator but panics on an invalid prefix, for constant prefixes
+func MustGenerator(prefix string) *Generator {
+	g, err := NewGenerator(prefix)
+	if err != nil {
+		panic(err)
+	}
+	return g
+}
+
+// New returns a new reference
+func (g *Generator) New() (string, error) {
+	var id [16]byte
+
+	ms := uint64(g.now().UnixMilli())
+	binary.BigEndian.PutUint64(id[:8], ms<<16)
+	if _, err := io.ReadFull(g.entropy, id[6:]); err != nil {
+		return "", fmt.Errorf("failed to generate reference: %w", err)
+	}
+
+	return g.prefix + encode(id), nil
+}
+
+// Time returns the time a reference from this generator was created, to the millisecond
+func (g *Generator) Time(ref string) (time.Time, error) {
+	if len(ref) != len(g.prefix)+ulidLength || ref[:len(g.prefix)] != g.prefix {
+		return time.Time{}, fmt.Errorf("invalid reference %q", ref)
+	}
+
+	// The first 10 characters hold the 48-bit timestamp, 50 bits with the two leading zero bits
+	var ms uint64
+	for _, c := range ref[len(g.prefix) : len(g.prefix)+10] {
+		v := indexOf(byte(c))
+		if v < 0 {
+			return time.Time{}, fmt.Errorf("invalid reference %q", ref)
+		}
+		ms = ms<<5 | uint64(v)
+	}
+
+	return time.UnixMilli(int64(ms)), nil
+}
+
+// encode writes a 128-bit ID in Crockford base32, most significant bits first
+func encode(id [16]byte) string {
+	hi := binary.BigEndian.Uint64(id[:8])
+	lo := binary.BigEndian.Uint64(id[8:])
+
+	var dst [ulidLength]byte
+	for i := ulidLength - 1; i >= 0; i-- {
+		dst[i] = crockford[lo&31]
+		lo = lo>>5 | hi<<59
+		hi >>= 5
+	}
+	return string(dst[:])
+}
+
+// indexOf returns the value of a Crockford base32 digit, or -1
+func indexOf(c byte) int {
+	for i := 0; i < len(crockford); i++ {
+		if crockford[i] == c {
+			return i
+		}
+	}
+	return -1
+}
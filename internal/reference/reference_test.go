@@ -0,0 +1,67 @@
+package reference
+
+import (
+	"bytes"
+	"errors"
+	"regexp"
+	"testing"
+	"time"
+)
+
+// TestNew tests the format, uniqueness and time ordering of references
+func TestNew(t *testing.T) {
+	g, err := NewGenerator("PG")
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+
+	format := regexp.MustCompile(`^PG[0-9A-HJKMNP-TV-Z]{26}$`)
+	seen := make(map[string]bool)
+	start := time.Unix(1700000000, 0)
+
+	var refs []string
+	for i := 0; i < 1000; i++ {
+		ms := i / 10 // ten references per millisecond
+		g.now = func() time.Time { return start.Add(time.Duration(ms) * time.Millisecond) }
+
+		ref, err := g.New()
+		if err != nil {
+			t.Fatalf("Expected no error, got: %v", err)
+		}
+		if !format.MatchString(ref) {
+			t.Fatalf("Unexpected reference format: %s", ref)
+		}
+		if seen[ref] {
+			t.Fatalf("Duplicate reference: %s", ref)
+		}
+		seen[ref] = true
+		refs = append(refs, ref)
+	}
+
+	// References from later milliseconds sort after earlier ones
+	for i := 10; i < len(refs); i += 10 {
+		if refs[i] <= refs[i-10] {
+			t.Errorf("Expected %s to sort after %s", refs[i], refs[i-10])
+		}
+	}
+
+	created, err := g.Time(refs[500])
+	if err != nil || !created.Equal(start.Add(50*time.Millisecond)) {
+		t.Errorf("Expected creation time %v, got %v, %v", start.Add(50*time.Millisecond), created, err)
+	}
+}
+
+// TestNewGeneratorErrors tests prefix validation and entropy failures
+func TestNewGeneratorErrors(t *testing.T) {
+	for _, prefix := range []string{"pg", "PG-", "TOOLONG"} {
+		if _, err := NewGenerator(prefix); !errors.Is(err, ErrInvalidPrefix) {
+			t.Errorf("Expected ErrInvalidPrefix for %q, got: %v", prefix, err)
+		}
+	}
+
+	g := MustGenerator("")
+	g.entropy = bytes.NewReader(nil)
+	if _, err := g.New(); err == nil {
+		t.Error("Expected an error when no randomness is available")
+	}
+}
@@ -0,0 +1,144 @@
+// Package scheduler runs the service's background jobs, such as expiring stale transactions or
+// dispatching the outbox, each on its own fixed interval. Runs of a job never overlap, a job that
+// fails or panics is logged and run again at its next interval, and stopping the scheduler
+// cancels the runs in progress and waits for them to return.
+package scheduler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"runtime/debug"
+	"sync"
+	"time"
+)
+
+// Func is one run of a job. Its context is cancelled when the scheduler is stopped.
+type Func func(ctx context.Context) error
+
+// job is a registered job
+type job struct {
+	name     string
+	interval time.Duration
+	run      Func
+}
+
+// Scheduler runs registered jobs on their intervals
+type Scheduler struct {
+	mu      sync.Mutex
+	jobs    []*job
+	names   map[string]bool
+	started bool
+	stopped bool
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+// New creates a scheduler without jobs
+func New() *Scheduler {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Scheduler{names: make(map[string]bool), ctx: ctx, cancel: cancel}
+}
+
+// Register adds a job run every interval once the scheduler is started, the first time one
+// interval after it started. A run taking longer than the interval delays the next rather than
+// overlapping it. Jobs registered once the scheduler is started start at once. Register panics
+// when the name is already registered or the interval isn't positive, as both are programming
+// errors.
+func (s *Scheduler) Register(name string, interval time.Duration, run Func) {
+	if interval <= 0 {
+		panic(fmt.Sprintf("scheduler: job %s has interval %s", name, interval))
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.names[name] {
+		panic(fmt.Sprintf("scheduler: job %s registered twice", name))
+	}
+	s.names[name] = true
+
+	j := &job{name: name, interval: interval, run: run}
+	s.jobs = append(s.jobs, j)
+	if s.started && !s.stopped {
+		s.wg.Add(1)
+		go s.loop(j)
+	}
+}
+
+// Start starts running the registered jobs. Starting a scheduler again, or once stopped, does
+// nothing.
+func (s *Scheduler) Start() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.started || s.stopped {
+		return
+	}
+	s.started = true
+
+	for _, j := range s.jobs {
+		s.wg.Add(1)
+		go s.loop(j)
+	}
+	log.Printf("Scheduler started %d jobs", len(s.jobs))
+}
+
+// Stop stops running jobs, cancelling the context of the runs in progress, and waits for them
+// to return until ctx is done, when ctx's error is returned
+func (s *Scheduler) Stop(ctx context.Context) error {
+	s.mu.Lock()
+	s.stopped = true
+	s.mu.Unlock()
+	s.cancel()
+
+	done := make(chan struct{})
+	go func() {
+		s.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("jobs still running: %w", ctx.Err())
+	}
+}
+
+// loop runs a job every interval until the scheduler is stopped
+func (s *Scheduler) loop(j *job) {
+	defer s.wg.Done()
+
+	ticker := time.NewTicker(j.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			s.runOnce(j)
+		case <-s.ctx.Done():
+			return
+		}
+	}
+}
+
+// runOnce runs a job, logging its error or panic
+func (s *Scheduler) runOnce(j *job) {
+	defer func() {
+		if r := recover(); r != nil {
+			log.Printf("Job %s panicked: %v\n%s", j.name, r, debug.Stack())
+		}
+	}()
+
+	if err := j.run(s.ctx); err != nil {
+		// Runs cut short by stopping the scheduler didn't fail
+		if errors.Is(err, context.Canceled) && s.ctx.Err() != nil {
+			return
+		}
+		log.Printf("Job %s failed: %v", j.name, err)
+	}
+}
@@ -0,0 +1,117 @@
+package scheduler
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// TestSchedulerRunsJobs tests that jobs run on their interval and keep running after failing or
+// panicking
+func TestSchedulerRunsJobs(t *testing.T) {
+	s := New()
+
+	var runs, failures, panics atomic.Int32
+	s.Register("count", 5*time.Millisecond, func(ctx context.Context) error {
+		runs.Add(1)
+		return nil
+	})
+	s.Register("fail", 5*time.Millisecond, func(ctx context.Context) error {
+		failures.Add(1)
+		return errors.New("failed")
+	})
+	s.Register("panic", 5*time.Millisecond, func(ctx context.Context) error {
+		panics.Add(1)
+		panic("boom")
+	})
+
+	s.Start()
+	time.Sleep(60 * time.Millisecond)
+
+	// Jobs registered once started start at once
+	var late atomic.Int32
+	s.Register("late", 5*time.Millisecond, func(ctx context.Context) error {
+		late.Add(1)
+		return nil
+	})
+	time.Sleep(30 * time.Millisecond)
+
+	if err := s.Stop(context.Background()); err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	if runs.Load() < 2 || failures.Load() < 2 || panics.Load() < 2 || late.Load() < 1 {
+		t.Errorf("Expected every job to run repeatedly, got %d runs, %d failures, %d panics and %d late runs", runs.Load(), failures.Load(), panics.Load(), late.Load())
+	}
+
+	// Nothing runs once stopped
+	stoppedAt := runs.Load()
+	time.Sleep(20 * time.Millisecond)
+	if runs.Load() != stoppedAt {
+		t.Errorf("Expected no runs after stopping, got %d more", runs.Load()-stoppedAt)
+	}
+}
+
+// TestSchedulerStopWaitsForRuns tests that stopping cancels runs in progress and waits for them,
+// giving up when its context is done first
+func TestSchedulerStopWaitsForRuns(t *testing.T) {
+	s := New()
+
+	started := make(chan struct{})
+	var finished atomic.Bool
+	s.Register("slow", time.Millisecond, func(ctx context.Context) error {
+		select {
+		case started <- struct{}{}:
+		default:
+		}
+		<-ctx.Done()
+		time.Sleep(10 * time.Millisecond)
+		finished.Store(true)
+		return ctx.Err()
+	})
+	s.Start()
+	<-started
+
+	if err := s.Stop(context.Background()); err != nil || !finished.Load() {
+		t.Errorf("Expected Stop to wait for the run to finish, got finished=%v (%v)", finished.Load(), err)
+	}
+
+	stuck := New()
+	release := make(chan struct{})
+	defer close(release)
+	stuck.Register("stuck", time.Millisecond, func(ctx context.Context) error {
+		<-release
+		return nil
+	})
+	stuck.Start()
+	time.Sleep(10 * time.Millisecond)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := stuck.Stop(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Expected the deadline to be exceeded, got: %v", err)
+	}
+}
+
+// TestSchedulerRegisterPanics tests that duplicate names and non-positive intervals are rejected
+func TestSchedulerRegisterPanics(t *testing.T) {
+	noop := func(ctx context.Context) error { return nil }
+
+	for name, register := range map[string]func(s *Scheduler){
+		"duplicate": func(s *Scheduler) {
+			s.Register("job", time.Second, noop)
+			s.Register("job", time.Second, noop)
+		},
+		"zero interval": func(s *Scheduler) { s.Register("job", 0, noop) },
+	} {
+		t.Run(name, func(t *testing.T) {
+			defer func() {
+				if recover() == nil {
+					t.Error("Expected Register to panic")
+				}
+			}()
+			register(New())
+		})
+	}
+}
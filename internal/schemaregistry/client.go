@@ -0,0 +1,155 @@
+// Package schemaregistry is a client of the Confluent Schema Registry REST API. It registers the
+// schemas messages are published with, checks that a new schema is compatible with the versions
+// already registered for its subject, and fetches schemas by the ID messages carry.
+package schemaregistry
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"payment-gateway/internal/httpclient"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// contentType is the media type of the registry's requests and responses
+const contentType = "application/vnd.schemaregistry.v1+json"
+
+// Error codes the registry reports alongside the HTTP status
+const (
+	ErrorSubjectNotFound = 40401
+	ErrorVersionNotFound = 40402
+	ErrorSchemaNotFound  = 40403
+)
+
+// Config configures a Client
+type Config struct {
+	URL      string // base URL of the registry, e.g. http://schema-registry:8081
+	Username string // basic auth credentials, e.g. a Confluent Cloud API key; empty for none
+	Password string
+	Timeout  time.Duration
+}
+
+// Error is an error response of the registry
+type Error struct {
+	StatusCode int
+	Code       int    `json:"error_code"`
+	Message    string `json:"message"`
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("schema registry returned %d (error %d): %s", e.StatusCode, e.Code, e.Message)
+}
+
+// Client calls a schema registry
+type Client struct {
+	config Config
+	http   *httpclient.Client
+}
+
+// New creates a client of the registry at config.URL
+func New(config Config) *Client {
+	clientConfig := httpclient.DefaultConfig("Schema Registry")
+	if config.Timeout > 0 {
+		clientConfig.Timeout = config.Timeout
+	}
+	config.URL = strings.TrimRight(config.URL, "/")
+
+	return &Client{config: config, http: httpclient.New(clientConfig)}
+}
+
+// URL returns the base URL of the registry
+func (c *Client) URL() string {
+	return c.config.URL
+}
+
+// CheckCompatibility checks a schema against the versions registered for subject under the
+// subject's compatibility level, BACKWARD unless configured otherwise, returning why it is not
+// compatible. A schema for a subject without versions is compatible.
+func (c *Client) CheckCompatibility(ctx context.Context, subject, schema string) (bool, []string, error) {
+	var result struct {
+		IsCompatible bool     `json:"is_compatible"`
+		Messages     []string `json:"messages"`
+	}
+	path := "/compatibility/subjects/" + url.PathEscape(subject) + "/versions/latest?verbose=true"
+	err := c.call(ctx, http.MethodPost, path, map[string]string{"schema": schema}, &result)
+	var apiErr *Error
+	if errors.As(err, &apiErr) && (apiErr.Code == ErrorSubjectNotFound || apiErr.Code == ErrorVersionNotFound) {
+		return true, nil, nil
+	}
+	if err != nil {
+		return false, nil, err
+	}
+	return result.IsCompatible, result.Messages, nil
+}
+
+// Register registers a schema under subject, returning its ID. Registering a schema already
+// registered returns its existing ID.
+func (c *Client) Register(ctx context.Context, subject, schema string) (int, error) {
+	var result struct {
+		ID int `json:"id"`
+	}
+	if err := c.call(ctx, http.MethodPost, "/subjects/"+url.PathEscape(subject)+"/versions", map[string]string{"schema": schema}, &result); err != nil {
+		return 0, err
+	}
+	return result.ID, nil
+}
+
+// Schema returns the schema registered with an ID
+func (c *Client) Schema(ctx context.Context, id int) (string, error) {
+	var result struct {
+		Schema string `json:"schema"`
+	}
+	if err := c.call(ctx, http.MethodGet, "/schemas/ids/"+strconv.Itoa(id), nil, &result); err != nil {
+		return "", err
+	}
+	return result.Schema, nil
+}
+
+// call sends a request to the registry and decodes its response into result
+func (c *Client) call(ctx context.Context, method, path string, body, result interface{}) error {
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(data)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, c.config.URL+path, reader)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", contentType)
+	if body != nil {
+		req.Header.Set("Content-Type", contentType)
+	}
+	if c.config.Username != "" {
+		req.SetBasicAuth(c.config.Username, c.config.Password)
+	}
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return fmt.Errorf("schema registry request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		apiErr := &Error{StatusCode: resp.StatusCode}
+		if err := json.NewDecoder(resp.Body).Decode(apiErr); err != nil {
+			apiErr.Message = http.StatusText(resp.StatusCode)
+		}
+		return apiErr
+	}
+	if err := json.NewDecoder(resp.Body).Decode(result); err != nil {
+		return fmt.Errorf("failed to decode schema registry response: %w", err)
+	}
+	return nil
+}
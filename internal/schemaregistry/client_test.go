@@ -0,0 +1,68 @@
+package schemaregistry
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// TestClient tests that requests carry the registry's media type and credentials, and that
+// responses and errors are decoded
+func TestClient(t *testing.T) {
+	var requests []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests = append(requests, r.Method+" "+r.URL.RequestURI())
+		if user, password, _ := r.BasicAuth(); user != "key" || password != "secret" || r.Header.Get("Accept") != contentType {
+			w.WriteHeader(http.StatusUnauthorized)
+			json.NewEncoder(w).Encode(map[string]interface{}{"error_code": 40101, "message": "Unauthorized"})
+			return
+		}
+
+		switch r.URL.Path {
+		case "/compatibility/subjects/transactions.json-value/versions/latest":
+			json.NewEncoder(w).Encode(map[string]interface{}{"is_compatible": false, "messages": []string{"reader field 'merchant_id' has no default"}})
+		case "/compatibility/subjects/transactions.soap-value/versions/latest":
+			w.WriteHeader(http.StatusNotFound)
+			json.NewEncoder(w).Encode(map[string]interface{}{"error_code": ErrorSubjectNotFound, "message": "Subject 'transactions.soap-value' not found."})
+		case "/subjects/transactions.soap-value/versions":
+			json.NewEncoder(w).Encode(map[string]int{"id": 42})
+		case "/schemas/ids/42":
+			json.NewEncoder(w).Encode(map[string]string{"schema": `"string"`})
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			json.NewEncoder(w).Encode(map[string]interface{}{"error_code": ErrorSchemaNotFound, "message": "Schema not found"})
+		}
+	}))
+	defer server.Close()
+
+	ctx := context.Background()
+	client := New(Config{URL: server.URL + "/", Username: "key", Password: "secret"})
+
+	compatible, reasons, err := client.CheckCompatibility(ctx, "transactions.json-value", `"string"`)
+	if err != nil || compatible || len(reasons) != 1 {
+		t.Errorf("Expected an incompatible schema with its reason, got %t %v: %v", compatible, reasons, err)
+	}
+	if compatible, _, err := client.CheckCompatibility(ctx, "transactions.soap-value", `"string"`); err != nil || !compatible {
+		t.Errorf("Expected a schema for a new subject compatible, got %t: %v", compatible, err)
+	}
+	if id, err := client.Register(ctx, "transactions.soap-value", `"string"`); err != nil || id != 42 {
+		t.Errorf("Expected schema 42 registered, got %d: %v", id, err)
+	}
+	if schema, err := client.Schema(ctx, 42); err != nil || schema != `"string"` {
+		t.Errorf("Expected schema 42 fetched, got %q: %v", schema, err)
+	}
+
+	var apiErr *Error
+	if _, err := client.Schema(ctx, 7); !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusNotFound || apiErr.Code != ErrorSchemaNotFound {
+		t.Errorf("Expected the registry's error, got %v", err)
+	}
+	if _, err := New(Config{URL: server.URL}).Schema(ctx, 42); !errors.As(err, &apiErr) || apiErr.Code != 40101 {
+		t.Errorf("Expected a request without credentials refused, got %v", err)
+	}
+	if requests[0] != "POST /compatibility/subjects/transactions.json-value/versions/latest?verbose=true" {
+		t.Errorf("Expected a verbose compatibility check, got %s", requests[0])
+	}
+}
@@ -0,0 +1,208 @@
+// Package screening checks people's names against sanctions lists. The gateway screens the names
+// of new users and of the beneficiaries of large withdrawals; matches are held for a compliance
+// officer to review.
+//
+// Two screeners are provided:
+//
+//	List  a local dataset loaded from CSV, such as an export of the OFAC SDN or UN consolidated lists
+//	HTTP  an external screening API
+package screening
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"payment-gateway/internal/models"
+	"strings"
+	"time"
+	"unicode"
+)
+
+var ErrInvalidList = errors.New("invalid sanctions list")
+
+// Subject is a person or organisation to screen
+type Subject struct {
+	Name        string
+	CountryCode string // ISO 3166-1 alpha-2, when known
+}
+
+// Screener checks a subject against sanctions lists, returning the entries it matches. A screener
+// that cannot reach its lists returns an error rather than no matches.
+type Screener interface {
+	Screen(ctx context.Context, subject Subject) ([]models.ScreeningMatch, error)
+}
+
+// entry is a sanctioned party on a local list, with the token sets of its name and aliases
+type entry struct {
+	list   string
+	id     string
+	name   string
+	tokens [][]string
+}
+
+// List screens against a local dataset. A subject matches an entry when their names are made up
+// of the same words in any order, or when all words of an entry's name of two or more words
+// appear in the subject's name.
+type List struct {
+	entries []entry
+}
+
+// LoadList reads a list from CSV with the columns list, id, name and aliases, the aliases
+// separated by semicolons. A header row starting with "list" is skipped.
+func LoadList(r io.Reader) (*List, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+
+	list := &List{}
+	for line := 1; ; line++ {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidList, err)
+		}
+		if line == 1 && strings.EqualFold(record[0], "list") {
+			continue
+		}
+		if len(record) < 3 || record[2] == "" {
+			return nil, fmt.Errorf("%w: line %d needs a list, an id and a name", ErrInvalidList, line)
+		}
+
+		e := entry{list: record[0], id: record[1], name: record[2], tokens: [][]string{tokenize(record[2])}}
+		if len(record) > 3 {
+			for _, alias := range strings.Split(record[3], ";") {
+				if tokens := tokenize(alias); len(tokens) > 0 {
+					e.tokens = append(e.tokens, tokens)
+				}
+			}
+		}
+		list.entries = append(list.entries, e)
+	}
+	return list, nil
+}
+
+// Len returns the number of entries on the list
+func (l *List) Len() int {
+	return len(l.entries)
+}
+
+// Screen returns the entries a subject's name matches, scored 1 for the same words and 0.9 for an
+// entry's words contained in the name
+func (l *List) Screen(ctx context.Context, subject Subject) ([]models.ScreeningMatch, error) {
+	name := tokenize(subject.Name)
+	if len(name) == 0 {
+		return nil, nil
+	}
+
+	var matches []models.ScreeningMatch
+	for _, e := range l.entries {
+		best := 0.0
+		for _, tokens := range e.tokens {
+			switch {
+			case sameTokens(tokens, name):
+				best = 1
+			case len(tokens) >= 2 && containsTokens(name, tokens) && best < 0.9:
+				best = 0.9
+			}
+		}
+		if best > 0 {
+			matches = append(matches, models.ScreeningMatch{List: e.list, EntryID: e.id, Name: e.name, Score: best})
+		}
+	}
+	return matches, nil
+}
+
+// tokenize lowercases a name and splits it into words, ignoring punctuation
+func tokenize(name string) []string {
+	return strings.FieldsFunc(strings.ToLower(name), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+// sameTokens reports whether two names have the same words, in any order
+func sameTokens(a, b []string) bool {
+	return len(a) == len(b) && containsTokens(a, b)
+}
+
+// containsTokens reports whether every word of sub appears in name
+func containsTokens(name, sub []string) bool {
+	words := make(map[string]int, len(name))
+	for _, word := range name {
+		words[word]++
+	}
+	for _, word := range sub {
+		if words[word] == 0 {
+			return false
+		}
+		words[word]--
+	}
+	return true
+}
+
+// HTTP screens against an external API. It posts {"name", "country"} and expects
+// {"matches": [{"list", "id", "name", "score"}]} back; matches scoring below MinScore are ignored.
+type HTTP struct {
+	URL      string
+	APIKey   string // sent as a bearer token when set
+	MinScore float64
+
+	client *http.Client
+}
+
+// NewHTTP creates a screener for the API at url
+func NewHTTP(url, apiKey string, minScore float64) *HTTP {
+	return &HTTP{URL: url, APIKey: apiKey, MinScore: minScore, client: &http.Client{Timeout: 10 * time.Second}}
+}
+
+// Screen asks the API for the entries a subject matches
+func (h *HTTP) Screen(ctx context.Context, subject Subject) ([]models.ScreeningMatch, error) {
+	body, err := json.Marshal(map[string]string{"name": subject.Name, "country": subject.CountryCode})
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, h.URL, bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("failed to build screening request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if h.APIKey != "" {
+		req.Header.Set("Authorization", "Bearer "+h.APIKey)
+	}
+
+	resp, err := h.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to reach screening API: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return nil, fmt.Errorf("screening API returned status %d", resp.StatusCode)
+	}
+
+	var result struct {
+		Matches []struct {
+			List  string  `json:"list"`
+			ID    string  `json:"id"`
+			Name  string  `json:"name"`
+			Score float64 `json:"score"`
+		} `json:"matches"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, fmt.Errorf("failed to decode screening response: %w", err)
+	}
+
+	var matches []models.ScreeningMatch
+	for _, match := range result.Matches {
+		if match.Score >= h.MinScore {
+			matches = append(matches, models.ScreeningMatch{List: match.List, EntryID: match.ID, Name: match.Name, Score: match.Score})
+		}
+	}
+	return matches, nil
+}
@@ -0,0 +1,90 @@
+package screening
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+const testList = `list,id,name,aliases
+OFAC SDN,7441,Ivan Petrovich Sidorov,Sidorov Ivan;I. P. Sidorov
+UN,QDi.001,Acme Trading Company,
+`
+
+func TestListScreen(t *testing.T) {
+	list, err := LoadList(strings.NewReader(testList))
+	if err != nil {
+		t.Fatalf("LoadList failed: %v", err)
+	}
+	if list.Len() != 2 {
+		t.Fatalf("Expected 2 entries, got %d", list.Len())
+	}
+
+	tests := []struct {
+		name  string
+		entry string
+		score float64
+	}{
+		{"SIDOROV, Ivan Petrovich", "7441", 1},
+		{"ivan sidorov", "7441", 1},
+		{"Mr Ivan Sidorov Jr", "7441", 0.9},
+		{"ACME trading company", "QDi.001", 1},
+		{"Ivan Petrov", "", 0},
+		{"Sidorov", "", 0},
+		{"", "", 0},
+	}
+
+	for _, tt := range tests {
+		matches, err := list.Screen(context.Background(), Subject{Name: tt.name})
+		if err != nil {
+			t.Fatalf("%q: Screen failed: %v", tt.name, err)
+		}
+		if tt.entry == "" {
+			if len(matches) != 0 {
+				t.Errorf("%q: expected no matches, got %+v", tt.name, matches)
+			}
+			continue
+		}
+		if len(matches) != 1 || matches[0].EntryID != tt.entry || matches[0].Score != tt.score {
+			t.Errorf("%q: expected entry %s scored %.1f, got %+v", tt.name, tt.entry, tt.score, matches)
+		}
+	}
+
+	if _, err := LoadList(strings.NewReader("OFAC SDN,7441\n")); !errors.Is(err, ErrInvalidList) {
+		t.Errorf("Expected ErrInvalidList for an entry without a name, got: %v", err)
+	}
+}
+
+func TestHTTPScreen(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer key-1" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		var request map[string]string
+		json.NewDecoder(r.Body).Decode(&request)
+		if request["name"] != "Jane Doe" || request["country"] != "GB" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		fmt.Fprint(w, `{"matches":[{"list":"OFSI","id":"GB-1","name":"Jane Doe","score":0.95},{"list":"OFSI","id":"GB-2","name":"Jan Doe","score":0.6}]}`)
+	}))
+	defer server.Close()
+
+	matches, err := NewHTTP(server.URL, "key-1", 0.85).Screen(context.Background(), Subject{Name: "Jane Doe", CountryCode: "GB"})
+	if err != nil {
+		t.Fatalf("Screen failed: %v", err)
+	}
+	if len(matches) != 1 || matches[0].EntryID != "GB-1" || matches[0].List != "OFSI" {
+		t.Errorf("Expected the match above the minimum score, got %+v", matches)
+	}
+
+	if _, err := NewHTTP(server.URL, "wrong", 0.85).Screen(context.Background(), Subject{Name: "Jane Doe", CountryCode: "GB"}); err == nil {
+		t.Error("Expected an error when the API refuses the request")
+	}
+}
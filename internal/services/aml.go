@@ -0,0 +1,254 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"payment-gateway/internal/aml"
+	"payment-gateway/internal/consts"
+	"payment-gateway/internal/models"
+	"payment-gateway/internal/validation"
+	"strings"
+	"time"
+)
+
+var (
+	ErrAMLThresholdNotFound = errors.New("AML threshold not found")
+	ErrInvalidAMLThreshold  = errors.New("invalid AML threshold")
+	ErrAMLCaseNotFound      = errors.New("AML case not found")
+	ErrAMLCaseClosed        = errors.New("AML case is already closed")
+	ErrInvalidAMLCaseQueue  = errors.New("invalid AML case status")
+)
+
+// SetAMLReportingEntityID configures the reporting entity's registration with the financial
+// intelligence unit, written into AML reports
+func (s *TransactionService) SetAMLReportingEntityID(id string) {
+	s.amlReportingEntityID = id
+}
+
+// AMLThresholds returns the AML thresholds of a country by currency
+func (s *TransactionService) AMLThresholds(ctx context.Context, countryCode string) ([]models.AMLThreshold, error) {
+	country, err := s.countryByCode(countryCode)
+	if err != nil {
+		return nil, err
+	}
+
+	thresholds, err := s.db.GetAMLThresholds(country.ID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get AML thresholds: %w", err)
+	}
+	if thresholds == nil {
+		thresholds = []models.AMLThreshold{}
+	}
+	return thresholds, nil
+}
+
+// SetAMLThreshold sets the amount in a currency from which transactions in a country are flagged.
+// Transactions in currencies without a threshold are never flagged.
+func (s *TransactionService) SetAMLThreshold(ctx context.Context, countryCode, currency string, req models.AMLThresholdRequest) (*models.AMLThreshold, error) {
+	country, err := s.countryByCode(countryCode)
+	if err != nil {
+		return nil, err
+	}
+	currency = strings.ToUpper(currency)
+	if !validation.IsCurrency(currency) {
+		return nil, fmt.Errorf("%w: %q is not an ISO 4217 currency code", ErrInvalidAMLThreshold, currency)
+	}
+
+	threshold := models.AMLThreshold{CountryID: country.ID, Currency: currency, Amount: req.Amount, UpdatedAt: time.Now()}
+	if err := s.db.SetAMLThreshold(threshold); err != nil {
+		return nil, fmt.Errorf("failed to save AML threshold: %w", err)
+	}
+
+	log.Printf("AML threshold of %s in %s set to %.2f", country.Code, currency, req.Amount)
+	return &threshold, nil
+}
+
+// DeleteAMLThreshold stops flagging transactions in a country and currency
+func (s *TransactionService) DeleteAMLThreshold(ctx context.Context, countryCode, currency string) error {
+	country, err := s.countryByCode(countryCode)
+	if err != nil {
+		return err
+	}
+
+	if err := s.db.DeleteAMLThreshold(country.ID, strings.ToUpper(currency)); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrAMLThresholdNotFound
+		}
+		return fmt.Errorf("failed to delete AML threshold: %w", err)
+	}
+	return nil
+}
+
+// amlThreshold returns the threshold a transaction reaches in its country, or nil when it stays
+// below it or the country has none in its currency
+func (s *TransactionService) amlThreshold(countryID int, req models.TransactionRequest) (*models.AMLThreshold, error) {
+	threshold, err := s.db.GetAMLThreshold(countryID, req.Currency)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to get AML threshold: %w", err)
+	}
+	if req.Amount < threshold.Amount {
+		return nil, nil
+	}
+	return threshold, nil
+}
+
+// validateTravelRule checks that a transaction reaching an AML threshold names its originator and
+// beneficiary, reporting each missing detail as a field error
+func validateTravelRule(threshold *models.AMLThreshold, info *models.TravelRuleInfo) error {
+	if info == nil {
+		info = &models.TravelRuleInfo{}
+	}
+
+	required := []struct {
+		field string
+		value string
+	}{
+		{"originator_name", info.OriginatorName},
+		{"originator_account", info.OriginatorAccount},
+		{"beneficiary_name", info.BeneficiaryName},
+		{"beneficiary_account", info.BeneficiaryAccount},
+	}
+
+	var fieldErrs validation.Errors
+	for _, detail := range required {
+		if detail.value == "" {
+			fieldErrs = append(fieldErrs, models.FieldError{
+				Field:   "travel_rule." + detail.field,
+				Rule:    "required",
+				Param:   fmt.Sprintf("%.2f %s", threshold.Amount, threshold.Currency),
+				Message: "is required for transactions reaching the AML threshold",
+			})
+		}
+	}
+	if len(fieldErrs) > 0 {
+		return fieldErrs
+	}
+	return nil
+}
+
+// openAMLCase places a transaction that reached its country's AML threshold in the review queue.
+// The transaction has been submitted, so a case that cannot be saved is logged rather than failing it.
+func (s *TransactionService) openAMLCase(txType string, user *models.User, country *countryResolution, req models.TransactionRequest, threshold *models.AMLThreshold, response *models.TransactionResponse) {
+	amlCase := models.AMLCase{
+		TransactionID: response.TransactionID,
+		ReferenceID:   response.ReferenceID,
+		UserID:        user.ID,
+		CountryID:     country.countryID,
+		Type:          txType,
+		Amount:        req.Amount,
+		Currency:      req.Currency,
+		Threshold:     threshold.Amount,
+		TravelRule:    *req.TravelRule,
+		Status:        consts.AMLCaseOpen,
+		CreatedAt:     time.Now(),
+	}
+
+	id, err := s.db.CreateAMLCase(amlCase)
+	if err != nil {
+		log.Printf("ERROR: failed to open AML case for transaction %d: %v", response.TransactionID, err)
+		return
+	}
+	log.Printf("Transaction %d reached the AML threshold of %.2f %s; AML case %d queued for review", response.TransactionID, threshold.Amount, threshold.Currency, id)
+}
+
+// ListAMLCases returns the review queue: AML cases with the given status, open by default, oldest first
+func (s *TransactionService) ListAMLCases(ctx context.Context, status string) ([]models.AMLCase, error) {
+	return s.amlCases(status, consts.AMLCaseOpen, consts.MaxAMLCaseListResults)
+}
+
+// GetAMLCase returns an AML case
+func (s *TransactionService) GetAMLCase(ctx context.Context, caseID int) (*models.AMLCase, error) {
+	amlCase, err := s.db.GetAMLCaseByID(caseID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrAMLCaseNotFound
+		}
+		return nil, err
+	}
+	cases := []models.AMLCase{*amlCase}
+	s.setAMLCaseCountries(cases)
+	return &cases[0], nil
+}
+
+// UpdateAMLCase moves an AML case through review. Reported and dismissed cases are closed and
+// cannot change again.
+func (s *TransactionService) UpdateAMLCase(ctx context.Context, caseID int, req models.AMLCaseUpdateRequest) (*models.AMLCase, error) {
+	if err := s.db.UpdateAMLCaseStatus(caseID, req.Status, req.Note, time.Now()); err != nil {
+		if !errors.Is(err, sql.ErrNoRows) {
+			return nil, err
+		}
+		if _, err := s.db.GetAMLCaseByID(caseID); err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				return nil, ErrAMLCaseNotFound
+			}
+			return nil, err
+		}
+		return nil, ErrAMLCaseClosed
+	}
+
+	log.Printf("AML case %d moved to %s", caseID, req.Status)
+	return s.GetAMLCase(ctx, caseID)
+}
+
+// AMLReport renders the AML cases with the given status, in_review by default, as a report file
+// in a format, returning the file, its content type and its name
+func (s *TransactionService) AMLReport(ctx context.Context, format, status string) ([]byte, string, string, error) {
+	cases, err := s.amlCases(status, consts.AMLCaseInReview, consts.MaxAMLReportCases)
+	if err != nil {
+		return nil, "", "", err
+	}
+
+	report := aml.Report{ReportingEntityID: s.amlReportingEntityID, GeneratedAt: time.Now(), Cases: cases}
+	data, contentType, err := aml.Render(format, report)
+	if err != nil {
+		return nil, "", "", err
+	}
+	return data, contentType, aml.FileName(format, report.GeneratedAt), nil
+}
+
+// amlCases lists up to limit AML cases with a status, or with defaultStatus for "", with their
+// country codes
+func (s *TransactionService) amlCases(status, defaultStatus string, limit int) ([]models.AMLCase, error) {
+	if status == "" {
+		status = defaultStatus
+	}
+
+	switch status {
+	case consts.AMLCaseOpen, consts.AMLCaseInReview, consts.AMLCaseReported, consts.AMLCaseDismissed:
+	default:
+		return nil, ErrInvalidAMLCaseQueue
+	}
+
+	cases, err := s.db.ListAMLCases(status, limit)
+	if err != nil {
+		return nil, err
+	}
+	if cases == nil {
+		cases = []models.AMLCase{}
+	}
+	s.setAMLCaseCountries(cases)
+	return cases, nil
+}
+
+// setAMLCaseCountries fills in the country code of each case, which reports identify countries by
+func (s *TransactionService) setAMLCaseCountries(cases []models.AMLCase) {
+	codes := make(map[int]string)
+	for i := range cases {
+		code, ok := codes[cases[i].CountryID]
+		if !ok {
+			if country, err := s.db.GetCountryByID(cases[i].CountryID); err == nil {
+				code = country.Code
+			} else {
+				log.Printf("Failed to get country %d of AML case %d: %v", cases[i].CountryID, cases[i].ID, err)
+			}
+			codes[cases[i].CountryID] = code
+		}
+		cases[i].CountryCode = code
+	}
+}
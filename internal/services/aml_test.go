@@ -0,0 +1,102 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"payment-gateway/db"
+	"payment-gateway/internal/consts"
+	"payment-gateway/internal/gateway"
+	"payment-gateway/internal/models"
+	"payment-gateway/internal/validation"
+	"strings"
+	"testing"
+)
+
+// TestAMLThreshold tests that transactions reaching their country's AML threshold must carry
+// travel rule details and open a case in the review queue, and that smaller ones pass unflagged
+func TestAMLThreshold(t *testing.T) {
+	mockDB := db.NewMockDB()
+	selector := gateway.NewSelector(mockDB)
+	selector.RegisterProvider(gateway.NewMockProvider(1, "Stripe", "application/json", 1.0, 0))
+	service := NewTransactionService(mockDB, selector)
+	ctx := context.Background()
+
+	if _, err := service.SetAMLThreshold(ctx, "US", "usd", models.AMLThresholdRequest{Amount: 3000}); err != nil {
+		t.Fatalf("SetAMLThreshold failed: %v", err)
+	}
+
+	// Below the threshold, no details are needed and no case opens
+	if _, err := service.ProcessDeposit(ctx, models.TransactionRequest{UserID: 1, Amount: 2999.99, Currency: "USD"}); err != nil {
+		t.Fatalf("Expected a deposit below the threshold to pass, got: %v", err)
+	}
+
+	// At the threshold, the travel rule details are required
+	_, err := service.ProcessDeposit(ctx, models.TransactionRequest{UserID: 1, Amount: 3000, Currency: "USD",
+		TravelRule: &models.TravelRuleInfo{OriginatorName: "Jane Doe"}})
+	var fieldErrs validation.Errors
+	if !errors.As(err, &fieldErrs) || len(fieldErrs) != 3 || fieldErrs[0].Field != "travel_rule.originator_account" {
+		t.Fatalf("Expected three missing travel rule details, got: %v", err)
+	}
+
+	travelRule := &models.TravelRuleInfo{OriginatorName: "Jane Doe", OriginatorAccount: "US-1234", BeneficiaryName: "Acme Corp", BeneficiaryAccount: "DE89370400440532013000"}
+	response, err := service.ProcessDeposit(ctx, models.TransactionRequest{UserID: 1, Amount: 5000, Currency: "USD", TravelRule: travelRule})
+	if err != nil {
+		t.Fatalf("ProcessDeposit failed: %v", err)
+	}
+
+	cases, err := service.ListAMLCases(ctx, "")
+	if err != nil || len(cases) != 1 {
+		t.Fatalf("Expected one open AML case, got %+v, %v", cases, err)
+	}
+	if c := cases[0]; c.TransactionID != response.TransactionID || c.Threshold != 3000 || c.CountryCode != "US" ||
+		c.TravelRule.BeneficiaryName != "Acme Corp" || c.Status != consts.AMLCaseOpen {
+		t.Errorf("Unexpected AML case: %+v", c)
+	}
+
+	// Other currencies have no threshold
+	if _, err := service.ProcessDeposit(ctx, models.TransactionRequest{UserID: 1, Amount: 5000, Currency: "EUR"}); err != nil {
+		t.Errorf("Expected a deposit without a threshold in its currency to pass, got: %v", err)
+	}
+}
+
+// TestAMLCaseReview tests moving a case through the review queue and exporting it
+func TestAMLCaseReview(t *testing.T) {
+	mockDB := db.NewMockDB()
+	service := NewTransactionService(mockDB, gateway.NewSelector(mockDB))
+	service.SetAMLReportingEntityID("FIU-42")
+	ctx := context.Background()
+
+	caseID, err := mockDB.CreateAMLCase(models.AMLCase{TransactionID: 7, CountryID: 1, Type: consts.Deposit, Amount: 5000, Currency: "USD",
+		Threshold: 3000, TravelRule: models.TravelRuleInfo{OriginatorName: "Jane Doe"}, Status: consts.AMLCaseOpen})
+	if err != nil {
+		t.Fatalf("CreateAMLCase failed: %v", err)
+	}
+
+	if _, err := service.UpdateAMLCase(ctx, caseID, models.AMLCaseUpdateRequest{Status: consts.AMLCaseInReview}); err != nil {
+		t.Fatalf("UpdateAMLCase failed: %v", err)
+	}
+
+	data, contentType, fileName, err := service.AMLReport(ctx, consts.AMLReportGoAML, "")
+	if err != nil {
+		t.Fatalf("AMLReport failed: %v", err)
+	}
+	if contentType != "application/xml" || !strings.HasSuffix(fileName, ".xml") ||
+		!strings.Contains(string(data), "<rentity_id>FIU-42</rentity_id>") || !strings.Contains(string(data), "<account_name>Jane Doe</account_name>") {
+		t.Errorf("Unexpected report %s (%s):\n%s", fileName, contentType, data)
+	}
+
+	amlCase, err := service.UpdateAMLCase(ctx, caseID, models.AMLCaseUpdateRequest{Status: consts.AMLCaseReported, Note: "Filed"})
+	if err != nil || amlCase.Status != consts.AMLCaseReported || amlCase.Note != "Filed" {
+		t.Fatalf("Expected the case reported, got %+v, %v", amlCase, err)
+	}
+
+	if _, err := service.UpdateAMLCase(ctx, caseID, models.AMLCaseUpdateRequest{Status: consts.AMLCaseDismissed}); !errors.Is(err, ErrAMLCaseClosed) {
+		t.Errorf("Expected ErrAMLCaseClosed, got: %v", err)
+	}
+	if _, err := service.UpdateAMLCase(ctx, 99, models.AMLCaseUpdateRequest{Status: consts.AMLCaseDismissed}); !errors.Is(err, ErrAMLCaseNotFound) {
+		t.Errorf("Expected ErrAMLCaseNotFound, got: %v", err)
+	}
+	if _, err := service.ListAMLCases(ctx, "closed"); !errors.Is(err, ErrInvalidAMLCaseQueue) {
+		t.Errorf("Expected ErrInvalidAMLCaseQueue, got: %v", err)
+	}
+}
@@ -0,0 +1,330 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"payment-gateway/internal/consts"
+	"payment-gateway/internal/models"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Metrics an anomaly can be raised for
+const (
+	AnomalyFailureRate = "failure_rate"
+	AnomalyLatency     = "latency"
+)
+
+// GatewayObserver receives the outcome and latency of every call made to a gateway
+type GatewayObserver interface {
+	ObserveGatewayCall(gatewayID string, latency time.Duration, failed bool)
+}
+
+// GatewayDowngrader moves a gateway to the end of the routing order until the given time
+type GatewayDowngrader interface {
+	DowngradeGateway(gatewayID string, until time.Time)
+}
+
+// AlertSink delivers anomaly alerts to operators
+type AlertSink interface {
+	SendAlert(ctx context.Context, alert models.AnomalyAlert) error
+}
+
+// AnomalySettings controls how sensitive detection is for one gateway
+type AnomalySettings struct {
+	Threshold     float64 // z-score above the baseline that counts as a spike; lower is more sensitive
+	AutoDowngrade bool    // downgrade the gateway in routing when a spike is detected
+}
+
+// gatewayBaseline accumulates the current interval's calls and the gateway's historical baseline
+type gatewayBaseline struct {
+	calls        int
+	failures     int
+	latencySum   float64 // milliseconds
+	latencySumSq float64
+
+	intervals   int // intervals folded into the baseline
+	failureRate float64
+	latencyMean float64
+	latencyVar  float64
+
+	downgradedUntil time.Time
+}
+
+// AnomalyDetector watches per-gateway failure rates and latency and raises an alert when an
+// interval is significantly worse than the gateway's baseline. The baseline is an exponentially
+// weighted average of past normal intervals, so a spike does not become the new normal.
+type AnomalyDetector struct {
+	downgrader GatewayDowngrader
+	sink       AlertSink
+
+	mu       sync.Mutex
+	gateways map[string]*gatewayBaseline
+	settings map[string]AnomalySettings
+	defaults AnomalySettings
+	alerts   []models.AnomalyAlert
+	now      func() time.Time
+}
+
+// NewAnomalyDetector creates a detector that downgrades gateways through downgrader when their
+// settings allow it
+func NewAnomalyDetector(downgrader GatewayDowngrader) *AnomalyDetector {
+	return &AnomalyDetector{
+		downgrader: downgrader,
+		gateways:   make(map[string]*gatewayBaseline),
+		settings:   make(map[string]AnomalySettings),
+		defaults:   AnomalySettings{Threshold: consts.DefaultAnomalyThreshold},
+		now:        time.Now,
+	}
+}
+
+// SetGatewaySettings overrides the detection settings of one gateway
+func (d *AnomalyDetector) SetGatewaySettings(gatewayID string, settings AnomalySettings) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.settings[gatewayID] = settings
+}
+
+// SetAlertSink sends alerts to sink in addition to logging and keeping them
+func (d *AnomalyDetector) SetAlertSink(sink AlertSink) {
+	d.sink = sink
+}
+
+// ObserveGatewayCall counts a gateway call in the current interval
+func (d *AnomalyDetector) ObserveGatewayCall(gatewayID string, latency time.Duration, failed bool) {
+	ms := float64(latency) / float64(time.Millisecond)
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	g := d.gateway(gatewayID)
+	g.calls++
+	if failed {
+		g.failures++
+	}
+	g.latencySum += ms
+	g.latencySumSq += ms * ms
+}
+
+// Evaluate closes the current interval of every gateway with enough calls, raising alerts for
+// spikes and folding normal intervals into the baseline. Gateways with fewer calls keep
+// accumulating into the next interval.
+func (d *AnomalyDetector) Evaluate(ctx context.Context) []models.AnomalyAlert {
+	now := d.now()
+
+	d.mu.Lock()
+	var raised []models.AnomalyAlert
+	for id, g := range d.gateways {
+		if g.calls < consts.AnomalyMinSamples {
+			continue
+		}
+
+		settings := d.settingsFor(id)
+		alerts := g.detect(id, settings.Threshold, now)
+		if len(alerts) == 0 {
+			g.fold()
+		} else if settings.AutoDowngrade && d.downgrader != nil {
+			g.downgradedUntil = now.Add(consts.AnomalyDowngradeDuration)
+			d.downgrader.DowngradeGateway(id, g.downgradedUntil)
+			for i := range alerts {
+				alerts[i].DowngradedUntil = g.downgradedUntil
+			}
+		}
+		g.reset()
+		raised = append(raised, alerts...)
+	}
+
+	sort.Slice(raised, func(i, j int) bool { return raised[i].GatewayID < raised[j].GatewayID })
+	d.alerts = append(d.alerts, raised...)
+	if excess := len(d.alerts) - consts.MaxAnomalyAlerts; excess > 0 {
+		d.alerts = d.alerts[excess:]
+	}
+	d.mu.Unlock()
+
+	for _, alert := range raised {
+		log.Printf("Anomaly on gateway %s: %s %.3f against baseline %.3f (z=%.1f over %d calls)",
+			alert.GatewayID, alert.Metric, alert.Observed, alert.Baseline, alert.ZScore, alert.Samples)
+		if d.sink != nil {
+			if err := d.sink.SendAlert(ctx, alert); err != nil {
+				log.Printf("Failed to send anomaly alert for gateway %s: %v", alert.GatewayID, err)
+			}
+		}
+	}
+
+	return raised
+}
+
+// Status reports each gateway's settings, baseline and current interval, with recent alerts
+func (d *AnomalyDetector) Status() models.AnomalyStatus {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	status := models.AnomalyStatus{Gateways: []models.GatewayAnomalyStatus{}, Alerts: []models.AnomalyAlert{}}
+	for id, g := range d.gateways {
+		settings := d.settingsFor(id)
+		status.Gateways = append(status.Gateways, models.GatewayAnomalyStatus{
+			GatewayID:           id,
+			Threshold:           settings.Threshold,
+			AutoDowngrade:       settings.AutoDowngrade,
+			BaselineIntervals:   g.intervals,
+			BaselineFailureRate: g.failureRate,
+			BaselineLatencyMs:   g.latencyMean,
+			CurrentCalls:        g.calls,
+			DowngradedUntil:     g.downgradedUntil,
+		})
+	}
+	sort.Slice(status.Gateways, func(i, j int) bool { return status.Gateways[i].GatewayID < status.Gateways[j].GatewayID })
+
+	// Newest first
+	for i := len(d.alerts) - 1; i >= 0; i-- {
+		status.Alerts = append(status.Alerts, d.alerts[i])
+	}
+	return status
+}
+
+// gateway returns the baseline of a gateway, creating it; the caller must hold d.mu
+func (d *AnomalyDetector) gateway(gatewayID string) *gatewayBaseline {
+	g, ok := d.gateways[gatewayID]
+	if !ok {
+		g = &gatewayBaseline{}
+		d.gateways[gatewayID] = g
+	}
+	return g
+}
+
+// settingsFor returns a gateway's settings or the defaults; the caller must hold d.mu
+func (d *AnomalyDetector) settingsFor(gatewayID string) AnomalySettings {
+	if settings, ok := d.settings[gatewayID]; ok {
+		return settings
+	}
+	return d.defaults
+}
+
+// detect compares the current interval with the baseline once enough intervals are known. A
+// spike must be both statistically significant and large enough to matter: with many calls,
+// even a negligible change would otherwise be significant.
+func (g *gatewayBaseline) detect(gatewayID string, threshold float64, now time.Time) []models.AnomalyAlert {
+	if g.intervals < consts.AnomalyWarmupIntervals {
+		return nil
+	}
+
+	n := float64(g.calls)
+	rate := float64(g.failures) / n
+	mean := g.latencySum / n
+
+	var alerts []models.AnomalyAlert
+
+	p0 := math.Max(g.failureRate, consts.AnomalyMinBaselineFailureRate)
+	if z := (rate - p0) / math.Sqrt(p0*(1-p0)/n); z > threshold && rate-g.failureRate >= consts.AnomalyMinFailureRateIncrease {
+		alerts = append(alerts, models.AnomalyAlert{
+			GatewayID: gatewayID, Metric: AnomalyFailureRate, Observed: rate, Baseline: g.failureRate, ZScore: z, Samples: g.calls, DetectedAt: now,
+		})
+	}
+
+	sd := math.Sqrt(math.Max(g.latencyVar, 1))
+	if z := (mean - g.latencyMean) / (sd / math.Sqrt(n)); z > threshold && mean >= g.latencyMean*consts.AnomalyMinLatencyRatio {
+		alerts = append(alerts, models.AnomalyAlert{
+			GatewayID: gatewayID, Metric: AnomalyLatency, Observed: mean, Baseline: g.latencyMean, ZScore: z, Samples: g.calls, DetectedAt: now,
+		})
+	}
+
+	return alerts
+}
+
+// fold adds the current interval to the baseline
+func (g *gatewayBaseline) fold() {
+	n := float64(g.calls)
+	rate := float64(g.failures) / n
+	mean := g.latencySum / n
+	variance := math.Max(g.latencySumSq/n-mean*mean, 0)
+
+	if g.intervals == 0 {
+		g.failureRate, g.latencyMean, g.latencyVar = rate, mean, variance
+	} else {
+		w := consts.AnomalyBaselineWeight
+		g.failureRate += w * (rate - g.failureRate)
+		g.latencyMean += w * (mean - g.latencyMean)
+		g.latencyVar += w * (variance - g.latencyVar)
+	}
+	g.intervals++
+}
+
+// reset starts a new interval
+func (g *gatewayBaseline) reset() {
+	g.calls, g.failures = 0, 0
+	g.latencySum, g.latencySumSq = 0, 0
+}
+
+// ParseAnomalySettings parses per-gateway settings such as "1=2.5,2=4:downgrade": a gateway ID,
+// its z-score threshold and optionally ":downgrade" to downgrade it automatically
+func ParseAnomalySettings(spec string) (map[string]AnomalySettings, error) {
+	settings := make(map[string]AnomalySettings)
+	for _, entry := range strings.Split(spec, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		id, value, ok := strings.Cut(entry, "=")
+		if !ok || id == "" {
+			return nil, fmt.Errorf("invalid anomaly setting %q", entry)
+		}
+
+		threshold, flag, _ := strings.Cut(value, ":")
+		t, err := strconv.ParseFloat(threshold, 64)
+		if err != nil || t <= 0 {
+			return nil, fmt.Errorf("invalid anomaly threshold %q for gateway %s", threshold, id)
+		}
+		if flag != "" && flag != "downgrade" {
+			return nil, fmt.Errorf("invalid anomaly option %q for gateway %s", flag, id)
+		}
+
+		settings[id] = AnomalySettings{Threshold: t, AutoDowngrade: flag == "downgrade"}
+	}
+	return settings, nil
+}
+
+// WebhookAlertSink posts alerts as JSON to an operator webhook such as a chat or paging integration
+type WebhookAlertSink struct {
+	url    string
+	client *http.Client
+}
+
+// NewWebhookAlertSink creates a sink posting alerts to url
+func NewWebhookAlertSink(url string) *WebhookAlertSink {
+	return &WebhookAlertSink{url: url, client: &http.Client{Timeout: 10 * time.Second}}
+}
+
+// SendAlert posts the alert
+func (s *WebhookAlertSink) SendAlert(ctx context.Context, alert models.AnomalyAlert) error {
+	body, err := json.Marshal(alert)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.url, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to build alert request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to deliver alert: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("alert webhook returned status %d", resp.StatusCode)
+	}
+	return nil
+}
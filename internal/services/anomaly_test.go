@@ -0,0 +1,111 @@
+package services
+
+import (
+	"context"
+	"payment-gateway/internal/consts"
+	"testing"
+	"time"
+)
+
+// recordingDowngrader records the gateways it was asked to downgrade
+type recordingDowngrader struct {
+	downgraded map[string]time.Time
+}
+
+func (d *recordingDowngrader) DowngradeGateway(gatewayID string, until time.Time) {
+	d.downgraded[gatewayID] = until
+}
+
+// observeInterval records calls for a gateway, failing failures of them, with latencies
+// alternating around latency
+func observeInterval(d *AnomalyDetector, gatewayID string, calls, failures int, latency time.Duration) {
+	for i := 0; i < calls; i++ {
+		jitter := time.Duration(i%5-2) * time.Millisecond
+		d.ObserveGatewayCall(gatewayID, latency+jitter, i < failures)
+	}
+}
+
+// warmUp builds a baseline of 5% failures at about 100ms for a gateway
+func warmUp(d *AnomalyDetector, gatewayID string) {
+	for i := 0; i < consts.AnomalyWarmupIntervals; i++ {
+		observeInterval(d, gatewayID, 100, 5, 100*time.Millisecond)
+		d.Evaluate(context.Background())
+	}
+}
+
+// TestAnomalyDetectorFailureSpike tests that a failure-rate spike is reported and downgrades the
+// gateway when configured to, while gateways with a higher threshold tolerate it
+func TestAnomalyDetectorFailureSpike(t *testing.T) {
+	downgrader := &recordingDowngrader{downgraded: make(map[string]time.Time)}
+	detector := NewAnomalyDetector(downgrader)
+	detector.SetGatewaySettings("1", AnomalySettings{Threshold: 3, AutoDowngrade: true})
+	detector.SetGatewaySettings("2", AnomalySettings{Threshold: 20})
+
+	warmUp(detector, "1")
+	warmUp(detector, "2")
+
+	observeInterval(detector, "1", 100, 20, 100*time.Millisecond)
+	observeInterval(detector, "2", 100, 20, 100*time.Millisecond)
+	alerts := detector.Evaluate(context.Background())
+
+	if len(alerts) != 1 || alerts[0].GatewayID != "1" || alerts[0].Metric != AnomalyFailureRate {
+		t.Fatalf("Expected one failure rate alert for gateway 1, got %+v", alerts)
+	}
+	if alerts[0].Observed != 0.2 || alerts[0].Baseline < 0.049 || alerts[0].Baseline > 0.051 {
+		t.Errorf("Expected 20%% failures against a 5%% baseline, got %+v", alerts[0])
+	}
+	if until, ok := downgrader.downgraded["1"]; !ok || alerts[0].DowngradedUntil != until {
+		t.Errorf("Expected gateway 1 downgraded as reported in the alert, got %v", downgrader.downgraded)
+	}
+
+	// The spike is not folded into the baseline
+	status := detector.Status()
+	if status.Gateways[0].BaselineIntervals != consts.AnomalyWarmupIntervals || len(status.Alerts) != 1 {
+		t.Errorf("Expected the spike to stay out of the baseline, got %+v", status)
+	}
+}
+
+// TestAnomalyDetectorLatencySpike tests latency spikes, warm-up and quiet intervals
+func TestAnomalyDetectorLatencySpike(t *testing.T) {
+	detector := NewAnomalyDetector(nil)
+
+	// No alerts while the baseline is still forming
+	observeInterval(detector, "1", 100, 50, time.Second)
+	if alerts := detector.Evaluate(context.Background()); len(alerts) != 0 {
+		t.Fatalf("Expected no alerts during warm-up, got %+v", alerts)
+	}
+	detector = NewAnomalyDetector(nil)
+	warmUp(detector, "1")
+
+	// Too few calls to judge; they carry over into the next interval
+	observeInterval(detector, "1", consts.AnomalyMinSamples-1, 0, 400*time.Millisecond)
+	if alerts := detector.Evaluate(context.Background()); len(alerts) != 0 {
+		t.Fatalf("Expected quiet interval to be skipped, got %+v", alerts)
+	}
+
+	observeInterval(detector, "1", 30, 1, 400*time.Millisecond)
+	alerts := detector.Evaluate(context.Background())
+	if len(alerts) != 1 || alerts[0].Metric != AnomalyLatency || alerts[0].Samples != consts.AnomalyMinSamples-1+30 {
+		t.Fatalf("Expected one latency alert over both intervals, got %+v", alerts)
+	}
+	if !alerts[0].DowngradedUntil.IsZero() {
+		t.Errorf("Expected no downgrade without auto-downgrade, got %v", alerts[0].DowngradedUntil)
+	}
+}
+
+// TestParseAnomalySettings tests the per-gateway settings format
+func TestParseAnomalySettings(t *testing.T) {
+	settings, err := ParseAnomalySettings("1=2.5, 2=4:downgrade")
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	if settings["1"] != (AnomalySettings{Threshold: 2.5}) || settings["2"] != (AnomalySettings{Threshold: 4, AutoDowngrade: true}) {
+		t.Errorf("Unexpected settings %+v", settings)
+	}
+
+	for _, spec := range []string{"1", "1=fast", "1=0", "1=3:pause"} {
+		if _, err := ParseAnomalySettings(spec); err == nil {
+			t.Errorf("Expected %q to be rejected", spec)
+		}
+	}
+}
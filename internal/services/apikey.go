@@ -0,0 +1,198 @@
+package services
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"payment-gateway/db"
+	"payment-gateway/internal/consts"
+	"payment-gateway/internal/models"
+	"strings"
+	"time"
+)
+
+var (
+	ErrInvalidAPIKey      = errors.New("invalid API key")
+	ErrAPIKeyNotFound     = errors.New("API key not found")
+	ErrInvalidAPIKeyScope = errors.New("scope must be read_only or full")
+	ErrMerchantNotFound   = errors.New("merchant not found")
+)
+
+// APIKeyService issues and verifies the API keys merchants authenticate with. Keys are
+// random, shown once and stored as SHA-256 hashes.
+type APIKeyService struct {
+	db db.DBInterface
+}
+
+// NewAPIKeyService creates a new API key service
+func NewAPIKeyService(dbInterface db.DBInterface) *APIKeyService {
+	return &APIKeyService{db: dbInterface}
+}
+
+// Create issues a new API key for a merchant. The full key is only returned here.
+func (s *APIKeyService) Create(ctx context.Context, merchantID int, req models.APIKeyRequest) (*models.APIKey, error) {
+	if req.Scope == "" {
+		req.Scope = consts.APIKeyScopeReadOnly
+	}
+	if req.Scope != consts.APIKeyScopeReadOnly && req.Scope != consts.APIKeyScopeFull {
+		return nil, ErrInvalidAPIKeyScope
+	}
+	if req.Name == "" {
+		req.Name = "default"
+	}
+
+	if _, err := s.db.GetMerchantByID(merchantID); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrMerchantNotFound
+		}
+		return nil, err
+	}
+
+	prefix, key, err := generateAPIKey()
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate API key: %w", err)
+	}
+
+	record := models.APIKey{
+		MerchantID: merchantID,
+		Name:       req.Name,
+		Prefix:     prefix,
+		KeyHash:    hashCredential(key),
+		Scope:      req.Scope,
+		Status:     consts.APIKeyActive,
+		CreatedAt:  time.Now(),
+	}
+
+	id, err := s.db.CreateAPIKey(record)
+	if err != nil {
+		return nil, err
+	}
+
+	record.ID = id
+	record.Key = key
+	return &record, nil
+}
+
+// List returns a merchant's API keys without their hashes
+func (s *APIKeyService) List(ctx context.Context, merchantID int) ([]models.APIKey, error) {
+	return s.db.GetAPIKeysByMerchant(merchantID)
+}
+
+// Roll issues a replacement for an active key with the same name and scope. The old key keeps
+// working for consts.APIKeyRollGracePeriod so clients can switch over.
+func (s *APIKeyService) Roll(ctx context.Context, merchantID, keyID int) (*models.APIKey, error) {
+	old, err := s.activeKey(merchantID, keyID)
+	if err != nil {
+		return nil, err
+	}
+
+	replacement, err := s.Create(ctx, merchantID, models.APIKeyRequest{Name: old.Name, Scope: old.Scope})
+	if err != nil {
+		return nil, err
+	}
+
+	expiresAt := time.Now().Add(consts.APIKeyRollGracePeriod)
+	if !old.ExpiresAt.IsZero() && old.ExpiresAt.Before(expiresAt) {
+		expiresAt = old.ExpiresAt
+	}
+	if err := s.db.ExpireAPIKey(merchantID, keyID, expiresAt); err != nil {
+		return nil, fmt.Errorf("failed to expire rolled API key: %w", err)
+	}
+
+	return replacement, nil
+}
+
+// Revoke stops an API key from working immediately
+func (s *APIKeyService) Revoke(ctx context.Context, merchantID, keyID int) error {
+	if err := s.db.RevokeAPIKey(merchantID, keyID); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrAPIKeyNotFound
+		}
+		return err
+	}
+	return nil
+}
+
+// Authenticate returns the active key matching a presented API key and records its use
+func (s *APIKeyService) Authenticate(ctx context.Context, key string) (*models.APIKey, error) {
+	prefix, ok := apiKeyPrefixOf(key)
+	if !ok {
+		return nil, ErrInvalidAPIKey
+	}
+
+	record, err := s.db.GetAPIKeyByPrefix(prefix)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrInvalidAPIKey
+		}
+		return nil, err
+	}
+
+	now := time.Now()
+	if subtle.ConstantTimeCompare([]byte(record.KeyHash), []byte(hashCredential(key))) != 1 ||
+		record.Status != consts.APIKeyActive ||
+		(!record.ExpiresAt.IsZero() && !now.Before(record.ExpiresAt)) {
+		return nil, ErrInvalidAPIKey
+	}
+
+	// Usage is tracked coarsely so authenticated requests don't each cost a write
+	if now.Sub(record.LastUsedAt) >= consts.APIKeyLastUsedResolution {
+		if err := s.db.TouchAPIKey(record.ID, now); err != nil {
+			log.Printf("Failed to record use of API key %d: %v", record.ID, err)
+		}
+		record.LastUsedAt = now
+	}
+
+	return record, nil
+}
+
+// activeKey returns an active key of a merchant
+func (s *APIKeyService) activeKey(merchantID, keyID int) (*models.APIKey, error) {
+	keys, err := s.db.GetAPIKeysByMerchant(merchantID)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, key := range keys {
+		if key.ID == keyID && key.Status == consts.APIKeyActive {
+			return &key, nil
+		}
+	}
+	return nil, ErrAPIKeyNotFound
+}
+
+// generateAPIKey returns a new key and its public prefix, e.g. "pgk_1a2b3c4d5e6f_<secret>"
+func generateAPIKey() (string, string, error) {
+	buf := make([]byte, 6+32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", "", err
+	}
+
+	prefix := consts.APIKeyPrefix + hex.EncodeToString(buf[:6])
+	return prefix, prefix + "_" + hex.EncodeToString(buf[6:]), nil
+}
+
+// apiKeyPrefixOf extracts the public prefix from a presented key
+func apiKeyPrefixOf(key string) (string, bool) {
+	if !strings.HasPrefix(key, consts.APIKeyPrefix) {
+		return "", false
+	}
+	idx := strings.LastIndex(key, "_")
+	if idx <= len(consts.APIKeyPrefix) {
+		return "", false
+	}
+	return key[:idx], true
+}
+
+// hashAPIKey returns the stored form of a key. Keys carry 256 bits of randomness, so a
+// fast hash is sufficient.
+func hashCredential(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,122 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"payment-gateway/db"
+	"payment-gateway/internal/consts"
+	"payment-gateway/internal/models"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestAPIKeyLifecycle tests that keys authenticate until they are revoked and that only
+// their hashes are stored
+func TestAPIKeyLifecycle(t *testing.T) {
+	mockDB := db.NewMockDB()
+	service := NewAPIKeyService(mockDB)
+	ctx := context.Background()
+
+	key, err := service.Create(ctx, 1, models.APIKeyRequest{Name: "reporting"})
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	if key.Scope != consts.APIKeyScopeReadOnly {
+		t.Errorf("Expected default scope %s, got %s", consts.APIKeyScopeReadOnly, key.Scope)
+	}
+	if !strings.HasPrefix(key.Key, key.Prefix+"_") {
+		t.Errorf("Expected key %q to start with its prefix %q", key.Key, key.Prefix)
+	}
+
+	stored, err := mockDB.GetAPIKeyByPrefix(key.Prefix)
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	if stored.Key != "" || stored.KeyHash == "" || strings.Contains(key.Key, stored.KeyHash) {
+		t.Errorf("Expected only the key hash to be stored, got key %q hash %q", stored.Key, stored.KeyHash)
+	}
+
+	authenticated, err := service.Authenticate(ctx, key.Key)
+	if err != nil {
+		t.Fatalf("Expected key to authenticate, got: %v", err)
+	}
+	if authenticated.MerchantID != 1 || authenticated.LastUsedAt.IsZero() {
+		t.Errorf("Expected key of merchant 1 with last use recorded, got %+v", authenticated)
+	}
+
+	if _, err := service.Authenticate(ctx, key.Prefix+"_wrongsecret"); !errors.Is(err, ErrInvalidAPIKey) {
+		t.Errorf("Expected ErrInvalidAPIKey for wrong secret, got: %v", err)
+	}
+	if _, err := service.Authenticate(ctx, "not-a-key"); !errors.Is(err, ErrInvalidAPIKey) {
+		t.Errorf("Expected ErrInvalidAPIKey for malformed key, got: %v", err)
+	}
+
+	if err := service.Revoke(ctx, 1, key.ID); err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	if _, err := service.Authenticate(ctx, key.Key); !errors.Is(err, ErrInvalidAPIKey) {
+		t.Errorf("Expected revoked key to be rejected, got: %v", err)
+	}
+	if err := service.Revoke(ctx, 1, key.ID); !errors.Is(err, ErrAPIKeyNotFound) {
+		t.Errorf("Expected ErrAPIKeyNotFound revoking twice, got: %v", err)
+	}
+}
+
+// TestRollAPIKey tests that a rolled key keeps working during the grace period alongside
+// a replacement with the same name and scope
+func TestRollAPIKey(t *testing.T) {
+	mockDB := db.NewMockDB()
+	service := NewAPIKeyService(mockDB)
+	ctx := context.Background()
+
+	old, err := service.Create(ctx, 1, models.APIKeyRequest{Name: "checkout", Scope: consts.APIKeyScopeFull})
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+
+	replacement, err := service.Roll(ctx, 1, old.ID)
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	if replacement.ID == old.ID || replacement.Name != "checkout" || replacement.Scope != consts.APIKeyScopeFull {
+		t.Errorf("Expected a new full key named checkout, got %+v", replacement)
+	}
+
+	for _, key := range []string{old.Key, replacement.Key} {
+		if _, err := service.Authenticate(ctx, key); err != nil {
+			t.Errorf("Expected key to authenticate during the grace period, got: %v", err)
+		}
+	}
+
+	stored, _ := mockDB.GetAPIKeyByPrefix(old.Prefix)
+	if stored.ExpiresAt.IsZero() || stored.ExpiresAt.After(time.Now().Add(consts.APIKeyRollGracePeriod)) {
+		t.Errorf("Expected rolled key to expire within the grace period, got %v", stored.ExpiresAt)
+	}
+
+	// Once the grace period has passed the old key is rejected
+	if err := mockDB.ExpireAPIKey(1, old.ID, time.Now().Add(-time.Second)); err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	if _, err := service.Authenticate(ctx, old.Key); !errors.Is(err, ErrInvalidAPIKey) {
+		t.Errorf("Expected expired key to be rejected, got: %v", err)
+	}
+
+	// Keys of other merchants cannot be rolled
+	if _, err := service.Roll(ctx, 2, replacement.ID); !errors.Is(err, ErrAPIKeyNotFound) {
+		t.Errorf("Expected ErrAPIKeyNotFound, got: %v", err)
+	}
+}
+
+// TestCreateAPIKeyValidation tests that unknown scopes and merchants are rejected
+func TestCreateAPIKeyValidation(t *testing.T) {
+	service := NewAPIKeyService(db.NewMockDB())
+	ctx := context.Background()
+
+	if _, err := service.Create(ctx, 1, models.APIKeyRequest{Scope: "admin"}); !errors.Is(err, ErrInvalidAPIKeyScope) {
+		t.Errorf("Expected ErrInvalidAPIKeyScope, got: %v", err)
+	}
+	if _, err := service.Create(ctx, 999, models.APIKeyRequest{}); !errors.Is(err, ErrMerchantNotFound) {
+		t.Errorf("Expected ErrMerchantNotFound, got: %v", err)
+	}
+}
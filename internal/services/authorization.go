@@ -0,0 +1,489 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"payment-gateway/internal/consts"
+	"payment-gateway/internal/events"
+	"payment-gateway/internal/gateway"
+	"payment-gateway/internal/models"
+	"payment-gateway/internal/money"
+	"payment-gateway/internal/utils"
+	"strconv"
+	"time"
+)
+
+var (
+	ErrInvalidAuthorization        = errors.New("invalid authorization")
+	ErrAuthorizationUnsupported    = errors.New("the gateway does not support authorizations")
+	ErrNotAuthorized               = errors.New("only authorized deposits can be captured or voided")
+	ErrCaptureExceedsAuthorization = errors.New("capture exceeds the authorized amount")
+	ErrIncrementNotRequested       = errors.New("incremental authorization was not requested")
+)
+
+// ProcessAuthorization authorizes a deposit: its gateway holds the amount on the customer's card
+// until the deposit is captured or voided, or the authorization lapses. Only gateways that can
+// hold amounts are selected. Gateways waiting for the customer leave the deposit processing until
+// they report it authorized.
+func (s *TransactionService) ProcessAuthorization(ctx context.Context, req models.TransactionRequest) (*models.TransactionResponse, error) {
+	req.ManualCapture = true
+	return s.processTransaction(ctx, consts.Deposit, req)
+}
+
+// validateAuthorization checks that only card deposits, paid with the card or a wallet token, are
+// authorized, and that only authorizations ask for incremental authorization
+func validateAuthorization(txType string, req models.TransactionRequest) error {
+	if !req.ManualCapture {
+		if req.IncrementalAuthorization {
+			return fmt.Errorf("%w: incremental authorization can only be requested for authorizations", ErrInvalidAuthorization)
+		}
+		return nil
+	}
+	if txType != consts.Deposit {
+		return fmt.Errorf("%w: only deposits can be authorized", ErrInvalidAuthorization)
+	}
+	if req.PhoneNumber != "" || req.BankID != "" || req.MandateID != 0 || req.VPA != "" {
+		return fmt.Errorf("%w: only card deposits can be authorized, not ones naming a wallet, bank, mandate or VPA", ErrInvalidAuthorization)
+	}
+	if req.SCAExemption != "" {
+		return fmt.Errorf("%w: SCA exemptions can't be requested for authorizations", ErrInvalidAuthorization)
+	}
+	return nil
+}
+
+// applyAuthorization keeps a deposit to authorize away from gateways that can't hold amounts, or
+// can't raise them when incremental authorization is requested. A preferred gateway in the
+// request must support it.
+func (s *TransactionService) applyAuthorization(opts *gateway.SelectionOptions, req models.TransactionRequest) error {
+	if !req.ManualCapture {
+		return nil
+	}
+
+	for _, provider := range s.gatewaySelector.Providers() {
+		if req.IncrementalAuthorization && gateway.SupportsIncrementalAuthorization(provider) {
+			continue
+		}
+		if !req.IncrementalAuthorization && gateway.SupportsAuthorization(provider) {
+			continue
+		}
+		if provider.ID() == opts.PreferredGatewayID {
+			if req.IncrementalAuthorization {
+				return fmt.Errorf("%w: the preferred gateway %s can't increment authorizations", ErrAuthorizationUnsupported, opts.PreferredGatewayID)
+			}
+			return fmt.Errorf("%w: the preferred gateway %s can't authorize deposits", ErrAuthorizationUnsupported, opts.PreferredGatewayID)
+		}
+		opts.ExcludedGatewayIDs = append(opts.ExcludedGatewayIDs, provider.ID())
+	}
+	return nil
+}
+
+// recordAuthorization records a deposit as authorized until its gateway's authorization lapses.
+// It returns sql.ErrNoRows when the deposit was already captured, voided or failed.
+func (s *TransactionService) recordAuthorization(tx *models.Transaction, provider gateway.Provider) error {
+	validity := consts.DefaultAuthorizationValidity
+	if authorizer, ok := provider.(gateway.AuthorizationProvider); ok {
+		validity = authorizer.AuthorizationValidity()
+	}
+
+	expiresAt := time.Now().Add(validity).UTC()
+	if err := s.db.AuthorizeTransaction(tx.ID, expiresAt); err != nil {
+		return err
+	}
+	tx.AuthorizedAmount = tx.Amount
+	tx.AuthorizationExpiresAt = expiresAt
+	return nil
+}
+
+// Capture takes part or all of what remains authorized of a deposit, all of it when no amount is
+// given. A final capture, the default, releases the rest and the deposit's amount becomes the
+// amount it captured. Captures that aren't final keep the rest authorized for further captures,
+// each recorded as a deposit of its own linked to the authorization.
+func (s *TransactionService) Capture(ctx context.Context, req models.CaptureRequest) (*models.TransactionResponse, error) {
+	tx, authorizer, err := s.authorizedTransaction(req.TransactionID)
+	if err != nil {
+		return nil, err
+	}
+
+	remaining := s.rounder.MinorUnits(tx.AuthorizedAmount, tx.Currency) - s.rounder.MinorUnits(tx.CapturedAmount, tx.Currency)
+	amount := money.FromMinorUnits(remaining, tx.Currency)
+	if req.Amount > 0 {
+		if amount, err = s.roundedAmount(req.Amount, tx.Currency); err != nil {
+			return nil, err
+		}
+	}
+	if s.rounder.MinorUnits(amount, tx.Currency) > remaining {
+		return nil, fmt.Errorf("%w: %v %s remains authorized", ErrCaptureExceedsAuthorization, money.FromMinorUnits(remaining, tx.Currency), tx.Currency)
+	}
+
+	// Capturing all that remains ends the authorization whatever the request says
+	if req.FinalCapture != nil && !*req.FinalCapture && s.rounder.MinorUnits(amount, tx.Currency) < remaining {
+		return s.capturePart(ctx, tx, authorizer, amount)
+	}
+
+	response, err := s.settleAuthorization(tx, authorizer, "capture", amount, func(call models.Transaction) (*models.TransactionResponse, error) {
+		return authorizer.Capture(ctx, call, amount)
+	})
+	if err != nil || response.Status == consts.Failed {
+		return response, err
+	}
+
+	// Gateways may capture asynchronously; their callback completes the deposit
+	status := consts.Processing
+	if response.Status == consts.Completed {
+		status = consts.Completed
+	}
+	captured := s.capturedAmount(tx, amount)
+	if err := s.db.CaptureTransaction(tx.ID, amount, captured, status); err != nil {
+		return nil, fmt.Errorf("failed to update transaction: %w", err)
+	}
+	tx.Amount = amount
+	tx.CapturedAmount = captured
+	s.publishStatus(*tx, status, "")
+
+	log.Printf("Captured %v %s of transaction %d, authorized for %v", amount, tx.Currency, tx.ID, tx.AuthorizedAmount)
+	response.Status = status
+	response.CapturedAmount = captured
+	response.RemainingAuthorizedAmount = new(float64)
+	response.FinalCapture = true
+	return response, nil
+}
+
+// capturePart captures part of an authorized deposit and keeps the rest authorized. The part is
+// recorded as a deposit linked to the authorization: completed once the gateway captured it, or
+// failed with the decline when the issuer declined it, which leaves the authorization as it was.
+func (s *TransactionService) capturePart(ctx context.Context, tx *models.Transaction, authorizer gateway.AuthorizationProvider, amount float64) (*models.TransactionResponse, error) {
+	capturer, ok := authorizer.(gateway.MultiCaptureProvider)
+	if !ok {
+		return nil, fmt.Errorf("%w: %s can't capture authorizations in parts", ErrAuthorizationUnsupported, authorizer.Name())
+	}
+
+	// Keyed by the total captured, so parts of the same amount are distinct calls
+	captured := s.capturedAmount(tx, amount)
+	response, decline, err := s.callAuthorizer(tx, capturer, "partial-capture", captured, func(call models.Transaction) (*models.TransactionResponse, error) {
+		return capturer.CapturePart(ctx, call, amount)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if decline != nil {
+		// The issuer keeps holding what remains authorized
+		if err := s.updateStatus(tx.ID, tx.Status, consts.Authorized, ""); err != nil {
+			return nil, fmt.Errorf("failed to update transaction: %w", err)
+		}
+		part, err := s.createCapturePart(tx, amount)
+		if err != nil {
+			return nil, err
+		}
+		log.Printf("The partial capture of %v %s of transaction %d was declined: %s", amount, tx.Currency, tx.ID, decline.Code)
+		response := s.declineTransaction(part, decline)
+		response.ReferenceID = part.ReferenceID
+		response.CaptureOfTransactionID = tx.ID
+		response.CapturedAmount = tx.CapturedAmount
+		response.RemainingAuthorizedAmount = s.remainingAuthorizedAmount(tx, tx.CapturedAmount)
+		return response, nil
+	}
+
+	if response.Status != consts.Completed {
+		if err := s.updateStatus(tx.ID, tx.Status, consts.Authorized, ""); err != nil {
+			log.Printf("Failed to return transaction %d to authorized: %v", tx.ID, err)
+		}
+		return nil, fmt.Errorf("gateway partial capture failed: the capture is %s", response.Status)
+	}
+	if err := s.db.RecordPartialCapture(tx.ID, captured); err != nil {
+		return nil, fmt.Errorf("failed to update transaction: %w", err)
+	}
+
+	part, err := s.createCapturePart(tx, amount)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.updateStatus(part.ID, part.Status, consts.Completed, ""); err != nil {
+		return nil, fmt.Errorf("failed to update transaction: %w", err)
+	}
+	s.publishStatus(part, consts.Completed, "")
+
+	log.Printf("Captured %v %s of transaction %d as transaction %d, %v of %v captured", amount, tx.Currency, tx.ID, part.ID, captured, tx.AuthorizedAmount)
+	response.TransactionID = part.ID
+	response.ReferenceID = part.ReferenceID
+	response.CaptureOfTransactionID = tx.ID
+	response.CapturedAmount = captured
+	response.RemainingAuthorizedAmount = s.remainingAuthorizedAmount(tx, captured)
+	return response, nil
+}
+
+// createCapturePart records a partial capture of an authorized deposit as a processing deposit of
+// amount linked to it. It has no gateway reference of its own, so gateway callbacks keep reaching
+// the authorization.
+func (s *TransactionService) createCapturePart(tx *models.Transaction, amount float64) (models.Transaction, error) {
+	ref, err := s.references.New()
+	if err != nil {
+		return models.Transaction{}, err
+	}
+
+	part := models.Transaction{
+		ReferenceID:   ref,
+		Amount:        amount,
+		Currency:      tx.Currency,
+		Type:          consts.Deposit,
+		Status:        consts.Processing,
+		UserID:        tx.UserID,
+		GatewayID:     tx.GatewayID,
+		CountryID:     tx.CountryID,
+		CountrySource: tx.CountrySource,
+		CardBIN:       tx.CardBIN,
+		Livemode:      tx.Livemode,
+		CaptureOfID:   tx.ID,
+		CreatedAt:     time.Now(),
+	}
+	id, err := s.db.CreateTransaction(part)
+	if err != nil {
+		return models.Transaction{}, fmt.Errorf("failed to create transaction: %w", err)
+	}
+	part.ID = id
+	s.emit(events.TransactionEvent{Type: events.TransactionCreated, Transaction: part})
+	return part, nil
+}
+
+// capturedAmount returns what an authorized deposit will have captured in all after capturing
+// amount
+func (s *TransactionService) capturedAmount(tx *models.Transaction, amount float64) float64 {
+	return money.FromMinorUnits(s.rounder.MinorUnits(tx.CapturedAmount, tx.Currency)+s.rounder.MinorUnits(amount, tx.Currency), tx.Currency)
+}
+
+// remainingAuthorizedAmount returns what remains authorized of a deposit once captured is captured
+func (s *TransactionService) remainingAuthorizedAmount(tx *models.Transaction, captured float64) *float64 {
+	remaining := money.FromMinorUnits(s.rounder.MinorUnits(tx.AuthorizedAmount, tx.Currency)-s.rounder.MinorUnits(captured, tx.Currency), tx.Currency)
+	return &remaining
+}
+
+// Void releases the amount held for an authorized deposit
+func (s *TransactionService) Void(ctx context.Context, req models.VoidRequest) (*models.TransactionResponse, error) {
+	tx, authorizer, err := s.authorizedTransaction(req.TransactionID)
+	if err != nil {
+		return nil, err
+	}
+
+	response, err := s.settleAuthorization(tx, authorizer, "void", tx.AuthorizedAmount, func(call models.Transaction) (*models.TransactionResponse, error) {
+		return authorizer.Void(ctx, call)
+	})
+	if err != nil || response.Status == consts.Failed {
+		return response, err
+	}
+
+	if err := s.updateStatus(tx.ID, tx.Status, consts.Voided, ""); err != nil {
+		return nil, fmt.Errorf("failed to update transaction: %w", err)
+	}
+	s.publishStatus(*tx, consts.Voided, "")
+
+	response.Status = consts.Voided
+	return response, nil
+}
+
+// IncrementAuthorization raises the amount held for an authorized deposit by the request's amount,
+// as when a hotel stay or car rental runs longer. The deposit must have asked for incremental
+// authorization when it was authorized. A declined increment leaves the authorization as it was.
+func (s *TransactionService) IncrementAuthorization(ctx context.Context, req models.IncrementAuthorizationRequest) (*models.TransactionResponse, error) {
+	tx, authorizer, err := s.authorizedTransaction(req.TransactionID)
+	if err != nil {
+		return nil, err
+	}
+	incrementer, ok := authorizer.(gateway.IncrementalAuthorizationProvider)
+	if !ok {
+		return nil, fmt.Errorf("%w: %s can't increment authorizations", ErrAuthorizationUnsupported, authorizer.Name())
+	}
+	if !tx.IncrementalAuthorization {
+		return nil, fmt.Errorf("%w: transaction %d was authorized without it", ErrIncrementNotRequested, tx.ID)
+	}
+
+	increment, err := s.roundedAmount(req.Amount, tx.Currency)
+	if err != nil {
+		return nil, err
+	}
+	amount := money.FromMinorUnits(s.rounder.MinorUnits(tx.AuthorizedAmount, tx.Currency)+s.rounder.MinorUnits(increment, tx.Currency), tx.Currency)
+
+	response, decline, err := s.callAuthorizer(tx, incrementer, "increment", amount, func(call models.Transaction) (*models.TransactionResponse, error) {
+		return incrementer.IncrementAuthorization(ctx, call, amount)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if decline != nil {
+		// The issuer keeps holding the amount already authorized
+		if err := s.updateStatus(tx.ID, tx.Status, consts.Authorized, ""); err != nil {
+			return nil, fmt.Errorf("failed to update transaction: %w", err)
+		}
+		log.Printf("The increment of transaction %d to %v %s was declined: %s", tx.ID, amount, tx.Currency, decline.Code)
+		return &models.TransactionResponse{
+			Status:           consts.Authorized,
+			TransactionID:    tx.ID,
+			ReferenceID:      tx.ReferenceID,
+			Message:          decline.Message,
+			DeclineCode:      decline.Code,
+			RecoveryHint:     s.recoveryHints.Hint(decline.Code),
+			AuthorizedAmount: tx.AuthorizedAmount,
+		}, nil
+	}
+
+	if response.Status != consts.Authorized {
+		if err := s.updateStatus(tx.ID, tx.Status, consts.Authorized, ""); err != nil {
+			log.Printf("Failed to return transaction %d to authorized: %v", tx.ID, err)
+		}
+		return nil, fmt.Errorf("gateway increment failed: the authorization is %s", response.Status)
+	}
+	if err := s.db.IncrementAuthorization(tx.ID, amount); err != nil {
+		return nil, fmt.Errorf("failed to update transaction: %w", err)
+	}
+
+	log.Printf("Incremented the authorization of transaction %d from %v to %v %s", tx.ID, tx.AuthorizedAmount, amount, tx.Currency)
+	response.AuthorizedAmount = amount
+	response.AuthorizationExpiresAt = &tx.AuthorizationExpiresAt
+	return response, nil
+}
+
+// authorizedTransaction returns an authorized deposit whose authorization hasn't lapsed and its
+// gateway
+func (s *TransactionService) authorizedTransaction(txID int) (*models.Transaction, gateway.AuthorizationProvider, error) {
+	tx, err := s.db.GetTransactionByID(txID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil, ErrTransactionNotFound
+		}
+		return nil, nil, fmt.Errorf("failed to get transaction: %w", err)
+	}
+	if tx.Status != consts.Authorized {
+		return nil, nil, fmt.Errorf("%w: transaction %d is %s", ErrNotAuthorized, tx.ID, tx.Status)
+	}
+	if !tx.AuthorizationExpiresAt.After(time.Now()) {
+		return nil, nil, fmt.Errorf("%w: the authorization of transaction %d expired", ErrNotAuthorized, tx.ID)
+	}
+
+	provider, err := s.gatewaySelector.GetProviderByID(strconv.Itoa(tx.GatewayID))
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to get provider: %w", err)
+	}
+	authorizer, ok := provider.(gateway.AuthorizationProvider)
+	if !ok {
+		return nil, nil, fmt.Errorf("%w: %s", ErrAuthorizationUnsupported, provider.Name())
+	}
+	return tx, authorizer, nil
+}
+
+// settleAuthorization claims an authorized deposit and sends its capture or void, the action, to
+// the gateway. Declines fail the deposit; the caller records the outcome otherwise.
+func (s *TransactionService) settleAuthorization(tx *models.Transaction, provider gateway.AuthorizationProvider, action string, amount float64, call func(models.Transaction) (*models.TransactionResponse, error)) (*models.TransactionResponse, error) {
+	response, decline, err := s.callAuthorizer(tx, provider, action, amount, call)
+	if err != nil {
+		return nil, err
+	}
+
+	if decline != nil {
+		log.Printf("The %s of transaction %d was declined: %s", action, tx.ID, decline.Code)
+		response := s.declineTransaction(*tx, decline)
+		response.ReferenceID = tx.ReferenceID
+		return response, nil
+	}
+	return response, nil
+}
+
+// callAuthorizer claims an authorized deposit and sends the action on its authorization to the
+// gateway with an idempotency key of its own. Gateway faults return the deposit to authorized, so
+// the call can be retried. Declines are returned for the caller to handle, with the deposit still
+// claimed.
+func (s *TransactionService) callAuthorizer(tx *models.Transaction, provider gateway.AuthorizationProvider, action string, amount float64, call func(models.Transaction) (*models.TransactionResponse, error)) (*models.TransactionResponse, *gateway.DeclineError, error) {
+	// Claiming the deposit keeps a concurrent capture, void or expiry from settling it too
+	if err := s.db.ClaimAuthorizedTransaction(tx.ID); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil, fmt.Errorf("%w: transaction %d is no longer authorized", ErrNotAuthorized, tx.ID)
+		}
+		return nil, nil, fmt.Errorf("failed to claim transaction: %w", err)
+	}
+	// The claim moved the deposit to processing, the status it is returned to authorized from
+	tx.Status = consts.Processing
+
+	request := *tx
+	request.GatewayIdempotencyKey = utils.GatewayIdempotencyKey(strconv.Itoa(tx.GatewayID), action, tx.ID, amount, tx.Currency)
+
+	var response *models.TransactionResponse
+	var decline *gateway.DeclineError
+
+	operation := func() error {
+		var processingErr error
+		start := time.Now()
+		response, processingErr = call(request)
+		if s.gatewayObserver != nil {
+			s.gatewayObserver.ObserveGatewayCall(provider.ID(), time.Since(start), processingErr != nil)
+		}
+		if processingErr != nil {
+			// A decline is the issuer's answer rather than a gateway fault, so it must not trip the breaker
+			if errors.As(processingErr, &decline) {
+				return nil
+			}
+			return fmt.Errorf("gateway %s failed: %w", action, processingErr)
+		}
+		return nil
+	}
+
+	if err := s.circuitBreaker.ExecuteWithCircuitBreaker(provider.ID(), operation); err != nil {
+		if updateErr := s.updateStatus(tx.ID, tx.Status, consts.Authorized, err.Error()); updateErr != nil {
+			log.Printf("Failed to return transaction %d to authorized: %v", tx.ID, updateErr)
+		}
+		return nil, nil, err
+	}
+	if decline != nil {
+		return nil, decline, nil
+	}
+
+	if response == nil {
+		response = &models.TransactionResponse{}
+	}
+	response.TransactionID = tx.ID
+	response.ReferenceID = tx.ReferenceID
+	return response, nil, nil
+}
+
+// ExpireAuthorizations voids authorized deposits whose authorization lapsed, as their gateway
+// released the amount held, returning how many were voided
+func (s *TransactionService) ExpireAuthorizations(ctx context.Context) (int, error) {
+	const message = "authorization expired"
+
+	ids, err := s.db.ExpireAuthorizations(time.Now(), message, consts.AuthorizationExpiryBatchSize)
+	for _, id := range ids {
+		log.Printf("Transaction %d: %s", id, message)
+		if tx, err := s.db.GetTransactionByID(id); err == nil {
+			s.emit(events.TransactionEvent{Type: events.TransactionStatusChanged, Transaction: *tx})
+		} else {
+			s.publishStatus(models.Transaction{ID: id}, consts.Voided, message)
+		}
+	}
+	if err != nil {
+		return len(ids), fmt.Errorf("failed to expire authorizations: %w", err)
+	}
+	return len(ids), nil
+}
+
+// authorizeFromCallback records a deposit a gateway reported authorized. Reports arriving after
+// the deposit was captured or voided are ignored.
+func (s *TransactionService) authorizeFromCallback(callbackData *models.CallbackData) error {
+	tx, err := s.db.GetTransactionByID(callbackData.TransactionID)
+	if err != nil {
+		return err
+	}
+	provider, err := s.gatewaySelector.GetProviderByID(strconv.Itoa(tx.GatewayID))
+	if err != nil {
+		return err
+	}
+
+	if err := s.recordAuthorization(tx, provider); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			log.Printf("Ignoring authorization of transaction %d, which is %s", tx.ID, tx.Status)
+			return nil
+		}
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,299 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"payment-gateway/db"
+	"payment-gateway/internal/consts"
+	"payment-gateway/internal/gateway"
+	"payment-gateway/internal/models"
+	"testing"
+	"time"
+)
+
+// depositOnlyProvider hides the authorization methods of the provider it wraps
+type depositOnlyProvider struct {
+	gateway.Provider
+}
+
+// TestAuthorizeAndCapture tests authorizing deposits, then capturing, voiding or expiring them
+func TestAuthorizeAndCapture(t *testing.T) {
+	mockDB := db.NewMockDB()
+	userID, err := mockDB.CreateUser(models.User{Username: "alice", Email: "alice@example.com", CountryID: 1, MerchantID: 1})
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+
+	selector := gateway.NewSelector(mockDB)
+	selector.RegisterProvider(depositOnlyProvider{gateway.NewMockProvider(1, "PayPal", "application/json", 1.0, 0)})
+	selector.RegisterProvider(gateway.NewMockProvider(2, "Stripe", "application/json", 1.0, 0))
+	service := NewTransactionService(mockDB, selector)
+	ctx := context.Background()
+
+	authorize := func() *models.TransactionResponse {
+		t.Helper()
+		response, err := service.ProcessAuthorization(ctx, models.TransactionRequest{UserID: userID, Amount: 100, Currency: "USD"})
+		if err != nil {
+			t.Fatalf("Expected no error, got: %v", err)
+		}
+		return response
+	}
+
+	// Deposits are authorized at gateways that can hold amounts only
+	response := authorize()
+	tx, _ := mockDB.GetTransactionByID(response.TransactionID)
+	if response.Status != consts.Authorized || response.AuthorizationExpiresAt == nil || tx.GatewayID != 2 {
+		t.Fatalf("Expected a deposit authorized at gateway 2 with an expiry, got %+v", response)
+	}
+	if tx.Status != consts.Authorized || tx.AuthorizedAmount != 100 || !tx.AuthorizationExpiresAt.After(time.Now()) {
+		t.Errorf("Expected 100 authorized until later, got %+v", tx)
+	}
+	if _, err := service.ProcessAuthorization(ctx, models.TransactionRequest{UserID: userID, Amount: 100, Currency: "USD", PreferredGatewayID: 1}); !errors.Is(err, ErrAuthorizationUnsupported) {
+		t.Errorf("Expected ErrAuthorizationUnsupported for a preferred gateway without authorizations, got: %v", err)
+	}
+
+	// Captures can't exceed the authorization and take part of it
+	if _, err := service.Capture(ctx, models.CaptureRequest{TransactionID: tx.ID, Amount: 150}); !errors.Is(err, ErrCaptureExceedsAuthorization) {
+		t.Errorf("Expected ErrCaptureExceedsAuthorization, got: %v", err)
+	}
+	captured, err := service.Capture(ctx, models.CaptureRequest{TransactionID: tx.ID, Amount: 60})
+	if err != nil || captured.Status != consts.Completed {
+		t.Fatalf("Expected a completed capture, got %+v (%v)", captured, err)
+	}
+	if tx, _ = mockDB.GetTransactionByID(tx.ID); tx.Status != consts.Completed || tx.Amount != 60 || tx.AuthorizedAmount != 100 {
+		t.Errorf("Expected 60 of 100 captured, got %+v", tx)
+	}
+	if _, err := service.Capture(ctx, models.CaptureRequest{TransactionID: tx.ID}); !errors.Is(err, ErrNotAuthorized) {
+		t.Errorf("Expected ErrNotAuthorized capturing twice, got: %v", err)
+	}
+	if _, err := service.Void(ctx, models.VoidRequest{TransactionID: tx.ID}); !errors.Is(err, ErrNotAuthorized) {
+		t.Errorf("Expected ErrNotAuthorized voiding a completed deposit, got: %v", err)
+	}
+	if _, err := service.Capture(ctx, models.CaptureRequest{TransactionID: 999}); !errors.Is(err, ErrTransactionNotFound) {
+		t.Errorf("Expected ErrTransactionNotFound, got: %v", err)
+	}
+
+	// Voids release the authorization
+	voided, err := service.Void(ctx, models.VoidRequest{TransactionID: authorize().TransactionID})
+	if err != nil || voided.Status != consts.Voided {
+		t.Fatalf("Expected a voided deposit, got %+v (%v)", voided, err)
+	}
+	if tx, _ = mockDB.GetTransactionByID(voided.TransactionID); tx.Status != consts.Voided {
+		t.Errorf("Expected the deposit voided, got %s", tx.Status)
+	}
+
+	// Lapsed authorizations are voided and can't be captured
+	lapsed := authorize().TransactionID
+	if err := mockDB.ClaimAuthorizedTransaction(lapsed); err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	if err := mockDB.AuthorizeTransaction(lapsed, time.Now().Add(-time.Minute)); err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	if _, err := service.Capture(ctx, models.CaptureRequest{TransactionID: lapsed}); !errors.Is(err, ErrNotAuthorized) {
+		t.Errorf("Expected ErrNotAuthorized for a lapsed authorization, got: %v", err)
+	}
+	expired, err := service.ExpireAuthorizations(ctx)
+	if err != nil || expired != 1 {
+		t.Fatalf("Expected 1 authorization expired, got %d (%v)", expired, err)
+	}
+	if tx, _ = mockDB.GetTransactionByID(lapsed); tx.Status != consts.Voided || tx.ErrorMessage != "authorization expired" {
+		t.Errorf("Expected the lapsed authorization voided, got %+v", tx)
+	}
+}
+
+// TestIncrementAuthorization tests raising the amount held for deposits authorized incrementally
+func TestIncrementAuthorization(t *testing.T) {
+	mockDB := db.NewMockDB()
+	userID, err := mockDB.CreateUser(models.User{Username: "alice", Email: "alice@example.com", CountryID: 1, MerchantID: 1})
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+
+	selector := gateway.NewSelector(mockDB)
+	selector.RegisterProvider(authorizeOnlyProvider{gateway.NewMockProvider(1, "PayPal", "application/json", 1.0, 0)})
+	selector.RegisterProvider(gateway.NewMockProvider(2, "Stripe", "application/json", 1.0, 0))
+	service := NewTransactionService(mockDB, selector)
+	ctx := context.Background()
+
+	authorize := func(req models.TransactionRequest) *models.Transaction {
+		t.Helper()
+		response, err := service.ProcessAuthorization(ctx, req)
+		if err != nil {
+			t.Fatalf("Expected no error, got: %v", err)
+		}
+		tx, _ := mockDB.GetTransactionByID(response.TransactionID)
+		return tx
+	}
+
+	// Incremental authorizations go to gateways that can raise them only
+	tx := authorize(models.TransactionRequest{UserID: userID, Amount: 100, Currency: "USD", IncrementalAuthorization: true})
+	if tx.Status != consts.Authorized || tx.GatewayID != 2 || !tx.IncrementalAuthorization {
+		t.Fatalf("Expected an incremental authorization at gateway 2, got %+v", tx)
+	}
+	if _, err := service.ProcessAuthorization(ctx, models.TransactionRequest{UserID: userID, Amount: 100, Currency: "USD", PreferredGatewayID: 1, IncrementalAuthorization: true}); !errors.Is(err, ErrAuthorizationUnsupported) {
+		t.Errorf("Expected ErrAuthorizationUnsupported for a preferred gateway without increments, got: %v", err)
+	}
+
+	// Increments raise the amount held and the amount captured by default
+	response, err := service.IncrementAuthorization(ctx, models.IncrementAuthorizationRequest{TransactionID: tx.ID, Amount: 50})
+	if err != nil || response.Status != consts.Authorized || response.AuthorizedAmount != 150 || response.AuthorizationExpiresAt == nil {
+		t.Fatalf("Expected 150 authorized, got %+v (%v)", response, err)
+	}
+	if tx, _ = mockDB.GetTransactionByID(tx.ID); tx.Status != consts.Authorized || tx.AuthorizedAmount != 150 || tx.Amount != 150 {
+		t.Errorf("Expected the deposit authorized for 150, got %+v", tx)
+	}
+
+	// Declined increments leave the authorization as it was
+	declined, err := service.IncrementAuthorization(ctx, models.IncrementAuthorizationRequest{TransactionID: tx.ID, Amount: 0.51})
+	if err != nil || declined.Status != consts.Authorized || declined.DeclineCode == "" || declined.AuthorizedAmount != 150 {
+		t.Fatalf("Expected a declined increment, got %+v (%v)", declined, err)
+	}
+	if tx, _ = mockDB.GetTransactionByID(tx.ID); tx.Status != consts.Authorized || tx.AuthorizedAmount != 150 {
+		t.Errorf("Expected the deposit still authorized for 150, got %+v", tx)
+	}
+	if captured, err := service.Capture(ctx, models.CaptureRequest{TransactionID: tx.ID}); err != nil || captured.Status != consts.Completed {
+		t.Fatalf("Expected the incremented authorization captured, got %+v (%v)", captured, err)
+	}
+	if tx, _ = mockDB.GetTransactionByID(tx.ID); tx.Amount != 150 {
+		t.Errorf("Expected 150 captured, got %v", tx.Amount)
+	}
+	if _, err := service.IncrementAuthorization(ctx, models.IncrementAuthorizationRequest{TransactionID: tx.ID, Amount: 10}); !errors.Is(err, ErrNotAuthorized) {
+		t.Errorf("Expected ErrNotAuthorized incrementing a captured deposit, got: %v", err)
+	}
+
+	// Only authorizations that asked for it are incremented, at gateways that support it
+	plain := authorize(models.TransactionRequest{UserID: userID, Amount: 100, Currency: "USD", PreferredGatewayID: 2})
+	if _, err := service.IncrementAuthorization(ctx, models.IncrementAuthorizationRequest{TransactionID: plain.ID, Amount: 10}); !errors.Is(err, ErrIncrementNotRequested) {
+		t.Errorf("Expected ErrIncrementNotRequested, got: %v", err)
+	}
+	other := authorize(models.TransactionRequest{UserID: userID, Amount: 100, Currency: "USD", PreferredGatewayID: 1})
+	if _, err := service.IncrementAuthorization(ctx, models.IncrementAuthorizationRequest{TransactionID: other.ID, Amount: 10}); !errors.Is(err, ErrAuthorizationUnsupported) {
+		t.Errorf("Expected ErrAuthorizationUnsupported, got: %v", err)
+	}
+}
+
+// authorizeOnlyProvider hides the incremental authorization method of the provider it wraps
+type authorizeOnlyProvider struct {
+	gateway.AuthorizationProvider
+}
+
+// TestMultiCapture tests capturing an authorization in parts, each a deposit of its own
+func TestMultiCapture(t *testing.T) {
+	mockDB := db.NewMockDB()
+	userID, err := mockDB.CreateUser(models.User{Username: "alice", Email: "alice@example.com", CountryID: 1, MerchantID: 1})
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+
+	selector := gateway.NewSelector(mockDB)
+	selector.RegisterProvider(authorizeOnlyProvider{gateway.NewMockProvider(1, "PayPal", "application/json", 1.0, 0)})
+	selector.RegisterProvider(gateway.NewMockProvider(2, "Stripe", "application/json", 1.0, 0))
+	service := NewTransactionService(mockDB, selector)
+	ctx := context.Background()
+	notFinal := false
+
+	authorized, err := service.ProcessAuthorization(ctx, models.TransactionRequest{UserID: userID, Amount: 100, Currency: "USD", PreferredGatewayID: 2})
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	authorizationID := authorized.TransactionID
+
+	// Parts that aren't final are deposits linked to the authorization, which stays authorized
+	first, err := service.Capture(ctx, models.CaptureRequest{TransactionID: authorizationID, Amount: 30, FinalCapture: &notFinal})
+	if err != nil || first.Status != consts.Completed || first.TransactionID == authorizationID || first.CaptureOfTransactionID != authorizationID {
+		t.Fatalf("Expected a completed capture linked to the authorization, got %+v (%v)", first, err)
+	}
+	if first.FinalCapture || first.CapturedAmount != 30 || first.RemainingAuthorizedAmount == nil || *first.RemainingAuthorizedAmount != 70 {
+		t.Errorf("Expected 30 captured and 70 remaining, got %+v", first)
+	}
+	part, _ := mockDB.GetTransactionByID(first.TransactionID)
+	if part.Type != consts.Deposit || part.Status != consts.Completed || part.Amount != 30 || part.CaptureOfID != authorizationID || part.UserID != userID {
+		t.Errorf("Expected a completed deposit of 30, got %+v", part)
+	}
+	tx, _ := mockDB.GetTransactionByID(authorizationID)
+	if tx.Status != consts.Authorized || tx.CapturedAmount != 30 || tx.AuthorizedAmount != 100 {
+		t.Errorf("Expected the authorization still open with 30 captured, got %+v", tx)
+	}
+
+	// Parts of the same amount are captured separately, up to what remains
+	second, err := service.Capture(ctx, models.CaptureRequest{TransactionID: authorizationID, Amount: 30, FinalCapture: &notFinal})
+	if err != nil || second.Status != consts.Completed || second.TransactionID == first.TransactionID || *second.RemainingAuthorizedAmount != 40 {
+		t.Fatalf("Expected a second capture leaving 40, got %+v (%v)", second, err)
+	}
+	if _, err := service.Capture(ctx, models.CaptureRequest{TransactionID: authorizationID, Amount: 50}); !errors.Is(err, ErrCaptureExceedsAuthorization) {
+		t.Errorf("Expected ErrCaptureExceedsAuthorization beyond what remains, got: %v", err)
+	}
+
+	// Declined parts are recorded as failed and leave the authorization as it was
+	declined, err := service.Capture(ctx, models.CaptureRequest{TransactionID: authorizationID, Amount: 0.51, FinalCapture: &notFinal})
+	if err != nil || declined.Status != consts.Failed || declined.DeclineCode == "" || declined.CaptureOfTransactionID != authorizationID {
+		t.Fatalf("Expected a declined capture, got %+v (%v)", declined, err)
+	}
+	if tx, _ = mockDB.GetTransactionByID(authorizationID); tx.Status != consts.Authorized || tx.CapturedAmount != 60 {
+		t.Errorf("Expected the authorization still open with 60 captured, got %+v", tx)
+	}
+
+	// The final capture takes what remains by default and completes the authorization
+	final, err := service.Capture(ctx, models.CaptureRequest{TransactionID: authorizationID})
+	if err != nil || final.Status != consts.Completed || final.TransactionID != authorizationID || !final.FinalCapture {
+		t.Fatalf("Expected the final capture on the authorization, got %+v (%v)", final, err)
+	}
+	if final.CapturedAmount != 100 || final.RemainingAuthorizedAmount == nil || *final.RemainingAuthorizedAmount != 0 {
+		t.Errorf("Expected 100 captured and nothing remaining, got %+v", final)
+	}
+	if tx, _ = mockDB.GetTransactionByID(authorizationID); tx.Status != consts.Completed || tx.Amount != 40 || tx.CapturedAmount != 100 {
+		t.Errorf("Expected the authorization completed with a final capture of 40, got %+v", tx)
+	}
+
+	// Capturing all that remains is final whatever the request says
+	whole, err := service.ProcessAuthorization(ctx, models.TransactionRequest{UserID: userID, Amount: 100, Currency: "USD", PreferredGatewayID: 2})
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	if captured, err := service.Capture(ctx, models.CaptureRequest{TransactionID: whole.TransactionID, FinalCapture: &notFinal}); err != nil || captured.TransactionID != whole.TransactionID || !captured.FinalCapture {
+		t.Errorf("Expected the whole authorization captured finally, got %+v (%v)", captured, err)
+	}
+
+	// Gateways that can't capture in parts only capture finally
+	other, err := service.ProcessAuthorization(ctx, models.TransactionRequest{UserID: userID, Amount: 100, Currency: "USD", PreferredGatewayID: 1})
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	if _, err := service.Capture(ctx, models.CaptureRequest{TransactionID: other.TransactionID, Amount: 30, FinalCapture: &notFinal}); !errors.Is(err, ErrAuthorizationUnsupported) {
+		t.Errorf("Expected ErrAuthorizationUnsupported, got: %v", err)
+	}
+	if tx, _ = mockDB.GetTransactionByID(other.TransactionID); tx.Status != consts.Authorized {
+		t.Errorf("Expected the authorization still open, got %s", tx.Status)
+	}
+}
+
+// TestValidateAuthorization tests that only card deposits are authorized
+func TestValidateAuthorization(t *testing.T) {
+	valid := models.TransactionRequest{UserID: 1, Amount: 10, Currency: "USD", ManualCapture: true}
+	if err := validateAuthorization(consts.Deposit, valid); err != nil {
+		t.Errorf("Expected no error, got: %v", err)
+	}
+	if err := validateAuthorization(consts.Withdrawal, valid); !errors.Is(err, ErrInvalidAuthorization) {
+		t.Errorf("Expected ErrInvalidAuthorization for a withdrawal, got: %v", err)
+	}
+
+	invalid := []models.TransactionRequest{valid, valid, valid, valid, valid}
+	invalid[0].PhoneNumber = "+15555550100"
+	invalid[1].MandateID = 1
+	invalid[2].VPA = "alice@okaxis"
+	invalid[3].SCAExemption = consts.SCAExemptionLowValue
+	invalid[4].ManualCapture = false
+	invalid[4].IncrementalAuthorization = true
+	for _, req := range invalid {
+		if err := validateAuthorization(consts.Deposit, req); !errors.Is(err, ErrInvalidAuthorization) {
+			t.Errorf("Expected ErrInvalidAuthorization for %+v, got: %v", req, err)
+		}
+	}
+
+	valid.ManualCapture = false
+	valid.VPA = "alice@okaxis"
+	if err := validateAuthorization(consts.Deposit, valid); err != nil {
+		t.Errorf("Expected deposits captured at once to be left alone, got: %v", err)
+	}
+}
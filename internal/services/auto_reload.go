@@ -0,0 +1,343 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"payment-gateway/internal/consts"
+	"payment-gateway/internal/events"
+	"payment-gateway/internal/gateway"
+	"payment-gateway/internal/models"
+	"time"
+)
+
+var (
+	ErrInvalidAutoReloadRule  = errors.New("invalid auto-reload rule")
+	ErrAutoReloadRuleNotFound = errors.New("auto-reload rule not found")
+)
+
+// AutoReloadLimits are the safety caps auto-reloads are held to
+type AutoReloadLimits struct {
+	MaxAmount   float64 // per reload, in the rule's currency
+	DailyCount  int     // reloads per rule within consts.AutoReloadLimitWindow
+	MaxFailures int     // consecutive failed reloads after which a rule is disabled
+}
+
+// DefaultAutoReloadLimits returns the caps auto-reloads are held to unless configured otherwise
+func DefaultAutoReloadLimits() AutoReloadLimits {
+	return AutoReloadLimits{
+		MaxAmount:   consts.DefaultAutoReloadMaxAmount,
+		DailyCount:  consts.DefaultAutoReloadDailyCount,
+		MaxFailures: consts.DefaultAutoReloadMaxFailures,
+	}
+}
+
+// SetAutoReloadLimits configures the safety caps auto-reloads are held to
+func (s *TransactionService) SetAutoReloadLimits(limits AutoReloadLimits) {
+	s.reloadLimits = limits
+}
+
+// CreateAutoReloadRule records a rule topping up a user's wallet in a currency: whenever its
+// balance drops below the threshold, the scheduler deposits the amount under the user's SEPA
+// mandate. The user must be one of the merchant's, the mandate must be the user's and active, its
+// gateway must take the currency, and the amount must be within the maximum amount of a reload.
+func (s *TransactionService) CreateAutoReloadRule(ctx context.Context, merchantID int, req models.AutoReloadRuleRequest) (*models.AutoReloadRule, error) {
+	user, err := s.merchantUser(req.UserID, merchantID)
+	if err != nil {
+		return nil, err
+	}
+	if err := screeningHold(user); err != nil {
+		return nil, err
+	}
+
+	amount, err := s.roundedAmount(req.Amount, req.Currency)
+	if err != nil {
+		return nil, err
+	}
+	if amount > s.reloadLimits.MaxAmount {
+		return nil, fmt.Errorf("%w: amount must not exceed %v %s", ErrInvalidAutoReloadRule, s.reloadLimits.MaxAmount, req.Currency)
+	}
+
+	mandate, err := s.userMandate(req.MandateID, user.ID)
+	if err != nil {
+		return nil, err
+	}
+	debiter, err := s.directDebitProvider(mandate.GatewayID)
+	if err != nil {
+		return nil, err
+	}
+	if !gateway.SupportsCurrency(debiter, req.Currency) {
+		return nil, fmt.Errorf("%w: %s does not take %s", ErrInvalidAutoReloadRule, debiter.Name(), req.Currency)
+	}
+
+	rule := models.AutoReloadRule{
+		UserID:    user.ID,
+		Currency:  req.Currency,
+		Threshold: s.rounder.Round(req.Threshold, req.Currency),
+		Amount:    amount,
+		MandateID: mandate.ID,
+		Status:    consts.AutoReloadActive,
+		CreatedAt: time.Now(),
+	}
+	if rule.ID, err = s.db.CreateAutoReloadRule(rule); err != nil {
+		return nil, fmt.Errorf("failed to store auto-reload rule: %w", err)
+	}
+	rule.UpdatedAt = rule.CreatedAt
+
+	log.Printf("User %d: created auto-reload rule %d of %v %s below %v", user.ID, rule.ID, rule.Amount, rule.Currency, rule.Threshold)
+	return &rule, nil
+}
+
+// ListAutoReloadRules returns the auto-reload rules of one of a merchant's users, whatever their
+// status
+func (s *TransactionService) ListAutoReloadRules(ctx context.Context, merchantID, userID int) ([]models.AutoReloadRule, error) {
+	if _, err := s.merchantUser(userID, merchantID); err != nil {
+		return nil, err
+	}
+	rules, err := s.db.ListAutoReloadRules(userID)
+	if err != nil {
+		return nil, err
+	}
+	if rules == nil {
+		rules = []models.AutoReloadRule{}
+	}
+	return rules, nil
+}
+
+// CancelAutoReloadRule stops a user's auto-reload rule for good. A reload already submitted is
+// not cancelled.
+func (s *TransactionService) CancelAutoReloadRule(ctx context.Context, merchantID, ruleID, userID int) error {
+	rule, err := s.userAutoReloadRule(merchantID, ruleID, userID)
+	if err != nil {
+		return err
+	}
+
+	rule.Status = consts.AutoReloadCancelled
+	rule.UpdatedAt = time.Now()
+	return s.db.UpdateAutoReloadRule(*rule)
+}
+
+// EnableAutoReloadRule re-enables a rule the safety caps disabled, clearing its failures. Its
+// mandate must still be active.
+func (s *TransactionService) EnableAutoReloadRule(ctx context.Context, merchantID, ruleID, userID int) (*models.AutoReloadRule, error) {
+	rule, err := s.userAutoReloadRule(merchantID, ruleID, userID)
+	if err != nil {
+		return nil, err
+	}
+	if rule.Status != consts.AutoReloadDisabled {
+		return rule, nil
+	}
+	if _, err := s.userMandate(rule.MandateID, userID); err != nil {
+		return nil, err
+	}
+
+	rule.Status = consts.AutoReloadActive
+	rule.DisabledReason = ""
+	rule.ConsecutiveFailures = 0
+	rule.UpdatedAt = time.Now()
+	if err := s.db.UpdateAutoReloadRule(*rule); err != nil {
+		return nil, err
+	}
+	return rule, nil
+}
+
+// userAutoReloadRule returns the rule of one of a merchant's users, or ErrAutoReloadRuleNotFound
+// when it belongs to another user or merchant or was cancelled
+func (s *TransactionService) userAutoReloadRule(merchantID, ruleID, userID int) (*models.AutoReloadRule, error) {
+	if _, err := s.merchantUser(userID, merchantID); err != nil {
+		if errors.Is(err, ErrUserNotFound) {
+			return nil, ErrAutoReloadRuleNotFound
+		}
+		return nil, err
+	}
+	rule, err := s.db.GetAutoReloadRuleByID(ruleID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrAutoReloadRuleNotFound
+		}
+		return nil, fmt.Errorf("failed to get auto-reload rule: %w", err)
+	}
+	if rule.UserID != userID || rule.Status == consts.AutoReloadCancelled {
+		return nil, ErrAutoReloadRuleNotFound
+	}
+	return rule, nil
+}
+
+// RunAutoReloads checks the wallets of active auto-reload rules against their thresholds and
+// deposits the rules' amounts into those below, returning how many reloads were submitted. Rules
+// are claimed in batches, so instances running concurrently check different rules.
+func (s *TransactionService) RunAutoReloads(ctx context.Context) (int, error) {
+	now := time.Now()
+	rules, err := s.db.ClaimAutoReloadRules(now, now.Add(-consts.AutoReloadClaimTTL), consts.AutoReloadBatchSize)
+	if err != nil {
+		return 0, fmt.Errorf("failed to claim auto-reload rules: %w", err)
+	}
+
+	reloaded := 0
+	for _, rule := range rules {
+		submitted, err := s.autoReload(ctx, rule, now)
+		if err != nil {
+			log.Printf("Auto-reload rule %d failed: %v", rule.ID, err)
+			continue
+		}
+		if submitted {
+			reloaded++
+		}
+	}
+	return reloaded, nil
+}
+
+// autoReload settles a rule's last reload and, when the wallet is below the threshold and the
+// safety caps allow it, submits another, reporting whether it did. Only one reload of a rule is
+// in flight at a time: while the last one is pending or processing its deposit is not yet in the
+// balance, so the wallet would look short again.
+func (s *TransactionService) autoReload(ctx context.Context, rule models.AutoReloadRule, now time.Time) (submitted bool, err error) {
+	original := rule
+	defer func() {
+		if rule != original {
+			rule.UpdatedAt = now
+			if saveErr := s.db.UpdateAutoReloadRule(rule); saveErr != nil && err == nil {
+				err = fmt.Errorf("failed to save auto-reload rule: %w", saveErr)
+			}
+		}
+	}()
+
+	if inFlight, err := s.settleAutoReload(&rule); err != nil || inFlight {
+		return false, err
+	}
+	if rule.Status != consts.AutoReloadActive {
+		return false, nil
+	}
+	if rule.LastReloadStatus == consts.Failed && now.Sub(rule.LastReloadAt) < consts.AutoReloadRetryDelay {
+		return false, nil
+	}
+
+	user, err := s.db.GetUserByID(rule.UserID)
+	if err != nil {
+		return false, fmt.Errorf("failed to get user: %w", err)
+	}
+	wallet, err := s.walletBalance(user, rule.Currency)
+	if err != nil {
+		return false, err
+	}
+	if wallet.Balance >= rule.Threshold {
+		return false, nil
+	}
+
+	if rule.WindowStart.IsZero() || now.Sub(rule.WindowStart) >= consts.AutoReloadLimitWindow {
+		rule.WindowStart = now
+		rule.WindowReloads = 0
+	}
+	if rule.WindowReloads >= s.reloadLimits.DailyCount {
+		return false, nil
+	}
+	if rule.Amount > s.reloadLimits.MaxAmount {
+		s.disableAutoReload(&rule, 0, fmt.Sprintf("amount exceeds the maximum auto-reload of %v %s", s.reloadLimits.MaxAmount, rule.Currency))
+		return false, nil
+	}
+
+	rule.LastReloadAt = now
+	rule.WindowReloads++
+	response, err := s.ProcessDeposit(ctx, models.TransactionRequest{UserID: rule.UserID, Amount: rule.Amount, Currency: rule.Currency, MandateID: rule.MandateID})
+	if err != nil {
+		rule.LastTransactionID = 0
+		rule.LastReloadStatus = consts.Failed
+		// A revoked mandate or a user held by screening fail every reload alike
+		permanent := errors.Is(err, ErrMandateNotFound) || errors.Is(err, ErrInvalidMandate) ||
+			errors.Is(err, ErrDirectDebitGatewayNotFound) || errors.Is(err, ErrScreeningHold)
+		s.autoReloadFailed(&rule, 0, err.Error(), permanent)
+		return true, nil
+	}
+
+	rule.LastTransactionID = response.TransactionID
+	rule.LastReloadStatus = response.Status
+	switch response.Status {
+	case consts.Failed:
+		s.autoReloadFailed(&rule, response.TransactionID, response.Message, false)
+	case consts.Completed:
+		rule.ConsecutiveFailures = 0
+	}
+	log.Printf("Auto-reload rule %d: deposited %v %s for user %d in transaction %d (%s)", rule.ID, rule.Amount, rule.Currency, rule.UserID, response.TransactionID, response.Status)
+	return true, nil
+}
+
+// settleAutoReload records the outcome of a rule's last reload once its deposit completed or
+// failed, and reports whether it is still in flight
+func (s *TransactionService) settleAutoReload(rule *models.AutoReloadRule) (inFlight bool, err error) {
+	if rule.LastTransactionID == 0 || rule.LastReloadStatus == consts.Completed || rule.LastReloadStatus == consts.Failed {
+		return false, nil
+	}
+
+	tx, err := s.db.GetTransactionByID(rule.LastTransactionID)
+	if err != nil {
+		return false, fmt.Errorf("failed to get reload %d: %w", rule.LastTransactionID, err)
+	}
+	rule.LastReloadStatus = tx.Status
+
+	switch tx.Status {
+	case consts.Completed:
+		rule.ConsecutiveFailures = 0
+	case consts.Failed:
+		message := tx.ErrorMessage
+		if message == "" {
+			message = tx.DeclineCode
+		}
+		if message == "" {
+			message = "deposit failed"
+		}
+		s.autoReloadFailed(rule, tx.ID, message, false)
+	default:
+		return true, nil
+	}
+	return false, nil
+}
+
+// autoReloadFailed counts a failed reload and notifies the user's merchant. A rule is disabled
+// once failures reach the maximum, or at once when the failure is permanent.
+func (s *TransactionService) autoReloadFailed(rule *models.AutoReloadRule, transactionID int, message string, permanent bool) {
+	rule.ConsecutiveFailures++
+	s.emitAutoReload(events.AutoReloadFailed, *rule, transactionID, message)
+
+	switch {
+	case permanent:
+		s.disableAutoReload(rule, transactionID, message)
+	case rule.ConsecutiveFailures >= s.reloadLimits.MaxFailures:
+		s.disableAutoReload(rule, transactionID, fmt.Sprintf("%d consecutive reloads failed", rule.ConsecutiveFailures))
+	}
+}
+
+// disableAutoReload stops a rule until the user re-enables it and notifies the user's merchant
+func (s *TransactionService) disableAutoReload(rule *models.AutoReloadRule, transactionID int, reason string) {
+	rule.Status = consts.AutoReloadDisabled
+	rule.DisabledReason = reason
+	s.emitAutoReload(events.AutoReloadDisabled, *rule, transactionID, reason)
+	log.Printf("Disabled auto-reload rule %d of user %d: %s", rule.ID, rule.UserID, reason)
+}
+
+// emitAutoReload records the merchant webhook announcing a failed reload or a disabled rule
+func (s *TransactionService) emitAutoReload(eventType string, rule models.AutoReloadRule, transactionID int, message string) {
+	user, err := s.db.GetUserByID(rule.UserID)
+	if err != nil || user.MerchantID == 0 {
+		return
+	}
+
+	evt := events.AutoReloadEvent{Type: eventType, Rule: rule, TransactionID: transactionID, Message: message, OccurredAt: time.Now()}
+	payload, err := json.Marshal(evt)
+	if err != nil {
+		log.Printf("Failed to marshal %s event for auto-reload rule %d: %v", evt.Type, rule.ID, err)
+		return
+	}
+
+	s.enqueue(models.OutboxMessage{
+		Destination:   consts.OutboxMerchantWebhook,
+		EventType:     evt.Type,
+		MerchantID:    user.MerchantID,
+		TransactionID: transactionID,
+		DedupToken:    OutboxDedupToken(evt.Type, rule.ID, rule.LastReloadAt.UTC().Format(time.RFC3339Nano)),
+		ContentType:   "application/json",
+		Payload:       payload,
+	})
+}
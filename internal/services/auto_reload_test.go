@@ -0,0 +1,151 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"payment-gateway/db"
+	"payment-gateway/internal/consts"
+	"payment-gateway/internal/events"
+	"payment-gateway/internal/gateway"
+	"payment-gateway/internal/models"
+	"testing"
+	"time"
+)
+
+// TestAutoReload tests that a wallet below an auto-reload rule's threshold is topped up under the
+// rule's mandate one reload at a time, and that failing reloads are reported and disable the rule
+func TestAutoReload(t *testing.T) {
+	mockDB := db.NewMockDB()
+	userID, err := mockDB.CreateUser(models.User{Username: "erika", Email: "erika@example.com", CountryID: 2, MerchantID: 1, FullName: "Erika Mustermann"})
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+
+	debiter := &mockDirectDebitProvider{MockProvider: gateway.NewMockProvider(3, "SEPA", "application/xml", 1.0, 0)}
+	selector := gateway.NewSelector(mockDB)
+	selector.RegisterProvider(debiter)
+	service := NewTransactionService(mockDB, selector)
+	service.SetAutoReloadLimits(AutoReloadLimits{MaxAmount: 100, DailyCount: 3, MaxFailures: 2})
+	ctx := context.Background()
+
+	mandate, err := service.CreateMandate(ctx, 1, models.MandateRequest{UserID: userID, DebtorName: "Erika Mustermann", IBAN: "DE89370400440532013000"})
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+
+	if _, err := service.CreateAutoReloadRule(ctx, 1, models.AutoReloadRuleRequest{UserID: userID, Currency: "EUR", Threshold: 10, Amount: 500, MandateID: mandate.ID}); !errors.Is(err, ErrInvalidAutoReloadRule) {
+		t.Errorf("Expected ErrInvalidAutoReloadRule above the maximum amount, got: %v", err)
+	}
+	if _, err := service.CreateAutoReloadRule(ctx, 1, models.AutoReloadRuleRequest{UserID: userID, Currency: "USD", Threshold: 10, Amount: 50, MandateID: mandate.ID}); !errors.Is(err, ErrInvalidAutoReloadRule) {
+		t.Errorf("Expected ErrInvalidAutoReloadRule for a currency the gateway does not take, got: %v", err)
+	}
+	if _, err := service.CreateAutoReloadRule(ctx, 1, models.AutoReloadRuleRequest{UserID: 1, Currency: "EUR", Threshold: 10, Amount: 50, MandateID: mandate.ID}); !errors.Is(err, ErrMandateNotFound) {
+		t.Errorf("Expected ErrMandateNotFound for another user's mandate, got: %v", err)
+	}
+	if _, err := service.CreateAutoReloadRule(ctx, 2, models.AutoReloadRuleRequest{UserID: userID, Currency: "EUR", Threshold: 10, Amount: 50, MandateID: mandate.ID}); !errors.Is(err, ErrUserNotFound) {
+		t.Errorf("Expected ErrUserNotFound for another merchant's user, got: %v", err)
+	}
+
+	rule, err := service.CreateAutoReloadRule(ctx, 1, models.AutoReloadRuleRequest{UserID: userID, Currency: "EUR", Threshold: 10, Amount: 50, MandateID: mandate.ID})
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	if rule.Status != consts.AutoReloadActive {
+		t.Fatalf("Expected an active rule, got %+v", rule)
+	}
+
+	// The empty wallet is reloaded, and not again while the debit is processing
+	if reloaded, err := service.RunAutoReloads(ctx); err != nil || reloaded != 1 {
+		t.Fatalf("Expected 1 reload, got %d (%v)", reloaded, err)
+	}
+	stored, _ := mockDB.GetAutoReloadRuleByID(rule.ID)
+	if tx, _ := mockDB.GetTransactionByID(stored.LastTransactionID); tx == nil || tx.MandateID != mandate.ID || tx.Amount != 50 {
+		t.Fatalf("Expected a deposit of 50 under mandate %d, got %+v", mandate.ID, tx)
+	}
+	now := time.Now().Add(time.Minute)
+	if submitted, err := service.autoReload(ctx, *stored, now); err != nil || submitted {
+		t.Errorf("Expected no reload while the last one is processing, got %v (%v)", submitted, err)
+	}
+
+	// A returned debit is reported to the merchant and retried after the retry delay only
+	err = service.HandleCallback(ctx, &models.CallbackData{TransactionID: stored.LastTransactionID, GatewayID: "3", Status: consts.Failed, ReasonCode: "AM04"})
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	stored, _ = mockDB.GetAutoReloadRuleByID(rule.ID)
+	if submitted, err := service.autoReload(ctx, *stored, now); err != nil || submitted {
+		t.Errorf("Expected no reload within the retry delay, got %v (%v)", submitted, err)
+	}
+	stored, _ = mockDB.GetAutoReloadRuleByID(rule.ID)
+	if stored.ConsecutiveFailures != 1 || stored.LastReloadStatus != consts.Failed || stored.Status != consts.AutoReloadActive {
+		t.Fatalf("Expected one failure on an active rule, got %+v", stored)
+	}
+	messages := autoReloadWebhooks(mockDB)
+	if len(messages) != 1 || messages[0].EventType != events.AutoReloadFailed || messages[0].MerchantID != 1 {
+		t.Fatalf("Expected an auto_reload.failed webhook to merchant 1, got %+v", messages)
+	}
+
+	// A second failure reaches the maximum and disables the rule
+	now = now.Add(consts.AutoReloadRetryDelay)
+	if submitted, err := service.autoReload(ctx, *stored, now); err != nil || !submitted {
+		t.Fatalf("Expected a retry after the delay, got %v (%v)", submitted, err)
+	}
+	stored, _ = mockDB.GetAutoReloadRuleByID(rule.ID)
+	if err := service.HandleCallback(ctx, &models.CallbackData{TransactionID: stored.LastTransactionID, GatewayID: "3", Status: consts.Failed, ReasonCode: "AM04"}); err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	if _, err := service.autoReload(ctx, *stored, now.Add(time.Minute)); err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	stored, _ = mockDB.GetAutoReloadRuleByID(rule.ID)
+	if stored.Status != consts.AutoReloadDisabled || stored.DisabledReason == "" {
+		t.Fatalf("Expected the rule disabled after 2 failures, got %+v", stored)
+	}
+	messages = autoReloadWebhooks(mockDB)
+	if len(messages) != 3 || messages[2].EventType != events.AutoReloadDisabled {
+		t.Errorf("Expected a second failure and an auto_reload.disabled webhook, got %+v", messages)
+	}
+
+	// Re-enabling clears the failures; cancelling hides the rule from the user's actions
+	enabled, err := service.EnableAutoReloadRule(ctx, 1, rule.ID, userID)
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	if enabled.Status != consts.AutoReloadActive || enabled.ConsecutiveFailures != 0 || enabled.DisabledReason != "" {
+		t.Errorf("Expected an active rule without failures, got %+v", enabled)
+	}
+	if err := service.CancelAutoReloadRule(ctx, 1, rule.ID, 1); !errors.Is(err, ErrAutoReloadRuleNotFound) {
+		t.Errorf("Expected ErrAutoReloadRuleNotFound for another user, got: %v", err)
+	}
+	if err := service.CancelAutoReloadRule(ctx, 2, rule.ID, userID); !errors.Is(err, ErrAutoReloadRuleNotFound) {
+		t.Errorf("Expected ErrAutoReloadRuleNotFound for another merchant, got: %v", err)
+	}
+	if _, err := service.ListAutoReloadRules(ctx, 2, userID); !errors.Is(err, ErrUserNotFound) {
+		t.Errorf("Expected ErrUserNotFound listing another merchant's user's rules, got: %v", err)
+	}
+	if err := service.CancelAutoReloadRule(ctx, 1, rule.ID, userID); err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	if _, err := service.EnableAutoReloadRule(ctx, 1, rule.ID, userID); !errors.Is(err, ErrAutoReloadRuleNotFound) {
+		t.Errorf("Expected ErrAutoReloadRuleNotFound for a cancelled rule, got: %v", err)
+	}
+	stored, _ = mockDB.GetAutoReloadRuleByID(rule.ID)
+	if submitted, err := service.autoReload(ctx, *stored, now.Add(2*consts.AutoReloadRetryDelay)); err != nil || submitted {
+		t.Errorf("Expected no reload of a cancelled rule, got %v (%v)", submitted, err)
+	}
+	if rules, _ := service.ListAutoReloadRules(ctx, 1, userID); len(rules) != 1 || rules[0].Status != consts.AutoReloadCancelled {
+		t.Errorf("Expected the cancelled rule listed, got %+v", rules)
+	}
+}
+
+// autoReloadWebhooks returns the pending merchant webhooks about auto-reload rules
+func autoReloadWebhooks(mockDB *db.MockDB) []models.OutboxMessage {
+	messages, _ := mockDB.GetPendingOutboxMessages(consts.OutboxMerchantWebhook, time.Now(), consts.OutboxBatchSize)
+	var reloads []models.OutboxMessage
+	for _, message := range messages {
+		if message.EventType == events.AutoReloadFailed || message.EventType == events.AutoReloadDisabled {
+			reloads = append(reloads, message)
+		}
+	}
+	return reloads
+}
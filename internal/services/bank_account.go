@@ -0,0 +1,339 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"payment-gateway/internal/consts"
+	"payment-gateway/internal/gateway"
+	"payment-gateway/internal/models"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+var (
+	ErrInvalidBankPayout          = errors.New("invalid bank payout")
+	ErrBankAccountNotFound        = errors.New("bank account not found")
+	ErrBankAccountHolderMismatch  = errors.New("no linked bank account is held in the user's name")
+	ErrBankPayoutGatewayNotFound  = errors.New("bank payout gateway not found")
+	ErrBankAccountConsentNotFound = errors.New("bank account consent not found")
+)
+
+// SetBankAccountRedirectURI configures where banks send users back to after they authorised or
+// rejected linking their bank accounts, i.e. the bank account callback endpoint
+func (s *TransactionService) SetBankAccountRedirectURI(uri string) {
+	s.bankAccountRedirectURI = uri
+}
+
+// CreateBankAccountLink starts linking a user's bank accounts through a bank payout gateway, or
+// the only one registered when gatewayID is 0. It returns the consent, whose authorisation URL
+// the user is sent to.
+func (s *TransactionService) CreateBankAccountLink(ctx context.Context, userID, gatewayID int) (*models.BankAccountConsent, error) {
+	user, err := s.db.GetUserByID(userID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrUserNotFound
+		}
+		return nil, fmt.Errorf("failed to get user: %w", err)
+	}
+	livemode, err := s.livemode(user)
+	if err != nil {
+		return nil, err
+	}
+
+	payer, err := s.bankPayoutProvider(gatewayID)
+	if err != nil {
+		return nil, err
+	}
+
+	state, err := newConsentState()
+	if err != nil {
+		return nil, err
+	}
+	link, err := payer.CreateAccountLink(ctx, livemode, s.bankAccountRedirectURI, state)
+	if err != nil {
+		return nil, err
+	}
+
+	consent := models.BankAccountConsent{
+		UserID:           user.ID,
+		GatewayID:        atoi(payer.ID()),
+		Status:           consts.ConsentAwaitingAuthorisation,
+		StateHash:        hashConsentState(state),
+		AuthorisationURL: link.AuthorisationURL,
+		Livemode:         livemode,
+		ExpiresAt:        link.ExpiresAt,
+		CreatedAt:        time.Now(),
+	}
+	if consent.ID, err = s.db.CreateBankAccountConsent(consent); err != nil {
+		return nil, fmt.Errorf("failed to store bank account consent: %w", err)
+	}
+	consent.UpdatedAt = consent.CreatedAt
+
+	log.Printf("User %d: created bank account consent %d at %s", user.ID, consent.ID, payer.Name())
+	return &consent, nil
+}
+
+// HandleBankAccountCallback links a user's bank accounts when the bank sends them back with the
+// consent's state and either an authorisation code, exchanged for access to their accounts, or an
+// error when they rejected it. A consent is only used once. Accounts are only linked when held in
+// the user's full name, if they have one on file. It returns the accounts linked.
+func (s *TransactionService) HandleBankAccountCallback(ctx context.Context, state, code, errorCode string) ([]models.BankAccount, error) {
+	if state == "" {
+		return nil, fmt.Errorf("%w: state is required", ErrInvalidConsentCallback)
+	}
+	if code == "" && errorCode == "" {
+		return nil, fmt.Errorf("%w: code or error is required", ErrInvalidConsentCallback)
+	}
+
+	consent, err := s.db.GetBankAccountConsentByState(hashConsentState(state))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrBankAccountConsentNotFound
+		}
+		return nil, err
+	}
+	if consent.Status != consts.ConsentAwaitingAuthorisation {
+		return nil, ErrConsentUsed
+	}
+
+	switch {
+	case errorCode != "":
+		if err := s.claimBankAccountConsent(*consent, consts.ConsentRejected); err != nil {
+			return nil, err
+		}
+		return nil, fmt.Errorf("%w: linking was rejected at the bank: %s", ErrInvalidConsentCallback, errorCode)
+	case time.Now().After(consent.ExpiresAt):
+		if err := s.claimBankAccountConsent(*consent, consts.ConsentExpired); err != nil {
+			return nil, err
+		}
+		return nil, fmt.Errorf("%w: consent expired before it was authorised", ErrInvalidConsentCallback)
+	}
+
+	if err := s.claimBankAccountConsent(*consent, consts.ConsentAuthorised); err != nil {
+		return nil, err
+	}
+	return s.linkBankAccounts(ctx, *consent, code)
+}
+
+// linkBankAccounts exchanges an authorised consent's code for access to the user's accounts,
+// stores the tokens it grants and links the accounts held in the user's name
+func (s *TransactionService) linkBankAccounts(ctx context.Context, consent models.BankAccountConsent, code string) ([]models.BankAccount, error) {
+	payer, err := s.bankPayoutProvider(consent.GatewayID)
+	if err != nil {
+		return nil, err
+	}
+	user, err := s.db.GetUserByID(consent.UserID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get user: %w", err)
+	}
+
+	access, err := payer.LinkAccounts(ctx, consent.Livemode, code, s.bankAccountRedirectURI)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.db.StoreBankAccountConsentTokens(consent.ID, access.AccessToken, access.RefreshToken, access.TokenExpiresAt); err != nil {
+		return nil, fmt.Errorf("failed to store bank account consent tokens: %w", err)
+	}
+
+	linked := []models.BankAccount{}
+	now := time.Now()
+	for _, account := range access.Accounts {
+		if user.FullName != "" && !holderNameMatches(user.FullName, account.HolderName) {
+			log.Printf("User %d: not linking a %s account held by another name", user.ID, account.BankName)
+			continue
+		}
+
+		account.UserID = user.ID
+		account.GatewayID = consent.GatewayID
+		account.ConsentID = consent.ID
+		account.Last4 = lastDigits(account)
+		account.Status = consts.BankAccountActive
+		account.CreatedAt = now
+		if account.ID, err = s.db.SaveBankAccount(account); err != nil {
+			return nil, fmt.Errorf("failed to store bank account: %w", err)
+		}
+		account.UpdatedAt = now
+		linked = append(linked, account)
+	}
+	if len(access.Accounts) > 0 && len(linked) == 0 {
+		return nil, ErrBankAccountHolderMismatch
+	}
+
+	log.Printf("User %d: linked %d bank accounts with consent %d", user.ID, len(linked), consent.ID)
+	return linked, nil
+}
+
+// claimBankAccountConsent moves a bank account consent awaiting authorisation to status, failing
+// with ErrConsentUsed when a concurrent callback got there first
+func (s *TransactionService) claimBankAccountConsent(consent models.BankAccountConsent, status string) error {
+	if err := s.db.UpdateBankAccountConsentStatus(consent.ID, status, time.Now()); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrConsentUsed
+		}
+		return fmt.Errorf("failed to update bank account consent: %w", err)
+	}
+	return nil
+}
+
+// ListBankAccounts returns the bank accounts a user linked, active or revoked
+func (s *TransactionService) ListBankAccounts(ctx context.Context, userID int) ([]models.BankAccount, error) {
+	accounts, err := s.db.ListBankAccounts(userID)
+	if err != nil {
+		return nil, err
+	}
+	if accounts == nil {
+		accounts = []models.BankAccount{}
+	}
+	return accounts, nil
+}
+
+// RevokeBankAccount stops paying withdrawals out to a user's linked bank account. Withdrawals
+// already scheduled to it fail when their payout runs.
+func (s *TransactionService) RevokeBankAccount(ctx context.Context, accountID, userID int) error {
+	account, err := s.userBankAccount(accountID, userID)
+	if err != nil {
+		return err
+	}
+	if err := s.db.RevokeBankAccount(account.ID, time.Now()); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrBankAccountNotFound
+		}
+		return fmt.Errorf("failed to revoke bank account: %w", err)
+	}
+	return nil
+}
+
+// userBankAccount returns a user's active linked bank account, or ErrBankAccountNotFound when it
+// belongs to another user or was revoked
+func (s *TransactionService) userBankAccount(accountID, userID int) (*models.BankAccount, error) {
+	account, err := s.db.GetBankAccountByID(accountID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrBankAccountNotFound
+		}
+		return nil, fmt.Errorf("failed to get bank account: %w", err)
+	}
+	if account.UserID != userID || account.Status != consts.BankAccountActive {
+		return nil, ErrBankAccountNotFound
+	}
+	return account, nil
+}
+
+// bankPayoutProvider returns the bank payout gateway with an ID, or the only one registered for 0
+func (s *TransactionService) bankPayoutProvider(gatewayID int) (gateway.BankPayoutProvider, error) {
+	var found []gateway.BankPayoutProvider
+	for _, provider := range s.gatewaySelector.Providers() {
+		payer, ok := provider.(gateway.BankPayoutProvider)
+		if ok && (gatewayID == 0 || provider.ID() == strconv.Itoa(gatewayID)) {
+			found = append(found, payer)
+		}
+	}
+	switch {
+	case len(found) == 0:
+		return nil, ErrBankPayoutGatewayNotFound
+	case len(found) > 1:
+		return nil, fmt.Errorf("%w: several gateways pay out to bank accounts; name one with gateway_id", ErrInvalidBankPayout)
+	}
+	return found[0], nil
+}
+
+// validateBankPayout checks that a bank account is only given for withdrawals without another
+// payout destination
+func validateBankPayout(txType string, req models.TransactionRequest) error {
+	if req.BankAccountID == 0 {
+		return nil
+	}
+	if txType != consts.Withdrawal {
+		return fmt.Errorf("%w: bank accounts apply to withdrawals only", ErrInvalidBankPayout)
+	}
+	if req.PhoneNumber != "" || req.BankID != "" {
+		return fmt.Errorf("%w: withdrawals to a bank account cannot name a wallet or bank", ErrInvalidBankPayout)
+	}
+	return nil
+}
+
+// applyBankPayout routes a withdrawal to a linked bank account to the gateway the account was
+// linked through. The account must be the user's, active and in the withdrawal's currency, and a
+// preferred gateway in the request must be that gateway.
+func (s *TransactionService) applyBankPayout(opts *gateway.SelectionOptions, user *models.User, req models.TransactionRequest) error {
+	if req.BankAccountID == 0 {
+		return nil
+	}
+
+	account, err := s.userBankAccount(req.BankAccountID, user.ID)
+	if err != nil {
+		return err
+	}
+	if account.Currency != req.Currency {
+		return fmt.Errorf("%w: bank account %d holds %s, not %s", ErrInvalidBankPayout, account.ID, account.Currency, req.Currency)
+	}
+
+	gatewayID := strconv.Itoa(account.GatewayID)
+	if opts.PreferredGatewayID != "" && opts.PreferredGatewayID != gatewayID {
+		return fmt.Errorf("%w: bank account %d is paid out by gateway %s, not the preferred gateway %s", ErrInvalidBankPayout, account.ID, gatewayID, opts.PreferredGatewayID)
+	}
+	opts.PreferredGatewayID = gatewayID
+	opts.BankPayout = true
+	return nil
+}
+
+// withdraw pays a withdrawal out through its gateway, to its linked bank account when the
+// gateway pays out to linked accounts, or to its mandate's account when it takes mandates
+func (s *TransactionService) withdraw(ctx context.Context, provider gateway.Provider, tx models.Transaction) (*models.TransactionResponse, error) {
+	if debiter, ok := provider.(gateway.DirectDebitProvider); ok {
+		return s.debit(ctx, debiter, tx)
+	}
+
+	payer, ok := provider.(gateway.BankPayoutProvider)
+	if !ok {
+		return provider.ProcessWithdrawal(ctx, tx)
+	}
+
+	// The account is read again as it may have been revoked since a scheduled withdrawal was created
+	account, err := s.userBankAccount(tx.BankAccountID, tx.UserID)
+	if err != nil {
+		return nil, fmt.Errorf("cannot pay out to bank account %d: %w", tx.BankAccountID, err)
+	}
+	return payer.PayOut(ctx, tx, *account)
+}
+
+// holderNameMatches reports whether a bank account is held in a user's name: every word of the
+// user's full name must appear in the holder name, in any order, so titles banks add such as
+// "Mr" do not prevent a match
+func holderNameMatches(fullName, holderName string) bool {
+	words := make(map[string]int)
+	for _, word := range nameWords(holderName) {
+		words[word]++
+	}
+	for _, word := range nameWords(fullName) {
+		if words[word] == 0 {
+			return false
+		}
+		words[word]--
+	}
+	return true
+}
+
+// nameWords lowercases a name and splits it into words, ignoring punctuation
+func nameWords(name string) []string {
+	return strings.FieldsFunc(strings.ToLower(name), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+// lastDigits returns the last four characters of a bank account's number, or of its IBAN
+func lastDigits(account models.BankAccount) string {
+	number := account.AccountNumber
+	if number == "" {
+		number = account.IBAN
+	}
+	if len(number) > 4 {
+		number = number[len(number)-4:]
+	}
+	return number
+}
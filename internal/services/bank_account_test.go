@@ -0,0 +1,118 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"net/url"
+	"payment-gateway/db"
+	"payment-gateway/internal/consts"
+	"payment-gateway/internal/gateway"
+	"payment-gateway/internal/models"
+	"testing"
+	"time"
+)
+
+// mockBankPayoutProvider links the accounts it was given and records the accounts it paid out to
+type mockBankPayoutProvider struct {
+	*gateway.MockProvider
+	accounts []models.BankAccount
+	paidTo   []models.BankAccount
+}
+
+func (p *mockBankPayoutProvider) CreateAccountLink(ctx context.Context, livemode bool, redirectURI, state string) (*gateway.AccountLink, error) {
+	return &gateway.AccountLink{
+		AuthorisationURL: "https://bank.example.com/authorise?state=" + url.QueryEscape(state),
+		ExpiresAt:        time.Now().Add(15 * time.Minute),
+	}, nil
+}
+
+func (p *mockBankPayoutProvider) LinkAccounts(ctx context.Context, livemode bool, authorisationCode, redirectURI string) (*gateway.AccountAccess, error) {
+	return &gateway.AccountAccess{AccessToken: "access", RefreshToken: "refresh", TokenExpiresAt: time.Now().Add(time.Hour), Accounts: p.accounts}, nil
+}
+
+func (p *mockBankPayoutProvider) PayOut(ctx context.Context, transaction models.Transaction, account models.BankAccount) (*models.TransactionResponse, error) {
+	p.paidTo = append(p.paidTo, account)
+	return &models.TransactionResponse{TransactionID: transaction.ID, Status: consts.Processing, GatewayReference: "payout-1"}, nil
+}
+
+// TestBankAccountPayout tests that withdrawals pay out to bank accounts linked in the user's name
+func TestBankAccountPayout(t *testing.T) {
+	mockDB := db.NewMockDB()
+	userID, err := mockDB.CreateUser(models.User{Username: "jane", Email: "jane@example.com", CountryID: 2, MerchantID: 1, FullName: "Jane Doe"})
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+
+	payer := &mockBankPayoutProvider{
+		MockProvider: gateway.NewMockProvider(3, "TrueLayer", "application/json", 1.0, 0),
+		accounts: []models.BankAccount{
+			{ExternalID: "acc-1", BankName: "Lloyds", HolderName: "MRS JANE DOE", Currency: "GBP", SortCode: "012131", AccountNumber: "12345678"},
+			{ExternalID: "acc-2", BankName: "Lloyds", HolderName: "JOHN DOE", Currency: "GBP", SortCode: "012131", AccountNumber: "87654321"},
+		},
+	}
+	selector := gateway.NewSelector(mockDB)
+	selector.RegisterProvider(gateway.NewMockProvider(2, "Stripe", "application/json", 1.0, 0))
+	selector.RegisterProvider(payer)
+	service := NewTransactionService(mockDB, selector)
+	service.SetBankAccountRedirectURI("https://payments.example.com/bank-accounts/callback")
+	ctx := context.Background()
+
+	consent, err := service.CreateBankAccountLink(ctx, userID, 0)
+	if err != nil || consent.GatewayID != 3 || consent.Status != consts.ConsentAwaitingAuthorisation {
+		t.Fatalf("Expected a consent awaiting authorisation at gateway 3, got %+v, %v", consent, err)
+	}
+	authorisation, _ := url.Parse(consent.AuthorisationURL)
+	state := authorisation.Query().Get("state")
+
+	linked, err := service.HandleBankAccountCallback(ctx, state, "auth-code", "")
+	if err != nil || len(linked) != 1 || linked[0].ExternalID != "acc-1" || linked[0].Last4 != "5678" || linked[0].Status != consts.BankAccountActive {
+		t.Fatalf("Expected only the account held in the user's name linked, got %+v, %v", linked, err)
+	}
+	if _, err := service.HandleBankAccountCallback(ctx, state, "auth-code", ""); !errors.Is(err, ErrConsentUsed) {
+		t.Errorf("Expected a replayed callback to be refused, got: %v", err)
+	}
+	account := linked[0]
+
+	// Withdrawals are only made from a wallet that covers them
+	if _, err := mockDB.CreateTransaction(models.Transaction{UserID: userID, Amount: 100, Currency: "GBP", Type: consts.Deposit, Status: consts.Completed, GatewayID: 2}); err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	response, err := service.ProcessWithdrawal(ctx, models.TransactionRequest{UserID: userID, Amount: 25, Currency: "GBP", BankAccountID: account.ID})
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	if tx, _ := mockDB.GetTransactionByID(response.TransactionID); tx.GatewayID != 3 || tx.BankAccountID != account.ID {
+		t.Errorf("Expected the withdrawal paid out by gateway 3 to account %d, got %+v", account.ID, tx)
+	}
+	if len(payer.paidTo) != 1 || payer.paidTo[0].AccountNumber != "12345678" {
+		t.Errorf("Expected a payout to the linked account, got %+v", payer.paidTo)
+	}
+
+	// Withdrawals without a linked account and deposits never reach the payout gateway
+	response, err = service.ProcessWithdrawal(ctx, models.TransactionRequest{UserID: userID, Amount: 25, Currency: "GBP"})
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	if tx, _ := mockDB.GetTransactionByID(response.TransactionID); tx.GatewayID != 2 {
+		t.Errorf("Expected the withdrawal routed to gateway 2, got %d", tx.GatewayID)
+	}
+	if _, err := service.ProcessDeposit(ctx, models.TransactionRequest{UserID: userID, Amount: 25, Currency: "GBP", BankAccountID: account.ID}); !errors.Is(err, ErrInvalidBankPayout) {
+		t.Errorf("Expected ErrInvalidBankPayout for a deposit, got: %v", err)
+	}
+	if _, err := service.ProcessWithdrawal(ctx, models.TransactionRequest{UserID: userID, Amount: 25, Currency: "EUR", BankAccountID: account.ID}); !errors.Is(err, ErrInvalidBankPayout) {
+		t.Errorf("Expected ErrInvalidBankPayout for another currency, got: %v", err)
+	}
+	if _, err := service.ProcessWithdrawal(ctx, models.TransactionRequest{UserID: 1, Amount: 25, Currency: "GBP", BankAccountID: account.ID}); !errors.Is(err, ErrBankAccountNotFound) {
+		t.Errorf("Expected ErrBankAccountNotFound for another user's account, got: %v", err)
+	}
+
+	if err := service.RevokeBankAccount(ctx, account.ID, userID); err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	if _, err := service.ProcessWithdrawal(ctx, models.TransactionRequest{UserID: userID, Amount: 25, Currency: "GBP", BankAccountID: account.ID}); !errors.Is(err, ErrBankAccountNotFound) {
+		t.Errorf("Expected ErrBankAccountNotFound for a revoked account, got: %v", err)
+	}
+	if accounts, _ := service.ListBankAccounts(ctx, userID); len(accounts) != 1 || accounts[0].Status != consts.BankAccountRevoked {
+		t.Errorf("Expected the revoked account listed, got %+v", accounts)
+	}
+}
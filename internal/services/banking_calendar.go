@@ -0,0 +1,160 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"payment-gateway/db"
+	"payment-gateway/internal/consts"
+	"payment-gateway/internal/geo"
+	"payment-gateway/internal/models"
+	"time"
+)
+
+// dateLayout is the format of calendar dates
+const dateLayout = "2006-01-02"
+
+var (
+	ErrInvalidBankHoliday  = errors.New("invalid bank holiday")
+	ErrBankHolidayNotFound = errors.New("bank holiday not found")
+)
+
+// BankingCalendar knows which days banks in each country are open: weekdays other than the
+// country's bank holidays. Dates are local to the country.
+type BankingCalendar struct {
+	db db.DBInterface
+}
+
+// NewBankingCalendar creates a new banking calendar
+func NewBankingCalendar(dbInterface db.DBInterface) *BankingCalendar {
+	return &BankingCalendar{db: dbInterface}
+}
+
+// bankingDays holds the bank holiday dates of a country over a loaded range
+type bankingDays map[string]bool
+
+// isBankingDay reports whether a day is a weekday that is not a bank holiday
+func (h bankingDays) isBankingDay(day time.Time) bool {
+	if day.Weekday() == time.Saturday || day.Weekday() == time.Sunday {
+		return false
+	}
+	return !h[day.Format(dateLayout)]
+}
+
+// Holidays returns a country's bank holidays in a year
+func (c *BankingCalendar) Holidays(ctx context.Context, countryCode string, year int) ([]models.BankHoliday, error) {
+	country, err := c.country(countryCode)
+	if err != nil {
+		return nil, err
+	}
+
+	holidays, err := c.db.GetBankHolidays(country.ID, fmt.Sprintf("%04d-01-01", year), fmt.Sprintf("%04d-12-31", year))
+	if err != nil {
+		return nil, err
+	}
+	if holidays == nil {
+		holidays = []models.BankHoliday{}
+	}
+	return holidays, nil
+}
+
+// AddHoliday adds a bank holiday to a country's calendar. Withdrawals already scheduled for the
+// day are deferred to the next banking day when their payout runs.
+func (c *BankingCalendar) AddHoliday(ctx context.Context, countryCode string, req models.BankHolidayRequest) (*models.BankHoliday, error) {
+	if _, err := time.Parse(dateLayout, req.Date); err != nil {
+		return nil, fmt.Errorf("%w: date %q is not YYYY-MM-DD", ErrInvalidBankHoliday, req.Date)
+	}
+
+	country, err := c.country(countryCode)
+	if err != nil {
+		return nil, err
+	}
+
+	holiday := models.BankHoliday{CountryID: country.ID, Date: req.Date, Name: req.Name}
+	if err := c.db.CreateBankHoliday(holiday); err != nil {
+		return nil, err
+	}
+	return &holiday, nil
+}
+
+// RemoveHoliday removes a bank holiday from a country's calendar
+func (c *BankingCalendar) RemoveHoliday(ctx context.Context, countryCode, date string) error {
+	if _, err := time.Parse(dateLayout, date); err != nil {
+		return fmt.Errorf("%w: date %q is not YYYY-MM-DD", ErrInvalidBankHoliday, date)
+	}
+
+	country, err := c.country(countryCode)
+	if err != nil {
+		return err
+	}
+
+	if err := c.db.DeleteBankHoliday(country.ID, date); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrBankHolidayNotFound
+		}
+		return err
+	}
+	return nil
+}
+
+// days loads the banking days of a country from a day through the lookahead that follows it
+func (c *BankingCalendar) days(countryID int, from time.Time) (bankingDays, error) {
+	holidays, err := c.db.GetBankHolidays(countryID, from.Format(dateLayout), from.AddDate(0, 0, consts.BankingCalendarLookaheadDays).Format(dateLayout))
+	if err != nil {
+		return nil, fmt.Errorf("failed to get bank holidays: %w", err)
+	}
+
+	days := make(bankingDays, len(holidays))
+	for _, holiday := range holidays {
+		days[holiday.Date] = true
+	}
+	return days, nil
+}
+
+// SettlementDate returns the banking day a bank transfer submitted at the given time is expected
+// to settle in a country: consts.BankTransferSettlementDays banking days after submission, counted
+// from the next banking day when it is submitted on a non-banking day.
+func (c *BankingCalendar) SettlementDate(country *models.Country, submittedAt time.Time) (string, error) {
+	local := submittedAt.In(countryLocation(country))
+	day := time.Date(local.Year(), local.Month(), local.Day(), 0, 0, 0, 0, local.Location())
+
+	days, err := c.days(country.ID, day)
+	if err != nil {
+		return "", err
+	}
+
+	for !days.isBankingDay(day) {
+		day = day.AddDate(0, 0, 1)
+	}
+	for added := 0; added < consts.BankTransferSettlementDays; {
+		day = day.AddDate(0, 0, 1)
+		if days.isBankingDay(day) {
+			added++
+		}
+	}
+	return day.Format(dateLayout), nil
+}
+
+// country looks up a country by code for the calendar endpoints
+func (c *BankingCalendar) country(code string) (*models.Country, error) {
+	country, err := c.db.GetCountryByCode(geo.NormalizeCountryCode(code))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, fmt.Errorf("%w: %s", ErrUnsupportedCountry, code)
+		}
+		return nil, err
+	}
+	return country, nil
+}
+
+// countryLocation returns the timezone of a country, or UTC when it has none that loads
+func countryLocation(country *models.Country) *time.Location {
+	loc, err := time.LoadLocation(country.Timezone)
+	if err != nil {
+		log.Printf("Unknown timezone %q of country %s, using UTC: %v", country.Timezone, country.Code, err)
+		return time.UTC
+	}
+	return loc
+}
@@ -0,0 +1,76 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"payment-gateway/db"
+	"payment-gateway/internal/models"
+	"testing"
+	"time"
+)
+
+// TestSettlementDate tests that settlement skips weekends and bank holidays in the country's timezone
+func TestSettlementDate(t *testing.T) {
+	mockDB := db.NewMockDB()
+	calendar := NewBankingCalendar(mockDB)
+	us, _ := mockDB.GetCountryByCode("US")
+	gb, _ := mockDB.GetCountryByCode("GB")
+
+	tests := []struct {
+		name        string
+		country     *models.Country
+		submittedAt time.Time
+		want        string
+	}{
+		{"next banking day", us, time.Date(2026, 11, 24, 15, 0, 0, 0, time.UTC), "2026-11-25"},
+		{"over a holiday", us, time.Date(2026, 11, 25, 15, 0, 0, 0, time.UTC), "2026-11-27"},
+		{"over a holiday and weekend", us, time.Date(2026, 12, 24, 15, 0, 0, 0, time.UTC), "2026-12-28"},
+		{"submitted on a weekend", us, time.Date(2026, 12, 26, 15, 0, 0, 0, time.UTC), "2026-12-29"},
+		// 03:00 UTC on Thursday is still Wednesday evening in New York
+		{"local date", us, time.Date(2026, 11, 26, 3, 0, 0, 0, time.UTC), "2026-11-27"},
+		{"country's own holidays", gb, time.Date(2026, 12, 24, 15, 0, 0, 0, time.UTC), "2026-12-29"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := calendar.SettlementDate(tt.country, tt.submittedAt)
+			if err != nil {
+				t.Fatalf("Expected no error, got: %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("Expected settlement on %s, got %s", tt.want, got)
+			}
+		})
+	}
+}
+
+// TestBankHolidays tests managing a country's bank holidays
+func TestBankHolidays(t *testing.T) {
+	calendar := NewBankingCalendar(db.NewMockDB())
+	ctx := context.Background()
+
+	if _, err := calendar.AddHoliday(ctx, "US", models.BankHolidayRequest{Date: "26/11/2026", Name: "Thanksgiving"}); !errors.Is(err, ErrInvalidBankHoliday) {
+		t.Errorf("Expected ErrInvalidBankHoliday, got: %v", err)
+	}
+	if _, err := calendar.AddHoliday(ctx, "BR", models.BankHolidayRequest{Date: "2026-11-20", Name: "Consciência Negra"}); !errors.Is(err, ErrUnsupportedCountry) {
+		t.Errorf("Expected ErrUnsupportedCountry, got: %v", err)
+	}
+	if _, err := calendar.AddHoliday(ctx, "de", models.BankHolidayRequest{Date: "2026-10-03", Name: "Tag der Deutschen Einheit"}); err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+
+	holidays, err := calendar.Holidays(ctx, "DE", 2026)
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	if len(holidays) != 1 || holidays[0].Date != "2026-10-03" {
+		t.Errorf("Expected the added holiday, got %+v", holidays)
+	}
+
+	if err := calendar.RemoveHoliday(ctx, "DE", "2026-10-03"); err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	if err := calendar.RemoveHoliday(ctx, "DE", "2026-10-03"); !errors.Is(err, ErrBankHolidayNotFound) {
+		t.Errorf("Expected ErrBankHolidayNotFound, got: %v", err)
+	}
+}
@@ -0,0 +1,368 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"payment-gateway/internal/consts"
+	"payment-gateway/internal/models"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// withdrawalCSVColumns lists the columns expected in a bulk withdrawal CSV header
+var withdrawalCSVColumns = []string{"user_id", "amount", "currency", "beneficiary"}
+
+// ProcessDepositBatch validates and processes a batch of deposit requests concurrently.
+// Each item is validated individually; invalid items are reported as failed without
+// affecting the rest of the batch. The resulting batch is persisted for later polling.
+func (s *TransactionService) ProcessDepositBatch(ctx context.Context, reqs []models.TransactionRequest) (*models.Batch, error) {
+	if len(reqs) == 0 {
+		return nil, fmt.Errorf("batch must contain at least one transaction")
+	}
+	if len(reqs) > consts.MaxBatchSize {
+		return nil, fmt.Errorf("batch exceeds maximum size of %d transactions", consts.MaxBatchSize)
+	}
+
+	items := make([]models.BatchItemResult, len(reqs))
+	for i, req := range reqs {
+		items[i] = newBatchItem(i, req)
+		if err := ValidateTransactionRequest(req); err != nil {
+			items[i].Status = consts.Failed
+			items[i].Error = err.Error()
+		}
+	}
+
+	s.runBatchItems(ctx, items, reqs, s.ProcessDeposit, nil)
+
+	batch := models.Batch{
+		Type:      consts.Deposit,
+		Items:     items,
+		CreatedAt: time.Now(),
+	}
+	summarizeBatch(&batch)
+
+	batchID, err := s.db.CreateBatch(batch)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create batch: %w", err)
+	}
+	batch.ID = batchID
+
+	return &batch, nil
+}
+
+// SubmitWithdrawalCSV creates a withdrawal batch from an uploaded CSV file. The file is
+// parsed, validated and processed asynchronously; the returned batch is in the processing
+// state and carries an operation ID that can be polled for progress.
+func (s *TransactionService) SubmitWithdrawalCSV(data []byte) (*models.Batch, error) {
+	if len(bytes.TrimSpace(data)) == 0 {
+		return nil, fmt.Errorf("CSV file is empty")
+	}
+
+	op, err := s.operations.Start(consts.OperationWithdrawalBatch)
+	if err != nil {
+		return nil, err
+	}
+
+	batch := models.Batch{
+		Type:        consts.Withdrawal,
+		Status:      consts.BatchProcessing,
+		Items:       []models.BatchItemResult{},
+		OperationID: op.ID,
+		CreatedAt:   time.Now(),
+	}
+
+	batchID, err := s.db.CreateBatch(batch)
+	if err != nil {
+		s.operations.Fail(op, err)
+		return nil, fmt.Errorf("failed to create batch: %w", err)
+	}
+	batch.ID = batchID
+
+	go s.processWithdrawalCSV(batch, op, data)
+
+	return &batch, nil
+}
+
+// GetBatch returns a batch with the current status of each of its transactions
+func (s *TransactionService) GetBatch(ctx context.Context, batchID int) (*models.Batch, error) {
+	batch, err := s.db.GetBatchByID(batchID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get batch: %w", err)
+	}
+
+	// Refresh item statuses from their transactions so callers see gateway progress
+	for i := range batch.Items {
+		if batch.Items[i].TransactionID == 0 {
+			continue
+		}
+
+		tx, err := s.db.GetTransactionByID(batch.Items[i].TransactionID)
+		if err != nil {
+			continue
+		}
+
+		batch.Items[i].Status = tx.Status
+		batch.Items[i].DeclineCode = tx.DeclineCode
+		batch.Items[i].RecoveryHint = s.recoveryHints.Hint(tx.DeclineCode)
+		if tx.ErrorMessage != "" {
+			batch.Items[i].Error = tx.ErrorMessage
+		}
+	}
+
+	return batch, nil
+}
+
+// processWithdrawalCSV parses the uploaded file and runs the valid rows through the payout pipeline
+func (s *TransactionService) processWithdrawalCSV(batch models.Batch, op *models.Operation, data []byte) {
+	ctx := context.Background()
+
+	reqs, items, err := parseWithdrawalCSV(data)
+	if err != nil {
+		log.Printf("Failed to parse withdrawal CSV for batch %d: %v", batch.ID, err)
+		batch.Status = consts.BatchFailed
+		batch.Items = []models.BatchItemResult{{Status: consts.Failed, Error: err.Error()}}
+		batch.Total, batch.Failed = 1, 1
+		if err := s.db.UpdateBatch(batch); err != nil {
+			log.Printf("Failed to update batch %d: %v", batch.ID, err)
+		}
+		s.operations.Fail(op, err)
+		return
+	}
+
+	// Persist validation results before processing so pollers see row errors early
+	batch.Items = items
+	batch.Total = len(items)
+	if err := s.db.UpdateBatch(batch); err != nil {
+		log.Printf("Failed to update batch %d: %v", batch.ID, err)
+	}
+
+	var mu sync.Mutex
+	processed := 0
+	s.operations.Progress(op, processed, len(items))
+
+	s.runBatchItems(ctx, items, reqs, s.ProcessWithdrawal, func() {
+		mu.Lock()
+		defer mu.Unlock()
+		processed++
+		s.operations.Progress(op, processed, len(items))
+	})
+
+	summarizeBatch(&batch)
+	if err := s.db.UpdateBatch(batch); err != nil {
+		log.Printf("Failed to update batch %d: %v", batch.ID, err)
+	}
+
+	s.operations.Complete(op, map[string]interface{}{
+		"batch_id":  batch.ID,
+		"status":    batch.Status,
+		"succeeded": batch.Succeeded,
+		"failed":    batch.Failed,
+	})
+}
+
+// runBatchItems processes every item that has not already failed validation, with
+// bounded concurrency, recording the outcome on the corresponding item. The optional
+// onDone callback is invoked once per item, including items skipped due to validation.
+func (s *TransactionService) runBatchItems(
+	ctx context.Context,
+	items []models.BatchItemResult,
+	reqs []models.TransactionRequest,
+	process func(context.Context, models.TransactionRequest) (*models.TransactionResponse, error),
+	onDone func(),
+) {
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, consts.BatchConcurrency)
+
+	done := func() {
+		if onDone != nil {
+			onDone()
+		}
+	}
+
+	for i := range items {
+		if items[i].Status == consts.Failed {
+			done()
+			continue
+		}
+
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			defer done()
+
+			sem <- struct{}{}
+			defer func() { <-sem }()
+
+			response, err := process(ctx, reqs[i])
+			if err != nil {
+				items[i].Status = consts.Failed
+				items[i].Error = err.Error()
+				return
+			}
+
+			items[i].Status = response.Status
+			items[i].TransactionID = response.TransactionID
+			items[i].ReferenceID = response.ReferenceID
+			items[i].GatewayReference = response.GatewayReference
+			items[i].Message = response.Message
+			items[i].RedirectURL = response.RedirectURL
+			items[i].DeclineCode = response.DeclineCode
+			items[i].RecoveryHint = response.RecoveryHint
+		}(i)
+	}
+
+	wg.Wait()
+}
+
+// parseWithdrawalCSV parses a bulk withdrawal file. It returns one request and one item
+// result per data row; rows that fail parsing or validation are marked as failed with
+// the reason. An error is returned only when the file as a whole is unusable.
+func parseWithdrawalCSV(data []byte) ([]models.TransactionRequest, []models.BatchItemResult, error) {
+	reader := csv.NewReader(bytes.NewReader(data))
+	reader.TrimLeadingSpace = true
+	reader.FieldsPerRecord = -1
+
+	header, err := reader.Read()
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to read CSV header: %w", err)
+	}
+
+	columns := make(map[string]int, len(header))
+	for i, name := range header {
+		columns[strings.ToLower(strings.TrimSpace(name))] = i
+	}
+	for _, name := range withdrawalCSVColumns {
+		if _, ok := columns[name]; !ok {
+			return nil, nil, fmt.Errorf("CSV header is missing column %q", name)
+		}
+	}
+
+	var reqs []models.TransactionRequest
+	var items []models.BatchItemResult
+
+	for {
+		record, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+
+		if len(items) >= consts.MaxCSVRows {
+			return nil, nil, fmt.Errorf("CSV exceeds maximum of %d rows", consts.MaxCSVRows)
+		}
+
+		index := len(items)
+
+		var parseErr *csv.ParseError
+		if errors.As(err, &parseErr) {
+			reqs = append(reqs, models.TransactionRequest{})
+			items = append(items, models.BatchItemResult{Index: index, Row: parseErr.StartLine, Status: consts.Failed, Error: err.Error()})
+			continue
+		}
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to read CSV: %w", err)
+		}
+
+		row, _ := reader.FieldPos(0)
+
+		req, err := parseWithdrawalRecord(record, columns)
+		item := newBatchItem(index, req)
+		item.Row = row
+		if err == nil {
+			err = ValidateTransactionRequest(req)
+		}
+		if err != nil {
+			item.Status = consts.Failed
+			item.Error = err.Error()
+		}
+
+		reqs = append(reqs, req)
+		items = append(items, item)
+	}
+
+	if len(items) == 0 {
+		return nil, nil, fmt.Errorf("CSV contains no withdrawal rows")
+	}
+
+	return reqs, items, nil
+}
+
+// parseWithdrawalRecord converts a CSV record into a withdrawal request
+func parseWithdrawalRecord(record []string, columns map[string]int) (models.TransactionRequest, error) {
+	var req models.TransactionRequest
+
+	field := func(name string) string {
+		if i := columns[name]; i < len(record) {
+			return strings.TrimSpace(record[i])
+		}
+		return ""
+	}
+
+	req.Currency = strings.ToUpper(field("currency"))
+	req.Beneficiary = field("beneficiary")
+
+	userID, err := strconv.Atoi(field("user_id"))
+	if err != nil {
+		return req, fmt.Errorf("invalid user_id %q", field("user_id"))
+	}
+	req.UserID = userID
+
+	amount, err := strconv.ParseFloat(field("amount"), 64)
+	if err != nil {
+		return req, fmt.Errorf("invalid amount %q", field("amount"))
+	}
+	req.Amount = amount
+
+	if len(req.Currency) != 3 {
+		return req, fmt.Errorf("invalid currency %q", req.Currency)
+	}
+
+	if req.Beneficiary == "" {
+		return req, fmt.Errorf("beneficiary is required")
+	}
+
+	return req, nil
+}
+
+// newBatchItem creates the initial result entry for a batch item
+func newBatchItem(index int, req models.TransactionRequest) models.BatchItemResult {
+	return models.BatchItemResult{
+		Index:       index,
+		UserID:      req.UserID,
+		Amount:      req.Amount,
+		Currency:    req.Currency,
+		Beneficiary: req.Beneficiary,
+	}
+}
+
+// summarizeBatch updates the batch counters and overall status from its items
+func summarizeBatch(batch *models.Batch) {
+	batch.Total = len(batch.Items)
+	batch.Succeeded, batch.Failed = 0, 0
+	for _, item := range batch.Items {
+		if item.Status == consts.Failed {
+			batch.Failed++
+		} else {
+			batch.Succeeded++
+		}
+	}
+	batch.Status = batchStatus(batch.Succeeded, batch.Failed)
+}
+
+// batchStatus derives the overall batch status from its item counts
+func batchStatus(succeeded, failed int) string {
+	switch {
+	case failed == 0:
+		return consts.BatchCompleted
+	case succeeded == 0:
+		return consts.BatchFailed
+	default:
+		return consts.BatchPartialFailure
+	}
+}
@@ -0,0 +1,128 @@
+package services
+
+import (
+	"context"
+	"payment-gateway/internal/consts"
+	"payment-gateway/internal/gateway"
+	"payment-gateway/internal/models"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+// TestProcessDepositBatch tests that valid items are processed and invalid items are reported individually
+func TestProcessDepositBatch(t *testing.T) {
+	var nextID int32 = 100
+	var savedBatch models.Batch
+
+	mockDB := &mockDB{
+		getUserFunc: func(id int) (*models.User, error) {
+			return &models.User{ID: id, CountryID: 1}, nil
+		},
+		createTransactionFunc: func(tx models.Transaction) (int, error) {
+			return int(atomic.AddInt32(&nextID, 1)), nil
+		},
+		createBatchFunc: func(batch models.Batch) (int, error) {
+			savedBatch = batch
+			return 7, nil
+		},
+	}
+
+	mockProvider := &mockProvider{
+		id:         "1",
+		name:       "TestGateway",
+		dataFormat: "application/json",
+	}
+
+	mockSelector := &mockGatewaySelector{
+		selectGatewayFunc: func(ctx context.Context, countryID int, txType string) (gateway.Provider, error) {
+			return mockProvider, nil
+		},
+	}
+
+	service := NewTransactionService(mockDB, mockSelector)
+
+	requests := []models.TransactionRequest{
+		{UserID: 1, Amount: 100.0, Currency: "USD"},
+		{UserID: 2, Amount: 0, Currency: "USD"}, // invalid amount
+		{UserID: 3, Amount: 25.5, Currency: "EUR"},
+	}
+
+	batch, err := service.ProcessDepositBatch(context.Background(), requests)
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+
+	if batch.ID != 7 {
+		t.Errorf("Expected batch ID 7, got: %d", batch.ID)
+	}
+
+	if batch.Total != 3 || batch.Succeeded != 2 || batch.Failed != 1 {
+		t.Errorf("Unexpected batch counts: total=%d succeeded=%d failed=%d", batch.Total, batch.Succeeded, batch.Failed)
+	}
+
+	if batch.Status != consts.BatchPartialFailure {
+		t.Errorf("Expected status %q, got: %s", consts.BatchPartialFailure, batch.Status)
+	}
+
+	if batch.Items[1].Status != consts.Failed || !strings.HasPrefix(batch.Items[1].Error, "amount ") {
+		t.Errorf("Expected item 1 to fail validation, got: %+v", batch.Items[1])
+	}
+
+	if batch.Items[0].TransactionID == 0 || batch.Items[2].TransactionID == 0 {
+		t.Error("Expected valid items to have transaction IDs")
+	}
+
+	if len(savedBatch.Items) != 3 {
+		t.Errorf("Expected batch to be persisted with 3 items, got: %d", len(savedBatch.Items))
+	}
+}
+
+// TestProcessDepositBatchTooLarge tests that oversized batches are rejected
+func TestProcessDepositBatchTooLarge(t *testing.T) {
+	service := NewTransactionService(&mockDB{}, &mockGatewaySelector{})
+
+	requests := make([]models.TransactionRequest, consts.MaxBatchSize+1)
+
+	if _, err := service.ProcessDepositBatch(context.Background(), requests); err == nil {
+		t.Error("Expected error for oversized batch, got none")
+	}
+}
+
+// TestParseWithdrawalCSV tests per-row validation of bulk withdrawal files
+func TestParseWithdrawalCSV(t *testing.T) {
+	data := []byte("user_id,amount,currency,beneficiary\n" +
+		"1,50.00,usd,GB29NWBK60161331926819\n" +
+		"abc,10,USD,GB29NWBK60161331926819\n" +
+		"2,-5,USD,GB29NWBK60161331926819\n" +
+		"3,20,EUR,\n")
+
+	reqs, items, err := parseWithdrawalCSV(data)
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+
+	if len(reqs) != 4 || len(items) != 4 {
+		t.Fatalf("Expected 4 rows, got %d requests and %d items", len(reqs), len(items))
+	}
+
+	if items[0].Status == consts.Failed || reqs[0].Currency != "USD" || reqs[0].Amount != 50 {
+		t.Errorf("Expected first row to be valid, got: %+v", items[0])
+	}
+
+	for i := 1; i < 4; i++ {
+		if items[i].Status != consts.Failed || items[i].Error == "" {
+			t.Errorf("Expected row %d to fail validation, got: %+v", i, items[i])
+		}
+		if items[i].Row != i+2 {
+			t.Errorf("Expected row %d to report line %d, got: %d", i, i+2, items[i].Row)
+		}
+	}
+}
+
+// TestParseWithdrawalCSVMissingColumn tests that a malformed header rejects the whole file
+func TestParseWithdrawalCSVMissingColumn(t *testing.T) {
+	if _, _, err := parseWithdrawalCSV([]byte("user_id,amount\n1,10\n")); err == nil {
+		t.Error("Expected error for missing columns, got none")
+	}
+}
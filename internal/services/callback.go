@@ -0,0 +1,178 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"payment-gateway/internal/consts"
+	"payment-gateway/internal/gateway"
+	"payment-gateway/internal/models"
+	"payment-gateway/internal/utils"
+	"strconv"
+	"time"
+)
+
+var (
+	ErrCallbackNotFound       = errors.New("Callback not found")
+	ErrCallbackNotReparseable = errors.New("Only callbacks that failed to parse or process can be reparsed")
+	ErrInvalidCallback        = errors.New("Invalid callback")
+)
+
+// callbackStatuses lists the transaction statuses a gateway callback may report
+var callbackStatuses = map[string]bool{
+	consts.Pending:    true,
+	consts.Processing: true,
+	consts.Completed:  true,
+	consts.Failed:     true,
+}
+
+// RecordCallback stores a callback as received, before it is verified or parsed
+func (s *TransactionService) RecordCallback(ctx context.Context, gatewayID int, header http.Header, body []byte) (*models.CallbackRecord, error) {
+	record := models.CallbackRecord{
+		GatewayID: gatewayID,
+		Headers:   utils.MaskHeaders(header),
+		Body:      string(body),
+		Status:    consts.CallbackReceived,
+		CreatedAt: time.Now(),
+	}
+
+	id, err := s.db.CreateCallbackRecord(record)
+	if err != nil {
+		return nil, fmt.Errorf("failed to store callback: %w", err)
+	}
+	record.ID = id
+
+	return &record, nil
+}
+
+// RejectCallback marks a stored callback as rejected, e.g. because its signature did not verify.
+// Rejected callbacks are kept for investigation but cannot be reparsed.
+func (s *TransactionService) RejectCallback(ctx context.Context, record *models.CallbackRecord, reason error) {
+	s.updateCallback(record, consts.CallbackRejected, reason.Error(), 0)
+}
+
+// ProcessCallback parses a stored callback with its gateway's provider and applies it. Parse and
+// validation failures wrap ErrInvalidCallback, and callbacks whose provider rejects their signature
+// are rejected with ErrInvalidWebhookSignature; the outcome is recorded on the callback either way.
+func (s *TransactionService) ProcessCallback(ctx context.Context, record *models.CallbackRecord) error {
+	gatewayID := strconv.Itoa(record.GatewayID)
+	provider, err := s.gatewaySelector.GetProviderByID(gatewayID)
+	if err != nil {
+		s.updateCallback(record, consts.CallbackFailed, err.Error(), 0)
+		return fmt.Errorf("failed to get provider: %w", err)
+	}
+
+	// Providers verifying signature timestamps check them against when the callback arrived
+	parseCtx := gateway.WithCallbackReceivedAt(ctx, record.CreatedAt)
+	req, err := http.NewRequestWithContext(parseCtx, http.MethodPost, consts.CallbackRoute+"/"+gatewayID, bytes.NewReader([]byte(record.Body)))
+	if err != nil {
+		s.updateCallback(record, consts.CallbackFailed, err.Error(), 0)
+		return fmt.Errorf("failed to rebuild callback request: %w", err)
+	}
+	for name, value := range record.Headers {
+		req.Header.Set(name, value)
+	}
+
+	callbackData, err := provider.ParseCallback(req)
+	if errors.Is(err, gateway.ErrInvalidCallbackSignature) {
+		s.RejectCallback(ctx, record, err)
+		return fmt.Errorf("%w: %v", ErrInvalidWebhookSignature, err)
+	}
+	if err == nil {
+		err = s.resolveCallbackTransaction(record.GatewayID, callbackData)
+	}
+	if err == nil {
+		err = validateCallbackData(callbackData)
+	}
+	if err != nil {
+		s.updateCallback(record, consts.CallbackParseFailed, err.Error(), 0)
+		return fmt.Errorf("%w: %v", ErrInvalidCallback, err)
+	}
+
+	if err := s.HandleCallback(ctx, callbackData); err != nil {
+		s.updateCallback(record, consts.CallbackFailed, err.Error(), callbackData.TransactionID)
+		return err
+	}
+
+	s.updateCallback(record, consts.CallbackProcessed, "", callbackData.TransactionID)
+	return nil
+}
+
+// GetCallback returns a stored callback
+func (s *TransactionService) GetCallback(ctx context.Context, recordID int) (*models.CallbackRecord, error) {
+	record, err := s.db.GetCallbackRecordByID(recordID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrCallbackNotFound
+		}
+		return nil, fmt.Errorf("failed to fetch callback: %w", err)
+	}
+	return record, nil
+}
+
+// ReparseCallback processes a stored callback again, typically after a provider's parser was
+// fixed. Only callbacks that failed to parse or process can be reparsed.
+func (s *TransactionService) ReparseCallback(ctx context.Context, recordID int) (*models.CallbackRecord, error) {
+	record, err := s.GetCallback(ctx, recordID)
+	if err != nil {
+		return nil, err
+	}
+	if record.Status != consts.CallbackParseFailed && record.Status != consts.CallbackFailed {
+		return nil, ErrCallbackNotReparseable
+	}
+
+	// The outcome is recorded on the callback, so the error is reported through its status
+	if err := s.ProcessCallback(ctx, record); err != nil {
+		log.Printf("Reparse of callback %d failed: %v", record.ID, err)
+	}
+
+	return record, nil
+}
+
+// resolveCallbackTransaction sets the transaction ID of a callback identifying its transaction only
+// by the gateway's reference, as M-Pesa's do
+func (s *TransactionService) resolveCallbackTransaction(gatewayID int, data *models.CallbackData) error {
+	if data.TransactionID > 0 || data.GatewayReference == "" {
+		return nil
+	}
+
+	txID, err := s.db.GetTransactionIDByGatewayReference(gatewayID, data.GatewayReference)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return fmt.Errorf("no transaction of gateway %d has reference %q", gatewayID, data.GatewayReference)
+		}
+		return fmt.Errorf("failed to find transaction by gateway reference: %w", err)
+	}
+	data.TransactionID = txID
+	return nil
+}
+
+// validateCallbackData checks that a parsed callback identifies a transaction and reports a known status
+func validateCallbackData(data *models.CallbackData) error {
+	if data.TransactionID <= 0 {
+		return errors.New("callback is missing a transaction ID")
+	}
+	if !callbackStatuses[data.Status] {
+		return fmt.Errorf("unknown callback status: %q", data.Status)
+	}
+	return nil
+}
+
+// updateCallback records the outcome of a callback on the stored record and the in-memory copy
+func (s *TransactionService) updateCallback(record *models.CallbackRecord, status, errorMsg string, transactionID int) {
+	if err := s.db.UpdateCallbackRecord(record.ID, status, errorMsg, transactionID); err != nil {
+		log.Printf("Failed to update callback %d: %v", record.ID, err)
+	}
+
+	record.Status = status
+	record.Error = errorMsg
+	if transactionID > 0 {
+		record.TransactionID = transactionID
+	}
+	record.Attempts++
+	record.ProcessedAt = time.Now()
+}
@@ -0,0 +1,209 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"payment-gateway/db"
+	"payment-gateway/internal/consts"
+	"payment-gateway/internal/gateway"
+	"payment-gateway/internal/models"
+	"testing"
+	"time"
+)
+
+// TestReparseCallbackAfterProviderFix tests that a callback the provider failed to parse is stored
+// with masked headers and can be applied by reparsing it once the parser is fixed
+func TestReparseCallbackAfterProviderFix(t *testing.T) {
+	mockDB := db.NewMockDB()
+	txID, err := mockDB.CreateTransaction(models.Transaction{UserID: 1, Amount: 10, Currency: "USD", Status: consts.Processing})
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+
+	// The provider initially reads the status from the wrong field
+	fixed := false
+	provider := &mockProvider{
+		id: "1",
+		parseCallbackFunc: func(r *http.Request) (*models.CallbackData, error) {
+			var payload struct {
+				TransactionID int    `json:"transaction_id"`
+				State         string `json:"state"`
+			}
+			if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+				return nil, err
+			}
+			data := &models.CallbackData{TransactionID: payload.TransactionID}
+			if fixed {
+				data.Status = payload.State
+			}
+			return data, nil
+		},
+	}
+	selector := &mockGatewaySelector{
+		getProviderFunc: func(id string) (gateway.Provider, error) { return provider, nil },
+	}
+	service := NewTransactionService(mockDB, selector)
+	ctx := context.Background()
+
+	header := http.Header{}
+	header.Set("Content-Type", "application/json")
+	header.Set(consts.WebhookSignatureHeader, "sha256=abcdef")
+	body, _ := json.Marshal(map[string]interface{}{"transaction_id": txID, "state": consts.Completed})
+
+	record, err := service.RecordCallback(ctx, 1, header, body)
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	if record.Headers[consts.WebhookSignatureHeader] != "***" {
+		t.Errorf("Expected signature header to be masked, got %q", record.Headers[consts.WebhookSignatureHeader])
+	}
+
+	if err := service.ProcessCallback(ctx, record); !errors.Is(err, ErrInvalidCallback) {
+		t.Fatalf("Expected ErrInvalidCallback, got: %v", err)
+	}
+	stored, _ := service.GetCallback(ctx, record.ID)
+	if stored.Status != consts.CallbackParseFailed || stored.Error == "" {
+		t.Fatalf("Expected parse failure to be recorded, got status %q", stored.Status)
+	}
+
+	fixed = true
+	reparsed, err := service.ReparseCallback(ctx, record.ID)
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	if reparsed.Status != consts.CallbackProcessed || reparsed.TransactionID != txID {
+		t.Errorf("Expected callback to be processed for transaction %d, got %+v", txID, reparsed)
+	}
+
+	tx, _ := mockDB.GetTransactionByID(txID)
+	if tx.Status != consts.Completed {
+		t.Errorf("Expected transaction to be completed, got %s", tx.Status)
+	}
+
+	// Processed callbacks are not applied twice
+	if _, err := service.ReparseCallback(ctx, record.ID); !errors.Is(err, ErrCallbackNotReparseable) {
+		t.Errorf("Expected ErrCallbackNotReparseable, got: %v", err)
+	}
+}
+
+// TestProcessCallbackProviderSignature tests that callbacks are parsed as of when they were
+// received and that a provider rejecting their signature rejects the callback
+func TestProcessCallbackProviderSignature(t *testing.T) {
+	mockDB := db.NewMockDB()
+	var receivedAt time.Time
+	provider := &mockProvider{
+		id: "2",
+		parseCallbackFunc: func(r *http.Request) (*models.CallbackData, error) {
+			receivedAt = gateway.CallbackReceivedAt(r.Context())
+			return nil, fmt.Errorf("%w: timestamp is outside the tolerance", gateway.ErrInvalidCallbackSignature)
+		},
+	}
+	selector := &mockGatewaySelector{
+		getProviderFunc: func(id string) (gateway.Provider, error) { return provider, nil },
+	}
+	service := NewTransactionService(mockDB, selector)
+	ctx := context.Background()
+
+	record, err := service.RecordCallback(ctx, 2, http.Header{}, []byte(`{}`))
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+
+	if err := service.ProcessCallback(ctx, record); !errors.Is(err, ErrInvalidWebhookSignature) {
+		t.Fatalf("Expected ErrInvalidWebhookSignature, got: %v", err)
+	}
+	if !receivedAt.Equal(record.CreatedAt) {
+		t.Errorf("Expected the callback to be parsed as of %v, got %v", record.CreatedAt, receivedAt)
+	}
+
+	stored, _ := service.GetCallback(ctx, record.ID)
+	if stored.Status != consts.CallbackRejected {
+		t.Errorf("Expected callback to be rejected, got status %q", stored.Status)
+	}
+	if _, err := service.ReparseCallback(ctx, record.ID); !errors.Is(err, ErrCallbackNotReparseable) {
+		t.Errorf("Expected rejected callbacks not to be reparseable, got: %v", err)
+	}
+}
+
+// TestProcessCallbackByGatewayReference tests that callbacks identifying their transaction only by
+// the gateway's reference, as M-Pesa's do, are applied to the gateway's transaction with it
+func TestProcessCallbackByGatewayReference(t *testing.T) {
+	mockDB := db.NewMockDB()
+	other, _ := mockDB.CreateTransaction(models.Transaction{UserID: 1, Amount: 10, Currency: "KES", GatewayID: 1, GatewayReference: "ws_CO_1", Status: consts.Processing})
+	txID, _ := mockDB.CreateTransaction(models.Transaction{UserID: 1, Amount: 10, Currency: "KES", GatewayID: 6, GatewayReference: "ws_CO_1", Status: consts.Processing})
+
+	provider := gateway.NewMpesaProvider(6, "M-Pesa", gateway.MpesaConfig{})
+	selector := &mockGatewaySelector{
+		getProviderFunc: func(id string) (gateway.Provider, error) { return provider, nil },
+	}
+	service := NewTransactionService(mockDB, selector)
+	ctx := context.Background()
+
+	body := []byte(`{"Body":{"stkCallback":{"MerchantRequestID":"1","CheckoutRequestID":"ws_CO_1","ResultCode":1032,"ResultDesc":"Request cancelled by user"}}}`)
+	record, err := service.RecordCallback(ctx, 6, http.Header{}, body)
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	if err := service.ProcessCallback(ctx, record); err != nil {
+		t.Fatalf("ProcessCallback failed: %v", err)
+	}
+	if record.TransactionID != txID {
+		t.Errorf("Expected the callback applied to transaction %d, got %d", txID, record.TransactionID)
+	}
+
+	tx, _ := mockDB.GetTransactionByID(txID)
+	if tx.Status != consts.Failed || tx.DeclineCode != consts.DeclineConsentRejected {
+		t.Errorf("Expected the deposit failed as rejected, got %s (%s)", tx.Status, tx.DeclineCode)
+	}
+	if tx, _ := mockDB.GetTransactionByID(other); tx.Status != consts.Processing {
+		t.Errorf("Expected the other gateway's transaction unchanged, got %s", tx.Status)
+	}
+
+	// Callbacks with unknown references fail to parse, so they can be reparsed
+	record, _ = service.RecordCallback(ctx, 6, http.Header{}, []byte(`{"Result":{"ResultCode":0,"ConversationID":"AG_unknown"}}`))
+	if err := service.ProcessCallback(ctx, record); !errors.Is(err, ErrInvalidCallback) || record.Status != consts.CallbackParseFailed {
+		t.Errorf("Expected an unknown reference to fail parsing, got %s, %v", record.Status, err)
+	}
+}
+
+// TestProcessCallbackCryptoPayment tests that the on-chain payment a crypto gateway reports is
+// recorded on the deposit, including payments for less than the price
+func TestProcessCallbackCryptoPayment(t *testing.T) {
+	mockDB := db.NewMockDB()
+	txID, _ := mockDB.CreateTransaction(models.Transaction{UserID: 1, Amount: 100, Currency: "USD", GatewayID: 7, GatewayReference: "ABCD1234", Status: consts.Processing})
+
+	provider := &mockProvider{
+		id: "7",
+		parseCallbackFunc: func(r *http.Request) (*models.CallbackData, error) {
+			return &models.CallbackData{
+				TransactionID:    txID,
+				Status:           consts.Failed,
+				Message:          "underpaid: received 0.00120000 BTC",
+				DeclineCode:      consts.DeclineInsufficientFunds,
+				GatewayReference: "ABCD1234",
+				Crypto:           &models.CryptoPayment{Amount: "0.00120000", Currency: "BTC", Network: "bitcoin", Confirmations: 1, Status: consts.CryptoPaymentUnderpaid},
+			}, nil
+		},
+	}
+	selector := &mockGatewaySelector{
+		getProviderFunc: func(id string) (gateway.Provider, error) { return provider, nil },
+	}
+	service := NewTransactionService(mockDB, selector)
+	ctx := context.Background()
+
+	record, _ := service.RecordCallback(ctx, 7, http.Header{}, []byte(`{}`))
+	if err := service.ProcessCallback(ctx, record); err != nil {
+		t.Fatalf("ProcessCallback failed: %v", err)
+	}
+
+	tx, _ := mockDB.GetTransactionByID(txID)
+	if tx.Status != consts.Failed || tx.DeclineCode != consts.DeclineInsufficientFunds {
+		t.Errorf("Expected the underpaid deposit to fail, got %s (%s)", tx.Status, tx.DeclineCode)
+	}
+	if tx.Crypto == nil || tx.Crypto.Amount != "0.00120000" || tx.Crypto.Network != "bitcoin" || tx.Crypto.Status != consts.CryptoPaymentUnderpaid {
+		t.Errorf("Expected the crypto payment recorded, got %+v", tx.Crypto)
+	}
+}
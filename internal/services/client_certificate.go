@@ -0,0 +1,280 @@
+package services
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"payment-gateway/db"
+	"payment-gateway/internal/consts"
+	"payment-gateway/internal/models"
+	"payment-gateway/internal/utils"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	ErrInvalidClientCertificate  = errors.New("invalid client certificate")
+	ErrClientCertificateNotFound = errors.New("client certificate not found")
+	ErrLastClientCertificate     = errors.New("cannot retire the last active client certificate; add a new one first")
+)
+
+// ClientCertificateService manages the TLS client certificates that connections to gateways
+// requiring mutual TLS authenticate with. Private keys are stored encrypted. A gateway can have
+// several active certificates while one is rotated; connections present the newest one the
+// gateway accepts. Certificates are loaded into memory and refreshed periodically, so every
+// instance picks up a rotation without a restart.
+type ClientCertificateService struct {
+	db db.DBInterface
+
+	mu           sync.RWMutex
+	certificates map[int][]tls.Certificate // active certificates per gateway, newest first
+	fingerprints map[int]string            // of the active certificates per gateway, to detect rotations
+	onRotate     map[int][]func()
+	now          func() time.Time
+}
+
+// NewClientCertificateService creates a new client certificate service
+func NewClientCertificateService(dbInterface db.DBInterface) *ClientCertificateService {
+	return &ClientCertificateService{
+		db:           dbInterface,
+		certificates: make(map[int][]tls.Certificate),
+		fingerprints: make(map[int]string),
+		onRotate:     make(map[int][]func()),
+		now:          time.Now,
+	}
+}
+
+// Add stores a certificate and its private key as an active client certificate of a gateway and
+// starts presenting it. The certificate must match the key, be valid now and allow client
+// authentication. The private key is stored encrypted and never returned.
+func (s *ClientCertificateService) Add(ctx context.Context, gatewayID int, request models.ClientCertificateRequest) (*models.ClientCertificate, error) {
+	pair, err := tls.X509KeyPair([]byte(request.Certificate), []byte(request.PrivateKey))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidClientCertificate, err)
+	}
+	leaf, err := x509.ParseCertificate(pair.Certificate[0])
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidClientCertificate, err)
+	}
+
+	now := s.now()
+	switch {
+	case now.After(leaf.NotAfter):
+		return nil, fmt.Errorf("%w: expired at %s", ErrInvalidClientCertificate, leaf.NotAfter.Format(time.RFC3339))
+	case now.Before(leaf.NotBefore):
+		return nil, fmt.Errorf("%w: not valid until %s", ErrInvalidClientCertificate, leaf.NotBefore.Format(time.RFC3339))
+	case !allowsClientAuth(leaf):
+		return nil, fmt.Errorf("%w: not issued for client authentication", ErrInvalidClientCertificate)
+	}
+
+	encrypted, err := utils.EncryptString(request.PrivateKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encrypt private key: %w", err)
+	}
+
+	fingerprint := sha256.Sum256(leaf.Raw)
+	record := models.ClientCertificate{
+		GatewayID:   gatewayID,
+		Certificate: strings.TrimSpace(request.Certificate),
+		PrivateKey:  encrypted,
+		Subject:     leaf.Subject.String(),
+		Fingerprint: hex.EncodeToString(fingerprint[:]),
+		NotAfter:    leaf.NotAfter.UTC(),
+		Status:      consts.ClientCertificateActive,
+		CreatedAt:   now,
+	}
+
+	id, err := s.db.CreateClientCertificate(record)
+	if err != nil {
+		return nil, err
+	}
+	record.ID = id
+	record.PrivateKey = ""
+
+	log.Printf("Added client certificate %d (%s) to gateway %d", id, record.Subject, gatewayID)
+	if err := s.Refresh(ctx); err != nil {
+		log.Printf("Failed to refresh client certificates: %v", err)
+	}
+
+	return &record, nil
+}
+
+// List returns a gateway's active and retired client certificates without their private keys
+func (s *ClientCertificateService) List(ctx context.Context, gatewayID int) ([]models.ClientCertificate, error) {
+	certificates, err := s.db.GetClientCertificates(gatewayID)
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range certificates {
+		certificates[i].PrivateKey = ""
+	}
+	return certificates, nil
+}
+
+// Retire stops presenting a client certificate. The last active certificate of a gateway cannot
+// be retired, since connections to the gateway would then fail.
+func (s *ClientCertificateService) Retire(ctx context.Context, gatewayID, certificateID int) error {
+	certificates, err := s.db.GetClientCertificates(gatewayID)
+	if err != nil {
+		return err
+	}
+
+	found, active := false, 0
+	for _, certificate := range certificates {
+		if certificate.Status != consts.ClientCertificateActive {
+			continue
+		}
+		active++
+		if certificate.ID == certificateID {
+			found = true
+		}
+	}
+
+	if !found {
+		return ErrClientCertificateNotFound
+	}
+	if active == 1 {
+		return ErrLastClientCertificate
+	}
+
+	if err := s.db.RetireClientCertificate(gatewayID, certificateID); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrClientCertificateNotFound
+		}
+		return err
+	}
+
+	log.Printf("Retired client certificate %d of gateway %d", certificateID, gatewayID)
+	if err := s.Refresh(ctx); err != nil {
+		log.Printf("Failed to refresh client certificates: %v", err)
+	}
+	return nil
+}
+
+// Refresh reloads the active client certificates of every gateway. Gateways whose certificates
+// changed are notified through their OnRotate callbacks. Expired certificates are skipped, and
+// certificates expiring within consts.ClientCertificateExpiryWarning are logged.
+func (s *ClientCertificateService) Refresh(ctx context.Context) error {
+	records, err := s.db.GetClientCertificates(0)
+	if err != nil {
+		return fmt.Errorf("failed to fetch client certificates: %w", err)
+	}
+
+	now := s.now()
+	certificates := make(map[int][]tls.Certificate)
+	fingerprints := make(map[int]string)
+	// Newest first, so connections prefer the latest certificate during a rotation
+	for i := len(records) - 1; i >= 0; i-- {
+		record := records[i]
+		if record.Status != consts.ClientCertificateActive {
+			continue
+		}
+		if now.After(record.NotAfter) {
+			log.Printf("Client certificate %d of gateway %d expired at %s", record.ID, record.GatewayID, record.NotAfter.Format(time.RFC3339))
+			continue
+		}
+		if record.NotAfter.Sub(now) < consts.ClientCertificateExpiryWarning {
+			log.Printf("Client certificate %d of gateway %d expires at %s; add its replacement", record.ID, record.GatewayID, record.NotAfter.Format(time.RFC3339))
+		}
+
+		certificate, err := loadClientCertificate(record)
+		if err != nil {
+			log.Printf("Failed to load client certificate %d of gateway %d: %v", record.ID, record.GatewayID, err)
+			continue
+		}
+		certificates[record.GatewayID] = append(certificates[record.GatewayID], certificate)
+		fingerprints[record.GatewayID] += record.Fingerprint + ","
+	}
+
+	s.mu.Lock()
+	var rotated []func()
+	for gatewayID, callbacks := range s.onRotate {
+		if fingerprints[gatewayID] != s.fingerprints[gatewayID] {
+			rotated = append(rotated, callbacks...)
+		}
+	}
+	s.certificates = certificates
+	s.fingerprints = fingerprints
+	s.mu.Unlock()
+
+	for _, callback := range rotated {
+		callback()
+	}
+	return nil
+}
+
+// OnRotate registers a callback run when a gateway's active certificates change, e.g. to close
+// idle connections that authenticated with a retired certificate
+func (s *ClientCertificateService) OnRotate(gatewayID int, callback func()) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.onRotate[gatewayID] = append(s.onRotate[gatewayID], callback)
+}
+
+// TLSConfig returns the TLS configuration of a gateway's connections, presenting its client
+// certificates when the gateway asks for one
+func (s *ClientCertificateService) TLSConfig(gatewayID int) *tls.Config {
+	return &tls.Config{
+		MinVersion:           tls.VersionTLS12,
+		GetClientCertificate: s.clientCertificate(gatewayID),
+	}
+}
+
+// clientCertificate selects the newest active certificate of a gateway that the gateway
+// accepts, or the newest one when it accepts none of them
+func (s *ClientCertificateService) clientCertificate(gatewayID int) func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+	return func(info *tls.CertificateRequestInfo) (*tls.Certificate, error) {
+		s.mu.RLock()
+		certificates := s.certificates[gatewayID]
+		s.mu.RUnlock()
+
+		for i := range certificates {
+			if info.SupportsCertificate(&certificates[i]) == nil {
+				return &certificates[i], nil
+			}
+		}
+		if len(certificates) > 0 {
+			return &certificates[0], nil
+		}
+
+		// Without a certificate the handshake continues and the gateway decides whether to refuse it
+		return &tls.Certificate{}, nil
+	}
+}
+
+// loadClientCertificate decrypts a stored certificate's private key and parses the pair
+func loadClientCertificate(record models.ClientCertificate) (tls.Certificate, error) {
+	key, err := utils.DecryptString(record.PrivateKey)
+	if err != nil {
+		return tls.Certificate{}, fmt.Errorf("failed to decrypt private key: %w", err)
+	}
+
+	certificate, err := tls.X509KeyPair([]byte(record.Certificate), []byte(key))
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	certificate.Leaf, err = x509.ParseCertificate(certificate.Certificate[0])
+	return certificate, err
+}
+
+// allowsClientAuth reports whether a certificate may be used to authenticate a TLS client
+func allowsClientAuth(leaf *x509.Certificate) bool {
+	if len(leaf.ExtKeyUsage) == 0 {
+		return true
+	}
+	for _, usage := range leaf.ExtKeyUsage {
+		if usage == x509.ExtKeyUsageClientAuth || usage == x509.ExtKeyUsageAny {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,197 @@
+package services
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"payment-gateway/db"
+	"payment-gateway/internal/httpclient"
+	"payment-gateway/internal/models"
+	"testing"
+	"time"
+)
+
+// testCA issues client certificates for tests
+type testCA struct {
+	cert   *x509.Certificate
+	key    *ecdsa.PrivateKey
+	serial int64
+}
+
+func newTestCA(t *testing.T) *testCA {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate CA key: %v", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "Test Bank CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(24 * time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("Failed to create CA certificate: %v", err)
+	}
+	cert, _ := x509.ParseCertificate(der)
+	return &testCA{cert: cert, key: key, serial: 1}
+}
+
+// issue returns a PEM certificate and key for a client, valid until notAfter
+func (ca *testCA) issue(t *testing.T, commonName string, notAfter time.Time, usage x509.ExtKeyUsage) models.ClientCertificateRequest {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate client key: %v", err)
+	}
+	ca.serial++
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(ca.serial),
+		Subject:      pkix.Name{CommonName: commonName},
+		NotBefore:    time.Now().Add(-2 * time.Hour),
+		NotAfter:     notAfter,
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{usage},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.cert, &key.PublicKey, ca.key)
+	if err != nil {
+		t.Fatalf("Failed to create client certificate: %v", err)
+	}
+	keyDER, _ := x509.MarshalECPrivateKey(key)
+
+	return models.ClientCertificateRequest{
+		Certificate: string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})),
+		PrivateKey:  string(pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})),
+	}
+}
+
+// TestClientCertificateValidation tests that only usable certificates are stored and keys are never returned
+func TestClientCertificateValidation(t *testing.T) {
+	service := NewClientCertificateService(db.NewMockDB())
+	ctx := context.Background()
+	ca := newTestCA(t)
+
+	expired := ca.issue(t, "expired", time.Now().Add(-time.Hour), x509.ExtKeyUsageClientAuth)
+	serverOnly := ca.issue(t, "server", time.Now().Add(time.Hour), x509.ExtKeyUsageServerAuth)
+	mismatched := ca.issue(t, "client", time.Now().Add(time.Hour), x509.ExtKeyUsageClientAuth)
+	mismatched.PrivateKey = expired.PrivateKey
+
+	for name, request := range map[string]models.ClientCertificateRequest{
+		"expired":     expired,
+		"server only": serverOnly,
+		"mismatched":  mismatched,
+		"not PEM":     {Certificate: "certificate", PrivateKey: "key"},
+	} {
+		if _, err := service.Add(ctx, 1, request); !errors.Is(err, ErrInvalidClientCertificate) {
+			t.Errorf("Expected ErrInvalidClientCertificate for the %s certificate, got: %v", name, err)
+		}
+	}
+
+	certificate, err := service.Add(ctx, 1, ca.issue(t, "client", time.Now().Add(time.Hour), x509.ExtKeyUsageClientAuth))
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	if certificate.PrivateKey != "" || certificate.Subject != "CN=client" || len(certificate.Fingerprint) != 64 {
+		t.Errorf("Expected a certificate without its key, got %+v", certificate)
+	}
+
+	certificates, err := service.List(ctx, 1)
+	if err != nil || len(certificates) != 1 || certificates[0].PrivateKey != "" {
+		t.Errorf("Expected one listed certificate without its key, got %+v (%v)", certificates, err)
+	}
+
+	if err := service.Retire(ctx, 1, certificate.ID); !errors.Is(err, ErrLastClientCertificate) {
+		t.Errorf("Expected ErrLastClientCertificate, got: %v", err)
+	}
+	if err := service.Retire(ctx, 2, certificate.ID); !errors.Is(err, ErrClientCertificateNotFound) {
+		t.Errorf("Expected ErrClientCertificateNotFound for another gateway, got: %v", err)
+	}
+}
+
+// TestClientCertificateRotation tests that connections present the newest certificate and switch
+// back to the remaining one once it is retired
+func TestClientCertificateRotation(t *testing.T) {
+	ca := newTestCA(t)
+	clientCAs := x509.NewCertPool()
+	clientCAs.AddCert(ca.cert)
+
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(r.TLS.PeerCertificates[0].Subject.CommonName))
+	}))
+	server.TLS = &tls.Config{ClientAuth: tls.RequireAndVerifyClientCert, ClientCAs: clientCAs}
+	server.StartTLS()
+	defer server.Close()
+
+	service := NewClientCertificateService(db.NewMockDB())
+	ctx := context.Background()
+
+	config := httpclient.DefaultConfig("MutualTLSTest")
+	config.MaxRetries = 0
+	config.TLS = service.TLSConfig(1)
+	config.TLS.RootCAs = server.Client().Transport.(*http.Transport).TLSClientConfig.RootCAs
+	client := httpclient.New(config)
+
+	var rotations int
+	service.OnRotate(1, func() {
+		rotations++
+		client.CloseIdleConnections()
+	})
+
+	presented := func() string {
+		t.Helper()
+		req, _ := http.NewRequest(http.MethodGet, server.URL, nil)
+		resp, err := client.Do(req)
+		if err != nil {
+			return "none"
+		}
+		defer resp.Body.Close()
+		body := make([]byte, 64)
+		n, _ := resp.Body.Read(body)
+		return string(body[:n])
+	}
+
+	if got := presented(); got != "none" {
+		t.Fatalf("Expected the handshake to fail without a certificate, got %s", got)
+	}
+
+	old, err := service.Add(ctx, 1, ca.issue(t, "old", time.Now().Add(time.Hour), x509.ExtKeyUsageClientAuth))
+	if err != nil {
+		t.Fatalf("Failed to add certificate: %v", err)
+	}
+	if got := presented(); got != "old" {
+		t.Errorf("Expected the old certificate to be presented, got %s", got)
+	}
+
+	replacement, err := service.Add(ctx, 1, ca.issue(t, "new", time.Now().Add(2*time.Hour), x509.ExtKeyUsageClientAuth))
+	if err != nil {
+		t.Fatalf("Failed to add certificate: %v", err)
+	}
+	if got := presented(); got != "new" {
+		t.Errorf("Expected the newest certificate to be presented, got %s", got)
+	}
+
+	// Rolling back a replacement the gateway has not enrolled yet
+	if err := service.Retire(ctx, 1, replacement.ID); err != nil {
+		t.Fatalf("Failed to retire certificate: %v", err)
+	}
+	if got := presented(); got != "old" {
+		t.Errorf("Expected the old certificate to be presented again, got %s", got)
+	}
+	if err := service.Retire(ctx, 1, old.ID); !errors.Is(err, ErrLastClientCertificate) {
+		t.Errorf("Expected ErrLastClientCertificate, got: %v", err)
+	}
+	if rotations != 3 {
+		t.Errorf("Expected 3 rotations, got %d", rotations)
+	}
+}
@@ -0,0 +1,146 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"payment-gateway/internal/models"
+	"payment-gateway/internal/validation"
+	"regexp"
+	"sort"
+)
+
+var ErrInvalidComplianceFields = errors.New("invalid compliance fields")
+
+// complianceFieldName is the form of compliance field names, the keys of compliance_fields
+var complianceFieldName = regexp.MustCompile(`^[a-z][a-z0-9_]*$`)
+
+// ComplianceFields returns the compliance fields transactions in a country must carry
+func (s *TransactionService) ComplianceFields(ctx context.Context, countryCode string) ([]models.ComplianceField, error) {
+	country, err := s.countryByCode(countryCode)
+	if err != nil {
+		return nil, err
+	}
+	return s.countryComplianceFields(country.ID, "")
+}
+
+// SetComplianceFields replaces the compliance fields of a country. Transactions created from then
+// on must carry them; an empty list requires none.
+func (s *TransactionService) SetComplianceFields(ctx context.Context, countryCode string, fields []models.ComplianceField) ([]models.ComplianceField, error) {
+	country, err := s.countryByCode(countryCode)
+	if err != nil {
+		return nil, err
+	}
+
+	names := make(map[string]bool, len(fields))
+	for _, field := range fields {
+		if !complianceFieldName.MatchString(field.Name) {
+			return nil, fmt.Errorf("%w: name %q must be lower case letters, digits and underscores", ErrInvalidComplianceFields, field.Name)
+		}
+		if names[field.Name] {
+			return nil, fmt.Errorf("%w: %s is listed twice", ErrInvalidComplianceFields, field.Name)
+		}
+		names[field.Name] = true
+
+		if _, err := compilePattern(field.Pattern); err != nil {
+			return nil, fmt.Errorf("%w: pattern of %s: %v", ErrInvalidComplianceFields, field.Name, err)
+		}
+	}
+
+	if err := s.db.ReplaceComplianceFields(country.ID, fields); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, fmt.Errorf("%w: %s", ErrUnsupportedCountry, countryCode)
+		}
+		return nil, fmt.Errorf("failed to save compliance fields: %w", err)
+	}
+	return s.countryComplianceFields(country.ID, "")
+}
+
+// countryComplianceFields returns a country's compliance fields that transactions of a type must
+// carry, or all of them for ""
+func (s *TransactionService) countryComplianceFields(countryID int, txType string) ([]models.ComplianceField, error) {
+	fields, err := s.db.GetComplianceFields(countryID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get compliance fields: %w", err)
+	}
+
+	required := []models.ComplianceField{}
+	for _, field := range fields {
+		if txType == "" || requiredFor(field, txType) {
+			required = append(required, field)
+		}
+	}
+	return required, nil
+}
+
+// validateComplianceFields checks a transaction's compliance field values against the fields its
+// country requires for its type. Missing, malformed and unexpected values are reported as field
+// errors, so no more personal data than required is kept.
+func (s *TransactionService) validateComplianceFields(txType string, countryID int, values map[string]string) error {
+	fields, err := s.countryComplianceFields(countryID, txType)
+	if err != nil {
+		return err
+	}
+
+	var fieldErrs validation.Errors
+	required := make(map[string]bool, len(fields))
+	for _, field := range fields {
+		required[field.Name] = true
+
+		value, ok := values[field.Name]
+		if !ok || value == "" {
+			fieldErrs = append(fieldErrs, complianceFieldError(field.Name, "required", "", "is required in the transaction country"))
+			continue
+		}
+		pattern, err := compilePattern(field.Pattern)
+		if err != nil {
+			return fmt.Errorf("invalid pattern of compliance field %s: %w", field.Name, err)
+		}
+		if pattern != nil && !pattern.MatchString(value) {
+			fieldErrs = append(fieldErrs, complianceFieldError(field.Name, "pattern", field.Pattern, "does not match the required format"))
+		}
+	}
+
+	unexpected := make([]string, 0)
+	for name := range values {
+		if !required[name] {
+			unexpected = append(unexpected, name)
+		}
+	}
+	sort.Strings(unexpected)
+	for _, name := range unexpected {
+		fieldErrs = append(fieldErrs, complianceFieldError(name, "unexpected", "", "is not required in the transaction country"))
+	}
+
+	if len(fieldErrs) > 0 {
+		return fieldErrs
+	}
+	return nil
+}
+
+// requiredFor reports whether transactions of a type must carry a compliance field
+func requiredFor(field models.ComplianceField, txType string) bool {
+	if len(field.Types) == 0 {
+		return true
+	}
+	for _, t := range field.Types {
+		if t == txType {
+			return true
+		}
+	}
+	return false
+}
+
+// compilePattern compiles a compliance field pattern to match whole values, or returns nil for ""
+func compilePattern(pattern string) (*regexp.Regexp, error) {
+	if pattern == "" {
+		return nil, nil
+	}
+	return regexp.Compile(`^(?:` + pattern + `)$`)
+}
+
+// complianceFieldError reports an invalid compliance field value
+func complianceFieldError(name, rule, param, message string) models.FieldError {
+	return models.FieldError{Field: "compliance_fields." + name, Rule: rule, Param: param, Message: message}
+}
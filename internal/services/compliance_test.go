@@ -0,0 +1,100 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"payment-gateway/db"
+	"payment-gateway/internal/consts"
+	"payment-gateway/internal/gateway"
+	"payment-gateway/internal/models"
+	"payment-gateway/internal/validation"
+	"testing"
+)
+
+// TestComplianceFields tests that transactions must carry the compliance fields their country
+// requires of their type, which are stored with them and listed with the payment methods
+func TestComplianceFields(t *testing.T) {
+	mockDB := db.NewMockDB()
+	selector := gateway.NewSelector(mockDB)
+	selector.RegisterProvider(gateway.NewMockProvider(1, "Stripe", "application/json", 1.0, 0))
+	service := NewTransactionService(mockDB, selector)
+	ctx := context.Background()
+
+	fields, err := service.SetComplianceFields(ctx, "us", []models.ComplianceField{
+		{Name: "ssn_last4", Label: "Last 4 digits of SSN", Pattern: `[0-9]{4}`},
+		{Name: "tax_id", Label: "Tax ID", Types: []string{consts.Withdrawal}},
+	})
+	if err != nil || len(fields) != 2 {
+		t.Fatalf("Expected two compliance fields, got %+v, %v", fields, err)
+	}
+
+	methods, err := service.ListPaymentMethods(ctx, "US", "")
+	if err != nil || len(methods.RequiredFields) != 1 || methods.RequiredFields[0].Name != "ssn_last4" {
+		t.Errorf("Expected deposits to list only ssn_last4, got %+v, %v", methods, err)
+	}
+
+	tests := []struct {
+		name   string
+		values map[string]string
+		want   []string // rejected fields
+	}{
+		{"missing", nil, []string{"compliance_fields.ssn_last4"}},
+		{"malformed", map[string]string{"ssn_last4": "12345"}, []string{"compliance_fields.ssn_last4"}},
+		{"unexpected", map[string]string{"ssn_last4": "1234", "tax_id": "99-1234567"}, []string{"compliance_fields.tax_id"}},
+		{"valid", map[string]string{"ssn_last4": "1234"}, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			response, err := service.ProcessDeposit(ctx, models.TransactionRequest{UserID: 1, Amount: 10, Currency: "USD", ComplianceFields: tt.values})
+			if tt.want == nil {
+				if err != nil {
+					t.Fatalf("Expected no error, got: %v", err)
+				}
+				tx, err := mockDB.GetTransactionByID(response.TransactionID)
+				if err != nil || tx.ComplianceFields["ssn_last4"] != "1234" {
+					t.Errorf("Expected the compliance fields stored with the transaction, got %+v, %v", tx, err)
+				}
+				return
+			}
+
+			var fieldErrs validation.Errors
+			if !errors.As(err, &fieldErrs) || len(fieldErrs) != len(tt.want) {
+				t.Fatalf("Expected %d field errors, got: %v", len(tt.want), err)
+			}
+			for i, field := range tt.want {
+				if fieldErrs[i].Field != field {
+					t.Errorf("Expected %s rejected, got %+v", field, fieldErrs[i])
+				}
+			}
+		})
+	}
+
+	// Withdrawals must also carry the tax ID
+	_, err = service.ProcessWithdrawal(ctx, models.TransactionRequest{UserID: 1, Amount: 10, Currency: "USD", Beneficiary: "DE89370400440532013000", ComplianceFields: map[string]string{"ssn_last4": "1234"}})
+	var fieldErrs validation.Errors
+	if !errors.As(err, &fieldErrs) || len(fieldErrs) != 1 || fieldErrs[0].Field != "compliance_fields.tax_id" {
+		t.Errorf("Expected withdrawals without a tax ID rejected, got: %v", err)
+	}
+}
+
+// TestSetComplianceFieldsInvalid tests that invalid compliance field schemas are refused
+func TestSetComplianceFieldsInvalid(t *testing.T) {
+	service := NewTransactionService(db.NewMockDB(), gateway.NewSelector(db.NewMockDB()))
+	ctx := context.Background()
+
+	invalid := map[string][]models.ComplianceField{
+		"name":      {{Name: "CPF", Label: "CPF"}},
+		"duplicate": {{Name: "cpf", Label: "CPF"}, {Name: "cpf", Label: "CPF"}},
+		"pattern":   {{Name: "cpf", Label: "CPF", Pattern: "[0-9"}},
+	}
+	for name, fields := range invalid {
+		if _, err := service.SetComplianceFields(ctx, "US", fields); !errors.Is(err, ErrInvalidComplianceFields) {
+			t.Errorf("%s: expected ErrInvalidComplianceFields, got: %v", name, err)
+		}
+	}
+
+	if _, err := service.SetComplianceFields(ctx, "FR", nil); !errors.Is(err, ErrUnsupportedCountry) {
+		t.Errorf("Expected ErrUnsupportedCountry, got: %v", err)
+	}
+}
@@ -0,0 +1,147 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"payment-gateway/internal/consts"
+	"payment-gateway/internal/geo"
+	"payment-gateway/internal/models"
+	"sort"
+	"strings"
+)
+
+var (
+	ErrUnsupportedCountry = errors.New("unsupported country")
+)
+
+// countryResolution is the country a transaction is processed in and how it was determined
+type countryResolution struct {
+	countryID int
+	code      string // set once needed; see countryCode
+	source    string
+	flags     []string
+}
+
+// countrySignal is one source's opinion of where a transaction originates
+type countrySignal struct {
+	source string
+	code   string
+}
+
+// SetBINTable configures the card BIN to issuing country table used for country inference
+func (s *TransactionService) SetBINTable(table geo.BINTable) {
+	s.binTable = table
+}
+
+// resolveCountry determines the transaction country from the explicit request field, the card
+// BIN's issuing country or the client's GeoIP country, in that order, falling back to the user's
+// stored country. Signals that disagree are flagged for the fraud pipeline.
+func (s *TransactionService) resolveCountry(ctx context.Context, user *models.User, req models.TransactionRequest) (*countryResolution, error) {
+	resolution := &countryResolution{countryID: user.CountryID, source: consts.CountrySourceUser}
+
+	var signals []countrySignal
+	if req.CountryCode != "" {
+		signals = append(signals, countrySignal{consts.CountrySourceExplicit, geo.NormalizeCountryCode(req.CountryCode)})
+	}
+	if req.CardBIN != "" {
+		if code, ok := s.binTable.Lookup(req.CardBIN); ok {
+			signals = append(signals, countrySignal{consts.CountrySourceCardBIN, code})
+		}
+	}
+	if info, ok := geo.FromContext(ctx); ok && info.CountryCode != "" {
+		signals = append(signals, countrySignal{consts.CountrySourceGeoIP, info.CountryCode})
+	}
+
+	if len(signals) == 0 {
+		return resolution, nil
+	}
+
+	for _, signal := range signals {
+		country, err := s.db.GetCountryByCode(signal.code)
+		if err != nil {
+			// An explicit country must be one we process; inferred ones only inform risk checks
+			if signal.source == consts.CountrySourceExplicit {
+				return nil, fmt.Errorf("%w: %s", ErrUnsupportedCountry, signal.code)
+			}
+			continue
+		}
+
+		resolution.countryID = country.ID
+		resolution.code = country.Code
+		resolution.source = signal.source
+		break
+	}
+
+	userCountry, err := s.db.GetCountryByID(user.CountryID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get user country: %w", err)
+	}
+
+	if mismatch := countryMismatch(append(signals, countrySignal{consts.CountrySourceUser, userCountry.Code})); mismatch != "" {
+		resolution.flags = append(resolution.flags, consts.RiskCountryMismatch)
+		log.Printf("Country mismatch for user %d: %s", user.ID, mismatch)
+	}
+
+	return resolution, nil
+}
+
+// countryCode returns the ISO code of the resolved country, looking it up when the country was
+// taken from the user's profile
+func (s *TransactionService) countryCode(resolution *countryResolution) (string, error) {
+	if resolution.code == "" {
+		country, err := s.db.GetCountryByID(resolution.countryID)
+		if err != nil {
+			return "", fmt.Errorf("failed to get transaction country: %w", err)
+		}
+		resolution.code = country.Code
+	}
+	return resolution.code, nil
+}
+
+// countryByCode looks up a country by code for endpoints naming one
+func (s *TransactionService) countryByCode(code string) (*models.Country, error) {
+	country, err := s.db.GetCountryByCode(geo.NormalizeCountryCode(code))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, fmt.Errorf("%w: %s", ErrUnsupportedCountry, code)
+		}
+		return nil, fmt.Errorf("failed to get country: %w", err)
+	}
+	return country, nil
+}
+
+// normalizeWalletNumber converts a mobile-money wallet number to E.164 form, reading national
+// numbers as numbers of the transaction country
+func (s *TransactionService) normalizeWalletNumber(resolution *countryResolution, number string) (string, error) {
+	code, err := s.countryCode(resolution)
+	if err != nil {
+		return "", err
+	}
+
+	normalized, err := geo.NormalizePhoneNumber(number, code)
+	if err != nil {
+		return "", fmt.Errorf("%w for %s", err, code)
+	}
+	return normalized, nil
+}
+
+// countryMismatch describes the signals when they name more than one country, or returns ""
+func countryMismatch(signals []countrySignal) string {
+	codes := make(map[string]bool)
+	parts := make([]string, 0, len(signals))
+
+	for _, signal := range signals {
+		codes[signal.code] = true
+		parts = append(parts, signal.source+"="+signal.code)
+	}
+
+	if len(codes) <= 1 {
+		return ""
+	}
+
+	sort.Strings(parts)
+	return strings.Join(parts, " ")
+}
@@ -0,0 +1,159 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"payment-gateway/db"
+	"payment-gateway/internal/consts"
+	"payment-gateway/internal/gateway"
+	"payment-gateway/internal/geo"
+	"payment-gateway/internal/models"
+	"reflect"
+	"testing"
+)
+
+// TestResolveCountry tests country precedence and mismatch flagging across request signals
+func TestResolveCountry(t *testing.T) {
+	service := NewTransactionService(&mockDB{DBInterface: db.NewMockDB()}, &mockGatewaySelector{})
+	service.SetBINTable(geo.BINTable{"424242": "US", "535522": "GB", "999999": "FR"})
+
+	user := &models.User{ID: 1, CountryID: 1} // United States
+
+	tests := []struct {
+		name      string
+		req       models.TransactionRequest
+		geoIP     string
+		countryID int
+		source    string
+		flags     []string
+	}{
+		{"no signals", models.TransactionRequest{}, "", 1, consts.CountrySourceUser, nil},
+		{"matching GeoIP", models.TransactionRequest{}, "US", 1, consts.CountrySourceGeoIP, nil},
+		{"GeoIP mismatch", models.TransactionRequest{}, "DE", 3, consts.CountrySourceGeoIP, []string{consts.RiskCountryMismatch}},
+		{"card BIN before GeoIP", models.TransactionRequest{CardBIN: "53552212"}, "GB", 2, consts.CountrySourceCardBIN, []string{consts.RiskCountryMismatch}},
+		{"explicit before card BIN", models.TransactionRequest{CountryCode: "us", CardBIN: "424242"}, "", 1, consts.CountrySourceExplicit, nil},
+		{"unsupported inferred country", models.TransactionRequest{CardBIN: "999999"}, "", 1, consts.CountrySourceUser, []string{consts.RiskCountryMismatch}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			if tt.geoIP != "" {
+				ctx = geo.NewContext(ctx, geo.ClientInfo{CountryCode: tt.geoIP})
+			}
+
+			resolution, err := service.resolveCountry(ctx, user, tt.req)
+			if err != nil {
+				t.Fatalf("Expected no error, got: %v", err)
+			}
+
+			if resolution.countryID != tt.countryID || resolution.source != tt.source {
+				t.Errorf("Expected country %d from %s, got %d from %s", tt.countryID, tt.source, resolution.countryID, resolution.source)
+			}
+			if !reflect.DeepEqual(resolution.flags, tt.flags) {
+				t.Errorf("Expected flags %v, got %v", tt.flags, resolution.flags)
+			}
+		})
+	}
+}
+
+// TestProcessDepositRejectsUnsupportedCountry tests that an explicit country must be supported
+func TestProcessDepositRejectsUnsupportedCountry(t *testing.T) {
+	mockDB := &mockDB{
+		DBInterface: db.NewMockDB(),
+		getUserFunc: func(id int) (*models.User, error) {
+			return &models.User{ID: id, CountryID: 1}, nil
+		},
+	}
+
+	service := NewTransactionService(mockDB, &mockGatewaySelector{})
+
+	request := models.TransactionRequest{UserID: 1, Amount: 100.0, Currency: "USD", CountryCode: "ZZ"}
+
+	_, err := service.ProcessDeposit(context.Background(), request)
+	if !errors.Is(err, ErrUnsupportedCountry) {
+		t.Errorf("Expected ErrUnsupportedCountry, got: %v", err)
+	}
+}
+
+// TestProcessDepositRecordsCountrySignals tests that the resolved country and risk flags are stored
+func TestProcessDepositRecordsCountrySignals(t *testing.T) {
+	var created models.Transaction
+	var selectedCountry int
+
+	mockDB := &mockDB{
+		DBInterface: db.NewMockDB(),
+		getUserFunc: func(id int) (*models.User, error) {
+			return &models.User{ID: id, CountryID: 1}, nil
+		},
+		createTransactionFunc: func(tx models.Transaction) (int, error) {
+			created = tx
+			return 1, nil
+		},
+	}
+
+	mockSelector := &mockGatewaySelector{
+		selectGatewayFunc: func(ctx context.Context, countryID int, txType string) (gateway.Provider, error) {
+			selectedCountry = countryID
+			return &mockProvider{id: "1", name: "TestGateway", dataFormat: "application/json"}, nil
+		},
+	}
+
+	service := NewTransactionService(mockDB, mockSelector)
+
+	ctx := geo.NewContext(context.Background(), geo.ClientInfo{IP: "203.0.113.7", CountryCode: "GB"})
+	request := models.TransactionRequest{UserID: 1, Amount: 100.0, Currency: "GBP"}
+
+	if _, err := service.ProcessDeposit(ctx, request); err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+
+	if selectedCountry != 2 || created.CountryID != 2 {
+		t.Errorf("Expected gateway selection and transaction in country 2, got %d and %d", selectedCountry, created.CountryID)
+	}
+	if created.CountrySource != consts.CountrySourceGeoIP {
+		t.Errorf("Expected country source %s, got %s", consts.CountrySourceGeoIP, created.CountrySource)
+	}
+	if !reflect.DeepEqual(created.RiskFlags, []string{consts.RiskCountryMismatch}) {
+		t.Errorf("Expected country mismatch flag, got %v", created.RiskFlags)
+	}
+}
+
+// TestProcessWithdrawalNormalizesWalletNumber tests that mobile-money wallet numbers are read as
+// numbers of the transaction country and stored with their E.164 form
+func TestProcessWithdrawalNormalizesWalletNumber(t *testing.T) {
+	var created models.Transaction
+
+	mockDB := &mockDB{
+		DBInterface: db.NewMockDB(),
+		getUserFunc: func(id int) (*models.User, error) {
+			return &models.User{ID: id, CountryID: 2}, nil
+		},
+		createTransactionFunc: func(tx models.Transaction) (int, error) {
+			created = tx
+			return 1, nil
+		},
+	}
+
+	mockSelector := &mockGatewaySelector{
+		selectGatewayFunc: func(ctx context.Context, countryID int, txType string) (gateway.Provider, error) {
+			return &mockProvider{id: "1", name: "TestGateway", dataFormat: "application/json"}, nil
+		},
+	}
+
+	service := NewTransactionService(mockDB, mockSelector)
+
+	request := models.TransactionRequest{UserID: 1, Amount: 20.0, Currency: "GBP", PhoneNumber: "07911 123456"}
+	if _, err := service.ProcessWithdrawal(context.Background(), request); err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	if created.PhoneNumber != "07911 123456" || created.PhoneE164 != "+447911123456" {
+		t.Errorf("Expected raw and E.164 wallet numbers, got %q and %q", created.PhoneNumber, created.PhoneE164)
+	}
+
+	// A German number is not a valid UK wallet
+	request.PhoneNumber = "+49 1512 3456789"
+	if _, err := service.ProcessWithdrawal(context.Background(), request); !errors.Is(err, geo.ErrInvalidPhoneNumber) {
+		t.Errorf("Expected ErrInvalidPhoneNumber, got: %v", err)
+	}
+}
@@ -0,0 +1,215 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"payment-gateway/internal/consts"
+	"payment-gateway/internal/models"
+	"payment-gateway/internal/validation"
+	"sort"
+	"time"
+)
+
+// Decline report intervals
+const (
+	ReportDaily   = "day"
+	ReportWeekly  = "week"
+	ReportMonthly = "month"
+)
+
+var ErrInvalidDeclineReport = errors.New("invalid decline report")
+
+// DeclineReport breaks a merchant's declines down by period, gateway, country and card BIN range,
+// so systemic issues such as a gateway declining every card of one issuer stand out. Attempts are
+// the merchant's completed and failed transactions; declines are the ones a gateway declined.
+// Periods are UTC days, weeks starting on Monday, or calendar months. A tag limits the report to
+// the transactions carrying it.
+func (s *TransactionService) DeclineReport(ctx context.Context, merchantID int, query models.DeclineReportQuery) (*models.DeclineReport, error) {
+	from, to, err := declineReportRange(query, time.Now().UTC())
+	if err != nil {
+		return nil, err
+	}
+	interval := query.Interval
+	if interval == "" {
+		interval = ReportDaily
+	}
+	if interval != ReportDaily && interval != ReportWeekly && interval != ReportMonthly {
+		return nil, fmt.Errorf("%w: interval must be day, week or month", ErrInvalidDeclineReport)
+	}
+
+	tag := normalizeTag(query.Tag)
+	if tag != "" && !validation.IsTag(tag) {
+		return nil, fmt.Errorf("%w: invalid tag %q", ErrInvalidDeclineReport, query.Tag)
+	}
+
+	counts, err := s.db.GetDeclineCounts(merchantID, tag, from, to.AddDate(0, 0, 1))
+	if err != nil {
+		return nil, fmt.Errorf("failed to count declines: %w", err)
+	}
+
+	report := &models.DeclineReport{From: from.Format(dateLayout), To: to.Format(dateLayout), Interval: interval, Tag: tag}
+	var starts []string
+	periods := make(map[string]*models.DeclinePeriod)
+	for start := reportPeriodStart(from, interval); !start.After(to); start = nextReportPeriod(start, interval) {
+		starts = append(starts, start.Format(dateLayout))
+		periods[start.Format(dateLayout)] = &models.DeclinePeriod{Start: start.Format(dateLayout), Breakdown: []models.DeclineBreakdown{}}
+	}
+
+	type breakdownKey struct {
+		period    string
+		gatewayID int
+		countryID int
+		binRange  string
+	}
+	breakdowns := make(map[breakdownKey]*models.DeclineBreakdown)
+	declineCodes := make(map[breakdownKey]map[string]int)
+	countryCodes := make(map[int]string)
+
+	for _, count := range counts {
+		day, err := time.Parse(dateLayout, count.Day)
+		if err != nil {
+			return nil, fmt.Errorf("invalid decline count day %q: %w", count.Day, err)
+		}
+
+		key := breakdownKey{reportPeriodStart(day, interval).Format(dateLayout), count.GatewayID, count.CountryID, count.BINRange}
+		breakdown, ok := breakdowns[key]
+		if !ok {
+			code, ok := countryCodes[count.CountryID]
+			if !ok {
+				country, err := s.db.GetCountryByID(count.CountryID)
+				if err != nil {
+					return nil, fmt.Errorf("failed to get country %d: %w", count.CountryID, err)
+				}
+				code = country.Code
+				countryCodes[count.CountryID] = code
+			}
+
+			breakdown = &models.DeclineBreakdown{GatewayID: count.GatewayID, CountryCode: code, BINRange: count.BINRange}
+			breakdowns[key] = breakdown
+			declineCodes[key] = make(map[string]int)
+		}
+
+		breakdown.Attempts += count.Count
+		if count.DeclineCode != "" {
+			breakdown.Declines += count.Count
+			declineCodes[key][count.DeclineCode] += count.Count
+		}
+	}
+
+	for key, breakdown := range breakdowns {
+		period, ok := periods[key.period]
+		if !ok {
+			continue
+		}
+
+		breakdown.DeclineRate = declineRate(breakdown.Declines, breakdown.Attempts)
+		breakdown.DeclineCodes = []models.DeclineCodeCount{}
+		for code, count := range declineCodes[key] {
+			breakdown.DeclineCodes = append(breakdown.DeclineCodes, models.DeclineCodeCount{DeclineCode: code, Count: count})
+		}
+		sort.Slice(breakdown.DeclineCodes, func(i, j int) bool {
+			a, b := breakdown.DeclineCodes[i], breakdown.DeclineCodes[j]
+			if a.Count != b.Count {
+				return a.Count > b.Count
+			}
+			return a.DeclineCode < b.DeclineCode
+		})
+
+		period.Attempts += breakdown.Attempts
+		period.Declines += breakdown.Declines
+		period.Breakdown = append(period.Breakdown, *breakdown)
+	}
+
+	for _, start := range starts {
+		period := periods[start]
+		period.DeclineRate = declineRate(period.Declines, period.Attempts)
+		sortDeclineBreakdown(period.Breakdown)
+
+		report.Attempts += period.Attempts
+		report.Declines += period.Declines
+		report.Periods = append(report.Periods, *period)
+	}
+	report.DeclineRate = declineRate(report.Declines, report.Attempts)
+
+	return report, nil
+}
+
+// declineReportRange returns the first and last days a report covers, defaulting to the
+// consts.DeclineReportDefaultDays days up to today
+func declineReportRange(query models.DeclineReportQuery, now time.Time) (time.Time, time.Time, error) {
+	to := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+	if query.To != "" {
+		var err error
+		if to, err = time.Parse(dateLayout, query.To); err != nil {
+			return time.Time{}, time.Time{}, fmt.Errorf("%w: to %q is not YYYY-MM-DD", ErrInvalidDeclineReport, query.To)
+		}
+	}
+
+	from := to.AddDate(0, 0, 1-consts.DeclineReportDefaultDays)
+	if query.From != "" {
+		var err error
+		if from, err = time.Parse(dateLayout, query.From); err != nil {
+			return time.Time{}, time.Time{}, fmt.Errorf("%w: from %q is not YYYY-MM-DD", ErrInvalidDeclineReport, query.From)
+		}
+	}
+
+	if from.After(to) {
+		return time.Time{}, time.Time{}, fmt.Errorf("%w: from must not be after to", ErrInvalidDeclineReport)
+	}
+	if to.Sub(from) >= consts.MaxDeclineReportDays*24*time.Hour {
+		return time.Time{}, time.Time{}, fmt.Errorf("%w: reports cover at most %d days", ErrInvalidDeclineReport, consts.MaxDeclineReportDays)
+	}
+	return from, to, nil
+}
+
+// reportPeriodStart returns the first day of the period a day falls in
+func reportPeriodStart(day time.Time, interval string) time.Time {
+	switch interval {
+	case ReportWeekly:
+		return day.AddDate(0, 0, -(int(day.Weekday())+6)%7)
+	case ReportMonthly:
+		return time.Date(day.Year(), day.Month(), 1, 0, 0, 0, 0, time.UTC)
+	default:
+		return day
+	}
+}
+
+// nextReportPeriod returns the first day of the period after the one starting at start
+func nextReportPeriod(start time.Time, interval string) time.Time {
+	switch interval {
+	case ReportWeekly:
+		return start.AddDate(0, 0, 7)
+	case ReportMonthly:
+		return start.AddDate(0, 1, 0)
+	default:
+		return start.AddDate(0, 0, 1)
+	}
+}
+
+// sortDeclineBreakdown orders a period's breakdown by declines, then decline rate, most first
+func sortDeclineBreakdown(breakdown []models.DeclineBreakdown) {
+	sort.Slice(breakdown, func(i, j int) bool {
+		a, b := breakdown[i], breakdown[j]
+		switch {
+		case a.Declines != b.Declines:
+			return a.Declines > b.Declines
+		case a.DeclineRate != b.DeclineRate:
+			return a.DeclineRate > b.DeclineRate
+		case a.GatewayID != b.GatewayID:
+			return a.GatewayID < b.GatewayID
+		case a.CountryCode != b.CountryCode:
+			return a.CountryCode < b.CountryCode
+		default:
+			return a.BINRange < b.BINRange
+		}
+	})
+}
+
+// declineRate returns declines / attempts, or 0 when there were no attempts
+func declineRate(declines, attempts int) float64 {
+	if attempts == 0 {
+		return 0
+	}
+	return float64(declines) / float64(attempts)
+}
@@ -0,0 +1,81 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"payment-gateway/db"
+	"payment-gateway/internal/consts"
+	"payment-gateway/internal/models"
+	"testing"
+	"time"
+)
+
+// TestDeclineReport tests that declines are broken down by period, gateway, country and BIN range
+func TestDeclineReport(t *testing.T) {
+	mockDB := db.NewMockDB()
+	service := NewTransactionService(mockDB, newRulesSelector(mockDB))
+	ctx := context.Background()
+
+	// Monday 5 and Tuesday 6 October 2026
+	monday := time.Date(2026, 10, 5, 9, 0, 0, 0, time.UTC)
+	tuesday := monday.AddDate(0, 0, 1)
+	transactions := []models.Transaction{
+		{GatewayID: 1, CountryID: 1, CardBIN: "42424242", Status: consts.Failed, DeclineCode: consts.DeclineDoNotHonor, CreatedAt: monday},
+		{GatewayID: 1, CountryID: 1, CardBIN: "424242", Status: consts.Failed, DeclineCode: consts.DeclineDoNotHonor, CreatedAt: monday},
+		{GatewayID: 1, CountryID: 1, CardBIN: "424242", Status: consts.Failed, DeclineCode: consts.DeclineFraudSuspected, CreatedAt: tuesday},
+		{GatewayID: 1, CountryID: 1, CardBIN: "535522", Status: consts.Completed, CreatedAt: monday},
+		{GatewayID: 2, CountryID: 2, Status: consts.Completed, CreatedAt: tuesday},
+		{GatewayID: 2, CountryID: 2, Status: consts.Failed, ErrorMessage: "timeout", CreatedAt: tuesday},
+		{GatewayID: 1, CountryID: 1, CardBIN: "424242", Status: consts.Processing, CreatedAt: tuesday},
+		{GatewayID: 1, CountryID: 1, CardBIN: "424242", Status: consts.Failed, DeclineCode: consts.DeclineDoNotHonor, CreatedAt: monday.AddDate(0, 0, -7)},
+	}
+	for _, tx := range transactions {
+		tx.UserID = 1
+		tx.Type = consts.Deposit
+		if _, err := mockDB.CreateTransaction(tx); err != nil {
+			t.Fatalf("Failed to create transaction: %v", err)
+		}
+	}
+
+	report, err := service.DeclineReport(ctx, 1, models.DeclineReportQuery{From: "2026-10-05", To: "2026-10-06"})
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	if report.Interval != ReportDaily || len(report.Periods) != 2 || report.Attempts != 6 || report.Declines != 3 || report.DeclineRate != 0.5 {
+		t.Fatalf("Expected 3 of 6 attempts declined over 2 days, got %+v", report)
+	}
+
+	top := report.Periods[0].Breakdown[0]
+	if top.GatewayID != 1 || top.CountryCode != "US" || top.BINRange != "424242" || top.Attempts != 2 || top.Declines != 2 || top.DeclineRate != 1 {
+		t.Errorf("Expected every attempt of BIN range 424242 on gateway 1 declined on Monday, got %+v", top)
+	}
+	if len(top.DeclineCodes) != 1 || top.DeclineCodes[0] != (models.DeclineCodeCount{DeclineCode: consts.DeclineDoNotHonor, Count: 2}) {
+		t.Errorf("Expected 2 do_not_honor declines, got %+v", top.DeclineCodes)
+	}
+	if tuesday := report.Periods[1]; tuesday.Start != "2026-10-06" || tuesday.Attempts != 3 || tuesday.Declines != 1 || len(tuesday.Breakdown) != 2 {
+		t.Errorf("Expected 1 of 3 attempts declined on Tuesday, got %+v", tuesday)
+	}
+
+	weekly, err := service.DeclineReport(ctx, 1, models.DeclineReportQuery{From: "2026-09-28", To: "2026-10-11", Interval: ReportWeekly})
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	if len(weekly.Periods) != 2 || weekly.Periods[1].Start != "2026-10-05" || weekly.Periods[1].Declines != 3 || weekly.Periods[0].Declines != 1 {
+		t.Errorf("Expected weekly periods starting on Monday, got %+v", weekly.Periods)
+	}
+	if codes := weekly.Periods[1].Breakdown[0].DeclineCodes; len(codes) != 2 || codes[0].DeclineCode != consts.DeclineDoNotHonor {
+		t.Errorf("Expected decline codes with the most declines first, got %+v", codes)
+	}
+
+	invalid := []models.DeclineReportQuery{
+		{From: "05/10/2026"},
+		{From: "2026-10-06", To: "2026-10-05"},
+		{From: "2025-01-01", To: "2026-10-05"},
+		{Interval: "hour"},
+	}
+	for _, query := range invalid {
+		if _, err := service.DeclineReport(ctx, 1, query); !errors.Is(err, ErrInvalidDeclineReport) {
+			t.Errorf("Expected ErrInvalidDeclineReport for %+v, got: %v", query, err)
+		}
+	}
+}
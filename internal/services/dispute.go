@@ -0,0 +1,122 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"payment-gateway/internal/consts"
+	"payment-gateway/internal/models"
+	"time"
+)
+
+var (
+	ErrDisputeNotFound     = errors.New("dispute not found")
+	ErrDisputeExists       = errors.New("transaction is already disputed")
+	ErrDisputeClosed       = errors.New("dispute is already closed")
+	ErrTransactionNotFinal = errors.New("only completed or processing transactions can be disputed")
+	ErrInvalidDisputeQueue = errors.New("invalid dispute status")
+)
+
+// SubmitDispute opens a dispute for a transaction the user does not recognize and places it in
+// the review queue. Transactions of other users are reported as not found.
+func (s *TransactionService) SubmitDispute(ctx context.Context, txID int, req models.DisputeRequest) (*models.Dispute, error) {
+	tx, err := s.userTransaction(txID, req.UserID)
+	if err != nil {
+		return nil, err
+	}
+	if tx.Status != consts.Completed && tx.Status != consts.Processing {
+		return nil, ErrTransactionNotFinal
+	}
+
+	if _, err := s.db.GetDisputeByTransaction(txID); err == nil {
+		return nil, ErrDisputeExists
+	} else if !errors.Is(err, sql.ErrNoRows) {
+		return nil, err
+	}
+
+	dispute := models.Dispute{
+		TransactionID: txID,
+		UserID:        req.UserID,
+		Description:   req.Description,
+		Status:        consts.DisputeOpen,
+		CreatedAt:     time.Now(),
+	}
+	dispute.UpdatedAt = dispute.CreatedAt
+
+	id, err := s.db.CreateDispute(dispute)
+	if err != nil {
+		return nil, err
+	}
+	dispute.ID = id
+
+	log.Printf("User %d disputed transaction %d; dispute %d queued for review", req.UserID, txID, id)
+	return &dispute, nil
+}
+
+// GetDispute returns the dispute a user opened for their transaction
+func (s *TransactionService) GetDispute(ctx context.Context, txID, userID int) (*models.Dispute, error) {
+	if _, err := s.userTransaction(txID, userID); err != nil {
+		return nil, err
+	}
+
+	dispute, err := s.db.GetDisputeByTransaction(txID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrDisputeNotFound
+		}
+		return nil, err
+	}
+	return dispute, nil
+}
+
+// ListDisputes returns the review queue: disputes with the given status, open by default, oldest first
+func (s *TransactionService) ListDisputes(ctx context.Context, status string) ([]models.Dispute, error) {
+	if status == "" {
+		status = consts.DisputeOpen
+	}
+
+	switch status {
+	case consts.DisputeOpen, consts.DisputeInReview, consts.DisputeResolved, consts.DisputeRejected:
+	default:
+		return nil, ErrInvalidDisputeQueue
+	}
+
+	return s.db.ListDisputes(status, consts.MaxDisputeListResults)
+}
+
+// UpdateDispute moves a dispute through review. Resolved and rejected disputes are closed and
+// cannot change again.
+func (s *TransactionService) UpdateDispute(ctx context.Context, disputeID int, req models.DisputeUpdateRequest) (*models.Dispute, error) {
+	if err := s.db.UpdateDisputeStatus(disputeID, req.Status, req.Resolution, time.Now()); err != nil {
+		if !errors.Is(err, sql.ErrNoRows) {
+			return nil, err
+		}
+		if _, err := s.db.GetDisputeByID(disputeID); err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				return nil, ErrDisputeNotFound
+			}
+			return nil, err
+		}
+		return nil, ErrDisputeClosed
+	}
+
+	log.Printf("Dispute %d moved to %s", disputeID, req.Status)
+	return s.db.GetDisputeByID(disputeID)
+}
+
+// userTransaction fetches a transaction, reporting it as not found unless it belongs to the user
+func (s *TransactionService) userTransaction(txID, userID int) (*models.Transaction, error) {
+	tx, err := s.db.GetTransactionByID(txID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrTransactionNotFound
+		}
+		return nil, fmt.Errorf("failed to get transaction: %w", err)
+	}
+	if tx.UserID != userID {
+		return nil, ErrTransactionNotFound
+	}
+	return tx, nil
+}
@@ -0,0 +1,73 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"payment-gateway/db"
+	"payment-gateway/internal/consts"
+	"payment-gateway/internal/models"
+	"testing"
+)
+
+// TestDisputes tests that users dispute their own transactions once and reviewers close the case
+func TestDisputes(t *testing.T) {
+	mockDB := db.NewMockDB()
+	service := NewTransactionService(mockDB, newRulesSelector(mockDB))
+	ctx := context.Background()
+
+	completed, err := mockDB.CreateTransaction(models.Transaction{UserID: 1, Type: consts.Deposit, Amount: 25, Currency: "USD", Status: consts.Completed})
+	if err != nil {
+		t.Fatalf("Failed to create transaction: %v", err)
+	}
+	failed, err := mockDB.CreateTransaction(models.Transaction{UserID: 1, Type: consts.Deposit, Amount: 25, Currency: "USD", Status: consts.Failed})
+	if err != nil {
+		t.Fatalf("Failed to create transaction: %v", err)
+	}
+
+	if _, err := service.SubmitDispute(ctx, completed, models.DisputeRequest{UserID: 2}); !errors.Is(err, ErrTransactionNotFound) {
+		t.Errorf("Expected another user's transaction to be reported as not found, got: %v", err)
+	}
+	if _, err := service.SubmitDispute(ctx, failed, models.DisputeRequest{UserID: 1}); !errors.Is(err, ErrTransactionNotFinal) {
+		t.Errorf("Expected ErrTransactionNotFinal for a failed transaction, got: %v", err)
+	}
+	if _, err := service.GetDispute(ctx, completed, 1); !errors.Is(err, ErrDisputeNotFound) {
+		t.Errorf("Expected ErrDisputeNotFound before a dispute is opened, got: %v", err)
+	}
+
+	dispute, err := service.SubmitDispute(ctx, completed, models.DisputeRequest{UserID: 1, Description: "I don't recognize this charge"})
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	if dispute.Status != consts.DisputeOpen || dispute.TransactionID != completed {
+		t.Errorf("Expected an open dispute of transaction %d, got %+v", completed, dispute)
+	}
+	if _, err := service.SubmitDispute(ctx, completed, models.DisputeRequest{UserID: 1}); !errors.Is(err, ErrDisputeExists) {
+		t.Errorf("Expected ErrDisputeExists, got: %v", err)
+	}
+
+	queue, err := service.ListDisputes(ctx, "")
+	if err != nil || len(queue) != 1 || queue[0].ID != dispute.ID {
+		t.Fatalf("Expected the dispute in the review queue, got %+v: %v", queue, err)
+	}
+	if _, err := service.ListDisputes(ctx, "closed"); !errors.Is(err, ErrInvalidDisputeQueue) {
+		t.Errorf("Expected ErrInvalidDisputeQueue, got: %v", err)
+	}
+
+	if _, err := service.UpdateDispute(ctx, dispute.ID, models.DisputeUpdateRequest{Status: consts.DisputeResolved, Resolution: "Refunded"}); err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	got, err := service.GetDispute(ctx, completed, 1)
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	if got.Status != consts.DisputeResolved || got.Resolution != "Refunded" {
+		t.Errorf("Expected the user to see the resolution, got %+v", got)
+	}
+
+	if _, err := service.UpdateDispute(ctx, dispute.ID, models.DisputeUpdateRequest{Status: consts.DisputeRejected}); !errors.Is(err, ErrDisputeClosed) {
+		t.Errorf("Expected ErrDisputeClosed, got: %v", err)
+	}
+	if _, err := service.UpdateDispute(ctx, 99, models.DisputeUpdateRequest{Status: consts.DisputeInReview}); !errors.Is(err, ErrDisputeNotFound) {
+		t.Errorf("Expected ErrDisputeNotFound, got: %v", err)
+	}
+}
@@ -0,0 +1,104 @@
+package services
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"payment-gateway/internal/consts"
+	"payment-gateway/internal/models"
+	"time"
+)
+
+var (
+	ErrInvalidIdempotencyKey = errors.New("invalid idempotency key")
+	ErrIdempotencyKeyInUse   = errors.New("a request with this idempotency key is still in progress")
+	ErrIdempotencyKeyReused  = errors.New("idempotency key was already used for a different request")
+)
+
+// BeginIdempotentRequest claims an idempotency key for a request to route with the given body. Keys
+// are scoped to the caller sending them, so callers reusing each other's keys never collide. It
+// returns the claim, whose CompletedAt is zero, when the request should run: the caller then
+// records its response with CompleteIdempotentRequest. It returns the completed key instead when
+// the same request was sent with the key before, so its response is replayed. Keys expire after
+// consts.IdempotencyKeyTTL, and keys whose request ran longer than consts.IdempotencyKeyLockTimeout
+// are taken as abandoned.
+func (s *TransactionService) BeginIdempotentRequest(ctx context.Context, caller, route, key string, body []byte) (*models.IdempotencyKey, error) {
+	if len(key) > consts.MaxIdempotencyKeyLength {
+		return nil, fmt.Errorf("%w: keys are at most %d characters", ErrInvalidIdempotencyKey, consts.MaxIdempotencyKeyLength)
+	}
+
+	hash := sha256.Sum256(body)
+	now := time.Now().UTC()
+	claim := models.IdempotencyKey{
+		Caller:      caller,
+		Key:         key,
+		Route:       route,
+		RequestHash: hex.EncodeToString(hash[:]),
+		CreatedAt:   now,
+		ExpiresAt:   now.Add(consts.IdempotencyKeyTTL),
+	}
+
+	// A key found expired or abandoned is deleted and claimed again, once
+	for attempt := 0; attempt < 2; attempt++ {
+		err := s.db.CreateIdempotencyKey(claim)
+		if err == nil {
+			return &claim, nil
+		}
+		if !errors.Is(err, sql.ErrNoRows) {
+			return nil, err
+		}
+
+		existing, err := s.db.GetIdempotencyKey(caller, route, key)
+		if err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				// Purged since the claim failed
+				continue
+			}
+			return nil, err
+		}
+
+		abandoned := existing.CompletedAt.IsZero() && existing.CreatedAt.Before(now.Add(-consts.IdempotencyKeyLockTimeout))
+		if existing.ExpiresAt.Before(now) || abandoned {
+			if err := s.db.DeleteIdempotencyKey(caller, route, key, existing.CreatedAt); err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		if existing.RequestHash != claim.RequestHash {
+			return nil, ErrIdempotencyKeyReused
+		}
+		if existing.CompletedAt.IsZero() {
+			return nil, ErrIdempotencyKeyInUse
+		}
+		return existing, nil
+	}
+
+	return nil, ErrIdempotencyKeyInUse
+}
+
+// CompleteIdempotentRequest records the response to a claimed idempotency key's request, to be
+// replayed to retries. Server errors are recorded too: the request may have created a transaction
+// before failing, so running it again could charge twice.
+func (s *TransactionService) CompleteIdempotentRequest(ctx context.Context, claim *models.IdempotencyKey, statusCode int, contentType string, body []byte) error {
+	if err := s.db.CompleteIdempotencyKey(claim.Caller, claim.Route, claim.Key, statusCode, contentType, body, time.Now().UTC()); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return fmt.Errorf("idempotency key %q was released before its request completed", claim.Key)
+		}
+		return err
+	}
+	return nil
+}
+
+// PurgeExpiredIdempotencyKeys deletes idempotency keys past their expiry, returning how many were
+// deleted
+func (s *TransactionService) PurgeExpiredIdempotencyKeys(ctx context.Context) (int, error) {
+	purged, err := s.db.PurgeIdempotencyKeys(time.Now().UTC(), consts.IdempotencyKeyPurgeBatchSize)
+	if err != nil {
+		return purged, fmt.Errorf("failed to purge idempotency keys: %w", err)
+	}
+	return purged, nil
+}
@@ -0,0 +1,84 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"payment-gateway/db"
+	"payment-gateway/internal/consts"
+	"payment-gateway/internal/models"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestIdempotentRequests tests that requests repeating an idempotency key get the original
+// response, that keys are scoped to their caller and route, and that keys are reclaimed and purged
+func TestIdempotentRequests(t *testing.T) {
+	mockDB := db.NewMockDB()
+	service := NewTransactionService(mockDB, &mockGatewaySelector{})
+	ctx := context.Background()
+	body := []byte(`{"user_id":1,"amount":10,"currency":"USD"}`)
+
+	claim, err := service.BeginIdempotentRequest(ctx, "merchant:1", consts.DepositRoute, "key-1", body)
+	if err != nil || !claim.CompletedAt.IsZero() {
+		t.Fatalf("Expected the key claimed, got %+v (%v)", claim, err)
+	}
+
+	// Retries wait for the first request, and keys name one request only
+	if _, err := service.BeginIdempotentRequest(ctx, "merchant:1", consts.DepositRoute, "key-1", body); !errors.Is(err, ErrIdempotencyKeyInUse) {
+		t.Errorf("Expected ErrIdempotencyKeyInUse, got: %v", err)
+	}
+	if _, err := service.BeginIdempotentRequest(ctx, "merchant:1", consts.DepositRoute, "key-1", []byte(`{"amount":20}`)); !errors.Is(err, ErrIdempotencyKeyReused) {
+		t.Errorf("Expected ErrIdempotencyKeyReused, got: %v", err)
+	}
+	if other, err := service.BeginIdempotentRequest(ctx, "merchant:1", consts.WithdrawRoute, "key-1", body); err != nil || !other.CompletedAt.IsZero() {
+		t.Errorf("Expected keys scoped to their route, got %+v (%v)", other, err)
+	}
+	if other, err := service.BeginIdempotentRequest(ctx, "merchant:2", consts.DepositRoute, "key-1", []byte(`{"amount":20}`)); err != nil || !other.CompletedAt.IsZero() {
+		t.Errorf("Expected keys scoped to their caller, got %+v (%v)", other, err)
+	}
+	if _, err := service.BeginIdempotentRequest(ctx, "merchant:1", consts.DepositRoute, strings.Repeat("k", consts.MaxIdempotencyKeyLength+1), body); !errors.Is(err, ErrInvalidIdempotencyKey) {
+		t.Errorf("Expected ErrInvalidIdempotencyKey, got: %v", err)
+	}
+
+	// Completed requests are replayed
+	response := []byte(`{"status":"processing","transaction_id":1}`)
+	if err := service.CompleteIdempotentRequest(ctx, claim, 200, "application/json", response); err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	replay, err := service.BeginIdempotentRequest(ctx, "merchant:1", consts.DepositRoute, "key-1", body)
+	if err != nil || replay.CompletedAt.IsZero() || replay.StatusCode != 200 || string(replay.ResponseBody) != string(response) || replay.ContentType != "application/json" {
+		t.Fatalf("Expected the response replayed, got %+v (%v)", replay, err)
+	}
+
+	// Server errors are replayed too, as the request may have created a transaction
+	failed, err := service.BeginIdempotentRequest(ctx, "merchant:1", consts.DepositRoute, "key-2", body)
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	if err := service.CompleteIdempotentRequest(ctx, failed, 500, "application/json", []byte(`{}`)); err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	if retry, err := service.BeginIdempotentRequest(ctx, "merchant:1", consts.DepositRoute, "key-2", body); err != nil || retry.CompletedAt.IsZero() || retry.StatusCode != 500 {
+		t.Errorf("Expected the server error replayed, got %+v (%v)", retry, err)
+	}
+
+	// Abandoned and expired keys are claimed again
+	abandoned := models.IdempotencyKey{Caller: "merchant:1", Key: "key-3", Route: consts.DepositRoute, RequestHash: "x", CreatedAt: time.Now().UTC().Add(-consts.IdempotencyKeyLockTimeout - time.Minute), ExpiresAt: time.Now().UTC().Add(time.Hour)}
+	expired := models.IdempotencyKey{Caller: "merchant:1", Key: "key-4", Route: consts.DepositRoute, RequestHash: "x", CreatedAt: time.Now().UTC().Add(-48 * time.Hour), CompletedAt: time.Now().UTC().Add(-48 * time.Hour), ExpiresAt: time.Now().UTC().Add(-24 * time.Hour)}
+	for _, key := range []models.IdempotencyKey{abandoned, expired} {
+		if err := mockDB.CreateIdempotencyKey(key); err != nil {
+			t.Fatalf("Expected no error, got: %v", err)
+		}
+		if claim, err := service.BeginIdempotentRequest(ctx, "merchant:1", consts.DepositRoute, key.Key, body); err != nil || !claim.CompletedAt.IsZero() {
+			t.Errorf("Expected %s claimed again, got %+v (%v)", key.Key, claim, err)
+		}
+	}
+
+	if err := mockDB.CreateIdempotencyKey(models.IdempotencyKey{Caller: "merchant:1", Key: "key-5", Route: consts.DepositRoute, ExpiresAt: time.Now().UTC().Add(-time.Minute)}); err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	if purged, err := service.PurgeExpiredIdempotencyKeys(ctx); err != nil || purged != 1 {
+		t.Errorf("Expected 1 key purged, got %d (%v)", purged, err)
+	}
+}
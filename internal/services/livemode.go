@@ -0,0 +1,53 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"payment-gateway/internal/models"
+)
+
+var ErrLivemodeUnavailable = errors.New("livemode is only available in production deployments")
+
+// SetLiveTransactions allows transactions of livemode merchants to be sent to gateways' production
+// environments. It is enabled only in production deployments; elsewhere livemode transactions are
+// refused rather than sent to a sandbox.
+func (s *TransactionService) SetLiveTransactions(enabled bool) {
+	s.liveTransactions = enabled
+}
+
+// SetMerchantLivemode switches a merchant's transactions between gateways' sandbox and production
+// environments. Transactions already created keep the environment they were created in.
+func (s *TransactionService) SetMerchantLivemode(ctx context.Context, merchantID int, req models.MerchantLivemodeRequest) (*models.Merchant, error) {
+	if req.Livemode && !s.liveTransactions {
+		return nil, ErrLivemodeUnavailable
+	}
+
+	if err := s.db.SetMerchantLivemode(merchantID, req.Livemode); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrMerchantNotFound
+		}
+		return nil, err
+	}
+
+	log.Printf("Set livemode of merchant %d to %t", merchantID, req.Livemode)
+	return s.db.GetMerchantByID(merchantID)
+}
+
+// livemode reports whether a user's transactions go to gateways' production environments
+func (s *TransactionService) livemode(user *models.User) (bool, error) {
+	if user.MerchantID == 0 {
+		return false, nil
+	}
+
+	merchant, err := s.db.GetMerchantByID(user.MerchantID)
+	if err != nil {
+		return false, fmt.Errorf("failed to get merchant: %w", err)
+	}
+	if merchant.Livemode && !s.liveTransactions {
+		return false, ErrLivemodeUnavailable
+	}
+	return merchant.Livemode, nil
+}
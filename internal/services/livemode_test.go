@@ -0,0 +1,58 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"payment-gateway/db"
+	"payment-gateway/internal/gateway"
+	"payment-gateway/internal/models"
+	"testing"
+)
+
+// TestMerchantLivemode tests that livemode merchants' transactions go to production environments
+// only when live transactions are enabled
+func TestMerchantLivemode(t *testing.T) {
+	mockDB := db.NewMockDB()
+	service := NewTransactionService(mockDB, newRulesSelector(mockDB))
+	ctx := context.Background()
+	deposit := models.TransactionRequest{UserID: 1, Amount: 20, Currency: "USD"}
+
+	if _, err := service.SetMerchantLivemode(ctx, 1, models.MerchantLivemodeRequest{Livemode: true}); !errors.Is(err, ErrLivemodeUnavailable) {
+		t.Errorf("Expected ErrLivemodeUnavailable outside production, got: %v", err)
+	}
+
+	// A merchant switched to livemode directly is refused rather than sent to a sandbox
+	if err := mockDB.SetMerchantLivemode(1, true); err != nil {
+		t.Fatalf("Failed to set livemode: %v", err)
+	}
+	if _, err := service.ProcessDeposit(ctx, deposit); !errors.Is(err, ErrLivemodeUnavailable) {
+		t.Errorf("Expected ErrLivemodeUnavailable for a livemode deposit outside production, got: %v", err)
+	}
+
+	service.SetLiveTransactions(true)
+	if _, err := service.SetMerchantLivemode(ctx, 99, models.MerchantLivemodeRequest{Livemode: true}); !errors.Is(err, ErrMerchantNotFound) {
+		t.Errorf("Expected ErrMerchantNotFound, got: %v", err)
+	}
+
+	// Gateways without live credentials fail livemode transactions
+	response, err := service.ProcessDeposit(ctx, deposit)
+	if err == nil {
+		t.Fatalf("Expected the deposit to fail without a production environment, got %+v", response)
+	}
+	if !errors.Is(err, gateway.ErrNoProductionEnvironment) {
+		t.Errorf("Expected ErrNoProductionEnvironment, got: %v", err)
+	}
+
+	merchant, err := service.SetMerchantLivemode(ctx, 1, models.MerchantLivemodeRequest{Livemode: false})
+	if err != nil || merchant.Livemode {
+		t.Fatalf("Expected livemode disabled, got %+v: %v", merchant, err)
+	}
+	response, err = service.ProcessDeposit(ctx, deposit)
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	tx, err := mockDB.GetTransactionByID(response.TransactionID)
+	if err != nil || tx.Livemode {
+		t.Errorf("Expected a sandbox transaction, got %+v: %v", tx, err)
+	}
+}
@@ -0,0 +1,141 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"payment-gateway/db"
+	"payment-gateway/internal/consts"
+	"payment-gateway/internal/models"
+	"sync"
+	"time"
+)
+
+var (
+	ErrInvalidMaintenanceWindow  = errors.New("invalid maintenance window")
+	ErrMaintenanceWindowNotFound = errors.New("maintenance window not found")
+)
+
+// MaintenanceRouter takes gateways out of routing during their maintenance windows
+type MaintenanceRouter interface {
+	SetMaintenanceWindows(windows []models.MaintenanceWindow)
+}
+
+// MaintenanceService schedules gateway maintenance windows and keeps the router's copy of them
+// current. Windows are stored in the database, so every instance picks them up on its next
+// refresh; the router compares them with the clock, so a window starts and ends on time
+// between refreshes.
+type MaintenanceService struct {
+	db     db.DBInterface
+	router MaintenanceRouter
+
+	mu      sync.Mutex
+	windows []models.MaintenanceWindow // as of the last refresh
+	now     func() time.Time
+}
+
+// NewMaintenanceService creates a service applying maintenance windows to router
+func NewMaintenanceService(dbInterface db.DBInterface, router MaintenanceRouter) *MaintenanceService {
+	return &MaintenanceService{db: dbInterface, router: router, now: time.Now}
+}
+
+// Schedule stores a maintenance window for a gateway and applies it immediately
+func (s *MaintenanceService) Schedule(ctx context.Context, gatewayID int, request models.MaintenanceWindowRequest) (*models.MaintenanceWindow, error) {
+	now := s.now()
+	switch {
+	case !request.EndsAt.After(request.StartsAt):
+		return nil, fmt.Errorf("%w: ends_at must be after starts_at", ErrInvalidMaintenanceWindow)
+	case !request.EndsAt.After(now):
+		return nil, fmt.Errorf("%w: ends_at must be in the future", ErrInvalidMaintenanceWindow)
+	case request.EndsAt.Sub(request.StartsAt) > consts.MaxMaintenanceWindow:
+		return nil, fmt.Errorf("%w: must not be longer than %s", ErrInvalidMaintenanceWindow, consts.MaxMaintenanceWindow)
+	}
+
+	window := models.MaintenanceWindow{
+		GatewayID: gatewayID,
+		StartsAt:  request.StartsAt.UTC(),
+		EndsAt:    request.EndsAt.UTC(),
+		Reason:    request.Reason,
+		CreatedAt: now,
+	}
+
+	id, err := s.db.CreateMaintenanceWindow(window)
+	if err != nil {
+		return nil, err
+	}
+	window.ID = id
+	window.Active = !now.Before(window.StartsAt)
+
+	log.Printf("Scheduled maintenance of gateway %d from %s to %s", gatewayID, window.StartsAt.Format(time.RFC3339), window.EndsAt.Format(time.RFC3339))
+	if err := s.Refresh(ctx); err != nil {
+		log.Printf("Failed to refresh maintenance windows: %v", err)
+	}
+
+	return &window, nil
+}
+
+// List returns a gateway's current and upcoming maintenance windows
+func (s *MaintenanceService) List(ctx context.Context, gatewayID int) ([]models.MaintenanceWindow, error) {
+	now := s.now()
+	windows, err := s.db.GetMaintenanceWindows(gatewayID, now)
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range windows {
+		windows[i].Active = !now.Before(windows[i].StartsAt)
+	}
+	return windows, nil
+}
+
+// Cancel removes a maintenance window; cancelling one in progress returns the gateway to routing
+func (s *MaintenanceService) Cancel(ctx context.Context, gatewayID, windowID int) error {
+	if err := s.db.CancelMaintenanceWindow(gatewayID, windowID); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrMaintenanceWindowNotFound
+		}
+		return err
+	}
+
+	log.Printf("Cancelled maintenance window %d of gateway %d", windowID, gatewayID)
+	if err := s.Refresh(ctx); err != nil {
+		log.Printf("Failed to refresh maintenance windows: %v", err)
+	}
+	return nil
+}
+
+// Refresh reloads the current and upcoming windows of every gateway and applies them to the router
+func (s *MaintenanceService) Refresh(ctx context.Context) error {
+	windows, err := s.db.GetMaintenanceWindows(0, s.now())
+	if err != nil {
+		return fmt.Errorf("failed to fetch maintenance windows: %w", err)
+	}
+
+	s.mu.Lock()
+	s.windows = windows
+	s.mu.Unlock()
+
+	s.router.SetMaintenanceWindows(windows)
+	return nil
+}
+
+// Upcoming returns the windows in progress or starting within the warning period, as of the
+// last refresh, so status checks do not query the database
+func (s *MaintenanceService) Upcoming() []models.MaintenanceWindow {
+	now := s.now()
+	horizon := now.Add(consts.MaintenanceWarningPeriod)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	upcoming := []models.MaintenanceWindow{}
+	for _, window := range s.windows {
+		if window.EndsAt.After(now) && window.StartsAt.Before(horizon) {
+			window.Active = !now.Before(window.StartsAt)
+			upcoming = append(upcoming, window)
+		}
+	}
+	return upcoming
+}
@@ -0,0 +1,91 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"payment-gateway/db"
+	"payment-gateway/internal/consts"
+	"payment-gateway/internal/models"
+	"testing"
+	"time"
+)
+
+// recordingRouter keeps the maintenance windows last applied to it
+type recordingRouter struct {
+	windows []models.MaintenanceWindow
+}
+
+func (r *recordingRouter) SetMaintenanceWindows(windows []models.MaintenanceWindow) {
+	r.windows = windows
+}
+
+// TestMaintenanceWindows tests scheduling, warning of and cancelling maintenance windows
+func TestMaintenanceWindows(t *testing.T) {
+	router := &recordingRouter{}
+	service := NewMaintenanceService(db.NewMockDB(), router)
+	now := time.Date(2024, 3, 9, 12, 0, 0, 0, time.UTC)
+	service.now = func() time.Time { return now }
+	ctx := context.Background()
+
+	invalid := []models.MaintenanceWindowRequest{
+		{StartsAt: now.Add(time.Hour), EndsAt: now},
+		{StartsAt: now.Add(-2 * time.Hour), EndsAt: now.Add(-time.Hour)},
+		{StartsAt: now, EndsAt: now.Add(consts.MaxMaintenanceWindow + time.Hour)},
+	}
+	for _, request := range invalid {
+		if _, err := service.Schedule(ctx, 1, request); !errors.Is(err, ErrInvalidMaintenanceWindow) {
+			t.Errorf("Expected ErrInvalidMaintenanceWindow for %v-%v, got: %v", request.StartsAt, request.EndsAt, err)
+		}
+	}
+
+	active, err := service.Schedule(ctx, 1, models.MaintenanceWindowRequest{StartsAt: now.Add(-time.Minute), EndsAt: now.Add(time.Hour), Reason: "upgrade"})
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	if !active.Active {
+		t.Error("Expected window in progress to be active")
+	}
+	if _, err := service.Schedule(ctx, 2, models.MaintenanceWindowRequest{StartsAt: now.Add(2 * time.Hour), EndsAt: now.Add(3 * time.Hour)}); err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	if _, err := service.Schedule(ctx, 3, models.MaintenanceWindowRequest{StartsAt: now.Add(48 * time.Hour), EndsAt: now.Add(49 * time.Hour)}); err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+
+	if len(router.windows) != 3 {
+		t.Fatalf("Expected 3 windows applied to the router, got %d", len(router.windows))
+	}
+
+	// Windows beyond the warning period are not reported yet
+	upcoming := service.Upcoming()
+	if len(upcoming) != 2 || upcoming[0].GatewayID != 1 || !upcoming[0].Active || upcoming[1].GatewayID != 2 || upcoming[1].Active {
+		t.Errorf("Expected the active window of gateway 1 and the upcoming one of gateway 2, got %+v", upcoming)
+	}
+
+	if err := service.Cancel(ctx, 2, active.ID); !errors.Is(err, ErrMaintenanceWindowNotFound) {
+		t.Errorf("Expected ErrMaintenanceWindowNotFound for another gateway's window, got: %v", err)
+	}
+	if err := service.Cancel(ctx, 1, active.ID); err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	if err := service.Cancel(ctx, 1, active.ID); !errors.Is(err, ErrMaintenanceWindowNotFound) {
+		t.Errorf("Expected ErrMaintenanceWindowNotFound for a cancelled window, got: %v", err)
+	}
+
+	windows, err := service.List(ctx, 1)
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	if len(windows) != 0 || len(router.windows) != 2 {
+		t.Errorf("Expected cancelled window to be removed, got %d listed and %d applied", len(windows), len(router.windows))
+	}
+
+	// Ended windows drop out on the next refresh
+	now = now.Add(4 * time.Hour)
+	if err := service.Refresh(ctx); err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	if len(router.windows) != 1 || router.windows[0].GatewayID != 3 {
+		t.Errorf("Expected only gateway 3's window after refresh, got %+v", router.windows)
+	}
+}
@@ -0,0 +1,241 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"payment-gateway/internal/consts"
+	"payment-gateway/internal/gateway"
+	"payment-gateway/internal/models"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	ErrInvalidMandate             = errors.New("invalid mandate")
+	ErrMandateNotFound            = errors.New("mandate not found")
+	ErrMandateDebtorMismatch      = errors.New("the mandate's debtor is not the user")
+	ErrDirectDebitGatewayNotFound = errors.New("direct debit gateway not found")
+)
+
+// CreateMandate records a SEPA Direct Debit mandate a user signed, for the direct debit gateway
+// with an ID or the only one registered when the request names none. The mandate must be in the
+// user's full name, if they have one on file, as withdrawals naming it are paid out to its account.
+// It is given a unique mandate reference, quoted on every debit collected under it. The user must
+// be one of the merchant's.
+func (s *TransactionService) CreateMandate(ctx context.Context, merchantID int, req models.MandateRequest) (*models.Mandate, error) {
+	user, err := s.merchantUser(req.UserID, merchantID)
+	if err != nil {
+		return nil, err
+	}
+	if err := screeningHold(user); err != nil {
+		return nil, err
+	}
+	if user.FullName != "" && !holderNameMatches(user.FullName, req.DebtorName) {
+		return nil, ErrMandateDebtorMismatch
+	}
+
+	debiter, err := s.directDebitProvider(req.GatewayID)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	signedAt := req.SignedAt
+	if signedAt.IsZero() {
+		signedAt = now
+	}
+	if signedAt.After(now) {
+		return nil, fmt.Errorf("%w: the mandate cannot be signed in the future", ErrInvalidMandate)
+	}
+
+	reference, err := s.mandateRefs.New()
+	if err != nil {
+		return nil, err
+	}
+
+	iban := strings.ToUpper(strings.ReplaceAll(req.IBAN, " ", ""))
+	mandate := models.Mandate{
+		UserID:       user.ID,
+		GatewayID:    atoi(debiter.ID()),
+		Reference:    reference,
+		DebtorName:   req.DebtorName,
+		IBAN:         iban,
+		BIC:          req.BIC,
+		Last4:        iban[len(iban)-4:],
+		SequenceType: consts.SequenceFirst,
+		Status:       consts.MandateActive,
+		SignedAt:     signedAt,
+		CreatedAt:    now,
+	}
+	if mandate.ID, err = s.db.CreateMandate(mandate); err != nil {
+		return nil, fmt.Errorf("failed to store mandate: %w", err)
+	}
+	mandate.UpdatedAt = mandate.CreatedAt
+
+	log.Printf("User %d: recorded mandate %d (%s) at %s", user.ID, mandate.ID, mandate.Reference, debiter.Name())
+	return &mandate, nil
+}
+
+// ListMandates returns the SEPA mandates one of a merchant's users signed, active or revoked
+func (s *TransactionService) ListMandates(ctx context.Context, merchantID, userID int) ([]models.Mandate, error) {
+	if _, err := s.merchantUser(userID, merchantID); err != nil {
+		return nil, err
+	}
+	mandates, err := s.db.ListMandates(userID)
+	if err != nil {
+		return nil, err
+	}
+	if mandates == nil {
+		mandates = []models.Mandate{}
+	}
+	return mandates, nil
+}
+
+// RevokeMandate stops debiting deposits under a user's mandate and paying withdrawals out to its
+// account. Transactions already scheduled under it fail when they run. Mandates of other
+// merchants' users are reported as not found.
+func (s *TransactionService) RevokeMandate(ctx context.Context, merchantID, mandateID, userID int) error {
+	if _, err := s.merchantUser(userID, merchantID); err != nil {
+		if errors.Is(err, ErrUserNotFound) {
+			return ErrMandateNotFound
+		}
+		return err
+	}
+	mandate, err := s.userMandate(mandateID, userID)
+	if err != nil {
+		return err
+	}
+	if err := s.db.RevokeMandate(mandate.ID, "", time.Now()); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrMandateNotFound
+		}
+		return fmt.Errorf("failed to revoke mandate: %w", err)
+	}
+	return nil
+}
+
+// userMandate returns a user's active mandate, or ErrMandateNotFound when it belongs to another
+// user or was revoked
+func (s *TransactionService) userMandate(mandateID, userID int) (*models.Mandate, error) {
+	mandate, err := s.db.GetMandateByID(mandateID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrMandateNotFound
+		}
+		return nil, fmt.Errorf("failed to get mandate: %w", err)
+	}
+	if mandate.UserID != userID || mandate.Status != consts.MandateActive {
+		return nil, ErrMandateNotFound
+	}
+	return mandate, nil
+}
+
+// directDebitProvider returns the direct debit gateway with an ID, or the only one registered for 0
+func (s *TransactionService) directDebitProvider(gatewayID int) (gateway.DirectDebitProvider, error) {
+	var found []gateway.DirectDebitProvider
+	for _, provider := range s.gatewaySelector.Providers() {
+		debiter, ok := provider.(gateway.DirectDebitProvider)
+		if ok && (gatewayID == 0 || provider.ID() == strconv.Itoa(gatewayID)) {
+			found = append(found, debiter)
+		}
+	}
+	switch {
+	case len(found) == 0:
+		return nil, ErrDirectDebitGatewayNotFound
+	case len(found) > 1:
+		return nil, fmt.Errorf("%w: several gateways take direct debit mandates; name one with gateway_id", ErrInvalidMandate)
+	}
+	return found[0], nil
+}
+
+// validateMandate checks that a transaction naming a mandate names no other way to pay or be paid
+func validateMandate(req models.TransactionRequest) error {
+	if req.MandateID == 0 {
+		return nil
+	}
+	if req.PhoneNumber != "" || req.BankID != "" || req.BankAccountID != 0 || req.Beneficiary != "" {
+		return fmt.Errorf("%w: transactions under a mandate cannot name a wallet, bank, bank account or beneficiary", ErrInvalidMandate)
+	}
+	return nil
+}
+
+// applyMandate routes a transaction naming a mandate to the gateway the mandate was recorded for.
+// The mandate must be the user's and active, the gateway must take the transaction's currency, and
+// a preferred gateway in the request must be that gateway.
+func (s *TransactionService) applyMandate(opts *gateway.SelectionOptions, user *models.User, req models.TransactionRequest) error {
+	if req.MandateID == 0 {
+		return nil
+	}
+
+	mandate, err := s.userMandate(req.MandateID, user.ID)
+	if err != nil {
+		return err
+	}
+	debiter, err := s.directDebitProvider(mandate.GatewayID)
+	if err != nil {
+		return err
+	}
+	if !gateway.SupportsCurrency(debiter, req.Currency) {
+		return fmt.Errorf("%w: %s does not take %s", ErrInvalidMandate, debiter.Name(), req.Currency)
+	}
+
+	gatewayID := strconv.Itoa(mandate.GatewayID)
+	if opts.PreferredGatewayID != "" && opts.PreferredGatewayID != gatewayID {
+		return fmt.Errorf("%w: mandate %d is collected by gateway %s, not the preferred gateway %s", ErrInvalidMandate, mandate.ID, gatewayID, opts.PreferredGatewayID)
+	}
+	opts.PreferredGatewayID = gatewayID
+	opts.DirectDebit = true
+	return nil
+}
+
+// debit submits a deposit or withdrawal under its mandate: deposits are collected by direct debit
+// and withdrawals paid out to the mandate's account. Once a first debit was submitted, later ones
+// are sent as recurring.
+func (s *TransactionService) debit(ctx context.Context, debiter gateway.DirectDebitProvider, tx models.Transaction) (*models.TransactionResponse, error) {
+	// The mandate is read again as it may have been revoked since a scheduled transaction was created
+	mandate, err := s.userMandate(tx.MandateID, tx.UserID)
+	if err != nil {
+		return nil, fmt.Errorf("cannot pay under mandate %d: %w", tx.MandateID, err)
+	}
+
+	if tx.Type == consts.Withdrawal {
+		return debiter.CreditTransfer(ctx, tx, *mandate)
+	}
+
+	response, err := debiter.CollectDebit(ctx, tx, *mandate)
+	if err == nil && mandate.SequenceType == consts.SequenceFirst {
+		if err := s.db.UpdateMandateSequenceType(mandate.ID, consts.SequenceRecurring); err != nil {
+			log.Printf("Failed to update sequence type of mandate %d: %v", mandate.ID, err)
+		}
+	}
+	return response, err
+}
+
+// revokeReturnedMandate revokes the mandate of a deposit its gateway returned for a reason that
+// means the mandate can never be collected under again, such as the account being closed
+func (s *TransactionService) revokeReturnedMandate(data *models.CallbackData) {
+	tx, err := s.db.GetTransactionByID(data.TransactionID)
+	if err != nil || tx.MandateID == 0 || tx.Type != consts.Deposit {
+		return
+	}
+	provider, err := s.gatewaySelector.GetProviderByID(strconv.Itoa(tx.GatewayID))
+	if err != nil {
+		return
+	}
+	debiter, ok := provider.(gateway.DirectDebitProvider)
+	if !ok || !debiter.RevokesMandate(data.ReasonCode) {
+		return
+	}
+
+	if err := s.db.RevokeMandate(tx.MandateID, data.ReasonCode, time.Now()); err != nil {
+		if !errors.Is(err, sql.ErrNoRows) {
+			log.Printf("Failed to revoke mandate %d after return %s: %v", tx.MandateID, data.ReasonCode, err)
+		}
+		return
+	}
+	log.Printf("Revoked mandate %d: deposit %d was returned with %s", tx.MandateID, tx.ID, data.ReasonCode)
+}
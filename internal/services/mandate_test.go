@@ -0,0 +1,149 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"payment-gateway/db"
+	"payment-gateway/internal/consts"
+	"payment-gateway/internal/gateway"
+	"payment-gateway/internal/models"
+	"testing"
+	"time"
+)
+
+// mockDirectDebitProvider takes euros only and records the mandates it debited and paid out to
+type mockDirectDebitProvider struct {
+	*gateway.MockProvider
+	debited []models.Mandate
+	paidTo  []models.Mandate
+}
+
+func (p *mockDirectDebitProvider) SupportsCurrency(currency string) bool {
+	return currency == "EUR"
+}
+
+func (p *mockDirectDebitProvider) CollectDebit(ctx context.Context, transaction models.Transaction, mandate models.Mandate) (*models.TransactionResponse, error) {
+	p.debited = append(p.debited, mandate)
+	return &models.TransactionResponse{TransactionID: transaction.ID, Status: consts.Processing, GatewayReference: transaction.ReferenceID}, nil
+}
+
+func (p *mockDirectDebitProvider) CreditTransfer(ctx context.Context, transaction models.Transaction, mandate models.Mandate) (*models.TransactionResponse, error) {
+	p.paidTo = append(p.paidTo, mandate)
+	return &models.TransactionResponse{TransactionID: transaction.ID, Status: consts.Processing, GatewayReference: transaction.ReferenceID}, nil
+}
+
+func (p *mockDirectDebitProvider) RevokesMandate(reasonCode string) bool {
+	return reasonCode == "AC04"
+}
+
+// TestMandate tests that deposits and withdrawals naming a mandate are debited and paid out under it
+// at its gateway, and that a return for a closed account revokes it
+func TestMandate(t *testing.T) {
+	mockDB := db.NewMockDB()
+	userID, err := mockDB.CreateUser(models.User{Username: "erika", Email: "erika@example.com", CountryID: 2, MerchantID: 1, FullName: "Erika Mustermann"})
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+
+	debiter := &mockDirectDebitProvider{MockProvider: gateway.NewMockProvider(3, "SEPA", "application/xml", 1.0, 0)}
+	selector := gateway.NewSelector(mockDB)
+	selector.RegisterProvider(gateway.NewMockProvider(2, "Stripe", "application/json", 1.0, 0))
+	selector.RegisterProvider(debiter)
+	service := NewTransactionService(mockDB, selector)
+	ctx := context.Background()
+
+	if _, err := service.CreateMandate(ctx, 1, models.MandateRequest{UserID: userID, DebtorName: "Max Mustermann", IBAN: "DE89370400440532013000"}); !errors.Is(err, ErrMandateDebtorMismatch) {
+		t.Errorf("Expected ErrMandateDebtorMismatch for another debtor, got: %v", err)
+	}
+	if _, err := service.CreateMandate(ctx, 1, models.MandateRequest{UserID: userID, DebtorName: "Erika Mustermann", IBAN: "DE89370400440532013000", SignedAt: time.Now().Add(time.Hour)}); !errors.Is(err, ErrInvalidMandate) {
+		t.Errorf("Expected ErrInvalidMandate for a mandate signed in the future, got: %v", err)
+	}
+
+	mandate, err := service.CreateMandate(ctx, 1, models.MandateRequest{UserID: userID, DebtorName: "Erika Mustermann", IBAN: "de89 3704 0044 0532 0130 00"})
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	if mandate.GatewayID != 3 || mandate.IBAN != "DE89370400440532013000" || mandate.Last4 != "3000" ||
+		mandate.SequenceType != consts.SequenceFirst || mandate.Status != consts.MandateActive || mandate.Reference == "" {
+		t.Fatalf("Expected an active first mandate at gateway 3, got %+v", mandate)
+	}
+
+	// The first deposit is debited as a first debit and later ones as recurring
+	var depositIDs []int
+	for _, sequenceType := range []string{consts.SequenceFirst, consts.SequenceRecurring} {
+		response, err := service.ProcessDeposit(ctx, models.TransactionRequest{UserID: userID, Amount: 25, Currency: "EUR", MandateID: mandate.ID})
+		if err != nil {
+			t.Fatalf("Expected no error, got: %v", err)
+		}
+		depositIDs = append(depositIDs, response.TransactionID)
+		if tx, _ := mockDB.GetTransactionByID(response.TransactionID); tx.GatewayID != 3 || tx.MandateID != mandate.ID {
+			t.Errorf("Expected the deposit debited by gateway 3 under mandate %d, got %+v", mandate.ID, tx)
+		}
+		if last := debiter.debited[len(debiter.debited)-1]; last.SequenceType != sequenceType {
+			t.Errorf("Expected a %s debit, got %s", sequenceType, last.SequenceType)
+		}
+	}
+
+	// The deposits are still being collected, so the wallet is funded separately
+	if _, err := mockDB.CreateTransaction(models.Transaction{UserID: userID, Amount: 100, Currency: "EUR", Type: consts.Deposit, Status: consts.Completed, GatewayID: 2}); err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	response, err := service.ProcessWithdrawal(ctx, models.TransactionRequest{UserID: userID, Amount: 10, Currency: "EUR", MandateID: mandate.ID})
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	if len(debiter.paidTo) != 1 || debiter.paidTo[0].IBAN != mandate.IBAN {
+		t.Errorf("Expected a credit transfer to the mandate's account, got %+v", debiter.paidTo)
+	}
+
+	// Transactions without a mandate never reach the direct debit gateway
+	response, err = service.ProcessDeposit(ctx, models.TransactionRequest{UserID: userID, Amount: 25, Currency: "EUR"})
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	if tx, _ := mockDB.GetTransactionByID(response.TransactionID); tx.GatewayID != 2 {
+		t.Errorf("Expected the deposit routed to gateway 2, got %d", tx.GatewayID)
+	}
+	if _, err := service.ProcessDeposit(ctx, models.TransactionRequest{UserID: userID, Amount: 25, Currency: "USD", MandateID: mandate.ID}); !errors.Is(err, ErrInvalidMandate) {
+		t.Errorf("Expected ErrInvalidMandate for a deposit in USD, got: %v", err)
+	}
+	if _, err := service.ProcessDeposit(ctx, models.TransactionRequest{UserID: 1, Amount: 25, Currency: "EUR", MandateID: mandate.ID}); !errors.Is(err, ErrMandateNotFound) {
+		t.Errorf("Expected ErrMandateNotFound for another user's mandate, got: %v", err)
+	}
+
+	// A deposit returned for a closed account revokes the mandate
+	err = service.HandleCallback(ctx, &models.CallbackData{TransactionID: depositIDs[0], GatewayID: "3", Status: consts.Failed, ReasonCode: "AC04"})
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	if got, _ := mockDB.GetMandateByID(mandate.ID); got.Status != consts.MandateRevoked || got.RevocationReason != "AC04" {
+		t.Errorf("Expected the mandate revoked with AC04, got %+v", got)
+	}
+	if _, err := service.ProcessDeposit(ctx, models.TransactionRequest{UserID: userID, Amount: 25, Currency: "EUR", MandateID: mandate.ID}); !errors.Is(err, ErrMandateNotFound) {
+		t.Errorf("Expected ErrMandateNotFound for a revoked mandate, got: %v", err)
+	}
+	if err := service.RevokeMandate(ctx, 1, mandate.ID, userID); !errors.Is(err, ErrMandateNotFound) {
+		t.Errorf("Expected ErrMandateNotFound revoking it again, got: %v", err)
+	}
+	if mandates, _ := service.ListMandates(ctx, 1, userID); len(mandates) != 1 || mandates[0].Status != consts.MandateRevoked {
+		t.Errorf("Expected the revoked mandate listed, got %+v", mandates)
+	}
+
+	// Another merchant can neither record, list nor revoke its user's mandates
+	if _, err := service.CreateMandate(ctx, 2, models.MandateRequest{UserID: userID, DebtorName: "Erika Mustermann", IBAN: "DE89370400440532013000"}); !errors.Is(err, ErrUserNotFound) {
+		t.Errorf("Expected ErrUserNotFound recording a mandate for another merchant's user, got: %v", err)
+	}
+	if _, err := service.ListMandates(ctx, 2, userID); !errors.Is(err, ErrUserNotFound) {
+		t.Errorf("Expected ErrUserNotFound listing another merchant's user's mandates, got: %v", err)
+	}
+	active, err := service.CreateMandate(ctx, 1, models.MandateRequest{UserID: userID, DebtorName: "Erika Mustermann", IBAN: "DE89370400440532013000"})
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	if err := service.RevokeMandate(ctx, 2, active.ID, userID); !errors.Is(err, ErrMandateNotFound) {
+		t.Errorf("Expected ErrMandateNotFound revoking another merchant's user's mandate, got: %v", err)
+	}
+	if got, _ := mockDB.GetMandateByID(active.ID); got.Status != consts.MandateActive {
+		t.Errorf("Expected the mandate to stay active, got %+v", got)
+	}
+}
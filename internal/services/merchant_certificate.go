@@ -0,0 +1,218 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"payment-gateway/db"
+	"payment-gateway/internal/consts"
+	"payment-gateway/internal/models"
+	"payment-gateway/internal/paytoken"
+	"payment-gateway/internal/utils"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	ErrInvalidMerchantCertificate  = errors.New("invalid merchant certificate")
+	ErrMerchantCertificateNotFound = errors.New("merchant certificate not found")
+)
+
+// MerchantCertificateService manages the key pairs Apple Pay and Google Pay encrypt payment
+// tokens to, and decrypts tokens with them for gateways that take cards rather than tokens.
+// Private keys are stored encrypted. A wallet can have several active keys while one is rotated.
+// Keys are loaded into memory and refreshed periodically, so every instance picks up a rotation
+// without a restart.
+type MerchantCertificateService struct {
+	db db.DBInterface
+
+	mu        sync.RWMutex
+	keys      map[string][]*utils.MerchantKey // active keys per wallet, newest first
+	decrypter *paytoken.Decrypter
+	now       func() time.Time
+}
+
+// NewMerchantCertificateService creates a new merchant certificate service. Tokens can only be
+// decrypted once SetDecrypter configured the wallets' roots of trust.
+func NewMerchantCertificateService(dbInterface db.DBInterface) *MerchantCertificateService {
+	return &MerchantCertificateService{
+		db:   dbInterface,
+		keys: make(map[string][]*utils.MerchantKey),
+		now:  time.Now,
+	}
+}
+
+// SetDecrypter configures the Apple root certificates and Google root keys tokens' signatures are
+// verified against
+func (s *MerchantCertificateService) SetDecrypter(decrypter *paytoken.Decrypter) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.decrypter = decrypter
+}
+
+// Add stores a key pair as an active key of a wallet and starts decrypting tokens with it. Apple
+// Pay keys need the payment processing certificate Apple issued for them, which must be valid now;
+// Google Pay keys come without one. The private key is stored encrypted and never returned.
+func (s *MerchantCertificateService) Add(ctx context.Context, request models.MerchantCertificateRequest) (*models.MerchantCertificate, error) {
+	if request.Wallet == consts.WalletGooglePay && strings.TrimSpace(request.Certificate) != "" {
+		return nil, fmt.Errorf("%w: Google Pay keys have no certificate", ErrInvalidMerchantCertificate)
+	}
+	if request.Wallet == consts.WalletApplePay && strings.TrimSpace(request.Certificate) == "" {
+		return nil, fmt.Errorf("%w: Apple Pay keys need their payment processing certificate", ErrInvalidMerchantCertificate)
+	}
+
+	key, err := utils.ParseMerchantKey(request.Certificate, request.PrivateKey)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidMerchantCertificate, err)
+	}
+
+	now := s.now()
+	record := models.MerchantCertificate{
+		Wallet:    request.Wallet,
+		Status:    consts.MerchantCertificateActive,
+		CreatedAt: now,
+	}
+	if key.Certificate != nil {
+		switch {
+		case key.MerchantIdentifier == nil:
+			return nil, fmt.Errorf("%w: not an Apple Pay payment processing certificate", ErrInvalidMerchantCertificate)
+		case now.After(key.Certificate.NotAfter):
+			return nil, fmt.Errorf("%w: expired at %s", ErrInvalidMerchantCertificate, key.Certificate.NotAfter.Format(time.RFC3339))
+		case now.Before(key.Certificate.NotBefore):
+			return nil, fmt.Errorf("%w: not valid until %s", ErrInvalidMerchantCertificate, key.Certificate.NotBefore.Format(time.RFC3339))
+		}
+		record.Certificate = strings.TrimSpace(request.Certificate)
+		record.MerchantIdentifier = hex.EncodeToString(key.MerchantIdentifier)
+		record.NotAfter = key.Certificate.NotAfter.UTC()
+	}
+
+	if record.PublicKey, err = key.PublicKey(); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidMerchantCertificate, err)
+	}
+	if record.PublicKeyHash, err = key.PublicKeyHash(); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidMerchantCertificate, err)
+	}
+	if record.PrivateKey, err = utils.EncryptString(request.PrivateKey); err != nil {
+		return nil, fmt.Errorf("failed to encrypt private key: %w", err)
+	}
+
+	id, err := s.db.CreateMerchantCertificate(record)
+	if err != nil {
+		return nil, err
+	}
+	record.ID = id
+	record.PrivateKey = ""
+
+	log.Printf("Added %s merchant certificate %d (%s)", record.Wallet, id, record.PublicKeyHash)
+	if err := s.Refresh(ctx); err != nil {
+		log.Printf("Failed to refresh merchant certificates: %v", err)
+	}
+
+	return &record, nil
+}
+
+// List returns a wallet's active and retired merchant certificates, or every wallet's when wallet
+// is empty, without their private keys
+func (s *MerchantCertificateService) List(ctx context.Context, wallet string) ([]models.MerchantCertificate, error) {
+	certificates, err := s.db.GetMerchantCertificates(wallet)
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range certificates {
+		certificates[i].PrivateKey = ""
+	}
+	return certificates, nil
+}
+
+// Retire stops decrypting tokens with a merchant certificate. Tokens encrypted to it can then only
+// be paid at gateways taking the token itself.
+func (s *MerchantCertificateService) Retire(ctx context.Context, certificateID int) error {
+	if err := s.db.RetireMerchantCertificate(certificateID); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrMerchantCertificateNotFound
+		}
+		return err
+	}
+
+	log.Printf("Retired merchant certificate %d", certificateID)
+	if err := s.Refresh(ctx); err != nil {
+		log.Printf("Failed to refresh merchant certificates: %v", err)
+	}
+	return nil
+}
+
+// Refresh reloads the active merchant certificates of every wallet. Expired Apple Pay
+// certificates are skipped, and those expiring within consts.ClientCertificateExpiryWarning are
+// logged.
+func (s *MerchantCertificateService) Refresh(ctx context.Context) error {
+	records, err := s.db.GetMerchantCertificates("")
+	if err != nil {
+		return fmt.Errorf("failed to fetch merchant certificates: %w", err)
+	}
+
+	now := s.now()
+	keys := make(map[string][]*utils.MerchantKey)
+	// Newest first, so tokens are decrypted with the latest key first during a rotation
+	for i := len(records) - 1; i >= 0; i-- {
+		record := records[i]
+		if record.Status != consts.MerchantCertificateActive {
+			continue
+		}
+		if !record.NotAfter.IsZero() {
+			if now.After(record.NotAfter) {
+				log.Printf("Merchant certificate %d of %s expired at %s", record.ID, record.Wallet, record.NotAfter.Format(time.RFC3339))
+				continue
+			}
+			if record.NotAfter.Sub(now) < consts.ClientCertificateExpiryWarning {
+				log.Printf("Merchant certificate %d of %s expires at %s; add its replacement", record.ID, record.Wallet, record.NotAfter.Format(time.RFC3339))
+			}
+		}
+
+		key, err := loadMerchantKey(record)
+		if err != nil {
+			log.Printf("Failed to load merchant certificate %d of %s: %v", record.ID, record.Wallet, err)
+			continue
+		}
+		keys[record.Wallet] = append(keys[record.Wallet], key)
+	}
+
+	s.mu.Lock()
+	s.keys = keys
+	s.mu.Unlock()
+	return nil
+}
+
+// Decrypt verifies a wallet payment token and decrypts the card it carries with the wallet's
+// active keys
+func (s *MerchantCertificateService) Decrypt(token models.WalletToken) (*models.WalletCard, error) {
+	s.mu.RLock()
+	decrypter, keys := s.decrypter, s.keys[token.Type]
+	s.mu.RUnlock()
+
+	if decrypter == nil {
+		return nil, fmt.Errorf("%w: %s tokens cannot be decrypted, no roots of trust are configured", ErrInvalidWalletToken, token.Type)
+	}
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("%w: no active %s merchant certificate", ErrInvalidWalletToken, token.Type)
+	}
+
+	card, err := decrypter.Decrypt(token, keys)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidWalletToken, err)
+	}
+	return card, nil
+}
+
+// loadMerchantKey decrypts a stored certificate's private key and parses the pair
+func loadMerchantKey(record models.MerchantCertificate) (*utils.MerchantKey, error) {
+	key, err := utils.DecryptString(record.PrivateKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt private key: %w", err)
+	}
+	return utils.ParseMerchantKey(record.Certificate, key)
+}
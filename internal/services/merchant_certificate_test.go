@@ -0,0 +1,191 @@
+package services
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"math/big"
+	"payment-gateway/db"
+	"payment-gateway/internal/consts"
+	"payment-gateway/internal/gateway"
+	"payment-gateway/internal/models"
+	"payment-gateway/internal/paytoken"
+	"strings"
+	"testing"
+	"time"
+)
+
+// merchantKeyPEM returns a P-256 key as PEM, with a certificate for it carrying an Apple Pay
+// merchant ID when merchantID is not empty
+func merchantKeyPEM(t *testing.T, merchantID string, notAfter time.Time) (certificatePEM, keyPEM string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+	der, _ := x509.MarshalPKCS8PrivateKey(key)
+	keyPEM = string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}))
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "Apple Pay Payment Processing:" + merchantID},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     notAfter,
+	}
+	if merchantID != "" {
+		hash := sha256.Sum256([]byte(merchantID))
+		value, _ := asn1.Marshal(hex.EncodeToString(hash[:]))
+		template.ExtraExtensions = []pkix.Extension{{Id: asn1.ObjectIdentifier{1, 2, 840, 113635, 100, 6, 32}, Value: value}}
+	}
+	certificate, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("Failed to create certificate: %v", err)
+	}
+	return string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: certificate})), keyPEM
+}
+
+// TestMerchantCertificates tests that wallet keys are validated, stored without exposing their
+// private keys, loaded for decryption and retired
+func TestMerchantCertificates(t *testing.T) {
+	mockDB := db.NewMockDB()
+	service := NewMerchantCertificateService(mockDB)
+	ctx := context.Background()
+
+	appleCertificate, appleKey := merchantKeyPEM(t, "merchant.com.example", time.Now().Add(24*time.Hour))
+	apple, err := service.Add(ctx, models.MerchantCertificateRequest{Wallet: consts.WalletApplePay, Certificate: appleCertificate, PrivateKey: appleKey})
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	hash := sha256.Sum256([]byte("merchant.com.example"))
+	if apple.MerchantIdentifier != hex.EncodeToString(hash[:]) || apple.PublicKeyHash == "" || apple.PrivateKey != "" || apple.NotAfter.IsZero() {
+		t.Errorf("Expected an Apple Pay certificate without its private key, got %+v", apple)
+	}
+
+	_, googleKey := merchantKeyPEM(t, "", time.Now().Add(24*time.Hour))
+	google, err := service.Add(ctx, models.MerchantCertificateRequest{Wallet: consts.WalletGooglePay, PrivateKey: googleKey})
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	if google.PublicKey == "" || google.Certificate != "" || google.Status != consts.MerchantCertificateActive {
+		t.Errorf("Expected an active Google Pay key with its public key, got %+v", google)
+	}
+
+	certificates, err := service.List(ctx, consts.WalletGooglePay)
+	if err != nil || len(certificates) != 1 || certificates[0].ID != google.ID || certificates[0].PrivateKey != "" {
+		t.Errorf("Expected the Google Pay key without its private key, got %+v (%v)", certificates, err)
+	}
+	if stored, _ := mockDB.GetMerchantCertificates(consts.WalletGooglePay); len(stored) != 1 || stored[0].PrivateKey == googleKey {
+		t.Errorf("Expected the private key stored encrypted")
+	}
+
+	otherCertificate, _ := merchantKeyPEM(t, "merchant.com.example", time.Now().Add(24*time.Hour))
+	plainCertificate, plainKey := merchantKeyPEM(t, "", time.Now().Add(24*time.Hour))
+	expiredCertificate, expiredKey := merchantKeyPEM(t, "merchant.com.example", time.Now().Add(-time.Minute))
+	invalid := []struct {
+		name    string
+		request models.MerchantCertificateRequest
+	}{
+		{"Apple Pay key without a certificate", models.MerchantCertificateRequest{Wallet: consts.WalletApplePay, PrivateKey: appleKey}},
+		{"Google Pay key with a certificate", models.MerchantCertificateRequest{Wallet: consts.WalletGooglePay, Certificate: appleCertificate, PrivateKey: appleKey}},
+		{"certificate of another key", models.MerchantCertificateRequest{Wallet: consts.WalletApplePay, Certificate: otherCertificate, PrivateKey: appleKey}},
+		{"certificate without a merchant ID", models.MerchantCertificateRequest{Wallet: consts.WalletApplePay, Certificate: plainCertificate, PrivateKey: plainKey}},
+		{"expired certificate", models.MerchantCertificateRequest{Wallet: consts.WalletApplePay, Certificate: expiredCertificate, PrivateKey: expiredKey}},
+	}
+	for _, tt := range invalid {
+		if _, err := service.Add(ctx, tt.request); !errors.Is(err, ErrInvalidMerchantCertificate) {
+			t.Errorf("%s: expected ErrInvalidMerchantCertificate, got: %v", tt.name, err)
+		}
+	}
+
+	// Tokens reach the decrypter once roots of trust are configured, and need an active key
+	token := models.WalletToken{Type: consts.WalletGooglePay, Data: `{"protocolVersion":"ECv2"}`}
+	if _, err := service.Decrypt(token); !errors.Is(err, ErrInvalidWalletToken) {
+		t.Errorf("Expected ErrInvalidWalletToken without roots of trust, got: %v", err)
+	}
+	service.SetDecrypter(&paytoken.Decrypter{})
+	if _, err := service.Decrypt(token); !errors.Is(err, ErrInvalidWalletToken) || !strings.Contains(err.Error(), paytoken.ErrInvalidToken.Error()) {
+		t.Errorf("Expected the decrypter to reject the token, got: %v", err)
+	}
+
+	if err := service.Retire(ctx, google.ID); err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	if _, err := service.Decrypt(token); !errors.Is(err, ErrInvalidWalletToken) || strings.Contains(err.Error(), paytoken.ErrInvalidToken.Error()) {
+		t.Errorf("Expected no active key after retiring it, got: %v", err)
+	}
+	if err := service.Retire(ctx, google.ID); !errors.Is(err, ErrMerchantCertificateNotFound) {
+		t.Errorf("Expected ErrMerchantCertificateNotFound retiring it again, got: %v", err)
+	}
+}
+
+// mockWalletTokenProvider takes Google Pay tokens as they are and records those it was sent
+type mockWalletTokenProvider struct {
+	*gateway.MockProvider
+	tokens []*models.WalletToken
+}
+
+func (p *mockWalletTokenProvider) AcceptsWalletToken(wallet string) bool {
+	return wallet == consts.WalletGooglePay
+}
+
+func (p *mockWalletTokenProvider) ProcessDeposit(ctx context.Context, transaction models.Transaction) (*models.TransactionResponse, error) {
+	p.tokens = append(p.tokens, transaction.WalletToken)
+	return p.MockProvider.ProcessDeposit(ctx, transaction)
+}
+
+// TestWalletTokenDeposit tests that deposits paid with a wallet token are routed to gateways taking
+// the token or cards, and that tokens which can't be decrypted fail the deposit
+func TestWalletTokenDeposit(t *testing.T) {
+	mockDB := db.NewMockDB()
+	userID, err := mockDB.CreateUser(models.User{Username: "alice", Email: "alice@example.com", CountryID: 1, MerchantID: 1})
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+
+	wallets := &mockWalletTokenProvider{MockProvider: gateway.NewMockProvider(3, "Adyen", "application/json", 1.0, 0)}
+	selector := gateway.NewSelector(mockDB)
+	selector.RegisterProvider(gateway.NewMockOpenBankingProvider(5, "Open Banking", nil))
+	selector.RegisterProvider(wallets)
+	service := NewTransactionService(mockDB, selector)
+	ctx := context.Background()
+
+	token := &models.WalletToken{Type: consts.WalletGooglePay, Data: `{"protocolVersion":"ECv2"}`}
+	response, err := service.ProcessDeposit(ctx, models.TransactionRequest{UserID: userID, Amount: 50, Currency: "USD", PaymentToken: token})
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	if tx, _ := mockDB.GetTransactionByID(response.TransactionID); tx.GatewayID != 3 {
+		t.Errorf("Expected the deposit routed to gateway 3, got %+v", tx)
+	}
+	if len(wallets.tokens) != 1 || wallets.tokens[0] != token {
+		t.Errorf("Expected the token forwarded as it is, got %v", wallets.tokens)
+	}
+
+	invalid := []struct {
+		name    string
+		process func(context.Context, models.TransactionRequest) (*models.TransactionResponse, error)
+		req     models.TransactionRequest
+	}{
+		{"withdrawal", service.ProcessWithdrawal, models.TransactionRequest{UserID: userID, Amount: 50, Currency: "USD", PaymentToken: token}},
+		{"with a card BIN", service.ProcessDeposit, models.TransactionRequest{UserID: userID, Amount: 50, Currency: "USD", PaymentToken: token, CardBIN: "411111"}},
+		{"preferred gateway without cards", service.ProcessDeposit, models.TransactionRequest{UserID: userID, Amount: 50, Currency: "USD", PaymentToken: token, PreferredGatewayID: 5}},
+		// Gateway 3 takes Apple Pay tokens as cards only, and no merchant certificate decrypts them
+		{"undecryptable token", service.ProcessDeposit, models.TransactionRequest{UserID: userID, Amount: 50, Currency: "USD",
+			PaymentToken: &models.WalletToken{Type: consts.WalletApplePay, Data: `{"version":"EC_v1"}`}}},
+	}
+	for _, tt := range invalid {
+		if _, err := tt.process(ctx, tt.req); !errors.Is(err, ErrInvalidWalletToken) {
+			t.Errorf("%s: expected ErrInvalidWalletToken, got: %v", tt.name, err)
+		}
+	}
+	if len(wallets.tokens) != 1 {
+		t.Errorf("Expected no other deposit sent to the gateway, got %d", len(wallets.tokens))
+	}
+}
@@ -0,0 +1,193 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"payment-gateway/db"
+	"payment-gateway/internal/consts"
+	"payment-gateway/internal/events"
+	"payment-gateway/internal/models"
+	"payment-gateway/internal/utils"
+	"payment-gateway/sdk/webhook"
+	"time"
+)
+
+var (
+	ErrNoMerchantWebhookSecret = errors.New("merchant has no webhook signing secret; create one first")
+	ErrInvalidWebhookCheck     = errors.New("invalid webhook check")
+)
+
+// MerchantWebhookService manages the secret a merchant's webhooks are signed with and gives
+// merchants signed samples to test their signature verification against during integration
+type MerchantWebhookService struct {
+	db  db.DBInterface
+	now func() time.Time
+}
+
+// NewMerchantWebhookService creates a new merchant webhook service
+func NewMerchantWebhookService(dbInterface db.DBInterface) *MerchantWebhookService {
+	return &MerchantWebhookService{db: dbInterface, now: time.Now}
+}
+
+// RollSecret generates a new signing secret for a merchant, replacing any previous one. Deliveries
+// are signed with the new secret from the next attempt. The plaintext secret is only returned here.
+func (s *MerchantWebhookService) RollSecret(ctx context.Context, merchantID int) (*models.MerchantWebhookSecret, error) {
+	secret, err := generateWebhookSecret()
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate webhook secret: %w", err)
+	}
+
+	encrypted, err := utils.EncryptString(secret)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encrypt webhook secret: %w", err)
+	}
+
+	if err := s.db.SetMerchantWebhookSecret(merchantID, encrypted); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrMerchantNotFound
+		}
+		return nil, err
+	}
+
+	log.Printf("Rolled webhook signing secret of merchant %d", merchantID)
+	return &models.MerchantWebhookSecret{Secret: secret, CreatedAt: s.now()}, nil
+}
+
+// Verify returns sample deliveries signed with the merchant's secret, some deliberately invalid,
+// for the merchant to run through its verifier. When the request carries a payload, the
+// merchant's own signature of it is checked as well.
+func (s *MerchantWebhookService) Verify(ctx context.Context, merchantID int, req models.WebhookVerifyRequest) (*models.WebhookVerification, error) {
+	if req.Payload != "" && req.Signature == "" {
+		return nil, fmt.Errorf("%w: signature is required with payload", ErrInvalidWebhookCheck)
+	}
+
+	secret, err := s.secret(merchantID)
+	if err != nil {
+		return nil, err
+	}
+
+	version := req.PayloadVersion
+	if version == "" {
+		version = consts.WebhookPayloadV1
+	}
+	if !isWebhookPayloadVersion(version) {
+		return nil, fmt.Errorf("%w: unknown payload version %q", ErrInvalidWebhookCheck, version)
+	}
+
+	samples, err := s.samples(secret, version)
+	if err != nil {
+		return nil, err
+	}
+	verification := &models.WebhookVerification{Samples: samples}
+
+	if req.Payload != "" {
+		valid := webhook.Verify(secret, []byte(req.Payload), req.Signature) == nil
+		verification.SignatureValid = &valid
+		verification.ExpectedSignature = webhook.Sign(secret, []byte(req.Payload))
+	}
+
+	return verification, nil
+}
+
+// SignDelivery returns the signature of a webhook body for a merchant, or "" when the merchant
+// has no signing secret
+func SignDelivery(merchant *models.Merchant, body []byte) (string, error) {
+	if merchant.WebhookSecret == "" {
+		return "", nil
+	}
+
+	secret, err := utils.DecryptString(merchant.WebhookSecret)
+	if err != nil {
+		return "", fmt.Errorf("failed to decrypt webhook secret of merchant %d: %w", merchant.ID, err)
+	}
+	return webhook.Sign(secret, body), nil
+}
+
+// secret returns a merchant's plaintext signing secret
+func (s *MerchantWebhookService) secret(merchantID int) (string, error) {
+	merchant, err := s.db.GetMerchantByID(merchantID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return "", ErrMerchantNotFound
+		}
+		return "", err
+	}
+	if merchant.WebhookSecret == "" {
+		return "", ErrNoMerchantWebhookSecret
+	}
+
+	secret, err := utils.DecryptString(merchant.WebhookSecret)
+	if err != nil {
+		return "", fmt.Errorf("failed to decrypt webhook secret: %w", err)
+	}
+	return secret, nil
+}
+
+// samples builds a status-change delivery as the merchant's endpoint would receive it in a
+// payload version, signed correctly and in the ways a verifier must reject
+func (s *MerchantWebhookService) samples(secret, version string) ([]models.WebhookSample, error) {
+	now := s.now().UTC().Truncate(time.Second)
+	tx := models.Transaction{
+		ID:          1001,
+		Amount:      25.00,
+		Currency:    "USD",
+		Type:        consts.Deposit,
+		Status:      consts.Completed,
+		UserID:      1,
+		GatewayID:   1,
+		CountryID:   1,
+		ReferenceID: "SAMPLE-1001",
+		CreatedAt:   now.Add(-time.Minute),
+		UpdatedAt:   now,
+	}
+
+	dedupToken := OutboxDedupToken(events.TransactionStatusChanged, tx.ID, tx.Status)
+	delivery := func(tx models.Transaction) ([]byte, error) {
+		payload, err := json.Marshal(events.TransactionEvent{Type: events.TransactionStatusChanged, Transaction: tx, OccurredAt: now})
+		if err != nil {
+			return nil, fmt.Errorf("failed to marshal sample webhook: %w", err)
+		}
+		return transformWebhookPayload(version, models.OutboxMessage{EventType: events.TransactionStatusChanged, DedupToken: dedupToken, Payload: payload, CreatedAt: now})
+	}
+
+	payload, err := delivery(tx)
+	if err != nil {
+		return nil, err
+	}
+
+	tx.Amount = 2500.00
+	tampered, err := delivery(tx)
+	if err != nil {
+		return nil, err
+	}
+
+	other, err := generateWebhookSecret()
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate sample secret: %w", err)
+	}
+
+	signature := webhook.Sign(secret, payload)
+	sample := func(description string, body []byte, signature string, valid bool) models.WebhookSample {
+		headers := map[string]string{
+			"Content-Type":            "application/json",
+			webhook.EventHeader:       events.TransactionStatusChanged,
+			webhook.IdempotencyHeader: dedupToken,
+			webhook.VersionHeader:     version,
+		}
+		if signature != "" {
+			headers[webhook.SignatureHeader] = signature
+		}
+		return models.WebhookSample{Description: description, Headers: headers, Payload: string(body), Valid: valid}
+	}
+
+	return []models.WebhookSample{
+		sample("signed with your current secret", payload, signature, true),
+		sample("body changed after signing", tampered, signature, false),
+		sample("signed with a different secret", payload, webhook.Sign(other, payload), false),
+		sample("signature header missing", payload, "", false),
+	}, nil
+}
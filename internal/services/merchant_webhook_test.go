@@ -0,0 +1,90 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"payment-gateway/db"
+	"payment-gateway/internal/consts"
+	"payment-gateway/internal/models"
+	"payment-gateway/sdk/webhook"
+	"testing"
+)
+
+// TestMerchantWebhookVerification tests rolling a merchant's signing secret and the signed
+// samples and signature checks offered for testing a verifier
+func TestMerchantWebhookVerification(t *testing.T) {
+	mockDB := db.NewMockDB()
+	service := NewMerchantWebhookService(mockDB)
+	ctx := context.Background()
+
+	if _, err := service.Verify(ctx, 1, models.WebhookVerifyRequest{}); !errors.Is(err, ErrNoMerchantWebhookSecret) {
+		t.Errorf("Expected ErrNoMerchantWebhookSecret before a secret exists, got: %v", err)
+	}
+	if _, err := service.RollSecret(ctx, 999); !errors.Is(err, ErrMerchantNotFound) {
+		t.Errorf("Expected ErrMerchantNotFound, got: %v", err)
+	}
+
+	secret, err := service.RollSecret(ctx, 1)
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+
+	verification, err := service.Verify(ctx, 1, models.WebhookVerifyRequest{})
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	if len(verification.Samples) != 4 || verification.SignatureValid != nil {
+		t.Fatalf("Expected 4 samples and no signature check, got %+v", verification)
+	}
+	for _, sample := range verification.Samples {
+		valid := webhook.Verify(secret.Secret, []byte(sample.Payload), sample.Headers[webhook.SignatureHeader]) == nil
+		if valid != sample.Valid {
+			t.Errorf("Expected sample %q to verify=%v with the secret, got %v", sample.Description, sample.Valid, valid)
+		}
+	}
+
+	// Samples can be requested in any payload version
+	v2, err := service.Verify(ctx, 1, models.WebhookVerifyRequest{PayloadVersion: consts.WebhookPayloadV2})
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	var envelope webhook.Envelope
+	if err := json.Unmarshal([]byte(v2.Samples[0].Payload), &envelope); err != nil || envelope.APIVersion != consts.WebhookPayloadV2 || v2.Samples[0].Headers[webhook.VersionHeader] != consts.WebhookPayloadV2 {
+		t.Errorf("Expected a v2 sample, got %+v (%v)", v2.Samples[0], err)
+	}
+
+	payload := verification.Samples[0].Payload
+	verification, err = service.Verify(ctx, 1, models.WebhookVerifyRequest{Payload: payload, Signature: webhook.Sign(secret.Secret, []byte(payload))})
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	if verification.SignatureValid == nil || !*verification.SignatureValid {
+		t.Errorf("Expected the merchant's signature to be valid, got %+v", verification)
+	}
+
+	verification, err = service.Verify(ctx, 1, models.WebhookVerifyRequest{Payload: payload, Signature: "sha256=00"})
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	if verification.SignatureValid == nil || *verification.SignatureValid || verification.ExpectedSignature != webhook.Sign(secret.Secret, []byte(payload)) {
+		t.Errorf("Expected a wrong signature to be reported with the expected one, got %+v", verification)
+	}
+
+	if _, err := service.Verify(ctx, 1, models.WebhookVerifyRequest{Payload: payload}); !errors.Is(err, ErrInvalidWebhookCheck) {
+		t.Errorf("Expected ErrInvalidWebhookCheck for a payload without signature, got: %v", err)
+	}
+
+	// Deliveries are signed with the current secret
+	merchant, err := mockDB.GetMerchantByID(1)
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	signature, err := SignDelivery(merchant, []byte(payload))
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	if err := webhook.Verify(secret.Secret, []byte(payload), signature); err != nil {
+		t.Errorf("Expected delivery signature to verify, got: %v", err)
+	}
+}
@@ -0,0 +1,155 @@
+package services
+
+import (
+	"log"
+	"payment-gateway/internal/consts"
+	"payment-gateway/internal/events"
+	"payment-gateway/internal/models"
+	"sort"
+	"sync"
+	"time"
+)
+
+// metricsWindows are the rolling windows reported, longest last; the longest sets how much
+// history is kept
+var metricsWindows = []time.Duration{time.Minute, 5 * time.Minute, time.Hour}
+
+// metricsKey identifies the gateway and country outcomes are counted for
+type metricsKey struct {
+	gatewayID int
+	countryID int
+}
+
+// metricsBucket holds the outcomes of one second
+type metricsBucket struct {
+	second    int64
+	completed int
+	failed    int
+}
+
+// RealtimeMetrics counts transaction outcomes per gateway and country over rolling windows,
+// from events on the transaction event bus. Counts are kept in per-second buckets in memory,
+// so they cover only this instance and restart empty.
+type RealtimeMetrics struct {
+	bus *events.Bus
+
+	mu      sync.Mutex
+	buckets map[metricsKey][]metricsBucket
+	now     func() time.Time
+}
+
+// NewRealtimeMetrics creates metrics fed by the events published on bus
+func NewRealtimeMetrics(bus *events.Bus) *RealtimeMetrics {
+	return &RealtimeMetrics{
+		bus:     bus,
+		buckets: make(map[metricsKey][]metricsBucket),
+		now:     time.Now,
+	}
+}
+
+// Start counts completed and failed transactions until the returned stop function is called
+func (m *RealtimeMetrics) Start() (stop func()) {
+	filter := events.Filter{Statuses: []string{consts.Completed, consts.Failed}}
+	ch, cancel := m.bus.Subscribe(filter, consts.MetricsEventBufferSize)
+
+	go func() {
+		for evt := range ch {
+			if evt.Type == events.TransactionStatusChanged {
+				m.Record(evt.Transaction, evt.OccurredAt)
+			}
+		}
+	}()
+
+	return cancel
+}
+
+// Record counts a transaction that completed or failed at the given time
+func (m *RealtimeMetrics) Record(tx models.Transaction, at time.Time) {
+	key := metricsKey{gatewayID: tx.GatewayID, countryID: tx.CountryID}
+	second := at.Unix()
+	size := int64(metricsWindows[len(metricsWindows)-1] / time.Second)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	buckets, ok := m.buckets[key]
+	if !ok {
+		buckets = make([]metricsBucket, size)
+		m.buckets[key] = buckets
+	}
+
+	// Each slot is reused once its second falls out of the longest window
+	bucket := &buckets[second%size]
+	if bucket.second != second {
+		if second < bucket.second {
+			log.Printf("Ignoring %s outcome of transaction %d older than the metrics window", tx.Status, tx.ID)
+			return
+		}
+		*bucket = metricsBucket{second: second}
+	}
+
+	switch tx.Status {
+	case consts.Completed:
+		bucket.completed++
+	case consts.Failed:
+		bucket.failed++
+	}
+}
+
+// Snapshot returns the counts and success rates of every gateway and country with outcomes in
+// the last hour, ordered by gateway and country
+func (m *RealtimeMetrics) Snapshot() models.RealtimeMetrics {
+	now := m.now()
+	second := now.Unix()
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	snapshot := models.RealtimeMetrics{GeneratedAt: now, Gateways: []models.GatewayMetrics{}}
+	for key, buckets := range m.buckets {
+		var windows [3]models.WindowStats
+		for _, bucket := range buckets {
+			age := time.Duration(second-bucket.second) * time.Second
+			if bucket.second == 0 || age < 0 {
+				continue
+			}
+			for i, window := range metricsWindows {
+				if age < window {
+					windows[i].Completed += bucket.completed
+					windows[i].Failed += bucket.failed
+				}
+			}
+		}
+
+		if windows[2].Completed+windows[2].Failed == 0 {
+			// Nothing in the last hour; forget the key so idle pairs do not accumulate
+			delete(m.buckets, key)
+			continue
+		}
+
+		for i := range windows {
+			windows[i].Total = windows[i].Completed + windows[i].Failed
+			if windows[i].Total > 0 {
+				windows[i].SuccessRate = float64(windows[i].Completed) / float64(windows[i].Total)
+			}
+		}
+
+		snapshot.Gateways = append(snapshot.Gateways, models.GatewayMetrics{
+			GatewayID:    key.gatewayID,
+			CountryID:    key.countryID,
+			LastMinute:   windows[0],
+			Last5Minutes: windows[1],
+			LastHour:     windows[2],
+		})
+	}
+
+	sort.Slice(snapshot.Gateways, func(i, j int) bool {
+		a, b := snapshot.Gateways[i], snapshot.Gateways[j]
+		if a.GatewayID != b.GatewayID {
+			return a.GatewayID < b.GatewayID
+		}
+		return a.CountryID < b.CountryID
+	})
+
+	return snapshot
+}
@@ -0,0 +1,68 @@
+package services
+
+import (
+	"payment-gateway/internal/consts"
+	"payment-gateway/internal/events"
+	"payment-gateway/internal/models"
+	"testing"
+	"time"
+)
+
+// TestRealtimeMetricsWindows tests that outcomes are counted in each rolling window they fall in
+func TestRealtimeMetricsWindows(t *testing.T) {
+	metrics := NewRealtimeMetrics(events.NewBus())
+	now := time.Date(2024, 3, 9, 12, 0, 0, 0, time.UTC)
+	metrics.now = func() time.Time { return now }
+
+	completed := models.Transaction{GatewayID: 1, CountryID: 2, Status: consts.Completed}
+	failed := models.Transaction{GatewayID: 1, CountryID: 2, Status: consts.Failed}
+
+	metrics.Record(completed, now.Add(-10*time.Second))
+	metrics.Record(failed, now.Add(-30*time.Second))
+	metrics.Record(completed, now.Add(-3*time.Minute))
+	metrics.Record(completed, now.Add(-30*time.Minute))
+	metrics.Record(failed, now.Add(-2*time.Hour)) // outside every window
+	metrics.Record(models.Transaction{GatewayID: 2, CountryID: 1, Status: consts.Failed}, now.Add(-90*time.Minute))
+
+	snapshot := metrics.Snapshot()
+	if len(snapshot.Gateways) != 1 {
+		t.Fatalf("Expected only gateway 1 in country 2 to have recent outcomes, got %+v", snapshot.Gateways)
+	}
+
+	m := snapshot.Gateways[0]
+	want := []models.WindowStats{
+		{Total: 2, Completed: 1, Failed: 1, SuccessRate: 0.5},
+		{Total: 3, Completed: 2, Failed: 1, SuccessRate: 2.0 / 3},
+		{Total: 4, Completed: 3, Failed: 1, SuccessRate: 0.75},
+	}
+	for i, got := range []models.WindowStats{m.LastMinute, m.Last5Minutes, m.LastHour} {
+		if got != want[i] {
+			t.Errorf("Window %d: expected %+v, got %+v", i, want[i], got)
+		}
+	}
+}
+
+// TestRealtimeMetricsFromBus tests that status changes published on the bus are counted
+func TestRealtimeMetricsFromBus(t *testing.T) {
+	bus := events.NewBus()
+	metrics := NewRealtimeMetrics(bus)
+	stop := metrics.Start()
+	defer stop()
+
+	bus.Publish(events.TransactionEvent{Type: events.TransactionCreated, Transaction: models.Transaction{GatewayID: 1, CountryID: 1, Status: consts.Completed}})
+	bus.Publish(events.TransactionEvent{Type: events.TransactionStatusChanged, Transaction: models.Transaction{GatewayID: 1, CountryID: 1, Status: consts.Processing}})
+	bus.Publish(events.TransactionEvent{Type: events.TransactionStatusChanged, Transaction: models.Transaction{GatewayID: 1, CountryID: 1, Status: consts.Completed}})
+
+	deadline := time.Now().Add(time.Second)
+	for time.Now().Before(deadline) {
+		snapshot := metrics.Snapshot()
+		if len(snapshot.Gateways) == 1 {
+			if got := snapshot.Gateways[0].LastMinute; got.Total != 1 || got.SuccessRate != 1 {
+				t.Errorf("Expected one completed transaction, got %+v", got)
+			}
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatal("Expected the completed transaction to be counted")
+}
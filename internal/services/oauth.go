@@ -0,0 +1,197 @@
+package services
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"payment-gateway/db"
+	"payment-gateway/internal/auth"
+	"payment-gateway/internal/consts"
+	"payment-gateway/internal/models"
+	"strings"
+	"time"
+)
+
+// OAuth2 grant types
+const grantTypeClientCredentials = "client_credentials"
+
+var (
+	ErrUnsupportedGrantType = errors.New("only the client_credentials grant is supported")
+	ErrInvalidOAuthClient   = errors.New("invalid client credentials")
+	ErrInvalidOAuthScope    = errors.New("requested scope is invalid or not granted to the client")
+)
+
+// OAuthConfig configures the token endpoint and the issuers whose access tokens are accepted
+type OAuthConfig struct {
+	// Issuer is the "iss" claim of tokens issued by our own token endpoint
+	Issuer string
+
+	// SigningKey signs our own tokens with HMAC-SHA256
+	SigningKey []byte
+
+	// TokenTTL is the lifetime of our own tokens
+	TokenTTL time.Duration
+
+	// ScopeRoles maps scopes to roles, for our own and external tokens
+	ScopeRoles auth.ScopeRoles
+
+	// ExternalIssuers are identity providers whose tokens are verified against their JWKS
+	ExternalIssuers []auth.Issuer
+}
+
+// OAuthService manages merchant OAuth2 clients, issues client-credentials access tokens and
+// authenticates requests made with them
+type OAuthService struct {
+	db       db.DBInterface
+	config   OAuthConfig
+	verifier *auth.Verifier
+	cache    *auth.TokenCache
+}
+
+// NewOAuthService creates a new OAuth2 service
+func NewOAuthService(dbInterface db.DBInterface, config OAuthConfig) *OAuthService {
+	if config.TokenTTL <= 0 {
+		config.TokenTTL = consts.OAuthTokenTTL
+	}
+	if config.ScopeRoles == nil {
+		config.ScopeRoles = auth.DefaultScopeRoles
+	}
+
+	issuers := append([]auth.Issuer{{Name: config.Issuer, HMACKey: config.SigningKey}}, config.ExternalIssuers...)
+
+	return &OAuthService{
+		db:       dbInterface,
+		config:   config,
+		verifier: auth.NewVerifier(config.ScopeRoles, issuers...),
+		cache:    auth.NewTokenCache(consts.MaxCachedTokens),
+	}
+}
+
+// CreateClient registers an OAuth2 client for a merchant. The client secret is only returned here.
+func (s *OAuthService) CreateClient(ctx context.Context, merchantID int, req models.OAuthClientRequest) (*models.OAuthClient, error) {
+	if len(req.Scopes) == 0 {
+		req.Scopes = []string{consts.OAuthScopePaymentsRead}
+	}
+	for _, scope := range req.Scopes {
+		if _, ok := s.config.ScopeRoles[scope]; !ok {
+			return nil, fmt.Errorf("%w: %s", ErrInvalidOAuthScope, scope)
+		}
+	}
+	if req.Name == "" {
+		req.Name = "default"
+	}
+
+	if _, err := s.db.GetMerchantByID(merchantID); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrMerchantNotFound
+		}
+		return nil, err
+	}
+
+	buf := make([]byte, 8+32)
+	if _, err := rand.Read(buf); err != nil {
+		return nil, fmt.Errorf("failed to generate client credentials: %w", err)
+	}
+	secret := hex.EncodeToString(buf[8:])
+
+	client := models.OAuthClient{
+		ClientID:   consts.OAuthClientIDPrefix + hex.EncodeToString(buf[:8]),
+		MerchantID: merchantID,
+		Name:       req.Name,
+		SecretHash: hashCredential(secret),
+		Scopes:     req.Scopes,
+		Status:     consts.OAuthClientActive,
+		CreatedAt:  time.Now(),
+	}
+
+	id, err := s.db.CreateOAuthClient(client)
+	if err != nil {
+		return nil, err
+	}
+
+	client.ID = id
+	client.Secret = secret
+	return &client, nil
+}
+
+// IssueToken exchanges client credentials for an access token. The token is granted the
+// requested scopes, or all of the client's scopes when none are requested.
+func (s *OAuthService) IssueToken(ctx context.Context, req models.TokenRequest) (*models.TokenResponse, error) {
+	if req.GrantType != grantTypeClientCredentials {
+		return nil, ErrUnsupportedGrantType
+	}
+
+	client, err := s.db.GetOAuthClientByClientID(req.ClientID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrInvalidOAuthClient
+		}
+		return nil, err
+	}
+	if subtle.ConstantTimeCompare([]byte(client.SecretHash), []byte(hashCredential(req.ClientSecret))) != 1 ||
+		client.Status != consts.OAuthClientActive {
+		return nil, ErrInvalidOAuthClient
+	}
+
+	scopes := client.Scopes
+	if req.Scope != "" {
+		scopes = strings.Fields(req.Scope)
+		for _, scope := range scopes {
+			if !containsString(client.Scopes, scope) {
+				return nil, fmt.Errorf("%w: %s", ErrInvalidOAuthScope, scope)
+			}
+		}
+	}
+
+	now := time.Now()
+	scope := strings.Join(scopes, " ")
+	token, err := auth.SignHS256(s.config.SigningKey, map[string]interface{}{
+		"iss":                     s.config.Issuer,
+		"sub":                     client.ClientID,
+		"client_id":               client.ClientID,
+		auth.DefaultMerchantClaim: client.MerchantID,
+		"scope":                   scope,
+		"iat":                     now.Unix(),
+		"exp":                     now.Add(s.config.TokenTTL).Unix(),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to sign access token: %w", err)
+	}
+
+	return &models.TokenResponse{
+		AccessToken: token,
+		TokenType:   "Bearer",
+		ExpiresIn:   int(s.config.TokenTTL / time.Second),
+		Scope:       scope,
+	}, nil
+}
+
+// Authenticate returns the merchant caller identified by an access token from our own token
+// endpoint or a trusted identity provider. Verified tokens are cached until they expire.
+func (s *OAuthService) Authenticate(ctx context.Context, token string) (*auth.Principal, error) {
+	if principal, ok := s.cache.Get(token); ok {
+		return principal, nil
+	}
+
+	principal, err := s.verifier.Verify(ctx, token)
+	if err != nil {
+		return nil, err
+	}
+
+	s.cache.Put(token, principal)
+	return principal, nil
+}
+
+// containsString reports whether values includes value
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,100 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"payment-gateway/db"
+	"payment-gateway/internal/consts"
+	"payment-gateway/internal/models"
+	"testing"
+)
+
+// newTestOAuthService creates an OAuth2 service signing with a fixed key
+func newTestOAuthService() *OAuthService {
+	return NewOAuthService(db.NewMockDB(), OAuthConfig{
+		Issuer:     "payment-gateway",
+		SigningKey: []byte("0123456789abcdef0123456789abcdef"),
+	})
+}
+
+// TestIssueAndAuthenticateToken tests the client-credentials flow from client registration
+// to an authenticated request
+func TestIssueAndAuthenticateToken(t *testing.T) {
+	service := newTestOAuthService()
+	ctx := context.Background()
+
+	client, err := service.CreateClient(ctx, 1, models.OAuthClientRequest{
+		Name:   "backoffice",
+		Scopes: []string{consts.OAuthScopePaymentsRead, consts.OAuthScopePaymentsWrite},
+	})
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	if client.Secret == "" {
+		t.Fatal("Expected the client secret to be returned on creation")
+	}
+
+	token, err := service.IssueToken(ctx, models.TokenRequest{
+		GrantType:    "client_credentials",
+		ClientID:     client.ClientID,
+		ClientSecret: client.Secret,
+		Scope:        consts.OAuthScopePaymentsRead,
+	})
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	if token.TokenType != "Bearer" || token.Scope != consts.OAuthScopePaymentsRead || token.ExpiresIn != 3600 {
+		t.Errorf("Unexpected token response: %+v", token)
+	}
+
+	principal, err := service.Authenticate(ctx, token.AccessToken)
+	if err != nil {
+		t.Fatalf("Expected token to authenticate, got: %v", err)
+	}
+	if principal.MerchantID != 1 || principal.Subject != client.ClientID {
+		t.Errorf("Unexpected principal: %+v", principal)
+	}
+	if !principal.HasRole(consts.RoleViewer) || principal.HasRole(consts.RoleOperator) {
+		t.Errorf("Expected a read-scoped token to grant only the viewer role, got %v", principal.Roles)
+	}
+
+	// The second request is answered from the cache
+	cached, err := service.Authenticate(ctx, token.AccessToken)
+	if err != nil || cached != principal {
+		t.Errorf("Expected the cached principal, got %+v, %v", cached, err)
+	}
+}
+
+// TestIssueTokenErrors tests that bad grants, credentials and scopes are rejected
+func TestIssueTokenErrors(t *testing.T) {
+	service := newTestOAuthService()
+	ctx := context.Background()
+
+	client, err := service.CreateClient(ctx, 1, models.OAuthClientRequest{})
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+
+	tests := []struct {
+		name    string
+		request models.TokenRequest
+		want    error
+	}{
+		{"password grant", models.TokenRequest{GrantType: "password", ClientID: client.ClientID, ClientSecret: client.Secret}, ErrUnsupportedGrantType},
+		{"wrong secret", models.TokenRequest{GrantType: "client_credentials", ClientID: client.ClientID, ClientSecret: "wrong"}, ErrInvalidOAuthClient},
+		{"unknown client", models.TokenRequest{GrantType: "client_credentials", ClientID: "pgc_unknown", ClientSecret: client.Secret}, ErrInvalidOAuthClient},
+		{"scope not granted", models.TokenRequest{GrantType: "client_credentials", ClientID: client.ClientID, ClientSecret: client.Secret, Scope: consts.OAuthScopePaymentsWrite}, ErrInvalidOAuthScope},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := service.IssueToken(ctx, tt.request); !errors.Is(err, tt.want) {
+				t.Errorf("Expected %v, got: %v", tt.want, err)
+			}
+		})
+	}
+
+	if _, err := service.CreateClient(ctx, 1, models.OAuthClientRequest{Scopes: []string{"admin"}}); !errors.Is(err, ErrInvalidOAuthScope) {
+		t.Errorf("Expected ErrInvalidOAuthScope for unknown scope, got: %v", err)
+	}
+}
@@ -0,0 +1,291 @@
+package services
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"payment-gateway/internal/consts"
+	"payment-gateway/internal/gateway"
+	"payment-gateway/internal/models"
+	"strconv"
+	"time"
+)
+
+var (
+	ErrInvalidBankPayment     = errors.New("invalid bank payment")
+	ErrBankNotFound           = errors.New("bank not found")
+	ErrConsentNotFound        = errors.New("payment consent not found")
+	ErrConsentUsed            = errors.New("payment consent was already used")
+	ErrInvalidConsentCallback = errors.New("invalid consent callback")
+)
+
+// SetOpenBankingRedirectURI configures where banks send customers back to after they authorised
+// or rejected a payment consent, i.e. the consent callback endpoint
+func (s *TransactionService) SetOpenBankingRedirectURI(uri string) {
+	s.openBankingRedirectURI = uri
+}
+
+// ListBanks returns the bank directory: the banks customers can pay from by open banking in a
+// country, or in every country for ""
+func (s *TransactionService) ListBanks(ctx context.Context, countryCode string) ([]models.Bank, error) {
+	banks := []models.Bank{}
+	for _, provider := range s.gatewaySelector.Providers() {
+		initiator, ok := provider.(gateway.PaymentInitiationProvider)
+		if !ok {
+			continue
+		}
+
+		offered, err := initiator.Banks(ctx, countryCode)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list banks of %s: %w", provider.Name(), err)
+		}
+		banks = append(banks, offered...)
+	}
+	return banks, nil
+}
+
+// GetPaymentConsent returns the payment consent of a user's open banking deposit
+func (s *TransactionService) GetPaymentConsent(ctx context.Context, txID, userID int) (*models.PaymentConsent, error) {
+	if _, err := s.userTransaction(txID, userID); err != nil {
+		return nil, err
+	}
+
+	consent, err := s.db.GetPaymentConsentByTransaction(txID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrConsentNotFound
+		}
+		return nil, err
+	}
+	return consent, nil
+}
+
+// validateBankPayment checks that a bank is only given for deposits without card details
+func validateBankPayment(txType string, req models.TransactionRequest) error {
+	if req.BankID == "" {
+		return nil
+	}
+	if txType != consts.Deposit {
+		return fmt.Errorf("%w: bank payments apply to deposits only", ErrInvalidBankPayment)
+	}
+	if req.CardBIN != "" || req.SCAExemption != "" {
+		return fmt.Errorf("%w: bank payments cannot carry card details", ErrInvalidBankPayment)
+	}
+	return nil
+}
+
+// applyBankPayment routes a deposit paid from a bank to the open banking gateway offering that
+// bank. A preferred gateway in the request must be that gateway.
+func (s *TransactionService) applyBankPayment(ctx context.Context, opts *gateway.SelectionOptions, req models.TransactionRequest) error {
+	if req.BankID == "" {
+		return nil
+	}
+
+	banks, err := s.ListBanks(ctx, "")
+	if err != nil {
+		return err
+	}
+	for _, bank := range banks {
+		if bank.ID != req.BankID {
+			continue
+		}
+
+		gatewayID := strconv.Itoa(bank.GatewayID)
+		if opts.PreferredGatewayID != "" && opts.PreferredGatewayID != gatewayID {
+			return fmt.Errorf("%w: bank %s is offered by gateway %s, not the preferred gateway %s", ErrInvalidBankPayment, bank.ID, gatewayID, opts.PreferredGatewayID)
+		}
+		opts.PreferredGatewayID = gatewayID
+		opts.PaymentInitiation = true
+		return nil
+	}
+	return fmt.Errorf("%w: %s", ErrBankNotFound, req.BankID)
+}
+
+// initiateBankPayment creates the consent to an open banking deposit at the customer's bank and
+// returns the deposit as processing, redirecting to the bank for the customer to authorise it
+func (s *TransactionService) initiateBankPayment(ctx context.Context, initiator gateway.PaymentInitiationProvider, tx *models.Transaction) (*models.TransactionResponse, error) {
+	state, err := newConsentState()
+	if err != nil {
+		return nil, err
+	}
+
+	created, err := initiator.CreateConsent(ctx, *tx, s.openBankingRedirectURI, state)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	consent := models.PaymentConsent{
+		TransactionID:    tx.ID,
+		GatewayID:        tx.GatewayID,
+		BankID:           tx.BankID,
+		ExternalID:       created.ExternalID,
+		Status:           consts.ConsentAwaitingAuthorisation,
+		StateHash:        hashConsentState(state),
+		AuthorisationURL: created.AuthorisationURL,
+		ExpiresAt:        created.ExpiresAt,
+		CreatedAt:        now,
+	}
+	if _, err := s.db.CreatePaymentConsent(consent); err != nil {
+		return nil, fmt.Errorf("failed to store payment consent: %w", err)
+	}
+
+	log.Printf("Transaction %d: created payment consent %s at bank %s", tx.ID, created.ExternalID, tx.BankID)
+	return &models.TransactionResponse{
+		Status:        consts.Processing,
+		TransactionID: tx.ID,
+		Message:       "Authorise the payment at your bank",
+		RedirectURL:   created.AuthorisationURL,
+	}, nil
+}
+
+// HandleConsentCallback completes an open banking deposit when the bank sends the customer back
+// with the consent's state and either an authorisation code, with which the payment is executed,
+// or an error when the customer rejected it. A consent is only used once; consents returned after
+// they expired fail their deposit. It returns the deposit as updated.
+func (s *TransactionService) HandleConsentCallback(ctx context.Context, state, code, errorCode string) (*models.Transaction, error) {
+	if state == "" {
+		return nil, fmt.Errorf("%w: state is required", ErrInvalidConsentCallback)
+	}
+	if code == "" && errorCode == "" {
+		return nil, fmt.Errorf("%w: code or error is required", ErrInvalidConsentCallback)
+	}
+
+	consent, err := s.db.GetPaymentConsentByState(hashConsentState(state))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrConsentNotFound
+		}
+		return nil, err
+	}
+	if consent.Status != consts.ConsentAwaitingAuthorisation {
+		return nil, ErrConsentUsed
+	}
+
+	switch {
+	case errorCode != "":
+		err = s.closeConsent(ctx, *consent, consts.ConsentRejected, consts.DeclineConsentRejected, fmt.Sprintf("consent rejected at the bank: %s", errorCode))
+	case time.Now().After(consent.ExpiresAt):
+		err = s.closeConsent(ctx, *consent, consts.ConsentExpired, consts.DeclineConsentExpired, "consent expired before it was authorised")
+	default:
+		err = s.executeConsent(ctx, *consent, code)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return s.db.GetTransactionByID(consent.TransactionID)
+}
+
+// executeConsent executes the payment of an authorised consent, claiming the consent first so
+// the payment cannot be executed twice
+func (s *TransactionService) executeConsent(ctx context.Context, consent models.PaymentConsent, code string) error {
+	if err := s.claimConsent(consent, consts.ConsentConsumed); err != nil {
+		return err
+	}
+
+	tx, err := s.db.GetTransactionByID(consent.TransactionID)
+	if err != nil {
+		return fmt.Errorf("failed to get transaction: %w", err)
+	}
+	provider, err := s.gatewaySelector.GetProviderByID(strconv.Itoa(consent.GatewayID))
+	if err != nil {
+		return err
+	}
+	initiator, ok := provider.(gateway.PaymentInitiationProvider)
+	if !ok {
+		return fmt.Errorf("gateway %d does not initiate bank payments", consent.GatewayID)
+	}
+
+	callback := &models.CallbackData{TransactionID: tx.ID, GatewayID: provider.ID()}
+	response, err := initiator.ExecutePayment(ctx, *tx, consent, code)
+	var decline *gateway.DeclineError
+	switch {
+	case errors.As(err, &decline):
+		callback.Status = consts.Failed
+		callback.Message = decline.Message
+		callback.DeclineCode = decline.Code
+	case err != nil:
+		log.Printf("Transaction %d: failed to execute payment consent %s: %v", tx.ID, consent.ExternalID, err)
+		callback.Status = consts.Failed
+		callback.Message = fmt.Sprintf("payment execution failed: %v", err)
+	default:
+		callback.Status = response.Status
+		callback.Message = response.Message
+		callback.GatewayReference = response.GatewayReference
+	}
+
+	return s.HandleCallback(ctx, callback)
+}
+
+// closeConsent marks a consent rejected or expired and fails its deposit with declineCode
+func (s *TransactionService) closeConsent(ctx context.Context, consent models.PaymentConsent, status, declineCode, message string) error {
+	if err := s.claimConsent(consent, status); err != nil {
+		return err
+	}
+
+	log.Printf("Transaction %d: payment consent %s %s", consent.TransactionID, consent.ExternalID, status)
+	return s.HandleCallback(ctx, &models.CallbackData{
+		TransactionID: consent.TransactionID,
+		Status:        consts.Failed,
+		Message:       message,
+		DeclineCode:   declineCode,
+		GatewayID:     strconv.Itoa(consent.GatewayID),
+	})
+}
+
+// claimConsent moves a consent awaiting authorisation to status, failing with ErrConsentUsed when
+// a concurrent callback or expiry run got there first
+func (s *TransactionService) claimConsent(consent models.PaymentConsent, status string) error {
+	if err := s.db.UpdatePaymentConsentStatus(consent.ID, status, time.Now()); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrConsentUsed
+		}
+		return fmt.Errorf("failed to update payment consent: %w", err)
+	}
+	return nil
+}
+
+// ExpireConsents fails the deposits of consents customers did not authorise before they expired,
+// returning how many were expired
+func (s *TransactionService) ExpireConsents(ctx context.Context) (int, error) {
+	consents, err := s.db.ListExpiredPaymentConsents(time.Now(), consts.ConsentExpiryBatchSize)
+	if err != nil {
+		return 0, fmt.Errorf("failed to list expired payment consents: %w", err)
+	}
+
+	expired := 0
+	for _, consent := range consents {
+		err := s.closeConsent(ctx, consent, consts.ConsentExpired, consts.DeclineConsentExpired, "consent expired before it was authorised")
+		if errors.Is(err, ErrConsentUsed) {
+			continue
+		}
+		if err != nil {
+			log.Printf("Failed to expire payment consent %d: %v", consent.ID, err)
+			continue
+		}
+		expired++
+	}
+	return expired, nil
+}
+
+// newConsentState returns a random state binding the bank's redirect to its consent
+func newConsentState() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("failed to generate consent state: %w", err)
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// hashConsentState returns the SHA-256 of a consent state; only the hash is stored
+func hashConsentState(state string) string {
+	sum := sha256.Sum256([]byte(state))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,128 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"net/url"
+	"payment-gateway/db"
+	"payment-gateway/internal/consts"
+	"payment-gateway/internal/gateway"
+	"payment-gateway/internal/models"
+	"testing"
+	"time"
+)
+
+// TestOpenBankingDeposit tests that bank deposits are authorised through a consent used once
+func TestOpenBankingDeposit(t *testing.T) {
+	mockDB := db.NewMockDB()
+	selector := gateway.NewSelector(mockDB)
+	selector.RegisterProvider(gateway.NewMockOpenBankingProvider(1, "Open Banking", []models.Bank{
+		{ID: "sandbox-us", Name: "Sandbox Bank", CountryCode: "US", Currencies: []string{"USD"}},
+	}))
+	selector.RegisterProvider(gateway.NewMockProvider(2, "Stripe", "application/json", 1.0, 0))
+	service := NewTransactionService(mockDB, selector)
+	service.SetOpenBankingRedirectURI("https://payments.example.com/open-banking/callback")
+	ctx := context.Background()
+
+	banks, err := service.ListBanks(ctx, "US")
+	if err != nil || len(banks) != 1 || banks[0].GatewayID != 1 {
+		t.Fatalf("Expected the sandbox bank of gateway 1, got %+v, %v", banks, err)
+	}
+
+	// deposit pays from the sandbox bank and returns the state its authorisation carries
+	deposit := func(amount float64) (*models.TransactionResponse, string) {
+		t.Helper()
+		response, err := service.ProcessDeposit(ctx, models.TransactionRequest{UserID: 1, Amount: amount, Currency: "USD", BankID: "sandbox-us"})
+		if err != nil {
+			t.Fatalf("Expected no error, got: %v", err)
+		}
+		authorisation, err := url.Parse(response.RedirectURL)
+		if err != nil || response.Status != consts.Processing || authorisation.Query().Get("state") == "" {
+			t.Fatalf("Expected a redirect to the bank to authorise the deposit, got %+v", response)
+		}
+		return response, authorisation.Query().Get("state")
+	}
+
+	authorised, state := deposit(25)
+	consent, err := service.GetPaymentConsent(ctx, authorised.TransactionID, 1)
+	if err != nil || consent.Status != consts.ConsentAwaitingAuthorisation || consent.BankID != "sandbox-us" {
+		t.Fatalf("Expected a consent awaiting authorisation, got %+v, %v", consent, err)
+	}
+	tx, err := service.HandleConsentCallback(ctx, state, "auth-code", "")
+	if err != nil || tx.Status != consts.Completed || tx.GatewayReference == "" {
+		t.Fatalf("Expected the authorised deposit completed, got %+v, %v", tx, err)
+	}
+	if _, err := service.HandleConsentCallback(ctx, state, "auth-code", ""); !errors.Is(err, ErrConsentUsed) {
+		t.Errorf("Expected a replayed callback to be refused, got: %v", err)
+	}
+
+	rejected, state := deposit(25)
+	tx, err = service.HandleConsentCallback(ctx, state, "", "access_denied")
+	if err != nil || tx.Status != consts.Failed || tx.DeclineCode != consts.DeclineConsentRejected {
+		t.Errorf("Expected the rejected deposit failed, got %+v, %v", tx, err)
+	}
+	if consent, _ := service.GetPaymentConsent(ctx, rejected.TransactionID, 1); consent.Status != consts.ConsentRejected {
+		t.Errorf("Expected the consent rejected, got %+v", consent)
+	}
+
+	_, state = deposit(10.51)
+	tx, err = service.HandleConsentCallback(ctx, state, "auth-code", "")
+	if err != nil || tx.Status != consts.Failed || tx.DeclineCode != consts.DeclineInsufficientFunds {
+		t.Errorf("Expected the bank to decline the payment, got %+v, %v", tx, err)
+	}
+
+	// Card deposits never reach the open banking gateway
+	card, err := service.ProcessDeposit(ctx, models.TransactionRequest{UserID: 1, Amount: 25, Currency: "USD"})
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	if tx, _ := mockDB.GetTransactionByID(card.TransactionID); tx.GatewayID != 2 {
+		t.Errorf("Expected the card deposit routed to gateway 2, got %d", tx.GatewayID)
+	}
+
+	if _, err := service.HandleConsentCallback(ctx, "unknown", "auth-code", ""); !errors.Is(err, ErrConsentNotFound) {
+		t.Errorf("Expected ErrConsentNotFound for an unknown state, got: %v", err)
+	}
+	if _, err := service.ProcessDeposit(ctx, models.TransactionRequest{UserID: 1, Amount: 25, Currency: "USD", BankID: "unknown"}); !errors.Is(err, ErrBankNotFound) {
+		t.Errorf("Expected ErrBankNotFound, got: %v", err)
+	}
+	if _, err := service.ProcessWithdrawal(ctx, models.TransactionRequest{UserID: 1, Amount: 25, Currency: "USD", BankID: "sandbox-us"}); !errors.Is(err, ErrInvalidBankPayment) {
+		t.Errorf("Expected ErrInvalidBankPayment for a withdrawal, got: %v", err)
+	}
+}
+
+// TestExpireConsents tests that deposits whose consent was not authorised in time fail
+func TestExpireConsents(t *testing.T) {
+	mockDB := db.NewMockDB()
+	service := NewTransactionService(mockDB, &mockGatewaySelector{})
+
+	txID, err := mockDB.CreateTransaction(models.Transaction{UserID: 1, Amount: 25, Currency: "USD", Type: consts.Deposit, Status: consts.Processing, GatewayID: 1})
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	if _, err := mockDB.CreatePaymentConsent(models.PaymentConsent{
+		TransactionID: txID,
+		GatewayID:     1,
+		BankID:        "sandbox-us",
+		ExternalID:    "consent-1",
+		Status:        consts.ConsentAwaitingAuthorisation,
+		StateHash:     hashConsentState("state"),
+		ExpiresAt:     time.Now().Add(-time.Minute),
+		CreatedAt:     time.Now().Add(-time.Hour),
+	}); err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+
+	expired, err := service.ExpireConsents(context.Background())
+	if err != nil || expired != 1 {
+		t.Fatalf("Expected one consent expired, got %d, %v", expired, err)
+	}
+	tx, _ := mockDB.GetTransactionByID(txID)
+	if tx.Status != consts.Failed || tx.DeclineCode != consts.DeclineConsentExpired {
+		t.Errorf("Expected the deposit failed as expired, got %+v", tx)
+	}
+
+	if _, err := service.HandleConsentCallback(context.Background(), "state", "auth-code", ""); !errors.Is(err, ErrConsentUsed) {
+		t.Errorf("Expected a late authorisation to be refused, got: %v", err)
+	}
+}
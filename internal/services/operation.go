@@ -0,0 +1,114 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"payment-gateway/db"
+	"payment-gateway/internal/consts"
+	"payment-gateway/internal/models"
+	"payment-gateway/internal/utils"
+	"time"
+)
+
+var (
+	ErrOperationNotFound = errors.New("operation not found")
+)
+
+// OperationService tracks long-running asynchronous jobs such as batches, exports and
+// reconciliations so clients can poll their progress and result by ID
+type OperationService struct {
+	db db.DBInterface
+}
+
+// NewOperationService creates a new operation service
+func NewOperationService(dbInterface db.DBInterface) *OperationService {
+	return &OperationService{db: dbInterface}
+}
+
+// Start creates and persists a new pending operation of the given type
+func (s *OperationService) Start(opType string) (*models.Operation, error) {
+	id, err := utils.GenerateID("op_")
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate operation ID: %w", err)
+	}
+
+	now := time.Now()
+	op := models.Operation{
+		ID:        id,
+		Type:      opType,
+		Status:    consts.OperationPending,
+		CreatedAt: now,
+		ExpiresAt: now.Add(consts.OperationTTL),
+	}
+
+	if err := s.db.CreateOperation(op); err != nil {
+		return nil, fmt.Errorf("failed to create operation: %w", err)
+	}
+
+	return &op, nil
+}
+
+// Progress records how many of the operation's units of work have been processed
+func (s *OperationService) Progress(op *models.Operation, processed, total int) {
+	op.Status = consts.OperationRunning
+	op.Processed = processed
+	op.Total = total
+	if total > 0 {
+		op.Progress = processed * 100 / total
+	}
+
+	s.save(op)
+}
+
+// Complete marks the operation as succeeded and stores its result
+func (s *OperationService) Complete(op *models.Operation, result interface{}) {
+	data, err := json.Marshal(result)
+	if err != nil {
+		s.Fail(op, fmt.Errorf("failed to marshal operation result: %w", err))
+		return
+	}
+
+	op.Status = consts.OperationSucceeded
+	op.Progress = 100
+	op.Processed = op.Total
+	op.Result = data
+
+	s.save(op)
+}
+
+// Fail marks the operation as failed with the given error
+func (s *OperationService) Fail(op *models.Operation, opErr error) {
+	op.Status = consts.OperationFailed
+	op.Error = opErr.Error()
+
+	s.save(op)
+}
+
+// Get returns an operation by ID. Expired operations are reported as not found.
+func (s *OperationService) Get(ctx context.Context, operationID string) (*models.Operation, error) {
+	op, err := s.db.GetOperationByID(operationID)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrOperationNotFound, err)
+	}
+
+	if time.Now().After(op.ExpiresAt) {
+		return nil, ErrOperationNotFound
+	}
+
+	return op, nil
+}
+
+// PurgeExpired deletes operations whose expiry has passed
+func (s *OperationService) PurgeExpired() (int64, error) {
+	return s.db.DeleteExpiredOperations(time.Now())
+}
+
+// save persists the operation state, logging failures since callers are background jobs
+func (s *OperationService) save(op *models.Operation) {
+	if err := s.db.UpdateOperation(*op); err != nil {
+		log.Printf("Failed to update operation %s: %v", op.ID, err)
+	}
+}
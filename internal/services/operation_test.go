@@ -0,0 +1,83 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"payment-gateway/db"
+	"payment-gateway/internal/consts"
+	"testing"
+	"time"
+)
+
+// TestOperationLifecycle tests starting, progressing and completing an operation
+func TestOperationLifecycle(t *testing.T) {
+	service := NewOperationService(db.NewMockDB())
+	ctx := context.Background()
+
+	op, err := service.Start(consts.OperationWithdrawalBatch)
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+
+	if op.ID == "" || op.Status != consts.OperationPending {
+		t.Fatalf("Unexpected new operation: %+v", op)
+	}
+
+	service.Progress(op, 1, 4)
+
+	stored, err := service.Get(ctx, op.ID)
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	if stored.Status != consts.OperationRunning || stored.Progress != 25 {
+		t.Errorf("Expected running at 25%%, got %s at %d%%", stored.Status, stored.Progress)
+	}
+
+	service.Complete(op, map[string]int{"batch_id": 9})
+
+	stored, err = service.Get(ctx, op.ID)
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	if stored.Status != consts.OperationSucceeded || stored.Progress != 100 {
+		t.Errorf("Expected succeeded at 100%%, got %s at %d%%", stored.Status, stored.Progress)
+	}
+
+	var result map[string]int
+	if err := json.Unmarshal(stored.Result, &result); err != nil || result["batch_id"] != 9 {
+		t.Errorf("Unexpected operation result: %s", stored.Result)
+	}
+}
+
+// TestOperationExpiry tests that expired operations are hidden and purged
+func TestOperationExpiry(t *testing.T) {
+	mockDB := db.NewMockDB()
+	service := NewOperationService(mockDB)
+
+	op, err := service.Start(consts.OperationWithdrawalBatch)
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+
+	// Force the operation into the past
+	expired := *op
+	expired.ID = "op_expired"
+	expired.ExpiresAt = time.Now().Add(-time.Minute)
+	if err := mockDB.CreateOperation(expired); err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+
+	if _, err := service.Get(context.Background(), expired.ID); !errors.Is(err, ErrOperationNotFound) {
+		t.Errorf("Expected ErrOperationNotFound for expired operation, got: %v", err)
+	}
+
+	deleted, err := service.PurgeExpired()
+	if err != nil || deleted != 1 {
+		t.Errorf("Expected 1 operation purged, got %d (err: %v)", deleted, err)
+	}
+
+	if _, err := service.Get(context.Background(), op.ID); err != nil {
+		t.Errorf("Expected active operation to remain, got: %v", err)
+	}
+}
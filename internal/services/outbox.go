@@ -0,0 +1,325 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"payment-gateway/db"
+	"payment-gateway/internal/consts"
+	"payment-gateway/internal/events"
+	"payment-gateway/internal/kafka"
+	"payment-gateway/internal/models"
+	"payment-gateway/sdk/webhook"
+	"time"
+)
+
+// Headers attached to merchant webhook deliveries
+const (
+	MerchantWebhookEventHeader       = webhook.EventHeader
+	MerchantWebhookIdempotencyHeader = webhook.IdempotencyHeader
+	MerchantWebhookVersionHeader     = webhook.VersionHeader
+)
+
+// OutboxSink delivers outbox messages for one destination. Returning nil marks the message delivered.
+type OutboxSink interface {
+	Deliver(ctx context.Context, msg models.OutboxMessage) error
+}
+
+var (
+	ErrOutboxMessageNotFound  = errors.New("outbox message not found")
+	ErrOutboxMessageDelivered = errors.New("outbox message is no longer awaiting delivery")
+	ErrInvalidOutboxFilter    = errors.New("invalid outbox destination or status")
+)
+
+// OutboxDispatcher delivers pending outbox messages for a destination at least once,
+// backing off between failed attempts until its attempt limit is reached
+type OutboxDispatcher struct {
+	db          db.DBInterface
+	destination string
+	sink        OutboxSink
+	maxAttempts int
+}
+
+// NewOutboxDispatcher creates a dispatcher delivering messages for destination through sink,
+// giving up on a message after MaxOutboxAttempts
+func NewOutboxDispatcher(dbInterface db.DBInterface, destination string, sink OutboxSink) *OutboxDispatcher {
+	return &OutboxDispatcher{
+		db:          dbInterface,
+		destination: destination,
+		sink:        sink,
+		maxAttempts: consts.MaxOutboxAttempts,
+	}
+}
+
+// SetMaxAttempts changes how many delivery attempts are made before a message is marked failed.
+// With 0 messages are retried at the maximum backoff until delivered or discarded by an admin.
+func (d *OutboxDispatcher) SetMaxAttempts(maxAttempts int) {
+	d.maxAttempts = maxAttempts
+}
+
+// DispatchPending delivers one batch of due messages and returns how many were delivered
+func (d *OutboxDispatcher) DispatchPending(ctx context.Context) (int, error) {
+	now := time.Now()
+	messages, err := d.db.GetPendingOutboxMessages(d.destination, now, consts.OutboxBatchSize)
+	if err != nil {
+		return 0, fmt.Errorf("failed to fetch pending outbox messages: %w", err)
+	}
+
+	delivered := 0
+	for _, msg := range messages {
+		if err := d.sink.Deliver(ctx, msg); err != nil {
+			attempts := msg.Attempts + 1
+			failed := d.maxAttempts > 0 && attempts >= d.maxAttempts
+			if failed {
+				log.Printf("Giving up on %s outbox message %d after %d attempts: %v", d.destination, msg.ID, attempts, err)
+			} else if d.maxAttempts == 0 && attempts == consts.MaxOutboxAttempts {
+				log.Printf("%s outbox message %d still undelivered after %d attempts, retrying every %s: %v", d.destination, msg.ID, attempts, consts.MaxOutboxBackoff, err)
+			}
+			if err := d.db.MarkOutboxMessageRetry(msg.ID, err.Error(), now.Add(outboxBackoff(attempts)), failed); err != nil {
+				log.Printf("Failed to record attempt for outbox message %d: %v", msg.ID, err)
+			}
+			continue
+		}
+
+		if err := d.db.MarkOutboxMessageDelivered(msg.ID); err != nil {
+			// The message will be delivered again; consumers deduplicate on its token
+			log.Printf("Failed to mark outbox message %d delivered: %v", msg.ID, err)
+			continue
+		}
+		delivered++
+	}
+
+	return delivered, nil
+}
+
+// outboxBackoff returns the delay before the next delivery attempt, doubling per attempt
+func outboxBackoff(attempts int) time.Duration {
+	backoff := time.Second
+	for i := 1; i < attempts && backoff < consts.MaxOutboxBackoff; i++ {
+		backoff *= 2
+	}
+	if backoff > consts.MaxOutboxBackoff {
+		backoff = consts.MaxOutboxBackoff
+	}
+	return backoff
+}
+
+// ListOutboxMessages returns outbox messages with the given status for an admin, oldest first.
+// An empty destination lists every destination and an empty status lists pending messages.
+func (s *TransactionService) ListOutboxMessages(ctx context.Context, destination, status string) ([]models.OutboxMessage, error) {
+	if status == "" {
+		status = consts.OutboxPending
+	}
+
+	switch destination {
+	case "", consts.OutboxKafka, consts.OutboxMerchantWebhook, consts.OutboxWarehouse:
+	default:
+		return nil, ErrInvalidOutboxFilter
+	}
+	switch status {
+	case consts.OutboxPending, consts.OutboxDelivered, consts.OutboxFailed, consts.OutboxDiscarded:
+	default:
+		return nil, ErrInvalidOutboxFilter
+	}
+
+	return s.db.ListOutboxMessages(destination, status, consts.MaxOutboxListResults)
+}
+
+// RetryOutboxMessage makes a pending or failed outbox message due immediately with its attempts
+// reset, so a message that was given up on is delivered again once the destination recovers
+func (s *TransactionService) RetryOutboxMessage(ctx context.Context, messageID int) (*models.OutboxMessage, error) {
+	if err := s.db.RequeueOutboxMessage(messageID, time.Now()); err != nil {
+		return nil, s.outboxUpdateError(messageID, err)
+	}
+
+	log.Printf("Outbox message %d requeued by admin", messageID)
+	return s.db.GetOutboxMessage(messageID)
+}
+
+// DiscardOutboxMessage stops delivery of a pending or failed outbox message
+func (s *TransactionService) DiscardOutboxMessage(ctx context.Context, messageID int) (*models.OutboxMessage, error) {
+	if err := s.db.DiscardOutboxMessage(messageID); err != nil {
+		return nil, s.outboxUpdateError(messageID, err)
+	}
+
+	log.Printf("Outbox message %d discarded by admin", messageID)
+	return s.db.GetOutboxMessage(messageID)
+}
+
+// outboxUpdateError tells a missing outbox message from one that has already been delivered or discarded
+func (s *TransactionService) outboxUpdateError(messageID int, err error) error {
+	if !errors.Is(err, sql.ErrNoRows) {
+		return err
+	}
+	if _, err := s.db.GetOutboxMessage(messageID); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrOutboxMessageNotFound
+		}
+		return err
+	}
+	return ErrOutboxMessageDelivered
+}
+
+// KafkaSink publishes outbox messages to the Kafka topic for their content type
+type KafkaSink struct {
+	producer *kafka.Producer
+}
+
+// NewKafkaSink creates a sink publishing through a producer
+func NewKafkaSink(producer *kafka.Producer) KafkaSink {
+	return KafkaSink{producer: producer}
+}
+
+// Deliver publishes the event of the transaction the message recorded, with its dedup token
+func (s KafkaSink) Deliver(ctx context.Context, msg models.OutboxMessage) error {
+	var tx models.Transaction
+	if err := json.Unmarshal(msg.Payload, &tx); err != nil {
+		return fmt.Errorf("failed to decode transaction %d: %w", msg.TransactionID, err)
+	}
+	return s.producer.PublishTransaction(ctx, tx, msg.ContentType, msg.DedupToken)
+}
+
+// MerchantWebhookSink posts outbox messages to the webhook URL configured for their merchant
+type MerchantWebhookSink struct {
+	db     db.DBInterface
+	client *http.Client
+}
+
+// NewMerchantWebhookSink creates a sink delivering merchant webhooks
+func NewMerchantWebhookSink(dbInterface db.DBInterface) *MerchantWebhookSink {
+	return &MerchantWebhookSink{
+		db:     dbInterface,
+		client: &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// Deliver posts the message to the merchant's endpoint it was recorded for, or its webhook URL,
+// in the payload version of that target and signed when the merchant has a signing secret.
+// Merchants without a webhook URL and deleted endpoints have nothing to deliver to.
+func (s *MerchantWebhookSink) Deliver(ctx context.Context, msg models.OutboxMessage) error {
+	merchant, err := s.db.GetMerchantByID(msg.MerchantID)
+	if err != nil {
+		return fmt.Errorf("failed to fetch merchant: %w", err)
+	}
+	target, version, err := webhookTarget(s.db, merchant, msg)
+	if err != nil {
+		return err
+	}
+	if target == "" {
+		return nil
+	}
+
+	body, err := transformWebhookPayload(version, msg)
+	if err != nil {
+		return err
+	}
+	signature, err := SignDelivery(merchant, body)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, target, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to build webhook request: %w", err)
+	}
+	req.Header.Set("Content-Type", msg.ContentType)
+	req.Header.Set(MerchantWebhookEventHeader, msg.EventType)
+	req.Header.Set(MerchantWebhookIdempotencyHeader, msg.DedupToken)
+	req.Header.Set(MerchantWebhookVersionHeader, version)
+	if signature != "" {
+		req.Header.Set(webhook.SignatureHeader, signature)
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to deliver webhook: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("merchant webhook returned status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// OutboxDedupToken derives the token identifying one occurrence of an event, so recording
+// the same transition twice (for example on a replayed callback) yields the same token
+func OutboxDedupToken(eventType string, transactionID int, status string) string {
+	sum := sha256.Sum256([]byte(fmt.Sprintf("%s|%d|%s", eventType, transactionID, status)))
+	return hex.EncodeToString(sum[:])
+}
+
+// emit publishes a lifecycle event to in-process subscribers and records the merchant
+// webhook and warehouse export for it in the outbox
+func (s *TransactionService) emit(evt events.TransactionEvent) {
+	if evt.OccurredAt.IsZero() {
+		evt.OccurredAt = time.Now()
+	}
+	evt.Transaction.RecoveryHint = s.recoveryHints.Hint(evt.Transaction.DeclineCode)
+
+	s.recordTransactionEvent(evt)
+	s.events.Publish(evt)
+	s.enqueueWarehouse(evt)
+
+	if evt.Type != events.TransactionStatusChanged || evt.Transaction.UserID == 0 {
+		return
+	}
+
+	user, err := s.db.GetUserByID(evt.Transaction.UserID)
+	if err != nil || user.MerchantID == 0 {
+		return
+	}
+
+	payload, err := json.Marshal(evt)
+	if err != nil {
+		log.Printf("Failed to marshal %s event for transaction %d: %v", evt.Type, evt.Transaction.ID, err)
+		return
+	}
+
+	s.enqueue(models.OutboxMessage{
+		Destination:   consts.OutboxMerchantWebhook,
+		EventType:     evt.Type,
+		TransactionID: evt.Transaction.ID,
+		MerchantID:    user.MerchantID,
+		DedupToken:    OutboxDedupToken(evt.Type, evt.Transaction.ID, evt.Transaction.Status),
+		ContentType:   "application/json",
+		Payload:       payload,
+	})
+}
+
+// enqueueSubmitted records the Kafka event announcing that a gateway accepted a transaction
+func (s *TransactionService) enqueueSubmitted(tx models.Transaction, dataFormat string) {
+	payload, err := json.Marshal(tx)
+	if err != nil {
+		log.Printf("Failed to marshal transaction: %v", err)
+		return
+	}
+
+	s.enqueue(models.OutboxMessage{
+		Destination:   consts.OutboxKafka,
+		EventType:     kafka.EventTransactionSubmitted,
+		TransactionID: tx.ID,
+		DedupToken:    OutboxDedupToken(kafka.EventTransactionSubmitted, tx.ID, ""),
+		ContentType:   dataFormat,
+		Payload:       payload,
+	})
+}
+
+// enqueue records an outbox message for the dispatchers to deliver. Merchant webhooks are also
+// recorded for each of the merchant's endpoints that receives their event type.
+func (s *TransactionService) enqueue(msg models.OutboxMessage) {
+	messages := []models.OutboxMessage{msg}
+	if msg.Destination == consts.OutboxMerchantWebhook {
+		messages = append(messages, endpointMessages(s.db, msg)...)
+	}
+	if err := s.db.CreateOutboxMessages(messages); err != nil {
+		log.Printf("Failed to record %s outbox message for transaction %d: %v", msg.Destination, msg.TransactionID, err)
+	}
+}
@@ -0,0 +1,200 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"payment-gateway/db"
+	"payment-gateway/internal/consts"
+	"payment-gateway/internal/models"
+	"testing"
+	"time"
+)
+
+// recordingSink records delivered messages and fails while err is set
+type recordingSink struct {
+	delivered []models.OutboxMessage
+	err       error
+}
+
+func (s *recordingSink) Deliver(ctx context.Context, msg models.OutboxMessage) error {
+	if s.err != nil {
+		return s.err
+	}
+	s.delivered = append(s.delivered, msg)
+	return nil
+}
+
+// TestCallbackReplayEnqueuesWebhookOnce tests that replaying a callback does not record a second merchant webhook
+func TestCallbackReplayEnqueuesWebhookOnce(t *testing.T) {
+	mockDB := db.NewMockDB()
+	service := NewTransactionService(mockDB, &mockGatewaySelector{})
+
+	txID, err := mockDB.CreateTransaction(models.Transaction{UserID: 1, Amount: 10, Currency: "USD", Status: consts.Processing})
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+
+	callback := &models.CallbackData{TransactionID: txID, Status: consts.Completed}
+	for i := 0; i < 2; i++ {
+		if err := service.HandleCallback(context.Background(), callback); err != nil {
+			t.Fatalf("Expected no error, got: %v", err)
+		}
+	}
+
+	messages, err := mockDB.GetPendingOutboxMessages(consts.OutboxMerchantWebhook, time.Now(), consts.OutboxBatchSize)
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	if len(messages) != 1 {
+		t.Fatalf("Expected 1 merchant webhook message, got %d", len(messages))
+	}
+	if messages[0].MerchantID != 1 {
+		t.Errorf("Expected merchant 1, got %d", messages[0].MerchantID)
+	}
+}
+
+// TestOutboxDispatcherRetries tests that failed deliveries are rescheduled and later delivered
+func TestOutboxDispatcherRetries(t *testing.T) {
+	mockDB := db.NewMockDB()
+	msg := models.OutboxMessage{
+		Destination:   consts.OutboxKafka,
+		EventType:     "transaction.submitted",
+		TransactionID: 1,
+		DedupToken:    OutboxDedupToken("transaction.submitted", 1, ""),
+		ContentType:   "application/json",
+		Payload:       []byte(`{"id":1}`),
+	}
+	if err := mockDB.CreateOutboxMessages([]models.OutboxMessage{msg}); err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+
+	sink := &recordingSink{err: errors.New("broker unavailable")}
+	dispatcher := NewOutboxDispatcher(mockDB, consts.OutboxKafka, sink)
+
+	delivered, err := dispatcher.DispatchPending(context.Background())
+	if err != nil || delivered != 0 {
+		t.Fatalf("Expected failed delivery, got %d delivered, err: %v", delivered, err)
+	}
+
+	// The failed message backs off, so it is not due again immediately
+	pending, _ := mockDB.GetPendingOutboxMessages(consts.OutboxKafka, time.Now(), consts.OutboxBatchSize)
+	if len(pending) != 0 {
+		t.Fatalf("Expected message to be backing off, got %d due", len(pending))
+	}
+	pending, _ = mockDB.GetPendingOutboxMessages(consts.OutboxKafka, time.Now().Add(consts.MaxOutboxBackoff), consts.OutboxBatchSize)
+	if len(pending) != 1 || pending[0].Attempts != 1 || pending[0].LastError == "" {
+		t.Fatalf("Expected one rescheduled message with its error recorded, got %+v", pending)
+	}
+
+	// Once the sink recovers the message is delivered exactly once
+	if err := mockDB.MarkOutboxMessageRetry(pending[0].ID, pending[0].LastError, time.Now(), false); err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	sink.err = nil
+	for i := 0; i < 2; i++ {
+		if _, err := dispatcher.DispatchPending(context.Background()); err != nil {
+			t.Fatalf("Expected no error, got: %v", err)
+		}
+	}
+	if len(sink.delivered) != 1 || sink.delivered[0].DedupToken != msg.DedupToken {
+		t.Errorf("Expected one delivery carrying the dedup token, got %+v", sink.delivered)
+	}
+}
+
+// TestOutboxDispatcherUnlimitedAttempts tests that a dispatcher without an attempt limit keeps a
+// message pending where the default limit gives up on it
+func TestOutboxDispatcherUnlimitedAttempts(t *testing.T) {
+	for _, tt := range []struct {
+		name        string
+		maxAttempts int
+		wantStatus  string
+	}{
+		{"default limit", consts.MaxOutboxAttempts, consts.OutboxFailed},
+		{"unlimited", 0, consts.OutboxPending},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			mockDB := db.NewMockDB()
+			err := mockDB.CreateOutboxMessages([]models.OutboxMessage{{
+				Destination:   consts.OutboxKafka,
+				EventType:     "transaction.submitted",
+				TransactionID: 1,
+				DedupToken:    OutboxDedupToken("transaction.submitted", 1, ""),
+				ContentType:   "application/json",
+				Payload:       []byte(`{"id":1}`),
+				Attempts:      consts.MaxOutboxAttempts - 1,
+			}})
+			if err != nil {
+				t.Fatalf("Expected no error, got: %v", err)
+			}
+
+			dispatcher := NewOutboxDispatcher(mockDB, consts.OutboxKafka, &recordingSink{err: errors.New("broker unavailable")})
+			dispatcher.SetMaxAttempts(tt.maxAttempts)
+			if _, err := dispatcher.DispatchPending(context.Background()); err != nil {
+				t.Fatalf("Expected no error, got: %v", err)
+			}
+
+			msg, _ := mockDB.GetOutboxMessage(1)
+			if msg.Status != tt.wantStatus || msg.Attempts != consts.MaxOutboxAttempts {
+				t.Errorf("Expected status %s after %d attempts, got %s after %d", tt.wantStatus, consts.MaxOutboxAttempts, msg.Status, msg.Attempts)
+			}
+			if tt.wantStatus == consts.OutboxPending && msg.NextAttemptAt.Before(time.Now().Add(consts.MaxOutboxBackoff-time.Second)) {
+				t.Errorf("Expected the next attempt at the maximum backoff, got %v", msg.NextAttemptAt)
+			}
+		})
+	}
+}
+
+// TestOutboxAdminActions tests that admins can list, requeue and discard undelivered messages
+func TestOutboxAdminActions(t *testing.T) {
+	mockDB := db.NewMockDB()
+	service := NewTransactionService(mockDB, &mockGatewaySelector{})
+	ctx := context.Background()
+
+	for i := 1; i <= 2; i++ {
+		err := mockDB.CreateOutboxMessages([]models.OutboxMessage{{
+			Destination:   consts.OutboxMerchantWebhook,
+			EventType:     "transaction.status_changed",
+			TransactionID: i,
+			DedupToken:    OutboxDedupToken("transaction.status_changed", i, consts.Completed),
+			ContentType:   "application/json",
+			Payload:       []byte(`{}`),
+		}})
+		if err != nil {
+			t.Fatalf("Expected no error, got: %v", err)
+		}
+	}
+	mockDB.MarkOutboxMessageRetry(1, "connection refused", time.Now().Add(time.Hour), true)
+
+	failed, err := service.ListOutboxMessages(ctx, consts.OutboxMerchantWebhook, consts.OutboxFailed)
+	if err != nil || len(failed) != 1 || failed[0].ID != 1 {
+		t.Fatalf("Expected message 1 to be listed as failed, got %+v, %v", failed, err)
+	}
+	if _, err := service.ListOutboxMessages(ctx, "smtp", ""); !errors.Is(err, ErrInvalidOutboxFilter) {
+		t.Errorf("Expected ErrInvalidOutboxFilter, got: %v", err)
+	}
+
+	// A retried message is due now with a fresh set of attempts
+	msg, err := service.RetryOutboxMessage(ctx, 1)
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	if msg.Status != consts.OutboxPending || msg.Attempts != 0 || msg.NextAttemptAt.After(time.Now()) {
+		t.Errorf("Expected message due now with attempts reset, got %+v", msg)
+	}
+
+	msg, err = service.DiscardOutboxMessage(ctx, 2)
+	if err != nil || msg.Status != consts.OutboxDiscarded {
+		t.Fatalf("Expected message discarded, got %+v, %v", msg, err)
+	}
+	pending, _ := mockDB.GetPendingOutboxMessages(consts.OutboxMerchantWebhook, time.Now(), consts.OutboxBatchSize)
+	if len(pending) != 1 || pending[0].ID != 1 {
+		t.Errorf("Expected only the retried message to be pending, got %+v", pending)
+	}
+
+	if _, err := service.RetryOutboxMessage(ctx, 2); !errors.Is(err, ErrOutboxMessageDelivered) {
+		t.Errorf("Expected ErrOutboxMessageDelivered, got: %v", err)
+	}
+	if _, err := service.DiscardOutboxMessage(ctx, 99); !errors.Is(err, ErrOutboxMessageNotFound) {
+		t.Errorf("Expected ErrOutboxMessageNotFound, got: %v", err)
+	}
+}
@@ -0,0 +1,45 @@
+package services
+
+import (
+	"fmt"
+	"log"
+	"payment-gateway/db"
+	"time"
+)
+
+// PartitionService keeps monthly partitions of high-volume tables created ahead of time
+// so inserts never fall through to the default partition
+type PartitionService struct {
+	partitioner db.Partitioner
+	tables      []string
+	monthsAhead int
+	now         func() time.Time
+}
+
+// NewPartitionService creates a partition service maintaining the given tables
+func NewPartitionService(partitioner db.Partitioner, tables []string, monthsAhead int) *PartitionService {
+	return &PartitionService{
+		partitioner: partitioner,
+		tables:      tables,
+		monthsAhead: monthsAhead,
+		now:         time.Now,
+	}
+}
+
+// EnsurePartitions creates partitions for the current month and the configured months ahead
+func (s *PartitionService) EnsurePartitions() error {
+	from := db.StartOfMonth(s.now())
+
+	for _, table := range s.tables {
+		created, err := s.partitioner.EnsureMonthlyPartitions(table, from, s.monthsAhead+1)
+		if err != nil {
+			return fmt.Errorf("failed to ensure partitions for %s: %w", table, err)
+		}
+
+		for _, name := range created {
+			log.Printf("Created partition %s", name)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,56 @@
+package services
+
+import (
+	"errors"
+	"payment-gateway/db"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// mockPartitioner records requested partitions instead of creating them
+type mockPartitioner struct {
+	requested []string
+	err       error
+}
+
+func (m *mockPartitioner) EnsureMonthlyPartitions(table string, from time.Time, months int) ([]string, error) {
+	if m.err != nil {
+		return nil, m.err
+	}
+
+	var created []string
+	for i := 0; i < months; i++ {
+		created = append(created, db.MonthlyPartitionName(table, from.AddDate(0, i, 0)))
+	}
+	m.requested = append(m.requested, created...)
+	return created, nil
+}
+
+// TestEnsurePartitions tests that partitions are requested for the current and upcoming months
+func TestEnsurePartitions(t *testing.T) {
+	partitioner := &mockPartitioner{}
+	service := NewPartitionService(partitioner, []string{"transactions", "routing_decisions"}, 2)
+	service.now = func() time.Time { return time.Date(2025, time.November, 17, 15, 4, 5, 0, time.UTC) }
+
+	if err := service.EnsurePartitions(); err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+
+	expected := []string{
+		"transactions_p202511", "transactions_p202512", "transactions_p202601",
+		"routing_decisions_p202511", "routing_decisions_p202512", "routing_decisions_p202601",
+	}
+	if !reflect.DeepEqual(partitioner.requested, expected) {
+		t.Errorf("Expected partitions %v, got %v", expected, partitioner.requested)
+	}
+}
+
+// TestEnsurePartitionsError tests that partition failures are reported with the table name
+func TestEnsurePartitionsError(t *testing.T) {
+	service := NewPartitionService(&mockPartitioner{err: errors.New("permission denied")}, []string{"transactions"}, 1)
+
+	if err := service.EnsurePartitions(); err == nil {
+		t.Error("Expected error when partition creation fails")
+	}
+}
@@ -0,0 +1,49 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"payment-gateway/internal/consts"
+	"payment-gateway/internal/events"
+	"payment-gateway/internal/gateway"
+	"payment-gateway/internal/models"
+	"strconv"
+	"time"
+)
+
+// ExpirePayments fails the deposits of gateways with a payment window, such as PIX charges, that
+// are still unpaid once their window and consts.PaymentExpiryGrace passed, returning how many were
+// failed. Deposits are failed with the consent_expired decline code.
+func (s *TransactionService) ExpirePayments(ctx context.Context) (int, error) {
+	now := time.Now()
+	expired := 0
+
+	for _, provider := range s.gatewaySelector.Providers() {
+		window := gateway.PaymentExpiry(provider)
+		if window <= 0 {
+			continue
+		}
+		gatewayID, err := strconv.Atoi(provider.ID())
+		if err != nil {
+			continue
+		}
+
+		message := fmt.Sprintf("not paid within %s", window)
+		ids, err := s.db.ExpireUnpaidDeposits(gatewayID, now.Add(-window-consts.PaymentExpiryGrace), message, consts.DeclineConsentExpired, consts.PaymentExpiryBatchSize)
+		if err != nil {
+			return expired, fmt.Errorf("failed to expire deposits of gateway %d: %w", gatewayID, err)
+		}
+
+		for _, id := range ids {
+			log.Printf("Transaction %d: %s deposit %s", id, provider.Name(), message)
+			if tx, err := s.db.GetTransactionByID(id); err == nil {
+				s.emit(events.TransactionEvent{Type: events.TransactionStatusChanged, Transaction: *tx})
+			} else {
+				s.publishStatus(models.Transaction{ID: id}, consts.Failed, message)
+			}
+		}
+		expired += len(ids)
+	}
+	return expired, nil
+}
@@ -0,0 +1,76 @@
+package services
+
+import (
+	"context"
+	"payment-gateway/db"
+	"payment-gateway/internal/consts"
+	"payment-gateway/internal/gateway"
+	"payment-gateway/internal/models"
+	"testing"
+	"time"
+)
+
+// mockExpiringProvider is a mock gateway whose deposits must be paid within half an hour
+type mockExpiringProvider struct {
+	*gateway.MockProvider
+}
+
+func (p *mockExpiringProvider) PaymentExpiry() time.Duration {
+	return 30 * time.Minute
+}
+
+// TestExpirePayments tests that unpaid deposits at a gateway with a payment window are failed
+// once it closed, leaving paid deposits, withdrawals and other gateways' deposits alone
+func TestExpirePayments(t *testing.T) {
+	mockDB := db.NewMockDB()
+	selector := gateway.NewSelector(mockDB)
+	selector.RegisterProvider(gateway.NewMockProvider(2, "Stripe", "application/json", 1.0, 0))
+	selector.RegisterProvider(&mockExpiringProvider{MockProvider: gateway.NewMockProvider(10, "PIX", "application/json", 1.0, 0)})
+	service := NewTransactionService(mockDB, selector)
+	userID, err := mockDB.CreateUser(models.User{Username: "joao", Email: "joao@example.com", CountryID: 1, MerchantID: 1})
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+
+	old := time.Now().Add(-time.Hour)
+	create := func(gatewayID int, txType, status string, createdAt time.Time) int {
+		id, err := mockDB.CreateTransaction(models.Transaction{Amount: 50, Currency: "BRL", Type: txType, Status: status, UserID: userID, GatewayID: gatewayID, CreatedAt: createdAt})
+		if err != nil {
+			t.Fatalf("Expected no error, got: %v", err)
+		}
+		return id
+	}
+	unpaid := create(10, consts.Deposit, consts.Processing, old)
+	paid := create(10, consts.Deposit, consts.Completed, old)
+	recent := create(10, consts.Deposit, consts.Processing, time.Now().Add(-20*time.Minute))
+	withinGrace := create(10, consts.Deposit, consts.Processing, time.Now().Add(-31*time.Minute))
+	withdrawal := create(10, consts.Withdrawal, consts.Processing, old)
+	otherGateway := create(2, consts.Deposit, consts.Processing, old)
+
+	expired, err := service.ExpirePayments(context.Background())
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	if expired != 1 {
+		t.Errorf("Expected 1 deposit expired, got %d", expired)
+	}
+
+	tx, _ := mockDB.GetTransactionByID(unpaid)
+	if tx.Status != consts.Failed || tx.DeclineCode != consts.DeclineConsentExpired || tx.ErrorMessage == "" {
+		t.Errorf("Expected the unpaid deposit failed as consent_expired, got %+v", tx)
+	}
+	for name, id := range map[string]int{"paid": paid, "recent": recent, "within grace": withinGrace, "withdrawal": withdrawal, "other gateway": otherGateway} {
+		if tx, _ := mockDB.GetTransactionByID(id); tx.Status == consts.Failed {
+			t.Errorf("Expected the %s transaction left alone, got %+v", name, tx)
+		}
+	}
+
+	messages, _ := mockDB.GetPendingOutboxMessages(consts.OutboxMerchantWebhook, time.Now(), consts.OutboxBatchSize)
+	if len(messages) != 1 || messages[0].TransactionID != unpaid {
+		t.Errorf("Expected a webhook announcing the failed deposit, got %+v", messages)
+	}
+
+	if expired, _ := service.ExpirePayments(context.Background()); expired != 0 {
+		t.Errorf("Expected nothing left to expire, got %d", expired)
+	}
+}
@@ -0,0 +1,81 @@
+package services
+
+import (
+	"context"
+	"log"
+	"payment-gateway/internal/consts"
+	"payment-gateway/internal/gateway"
+	"payment-gateway/internal/models"
+	"strconv"
+)
+
+// ListPaymentMethods returns the payment options customers have for a deposit in a country, and in
+// a currency or the country's own for "". Options are listed per gateway, in routing priority
+// order, for gateways that support the country and currency and could take the deposit now; a
+// payment initiation gateway lists each of its banks in the country taking the currency. The
+// compliance fields the country requires of deposits are listed with them.
+func (s *TransactionService) ListPaymentMethods(ctx context.Context, countryCode, currency string) (*models.PaymentMethods, error) {
+	country, err := s.countryByCode(countryCode)
+	if err != nil {
+		return nil, err
+	}
+	if currency == "" {
+		currency = country.Currency
+	}
+
+	requiredFields, err := s.countryComplianceFields(country.ID, consts.Deposit)
+	if err != nil {
+		return nil, err
+	}
+
+	providers, err := s.gatewaySelector.AvailableProviders(ctx, country.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	methods := &models.PaymentMethods{CountryCode: country.Code, Currency: currency, Options: []models.PaymentOption{}, RequiredFields: requiredFields}
+	for _, provider := range providers {
+		if !gateway.SupportsCurrency(provider, currency) {
+			continue
+		}
+		gatewayID, _ := strconv.Atoi(provider.ID())
+
+		initiator, ok := provider.(gateway.PaymentInitiationProvider)
+		if !ok {
+			for _, method := range gateway.PaymentMethods(provider) {
+				methods.Options = append(methods.Options, models.PaymentOption{Type: method, GatewayID: gatewayID, GatewayName: provider.Name()})
+			}
+			continue
+		}
+
+		// A bank directory that cannot be read leaves its banks out rather than failing the list
+		banks, err := initiator.Banks(ctx, country.Code)
+		if err != nil {
+			log.Printf("Failed to list banks of %s for payment methods: %v", provider.Name(), err)
+			continue
+		}
+		for _, bank := range banks {
+			if !bankTakesCurrency(bank, currency) {
+				continue
+			}
+			methods.Options = append(methods.Options, models.PaymentOption{
+				Type:        consts.PaymentMethodBank,
+				GatewayID:   gatewayID,
+				GatewayName: provider.Name(),
+				BankID:      bank.ID,
+				BankName:    bank.Name,
+			})
+		}
+	}
+	return methods, nil
+}
+
+// bankTakesCurrency reports whether customers can pay from a bank in a currency
+func bankTakesCurrency(bank models.Bank, currency string) bool {
+	for _, supported := range bank.Currencies {
+		if supported == currency {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,56 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"payment-gateway/db"
+	"payment-gateway/internal/consts"
+	"payment-gateway/internal/gateway"
+	"payment-gateway/internal/models"
+	"testing"
+)
+
+// TestListPaymentMethods tests that the options of available gateways and banks taking the
+// currency are listed in priority order
+func TestListPaymentMethods(t *testing.T) {
+	mockDB := db.NewMockDB()
+	selector := gateway.NewSelector(mockDB)
+	selector.RegisterProvider(gateway.NewMockOpenBankingProvider(1, "Open Banking", []models.Bank{
+		{ID: "sandbox-gb", Name: "Sandbox Bank UK", CountryCode: "GB", Currencies: []string{"GBP"}},
+		{ID: "sandbox-de", Name: "Sandbox Bank Deutschland", CountryCode: "DE", Currencies: []string{"EUR"}},
+	}))
+	selector.RegisterProvider(gateway.NewMockProvider(2, "Stripe", "application/json", 1.0, 0))
+	selector.RegisterProvider(gateway.NewISO8583Provider(3, "Card Switch", gateway.ISO8583Config{}))
+	service := NewTransactionService(mockDB, selector)
+	ctx := context.Background()
+
+	methods, err := service.ListPaymentMethods(ctx, "GB", "")
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	want := []models.PaymentOption{
+		{Type: consts.PaymentMethodCard, GatewayID: 2, GatewayName: "Stripe"},
+		{Type: consts.PaymentMethodWallet, GatewayID: 2, GatewayName: "Stripe"},
+		{Type: consts.PaymentMethodBank, GatewayID: 1, GatewayName: "Open Banking", BankID: "sandbox-gb", BankName: "Sandbox Bank UK"},
+		{Type: consts.PaymentMethodCard, GatewayID: 3, GatewayName: "Card Switch"},
+	}
+	if methods.Currency != "GBP" || len(methods.Options) != len(want) {
+		t.Fatalf("Expected %d GBP options, got %+v", len(want), methods)
+	}
+	for i, option := range want {
+		if methods.Options[i] != option {
+			t.Errorf("Option %d: expected %+v, got %+v", i, option, methods.Options[i])
+		}
+	}
+
+	// The card switch has no code for KRW, and no bank in the country takes it
+	selector.MarkGatewayDown("2")
+	methods, err = service.ListPaymentMethods(ctx, "GB", "KRW")
+	if err != nil || len(methods.Options) != 0 {
+		t.Errorf("Expected no KRW options with the card gateway down, got %+v, %v", methods, err)
+	}
+
+	if _, err := service.ListPaymentMethods(ctx, "FR", ""); !errors.Is(err, ErrUnsupportedCountry) {
+		t.Errorf("Expected ErrUnsupportedCountry, got: %v", err)
+	}
+}
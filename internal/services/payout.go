@@ -0,0 +1,305 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"payment-gateway/db"
+	"payment-gateway/internal/consts"
+	"payment-gateway/internal/gateway"
+	"payment-gateway/internal/models"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Payout schedule frequencies
+const (
+	PayoutDaily  = "daily"
+	PayoutWeekly = "weekly"
+)
+
+// payoutClockLayout is the format of payout and cut-off times
+const payoutClockLayout = "15:04"
+
+var (
+	ErrInvalidPayoutSchedule  = errors.New("invalid payout schedule")
+	ErrPayoutScheduleNotFound = errors.New("payout schedule not found")
+)
+
+var weekdays = map[string]time.Weekday{
+	"sunday":    time.Sunday,
+	"monday":    time.Monday,
+	"tuesday":   time.Tuesday,
+	"wednesday": time.Wednesday,
+	"thursday":  time.Thursday,
+	"friday":    time.Friday,
+	"saturday":  time.Saturday,
+}
+
+// PayoutService manages merchant payout schedules and pays out scheduled withdrawals once
+// their payout is due
+type PayoutService struct {
+	db           db.DBInterface
+	transactions *TransactionService
+	now          func() time.Time
+}
+
+// NewPayoutService creates a new payout service submitting due withdrawals through transactions
+func NewPayoutService(dbInterface db.DBInterface, transactions *TransactionService) *PayoutService {
+	return &PayoutService{db: dbInterface, transactions: transactions, now: time.Now}
+}
+
+// Get returns a merchant's payout schedule
+func (s *PayoutService) Get(ctx context.Context, merchantID int) (*models.PayoutSchedule, error) {
+	schedule, err := s.db.GetPayoutSchedule(merchantID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrPayoutScheduleNotFound
+		}
+		return nil, err
+	}
+	return schedule, nil
+}
+
+// Save validates and sets a merchant's payout schedule. Withdrawals requested afterwards wait for
+// the next payout; ones already scheduled keep their payout time.
+func (s *PayoutService) Save(ctx context.Context, merchantID int, req models.PayoutScheduleRequest) (*models.PayoutSchedule, error) {
+	schedule := models.PayoutSchedule{
+		MerchantID: merchantID,
+		Frequency:  req.Frequency,
+		Weekday:    strings.ToLower(req.Weekday),
+		PayoutTime: req.PayoutTime,
+		CutoffTime: req.CutoffTime,
+		UpdatedAt:  s.now().UTC(),
+	}
+	if schedule.Frequency == PayoutDaily {
+		schedule.Weekday = ""
+	}
+	if err := validatePayoutSchedule(schedule); err != nil {
+		return nil, err
+	}
+
+	if err := s.db.SavePayoutSchedule(schedule); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrMerchantNotFound
+		}
+		return nil, err
+	}
+	return &schedule, nil
+}
+
+// Delete removes a merchant's payout schedule, so withdrawals are paid out immediately again.
+// Withdrawals already scheduled are still paid out at their payout time.
+func (s *PayoutService) Delete(ctx context.Context, merchantID int) error {
+	if err := s.db.DeletePayoutSchedule(merchantID); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrPayoutScheduleNotFound
+		}
+		return err
+	}
+	return nil
+}
+
+// RunDue submits the scheduled withdrawals whose payout is due, returning how many were submitted.
+// Withdrawals are claimed in batches grouped by payout, so each payout's withdrawals go out together.
+// Payouts falling on a day that is not a banking day in the withdrawal's country, such as a bank
+// holiday added after they were scheduled, are deferred to the next banking day.
+func (s *PayoutService) RunDue(ctx context.Context) (int, error) {
+	now := s.now()
+	ids, err := s.db.ClaimDueScheduledTransactions(now, consts.PayoutBatchSize)
+	if err != nil {
+		return 0, fmt.Errorf("failed to claim scheduled withdrawals: %w", err)
+	}
+
+	type payoutKey struct {
+		at        time.Time
+		countryID int
+	}
+	batches := make(map[payoutKey]int)
+
+	submitted := 0
+	for _, id := range ids {
+		tx, err := s.db.GetTransactionByID(id)
+		if err != nil {
+			log.Printf("Failed to load scheduled withdrawal %d: %v", id, err)
+			continue
+		}
+
+		deferred, err := s.deferToBankingDay(tx, now)
+		if err != nil {
+			log.Printf("Failed to check banking day of withdrawal %d: %v", id, err)
+		}
+		if deferred {
+			continue
+		}
+
+		if err := s.transactions.payout(ctx, tx); err != nil {
+			log.Printf("Payout of withdrawal %d failed: %v", id, err)
+			continue
+		}
+		batches[payoutKey{at: tx.ScheduledFor.UTC(), countryID: tx.CountryID}]++
+		submitted++
+	}
+
+	for key, count := range batches {
+		log.Printf("Paid out %d withdrawals of the %s payout in country %d", count, key.at.Format(time.RFC3339), key.countryID)
+	}
+	return submitted, nil
+}
+
+// deferToBankingDay reschedules a claimed withdrawal to the next banking day when now is not one in
+// its country, keeping the payout's local time of day, and reports whether it did
+func (s *PayoutService) deferToBankingDay(tx *models.Transaction, now time.Time) (bool, error) {
+	country, err := s.db.GetCountryByID(tx.CountryID)
+	if err != nil {
+		return false, err
+	}
+	loc := countryLocation(country)
+
+	local := now.In(loc)
+	today := time.Date(local.Year(), local.Month(), local.Day(), 0, 0, 0, 0, loc)
+	days, err := s.transactions.calendar.days(country.ID, today)
+	if err != nil {
+		return false, err
+	}
+	if days.isBankingDay(today) {
+		return false, nil
+	}
+
+	day := today
+	for !days.isBankingDay(day) {
+		day = day.AddDate(0, 0, 1)
+	}
+	payoutAt := tx.ScheduledFor.In(loc)
+	scheduledFor := time.Date(day.Year(), day.Month(), day.Day(), payoutAt.Hour(), payoutAt.Minute(), 0, 0, loc).UTC()
+
+	settlement, err := s.transactions.calendar.SettlementDate(country, scheduledFor)
+	if err != nil {
+		return false, err
+	}
+	if err := s.db.RescheduleTransaction(tx.ID, scheduledFor, settlement); err != nil {
+		return false, err
+	}
+
+	log.Printf("Deferred withdrawal %d to %s: %s is not a banking day in %s", tx.ID, scheduledFor.Format(time.RFC3339), today.Format(dateLayout), country.Code)
+	return true, nil
+}
+
+// validatePayoutSchedule checks a schedule's times and that weekly schedules name their day
+func validatePayoutSchedule(schedule models.PayoutSchedule) error {
+	payout, err := time.Parse(payoutClockLayout, schedule.PayoutTime)
+	if err != nil {
+		return fmt.Errorf("%w: payout_time %q is not HH:MM", ErrInvalidPayoutSchedule, schedule.PayoutTime)
+	}
+	cutoff, err := time.Parse(payoutClockLayout, schedule.CutoffTime)
+	if err != nil {
+		return fmt.Errorf("%w: cutoff_time %q is not HH:MM", ErrInvalidPayoutSchedule, schedule.CutoffTime)
+	}
+	if cutoff.After(payout) {
+		return fmt.Errorf("%w: cutoff_time must not be after payout_time", ErrInvalidPayoutSchedule)
+	}
+
+	switch schedule.Frequency {
+	case PayoutDaily:
+	case PayoutWeekly:
+		if _, ok := weekdays[schedule.Weekday]; !ok {
+			return fmt.Errorf("%w: weekly payouts need a weekday", ErrInvalidPayoutSchedule)
+		}
+	default:
+		return fmt.Errorf("%w: unknown frequency %q", ErrInvalidPayoutSchedule, schedule.Frequency)
+	}
+	return nil
+}
+
+// nextPayout returns the first payout of a schedule whose cut-off is after now, with the
+// schedule's times read in loc. A payout falling on a day isBankingDay rejects is deferred to
+// the next banking day. Validated schedules always have one within a week.
+func nextPayout(now time.Time, schedule models.PayoutSchedule, loc *time.Location, isBankingDay func(time.Time) bool) time.Time {
+	payout, _ := time.Parse(payoutClockLayout, schedule.PayoutTime)
+	cutoff, _ := time.Parse(payoutClockLayout, schedule.CutoffTime)
+	local := now.In(loc)
+
+	for days := 0; days <= 7; days++ {
+		day := time.Date(local.Year(), local.Month(), local.Day()+days, 0, 0, 0, 0, loc)
+		if schedule.Frequency == PayoutWeekly && day.Weekday() != weekdays[schedule.Weekday] {
+			continue
+		}
+
+		dayCutoff := time.Date(day.Year(), day.Month(), day.Day(), cutoff.Hour(), cutoff.Minute(), 0, 0, loc)
+		if local.Before(dayCutoff) {
+			for !isBankingDay(day) {
+				day = day.AddDate(0, 0, 1)
+			}
+			return time.Date(day.Year(), day.Month(), day.Day(), payout.Hour(), payout.Minute(), 0, 0, loc).UTC()
+		}
+	}
+	return time.Time{}
+}
+
+// scheduledPayout returns when a withdrawal requested now by the user should be paid out, or
+// the zero time when the user's merchant pays out immediately
+func (s *TransactionService) scheduledPayout(user *models.User, country *countryResolution) (time.Time, error) {
+	if user.MerchantID == 0 {
+		return time.Time{}, nil
+	}
+
+	schedule, err := s.db.GetPayoutSchedule(user.MerchantID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return time.Time{}, nil
+		}
+		return time.Time{}, fmt.Errorf("failed to get payout schedule: %w", err)
+	}
+
+	transactionCountry, err := s.db.GetCountryByID(country.countryID)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("failed to get transaction country: %w", err)
+	}
+	loc := countryLocation(transactionCountry)
+
+	now := time.Now()
+	days, err := s.calendar.days(transactionCountry.ID, now.In(loc))
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	return nextPayout(now, *schedule, loc, days.isBankingDay), nil
+}
+
+// payout submits a claimed scheduled withdrawal. The gateway selected when it was requested is
+// kept while it is available; otherwise the withdrawal is routed again.
+func (s *TransactionService) payout(ctx context.Context, tx *models.Transaction) error {
+	user, err := s.db.GetUserByID(tx.UserID)
+	if err != nil {
+		return fmt.Errorf("failed to get user: %w", err)
+	}
+
+	opts := gateway.SelectionOptions{PreferredGatewayID: strconv.Itoa(tx.GatewayID)}
+	req := models.TransactionRequest{Amount: tx.Amount, Currency: tx.Currency}
+	if err := s.applyRoutingRules(&opts, tx.Type, user, &countryResolution{countryID: tx.CountryID}, req); err != nil {
+		return err
+	}
+
+	provider, decision, err := s.gatewaySelector.SelectGatewayWithOptions(ctx, tx.CountryID, tx.Type, opts)
+	if err != nil {
+		s.updateStatus(tx.ID, tx.Status, consts.Failed, err.Error())
+		s.publishStatus(*tx, consts.Failed, err.Error())
+		return fmt.Errorf("failed to select gateway: %w", err)
+	}
+
+	if gatewayID := atoi(provider.ID()); gatewayID != tx.GatewayID {
+		if err := s.db.UpdateTransactionGateway(tx.ID, gatewayID); err != nil {
+			return err
+		}
+		decision.Reason = fmt.Sprintf("%s; gateway %d unavailable at payout", decision.Reason, tx.GatewayID)
+		s.recordRoutingDecision(tx.ID, decision)
+		tx.GatewayID = gatewayID
+	}
+
+	tx.Status = consts.Pending
+	_, err = s.submit(ctx, provider, *tx)
+	return err
+}
@@ -0,0 +1,151 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"payment-gateway/db"
+	"payment-gateway/internal/consts"
+	"payment-gateway/internal/models"
+	"testing"
+	"time"
+)
+
+// TestNextPayout tests when withdrawals are paid out around cut-off times and across timezones
+func TestNextPayout(t *testing.T) {
+	newYork, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Fatalf("Failed to load timezone: %v", err)
+	}
+	tokyo, err := time.LoadLocation("Asia/Tokyo")
+	if err != nil {
+		t.Fatalf("Failed to load timezone: %v", err)
+	}
+
+	daily := models.PayoutSchedule{Frequency: PayoutDaily, PayoutTime: "17:00", CutoffTime: "15:00"}
+	weekly := models.PayoutSchedule{Frequency: PayoutWeekly, Weekday: "friday", PayoutTime: "17:00", CutoffTime: "12:00"}
+	holiday := bankingDays{"2024-03-15": true}
+
+	tests := []struct {
+		name     string
+		now      time.Time
+		schedule models.PayoutSchedule
+		loc      *time.Location
+		calendar bankingDays
+		want     time.Time
+	}{
+		// Wednesday 13 March 2024
+		{"daily before cutoff", time.Date(2024, 3, 13, 14, 59, 0, 0, newYork), daily, newYork, nil, time.Date(2024, 3, 13, 17, 0, 0, 0, newYork)},
+		{"daily at cutoff", time.Date(2024, 3, 13, 15, 0, 0, 0, newYork), daily, newYork, nil, time.Date(2024, 3, 14, 17, 0, 0, 0, newYork)},
+		{"weekly on another day", time.Date(2024, 3, 13, 9, 0, 0, 0, newYork), weekly, newYork, nil, time.Date(2024, 3, 15, 17, 0, 0, 0, newYork)},
+		{"weekly after cutoff", time.Date(2024, 3, 15, 13, 0, 0, 0, newYork), weekly, newYork, nil, time.Date(2024, 3, 22, 17, 0, 0, 0, newYork)},
+		// 20:00 UTC is already past the cutoff of the next day in Tokyo
+		{"daily in another timezone", time.Date(2024, 3, 13, 20, 0, 0, 0, time.UTC), daily, tokyo, nil, time.Date(2024, 3, 14, 17, 0, 0, 0, tokyo)},
+		// US clocks go forward on Sunday 10 March 2024
+		{"daily across DST change", time.Date(2024, 3, 8, 16, 0, 0, 0, newYork), daily, newYork, nil, time.Date(2024, 3, 11, 17, 0, 0, 0, newYork)},
+		// Payouts on weekends and bank holidays move to the next banking day
+		{"daily on a weekend", time.Date(2024, 3, 16, 9, 0, 0, 0, newYork), daily, newYork, nil, time.Date(2024, 3, 18, 17, 0, 0, 0, newYork)},
+		{"daily on a holiday", time.Date(2024, 3, 15, 9, 0, 0, 0, newYork), daily, newYork, holiday, time.Date(2024, 3, 18, 17, 0, 0, 0, newYork)},
+		{"weekly on a holiday", time.Date(2024, 3, 13, 9, 0, 0, 0, newYork), weekly, newYork, holiday, time.Date(2024, 3, 18, 17, 0, 0, 0, newYork)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := nextPayout(tt.now, tt.schedule, tt.loc, tt.calendar.isBankingDay); !got.Equal(tt.want) {
+				t.Errorf("Expected payout at %v, got %v", tt.want, got.In(tt.loc))
+			}
+		})
+	}
+}
+
+// TestPayoutSchedule tests that withdrawals wait for the scheduled payout and are submitted once due
+func TestPayoutSchedule(t *testing.T) {
+	mockDB := db.NewMockDB()
+	transactions := NewTransactionService(mockDB, newRulesSelector(mockDB))
+	service := NewPayoutService(mockDB, transactions)
+	ctx := context.Background()
+
+	invalid := []models.PayoutScheduleRequest{
+		{Frequency: PayoutDaily, PayoutTime: "17:00", CutoffTime: "18:00"},
+		{Frequency: PayoutDaily, PayoutTime: "25:00", CutoffTime: "15:00"},
+		{Frequency: PayoutWeekly, PayoutTime: "17:00", CutoffTime: "15:00"},
+	}
+	for _, request := range invalid {
+		if _, err := service.Save(ctx, 1, request); !errors.Is(err, ErrInvalidPayoutSchedule) {
+			t.Errorf("Expected ErrInvalidPayoutSchedule for %+v, got: %v", request, err)
+		}
+	}
+
+	if _, err := service.Get(ctx, 1); !errors.Is(err, ErrPayoutScheduleNotFound) {
+		t.Errorf("Expected ErrPayoutScheduleNotFound, got: %v", err)
+	}
+	if _, err := service.Save(ctx, 1, models.PayoutScheduleRequest{Frequency: PayoutDaily, Weekday: "friday", PayoutTime: "17:00", CutoffTime: "15:00"}); err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+
+	if _, err := mockDB.CreateTransaction(models.Transaction{UserID: 1, Amount: 100, Currency: "USD", Type: consts.Deposit, Status: consts.Completed, GatewayID: 1}); err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	response, err := transactions.ProcessWithdrawal(ctx, models.TransactionRequest{UserID: 1, Amount: 40, Currency: "USD", Beneficiary: "Jane Doe"})
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	if response.Status != consts.Scheduled || response.ScheduledFor == nil {
+		t.Fatalf("Expected a scheduled withdrawal, got %+v", response)
+	}
+
+	// Nothing is due before the payout
+	service.now = func() time.Time { return response.ScheduledFor.Add(-time.Minute) }
+	if submitted, err := service.RunDue(ctx); err != nil || submitted != 0 {
+		t.Errorf("Expected no payouts before the scheduled time, got %d: %v", submitted, err)
+	}
+
+	service.now = func() time.Time { return response.ScheduledFor.Add(time.Minute) }
+	if submitted, err := service.RunDue(ctx); err != nil || submitted != 1 {
+		t.Fatalf("Expected one payout, got %d: %v", submitted, err)
+	}
+
+	tx, err := mockDB.GetTransactionByID(response.TransactionID)
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	if tx.Status == consts.Scheduled || tx.Status == consts.Failed || tx.GatewayIdempotencyKey == "" {
+		t.Errorf("Expected the withdrawal to be submitted to its gateway, got %+v", tx)
+	}
+	if tx.ExpectedSettlementDate == "" || tx.ExpectedSettlementDate <= response.ScheduledFor.Format(dateLayout) {
+		t.Errorf("Expected settlement after the payout day, got %q", tx.ExpectedSettlementDate)
+	}
+
+	// A bank holiday added for the payout day defers the payout to the next banking day
+	response, err = transactions.ProcessWithdrawal(ctx, models.TransactionRequest{UserID: 1, Amount: 15, Currency: "USD", Beneficiary: "Jane Doe"})
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	newYork, _ := time.LoadLocation("America/New_York")
+	payoutDay := response.ScheduledFor.In(newYork).Format(dateLayout)
+	if _, err := transactions.Calendar().AddHoliday(ctx, "US", models.BankHolidayRequest{Date: payoutDay, Name: "Closure"}); err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	service.now = func() time.Time { return response.ScheduledFor.Add(time.Minute) }
+	if submitted, err := service.RunDue(ctx); err != nil || submitted != 0 {
+		t.Errorf("Expected the payout to be deferred, got %d submitted: %v", submitted, err)
+	}
+	tx, err = mockDB.GetTransactionByID(response.TransactionID)
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	if tx.Status != consts.Scheduled || !tx.ScheduledFor.After(*response.ScheduledFor) || tx.ScheduledFor.In(newYork).Hour() != 17 {
+		t.Errorf("Expected the withdrawal rescheduled to 17:00 on a later day, got %s at %v", tx.Status, tx.ScheduledFor)
+	}
+
+	// Without a schedule withdrawals are paid out immediately
+	if err := service.Delete(ctx, 1); err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	response, err = transactions.ProcessWithdrawal(ctx, models.TransactionRequest{UserID: 1, Amount: 40, Currency: "USD", Beneficiary: "Jane Doe"})
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	if response.Status == consts.Scheduled || response.ScheduledFor != nil {
+		t.Errorf("Expected an immediate withdrawal, got %+v", response)
+	}
+}
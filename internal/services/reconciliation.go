@@ -0,0 +1,373 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/csv"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"payment-gateway/db"
+	"payment-gateway/internal/consts"
+	"payment-gateway/internal/events"
+	"payment-gateway/internal/models"
+	"payment-gateway/internal/money"
+	"payment-gateway/internal/warehouse"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	ErrInvalidReconciliation      = errors.New("invalid reconciliation request")
+	ErrInvalidMerchantFees        = errors.New("invalid merchant fees")
+	ErrReconciliationFileNotFound = errors.New("reconciliation file not found")
+)
+
+// ReconciliationColumns are the CSV columns of reconciliation files. Fees and net amounts are in
+// the transaction's currency; only completed transactions have them, and withdrawals' net amounts
+// are negative.
+var ReconciliationColumns = []string{
+	"transaction_id", "reference_id", "gateway_reference", "type", "status", "amount", "currency",
+	"fee", "net_amount", "gateway_id", "decline_code", "livemode", "created_at", "updated_at",
+}
+
+// ReconciliationService generates merchants' daily reconciliation files, listing every transaction
+// of their users created on a UTC day with its status, fee and net amount. Files are written to a
+// store, one directory per merchant, and announced to the merchant's webhook with a download link.
+// A file's ID is derived from its merchant and day, so regenerating it, for example after late
+// callbacks changed statuses or fees were corrected, replaces its content under the same ID.
+type ReconciliationService struct {
+	db           db.DBInterface
+	store        warehouse.Store
+	transactions *TransactionService
+	baseURL      string
+	now          func() time.Time
+}
+
+// NewReconciliationService creates a new reconciliation service writing files to store and rounding
+// fees like transactions' amounts
+func NewReconciliationService(dbInterface db.DBInterface, store warehouse.Store, transactions *TransactionService) *ReconciliationService {
+	return &ReconciliationService{db: dbInterface, store: store, transactions: transactions, now: time.Now}
+}
+
+// SetBaseURL sets the public URL of the API download links in webhooks and responses point to
+func (s *ReconciliationService) SetBaseURL(baseURL string) {
+	s.baseURL = strings.TrimRight(baseURL, "/")
+}
+
+// Fees returns a merchant's fees by currency
+func (s *ReconciliationService) Fees(ctx context.Context, merchantID int) ([]models.MerchantFee, error) {
+	if _, err := s.db.GetMerchantByID(merchantID); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrMerchantNotFound
+		}
+		return nil, err
+	}
+
+	fees, err := s.db.GetMerchantFees(merchantID)
+	if err != nil {
+		return nil, err
+	}
+	if fees == nil {
+		fees = []models.MerchantFee{}
+	}
+	return fees, nil
+}
+
+// ReplaceFees replaces a merchant's fees. They apply to reconciliation files generated afterwards,
+// including regenerated ones.
+func (s *ReconciliationService) ReplaceFees(ctx context.Context, merchantID int, fees []models.MerchantFee) ([]models.MerchantFee, error) {
+	now := s.now().UTC()
+	seen := make(map[string]bool)
+	replaced := make([]models.MerchantFee, 0, len(fees))
+	for _, fee := range fees {
+		fee.Currency = strings.ToUpper(fee.Currency)
+		if seen[fee.Currency] {
+			return nil, fmt.Errorf("%w: %s is listed more than once", ErrInvalidMerchantFees, fee.Currency)
+		}
+		seen[fee.Currency] = true
+		fee.UpdatedAt = now
+		replaced = append(replaced, fee)
+	}
+
+	if _, err := s.db.GetMerchantByID(merchantID); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrMerchantNotFound
+		}
+		return nil, err
+	}
+
+	if err := s.db.ReplaceMerchantFees(merchantID, replaced); err != nil {
+		return nil, err
+	}
+	return replaced, nil
+}
+
+// Generate writes a merchant's reconciliation file for a day that has ended, replacing and
+// bumping the version of any file generated for it before, and announces it to the merchant
+func (s *ReconciliationService) Generate(ctx context.Context, merchantID int, date string) (*models.ReconciliationFile, error) {
+	day, err := time.Parse(dateLayout, date)
+	if err != nil {
+		return nil, fmt.Errorf("%w: date %q is not YYYY-MM-DD", ErrInvalidReconciliation, date)
+	}
+	now := s.now().UTC()
+	if day.AddDate(0, 0, 1).After(now) {
+		return nil, fmt.Errorf("%w: %s has not ended yet", ErrInvalidReconciliation, date)
+	}
+
+	if _, err := s.db.GetMerchantByID(merchantID); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrMerchantNotFound
+		}
+		return nil, err
+	}
+
+	file := models.ReconciliationFile{ID: ReconciliationFileID(merchantID, date), MerchantID: merchantID, Date: date, Version: 1}
+	previous, err := s.db.GetReconciliationFile(merchantID, file.ID)
+	switch {
+	case err == nil:
+		file.Version = previous.Version + 1
+	case !errors.Is(err, sql.ErrNoRows):
+		return nil, err
+	}
+
+	transactions, err := s.db.GetMerchantTransactions(merchantID, day, day.AddDate(0, 0, 1))
+	if err != nil {
+		return nil, err
+	}
+	fees, err := s.db.GetMerchantFees(merchantID)
+	if err != nil {
+		return nil, err
+	}
+	data, err := s.encode(transactions, fees)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode reconciliation file: %w", err)
+	}
+
+	file.Rows = len(transactions)
+	file.Size = len(data)
+	checksum := sha256.Sum256(data)
+	file.Checksum = hex.EncodeToString(checksum[:])
+	file.StorageKey = fmt.Sprintf("reconciliation/merchant=%d/%s.csv", merchantID, file.ID)
+	file.GeneratedAt = now
+
+	if err := s.store.Put(ctx, file.StorageKey, data); err != nil {
+		return nil, fmt.Errorf("failed to write reconciliation file: %w", err)
+	}
+	if err := s.db.SaveReconciliationFile(file); err != nil {
+		return nil, err
+	}
+
+	s.withDownloadURL(&file)
+	s.announce(file)
+	log.Printf("Generated reconciliation file %s version %d with %d transactions", file.ID, file.Version, file.Rows)
+	return &file, nil
+}
+
+// Get returns one of a merchant's reconciliation files
+func (s *ReconciliationService) Get(ctx context.Context, merchantID int, fileID string) (*models.ReconciliationFile, error) {
+	file, err := s.db.GetReconciliationFile(merchantID, fileID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrReconciliationFileNotFound
+		}
+		return nil, err
+	}
+
+	s.withDownloadURL(file)
+	return file, nil
+}
+
+// Content returns one of a merchant's reconciliation files with its CSV content
+func (s *ReconciliationService) Content(ctx context.Context, merchantID int, fileID string) (*models.ReconciliationFile, []byte, error) {
+	file, err := s.Get(ctx, merchantID, fileID)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	data, err := s.store.Get(ctx, file.StorageKey)
+	if err != nil {
+		if errors.Is(err, warehouse.ErrNotFound) {
+			return nil, nil, fmt.Errorf("%w: %s is missing from the store; regenerate it", ErrReconciliationFileNotFound, file.ID)
+		}
+		return nil, nil, fmt.Errorf("failed to read reconciliation file: %w", err)
+	}
+	return file, data, nil
+}
+
+// List returns a merchant's reconciliation files for the days of a query, newest first, defaulting
+// to the consts.ReconciliationDefaultDays days up to today
+func (s *ReconciliationService) List(ctx context.Context, merchantID int, query models.ReconciliationQuery) ([]models.ReconciliationFile, error) {
+	now := s.now().UTC()
+	to := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+	if query.To != "" {
+		var err error
+		if to, err = time.Parse(dateLayout, query.To); err != nil {
+			return nil, fmt.Errorf("%w: to %q is not YYYY-MM-DD", ErrInvalidReconciliation, query.To)
+		}
+	}
+	from := to.AddDate(0, 0, 1-consts.ReconciliationDefaultDays)
+	if query.From != "" {
+		var err error
+		if from, err = time.Parse(dateLayout, query.From); err != nil {
+			return nil, fmt.Errorf("%w: from %q is not YYYY-MM-DD", ErrInvalidReconciliation, query.From)
+		}
+	}
+	if from.After(to) {
+		return nil, fmt.Errorf("%w: from must not be after to", ErrInvalidReconciliation)
+	}
+	if to.Sub(from) >= consts.MaxReconciliationDays*24*time.Hour {
+		return nil, fmt.Errorf("%w: at most %d days can be listed", ErrInvalidReconciliation, consts.MaxReconciliationDays)
+	}
+
+	files, err := s.db.ListReconciliationFiles(merchantID, from.Format(dateLayout), to.Format(dateLayout))
+	if err != nil {
+		return nil, err
+	}
+	if files == nil {
+		files = []models.ReconciliationFile{}
+	}
+	for i := range files {
+		s.withDownloadURL(&files[i])
+	}
+	return files, nil
+}
+
+// RunDue generates the files of the last day that ended at least consts.ReconciliationDelay ago
+// for every merchant without one yet, returning how many were generated
+func (s *ReconciliationService) RunDue(ctx context.Context) (int, error) {
+	cutoff := s.now().UTC().Add(-consts.ReconciliationDelay)
+	date := time.Date(cutoff.Year(), cutoff.Month(), cutoff.Day(), 0, 0, 0, 0, time.UTC).AddDate(0, 0, -1).Format(dateLayout)
+
+	merchantIDs, err := s.db.GetMerchantIDs()
+	if err != nil {
+		return 0, fmt.Errorf("failed to fetch merchants: %w", err)
+	}
+
+	generated := 0
+	for _, merchantID := range merchantIDs {
+		_, err := s.db.GetReconciliationFile(merchantID, ReconciliationFileID(merchantID, date))
+		if err == nil {
+			continue
+		}
+		if !errors.Is(err, sql.ErrNoRows) {
+			log.Printf("Failed to check reconciliation file of merchant %d for %s: %v", merchantID, date, err)
+			continue
+		}
+
+		if _, err := s.Generate(ctx, merchantID, date); err != nil {
+			log.Printf("Failed to generate reconciliation file of merchant %d for %s: %v", merchantID, date, err)
+			continue
+		}
+		generated++
+	}
+
+	return generated, nil
+}
+
+// ReconciliationFileID returns the stable ID of a merchant's reconciliation file for a day
+func ReconciliationFileID(merchantID int, date string) string {
+	return fmt.Sprintf("rec_%d_%s", merchantID, strings.ReplaceAll(date, "-", ""))
+}
+
+// encode writes transactions as CSV with a header line. Fees are a percentage of the amount plus a
+// fixed amount, rounded to the currency's minor unit like amounts.
+func (s *ReconciliationService) encode(transactions []models.Transaction, fees []models.MerchantFee) ([]byte, error) {
+	feesByCurrency := make(map[string]models.MerchantFee, len(fees))
+	for _, fee := range fees {
+		feesByCurrency[fee.Currency] = fee
+	}
+
+	var buf bytes.Buffer
+	w := csv.NewWriter(&buf)
+	if err := w.Write(ReconciliationColumns); err != nil {
+		return nil, err
+	}
+
+	for _, tx := range transactions {
+		fee, net := "", ""
+		if tx.Status == consts.Completed {
+			amount := money.MinorUnits(tx.Amount, tx.Currency)
+			var charged int64
+			if rule, ok := feesByCurrency[tx.Currency]; ok {
+				charged = s.transactions.rounder.MinorUnits(tx.Amount*rule.Percent/100+rule.Fixed, tx.Currency)
+			}
+			units := amount - charged
+			if tx.Type == consts.Withdrawal {
+				units = -amount - charged
+			}
+			fee, net = formatMinorUnits(charged, tx.Currency), formatMinorUnits(units, tx.Currency)
+		}
+
+		record := []string{
+			strconv.Itoa(tx.ID),
+			tx.ReferenceID,
+			tx.GatewayReference,
+			tx.Type,
+			tx.Status,
+			formatMinorUnits(money.MinorUnits(tx.Amount, tx.Currency), tx.Currency),
+			tx.Currency,
+			fee,
+			net,
+			strconv.Itoa(tx.GatewayID),
+			tx.DeclineCode,
+			strconv.FormatBool(tx.Livemode),
+			formatReportTime(tx.CreatedAt),
+			formatReportTime(tx.UpdatedAt),
+		}
+		if err := w.Write(record); err != nil {
+			return nil, err
+		}
+	}
+
+	w.Flush()
+	return buf.Bytes(), w.Error()
+}
+
+// withDownloadURL sets the link a file's content is downloaded from
+func (s *ReconciliationService) withDownloadURL(file *models.ReconciliationFile) {
+	file.DownloadURL = s.baseURL + consts.MerchantReconciliationRoute + "/" + file.ID + "/download"
+}
+
+// announce records the merchant webhook announcing a generated file. Each version is announced
+// once, however many instances generate it.
+func (s *ReconciliationService) announce(file models.ReconciliationFile) {
+	evt := events.ReconciliationEvent{Type: events.ReconciliationFileReady, File: file, OccurredAt: file.GeneratedAt}
+	payload, err := json.Marshal(evt)
+	if err != nil {
+		log.Printf("Failed to marshal %s event for reconciliation file %s: %v", evt.Type, file.ID, err)
+		return
+	}
+
+	msg := models.OutboxMessage{
+		Destination: consts.OutboxMerchantWebhook,
+		EventType:   evt.Type,
+		MerchantID:  file.MerchantID,
+		DedupToken:  OutboxDedupToken(evt.Type, file.MerchantID, file.ID+"|"+strconv.Itoa(file.Version)),
+		ContentType: "application/json",
+		Payload:     payload,
+	}
+	messages := append([]models.OutboxMessage{msg}, endpointMessages(s.db, msg)...)
+	if err := s.db.CreateOutboxMessages(messages); err != nil {
+		log.Printf("Failed to record %s outbox message for reconciliation file %s: %v", msg.Destination, file.ID, err)
+	}
+}
+
+// formatMinorUnits formats minor units of a currency as a decimal amount with the currency's
+// number of fraction digits
+func formatMinorUnits(units int64, currency string) string {
+	return strconv.FormatFloat(money.FromMinorUnits(units, currency), 'f', money.Exponent(currency), 64)
+}
+
+// formatReportTime formats a time as RFC 3339 in UTC, leaving zero times empty
+func formatReportTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.UTC().Format(time.RFC3339)
+}
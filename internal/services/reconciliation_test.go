@@ -0,0 +1,159 @@
+package services
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"payment-gateway/db"
+	"payment-gateway/internal/consts"
+	"payment-gateway/internal/events"
+	"payment-gateway/internal/models"
+	"payment-gateway/internal/warehouse"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestReconciliationFile tests that daily files list the day's transactions with fees and net
+// amounts, keep their ID when regenerated and are announced to the merchant
+func TestReconciliationFile(t *testing.T) {
+	mockDB := db.NewMockDB()
+	store, err := warehouse.NewDirStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("Failed to create store: %v", err)
+	}
+	service := NewReconciliationService(mockDB, store, NewTransactionService(mockDB, newRulesSelector(mockDB)))
+	service.SetBaseURL("https://api.example.com/")
+	service.now = func() time.Time { return time.Date(2026, 3, 11, 9, 0, 0, 0, time.UTC) }
+	ctx := context.Background()
+
+	userID, err := mockDB.CreateUser(models.User{Username: "alice", Email: "alice@example.com", CountryID: 1, MerchantID: 1})
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	day := time.Date(2026, 3, 10, 12, 0, 0, 0, time.UTC)
+	for _, tx := range []models.Transaction{
+		{UserID: userID, Type: consts.Deposit, Status: consts.Completed, Amount: 100, Currency: "USD", CreatedAt: day},
+		{UserID: userID, Type: consts.Withdrawal, Status: consts.Completed, Amount: 40, Currency: "USD", CreatedAt: day},
+		{UserID: userID, Type: consts.Deposit, Status: consts.Failed, Amount: 25, Currency: "USD", CreatedAt: day},
+		{UserID: userID, Type: consts.Deposit, Status: consts.Completed, Amount: 1000, Currency: "JPY", CreatedAt: day},
+		{UserID: userID, Type: consts.Deposit, Status: consts.Completed, Amount: 10, Currency: "USD", CreatedAt: day.AddDate(0, 0, -1)},
+	} {
+		if _, err := mockDB.CreateTransaction(tx); err != nil {
+			t.Fatalf("Expected no error, got: %v", err)
+		}
+	}
+
+	if _, err := service.ReplaceFees(ctx, 1, []models.MerchantFee{{Currency: "usd", Percent: 2.9, Fixed: 0.3}, {Currency: "USD"}}); !errors.Is(err, ErrInvalidMerchantFees) {
+		t.Errorf("Expected ErrInvalidMerchantFees for a currency listed twice, got: %v", err)
+	}
+	if _, err := service.ReplaceFees(ctx, 99, nil); !errors.Is(err, ErrMerchantNotFound) {
+		t.Errorf("Expected ErrMerchantNotFound, got: %v", err)
+	}
+	if _, err := service.ReplaceFees(ctx, 1, []models.MerchantFee{{Currency: "usd", Percent: 2.9, Fixed: 0.3}}); err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+
+	file, err := service.Generate(ctx, 1, "2026-03-10")
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	if file.ID != "rec_1_20260310" || file.Version != 1 || file.Rows != 4 || file.Checksum == "" {
+		t.Errorf("Expected the first version of rec_1_20260310 with 4 rows, got %+v", file)
+	}
+	if file.DownloadURL != "https://api.example.com/merchant/reports/reconciliation/rec_1_20260310/download" {
+		t.Errorf("Expected a download URL, got %q", file.DownloadURL)
+	}
+
+	_, data, err := service.Content(ctx, 1, file.ID)
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	records, err := csv.NewReader(strings.NewReader(string(data))).ReadAll()
+	if err != nil || len(records) != 5 {
+		t.Fatalf("Expected a header and 4 rows, got %v (%v)", records, err)
+	}
+	// 2.9% of 100.00 plus 0.30; withdrawals' fees add to what leaves the merchant's balance
+	want := [][2]string{{"3.20", "96.80"}, {"1.46", "-41.46"}, {"", ""}, {"0", "1000"}}
+	for i, w := range want {
+		if fee, net := records[i+1][7], records[i+1][8]; fee != w[0] || net != w[1] {
+			t.Errorf("Row %d: expected fee %q and net amount %q, got %q and %q", i+1, w[0], w[1], fee, net)
+		}
+	}
+
+	regenerated, err := service.Generate(ctx, 1, "2026-03-10")
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	if regenerated.ID != file.ID || regenerated.Version != 2 {
+		t.Errorf("Expected version 2 of %s, got %+v", file.ID, regenerated)
+	}
+
+	messages, _ := mockDB.ListOutboxMessages(consts.OutboxMerchantWebhook, consts.OutboxPending, 10)
+	if len(messages) != 2 {
+		t.Fatalf("Expected both versions announced, got %d messages", len(messages))
+	}
+	var evt events.ReconciliationEvent
+	if err := json.Unmarshal(messages[1].Payload, &evt); err != nil || evt.Type != events.ReconciliationFileReady || evt.File.Version != 2 || evt.File.DownloadURL == "" {
+		t.Errorf("Expected a %s event for version 2, got %+v (%v)", events.ReconciliationFileReady, evt, err)
+	}
+
+	files, err := service.List(ctx, 1, models.ReconciliationQuery{})
+	if err != nil || len(files) != 1 || files[0].ID != file.ID {
+		t.Errorf("Expected the file listed, got %+v (%v)", files, err)
+	}
+	if _, err := service.Get(ctx, 2, file.ID); !errors.Is(err, ErrReconciliationFileNotFound) {
+		t.Errorf("Expected ErrReconciliationFileNotFound for another merchant, got: %v", err)
+	}
+
+	invalid := []struct {
+		name string
+		err  error
+	}{
+		{"today", func() error { _, err := service.Generate(ctx, 1, "2026-03-11"); return err }()},
+		{"malformed date", func() error { _, err := service.Generate(ctx, 1, "10/03/2026"); return err }()},
+		{"reversed range", func() error {
+			_, err := service.List(ctx, 1, models.ReconciliationQuery{From: "2026-03-10", To: "2026-03-01"})
+			return err
+		}()},
+		{"range too long", func() error {
+			_, err := service.List(ctx, 1, models.ReconciliationQuery{From: "2024-01-01", To: "2026-03-01"})
+			return err
+		}()},
+	}
+	for _, tt := range invalid {
+		if !errors.Is(tt.err, ErrInvalidReconciliation) {
+			t.Errorf("%s: expected ErrInvalidReconciliation, got: %v", tt.name, tt.err)
+		}
+	}
+}
+
+// TestReconciliationRunDue tests that scheduled runs generate the previous day's files once it has
+// been over for the delay, and only once
+func TestReconciliationRunDue(t *testing.T) {
+	mockDB := db.NewMockDB()
+	store, err := warehouse.NewDirStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("Failed to create store: %v", err)
+	}
+	service := NewReconciliationService(mockDB, store, NewTransactionService(mockDB, newRulesSelector(mockDB)))
+	ctx := context.Background()
+
+	// Less than two hours past midnight, the day before yesterday is still the latest due
+	service.now = func() time.Time { return time.Date(2026, 3, 11, 1, 0, 0, 0, time.UTC) }
+	if generated, err := service.RunDue(ctx); err != nil || generated != 1 {
+		t.Fatalf("Expected 1 file generated, got %d (%v)", generated, err)
+	}
+	if _, err := service.Get(ctx, 1, "rec_1_20260309"); err != nil {
+		t.Errorf("Expected the file of 9 March, got: %v", err)
+	}
+
+	service.now = func() time.Time { return time.Date(2026, 3, 11, 2, 0, 0, 0, time.UTC) }
+	if generated, err := service.RunDue(ctx); err != nil || generated != 1 {
+		t.Fatalf("Expected 1 file generated, got %d (%v)", generated, err)
+	}
+	if generated, err := service.RunDue(ctx); err != nil || generated != 0 {
+		t.Errorf("Expected no file generated again, got %d (%v)", generated, err)
+	}
+}
@@ -0,0 +1,133 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"payment-gateway/db"
+	"payment-gateway/internal/consts"
+	"payment-gateway/internal/models"
+	"sort"
+	"sync"
+	"time"
+)
+
+var ErrUnknownDeclineCode = errors.New("unknown decline code")
+
+// defaultRecoveryHints is what customers are told to do after each normalized decline code until
+// the mapping table says otherwise. Soft declines may succeed if tried again; hard declines need
+// another card or account, or the issuer's help.
+var defaultRecoveryHints = map[string]string{
+	consts.DeclineInsufficientFunds: consts.RecoveryUseOtherMethod,
+	consts.DeclineDoNotHonor:        consts.RecoveryContactBank,
+	consts.DeclineExpiredCard:       consts.RecoveryUseOtherMethod,
+	consts.DeclineFraudSuspected:    consts.RecoveryDoNotRetry,
+	consts.DeclineInvalidAccount:    consts.RecoveryUseOtherMethod,
+	consts.DeclineLimitExceeded:     consts.RecoveryContactBank,
+	consts.DeclineIssuerUnavailable: consts.RecoveryTryAgain,
+	consts.DeclineTimeout:           consts.RecoveryTryAgain,
+	consts.DeclineProcessingError:   consts.RecoveryTryAgain,
+	consts.DeclineConsentRejected:   consts.RecoveryUseOtherMethod,
+	consts.DeclineConsentExpired:    consts.RecoveryTryAgain,
+	consts.DeclineMandateInvalid:    consts.RecoveryUseOtherMethod,
+	consts.DeclineDebitRefunded:     consts.RecoveryDoNotRetry,
+	consts.DeclineUnknown:           consts.RecoveryUseOtherMethod,
+}
+
+// RecoveryHints maps normalized decline codes to the machine-readable recovery_hint returned with
+// declined transactions, so merchants can tell customers what to do next. The mapping is stored in
+// the database and cached; every instance picks up changes on its next refresh.
+type RecoveryHints struct {
+	db db.DBInterface
+
+	mu    sync.RWMutex
+	hints map[string]string // overrides of defaultRecoveryHints as of the last refresh
+	now   func() time.Time
+}
+
+// NewRecoveryHints creates a recovery hint mapping using the defaults until it is refreshed
+func NewRecoveryHints(dbInterface db.DBInterface) *RecoveryHints {
+	return &RecoveryHints{db: dbInterface, hints: map[string]string{}, now: time.Now}
+}
+
+// Hint returns the recovery hint of a decline code, or "" when the transaction was not declined
+func (h *RecoveryHints) Hint(declineCode string) string {
+	if declineCode == "" {
+		return ""
+	}
+
+	h.mu.RLock()
+	hint, ok := h.hints[declineCode]
+	h.mu.RUnlock()
+	if ok {
+		return hint
+	}
+
+	if hint, ok := defaultRecoveryHints[declineCode]; ok {
+		return hint
+	}
+	return defaultRecoveryHints[consts.DeclineUnknown]
+}
+
+// List returns the recovery hint of every normalized decline code
+func (h *RecoveryHints) List(ctx context.Context) ([]models.DeclineRecoveryHint, error) {
+	configured, err := h.db.GetDeclineRecoveryHints()
+	if err != nil {
+		return nil, err
+	}
+
+	hints := make(map[string]models.DeclineRecoveryHint, len(defaultRecoveryHints))
+	for code, hint := range defaultRecoveryHints {
+		hints[code] = models.DeclineRecoveryHint{DeclineCode: code, RecoveryHint: hint}
+	}
+	for _, hint := range configured {
+		if _, ok := defaultRecoveryHints[hint.DeclineCode]; ok {
+			hints[hint.DeclineCode] = hint
+		}
+	}
+
+	list := make([]models.DeclineRecoveryHint, 0, len(hints))
+	for _, hint := range hints {
+		list = append(list, hint)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].DeclineCode < list[j].DeclineCode })
+	return list, nil
+}
+
+// Set changes the recovery hint of a decline code and applies it on this instance immediately
+func (h *RecoveryHints) Set(ctx context.Context, declineCode string, req models.DeclineRecoveryHintRequest) (*models.DeclineRecoveryHint, error) {
+	if _, ok := defaultRecoveryHints[declineCode]; !ok {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownDeclineCode, declineCode)
+	}
+
+	hint := models.DeclineRecoveryHint{DeclineCode: declineCode, RecoveryHint: req.RecoveryHint, UpdatedAt: h.now()}
+	if err := h.db.SaveDeclineRecoveryHint(hint); err != nil {
+		return nil, err
+	}
+
+	log.Printf("Set recovery hint of decline code %s to %s", declineCode, req.RecoveryHint)
+	if err := h.Refresh(ctx); err != nil {
+		log.Printf("Failed to refresh decline recovery hints: %v", err)
+	}
+
+	return &hint, nil
+}
+
+// Refresh reloads the recovery hint mapping from the database
+func (h *RecoveryHints) Refresh(ctx context.Context) error {
+	configured, err := h.db.GetDeclineRecoveryHints()
+	if err != nil {
+		return fmt.Errorf("failed to fetch decline recovery hints: %w", err)
+	}
+
+	hints := make(map[string]string, len(configured))
+	for _, hint := range configured {
+		hints[hint.DeclineCode] = hint.RecoveryHint
+	}
+
+	h.mu.Lock()
+	h.hints = hints
+	h.mu.Unlock()
+	return nil
+}
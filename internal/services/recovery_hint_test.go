@@ -0,0 +1,64 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"payment-gateway/db"
+	"payment-gateway/internal/consts"
+	"payment-gateway/internal/events"
+	"payment-gateway/internal/models"
+	"testing"
+)
+
+// TestRecoveryHints tests that decline codes map to their default hints until the mapping table overrides them
+func TestRecoveryHints(t *testing.T) {
+	mockDB := db.NewMockDB()
+	service := NewTransactionService(mockDB, newRulesSelector(mockDB))
+	hints := service.RecoveryHints()
+	ctx := context.Background()
+
+	tests := map[string]string{
+		consts.DeclineInsufficientFunds: consts.RecoveryUseOtherMethod,
+		consts.DeclineDoNotHonor:        consts.RecoveryContactBank,
+		consts.DeclineFraudSuspected:    consts.RecoveryDoNotRetry,
+		consts.DeclineTimeout:           consts.RecoveryTryAgain,
+		"unmapped_code":                 consts.RecoveryUseOtherMethod,
+		"":                              "",
+	}
+	for code, want := range tests {
+		if got := hints.Hint(code); got != want {
+			t.Errorf("Expected hint %q for decline code %q, got %q", want, code, got)
+		}
+	}
+
+	if _, err := hints.Set(ctx, "unmapped_code", models.DeclineRecoveryHintRequest{RecoveryHint: consts.RecoveryTryAgain}); !errors.Is(err, ErrUnknownDeclineCode) {
+		t.Errorf("Expected ErrUnknownDeclineCode, got: %v", err)
+	}
+	if _, err := hints.Set(ctx, consts.DeclineDoNotHonor, models.DeclineRecoveryHintRequest{RecoveryHint: consts.RecoveryTryAgain}); err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	if got := hints.Hint(consts.DeclineDoNotHonor); got != consts.RecoveryTryAgain {
+		t.Errorf("Expected the configured hint to apply immediately, got %q", got)
+	}
+
+	list, err := hints.List(ctx)
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	if len(list) != len(defaultRecoveryHints) {
+		t.Errorf("Expected every normalized decline code to be listed, got %+v", list)
+	}
+	for _, hint := range list {
+		if hint.DeclineCode == consts.DeclineDoNotHonor && (hint.RecoveryHint != consts.RecoveryTryAgain || hint.UpdatedAt.IsZero()) {
+			t.Errorf("Expected the configured hint to be listed, got %+v", hint)
+		}
+	}
+
+	// Published events carry the hint of their decline code
+	published, cancel := service.Events().Subscribe(events.Filter{}, 1)
+	defer cancel()
+	service.publishStatus(models.Transaction{ID: 1, UserID: 1, DeclineCode: consts.DeclineExpiredCard}, consts.Failed, "declined")
+	if evt := <-published; evt.Transaction.RecoveryHint != consts.RecoveryUseOtherMethod {
+		t.Errorf("Expected the event to carry the recovery hint, got %+v", evt.Transaction)
+	}
+}
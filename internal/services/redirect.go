@@ -0,0 +1,74 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"payment-gateway/internal/models"
+	"strings"
+)
+
+var (
+	ErrInvalidRedirectURL = errors.New("invalid redirect URL")
+)
+
+// validateRedirectURLs checks the request's return and cancel URLs against the allowlist
+// of domains configured for the user's merchant
+func (s *TransactionService) validateRedirectURLs(user *models.User, req models.TransactionRequest) error {
+	if req.ReturnURL == "" && req.CancelURL == "" {
+		return nil
+	}
+
+	if user.MerchantID == 0 {
+		return fmt.Errorf("%w: no redirect domains are configured for this account", ErrInvalidRedirectURL)
+	}
+
+	merchant, err := s.db.GetMerchantByID(user.MerchantID)
+	if err != nil {
+		return fmt.Errorf("failed to get merchant: %w", err)
+	}
+
+	for name, raw := range map[string]string{"return_url": req.ReturnURL, "cancel_url": req.CancelURL} {
+		if raw == "" {
+			continue
+		}
+		if err := validateRedirectURL(raw, merchant.AllowedRedirectDomains); err != nil {
+			return fmt.Errorf("%w: %s %v", ErrInvalidRedirectURL, name, err)
+		}
+	}
+
+	return nil
+}
+
+// validateRedirectURL checks that a URL is absolute, uses HTTPS (HTTP is allowed only for
+// localhost) and points at one of the allowed domains or their subdomains
+func validateRedirectURL(raw string, allowedDomains []string) error {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return fmt.Errorf("is malformed")
+	}
+
+	host := strings.ToLower(u.Hostname())
+	if host == "" || u.User != nil {
+		return fmt.Errorf("must be an absolute URL without credentials")
+	}
+
+	switch u.Scheme {
+	case "https":
+	case "http":
+		if host != "localhost" {
+			return fmt.Errorf("must use https")
+		}
+	default:
+		return fmt.Errorf("must use https")
+	}
+
+	for _, domain := range allowedDomains {
+		domain = strings.ToLower(strings.TrimSpace(domain))
+		if domain != "" && (host == domain || strings.HasSuffix(host, "."+domain)) {
+			return nil
+		}
+	}
+
+	return fmt.Errorf("domain %s is not in the merchant allowlist", host)
+}
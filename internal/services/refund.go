@@ -0,0 +1,216 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"payment-gateway/db"
+	"payment-gateway/internal/consts"
+	"payment-gateway/internal/gateway"
+	"payment-gateway/internal/models"
+	"payment-gateway/internal/money"
+	"payment-gateway/internal/utils"
+	"strconv"
+	"time"
+)
+
+var (
+	ErrRefundNotFound       = errors.New("refund not found")
+	ErrRefundNotAllowed     = errors.New("only completed deposits can be refunded")
+	ErrRefundExceedsAmount  = errors.New("refund exceeds the deposit's refundable amount")
+	ErrRefundExceedsBalance = errors.New("refund exceeds the user's wallet balance")
+	ErrRefundUnsupported    = errors.New("the deposit's gateway does not support refunds")
+)
+
+// ProcessRefund refunds a completed deposit through the gateway that took it, in full or in part.
+// Refunds that haven't failed count against the deposit, so partial refunds are accepted until they
+// add up to its amount; a refund without an amount refunds what remains. The refund takes its
+// amount back out of the user's wallet, so funds already transferred or withdrawn can't be
+// refunded. Gateways that accept a refund asynchronously leave it processing. Another merchant's
+// deposit is reported as not found.
+func (s *TransactionService) ProcessRefund(ctx context.Context, merchantID int, req models.RefundRequest) (*models.Refund, error) {
+	tx, err := s.merchantTransaction(req.TransactionID, merchantID)
+	if err != nil {
+		return nil, err
+	}
+	if tx.Type != consts.Deposit || tx.Status != consts.Completed {
+		return nil, ErrRefundNotAllowed
+	}
+
+	remaining, err := s.refundableAmount(tx)
+	if err != nil {
+		return nil, err
+	}
+	amount := remaining
+	if req.Amount > 0 {
+		if amount, err = s.roundedAmount(req.Amount, tx.Currency); err != nil {
+			return nil, err
+		}
+	}
+	if amount <= 0 || s.rounder.MinorUnits(amount, tx.Currency) > s.rounder.MinorUnits(remaining, tx.Currency) {
+		return nil, fmt.Errorf("%w: %v %s remains refundable", ErrRefundExceedsAmount, remaining, tx.Currency)
+	}
+
+	refund := models.Refund{
+		TransactionID: tx.ID,
+		Amount:        amount,
+		Currency:      tx.Currency,
+		Status:        consts.Pending,
+		Reason:        req.Reason,
+		CreatedAt:     time.Now(),
+	}
+	refund.UpdatedAt = refund.CreatedAt
+
+	// The store checks the amount again while holding the deposit, as refunds may race, and checks
+	// the wallet while holding the user, as transfers may
+	id, err := s.db.CreateRefund(refund)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrRefundExceedsAmount
+		}
+		if errors.Is(err, db.ErrInsufficientBalance) {
+			return nil, ErrRefundExceedsBalance
+		}
+		return nil, fmt.Errorf("failed to create refund: %w", err)
+	}
+	refund.ID = id
+	refund.GatewayIdempotencyKey = utils.GatewayIdempotencyKey(strconv.Itoa(tx.GatewayID), "refund", refund.ID, refund.Amount, refund.Currency)
+
+	if err := s.submitRefund(ctx, &refund, *tx); err != nil {
+		return nil, err
+	}
+
+	if refund.RefundableAmount, err = s.refundableAmount(tx); err != nil {
+		return nil, err
+	}
+	return &refund, nil
+}
+
+// submitRefund sends a recorded refund to the deposit's gateway and records the outcome. Declined
+// refunds are recorded as failed rather than returned as an error, so their amount is refundable
+// again.
+func (s *TransactionService) submitRefund(ctx context.Context, refund *models.Refund, tx models.Transaction) error {
+	provider, err := s.gatewaySelector.GetProviderByID(strconv.Itoa(tx.GatewayID))
+	if err != nil {
+		s.failRefund(refund, "", err.Error())
+		return fmt.Errorf("failed to get provider: %w", err)
+	}
+
+	// Partial captures are refunded against the authorization they were taken from
+	if tx.GatewayReference == "" && tx.CaptureOfID > 0 {
+		if authorization, err := s.db.GetTransactionByID(tx.CaptureOfID); err == nil {
+			tx.GatewayReference = authorization.GatewayReference
+		}
+	}
+
+	var response *models.TransactionResponse
+	var decline *gateway.DeclineError
+	var unsupported error
+
+	operation := func() error {
+		var processingErr error
+		start := time.Now()
+		response, processingErr = provider.ProcessRefund(ctx, *refund, tx)
+		if s.gatewayObserver != nil {
+			s.gatewayObserver.ObserveGatewayCall(provider.ID(), time.Since(start), processingErr != nil)
+		}
+		if processingErr != nil {
+			// Neither a decline nor a gateway without refunds is a gateway fault, so they must not trip the breaker
+			if errors.As(processingErr, &decline) {
+				return nil
+			}
+			if errors.Is(processingErr, gateway.ErrRefundUnsupported) {
+				unsupported = processingErr
+				return nil
+			}
+			return fmt.Errorf("gateway processing failed: %w", processingErr)
+		}
+		return nil
+	}
+
+	if err := s.circuitBreaker.ExecuteWithCircuitBreaker(provider.ID(), operation); err != nil {
+		s.failRefund(refund, "", err.Error())
+		return err
+	}
+
+	switch {
+	case unsupported != nil:
+		s.failRefund(refund, "", unsupported.Error())
+		return fmt.Errorf("%w: %v", ErrRefundUnsupported, unsupported)
+	case decline != nil:
+		s.failRefund(refund, decline.Code, decline.Message)
+		log.Printf("Refund %d of transaction %d declined: %s", refund.ID, tx.ID, decline.Code)
+		return nil
+	}
+
+	refund.Status = consts.Processing
+	if response != nil {
+		if response.Status == consts.Completed {
+			refund.Status = consts.Completed
+		}
+		refund.GatewayReference = response.GatewayReference
+	}
+	refund.UpdatedAt = time.Now()
+	if err := s.db.UpdateRefund(*refund); err != nil {
+		return fmt.Errorf("failed to update refund: %w", err)
+	}
+
+	log.Printf("Refund %d of %v %s for transaction %d is %s", refund.ID, refund.Amount, refund.Currency, tx.ID, refund.Status)
+	return nil
+}
+
+// failRefund records a refund as failed, releasing its amount
+func (s *TransactionService) failRefund(refund *models.Refund, declineCode, message string) {
+	refund.Status = consts.Failed
+	refund.DeclineCode = declineCode
+	refund.ErrorMessage = message
+	refund.UpdatedAt = time.Now()
+	if err := s.db.UpdateRefund(*refund); err != nil {
+		log.Printf("Failed to record refund %d as failed: %v", refund.ID, err)
+	}
+}
+
+// GetRefund returns a refund with the amount of its deposit that remains refundable. Another
+// merchant's refund is reported as not found.
+func (s *TransactionService) GetRefund(ctx context.Context, merchantID, refundID int) (*models.Refund, error) {
+	refund, err := s.db.GetRefund(refundID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrRefundNotFound
+		}
+		return nil, err
+	}
+
+	tx, err := s.merchantTransaction(refund.TransactionID, merchantID)
+	if err != nil {
+		if errors.Is(err, ErrTransactionNotFound) {
+			return nil, ErrRefundNotFound
+		}
+		return nil, err
+	}
+	if refund.RefundableAmount, err = s.refundableAmount(tx); err != nil {
+		return nil, err
+	}
+	return refund, nil
+}
+
+// refundableAmount returns what remains of a deposit after its refunds that haven't failed
+func (s *TransactionService) refundableAmount(tx *models.Transaction) (float64, error) {
+	refunds, err := s.db.GetTransactionRefunds(tx.ID)
+	if err != nil {
+		return 0, fmt.Errorf("failed to get refunds: %w", err)
+	}
+
+	remaining := s.rounder.MinorUnits(tx.Amount, tx.Currency)
+	for _, refund := range refunds {
+		if refund.Status != consts.Failed {
+			remaining -= s.rounder.MinorUnits(refund.Amount, tx.Currency)
+		}
+	}
+	if remaining < 0 {
+		remaining = 0
+	}
+	return money.FromMinorUnits(remaining, tx.Currency), nil
+}
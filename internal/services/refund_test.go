@@ -0,0 +1,181 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"payment-gateway/db"
+	"payment-gateway/internal/consts"
+	"payment-gateway/internal/gateway"
+	"payment-gateway/internal/models"
+	"testing"
+)
+
+// TestRefund tests that deposits are refunded in part up to their remaining amount, that declined
+// refunds release their amount and that refunds without an amount refund what remains
+func TestRefund(t *testing.T) {
+	mockDB := db.NewMockDB()
+	var keys []string
+	provider := &mockProvider{id: "1", name: "TestGateway", dataFormat: "application/json"}
+	service := NewTransactionService(mockDB, &mockGatewaySelector{
+		getProviderFunc: func(id string) (gateway.Provider, error) {
+			if id != provider.id {
+				return nil, fmt.Errorf("provider with ID %s not found", id)
+			}
+			return provider, nil
+		},
+	})
+	ctx := context.Background()
+
+	depositID, err := mockDB.CreateTransaction(models.Transaction{UserID: 1, Amount: 100, Currency: "USD", Type: consts.Deposit, Status: consts.Completed, GatewayID: 1})
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+
+	provider.processRefundFunc = func(ctx context.Context, refund models.Refund, tx models.Transaction) (*models.TransactionResponse, error) {
+		keys = append(keys, refund.GatewayIdempotencyKey)
+		return &models.TransactionResponse{Status: consts.Completed, TransactionID: tx.ID, GatewayReference: "re_1"}, nil
+	}
+	refund, err := service.ProcessRefund(ctx, 1, models.RefundRequest{TransactionID: depositID, Amount: 30.004, Reason: "Damaged item"})
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	if refund.Status != consts.Completed || refund.Amount != 30 || refund.RefundableAmount != 70 || refund.GatewayReference != "re_1" {
+		t.Errorf("Expected a completed refund of 30 leaving 70, got %+v", refund)
+	}
+	if len(keys) != 1 || keys[0] == "" {
+		t.Errorf("Expected the refund sent with an idempotency key, got %v", keys)
+	}
+
+	if _, err := service.ProcessRefund(ctx, 1, models.RefundRequest{TransactionID: depositID, Amount: 70.01}); !errors.Is(err, ErrRefundExceedsAmount) {
+		t.Errorf("Expected ErrRefundExceedsAmount, got: %v", err)
+	}
+
+	provider.processRefundFunc = func(ctx context.Context, refund models.Refund, tx models.Transaction) (*models.TransactionResponse, error) {
+		return nil, &gateway.DeclineError{Code: consts.DeclineInsufficientFunds, Message: "Balance too low"}
+	}
+	declined, err := service.ProcessRefund(ctx, 1, models.RefundRequest{TransactionID: depositID, Amount: 50})
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	if declined.Status != consts.Failed || declined.DeclineCode != consts.DeclineInsufficientFunds || declined.RefundableAmount != 70 {
+		t.Errorf("Expected a failed refund leaving 70 refundable, got %+v", declined)
+	}
+
+	provider.processRefundFunc = nil
+	full, err := service.ProcessRefund(ctx, 1, models.RefundRequest{TransactionID: depositID})
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	if full.Amount != 70 || full.RefundableAmount != 0 {
+		t.Errorf("Expected the remaining 70 refunded, got %+v", full)
+	}
+	if _, err := service.ProcessRefund(ctx, 1, models.RefundRequest{TransactionID: depositID}); !errors.Is(err, ErrRefundExceedsAmount) {
+		t.Errorf("Expected ErrRefundExceedsAmount once fully refunded, got: %v", err)
+	}
+
+	stored, err := service.GetRefund(ctx, 1, declined.ID)
+	if err != nil || stored.Status != consts.Failed || stored.TransactionID != depositID || stored.RefundableAmount != 0 {
+		t.Errorf("Expected the declined refund stored, got %+v (%v)", stored, err)
+	}
+	if refunds, _ := mockDB.GetTransactionRefunds(depositID); len(refunds) != 3 {
+		t.Errorf("Expected 3 refunds of the deposit, got %d", len(refunds))
+	}
+	if _, err := service.GetRefund(ctx, 1, 99); !errors.Is(err, ErrRefundNotFound) {
+		t.Errorf("Expected ErrRefundNotFound, got: %v", err)
+	}
+
+	// Another merchant can neither refund the deposit nor read its refunds
+	if _, err := service.ProcessRefund(ctx, 2, models.RefundRequest{TransactionID: depositID}); !errors.Is(err, ErrTransactionNotFound) {
+		t.Errorf("Expected ErrTransactionNotFound refunding another merchant's deposit, got: %v", err)
+	}
+	if _, err := service.GetRefund(ctx, 2, declined.ID); !errors.Is(err, ErrRefundNotFound) {
+		t.Errorf("Expected ErrRefundNotFound reading another merchant's refund, got: %v", err)
+	}
+}
+
+// TestRefundRejected tests that only completed deposits are refunded, and that refunds gateways
+// can't take fail
+func TestRefundRejected(t *testing.T) {
+	mockDB := db.NewMockDB()
+	provider := &mockProvider{id: "1", name: "TestGateway", dataFormat: "application/json"}
+	service := NewTransactionService(mockDB, &mockGatewaySelector{
+		getProviderFunc: func(id string) (gateway.Provider, error) { return provider, nil },
+	})
+	ctx := context.Background()
+
+	var ids []int
+	for _, tx := range []models.Transaction{
+		{UserID: 1, Amount: 100, Currency: "USD", Type: consts.Deposit, Status: consts.Processing, GatewayID: 1},
+		{UserID: 2, Amount: 100, Currency: "USD", Type: consts.Withdrawal, Status: consts.Completed, GatewayID: 1},
+		{UserID: 1, Amount: 100, Currency: "USD", Type: consts.Deposit, Status: consts.Completed, GatewayID: 1},
+	} {
+		id, err := mockDB.CreateTransaction(tx)
+		if err != nil {
+			t.Fatalf("Expected no error, got: %v", err)
+		}
+		ids = append(ids, id)
+	}
+
+	if _, err := service.ProcessRefund(ctx, 1, models.RefundRequest{TransactionID: 99}); !errors.Is(err, ErrTransactionNotFound) {
+		t.Errorf("Expected ErrTransactionNotFound, got: %v", err)
+	}
+	for _, id := range ids[:2] {
+		if _, err := service.ProcessRefund(ctx, 1, models.RefundRequest{TransactionID: id}); !errors.Is(err, ErrRefundNotAllowed) {
+			t.Errorf("Transaction %d: expected ErrRefundNotAllowed, got: %v", id, err)
+		}
+	}
+
+	provider.processRefundFunc = func(ctx context.Context, refund models.Refund, tx models.Transaction) (*models.TransactionResponse, error) {
+		return nil, fmt.Errorf("%s: %w", provider.name, gateway.ErrRefundUnsupported)
+	}
+	if _, err := service.ProcessRefund(ctx, 1, models.RefundRequest{TransactionID: ids[2], Amount: 10}); !errors.Is(err, ErrRefundUnsupported) {
+		t.Errorf("Expected ErrRefundUnsupported, got: %v", err)
+	}
+	if refunds, _ := mockDB.GetTransactionRefunds(ids[2]); len(refunds) != 1 || refunds[0].Status != consts.Failed {
+		t.Errorf("Expected the refund recorded as failed, got %+v", refunds)
+	}
+}
+
+// TestRefundTakesFromWallet tests that a refund takes its amount out of the wallet, so it can't be
+// transferred afterwards, and that funds already transferred can't be refunded
+func TestRefundTakesFromWallet(t *testing.T) {
+	mockDB := db.NewMockDB()
+	provider := &mockProvider{id: "1", name: "TestGateway", dataFormat: "application/json"}
+	service := NewTransactionService(mockDB, &mockGatewaySelector{
+		getProviderFunc: func(id string) (gateway.Provider, error) { return provider, nil },
+	})
+	ctx := context.Background()
+
+	depositID, err := mockDB.CreateTransaction(models.Transaction{UserID: 1, Amount: 100, Currency: "USD", Type: consts.Deposit, Status: consts.Completed, GatewayID: 1})
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	if _, err := service.ProcessRefund(ctx, 1, models.RefundRequest{TransactionID: depositID}); err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	if wallet, err := service.GetWalletBalance(ctx, 1, 1, "USD"); err != nil || wallet.Balance != 0 {
+		t.Errorf("Expected the refund to empty the wallet, got %+v (%v)", wallet, err)
+	}
+	transfer, err := service.Transfer(ctx, 1, models.TransferRequest{FromUserID: 1, ToUserID: 2, Amount: 100, Currency: "USD"})
+	if err != nil || transfer.Status != consts.Failed || transfer.DeclineCode != consts.DeclineInsufficientFunds {
+		t.Errorf("Expected the transfer of refunded funds declined, got %+v (%v)", transfer, err)
+	}
+
+	otherID, err := mockDB.CreateTransaction(models.Transaction{UserID: 3, Amount: 50, Currency: "USD", Type: consts.Deposit, Status: consts.Completed, GatewayID: 1})
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	if transfer, err := service.Transfer(ctx, 1, models.TransferRequest{FromUserID: 3, ToUserID: 2, Amount: 40, Currency: "USD"}); err != nil || transfer.Status != consts.Completed {
+		t.Fatalf("Expected a completed transfer, got %+v (%v)", transfer, err)
+	}
+	if _, err := service.ProcessRefund(ctx, 1, models.RefundRequest{TransactionID: otherID, Amount: 20}); !errors.Is(err, ErrRefundExceedsBalance) {
+		t.Errorf("Expected ErrRefundExceedsBalance, got: %v", err)
+	}
+	if refunds, _ := mockDB.GetTransactionRefunds(otherID); len(refunds) != 0 {
+		t.Errorf("Expected no refund recorded, got %+v", refunds)
+	}
+	if refund, err := service.ProcessRefund(ctx, 1, models.RefundRequest{TransactionID: otherID, Amount: 10}); err != nil || refund.Status != consts.Completed {
+		t.Errorf("Expected the refund the wallet covers completed, got %+v (%v)", refund, err)
+	}
+}
@@ -0,0 +1,175 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"payment-gateway/db"
+	"payment-gateway/internal/consts"
+	"payment-gateway/internal/models"
+	"sync"
+	"time"
+)
+
+var (
+	ErrTransactionNotFound     = errors.New("transaction not found")
+	ErrTransactionNotDeletable = errors.New("only completed or failed transactions can be deleted")
+	ErrArchivalInProgress      = errors.New("an archival run is already in progress")
+)
+
+// RetentionService keeps the hot transactions table small by moving aged and soft-deleted
+// transactions into the archive, purging PII according to the retention policy
+type RetentionService struct {
+	db         db.DBInterface
+	operations *OperationService
+	policy     models.RetentionPolicy
+
+	mu      sync.Mutex
+	running bool
+}
+
+// DefaultRetentionPolicy returns the retention policy used when none is configured
+func DefaultRetentionPolicy() models.RetentionPolicy {
+	return models.RetentionPolicy{
+		ArchiveAfterDays: consts.DefaultArchiveAfterDays,
+		PurgePII:         true,
+		BatchSize:        consts.DefaultArchiveBatchSize,
+	}
+}
+
+// NewRetentionService creates a new retention service
+func NewRetentionService(dbInterface db.DBInterface, operations *OperationService, policy models.RetentionPolicy) *RetentionService {
+	defaults := DefaultRetentionPolicy()
+	if policy.ArchiveAfterDays <= 0 {
+		policy.ArchiveAfterDays = defaults.ArchiveAfterDays
+	}
+	if policy.BatchSize <= 0 {
+		policy.BatchSize = defaults.BatchSize
+	}
+
+	return &RetentionService{
+		db:         dbInterface,
+		operations: operations,
+		policy:     policy,
+	}
+}
+
+// Policy returns the active retention policy
+func (s *RetentionService) Policy() models.RetentionPolicy {
+	return s.policy
+}
+
+// SoftDelete hides a completed or failed transaction and queues it for archival
+func (s *RetentionService) SoftDelete(ctx context.Context, txID int) error {
+	tx, err := s.db.GetTransactionByID(txID)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrTransactionNotFound, err)
+	}
+
+	if tx.Status != consts.Completed && tx.Status != consts.Failed {
+		return ErrTransactionNotDeletable
+	}
+
+	if err := s.db.SoftDeleteTransaction(txID); err != nil {
+		return fmt.Errorf("failed to delete transaction: %w", err)
+	}
+
+	return nil
+}
+
+// StartArchival begins an asynchronous archival run tracked as a long-running operation
+func (s *RetentionService) StartArchival(ctx context.Context) (*models.Operation, error) {
+	if !s.acquire() {
+		return nil, ErrArchivalInProgress
+	}
+
+	op, err := s.operations.Start(consts.OperationArchival)
+	if err != nil {
+		s.release()
+		return nil, err
+	}
+
+	// Hand the caller a snapshot; the run keeps updating its own copy in the background
+	started := *op
+
+	go func() {
+		defer s.release()
+		s.archive(op)
+	}()
+
+	return &started, nil
+}
+
+// Status reports the retention policy, table sizes and the most recent archival run
+func (s *RetentionService) Status(ctx context.Context) (*models.ArchivalStatus, error) {
+	stats, err := s.db.GetArchivalStats()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get archival stats: %w", err)
+	}
+
+	status := &models.ArchivalStatus{
+		Policy: s.policy,
+		Stats:  *stats,
+	}
+
+	// A missing run only means archival has not happened since operations last expired
+	if op, err := s.db.GetLatestOperationByType(consts.OperationArchival); err == nil {
+		status.LastRun = op
+	}
+
+	return status, nil
+}
+
+// archive moves eligible transactions to the archive in batches until none remain
+func (s *RetentionService) archive(op *models.Operation) {
+	cutoff := time.Now().AddDate(0, 0, -s.policy.ArchiveAfterDays)
+
+	var archived int64
+	for {
+		moved, err := s.db.ArchiveTransactions(cutoff, s.policy.PurgePII, s.policy.BatchSize)
+		if err != nil {
+			s.operations.Fail(op, fmt.Errorf("archival stopped after %d transactions: %w", archived, err))
+			return
+		}
+
+		archived += moved
+		s.operations.Progress(op, int(archived), 0)
+
+		if moved < int64(s.policy.BatchSize) {
+			break
+		}
+	}
+
+	op.Total = int(archived)
+	s.operations.Complete(op, models.ArchivalResult{
+		Cutoff:      cutoff,
+		Archived:    archived,
+		PIIPurged:   s.policy.PurgePII,
+		CompletedAt: time.Now(),
+	})
+
+	if archived > 0 {
+		log.Printf("Archived %d transactions created before %s", archived, cutoff.Format(time.RFC3339))
+	}
+}
+
+// acquire marks an archival run as in progress, reporting false if one already is
+func (s *RetentionService) acquire() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.running {
+		return false
+	}
+	s.running = true
+	return true
+}
+
+// release marks the current archival run as finished
+func (s *RetentionService) release() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.running = false
+}
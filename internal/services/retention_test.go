@@ -0,0 +1,101 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"payment-gateway/db"
+	"payment-gateway/internal/consts"
+	"payment-gateway/internal/models"
+	"testing"
+	"time"
+)
+
+// waitForOperation polls an operation until it leaves the pending and running states
+func waitForOperation(t *testing.T, service *OperationService, operationID string) *models.Operation {
+	t.Helper()
+
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) {
+		op, err := service.Get(context.Background(), operationID)
+		if err == nil && op.Status != consts.OperationPending && op.Status != consts.OperationRunning {
+			return op
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	t.Fatalf("Operation %s did not finish in time", operationID)
+	return nil
+}
+
+// TestArchival tests that aged and soft-deleted transactions are archived with PII purged
+func TestArchival(t *testing.T) {
+	mockDB := db.NewMockDB()
+	operations := NewOperationService(mockDB)
+	service := NewRetentionService(mockDB, operations, models.RetentionPolicy{ArchiveAfterDays: 30, PurgePII: true, BatchSize: 1})
+	ctx := context.Background()
+
+	old := time.Now().AddDate(0, 0, -60)
+	agedID, _ := mockDB.CreateTransaction(models.Transaction{Status: consts.Completed, Beneficiary: "GB00BANK", CreatedAt: old})
+	pendingID, _ := mockDB.CreateTransaction(models.Transaction{Status: consts.Processing, CreatedAt: old})
+	recentID, _ := mockDB.CreateTransaction(models.Transaction{Status: consts.Failed})
+	deletedID, _ := mockDB.CreateTransaction(models.Transaction{Status: consts.Completed})
+
+	if err := service.SoftDelete(ctx, deletedID); err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	if _, err := mockDB.GetTransactionByID(deletedID); err == nil {
+		t.Error("Expected soft-deleted transaction to be hidden")
+	}
+
+	op, err := service.StartArchival(ctx)
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+
+	finished := waitForOperation(t, operations, op.ID)
+	if finished.Status != consts.OperationSucceeded {
+		t.Fatalf("Expected archival to succeed, got %s: %s", finished.Status, finished.Error)
+	}
+
+	var result models.ArchivalResult
+	if err := json.Unmarshal(finished.Result, &result); err != nil || result.Archived != 2 || !result.PIIPurged {
+		t.Errorf("Unexpected archival result: %s", finished.Result)
+	}
+
+	if _, err := mockDB.GetTransactionByID(agedID); err == nil {
+		t.Error("Expected aged transaction to be archived")
+	}
+	for _, id := range []int{pendingID, recentID} {
+		if _, err := mockDB.GetTransactionByID(id); err != nil {
+			t.Errorf("Expected transaction %d to remain in the hot table, got: %v", id, err)
+		}
+	}
+
+	status, err := service.Status(ctx)
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	if status.Stats.ArchivedTransactions != 2 || status.Stats.HotTransactions != 2 {
+		t.Errorf("Unexpected archival stats: %+v", status.Stats)
+	}
+	if status.LastRun == nil || status.LastRun.ID != op.ID {
+		t.Errorf("Expected last run %s, got: %+v", op.ID, status.LastRun)
+	}
+}
+
+// TestSoftDeleteRejectsActiveTransactions tests that in-flight transactions cannot be deleted
+func TestSoftDeleteRejectsActiveTransactions(t *testing.T) {
+	mockDB := db.NewMockDB()
+	service := NewRetentionService(mockDB, NewOperationService(mockDB), DefaultRetentionPolicy())
+
+	txID, _ := mockDB.CreateTransaction(models.Transaction{Status: consts.Processing})
+
+	if err := service.SoftDelete(context.Background(), txID); !errors.Is(err, ErrTransactionNotDeletable) {
+		t.Errorf("Expected ErrTransactionNotDeletable, got: %v", err)
+	}
+
+	if err := service.SoftDelete(context.Background(), 999); !errors.Is(err, ErrTransactionNotFound) {
+		t.Errorf("Expected ErrTransactionNotFound, got: %v", err)
+	}
+}
@@ -0,0 +1,40 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"payment-gateway/internal/models"
+	"payment-gateway/internal/money"
+	"payment-gateway/internal/validation"
+)
+
+// ErrRoundingLeak reports an amount about to be recorded that differs from what its gateway would
+// be sent, which would leave the two ledgers apart
+var ErrRoundingLeak = errors.New("amount is not rounded to its currency's minor unit")
+
+// roundAmount rounds a request's amount to the minor unit of its currency with the currency's
+// rounding mode, e.g. 10.005 USD to 10.01 (half_up) or 10.00 (half_even) and 1500.4 JPY to 1500.
+// Amounts rounding to zero are reported as a field error.
+func (s *TransactionService) roundAmount(req *models.TransactionRequest) error {
+	rounded, err := s.roundedAmount(req.Amount, req.Currency)
+	if err != nil {
+		return err
+	}
+	req.Amount = rounded
+	return nil
+}
+
+// roundedAmount returns an amount rounded to the minor unit of its currency, reporting one that
+// rounds to zero as a field error
+func (s *TransactionService) roundedAmount(amount float64, currency string) (float64, error) {
+	rounded := s.rounder.Round(amount, currency)
+	if rounded <= 0 {
+		return 0, validation.Errors{{
+			Field:   "amount",
+			Rule:    "amount",
+			Param:   currency,
+			Message: fmt.Sprintf("must be at least one minor unit of %s, %v", currency, money.FromMinorUnits(1, currency)),
+		}}
+	}
+	return rounded, nil
+}
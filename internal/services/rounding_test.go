@@ -0,0 +1,46 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"payment-gateway/db"
+	"payment-gateway/internal/gateway"
+	"payment-gateway/internal/models"
+	"payment-gateway/internal/money"
+	"payment-gateway/internal/validation"
+	"testing"
+)
+
+// TestRoundAmount tests that amounts are recorded rounded with their currency's rounding mode
+func TestRoundAmount(t *testing.T) {
+	mockDB := db.NewMockDB()
+	selector := gateway.NewSelector(mockDB)
+	selector.RegisterProvider(gateway.NewMockProvider(1, "Stripe", "application/json", 1.0, 0))
+	service := NewTransactionService(mockDB, selector)
+	rounder, err := money.ParseRounder("", "EUR=half_even")
+	if err != nil {
+		t.Fatalf("ParseRounder failed: %v", err)
+	}
+	service.SetRounder(rounder)
+	ctx := context.Background()
+
+	for _, tt := range []struct {
+		currency string
+		want     float64
+	}{{"USD", 10.01}, {"EUR", 10.00}} {
+		response, err := service.ProcessDeposit(ctx, models.TransactionRequest{UserID: 1, Amount: 10.005, Currency: tt.currency})
+		if err != nil {
+			t.Fatalf("ProcessDeposit failed: %v", err)
+		}
+		transaction, err := mockDB.GetTransactionByID(response.TransactionID)
+		if err != nil || transaction.Amount != tt.want {
+			t.Errorf("Expected %v %s recorded, got %+v, %v", tt.want, tt.currency, transaction, err)
+		}
+	}
+
+	_, err = service.ProcessDeposit(ctx, models.TransactionRequest{UserID: 1, Amount: 0.004, Currency: "USD"})
+	var fieldErrs validation.Errors
+	if !errors.As(err, &fieldErrs) || fieldErrs[0].Field != "amount" {
+		t.Errorf("Expected an amount rounding to zero refused, got: %v", err)
+	}
+}
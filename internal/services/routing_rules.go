@@ -0,0 +1,171 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"payment-gateway/db"
+	"payment-gateway/internal/gateway"
+	"payment-gateway/internal/geo"
+	"payment-gateway/internal/models"
+	"strconv"
+	"strings"
+)
+
+var ErrInvalidRoutingRule = errors.New("invalid routing rule")
+
+// RoutingRuleService manages the routing rules merchants use to prefer or exclude gateways
+// for their transactions. Rules only narrow the platform's choice: a gateway must still
+// support the country and be healthy and out of maintenance to be selected.
+type RoutingRuleService struct {
+	db       db.DBInterface
+	selector gateway.SelectorInterface
+}
+
+// NewRoutingRuleService creates a new routing rule service
+func NewRoutingRuleService(dbInterface db.DBInterface, selector gateway.SelectorInterface) *RoutingRuleService {
+	return &RoutingRuleService{db: dbInterface, selector: selector}
+}
+
+// List returns a merchant's routing rules in evaluation order
+func (s *RoutingRuleService) List(ctx context.Context, merchantID int) ([]models.RoutingRule, error) {
+	rules, err := s.db.GetRoutingRules(merchantID)
+	if err != nil {
+		return nil, err
+	}
+	if rules == nil {
+		rules = []models.RoutingRule{}
+	}
+	return rules, nil
+}
+
+// Replace validates rules and saves them as the merchant's rules, in the order given
+func (s *RoutingRuleService) Replace(ctx context.Context, merchantID int, rules []models.RoutingRule) ([]models.RoutingRule, error) {
+	rules, err := s.validate(rules)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := s.db.GetMerchantByID(merchantID); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrMerchantNotFound
+		}
+		return nil, err
+	}
+
+	if err := s.db.ReplaceRoutingRules(merchantID, rules); err != nil {
+		return nil, err
+	}
+	return rules, nil
+}
+
+// Simulate reports how a transaction would be routed without creating it, along with every
+// check the selector made. The request's draft rules are used when it has any, and otherwise
+// the saved rules of its merchant; a request without a merchant is routed without rules.
+func (s *RoutingRuleService) Simulate(ctx context.Context, req models.RoutingSimulationRequest) (*models.RoutingSimulation, error) {
+	if req.MerchantID != 0 {
+		if _, err := s.db.GetMerchantByID(req.MerchantID); err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				return nil, ErrMerchantNotFound
+			}
+			return nil, err
+		}
+	}
+
+	rules := req.Rules
+	if rules != nil {
+		var err error
+		if rules, err = s.validate(rules); err != nil {
+			return nil, err
+		}
+	} else if req.MerchantID != 0 {
+		var err error
+		if rules, err = s.db.GetRoutingRules(req.MerchantID); err != nil {
+			return nil, err
+		}
+	}
+
+	country, err := s.db.GetCountryByCode(geo.NormalizeCountryCode(req.CountryCode))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, fmt.Errorf("%w: %s", ErrUnsupportedCountry, req.CountryCode)
+		}
+		return nil, err
+	}
+
+	opts := selectionOptions(models.TransactionRequest{PreferredGatewayID: req.PreferredGatewayID, ExcludedGatewayIDs: req.ExcludedGatewayIDs})
+	opts.Rules = rules
+	opts.RuleInput = gateway.RuleInput{Type: req.Type, Amount: req.Amount, Currency: req.Currency, Country: country.Code}
+
+	simulation := &models.RoutingSimulation{CountryID: country.ID, MatchedRules: []int{}}
+	for _, rule := range rules {
+		if gateway.RuleMatches(rule, opts.RuleInput) {
+			simulation.MatchedRules = append(simulation.MatchedRules, rule.Position)
+		}
+	}
+
+	_, decision, trace, err := s.selector.TraceGatewaySelection(ctx, country.ID, req.Type, opts)
+	switch {
+	case errors.Is(err, gateway.ErrNoAvailableGateway), errors.Is(err, gateway.ErrInvalidGatewayOverride):
+		simulation.Reason = err.Error()
+	case err != nil:
+		return nil, err
+	default:
+		simulation.SelectedGatewayID = decision.SelectedGatewayID
+		simulation.Override = decision.Override
+		simulation.Reason = decision.Reason
+	}
+	simulation.Trace = trace
+	if simulation.Trace == nil {
+		simulation.Trace = []models.RoutingTraceStep{}
+	}
+
+	return simulation, nil
+}
+
+// validate checks each rule and that its gateway exists, returning the rules numbered in order
+func (s *RoutingRuleService) validate(rules []models.RoutingRule) ([]models.RoutingRule, error) {
+	validated := make([]models.RoutingRule, len(rules))
+	for i, rule := range rules {
+		rule.Position = i + 1
+		rule.Value = strings.TrimSpace(rule.Value)
+		if rule.Field == gateway.RuleFieldCurrency || rule.Field == gateway.RuleFieldCountry {
+			rule.Value = strings.ToUpper(rule.Value)
+		}
+
+		if err := gateway.ValidateRule(rule); err != nil {
+			return nil, fmt.Errorf("%w %d: %v", ErrInvalidRoutingRule, rule.Position, err)
+		}
+		if _, err := s.selector.GetProviderByID(strconv.Itoa(rule.GatewayID)); err != nil {
+			return nil, fmt.Errorf("%w %d: unknown gateway %d", ErrInvalidRoutingRule, rule.Position, rule.GatewayID)
+		}
+
+		validated[i] = rule
+	}
+	return validated, nil
+}
+
+// applyRoutingRules adds the merchant's routing rules to the selection options of a transaction
+func (s *TransactionService) applyRoutingRules(opts *gateway.SelectionOptions, txType string, user *models.User, country *countryResolution, req models.TransactionRequest) error {
+	if user.MerchantID == 0 {
+		return nil
+	}
+
+	rules, err := s.db.GetRoutingRules(user.MerchantID)
+	if err != nil {
+		return fmt.Errorf("failed to get routing rules: %w", err)
+	}
+	if len(rules) == 0 {
+		return nil
+	}
+
+	code, err := s.countryCode(country)
+	if err != nil {
+		return err
+	}
+
+	opts.Rules = rules
+	opts.RuleInput = gateway.RuleInput{Type: txType, Amount: req.Amount, Currency: req.Currency, Country: code}
+	return nil
+}
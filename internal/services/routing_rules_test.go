@@ -0,0 +1,127 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"payment-gateway/db"
+	"payment-gateway/internal/gateway"
+	"payment-gateway/internal/models"
+	"testing"
+)
+
+// newRulesSelector creates a selector over the mock database with always-available providers
+func newRulesSelector(mockDB db.DBInterface) *gateway.Selector {
+	selector := gateway.NewSelector(mockDB)
+	selector.RegisterProvider(gateway.NewMockProvider(1, "PayPal", "application/json", 1.0, 0))
+	selector.RegisterProvider(gateway.NewMockProvider(2, "Stripe", "application/json", 1.0, 0))
+	selector.RegisterProvider(gateway.NewMockProvider(3, "Adyen", "application/xml", 1.0, 0))
+	return selector
+}
+
+// TestRoutingRules tests saving, validating and simulating merchant routing rules
+func TestRoutingRules(t *testing.T) {
+	mockDB := db.NewMockDB()
+	service := NewRoutingRuleService(mockDB, newRulesSelector(mockDB))
+	ctx := context.Background()
+
+	invalid := [][]models.RoutingRule{
+		{{Field: gateway.RuleFieldCurrency, Operator: gateway.RuleOpGt, Value: "GBP", Action: gateway.RuleActionPrefer, GatewayID: 2}},
+		{{Field: gateway.RuleFieldAmount, Operator: gateway.RuleOpGt, Value: "1000", Action: gateway.RuleActionExclude, GatewayID: 9}},
+	}
+	for _, rules := range invalid {
+		if _, err := service.Replace(ctx, 1, rules); !errors.Is(err, ErrInvalidRoutingRule) {
+			t.Errorf("Expected ErrInvalidRoutingRule, got: %v", err)
+		}
+	}
+
+	rules := []models.RoutingRule{
+		{Field: gateway.RuleFieldCurrency, Operator: gateway.RuleOpEq, Value: "gbp", Action: gateway.RuleActionPrefer, GatewayID: 3},
+		{Field: gateway.RuleFieldAmount, Operator: gateway.RuleOpGt, Value: "1000", Action: gateway.RuleActionExclude, GatewayID: 1},
+	}
+	if _, err := service.Replace(ctx, 999, rules); !errors.Is(err, ErrMerchantNotFound) {
+		t.Errorf("Expected ErrMerchantNotFound, got: %v", err)
+	}
+
+	saved, err := service.Replace(ctx, 1, rules)
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	if saved[0].Position != 1 || saved[1].Position != 2 || saved[0].Value != "GBP" {
+		t.Errorf("Expected rules numbered in order with normalized values, got %+v", saved)
+	}
+
+	listed, err := service.List(ctx, 1)
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	if len(listed) != 2 {
+		t.Errorf("Expected 2 saved rules, got %d", len(listed))
+	}
+
+	simulation, err := service.Simulate(ctx, models.RoutingSimulationRequest{MerchantID: 1, Type: "deposit", Amount: 2000, Currency: "USD", CountryCode: "us"})
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	if simulation.SelectedGatewayID != 2 || len(simulation.MatchedRules) != 1 || simulation.MatchedRules[0] != 2 {
+		t.Errorf("Expected rule 2 to exclude gateway 1 and route to gateway 2, got %+v", simulation)
+	}
+	if last := simulation.Trace[len(simulation.Trace)-1]; last.GatewayID != 2 || last.Outcome != gateway.TraceSelected {
+		t.Errorf("Expected the trace to end with gateway 2 selected, got %+v", simulation.Trace)
+	}
+
+	// Without a merchant no rules apply
+	simulation, err = service.Simulate(ctx, models.RoutingSimulationRequest{Type: "deposit", Amount: 2000, Currency: "USD", CountryCode: "US"})
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	if simulation.SelectedGatewayID != 1 || len(simulation.MatchedRules) != 0 {
+		t.Errorf("Expected gateway 1 by priority without merchant rules, got %+v", simulation)
+	}
+	if _, err := service.Simulate(ctx, models.RoutingSimulationRequest{MerchantID: 999, Type: "deposit", Amount: 10, Currency: "USD", CountryCode: "US"}); !errors.Is(err, ErrMerchantNotFound) {
+		t.Errorf("Expected ErrMerchantNotFound, got: %v", err)
+	}
+
+	// Draft rules replace the saved ones for the simulation only
+	draft := []models.RoutingRule{{Field: gateway.RuleFieldCountry, Operator: gateway.RuleOpIn, Value: "US,CA", Action: gateway.RuleActionExclude, GatewayID: 1}}
+	simulation, err = service.Simulate(ctx, models.RoutingSimulationRequest{MerchantID: 1, Type: "deposit", Amount: 10, Currency: "GBP", CountryCode: "US", ExcludedGatewayIDs: []int{2, 3}, Rules: draft})
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	if simulation.SelectedGatewayID != 0 || simulation.Reason == "" {
+		t.Errorf("Expected no gateway to be available, got %+v", simulation)
+	}
+
+	if _, err := service.Simulate(ctx, models.RoutingSimulationRequest{MerchantID: 1, Type: "deposit", Amount: 10, Currency: "USD", CountryCode: "BR"}); !errors.Is(err, ErrUnsupportedCountry) {
+		t.Errorf("Expected ErrUnsupportedCountry, got: %v", err)
+	}
+}
+
+// TestProcessDepositAppliesRoutingRules tests that the user's merchant rules reach the selector
+func TestProcessDepositAppliesRoutingRules(t *testing.T) {
+	mockDB := db.NewMockDB()
+	rules := []models.RoutingRule{{Position: 1, Field: gateway.RuleFieldCurrency, Operator: gateway.RuleOpEq, Value: "USD", Action: gateway.RuleActionPrefer, GatewayID: 2}}
+	if err := mockDB.ReplaceRoutingRules(1, rules); err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+
+	var got gateway.SelectionOptions
+	selector := &mockGatewaySelector{
+		selectWithOptsFunc: func(ctx context.Context, countryID int, txType string, opts gateway.SelectionOptions) (gateway.Provider, models.RoutingDecision, error) {
+			got = opts
+			return &mockProvider{id: "2"}, models.RoutingDecision{}, nil
+		},
+	}
+
+	service := NewTransactionService(mockDB, selector)
+	if _, err := service.ProcessDeposit(context.Background(), models.TransactionRequest{UserID: 1, Amount: 25, Currency: "USD"}); err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+
+	if len(got.Rules) != 1 || got.Rules[0].GatewayID != 2 {
+		t.Errorf("Expected the merchant's rule to be passed to the selector, got %+v", got.Rules)
+	}
+	want := gateway.RuleInput{Type: "deposit", Amount: 25, Currency: "USD", Country: "US"}
+	if got.RuleInput != want {
+		t.Errorf("Expected rule input %+v, got %+v", want, got.RuleInput)
+	}
+}
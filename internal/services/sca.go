@@ -0,0 +1,86 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"payment-gateway/internal/consts"
+	"payment-gateway/internal/gateway"
+	"payment-gateway/internal/models"
+)
+
+var ErrInvalidSCAExemption = errors.New("invalid SCA exemption")
+
+// challengeKeySuffix distinguishes the idempotency key of a deposit resubmitted with a 3DS
+// challenge from the key of the attempt whose exemption was rejected
+const challengeKeySuffix = "-3ds"
+
+// validateSCAExemption checks that an SCA exemption is only requested for card deposits
+func validateSCAExemption(txType string, req models.TransactionRequest) error {
+	if req.SCAExemption == "" {
+		return nil
+	}
+	if txType != consts.Deposit {
+		return fmt.Errorf("%w: exemptions apply to deposits only", ErrInvalidSCAExemption)
+	}
+	if req.CardBIN == "" {
+		return fmt.Errorf("%w: exemptions apply to card deposits, card_bin is required", ErrInvalidSCAExemption)
+	}
+	return nil
+}
+
+// deposit submits a deposit to its gateway, requesting the transaction's SCA exemption while its
+// outcome is unknown. When the issuer rejects the exemption the deposit is submitted again without
+// it under a new idempotency key, so the customer is sent to a 3DS challenge instead.
+func (s *TransactionService) deposit(ctx context.Context, provider gateway.Provider, tx *models.Transaction) (*models.TransactionResponse, error) {
+	// Open banking deposits are authorised by the customer at their bank instead
+	if initiator, ok := provider.(gateway.PaymentInitiationProvider); ok {
+		return s.initiateBankPayment(ctx, initiator, tx)
+	}
+
+	// Deposits under a SEPA mandate are debited from the customer's account, without a challenge
+	if debiter, ok := provider.(gateway.DirectDebitProvider); ok {
+		return s.debit(ctx, debiter, *tx)
+	}
+
+	// Deposits to capture later only have their amount held
+	if authorizer, ok := provider.(gateway.AuthorizationProvider); ok && tx.ManualCapture {
+		return authorizer.Authorize(ctx, *tx)
+	}
+
+	call := *tx
+	if tx.SCAExemptionOutcome != "" {
+		call.SCAExemption = ""
+	}
+
+	response, err := provider.ProcessDeposit(ctx, call)
+	var rejected *gateway.SCAExemptionRejectedError
+	if !errors.As(err, &rejected) {
+		if err == nil && call.SCAExemption != "" {
+			s.recordSCAExemptionOutcome(tx, consts.SCAExemptionGranted)
+		}
+		return response, err
+	}
+
+	log.Printf("Transaction %d: %v; falling back to a 3DS challenge", tx.ID, rejected)
+	s.recordSCAExemptionOutcome(tx, consts.SCAExemptionRejected)
+
+	key := tx.GatewayIdempotencyKey + challengeKeySuffix
+	if err := s.db.UpdateTransactionIdempotencyKey(tx.ID, key); err != nil {
+		return nil, fmt.Errorf("failed to store idempotency key: %w", err)
+	}
+	tx.GatewayIdempotencyKey = key
+
+	call = *tx
+	call.SCAExemption = ""
+	return provider.ProcessDeposit(ctx, call)
+}
+
+// recordSCAExemptionOutcome stores the outcome of a transaction's requested SCA exemption
+func (s *TransactionService) recordSCAExemptionOutcome(tx *models.Transaction, outcome string) {
+	if err := s.db.UpdateTransactionSCAExemptionOutcome(tx.ID, outcome); err != nil {
+		log.Printf("Failed to record SCA exemption outcome of transaction %d: %v", tx.ID, err)
+	}
+	tx.SCAExemptionOutcome = outcome
+}
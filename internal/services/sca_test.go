@@ -0,0 +1,72 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"payment-gateway/db"
+	"payment-gateway/internal/consts"
+	"payment-gateway/internal/gateway"
+	"payment-gateway/internal/models"
+	"strings"
+	"testing"
+)
+
+// challengeOnlyProvider hides the SCA exemption support of the provider it wraps
+type challengeOnlyProvider struct {
+	gateway.Provider
+}
+
+// TestSCAExemption tests that granted exemptions skip the challenge and rejected ones fall back to 3DS
+func TestSCAExemption(t *testing.T) {
+	mockDB := db.NewMockDB()
+	service := NewTransactionService(mockDB, newRulesSelector(mockDB))
+	ctx := context.Background()
+
+	granted, err := service.ProcessDeposit(ctx, models.TransactionRequest{UserID: 1, Amount: 20, Currency: "USD", CardBIN: "424242", SCAExemption: consts.SCAExemptionLowValue})
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	if granted.SCAExemptionOutcome != consts.SCAExemptionGranted || granted.RedirectURL != "" {
+		t.Errorf("Expected a granted exemption without a challenge, got %+v", granted)
+	}
+
+	rejected, err := service.ProcessDeposit(ctx, models.TransactionRequest{UserID: 1, Amount: 100, Currency: "USD", CardBIN: "424242", SCAExemption: consts.SCAExemptionLowValue})
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	if rejected.SCAExemptionOutcome != consts.SCAExemptionRejected || rejected.RedirectURL == "" || rejected.Status != consts.Processing {
+		t.Errorf("Expected a rejected exemption to fall back to a challenge, got %+v", rejected)
+	}
+	tx, err := mockDB.GetTransactionByID(rejected.TransactionID)
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	if tx.SCAExemption != consts.SCAExemptionLowValue || tx.SCAExemptionOutcome != consts.SCAExemptionRejected || !strings.HasSuffix(tx.GatewayIdempotencyKey, challengeKeySuffix) {
+		t.Errorf("Expected the rejection recorded and the challenge submitted under a new key, got %+v", tx)
+	}
+
+	invalid := []struct {
+		txType string
+		req    models.TransactionRequest
+	}{
+		{consts.Withdrawal, models.TransactionRequest{UserID: 1, Amount: 20, Currency: "USD", CardBIN: "424242", SCAExemption: consts.SCAExemptionTRA}},
+		{consts.Deposit, models.TransactionRequest{UserID: 1, Amount: 20, Currency: "USD", SCAExemption: consts.SCAExemptionTRA}},
+	}
+	for _, tt := range invalid {
+		if _, err := service.processTransaction(ctx, tt.txType, tt.req); !errors.Is(err, ErrInvalidSCAExemption) {
+			t.Errorf("Expected ErrInvalidSCAExemption for a %s of %+v, got: %v", tt.txType, tt.req, err)
+		}
+	}
+
+	// Gateways that cannot request exemptions always challenge
+	selector := gateway.NewSelector(mockDB)
+	selector.RegisterProvider(challengeOnlyProvider{gateway.NewMockProvider(1, "PayPal", "application/json", 1.0, 0)})
+	service = NewTransactionService(mockDB, selector)
+	unsupported, err := service.ProcessDeposit(ctx, models.TransactionRequest{UserID: 1, Amount: 20, Currency: "USD", CardBIN: "424242", SCAExemption: consts.SCAExemptionLowValue})
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	if unsupported.SCAExemptionOutcome != consts.SCAExemptionUnsupported || unsupported.RedirectURL == "" {
+		t.Errorf("Expected an unsupported exemption to be challenged, got %+v", unsupported)
+	}
+}
@@ -0,0 +1,245 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"payment-gateway/db"
+	"payment-gateway/internal/consts"
+	"payment-gateway/internal/models"
+	"payment-gateway/internal/screening"
+	"strings"
+	"time"
+)
+
+var (
+	ErrSanctionsMatch            = errors.New("name matches a sanctions list and is held for review")
+	ErrScreeningHold             = errors.New("user is held by sanctions screening")
+	ErrScreeningUnavailable      = errors.New("sanctions screening unavailable")
+	ErrScreeningCaseNotFound     = errors.New("screening case not found")
+	ErrScreeningCaseClosed       = errors.New("screening case is already closed")
+	ErrInvalidScreeningCaseQueue = errors.New("invalid screening case status")
+)
+
+// ScreeningService checks the names of new users and of the beneficiaries of large withdrawals
+// against sanctions lists. Matches open a case in a review queue and every screening and decision
+// is recorded in the user's audit trail.
+type ScreeningService struct {
+	db       db.DBInterface
+	screener screening.Screener
+}
+
+// NewScreeningService creates a screening service. With a nil screener names are not screened,
+// but users already held stay held and the review queue can still be worked.
+func NewScreeningService(dbInterface db.DBInterface, screener screening.Screener) *ScreeningService {
+	return &ScreeningService{db: dbInterface, screener: screener}
+}
+
+// enabled reports whether names are screened
+func (s *ScreeningService) enabled() bool {
+	return s != nil && s.screener != nil
+}
+
+// screen checks a name against the sanctions lists. Screening fails closed: a screener that
+// cannot answer refuses the user or withdrawal rather than letting it through unchecked.
+func (s *ScreeningService) screen(ctx context.Context, name, countryCode string) ([]models.ScreeningMatch, error) {
+	matches, err := s.screener.Screen(ctx, screening.Subject{Name: name, CountryCode: countryCode})
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrScreeningUnavailable, err)
+	}
+	return matches, nil
+}
+
+// record writes a screening to the user's audit trail and, when the name matched, opens a case
+// for review. The screening's outcome is already enforced, so failures to record are logged.
+func (s *ScreeningService) record(screeningCase models.ScreeningCase) {
+	now := time.Now()
+	s.event(models.ScreeningEvent{
+		UserID:    screeningCase.UserID,
+		Action:    consts.ScreeningEventScreened,
+		Actor:     consts.ScreeningActorSystem,
+		Detail:    fmt.Sprintf("%s screening, %d matches", screeningCase.Trigger, len(screeningCase.Matches)),
+		CreatedAt: now,
+	})
+	if len(screeningCase.Matches) == 0 {
+		return
+	}
+
+	screeningCase.Status = consts.ScreeningCaseOpen
+	screeningCase.CreatedAt = now
+	id, err := s.db.CreateScreeningCase(screeningCase)
+	if err != nil {
+		log.Printf("ERROR: failed to open screening case for user %d: %v", screeningCase.UserID, err)
+		return
+	}
+
+	lists := make([]string, len(screeningCase.Matches))
+	for i, match := range screeningCase.Matches {
+		lists[i] = fmt.Sprintf("%s %s (%.2f)", match.List, match.EntryID, match.Score)
+	}
+	s.event(models.ScreeningEvent{
+		UserID:    screeningCase.UserID,
+		CaseID:    id,
+		Action:    consts.ScreeningEventMatched,
+		Actor:     consts.ScreeningActorSystem,
+		Detail:    strings.Join(lists, ", "),
+		CreatedAt: now,
+	})
+	log.Printf("User %d matched a sanctions list on %s screening; screening case %d queued for review", screeningCase.UserID, screeningCase.Trigger, id)
+}
+
+// event appends an entry to a user's screening audit trail
+func (s *ScreeningService) event(event models.ScreeningEvent) {
+	if err := s.db.CreateScreeningEvent(event); err != nil {
+		log.Printf("ERROR: failed to record %s screening event of user %d: %v", event.Action, event.UserID, err)
+	}
+}
+
+// screenWithdrawal screens the beneficiary of a withdrawal reaching the AML threshold. A name
+// already reviewed for the user is not screened again: cleared names pass and names in an open or
+// confirmed case are refused. A new match opens a case and refuses the withdrawal.
+func (s *ScreeningService) screenWithdrawal(ctx context.Context, user *models.User, name string, amount float64, currency string) error {
+	if !s.enabled() {
+		return nil
+	}
+
+	cases, err := s.db.ListUserScreeningCases(user.ID)
+	if err != nil {
+		return fmt.Errorf("failed to get screening cases: %w", err)
+	}
+	for _, screeningCase := range cases {
+		if sameName(screeningCase.Name, name) {
+			if screeningCase.Status == consts.ScreeningCaseCleared {
+				return nil
+			}
+			return fmt.Errorf("%w: screening case %d", ErrSanctionsMatch, screeningCase.ID)
+		}
+	}
+
+	matches, err := s.screen(ctx, name, "")
+	if err != nil {
+		return err
+	}
+	s.record(models.ScreeningCase{
+		UserID:   user.ID,
+		Trigger:  consts.ScreeningTriggerWithdrawal,
+		Name:     name,
+		Amount:   amount,
+		Currency: currency,
+		Matches:  matches,
+	})
+	if len(matches) > 0 {
+		return ErrSanctionsMatch
+	}
+	return nil
+}
+
+// sameName compares names ignoring case and spacing
+func sameName(a, b string) bool {
+	return strings.EqualFold(strings.Join(strings.Fields(a), " "), strings.Join(strings.Fields(b), " "))
+}
+
+// ListCases returns the review queue: screening cases with the given status, open by default, oldest first
+func (s *ScreeningService) ListCases(ctx context.Context, status string) ([]models.ScreeningCase, error) {
+	if status == "" {
+		status = consts.ScreeningCaseOpen
+	}
+
+	switch status {
+	case consts.ScreeningCaseOpen, consts.ScreeningCaseCleared, consts.ScreeningCaseConfirmed:
+	default:
+		return nil, ErrInvalidScreeningCaseQueue
+	}
+
+	cases, err := s.db.ListScreeningCases(status, consts.MaxScreeningCaseListResults)
+	if err != nil {
+		return nil, err
+	}
+	if cases == nil {
+		cases = []models.ScreeningCase{}
+	}
+	return cases, nil
+}
+
+// GetCase returns a screening case
+func (s *ScreeningService) GetCase(ctx context.Context, caseID int) (*models.ScreeningCase, error) {
+	screeningCase, err := s.db.GetScreeningCaseByID(caseID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrScreeningCaseNotFound
+		}
+		return nil, err
+	}
+	return screeningCase, nil
+}
+
+// DecideCase closes an open screening case. Clearing a new user's case as a false positive lets
+// them transact; confirming it blocks them. Decided withdrawal beneficiaries are remembered for
+// the user's later withdrawals.
+func (s *ScreeningService) DecideCase(ctx context.Context, caseID int, req models.ScreeningCaseDecisionRequest) (*models.ScreeningCase, error) {
+	if err := s.db.DecideScreeningCase(caseID, req.Status, req.Reviewer, req.Note, time.Now()); err != nil {
+		if !errors.Is(err, sql.ErrNoRows) {
+			return nil, err
+		}
+		if _, err := s.GetCase(ctx, caseID); err != nil {
+			return nil, err
+		}
+		return nil, ErrScreeningCaseClosed
+	}
+
+	screeningCase, err := s.GetCase(ctx, caseID)
+	if err != nil {
+		return nil, err
+	}
+	s.event(models.ScreeningEvent{
+		UserID:    screeningCase.UserID,
+		CaseID:    caseID,
+		Action:    req.Status,
+		Actor:     req.Reviewer,
+		Detail:    req.Note,
+		CreatedAt: screeningCase.UpdatedAt,
+	})
+
+	if screeningCase.Trigger == consts.ScreeningTriggerUserCreated {
+		status := consts.ScreeningClear
+		if req.Status == consts.ScreeningCaseConfirmed {
+			status = consts.ScreeningBlocked
+		}
+		if err := s.db.UpdateUserScreeningStatus(screeningCase.UserID, status); err != nil {
+			return nil, fmt.Errorf("failed to update user screening status: %w", err)
+		}
+	}
+
+	log.Printf("Screening case %d %s by %s", caseID, req.Status, req.Reviewer)
+	return screeningCase, nil
+}
+
+// Events returns a user's screening audit trail, oldest first
+func (s *ScreeningService) Events(ctx context.Context, userID int) ([]models.ScreeningEvent, error) {
+	if _, err := s.db.GetUserByID(userID); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrUserNotFound
+		}
+		return nil, err
+	}
+
+	events, err := s.db.ListScreeningEvents(userID)
+	if err != nil {
+		return nil, err
+	}
+	if events == nil {
+		events = []models.ScreeningEvent{}
+	}
+	return events, nil
+}
+
+// screeningHold refuses transactions of users whose screening matched until the match is cleared
+func screeningHold(user *models.User) error {
+	switch user.ScreeningStatus {
+	case consts.ScreeningPendingReview, consts.ScreeningBlocked:
+		return fmt.Errorf("%w: user %d is %s", ErrScreeningHold, user.ID, user.ScreeningStatus)
+	}
+	return nil
+}
@@ -0,0 +1,126 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"payment-gateway/db"
+	"payment-gateway/internal/consts"
+	"payment-gateway/internal/gateway"
+	"payment-gateway/internal/models"
+	"payment-gateway/internal/screening"
+	"strings"
+	"testing"
+)
+
+// newScreeningTestServices creates transaction and user services screening against a small list
+func newScreeningTestServices(t *testing.T) (*db.MockDB, *TransactionService, *UserService, *ScreeningService) {
+	t.Helper()
+
+	list, err := screening.LoadList(strings.NewReader("OFAC SDN,7441,Ivan Petrovich Sidorov,Ivan Sidorov\n"))
+	if err != nil {
+		t.Fatalf("LoadList failed: %v", err)
+	}
+
+	mockDB := db.NewMockDB()
+	selector := gateway.NewSelector(mockDB)
+	selector.RegisterProvider(gateway.NewMockProvider(1, "Stripe", "application/json", 1.0, 0))
+	screeningService := NewScreeningService(mockDB, list)
+
+	transactions := NewTransactionService(mockDB, selector)
+	transactions.SetScreening(screeningService)
+	users := NewUserService(mockDB)
+	users.SetScreening(screeningService)
+	return mockDB, transactions, users, screeningService
+}
+
+// TestScreenNewUser tests that a new user matching a sanctions list is held until cleared, with
+// every step in their audit trail
+func TestScreenNewUser(t *testing.T) {
+	_, transactions, users, screeningService := newScreeningTestServices(t)
+	ctx := context.Background()
+
+	jane, err := users.Create(ctx, models.UserCreateRequest{Username: "jane", Email: "jane@example.com", FullName: "Jane Doe", CountryCode: "gb"})
+	if err != nil || jane.ScreeningStatus != consts.ScreeningClear {
+		t.Fatalf("Expected a clear user, got %+v, %v", jane, err)
+	}
+
+	user, err := users.Create(ctx, models.UserCreateRequest{Username: "ivan", Email: "ivan@example.com", FullName: "SIDOROV, Ivan", CountryCode: "US"})
+	if err != nil || user.ScreeningStatus != consts.ScreeningPendingReview {
+		t.Fatalf("Expected a user pending review, got %+v, %v", user, err)
+	}
+
+	if _, err := transactions.ProcessDeposit(ctx, models.TransactionRequest{UserID: user.ID, Amount: 10, Currency: "USD"}); !errors.Is(err, ErrScreeningHold) {
+		t.Fatalf("Expected ErrScreeningHold, got: %v", err)
+	}
+
+	cases, err := screeningService.ListCases(ctx, "")
+	if err != nil || len(cases) != 1 || cases[0].UserID != user.ID || cases[0].Trigger != consts.ScreeningTriggerUserCreated ||
+		len(cases[0].Matches) != 1 || cases[0].Matches[0].EntryID != "7441" {
+		t.Fatalf("Expected one open case of the new user, got %+v, %v", cases, err)
+	}
+
+	decision := models.ScreeningCaseDecisionRequest{Status: consts.ScreeningCaseCleared, Reviewer: "compliance@example.com", Note: "Different date of birth"}
+	if _, err := screeningService.DecideCase(ctx, cases[0].ID, decision); err != nil {
+		t.Fatalf("DecideCase failed: %v", err)
+	}
+	if _, err := transactions.ProcessDeposit(ctx, models.TransactionRequest{UserID: user.ID, Amount: 10, Currency: "USD"}); err != nil {
+		t.Errorf("Expected the cleared user's deposit to pass, got: %v", err)
+	}
+	if _, err := screeningService.DecideCase(ctx, cases[0].ID, decision); !errors.Is(err, ErrScreeningCaseClosed) {
+		t.Errorf("Expected ErrScreeningCaseClosed, got: %v", err)
+	}
+
+	events, err := screeningService.Events(ctx, user.ID)
+	if err != nil || len(events) != 3 {
+		t.Fatalf("Expected three audit trail entries, got %+v, %v", events, err)
+	}
+	if events[0].Action != consts.ScreeningEventScreened || events[1].Action != consts.ScreeningEventMatched ||
+		events[2].Action != consts.ScreeningCaseCleared || events[2].Actor != "compliance@example.com" {
+		t.Errorf("Unexpected audit trail: %+v", events)
+	}
+}
+
+// TestScreenWithdrawal tests that the beneficiary of a withdrawal reaching the AML threshold is
+// screened, and that a reviewed beneficiary is not screened again
+func TestScreenWithdrawal(t *testing.T) {
+	mockDB, transactions, _, screeningService := newScreeningTestServices(t)
+	ctx := context.Background()
+
+	if _, err := transactions.SetAMLThreshold(ctx, "US", "USD", models.AMLThresholdRequest{Amount: 3000}); err != nil {
+		t.Fatalf("SetAMLThreshold failed: %v", err)
+	}
+	if _, err := mockDB.CreateTransaction(models.Transaction{UserID: 1, Amount: 100000, Currency: "USD", Type: consts.Deposit, Status: consts.Completed, GatewayID: 1}); err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	withdrawal := func(beneficiary string, amount float64) error {
+		_, err := transactions.ProcessWithdrawal(ctx, models.TransactionRequest{UserID: 1, Amount: amount, Currency: "USD",
+			TravelRule: &models.TravelRuleInfo{OriginatorName: "Jane Doe", OriginatorAccount: "US-1234", BeneficiaryName: beneficiary, BeneficiaryAccount: "RU-5678"}})
+		return err
+	}
+
+	// Below the threshold, beneficiaries are not screened
+	if err := withdrawal("Ivan Sidorov", 100); err != nil {
+		t.Fatalf("Expected a small withdrawal to pass, got: %v", err)
+	}
+	if err := withdrawal("Ivan Sidorov", 5000); !errors.Is(err, ErrSanctionsMatch) {
+		t.Fatalf("Expected ErrSanctionsMatch, got: %v", err)
+	}
+	if err := withdrawal("ivan  sidorov", 5000); !errors.Is(err, ErrSanctionsMatch) {
+		t.Fatalf("Expected the open case to refuse the beneficiary again, got: %v", err)
+	}
+
+	cases, _ := screeningService.ListCases(ctx, "")
+	if len(cases) != 1 || cases[0].Trigger != consts.ScreeningTriggerWithdrawal || cases[0].Amount != 5000 || cases[0].Name != "Ivan Sidorov" {
+		t.Fatalf("Expected one withdrawal case, got %+v", cases)
+	}
+
+	if _, err := screeningService.DecideCase(ctx, cases[0].ID, models.ScreeningCaseDecisionRequest{Status: consts.ScreeningCaseCleared, Reviewer: "compliance@example.com"}); err != nil {
+		t.Fatalf("DecideCase failed: %v", err)
+	}
+	if err := withdrawal("Ivan Sidorov", 5000); err != nil {
+		t.Errorf("Expected the cleared beneficiary to pass, got: %v", err)
+	}
+	if user, _ := mockDB.GetUserByID(1); user.ScreeningStatus != "" {
+		t.Errorf("Expected a withdrawal case to leave the user's status, got %q", user.ScreeningStatus)
+	}
+}
@@ -0,0 +1,68 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"payment-gateway/internal/consts"
+	"payment-gateway/internal/models"
+	"payment-gateway/internal/utils"
+	"strconv"
+	"strings"
+)
+
+var (
+	ErrInvalidSearchQuery = errors.New("search query must be at least 3 characters")
+)
+
+// SearchTransactions finds transactions for support agents by transaction ID, amount, user email
+// or gateway reference. Emails and references are matched through their blind indexes so no
+// stored value is compared or decrypted, and results are redacted. Only the kind of query is logged, never its value.
+func (s *TransactionService) SearchTransactions(ctx context.Context, q string) ([]models.TransactionSearchResult, error) {
+	search, kind, err := parseSearchQuery(q)
+	if err != nil {
+		return nil, err
+	}
+
+	results, err := s.db.SearchTransactions(search)
+	if err != nil {
+		return nil, fmt.Errorf("failed to search transactions: %w", err)
+	}
+
+	for i := range results {
+		results[i].UserEmail = utils.MaskEmail(results[i].UserEmail)
+	}
+
+	log.Printf("Admin transaction search by %s returned %d results", kind, len(results))
+	return results, nil
+}
+
+// parseSearchQuery turns a free-text query into search criteria: emails are blind indexed,
+// numbers match amounts (and transaction IDs when whole) and anything else matches references
+func parseSearchQuery(q string) (models.TransactionSearch, string, error) {
+	q = strings.TrimSpace(q)
+	search := models.TransactionSearch{Limit: consts.MaxSearchResults}
+
+	if len(q) < 3 {
+		return search, "", ErrInvalidSearchQuery
+	}
+
+	if strings.Contains(q, "@") {
+		search.EmailHashes = utils.BlindIndexCandidates(q)
+		return search, "email", nil
+	}
+
+	if amount, err := strconv.ParseFloat(q, 64); err == nil && amount > 0 {
+		search.Amount = amount
+		if id, err := strconv.Atoi(q); err == nil {
+			search.TransactionID = id
+			return search, "id_or_amount", nil
+		}
+		return search, "amount", nil
+	}
+
+	search.ReferenceHashes = utils.BlindIndexCandidates(q)
+	search.IdempotencyKey = q
+	return search, "reference", nil
+}
@@ -0,0 +1,62 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"payment-gateway/db"
+	"payment-gateway/internal/consts"
+	"payment-gateway/internal/models"
+	"testing"
+	"time"
+)
+
+// TestSearchTransactions tests searching by email, amount and reference with redacted results
+func TestSearchTransactions(t *testing.T) {
+	mock := db.NewMockDB()
+	service := NewTransactionService(mock, &mockGatewaySelector{})
+
+	for _, tx := range []models.Transaction{
+		{Amount: 42.50, Currency: "USD", Type: consts.Deposit, Status: consts.Completed, UserID: 1, GatewayID: 1, CountryID: 1, Beneficiary: "GB29NWBK60161331926819", CreatedAt: time.Now()},
+		{Amount: 10.00, Currency: "GBP", Type: consts.Deposit, Status: consts.Completed, UserID: 2, GatewayID: 2, CountryID: 2, CreatedAt: time.Now()},
+	} {
+		id, err := mock.CreateTransaction(tx)
+		if err != nil {
+			t.Fatalf("Failed to create transaction: %v", err)
+		}
+		mock.UpdateTransactionGatewayReference(id, fmt.Sprintf("STRIPE-REF-%d", id), "")
+	}
+
+	tests := []struct {
+		query   string
+		userIDs []int
+	}{
+		{"USER1@example.com", []int{1}},
+		{"42.50", []int{1}},
+		{"STRIPE-REF-2", []int{2}},
+		{"nobody@example.com", nil},
+	}
+
+	for _, tt := range tests {
+		results, err := service.SearchTransactions(context.Background(), tt.query)
+		if err != nil {
+			t.Fatalf("Expected no error for %q, got: %v", tt.query, err)
+		}
+
+		if len(results) != len(tt.userIDs) {
+			t.Fatalf("Expected %d results for %q, got %d", len(tt.userIDs), tt.query, len(results))
+		}
+		for i, result := range results {
+			if result.UserID != tt.userIDs[i] {
+				t.Errorf("Expected user %d for %q, got %d", tt.userIDs[i], tt.query, result.UserID)
+			}
+			if result.UserEmail != "u***@example.com" {
+				t.Errorf("Expected masked email, got %s", result.UserEmail)
+			}
+		}
+	}
+
+	if _, err := service.SearchTransactions(context.Background(), "ab"); !errors.Is(err, ErrInvalidSearchQuery) {
+		t.Errorf("Expected ErrInvalidSearchQuery, got: %v", err)
+	}
+}
@@ -0,0 +1,261 @@
+package services
+
+import (
+	"context"
+	"crypto"
+	"crypto/x509"
+	"database/sql"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"log"
+	"payment-gateway/db"
+	"payment-gateway/internal/auth"
+	"payment-gateway/internal/consts"
+	"payment-gateway/internal/models"
+	"payment-gateway/internal/utils"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	ErrInvalidSigningKey  = errors.New("invalid signing key")
+	ErrSigningKeyNotFound = errors.New("signing key not found")
+	ErrLastSigningKey     = errors.New("cannot retire the last active signing key; add a new one first")
+	ErrNoSigningKey       = errors.New("gateway has no active signing key")
+)
+
+// signingKey is a loaded active signing key
+type signingKey struct {
+	kid    string
+	signer crypto.Signer
+}
+
+// SigningKeyService manages the private keys requests to open-banking style gateways are signed
+// with, as JWTs (e.g. client assertions) or detached JWS request signatures. Private keys are
+// stored encrypted. Every signature names its key in the "kid" header, and the public keys of
+// active keys are published as a JWKS, so keys can be rotated without the gateway rejecting
+// requests: add a key, which is used from then on, and retire the old one once the gateway has
+// picked up the new one. Keys are refreshed periodically, so every instance signs with the same key.
+type SigningKeyService struct {
+	db db.DBInterface
+
+	mu   sync.RWMutex
+	keys map[int][]signingKey // active keys per gateway, newest first
+	jwks auth.JSONWebKeySet
+}
+
+// NewSigningKeyService creates a new signing key service
+func NewSigningKeyService(dbInterface db.DBInterface) *SigningKeyService {
+	return &SigningKeyService{
+		db:   dbInterface,
+		keys: make(map[int][]signingKey),
+		jwks: auth.JSONWebKeySet{Keys: []auth.JSONWebKey{}},
+	}
+}
+
+// Add stores an RSA or P-256 private key as the active signing key of a gateway. Requests are
+// signed with it from then on. The key ID defaults to the key's RFC 7638 thumbprint and must be
+// unique for the gateway. The private key is stored encrypted and never returned.
+func (s *SigningKeyService) Add(ctx context.Context, gatewayID int, request models.SigningKeyRequest) (*models.SigningKey, error) {
+	signer, err := auth.ParsePrivateKey([]byte(request.PrivateKey))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidSigningKey, err)
+	}
+	algorithm, _ := auth.SigningAlgorithm(signer.Public())
+
+	kid := strings.TrimSpace(request.KeyID)
+	if kid == "" {
+		if kid, err = auth.Thumbprint(signer.Public()); err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidSigningKey, err)
+		}
+	}
+
+	existing, err := s.db.GetSigningKeys(gatewayID)
+	if err != nil {
+		return nil, err
+	}
+	for _, key := range existing {
+		if key.KeyID == kid {
+			return nil, fmt.Errorf("%w: key ID %q is already used", ErrInvalidSigningKey, kid)
+		}
+	}
+
+	publicDER, err := x509.MarshalPKIXPublicKey(signer.Public())
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidSigningKey, err)
+	}
+	encrypted, err := utils.EncryptString(request.PrivateKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encrypt private key: %w", err)
+	}
+
+	record := models.SigningKey{
+		GatewayID:  gatewayID,
+		KeyID:      kid,
+		Algorithm:  algorithm,
+		PublicKey:  string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: publicDER})),
+		PrivateKey: encrypted,
+		Status:     consts.SigningKeyActive,
+		CreatedAt:  time.Now(),
+	}
+
+	id, err := s.db.CreateSigningKey(record)
+	if err != nil {
+		return nil, err
+	}
+	record.ID = id
+	record.PrivateKey = ""
+
+	log.Printf("Added signing key %d (kid %s) to gateway %d", id, kid, gatewayID)
+	if err := s.Refresh(ctx); err != nil {
+		log.Printf("Failed to refresh signing keys: %v", err)
+	}
+
+	return &record, nil
+}
+
+// List returns a gateway's active and retired signing keys without their private keys
+func (s *SigningKeyService) List(ctx context.Context, gatewayID int) ([]models.SigningKey, error) {
+	keys, err := s.db.GetSigningKeys(gatewayID)
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range keys {
+		keys[i].PrivateKey = ""
+	}
+	return keys, nil
+}
+
+// Retire stops signing with a key and removes it from the published key set. The last active
+// key of a gateway cannot be retired, since its requests could then not be signed.
+func (s *SigningKeyService) Retire(ctx context.Context, gatewayID, keyID int) error {
+	keys, err := s.db.GetSigningKeys(gatewayID)
+	if err != nil {
+		return err
+	}
+
+	found, active := false, 0
+	for _, key := range keys {
+		if key.Status != consts.SigningKeyActive {
+			continue
+		}
+		active++
+		if key.ID == keyID {
+			found = true
+		}
+	}
+
+	if !found {
+		return ErrSigningKeyNotFound
+	}
+	if active == 1 {
+		return ErrLastSigningKey
+	}
+
+	if err := s.db.RetireSigningKey(gatewayID, keyID); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrSigningKeyNotFound
+		}
+		return err
+	}
+
+	log.Printf("Retired signing key %d of gateway %d", keyID, gatewayID)
+	if err := s.Refresh(ctx); err != nil {
+		log.Printf("Failed to refresh signing keys: %v", err)
+	}
+	return nil
+}
+
+// Refresh reloads the active signing keys of every gateway and the published key set
+func (s *SigningKeyService) Refresh(ctx context.Context) error {
+	records, err := s.db.GetSigningKeys(0)
+	if err != nil {
+		return fmt.Errorf("failed to fetch signing keys: %w", err)
+	}
+
+	keys := make(map[int][]signingKey)
+	jwks := auth.JSONWebKeySet{Keys: []auth.JSONWebKey{}}
+	// Newest first, so requests are signed with the latest key during a rotation
+	for i := len(records) - 1; i >= 0; i-- {
+		record := records[i]
+		if record.Status != consts.SigningKeyActive {
+			continue
+		}
+
+		signer, err := loadSigningKey(record)
+		if err != nil {
+			log.Printf("Failed to load signing key %d of gateway %d: %v", record.ID, record.GatewayID, err)
+			continue
+		}
+		jwk, err := auth.NewJSONWebKey(record.KeyID, signer.Public())
+		if err != nil {
+			log.Printf("Failed to publish signing key %d of gateway %d: %v", record.ID, record.GatewayID, err)
+			continue
+		}
+
+		keys[record.GatewayID] = append(keys[record.GatewayID], signingKey{kid: record.KeyID, signer: signer})
+		jwks.Keys = append(jwks.Keys, jwk)
+	}
+	sort.SliceStable(jwks.Keys, func(i, j int) bool { return jwks.Keys[i].Kid < jwks.Keys[j].Kid })
+
+	s.mu.Lock()
+	s.keys = keys
+	s.jwks = jwks
+	s.mu.Unlock()
+	return nil
+}
+
+// SignJWT creates a JWT with the given claims, signed with the gateway's newest active key,
+// e.g. a private_key_jwt client assertion for the gateway's token endpoint
+func (s *SigningKeyService) SignJWT(gatewayID int, claims map[string]interface{}) (string, error) {
+	key, err := s.current(gatewayID)
+	if err != nil {
+		return "", err
+	}
+	return auth.Sign(key.signer, key.kid, claims)
+}
+
+// SignDetached signs a request body with the gateway's newest active key, returning a JWS with a
+// detached payload for the gateway's request signature header. Header parameters the gateway
+// requires are added to the protected header.
+func (s *SigningKeyService) SignDetached(gatewayID int, body []byte, header map[string]interface{}) (string, error) {
+	key, err := s.current(gatewayID)
+	if err != nil {
+		return "", err
+	}
+	return auth.SignDetached(key.signer, key.kid, body, header)
+}
+
+// KeySet returns the public keys of every active signing key, for gateways to verify signatures
+// with
+func (s *SigningKeyService) KeySet() auth.JSONWebKeySet {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.jwks
+}
+
+// current returns the newest active signing key of a gateway
+func (s *SigningKeyService) current(gatewayID int) (signingKey, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	keys := s.keys[gatewayID]
+	if len(keys) == 0 {
+		return signingKey{}, ErrNoSigningKey
+	}
+	return keys[0], nil
+}
+
+// loadSigningKey decrypts and parses a stored signing key
+func loadSigningKey(record models.SigningKey) (crypto.Signer, error) {
+	key, err := utils.DecryptString(record.PrivateKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt private key: %w", err)
+	}
+	return auth.ParsePrivateKey([]byte(key))
+}
@@ -0,0 +1,113 @@
+package services
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"payment-gateway/db"
+	"payment-gateway/internal/auth"
+	"payment-gateway/internal/models"
+	"strings"
+	"testing"
+)
+
+// newSigningKeyPEM returns a PKCS #8 PEM P-256 private key
+func newSigningKeyPEM(t *testing.T) string {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+	der, _ := x509.MarshalPKCS8PrivateKey(key)
+	return string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}))
+}
+
+// jwsKeyID returns the kid header of a JWS
+func jwsKeyID(t *testing.T, jws string) string {
+	data, err := base64.RawURLEncoding.DecodeString(strings.Split(jws, ".")[0])
+	if err != nil {
+		t.Fatalf("Failed to decode JWS header: %v", err)
+	}
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	json.Unmarshal(data, &header)
+	if header.Alg != auth.AlgES256 {
+		t.Errorf("Expected ES256, got %s", header.Alg)
+	}
+	return header.Kid
+}
+
+// TestSigningKeyRotation tests that requests are signed with the newest key, named by its kid,
+// and that active keys are published until they are retired
+func TestSigningKeyRotation(t *testing.T) {
+	service := NewSigningKeyService(db.NewMockDB())
+	ctx := context.Background()
+
+	if _, err := service.SignJWT(1, map[string]interface{}{"iss": "pgw"}); !errors.Is(err, ErrNoSigningKey) {
+		t.Errorf("Expected ErrNoSigningKey, got: %v", err)
+	}
+	if _, err := service.Add(ctx, 1, models.SigningKeyRequest{PrivateKey: "not a key"}); !errors.Is(err, ErrInvalidSigningKey) {
+		t.Errorf("Expected ErrInvalidSigningKey, got: %v", err)
+	}
+
+	old, err := service.Add(ctx, 1, models.SigningKeyRequest{PrivateKey: newSigningKeyPEM(t)})
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	if len(old.KeyID) != 43 || old.Algorithm != auth.AlgES256 || !strings.Contains(old.PublicKey, "PUBLIC KEY") {
+		t.Errorf("Expected an ES256 key identified by its thumbprint, got %+v", old)
+	}
+
+	replacement, err := service.Add(ctx, 1, models.SigningKeyRequest{KeyID: "2026-10", PrivateKey: newSigningKeyPEM(t)})
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	if _, err := service.Add(ctx, 1, models.SigningKeyRequest{KeyID: "2026-10", PrivateKey: newSigningKeyPEM(t)}); !errors.Is(err, ErrInvalidSigningKey) {
+		t.Errorf("Expected a reused key ID to be refused, got: %v", err)
+	}
+
+	keys, err := service.List(ctx, 1)
+	if err != nil || len(keys) != 2 || keys[0].PrivateKey != "" || keys[1].PrivateKey != "" {
+		t.Errorf("Expected two listed keys without their private keys, got %+v (%v)", keys, err)
+	}
+
+	token, err := service.SignJWT(1, map[string]interface{}{"iss": "pgw", "aud": "https://bank.example.com/token"})
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	if kid := jwsKeyID(t, token); kid != "2026-10" {
+		t.Errorf("Expected the newest key to sign, got kid %q", kid)
+	}
+	if set := service.KeySet(); len(set.Keys) != 2 {
+		t.Errorf("Expected both active keys to be published, got %+v", set)
+	}
+
+	// Rolling back a replacement the gateway has not picked up yet
+	if err := service.Retire(ctx, 1, replacement.ID); err != nil {
+		t.Fatalf("Failed to retire key: %v", err)
+	}
+	signature, err := service.SignDetached(1, []byte(`{"amount":"10.00"}`), nil)
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	if kid := jwsKeyID(t, signature); kid != old.KeyID || !strings.Contains(signature, "..") {
+		t.Errorf("Expected a detached signature with the remaining key, got %q", signature)
+	}
+	if set := service.KeySet(); len(set.Keys) != 1 || set.Keys[0].Kid != old.KeyID {
+		t.Errorf("Expected only the remaining key to be published, got %+v", set)
+	}
+
+	if err := service.Retire(ctx, 1, old.ID); !errors.Is(err, ErrLastSigningKey) {
+		t.Errorf("Expected ErrLastSigningKey, got: %v", err)
+	}
+	if err := service.Retire(ctx, 1, replacement.ID); !errors.Is(err, ErrSigningKeyNotFound) {
+		t.Errorf("Expected ErrSigningKeyNotFound for a retired key, got: %v", err)
+	}
+}
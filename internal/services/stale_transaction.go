@@ -0,0 +1,112 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"payment-gateway/internal/consts"
+	"payment-gateway/internal/events"
+	"payment-gateway/internal/gateway"
+	"payment-gateway/internal/models"
+	"strconv"
+	"time"
+)
+
+// StaleTransactionPolicy controls when transactions whose gateway never reported an outcome are
+// expired
+type StaleTransactionPolicy struct {
+	TTL          time.Duration // how long a transaction can stay pending or processing unchanged; zero disables expiry
+	QueryGateway bool          // ask gateways that can be asked for the status first, applying any outcome they report
+}
+
+// DefaultStaleTransactionPolicy returns the policy stale transactions are expired under unless
+// configured otherwise
+func DefaultStaleTransactionPolicy() StaleTransactionPolicy {
+	return StaleTransactionPolicy{TTL: consts.DefaultStaleTransactionTTL, QueryGateway: true}
+}
+
+// SetStaleTransactionPolicy configures when transactions left pending or processing are expired
+func (s *TransactionService) SetStaleTransactionPolicy(policy StaleTransactionPolicy) {
+	s.stalePolicy = policy
+}
+
+// ExpireStaleTransactions fails transactions still pending or processing that haven't changed for
+// the stale transaction TTL, returning how many were failed. Gateways that can be asked for a
+// transaction's status are asked first when the policy says so, and an outcome they report is
+// applied like a callback instead. Transactions are failed with the timeout decline code, unless a
+// callback settled them meanwhile.
+func (s *TransactionService) ExpireStaleTransactions(ctx context.Context) (int, error) {
+	policy := s.stalePolicy
+	if policy.TTL <= 0 {
+		return 0, nil
+	}
+
+	changedBefore := time.Now().Add(-policy.TTL)
+	ids, err := s.db.GetStaleTransactionIDs(changedBefore, consts.StaleTransactionBatchSize)
+	if err != nil {
+		return 0, fmt.Errorf("failed to get stale transactions: %w", err)
+	}
+
+	message := fmt.Sprintf("no outcome reported by the gateway within %s", policy.TTL)
+	expired := 0
+	for _, id := range ids {
+		if err := ctx.Err(); err != nil {
+			return expired, err
+		}
+		tx, err := s.db.GetTransactionByID(id)
+		if err != nil {
+			continue
+		}
+
+		if policy.QueryGateway && s.settleStaleTransaction(ctx, *tx) {
+			continue
+		}
+
+		if err := s.db.ExpireStaleTransaction(id, changedBefore, message, consts.DeclineTimeout); err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				continue
+			}
+			return expired, fmt.Errorf("failed to expire transaction %d: %w", id, err)
+		}
+
+		log.Printf("Transaction %d: %s %s", id, tx.Type, message)
+		if expiredTx, err := s.db.GetTransactionByID(id); err == nil {
+			s.emit(events.TransactionEvent{Type: events.TransactionStatusChanged, Transaction: *expiredTx})
+		} else {
+			s.publishStatus(models.Transaction{ID: id}, consts.Failed, message)
+		}
+		expired++
+	}
+	return expired, nil
+}
+
+// settleStaleTransaction asks a stale transaction's gateway for its status, through the status
+// query cache shared with lookups, and reports whether the gateway settled it. Transactions the
+// gateway was never sent, or can't be asked about, are left as they are.
+func (s *TransactionService) settleStaleTransaction(ctx context.Context, tx models.Transaction) bool {
+	if tx.GatewayReference == "" {
+		return false
+	}
+	provider, err := s.gatewaySelector.GetProviderByID(strconv.Itoa(tx.GatewayID))
+	if err != nil {
+		return false
+	}
+	querier, ok := provider.(gateway.StatusQueryProvider)
+	if !ok {
+		return false
+	}
+
+	if err := s.statusRefreshes.do(ctx, tx.ID, func() error { return s.refreshStatus(querier, tx) }); err != nil {
+		log.Printf("Failed to query the status of stale transaction %d at %s: %v", tx.ID, provider.Name(), err)
+		return false
+	}
+	settled, err := s.db.GetTransactionByID(tx.ID)
+	if err != nil || (settled.Status != consts.Completed && settled.Status != consts.Failed) {
+		return false
+	}
+
+	log.Printf("Transaction %d: %s reported it %s when queried before expiry", tx.ID, provider.Name(), settled.Status)
+	return true
+}
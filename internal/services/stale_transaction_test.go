@@ -0,0 +1,79 @@
+package services
+
+import (
+	"context"
+	"payment-gateway/internal/consts"
+	"payment-gateway/internal/models"
+	"testing"
+	"time"
+)
+
+// TestExpireStaleTransactions tests that transactions left in flight past the TTL are failed,
+// unless their gateway reports an outcome when asked first
+func TestExpireStaleTransactions(t *testing.T) {
+	service, mockDB, provider, userID := setupStatusQueries(t)
+	service.SetStaleTransactionPolicy(StaleTransactionPolicy{TTL: time.Hour, QueryGateway: true})
+	ctx := context.Background()
+
+	stale := time.Now().Add(-2 * time.Hour)
+	create := func(tx models.Transaction) int {
+		tx.Amount, tx.Currency, tx.Type, tx.UserID, tx.GatewayID = 20, "USD", consts.Deposit, userID, 2
+		id, err := mockDB.CreateTransaction(tx)
+		if err != nil {
+			t.Fatalf("Expected no error, got: %v", err)
+		}
+		return id
+	}
+
+	// The gateway settles a stale transaction it is asked about
+	provider.set(consts.Completed, nil)
+	settledID := create(models.Transaction{Status: consts.Processing, GatewayReference: "pi_settled", CreatedAt: stale})
+	if expired, err := service.ExpireStaleTransactions(ctx); err != nil || expired != 0 {
+		t.Fatalf("Expected nothing expired, got %d (%v)", expired, err)
+	}
+	if tx, _ := mockDB.GetTransactionByID(settledID); tx.Status != consts.Completed {
+		t.Errorf("Expected the gateway's outcome applied, got %s", tx.Status)
+	}
+
+	// Transactions the gateway still reports in flight, or was never sent, are failed; recent or
+	// recently changed ones are left alone
+	provider.set(consts.Processing, nil)
+	inFlightID := create(models.Transaction{Status: consts.Processing, GatewayReference: "pi_in_flight", CreatedAt: stale})
+	unsentID := create(models.Transaction{Status: consts.Pending, CreatedAt: stale})
+	recentID := create(models.Transaction{Status: consts.Processing, GatewayReference: "pi_recent"})
+	changedID := create(models.Transaction{Status: consts.Processing, GatewayReference: "pi_changed", CreatedAt: stale, UpdatedAt: time.Now()})
+
+	if expired, err := service.ExpireStaleTransactions(ctx); err != nil || expired != 2 {
+		t.Fatalf("Expected 2 transactions expired, got %d (%v)", expired, err)
+	}
+	for _, id := range []int{inFlightID, unsentID} {
+		tx, _ := mockDB.GetTransactionByID(id)
+		if tx.Status != consts.Failed || tx.DeclineCode != consts.DeclineTimeout || tx.ErrorMessage == "" {
+			t.Errorf("Expected transaction %d failed with a timeout, got %+v", id, tx)
+		}
+	}
+	for _, id := range []int{recentID, changedID} {
+		if tx, _ := mockDB.GetTransactionByID(id); tx.Status != consts.Processing {
+			t.Errorf("Expected transaction %d left processing, got %s", id, tx.Status)
+		}
+	}
+
+	// Gateways aren't asked when the policy says so
+	provider.set(consts.Completed, nil)
+	service.SetStaleTransactionPolicy(StaleTransactionPolicy{TTL: time.Hour})
+	queries := provider.queries.Load()
+	unqueriedID := create(models.Transaction{Status: consts.Processing, GatewayReference: "pi_unqueried", CreatedAt: stale})
+	if expired, err := service.ExpireStaleTransactions(ctx); err != nil || expired != 1 || provider.queries.Load() != queries {
+		t.Fatalf("Expected 1 transaction expired without a query, got %d (%v)", expired, err)
+	}
+	if tx, _ := mockDB.GetTransactionByID(unqueriedID); tx.Status != consts.Failed {
+		t.Errorf("Expected the transaction failed, got %s", tx.Status)
+	}
+
+	// A TTL of zero disables expiry
+	service.SetStaleTransactionPolicy(StaleTransactionPolicy{})
+	create(models.Transaction{Status: consts.Processing, CreatedAt: stale})
+	if expired, err := service.ExpireStaleTransactions(ctx); err != nil || expired != 0 {
+		t.Errorf("Expected nothing expired without a TTL, got %d (%v)", expired, err)
+	}
+}
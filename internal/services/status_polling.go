@@ -0,0 +1,94 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"payment-gateway/internal/consts"
+	"payment-gateway/internal/gateway"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// statusPollCursors are where each gateway's next status poll resumes, so gateways with more
+// transactions in flight than a batch have all of them polled in turn
+type statusPollCursors struct {
+	mu       sync.Mutex
+	afterIDs map[int]int
+}
+
+// next returns the ID a gateway's next poll starts after
+func (c *statusPollCursors) next(gatewayID int) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.afterIDs[gatewayID]
+}
+
+// advance records the last ID a gateway's poll reached, or starts its next poll over when the
+// poll reached the end
+func (c *statusPollCursors) advance(gatewayID int, ids []int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.afterIDs == nil {
+		c.afterIDs = make(map[int]int)
+	}
+	if len(ids) < consts.StatusPollBatchSize {
+		delete(c.afterIDs, gatewayID)
+		return
+	}
+	c.afterIDs[gatewayID] = ids[len(ids)-1]
+}
+
+// PollTransactionStatuses asks gateways whose callbacks can't be relied on, such as UPI PSPs, for
+// the status of their transactions in flight, returning how many were settled. Transactions are
+// polled once the gateway's poll delay passed, until their payment window and
+// consts.PaymentExpiryGrace closed or, at gateways without one, for consts.StatusPollMaxAge.
+// Outcomes are applied like callbacks reporting them, through the status query cache shared with
+// lookups.
+func (s *TransactionService) PollTransactionStatuses(ctx context.Context) (int, error) {
+	now := time.Now()
+	settled := 0
+
+	for _, provider := range s.gatewaySelector.Providers() {
+		delay := gateway.StatusPollDelay(provider)
+		if delay <= 0 {
+			continue
+		}
+		querier := provider.(gateway.StatusQueryProvider)
+		gatewayID, err := strconv.Atoi(provider.ID())
+		if err != nil {
+			continue
+		}
+
+		maxAge := consts.StatusPollMaxAge
+		if window := gateway.PaymentExpiry(provider); window > 0 {
+			maxAge = window + consts.PaymentExpiryGrace
+		}
+		ids, err := s.db.GetInFlightTransactionIDs(gatewayID, now.Add(-maxAge), now.Add(-delay), s.statusPolls.next(gatewayID), consts.StatusPollBatchSize)
+		if err != nil {
+			return settled, fmt.Errorf("failed to get transactions in flight at gateway %d: %w", gatewayID, err)
+		}
+		s.statusPolls.advance(gatewayID, ids)
+
+		for _, id := range ids {
+			if err := ctx.Err(); err != nil {
+				return settled, err
+			}
+			tx, err := s.db.GetTransactionByID(id)
+			if err != nil {
+				continue
+			}
+
+			if err := s.statusRefreshes.do(ctx, id, func() error { return s.refreshStatus(querier, *tx) }); err != nil {
+				log.Printf("Failed to poll the status of transaction %d at %s: %v", id, provider.Name(), err)
+				continue
+			}
+			if polled, err := s.db.GetTransactionByID(id); err == nil && (polled.Status == consts.Completed || polled.Status == consts.Failed) {
+				log.Printf("Transaction %d: %s reported it %s when polled", id, provider.Name(), polled.Status)
+				settled++
+			}
+		}
+	}
+	return settled, nil
+}
@@ -0,0 +1,99 @@
+package services
+
+import (
+	"context"
+	"payment-gateway/db"
+	"payment-gateway/internal/consts"
+	"payment-gateway/internal/gateway"
+	"payment-gateway/internal/models"
+	"testing"
+	"time"
+)
+
+// mockPollingProvider is a mock gateway whose transactions are polled a minute after submission
+// until their 15 minute payment window closed
+type mockPollingProvider struct {
+	*mockStatusProvider
+}
+
+func (p *mockPollingProvider) StatusPollDelay() time.Duration {
+	return time.Minute
+}
+
+func (p *mockPollingProvider) PaymentExpiry() time.Duration {
+	return 15 * time.Minute
+}
+
+// TestPollTransactionStatuses tests that transactions in flight at a gateway whose callbacks can't
+// be relied on are polled once its delay passed and until their window closed, with outcomes
+// applied like callbacks, leaving other transactions and gateways alone
+func TestPollTransactionStatuses(t *testing.T) {
+	mockDB := db.NewMockDB()
+	selector := gateway.NewSelector(mockDB)
+	queried := &mockStatusProvider{MockProvider: gateway.NewMockProvider(2, "Stripe", "application/json", 1.0, 0), status: consts.Completed}
+	polled := &mockPollingProvider{&mockStatusProvider{MockProvider: gateway.NewMockProvider(11, "UPI", "application/json", 1.0, 0), status: consts.Processing}}
+	selector.RegisterProvider(queried)
+	selector.RegisterProvider(polled)
+	service := NewTransactionService(mockDB, selector)
+	service.SetStatusQueryCacheTTL(time.Millisecond)
+
+	userID, err := mockDB.CreateUser(models.User{Username: "alice", Email: "alice@example.com", CountryID: 1, MerchantID: 1})
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	create := func(gatewayID int, status, reference string, age time.Duration) int {
+		id, err := mockDB.CreateTransaction(models.Transaction{Amount: 500, Currency: "INR", Type: consts.Deposit, Status: status, UserID: userID,
+			GatewayID: gatewayID, GatewayReference: reference, CreatedAt: time.Now().Add(-age)})
+		if err != nil {
+			t.Fatalf("Expected no error, got: %v", err)
+		}
+		return id
+	}
+	inFlight := create(11, consts.Processing, "PG01", 5*time.Minute)
+	recent := create(11, consts.Processing, "PG02", 10*time.Second)
+	windowClosed := create(11, consts.Processing, "PG03", time.Hour)
+	unsubmitted := create(11, consts.Pending, "", 5*time.Minute)
+	settled := create(11, consts.Failed, "PG04", 5*time.Minute)
+	otherGateway := create(2, consts.Processing, "pi_123", 5*time.Minute)
+
+	// Payments still pending leave their transactions processing
+	if count, err := service.PollTransactionStatuses(context.Background()); err != nil || count != 0 {
+		t.Fatalf("Expected nothing settled while pending, got %d (%v)", count, err)
+	}
+	if queries := polled.queries.Load(); queries != 1 {
+		t.Errorf("Expected only the transaction in flight polled, got %d queries", queries)
+	}
+
+	time.Sleep(10 * time.Millisecond)
+	polled.set(consts.Completed, nil)
+	if count, err := service.PollTransactionStatuses(context.Background()); err != nil || count != 1 {
+		t.Fatalf("Expected 1 transaction settled, got %d (%v)", count, err)
+	}
+	if tx, _ := mockDB.GetTransactionByID(inFlight); tx.Status != consts.Completed {
+		t.Errorf("Expected the polled transaction completed, got %+v", tx)
+	}
+	untouched := []struct {
+		name   string
+		id     int
+		status string
+	}{
+		{"recent", recent, consts.Processing},
+		{"window closed", windowClosed, consts.Processing},
+		{"unsubmitted", unsubmitted, consts.Pending},
+		{"settled", settled, consts.Failed},
+		{"other gateway", otherGateway, consts.Processing},
+	}
+	for _, tt := range untouched {
+		if tx, _ := mockDB.GetTransactionByID(tt.id); tx.Status != tt.status {
+			t.Errorf("Expected the %s transaction left alone, got %+v", tt.name, tx)
+		}
+	}
+	if queries := queried.queries.Load(); queries != 0 {
+		t.Errorf("Expected gateways that don't poll left alone, got %d queries", queries)
+	}
+
+	messages, _ := mockDB.GetPendingOutboxMessages(consts.OutboxMerchantWebhook, time.Now(), consts.OutboxBatchSize)
+	if len(messages) != 1 || messages[0].TransactionID != inFlight {
+		t.Errorf("Expected a webhook announcing the completed transaction, got %+v", messages)
+	}
+}
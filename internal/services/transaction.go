@@ -2,24 +2,65 @@ package services
 
 import (
 	"context"
-	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"payment-gateway/db"
 	"payment-gateway/internal/consts"
+	"payment-gateway/internal/events"
 	"payment-gateway/internal/gateway"
-	"payment-gateway/internal/kafka"
+	"payment-gateway/internal/geo"
 	"payment-gateway/internal/models"
+	"payment-gateway/internal/money"
+	"payment-gateway/internal/reference"
 	"payment-gateway/internal/utils"
+	"payment-gateway/internal/validation"
 	"strconv"
 	"time"
 )
 
+// ErrInsufficientBalance is returned for a withdrawal the user's wallet balance does not cover
+var ErrInsufficientBalance = errors.New("wallet balance does not cover the withdrawal")
+
 // TransactionService handles transaction processing
 type TransactionService struct {
 	db              db.DBInterface
 	gatewaySelector gateway.SelectorInterface
 	circuitBreaker  *utils.CircuitBreaker
+	operations      *OperationService
+	calendar        *BankingCalendar
+	recoveryHints   *RecoveryHints
+	events          *events.Bus
+	binTable        geo.BINTable
+	references      *reference.Generator
+	mandateRefs     *reference.Generator // unique mandate references of SEPA mandates
+	rounder         *money.Rounder
+	warehouseExport bool
+	gatewayObserver GatewayObserver
+
+	liveTransactions bool // livemode merchants may send transactions to production environments
+
+	openBankingRedirectURI string // consent callback banks send customers back to
+	bankAccountRedirectURI string // bank account callback banks send users back to after linking
+
+	amlReportingEntityID string // registration with the financial intelligence unit, written into AML reports
+
+	screening *ScreeningService
+
+	merchantCertificates *MerchantCertificateService // keys wallet payment tokens are decrypted with
+
+	transferLimits TransferLimits
+	reloadLimits   AutoReloadLimits
+
+	statusRefreshes *statusRefreshes // gateway status queries of transactions looked up while in flight
+	statusPolls     statusPollCursors
+	stalePolicy     StaleTransactionPolicy
+}
+
+// ValidateTransactionRequest checks a transaction request against its validate tags, returning
+// validation.Errors listing every invalid field
+func ValidateTransactionRequest(req models.TransactionRequest) error {
+	return validation.Struct(req)
 }
 
 // NewTransactionService creates a new transaction service
@@ -28,163 +69,524 @@ func NewTransactionService(dbInterface db.DBInterface, selector gateway.Selector
 		db:              dbInterface,
 		gatewaySelector: selector,
 		circuitBreaker:  utils.NewCircuitBreaker(),
+		operations:      NewOperationService(dbInterface),
+		calendar:        NewBankingCalendar(dbInterface),
+		recoveryHints:   NewRecoveryHints(dbInterface),
+		events:          events.NewBus(),
+		references:      reference.MustGenerator(consts.DefaultReferencePrefix),
+		mandateRefs:     reference.MustGenerator(consts.MandateReferencePrefix),
+		rounder:         money.NewRounder(),
+		transferLimits:  DefaultTransferLimits(),
+		reloadLimits:    DefaultAutoReloadLimits(),
+		statusRefreshes: newStatusRefreshes(consts.DefaultStatusQueryCacheTTL),
+		stalePolicy:     DefaultStaleTransactionPolicy(),
+
+		merchantCertificates: NewMerchantCertificateService(dbInterface),
 	}
 }
 
+// SetReferenceGenerator configures the generator of transaction references
+func (s *TransactionService) SetReferenceGenerator(generator *reference.Generator) {
+	s.references = generator
+}
+
+// SetRounder configures how amounts are rounded to their currency's minor unit
+func (s *TransactionService) SetRounder(rounder *money.Rounder) {
+	s.rounder = rounder
+}
+
+// SetScreening screens the beneficiaries of withdrawals reaching the AML threshold against
+// sanctions lists
+func (s *TransactionService) SetScreening(screening *ScreeningService) {
+	s.screening = screening
+}
+
+// SetWarehouseExport records completed transactions in the outbox for a WarehouseExporter
+func (s *TransactionService) SetWarehouseExport(enabled bool) {
+	s.warehouseExport = enabled
+}
+
+// SetGatewayObserver reports the outcome and latency of every gateway call to observer
+func (s *TransactionService) SetGatewayObserver(observer GatewayObserver) {
+	s.gatewayObserver = observer
+}
+
+// Events returns the bus on which transaction lifecycle events are published
+func (s *TransactionService) Events() *events.Bus {
+	return s.events
+}
+
+// Operations returns the service tracking long-running operations
+func (s *TransactionService) Operations() *OperationService {
+	return s.operations
+}
+
+// Calendar returns the banking calendar settlement dates and payouts are computed with
+func (s *TransactionService) Calendar() *BankingCalendar {
+	return s.calendar
+}
+
+// RecoveryHints returns the mapping of decline codes to the recovery hints declines are reported with
+func (s *TransactionService) RecoveryHints() *RecoveryHints {
+	return s.recoveryHints
+}
+
 // ProcessDeposit handles deposit request
 func (s *TransactionService) ProcessDeposit(ctx context.Context, req models.TransactionRequest) (*models.TransactionResponse, error) {
+	return s.processTransaction(ctx, consts.Deposit, req)
+}
+
+// ProcessWithdrawal handles withdrawal request
+func (s *TransactionService) ProcessWithdrawal(ctx context.Context, req models.TransactionRequest) (*models.TransactionResponse, error) {
+	return s.processTransaction(ctx, consts.Withdrawal, req)
+}
+
+// attemptResult is the outcome of submitting a transaction to one gateway
+type attemptResult struct {
+	response    *models.TransactionResponse
+	transaction models.Transaction
+	decline     *gateway.DeclineError
+}
+
+// processTransaction submits a deposit or withdrawal and retries soft declines on an alternate gateway
+func (s *TransactionService) processTransaction(ctx context.Context, txType string, req models.TransactionRequest) (*models.TransactionResponse, error) {
+	// Amounts are rounded to their currency's minor unit before any check or gateway sees them
+	if err := s.roundAmount(&req); err != nil {
+		return nil, err
+	}
+
 	// Get user information
 	user, err := s.db.GetUserByID(req.UserID)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get user: %w", err)
 	}
 
-	// Select appropriate gateway
-	provider, err := s.gatewaySelector.SelectGateway(ctx, user.CountryID, "deposit")
-	if err != nil {
-		return nil, fmt.Errorf("failed to select gateway: %w", err)
+	// Users whose name matched a sanctions list cannot transact until the match is cleared
+	if err := screeningHold(user); err != nil {
+		return nil, err
 	}
 
-	// Create transaction record
-	transaction := models.Transaction{
-		Amount:    req.Amount,
-		Currency:  req.Currency,
-		Type:      consts.Deposit,
-		Status:    consts.Pending,
-		UserID:    user.ID,
-		GatewayID: atoi(provider.ID()),
-		CountryID: user.CountryID,
-		CreatedAt: time.Now(),
-	}
-
-	// Save transaction to database
-	txID, err := s.db.CreateTransaction(transaction)
-	if err != nil {
-		return nil, fmt.Errorf("failed to create transaction: %w", err)
+	// Validate return and cancel URLs against the merchant allowlist
+	if err := s.validateRedirectURLs(user, req); err != nil {
+		return nil, err
 	}
-	transaction.ID = txID
 
-	// Execute gateway processing with circuit breaker and retry mechanism
-	var response *models.TransactionResponse
+	if err := validateSCAExemption(txType, req); err != nil {
+		return nil, err
+	}
 
-	operation := func() error {
-		var processingErr error
-		response, processingErr = provider.ProcessDeposit(ctx, transaction)
-		if processingErr != nil {
-			return fmt.Errorf("gateway processing failed: %w", processingErr)
+	if err := validateBankPayment(txType, req); err != nil {
+		return nil, err
+	}
+
+	if err := validateBankPayout(txType, req); err != nil {
+		return nil, err
+	}
+
+	if err := validateMandate(req); err != nil {
+		return nil, err
+	}
+
+	if err := validateUPIPayment(txType, req); err != nil {
+		return nil, err
+	}
+
+	if err := validateWalletToken(txType, req); err != nil {
+		return nil, err
+	}
+
+	if err := validateAuthorization(txType, req); err != nil {
+		return nil, err
+	}
+
+	// Resolve the country from the request's signals, flagging any that disagree
+	country, err := s.resolveCountry(ctx, user, req)
+	if err != nil {
+		return nil, err
+	}
+
+	// Mobile-money wallet numbers must be valid in the transaction country
+	var walletE164 string
+	if req.PhoneNumber != "" {
+		if walletE164, err = s.normalizeWalletNumber(country, req.PhoneNumber); err != nil {
+			return nil, err
 		}
+	}
+
+	// Some countries require extra fields (a CPF in Brazil, a tax ID in Turkey) passed to the gateway
+	if err := s.validateComplianceFields(txType, country.countryID, req.ComplianceFields); err != nil {
+		return nil, err
+	}
 
-		// Save gateway reference ID if provided
-		if response != nil && response.TransactionID > 0 {
-			// Update transaction with reference ID if available
-			if response.RedirectURL != "" {
-				s.db.UpdateTransactionReference(transaction.ID, response.RedirectURL)
+	// Transactions reaching the country's AML threshold must name their originator and beneficiary
+	threshold, err := s.amlThreshold(country.countryID, req)
+	if err != nil {
+		return nil, err
+	}
+	if threshold != nil {
+		if err := validateTravelRule(threshold, req.TravelRule); err != nil {
+			return nil, err
+		}
+		if txType == consts.Withdrawal {
+			if err := s.screening.screenWithdrawal(ctx, user, req.TravelRule.BeneficiaryName, req.Amount, req.Currency); err != nil {
+				return nil, err
 			}
 		}
+	}
 
-		return nil
+	// Merchant routing rules apply after the request's own routing controls
+	opts := selectionOptions(req)
+	if err := s.applyRoutingRules(&opts, txType, user, country, req); err != nil {
+		return nil, err
 	}
 
-	// Execute with circuit breaker
-	err = s.circuitBreaker.ExecuteWithCircuitBreaker(provider.ID(), operation)
+	// Deposits paid from a bank go to the open banking gateway offering it
+	if err := s.applyBankPayment(ctx, &opts, req); err != nil {
+		return nil, err
+	}
 
-	if err != nil {
-		// Mark gateway as unhealthy
-		s.gatewaySelector.MarkGatewayDown(provider.ID())
+	// Withdrawals to a linked bank account go to the gateway it was linked through
+	if err := s.applyBankPayout(&opts, user, req); err != nil {
+		return nil, err
+	}
 
-		// Update transaction to failed status
-		s.db.UpdateTransactionStatus(transaction.ID, "failed", err.Error())
+	// Transactions under a SEPA mandate go to the gateway it was recorded for
+	if err := s.applyMandate(&opts, user, req); err != nil {
+		return nil, err
+	}
 
+	// Deposits collected from a VPA go to a UPI gateway
+	if err := s.applyUPIPayment(&opts, req); err != nil {
 		return nil, err
 	}
 
-	// Update transaction status to processing
-	s.db.UpdateTransactionStatus(transaction.ID, "processing", "")
+	// Deposits paid with a wallet token go to gateways taking the token or the card inside it
+	if err := s.applyWalletToken(&opts, req); err != nil {
+		return nil, err
+	}
 
-	// Queue transaction for Kafka processing
-	go s.queueTransaction(transaction, provider.DataFormat())
+	// Deposits to capture later go to gateways that can hold their amount
+	if err := s.applyAuthorization(&opts, req); err != nil {
+		return nil, err
+	}
 
-	return response, nil
+	// Withdrawals of merchants with a payout schedule wait for the next payout
+	if txType == consts.Withdrawal {
+		scheduledFor, err := s.scheduledPayout(user, country)
+		if err != nil {
+			return nil, err
+		}
+		if !scheduledFor.IsZero() {
+			response, err := s.schedule(ctx, user, country, walletE164, req, opts, scheduledFor)
+			if err == nil && threshold != nil {
+				s.openAMLCase(txType, user, country, req, threshold, response)
+			}
+			return response, err
+		}
+	}
+
+	result, err := s.attempt(ctx, txType, user, country, walletE164, req, opts, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	// Soft declines (issuer unavailable, timeouts) may succeed elsewhere; hard declines never are retried
+	for retries := 0; result.decline != nil && result.decline.Soft() && retries < consts.MaxSoftDeclineRetries; retries++ {
+		opts.PreferredGatewayID = ""
+		opts.ExcludedGatewayIDs = append(opts.ExcludedGatewayIDs, strconv.Itoa(result.transaction.GatewayID))
+
+		retry, err := s.attempt(ctx, txType, user, country, walletE164, req, opts, result.transaction.ID)
+		if err != nil {
+			log.Printf("Retry of transaction %d after %s decline failed: %v", result.transaction.ID, result.decline.Code, err)
+			break
+		}
+		result = retry
+	}
+
+	// Declined transactions moved no funds and need no report
+	if threshold != nil && result.response.Status != consts.Failed {
+		s.openAMLCase(txType, user, country, req, threshold, result.response)
+	}
+
+	return result.response, nil
 }
 
-// ProcessWithdrawal handles withdrawal request
-func (s *TransactionService) ProcessWithdrawal(ctx context.Context, req models.TransactionRequest) (*models.TransactionResponse, error) {
-	// Get user information
-	user, err := s.db.GetUserByID(req.UserID)
+// attempt creates a transaction and submits it to the gateway selected with opts. walletE164 is
+// the normalized req.PhoneNumber, and retryOfID links the transaction to the one whose soft
+// decline it retries. Declines are reported in the result rather than as an error.
+func (s *TransactionService) attempt(ctx context.Context, txType string, user *models.User, country *countryResolution, walletE164 string, req models.TransactionRequest, opts gateway.SelectionOptions, retryOfID int) (*attemptResult, error) {
+	provider, transaction, err := s.create(ctx, txType, user, country, walletE164, req, opts, retryOfID, time.Time{})
 	if err != nil {
-		return nil, fmt.Errorf("failed to get user: %w", err)
+		return nil, err
+	}
+	return s.submit(ctx, provider, transaction)
+}
+
+// schedule creates a withdrawal that waits for the merchant's payout at scheduledFor. The gateway
+// is selected now and confirmed again when the payout runs.
+func (s *TransactionService) schedule(ctx context.Context, user *models.User, country *countryResolution, walletE164 string, req models.TransactionRequest, opts gateway.SelectionOptions, scheduledFor time.Time) (*models.TransactionResponse, error) {
+	_, transaction, err := s.create(ctx, consts.Withdrawal, user, country, walletE164, req, opts, 0, scheduledFor)
+	if err != nil {
+		return nil, err
+	}
+
+	return &models.TransactionResponse{
+		Status:                 consts.Scheduled,
+		TransactionID:          transaction.ID,
+		ReferenceID:            transaction.ReferenceID,
+		Message:                fmt.Sprintf("Withdrawal scheduled for payout at %s", scheduledFor.Format(time.RFC3339)),
+		ScheduledFor:           &transaction.ScheduledFor,
+		ExpectedSettlementDate: transaction.ExpectedSettlementDate,
+	}, nil
+}
+
+// create selects a gateway with opts and records the transaction and its routing decision. The
+// transaction is pending, or scheduled when scheduledFor is set.
+func (s *TransactionService) create(ctx context.Context, txType string, user *models.User, country *countryResolution, walletE164 string, req models.TransactionRequest, opts gateway.SelectionOptions, retryOfID int, scheduledFor time.Time) (gateway.Provider, models.Transaction, error) {
+	// The amount recorded must be exactly the one gateways are sent in minor units
+	if !money.IsRounded(req.Amount, req.Currency) {
+		return nil, models.Transaction{}, fmt.Errorf("%w: %v %s is not a whole number of minor units", ErrRoundingLeak, req.Amount, req.Currency)
+	}
+
+	// Transactions of livemode merchants go to gateways' production environments
+	livemode, err := s.livemode(user)
+	if err != nil {
+		return nil, models.Transaction{}, err
 	}
 
 	// Select appropriate gateway
-	provider, err := s.gatewaySelector.SelectGateway(ctx, user.CountryID, "withdrawal")
+	provider, decision, err := s.gatewaySelector.SelectGatewayWithOptions(ctx, country.countryID, txType, opts)
+	if err != nil {
+		return nil, models.Transaction{}, fmt.Errorf("failed to select gateway: %w", err)
+	}
+
+	// References are assigned before the gateway call so providers can pass them on
+	ref, err := s.references.New()
 	if err != nil {
-		return nil, fmt.Errorf("failed to select gateway: %w", err)
+		return nil, models.Transaction{}, err
 	}
 
 	// Create transaction record
 	transaction := models.Transaction{
-		Amount:    req.Amount,
-		Currency:  req.Currency,
-		Type:      consts.Withdrawal,
-		Status:    consts.Pending,
-		UserID:    user.ID,
-		GatewayID: atoi(provider.ID()),
-		CountryID: user.CountryID,
-		CreatedAt: time.Now(),
-	}
-
-	// Save transaction to database
-	txID, err := s.db.CreateTransaction(transaction)
+		ReferenceID:              ref,
+		Amount:                   req.Amount,
+		Currency:                 req.Currency,
+		Type:                     txType,
+		Status:                   consts.Pending,
+		UserID:                   user.ID,
+		GatewayID:                atoi(provider.ID()),
+		CountryID:                country.countryID,
+		CountrySource:            country.source,
+		RiskFlags:                country.flags,
+		PhoneNumber:              req.PhoneNumber,
+		PhoneE164:                walletE164,
+		ReturnURL:                req.ReturnURL,
+		CancelURL:                req.CancelURL,
+		CardBIN:                  req.CardBIN,
+		SCAExemption:             req.SCAExemption,
+		BankID:                   req.BankID,
+		PayerVPA:                 req.VPA,
+		ManualCapture:            req.ManualCapture,
+		IncrementalAuthorization: req.IncrementalAuthorization,
+		Livemode:                 livemode,
+		RetryOfID:                retryOfID,
+		ScheduledFor:             scheduledFor,
+		CreatedAt:                time.Now(),
+	}
+	if txType == consts.Withdrawal {
+		transaction.Beneficiary = req.Beneficiary
+		transaction.BankAccountID = req.BankAccountID
+	}
+	transaction.MandateID = req.MandateID
+	transaction.ComplianceFields = req.ComplianceFields
+	if !scheduledFor.IsZero() {
+		transaction.Status = consts.Scheduled
+	}
+	if txType == consts.Withdrawal {
+		transaction.ExpectedSettlementDate = s.settlementDate(transaction)
+	}
+
+	// Tokens that can't be decrypted for the gateway fail the deposit before it is recorded
+	if err := s.attachWalletToken(provider, &transaction, req.PaymentToken); err != nil {
+		return nil, models.Transaction{}, err
+	}
+
+	// Save transaction to database. Withdrawals are only recorded while the wallet balance covers
+	// them, checked while holding the user as transfers and refunds do.
+	create := s.db.CreateTransaction
+	if txType == consts.Withdrawal {
+		create = s.db.CreateWithdrawal
+	}
+	txID, err := create(transaction)
 	if err != nil {
-		return nil, fmt.Errorf("failed to create transaction: %w", err)
+		if errors.Is(err, db.ErrInsufficientBalance) {
+			return nil, models.Transaction{}, fmt.Errorf("%w: %v %s", ErrInsufficientBalance, transaction.Amount, transaction.Currency)
+		}
+		return nil, models.Transaction{}, fmt.Errorf("failed to create transaction: %w", err)
 	}
 	transaction.ID = txID
+	s.emit(events.TransactionEvent{Type: events.TransactionCreated, Transaction: transaction})
+
+	// Record the routing decision in the audit trail
+	if retryOfID > 0 {
+		decision.Reason = fmt.Sprintf("%s; retry of transaction %d after soft decline", decision.Reason, retryOfID)
+	}
+	if !scheduledFor.IsZero() {
+		decision.Reason = fmt.Sprintf("%s; scheduled for payout at %s", decision.Reason, scheduledFor.Format(time.RFC3339))
+	}
+	s.recordRoutingDecision(transaction.ID, decision)
+
+	return provider, transaction, nil
+}
+
+// submit sends a created transaction to its gateway. Declines are reported in the result rather
+// than as an error.
+func (s *TransactionService) submit(ctx context.Context, provider gateway.Provider, transaction models.Transaction) (*attemptResult, error) {
+	txType, retryOfID := transaction.Type, transaction.RetryOfID
+
+	// Record a deterministic idempotency key before calling the gateway so retries cannot double-charge
+	if err := s.assignIdempotencyKey(&transaction); err != nil {
+		return nil, err
+	}
+
+	// Exemptions are only requested from gateways that can ask the issuer for them
+	if transaction.SCAExemption != "" && !gateway.SupportsSCAExemption(provider, transaction.SCAExemption) {
+		s.recordSCAExemptionOutcome(&transaction, consts.SCAExemptionUnsupported)
+	}
 
 	// Execute gateway processing with circuit breaker and retry mechanism
 	var response *models.TransactionResponse
+	var decline *gateway.DeclineError
 
 	operation := func() error {
 		var processingErr error
-		response, processingErr = provider.ProcessWithdrawal(ctx, transaction)
+		start := time.Now()
+		if txType == consts.Withdrawal {
+			response, processingErr = s.withdraw(ctx, provider, transaction)
+		} else {
+			response, processingErr = s.deposit(ctx, provider, &transaction)
+		}
+		if s.gatewayObserver != nil {
+			s.gatewayObserver.ObserveGatewayCall(provider.ID(), time.Since(start), processingErr != nil)
+		}
 		if processingErr != nil {
+			// A decline is the issuer's answer rather than a gateway fault, so it must not trip the breaker
+			if errors.As(processingErr, &decline) {
+				return nil
+			}
 			return fmt.Errorf("gateway processing failed: %w", processingErr)
 		}
 
-		// Save gateway reference ID if provided
-		if response != nil && response.TransactionID > 0 {
-			// Update transaction with reference ID if available
-			if response.RedirectURL != "" {
-				s.db.UpdateTransactionReference(transaction.ID, response.RedirectURL)
+		// Keep the provider's reference and hosted payment page apart from our own reference
+		if response != nil && (response.GatewayReference != "" || response.RedirectURL != "") {
+			if err := s.db.UpdateTransactionGatewayReference(transaction.ID, response.GatewayReference, response.RedirectURL); err != nil {
+				log.Printf("Failed to store gateway reference of transaction %d: %v", transaction.ID, err)
 			}
+			transaction.GatewayReference = response.GatewayReference
+			transaction.RedirectURL = response.RedirectURL
 		}
 
 		return nil
 	}
 
 	// Execute with circuit breaker
-	err = s.circuitBreaker.ExecuteWithCircuitBreaker(provider.ID(), operation)
+	err := s.circuitBreaker.ExecuteWithCircuitBreaker(provider.ID(), operation)
 
 	if err != nil {
 		// Mark gateway as unhealthy
 		s.gatewaySelector.MarkGatewayDown(provider.ID())
 
-		// Update transaction to failed status
-		s.db.UpdateTransactionStatus(transaction.ID, "failed", err.Error())
+		// Update transaction to failed status, unless a callback already settled it
+		if updateErr := s.updateStatus(transaction.ID, transaction.Status, consts.Failed, err.Error()); updateErr != nil {
+			log.Printf("Failed to fail transaction %d: %v", transaction.ID, updateErr)
+		} else {
+			s.publishStatus(transaction, consts.Failed, err.Error())
+		}
 
 		return nil, err
 	}
 
-	// Update transaction status to processing
-	s.db.UpdateTransactionStatus(transaction.ID, "processing", "")
+	if decline != nil {
+		response := s.declineTransaction(transaction, decline)
+		response.ReferenceID = transaction.ReferenceID
+		response.SCAExemptionOutcome = transaction.SCAExemptionOutcome
+		return &attemptResult{
+			response:    response,
+			transaction: transaction,
+			decline:     decline,
+		}, nil
+	}
+
+	// Update transaction status to processing, or completed when the gateway settles synchronously
+	// as card switches do
+	status := consts.Processing
+	if response != nil && response.Status == consts.Completed {
+		status = consts.Completed
+	}
+	// Deposits to capture later are authorized once the gateway holds their amount
+	if response != nil && response.Status == consts.Authorized && transaction.ManualCapture {
+		if err := s.recordAuthorization(&transaction, provider); err != nil {
+			log.Printf("Failed to record authorization of transaction %d: %v", transaction.ID, err)
+		} else {
+			status = consts.Authorized
+			response.AuthorizationExpiresAt = &transaction.AuthorizationExpiresAt
+		}
+	}
+	var statusErr error
+	if status != consts.Authorized {
+		statusErr = s.updateStatus(transaction.ID, transaction.Status, status, "")
+	}
+	if statusErr != nil {
+		// A callback racing the gateway's response may already have settled the transaction
+		log.Printf("Failed to update status of transaction %d to %s: %v", transaction.ID, status, statusErr)
+	} else {
+		s.publishStatus(transaction, status, "")
+	}
+
+	// Record the submission for the Kafka dispatcher
+	s.enqueueSubmitted(transaction, provider.DataFormat())
 
-	// Queue transaction for Kafka processing
-	go s.queueTransaction(transaction, provider.DataFormat())
+	if response != nil {
+		response.ReferenceID = transaction.ReferenceID
+		response.ExpectedSettlementDate = transaction.ExpectedSettlementDate
+		response.SCAExemptionOutcome = transaction.SCAExemptionOutcome
+		if retryOfID > 0 {
+			response.RetryOfTransactionID = retryOfID
+		}
+	}
 
-	return response, nil
+	return &attemptResult{response: response, transaction: transaction}, nil
+}
+
+// settlementDate returns the expected settlement date of a withdrawal submitted now or at its
+// scheduled payout, or "" when the banking calendar cannot be read
+func (s *TransactionService) settlementDate(tx models.Transaction) string {
+	submittedAt := tx.CreatedAt
+	if !tx.ScheduledFor.IsZero() {
+		submittedAt = tx.ScheduledFor
+	}
+
+	country, err := s.db.GetCountryByID(tx.CountryID)
+	if err != nil {
+		log.Printf("Failed to get country %d for settlement date: %v", tx.CountryID, err)
+		return ""
+	}
+	date, err := s.calendar.SettlementDate(country, submittedAt)
+	if err != nil {
+		log.Printf("Failed to compute settlement date in country %s: %v", country.Code, err)
+		return ""
+	}
+	return date
 }
 
 // HandleCallback processes callbacks from payment gateways
 func (s *TransactionService) HandleCallback(ctx context.Context, callbackData *models.CallbackData) error {
+	// Callbacks can't move a transaction back, e.g. a completed one to pending
+	tx, err := s.checkCallbackTransition(callbackData)
+	if err != nil {
+		return err
+	}
+
 	// Update transaction status based on callback data
 	status := callbackData.Status
 	var errorMsg string
@@ -193,11 +595,60 @@ func (s *TransactionService) HandleCallback(ctx context.Context, callbackData *m
 		errorMsg = callbackData.Message
 	}
 
-	err := s.db.UpdateTransactionStatus(callbackData.TransactionID, status, errorMsg)
+	// Gateways authorizing once the customer confirmed the payment report the authorization later.
+	// Other statuses are only written while the transaction is in the status checked, so a
+	// concurrent callback or capture that moved it first wins.
+	if status == consts.Authorized {
+		err = s.authorizeFromCallback(callbackData)
+	} else {
+		err = s.writeStatus(callbackData.TransactionID, tx.Status, status, errorMsg)
+	}
 	if err != nil {
+		if errors.Is(err, ErrIllegalStatusTransition) {
+			log.Printf("Rejected callback moving transaction %d to %s (gateway %s): %v",
+				callbackData.TransactionID, status, callbackData.GatewayID, err)
+			return err
+		}
 		return fmt.Errorf("failed to update transaction: %w", err)
 	}
 
+	// Asynchronous gateways may only assign their reference once the payment is made
+	if callbackData.GatewayReference != "" {
+		if err := s.db.UpdateTransactionGatewayReference(callbackData.TransactionID, callbackData.GatewayReference, ""); err != nil {
+			return fmt.Errorf("failed to update transaction: %w", err)
+		}
+	}
+
+	// Record the normalized decline code for asynchronous declines
+	if status == consts.Failed && callbackData.DeclineCode != "" {
+		if err := s.db.UpdateTransactionDeclineCode(callbackData.TransactionID, callbackData.DeclineCode); err != nil {
+			return fmt.Errorf("failed to update transaction: %w", err)
+		}
+	}
+
+	// Direct debits returned because the account was closed or holds no mandate end their mandate
+	if status == consts.Failed && callbackData.ReasonCode != "" {
+		s.revokeReturnedMandate(callbackData)
+	}
+
+	// Crypto gateways report the on-chain payment, which may be for less or more than the price
+	if callbackData.Crypto != nil {
+		if err := s.db.UpdateTransactionCryptoPayment(callbackData.TransactionID, *callbackData.Crypto); err != nil {
+			return fmt.Errorf("failed to update transaction: %w", err)
+		}
+		if callbackData.Crypto.Status == consts.CryptoPaymentOverpaid {
+			log.Printf("Crypto deposit %d was overpaid with %s %s; the excess is owed back to the customer",
+				callbackData.TransactionID, callbackData.Crypto.Amount, callbackData.Crypto.Currency)
+		}
+	}
+
+	// Notify subscribers using the stored record, falling back to the callback contents
+	if tx, err := s.db.GetTransactionByID(callbackData.TransactionID); err == nil {
+		s.emit(events.TransactionEvent{Type: events.TransactionStatusChanged, Transaction: *tx})
+	} else {
+		s.publishStatus(models.Transaction{ID: callbackData.TransactionID, GatewayReference: callbackData.GatewayReference}, status, errorMsg)
+	}
+
 	// If gateway was previously marked as down, mark it as up since we received a callback
 	if callbackData.GatewayID != "" {
 		s.gatewaySelector.MarkGatewayUp(callbackData.GatewayID)
@@ -211,27 +662,70 @@ func (s *TransactionService) Ping() error {
 	return s.db.Ping()
 }
 
-// Helper function to queue transaction for async processing
-func (s *TransactionService) queueTransaction(tx models.Transaction, dataFormat string) {
-	// Marshal transaction to JSON
-	txJSON, err := json.Marshal(tx)
-	if err != nil {
-		log.Printf("Failed to marshal transaction: %v", err)
-		return
+// selectionOptions converts the request's routing controls into gateway selection options
+func selectionOptions(req models.TransactionRequest) gateway.SelectionOptions {
+	var opts gateway.SelectionOptions
+	if req.PreferredGatewayID > 0 {
+		opts.PreferredGatewayID = strconv.Itoa(req.PreferredGatewayID)
+	}
+	for _, id := range req.ExcludedGatewayIDs {
+		opts.ExcludedGatewayIDs = append(opts.ExcludedGatewayIDs, strconv.Itoa(id))
 	}
+	return opts
+}
 
-	// Publish to Kafka
-	ctx := context.Background()
-	txID := fmt.Sprintf("%d", tx.ID)
+// recordRoutingDecision stores how the gateway was chosen for a transaction
+func (s *TransactionService) recordRoutingDecision(txID int, decision models.RoutingDecision) {
+	decision.TransactionID = txID
+	decision.CreatedAt = time.Now()
 
-	// Retry operation if it fails
-	err = utils.RetryOperation(func() error {
-		return kafka.PublishTransaction(ctx, txID, txJSON, dataFormat)
-	}, 3)
+	if _, err := s.db.CreateRoutingDecision(decision); err != nil {
+		log.Printf("Failed to record routing decision for transaction %d: %v", txID, err)
+	}
+}
 
-	if err != nil {
-		log.Printf("Failed to publish transaction to Kafka after retries: %v", err)
+// declineTransaction records a provider decline on the transaction and builds the response reporting it
+func (s *TransactionService) declineTransaction(tx models.Transaction, decline *gateway.DeclineError) *models.TransactionResponse {
+	if err := s.db.UpdateTransactionDeclineCode(tx.ID, decline.Code); err != nil {
+		log.Printf("Failed to record decline code for transaction %d: %v", tx.ID, err)
+	}
+	if err := s.updateStatus(tx.ID, tx.Status, consts.Failed, decline.Error()); err != nil {
+		log.Printf("Failed to record decline of transaction %d: %v", tx.ID, err)
 	}
+
+	tx.DeclineCode = decline.Code
+	s.publishStatus(tx, consts.Failed, decline.Error())
+
+	return &models.TransactionResponse{
+		Status:               consts.Failed,
+		TransactionID:        tx.ID,
+		Message:              decline.Message,
+		DeclineCode:          decline.Code,
+		RecoveryHint:         s.recoveryHints.Hint(decline.Code),
+		RetryOfTransactionID: tx.RetryOfID,
+	}
+}
+
+// publishStatus publishes a status change event for a transaction
+func (s *TransactionService) publishStatus(tx models.Transaction, status, errorMsg string) {
+	tx.Status = status
+	tx.ErrorMessage = errorMsg
+	tx.UpdatedAt = time.Now()
+	s.emit(events.TransactionEvent{Type: events.TransactionStatusChanged, Transaction: tx})
+}
+
+// assignIdempotencyKey derives the gateway idempotency key for a transaction and stores it
+// on the record so support can correlate retried calls with the provider's logs
+func (s *TransactionService) assignIdempotencyKey(tx *models.Transaction) error {
+	key := utils.GatewayIdempotencyKey(strconv.Itoa(tx.GatewayID), tx.Type, tx.ID, tx.Amount, tx.Currency)
+
+	if err := s.db.UpdateTransactionIdempotencyKey(tx.ID, key); err != nil {
+		s.updateStatus(tx.ID, tx.Status, consts.Failed, err.Error())
+		return fmt.Errorf("failed to store idempotency key: %w", err)
+	}
+
+	tx.GatewayIdempotencyKey = key
+	return nil
 }
 
 // Helper to convert string to int
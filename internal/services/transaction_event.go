@@ -0,0 +1,46 @@
+package services
+
+import (
+	"context"
+	"log"
+	"payment-gateway/internal/events"
+	"payment-gateway/internal/models"
+)
+
+// GetTransactionEvents returns the audit trail of a user's transaction: its creation and each
+// status change, oldest first
+func (s *TransactionService) GetTransactionEvents(ctx context.Context, txID, userID int) ([]models.TransactionEvent, error) {
+	if _, err := s.userTransaction(txID, userID); err != nil {
+		return nil, err
+	}
+
+	history, err := s.db.GetTransactionEvents(txID)
+	if err != nil {
+		return nil, err
+	}
+	if history == nil {
+		history = []models.TransactionEvent{}
+	}
+	return history, nil
+}
+
+// recordTransactionEvent appends a transaction's creation or status change to its audit trail.
+// The change has already happened, so a failure to record it is only logged.
+func (s *TransactionService) recordTransactionEvent(evt events.TransactionEvent) {
+	if evt.Transaction.ID == 0 {
+		return
+	}
+
+	entry := models.TransactionEvent{
+		TransactionID:    evt.Transaction.ID,
+		Type:             evt.Type,
+		Status:           evt.Transaction.Status,
+		Message:          evt.Transaction.ErrorMessage,
+		DeclineCode:      evt.Transaction.DeclineCode,
+		GatewayReference: evt.Transaction.GatewayReference,
+		CreatedAt:        evt.OccurredAt,
+	}
+	if _, err := s.db.CreateTransactionEvent(entry); err != nil {
+		log.Printf("Failed to record %s event of transaction %d: %v", evt.Type, evt.Transaction.ID, err)
+	}
+}
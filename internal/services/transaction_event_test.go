@@ -0,0 +1,60 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"payment-gateway/db"
+	"payment-gateway/internal/consts"
+	"payment-gateway/internal/events"
+	"payment-gateway/internal/models"
+	"testing"
+)
+
+// TestTransactionEvents tests that a transaction's creation and status changes are recorded in its
+// audit trail, which only the user who made it can read
+func TestTransactionEvents(t *testing.T) {
+	mockDB := db.NewMockDB()
+	service := NewTransactionService(mockDB, returnsSelector())
+	ctx := context.Background()
+
+	tx := models.Transaction{UserID: 1, Amount: 10, Currency: "USD", Status: consts.Processing, GatewayID: 1}
+	txID, err := mockDB.CreateTransaction(tx)
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	tx.ID = txID
+	service.emit(events.TransactionEvent{Type: events.TransactionCreated, Transaction: tx})
+
+	callbacks := []*models.CallbackData{
+		{TransactionID: txID, Status: consts.Completed, GatewayReference: "ref-1"},
+		{TransactionID: txID, Status: consts.Pending},
+		{TransactionID: txID, Status: consts.Failed, ReasonCode: "MD06", Message: "returned by the bank: MD06"},
+	}
+	for _, callback := range callbacks {
+		service.HandleCallback(ctx, callback)
+	}
+
+	history, err := service.GetTransactionEvents(ctx, txID, 1)
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	if len(history) != 3 {
+		t.Fatalf("Expected 3 events, got %+v", history)
+	}
+	if history[0].Type != events.TransactionCreated || history[0].Status != consts.Processing {
+		t.Errorf("Expected the creation first, got %+v", history[0])
+	}
+	if history[1].Type != events.TransactionStatusChanged || history[1].Status != consts.Completed || history[1].GatewayReference != "ref-1" {
+		t.Errorf("Expected the completion with its gateway reference, got %+v", history[1])
+	}
+	if history[2].Status != consts.Failed || history[2].Message != "returned by the bank: MD06" || history[2].CreatedAt.Before(history[1].CreatedAt) {
+		t.Errorf("Expected the return last with its message, got %+v", history[2])
+	}
+
+	if _, err := service.GetTransactionEvents(ctx, txID, 2); !errors.Is(err, ErrTransactionNotFound) {
+		t.Errorf("Expected ErrTransactionNotFound for another user, got: %v", err)
+	}
+	if history, err := service.GetTransactionEvents(ctx, 999, 1); !errors.Is(err, ErrTransactionNotFound) || history != nil {
+		t.Errorf("Expected ErrTransactionNotFound for an unknown transaction, got %+v (%v)", history, err)
+	}
+}
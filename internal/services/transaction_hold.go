@@ -0,0 +1,175 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"payment-gateway/internal/consts"
+	"payment-gateway/internal/models"
+	"time"
+)
+
+var (
+	ErrHoldNotFound     = errors.New("transaction hold not found")
+	ErrTransactionHeld  = errors.New("transaction is already held")
+	ErrHoldClosed       = errors.New("transaction hold is already released")
+	ErrNotHoldable      = errors.New("only completed deposits can be held")
+	ErrInvalidHold      = errors.New("invalid transaction hold")
+	ErrInvalidHoldQueue = errors.New("invalid transaction hold status")
+)
+
+// HoldTransaction places a hold on a completed deposit the fraud pipeline wants reviewed: its
+// amount stays out of the user's spendable wallet balance until a reviewer releases the hold, or
+// until its deadline, consts.DefaultHoldDuration from now unless the request sets one.
+func (s *TransactionService) HoldTransaction(ctx context.Context, txID int, req models.TransactionHoldRequest) (*models.TransactionHold, error) {
+	tx, err := s.db.GetTransactionByID(txID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrTransactionNotFound
+		}
+		return nil, fmt.Errorf("failed to get transaction: %w", err)
+	}
+	if tx.Type != consts.Deposit || tx.Status != consts.Completed {
+		return nil, fmt.Errorf("%w: transaction %d is a %s %s", ErrNotHoldable, tx.ID, tx.Status, tx.Type)
+	}
+
+	now := time.Now()
+	releaseAt := req.ReleaseAt
+	if releaseAt.IsZero() {
+		releaseAt = now.Add(consts.DefaultHoldDuration)
+	}
+	if err := validateHoldDeadline(releaseAt, now, now); err != nil {
+		return nil, err
+	}
+
+	hold := models.TransactionHold{
+		TransactionID: tx.ID,
+		UserID:        tx.UserID,
+		Amount:        tx.Amount,
+		Currency:      tx.Currency,
+		Livemode:      tx.Livemode,
+		Reason:        req.Reason,
+		Status:        consts.HoldActive,
+		ReleaseAt:     releaseAt.UTC(),
+		CreatedAt:     now,
+		UpdatedAt:     now,
+	}
+	if hold.ID, err = s.db.CreateTransactionHold(hold); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrTransactionHeld
+		}
+		return nil, err
+	}
+
+	log.Printf("Transaction %d held until %s: %s", tx.ID, hold.ReleaseAt.Format(time.RFC3339), req.Reason)
+	return &hold, nil
+}
+
+// GetTransactionHold returns the latest hold placed on a transaction
+func (s *TransactionService) GetTransactionHold(ctx context.Context, txID int) (*models.TransactionHold, error) {
+	hold, err := s.db.GetTransactionHold(txID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrHoldNotFound
+		}
+		return nil, err
+	}
+	return hold, nil
+}
+
+// ListTransactionHolds returns the review queue: holds with the given status, active by default,
+// oldest first
+func (s *TransactionService) ListTransactionHolds(ctx context.Context, status string) ([]models.TransactionHold, error) {
+	if status == "" {
+		status = consts.HoldActive
+	}
+
+	switch status {
+	case consts.HoldActive, consts.HoldReleased, consts.HoldExpired:
+	default:
+		return nil, ErrInvalidHoldQueue
+	}
+
+	return s.db.ListTransactionHolds(status, consts.MaxHoldListResults)
+}
+
+// ReleaseTransactionHold releases a transaction's active hold after review, making the deposit's
+// amount spendable again
+func (s *TransactionService) ReleaseTransactionHold(ctx context.Context, txID int, req models.TransactionHoldReleaseRequest) (*models.TransactionHold, error) {
+	if err := s.db.ReleaseTransactionHold(txID, req.Note, time.Now()); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, s.closedHoldError(txID)
+		}
+		return nil, err
+	}
+
+	log.Printf("Hold on transaction %d released", txID)
+	return s.GetTransactionHold(ctx, txID)
+}
+
+// ExtendTransactionHold moves the deadline of a transaction's active hold later, to at most
+// consts.MaxHoldDuration after the hold was placed
+func (s *TransactionService) ExtendTransactionHold(ctx context.Context, txID int, req models.TransactionHoldExtendRequest) (*models.TransactionHold, error) {
+	hold, err := s.GetTransactionHold(ctx, txID)
+	if err != nil {
+		return nil, err
+	}
+	if hold.Status != consts.HoldActive {
+		return nil, ErrHoldClosed
+	}
+	if !req.ReleaseAt.After(hold.ReleaseAt) {
+		return nil, fmt.Errorf("%w: release_at must be later than %s", ErrInvalidHold, hold.ReleaseAt.Format(time.RFC3339))
+	}
+	if err := validateHoldDeadline(req.ReleaseAt, hold.CreatedAt, time.Now()); err != nil {
+		return nil, err
+	}
+
+	if err := s.db.ExtendTransactionHold(txID, req.ReleaseAt.UTC(), time.Now()); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			// Released or expired since it was read
+			return nil, ErrHoldClosed
+		}
+		return nil, err
+	}
+
+	log.Printf("Hold on transaction %d extended until %s", txID, req.ReleaseAt.UTC().Format(time.RFC3339))
+	return s.GetTransactionHold(ctx, txID)
+}
+
+// validateHoldDeadline checks that a hold placed at placedAt is released after now, and no later
+// than consts.MaxHoldDuration after it was placed
+func validateHoldDeadline(releaseAt, placedAt, now time.Time) error {
+	if !releaseAt.After(now) {
+		return fmt.Errorf("%w: release_at must be in the future", ErrInvalidHold)
+	}
+	if latest := placedAt.Add(consts.MaxHoldDuration); releaseAt.After(latest) {
+		return fmt.Errorf("%w: holds last at most %s, until %s", ErrInvalidHold, consts.MaxHoldDuration, latest.UTC().Format(time.RFC3339))
+	}
+	return nil
+}
+
+// closedHoldError tells apart a transaction that was never held from one whose hold was released
+func (s *TransactionService) closedHoldError(txID int) error {
+	if _, err := s.db.GetTransactionHold(txID); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrHoldNotFound
+		}
+		return err
+	}
+	return ErrHoldClosed
+}
+
+// ReleaseExpiredHolds releases holds whose deadline passed without review, returning how many
+// were released
+func (s *TransactionService) ReleaseExpiredHolds(ctx context.Context) (int, error) {
+	txIDs, err := s.db.ExpireTransactionHolds(time.Now(), consts.HoldReleaseBatchSize)
+	for _, txID := range txIDs {
+		log.Printf("Hold on transaction %d expired and was released", txID)
+	}
+	if err != nil {
+		return len(txIDs), fmt.Errorf("failed to release expired holds: %w", err)
+	}
+	return len(txIDs), nil
+}
@@ -0,0 +1,129 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"payment-gateway/db"
+	"payment-gateway/internal/consts"
+	"payment-gateway/internal/models"
+	"testing"
+	"time"
+)
+
+// TestTransactionHolds tests that held deposits can't be spent until their hold is released by a
+// reviewer or at its deadline
+func TestTransactionHolds(t *testing.T) {
+	mockDB := db.NewMockDB()
+	service := NewTransactionService(mockDB, &mockGatewaySelector{})
+	ctx := context.Background()
+
+	var ids []int
+	for _, tx := range []models.Transaction{
+		{UserID: 1, Amount: 100, Currency: "USD", Type: consts.Deposit, Status: consts.Completed, GatewayID: 1},
+		{UserID: 1, Amount: 40, Currency: "USD", Type: consts.Deposit, Status: consts.Completed, GatewayID: 1},
+		{UserID: 1, Amount: 25, Currency: "USD", Type: consts.Deposit, Status: consts.Processing, GatewayID: 1},
+		{UserID: 1, Amount: 10, Currency: "USD", Type: consts.Withdrawal, Status: consts.Completed, GatewayID: 1},
+	} {
+		id, err := mockDB.CreateTransaction(tx)
+		if err != nil {
+			t.Fatalf("Expected no error, got: %v", err)
+		}
+		ids = append(ids, id)
+	}
+	wallet := func() *models.WalletBalance {
+		t.Helper()
+		wallet, err := service.GetWalletBalance(ctx, 1, 1, "USD")
+		if err != nil {
+			t.Fatalf("Expected no error, got: %v", err)
+		}
+		return wallet
+	}
+
+	// Only completed deposits can be held, once at a time
+	hold, err := service.HoldTransaction(ctx, ids[0], models.TransactionHoldRequest{Reason: "velocity"})
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	if hold.Status != consts.HoldActive || hold.Amount != 100 || hold.UserID != 1 || time.Until(hold.ReleaseAt) < consts.DefaultHoldDuration-time.Minute {
+		t.Errorf("Expected 100 held for the default duration, got %+v", hold)
+	}
+	if _, err := service.HoldTransaction(ctx, ids[0], models.TransactionHoldRequest{Reason: "velocity"}); !errors.Is(err, ErrTransactionHeld) {
+		t.Errorf("Expected ErrTransactionHeld, got: %v", err)
+	}
+	for _, txID := range []int{ids[2], ids[3]} {
+		if _, err := service.HoldTransaction(ctx, txID, models.TransactionHoldRequest{Reason: "velocity"}); !errors.Is(err, ErrNotHoldable) {
+			t.Errorf("Expected ErrNotHoldable for transaction %d, got: %v", txID, err)
+		}
+	}
+	if _, err := service.HoldTransaction(ctx, 999, models.TransactionHoldRequest{Reason: "velocity"}); !errors.Is(err, ErrTransactionNotFound) {
+		t.Errorf("Expected ErrTransactionNotFound, got: %v", err)
+	}
+	for _, releaseAt := range []time.Time{time.Now().Add(-time.Hour), time.Now().Add(consts.MaxHoldDuration + time.Hour)} {
+		if _, err := service.HoldTransaction(ctx, ids[1], models.TransactionHoldRequest{Reason: "velocity", ReleaseAt: releaseAt}); !errors.Is(err, ErrInvalidHold) {
+			t.Errorf("Expected ErrInvalidHold releasing at %s, got: %v", releaseAt, err)
+		}
+	}
+
+	// Held deposits can't be spent
+	if got := wallet(); got.Balance != 30 || got.Held != 100 {
+		t.Fatalf("Expected 30 spendable and 100 held, got %+v", got)
+	}
+	transfer, err := service.Transfer(ctx, 1, models.TransferRequest{FromUserID: 1, ToUserID: 2, Amount: 50, Currency: "USD"})
+	if err != nil || transfer.DeclineCode != consts.DeclineInsufficientFunds {
+		t.Errorf("Expected a transfer of held funds declined, got %+v (%v)", transfer, err)
+	}
+
+	// Holds are extended within their maximum duration only
+	extended, err := service.ExtendTransactionHold(ctx, ids[0], models.TransactionHoldExtendRequest{ReleaseAt: hold.ReleaseAt.Add(24 * time.Hour)})
+	if err != nil || !extended.ReleaseAt.Equal(hold.ReleaseAt.Add(24*time.Hour)) {
+		t.Errorf("Expected the hold extended by a day, got %+v (%v)", extended, err)
+	}
+	for _, releaseAt := range []time.Time{hold.ReleaseAt, hold.CreatedAt.Add(consts.MaxHoldDuration + time.Hour)} {
+		if _, err := service.ExtendTransactionHold(ctx, ids[0], models.TransactionHoldExtendRequest{ReleaseAt: releaseAt}); !errors.Is(err, ErrInvalidHold) {
+			t.Errorf("Expected ErrInvalidHold extending to %s, got: %v", releaseAt, err)
+		}
+	}
+
+	// Reviewers release holds once
+	holds, err := service.ListTransactionHolds(ctx, "")
+	if err != nil || len(holds) != 1 || holds[0].TransactionID != ids[0] {
+		t.Errorf("Expected the active hold queued, got %+v (%v)", holds, err)
+	}
+	released, err := service.ReleaseTransactionHold(ctx, ids[0], models.TransactionHoldReleaseRequest{Note: "Cardholder confirmed"})
+	if err != nil || released.Status != consts.HoldReleased || released.ReleaseNote != "Cardholder confirmed" || released.ReleasedAt.IsZero() {
+		t.Errorf("Expected the hold released, got %+v (%v)", released, err)
+	}
+	if got := wallet(); got.Balance != 130 || got.Held != 0 {
+		t.Errorf("Expected 130 spendable once released, got %+v", got)
+	}
+	if _, err := service.ReleaseTransactionHold(ctx, ids[0], models.TransactionHoldReleaseRequest{}); !errors.Is(err, ErrHoldClosed) {
+		t.Errorf("Expected ErrHoldClosed releasing twice, got: %v", err)
+	}
+	if _, err := service.ExtendTransactionHold(ctx, ids[0], models.TransactionHoldExtendRequest{ReleaseAt: time.Now().Add(time.Hour)}); !errors.Is(err, ErrHoldClosed) {
+		t.Errorf("Expected ErrHoldClosed extending a released hold, got: %v", err)
+	}
+	if _, err := service.ReleaseTransactionHold(ctx, ids[1], models.TransactionHoldReleaseRequest{}); !errors.Is(err, ErrHoldNotFound) {
+		t.Errorf("Expected ErrHoldNotFound for a deposit never held, got: %v", err)
+	}
+
+	// Holds past their deadline are released automatically
+	if _, err := service.HoldTransaction(ctx, ids[1], models.TransactionHoldRequest{Reason: "new device"}); err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	if err := mockDB.ExtendTransactionHold(ids[1], time.Now().Add(-time.Minute), time.Now()); err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	if expired, err := service.ReleaseExpiredHolds(ctx); err != nil || expired != 1 {
+		t.Fatalf("Expected 1 hold released, got %d (%v)", expired, err)
+	}
+	if hold, _ := service.GetTransactionHold(ctx, ids[1]); hold.Status != consts.HoldExpired {
+		t.Errorf("Expected the hold expired, got %+v", hold)
+	}
+	if got := wallet(); got.Balance != 130 {
+		t.Errorf("Expected 130 spendable once expired, got %+v", got)
+	}
+
+	if _, err := service.ListTransactionHolds(ctx, "pending"); !errors.Is(err, ErrInvalidHoldQueue) {
+		t.Errorf("Expected ErrInvalidHoldQueue, got: %v", err)
+	}
+}
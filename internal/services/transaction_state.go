@@ -0,0 +1,90 @@
+package services
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"payment-gateway/internal/consts"
+	"payment-gateway/internal/gateway"
+	"payment-gateway/internal/models"
+	"strconv"
+)
+
+var ErrIllegalStatusTransition = errors.New("illegal transaction status transition")
+
+// statusTransitions lists the statuses a transaction in each status may move to. Completed,
+// failed and voided transactions are final, except for returns of settled payments. Authorized
+// deposits are claimed as processing while their capture or void is sent.
+var statusTransitions = map[string][]string{
+	consts.Pending:    {consts.Processing, consts.Scheduled, consts.Authorized, consts.Completed, consts.Failed},
+	consts.Scheduled:  {consts.Pending, consts.Processing, consts.Completed, consts.Failed},
+	consts.Processing: {consts.Authorized, consts.Completed, consts.Failed, consts.Voided},
+	consts.Authorized: {consts.Processing, consts.Completed, consts.Failed, consts.Voided},
+	consts.Completed:  {},
+	consts.Failed:     {},
+	consts.Voided:     {},
+}
+
+// validateStatusTransition checks that a transaction may move from one status to another.
+// Staying in the same status is allowed, so replayed callbacks are harmless.
+func validateStatusTransition(from, to string) error {
+	if from == to {
+		return nil
+	}
+	for _, allowed := range statusTransitions[from] {
+		if allowed == to {
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: %s to %s", ErrIllegalStatusTransition, from, to)
+}
+
+// updateStatus moves a transaction from the status it was read in to another the state machine
+// allows
+func (s *TransactionService) updateStatus(txID int, from, to, errorMsg string) error {
+	if err := validateStatusTransition(from, to); err != nil {
+		return err
+	}
+	return s.writeStatus(txID, from, to, errorMsg)
+}
+
+// writeStatus records a checked transition. The store only writes it while the transaction is
+// still in from, so a writer that lost a race gets ErrIllegalStatusTransition rather than
+// overwriting the status another one wrote.
+func (s *TransactionService) writeStatus(txID int, from, to, errorMsg string) error {
+	if err := s.db.UpdateTransactionStatus(txID, from, to, errorMsg); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return fmt.Errorf("%w: transaction %d is no longer %s", ErrIllegalStatusTransition, txID, from)
+		}
+		return err
+	}
+	return nil
+}
+
+// checkCallbackTransition checks that the status a callback reports is reachable from the
+// transaction's current one, logging attempts that aren't, and returns the transaction as read. A
+// settled payment the other party's bank returns fails afterwards; such callbacks carry the
+// return's reason code and are only taken from gateways that report returns.
+func (s *TransactionService) checkCallbackTransition(callbackData *models.CallbackData) (*models.Transaction, error) {
+	tx, err := s.db.GetTransactionByID(callbackData.TransactionID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get transaction: %w", err)
+	}
+
+	if tx.Status == consts.Completed && callbackData.Status == consts.Failed && callbackData.ReasonCode != "" && s.reportsReturns(tx) {
+		return tx, nil
+	}
+	if err := validateStatusTransition(tx.Status, callbackData.Status); err != nil {
+		log.Printf("Rejected callback moving transaction %d from %s to %s (gateway %s)",
+			tx.ID, tx.Status, callbackData.Status, callbackData.GatewayID)
+		return nil, err
+	}
+	return tx, nil
+}
+
+// reportsReturns reports whether a transaction's gateway reports returns of settled payments
+func (s *TransactionService) reportsReturns(tx *models.Transaction) bool {
+	provider, err := s.gatewaySelector.GetProviderByID(strconv.Itoa(tx.GatewayID))
+	return err == nil && gateway.ReportsReturns(provider)
+}
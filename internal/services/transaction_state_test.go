@@ -0,0 +1,150 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"payment-gateway/db"
+	"payment-gateway/internal/consts"
+	"payment-gateway/internal/gateway"
+	"payment-gateway/internal/models"
+	"testing"
+)
+
+// mockReturnProvider reports returns of settled payments, as SEPA does
+type mockReturnProvider struct {
+	*gateway.MockProvider
+}
+
+func (p *mockReturnProvider) ReportsReturns() bool {
+	return true
+}
+
+// returnsSelector returns a selector whose gateway 1 reports returns and gateway 2 doesn't
+func returnsSelector() *mockGatewaySelector {
+	return &mockGatewaySelector{
+		getProviderFunc: func(id string) (gateway.Provider, error) {
+			if id == "1" {
+				return &mockReturnProvider{gateway.NewMockProvider(1, "SEPA", "application/xml", 1.0, 0)}, nil
+			}
+			return gateway.NewMockProvider(2, "Stripe", "application/json", 1.0, 0), nil
+		},
+	}
+}
+
+// staleReadDB reads transactions as they were before another writer moved them, as a callback
+// racing that writer does
+type staleReadDB struct {
+	*db.MockDB
+	status string
+}
+
+func (d *staleReadDB) GetTransactionByID(transactionID int) (*models.Transaction, error) {
+	tx, err := d.MockDB.GetTransactionByID(transactionID)
+	if err == nil {
+		tx.Status = d.status
+	}
+	return tx, err
+}
+
+// TestValidateStatusTransition tests which status changes the state machine allows
+func TestValidateStatusTransition(t *testing.T) {
+	tests := []struct {
+		from, to string
+		allowed  bool
+	}{
+		{consts.Pending, consts.Processing, true},
+		{consts.Pending, consts.Completed, true},
+		{consts.Scheduled, consts.Pending, true},
+		{consts.Processing, consts.Completed, true},
+		{consts.Processing, consts.Pending, false},
+		{consts.Authorized, consts.Voided, true},
+		{consts.Processing, consts.Voided, true},
+		{consts.Completed, consts.Completed, true},
+		{consts.Completed, consts.Pending, false},
+		{consts.Completed, consts.Processing, false},
+		{consts.Failed, consts.Completed, false},
+		{consts.Voided, consts.Authorized, false},
+		{"unknown", consts.Completed, false},
+	}
+
+	for _, tt := range tests {
+		err := validateStatusTransition(tt.from, tt.to)
+		if tt.allowed && err != nil {
+			t.Errorf("Expected %s to %s allowed, got: %v", tt.from, tt.to, err)
+		}
+		if !tt.allowed && !errors.Is(err, ErrIllegalStatusTransition) {
+			t.Errorf("Expected ErrIllegalStatusTransition for %s to %s, got: %v", tt.from, tt.to, err)
+		}
+	}
+}
+
+// TestHandleCallbackRejectsIllegalTransitions tests that callbacks can't move a settled transaction
+// back, while returns of settled direct debits still fail it at gateways reporting returns
+func TestHandleCallbackRejectsIllegalTransitions(t *testing.T) {
+	mockDB := db.NewMockDB()
+	service := NewTransactionService(mockDB, returnsSelector())
+	ctx := context.Background()
+
+	txID, err := mockDB.CreateTransaction(models.Transaction{UserID: 1, Amount: 10, Currency: "EUR", Status: consts.Processing, GatewayID: 1})
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	if err := service.HandleCallback(ctx, &models.CallbackData{TransactionID: txID, Status: consts.Completed}); err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+
+	for _, status := range []string{consts.Pending, consts.Processing, consts.Failed} {
+		err := service.HandleCallback(ctx, &models.CallbackData{TransactionID: txID, Status: status, Message: "late callback"})
+		if !errors.Is(err, ErrIllegalStatusTransition) {
+			t.Errorf("Expected ErrIllegalStatusTransition moving a completed transaction to %s, got: %v", status, err)
+		}
+	}
+	if tx, _ := mockDB.GetTransactionByID(txID); tx.Status != consts.Completed || tx.ErrorMessage != "" {
+		t.Fatalf("Expected the transaction to stay completed, got %+v", tx)
+	}
+
+	// A settled direct debit returned by the bank fails, and then stays failed
+	if err := service.HandleCallback(ctx, &models.CallbackData{TransactionID: txID, Status: consts.Failed, ReasonCode: "MD06", Message: "returned by the bank: MD06"}); err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	if tx, _ := mockDB.GetTransactionByID(txID); tx.Status != consts.Failed {
+		t.Fatalf("Expected the returned transaction failed, got %s", tx.Status)
+	}
+	if err := service.HandleCallback(ctx, &models.CallbackData{TransactionID: txID, Status: consts.Completed}); !errors.Is(err, ErrIllegalStatusTransition) {
+		t.Errorf("Expected ErrIllegalStatusTransition completing a failed transaction, got: %v", err)
+	}
+
+	// Gateways that don't report returns can't fail a settled transaction, reason code or not
+	cardID, err := mockDB.CreateTransaction(models.Transaction{UserID: 1, Amount: 10, Currency: "EUR", Status: consts.Completed, GatewayID: 2})
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	if err := service.HandleCallback(ctx, &models.CallbackData{TransactionID: cardID, Status: consts.Failed, ReasonCode: "card_declined"}); !errors.Is(err, ErrIllegalStatusTransition) {
+		t.Errorf("Expected ErrIllegalStatusTransition failing a completed card payment, got: %v", err)
+	}
+	if tx, _ := mockDB.GetTransactionByID(cardID); tx.Status != consts.Completed {
+		t.Errorf("Expected the card payment to stay completed, got %s", tx.Status)
+	}
+}
+
+// TestHandleCallbackLosesRace tests that a callback checked against a status another writer has
+// since changed is rejected rather than overwriting it
+func TestHandleCallbackLosesRace(t *testing.T) {
+	mockDB := db.NewMockDB()
+	stale := &staleReadDB{MockDB: mockDB, status: consts.Processing}
+	service := NewTransactionService(stale, returnsSelector())
+	ctx := context.Background()
+
+	txID, err := mockDB.CreateTransaction(models.Transaction{UserID: 1, Amount: 10, Currency: "USD", Status: consts.Completed, GatewayID: 2})
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+
+	err = service.HandleCallback(ctx, &models.CallbackData{TransactionID: txID, Status: consts.Failed, Message: "late decline"})
+	if !errors.Is(err, ErrIllegalStatusTransition) {
+		t.Errorf("Expected ErrIllegalStatusTransition, got: %v", err)
+	}
+	if tx, _ := mockDB.GetTransactionByID(txID); tx.Status != consts.Completed || tx.ErrorMessage != "" {
+		t.Errorf("Expected the transaction to stay completed, got %+v", tx)
+	}
+}
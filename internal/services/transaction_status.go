@@ -0,0 +1,136 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"payment-gateway/internal/consts"
+	"payment-gateway/internal/gateway"
+	"payment-gateway/internal/models"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// statusRefreshes runs the gateway status queries of transactions looked up while in flight. A
+// transaction is queried by one lookup at a time and at most once per TTL; lookups arriving
+// meanwhile share the outcome of that query, so clients polling a transaction don't each reach
+// the gateway.
+type statusRefreshes struct {
+	mu      sync.Mutex
+	ttl     time.Duration
+	entries map[int]*statusRefresh
+}
+
+// statusRefresh is a status query of a transaction, running until done is closed
+type statusRefresh struct {
+	done chan struct{}
+	err  error
+}
+
+// newStatusRefreshes creates the status queries of transactions, each reused for ttl
+func newStatusRefreshes(ttl time.Duration) *statusRefreshes {
+	return &statusRefreshes{ttl: ttl, entries: make(map[int]*statusRefresh)}
+}
+
+// do runs refresh for a transaction unless a query of it is running or ran within the TTL, in
+// which case it waits for that query and returns its error
+func (r *statusRefreshes) do(ctx context.Context, txID int, refresh func() error) error {
+	r.mu.Lock()
+	entry, running := r.entries[txID]
+	if !running {
+		entry = &statusRefresh{done: make(chan struct{})}
+		r.entries[txID] = entry
+	}
+	ttl := r.ttl
+	r.mu.Unlock()
+
+	if running {
+		select {
+		case <-entry.done:
+			return entry.err
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+
+	entry.err = refresh()
+	close(entry.done)
+	time.AfterFunc(ttl, func() {
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		if r.entries[txID] == entry {
+			delete(r.entries, txID)
+		}
+	})
+	return entry.err
+}
+
+// SetStatusQueryCacheTTL configures how long the status a gateway reported for a transaction is
+// reused by later lookups
+func (s *TransactionService) SetStatusQueryCacheTTL(ttl time.Duration) {
+	s.statusRefreshes.mu.Lock()
+	defer s.statusRefreshes.mu.Unlock()
+	s.statusRefreshes.ttl = ttl
+}
+
+// GetTransaction returns a user's transaction. A transaction still pending or processing at a
+// gateway that can be asked for its status is refreshed from the gateway first, through the
+// status query cache; when the gateway can't be reached the stored transaction is returned.
+func (s *TransactionService) GetTransaction(ctx context.Context, txID, userID int) (*models.Transaction, error) {
+	tx, err := s.userTransaction(txID, userID)
+	if err != nil {
+		return nil, err
+	}
+	if (tx.Status != consts.Pending && tx.Status != consts.Processing) || tx.GatewayReference == "" {
+		return tx, nil
+	}
+
+	provider, err := s.gatewaySelector.GetProviderByID(strconv.Itoa(tx.GatewayID))
+	if err != nil {
+		return tx, nil
+	}
+	querier, ok := provider.(gateway.StatusQueryProvider)
+	if !ok {
+		return tx, nil
+	}
+
+	if err := s.statusRefreshes.do(ctx, tx.ID, func() error { return s.refreshStatus(querier, *tx) }); err != nil {
+		log.Printf("Failed to query the status of transaction %d at %s: %v", tx.ID, provider.Name(), err)
+		return tx, nil
+	}
+
+	refreshed, err := s.db.GetTransactionByID(tx.ID)
+	if err != nil {
+		return tx, nil
+	}
+	return refreshed, nil
+}
+
+// refreshStatus asks a transaction's gateway for its status and applies an outcome like a
+// callback reporting it. The query isn't bound to the lookup that started it, since every lookup
+// waiting for it shares its outcome.
+func (s *TransactionService) refreshStatus(provider gateway.StatusQueryProvider, tx models.Transaction) error {
+	ctx, cancel := context.WithTimeout(context.Background(), consts.StatusQueryTimeout)
+	defer cancel()
+
+	data, err := provider.QueryTransactionStatus(ctx, tx)
+	if err != nil {
+		return err
+	}
+
+	// Only outcomes are applied, and only to a transaction no callback settled meanwhile
+	if data.Status != consts.Completed && data.Status != consts.Failed {
+		return nil
+	}
+	current, err := s.db.GetTransactionByID(tx.ID)
+	if err != nil {
+		return fmt.Errorf("failed to get transaction: %w", err)
+	}
+	if current.Status != consts.Pending && current.Status != consts.Processing {
+		return nil
+	}
+
+	data.TransactionID = tx.ID
+	return s.HandleCallback(ctx, data)
+}
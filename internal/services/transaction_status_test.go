@@ -0,0 +1,133 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"payment-gateway/db"
+	"payment-gateway/internal/consts"
+	"payment-gateway/internal/gateway"
+	"payment-gateway/internal/models"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// mockStatusProvider is a mock gateway reporting the status it is set to, counting its queries
+type mockStatusProvider struct {
+	*gateway.MockProvider
+	queries atomic.Int32
+	delay   time.Duration
+
+	mu     sync.Mutex
+	status string
+	err    error
+}
+
+func (p *mockStatusProvider) QueryTransactionStatus(ctx context.Context, transaction models.Transaction) (*models.CallbackData, error) {
+	p.queries.Add(1)
+	time.Sleep(p.delay)
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.err != nil {
+		return nil, p.err
+	}
+	return &models.CallbackData{TransactionID: transaction.ID, Status: p.status, GatewayReference: transaction.GatewayReference, GatewayID: p.ID()}, nil
+}
+
+func (p *mockStatusProvider) set(status string, err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.status, p.err = status, err
+}
+
+// setupStatusQueries creates a service with a gateway reporting statuses and a user with a merchant
+func setupStatusQueries(t *testing.T) (*TransactionService, *db.MockDB, *mockStatusProvider, int) {
+	mockDB := db.NewMockDB()
+	selector := gateway.NewSelector(mockDB)
+	provider := &mockStatusProvider{MockProvider: gateway.NewMockProvider(2, "Stripe", "application/json", 1.0, 0), status: consts.Processing}
+	selector.RegisterProvider(provider)
+	service := NewTransactionService(mockDB, selector)
+
+	userID, err := mockDB.CreateUser(models.User{Username: "alice", Email: "alice@example.com", CountryID: 1, MerchantID: 1})
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	return service, mockDB, provider, userID
+}
+
+// TestGetTransactionStatusQueryStampede tests that concurrent lookups of a transaction in flight
+// share one gateway query, and that lookups within the TTL reuse its outcome
+func TestGetTransactionStatusQueryStampede(t *testing.T) {
+	service, mockDB, provider, userID := setupStatusQueries(t)
+	provider.delay = 50 * time.Millisecond
+	service.SetStatusQueryCacheTTL(time.Hour)
+
+	txID, err := mockDB.CreateTransaction(models.Transaction{Amount: 20, Currency: "USD", Type: consts.Deposit, Status: consts.Processing, UserID: userID, GatewayID: 2, GatewayReference: "pi_123"})
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if tx, err := service.GetTransaction(context.Background(), txID, userID); err != nil || tx.Status != consts.Processing {
+				t.Errorf("Expected the processing transaction, got %+v (%v)", tx, err)
+			}
+		}()
+	}
+	wg.Wait()
+	if queries := provider.queries.Load(); queries != 1 {
+		t.Errorf("Expected concurrent lookups to share 1 gateway query, got %d", queries)
+	}
+
+	provider.set(consts.Completed, nil)
+	if tx, _ := service.GetTransaction(context.Background(), txID, userID); tx.Status != consts.Processing || provider.queries.Load() != 1 {
+		t.Errorf("Expected the cached status within the TTL, got %s after %d queries", tx.Status, provider.queries.Load())
+	}
+
+	if _, err := service.GetTransaction(context.Background(), txID, userID+1); !errors.Is(err, ErrTransactionNotFound) {
+		t.Errorf("Expected ErrTransactionNotFound for another user's transaction, got: %v", err)
+	}
+}
+
+// TestGetTransactionStatusQueryRefresh tests that an outcome the gateway reports once the TTL
+// passed is applied like a callback, and that settled transactions and gateway errors don't fail
+// lookups
+func TestGetTransactionStatusQueryRefresh(t *testing.T) {
+	service, mockDB, provider, userID := setupStatusQueries(t)
+	service.SetStatusQueryCacheTTL(20 * time.Millisecond)
+
+	txID, err := mockDB.CreateTransaction(models.Transaction{Amount: 20, Currency: "USD", Type: consts.Deposit, Status: consts.Processing, UserID: userID, GatewayID: 2, GatewayReference: "pi_123"})
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+
+	provider.set("", errors.New("gateway unreachable"))
+	if tx, err := service.GetTransaction(context.Background(), txID, userID); err != nil || tx.Status != consts.Processing {
+		t.Errorf("Expected the stored transaction when the gateway fails, got %+v (%v)", tx, err)
+	}
+
+	time.Sleep(50 * time.Millisecond)
+	provider.set(consts.Completed, nil)
+	tx, err := service.GetTransaction(context.Background(), txID, userID)
+	if err != nil || tx.Status != consts.Completed {
+		t.Fatalf("Expected the transaction completed by the gateway's status, got %+v (%v)", tx, err)
+	}
+	if queries := provider.queries.Load(); queries != 2 {
+		t.Errorf("Expected a second gateway query once the TTL passed, got %d", queries)
+	}
+
+	messages, _ := mockDB.GetPendingOutboxMessages(consts.OutboxMerchantWebhook, time.Now(), consts.OutboxBatchSize)
+	if len(messages) != 1 || messages[0].TransactionID != txID {
+		t.Errorf("Expected a webhook announcing the completed transaction, got %+v", messages)
+	}
+
+	time.Sleep(50 * time.Millisecond)
+	if _, err := service.GetTransaction(context.Background(), txID, userID); err != nil || provider.queries.Load() != 2 {
+		t.Errorf("Expected settled transactions not to be queried, got %d queries (%v)", provider.queries.Load(), err)
+	}
+}
@@ -0,0 +1,209 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"payment-gateway/internal/consts"
+	"payment-gateway/internal/models"
+	"payment-gateway/internal/validation"
+	"sort"
+	"strings"
+	"time"
+)
+
+var (
+	ErrInvalidTransactionTags = errors.New("invalid transaction tags")
+	ErrTransactionTagNotFound = errors.New("transaction does not carry the tag")
+	ErrInvalidTransactionList = errors.New("invalid transaction list")
+)
+
+// AddTransactionTags attaches tags to a transaction, keeping those it already carries. Tags are
+// stored in lower case. Merchants may only tag their users' transactions; a merchantID of 0 is an
+// admin's and may tag any.
+func (s *TransactionService) AddTransactionTags(ctx context.Context, txID, merchantID int, req models.TransactionTagsRequest) (*models.TransactionTags, error) {
+	if _, err := s.merchantTransaction(txID, merchantID); err != nil {
+		return nil, err
+	}
+
+	existing, err := s.db.GetTransactionTags(txID)
+	if err != nil {
+		return nil, err
+	}
+
+	tags := make(map[string]bool)
+	for _, tag := range existing {
+		tags[tag] = true
+	}
+	var added []string
+	for _, tag := range req.Tags {
+		tag = normalizeTag(tag)
+		if !validation.IsTag(tag) {
+			return nil, fmt.Errorf("%w: %q is not a tag", ErrInvalidTransactionTags, tag)
+		}
+		if !tags[tag] {
+			tags[tag] = true
+			added = append(added, tag)
+		}
+	}
+	if len(tags) > consts.MaxTransactionTags {
+		return nil, fmt.Errorf("%w: a transaction carries at most %d tags", ErrInvalidTransactionTags, consts.MaxTransactionTags)
+	}
+
+	if len(added) > 0 {
+		if err := s.db.AddTransactionTags(txID, added, time.Now()); err != nil {
+			return nil, err
+		}
+		log.Printf("Tagged transaction %d with %s", txID, strings.Join(added, ", "))
+	}
+
+	return s.GetTransactionTags(ctx, txID, merchantID)
+}
+
+// RemoveTransactionTag removes a tag from a transaction
+func (s *TransactionService) RemoveTransactionTag(ctx context.Context, txID, merchantID int, tag string) (*models.TransactionTags, error) {
+	if _, err := s.merchantTransaction(txID, merchantID); err != nil {
+		return nil, err
+	}
+
+	if err := s.db.RemoveTransactionTag(txID, normalizeTag(tag)); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrTransactionTagNotFound
+		}
+		return nil, err
+	}
+
+	return s.GetTransactionTags(ctx, txID, merchantID)
+}
+
+// GetTransactionTags returns a transaction's tags in alphabetical order
+func (s *TransactionService) GetTransactionTags(ctx context.Context, txID, merchantID int) (*models.TransactionTags, error) {
+	if _, err := s.merchantTransaction(txID, merchantID); err != nil {
+		return nil, err
+	}
+
+	tags, err := s.db.GetTransactionTags(txID)
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(tags)
+	return &models.TransactionTags{TransactionID: txID, Tags: tags}, nil
+}
+
+// ListTransactions returns a merchant's transactions with their tags, newest first, or every
+// merchant's for a merchantID of 0. Filtering by tags returns the transactions carrying all of them.
+func (s *TransactionService) ListTransactions(ctx context.Context, merchantID int, query models.TransactionListQuery) ([]models.TransactionSummary, error) {
+	filter := models.TransactionFilter{
+		MerchantID: merchantID,
+		Status:     query.Status,
+		Type:       query.Type,
+		BeforeID:   query.BeforeID,
+		Limit:      query.Limit,
+	}
+
+	for _, tag := range query.Tags {
+		tag = normalizeTag(tag)
+		if !validation.IsTag(tag) {
+			return nil, fmt.Errorf("%w: %q is not a tag", ErrInvalidTransactionList, tag)
+		}
+		filter.Tags = append(filter.Tags, tag)
+	}
+
+	switch filter.Status {
+	case "", consts.Pending, consts.Scheduled, consts.Processing, consts.Authorized, consts.Completed, consts.Failed, consts.Voided:
+	default:
+		return nil, fmt.Errorf("%w: status must be pending, scheduled, processing, authorized, completed, failed or voided", ErrInvalidTransactionList)
+	}
+	if filter.Type != "" && filter.Type != consts.Deposit && filter.Type != consts.Withdrawal {
+		return nil, fmt.Errorf("%w: type must be deposit or withdrawal", ErrInvalidTransactionList)
+	}
+
+	if query.From != "" {
+		from, err := time.Parse(dateLayout, query.From)
+		if err != nil {
+			return nil, fmt.Errorf("%w: from %q is not YYYY-MM-DD", ErrInvalidTransactionList, query.From)
+		}
+		filter.From = from
+	}
+	if query.To != "" {
+		to, err := time.Parse(dateLayout, query.To)
+		if err != nil {
+			return nil, fmt.Errorf("%w: to %q is not YYYY-MM-DD", ErrInvalidTransactionList, query.To)
+		}
+		filter.To = to.AddDate(0, 0, 1)
+	}
+	if !filter.From.IsZero() && !filter.To.IsZero() && !filter.From.Before(filter.To) {
+		return nil, fmt.Errorf("%w: from must not be after to", ErrInvalidTransactionList)
+	}
+
+	if filter.BeforeID < 0 {
+		return nil, fmt.Errorf("%w: before_id must not be negative", ErrInvalidTransactionList)
+	}
+	if filter.Limit == 0 {
+		filter.Limit = consts.DefaultTransactionListLimit
+	}
+	if filter.Limit < 0 || filter.Limit > consts.MaxTransactionListLimit {
+		return nil, fmt.Errorf("%w: limit must be between 1 and %d", ErrInvalidTransactionList, consts.MaxTransactionListLimit)
+	}
+
+	transactions, err := s.db.ListTransactions(filter)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list transactions: %w", err)
+	}
+	for i := range transactions {
+		if transactions[i].Tags == nil {
+			transactions[i].Tags = []string{}
+		}
+	}
+	return transactions, nil
+}
+
+// merchantTransaction fetches a transaction of one of a merchant's users, reporting those of
+// other merchants as not found. A merchantID of 0 fetches any transaction.
+func (s *TransactionService) merchantTransaction(txID, merchantID int) (*models.Transaction, error) {
+	tx, err := s.db.GetTransactionByID(txID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrTransactionNotFound
+		}
+		return nil, fmt.Errorf("failed to get transaction: %w", err)
+	}
+	if merchantID == 0 {
+		return tx, nil
+	}
+
+	user, err := s.db.GetUserByID(tx.UserID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrTransactionNotFound
+		}
+		return nil, fmt.Errorf("failed to get user: %w", err)
+	}
+	if user.MerchantID != merchantID {
+		return nil, ErrTransactionNotFound
+	}
+	return tx, nil
+}
+
+// merchantUser fetches one of a merchant's users, reporting those of other merchants as not found
+// so callers cannot probe for them
+func (s *TransactionService) merchantUser(userID, merchantID int) (*models.User, error) {
+	user, err := s.db.GetUserByID(userID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrUserNotFound
+		}
+		return nil, fmt.Errorf("failed to get user: %w", err)
+	}
+	if user.MerchantID != merchantID {
+		return nil, ErrUserNotFound
+	}
+	return user, nil
+}
+
+// normalizeTag trims a tag and puts it in lower case, the form tags are stored and compared in
+func normalizeTag(tag string) string {
+	return strings.ToLower(strings.TrimSpace(tag))
+}
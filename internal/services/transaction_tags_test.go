@@ -0,0 +1,119 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"payment-gateway/db"
+	"payment-gateway/internal/consts"
+	"payment-gateway/internal/models"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// TestTransactionTags tests that merchants tag only their users' transactions, that tags are
+// normalized and capped, and that lists and decline reports filter by them
+func TestTransactionTags(t *testing.T) {
+	mockDB := db.NewMockDB()
+	service := NewTransactionService(mockDB, &mockGatewaySelector{})
+	ctx := context.Background()
+
+	alice, err := mockDB.CreateUser(models.User{Username: "alice", Email: "alice@example.com", CountryID: 1, MerchantID: 1})
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	bob, err := mockDB.CreateUser(models.User{Username: "bob", Email: "bob@example.com", CountryID: 1, MerchantID: 2})
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+
+	day := time.Now().UTC().AddDate(0, 0, -1)
+	var ids []int
+	for _, tx := range []models.Transaction{
+		{UserID: alice, Type: consts.Deposit, Status: consts.Completed, Amount: 100, Currency: "USD", CountryID: 1, CreatedAt: day},
+		{UserID: alice, Type: consts.Deposit, Status: consts.Failed, Amount: 50, Currency: "USD", DeclineCode: consts.DeclineInsufficientFunds, CountryID: 1, CreatedAt: day},
+		{UserID: alice, Type: consts.Withdrawal, Status: consts.Completed, Amount: 20, Currency: "USD", CountryID: 1, CreatedAt: day},
+		{UserID: bob, Type: consts.Deposit, Status: consts.Completed, Amount: 10, Currency: "USD", CountryID: 1, CreatedAt: day},
+	} {
+		id, err := mockDB.CreateTransaction(tx)
+		if err != nil {
+			t.Fatalf("Expected no error, got: %v", err)
+		}
+		ids = append(ids, id)
+	}
+
+	tags, err := service.AddTransactionTags(ctx, ids[0], 1, models.TransactionTagsRequest{Tags: []string{" Campaign:BlackFriday ", "vip", "campaign:blackfriday"}})
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	if !reflect.DeepEqual(tags.Tags, []string{"campaign:blackfriday", "vip"}) {
+		t.Errorf("Expected the tags normalized and deduplicated, got %v", tags.Tags)
+	}
+	if _, err := service.AddTransactionTags(ctx, ids[1], 1, models.TransactionTagsRequest{Tags: []string{"campaign:blackfriday"}}); err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	// Admins tag any merchant's transactions
+	if _, err := service.AddTransactionTags(ctx, ids[3], 0, models.TransactionTagsRequest{Tags: []string{"campaign:blackfriday"}}); err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+
+	if _, err := service.AddTransactionTags(ctx, ids[3], 1, models.TransactionTagsRequest{Tags: []string{"vip"}}); !errors.Is(err, ErrTransactionNotFound) {
+		t.Errorf("Expected ErrTransactionNotFound for another merchant's transaction, got: %v", err)
+	}
+	many := make([]string, consts.MaxTransactionTags-1)
+	for i := range many {
+		many[i] = "batch:" + string(rune('a'+i))
+	}
+	if _, err := service.AddTransactionTags(ctx, ids[0], 1, models.TransactionTagsRequest{Tags: many}); !errors.Is(err, ErrInvalidTransactionTags) {
+		t.Errorf("Expected ErrInvalidTransactionTags over %d tags, got: %v", consts.MaxTransactionTags, err)
+	}
+
+	listed, err := service.ListTransactions(ctx, 1, models.TransactionListQuery{Tags: []string{"Campaign:BlackFriday"}})
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	if len(listed) != 2 || listed[0].ID != ids[1] || listed[1].ID != ids[0] || len(listed[1].Tags) != 2 {
+		t.Errorf("Expected the merchant's two tagged transactions, newest first, got %+v", listed)
+	}
+	if listed, _ := service.ListTransactions(ctx, 1, models.TransactionListQuery{Tags: []string{"campaign:blackfriday", "vip"}}); len(listed) != 1 || listed[0].ID != ids[0] {
+		t.Errorf("Expected only the transaction carrying both tags, got %+v", listed)
+	}
+	if listed, _ := service.ListTransactions(ctx, 0, models.TransactionListQuery{Tags: []string{"campaign:blackfriday"}, Limit: 2}); len(listed) != 2 || listed[0].ID != ids[3] {
+		t.Errorf("Expected a page of every merchant's tagged transactions, got %+v", listed)
+	}
+	if listed, _ := service.ListTransactions(ctx, 1, models.TransactionListQuery{BeforeID: ids[1]}); len(listed) != 1 || listed[0].ID != ids[0] || listed[0].Tags == nil {
+		t.Errorf("Expected the page before transaction %d, got %+v", ids[1], listed)
+	}
+
+	report, err := service.DeclineReport(ctx, 1, models.DeclineReportQuery{Tag: "campaign:blackfriday"})
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	if report.Attempts != 2 || report.Declines != 1 || report.Tag != "campaign:blackfriday" {
+		t.Errorf("Expected 2 tagged attempts with 1 decline, got %+v", report)
+	}
+
+	tags, err = service.RemoveTransactionTag(ctx, ids[0], 1, "VIP")
+	if err != nil || !reflect.DeepEqual(tags.Tags, []string{"campaign:blackfriday"}) {
+		t.Errorf("Expected vip removed, got %+v (%v)", tags, err)
+	}
+	if _, err := service.RemoveTransactionTag(ctx, ids[0], 1, "vip"); !errors.Is(err, ErrTransactionTagNotFound) {
+		t.Errorf("Expected ErrTransactionTagNotFound removing it again, got: %v", err)
+	}
+
+	invalid := []models.TransactionListQuery{
+		{Tags: []string{"black friday"}},
+		{Status: "settled"},
+		{Type: "refund"},
+		{From: "2026-03-10", To: "2026-03-01"},
+		{Limit: consts.MaxTransactionListLimit + 1},
+	}
+	for _, query := range invalid {
+		if _, err := service.ListTransactions(ctx, 1, query); !errors.Is(err, ErrInvalidTransactionList) {
+			t.Errorf("%+v: expected ErrInvalidTransactionList, got: %v", query, err)
+		}
+	}
+	if _, err := service.DeclineReport(ctx, 1, models.DeclineReportQuery{Tag: "a:b:c"}); !errors.Is(err, ErrInvalidDeclineReport) {
+		t.Errorf("Expected ErrInvalidDeclineReport for an invalid tag, got: %v", err)
+	}
+}
@@ -6,19 +6,36 @@ import (
 	"errors"
 	"net/http"
 
+	"payment-gateway/db"
+	"payment-gateway/internal/consts"
+	"payment-gateway/internal/events"
 	"payment-gateway/internal/gateway"
 	"payment-gateway/internal/models"
+	"payment-gateway/internal/utils"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 )
 
-// mockDB implements db.DBInterface for testing
+// mockDB implements db.DBInterface for testing. Operations not covered by a
+// func field fall through to the embedded interface.
 type mockDB struct {
+	db.DBInterface
+
 	getUserFunc               func(int) (*models.User, error)
+	getMerchantFunc           func(int) (*models.Merchant, error)
+	createRoutingDecisionFunc func(models.RoutingDecision) (int, error)
 	getGatewaysByPriorityFunc func(int) ([]models.GatewayPriority, error)
 	createTransactionFunc     func(models.Transaction) (int, error)
 	updateStatusFunc          func(int, string, string) error
-	updateReferenceFunc       func(int, string) error
+	updateGatewayRefFunc      func(int, string, string) error
+	updateIdempotencyKeyFunc  func(int, string) error
+	updateDeclineCodeFunc     func(int, string) error
 	getTransactionFunc        func(int) (*models.Transaction, error)
+	createBatchFunc           func(models.Batch) (int, error)
+	getBatchFunc              func(int) (*models.Batch, error)
+	createOutboxMessagesFunc  func([]models.OutboxMessage) error
 }
 
 func (m *mockDB) GetUserByID(userID int) (*models.User, error) {
@@ -28,6 +45,13 @@ func (m *mockDB) GetUserByID(userID int) (*models.User, error) {
 	return nil, sql.ErrNoRows
 }
 
+func (m *mockDB) GetMerchantByID(merchantID int) (*models.Merchant, error) {
+	if m.getMerchantFunc != nil {
+		return m.getMerchantFunc(merchantID)
+	}
+	return nil, sql.ErrNoRows
+}
+
 func (m *mockDB) GetGatewaysByPriority(countryID int) ([]models.GatewayPriority, error) {
 	if m.getGatewaysByPriorityFunc != nil {
 		return m.getGatewaysByPriorityFunc(countryID)
@@ -35,6 +59,13 @@ func (m *mockDB) GetGatewaysByPriority(countryID int) ([]models.GatewayPriority,
 	return nil, errors.New("not implemented")
 }
 
+func (m *mockDB) CreateRoutingDecision(decision models.RoutingDecision) (int, error) {
+	if m.createRoutingDecisionFunc != nil {
+		return m.createRoutingDecisionFunc(decision)
+	}
+	return 1, nil
+}
+
 func (m *mockDB) CreateTransaction(tx models.Transaction) (int, error) {
 	if m.createTransactionFunc != nil {
 		return m.createTransactionFunc(tx)
@@ -42,6 +73,10 @@ func (m *mockDB) CreateTransaction(tx models.Transaction) (int, error) {
 	return 0, errors.New("not implemented")
 }
 
+func (m *mockDB) CreateWithdrawal(tx models.Transaction) (int, error) {
+	return m.CreateTransaction(tx)
+}
+
 func (m *mockDB) GetTransactionByID(transactionID int) (*models.Transaction, error) {
 	if m.getTransactionFunc != nil {
 		return m.getTransactionFunc(transactionID)
@@ -49,16 +84,55 @@ func (m *mockDB) GetTransactionByID(transactionID int) (*models.Transaction, err
 	return nil, sql.ErrNoRows
 }
 
-func (m *mockDB) UpdateTransactionStatus(txID int, status, errorMsg string) error {
+func (m *mockDB) UpdateTransactionStatus(txID int, from, to, errorMsg string) error {
 	if m.updateStatusFunc != nil {
-		return m.updateStatusFunc(txID, status, errorMsg)
+		return m.updateStatusFunc(txID, to, errorMsg)
 	}
 	return nil
 }
 
-func (m *mockDB) UpdateTransactionReference(txID int, referenceID string) error {
-	if m.updateReferenceFunc != nil {
-		return m.updateReferenceFunc(txID, referenceID)
+func (m *mockDB) UpdateTransactionGatewayReference(txID int, gatewayReference, redirectURL string) error {
+	if m.updateGatewayRefFunc != nil {
+		return m.updateGatewayRefFunc(txID, gatewayReference, redirectURL)
+	}
+	return nil
+}
+
+func (m *mockDB) UpdateTransactionIdempotencyKey(txID int, key string) error {
+	if m.updateIdempotencyKeyFunc != nil {
+		return m.updateIdempotencyKeyFunc(txID, key)
+	}
+	return nil
+}
+
+func (m *mockDB) UpdateTransactionDeclineCode(txID int, declineCode string) error {
+	if m.updateDeclineCodeFunc != nil {
+		return m.updateDeclineCodeFunc(txID, declineCode)
+	}
+	return nil
+}
+
+func (m *mockDB) CreateBatch(batch models.Batch) (int, error) {
+	if m.createBatchFunc != nil {
+		return m.createBatchFunc(batch)
+	}
+	return 0, errors.New("not implemented")
+}
+
+func (m *mockDB) GetBatchByID(batchID int) (*models.Batch, error) {
+	if m.getBatchFunc != nil {
+		return m.getBatchFunc(batchID)
+	}
+	return nil, sql.ErrNoRows
+}
+
+func (m *mockDB) CreateTransactionEvent(event models.TransactionEvent) (int, error) {
+	return 1, nil
+}
+
+func (m *mockDB) CreateOutboxMessages(messages []models.OutboxMessage) error {
+	if m.createOutboxMessagesFunc != nil {
+		return m.createOutboxMessagesFunc(messages)
 	}
 	return nil
 }
@@ -67,6 +141,14 @@ func (m *mockDB) GetSupportedGatewaysByCountry(countryID int) ([]models.Gateway,
 	return nil, nil
 }
 
+func (m *mockDB) GetComplianceFields(countryID int) ([]models.ComplianceField, error) {
+	return nil, nil
+}
+
+func (m *mockDB) GetAMLThreshold(countryID int, currency string) (*models.AMLThreshold, error) {
+	return nil, sql.ErrNoRows
+}
+
 func (m *mockDB) Ping() error {
 	return nil
 }
@@ -83,6 +165,7 @@ type mockProvider struct {
 	isAvailableFunc     func() bool
 	processDepositFunc  func(context.Context, models.Transaction) (*models.TransactionResponse, error)
 	processWithdrawFunc func(context.Context, models.Transaction) (*models.TransactionResponse, error)
+	processRefundFunc   func(context.Context, models.Refund, models.Transaction) (*models.TransactionResponse, error)
 	parseCallbackFunc   func(*http.Request) (*models.CallbackData, error)
 }
 
@@ -127,6 +210,17 @@ func (p *mockProvider) ProcessWithdrawal(ctx context.Context, tx models.Transact
 	}, nil
 }
 
+func (p *mockProvider) ProcessRefund(ctx context.Context, refund models.Refund, tx models.Transaction) (*models.TransactionResponse, error) {
+	if p.processRefundFunc != nil {
+		return p.processRefundFunc(ctx, refund, tx)
+	}
+	return &models.TransactionResponse{
+		Status:        "completed",
+		TransactionID: tx.ID,
+		Message:       "Refund completed",
+	}, nil
+}
+
 func (p *mockProvider) ParseCallback(r *http.Request) (*models.CallbackData, error) {
 	if p.parseCallbackFunc != nil {
 		return p.parseCallbackFunc(r)
@@ -136,10 +230,11 @@ func (p *mockProvider) ParseCallback(r *http.Request) (*models.CallbackData, err
 
 // mockGatewaySelector mocks the gateway.Selector for testing
 type mockGatewaySelector struct {
-	selectGatewayFunc func(context.Context, int, string) (gateway.Provider, error)
-	getProviderFunc   func(string) (gateway.Provider, error)
-	markUpFunc        func(string)
-	markDownFunc      func(string)
+	selectGatewayFunc  func(context.Context, int, string) (gateway.Provider, error)
+	selectWithOptsFunc func(context.Context, int, string, gateway.SelectionOptions) (gateway.Provider, models.RoutingDecision, error)
+	getProviderFunc    func(string) (gateway.Provider, error)
+	markUpFunc         func(string)
+	markDownFunc       func(string)
 }
 
 func (m *mockGatewaySelector) RegisterProvider(provider gateway.Provider) {
@@ -154,6 +249,27 @@ func (m *mockGatewaySelector) SelectGateway(ctx context.Context, countryID int,
 	return nil, errors.New("no gateway available")
 }
 
+func (m *mockGatewaySelector) SelectGatewayWithOptions(ctx context.Context, countryID int, txType string, opts gateway.SelectionOptions) (gateway.Provider, models.RoutingDecision, error) {
+	if m.selectWithOptsFunc != nil {
+		return m.selectWithOptsFunc(ctx, countryID, txType, opts)
+	}
+	provider, err := m.SelectGateway(ctx, countryID, txType)
+	return provider, models.RoutingDecision{CountryID: countryID, Type: txType}, err
+}
+
+func (m *mockGatewaySelector) TraceGatewaySelection(ctx context.Context, countryID int, txType string, opts gateway.SelectionOptions) (gateway.Provider, models.RoutingDecision, []models.RoutingTraceStep, error) {
+	provider, decision, err := m.SelectGatewayWithOptions(ctx, countryID, txType, opts)
+	return provider, decision, nil, err
+}
+
+func (m *mockGatewaySelector) Providers() []gateway.Provider {
+	return nil
+}
+
+func (m *mockGatewaySelector) AvailableProviders(ctx context.Context, countryID int) ([]gateway.Provider, error) {
+	return nil, nil
+}
+
 func (m *mockGatewaySelector) GetProviderByID(id string) (gateway.Provider, error) {
 	if m.getProviderFunc != nil {
 		return m.getProviderFunc(id)
@@ -182,6 +298,8 @@ func TestProcessDeposit(t *testing.T) {
 		Email:     "test@example.com",
 		CountryID: 1,
 	}
+	var created models.Transaction
+	var gatewayReference, storedRedirect string
 
 	mockDB := &mockDB{
 		getUserFunc: func(id int) (*models.User, error) {
@@ -191,14 +309,27 @@ func TestProcessDeposit(t *testing.T) {
 			return nil, sql.ErrNoRows
 		},
 		createTransactionFunc: func(tx models.Transaction) (int, error) {
+			created = tx
 			return 123, nil // Return a test ID
 		},
+		updateGatewayRefFunc: func(id int, ref, redirectURL string) error {
+			gatewayReference, storedRedirect = ref, redirectURL
+			return nil
+		},
 	}
 
 	mockProvider := &mockProvider{
 		id:         "1",
 		name:       "TestGateway",
 		dataFormat: "application/json",
+		processDepositFunc: func(ctx context.Context, tx models.Transaction) (*models.TransactionResponse, error) {
+			return &models.TransactionResponse{
+				Status:           "processing",
+				TransactionID:    tx.ID,
+				GatewayReference: "GW-123",
+				RedirectURL:      "https://gateway.example.com/pay/GW-123",
+			}, nil
+		},
 	}
 
 	mockSelector := &mockGatewaySelector{
@@ -238,6 +369,243 @@ func TestProcessDeposit(t *testing.T) {
 	if response.TransactionID != 123 {
 		t.Errorf("Expected transaction ID 123, got: %d", response.TransactionID)
 	}
+
+	// The reference is generated before the transaction is stored and returned to the caller
+	if !strings.HasPrefix(created.ReferenceID, consts.DefaultReferencePrefix) || response.ReferenceID != created.ReferenceID {
+		t.Errorf("Expected a stored reference returned in the response, got %q and %q", created.ReferenceID, response.ReferenceID)
+	}
+
+	// The gateway's own reference and redirect URL are stored apart from ours
+	if gatewayReference != "GW-123" || storedRedirect != "https://gateway.example.com/pay/GW-123" {
+		t.Errorf("Expected gateway reference and redirect URL to be stored, got %q and %q", gatewayReference, storedRedirect)
+	}
+}
+
+// TestProcessDepositDecline tests that provider declines are recorded with a normalized code
+// and reported in the response without marking the gateway down
+func TestProcessDepositDecline(t *testing.T) {
+	var storedCode, storedStatus string
+	gatewayMarkedDown := false
+
+	mockDB := &mockDB{
+		getUserFunc: func(id int) (*models.User, error) {
+			return &models.User{ID: id, CountryID: 1}, nil
+		},
+		createTransactionFunc: func(tx models.Transaction) (int, error) {
+			return 123, nil
+		},
+		updateDeclineCodeFunc: func(id int, code string) error {
+			storedCode = code
+			return nil
+		},
+		updateStatusFunc: func(id int, status, errorMsg string) error {
+			storedStatus = status
+			return nil
+		},
+	}
+
+	mockProvider := &mockProvider{
+		id:         "1",
+		name:       "TestGateway",
+		dataFormat: "application/json",
+		processDepositFunc: func(ctx context.Context, tx models.Transaction) (*models.TransactionResponse, error) {
+			return nil, &gateway.DeclineError{Code: consts.DeclineInsufficientFunds, ProviderCode: "51", Message: "Not sufficient funds"}
+		},
+	}
+
+	mockSelector := &mockGatewaySelector{
+		selectGatewayFunc: func(ctx context.Context, countryID int, txType string) (gateway.Provider, error) {
+			return mockProvider, nil
+		},
+		markDownFunc: func(gatewayID string) {
+			gatewayMarkedDown = true
+		},
+	}
+
+	service := NewTransactionService(mockDB, mockSelector)
+
+	request := models.TransactionRequest{UserID: 1, Amount: 100.0, Currency: "USD"}
+	response, err := service.ProcessDeposit(context.Background(), request)
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+
+	if response.Status != consts.Failed || response.DeclineCode != consts.DeclineInsufficientFunds || response.RecoveryHint != consts.RecoveryUseOtherMethod {
+		t.Errorf("Expected failed response with decline code and recovery hint, got: %+v", response)
+	}
+
+	if storedCode != consts.DeclineInsufficientFunds || storedStatus != consts.Failed {
+		t.Errorf("Expected decline stored on transaction, got code %q status %q", storedCode, storedStatus)
+	}
+
+	if gatewayMarkedDown {
+		t.Error("Expected gateway to stay up after a decline")
+	}
+}
+
+// TestProcessDepositRetriesSoftDecline tests that a soft decline is retried once on an alternate
+// gateway and that hard declines are not retried
+func TestProcessDepositRetriesSoftDecline(t *testing.T) {
+	tests := []struct {
+		name            string
+		declineCode     string
+		expectedStatus  string
+		expectedRetries int
+	}{
+		{"soft decline retried", consts.DeclineIssuerUnavailable, consts.Processing, 1},
+		{"hard decline not retried", consts.DeclineFraudSuspected, consts.Failed, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var created []models.Transaction
+
+			mockDB := &mockDB{
+				getUserFunc: func(id int) (*models.User, error) {
+					return &models.User{ID: id, CountryID: 1}, nil
+				},
+				createTransactionFunc: func(tx models.Transaction) (int, error) {
+					created = append(created, tx)
+					return 100 + len(created), nil
+				},
+			}
+
+			declining := &mockProvider{
+				id: "1",
+				processDepositFunc: func(ctx context.Context, tx models.Transaction) (*models.TransactionResponse, error) {
+					return nil, &gateway.DeclineError{Code: tt.declineCode, Message: "declined"}
+				},
+			}
+			alternate := &mockProvider{id: "2"}
+
+			mockSelector := &mockGatewaySelector{
+				selectWithOptsFunc: func(ctx context.Context, countryID int, txType string, opts gateway.SelectionOptions) (gateway.Provider, models.RoutingDecision, error) {
+					if len(opts.ExcludedGatewayIDs) > 0 && opts.ExcludedGatewayIDs[0] == "1" {
+						return alternate, models.RoutingDecision{}, nil
+					}
+					return declining, models.RoutingDecision{}, nil
+				},
+			}
+
+			service := NewTransactionService(mockDB, mockSelector)
+
+			request := models.TransactionRequest{UserID: 1, Amount: 100.0, Currency: "USD"}
+			response, err := service.ProcessDeposit(context.Background(), request)
+			if err != nil {
+				t.Fatalf("Expected no error, got: %v", err)
+			}
+
+			if response.Status != tt.expectedStatus {
+				t.Errorf("Expected status %s, got: %s", tt.expectedStatus, response.Status)
+			}
+
+			if len(created) != tt.expectedRetries+1 {
+				t.Fatalf("Expected %d transactions, got %d", tt.expectedRetries+1, len(created))
+			}
+
+			if tt.expectedRetries > 0 {
+				if created[1].GatewayID != 2 || created[1].RetryOfID != 101 {
+					t.Errorf("Expected retry on gateway 2 linked to transaction 101, got: %+v", created[1])
+				}
+				if response.TransactionID != 102 || response.RetryOfTransactionID != 101 {
+					t.Errorf("Expected response for retry 102 of 101, got: %+v", response)
+				}
+			}
+		})
+	}
+}
+
+// TestProcessDepositAssignsIdempotencyKey tests that a deterministic key is stored and sent to the gateway
+func TestProcessDepositAssignsIdempotencyKey(t *testing.T) {
+	var storedKey, sentKey string
+
+	mockDB := &mockDB{
+		getUserFunc: func(id int) (*models.User, error) {
+			return &models.User{ID: id, CountryID: 1}, nil
+		},
+		createTransactionFunc: func(tx models.Transaction) (int, error) {
+			return 123, nil
+		},
+		updateIdempotencyKeyFunc: func(id int, key string) error {
+			storedKey = key
+			return nil
+		},
+	}
+
+	mockProvider := &mockProvider{
+		id:         "1",
+		name:       "TestGateway",
+		dataFormat: "application/json",
+		processDepositFunc: func(ctx context.Context, tx models.Transaction) (*models.TransactionResponse, error) {
+			sentKey = tx.GatewayIdempotencyKey
+			return &models.TransactionResponse{Status: "processing", TransactionID: tx.ID}, nil
+		},
+	}
+
+	mockSelector := &mockGatewaySelector{
+		selectGatewayFunc: func(ctx context.Context, countryID int, txType string) (gateway.Provider, error) {
+			return mockProvider, nil
+		},
+	}
+
+	service := NewTransactionService(mockDB, mockSelector)
+
+	request := models.TransactionRequest{UserID: 1, Amount: 100.0, Currency: "USD"}
+	if _, err := service.ProcessDeposit(context.Background(), request); err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+
+	if storedKey == "" {
+		t.Fatal("Expected idempotency key to be stored on the transaction")
+	}
+
+	if sentKey != storedKey {
+		t.Errorf("Expected gateway to receive stored key %q, got %q", storedKey, sentKey)
+	}
+
+	if expected := utils.GatewayIdempotencyKey("1", "deposit", 123, 100.0, "USD"); storedKey != expected {
+		t.Errorf("Expected deterministic key %q, got %q", expected, storedKey)
+	}
+}
+
+// TestProcessDepositPublishesEvents tests that lifecycle events reach filtered subscribers
+func TestProcessDepositPublishesEvents(t *testing.T) {
+	mockDB := &mockDB{
+		getUserFunc: func(id int) (*models.User, error) {
+			return &models.User{ID: id, CountryID: 1}, nil
+		},
+		createTransactionFunc: func(tx models.Transaction) (int, error) {
+			return 123, nil
+		},
+	}
+
+	mockSelector := &mockGatewaySelector{
+		selectGatewayFunc: func(ctx context.Context, countryID int, txType string) (gateway.Provider, error) {
+			return &mockProvider{id: "1", name: "TestGateway", dataFormat: "application/json"}, nil
+		},
+	}
+
+	service := NewTransactionService(mockDB, mockSelector)
+
+	ch, cancel := service.Events().Subscribe(events.Filter{}, 10)
+	defer cancel()
+
+	request := models.TransactionRequest{UserID: 1, Amount: 100.0, Currency: "USD"}
+	if _, err := service.ProcessDeposit(context.Background(), request); err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+
+	if len(ch) != 2 {
+		t.Fatalf("Expected 2 events, got %d", len(ch))
+	}
+
+	if evt := <-ch; evt.Type != events.TransactionCreated || evt.Transaction.Status != "pending" {
+		t.Errorf("Expected created event with pending status, got: %+v", evt)
+	}
+
+	if evt := <-ch; evt.Type != events.TransactionStatusChanged || evt.Transaction.Status != "processing" {
+		t.Errorf("Expected status change to processing, got: %+v", evt)
+	}
 }
 
 // TestProcessDepositWithInvalidUser tests deposit with an invalid user
@@ -344,19 +712,64 @@ func TestProcessDepositWithGatewayFailure(t *testing.T) {
 	}
 }
 
+// TestProcessWithdrawalBalanceConcurrent tests that concurrent withdrawals can't together exceed
+// the user's wallet balance, as it is checked while the user is held
+func TestProcessWithdrawalBalanceConcurrent(t *testing.T) {
+	mockDB := db.NewMockDB()
+	service := NewTransactionService(mockDB, newRulesSelector(mockDB))
+	ctx := context.Background()
+
+	if _, err := mockDB.CreateTransaction(models.Transaction{UserID: 1, Amount: 100, Currency: "USD", Type: consts.Deposit, Status: consts.Completed, GatewayID: 1}); err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+
+	var wg sync.WaitGroup
+	var accepted atomic.Int32
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := service.ProcessWithdrawal(ctx, models.TransactionRequest{UserID: 1, Amount: 30, Currency: "USD", Beneficiary: "Jane Doe"})
+			if err == nil {
+				accepted.Add(1)
+			} else if !errors.Is(err, ErrInsufficientBalance) {
+				t.Errorf("Expected withdrawals over the balance refused with ErrInsufficientBalance, got: %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if got := accepted.Load(); got != 3 {
+		t.Errorf("Expected 3 accepted withdrawals, got %d", got)
+	}
+	if balance, _ := mockDB.GetWalletBalance(1, "USD", false); balance != 10 {
+		t.Errorf("Expected 10 left in the wallet, got %v", balance)
+	}
+}
+
 // TestHandleCallback tests callback handling
 func TestHandleCallback(t *testing.T) {
 	// Create test fixtures
 	var statusUpdated bool
 	var gatewayMarkedUp bool
+	var gatewayReference string
 
 	mockDB := &mockDB{
+		getTransactionFunc: func(id int) (*models.Transaction, error) {
+			return &models.Transaction{ID: id, Status: consts.Processing}, nil
+		},
 		updateStatusFunc: func(id int, status, errorMsg string) error {
 			if id == 123 && status == "completed" {
 				statusUpdated = true
 			}
 			return nil
 		},
+		updateGatewayRefFunc: func(id int, ref, redirectURL string) error {
+			if id == 123 {
+				gatewayReference = ref
+			}
+			return nil
+		},
 	}
 
 	mockSelector := &mockGatewaySelector{
@@ -372,10 +785,10 @@ func TestHandleCallback(t *testing.T) {
 
 	// Create callback data
 	callbackData := &models.CallbackData{
-		TransactionID: 123,
-		Status:        "completed",
-		ReferenceID:   "ref-123",
-		GatewayID:     "1",
+		TransactionID:    123,
+		Status:           "completed",
+		GatewayReference: "ref-123",
+		GatewayID:        "1",
 	}
 
 	// Process callback
@@ -392,6 +805,11 @@ func TestHandleCallback(t *testing.T) {
 		t.Error("Expected transaction status to be updated")
 	}
 
+	// Verify the gateway's reference was stored
+	if gatewayReference != "ref-123" {
+		t.Errorf("Expected gateway reference ref-123, got %q", gatewayReference)
+	}
+
 	// Verify gateway was marked up
 	if !gatewayMarkedUp {
 		t.Error("Expected gateway to be marked up")
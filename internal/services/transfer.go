@@ -0,0 +1,224 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"payment-gateway/internal/consts"
+	"payment-gateway/internal/events"
+	"payment-gateway/internal/models"
+	"payment-gateway/internal/money"
+	"time"
+)
+
+var (
+	ErrInvalidTransfer           = errors.New("invalid transfer")
+	ErrTransferNotFound          = errors.New("transfer not found")
+	ErrTransferRecipientNotFound = errors.New("transfer recipient not found")
+)
+
+// TransferLimits bounds the wallet transfers a user sends, in the transfer's currency. A zero
+// limit is not enforced.
+type TransferLimits struct {
+	MaxAmount          float64 // per transfer
+	DailyAmount        float64 // sent within consts.TransferLimitWindow
+	DailyCount         int     // sent within consts.TransferLimitWindow
+	NewRecipientAmount float64 // first transfer to a recipient; larger ones are declined as suspected fraud
+}
+
+// DefaultTransferLimits returns the limits transfers are held to unless configured otherwise
+func DefaultTransferLimits() TransferLimits {
+	return TransferLimits{
+		MaxAmount:          consts.DefaultTransferMaxAmount,
+		DailyAmount:        consts.DefaultTransferDailyAmount,
+		DailyCount:         consts.DefaultTransferDailyCount,
+		NewRecipientAmount: consts.DefaultTransferNewRecipientAmount,
+	}
+}
+
+// SetTransferLimits configures the limits wallet transfers are held to
+func (s *TransactionService) SetTransferLimits(limits TransferLimits) {
+	s.transferLimits = limits
+}
+
+// Transfer moves funds from one user's wallet to another's on behalf of the merchant both users
+// belong to; neither may be held by sanctions screening. The transfer never reaches a gateway:
+// it is recorded in the wallet ledger, completed when the sender's balance covers it and within
+// their limits, and declined otherwise. Declined transfers are returned with their decline code
+// rather than as an error, and either outcome is sent to the merchant's webhook.
+func (s *TransactionService) Transfer(ctx context.Context, merchantID int, req models.TransferRequest) (*models.Transfer, error) {
+	if req.FromUserID == req.ToUserID {
+		return nil, fmt.Errorf("%w: the sender and recipient are the same user", ErrInvalidTransfer)
+	}
+	amount, err := s.roundedAmount(req.Amount, req.Currency)
+	if err != nil {
+		return nil, err
+	}
+
+	sender, err := s.merchantUser(req.FromUserID, merchantID)
+	if err != nil {
+		return nil, err
+	}
+	recipient, err := s.db.GetUserByID(req.ToUserID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrTransferRecipientNotFound
+		}
+		return nil, fmt.Errorf("failed to get user: %w", err)
+	}
+	if recipient.MerchantID != sender.MerchantID {
+		return nil, ErrTransferRecipientNotFound
+	}
+
+	// Funds cannot move to or from users whose name matched a sanctions list
+	if err := screeningHold(sender); err != nil {
+		return nil, err
+	}
+	if err := screeningHold(recipient); err != nil {
+		return nil, err
+	}
+
+	livemode, err := s.livemode(sender)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	transfer := models.Transfer{
+		FromUserID:  sender.ID,
+		ToUserID:    recipient.ID,
+		MerchantID:  sender.MerchantID,
+		Amount:      amount,
+		Currency:    req.Currency,
+		Description: req.Description,
+		Status:      consts.Completed,
+		Livemode:    livemode,
+		CreatedAt:   now,
+	}
+
+	// The store decides the transfer while holding the sender, so concurrent transfers can't
+	// spend the same funds or both fit under the same limit
+	recorded, err := s.db.CreateTransfer(transfer, now.Add(-consts.TransferLimitWindow), s.checkTransfer)
+	if err != nil {
+		return nil, fmt.Errorf("failed to record transfer: %w", err)
+	}
+	transfer = *recorded
+
+	if transfer.Status == consts.Completed {
+		log.Printf("Transfer %d: user %d sent %v %s to user %d", transfer.ID, sender.ID, amount, req.Currency, recipient.ID)
+	} else {
+		log.Printf("Transfer %d from user %d to user %d declined: %s", transfer.ID, sender.ID, recipient.ID, transfer.DeclineCode)
+	}
+	s.emitTransfer(transfer)
+
+	return &transfer, nil
+}
+
+// checkTransfer flags a transfer to a new recipient and declines one breaking the sender's limits,
+// given the transfers they already sent
+func (s *TransactionService) checkTransfer(transfer models.Transfer, stats models.TransferStats) models.Transfer {
+	if stats.ToRecipient == 0 {
+		transfer.RiskFlags = append(transfer.RiskFlags, consts.RiskNewRecipient)
+	}
+	if transfer.DeclineCode = s.transferDecline(transfer, stats); transfer.DeclineCode != "" {
+		transfer.Status = consts.Failed
+	}
+	return transfer
+}
+
+// transferDecline returns the decline code of a transfer breaking the sender's limits given the
+// transfers they already sent, or "" when it is within them
+func (s *TransactionService) transferDecline(transfer models.Transfer, stats models.TransferStats) string {
+	limits := s.transferLimits
+	units := func(amount float64) int64 { return money.MinorUnits(amount, transfer.Currency) }
+
+	switch {
+	case limits.MaxAmount > 0 && units(transfer.Amount) > units(limits.MaxAmount):
+		return consts.DeclineLimitExceeded
+	case limits.DailyCount > 0 && stats.Count >= limits.DailyCount:
+		return consts.DeclineLimitExceeded
+	case limits.DailyAmount > 0 && units(stats.Amount)+units(transfer.Amount) > units(limits.DailyAmount):
+		return consts.DeclineLimitExceeded
+	case limits.NewRecipientAmount > 0 && stats.ToRecipient == 0 && units(transfer.Amount) > units(limits.NewRecipientAmount):
+		// Large first payments to a new recipient are how stolen accounts are emptied
+		return consts.DeclineFraudSuspected
+	}
+	return ""
+}
+
+// GetTransfer returns a transfer one of a merchant's users sent or received. Transfers of other
+// merchants' users are reported as not found.
+func (s *TransactionService) GetTransfer(ctx context.Context, merchantID, transferID, userID int) (*models.Transfer, error) {
+	if _, err := s.merchantUser(userID, merchantID); err != nil {
+		if errors.Is(err, ErrUserNotFound) {
+			return nil, ErrTransferNotFound
+		}
+		return nil, err
+	}
+	transfer, err := s.db.GetTransfer(transferID, userID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrTransferNotFound
+		}
+		return nil, err
+	}
+	return transfer, nil
+}
+
+// GetWalletBalance returns the wallet balance of one of a merchant's users in a currency, like
+// walletBalance. Other merchants' users are reported as not found.
+func (s *TransactionService) GetWalletBalance(ctx context.Context, merchantID, userID int, currency string) (*models.WalletBalance, error) {
+	user, err := s.merchantUser(userID, merchantID)
+	if err != nil {
+		return nil, err
+	}
+	return s.walletBalance(user, currency)
+}
+
+// walletBalance returns a user's spendable wallet balance in a currency, live or sandbox as their
+// merchant currently is, and the amount of their deposits held for review
+func (s *TransactionService) walletBalance(user *models.User, currency string) (*models.WalletBalance, error) {
+	livemode, err := s.livemode(user)
+	if err != nil {
+		return nil, err
+	}
+
+	balance, err := s.db.GetWalletBalance(user.ID, currency, livemode)
+	if err != nil {
+		return nil, err
+	}
+	held, err := s.db.GetHeldAmount(user.ID, currency, livemode)
+	if err != nil {
+		return nil, err
+	}
+	return &models.WalletBalance{UserID: user.ID, Currency: currency, Balance: balance, Held: held, Livemode: livemode}, nil
+}
+
+// emitTransfer records the merchant webhook announcing a completed or declined transfer
+func (s *TransactionService) emitTransfer(transfer models.Transfer) {
+	if transfer.MerchantID == 0 {
+		return
+	}
+
+	evt := events.TransferEvent{Type: events.TransferCompleted, Transfer: transfer, OccurredAt: time.Now()}
+	if transfer.Status == consts.Failed {
+		evt.Type = events.TransferFailed
+	}
+	payload, err := json.Marshal(evt)
+	if err != nil {
+		log.Printf("Failed to marshal %s event for transfer %d: %v", evt.Type, transfer.ID, err)
+		return
+	}
+
+	s.enqueue(models.OutboxMessage{
+		Destination: consts.OutboxMerchantWebhook,
+		EventType:   evt.Type,
+		MerchantID:  transfer.MerchantID,
+		DedupToken:  OutboxDedupToken(evt.Type, transfer.ID, transfer.Status),
+		ContentType: "application/json",
+		Payload:     payload,
+	})
+}
@@ -0,0 +1,163 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"payment-gateway/db"
+	"payment-gateway/internal/consts"
+	"payment-gateway/internal/events"
+	"payment-gateway/internal/models"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// TestTransfer tests that transfers move funds between wallets only when covered and within limits
+func TestTransfer(t *testing.T) {
+	mockDB := db.NewMockDB()
+	service := NewTransactionService(mockDB, &mockGatewaySelector{})
+	ctx := context.Background()
+
+	for _, tx := range []models.Transaction{
+		{UserID: 1, Amount: 100, Currency: "USD", Type: consts.Deposit, Status: consts.Completed, GatewayID: 1},
+		{UserID: 1, Amount: 500, Currency: "USD", Type: consts.Deposit, Status: consts.Failed, GatewayID: 1},
+		{UserID: 1, Amount: 10, Currency: "USD", Type: consts.Withdrawal, Status: consts.Processing, GatewayID: 1},
+	} {
+		if _, err := mockDB.CreateTransaction(tx); err != nil {
+			t.Fatalf("Expected no error, got: %v", err)
+		}
+	}
+	balance := func(userID int) float64 {
+		t.Helper()
+		wallet, err := service.GetWalletBalance(ctx, 1, userID, "USD")
+		if err != nil {
+			t.Fatalf("Expected no error, got: %v", err)
+		}
+		return wallet.Balance
+	}
+	if got := balance(1); got != 90 {
+		t.Fatalf("Expected a balance of 90, got %v", got)
+	}
+
+	transfer, err := service.Transfer(ctx, 1, models.TransferRequest{FromUserID: 1, ToUserID: 2, Amount: 30.004, Currency: "USD", Description: "Dinner"})
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	if transfer.Status != consts.Completed || transfer.Amount != 30 || len(transfer.RiskFlags) != 1 || transfer.RiskFlags[0] != consts.RiskNewRecipient {
+		t.Errorf("Expected a completed transfer to a new recipient, got %+v", transfer)
+	}
+	if balance(1) != 60 || balance(2) != 30 {
+		t.Errorf("Expected balances of 60 and 30, got %v and %v", balance(1), balance(2))
+	}
+	if got, err := service.GetTransfer(ctx, 1, transfer.ID, 2); err != nil || got.Amount != 30 {
+		t.Errorf("Expected the recipient to see the transfer, got %+v, %v", got, err)
+	}
+	if _, err := service.GetTransfer(ctx, 1, transfer.ID, 3); !errors.Is(err, ErrTransferNotFound) {
+		t.Errorf("Expected ErrTransferNotFound for another user, got: %v", err)
+	}
+	if _, err := service.GetTransfer(ctx, 2, transfer.ID, 2); !errors.Is(err, ErrTransferNotFound) {
+		t.Errorf("Expected ErrTransferNotFound for another merchant, got: %v", err)
+	}
+	if _, err := service.GetWalletBalance(ctx, 2, 1, "USD"); !errors.Is(err, ErrUserNotFound) {
+		t.Errorf("Expected ErrUserNotFound for another merchant's user's wallet, got: %v", err)
+	}
+
+	declined, err := service.Transfer(ctx, 1, models.TransferRequest{FromUserID: 1, ToUserID: 2, Amount: 80, Currency: "USD"})
+	if err != nil || declined.Status != consts.Failed || declined.DeclineCode != consts.DeclineInsufficientFunds {
+		t.Errorf("Expected the transfer declined for insufficient funds, got %+v, %v", declined, err)
+	}
+	if balance(1) != 60 {
+		t.Errorf("Expected a declined transfer to move nothing, got %v", balance(1))
+	}
+
+	// Both outcomes are sent to the merchant's webhook
+	messages, _ := mockDB.GetPendingOutboxMessages(consts.OutboxMerchantWebhook, time.Now(), consts.OutboxBatchSize)
+	if len(messages) != 2 || messages[0].EventType != events.TransferCompleted || messages[1].EventType != events.TransferFailed {
+		t.Fatalf("Expected transfer.completed and transfer.failed webhooks, got %+v", messages)
+	}
+	var evt events.TransferEvent
+	if err := json.Unmarshal(messages[0].Payload, &evt); err != nil || evt.Transfer.ID != transfer.ID || messages[0].MerchantID != 1 {
+		t.Errorf("Expected the webhook to carry the transfer, got %s, %v", messages[0].Payload, err)
+	}
+
+	service.SetTransferLimits(TransferLimits{MaxAmount: 40, DailyCount: 3, NewRecipientAmount: 5})
+	tests := []struct {
+		name        string
+		req         models.TransferRequest
+		declineCode string
+	}{
+		{"over the new recipient amount", models.TransferRequest{FromUserID: 1, ToUserID: 3, Amount: 10, Currency: "USD"}, consts.DeclineFraudSuspected},
+		{"known recipient", models.TransferRequest{FromUserID: 1, ToUserID: 2, Amount: 10, Currency: "USD"}, ""},
+		{"over the maximum amount", models.TransferRequest{FromUserID: 1, ToUserID: 2, Amount: 40.01, Currency: "USD"}, consts.DeclineLimitExceeded},
+		{"within the daily count", models.TransferRequest{FromUserID: 1, ToUserID: 3, Amount: 5, Currency: "USD"}, ""},
+		{"over the daily count", models.TransferRequest{FromUserID: 1, ToUserID: 2, Amount: 5, Currency: "USD"}, consts.DeclineLimitExceeded},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			transfer, err := service.Transfer(ctx, 1, tt.req)
+			if err != nil {
+				t.Fatalf("Expected no error, got: %v", err)
+			}
+			if transfer.DeclineCode != tt.declineCode || (tt.declineCode == "") != (transfer.Status == consts.Completed) {
+				t.Errorf("Expected decline code %q, got %+v", tt.declineCode, transfer)
+			}
+		})
+	}
+	if balance(1) != 45 {
+		t.Errorf("Expected a balance of 45, got %v", balance(1))
+	}
+
+	otherMerchant, _ := mockDB.CreateUser(models.User{Username: "other", Email: "other@example.com", CountryID: 1, MerchantID: 2})
+	if _, err := service.Transfer(ctx, 1, models.TransferRequest{FromUserID: 2, ToUserID: otherMerchant, Amount: 5, Currency: "USD"}); !errors.Is(err, ErrTransferRecipientNotFound) {
+		t.Errorf("Expected ErrTransferRecipientNotFound for another merchant's user, got: %v", err)
+	}
+	if _, err := service.Transfer(ctx, 2, models.TransferRequest{FromUserID: 1, ToUserID: 2, Amount: 5, Currency: "USD"}); !errors.Is(err, ErrUserNotFound) {
+		t.Errorf("Expected ErrUserNotFound sending from another merchant's user, got: %v", err)
+	}
+	if _, err := service.Transfer(ctx, 1, models.TransferRequest{FromUserID: 2, ToUserID: 2, Amount: 5, Currency: "USD"}); !errors.Is(err, ErrInvalidTransfer) {
+		t.Errorf("Expected ErrInvalidTransfer for a transfer to oneself, got: %v", err)
+	}
+	mockDB.UpdateUserScreeningStatus(3, consts.ScreeningPendingReview)
+	if _, err := service.Transfer(ctx, 1, models.TransferRequest{FromUserID: 2, ToUserID: 3, Amount: 5, Currency: "USD"}); !errors.Is(err, ErrScreeningHold) {
+		t.Errorf("Expected ErrScreeningHold for a recipient pending review, got: %v", err)
+	}
+}
+
+// TestTransferLimitsConcurrent tests that concurrent transfers from a user can't together exceed
+// their daily count, as the limits are checked while the sender is held
+func TestTransferLimitsConcurrent(t *testing.T) {
+	mockDB := db.NewMockDB()
+	service := NewTransactionService(mockDB, &mockGatewaySelector{})
+	service.SetTransferLimits(TransferLimits{DailyCount: 3})
+	ctx := context.Background()
+
+	if _, err := mockDB.CreateTransaction(models.Transaction{UserID: 1, Amount: 100, Currency: "USD", Type: consts.Deposit, Status: consts.Completed, GatewayID: 1}); err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+
+	var wg sync.WaitGroup
+	var completed atomic.Int32
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			transfer, err := service.Transfer(ctx, 1, models.TransferRequest{FromUserID: 1, ToUserID: 2, Amount: 1, Currency: "USD"})
+			if err != nil {
+				t.Errorf("Expected no error, got: %v", err)
+				return
+			}
+			if transfer.Status == consts.Completed {
+				completed.Add(1)
+			} else if transfer.DeclineCode != consts.DeclineLimitExceeded {
+				t.Errorf("Expected transfers over the count declined with limit_exceeded, got %+v", transfer)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if got := completed.Load(); got != 3 {
+		t.Errorf("Expected 3 completed transfers, got %d", got)
+	}
+}
@@ -0,0 +1,102 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"payment-gateway/internal/consts"
+	"payment-gateway/internal/gateway"
+	"payment-gateway/internal/models"
+	"strconv"
+)
+
+var (
+	ErrInvalidUPIPayment  = errors.New("invalid UPI payment")
+	ErrUPIGatewayNotFound = errors.New("UPI gateway not found")
+)
+
+// ValidateVPA asks a UPI gateway whether a virtual payment address exists and whose it is, in the
+// environment of the user's merchant, so customers can confirm it before a collect request is
+// sent to it
+func (s *TransactionService) ValidateVPA(ctx context.Context, req models.VPAValidationRequest) (*models.VPAValidation, error) {
+	user, err := s.db.GetUserByID(req.UserID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrUserNotFound
+		}
+		return nil, fmt.Errorf("failed to get user: %w", err)
+	}
+	livemode, err := s.livemode(user)
+	if err != nil {
+		return nil, err
+	}
+
+	validator, err := s.vpaValidator(req.GatewayID)
+	if err != nil {
+		return nil, err
+	}
+
+	validation, err := validator.ValidateVPA(ctx, req.VPA, livemode)
+	if err != nil {
+		return nil, fmt.Errorf("failed to validate VPA at %s: %w", validator.Name(), err)
+	}
+	log.Printf("Validated VPA for user %d at %s: valid %t", user.ID, validator.Name(), validation.Valid)
+	return validation, nil
+}
+
+// vpaValidator returns the UPI gateway with an ID, or the only one for 0
+func (s *TransactionService) vpaValidator(gatewayID int) (gateway.VPAValidator, error) {
+	var found []gateway.VPAValidator
+	for _, provider := range s.gatewaySelector.Providers() {
+		validator, ok := provider.(gateway.VPAValidator)
+		if ok && (gatewayID == 0 || provider.ID() == strconv.Itoa(gatewayID)) {
+			found = append(found, validator)
+		}
+	}
+	switch {
+	case len(found) == 0:
+		return nil, ErrUPIGatewayNotFound
+	case len(found) > 1:
+		return nil, fmt.Errorf("%w: several UPI gateways are registered; name one with gateway_id", ErrInvalidUPIPayment)
+	}
+	return found[0], nil
+}
+
+// validateUPIPayment checks that a VPA is only given for deposits naming no other way to pay
+func validateUPIPayment(txType string, req models.TransactionRequest) error {
+	if req.VPA == "" {
+		return nil
+	}
+	if txType != consts.Deposit {
+		return fmt.Errorf("%w: collect requests apply to deposits only", ErrInvalidUPIPayment)
+	}
+	if req.PhoneNumber != "" || req.BankID != "" || req.MandateID != 0 || req.CardBIN != "" {
+		return fmt.Errorf("%w: deposits collected from a VPA cannot name a wallet, bank, mandate or card", ErrInvalidUPIPayment)
+	}
+	return nil
+}
+
+// applyUPIPayment routes a deposit collected from a VPA to a UPI gateway. A preferred gateway in
+// the request must be one.
+func (s *TransactionService) applyUPIPayment(opts *gateway.SelectionOptions, req models.TransactionRequest) error {
+	if req.VPA == "" {
+		return nil
+	}
+
+	if opts.PreferredGatewayID != "" {
+		provider, err := s.gatewaySelector.GetProviderByID(opts.PreferredGatewayID)
+		if err != nil || !gateway.SupportsVPAValidation(provider) {
+			return fmt.Errorf("%w: the preferred gateway %s does not take UPI payments", ErrInvalidUPIPayment, opts.PreferredGatewayID)
+		}
+		return nil
+	}
+
+	validator, err := s.vpaValidator(0)
+	if err != nil {
+		return err
+	}
+	opts.PreferredGatewayID = validator.ID()
+	return nil
+}
@@ -0,0 +1,94 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"payment-gateway/db"
+	"payment-gateway/internal/consts"
+	"payment-gateway/internal/gateway"
+	"payment-gateway/internal/models"
+	"testing"
+)
+
+// mockUPIProvider knows one VPA and records the payer VPAs of the deposits it was sent
+type mockUPIProvider struct {
+	*gateway.MockProvider
+	payerVPAs []string
+	livemode  bool
+}
+
+func (p *mockUPIProvider) ValidateVPA(ctx context.Context, vpa string, livemode bool) (*models.VPAValidation, error) {
+	p.livemode = livemode
+	if vpa != "alice@okaxis" {
+		return &models.VPAValidation{VPA: vpa}, nil
+	}
+	return &models.VPAValidation{VPA: vpa, Valid: true, Name: "ALICE KUMAR"}, nil
+}
+
+func (p *mockUPIProvider) ProcessDeposit(ctx context.Context, transaction models.Transaction) (*models.TransactionResponse, error) {
+	p.payerVPAs = append(p.payerVPAs, transaction.PayerVPA)
+	return &models.TransactionResponse{TransactionID: transaction.ID, Status: consts.Processing, GatewayReference: transaction.ReferenceID,
+		UPI: &models.UPIPayment{Flow: consts.UPIFlowCollect}}, nil
+}
+
+// TestUPIPayment tests that VPAs are validated at the UPI gateway and that deposits naming one are
+// collected from it there
+func TestUPIPayment(t *testing.T) {
+	mockDB := db.NewMockDB()
+	userID, err := mockDB.CreateUser(models.User{Username: "alice", Email: "alice@example.com", CountryID: 1, MerchantID: 1})
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+
+	upi := &mockUPIProvider{MockProvider: gateway.NewMockProvider(3, "UPI", "application/json", 1.0, 0)}
+	selector := gateway.NewSelector(mockDB)
+	selector.RegisterProvider(gateway.NewMockProvider(2, "Stripe", "application/json", 1.0, 0))
+	ctx := context.Background()
+
+	service := NewTransactionService(mockDB, selector)
+	if _, err := service.ValidateVPA(ctx, models.VPAValidationRequest{UserID: userID, VPA: "alice@okaxis"}); !errors.Is(err, ErrUPIGatewayNotFound) {
+		t.Errorf("Expected ErrUPIGatewayNotFound without a UPI gateway, got: %v", err)
+	}
+
+	selector.RegisterProvider(upi)
+	validation, err := service.ValidateVPA(ctx, models.VPAValidationRequest{UserID: userID, VPA: "alice@okaxis"})
+	if err != nil || !validation.Valid || validation.Name != "ALICE KUMAR" || upi.livemode {
+		t.Errorf("Expected a valid VPA validated in the sandbox, got %+v (%v)", validation, err)
+	}
+	if validation, err := service.ValidateVPA(ctx, models.VPAValidationRequest{UserID: userID, VPA: "nobody@okaxis"}); err != nil || validation.Valid {
+		t.Errorf("Expected an invalid VPA, got %+v (%v)", validation, err)
+	}
+	if _, err := service.ValidateVPA(ctx, models.VPAValidationRequest{UserID: userID, VPA: "alice@okaxis", GatewayID: 2}); !errors.Is(err, ErrUPIGatewayNotFound) {
+		t.Errorf("Expected ErrUPIGatewayNotFound for a gateway without UPI, got: %v", err)
+	}
+	if _, err := service.ValidateVPA(ctx, models.VPAValidationRequest{UserID: 999, VPA: "alice@okaxis"}); !errors.Is(err, ErrUserNotFound) {
+		t.Errorf("Expected ErrUserNotFound, got: %v", err)
+	}
+
+	// Deposits naming a VPA are collected from it at the UPI gateway, whatever the country prefers
+	response, err := service.ProcessDeposit(ctx, models.TransactionRequest{UserID: userID, Amount: 500, Currency: "INR", VPA: "alice@okaxis"})
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	if tx, _ := mockDB.GetTransactionByID(response.TransactionID); tx.GatewayID != 3 || response.UPI == nil {
+		t.Errorf("Expected a collect request at gateway 3, got %+v", response)
+	}
+	if len(upi.payerVPAs) != 1 || upi.payerVPAs[0] != "alice@okaxis" {
+		t.Errorf("Expected the deposit collected from alice@okaxis, got %v", upi.payerVPAs)
+	}
+
+	invalid := []struct {
+		name    string
+		process func(context.Context, models.TransactionRequest) (*models.TransactionResponse, error)
+		req     models.TransactionRequest
+	}{
+		{"withdrawal", service.ProcessWithdrawal, models.TransactionRequest{UserID: userID, Amount: 500, Currency: "INR", VPA: "alice@okaxis"}},
+		{"wallet", service.ProcessDeposit, models.TransactionRequest{UserID: userID, Amount: 500, Currency: "INR", VPA: "alice@okaxis", PhoneNumber: "+254712345678"}},
+		{"preferred gateway without UPI", service.ProcessDeposit, models.TransactionRequest{UserID: userID, Amount: 500, Currency: "INR", VPA: "alice@okaxis", PreferredGatewayID: 2}},
+	}
+	for _, tt := range invalid {
+		if _, err := tt.process(ctx, tt.req); !errors.Is(err, ErrInvalidUPIPayment) {
+			t.Errorf("%s: expected ErrInvalidUPIPayment, got: %v", tt.name, err)
+		}
+	}
+}
@@ -0,0 +1,123 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"payment-gateway/db"
+	"payment-gateway/internal/consts"
+	"payment-gateway/internal/geo"
+	"payment-gateway/internal/models"
+	"time"
+)
+
+var ErrUserNotFound = errors.New("user not found")
+
+// UserService manages user profile details
+type UserService struct {
+	db        db.DBInterface
+	screening *ScreeningService
+}
+
+// NewUserService creates a new user service
+func NewUserService(dbInterface db.DBInterface) *UserService {
+	return &UserService{db: dbInterface}
+}
+
+// SetScreening screens the names of new users against sanctions lists
+func (s *UserService) SetScreening(screening *ScreeningService) {
+	s.screening = screening
+}
+
+// Create registers a user. When screening is enabled, their full name is checked against the
+// sanctions lists first; a user whose name matches is created pending review, unable to transact
+// until a compliance officer clears the match.
+func (s *UserService) Create(ctx context.Context, req models.UserCreateRequest) (*models.User, error) {
+	country, err := s.db.GetCountryByCode(geo.NormalizeCountryCode(req.CountryCode))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, fmt.Errorf("%w: %s", ErrUnsupportedCountry, req.CountryCode)
+		}
+		return nil, fmt.Errorf("failed to get country: %w", err)
+	}
+	if req.MerchantID != 0 {
+		if _, err := s.db.GetMerchantByID(req.MerchantID); err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				return nil, ErrMerchantNotFound
+			}
+			return nil, err
+		}
+	}
+
+	user := models.User{
+		Username:   req.Username,
+		Email:      req.Email,
+		FullName:   req.FullName,
+		CountryID:  country.ID,
+		MerchantID: req.MerchantID,
+		CreatedAt:  time.Now(),
+	}
+
+	var matches []models.ScreeningMatch
+	if s.screening.enabled() {
+		if matches, err = s.screening.screen(ctx, req.FullName, country.Code); err != nil {
+			return nil, err
+		}
+		user.ScreeningStatus = consts.ScreeningClear
+		if len(matches) > 0 {
+			user.ScreeningStatus = consts.ScreeningPendingReview
+		}
+	}
+
+	if user.ID, err = s.db.CreateUser(user); err != nil {
+		return nil, err
+	}
+	user.UpdatedAt = user.CreatedAt
+
+	if s.screening.enabled() {
+		s.screening.record(models.ScreeningCase{
+			UserID:  user.ID,
+			Trigger: consts.ScreeningTriggerUserCreated,
+			Name:    user.FullName,
+			Matches: matches,
+		})
+	}
+	return &user, nil
+}
+
+// UpdateContact replaces a user's phone number and postal code. Both are checked against the
+// user's country and stored as entered together with their normalized form.
+func (s *UserService) UpdateContact(ctx context.Context, userID int, req models.UserContactRequest) (*models.User, error) {
+	user, err := s.db.GetUserByID(userID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrUserNotFound
+		}
+		return nil, err
+	}
+
+	country, err := s.db.GetCountryByID(user.CountryID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get user country: %w", err)
+	}
+
+	contact := models.UserContact{Phone: req.Phone, PostalCode: req.PostalCode}
+	if req.Phone != "" {
+		if contact.PhoneE164, err = geo.NormalizePhoneNumber(req.Phone, country.Code); err != nil {
+			return nil, fmt.Errorf("%w for %s", err, country.Code)
+		}
+	}
+	if req.PostalCode != "" {
+		if contact.PostalCodeNormalized, err = geo.NormalizePostalCode(req.PostalCode, country.Code); err != nil {
+			return nil, fmt.Errorf("%w for %s", err, country.Code)
+		}
+	}
+
+	if err := s.db.UpdateUserContact(userID, contact); err != nil {
+		return nil, err
+	}
+
+	user.UserContact = contact
+	return user, nil
+}
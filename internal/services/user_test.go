@@ -0,0 +1,46 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"payment-gateway/db"
+	"payment-gateway/internal/geo"
+	"payment-gateway/internal/models"
+	"testing"
+)
+
+// TestUpdateContact tests that contact details are normalized for the user's country and
+// stored alongside the raw input
+func TestUpdateContact(t *testing.T) {
+	mockDB := db.NewMockDB()
+	service := NewUserService(mockDB)
+	ctx := context.Background()
+
+	// User 2 is in the UK
+	user, err := service.UpdateContact(ctx, 2, models.UserContactRequest{Phone: "07911 123456", PostalCode: "sw1a1aa"})
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+
+	want := models.UserContact{Phone: "07911 123456", PhoneE164: "+447911123456", PostalCode: "sw1a1aa", PostalCodeNormalized: "SW1A 1AA"}
+	if user.UserContact != want {
+		t.Errorf("Expected %+v, got %+v", want, user.UserContact)
+	}
+
+	stored, _ := mockDB.GetUserByID(2)
+	if stored.UserContact != want {
+		t.Errorf("Expected stored contact %+v, got %+v", want, stored.UserContact)
+	}
+
+	// User 1 is in the US, where neither is valid
+	if _, err := service.UpdateContact(ctx, 1, models.UserContactRequest{Phone: "07911 123456"}); !errors.Is(err, geo.ErrInvalidPhoneNumber) {
+		t.Errorf("Expected ErrInvalidPhoneNumber, got: %v", err)
+	}
+	if _, err := service.UpdateContact(ctx, 1, models.UserContactRequest{PostalCode: "SW1A 1AA"}); !errors.Is(err, geo.ErrInvalidPostalCode) {
+		t.Errorf("Expected ErrInvalidPostalCode, got: %v", err)
+	}
+
+	if _, err := service.UpdateContact(ctx, 999, models.UserContactRequest{}); !errors.Is(err, ErrUserNotFound) {
+		t.Errorf("Expected ErrUserNotFound, got: %v", err)
+	}
+}
@@ -0,0 +1,74 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"payment-gateway/internal/consts"
+	"payment-gateway/internal/gateway"
+	"payment-gateway/internal/models"
+)
+
+var ErrInvalidWalletToken = errors.New("invalid wallet token")
+
+// MerchantCertificates returns the service managing the keys wallet payment tokens are decrypted with
+func (s *TransactionService) MerchantCertificates() *MerchantCertificateService {
+	return s.merchantCertificates
+}
+
+// validateWalletToken checks that a payment token is only given for deposits naming no other way
+// to pay
+func validateWalletToken(txType string, req models.TransactionRequest) error {
+	if req.PaymentToken == nil {
+		return nil
+	}
+	if txType != consts.Deposit {
+		return fmt.Errorf("%w: payment tokens apply to deposits only", ErrInvalidWalletToken)
+	}
+	if req.PhoneNumber != "" || req.BankID != "" || req.MandateID != 0 || req.VPA != "" || req.CardBIN != "" {
+		return fmt.Errorf("%w: deposits paid with a payment token cannot name a wallet, bank, mandate, VPA or card", ErrInvalidWalletToken)
+	}
+	return nil
+}
+
+// applyWalletToken keeps a deposit paid with a payment token away from gateways that take neither
+// the wallet's tokens nor cards. A preferred gateway in the request must take one of them.
+func (s *TransactionService) applyWalletToken(opts *gateway.SelectionOptions, req models.TransactionRequest) error {
+	if req.PaymentToken == nil {
+		return nil
+	}
+
+	wallet := req.PaymentToken.Type
+	for _, provider := range s.gatewaySelector.Providers() {
+		if gateway.TakesWalletToken(provider, wallet) {
+			continue
+		}
+		if provider.ID() == opts.PreferredGatewayID {
+			return fmt.Errorf("%w: the preferred gateway %s takes neither %s tokens nor cards", ErrInvalidWalletToken, opts.PreferredGatewayID, wallet)
+		}
+		opts.ExcludedGatewayIDs = append(opts.ExcludedGatewayIDs, provider.ID())
+	}
+	return nil
+}
+
+// attachWalletToken passes a deposit's payment token on to the gateway selected for it: as it is
+// to gateways taking the wallet's tokens, or as the card decrypted from it to the others, whose
+// BIN is recorded as the transaction's
+func (s *TransactionService) attachWalletToken(provider gateway.Provider, transaction *models.Transaction, token *models.WalletToken) error {
+	if token == nil {
+		return nil
+	}
+	if gateway.AcceptsWalletToken(provider, token.Type) {
+		transaction.WalletToken = token
+		return nil
+	}
+
+	card, err := s.merchantCertificates.Decrypt(*token)
+	if err != nil {
+		return err
+	}
+	transaction.WalletCard = card
+	if len(card.Number) >= 6 {
+		transaction.CardBIN = card.Number[:6]
+	}
+	return nil
+}
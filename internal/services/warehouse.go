@@ -0,0 +1,139 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"payment-gateway/db"
+	"payment-gateway/internal/consts"
+	"payment-gateway/internal/events"
+	"payment-gateway/internal/models"
+	"payment-gateway/internal/warehouse"
+	"time"
+)
+
+// WarehouseExporter writes completed transactions recorded in the outbox to a warehouse store
+// in date-partitioned files. A message is marked delivered only after the manifest of the file
+// holding it is written, and messages already listed in a manifest are never written again, so
+// each transaction appears in the warehouse exactly once.
+type WarehouseExporter struct {
+	db    db.DBInterface
+	store warehouse.Store
+
+	// committed caches the message IDs listed in each date partition's manifests
+	committed map[string]map[int]bool
+}
+
+// NewWarehouseExporter creates an exporter writing to store
+func NewWarehouseExporter(dbInterface db.DBInterface, store warehouse.Store) *WarehouseExporter {
+	return &WarehouseExporter{
+		db:        dbInterface,
+		store:     store,
+		committed: make(map[string]map[int]bool),
+	}
+}
+
+// ExportPending writes one batch of due messages and returns how many rows were written
+func (e *WarehouseExporter) ExportPending(ctx context.Context) (int, error) {
+	now := time.Now()
+	messages, err := e.db.GetPendingOutboxMessages(consts.OutboxWarehouse, now, consts.WarehouseBatchSize)
+	if err != nil {
+		return 0, fmt.Errorf("failed to fetch pending warehouse messages: %w", err)
+	}
+
+	partitions := make(map[string][]warehouse.Row)
+	messagesByDate := make(map[string][]models.OutboxMessage)
+	for _, msg := range messages {
+		var evt events.TransactionEvent
+		if err := json.Unmarshal(msg.Payload, &evt); err != nil {
+			log.Printf("Dropping malformed warehouse message %d: %v", msg.ID, err)
+			if err := e.db.MarkOutboxMessageRetry(msg.ID, err.Error(), now, true); err != nil {
+				log.Printf("Failed to record attempt for outbox message %d: %v", msg.ID, err)
+			}
+			continue
+		}
+
+		date := warehouse.Partition(evt.OccurredAt)
+		partitions[date] = append(partitions[date], warehouse.Row{MessageID: msg.ID, Transaction: evt.Transaction, CompletedAt: evt.OccurredAt})
+		messagesByDate[date] = append(messagesByDate[date], msg)
+	}
+
+	written := 0
+	for date, rows := range partitions {
+		n, err := e.exportPartition(ctx, date, rows)
+		if err != nil {
+			log.Printf("Failed to export warehouse partition %s: %v", date, err)
+			for _, msg := range messagesByDate[date] {
+				if err := e.db.MarkOutboxMessageRetry(msg.ID, err.Error(), now.Add(outboxBackoff(msg.Attempts+1)), false); err != nil {
+					log.Printf("Failed to record attempt for outbox message %d: %v", msg.ID, err)
+				}
+			}
+			continue
+		}
+		written += n
+
+		for _, msg := range messagesByDate[date] {
+			if err := e.db.MarkOutboxMessageDelivered(msg.ID); err != nil {
+				// The manifest already lists the message, so it is skipped when fetched again
+				log.Printf("Failed to mark outbox message %d delivered: %v", msg.ID, err)
+			}
+		}
+	}
+
+	return written, nil
+}
+
+// exportPartition commits the rows of one date partition that no manifest lists yet
+func (e *WarehouseExporter) exportPartition(ctx context.Context, date string, rows []warehouse.Row) (int, error) {
+	committed, ok := e.committed[date]
+	if !ok {
+		var err error
+		if committed, err = warehouse.CommittedMessages(ctx, e.store, date); err != nil {
+			return 0, err
+		}
+		e.committed[date] = committed
+	}
+
+	pending := rows[:0:0]
+	for _, row := range rows {
+		if !committed[row.MessageID] {
+			pending = append(pending, row)
+		}
+	}
+	if len(pending) == 0 {
+		return 0, nil
+	}
+
+	manifest, err := warehouse.Commit(ctx, e.store, date, pending)
+	if err != nil {
+		return 0, err
+	}
+	for _, id := range manifest.MessageIDs {
+		committed[id] = true
+	}
+
+	return manifest.Rows, nil
+}
+
+// enqueueWarehouse records a completed transaction for export when a warehouse is configured
+func (s *TransactionService) enqueueWarehouse(evt events.TransactionEvent) {
+	if !s.warehouseExport || evt.Type != events.TransactionStatusChanged || evt.Transaction.Status != consts.Completed {
+		return
+	}
+
+	payload, err := json.Marshal(evt)
+	if err != nil {
+		log.Printf("Failed to marshal %s event for transaction %d: %v", evt.Type, evt.Transaction.ID, err)
+		return
+	}
+
+	s.enqueue(models.OutboxMessage{
+		Destination:   consts.OutboxWarehouse,
+		EventType:     evt.Type,
+		TransactionID: evt.Transaction.ID,
+		DedupToken:    OutboxDedupToken(evt.Type, evt.Transaction.ID, evt.Transaction.Status),
+		ContentType:   "application/json",
+		Payload:       payload,
+	})
+}
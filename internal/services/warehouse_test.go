@@ -0,0 +1,71 @@
+package services
+
+import (
+	"context"
+	"payment-gateway/db"
+	"payment-gateway/internal/consts"
+	"payment-gateway/internal/models"
+	"payment-gateway/internal/warehouse"
+	"testing"
+	"time"
+)
+
+// TestWarehouseExportExactlyOnce tests that completed transactions are exported once, including
+// messages whose file was committed before they could be marked delivered
+func TestWarehouseExportExactlyOnce(t *testing.T) {
+	mockDB := db.NewMockDB()
+	service := NewTransactionService(mockDB, &mockGatewaySelector{})
+	service.SetWarehouseExport(true)
+	ctx := context.Background()
+
+	var ids []int
+	for i := 0; i < 3; i++ {
+		txID, err := mockDB.CreateTransaction(models.Transaction{UserID: 1, Amount: 10, Currency: "USD", Status: consts.Processing})
+		if err != nil {
+			t.Fatalf("Expected no error, got: %v", err)
+		}
+		ids = append(ids, txID)
+	}
+	for _, id := range ids[:2] {
+		if err := service.HandleCallback(ctx, &models.CallbackData{TransactionID: id, Status: consts.Completed}); err != nil {
+			t.Fatalf("Expected no error, got: %v", err)
+		}
+	}
+	if err := service.HandleCallback(ctx, &models.CallbackData{TransactionID: ids[2], Status: consts.Failed}); err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+
+	pending, _ := mockDB.GetPendingOutboxMessages(consts.OutboxWarehouse, time.Now(), consts.WarehouseBatchSize)
+	if len(pending) != 2 {
+		t.Fatalf("Expected 2 completed transactions queued for export, got %d", len(pending))
+	}
+
+	// Simulate a crash after the first message's file was committed but before it was marked delivered
+	store, err := warehouse.NewDirStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	date := warehouse.Partition(time.Now())
+	if _, err := warehouse.Commit(ctx, store, date, []warehouse.Row{{MessageID: pending[0].ID, Transaction: models.Transaction{ID: ids[0]}}}); err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+
+	exporter := NewWarehouseExporter(mockDB, store)
+	for i := 0; i < 2; i++ {
+		written, err := exporter.ExportPending(ctx)
+		if err != nil {
+			t.Fatalf("Expected no error, got: %v", err)
+		}
+		if want := 1 - i; written != want {
+			t.Errorf("Run %d: expected %d rows written, got %d", i, want, written)
+		}
+	}
+
+	committed, err := warehouse.CommittedMessages(ctx, store, date)
+	if err != nil || len(committed) != 2 {
+		t.Errorf("Expected both messages committed once, got %v, %v", committed, err)
+	}
+	if pending, _ := mockDB.GetPendingOutboxMessages(consts.OutboxWarehouse, time.Now(), consts.WarehouseBatchSize); len(pending) != 0 {
+		t.Errorf("Expected no pending warehouse messages, got %d", len(pending))
+	}
+}
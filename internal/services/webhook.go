@@ -0,0 +1,171 @@
+package services
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"payment-gateway/db"
+	"payment-gateway/internal/consts"
+	"payment-gateway/internal/models"
+	"payment-gateway/internal/utils"
+	"payment-gateway/sdk/webhook"
+	"strings"
+	"time"
+)
+
+var (
+	ErrInvalidWebhookSecret    = errors.New("invalid webhook secret")
+	ErrWebhookSecretNotFound   = errors.New("webhook secret not found")
+	ErrLastWebhookSecret       = errors.New("cannot retire the last active webhook secret; add a new one first")
+	ErrInvalidWebhookSignature = errors.New("invalid webhook signature")
+)
+
+// WebhookSecretService manages the secrets gateways sign their callbacks with and verifies
+// callback signatures against every active secret, so secrets can be rotated without downtime
+type WebhookSecretService struct {
+	db db.DBInterface
+}
+
+// NewWebhookSecretService creates a new webhook secret service
+func NewWebhookSecretService(dbInterface db.DBInterface) *WebhookSecretService {
+	return &WebhookSecretService{db: dbInterface}
+}
+
+// Add stores a new active secret for a gateway, generating one when secret is empty. The
+// plaintext secret is only returned here; it is stored encrypted.
+func (s *WebhookSecretService) Add(ctx context.Context, gatewayID int, secret string) (*models.WebhookSecret, error) {
+	if secret == "" {
+		generated, err := generateWebhookSecret()
+		if err != nil {
+			return nil, fmt.Errorf("failed to generate webhook secret: %w", err)
+		}
+		secret = generated
+	}
+
+	if len(secret) < consts.MinWebhookSecretLength {
+		return nil, fmt.Errorf("%w: must be at least %d characters", ErrInvalidWebhookSecret, consts.MinWebhookSecretLength)
+	}
+
+	encrypted, err := utils.EncryptString(secret)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encrypt webhook secret: %w", err)
+	}
+
+	record := models.WebhookSecret{
+		GatewayID: gatewayID,
+		Secret:    encrypted,
+		Hint:      secret[len(secret)-4:],
+		Status:    consts.WebhookSecretActive,
+		CreatedAt: time.Now(),
+	}
+
+	id, err := s.db.CreateWebhookSecret(record)
+	if err != nil {
+		return nil, err
+	}
+
+	record.ID = id
+	record.Secret = secret
+	return &record, nil
+}
+
+// List returns a gateway's active and retired secrets without their values
+func (s *WebhookSecretService) List(ctx context.Context, gatewayID int) ([]models.WebhookSecret, error) {
+	secrets, err := s.db.GetWebhookSecretsByGateway(gatewayID)
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range secrets {
+		secrets[i].Secret = ""
+	}
+	return secrets, nil
+}
+
+// Retire stops accepting callbacks signed with a secret. The last active secret of a gateway
+// cannot be retired, since that would silently turn off signature verification.
+func (s *WebhookSecretService) Retire(ctx context.Context, gatewayID, secretID int) error {
+	secrets, err := s.db.GetWebhookSecretsByGateway(gatewayID)
+	if err != nil {
+		return err
+	}
+
+	found, active := false, 0
+	for _, secret := range secrets {
+		if secret.Status != consts.WebhookSecretActive {
+			continue
+		}
+		active++
+		if secret.ID == secretID {
+			found = true
+		}
+	}
+
+	if !found {
+		return ErrWebhookSecretNotFound
+	}
+	if active == 1 {
+		return ErrLastWebhookSecret
+	}
+
+	if err := s.db.RetireWebhookSecret(gatewayID, secretID); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrWebhookSecretNotFound
+		}
+		return err
+	}
+
+	return nil
+}
+
+// Verify checks a callback body's "sha256=<hex>" signature against each of the gateway's active
+// secrets. Gateways without any secret configured are not verified.
+func (s *WebhookSecretService) Verify(ctx context.Context, gatewayID int, body []byte, signature string) error {
+	secrets, err := s.db.GetWebhookSecretsByGateway(gatewayID)
+	if err != nil {
+		return fmt.Errorf("failed to load webhook secrets: %w", err)
+	}
+
+	configured := false
+	for _, secret := range secrets {
+		if secret.Status != consts.WebhookSecretActive {
+			continue
+		}
+		configured = true
+
+		plaintext, err := utils.DecryptString(secret.Secret)
+		if err != nil {
+			log.Printf("Failed to decrypt webhook secret %d for gateway %d: %v", secret.ID, gatewayID, err)
+			continue
+		}
+
+		if hmac.Equal([]byte(SignWebhookPayload(plaintext, body)), []byte(strings.TrimSpace(signature))) {
+			return nil
+		}
+	}
+
+	if !configured {
+		return nil
+	}
+	return ErrInvalidWebhookSignature
+}
+
+// SignWebhookPayload returns the "sha256=<hex>" HMAC signature of a callback body. Gateway
+// callbacks and merchant webhooks are signed the same way.
+func SignWebhookPayload(secret string, body []byte) string {
+	return webhook.Sign(secret, body)
+}
+
+// generateWebhookSecret returns a random secret suitable for signing callbacks
+func generateWebhookSecret() (string, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return "whsec_" + hex.EncodeToString(buf), nil
+}
@@ -0,0 +1,296 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/url"
+	"payment-gateway/db"
+	"payment-gateway/internal/consts"
+	"payment-gateway/internal/events"
+	"payment-gateway/internal/models"
+	"strings"
+)
+
+var (
+	ErrInvalidWebhookEndpoint  = errors.New("invalid webhook endpoint")
+	ErrWebhookEndpointNotFound = errors.New("webhook endpoint not found")
+)
+
+// WebhookEventTypes are the event types merchants filter their webhook endpoints by. Besides
+// transaction.status_changed, each status change is also a transaction.<status> event.
+var WebhookEventTypes = []string{
+	events.TransactionStatusChanged,
+	"transaction." + consts.Pending,
+	"transaction." + consts.Processing,
+	"transaction." + consts.Scheduled,
+	"transaction." + consts.Authorized,
+	"transaction." + consts.Completed,
+	"transaction." + consts.Failed,
+	"transaction." + consts.Voided,
+	events.TransferCompleted,
+	events.TransferFailed,
+	events.AutoReloadFailed,
+	events.AutoReloadDisabled,
+	events.ReconciliationFileReady,
+}
+
+// ListEndpoints returns a merchant's webhook endpoints, oldest first
+func (s *MerchantWebhookService) ListEndpoints(ctx context.Context, merchantID int) ([]models.WebhookEndpoint, error) {
+	endpoints, err := s.db.GetWebhookEndpoints(merchantID)
+	if err != nil {
+		return nil, err
+	}
+	if endpoints == nil {
+		endpoints = []models.WebhookEndpoint{}
+	}
+	return endpoints, nil
+}
+
+// CreateEndpoint adds a webhook endpoint for a merchant. Events recorded from then on are
+// delivered to it when their type matches its filter.
+func (s *MerchantWebhookService) CreateEndpoint(ctx context.Context, merchantID int, req models.WebhookEndpointRequest) (*models.WebhookEndpoint, error) {
+	eventTypes, err := validateWebhookEndpoint(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := s.db.GetMerchantByID(merchantID); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrMerchantNotFound
+		}
+		return nil, err
+	}
+
+	existing, err := s.db.GetWebhookEndpoints(merchantID)
+	if err != nil {
+		return nil, err
+	}
+	if len(existing) >= consts.MaxWebhookEndpoints {
+		return nil, fmt.Errorf("%w: a merchant can have at most %d endpoints", ErrInvalidWebhookEndpoint, consts.MaxWebhookEndpoints)
+	}
+
+	version := req.PayloadVersion
+	if version == "" {
+		version = consts.WebhookPayloadLatest
+	}
+
+	now := s.now().UTC()
+	endpoint := models.WebhookEndpoint{
+		MerchantID:     merchantID,
+		URL:            req.URL,
+		EventTypes:     eventTypes,
+		PayloadVersion: version,
+		Description:    req.Description,
+		CreatedAt:      now,
+		UpdatedAt:      now,
+	}
+	if endpoint.ID, err = s.db.CreateWebhookEndpoint(endpoint); err != nil {
+		return nil, err
+	}
+
+	log.Printf("Added webhook endpoint %d of merchant %d", endpoint.ID, merchantID)
+	return &endpoint, nil
+}
+
+// UpdateEndpoint replaces the URL, event types and description of a merchant's webhook endpoint,
+// and its payload version when one is given. Events already recorded for it are delivered to its
+// new URL in its new version.
+func (s *MerchantWebhookService) UpdateEndpoint(ctx context.Context, merchantID, endpointID int, req models.WebhookEndpointRequest) (*models.WebhookEndpoint, error) {
+	eventTypes, err := validateWebhookEndpoint(req)
+	if err != nil {
+		return nil, err
+	}
+
+	endpoint, err := s.db.GetWebhookEndpoint(merchantID, endpointID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrWebhookEndpointNotFound
+		}
+		return nil, err
+	}
+
+	endpoint.URL = req.URL
+	endpoint.EventTypes = eventTypes
+	if req.PayloadVersion != "" {
+		endpoint.PayloadVersion = req.PayloadVersion
+	}
+	endpoint.Description = req.Description
+	endpoint.UpdatedAt = s.now().UTC()
+	if err := s.db.UpdateWebhookEndpoint(*endpoint); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrWebhookEndpointNotFound
+		}
+		return nil, err
+	}
+	return endpoint, nil
+}
+
+// DeleteEndpoint removes a merchant's webhook endpoint. Events still awaiting delivery to it are
+// dropped.
+func (s *MerchantWebhookService) DeleteEndpoint(ctx context.Context, merchantID, endpointID int) error {
+	if err := s.db.DeleteWebhookEndpoint(merchantID, endpointID); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrWebhookEndpointNotFound
+		}
+		return err
+	}
+
+	log.Printf("Deleted webhook endpoint %d of merchant %d", endpointID, merchantID)
+	return nil
+}
+
+// validateWebhookEndpoint checks an endpoint's URL, event type filters and payload version,
+// returning the filters without duplicates
+func validateWebhookEndpoint(req models.WebhookEndpointRequest) ([]string, error) {
+	if err := validateWebhookURL(req.URL); err != nil {
+		return nil, fmt.Errorf("%w: url %v", ErrInvalidWebhookEndpoint, err)
+	}
+	if req.PayloadVersion != "" && !isWebhookPayloadVersion(req.PayloadVersion) {
+		return nil, fmt.Errorf("%w: unknown payload version %q", ErrInvalidWebhookEndpoint, req.PayloadVersion)
+	}
+
+	eventTypes := []string{}
+	seen := make(map[string]bool)
+	for _, eventType := range req.EventTypes {
+		eventType = strings.TrimSpace(eventType)
+		if !isWebhookEventFilter(eventType) {
+			return nil, fmt.Errorf("%w: unknown event type %q", ErrInvalidWebhookEndpoint, eventType)
+		}
+		if !seen[eventType] {
+			seen[eventType] = true
+			eventTypes = append(eventTypes, eventType)
+		}
+	}
+	return eventTypes, nil
+}
+
+// validateWebhookURL checks that a URL is absolute and uses HTTPS; HTTP is allowed only for
+// localhost
+func validateWebhookURL(raw string) error {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return fmt.Errorf("is malformed")
+	}
+
+	host := strings.ToLower(u.Hostname())
+	if host == "" || u.User != nil {
+		return fmt.Errorf("must be an absolute URL without credentials")
+	}
+
+	switch u.Scheme {
+	case "https":
+	case "http":
+		if host != "localhost" {
+			return fmt.Errorf("must use https")
+		}
+	default:
+		return fmt.Errorf("must use https")
+	}
+	return nil
+}
+
+// isWebhookEventFilter reports whether filter is a webhook event type, a prefix of some ending
+// in .* such as transaction.*, or * for every event
+func isWebhookEventFilter(filter string) bool {
+	if filter == "*" {
+		return true
+	}
+	for _, eventType := range WebhookEventTypes {
+		if matchesWebhookEvent(filter, eventType) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchesWebhookEvent reports whether a filter selects an event type
+func matchesWebhookEvent(filter, eventType string) bool {
+	if filter == "*" || filter == eventType {
+		return true
+	}
+	prefix := strings.TrimSuffix(filter, "*")
+	return prefix != filter && strings.HasSuffix(prefix, ".") && strings.HasPrefix(eventType, prefix)
+}
+
+// endpointMessages returns a copy of a merchant webhook message for each of the merchant's
+// endpoints whose filter selects it. An endpoint without filters selects every message.
+func endpointMessages(dbInterface db.DBInterface, msg models.OutboxMessage) []models.OutboxMessage {
+	if msg.MerchantID == 0 {
+		return nil
+	}
+
+	endpoints, err := dbInterface.GetWebhookEndpoints(msg.MerchantID)
+	if err != nil {
+		log.Printf("Failed to fetch webhook endpoints of merchant %d: %v", msg.MerchantID, err)
+		return nil
+	}
+
+	eventTypes := webhookEventTypes(msg)
+	var messages []models.OutboxMessage
+	for _, endpoint := range endpoints {
+		if !selectsWebhookEvent(endpoint.EventTypes, eventTypes) {
+			continue
+		}
+		copied := msg
+		copied.EndpointID = endpoint.ID
+		messages = append(messages, copied)
+	}
+	return messages
+}
+
+// webhookEventTypes returns the event types a merchant webhook message is filtered by: its own
+// and, for a transaction status change, transaction.<status>
+func webhookEventTypes(msg models.OutboxMessage) []string {
+	eventTypes := []string{msg.EventType}
+	if msg.EventType != events.TransactionStatusChanged {
+		return eventTypes
+	}
+
+	var evt struct {
+		Transaction struct {
+			Status string `json:"status"`
+		} `json:"transaction"`
+	}
+	if err := json.Unmarshal(msg.Payload, &evt); err == nil && evt.Transaction.Status != "" {
+		eventTypes = append(eventTypes, "transaction."+evt.Transaction.Status)
+	}
+	return eventTypes
+}
+
+// selectsWebhookEvent reports whether any of an endpoint's filters selects any of an event's
+// types. Endpoints without filters select every event.
+func selectsWebhookEvent(filters, eventTypes []string) bool {
+	if len(filters) == 0 {
+		return true
+	}
+	for _, filter := range filters {
+		for _, eventType := range eventTypes {
+			if matchesWebhookEvent(filter, eventType) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// webhookTarget returns the URL a merchant webhook message is delivered to and the payload
+// version it is delivered in: its endpoint's, or the merchant's webhook_url, which receives v1
+// payloads. Messages for deleted endpoints have nowhere to go.
+func webhookTarget(dbInterface db.DBInterface, merchant *models.Merchant, msg models.OutboxMessage) (string, string, error) {
+	if msg.EndpointID == 0 {
+		return merchant.WebhookURL, consts.WebhookPayloadV1, nil
+	}
+
+	endpoint, err := dbInterface.GetWebhookEndpoint(merchant.ID, msg.EndpointID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return "", "", nil
+		}
+		return "", "", fmt.Errorf("failed to fetch webhook endpoint: %w", err)
+	}
+	return endpoint.URL, endpoint.PayloadVersion, nil
+}
@@ -0,0 +1,127 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"payment-gateway/db"
+	"payment-gateway/internal/consts"
+	"payment-gateway/internal/models"
+	"testing"
+	"time"
+)
+
+// TestWebhookEndpoints tests managing a merchant's webhook endpoints and recording each event for
+// the endpoints whose filters select it
+func TestWebhookEndpoints(t *testing.T) {
+	mockDB := db.NewMockDB()
+	endpoints := NewMerchantWebhookService(mockDB)
+	service := NewTransactionService(mockDB, &mockGatewaySelector{})
+	ctx := context.Background()
+
+	for _, req := range []models.WebhookEndpointRequest{
+		{URL: "http://example.com/hooks"},
+		{URL: "/hooks"},
+		{URL: "https://example.com/hooks", EventTypes: []string{"refund.*"}},
+		{URL: "https://example.com/hooks", EventTypes: []string{"transaction.settled"}},
+	} {
+		if _, err := endpoints.CreateEndpoint(ctx, 1, req); !errors.Is(err, ErrInvalidWebhookEndpoint) {
+			t.Errorf("Expected ErrInvalidWebhookEndpoint for %+v, got: %v", req, err)
+		}
+	}
+	if _, err := endpoints.CreateEndpoint(ctx, 999, models.WebhookEndpointRequest{URL: "https://example.com/hooks"}); !errors.Is(err, ErrMerchantNotFound) {
+		t.Errorf("Expected ErrMerchantNotFound, got: %v", err)
+	}
+
+	completed, err := endpoints.CreateEndpoint(ctx, 1, models.WebhookEndpointRequest{URL: "https://example.com/completed", EventTypes: []string{"transaction.completed", "transfer.*", "transaction.completed"}})
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	if len(completed.EventTypes) != 2 || completed.PayloadVersion != consts.WebhookPayloadLatest {
+		t.Errorf("Expected duplicate event types dropped and the latest payload version, got %+v", completed)
+	}
+	all, err := endpoints.CreateEndpoint(ctx, 1, models.WebhookEndpointRequest{URL: "https://example.com/all"})
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	failed, err := endpoints.CreateEndpoint(ctx, 1, models.WebhookEndpointRequest{URL: "http://localhost:9000/failed", EventTypes: []string{"transaction.failed"}})
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+
+	// A completed deposit is recorded for the merchant's webhook URL and the endpoints selecting it
+	txID, err := mockDB.CreateTransaction(models.Transaction{UserID: 1, Amount: 10, Currency: "USD", Status: consts.Processing})
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	if err := service.HandleCallback(ctx, &models.CallbackData{TransactionID: txID, Status: consts.Completed}); err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	messages, err := mockDB.GetPendingOutboxMessages(consts.OutboxMerchantWebhook, time.Now(), consts.OutboxBatchSize)
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	var recipients []int
+	for _, msg := range messages {
+		recipients = append(recipients, msg.EndpointID)
+	}
+	if len(recipients) != 3 || recipients[0] != 0 || recipients[1] != completed.ID || recipients[2] != all.ID {
+		t.Errorf("Expected the webhook URL and endpoints %d and %d, got %v", completed.ID, all.ID, recipients)
+	}
+
+	// Endpoints are updated and deleted by their merchant only
+	failed, err = endpoints.UpdateEndpoint(ctx, 1, failed.ID, models.WebhookEndpointRequest{URL: "https://example.com/failed", EventTypes: []string{"*"}})
+	if err != nil || failed.URL != "https://example.com/failed" || failed.EventTypes[0] != "*" {
+		t.Fatalf("Expected the endpoint updated, got %+v (%v)", failed, err)
+	}
+	if _, err := endpoints.UpdateEndpoint(ctx, 2, failed.ID, models.WebhookEndpointRequest{URL: "https://example.com/failed"}); !errors.Is(err, ErrWebhookEndpointNotFound) {
+		t.Errorf("Expected ErrWebhookEndpointNotFound for another merchant's endpoint, got: %v", err)
+	}
+	if err := endpoints.DeleteEndpoint(ctx, 1, all.ID); err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	if err := endpoints.DeleteEndpoint(ctx, 1, all.ID); !errors.Is(err, ErrWebhookEndpointNotFound) {
+		t.Errorf("Expected ErrWebhookEndpointNotFound deleting twice, got: %v", err)
+	}
+	list, err := endpoints.ListEndpoints(ctx, 1)
+	if err != nil || len(list) != 2 || list[0].ID != completed.ID || list[1].ID != failed.ID {
+		t.Errorf("Expected endpoints %d and %d, got %+v (%v)", completed.ID, failed.ID, list, err)
+	}
+}
+
+// TestMerchantWebhookSinkEndpoints tests delivering webhooks recorded for an endpoint to its URL
+func TestMerchantWebhookSinkEndpoints(t *testing.T) {
+	mockDB := db.NewMockDB()
+	sink := NewMerchantWebhookSink(mockDB)
+
+	var received []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received = append(received, r.URL.Path+" "+r.Header.Get(MerchantWebhookEventHeader))
+	}))
+	defer server.Close()
+
+	endpointID, err := mockDB.CreateWebhookEndpoint(models.WebhookEndpoint{MerchantID: 1, URL: server.URL + "/endpoint", PayloadVersion: consts.WebhookPayloadV1})
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+
+	msg := models.OutboxMessage{Destination: consts.OutboxMerchantWebhook, EventType: "transfer.completed", MerchantID: 1, EndpointID: endpointID, ContentType: "application/json", Payload: []byte(`{}`)}
+	if err := sink.Deliver(context.Background(), msg); err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	if len(received) != 1 || received[0] != "/endpoint transfer.completed" {
+		t.Errorf("Expected one delivery to the endpoint, got %v", received)
+	}
+
+	// Messages for deleted endpoints have nowhere to go
+	if err := mockDB.DeleteWebhookEndpoint(1, endpointID); err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	if err := sink.Deliver(context.Background(), msg); err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	if len(received) != 1 {
+		t.Errorf("Expected nothing delivered to a deleted endpoint, got %v", received)
+	}
+}
@@ -0,0 +1,60 @@
+package services
+
+import (
+	"encoding/json"
+	"fmt"
+	"payment-gateway/internal/consts"
+	"payment-gateway/internal/models"
+	"payment-gateway/sdk/webhook"
+	"time"
+)
+
+// webhookPayloadTransformers convert a merchant webhook payload, recorded in the v1 shape, to
+// each payload version merchants can receive. A new version is added here, so payloads can
+// evolve while endpoints pinned to an older version keep receiving the shape they parse.
+var webhookPayloadTransformers = map[string]func(msg models.OutboxMessage) ([]byte, error){
+	consts.WebhookPayloadV1: func(msg models.OutboxMessage) ([]byte, error) { return msg.Payload, nil },
+	consts.WebhookPayloadV2: webhookEnvelope,
+}
+
+// isWebhookPayloadVersion reports whether merchants can receive payloads of a version
+func isWebhookPayloadVersion(version string) bool {
+	_, ok := webhookPayloadTransformers[version]
+	return ok
+}
+
+// transformWebhookPayload returns a merchant webhook message's payload in a payload version
+func transformWebhookPayload(version string, msg models.OutboxMessage) ([]byte, error) {
+	transform, ok := webhookPayloadTransformers[version]
+	if !ok {
+		return nil, fmt.Errorf("unsupported webhook payload version %q", version)
+	}
+	return transform(msg)
+}
+
+// webhookEnvelope wraps a v1 payload in the v2 event envelope. Its type and occurred_at move to
+// the envelope and every other field, such as the transaction, goes under data. The envelope ID is
+// the message's dedup token, which the Idempotency-Key header carries as well.
+func webhookEnvelope(msg models.OutboxMessage) ([]byte, error) {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(msg.Payload, &fields); err != nil {
+		return nil, fmt.Errorf("failed to parse %s payload: %w", msg.EventType, err)
+	}
+
+	envelope := webhook.Envelope{ID: msg.DedupToken, Type: msg.EventType, APIVersion: consts.WebhookPayloadV2, CreatedAt: msg.CreatedAt.UTC()}
+	if occurredAt, ok := fields["occurred_at"]; ok {
+		var t time.Time
+		if err := json.Unmarshal(occurredAt, &t); err == nil {
+			envelope.CreatedAt = t.UTC()
+		}
+	}
+	delete(fields, "type")
+	delete(fields, "occurred_at")
+
+	data, err := json.Marshal(fields)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal %s payload: %w", msg.EventType, err)
+	}
+	envelope.Data = data
+	return json.Marshal(envelope)
+}
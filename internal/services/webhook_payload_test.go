@@ -0,0 +1,124 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"payment-gateway/db"
+	"payment-gateway/internal/consts"
+	"payment-gateway/internal/events"
+	"payment-gateway/internal/models"
+	"payment-gateway/sdk/webhook"
+	"testing"
+	"time"
+)
+
+// TestTransformWebhookPayload tests converting a recorded payload to each payload version
+func TestTransformWebhookPayload(t *testing.T) {
+	occurredAt := time.Date(2026, 3, 10, 12, 0, 0, 0, time.UTC)
+	payload, err := json.Marshal(events.AutoReloadEvent{Type: events.AutoReloadFailed, Rule: models.AutoReloadRule{ID: 7}, TransactionID: 42, Message: "returned", OccurredAt: occurredAt})
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	msg := models.OutboxMessage{EventType: events.AutoReloadFailed, DedupToken: "token-1", Payload: payload, CreatedAt: occurredAt.Add(time.Second)}
+
+	legacy, err := transformWebhookPayload(consts.WebhookPayloadV1, msg)
+	if err != nil || string(legacy) != string(payload) {
+		t.Errorf("Expected the v1 payload unchanged, got %s (%v)", legacy, err)
+	}
+
+	body, err := transformWebhookPayload(consts.WebhookPayloadV2, msg)
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	var envelope webhook.Envelope
+	if err := json.Unmarshal(body, &envelope); err != nil {
+		t.Fatalf("Expected an envelope, got %s (%v)", body, err)
+	}
+	if envelope.ID != "token-1" || envelope.Type != events.AutoReloadFailed || envelope.APIVersion != consts.WebhookPayloadV2 || !envelope.CreatedAt.Equal(occurredAt) {
+		t.Errorf("Expected the envelope to carry the event's token, type, version and time, got %+v", envelope)
+	}
+	var data map[string]json.RawMessage
+	if err := json.Unmarshal(envelope.Data, &data); err != nil {
+		t.Fatalf("Expected object data, got %s (%v)", envelope.Data, err)
+	}
+	if _, ok := data["rule"]; !ok || string(data["transaction_id"]) != "42" || data["type"] != nil || data["occurred_at"] != nil {
+		t.Errorf("Expected the event's fields under data without type and occurred_at, got %s", envelope.Data)
+	}
+
+	if _, err := transformWebhookPayload("v9", msg); err == nil {
+		t.Error("Expected an error for an unknown payload version")
+	}
+}
+
+// TestMerchantWebhookSinkPayloadVersions tests that each endpoint receives deliveries in the
+// payload version it is pinned to, signed as delivered
+func TestMerchantWebhookSinkPayloadVersions(t *testing.T) {
+	mockDB := db.NewMockDB()
+	endpoints := NewMerchantWebhookService(mockDB)
+	sink := NewMerchantWebhookSink(mockDB)
+	ctx := context.Background()
+
+	secret, err := endpoints.RollSecret(ctx, 1)
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+
+	type delivery struct {
+		version string
+		body    []byte
+		valid   bool
+	}
+	var received []delivery
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := webhook.VerifyRequest(secret.Secret, r)
+		received = append(received, delivery{version: r.Header.Get(MerchantWebhookVersionHeader), body: body, valid: err == nil})
+	}))
+	defer server.Close()
+
+	legacyID, err := mockDB.CreateWebhookEndpoint(models.WebhookEndpoint{MerchantID: 1, URL: server.URL, PayloadVersion: consts.WebhookPayloadV1})
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	latestID, err := mockDB.CreateWebhookEndpoint(models.WebhookEndpoint{MerchantID: 1, URL: server.URL, PayloadVersion: consts.WebhookPayloadV2})
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+
+	payload, _ := json.Marshal(events.TransactionEvent{Type: events.TransactionStatusChanged, Transaction: models.Transaction{ID: 5, Status: consts.Completed}, OccurredAt: time.Now()})
+	for _, endpointID := range []int{legacyID, latestID} {
+		msg := models.OutboxMessage{Destination: consts.OutboxMerchantWebhook, EventType: events.TransactionStatusChanged, MerchantID: 1, EndpointID: endpointID, DedupToken: "token-5", ContentType: "application/json", Payload: payload}
+		if err := sink.Deliver(ctx, msg); err != nil {
+			t.Fatalf("Expected no error, got: %v", err)
+		}
+	}
+
+	if len(received) != 2 {
+		t.Fatalf("Expected 2 deliveries, got %d", len(received))
+	}
+	if received[0].version != consts.WebhookPayloadV1 || !received[0].valid || string(received[0].body) != string(payload) {
+		t.Errorf("Expected the v1 payload unchanged and signed, got %+v", received[0])
+	}
+	var envelope webhook.Envelope
+	if err := json.Unmarshal(received[1].body, &envelope); err != nil || received[1].version != consts.WebhookPayloadV2 || !received[1].valid || envelope.ID != "token-5" {
+		t.Errorf("Expected a signed v2 envelope, got %+v (%v)", received[1], err)
+	}
+
+	// Endpoints are pinned to the latest version unless given one, and keep their version when
+	// updated without one
+	endpoint, err := endpoints.CreateEndpoint(ctx, 1, models.WebhookEndpointRequest{URL: "https://example.com/hooks"})
+	if err != nil || endpoint.PayloadVersion != consts.WebhookPayloadLatest {
+		t.Fatalf("Expected the latest payload version, got %+v (%v)", endpoint, err)
+	}
+	if _, err := endpoints.CreateEndpoint(ctx, 1, models.WebhookEndpointRequest{URL: "https://example.com/hooks", PayloadVersion: "v9"}); !errors.Is(err, ErrInvalidWebhookEndpoint) {
+		t.Errorf("Expected ErrInvalidWebhookEndpoint for an unknown payload version, got: %v", err)
+	}
+	if updated, err := endpoints.UpdateEndpoint(ctx, 1, endpoint.ID, models.WebhookEndpointRequest{URL: "https://example.com/hooks", PayloadVersion: consts.WebhookPayloadV1}); err != nil || updated.PayloadVersion != consts.WebhookPayloadV1 {
+		t.Fatalf("Expected the endpoint repinned to v1, got %+v (%v)", updated, err)
+	}
+	if updated, err := endpoints.UpdateEndpoint(ctx, 1, endpoint.ID, models.WebhookEndpointRequest{URL: "https://example.com/other"}); err != nil || updated.PayloadVersion != consts.WebhookPayloadV1 {
+		t.Fatalf("Expected the endpoint to stay on v1, got %+v (%v)", updated, err)
+	}
+}
@@ -0,0 +1,69 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"payment-gateway/db"
+	"testing"
+)
+
+// TestWebhookSecretRotation tests that callbacks verify with either secret during rotation
+// and only with the new one once the old secret is retired
+func TestWebhookSecretRotation(t *testing.T) {
+	service := NewWebhookSecretService(db.NewMockDB())
+	ctx := context.Background()
+	body := []byte(`{"transaction_id":1,"status":"completed"}`)
+
+	// Gateways without secrets are not verified
+	if err := service.Verify(ctx, 1, body, ""); err != nil {
+		t.Fatalf("Expected unconfigured gateway to pass verification, got: %v", err)
+	}
+
+	oldSecret, err := service.Add(ctx, 1, "old-secret-0123456789")
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	newSecret, err := service.Add(ctx, 1, "")
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+
+	for _, secret := range []string{oldSecret.Secret, newSecret.Secret} {
+		if err := service.Verify(ctx, 1, body, SignWebhookPayload(secret, body)); err != nil {
+			t.Errorf("Expected signature to verify during rotation, got: %v", err)
+		}
+	}
+	if err := service.Verify(ctx, 1, body, SignWebhookPayload("unknown-secret-0123", body)); !errors.Is(err, ErrInvalidWebhookSignature) {
+		t.Errorf("Expected ErrInvalidWebhookSignature, got: %v", err)
+	}
+
+	if err := service.Retire(ctx, 1, oldSecret.ID); err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	if err := service.Verify(ctx, 1, body, SignWebhookPayload(oldSecret.Secret, body)); !errors.Is(err, ErrInvalidWebhookSignature) {
+		t.Errorf("Expected retired secret to be rejected, got: %v", err)
+	}
+
+	if err := service.Retire(ctx, 1, newSecret.ID); !errors.Is(err, ErrLastWebhookSecret) {
+		t.Errorf("Expected ErrLastWebhookSecret, got: %v", err)
+	}
+
+	secrets, err := service.List(ctx, 1)
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	for _, secret := range secrets {
+		if secret.Secret != "" {
+			t.Errorf("Expected secret %d value to be hidden", secret.ID)
+		}
+	}
+}
+
+// TestAddWebhookSecretTooShort tests that short admin-supplied secrets are rejected
+func TestAddWebhookSecretTooShort(t *testing.T) {
+	service := NewWebhookSecretService(db.NewMockDB())
+
+	if _, err := service.Add(context.Background(), 1, "short"); !errors.Is(err, ErrInvalidWebhookSecret) {
+		t.Errorf("Expected ErrInvalidWebhookSecret, got: %v", err)
+	}
+}
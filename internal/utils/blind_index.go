@@ -0,0 +1,110 @@
+package utils
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// BlindIndexKey is a versioned key for computing blind indexes
+type BlindIndexKey struct {
+	Version int
+	Key     []byte
+}
+
+var (
+	// blindIndexKeys holds the current blind index key first, followed by keys being rotated out
+	blindIndexKeys []BlindIndexKey
+)
+
+func init() {
+	// Without configured keys, the key is derived from the encryption key once it is loaded
+	keys, err := ParseBlindIndexKeys(os.Getenv("BLIND_INDEX_KEYS"))
+	if err != nil {
+		log.Printf("Invalid BLIND_INDEX_KEYS, deriving the blind index key from the encryption key: %v", err)
+		return
+	}
+	if len(keys) > 0 {
+		SetBlindIndexKeys(keys)
+	}
+}
+
+// derivedBlindIndexKey derives a blind index key from the encryption key, for development only
+func derivedBlindIndexKey(encryptionKey []byte) BlindIndexKey {
+	mac := hmac.New(sha256.New, encryptionKey)
+	mac.Write([]byte("blind-index"))
+	return BlindIndexKey{Version: 1, Key: mac.Sum(nil)}
+}
+
+// ParseBlindIndexKeys parses a comma-separated list of VERSION:HEXKEY pairs, current key first,
+// e.g. "2:<hex>,1:<hex>" while rotating from version 1 to 2
+func ParseBlindIndexKeys(spec string) ([]BlindIndexKey, error) {
+	var keys []BlindIndexKey
+
+	for _, entry := range strings.Split(spec, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		versionStr, keyHex, ok := strings.Cut(entry, ":")
+		version, err := strconv.Atoi(versionStr)
+		if !ok || err != nil || version <= 0 {
+			return nil, fmt.Errorf("invalid key %q: expected VERSION:HEXKEY", versionStr)
+		}
+
+		key, err := hex.DecodeString(keyHex)
+		if err != nil || len(key) < 32 {
+			return nil, fmt.Errorf("key version %d must be at least 32 hex-encoded bytes", version)
+		}
+
+		keys = append(keys, BlindIndexKey{Version: version, Key: key})
+	}
+
+	return keys, nil
+}
+
+// SetBlindIndexKeys replaces the blind index keys; the first key is used for new indexes
+func SetBlindIndexKeys(keys []BlindIndexKey) {
+	blindIndexKeys = keys
+}
+
+// BlindIndex returns a keyed hash of a normalized value, prefixed with the version of the key
+// it was computed with, so the value can be matched exactly in the database without storing
+// or comparing the value itself
+func BlindIndex(value string) string {
+	return blindIndex(blindIndexKeys[0], value)
+}
+
+// BlindIndexCandidates returns the blind indexes of a value under every active key, so rows
+// indexed with a key being rotated out are still found until they are re-indexed
+func BlindIndexCandidates(value string) []string {
+	candidates := make([]string, 0, len(blindIndexKeys))
+	for _, key := range blindIndexKeys {
+		candidates = append(candidates, blindIndex(key, value))
+	}
+	return candidates
+}
+
+// CurrentBlindIndexPrefix returns the prefix of blind indexes computed with the current key;
+// indexes without it need to be recomputed
+func CurrentBlindIndexPrefix() string {
+	return blindIndexPrefix(blindIndexKeys[0].Version)
+}
+
+// blindIndex computes the blind index of value with key
+func blindIndex(key BlindIndexKey, value string) string {
+	mac := hmac.New(sha256.New, key.Key)
+	mac.Write([]byte(strings.ToLower(strings.TrimSpace(value))))
+	return blindIndexPrefix(key.Version) + hex.EncodeToString(mac.Sum(nil))
+}
+
+// blindIndexPrefix returns the prefix identifying a blind index key version
+func blindIndexPrefix(version int) string {
+	return fmt.Sprintf("v%d:", version)
+}
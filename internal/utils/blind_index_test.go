@@ -0,0 +1,43 @@
+package utils
+
+import (
+	"strings"
+	"testing"
+)
+
+// TestBlindIndexRotation tests that values indexed with a rotated-out key are still found
+func TestBlindIndexRotation(t *testing.T) {
+	original := blindIndexKeys
+	defer SetBlindIndexKeys(original)
+
+	oldKeys, err := ParseBlindIndexKeys("1:" + strings.Repeat("ab", 32))
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	SetBlindIndexKeys(oldKeys)
+	oldIndex := BlindIndex("User@Example.com ")
+
+	if oldIndex != BlindIndex("user@example.com") {
+		t.Error("Expected blind index to ignore case and surrounding whitespace")
+	}
+
+	rotated, err := ParseBlindIndexKeys("2:" + strings.Repeat("cd", 32) + ",1:" + strings.Repeat("ab", 32))
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	SetBlindIndexKeys(rotated)
+
+	newIndex := BlindIndex("user@example.com")
+	if !strings.HasPrefix(newIndex, CurrentBlindIndexPrefix()) || strings.HasPrefix(oldIndex, CurrentBlindIndexPrefix()) {
+		t.Errorf("Expected only the new index to have the current prefix, got %s and %s", newIndex, oldIndex)
+	}
+
+	candidates := BlindIndexCandidates("user@example.com")
+	if len(candidates) != 2 || candidates[0] != newIndex || candidates[1] != oldIndex {
+		t.Errorf("Expected candidates under both keys, got %v", candidates)
+	}
+
+	if _, err := ParseBlindIndexKeys("1:abcd"); err == nil {
+		t.Error("Expected short key to be rejected")
+	}
+}
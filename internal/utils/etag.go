@@ -0,0 +1,58 @@
+package utils
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"strings"
+)
+
+// SendCacheableResponse sends a 200 response tagged with an ETag of its body, for resources
+// clients poll. When the request's If-None-Match already names the ETag, i.e. the client holds the
+// current representation, the response is a 304 without a body. Responses must be revalidated
+// before they are reused and vary with the requested format, whose bodies have distinct ETags.
+func SendCacheableResponse(w http.ResponseWriter, r *http.Request, data interface{}) {
+	contentType := r.Header.Get("Accept")
+	if contentType == "" {
+		contentType = r.Header.Get("Content-Type")
+	}
+	buf := getBuffer()
+	defer putBuffer(buf)
+	mediaType := renderResponse(buf, contentType, data)
+	body := buf.Bytes()
+	etag := ETag(body)
+
+	w.Header().Set("ETag", etag)
+	w.Header().Set("Cache-Control", "private, no-cache")
+	w.Header().Add("Vary", "Accept, Content-Type")
+
+	if IfNoneMatch(r, etag) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	setContentType(w.Header(), mediaType)
+	w.WriteHeader(http.StatusOK)
+	w.Write(body)
+}
+
+// ETag returns a strong entity tag of a response body: the quoted hex of the first 16 bytes of
+// its SHA-256 digest
+func ETag(body []byte) string {
+	sum := sha256.Sum256(body)
+	return `"` + hex.EncodeToString(sum[:16]) + `"`
+}
+
+// IfNoneMatch reports whether a request's If-None-Match header matches an entity tag, comparing
+// tags weakly as RFC 9110 requires for the header, or is "*"
+func IfNoneMatch(r *http.Request, etag string) bool {
+	for _, header := range r.Header.Values("If-None-Match") {
+		for _, candidate := range strings.Split(header, ",") {
+			candidate = strings.TrimSpace(candidate)
+			if candidate == "*" || strings.TrimPrefix(candidate, "W/") == strings.TrimPrefix(etag, "W/") {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,57 @@
+package utils
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// TestSendCacheableResponse tests that responses carry an ETag of their body and that requests
+// naming it get a 304 without a body
+func TestSendCacheableResponse(t *testing.T) {
+	data := map[string]string{"status": "processing"}
+	send := func(accept, ifNoneMatch string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/transactions/1", nil)
+		req.Header.Set("Accept", accept)
+		if ifNoneMatch != "" {
+			req.Header.Set("If-None-Match", ifNoneMatch)
+		}
+		rec := httptest.NewRecorder()
+		SendCacheableResponse(rec, req, data)
+		return rec
+	}
+
+	first := send("application/json", "")
+	etag := first.Header().Get("ETag")
+	if first.Code != http.StatusOK || etag == "" || first.Body.Len() == 0 {
+		t.Fatalf("Expected a 200 with an ETag and a body, got %d %q", first.Code, etag)
+	}
+	if got := first.Header().Get("Cache-Control"); got != "private, no-cache" {
+		t.Errorf("Expected responses to be revalidated, got Cache-Control %q", got)
+	}
+
+	tests := []struct {
+		name        string
+		ifNoneMatch string
+		want        int
+	}{
+		{"same ETag", etag, http.StatusNotModified},
+		{"weak ETag in a list", `"stale", W/` + etag, http.StatusNotModified},
+		{"any", "*", http.StatusNotModified},
+		{"stale ETag", `"stale"`, http.StatusOK},
+	}
+	for _, tt := range tests {
+		rec := send("application/json", tt.ifNoneMatch)
+		if rec.Code != tt.want {
+			t.Errorf("%s: expected %d, got %d", tt.name, tt.want, rec.Code)
+		}
+		if rec.Code == http.StatusNotModified && (rec.Body.Len() != 0 || rec.Header().Get("ETag") != etag) {
+			t.Errorf("%s: expected an empty 304 with the ETag, got %q with ETag %q", tt.name, rec.Body.String(), rec.Header().Get("ETag"))
+		}
+	}
+
+	// Other formats are other representations
+	if xml := send("application/xml", etag); xml.Code != http.StatusOK || xml.Header().Get("ETag") == etag {
+		t.Errorf("Expected the XML representation to have its own ETag, got %d %q", xml.Code, xml.Header().Get("ETag"))
+	}
+}
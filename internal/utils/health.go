@@ -0,0 +1,93 @@
+package utils
+
+import (
+	"context"
+	"errors"
+	"log"
+	"payment-gateway/internal/consts"
+	"payment-gateway/internal/models"
+	"sync"
+	"time"
+)
+
+// HealthCheck checks that a dependency is available
+type HealthCheck struct {
+	Name     string
+	Timeout  time.Duration
+	Critical bool // the service is unhealthy, rather than degraded, while the dependency is unavailable
+	Check    func(ctx context.Context) error
+}
+
+// RunHealthChecks runs the checks in parallel, each bounded by its timeout, and returns the result
+// of each by name. A check that ignores its context and outlives its timeout is reported as timed
+// out and left to finish in the background.
+func RunHealthChecks(ctx context.Context, checks []HealthCheck) map[string]models.DependencyHealth {
+	results := make(map[string]models.DependencyHealth, len(checks))
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+
+	for _, check := range checks {
+		wg.Add(1)
+		go func(check HealthCheck) {
+			defer wg.Done()
+
+			result := check.run(ctx)
+			mu.Lock()
+			results[check.Name] = result
+			mu.Unlock()
+		}(check)
+	}
+
+	wg.Wait()
+	return results
+}
+
+// HealthStatus summarizes check results: unhealthy when a critical dependency is unavailable,
+// degraded when any other one is, and healthy otherwise
+func HealthStatus(checks []HealthCheck, results map[string]models.DependencyHealth) string {
+	status := "healthy"
+	for _, check := range checks {
+		if results[check.Name].Status == consts.HealthUp {
+			continue
+		}
+		if check.Critical {
+			return "unhealthy"
+		}
+		status = "degraded"
+	}
+	return status
+}
+
+// run runs the check with its timeout and measures its latency
+func (c HealthCheck) run(ctx context.Context) models.DependencyHealth {
+	ctx, cancel := context.WithTimeout(ctx, c.Timeout)
+	defer cancel()
+
+	start := time.Now()
+	// Buffered so a check that outlives its timeout does not block once it returns
+	done := make(chan error, 1)
+	go func() {
+		done <- c.Check(ctx)
+	}()
+
+	var err error
+	select {
+	case err = <-done:
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+
+	health := models.DependencyHealth{
+		Status:    consts.HealthUp,
+		LatencyMS: float64(time.Since(start).Microseconds()) / 1000,
+	}
+	switch {
+	case errors.Is(err, context.DeadlineExceeded):
+		health.Status = consts.HealthTimeout
+		log.Printf("Health check of %s timed out after %s", c.Name, c.Timeout)
+	case err != nil:
+		health.Status = consts.HealthDown
+		log.Printf("Health check of %s failed: %v", c.Name, err)
+	}
+	return health
+}
@@ -0,0 +1,52 @@
+package utils
+
+import (
+	"context"
+	"errors"
+	"payment-gateway/internal/consts"
+	"testing"
+	"time"
+)
+
+// TestRunHealthChecks tests that checks run in parallel and a hanging check times out
+func TestRunHealthChecks(t *testing.T) {
+	hang := make(chan struct{})
+	defer close(hang)
+
+	checks := []HealthCheck{
+		{Name: "database", Timeout: 50 * time.Millisecond, Critical: true, Check: func(context.Context) error {
+			<-hang // a wedged connection that ignores the context
+			return nil
+		}},
+		{Name: "kafka", Timeout: time.Second, Check: func(context.Context) error {
+			time.Sleep(40 * time.Millisecond)
+			return errors.New("connection refused")
+		}},
+		{Name: "cache", Timeout: time.Second, Check: func(context.Context) error {
+			return nil
+		}},
+	}
+
+	start := time.Now()
+	results := RunHealthChecks(context.Background(), checks)
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Errorf("Expected checks to run in parallel within their timeouts, took %s", elapsed)
+	}
+
+	if got := results["database"]; got.Status != consts.HealthTimeout || got.LatencyMS < 50 {
+		t.Errorf("Expected the database check to time out after 50ms, got %+v", got)
+	}
+	if got := results["kafka"]; got.Status != consts.HealthDown || got.LatencyMS < 40 {
+		t.Errorf("Expected the kafka check to be down after 40ms, got %+v", got)
+	}
+	if got := results["cache"]; got.Status != consts.HealthUp {
+		t.Errorf("Expected the cache check to be up, got %+v", got)
+	}
+
+	if status := HealthStatus(checks, results); status != "unhealthy" {
+		t.Errorf("Expected unhealthy with the critical database down, got %s", status)
+	}
+	if status := HealthStatus(checks[1:], results); status != "degraded" {
+		t.Errorf("Expected degraded with only kafka down, got %s", status)
+	}
+}
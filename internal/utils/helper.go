@@ -1,27 +1,144 @@
 package utils
 
 import (
+	"bytes"
 	"encoding/json"
 	"encoding/xml"
+	"errors"
 	"fmt"
 	"net/http"
+	"payment-gateway/internal/codec"
+	"payment-gateway/internal/consts"
 	"payment-gateway/internal/models"
+	"payment-gateway/internal/validation"
+	"strings"
+	"sync"
 )
 
 // Helper functions
 
-// DecodeRequest decodes the request body based on content type
+// codecBuffer is a buffer request bodies are read into and responses rendered into, with
+// encoders writing into it that are reused along with it
+type codecBuffer struct {
+	bytes.Buffer
+	json *json.Encoder
+	xml  *xml.Encoder
+}
+
+func newCodecBuffer() *codecBuffer {
+	b := &codecBuffer{}
+	b.json = json.NewEncoder(&b.Buffer)
+	b.xml = xml.NewEncoder(&b.Buffer)
+	return b
+}
+
+// bufferPool holds codec buffers, so decoding and rendering allocate none once it is warm
+var bufferPool = sync.Pool{
+	New: func() interface{} { return newCodecBuffer() },
+}
+
+// getBuffer takes an empty buffer from the pool
+func getBuffer() *codecBuffer {
+	b := bufferPool.Get().(*codecBuffer)
+	b.Reset()
+	return b
+}
+
+// putBuffer returns a buffer to the pool unless a large body grew it past the pooled size
+func putBuffer(b *codecBuffer) {
+	if b.Cap() > consts.MaxPooledBufferSize {
+		return
+	}
+	bufferPool.Put(b)
+}
+
+// encode appends data encoded with a codec, producing what its Marshal would. JSON and XML
+// are encoded with the buffer's own encoders rather than into a newly allocated slice.
+func (b *codecBuffer) encode(c codec.Codec, data interface{}) error {
+	switch c.(type) {
+	case codec.JSON:
+		if err := b.json.Encode(data); err != nil {
+			return err
+		}
+		// Encode terminates the value with a newline that Marshal does not
+		b.Truncate(b.Len() - 1)
+		return nil
+	case codec.XML:
+		if err := b.xml.Encode(data); err != nil {
+			// A failed encode may leave open elements or unflushed output behind
+			b.xml = xml.NewEncoder(&b.Buffer)
+			return err
+		}
+		return nil
+	}
+
+	body, err := c.Marshal(data)
+	if err != nil {
+		return err
+	}
+	b.Write(body)
+	return nil
+}
+
+// contentTypes holds a Content-Type header value per media type, shared by responses so that
+// setting the header does not allocate
+var contentTypes = struct {
+	sync.RWMutex
+	values map[string][]string
+}{values: make(map[string][]string)}
+
+// setContentType sets a response's Content-Type header to a media type
+func setContentType(h http.Header, mediaType string) {
+	contentTypes.RLock()
+	value, ok := contentTypes.values[mediaType]
+	contentTypes.RUnlock()
+
+	if !ok {
+		// Capacity 1 makes appending to the header copy rather than modify the shared value
+		value = []string{mediaType}[:1:1]
+		contentTypes.Lock()
+		contentTypes.values[mediaType] = value
+		contentTypes.Unlock()
+	}
+	h["Content-Type"] = value
+}
+
+// DecodeRequest decodes the request body with the codec registered for its content type.
+// Bodies over MaxRequestBodySize are refused with an *http.MaxBytesError.
 func DecodeRequest(r *http.Request, request interface{}) error {
 	contentType := r.Header.Get("Content-Type")
+	if contentType == "" {
+		contentType = "application/json"
+	}
 
-	switch contentType {
-	case "application/json", "":
-		return json.NewDecoder(r.Body).Decode(request)
-	case "application/xml", "text/xml":
-		return xml.NewDecoder(r.Body).Decode(request)
-	default:
+	c, err := codec.Lookup(contentType)
+	if err != nil {
 		return fmt.Errorf("unsupported content type: %s", contentType)
 	}
+
+	// Codecs copy what they decode, so the body's buffer can be reused once Unmarshal returns
+	buf := getBuffer()
+	defer putBuffer(buf)
+	if _, err := buf.ReadFrom(http.MaxBytesReader(nil, r.Body, consts.MaxRequestBodySize)); err != nil {
+		return err
+	}
+	return c.Unmarshal(buf.Bytes(), request)
+}
+
+// IsBodyTooLarge reports whether a request body was refused for exceeding its size limit
+func IsBodyTooLarge(err error) bool {
+	var tooLarge *http.MaxBytesError
+	return errors.As(err, &tooLarge)
+}
+
+// SendDecodeError sends a 413 response when a request body was refused for its size, and a 400
+// error response for any other failure to decode it
+func SendDecodeError(w http.ResponseWriter, r *http.Request, err error) {
+	if IsBodyTooLarge(err) {
+		SendErrorResponse(w, r, http.StatusRequestEntityTooLarge, fmt.Sprintf("Request body exceeds %d bytes", consts.MaxRequestBodySize))
+		return
+	}
+	SendErrorResponse(w, r, http.StatusBadRequest, fmt.Sprintf("Invalid request: %v", err))
 }
 
 // sendResponse sends a response with the appropriate format
@@ -30,24 +147,42 @@ func SendResponse(w http.ResponseWriter, r *http.Request, statusCode int, data i
 	if contentType == "" {
 		contentType = r.Header.Get("Content-Type")
 	}
-	if contentType == "" {
-		contentType = "application/json" // Default to JSON
-	}
 
+	// Render with the first acceptable codec, defaulting to JSON when none applies
+	// or the data cannot be represented in the requested format
+	buf := getBuffer()
+	defer putBuffer(buf)
+	mediaType := renderResponse(buf, contentType, data)
+
+	setContentType(w.Header(), mediaType)
 	w.WriteHeader(statusCode)
+	w.Write(buf.Bytes())
+}
+
+// renderResponse encodes data into buf with the first codec named in an Accept-style list,
+// falling back to JSON, and returns the media type of the encoding
+func renderResponse(buf *codecBuffer, accept string, data interface{}) string {
+	for rest, more := accept, true; more; {
+		var candidate string
+		candidate, rest, more = strings.Cut(rest, ",")
+
+		c, err := codec.Lookup(candidate)
+		if err != nil {
+			continue
+		}
+		if buf.encode(c, data) == nil {
+			return c.ContentTypes()[0]
+		}
+		buf.Reset()
+		break
+	}
 
-	switch contentType {
-	case "application/json":
-		w.Header().Set("Content-Type", "application/json")
-		json.NewEncoder(w).Encode(data)
-	case "application/xml", "text/xml":
-		w.Header().Set("Content-Type", "application/xml")
-		xml.NewEncoder(w).Encode(data)
-	default:
-		// Default to JSON
-		w.Header().Set("Content-Type", "application/json")
-		json.NewEncoder(w).Encode(data)
+	// Encode terminates the body with a newline; a value JSON cannot represent leaves the body empty
+	if err := buf.json.Encode(data); err != nil {
+		buf.Reset()
+		buf.WriteByte('\n')
 	}
+	return "application/json"
 }
 
 // SendErrorResponse sends an error response
@@ -59,3 +194,20 @@ func SendErrorResponse(w http.ResponseWriter, r *http.Request, statusCode int, m
 
 	SendResponse(w, r, statusCode, response)
 }
+
+// SendValidationError sends a 400 response listing the invalid fields when err is a validation
+// failure, and a plain 400 error response otherwise
+func SendValidationError(w http.ResponseWriter, r *http.Request, err error) {
+	response := models.APIResponse{
+		StatusCode: http.StatusBadRequest,
+		Message:    fmt.Sprintf("Invalid request: %v", err),
+	}
+
+	var fieldErrs validation.Errors
+	if errors.As(err, &fieldErrs) {
+		response.Message = "Invalid request"
+		response.Errors = fieldErrs
+	}
+
+	SendResponse(w, r, http.StatusBadRequest, response)
+}
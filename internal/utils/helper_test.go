@@ -0,0 +1,174 @@
+package utils
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"payment-gateway/internal/consts"
+	"payment-gateway/internal/models"
+	"strings"
+	"testing"
+)
+
+// benchmarkTransaction is a transaction response as handlers send it
+var benchmarkTransaction = models.TransactionResponse{
+	Status:                 "processing",
+	TransactionID:          1042,
+	ReferenceID:            "TX-20261018-1042",
+	Message:                "Transaction is being processed",
+	GatewayReference:       "pi_3Q0x2bLkdIwHu7ix0a1b2c3d",
+	RedirectURL:            "https://pay.example/checkout?session=1042&step=<3ds>",
+	ExpectedSettlementDate: "2026-10-20",
+}
+
+// TestSendResponseFormats tests that responses are rendered in the first acceptable format and
+// fall back to newline-terminated JSON
+func TestSendResponseFormats(t *testing.T) {
+	tests := []struct {
+		name      string
+		accept    string
+		data      interface{}
+		wantType  string
+		wantBody  string
+		wantXMLTx bool
+	}{
+		{"json", "application/json", map[string]int{"id": 1}, "application/json", `{"id":1}`, false},
+		{"unsupported then xml", "text/html, application/xml", benchmarkTransaction, "application/xml", "", true},
+		{"no accept", "", map[string]string{"url": "a?b=<c>&d"}, "application/json", "{\"url\":\"a?b=\\u003cc\\u003e\\u0026d\"}\n", false},
+		{"unrepresentable in xml", "application/xml", map[string]int{"id": 1}, "application/json", "{\"id\":1}\n", false},
+		{"unrepresentable in json", "application/json", func() {}, "application/json", "\n", false},
+		{"xml after a failed xml encode", "application/xml", benchmarkTransaction, "application/xml", "", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/transactions/1", nil)
+			if tt.accept != "" {
+				req.Header.Set("Accept", tt.accept)
+			}
+			rec := httptest.NewRecorder()
+			SendResponse(rec, req, http.StatusOK, tt.data)
+
+			if got := rec.Header().Get("Content-Type"); got != tt.wantType {
+				t.Errorf("Expected Content-Type %q, got %q", tt.wantType, got)
+			}
+			if tt.wantXMLTx {
+				if !strings.HasPrefix(rec.Body.String(), "<TransactionResponse>") || !strings.Contains(rec.Body.String(), "<TransactionID>1042</TransactionID>") {
+					t.Errorf("Expected the transaction as XML, got %q", rec.Body.String())
+				}
+				return
+			}
+			if got := rec.Body.String(); got != tt.wantBody {
+				t.Errorf("Expected body %q, got %q", tt.wantBody, got)
+			}
+		})
+	}
+}
+
+// TestDecodeRequestReusesBuffers tests that a decoded request is unaffected by the bodies of
+// requests decoded after it
+func TestDecodeRequestReusesBuffers(t *testing.T) {
+	decode := func(body string) models.TransactionRequest {
+		req := httptest.NewRequest(http.MethodPost, "/deposit", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		var request models.TransactionRequest
+		if err := DecodeRequest(req, &request); err != nil {
+			t.Fatalf("Failed to decode %s: %v", body, err)
+		}
+		return request
+	}
+
+	first := decode(`{"user_id":1,"amount":10,"currency":"USD","beneficiary":"Alice"}`)
+	decode(`{"user_id":2,"amount":20,"currency":"EUR","beneficiary":"Bobby"}`)
+
+	if first.UserID != 1 || first.Currency != "USD" || first.Beneficiary != "Alice" {
+		t.Errorf("Expected the first request intact, got %+v", first)
+	}
+}
+
+// TestDecodeRequestBodyLimit tests that bodies over the size limit are refused with 413 and
+// bodies within it are decoded
+func TestDecodeRequestBodyLimit(t *testing.T) {
+	padding := strings.Repeat(" ", consts.MaxRequestBodySize)
+	for _, tt := range []struct {
+		body string
+		want int
+	}{
+		{`{"user_id":1}`, http.StatusOK},
+		{`{"user_id":1}` + padding, http.StatusRequestEntityTooLarge},
+		{`{"user_id":`, http.StatusBadRequest},
+	} {
+		req := httptest.NewRequest(http.MethodPost, "/deposit", strings.NewReader(tt.body))
+		rec := httptest.NewRecorder()
+		var request models.TransactionRequest
+		if err := DecodeRequest(req, &request); err != nil {
+			SendDecodeError(rec, req, err)
+		}
+		if rec.Code != tt.want {
+			t.Errorf("Body of %d bytes: expected status %d, got %d", len(tt.body), tt.want, rec.Code)
+		}
+	}
+}
+
+// discardWriter is a response writer that drops what is written, so benchmarks measure
+// rendering only
+type discardWriter struct {
+	header http.Header
+}
+
+func (w *discardWriter) Header() http.Header         { return w.header }
+func (w *discardWriter) Write(b []byte) (int, error) { return len(b), nil }
+func (w *discardWriter) WriteHeader(int)             {}
+
+// benchmarkSendResponse measures rendering a transaction response in the format accept names
+func benchmarkSendResponse(b *testing.B, accept string) {
+	req := httptest.NewRequest(http.MethodGet, "/transactions/1042", nil)
+	req.Header.Set("Accept", accept)
+	w := &discardWriter{header: make(http.Header)}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		SendResponse(w, req, http.StatusOK, benchmarkTransaction)
+	}
+}
+
+func BenchmarkSendResponseJSON(b *testing.B) { benchmarkSendResponse(b, "application/json") }
+
+func BenchmarkSendResponseXML(b *testing.B) { benchmarkSendResponse(b, "application/xml") }
+
+func BenchmarkSendResponseFallback(b *testing.B) { benchmarkSendResponse(b, "") }
+
+// BenchmarkSendResponseParallel measures rendering under concurrent requests, as served at high
+// request rates
+func BenchmarkSendResponseParallel(b *testing.B) {
+	b.ReportAllocs()
+	b.RunParallel(func(pb *testing.PB) {
+		req := httptest.NewRequest(http.MethodGet, "/transactions/1042", nil)
+		req.Header.Set("Accept", "application/json")
+		w := &discardWriter{header: make(http.Header)}
+		for pb.Next() {
+			SendResponse(w, req, http.StatusOK, benchmarkTransaction)
+		}
+	})
+}
+
+// BenchmarkDecodeRequest measures decoding a JSON transaction request
+func BenchmarkDecodeRequest(b *testing.B) {
+	body := []byte(`{"user_id":7,"amount":250.75,"currency":"EUR","beneficiary":"Jane Doe","return_url":"https://merchant.example/return"}`)
+	reader := bytes.NewReader(body)
+	req := httptest.NewRequest(http.MethodPost, "/deposit", nil)
+	req.Header.Set("Content-Type", "application/json")
+	req.Body = io.NopCloser(reader)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		reader.Reset(body)
+		var request models.TransactionRequest
+		if err := DecodeRequest(req, &request); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,107 @@
+package utils
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// oidApplePayMerchantID is the extension of Apple Pay payment processing certificates holding the
+// hash of the merchant ID, which Apple Pay mixes into the key tokens are encrypted with
+var oidApplePayMerchantID = asn1.ObjectIdentifier{1, 2, 840, 113635, 100, 6, 32}
+
+// MerchantKey is a P-256 key pair Apple Pay or Google Pay payment tokens are encrypted to
+type MerchantKey struct {
+	PrivateKey *ecdsa.PrivateKey
+
+	// Certificate is the payment processing certificate Apple issued for the key; nil for Google Pay
+	Certificate *x509.Certificate
+
+	// MerchantIdentifier is the hash of the Apple Pay merchant ID the certificate was issued to
+	MerchantIdentifier []byte
+}
+
+// ParseMerchantKey parses a PEM private key, in PKCS #8 or SEC 1 form, and the PEM certificate
+// issued for it, if any. The key must be a P-256 key and the certificate must match it.
+func ParseMerchantKey(certificatePEM, privateKeyPEM string) (*MerchantKey, error) {
+	block, _ := pem.Decode([]byte(privateKeyPEM))
+	if block == nil {
+		return nil, errors.New("private key is not PEM encoded")
+	}
+
+	var key *ecdsa.PrivateKey
+	if parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes); err == nil {
+		ecKey, ok := parsed.(*ecdsa.PrivateKey)
+		if !ok {
+			return nil, fmt.Errorf("private key is a %T, not an EC key", parsed)
+		}
+		key = ecKey
+	} else if key, err = x509.ParseECPrivateKey(block.Bytes); err != nil {
+		return nil, fmt.Errorf("invalid private key: %w", err)
+	}
+	if key.Curve != elliptic.P256() {
+		return nil, fmt.Errorf("private key is on %s, not P-256", key.Curve.Params().Name)
+	}
+
+	merchantKey := &MerchantKey{PrivateKey: key}
+	if strings.TrimSpace(certificatePEM) == "" {
+		return merchantKey, nil
+	}
+
+	block, _ = pem.Decode([]byte(certificatePEM))
+	if block == nil || block.Type != "CERTIFICATE" {
+		return nil, errors.New("certificate is not PEM encoded")
+	}
+	certificate, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("invalid certificate: %w", err)
+	}
+	public, ok := certificate.PublicKey.(*ecdsa.PublicKey)
+	if !ok || !public.Equal(&key.PublicKey) {
+		return nil, errors.New("certificate does not match the private key")
+	}
+	merchantKey.Certificate = certificate
+
+	for _, extension := range certificate.Extensions {
+		if !extension.Id.Equal(oidApplePayMerchantID) {
+			continue
+		}
+		// The extension holds the hex hash as an ASN.1 string
+		var value asn1.RawValue
+		if _, err := asn1.Unmarshal(extension.Value, &value); err != nil {
+			return nil, fmt.Errorf("invalid merchant ID extension: %w", err)
+		}
+		if merchantKey.MerchantIdentifier, err = hex.DecodeString(string(value.Bytes)); err != nil {
+			return nil, fmt.Errorf("invalid merchant ID extension: %w", err)
+		}
+	}
+	return merchantKey, nil
+}
+
+// PublicKeyHash returns the base64 SHA-256 of the key's DER public key, by which Apple Pay tokens
+// name the key they were encrypted to
+func (k *MerchantKey) PublicKeyHash() (string, error) {
+	der, err := x509.MarshalPKIXPublicKey(&k.PrivateKey.PublicKey)
+	if err != nil {
+		return "", err
+	}
+	hash := sha256.Sum256(der)
+	return base64.StdEncoding.EncodeToString(hash[:]), nil
+}
+
+// PublicKey returns the base64 uncompressed public key, as it is registered with Google Pay
+func (k *MerchantKey) PublicKey() (string, error) {
+	public, err := k.PrivateKey.PublicKey.ECDH()
+	if err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(public.Bytes()), nil
+}
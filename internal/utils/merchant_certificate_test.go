@@ -0,0 +1,87 @@
+package utils
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/pem"
+	"math/big"
+	"testing"
+	"time"
+)
+
+// TestParseMerchantKey tests that merchant keys are parsed in either PEM form with the merchant ID
+// hash of their Apple Pay certificate, and that mismatched or unsupported keys are rejected
+func TestParseMerchantKey(t *testing.T) {
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	other, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	p384, _ := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+
+	merchantID := sha256.Sum256([]byte("merchant.com.example"))
+	extension, _ := asn1.Marshal(hex.EncodeToString(merchantID[:]))
+	template := &x509.Certificate{
+		SerialNumber:    big.NewInt(1),
+		Subject:         pkix.Name{CommonName: "Apple Pay Payment Processing:merchant.com.example"},
+		NotBefore:       time.Now(),
+		NotAfter:        time.Now().Add(24 * time.Hour),
+		ExtraExtensions: []pkix.Extension{{Id: oidApplePayMerchantID, Value: extension}},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("Failed to create certificate: %v", err)
+	}
+	certificatePEM := string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}))
+
+	pkcs8 := func(key *ecdsa.PrivateKey) string {
+		der, _ := x509.MarshalPKCS8PrivateKey(key)
+		return string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}))
+	}
+	sec1, _ := x509.MarshalECPrivateKey(key)
+	sec1PEM := string(pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: sec1}))
+
+	merchantKey, err := ParseMerchantKey(certificatePEM, pkcs8(key))
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	if !bytes.Equal(merchantKey.MerchantIdentifier, merchantID[:]) || merchantKey.Certificate == nil {
+		t.Errorf("Expected the merchant ID hash %x, got %x", merchantID, merchantKey.MerchantIdentifier)
+	}
+
+	spki, _ := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	spkiHash := sha256.Sum256(spki)
+	if hash, _ := merchantKey.PublicKeyHash(); hash != base64.StdEncoding.EncodeToString(spkiHash[:]) {
+		t.Errorf("Expected the hash of the DER public key, got %s", hash)
+	}
+	if public, _ := merchantKey.PublicKey(); len(public) != 88 {
+		t.Errorf("Expected a base64 65-byte uncompressed point, got %q", public)
+	}
+
+	// Google Pay keys come without a certificate
+	googleKey, err := ParseMerchantKey("", sec1PEM)
+	if err != nil || googleKey.Certificate != nil || googleKey.MerchantIdentifier != nil {
+		t.Errorf("Expected a key without a certificate, got %+v (%v)", googleKey, err)
+	}
+
+	invalid := []struct {
+		name        string
+		certificate string
+		key         string
+	}{
+		{"mismatched certificate", certificatePEM, pkcs8(other)},
+		{"P-384 key", "", pkcs8(p384)},
+		{"not PEM", "", "not a key"},
+		{"certificate not PEM", "not a certificate", pkcs8(key)},
+	}
+	for _, tt := range invalid {
+		if _, err := ParseMerchantKey(tt.certificate, tt.key); err == nil {
+			t.Errorf("%s: expected an error", tt.name)
+		}
+	}
+}
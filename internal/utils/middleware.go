@@ -1,8 +1,15 @@
 package utils
 
 import (
+	"bytes"
+	"context"
+	"encoding/json"
 	"fmt"
+	"io"
+	"log"
+	"math/rand"
 	"net/http"
+	"strings"
 	"time"
 )
 
@@ -20,21 +27,234 @@ func LoggingMiddleware(next http.Handler) http.Handler {
 	})
 }
 
-// CorsMiddleware adds CORS headers to responses
-func CorsMiddleware(next http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		// Set CORS headers for all responses
-		w.Header().Set("Access-Control-Allow-Origin", "*")
-		w.Header().Set("Access-Control-Allow-Methods", "POST, GET, OPTIONS, PUT, DELETE")
-		w.Header().Set("Access-Control-Allow-Headers", "Accept, Content-Type, Content-Length, Accept-Encoding, Authorization")
-
-		// Handle preflight requests
-		if r.Method == "OPTIONS" {
-			w.WriteHeader(http.StatusOK)
-			return
+// CorsMiddleware adds CORS headers to responses to requests from the allowed origins
+func CorsMiddleware(config SecurityConfig) func(http.Handler) http.Handler {
+	allowed := make(map[string]bool, len(config.AllowedOrigins))
+	for _, origin := range config.AllowedOrigins {
+		allowed[origin] = true
+	}
+	anyOrigin := config.AllowsAnyOrigin()
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			// Set CORS headers for responses to allowed origins
+			origin := r.Header.Get("Origin")
+			if anyOrigin || allowed[origin] {
+				if anyOrigin {
+					w.Header().Set("Access-Control-Allow-Origin", "*")
+				} else {
+					w.Header().Set("Access-Control-Allow-Origin", origin)
+					w.Header().Add("Vary", "Origin")
+				}
+				w.Header().Set("Access-Control-Allow-Methods", "POST, GET, OPTIONS, PUT, DELETE")
+				w.Header().Set("Access-Control-Allow-Headers", "Accept, Content-Type, Content-Length, Accept-Encoding, Authorization, If-None-Match, Idempotency-Key")
+				w.Header().Set("Access-Control-Expose-Headers", "ETag, Idempotent-Replayed")
+			}
+
+			// Handle preflight requests
+			if r.Method == "OPTIONS" {
+				w.WriteHeader(http.StatusOK)
+				return
+			}
+
+			// Call the next handler
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// AdminAuthMiddleware requires requests to paths under prefix to come from the admin networks
+// and to carry an admin token as a bearer token, recording the operator it belongs to in the
+// admin audit log
+func AdminAuthMiddleware(config SecurityConfig, prefix string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		if !config.AdminAuthEnabled() && len(config.AdminNetworks) == 0 {
+			return next
 		}
 
-		// Call the next handler
-		next.ServeHTTP(w, r)
-	})
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !strings.HasPrefix(r.URL.Path, prefix) {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			if !config.adminNetworkAllowed(r.RemoteAddr) {
+				SendErrorResponse(w, r, http.StatusForbidden, "Admin access is not allowed from this address")
+				return
+			}
+
+			if config.AdminAuthEnabled() {
+				header := r.Header.Get("Authorization")
+				token := ""
+				if len(header) > 7 && strings.EqualFold(header[:7], "Bearer ") {
+					token = strings.TrimSpace(header[7:])
+				}
+				actor, ok := config.adminActor(token)
+				if !ok {
+					w.Header().Set("WWW-Authenticate", "Bearer")
+					SendErrorResponse(w, r, http.StatusUnauthorized, "Admin token required")
+					return
+				}
+				if entry, ok := r.Context().Value(adminAuditKey{}).(*adminAuditEntry); ok {
+					entry.Actor = actor
+				}
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// adminAuditKey is the context key of the audit log entry of an admin request
+type adminAuditKey struct{}
+
+// adminAuditEntry is an admin request in the audit log
+type adminAuditEntry struct {
+	Time         time.Time `json:"time"`
+	Actor        string    `json:"actor,omitempty"` // operator of the admin token, empty when refused or unauthenticated
+	RemoteAddr   string    `json:"remote_addr"`
+	ForwardedFor string    `json:"forwarded_for,omitempty"`
+	Method       string    `json:"method"`
+	Path         string    `json:"path"`
+	Query        string    `json:"query,omitempty"`
+	Status       int       `json:"status"`
+	DurationMS   float64   `json:"duration_ms"`
+}
+
+// AdminAuditMiddleware logs every request to paths under prefix, including refused ones, with
+// the operator AdminAuthMiddleware authenticated, so it must come before it in the chain. Query
+// strings are masked and bodies are not logged.
+func AdminAuditMiddleware(prefix string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !strings.HasPrefix(r.URL.Path, prefix) {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			entry := &adminAuditEntry{
+				Time:         time.Now().UTC(),
+				RemoteAddr:   r.RemoteAddr,
+				ForwardedFor: r.Header.Get("X-Forwarded-For"),
+				Method:       r.Method,
+				Path:         r.URL.Path,
+				Query:        MaskBody("application/x-www-form-urlencoded", []byte(r.URL.RawQuery)),
+			}
+			recorder := &responseRecorder{ResponseWriter: w, status: http.StatusOK}
+			next.ServeHTTP(recorder, r.WithContext(context.WithValue(r.Context(), adminAuditKey{}, entry)))
+			entry.Status = recorder.status
+			entry.DurationMS = float64(time.Since(entry.Time).Microseconds()) / 1000
+
+			line, err := json.Marshal(entry)
+			if err != nil {
+				log.Printf("Failed to audit admin request %s %s: %v", r.Method, r.URL.Path, err)
+				return
+			}
+			log.Printf("Admin audit: %s", line)
+		})
+	}
+}
+
+// RequestLogConfig controls logging of full requests and responses for debugging
+type RequestLogConfig struct {
+	Enabled      bool
+	SampleRate   float64 // fraction of requests logged, from 0 to 1
+	MaxBodyBytes int     // longer bodies are summarized by their size
+}
+
+// requestLogEntry is a logged request and its response
+type requestLogEntry struct {
+	Method          string            `json:"method"`
+	Path            string            `json:"path"`
+	Query           string            `json:"query,omitempty"`
+	Status          int               `json:"status"`
+	DurationMS      float64           `json:"duration_ms"`
+	RequestHeaders  map[string]string `json:"request_headers"`
+	RequestBody     string            `json:"request_body,omitempty"`
+	ResponseHeaders map[string]string `json:"response_headers"`
+	ResponseBody    string            `json:"response_body,omitempty"`
+}
+
+// RequestLogMiddleware logs a sample of requests with their responses in full. Credentials are
+// masked in headers, and sensitive fields and emails in query strings and bodies, before writing.
+func RequestLogMiddleware(config RequestLogConfig) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		if !config.Enabled || config.SampleRate <= 0 {
+			return next
+		}
+
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if config.SampleRate < 1 && rand.Float64() >= config.SampleRate {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			// Read up to one byte past the limit to tell whether the body is too long, then
+			// restore the body for the handler
+			var requestBody []byte
+			if r.Body != nil {
+				requestBody, _ = io.ReadAll(io.LimitReader(r.Body, int64(config.MaxBodyBytes)+1))
+				r.Body = readCloser{io.MultiReader(bytes.NewReader(requestBody), r.Body), r.Body}
+			}
+
+			recorder := &responseRecorder{ResponseWriter: w, status: http.StatusOK, limit: config.MaxBodyBytes + 1}
+			start := time.Now()
+			next.ServeHTTP(recorder, r)
+
+			entry := requestLogEntry{
+				Method:          r.Method,
+				Path:            r.URL.Path,
+				Query:           MaskBody("application/x-www-form-urlencoded", []byte(r.URL.RawQuery)),
+				Status:          recorder.status,
+				DurationMS:      float64(time.Since(start).Microseconds()) / 1000,
+				RequestHeaders:  MaskHeaders(r.Header),
+				RequestBody:     logBody(r.Header.Get("Content-Type"), requestBody, config.MaxBodyBytes),
+				ResponseHeaders: MaskHeaders(w.Header()),
+				ResponseBody:    logBody(w.Header().Get("Content-Type"), recorder.body.Bytes(), config.MaxBodyBytes),
+			}
+			line, err := json.Marshal(entry)
+			if err != nil {
+				log.Printf("Failed to log request %s %s: %v", r.Method, r.URL.Path, err)
+				return
+			}
+			log.Printf("HTTP exchange: %s", line)
+		})
+	}
+}
+
+// logBody masks a body for logging, summarizing bodies longer than limit by their size
+func logBody(contentType string, body []byte, limit int) string {
+	if len(body) > limit {
+		return fmt.Sprintf("[over %d bytes of %s]", limit, contentType)
+	}
+	return MaskBody(contentType, body)
+}
+
+// readCloser reads a restored request body and closes the original one
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
+
+// responseRecorder records the status and the start of the body written to a response
+type responseRecorder struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+	limit  int
+}
+
+func (r *responseRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *responseRecorder) Write(data []byte) (int, error) {
+	if remaining := r.limit - r.body.Len(); remaining > 0 {
+		if len(data) < remaining {
+			remaining = len(data)
+		}
+		r.body.Write(data[:remaining])
+	}
+	return r.ResponseWriter.Write(data)
 }
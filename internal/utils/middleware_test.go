@@ -0,0 +1,171 @@
+package utils
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+)
+
+// TestCorsMiddleware tests that CORS headers are only sent to allowed origins
+func TestCorsMiddleware(t *testing.T) {
+	handler := CorsMiddleware(SecurityConfig{AllowedOrigins: []string{"https://dashboard.example.com"}})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	tests := []struct {
+		origin string
+		want   string
+	}{
+		{"https://dashboard.example.com", "https://dashboard.example.com"},
+		{"https://evil.example.com", ""},
+	}
+
+	for _, tt := range tests {
+		req := httptest.NewRequest(http.MethodGet, "/health", nil)
+		req.Header.Set("Origin", tt.origin)
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+
+		if got := rec.Header().Get("Access-Control-Allow-Origin"); got != tt.want {
+			t.Errorf("Origin %s: expected Access-Control-Allow-Origin %q, got %q", tt.origin, tt.want, got)
+		}
+	}
+}
+
+// TestAdminAuthMiddleware tests that admin paths require the admin token and other paths do not
+func TestAdminAuthMiddleware(t *testing.T) {
+	handler := AdminAuthMiddleware(SecurityConfig{AdminToken: "admin-token"}, "/admin/")(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	tests := []struct {
+		path          string
+		authorization string
+		want          int
+	}{
+		{"/admin/disputes", "", http.StatusUnauthorized},
+		{"/admin/disputes", "Bearer wrong-token", http.StatusUnauthorized},
+		{"/admin/disputes", "Bearer admin-token", http.StatusOK},
+		{"/deposit", "", http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		req := httptest.NewRequest(http.MethodGet, tt.path, nil)
+		if tt.authorization != "" {
+			req.Header.Set("Authorization", tt.authorization)
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+
+		if rec.Code != tt.want {
+			t.Errorf("%s with %q: expected status %d, got %d", tt.path, tt.authorization, tt.want, rec.Code)
+		}
+	}
+}
+
+// TestAdminAuthMiddlewareOperators tests that operator tokens are accepted, that requests from
+// outside the admin networks are refused whatever their token, and that the audit log records
+// the operator of each request and the requests refused
+func TestAdminAuthMiddlewareOperators(t *testing.T) {
+	var logged bytes.Buffer
+	log.SetOutput(&logged)
+	defer log.SetOutput(os.Stderr)
+
+	networks, _ := ParseNetworks("10.0.0.0/8")
+	config := SecurityConfig{AdminToken: "admin-token", AdminTokens: map[string]string{"alice": "alice-token"}, AdminNetworks: networks}
+	handler := AdminAuditMiddleware("/admin/")(AdminAuthMiddleware(config, "/admin/")(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	})))
+
+	tests := []struct {
+		remoteAddr string
+		token      string
+		want       int
+		actor      string
+	}{
+		{"10.0.0.5:4000", "alice-token", http.StatusNoContent, "alice"},
+		{"10.0.0.5:4000", "admin-token", http.StatusNoContent, AdminTokenActor},
+		{"10.0.0.5:4000", "mallory-token", http.StatusUnauthorized, ""},
+		{"203.0.113.9:4000", "alice-token", http.StatusForbidden, ""},
+	}
+	for _, tt := range tests {
+		logged.Reset()
+		req := httptest.NewRequest(http.MethodPost, "/admin/disputes/7?email=jane@example.com", nil)
+		req.RemoteAddr = tt.remoteAddr
+		req.Header.Set("Authorization", "Bearer "+tt.token)
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		if rec.Code != tt.want {
+			t.Errorf("%s from %s: expected status %d, got %d", tt.token, tt.remoteAddr, tt.want, rec.Code)
+		}
+
+		_, line, _ := strings.Cut(logged.String(), "Admin audit: ")
+		var entry adminAuditEntry
+		if err := json.Unmarshal([]byte(line), &entry); err != nil {
+			t.Fatalf("Expected an audit log entry, got %q", logged.String())
+		}
+		if entry.Actor != tt.actor || entry.Status != tt.want || entry.RemoteAddr != tt.remoteAddr || entry.Method != http.MethodPost || entry.Path != "/admin/disputes/7" {
+			t.Errorf("Expected the request audited as %s, got %+v", tt.actor, entry)
+		}
+		if strings.Contains(line, "jane@example.com") || strings.Contains(line, tt.token) {
+			t.Errorf("Expected the query masked and no token logged, got %s", line)
+		}
+	}
+
+	logged.Reset()
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/deposit", nil))
+	if logged.Len() != 0 {
+		t.Errorf("Expected requests outside the admin endpoints not audited, got %s", logged.String())
+	}
+}
+
+// TestRequestLogMiddleware tests that logged exchanges are masked and handlers still see the full body
+func TestRequestLogMiddleware(t *testing.T) {
+	var logged bytes.Buffer
+	log.SetOutput(&logged)
+	defer log.SetOutput(os.Stderr)
+
+	var received string
+	handler := RequestLogMiddleware(RequestLogConfig{Enabled: true, SampleRate: 1, MaxBodyBytes: 1024})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		received = string(body)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte(`{"key":"pk_live_abc","name":"Checkout"}`))
+	}))
+
+	req := httptest.NewRequest(http.MethodPost, "/merchant/api-keys", strings.NewReader(`{"name":"Checkout","email":"jane@example.com"}`))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer secret-token")
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+
+	if received != `{"name":"Checkout","email":"jane@example.com"}` {
+		t.Errorf("Expected the handler to read the full body, got %s", received)
+	}
+	if rec.Body.String() != `{"key":"pk_live_abc","name":"Checkout"}` || rec.Code != http.StatusCreated {
+		t.Errorf("Expected the response to be written unchanged, got %d %s", rec.Code, rec.Body.String())
+	}
+
+	output := logged.String()
+	for _, leaked := range []string{"jane@example.com", "pk_live_abc", "secret-token"} {
+		if strings.Contains(output, leaked) {
+			t.Errorf("Expected %s to be masked, got: %s", leaked, output)
+		}
+	}
+	for _, want := range []string{`"status":201`, `j***@example.com`, `Checkout`} {
+		if !strings.Contains(output, want) {
+			t.Errorf("Expected the log to contain %s, got: %s", want, output)
+		}
+	}
+
+	// Nothing is logged when no requests are sampled
+	logged.Reset()
+	unsampled := RequestLogMiddleware(RequestLogConfig{Enabled: true, SampleRate: 0})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	unsampled.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/health", nil))
+	if logged.Len() != 0 {
+		t.Errorf("Expected nothing logged at a sample rate of 0, got: %s", logged.String())
+	}
+}
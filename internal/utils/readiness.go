@@ -0,0 +1,69 @@
+package utils
+
+import (
+	"sort"
+	"sync"
+)
+
+// Readiness tracks whether the dependencies the service needs to handle traffic are available
+type Readiness struct {
+	mu           sync.RWMutex
+	dependencies map[string]bool
+}
+
+// NewReadiness creates a readiness tracker with the given dependencies initially not ready
+func NewReadiness(dependencies ...string) *Readiness {
+	r := &Readiness{dependencies: make(map[string]bool, len(dependencies))}
+	for _, name := range dependencies {
+		r.dependencies[name] = false
+	}
+	return r
+}
+
+// SetReady records the readiness of a dependency
+func (r *Readiness) SetReady(dependency string, ready bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.dependencies[dependency] = ready
+}
+
+// IsReady reports whether every tracked dependency is ready
+func (r *Readiness) IsReady() bool {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	for _, ready := range r.dependencies {
+		if !ready {
+			return false
+		}
+	}
+	return true
+}
+
+// Status returns the readiness of each tracked dependency
+func (r *Readiness) Status() map[string]bool {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	status := make(map[string]bool, len(r.dependencies))
+	for name, ready := range r.dependencies {
+		status[name] = ready
+	}
+	return status
+}
+
+// Pending returns the names of dependencies that are not yet ready
+func (r *Readiness) Pending() []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	var pending []string
+	for name, ready := range r.dependencies {
+		if !ready {
+			pending = append(pending, name)
+		}
+	}
+	sort.Strings(pending)
+	return pending
+}
@@ -0,0 +1,31 @@
+package utils
+
+import (
+	"reflect"
+	"testing"
+)
+
+// TestReadiness tests that readiness is reported only once every dependency is ready
+func TestReadiness(t *testing.T) {
+	readiness := NewReadiness("database", "kafka")
+
+	if readiness.IsReady() {
+		t.Error("Expected not ready before dependencies are confirmed")
+	}
+
+	readiness.SetReady("database", true)
+
+	if readiness.IsReady() {
+		t.Error("Expected not ready while kafka is pending")
+	}
+
+	if pending := readiness.Pending(); !reflect.DeepEqual(pending, []string{"kafka"}) {
+		t.Errorf("Expected kafka to be pending, got: %v", pending)
+	}
+
+	readiness.SetReady("kafka", true)
+
+	if !readiness.IsReady() {
+		t.Error("Expected ready once all dependencies are confirmed")
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"log"
 	"math/rand"
+	"sync"
 	"time"
 
 	"github.com/sony/gobreaker"
@@ -12,6 +13,7 @@ import (
 // CircuitBreaker wraps gobreaker for payment gateway operations
 type CircuitBreaker struct {
 	breakers map[string]*gobreaker.CircuitBreaker
+	mu       sync.Mutex
 }
 
 // NewCircuitBreaker creates a new circuit breaker manager
@@ -23,6 +25,9 @@ func NewCircuitBreaker() *CircuitBreaker {
 
 // GetBreaker returns a circuit breaker for a specific gateway
 func (cb *CircuitBreaker) GetBreaker(gatewayID string) *gobreaker.CircuitBreaker {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
 	breaker, exists := cb.breakers[gatewayID]
 	if !exists {
 		// Create new breaker with default settings
@@ -1,21 +1,37 @@
 package utils
 
 import (
+	"bytes"
 	"crypto/aes"
 	"crypto/cipher"
+	"crypto/hmac"
 	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
 	"encoding/base64"
 	"encoding/hex"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
+	"net"
+	"net/http"
+	"net/url"
 	"os"
+	"sort"
+	"strings"
 )
 
+// developmentKeyHex is the hardcoded encryption key used for development
+const developmentKeyHex = "1234567890abcdef1234567890abcdef"
+
 var (
 	// encryptionKey is used for encrypting sensitive data
 	// In a real system, this should be securely stored and accessed
 	encryptionKey []byte
+
+	// developmentKey is set when encryptionKey is the hardcoded development key
+	developmentKey bool
 )
 
 func init() {
@@ -23,17 +39,36 @@ func init() {
 	keyStr := os.Getenv("ENCRYPTION_KEY")
 	if keyStr == "" {
 		// For development only - use a hardcoded key
-		// In production, this should fail if no key is provided
-		keyStr = "1234567890abcdef1234567890abcdef" // 32 bytes = 256 bits
+		// Production deployments refuse to start with it, see SecurityConfig.CheckProduction
+		keyStr = developmentKeyHex // 32 bytes = 256 bits
 	}
+	developmentKey = strings.EqualFold(keyStr, developmentKeyHex)
 
 	var err error
 	encryptionKey, err = hex.DecodeString(keyStr)
 	if err != nil {
 		// Log error and use a default key for development
-		// In production, this should fail
-		encryptionKey = []byte("1234567890abcdef1234567890abcdef")
+		// Production deployments refuse to start with it
+		encryptionKey = []byte(developmentKeyHex)
+		developmentKey = true
 	}
+
+	if len(blindIndexKeys) == 0 {
+		blindIndexKeys = []BlindIndexKey{derivedBlindIndexKey(encryptionKey)}
+	}
+}
+
+// UsingDevelopmentKey reports whether data is encrypted with the hardcoded development key,
+// because ENCRYPTION_KEY is unset, invalid or set to that key
+func UsingDevelopmentKey() bool {
+	return developmentKey
+}
+
+// DeriveKey derives a purpose-specific key from the encryption key, for development only
+func DeriveKey(purpose string) []byte {
+	mac := hmac.New(sha256.New, encryptionKey)
+	mac.Write([]byte(purpose))
+	return mac.Sum(nil)
 }
 
 // MaskData masks data using base64 encoding (non-encrypted, for logging)
@@ -122,3 +157,296 @@ func DecryptString(encryptedBase64 string) (string, error) {
 
 	return string(decrypted), nil
 }
+
+// GenerateID returns a random, URL-safe identifier with the given prefix
+func GenerateID(prefix string) (string, error) {
+	buf := make([]byte, 16)
+	if _, err := io.ReadFull(rand.Reader, buf); err != nil {
+		return "", err
+	}
+	return prefix + hex.EncodeToString(buf), nil
+}
+
+// GatewayIdempotencyKey derives a deterministic idempotency key for a gateway call from
+// the attributes that identify a transaction, so every retry of the same call reuses it
+func GatewayIdempotencyKey(gatewayID, txType string, txID int, amount float64, currency string) string {
+	payload := fmt.Sprintf("%s|%s|%d|%.2f|%s", gatewayID, txType, txID, amount, currency)
+	sum := sha256.Sum256([]byte(payload))
+	return "pgw-" + hex.EncodeToString(sum[:16])
+}
+
+// sensitiveHeaders lists request headers whose values must never be stored
+var sensitiveHeaders = map[string]bool{
+	"Authorization":       true,
+	"Proxy-Authorization": true,
+	"Cookie":              true,
+	"Set-Cookie":          true,
+	"X-Api-Key":           true,
+	"X-Webhook-Signature": true,
+}
+
+// MaskHeaders flattens request headers for storage, replacing the values of credentials and signatures
+func MaskHeaders(header http.Header) map[string]string {
+	masked := make(map[string]string, len(header))
+	for name, values := range header {
+		name = http.CanonicalHeaderKey(name)
+		if sensitiveHeaders[name] {
+			masked[name] = "***"
+			continue
+		}
+		masked[name] = strings.Join(values, ", ")
+	}
+	return masked
+}
+
+// MaskEmail keeps the first character of the local part and the domain of an email address
+func MaskEmail(email string) string {
+	local, domain, ok := strings.Cut(email, "@")
+	if !ok || local == "" {
+		return "***"
+	}
+	return local[:1] + "***@" + domain
+}
+
+// sensitiveFields lists request and response body fields whose values must never be logged.
+// Emails are masked with MaskEmail instead.
+var sensitiveFields = map[string]bool{
+	"password":               true,
+	"secret":                 true,
+	"client_secret":          true,
+	"key":                    true,
+	"access_token":           true,
+	"beneficiary":            true,
+	"phone":                  true,
+	"phone_number":           true,
+	"phone_e164":             true,
+	"postal_code":            true,
+	"postal_code_normalized": true,
+	"redirect_url":           true,
+	"compliance_fields":      true,
+	"travel_rule":            true,
+	"full_name":              true,
+	"payment_token":          true,
+	"private_key":            true,
+}
+
+// MaskBody renders a JSON or form-encoded body for logging, masking the values of sensitive fields
+// and emails. Bodies in other formats, or that cannot be parsed, are summarized by their size.
+func MaskBody(contentType string, body []byte) string {
+	if len(body) == 0 {
+		return ""
+	}
+
+	mediaType, _, _ := strings.Cut(contentType, ";")
+	switch strings.TrimSpace(strings.ToLower(mediaType)) {
+	case "application/json", "":
+		decoder := json.NewDecoder(bytes.NewReader(body))
+		decoder.UseNumber()
+		var value interface{}
+		if err := decoder.Decode(&value); err == nil {
+			if masked, err := json.Marshal(maskValue("", value)); err == nil {
+				return string(masked)
+			}
+		}
+	case "application/x-www-form-urlencoded":
+		if values, err := url.ParseQuery(string(body)); err == nil {
+			for name, fieldValues := range values {
+				for i, v := range fieldValues {
+					fieldValues[i], _ = maskValue(name, v).(string)
+				}
+			}
+			return values.Encode()
+		}
+	}
+	return fmt.Sprintf("[%d bytes of %s]", len(body), contentType)
+}
+
+// maskValue masks a decoded body value found under the given field name, recursing into objects
+// and arrays
+func maskValue(field string, value interface{}) interface{} {
+	field = strings.ToLower(field)
+	switch v := value.(type) {
+	case map[string]interface{}:
+		// Every value of a sensitive object, such as compliance_fields, is masked
+		for name, nested := range v {
+			if sensitiveFields[field] {
+				v[name] = maskValue(field, nested)
+			} else {
+				v[name] = maskValue(name, nested)
+			}
+		}
+		return v
+	case []interface{}:
+		for i, nested := range v {
+			v[i] = maskValue(field, nested)
+		}
+		return v
+	}
+
+	if sensitiveFields[field] && value != nil {
+		return "***"
+	}
+	if email, ok := value.(string); ok && (strings.Contains(field, "email") || looksLikeEmail(email)) {
+		return MaskEmail(email)
+	}
+	return value
+}
+
+// looksLikeEmail reports whether a value that is not in an email field, such as a search query,
+// is an email address
+func looksLikeEmail(value string) bool {
+	return strings.Contains(value, "@") && !strings.ContainsAny(value, " /")
+}
+
+// AdminTokenMinLength is the shortest admin token production deployments accept
+const AdminTokenMinLength = 32
+
+// AdminTokenActor names the operator of ADMIN_TOKEN in the admin audit log
+const AdminTokenActor = "admin"
+
+// SecurityConfig controls which browser origins may call the API and how admin endpoints are
+// authenticated
+type SecurityConfig struct {
+	AllowedOrigins []string          // "*" allows any origin
+	AdminToken     string            // bearer token required by admin endpoints; empty disables admin authentication
+	AdminTokens    map[string]string // further admin tokens by operator name, recorded in the admin audit log
+	AdminNetworks  []*net.IPNet      // networks admin requests must come from; empty allows any
+}
+
+// AdminAuthEnabled reports whether admin endpoints require a token
+func (c SecurityConfig) AdminAuthEnabled() bool {
+	return c.AdminToken != "" || len(c.AdminTokens) > 0
+}
+
+// adminActor returns the operator an admin token belongs to
+func (c SecurityConfig) adminActor(token string) (string, bool) {
+	if token == "" {
+		return "", false
+	}
+	if c.AdminToken != "" && subtle.ConstantTimeCompare([]byte(token), []byte(c.AdminToken)) == 1 {
+		return AdminTokenActor, true
+	}
+	for actor, adminToken := range c.AdminTokens {
+		if subtle.ConstantTimeCompare([]byte(token), []byte(adminToken)) == 1 {
+			return actor, true
+		}
+	}
+	return "", false
+}
+
+// adminNetworkAllowed reports whether an admin request may come from a connection's remote
+// address. Connections without an IP address, on Unix sockets, are allowed: the socket's file
+// mode restricts them.
+func (c SecurityConfig) adminNetworkAllowed(remoteAddr string) bool {
+	if len(c.AdminNetworks) == 0 {
+		return true
+	}
+	host, _, err := net.SplitHostPort(remoteAddr)
+	if err != nil {
+		host = remoteAddr
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return true
+	}
+	for _, network := range c.AdminNetworks {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// ParseNetworks parses a comma-separated list of CIDR networks and IP addresses, an address
+// standing for itself alone
+func ParseNetworks(value string) ([]*net.IPNet, error) {
+	var networks []*net.IPNet
+	for _, entry := range strings.Split(value, ",") {
+		if entry = strings.TrimSpace(entry); entry == "" {
+			continue
+		}
+		if !strings.Contains(entry, "/") {
+			ip := net.ParseIP(entry)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid network %q", entry)
+			}
+			bits := 8 * net.IPv6len
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 8*net.IPv4len
+			}
+			networks = append(networks, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, network, err := net.ParseCIDR(entry)
+		if err != nil {
+			return nil, fmt.Errorf("invalid network %q: %w", entry, err)
+		}
+		networks = append(networks, network)
+	}
+	return networks, nil
+}
+
+// ParseAdminTokens parses a comma-separated list of operator:token pairs
+func ParseAdminTokens(value string) (map[string]string, error) {
+	tokens := map[string]string{}
+	for _, entry := range strings.Split(value, ",") {
+		if entry = strings.TrimSpace(entry); entry == "" {
+			continue
+		}
+		actor, token, ok := strings.Cut(entry, ":")
+		if actor, token = strings.TrimSpace(actor), strings.TrimSpace(token); !ok || actor == "" || token == "" {
+			return nil, fmt.Errorf("invalid admin token entry %q: expected operator:token", entry)
+		}
+		if _, ok := tokens[actor]; ok || actor == AdminTokenActor {
+			return nil, fmt.Errorf("duplicate admin token operator %q", actor)
+		}
+		tokens[actor] = token
+	}
+	return tokens, nil
+}
+
+// AllowsAnyOrigin reports whether CORS lets any origin call the API
+func (c SecurityConfig) AllowsAnyOrigin() bool {
+	for _, origin := range c.AllowedOrigins {
+		if origin == "*" {
+			return true
+		}
+	}
+	return false
+}
+
+// CheckProduction returns every setting that is only acceptable in development: the hardcoded
+// encryption key, the mock database, mock payment gateways, unauthenticated admin endpoints or
+// short admin tokens, and CORS allowing any origin
+func (c SecurityConfig) CheckProduction(mockDB bool, mockGateways []string) error {
+	var errs []error
+	if UsingDevelopmentKey() {
+		errs = append(errs, errors.New("ENCRYPTION_KEY must be set to a key other than the development key"))
+	}
+	if mockDB {
+		errs = append(errs, errors.New("the mock database cannot be used"))
+	}
+	if len(mockGateways) > 0 {
+		errs = append(errs, fmt.Errorf("mock payment gateways cannot be used: %s; configure their API keys", strings.Join(mockGateways, ", ")))
+	}
+	if !c.AdminAuthEnabled() {
+		errs = append(errs, errors.New("ADMIN_TOKEN or ADMIN_TOKENS must be set to authenticate admin endpoints"))
+	}
+	if c.AdminToken != "" && len(c.AdminToken) < AdminTokenMinLength {
+		errs = append(errs, fmt.Errorf("ADMIN_TOKEN must be at least %d characters", AdminTokenMinLength))
+	}
+	actors := make([]string, 0, len(c.AdminTokens))
+	for actor := range c.AdminTokens {
+		actors = append(actors, actor)
+	}
+	sort.Strings(actors)
+	for _, actor := range actors {
+		if len(c.AdminTokens[actor]) < AdminTokenMinLength {
+			errs = append(errs, fmt.Errorf("the admin token of %s must be at least %d characters", actor, AdminTokenMinLength))
+		}
+	}
+	if len(c.AllowedOrigins) == 0 || c.AllowsAnyOrigin() {
+		errs = append(errs, errors.New("CORS_ALLOWED_ORIGINS must list the allowed origins"))
+	}
+	return errors.Join(errs...)
+}
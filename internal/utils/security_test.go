@@ -0,0 +1,111 @@
+package utils
+
+import (
+	"strings"
+	"testing"
+)
+
+// TestCheckProduction tests that production deployments reject every development-only setting
+func TestCheckProduction(t *testing.T) {
+	defer func(previous bool) { developmentKey = previous }(developmentKey)
+
+	adminToken := strings.Repeat("k", AdminTokenMinLength)
+	secure := SecurityConfig{AllowedOrigins: []string{"https://dashboard.example.com"}, AdminToken: adminToken}
+
+	developmentKey = false
+	if err := secure.CheckProduction(false, nil); err != nil {
+		t.Errorf("Expected a secure configuration to pass, got: %v", err)
+	}
+
+	developmentKey = true
+	err := SecurityConfig{AllowedOrigins: []string{"*"}}.CheckProduction(true, []string{"2 (Stripe)"})
+	if err == nil {
+		t.Fatal("Expected an insecure configuration to fail")
+	}
+	for _, want := range []string{"ENCRYPTION_KEY", "mock database", "2 (Stripe)", "ADMIN_TOKEN", "CORS_ALLOWED_ORIGINS"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("Expected the error to mention %s, got: %v", want, err)
+		}
+	}
+
+	developmentKey = false
+	if err := (SecurityConfig{AdminToken: adminToken}).CheckProduction(false, nil); err == nil || !strings.Contains(err.Error(), "CORS_ALLOWED_ORIGINS") {
+		t.Errorf("Expected no allowed origins to fail, got: %v", err)
+	}
+
+	operators := SecurityConfig{AllowedOrigins: secure.AllowedOrigins, AdminTokens: map[string]string{"alice": adminToken, "ops-bot": "short"}}
+	if err := operators.CheckProduction(false, nil); err == nil || !strings.Contains(err.Error(), "ops-bot") || strings.Contains(err.Error(), "ADMIN_TOKEN ") {
+		t.Errorf("Expected only the short operator token to fail, got: %v", err)
+	}
+	if err := (SecurityConfig{AllowedOrigins: secure.AllowedOrigins, AdminToken: "admin-token"}).CheckProduction(false, nil); err == nil || !strings.Contains(err.Error(), "ADMIN_TOKEN must be at least") {
+		t.Errorf("Expected a short admin token to fail, got: %v", err)
+	}
+}
+
+// TestParseAdminTokens tests that operator tokens are parsed and malformed or duplicate entries refused
+func TestParseAdminTokens(t *testing.T) {
+	tokens, err := ParseAdminTokens(" alice:token-a, ops-bot:token-b ,")
+	if err != nil || len(tokens) != 2 || tokens["alice"] != "token-a" || tokens["ops-bot"] != "token-b" {
+		t.Errorf("Expected both operators, got %v: %v", tokens, err)
+	}
+	for _, value := range []string{"token-a", "alice:", "alice:a,alice:b", AdminTokenActor + ":token"} {
+		if _, err := ParseAdminTokens(value); err == nil {
+			t.Errorf("Expected %q refused", value)
+		}
+	}
+}
+
+// TestParseNetworks tests that CIDR networks and single addresses are parsed
+func TestParseNetworks(t *testing.T) {
+	networks, err := ParseNetworks("10.0.0.0/8, 192.0.2.7, ::1")
+	if err != nil || len(networks) != 3 {
+		t.Fatalf("Expected 3 networks, got %v: %v", networks, err)
+	}
+	config := SecurityConfig{AdminNetworks: networks}
+	for addr, want := range map[string]bool{
+		"10.1.2.3:4000":   true,
+		"192.0.2.7:4000":  true,
+		"192.0.2.8:4000":  false,
+		"[::1]:4000":      true,
+		"[2001:db8::1]:1": false,
+		"@":               true, // Unix socket
+	} {
+		if got := config.adminNetworkAllowed(addr); got != want {
+			t.Errorf("%s: expected allowed %t, got %t", addr, want, got)
+		}
+	}
+	if _, err := ParseNetworks("10.0.0.0/33"); err == nil {
+		t.Error("Expected an invalid network refused")
+	}
+}
+
+// TestMaskBody tests that sensitive fields and emails are masked in logged bodies
+func TestMaskBody(t *testing.T) {
+	tests := []struct {
+		name        string
+		contentType string
+		body        string
+		want        string
+	}{
+		{
+			"JSON",
+			"application/json; charset=utf-8",
+			`{"amount":100.50,"beneficiary":"DE89370400440532013000","user":{"email":"jane@example.com","phone":447911123456},"items":[{"secret":"s3cr3t"}]}`,
+			`{"amount":100.50,"beneficiary":"***","items":[{"secret":"***"}],"user":{"email":"j***@example.com","phone":"***"}}`,
+		},
+		{"compliance fields", "application/json", `{"compliance_fields":{"cpf":"12345678909"}}`, `{"compliance_fields":{"cpf":"***"}}`},
+		{"payment token", "application/json", `{"payment_token":{"type":"apple_pay","data":"{}"}}`, `{"payment_token":{"data":"***","type":"***"}}`},
+		{"form", "application/x-www-form-urlencoded", "client_secret=abc&grant_type=client_credentials", "client_secret=%2A%2A%2A&grant_type=client_credentials"},
+		{"email search query", "application/x-www-form-urlencoded", "q=jane@example.com", "q=j%2A%2A%2A%40example.com"},
+		{"XML", "application/xml", "<deposit><beneficiary>x</beneficiary></deposit>", "[47 bytes of application/xml]"},
+		{"invalid JSON", "application/json", `{"password":`, "[12 bytes of application/json]"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := MaskBody(tt.contentType, []byte(tt.body)); got != tt.want {
+				t.Errorf("Expected %s, got %s", tt.want, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,205 @@
+package validation
+
+import (
+	"math"
+	"net/mail"
+	"payment-gateway/internal/geo"
+	"reflect"
+	"strings"
+)
+
+// maxAmountDecimals is the largest number of minor-unit digits of any ISO 4217 currency
+const maxAmountDecimals = 3
+
+// currencies are the active ISO 4217 currency codes
+var currencies = map[string]bool{}
+
+func init() {
+	for _, code := range []string{
+		"AED", "AFN", "ALL", "AMD", "ANG", "AOA", "ARS", "AUD", "AWG", "AZN",
+		"BAM", "BBD", "BDT", "BGN", "BHD", "BIF", "BMD", "BND", "BOB", "BRL",
+		"BSD", "BTN", "BWP", "BYN", "BZD", "CAD", "CDF", "CHF", "CLP", "CNY",
+		"COP", "CRC", "CUP", "CVE", "CZK", "DJF", "DKK", "DOP", "DZD", "EGP",
+		"ERN", "ETB", "EUR", "FJD", "FKP", "GBP", "GEL", "GHS", "GIP", "GMD",
+		"GNF", "GTQ", "GYD", "HKD", "HNL", "HTG", "HUF", "IDR", "ILS", "INR",
+		"IQD", "IRR", "ISK", "JMD", "JOD", "JPY", "KES", "KGS", "KHR", "KMF",
+		"KPW", "KRW", "KWD", "KYD", "KZT", "LAK", "LBP", "LKR", "LRD", "LSL",
+		"LYD", "MAD", "MDL", "MGA", "MKD", "MMK", "MNT", "MOP", "MRU", "MUR",
+		"MVR", "MWK", "MXN", "MYR", "MZN", "NAD", "NGN", "NIO", "NOK", "NPR",
+		"NZD", "OMR", "PAB", "PEN", "PGK", "PHP", "PKR", "PLN", "PYG", "QAR",
+		"RON", "RSD", "RUB", "RWF", "SAR", "SBD", "SCR", "SDG", "SEK", "SGD",
+		"SHP", "SLE", "SOS", "SRD", "SSP", "STN", "SVC", "SYP", "SZL", "THB",
+		"TJS", "TMT", "TND", "TOP", "TRY", "TTD", "TWD", "TZS", "UAH", "UGX",
+		"USD", "UYU", "UZS", "VES", "VND", "VUV", "WST", "XAF", "XCD", "XOF",
+		"XPF", "YER", "ZAR", "ZMW", "ZWL",
+	} {
+		currencies[code] = true
+	}
+}
+
+// IsCurrency reports whether code is an active ISO 4217 currency code
+func IsCurrency(code string) bool {
+	return currencies[code]
+}
+
+// isCurrency validates an ISO 4217 currency code; codes must be upper case
+func isCurrency(fv reflect.Value, _ string) bool {
+	return fv.Kind() == reflect.String && IsCurrency(fv.String())
+}
+
+// isCountry validates an ISO 3166-1 alpha-2 country code in either case
+func isCountry(fv reflect.Value, _ string) bool {
+	return fv.Kind() == reflect.String && geo.IsValidCountryCode(fv.String())
+}
+
+// isBIN validates a card BIN
+func isBIN(fv reflect.Value, _ string) bool {
+	return fv.Kind() == reflect.String && geo.IsValidBIN(fv.String())
+}
+
+// isPhone validates a phone number in international or national form; the country-aware check
+// is made when the number is normalized with geo.NormalizePhoneNumber
+func isPhone(fv reflect.Value, _ string) bool {
+	return fv.Kind() == reflect.String && geo.IsPhoneNumber(fv.String())
+}
+
+// isPostalCode validates the general shape of a postal code; the country-aware check is made
+// when the code is normalized with geo.NormalizePostalCode
+func isPostalCode(fv reflect.Value, _ string) bool {
+	return fv.Kind() == reflect.String && geo.IsPostalCode(fv.String())
+}
+
+// isEmail validates a bare email address, without a display name
+func isEmail(fv reflect.Value, _ string) bool {
+	if fv.Kind() != reflect.String {
+		return false
+	}
+	address, err := mail.ParseAddress(fv.String())
+	return err == nil && address.Address == fv.String()
+}
+
+// IsIBAN reports whether s is an IBAN with valid check digits, ignoring spaces and case
+func IsIBAN(s string) bool {
+	iban := strings.ToUpper(strings.ReplaceAll(s, " ", ""))
+	if len(iban) < 15 || len(iban) > 34 || !geo.IsValidCountryCode(iban[:2]) {
+		return false
+	}
+
+	// Moving the first four characters to the end and letters to 10-35 leaves a number that is 1
+	// modulo 97 (ISO 13616)
+	remainder := 0
+	for _, c := range iban[4:] + iban[:4] {
+		switch {
+		case c >= '0' && c <= '9':
+			remainder = (remainder*10 + int(c-'0')) % 97
+		case c >= 'A' && c <= 'Z':
+			remainder = (remainder*100 + int(c-'A') + 10) % 97
+		default:
+			return false
+		}
+	}
+	return remainder == 1
+}
+
+// isIBAN validates an IBAN
+func isIBAN(fv reflect.Value, _ string) bool {
+	return fv.Kind() == reflect.String && IsIBAN(fv.String())
+}
+
+// isBIC validates a BIC: a four-letter bank code, a country code and a location code, optionally
+// followed by a branch code
+func isBIC(fv reflect.Value, _ string) bool {
+	if fv.Kind() != reflect.String {
+		return false
+	}
+	bic := fv.String()
+	if len(bic) != 8 && len(bic) != 11 {
+		return false
+	}
+	for i, c := range bic {
+		letter := c >= 'A' && c <= 'Z'
+		if !letter && (i < 6 || c < '0' || c > '9') {
+			return false
+		}
+	}
+	return geo.IsValidCountryCode(bic[4:6])
+}
+
+// IsVPA reports whether s is a UPI virtual payment address: a handle of 2 to 256 letters, digits,
+// dots, dashes and underscores starting with a letter or digit, "@" and the payment service
+// provider's handle of 2 to 64 letters and digits starting with a letter, e.g. "alice.99@okbank"
+func IsVPA(s string) bool {
+	handle, provider, ok := strings.Cut(s, "@")
+	if !ok || len(handle) < 2 || len(handle) > 256 || len(provider) < 2 || len(provider) > 64 {
+		return false
+	}
+	for i, c := range handle {
+		alphanumeric := (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9')
+		if !alphanumeric && (i == 0 || (c != '.' && c != '-' && c != '_')) {
+			return false
+		}
+	}
+	for i, c := range provider {
+		letter := (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+		if !letter && (i == 0 || c < '0' || c > '9') {
+			return false
+		}
+	}
+	return true
+}
+
+// isVPA validates a UPI virtual payment address
+func isVPA(fv reflect.Value, _ string) bool {
+	return fv.Kind() == reflect.String && IsVPA(fv.String())
+}
+
+// maxTagLength is the longest transaction tag, key and value included
+const maxTagLength = 64
+
+// IsTag reports whether s is a transaction tag: a label of letters, digits, dots, dashes and
+// underscores starting with a letter or digit, optionally followed by ":" and a value of the same
+// characters, e.g. "campaign:blackfriday". Tags are compared in lower case.
+func IsTag(s string) bool {
+	if len(s) > maxTagLength {
+		return false
+	}
+	key, value, hasValue := strings.Cut(s, ":")
+	if !isTagPart(key) {
+		return false
+	}
+	return !hasValue || isTagPart(value)
+}
+
+// isTagPart reports whether s is the key or value of a tag
+func isTagPart(s string) bool {
+	if s == "" {
+		return false
+	}
+	for i, c := range s {
+		alphanumeric := (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9')
+		if !alphanumeric && (i == 0 || (c != '.' && c != '-' && c != '_')) {
+			return false
+		}
+	}
+	return true
+}
+
+// isTag validates a transaction tag
+func isTag(fv reflect.Value, _ string) bool {
+	return fv.Kind() == reflect.String && IsTag(fv.String())
+}
+
+// isAmount validates a positive, finite amount that can be expressed in minor units
+func isAmount(fv reflect.Value, _ string) bool {
+	if fv.Kind() != reflect.Float64 && fv.Kind() != reflect.Float32 {
+		return false
+	}
+
+	amount := fv.Float()
+	if math.IsNaN(amount) || math.IsInf(amount, 0) || amount <= 0 {
+		return false
+	}
+
+	scaled := amount * math.Pow10(maxAmountDecimals)
+	return math.Abs(scaled-math.Round(scaled)) < 1e-9*math.Max(1, scaled)
+}
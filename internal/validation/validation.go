@@ -0,0 +1,297 @@
+// Package validation checks request models against rules declared in `validate` struct tags,
+// e.g. `validate:"required,currency"`, and reports every failing field at once.
+//
+// Rules are separated by commas and run in order; a rule's parameter follows "=". The built-in
+// rules are:
+//
+//	required          the field is not its zero value (non-empty for strings and slices)
+//	omitempty         skip the remaining rules when the field is its zero value
+//	gt, gte, lt, lte  compare numbers by value and strings and slices by length
+//	min, max          aliases of gte and lte
+//	len               exact length of a string or slice
+//	oneof             the value is one of the space-separated parameters
+//	dive              apply the remaining rules to each element of a slice
+//	currency          an ISO 4217 currency code
+//	country           an ISO 3166-1 alpha-2 country code
+//	amount            a positive, finite amount with at most 3 decimal places
+//	bin               a card BIN of 6 to 8 digits
+//	phone             a phone number in international (E.164) or national form
+//	postal_code       a postal code of 3 to 10 letters, digits, spaces and dashes
+//	email             an email address, without a display name
+//	vpa               a UPI virtual payment address, e.g. "name@bank"
+//	tag               a transaction tag, e.g. "campaign:blackfriday"
+//
+// Nested structs are validated recursively, and the elements of slices tagged with dive.
+// Fields are reported by their json names, e.g. "transactions[1].amount".
+package validation
+
+import (
+	"fmt"
+	"payment-gateway/internal/models"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Errors lists the fields of a request that failed validation
+type Errors []models.FieldError
+
+// Error joins the field errors, e.g. "amount must be greater than 0; currency is required"
+func (e Errors) Error() string {
+	messages := make([]string, len(e))
+	for i, fieldErr := range e {
+		messages[i] = fieldErr.Field + " " + fieldErr.Message
+	}
+	return strings.Join(messages, "; ")
+}
+
+// Func reports whether a field value satisfies a rule with the given parameter
+type Func func(v reflect.Value, param string) bool
+
+// rule is a registered validation rule
+type rule struct {
+	check   Func
+	message string // may contain one %s for the parameter
+}
+
+// fieldRules are the parsed rules of one struct field
+type fieldRules struct {
+	index int
+	name  string
+	rules []parsedRule
+}
+
+// parsedRule is a rule named in a tag with its parameter
+type parsedRule struct {
+	name  string
+	param string
+}
+
+// Validator validates structs against their `validate` tags
+type Validator struct {
+	mu    sync.RWMutex
+	rules map[string]rule
+	cache sync.Map // reflect.Type -> []fieldRules
+}
+
+// New creates a validator with the built-in rules
+func New() *Validator {
+	v := &Validator{rules: make(map[string]rule)}
+
+	v.Register("required", func(fv reflect.Value, _ string) bool { return !fv.IsZero() }, "is required")
+	v.Register("gt", compare(func(a, b float64) bool { return a > b }), "must be greater than %s")
+	v.Register("gte", compare(func(a, b float64) bool { return a >= b }), "must be at least %s")
+	v.Register("lt", compare(func(a, b float64) bool { return a < b }), "must be less than %s")
+	v.Register("lte", compare(func(a, b float64) bool { return a <= b }), "must be at most %s")
+	v.Register("min", compare(func(a, b float64) bool { return a >= b }), "must be at least %s")
+	v.Register("max", compare(func(a, b float64) bool { return a <= b }), "must be at most %s")
+	v.Register("len", compare(func(a, b float64) bool { return a == b }), "must have length %s")
+	v.Register("oneof", isOneOf, "must be one of: %s")
+	v.Register("currency", isCurrency, "must be an ISO 4217 currency code")
+	v.Register("country", isCountry, "must be an ISO 3166-1 alpha-2 country code")
+	v.Register("amount", isAmount, "must be a positive amount with at most 3 decimal places")
+	v.Register("bin", isBIN, "must be 6 to 8 digits")
+	v.Register("phone", isPhone, "must be a phone number")
+	v.Register("postal_code", isPostalCode, "must be a postal code")
+	v.Register("email", isEmail, "must be an email address")
+	v.Register("iban", isIBAN, "must be an IBAN")
+	v.Register("bic", isBIC, "must be a BIC")
+	v.Register("vpa", isVPA, "must be a UPI virtual payment address")
+	v.Register("tag", isTag, "must be a tag of letters, digits, dots, dashes and underscores, optionally key:value")
+
+	return v
+}
+
+// Register adds or replaces a rule. The message describes a failing field and may contain one
+// %s, replaced by the rule's parameter.
+func (v *Validator) Register(name string, check Func, message string) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.rules[name] = rule{check: check, message: message}
+}
+
+// Struct validates a struct or pointer to a struct, returning Errors when any field is invalid
+func (v *Validator) Struct(s interface{}) error {
+	rv := reflect.Indirect(reflect.ValueOf(s))
+	if rv.Kind() != reflect.Struct {
+		return fmt.Errorf("validation: %T is not a struct", s)
+	}
+
+	var errs Errors
+	if err := v.validateStruct(rv, "", &errs); err != nil {
+		return err
+	}
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+// validateStruct validates every tagged field of a struct, appending failures to errs
+func (v *Validator) validateStruct(rv reflect.Value, prefix string, errs *Errors) error {
+	fields, err := v.fieldsOf(rv.Type())
+	if err != nil {
+		return err
+	}
+
+	for _, field := range fields {
+		if err := v.validateValue(rv.Field(field.index), prefix+field.name, field.rules, errs); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// validateValue applies rules to a value and descends into nested structs
+func (v *Validator) validateValue(fv reflect.Value, path string, rules []parsedRule, errs *Errors) error {
+	for i, r := range rules {
+		switch r.name {
+		case "omitempty":
+			if fv.IsZero() {
+				return nil
+			}
+			continue
+		case "dive":
+			if fv.Kind() != reflect.Slice && fv.Kind() != reflect.Array {
+				return fmt.Errorf("validation: dive on non-slice field %s", path)
+			}
+			for j := 0; j < fv.Len(); j++ {
+				if err := v.validateValue(fv.Index(j), fmt.Sprintf("%s[%d]", path, j), rules[i+1:], errs); err != nil {
+					return err
+				}
+			}
+			return nil
+		}
+
+		v.mu.RLock()
+		registered, ok := v.rules[r.name]
+		v.mu.RUnlock()
+		if !ok {
+			return fmt.Errorf("validation: unknown rule %q on field %s", r.name, path)
+		}
+
+		if !registered.check(fv, r.param) {
+			message := registered.message
+			if strings.Contains(message, "%s") {
+				message = fmt.Sprintf(message, strings.ReplaceAll(r.param, " ", ", "))
+			}
+			*errs = append(*errs, models.FieldError{Field: path, Rule: r.name, Param: r.param, Message: message})
+			// Later rules usually assume earlier ones passed, e.g. required before currency
+			return nil
+		}
+	}
+
+	if indirect := reflect.Indirect(fv); indirect.Kind() == reflect.Struct {
+		return v.validateStruct(indirect, path+".", errs)
+	}
+	return nil
+}
+
+// fieldsOf returns the parsed rules of a struct type's exported fields, cached per type
+func (v *Validator) fieldsOf(t reflect.Type) ([]fieldRules, error) {
+	if cached, ok := v.cache.Load(t); ok {
+		return cached.([]fieldRules), nil
+	}
+
+	var fields []fieldRules
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if !field.IsExported() {
+			continue
+		}
+
+		tag := field.Tag.Get("validate")
+		if tag == "-" {
+			continue
+		}
+
+		name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+		if name == "" || name == "-" {
+			name = field.Name
+		}
+
+		var rules []parsedRule
+		for _, entry := range strings.Split(tag, ",") {
+			if entry = strings.TrimSpace(entry); entry == "" {
+				continue
+			}
+			ruleName, param, _ := strings.Cut(entry, "=")
+			rules = append(rules, parsedRule{name: ruleName, param: param})
+		}
+
+		// Untagged fields are still descended into when they hold structs
+		if len(rules) == 0 && !holdsStructs(field.Type) {
+			continue
+		}
+		fields = append(fields, fieldRules{index: i, name: name, rules: rules})
+	}
+
+	v.cache.Store(t, fields)
+	return fields, nil
+}
+
+// holdsStructs reports whether a field type is a struct or pointer to struct
+func holdsStructs(t reflect.Type) bool {
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	return t.Kind() == reflect.Struct
+}
+
+// compare builds a rule comparing numbers by value and strings, slices and maps by length
+func compare(ok func(a, b float64) bool) Func {
+	return func(fv reflect.Value, param string) bool {
+		limit, err := strconv.ParseFloat(param, 64)
+		if err != nil {
+			return false
+		}
+
+		switch fv.Kind() {
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			return ok(float64(fv.Int()), limit)
+		case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+			return ok(float64(fv.Uint()), limit)
+		case reflect.Float32, reflect.Float64:
+			return ok(fv.Float(), limit)
+		case reflect.String:
+			return ok(float64(len([]rune(fv.String()))), limit)
+		case reflect.Slice, reflect.Array, reflect.Map:
+			return ok(float64(fv.Len()), limit)
+		}
+		return false
+	}
+}
+
+// isOneOf reports whether a string or integer is one of the space-separated parameters
+func isOneOf(fv reflect.Value, param string) bool {
+	var value string
+	switch fv.Kind() {
+	case reflect.String:
+		value = fv.String()
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		value = strconv.FormatInt(fv.Int(), 10)
+	default:
+		return false
+	}
+
+	for _, allowed := range strings.Fields(param) {
+		if value == allowed {
+			return true
+		}
+	}
+	return false
+}
+
+// Default is the validator used by the package-level functions
+var Default = New()
+
+// Struct validates a struct with the default validator
+func Struct(s interface{}) error {
+	return Default.Struct(s)
+}
+
+// Register adds a rule to the default validator
+func Register(name string, check Func, message string) {
+	Default.Register(name, check, message)
+}
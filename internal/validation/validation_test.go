@@ -0,0 +1,189 @@
+package validation
+
+import (
+	"errors"
+	"payment-gateway/internal/models"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// failedFields returns the failing fields of a validation error
+func failedFields(t *testing.T, err error) []string {
+	t.Helper()
+
+	var errs Errors
+	if !errors.As(err, &errs) {
+		t.Fatalf("Expected validation.Errors, got: %v", err)
+	}
+
+	fields := make([]string, len(errs))
+	for i, fieldErr := range errs {
+		fields[i] = fieldErr.Field + ":" + fieldErr.Rule
+	}
+	return fields
+}
+
+// TestTransactionRequest tests the rules declared on transaction requests
+func TestTransactionRequest(t *testing.T) {
+	valid := models.TransactionRequest{UserID: 1, Amount: 10.25, Currency: "USD", CountryCode: "de", CardBIN: "424242"}
+	if err := Struct(valid); err != nil {
+		t.Fatalf("Expected valid request to pass, got: %v", err)
+	}
+
+	tests := []struct {
+		name   string
+		modify func(*models.TransactionRequest)
+		want   []string
+	}{
+		{"zero amount", func(r *models.TransactionRequest) { r.Amount = 0 }, []string{"amount:amount"}},
+		{"sub-mil amount", func(r *models.TransactionRequest) { r.Amount = 0.0015 }, []string{"amount:amount"}},
+		{"missing currency", func(r *models.TransactionRequest) { r.Currency = "" }, []string{"currency:required"}},
+		{"unknown currency", func(r *models.TransactionRequest) { r.Currency = "XYZ" }, []string{"currency:currency"}},
+		{"bad country and BIN", func(r *models.TransactionRequest) { r.CountryCode = "ZZZ"; r.CardBIN = "12ab" }, []string{"country_code:country", "card_bin:bin"}},
+		{"excluded gateway", func(r *models.TransactionRequest) { r.ExcludedGatewayIDs = []int{2, 0} }, []string{"excluded_gateway_ids[1]:gt"}},
+		{"everything", func(r *models.TransactionRequest) { *r = models.TransactionRequest{} }, []string{"user_id:gt", "amount:amount", "currency:required"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := valid
+			tt.modify(&req)
+
+			if got := failedFields(t, Struct(req)); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Expected %v, got %v", tt.want, got)
+			}
+		})
+	}
+}
+
+// TestNestedAndCustomRules tests nested paths, parameter messages and registered rules
+func TestNestedAndCustomRules(t *testing.T) {
+	type item struct {
+		Kind string `json:"kind" validate:"oneof=card bank"`
+	}
+	type request struct {
+		Items  []item `json:"items" validate:"required,dive"`
+		Code   string `json:"code" validate:"even"`
+		Nested struct {
+			Name string `json:"name" validate:"max=3"`
+		} `json:"nested"`
+	}
+
+	v := New()
+	v.Register("even", func(fv reflect.Value, _ string) bool { return len(fv.String())%2 == 0 }, "must have an even length")
+
+	err := v.Struct(&request{Items: []item{{Kind: "card"}, {Kind: "cash"}}, Code: "abc", Nested: struct {
+		Name string `json:"name" validate:"max=3"`
+	}{Name: "long"}})
+
+	want := []string{"items[1].kind:oneof", "code:even", "nested.name:max"}
+	if got := failedFields(t, err); !reflect.DeepEqual(got, want) {
+		t.Errorf("Expected %v, got %v", want, got)
+	}
+	if !strings.Contains(err.Error(), "items[1].kind must be one of: card, bank") {
+		t.Errorf("Expected the parameter in the message, got: %v", err)
+	}
+
+	if err := v.Struct(struct {
+		A string `validate:"nosuchrule"`
+	}{}); err == nil || errors.As(err, new(Errors)) {
+		t.Errorf("Expected an error for an unknown rule, got: %v", err)
+	}
+}
+
+// TestMandateRequest tests the IBAN and BIC rules of mandate requests
+func TestMandateRequest(t *testing.T) {
+	valid := models.MandateRequest{UserID: 1, DebtorName: "Jane Doe", IBAN: "DE89 3704 0044 0532 0130 00", BIC: "COBADEFFXXX"}
+	if err := Struct(valid); err != nil {
+		t.Fatalf("Expected valid request to pass, got: %v", err)
+	}
+
+	tests := []struct {
+		name   string
+		modify func(*models.MandateRequest)
+		want   []string
+	}{
+		{"wrong check digits", func(r *models.MandateRequest) { r.IBAN = "DE88370400440532013000" }, []string{"iban:iban"}},
+		{"not an IBAN", func(r *models.MandateRequest) { r.IBAN = "12345678" }, []string{"iban:iban"}},
+		{"short BIC", func(r *models.MandateRequest) { r.BIC = "COBADE" }, []string{"bic:bic"}},
+		{"digits in the bank code", func(r *models.MandateRequest) { r.BIC = "C0BADEFF" }, []string{"bic:bic"}},
+		{"no debtor", func(r *models.MandateRequest) { r.DebtorName = "" }, []string{"debtor_name:required"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := valid
+			tt.modify(&req)
+
+			if got := failedFields(t, Struct(req)); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Expected %v, got %v", tt.want, got)
+			}
+		})
+	}
+}
+
+// TestVPAValidationRequest tests the VPA rule of VPA validation requests
+func TestVPAValidationRequest(t *testing.T) {
+	valid := models.VPAValidationRequest{UserID: 1, VPA: "alice.99@okbank"}
+	if err := Struct(valid); err != nil {
+		t.Fatalf("Expected valid request to pass, got: %v", err)
+	}
+
+	tests := []struct {
+		name   string
+		modify func(*models.VPAValidationRequest)
+		want   []string
+	}{
+		{"no VPA", func(r *models.VPAValidationRequest) { r.VPA = "" }, []string{"vpa:required"}},
+		{"no provider handle", func(r *models.VPAValidationRequest) { r.VPA = "alice" }, []string{"vpa:vpa"}},
+		{"email address", func(r *models.VPAValidationRequest) { r.VPA = "alice@okbank.com" }, []string{"vpa:vpa"}},
+		{"handle starting with a dot", func(r *models.VPAValidationRequest) { r.VPA = ".alice@okbank" }, []string{"vpa:vpa"}},
+		{"provider handle starting with a digit", func(r *models.VPAValidationRequest) { r.VPA = "alice@1bank" }, []string{"vpa:vpa"}},
+		{"negative gateway", func(r *models.VPAValidationRequest) { r.GatewayID = -1 }, []string{"gateway_id:gte"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := valid
+			tt.modify(&req)
+
+			if got := failedFields(t, Struct(req)); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Expected %v, got %v", tt.want, got)
+			}
+		})
+	}
+}
+
+// TestTransactionTagsRequest tests the tag rule of transaction tag requests
+func TestTransactionTagsRequest(t *testing.T) {
+	valid := models.TransactionTagsRequest{Tags: []string{"campaign:blackfriday", "VIP", "promo:2026-q4_eu.web"}}
+	if err := Struct(valid); err != nil {
+		t.Fatalf("Expected valid request to pass, got: %v", err)
+	}
+
+	tests := []struct {
+		name   string
+		modify func(*models.TransactionTagsRequest)
+		want   []string
+	}{
+		{"no tags", func(r *models.TransactionTagsRequest) { r.Tags = nil }, []string{"tags:required"}},
+		{"empty value", func(r *models.TransactionTagsRequest) { r.Tags = []string{"campaign:"} }, []string{"tags[0]:tag"}},
+		{"two colons", func(r *models.TransactionTagsRequest) { r.Tags = []string{"vip", "a:b:c"} }, []string{"tags[1]:tag"}},
+		{"space", func(r *models.TransactionTagsRequest) { r.Tags = []string{"black friday"} }, []string{"tags[0]:tag"}},
+		{"starting with a dash", func(r *models.TransactionTagsRequest) { r.Tags = []string{"-vip"} }, []string{"tags[0]:tag"}},
+		{"too long", func(r *models.TransactionTagsRequest) { r.Tags = []string{"campaign:" + strings.Repeat("a", 56)} }, []string{"tags[0]:tag"}},
+		{"too many", func(r *models.TransactionTagsRequest) { r.Tags = make([]string, 21) }, []string{"tags:max"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := valid
+			tt.modify(&req)
+
+			if got := failedFields(t, Struct(req)); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Expected %v, got %v", tt.want, got)
+			}
+		})
+	}
+}
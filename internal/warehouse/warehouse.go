@@ -0,0 +1,283 @@
+// Package warehouse writes completed transactions to a data warehouse as date-partitioned CSV
+// files. Each data file is followed by a manifest listing the outbox messages it contains;
+// readers load only files that have a manifest, so a file written before a crash is never
+// read twice or half-written.
+//
+// Layout under the store root:
+//
+//	transactions/dt=2024-03-09/part-00000000123-00000000456.csv
+//	_manifests/dt=2024-03-09/part-00000000123-00000000456.json
+package warehouse
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"payment-gateway/internal/models"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Key prefixes of data files and manifests
+const (
+	DataPrefix     = "transactions/"
+	ManifestPrefix = "_manifests/"
+)
+
+var ErrNotFound = errors.New("warehouse object not found")
+
+// Store holds warehouse objects by key. Put must replace an object atomically, so readers
+// never see a partial file.
+type Store interface {
+	Put(ctx context.Context, key string, data []byte) error
+	Get(ctx context.Context, key string) ([]byte, error)
+	List(ctx context.Context, prefix string) ([]string, error)
+}
+
+// Columns are the CSV columns written for each transaction. User details are left out; rows
+// are joined to users by user_id inside the warehouse where access is controlled.
+var Columns = []string{
+	"transaction_id", "reference_id", "gateway_reference", "type", "status", "amount", "currency",
+	"user_id", "gateway_id", "country_id", "created_at", "completed_at",
+}
+
+// Row is a completed transaction to export, identified by the outbox message that recorded it
+type Row struct {
+	MessageID   int
+	Transaction models.Transaction
+	CompletedAt time.Time
+}
+
+// Manifest records a committed data file and the outbox messages it holds
+type Manifest struct {
+	File       string    `json:"file"`
+	Date       string    `json:"date"`
+	Rows       int       `json:"rows"`
+	MessageIDs []int     `json:"message_ids"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+// Partition returns the date partition a row is written to, by completion time in UTC
+func Partition(completedAt time.Time) string {
+	return completedAt.UTC().Format("2006-01-02")
+}
+
+// PartName returns the name shared by the data file and manifest holding the given messages,
+// derived from their IDs so writing the same rows again replaces the same objects
+func PartName(messageIDs []int) string {
+	lo, hi := messageIDs[0], messageIDs[0]
+	for _, id := range messageIDs {
+		if id < lo {
+			lo = id
+		}
+		if id > hi {
+			hi = id
+		}
+	}
+	return fmt.Sprintf("part-%011d-%011d", lo, hi)
+}
+
+// DataKey returns the key of a data file
+func DataKey(date, part string) string {
+	return DataPrefix + "dt=" + date + "/" + part + ".csv"
+}
+
+// ManifestKey returns the key of a data file's manifest
+func ManifestKey(date, part string) string {
+	return ManifestPrefix + "dt=" + date + "/" + part + ".json"
+}
+
+// EncodeCSV writes rows as CSV with a header line
+func EncodeCSV(rows []Row) ([]byte, error) {
+	var buf bytes.Buffer
+	w := csv.NewWriter(&buf)
+
+	if err := w.Write(Columns); err != nil {
+		return nil, err
+	}
+	for _, row := range rows {
+		tx := row.Transaction
+		record := []string{
+			strconv.Itoa(tx.ID),
+			tx.ReferenceID,
+			tx.GatewayReference,
+			tx.Type,
+			tx.Status,
+			strconv.FormatFloat(tx.Amount, 'f', -1, 64),
+			tx.Currency,
+			strconv.Itoa(tx.UserID),
+			strconv.Itoa(tx.GatewayID),
+			strconv.Itoa(tx.CountryID),
+			formatTime(tx.CreatedAt),
+			formatTime(row.CompletedAt),
+		}
+		if err := w.Write(record); err != nil {
+			return nil, err
+		}
+	}
+
+	w.Flush()
+	return buf.Bytes(), w.Error()
+}
+
+// Commit writes rows of one date partition as a data file followed by its manifest
+func Commit(ctx context.Context, store Store, date string, rows []Row) (*Manifest, error) {
+	ids := make([]int, len(rows))
+	for i, row := range rows {
+		ids[i] = row.MessageID
+	}
+	sort.Ints(ids)
+	part := PartName(ids)
+
+	data, err := EncodeCSV(rows)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode warehouse rows: %w", err)
+	}
+	if err := store.Put(ctx, DataKey(date, part), data); err != nil {
+		return nil, fmt.Errorf("failed to write warehouse file: %w", err)
+	}
+
+	manifest := &Manifest{File: DataKey(date, part), Date: date, Rows: len(rows), MessageIDs: ids, CreatedAt: time.Now().UTC()}
+	body, err := json.Marshal(manifest)
+	if err != nil {
+		return nil, err
+	}
+	if err := store.Put(ctx, ManifestKey(date, part), body); err != nil {
+		return nil, fmt.Errorf("failed to write warehouse manifest: %w", err)
+	}
+
+	return manifest, nil
+}
+
+// CommittedMessages returns the IDs of the outbox messages already committed to a date partition
+func CommittedMessages(ctx context.Context, store Store, date string) (map[int]bool, error) {
+	keys, err := store.List(ctx, ManifestPrefix+"dt="+date+"/")
+	if err != nil {
+		return nil, fmt.Errorf("failed to list warehouse manifests: %w", err)
+	}
+
+	committed := make(map[int]bool)
+	for _, key := range keys {
+		body, err := store.Get(ctx, key)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read warehouse manifest %s: %w", key, err)
+		}
+		var manifest Manifest
+		if err := json.Unmarshal(body, &manifest); err != nil {
+			return nil, fmt.Errorf("invalid warehouse manifest %s: %w", key, err)
+		}
+		for _, id := range manifest.MessageIDs {
+			committed[id] = true
+		}
+	}
+
+	return committed, nil
+}
+
+// formatTime formats a time as RFC 3339 in UTC, leaving zero times empty
+func formatTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.UTC().Format(time.RFC3339)
+}
+
+// DirStore keeps warehouse objects as files under a directory, such as a mounted bucket or a
+// directory synced to object storage
+type DirStore struct {
+	root string
+}
+
+// NewDirStore creates a store rooted at dir, creating it if needed
+func NewDirStore(dir string) (*DirStore, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create warehouse directory: %w", err)
+	}
+	return &DirStore{root: dir}, nil
+}
+
+// Put writes an object to a temporary file and renames it into place
+func (s *DirStore) Put(ctx context.Context, key string, data []byte) error {
+	name, err := s.path(key)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(name), 0o755); err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(name), ".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), name)
+}
+
+// Get reads an object
+func (s *DirStore) Get(ctx context.Context, key string) ([]byte, error) {
+	name, err := s.path(key)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := os.ReadFile(name)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, ErrNotFound
+	}
+	return data, err
+}
+
+// List returns the keys of the objects in the directory named by prefix, which must end in "/"
+func (s *DirStore) List(ctx context.Context, prefix string) ([]string, error) {
+	name, err := s.path(prefix)
+	if err != nil {
+		return nil, err
+	}
+
+	entries, err := os.ReadDir(name)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var keys []string
+	for _, entry := range entries {
+		if entry.IsDir() || strings.HasPrefix(entry.Name(), ".tmp-") {
+			continue
+		}
+		keys = append(keys, prefix+entry.Name())
+	}
+	return keys, nil
+}
+
+// path maps a key to a file under the root, rejecting keys that would escape it
+func (s *DirStore) path(key string) (string, error) {
+	clean := path.Clean("/" + key)
+	if clean != "/"+strings.TrimSuffix(key, "/") {
+		return "", fmt.Errorf("invalid warehouse key %q", key)
+	}
+	return filepath.Join(s.root, filepath.FromSlash(clean)), nil
+}
@@ -0,0 +1,69 @@
+package warehouse
+
+import (
+	"context"
+	"errors"
+	"payment-gateway/internal/models"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestCommit tests that a partition is written as a CSV file followed by a manifest listing its messages
+func TestCommit(t *testing.T) {
+	store, err := NewDirStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	ctx := context.Background()
+	completed := time.Date(2024, 3, 9, 23, 30, 0, 0, time.UTC)
+
+	rows := []Row{
+		{MessageID: 7, Transaction: models.Transaction{ID: 2, Amount: 12.5, Currency: "EUR", Type: "deposit", Status: "completed", UserID: 3}, CompletedAt: completed},
+		{MessageID: 5, Transaction: models.Transaction{ID: 1, Amount: 100, Currency: "USD", Type: "withdrawal", Status: "completed", UserID: 1}, CompletedAt: completed},
+	}
+	manifest, err := Commit(ctx, store, Partition(completed), rows)
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+
+	if manifest.File != "transactions/dt=2024-03-09/part-00000000005-00000000007.csv" || manifest.Rows != 2 {
+		t.Errorf("Unexpected manifest %+v", manifest)
+	}
+
+	data, err := store.Get(ctx, manifest.File)
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	if len(lines) != 3 || lines[0] != strings.Join(Columns, ",") || !strings.HasPrefix(lines[1], "2,,,deposit,completed,12.5,EUR,3,") {
+		t.Errorf("Unexpected CSV:\n%s", data)
+	}
+
+	committed, err := CommittedMessages(ctx, store, "2024-03-09")
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	if len(committed) != 2 || !committed[5] || !committed[7] {
+		t.Errorf("Expected messages 5 and 7 committed, got %v", committed)
+	}
+	if committed, _ := CommittedMessages(ctx, store, "2024-03-10"); len(committed) != 0 {
+		t.Errorf("Expected no messages committed to another date, got %v", committed)
+	}
+}
+
+// TestDirStoreKeys tests that keys cannot escape the store directory
+func TestDirStoreKeys(t *testing.T) {
+	store, err := NewDirStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	ctx := context.Background()
+
+	if err := store.Put(ctx, "../outside.csv", []byte("x")); err == nil {
+		t.Error("Expected a key outside the store to be rejected")
+	}
+	if _, err := store.Get(ctx, "transactions/missing.csv"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Expected ErrNotFound, got: %v", err)
+	}
+}
@@ -0,0 +1,69 @@
+// Package webhook verifies the transaction webhooks the payment gateway sends merchants.
+// Merchant integrations written in Go can import it; others can follow it, since a signature
+// is just the HMAC-SHA256 of the raw request body.
+package webhook
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Headers attached to every webhook delivery
+const (
+	SignatureHeader   = "X-Webhook-Signature" // "sha256=<hex>" HMAC-SHA256 of the raw body
+	EventHeader       = "X-Event-Type"
+	IdempotencyHeader = "Idempotency-Key"
+	VersionHeader     = "X-Webhook-Version" // payload version of the delivery, "v1" or "v2"
+)
+
+// Envelope is a v2 webhook payload. Data holds the event's object under its name, e.g.
+// {"transaction": {...}}, along with any other fields the event carries.
+type Envelope struct {
+	ID         string          `json:"id"` // the delivery's Idempotency-Key
+	Type       string          `json:"type"`
+	APIVersion string          `json:"api_version"`
+	CreatedAt  time.Time       `json:"created_at"`
+	Data       json.RawMessage `json:"data"`
+}
+
+// MaxBodySize bounds how much of a request body VerifyRequest reads
+const MaxBodySize = 1 << 20
+
+var ErrInvalidSignature = errors.New("invalid webhook signature")
+
+// Sign returns the "sha256=<hex>" signature of a webhook body
+func Sign(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Verify checks a webhook body's signature in constant time. body must be the bytes received,
+// before any decoding: re-encoding a parsed payload changes it and the signature will not match.
+func Verify(secret string, body []byte, signature string) error {
+	if !hmac.Equal([]byte(Sign(secret, body)), []byte(strings.TrimSpace(signature))) {
+		return ErrInvalidSignature
+	}
+	return nil
+}
+
+// VerifyRequest reads a webhook delivery and checks its signature, returning the body only
+// when the signature is valid
+func VerifyRequest(secret string, r *http.Request) ([]byte, error) {
+	body, err := io.ReadAll(io.LimitReader(r.Body, MaxBodySize))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read webhook body: %w", err)
+	}
+	if err := Verify(secret, body, r.Header.Get(SignatureHeader)); err != nil {
+		return nil, err
+	}
+	return body, nil
+}
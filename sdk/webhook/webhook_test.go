@@ -0,0 +1,47 @@
+package webhook
+
+import (
+	"errors"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// TestVerify tests that only the exact signed body with the right secret verifies
+func TestVerify(t *testing.T) {
+	body := []byte(`{"type":"transaction.status_changed"}`)
+	signature := Sign("whsec_test_secret", body)
+	if !strings.HasPrefix(signature, "sha256=") {
+		t.Errorf("Expected a sha256= signature, got %q", signature)
+	}
+
+	if err := Verify("whsec_test_secret", body, signature); err != nil {
+		t.Errorf("Expected signature to verify, got: %v", err)
+	}
+	if err := Verify("whsec_test_secret", []byte(`{"type": "transaction.status_changed"}`), signature); !errors.Is(err, ErrInvalidSignature) {
+		t.Errorf("Expected ErrInvalidSignature for a changed body, got: %v", err)
+	}
+	if err := Verify("whsec_other_secret", body, signature); !errors.Is(err, ErrInvalidSignature) {
+		t.Errorf("Expected ErrInvalidSignature for another secret, got: %v", err)
+	}
+}
+
+// TestVerifyRequest tests verifying a delivery from its headers
+func TestVerifyRequest(t *testing.T) {
+	payload := `{"type":"transaction.status_changed"}`
+	req := httptest.NewRequest("POST", "/webhooks", strings.NewReader(payload))
+	req.Header.Set(SignatureHeader, Sign("whsec_test_secret", []byte(payload)))
+
+	body, err := VerifyRequest("whsec_test_secret", req)
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	if string(body) != payload {
+		t.Errorf("Expected body %q, got %q", payload, body)
+	}
+
+	req = httptest.NewRequest("POST", "/webhooks", strings.NewReader(payload))
+	if _, err := VerifyRequest("whsec_test_secret", req); !errors.Is(err, ErrInvalidSignature) {
+		t.Errorf("Expected ErrInvalidSignature without a signature header, got: %v", err)
+	}
+}